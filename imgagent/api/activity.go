@@ -0,0 +1,17 @@
+package api
+
+// Activity 动态流中的一条事件
+type Activity struct {
+	ID         string `json:"id"`
+	Type       string `json:"type"`
+	DocumentID string `json:"document_id,omitempty"`
+	SceneID    string `json:"scene_id,omitempty"`
+	Message    string `json:"message"`
+	CreatedAt  string `json:"created_at"`
+}
+
+// ListActivityResult 动态流列取响应，NextMarker 为空表示没有更多
+type ListActivityResult struct {
+	Activities []Activity `json:"activities"`
+	NextMarker string     `json:"next_marker"`
+}
@@ -0,0 +1,23 @@
+package api
+
+// AskDocumentArgs 文档问答参数，TopK 为检索的章节数，为 0 时默认 3
+type AskDocumentArgs struct {
+	Question string `json:"question" binding:"required,max=500"`
+	TopK     int    `json:"top_k" binding:"min=0,max=10"`
+}
+
+// AskSource 回答参考的章节片段，Score 为与问题的余弦相似度
+type AskSource struct {
+	ChapterID string  `json:"chapter_id"`
+	Index     int     `json:"index"`
+	Title     string  `json:"title"`
+	Content   string  `json:"content"`
+	Score     float64 `json:"score"`
+}
+
+// AskDocumentResult 文档问答的回答，Citations 为回答引用的章节 id，Sources 为检索到的全部章节片段
+type AskDocumentResult struct {
+	Answer    string      `json:"answer"`
+	Citations []string    `json:"citations"`
+	Sources   []AskSource `json:"sources"`
+}
@@ -0,0 +1,38 @@
+package api
+
+import "encoding/json"
+
+// 审计日志的资源类型，chapters、roles 为文档的章节、角色集合，资源 id 为文档 id；
+// prompt_template 的资源 id 为模板类型，backup 的资源 id 为备份 id
+const (
+	AuditResourceDocument       = "document"
+	AuditResourceChapter        = "chapter"
+	AuditResourceScene          = "scene"
+	AuditResourceRole           = "role"
+	AuditResourceChapters       = "chapters"
+	AuditResourceRoles          = "roles"
+	AuditResourcePromptTemplate = "prompt_template"
+	AuditResourceBackup         = "backup"
+)
+
+// AuditLog 一次修改操作的审计记录，Before 在新建时为空，After 在删除时为空
+type AuditLog struct {
+	ID           string          `json:"id"`
+	UserID       int64           `json:"user_id"`
+	UserName     string          `json:"user_name"`
+	Action       string          `json:"action"`
+	ResourceType string          `json:"resource_type"`
+	ResourceID   string          `json:"resource_id"`
+	DocumentID   string          `json:"document_id,omitempty"`
+	Route        string          `json:"route"`
+	RequestID    string          `json:"request_id"`
+	Before       json.RawMessage `json:"before,omitempty"`
+	After        json.RawMessage `json:"after,omitempty"`
+	CreatedAt    string          `json:"created_at"`
+}
+
+// ListAuditLogsResult NextMarker 为空表示没有更多
+type ListAuditLogsResult struct {
+	AuditLogs  []AuditLog `json:"audit_logs"`
+	NextMarker string     `json:"next_marker"`
+}
@@ -0,0 +1,47 @@
+package api
+
+// CreateBackupArgs POST /admin/backup 的参数
+type CreateBackupArgs struct {
+	// IncludeMedia 同时保存服务端生成的图片和语音文件清单，恢复时据此检查缺失的媒体文件
+	IncludeMedia bool `json:"include_media"`
+}
+
+// Backup 保存到对象存储的备份，ID 用于 POST /admin/restore
+type Backup struct {
+	ID         string           `json:"id"`
+	Key        string           `json:"key"` // 数据库快照在对象存储中的 key
+	Size       int64            `json:"size"`
+	Tables     map[string]int64 `json:"tables"` // 各表的行数
+	MediaFiles int              `json:"media_files,omitempty"`
+	CreatedAt  string           `json:"created_at"`
+}
+
+// RestoreBackupArgs POST /admin/restore 的参数
+type RestoreBackupArgs struct {
+	ID string `json:"id" binding:"required"`
+	// DryRun 只校验快照并统计行数，不修改数据
+	DryRun bool `json:"dry_run"`
+	// Confirm 非 dry_run 时必须与 ID 相同，避免误操作清空数据
+	Confirm string `json:"confirm"`
+}
+
+// RestoreResult 恢复或校验的结果
+type RestoreResult struct {
+	ID              string           `json:"id"`
+	DryRun          bool             `json:"dry_run"`
+	BackupCreatedAt string           `json:"backup_created_at"`
+	Tables          map[string]int64 `json:"tables"`
+	// MissingMedia 备份包含媒体文件清单时，清单中本地不存在的文件名
+	MissingMedia []string `json:"missing_media,omitempty"`
+}
+
+// MediaManifest 备份时服务端生成的媒体文件清单
+type MediaManifest struct {
+	Images []MediaFile `json:"images"`
+	Audio  []MediaFile `json:"audio"`
+}
+
+type MediaFile struct {
+	Name string `json:"name"`
+	Size int64  `json:"size"`
+}
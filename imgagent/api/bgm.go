@@ -0,0 +1,23 @@
+package api
+
+// BGMTrack 背景音乐曲目，由管理员通过 POST /admin/bgm 上传，文档通过 bgm_id 选用
+type BGMTrack struct {
+	ID   string `json:"id"`
+	Name string `json:"name"`
+	URL  string `json:"url"`
+	// Volume 视频合成时叠加在旁白下的音量，0~1，旁白为 1
+	Volume    float64 `json:"volume"`
+	CreatedAt string  `json:"created_at"`
+}
+
+type ListBGMTracksResult struct {
+	Tracks []BGMTrack `json:"tracks"`
+}
+
+// ManifestBGM 播放清单中文档选用的背景音乐，视频合成时从头循环播放至 TotalDurationMs，以 Volume 叠加在旁白下
+type ManifestBGM struct {
+	ID     string  `json:"id"`
+	Name   string  `json:"name"`
+	URL    string  `json:"url"`
+	Volume float64 `json:"volume"`
+}
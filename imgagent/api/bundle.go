@@ -0,0 +1,77 @@
+package api
+
+// BundleVersion 文档包的格式版本，不兼容的修改时递增，导入时拒绝其他版本
+const BundleVersion = 1
+
+// DocumentBundle GET /documents/:document_id/export?format=bundle 导出的文档包，用于在环境之间迁移文档，
+// 通过 POST /documents:import 导入。图片和语音只包含 url 引用，不包含媒体内容；
+// 各 id 为导出环境中的 id，只用于包内的相互引用，导入时重新生成
+type DocumentBundle struct {
+	Version    int             `json:"version"`
+	ExportedAt string          `json:"exported_at"`
+	Document   BundleDocument  `json:"document"`
+	Chapters   []BundleChapter `json:"chapters"`
+	Roles      []BundleRole    `json:"roles"`
+	Scenes     []BundleScene   `json:"scenes"`
+}
+
+type BundleDocument struct {
+	ID              string       `json:"id"`
+	Name            string       `json:"name"`
+	Status          string       `json:"status"`
+	Priority        string       `json:"priority"`
+	FileSize        int64        `json:"file_size"`
+	Summary         string       `json:"summary"`
+	SummaryImageURL string       `json:"summary_image_url"`
+	RoleConsistency bool         `json:"role_consistency"`
+	ImageProvider   string       `json:"image_provider"`
+	ImageStyle      ImageStyle   `json:"image_style"`
+	AspectRatio     string       `json:"aspect_ratio"`
+	ImageSize       string       `json:"image_size"`
+	IngestReport    IngestReport `json:"ingest_report"`
+	AutoRegenerate  bool         `json:"auto_regenerate"`
+	VoiceProsody    VoiceProsody `json:"voice_prosody"`
+}
+
+type BundleChapter struct {
+	ID           string   `json:"id"`
+	Index        int      `json:"index"`
+	Title        string   `json:"title"`
+	Content      string   `json:"content"`
+	SceneIDs     []string `json:"scene_ids"`
+	CoverSceneID string   `json:"cover_scene_id"`
+	CoverURL     string   `json:"cover_url"`
+	DuplicateOf  string   `json:"duplicate_of,omitempty"`
+	Similarity   float64  `json:"similarity,omitempty"`
+}
+
+type BundleRole struct {
+	ID          string `json:"id"`
+	Name        string `json:"name"`
+	Gender      string `json:"gender"`
+	Character   string `json:"character"`
+	Appearance  string `json:"appearance"`
+	PortraitURL string `json:"portrait_url"`
+}
+
+type BundleScene struct {
+	ID                string         `json:"id"`
+	ChapterID         string         `json:"chapter_id"`
+	Index             int            `json:"index"`
+	Content           string         `json:"content"`
+	Status            string         `json:"status"`
+	Error             string         `json:"error,omitempty"`
+	ImageURL          string         `json:"image_url"`
+	HDImageURL        string         `json:"hd_image_url"`
+	HDSourceURL       string         `json:"hd_source_url"`
+	VoiceURL          string         `json:"voice_url"`
+	Placeholder       bool           `json:"placeholder"`
+	MediaStale        bool           `json:"media_stale"`
+	AudioDurationMs   int64          `json:"audio_duration_ms"`
+	DisplayDurationMs int64          `json:"display_duration_ms"`
+	Overrides         SceneOverrides `json:"overrides"`
+	Location          string         `json:"location,omitempty"`
+	TimeOfDay         string         `json:"time_of_day,omitempty"`
+	Mood              string         `json:"mood,omitempty"`
+	CharactersPresent []string       `json:"characters_present,omitempty"`
+}
@@ -1,11 +1,88 @@
 package api
 
+import "time"
+
 type CreateDocumentArgs struct {
-	Name string `json:"name" binding:"required,max=50"`
+	Name string `json:"name" binding:"required,max=128"`
+
+	// 以下字段由服务端填充
+	UserID         int64        `json:"-"`
+	FileSize       int64        `json:"-"`
+	Priority       int          `json:"-"`
+	IngestReport   IngestReport `json:"-"`
+	SplitStartedAt time.Time    `json:"-"` // 开始切分章节的时间
+	ContentHash    string       `json:"-"` // 上传文件的 sha256
+}
+
+// DuplicateDocument 上传内容与已有文档相同时错误响应的 data
+type DuplicateDocument struct {
+	DocumentID string `json:"document_id"`
+	Name       string `json:"name"`
+}
+
+// DocumentNameConflict 文档名已存在时错误响应的 data，Suggestions 为当前可用的候选名称
+type DocumentNameConflict struct {
+	Name        string   `json:"name"`
+	Suggestions []string `json:"suggestions"`
+}
+
+// IngestReport 上传时生成的原文统计和质量检查报告，Warnings 为空表示未发现问题
+type IngestReport struct {
+	Encoding         string   `json:"encoding"`          // 检测到的原文编码，utf-8|utf-8-bom|utf-16le|utf-16be|gbk|unknown
+	Chars            int      `json:"chars"`             // 清洗后的字数
+	Paragraphs       int      `json:"paragraphs"`        // 非空段落数
+	Chapters         int      `json:"chapters"`          // 分割后的章节数
+	NonTextRatio     float64  `json:"non_text_ratio"`    // 控制字符、替换符等非文本字符的占比
+	ShortChapters    []int    `json:"short_chapters"`    // 字数过少的章节序号，可能是目录或误识别的标题
+	LongParagraphs   int      `json:"long_paragraphs"`   // 字数过多（可能丢失换行）的段落数
+	LongestParagraph int      `json:"longest_paragraph"` // 最长段落的字数
+	DuplicateTitles  []string `json:"duplicate_titles"`  // 重复出现的章节标题
+	Warnings         []string `json:"warnings"`
+
+	DuplicateChapters []DuplicateChapter `json:"duplicate_chapters"` // 与前文内容相同的章节
+	DuplicatesRemoved bool               `json:"duplicates_removed"` // 重复章节是否已在分割时自动删除
+
+	NearDuplicateChapters []NearDuplicateChapter `json:"near_duplicate_chapters"` // 与前文内容高度相似的章节
+}
+
+// DuplicateChapter 与前文内容相同的章节，序号为去重前分割结果中的位置，从 0 开始
+type DuplicateChapter struct {
+	Index      int    `json:"index"`
+	FirstIndex int    `json:"first_index"` // 首次出现的章节序号
+	Title      string `json:"title"`
+}
+
+// NearDuplicateChapter 与前文内容高度相似但不完全相同的章节，序号为上传时的章节序号
+type NearDuplicateChapter struct {
+	Index      int     `json:"index"`
+	FirstIndex int     `json:"first_index"` // 最相似的前文章节序号
+	Title      string  `json:"title"`
+	Similarity float64 `json:"similarity"` // 相似度，1 表示几乎相同
 }
 
 type UpdateDocumentArgs struct {
-	Name string `json:"name" binding:"required,max=50"`
+	Name string `json:"name" binding:"required,max=128"`
+	// RoleConsistency 为空时保持不变，见 Document.RoleConsistency
+	RoleConsistency *bool `json:"role_consistency"`
+	// ImageProvider 为空时保持不变，空字符串表示使用服务配置的默认图片服务
+	ImageProvider *string `json:"image_provider"`
+	// ImageStyle 为空时保持不变，传入时整体替换，{} 表示清除
+	ImageStyle *ImageStyle `json:"image_style"`
+	// AspectRatio、ImageSize 为空时保持不变，空字符串表示使用图片服务的默认分辨率
+	AspectRatio *string `json:"aspect_ratio"`
+	ImageSize   *string `json:"image_size"`
+	// AutoRegenerate 为空时保持不变，见 Document.AutoRegenerate
+	AutoRegenerate *bool `json:"auto_regenerate"`
+	// VoiceProsody 为空时保持不变，传入时整体替换，{} 表示使用语音服务的默认值
+	VoiceProsody *VoiceProsody `json:"voice_prosody"`
+	// BGMID 为空时保持不变，空字符串表示不使用背景音乐，曲目见 GET /bgm
+	BGMID *string `json:"bgm_id" binding:"omitempty,max=32"`
+}
+
+// RetryDocumentArgs 重试失败文档的参数，Stage 为空时重试文档失败的阶段。
+// images、voices 在同一阶段按场景生成，都重新生成失败场景的图片和语音
+type RetryDocumentArgs struct {
+	Stage string `json:"stage" binding:"omitempty,oneof=roles scenes images voices"`
 }
 
 type Document struct {
@@ -14,8 +91,44 @@ type Document struct {
 	FileID          string `json:"file_id"`
 	SummaryImageURL string `json:"summary_image_url"`
 	Status          string `json:"status"`
-	CreatedAt       string `json:"created_at"`
-	UpdatedAt       string `json:"updated_at"`
+	// SceneCount 场景总数，FailedSceneCount 永久失败的场景数（状态为 completedWithErrors 时大于 0）
+	SceneCount       int `json:"scene_count"`
+	FailedSceneCount int `json:"failed_scene_count"`
+	// Priority 处理优先级 high|normal|low
+	Priority string `json:"priority"`
+	// Paused 是否已暂停处理
+	Paused bool `json:"paused"`
+	// IngestWarnings 上传时发现的原文问题数，详情见 /documents/{id}/ingest-report
+	IngestWarnings int `json:"ingest_warnings"`
+	// RoleConsistency 生成场景图片时只注入场景中出现的角色，要求外貌与角色设定严格一致，角色的参考立绘作为参考图
+	RoleConsistency bool `json:"role_consistency"`
+	// ImageProvider 场景图片使用的生成服务 bailian|openai|stable_diffusion，为空时使用服务配置的默认服务
+	ImageProvider string `json:"image_provider"`
+	// ImageStyle 场景图片的风格预设、额外提示词和反向提示词
+	ImageStyle ImageStyle `json:"image_style"`
+	// AspectRatio 场景图片的画面比例 16:9|9:16|1:1，由图片服务选择对应的分辨率；
+	// ImageSize 场景图片的分辨率 宽*高，优先于 AspectRatio，场景可单独指定分辨率
+	AspectRatio string `json:"aspect_ratio"`
+	ImageSize   string `json:"image_size"`
+	// Attempts 当前处理阶段的失败次数，LastError 最近一次失败原因，NextAttemptAt 下次重试时间
+	Attempts      int    `json:"attempts"`
+	LastError     string `json:"last_error,omitempty"`
+	NextAttemptAt string `json:"next_attempt_at,omitempty"`
+	// FailedStage 状态为 failed 时失败所在的处理阶段 role|scene|image
+	FailedStage string `json:"failed_stage,omitempty"`
+	// AutoRegenerate 通过 PATCH /scenes/{id} 修改场景描述使媒体过期后，是否自动回到图片生成阶段重新生成
+	AutoRegenerate bool `json:"auto_regenerate"`
+	// VoiceProsody 场景语音的语速、音调和情感风格
+	VoiceProsody VoiceProsody `json:"voice_prosody"`
+	// BGMID 视频合成时叠加在旁白下的背景音乐曲目，为空时不使用
+	BGMID string `json:"bgm_id"`
+	// Synopsis 全书梗概，PlotPoints 按发生顺序排列的关键情节，由 POST /documents/{id}:summarize 生成
+	Synopsis   string   `json:"synopsis"`
+	PlotPoints []string `json:"plot_points"`
+	// Translation 请求指定 lang 时该语言的翻译任务，尚未翻译时为空
+	Translation *Translation `json:"translation,omitempty"`
+	CreatedAt   string       `json:"created_at"`
+	UpdatedAt   string       `json:"updated_at"`
 }
 
 type ListDocumentsResult struct {
@@ -23,20 +136,75 @@ type ListDocumentsResult struct {
 }
 
 type Chapter struct {
-	ID         string   `json:"id"`
-	Index      int      `json:"index"`
-	DocumentID string   `json:"document_id"`
-	Title      string   `json:"title"`
-	Content    string   `json:"content"`
-	SceneIDs   []string `json:"scene_ids"`
-	CreatedAt  string   `json:"created_at"`
-	UpdatedAt  string   `json:"updated_at"`
+	ID           string   `json:"id"`
+	Index        int      `json:"index"`
+	DocumentID   string   `json:"document_id"`
+	Title        string   `json:"title"`
+	Content      string   `json:"content"`
+	SceneIDs     []string `json:"scene_ids"`
+	CoverSceneID string   `json:"cover_scene_id"` // 手动选择的封面场景，为空表示自动选择
+	CoverURL     string   `json:"cover_url"`      // 章节封面图片，尚无场景图片时为空
+	DuplicateOf  string   `json:"duplicate_of"`   // 上传时检测到的高度相似的前文章节，为空表示未发现相似章节
+	Summary      string   `json:"summary"`        // 章节摘要，由 :summarize 生成，修改内容后清空
+	Lang         string   `json:"lang,omitempty"` // 标题和内容为该语言的译文，为空表示原文
+	CreatedAt    string   `json:"created_at"`
+	UpdatedAt    string   `json:"updated_at"`
+}
+
+// TranslateDocumentArgs POST /documents/:document_id:translate 的参数
+type TranslateDocumentArgs struct {
+	// Lang 目标语言 en|ja|ko|fr|de|es|ru|zh-Hant
+	Lang string `json:"lang" binding:"required"`
+}
+
+// Translation 文档的翻译任务，status 为 pending|running|succeeded|failed。
+// 重新翻译时只翻译尚无译文或原文已修改的章节
+type Translation struct {
+	DocumentID string `json:"document_id"`
+	Lang       string `json:"lang"`
+	Status     string `json:"status"`
+	Translated int    `json:"translated"` // 已翻译的章节数
+	Total      int    `json:"total"`
+	Error      string `json:"error,omitempty"`
+	CreatedAt  string `json:"created_at"`
+	UpdatedAt  string `json:"updated_at"`
+}
+
+// ChapterDuplicate 与前文高度相似的章节，可删除后重新生成
+type ChapterDuplicate struct {
+	ChapterID        string  `json:"chapter_id"`
+	Index            int     `json:"index"`
+	Title            string  `json:"title"`
+	DuplicateOf      string  `json:"duplicate_of"` // 最相似的前文章节 id
+	DuplicateOfIndex int     `json:"duplicate_of_index"`
+	Similarity       float64 `json:"similarity"`
+}
+
+type ListChapterDuplicatesResult struct {
+	Duplicates []ChapterDuplicate `json:"duplicates"`
 }
 
 type UpdateChapterArgs struct {
 	Content string `json:"content" binding:"required,max=4000"`
 }
 
+// ReorderChaptersArgs 重排章节参数，ChapterIDs 为文档全部章节 id 的新顺序
+type ReorderChaptersArgs struct {
+	ChapterIDs []string `json:"chapter_ids" binding:"required,min=1"`
+}
+
+// SplitChapterArgs 拆分章节参数，Offset 与 Marker 二选一：Offset 为按字符计的拆分位置，
+// Marker 为第二章开头的文本，从第一次出现的位置拆分
+type SplitChapterArgs struct {
+	Offset *int   `json:"offset"`
+	Marker string `json:"marker"`
+}
+
+// SetChapterCoverArgs 设置章节封面参数，SceneID 为章节内已生成图片的场景，为空时恢复自动选择
+type SetChapterCoverArgs struct {
+	SceneID string `json:"scene_id"`
+}
+
 type ListChaptersResult struct {
 	Chapters []Chapter `json:"chapters"`
 }
@@ -46,3 +214,58 @@ type Records struct {
 	Content string  `json:"content"`
 	Score   float32 `json:"score"`
 }
+
+// DocumentProgress 文档处理进度，由 GET /documents/:document_id/events 以 SSE 推送，SSE 事件名与 Event 相同
+type DocumentProgress struct {
+	DocumentID string `json:"document_id"`
+	// Event snapshot（连接建立时的当前进度）| roles.extracted | scenes.split | scene.generated | scene.failed |
+	// scene.blocked | document.paused | document.resumed | document.finished | document.failed
+	Event            string `json:"event"`
+	Status           string `json:"status"`
+	Paused           bool   `json:"paused"`
+	ChapterCount     int    `json:"chapter_count"`
+	RoleCount        int    `json:"role_count"`
+	SceneCount       int    `json:"scene_count"`
+	ReadySceneCount  int    `json:"ready_scene_count"`
+	FailedSceneCount int    `json:"failed_scene_count"`
+	Percent          int    `json:"percent"`
+}
+
+// StageProgress 处理阶段的进度。Done/Total 为已完成和全部的处理单元数：splitting、scenes 按章节，
+// roles 按角色（提取完成前为 0），images、voices 按场景，永久失败的场景计为已完成
+type StageProgress struct {
+	Stage      string `json:"stage"` // splitting|roles|scenes|images|voices
+	Done       int    `json:"done"`
+	Total      int    `json:"total"`
+	StartedAt  string `json:"started_at,omitempty"`
+	FinishedAt string `json:"finished_at,omitempty"`
+}
+
+// ProcessingProgress GET /documents/:document_id/progress 返回的结构化处理进度
+type ProcessingProgress struct {
+	DocumentID string `json:"document_id"`
+	Status     string `json:"status"`
+	// Stage 当前所处的阶段，图片和语音同时生成时为 images；失败时为失败所在的阶段，处理结束后为空
+	Stage   string          `json:"stage"`
+	Paused  bool            `json:"paused"`
+	Percent int             `json:"percent"`
+	Stages  []StageProgress `json:"stages"`
+	// LastError 最近一次失败原因，文档当前阶段没有失败时为最近失败的场景的原因
+	LastError string `json:"last_error,omitempty"`
+}
+
+// ChapterSummaryDelta GET /documents/:document_id/chapters/:id/summary 以 SSE 推送的 delta 事件，Content 为摘要的增量内容
+type ChapterSummaryDelta struct {
+	Content string `json:"content"`
+}
+
+// ChapterSummary 流式章节摘要结束时的 done 事件，Summary 为完整摘要；生成失败时推送 error 事件，data 为 StreamError
+type ChapterSummary struct {
+	ChapterID string `json:"chapter_id"`
+	Summary   string `json:"summary"`
+}
+
+// StreamError 流式接口中途失败时推送的 error 事件
+type StreamError struct {
+	Message string `json:"message"`
+}
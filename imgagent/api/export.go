@@ -0,0 +1,23 @@
+package api
+
+type CreateExportArgs struct {
+	Format string `json:"format" binding:"required"`
+}
+
+// Export 导出任务，Status 为 succeeded 时可通过 /exports/:id/download 下载产物，ExpiresAt 之后产物被清理
+type Export struct {
+	ID           string `json:"id"`
+	DocumentID   string `json:"document_id"`
+	Format       string `json:"format"`
+	Status       string `json:"status"`
+	Size         int64  `json:"size"`
+	Error        string `json:"error,omitempty"`
+	ExpiresAt    string `json:"expires_at,omitempty"`
+	OperationURL string `json:"operation_url"` // 轮询导出进度的统一操作地址
+	CreatedAt    string `json:"created_at"`
+	UpdatedAt    string `json:"updated_at"`
+}
+
+type ListExportsResult struct {
+	Exports []Export `json:"exports"`
+}
@@ -0,0 +1,26 @@
+package api
+
+// 死信任务类型
+const (
+	FailedJobDocument = "document"
+	FailedJobScene    = "scene"
+)
+
+// FailedJob 重试耗尽后永久失败的文档或场景
+type FailedJob struct {
+	Type       string `json:"type"`
+	ID         string `json:"id"`
+	DocumentID string `json:"document_id"`
+	UserID     int64  `json:"user_id"`
+	// Stage 失败所在的处理阶段 role|scene|image，场景固定为 image
+	Stage    string `json:"stage"`
+	Attempts int    `json:"attempts"`
+	Error    string `json:"error"`
+	FailedAt string `json:"failed_at"`
+}
+
+// ListFailedJobsResult NextMarker 为空表示没有更多
+type ListFailedJobsResult struct {
+	Jobs       []FailedJob `json:"jobs"`
+	NextMarker string      `json:"next_marker"`
+}
@@ -0,0 +1,25 @@
+package api
+
+// ImportBatch 批量导入任务，Status 为 finished 时所有文件处理完成，
+// 每个文件的结果见 Items，创建成功的文档由 DocumentMgr 继续异步处理
+type ImportBatch struct {
+	ID           string       `json:"id"`
+	Filename     string       `json:"filename"`
+	Status       string       `json:"status"`
+	Total        int          `json:"total"`
+	Created      int          `json:"created"`
+	Failed       int          `json:"failed"`
+	Items        []ImportItem `json:"items"`
+	OperationURL string       `json:"operation_url"` // 轮询导入进度的统一操作地址
+	CreatedAt    string       `json:"created_at"`
+	UpdatedAt    string       `json:"updated_at"`
+}
+
+// ImportItem 归档中的单个文件，Status 为 pending|created|failed
+type ImportItem struct {
+	Filename   string `json:"filename"`
+	Name       string `json:"name"`
+	DocumentID string `json:"document_id,omitempty"`
+	Status     string `json:"status"`
+	Error      string `json:"error,omitempty"`
+}
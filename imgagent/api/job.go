@@ -0,0 +1,12 @@
+package api
+
+// Job 排队执行的异步任务，Status 为 succeeded 时 Result 为原接口的业务数据，
+// 为 failed 时 Code 和 Message 为原接口的错误信息
+type Job struct {
+	ID           string `json:"id"`
+	Status       string `json:"status"`
+	OperationURL string `json:"operation_url"` // 轮询任务结果的统一操作地址，与 202 响应的 Location 头相同
+	Code         int    `json:"code,omitempty"`
+	Message      string `json:"message,omitempty"`
+	Result       any    `json:"result,omitempty"`
+}
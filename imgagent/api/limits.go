@@ -0,0 +1,65 @@
+package api
+
+// DocumentNameLimits 文档名规则。名称去掉首尾空白后校验，MaxLength 按字符计；
+// 判断重名和保留名称时忽略大小写和全半角差异
+type DocumentNameLimits struct {
+	MaxLength     int      `json:"max_length"`
+	Pattern       string   `json:"pattern,omitempty"` // 名称需匹配的正则（RE2 语法），为空时不限制字符
+	ReservedNames []string `json:"reserved_names"`
+}
+
+// UploadLimits 上传原文和批量导入的限制
+type UploadLimits struct {
+	FileTypes            []string `json:"file_types"`              // 支持的原文扩展名，如 .txt
+	MaxFileBytes         int64    `json:"max_file_bytes"`          // 单个原文文件的最大字节数
+	MaxURLBytes          int64    `json:"max_url_bytes"`           // 通过 URL 创建文档时下载的最大字节数
+	ArchiveTypes         []string `json:"archive_types"`           // 批量导入支持的归档格式
+	MaxArchiveFiles      int      `json:"max_archive_files"`       // 归档中最多的文件数
+	MaxArchiveFileBytes  int64    `json:"max_archive_file_bytes"`  // 归档中单个文件解压后的最大字节数
+	MaxArchiveTotalBytes int64    `json:"max_archive_total_bytes"` // 归档解压后的最大总字节数
+}
+
+// ChapterLimits 分割章节的参数，均按字符计
+type ChapterLimits struct {
+	MaxChars     int `json:"max_chars"`     // 单个章节的最大字符数，超出时按更细的分隔符继续分割
+	OverlapChars int `json:"overlap_chars"` // 继续分割时相邻块重叠的字符数
+	ShortChars   int `json:"short_chars"`   // 少于该字符数的章节在上传报告中给出警告
+}
+
+// RateLimits 租户级并发限制，超出 MaxConcurrent 的请求排队，排队数超出 MaxQueued 时返回 429
+type RateLimits struct {
+	MaxConcurrent int `json:"max_concurrent"`
+	MaxQueued     int `json:"max_queued"`
+}
+
+// QuotaRemaining 租户的剩余配额，未限制的项不返回
+type QuotaRemaining struct {
+	Documents   *int64 `json:"documents,omitempty"`
+	UploadBytes *int64 `json:"upload_bytes,omitempty"`
+	ImagesToday *int64 `json:"images_today,omitempty"`
+	VoicesToday *int64 `json:"voices_today,omitempty"`
+}
+
+// ValueRange 参数的取值范围，Max 为 0 表示不支持该参数
+type ValueRange struct {
+	Min float64 `json:"min"`
+	Max float64 `json:"max"`
+}
+
+// TTSLimits 语音服务支持的 VoiceProsody 取值，Emotion 表示是否支持情感风格
+type TTSLimits struct {
+	Speed   ValueRange `json:"speed"`
+	Pitch   ValueRange `json:"pitch"`
+	Emotion bool       `json:"emotion"`
+}
+
+// Limits 当前实例对租户生效的限制，客户端提交前可用于本地校验
+type Limits struct {
+	Upload         UploadLimits       `json:"upload"`
+	Chapter        ChapterLimits      `json:"chapter"`
+	RateLimit      RateLimits         `json:"rate_limit"`
+	DocumentName   DocumentNameLimits `json:"document_name"`
+	Quota          QuotaLimits        `json:"quota"`
+	QuotaRemaining QuotaRemaining     `json:"quota_remaining"`
+	TTS            TTSLimits          `json:"tts"`
+}
@@ -0,0 +1,19 @@
+package api
+
+// BlockedScene 未通过内容审核、等待人工复核的场景
+type BlockedScene struct {
+	ID           string `json:"id"`
+	DocumentID   string `json:"document_id"`
+	UserID       int64  `json:"user_id"`
+	Index        int    `json:"index"`
+	Content      string `json:"content"`
+	Reason       string `json:"reason"`
+	HeldImageURL string `json:"held_image_url,omitempty"` // 未通过审核而扣留的生成图片，描述未通过时为空
+	BlockedAt    string `json:"blocked_at"`
+}
+
+// ListBlockedScenesResult NextMarker 为空表示没有更多
+type ListBlockedScenesResult struct {
+	Scenes     []BlockedScene `json:"scenes"`
+	NextMarker string         `json:"next_marker"`
+}
@@ -0,0 +1,32 @@
+package api
+
+const (
+	OperationKindExport   = "export"
+	OperationKindImport   = "import"
+	OperationKindJob      = "job"
+	OperationKindDocument = "document"
+
+	OperationStatusPending   = "pending"
+	OperationStatusRunning   = "running"
+	OperationStatusSucceeded = "succeeded"
+	OperationStatusFailed    = "failed"
+	OperationStatusCanceled  = "canceled"
+	// OperationStatusExpired 操作成功但结果已过期清理
+	OperationStatusExpired = "expired"
+)
+
+// Operation 长时间运行操作的统一视图。导出、批量导入、排队执行的请求和文档（重新）处理都可通过
+// GET /operations/:id 轮询，id 为各自资源的 id；Status 为 succeeded 时结果见 ResultURL 或 Result，
+// 为 failed 时 Code 和 Error 为失败原因
+type Operation struct {
+	ID        string `json:"id"`
+	Kind      string `json:"kind"`
+	Status    string `json:"status"`
+	Progress  int    `json:"progress"`             // 进度百分比
+	ResultURL string `json:"result_url,omitempty"` // 获取结果的地址
+	Result    any    `json:"result,omitempty"`     // 排队执行的请求成功时为原接口的业务数据
+	Code      int    `json:"code,omitempty"`
+	Error     string `json:"error,omitempty"`
+	CreatedAt string `json:"created_at,omitempty"`
+	UpdatedAt string `json:"updated_at,omitempty"`
+}
@@ -0,0 +1,4 @@
+// Package pb 为 imgagent.proto 生成的 gRPC 代码，修改 proto 后执行 go generate 重新生成
+package pb
+
+//go:generate protoc --go_out=. --go_opt=paths=source_relative --go-grpc_out=. --go-grpc_opt=paths=source_relative imgagent.proto
@@ -0,0 +1,21 @@
+package api
+
+// PromptTemplate 提示词模板，使用 Go text/template 语法。可用变量：
+//   - role：.Document.Name、.Document.Summary
+//   - scene：.Document、.Chapter.Index、.Chapter.Content
+//   - image：.Document、.Scene.Index、.Scene.Content、.Scene.Style、.Roles、.FeaturedRoles（场景中提到名字的角色）、.RoleConsistency，
+//     角色字段为 .Name、.Gender、.Character、.Appearance
+type PromptTemplate struct {
+	Kind      string `json:"kind"` // role|scene|image
+	Content   string `json:"content"`
+	Default   bool   `json:"default"` // 未自定义，使用内置的默认提示词
+	UpdatedAt string `json:"updated_at,omitempty"`
+}
+
+type ListPromptTemplatesResult struct {
+	Templates []PromptTemplate `json:"templates"`
+}
+
+type UpdatePromptTemplateArgs struct {
+	Content string `json:"content" binding:"required,max=20000"`
+}
@@ -0,0 +1,33 @@
+package api
+
+// QuotaLimits 租户配额上限，0 表示不限制。WarningThresholds 为预警线（上限的百分比，如 [80, 90]），
+// 用量越过预警线时发出 quota.warning 事件和回调，达到上限时发出 quota.exceeded，为空时使用默认预警线
+type QuotaLimits struct {
+	MaxDocuments      int   `json:"max_documents"`
+	MaxUploadBytes    int64 `json:"max_upload_bytes"`
+	MaxImagesPerDay   int   `json:"max_images_per_day"`
+	MaxVoicesPerDay   int   `json:"max_voices_per_day"`
+	WarningThresholds []int `json:"warning_thresholds,omitempty"`
+}
+
+// QuotaUsage 租户配额用量
+type QuotaUsage struct {
+	Documents   int64 `json:"documents"`
+	UploadBytes int64 `json:"upload_bytes"`
+	ImagesToday int   `json:"images_today"`
+	VoicesToday int   `json:"voices_today"`
+}
+
+// GetQuotaResult 配额查询响应
+type GetQuotaResult struct {
+	Limits QuotaLimits `json:"limits"`
+	Usage  QuotaUsage  `json:"usage"`
+}
+
+// QuotaAlert quota.warning 和 quota.exceeded 回调的数据，Threshold 为越过的预警线百分比，达到上限时为 100
+type QuotaAlert struct {
+	Kind      string `json:"kind"` // documents|upload_bytes|images_per_day|voices_per_day
+	Usage     int64  `json:"usage"`
+	Limit     int64  `json:"limit"`
+	Threshold int    `json:"threshold"`
+}
@@ -0,0 +1,35 @@
+package api
+
+// CreateBookmarkArgs 创建书签参数，SceneID 为空表示章节书签
+type CreateBookmarkArgs struct {
+	ChapterID string `json:"chapter_id" binding:"required"`
+	SceneID   string `json:"scene_id"`
+	Note      string `json:"note" binding:"max=512"`
+}
+
+type Bookmark struct {
+	ID         string `json:"id"`
+	DocumentID string `json:"document_id"`
+	ChapterID  string `json:"chapter_id"`
+	SceneID    string `json:"scene_id"`
+	Note       string `json:"note"`
+	CreatedAt  string `json:"created_at"`
+}
+
+type ListBookmarksResult struct {
+	Bookmarks []Bookmark `json:"bookmarks"`
+}
+
+// UpdateReadPositionArgs 更新阅读位置参数
+type UpdateReadPositionArgs struct {
+	ChapterID string `json:"chapter_id" binding:"required"`
+	SceneID   string `json:"scene_id"`
+}
+
+// ReadPosition 最后阅读位置，从未阅读时 ChapterID 为空
+type ReadPosition struct {
+	DocumentID string `json:"document_id"`
+	ChapterID  string `json:"chapter_id"`
+	SceneID    string `json:"scene_id"`
+	UpdatedAt  string `json:"updated_at"`
+}
@@ -0,0 +1,48 @@
+package api
+
+// DocumentRun 文档的一次处理结果，Seq 为文档内的处理序号
+type DocumentRun struct {
+	Seq        int    `json:"seq"`
+	Status     string `json:"status"`
+	SceneCount int    `json:"scene_count"`
+	ImageCount int    `json:"image_count"`
+	VoiceCount int    `json:"voice_count"`
+	CreatedAt  string `json:"created_at"`
+}
+
+type ListDocumentRunsResult struct {
+	Runs []DocumentRun `json:"runs"`
+}
+
+// DiffSegment prompt 差异片段，Op 为 equal | delete | insert
+type DiffSegment struct {
+	Op   string `json:"op"`
+	Text string `json:"text"`
+}
+
+// SceneComparison 按场景序号对齐的场景对比，Change 为 unchanged | changed | added | removed
+type SceneComparison struct {
+	Index       int           `json:"index"`
+	Change      string        `json:"change"`
+	OldSceneID  string        `json:"old_scene_id,omitempty"`
+	NewSceneID  string        `json:"new_scene_id,omitempty"`
+	PromptDiff  []DiffSegment `json:"prompt_diff,omitempty"`
+	OldImageURL string        `json:"old_image_url,omitempty"`
+	NewImageURL string        `json:"new_image_url,omitempty"`
+	OldVoiceURL string        `json:"old_voice_url,omitempty"`
+	NewVoiceURL string        `json:"new_voice_url,omitempty"`
+}
+
+// CostDelta 新一次处理相对旧一次多生成的图片和语音数，为负表示更少
+type CostDelta struct {
+	Images int `json:"images"`
+	Voices int `json:"voices"`
+}
+
+type RunComparison struct {
+	DocumentID string            `json:"document_id"`
+	Old        DocumentRun       `json:"old"`
+	New        DocumentRun       `json:"new"`
+	CostDelta  CostDelta         `json:"cost_delta"`
+	Scenes     []SceneComparison `json:"scenes"`
+}
@@ -0,0 +1,27 @@
+package api
+
+import "time"
+
+// RuntimeConfig 可在运行时修改、不需要重启即生效的配置。配置文件变化时重新加载，
+// GET /admin/config 返回当前生效的值
+type RuntimeConfig struct {
+	LogLevel    string               `json:"log_level"` // debug|info|warn|error
+	RateLimit   RateLimits           `json:"rate_limit"`
+	DocumentMgr *DocumentConcurrency `json:"document_mgr,omitempty"` // 未启用文档处理时为空
+	Bailian     *BailianModels       `json:"bailian,omitempty"`      // 未配置百炼时为空
+	ReloadedAt  *time.Time           `json:"reloaded_at,omitempty"`  // 最近一次运行时修改的时间，启动后未修改时为空
+}
+
+// DocumentConcurrency 文档处理的并发，调小后正在进行的处理结束时生效
+type DocumentConcurrency struct {
+	Workers                int `json:"workers"`
+	MaxConcurrentImageJobs int `json:"max_concurrent_image_jobs"`
+	MaxConcurrentTTSJobs   int `json:"max_concurrent_tts_jobs"`
+}
+
+// BailianModels 调用百炼使用的模型，修改后新发起的调用生效
+type BailianModels struct {
+	ChatModel  string `json:"chat_model"`
+	ImageModel string `json:"image_model"`
+	TTSModel   string `json:"tts_model"`
+}
@@ -8,8 +8,12 @@ type Role struct {
 	Gender     string `json:"gender"`
 	Character  string `json:"character"`
 	Appearance string `json:"appearance"`
-	CreatedAt  string `json:"created_at"`
-	UpdatedAt  string `json:"updated_at"`
+	// PortraitURL 角色参考立绘，文档开启角色一致性时作为场景图片的参考图
+	PortraitURL string `json:"portrait_url"`
+	// RegeneratingScenes PUT /roles/:id 指定 regenerate_scenes 时排队重新生成的场景数
+	RegeneratingScenes int    `json:"regenerating_scenes,omitempty"`
+	CreatedAt          string `json:"created_at"`
+	UpdatedAt          string `json:"updated_at"`
 }
 
 // Scene 场景信息
@@ -21,8 +25,42 @@ type Scene struct {
 	Content    string `json:"content"`
 	ImageURL   string `json:"image_url"`
 	VoiceURL   string `json:"voice_url"`
-	CreatedAt  string `json:"created_at"`
-	UpdatedAt  string `json:"updated_at"`
+	// SubtitleURL 与语音时间对齐的 WebVTT 字幕，随语音生成
+	SubtitleURL string `json:"subtitle_url,omitempty"`
+	// Status 场景生成状态：空表示待生成，ready 表示已生成，failed 表示重试耗尽永久失败，blocked 表示未通过内容审核
+	Status           string `json:"status"`
+	Error            string `json:"error,omitempty"`
+	ModerationReason string `json:"moderation_reason,omitempty"` // 未通过内容审核的原因，blocked 状态时有值
+	// Placeholder 为 true 时 image_url/voice_url 指向占位卡片图片与静音音频，场景等待人工重试
+	Placeholder bool `json:"placeholder"`
+	// MediaStale 为 true 时场景描述在生成图片和语音后修改过，image_url/voice_url 为旧描述的媒体，重新生成成功后清除
+	MediaStale bool `json:"media_stale"`
+	// AudioDurationMs 语音时长，DisplayDurationMs 建议展示时长（毫秒），播放器和视频导出共用
+	AudioDurationMs   int64           `json:"audio_duration_ms"`
+	DisplayDurationMs int64           `json:"display_duration_ms"`
+	Overrides         *SceneOverrides `json:"overrides,omitempty"`
+	HDImageURL        string          `json:"hd_image_url,omitempty"` // 当前场景图片超分得到的高清图片，未超分或图片已重新生成时为空
+	// Location、TimeOfDay、Mood、CharactersPresent 场景拆分时提取的地点、时间段、氛围和出场人物，用于图片提示词和筛选；
+	// TimeOfDay 为 dawn|morning|noon|afternoon|evening|night
+	Location          string   `json:"location,omitempty"`
+	TimeOfDay         string   `json:"time_of_day,omitempty"`
+	Mood              string   `json:"mood,omitempty"`
+	CharactersPresent []string `json:"characters_present,omitempty"`
+	CreatedAt         string   `json:"created_at"`
+	UpdatedAt         string   `json:"updated_at"`
+}
+
+// SceneOverrides 场景级生成参数，非空字段在生成该场景的图片和语音时覆盖默认配置
+type SceneOverrides struct {
+	Style      string `json:"style,omitempty" binding:"max=200"`      // 画面风格，如 水彩、赛博朋克
+	ImageSize  string `json:"image_size,omitempty"`                   // 图片分辨率，如 1328*1328
+	ImageModel string `json:"image_model,omitempty" binding:"max=64"` // 图片生成模型
+	Voice      string `json:"voice,omitempty" binding:"max=64"`       // 语音音色
+	TTSModel   string `json:"tts_model,omitempty" binding:"max=64"`   // 语音合成模型
+	// Speed、Pitch、Emotion 覆盖文档的 VoiceProsody，为零值时使用文档的设置
+	Speed   float64 `json:"speed,omitempty" binding:"min=0"`
+	Pitch   float64 `json:"pitch,omitempty" binding:"min=0"`
+	Emotion string  `json:"emotion,omitempty" binding:"max=200"`
 }
 
 // ListRolesResult 角色列表响应
@@ -30,6 +68,37 @@ type ListRolesResult struct {
 	Roles []Role `json:"roles"`
 }
 
+// ExtractRolesResult 重新提取角色的结果，Created 为新增的角色数，Updated 为补全了空字段的已有角色数，Roles 为合并后的全部角色
+type ExtractRolesResult struct {
+	Created int    `json:"created"`
+	Updated int    `json:"updated"`
+	Roles   []Role `json:"roles"`
+}
+
+// RoleGraph 角色关系图，Nodes 为文档的全部角色，Edges 为角色之间的有向关系，可直接用于关系图渲染
+type RoleGraph struct {
+	Nodes []RoleGraphNode `json:"nodes"`
+	Edges []RoleGraphEdge `json:"edges"`
+}
+
+// RoleGraphNode 关系图中的角色，Degree 为与该角色相连的关系数，可用于节点大小
+type RoleGraphNode struct {
+	ID          string `json:"id"`
+	Name        string `json:"name"`
+	Gender      string `json:"gender"`
+	PortraitURL string `json:"portrait_url,omitempty"`
+	Degree      int    `json:"degree"`
+}
+
+// RoleGraphEdge 关系图中的关系，Source、Target 为角色 id。Type 为 Source 相对 Target 的身份：
+// parent|child|sibling|spouse|lover|friend|rival|enemy|mentor|student|colleague|master|servant|other
+type RoleGraphEdge struct {
+	Source      string `json:"source"`
+	Target      string `json:"target"`
+	Type        string `json:"type"`
+	Description string `json:"description"`
+}
+
 // ListScenesResult 场景列表响应
 type ListScenesResult struct {
 	Scenes []Scene `json:"scenes"`
@@ -41,9 +110,118 @@ type UpdateRoleArgs struct {
 	Gender     string `json:"gender" binding:"required"`
 	Character  string `json:"character" binding:"required"`
 	Appearance string `json:"appearance" binding:"required"`
+	// PortraitURL 为空时清除参考立绘
+	PortraitURL string `json:"portrait_url" binding:"omitempty,url,max=500"`
+	// RegenerateScenes 为 true 且外貌、性别或参考立绘有变化时，出现该角色的场景标记为媒体过期并排队重新生成图片
+	RegenerateScenes bool `json:"regenerate_scenes"`
+}
+
+// MergeRolesArgs 合并角色请求参数，SourceIDs 的角色合并到 TargetID 后删除，场景描述中的来源角色名改为目标角色名；
+// Consolidate 为 true 时由大模型综合各条记录的性格和外貌描述，否则只用来源角色补全目标角色的空字段
+type MergeRolesArgs struct {
+	TargetID    string   `json:"target_id" binding:"required"`
+	SourceIDs   []string `json:"source_ids" binding:"required,min=1"`
+	Consolidate bool     `json:"consolidate"`
 }
 
-// UpdateSceneArgs 更新场景请求参数
+// UpdateSceneArgs 更新场景请求参数，Overrides 为空时保留场景原有的生成参数，传 {} 清除。
+// PUT /scenes/:id 更新后立即重新生成图片和语音；PATCH /scenes/:id 只更新，内容变化时已有媒体标记为过期，
+// 文档开启 auto_regenerate 时回到图片生成阶段重新生成
 type UpdateSceneArgs struct {
-	Content string `json:"content" binding:"required"`
+	Content   string          `json:"content" binding:"required"`
+	Overrides *SceneOverrides `json:"overrides"`
+}
+
+// CreateSceneArgs 插入场景请求参数，InsertAfter 为同一章节中的场景 id，为空时插入到章节开头；
+// Generate 为 true 时立即排队生成图片和语音，文档已处理结束时回到图片生成阶段；
+// 文档仍在图片生成阶段时新场景总会随文档一起生成
+type CreateSceneArgs struct {
+	InsertAfter string          `json:"insert_after"`
+	Content     string          `json:"content" binding:"required,max=1000"`
+	Overrides   *SceneOverrides `json:"overrides"`
+	Generate    bool            `json:"generate"`
+}
+
+// UpscaleSceneImageArgs 场景图片超分请求参数，Scale 为 0 时使用配置的默认倍数
+type UpscaleSceneImageArgs struct {
+	Scale int `json:"scale" binding:"min=0,max=4"`
+}
+
+// ManifestScene 播放清单中的场景
+type ManifestScene struct {
+	ID                string `json:"id"`
+	ChapterID         string `json:"chapter_id"`
+	Index             int    `json:"index"`
+	Content           string `json:"content"`
+	ImageURL          string `json:"image_url"`
+	HDImageURL        string `json:"hd_image_url,omitempty"` // 高清图片，视频导出优先使用
+	VoiceURL          string `json:"voice_url"`
+	SubtitleURL       string `json:"subtitle_url,omitempty"` // 场景的 WebVTT 字幕，时间相对场景开始
+	Placeholder       bool   `json:"placeholder"`
+	StartMs           int64  `json:"start_ms"`
+	AudioDurationMs   int64  `json:"audio_duration_ms"`
+	DisplayDurationMs int64  `json:"display_duration_ms"`
+}
+
+// ManifestChapter 播放清单中的章节目录，StartMs 为章节第一个场景的开始时间
+type ManifestChapter struct {
+	ID       string `json:"id"`
+	Index    int    `json:"index"`
+	Title    string `json:"title"`
+	CoverURL string `json:"cover_url"`
+	StartMs  int64  `json:"start_ms"`
+}
+
+// Manifest 文档播放清单，按播放顺序给出每个场景的媒体与时间轴
+type Manifest struct {
+	DocumentID      string            `json:"document_id"`
+	Name            string            `json:"name"`
+	Status          string            `json:"status"`
+	TotalDurationMs int64             `json:"total_duration_ms"`
+	Chapters        []ManifestChapter `json:"chapters"`
+	Scenes          []ManifestScene   `json:"scenes"`
+	// BGM 文档选用的背景音乐，未选用或曲目已删除时为空
+	BGM *ManifestBGM `json:"bgm,omitempty"`
+}
+
+// SceneGeneration 场景的一次图片和语音生成，Active 表示场景当前使用该次生成的媒体
+type SceneGeneration struct {
+	Seq               int    `json:"seq"`
+	ImageURL          string `json:"image_url"`
+	VoiceURL          string `json:"voice_url"`
+	AudioDurationMs   int64  `json:"audio_duration_ms"`
+	DisplayDurationMs int64  `json:"display_duration_ms"`
+	Active            bool   `json:"active"`
+	CreatedAt         string `json:"created_at"`
+}
+
+type ListSceneGenerationsResult struct {
+	Generations []SceneGeneration `json:"generations"`
+}
+
+// SceneImageVersion 场景生成过的一张图片，Active 表示场景当前使用该版本
+type SceneImageVersion struct {
+	Version   int    `json:"version"`
+	ImageURL  string `json:"image_url"`
+	Active    bool   `json:"active"`
+	CreatedAt string `json:"created_at"`
+}
+
+type ListSceneImageVersionsResult struct {
+	Versions []SceneImageVersion `json:"versions"`
+}
+
+// RevertSceneImageArgs 将场景图片回退为 GET /scenes/:id/image/history 中的版本，语音不变
+type RevertSceneImageArgs struct {
+	Version int `json:"version" binding:"required,min=1"`
+}
+
+// ScenePromptPreview 生成场景图片时发送给图片服务的提示词，已合并画面风格并注入角色外貌
+type ScenePromptPreview struct {
+	SceneID        string   `json:"scene_id"`
+	Provider       string   `json:"provider"` // 实际使用的图片服务
+	Template       bool     `json:"template"` // 是否由管理员自定义的图片提示词模板渲染
+	Prompt         string   `json:"prompt"`
+	NegativePrompt string   `json:"negative_prompt,omitempty"`
+	References     []string `json:"references,omitempty"` // 随提示词发送的角色参考立绘
 }
@@ -0,0 +1,28 @@
+package api
+
+// SearchHit 一个匹配的场景
+type SearchHit struct {
+	DocumentID string `json:"document_id"`
+	ChapterID  string `json:"chapter_id"`
+	SceneID    string `json:"scene_id"`
+	Content    string `json:"content"`
+}
+
+// SearchResult 搜索响应，索引异步构建，刚写入的场景可能暂时搜不到
+type SearchResult struct {
+	Hits []SearchHit `json:"hits"`
+}
+
+// SemanticSearchHit 一个语义相近的章节，Score 为余弦相似度，Snippet 为章节内容的开头片段
+type SemanticSearchHit struct {
+	ChapterID string  `json:"chapter_id"`
+	Index     int     `json:"index"`
+	Title     string  `json:"title"`
+	Snippet   string  `json:"snippet"`
+	Score     float64 `json:"score"`
+}
+
+// SemanticSearchResult 文档内语义搜索的响应，按相似度降序
+type SemanticSearchResult struct {
+	Hits []SemanticSearchHit `json:"hits"`
+}
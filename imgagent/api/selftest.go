@@ -0,0 +1,25 @@
+package api
+
+// 自检使用的服务
+const (
+	SelfTestProviderMock = "mock" // 模拟的百炼、图片和语音服务，只验证本实例的存储、数据库和处理流程
+	SelfTestProviderReal = "real" // 实际配置的服务，会产生少量调用费用
+)
+
+// SelfTestStage 自检阶段的结果，前面的阶段失败时后续阶段跳过
+type SelfTestStage struct {
+	Name       string `json:"name"` // split|upload|summary|roles|scenes|image|tts
+	OK         bool   `json:"ok"`
+	Skipped    bool   `json:"skipped,omitempty"`
+	DurationMs int64  `json:"duration_ms"`
+	Detail     string `json:"detail,omitempty"` // 阶段产出的概要，如章节数、图片 URL
+	Error      string `json:"error,omitempty"`
+}
+
+// SelfTestResult 用内置的短篇文档走一遍完整处理流程的结果，不创建文档
+type SelfTestResult struct {
+	Provider   string          `json:"provider"`
+	OK         bool            `json:"ok"`
+	DurationMs int64           `json:"duration_ms"`
+	Stages     []SelfTestStage `json:"stages"`
+}
@@ -0,0 +1,19 @@
+package api
+
+// StatsSummary 当前租户的统计概览，本月按服务端时区的自然月计算
+type StatsSummary struct {
+	DocumentsByStatus map[string]int64 `json:"documents_by_status"`
+	ScenesThisMonth   int64            `json:"scenes_this_month"` // 本月生成的场景图片数
+	VoicesThisMonth   int64            `json:"voices_this_month"` // 本月生成的场景语音数
+	// ProcessedThisMonth 本月首次处理结束的文档数，AvgProcessingSecs 为这些文档从创建到处理结束的平均耗时
+	ProcessedThisMonth int        `json:"processed_this_month"`
+	AvgProcessingSecs  float64    `json:"avg_processing_secs"`
+	Queue              StatsQueue `json:"queue"`
+}
+
+// StatsQueue 当前排队情况，Documents 为处理中且未暂停的文档数，Scenes 为其中尚未生成完成的场景数
+type StatsQueue struct {
+	Documents       int64 `json:"documents"`
+	PausedDocuments int64 `json:"paused_documents"`
+	Scenes          int64 `json:"scenes"`
+}
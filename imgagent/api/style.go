@@ -0,0 +1,29 @@
+package api
+
+// ImageStyle 文档级的场景图片风格，合并到每个场景的图片请求中：
+// 预设的提示词、Prompt、场景级的 style 依次追加到画面风格，预设和文档的反向提示词合并后传给图片服务
+type ImageStyle struct {
+	Preset         string `json:"preset,omitempty" binding:"max=50"`           // 风格预设名称，见 /style-presets
+	Prompt         string `json:"prompt,omitempty" binding:"max=500"`          // 额外的正向提示词
+	NegativePrompt string `json:"negative_prompt,omitempty" binding:"max=500"` // 画面中需要避免的内容
+}
+
+// VoiceProsody 文档级的场景语音参数，场景可通过 SceneOverrides 逐项覆盖。
+// 为零值的字段使用语音服务的默认值，取值范围由语音服务决定，见 /limits 的 tts
+type VoiceProsody struct {
+	Speed   float64 `json:"speed,omitempty" binding:"min=0"`     // 语速倍数，1 为正常语速
+	Pitch   float64 `json:"pitch,omitempty" binding:"min=0"`     // 音调倍数，1 为正常音调
+	Emotion string  `json:"emotion,omitempty" binding:"max=200"` // 情感或朗读风格，如 欢快、低沉
+}
+
+// StylePreset 画面风格预设
+type StylePreset struct {
+	Name           string `json:"name"`
+	Title          string `json:"title"`
+	Prompt         string `json:"prompt"`
+	NegativePrompt string `json:"negative_prompt"`
+}
+
+type ListStylePresetsResult struct {
+	Presets []StylePreset `json:"presets"`
+}
@@ -0,0 +1,18 @@
+package api
+
+// URLPolicy 租户通过 URL 创建文档时允许访问的来源，域名支持 *.example.com
+type URLPolicy struct {
+	UserID         int64    `json:"user_id"`
+	AllowedDomains []string `json:"allowed_domains"`
+	DeniedDomains  []string `json:"denied_domains"`
+	AllowPrivate   bool     `json:"allow_private"`
+	Default        bool     `json:"default"` // 租户未单独设置，使用默认策略
+	UpdatedAt      string   `json:"updated_at,omitempty"`
+}
+
+// UpdateURLPolicyArgs AllowedDomains 为空时不限制域名，DeniedDomains 优先于 AllowedDomains
+type UpdateURLPolicyArgs struct {
+	AllowedDomains []string `json:"allowed_domains" binding:"max=100"`
+	DeniedDomains  []string `json:"denied_domains" binding:"max=100"`
+	AllowPrivate   bool     `json:"allow_private"`
+}
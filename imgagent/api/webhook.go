@@ -0,0 +1,50 @@
+package api
+
+// CreateWebhookArgs 注册 webhook 参数，DocumentID 为空表示接收所有文档的事件，Secret 为空时由服务端生成
+type CreateWebhookArgs struct {
+	URL        string `json:"url" binding:"required,url,max=512"`
+	DocumentID string `json:"document_id"`
+	Secret     string `json:"secret" binding:"max=64"`
+	// BatchScenes 为 true 时不发送 scene.* 事件，改为按章节合并为 chapter.scenes.ready 事件，
+	// 章节在防抖窗口内没有新的场景就绪时投递
+	BatchScenes bool `json:"batch_scenes"`
+	// Template 自定义回调 body 的 Go text/template 模板，为空时发送 WebhookEvent JSON。
+	// 模板数据为 WebhookEvent JSON 解码后的对象，字段名与 JSON 相同，如 {{.type}}、{{.data.name}}；
+	// 可用函数 json、default、title（事件标题），可引用内置模板 {{template "text" .}}（纯文本摘要）
+	// 和 {{template "dingtalk" .}}（钉钉机器人文本消息）
+	Template string `json:"template" binding:"max=8192"`
+	// ContentType 回调请求的 Content-Type，默认 application/json
+	ContentType string `json:"content_type" binding:"max=100"`
+}
+
+// Webhook Secret 只在注册时返回
+type Webhook struct {
+	ID          string `json:"id"`
+	URL         string `json:"url"`
+	DocumentID  string `json:"document_id"`
+	Secret      string `json:"secret,omitempty"`
+	BatchScenes bool   `json:"batch_scenes"`
+	Template    string `json:"template,omitempty"`
+	ContentType string `json:"content_type,omitempty"`
+	CreatedAt   string `json:"created_at"`
+}
+
+type ListWebhooksResult struct {
+	Webhooks []Webhook `json:"webhooks"`
+}
+
+// WebhookEvent 回调请求 body，document.* 事件的 Data 为 Document，scene.* 事件的 Data 为 Scene，
+// chapter.scenes.ready 事件的 Data 为 ChapterScenesReady，quota.* 事件的 Data 为 QuotaAlert
+type WebhookEvent struct {
+	ID        string `json:"id"`
+	Type      string `json:"type"`
+	CreatedAt string `json:"created_at"`
+	Data      any    `json:"data"`
+}
+
+// ChapterScenesReady 合并投递的章节场景就绪事件，Scenes 按场景序号排列
+type ChapterScenesReady struct {
+	DocumentID string  `json:"document_id"`
+	ChapterID  string  `json:"chapter_id"`
+	Scenes     []Scene `json:"scenes"`
+}
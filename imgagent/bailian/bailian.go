@@ -2,9 +2,13 @@ package bailian
 
 import (
 	"net/http"
-	"time"
+	"sync/atomic"
 
 	"go.uber.org/zap"
+
+	"imgagent/pkg/faults"
+	"imgagent/pkg/logger"
+	"imgagent/pkg/tracing"
 )
 
 // Config 阿里云百炼配置
@@ -16,15 +20,43 @@ type Config struct {
 	ScenePrompt    string `json:"scene_prompt"`    // 场景生成 Prompt
 	ImageSize      string `json:"image_size"`      // 图片尺寸
 	ImageWatermark bool   `json:"image_watermark"` // 是否添加水印
-	RequestTimeout int    `json:"request_timeout"` // 请求超时时间（秒）
-	MaxRetries     int    `json:"max_retries"`     // 最大重试次数
+	RequestTimeout int    `json:"request_timeout"` // 请求超时时间（秒），每次重试单独计时
+	MaxRetries     int    `json:"max_retries"`     // 429、5xx 和网络错误的最大重试次数，0 不重试
+
+	RetryBackoffMs int     `json:"retry_backoff_ms"` // 首次重试的退避时间（毫秒），之后每次翻倍，默认 1000
+	RateLimit      float64 `json:"rate_limit"`       // 客户端每秒最多发出的请求数，0 不限制
+	RateBurst      int     `json:"rate_burst"`       // 限流允许的突发请求数，默认为 rate_limit 取整
+
+	Models // 调用的模型，可在运行时通过 SetModels 切换
+}
+
+// Models 调用的模型，为空时使用默认模型
+type Models struct {
+	ChatModel  string `json:"chat_model"`  // 摘要、角色和场景提取、问答，默认 qwen-long
+	ImageModel string `json:"image_model"` // 场景和封面图片，默认 qwen-image-plus；有角色参考图时使用图像编辑模型
+	TTSModel   string `json:"tts_model"`   // 场景语音，默认 qwen3-tts-flash
+}
+
+func (m Models) withDefaults() Models {
+	if m.ChatModel == "" {
+		m.ChatModel = defaultChatModel
+	}
+	if m.ImageModel == "" {
+		m.ImageModel = defaultImageModel
+	}
+	if m.TTSModel == "" {
+		m.TTSModel = defaultTTSModel
+	}
+	return m
 }
 
 // Client 阿里云百炼客户端
 type Client struct {
 	config     Config
 	httpClient *http.Client
+	limiter    *rateLimiter
 	logger     *zap.SugaredLogger
+	models     atomic.Pointer[Models]
 }
 
 // NewClient 创建新的百炼客户端
@@ -39,32 +71,63 @@ func NewClient(config Config) (*Client, error) {
 	if config.RequestTimeout == 0 {
 		config.RequestTimeout = 300 // 5分钟
 	}
+	if config.RetryBackoffMs == 0 {
+		config.RetryBackoffMs = 1000
+	}
 
 	// 设置默认 Prompt
 	if config.SummaryPrompt == "" {
 		config.SummaryPrompt = defaultSummaryPrompt
 	}
 	if config.RolePrompt == "" {
-		config.RolePrompt = defaultRolePrompt
+		config.RolePrompt = DefaultRolePrompt
 	}
 	if config.ScenePrompt == "" {
-		config.ScenePrompt = defaultScenePrompt
+		config.ScenePrompt = DefaultScenePrompt
 	}
 
-	// 创建 HTTP 客户端
+	// 创建 HTTP 客户端，超时由 do 按每次请求设置；请求携带 X-Request-ID，便于与百炼侧的请求对应
 	httpClient := &http.Client{
-		Timeout: time.Duration(config.RequestTimeout) * time.Second,
+		Transport: tracing.NewTransport(logger.NewTransport(newRequestLogTransport(faults.NewTransport(http.DefaultTransport, faults.TargetBailian)))),
 	}
 
-	return &Client{
+	c := &Client{
 		config:     config,
 		httpClient: httpClient,
+		limiter:    newRateLimiter(config.RateLimit, config.RateBurst),
 		logger:     zap.S().Named("bailian"),
-	}, nil
+	}
+	c.SetModels(config.Models)
+	return c, nil
+}
+
+// Models 当前使用的模型
+func (c *Client) Models() Models {
+	return *c.models.Load()
+}
+
+// SetModels 切换之后的调用使用的模型，进行中的调用不受影响
+func (c *Client) SetModels(models Models) {
+	models = models.withDefaults()
+	c.models.Store(&models)
 }
 
-// 默认角色提取 Prompt
-const defaultRolePrompt = `请仔细分析这篇小说，提取出所有主要人物角色的信息。对每个角色，请提供：
+// 未配置或未通过 GenerateOptions 指定时使用的模型和音色
+const (
+	defaultChatModel  = "qwen-long"
+	defaultImageModel = "qwen-image-plus"
+	defaultTTSModel   = "qwen3-tts-flash"
+	defaultVoice      = "Cherry"
+)
+
+// 场景图片带角色参考立绘时使用图像编辑模型，最多支持 3 张参考图
+const (
+	defaultReferenceImageModel = "qwen-image-edit-plus"
+	maxReferenceImages         = 3
+)
+
+// DefaultRolePrompt 默认角色提取 Prompt
+const DefaultRolePrompt = `请仔细分析这篇小说，提取出所有主要人物角色的信息。对每个角色，请提供：
 1. 姓名（name）
 2. 性别（gender）：男/女/未知
 3. 性格特点（character）：简要描述角色的性格特征
@@ -99,8 +162,8 @@ const defaultSummaryPrompt = `请为这篇小说生成一段简洁的摘要，
 返回格式示例：
 这是一部现代都市悬疑小说，讲述了...`
 
-// 默认场景生成 Prompt
-const defaultScenePrompt = `请将以下章节内容拆分为 0-3 个关键场景，用于生成连环漫画。
+// DefaultScenePrompt 默认场景生成 Prompt，%s 为章节内容
+const DefaultScenePrompt = `请将以下章节内容拆分为 0-3 个关键场景，用于生成连环漫画。
 
 要求：
 1. 每个场景用一句话描述，适合作为文生图的提示词
@@ -109,11 +172,92 @@ const defaultScenePrompt = `请将以下章节内容拆分为 0-3 个关键场
 4. 每个场景描述要包含：地点、人物、事件
 5. 场景描述要便于AI理解和画图
 6. 考虑连环画的阅读节奏，场景之间要有逻辑连贯性
-7. 严格返回 JSON 数组格式，每个元素是一个场景描述字符串
+7. 严格返回 JSON 数组格式，每个元素是一个场景对象：description 为场景描述；location 为地点；
+   time_of_day 为时间段，只能是 dawn、morning、noon、afternoon、evening、night 之一，无法判断时为空字符串；
+   mood 为画面氛围，如 温馨、紧张、悲伤；characters_present 为场景中出场的人物名字
 8. 最多返回 3 个场景
 
 章节内容：
 %s
 
 返回格式示例：
-["场景1的描述文字", "场景2的描述文字", "场景3的描述文字"]`
+[{"description": "场景1的描述文字", "location": "湖边的小屋", "time_of_day": "evening", "mood": "温馨", "characters_present": ["人物甲", "人物乙"]}]`
+
+// 章节摘要 Prompt，%s 为章节内容
+const chapterSummaryPrompt = `请用 100-200 字概括以下章节的主要情节，包括出场人物、地点和关键事件。
+直接返回摘要文本，不要有其他说明或格式标记。
+
+章节内容：
+%s`
+
+// 角色合并 Prompt，%s 为同一人物的多条角色记录
+const consolidateRolePrompt = `以下是从同一部小说中提取出的多条角色记录，它们指的是同一个人物（可能是不同年龄段、称呼或别名）。
+请将它们合并为一条角色记录：
+
+要求：
+1. 姓名（name）使用第一条记录的姓名
+2. 性别（gender）取记录中明确的性别
+3. 性格特点（character）综合各条记录，去掉重复和矛盾的描述
+4. 外貌描述（appearance）综合各条记录，形成一段统一、适合生成角色画像的描述；不同时期外貌差异较大时以主要时期为准
+5. 严格按照 JSON 对象格式返回，不要有其他文字说明
+
+角色记录：
+%s
+
+返回格式示例：
+{"name": "张三", "gender": "男", "character": "勇敢、正直", "appearance": "身材魁梧，浓眉大眼"}`
+
+// 文档问答 Prompt，第一个 %s 为编号的参考片段，第二个 %s 为问题
+const answerQuestionPrompt = `请只根据以下小说片段回答问题。每个片段以 [编号] 开头。
+要求：
+1. 回答使用中文，简洁准确，在引用片段内容的句子后用 [编号] 标注出处
+2. 片段中没有相关信息时，回答"根据文档内容无法回答该问题"，citations 为空数组
+3. citations 列出回答实际引用的片段编号
+4. 严格按照 JSON 对象格式返回，不要有其他文字说明
+
+片段：
+%s
+
+问题：%s
+
+返回格式示例：
+{"answer": "林晓在石桥边遇见了老人[1]，两人约定来年再见[2]。", "citations": [1, 2]}`
+
+// 章节翻译 Prompt，第一个 %s 为目标语言，第二个 %s 为章节标题，第三个 %s 为章节内容
+const translateChapterPrompt = `请将以下小说章节的标题和内容翻译为%s。
+要求：
+1. 忠实原文，保留段落划分，人名、地名在全文中保持一致的译法
+2. 译文符合目标语言的文学表达习惯，不要添加注释或解释
+3. 严格按照 JSON 对象格式返回，不要有其他文字说明
+
+标题：%s
+
+内容：
+%s
+
+返回格式示例：
+{"title": "译文标题", "content": "译文内容"}`
+
+// 全书梗概 Prompt，第一个 %s 为小说名称，第二个 %s 为按顺序编号的各部分摘要
+const documentSynopsisPrompt = `以下是小说《%s》按顺序排列的各部分摘要。请综合所有摘要：
+1. synopsis：用 300-500 字写出全书梗概，交代主要人物、背景和故事主线的起承转合
+2. plot_points：按发生顺序列出 5-10 条推动故事发展的关键情节，每条不超过 50 字
+3. 只根据摘要内容归纳，不要编造摘要中没有的情节
+4. 严格按照 JSON 对象格式返回，不要有其他文字说明
+
+各部分摘要：
+%s
+
+返回格式示例：
+{"synopsis": "全书梗概", "plot_points": ["关键情节一", "关键情节二"]}`
+
+// 角色关系提取 Prompt，%s 为以顿号分隔的角色名字
+const roleRelationPrompt = `请根据这篇小说，分析以下角色之间的关系：%s
+1. source、target 必须是上面列出的角色名字，不要使用别名或列表外的人物
+2. type 表示 source 相对 target 的身份，只能是 parent（父母）、child（子女）、sibling（兄弟姐妹）、spouse（配偶）、lover（恋人）、friend（朋友）、rival（对手）、enemy（仇敌）、mentor（师长）、student（弟子）、colleague（同伴、同事）、master（主人、上级）、servant（仆从、下属）、other（其他）之一
+3. description 用一句话说明两人的关系，不超过 50 字
+4. 只列出小说中明确体现的关系，同一对角色只列出最主要的一种关系
+5. 严格按照 JSON 数组格式返回，不要有其他文字说明
+
+返回格式示例：
+[{"source": "角色甲", "target": "角色乙", "type": "parent", "description": "角色甲是角色乙的父亲"}]`
@@ -8,8 +8,10 @@ import (
 	"io"
 	"mime/multipart"
 	"net/http"
+	"net/url"
 	"os"
 	"path/filepath"
+	"strconv"
 
 	"imgagent/pkg/logger"
 )
@@ -70,7 +72,7 @@ func (c *Client) UploadFile(ctx context.Context, filename string) (string, error
 	req.Header.Set("Content-Type", writer.FormDataContentType())
 
 	// 发送请求
-	resp, err := c.httpClient.Do(req)
+	resp, err := c.do(req)
 	if err != nil {
 		log.Errorf("Failed to send request, err: %v", err)
 		return "", fmt.Errorf("send request failed: %w", err)
@@ -105,3 +107,82 @@ func (c *Client) UploadFile(ctx context.Context, filename string) (string, error
 	log.Infof("File uploaded successfully, fileID: %s", uploadResp.ID)
 	return uploadResp.ID, nil
 }
+
+// ListFiles 分页列取已上传到百炼的文件，after 为上一页最后一个文件的 id，为空时从头开始
+func (c *Client) ListFiles(ctx context.Context, after string, limit int) (*ListFilesResponse, error) {
+	log := logger.FromContext(ctx)
+
+	query := url.Values{}
+	query.Set("limit", strconv.Itoa(limit))
+	if after != "" {
+		query.Set("after", after)
+	}
+	reqURL := fmt.Sprintf("%s/compatible-mode/v1/files?%s", c.config.BaseURL, query.Encode())
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, reqURL, nil)
+	if err != nil {
+		log.Errorf("Failed to create request, err: %v", err)
+		return nil, fmt.Errorf("create request failed: %w", err)
+	}
+	req.Header.Set("Authorization", fmt.Sprintf("Bearer %s", c.config.APIKey))
+
+	resp, err := c.do(req)
+	if err != nil {
+		log.Errorf("Failed to send request, err: %v", err)
+		return nil, fmt.Errorf("send request failed: %w", err)
+	}
+	defer resp.Body.Close()
+
+	respBody, err := io.ReadAll(resp.Body)
+	if err != nil {
+		log.Errorf("Failed to read response, err: %v", err)
+		return nil, fmt.Errorf("read response failed: %w", err)
+	}
+	if resp.StatusCode != http.StatusOK {
+		log.Errorf("List files failed, status: %d, body: %s", resp.StatusCode, string(respBody))
+		return nil, fmt.Errorf("list files failed, status: %d, body: %s", resp.StatusCode, string(respBody))
+	}
+
+	var listResp ListFilesResponse
+	err = json.Unmarshal(respBody, &listResp)
+	if err != nil {
+		log.Errorf("Failed to parse response, err: %v, body: %s", err, string(respBody))
+		return nil, fmt.Errorf("parse response failed: %w", err)
+	}
+	return &listResp, nil
+}
+
+// DeleteFile 删除百炼上的文件，文件不存在时视为删除成功
+func (c *Client) DeleteFile(ctx context.Context, fileID string) error {
+	log := logger.FromContext(ctx)
+	log.Infof("Deleting file from Bailian, fileID: %s", fileID)
+
+	reqURL := fmt.Sprintf("%s/compatible-mode/v1/files/%s", c.config.BaseURL, url.PathEscape(fileID))
+	req, err := http.NewRequestWithContext(ctx, http.MethodDelete, reqURL, nil)
+	if err != nil {
+		log.Errorf("Failed to create request, err: %v", err)
+		return fmt.Errorf("create request failed: %w", err)
+	}
+	req.Header.Set("Authorization", fmt.Sprintf("Bearer %s", c.config.APIKey))
+
+	resp, err := c.do(req)
+	if err != nil {
+		log.Errorf("Failed to send request, err: %v", err)
+		return fmt.Errorf("send request failed: %w", err)
+	}
+	defer resp.Body.Close()
+
+	respBody, err := io.ReadAll(resp.Body)
+	if err != nil {
+		log.Errorf("Failed to read response, err: %v", err)
+		return fmt.Errorf("read response failed: %w", err)
+	}
+	if resp.StatusCode == http.StatusNotFound {
+		log.Warnf("File not found on Bailian, fileID: %s", fileID)
+		return nil
+	}
+	if resp.StatusCode != http.StatusOK {
+		log.Errorf("Delete file failed, status: %d, body: %s", resp.StatusCode, string(respBody))
+		return fmt.Errorf("delete file failed, status: %d, body: %s", resp.StatusCode, string(respBody))
+	}
+	return nil
+}
@@ -0,0 +1,118 @@
+package bailian
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"time"
+
+	"imgagent/pkg/logger"
+)
+
+const (
+	// defaultImageEditModel 图像超分使用的通用图像编辑模型
+	defaultImageEditModel = "wanx2.1-imageedit"
+	// taskPollInterval 查询异步任务状态的间隔
+	taskPollInterval = 3 * time.Second
+)
+
+// UpscaleImage 图像超分，将图片放大 scale 倍（1-4），image 为图片 URL 或 data:image/png;base64 格式的数据。
+// 超分为异步任务，提交后轮询直到完成或 ctx 结束，返回高清图片 URL
+func (c *Client) UpscaleImage(ctx context.Context, image string, scale int) (string, error) {
+	log := logger.FromContext(ctx)
+	log.Infof("Upscaling image, scale: %d", scale)
+
+	req := ImageEditRequest{
+		Model: defaultImageEditModel,
+		Input: ImageEditInput{
+			Function:     "super_resolution",
+			Prompt:       "图像超分",
+			BaseImageURL: image,
+		},
+		Parameters: ImageEditParameters{
+			UpscaleFactor: scale,
+			N:             1,
+		},
+	}
+	reqBody, err := json.Marshal(req)
+	if err != nil {
+		log.Errorf("Failed to marshal request, err: %v", err)
+		return "", fmt.Errorf("marshal request failed: %w", err)
+	}
+
+	url := fmt.Sprintf("%s/api/v1/services/aigc/image2image/image-synthesis", c.config.BaseURL)
+	httpReq, err := http.NewRequestWithContext(ctx, "POST", url, bytes.NewReader(reqBody))
+	if err != nil {
+		log.Errorf("Failed to create request, err: %v", err)
+		return "", fmt.Errorf("create request failed: %w", err)
+	}
+	httpReq.Header.Set("Content-Type", "application/json")
+	httpReq.Header.Set("X-DashScope-Async", "enable")
+
+	task, err := c.callTask(httpReq)
+	if err != nil {
+		log.Errorf("Failed to submit upscale task, err: %v", err)
+		return "", err
+	}
+	log.Infof("Upscale task submitted, taskID: %s", task.TaskID)
+
+	for {
+		switch task.TaskStatus {
+		case "SUCCEEDED":
+			if len(task.Results) == 0 || task.Results[0].URL == "" {
+				return "", fmt.Errorf("no image in task result, taskID: %s", task.TaskID)
+			}
+			log.Infof("Image upscaled successfully, URL: %s", task.Results[0].URL)
+			return task.Results[0].URL, nil
+		case "FAILED", "CANCELED", "UNKNOWN":
+			return "", fmt.Errorf("upscale task %s, taskID: %s, code: %s, message: %s", task.TaskStatus, task.TaskID, task.Code, task.Message)
+		}
+
+		select {
+		case <-ctx.Done():
+			return "", ctx.Err()
+		case <-time.After(taskPollInterval):
+		}
+		httpReq, err = http.NewRequestWithContext(ctx, "GET", fmt.Sprintf("%s/api/v1/tasks/%s", c.config.BaseURL, task.TaskID), nil)
+		if err != nil {
+			return "", fmt.Errorf("create request failed: %w", err)
+		}
+		taskID := task.TaskID
+		task, err = c.callTask(httpReq)
+		if err != nil {
+			log.Errorf("Failed to get upscale task, taskID: %s, err: %v", taskID, err)
+			return "", err
+		}
+	}
+}
+
+// callTask 提交或查询异步任务，返回任务状态
+func (c *Client) callTask(httpReq *http.Request) (*TaskOutput, error) {
+	httpReq.Header.Set("Authorization", fmt.Sprintf("Bearer %s", c.config.APIKey))
+	resp, err := c.do(httpReq)
+	if err != nil {
+		return nil, fmt.Errorf("send request failed: %w", err)
+	}
+	defer resp.Body.Close()
+
+	respBody, err := io.ReadAll(resp.Body)
+	if err != nil {
+		return nil, fmt.Errorf("read response failed: %w", err)
+	}
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("task request failed, status: %d, body: %s", resp.StatusCode, string(respBody))
+	}
+
+	var taskResp TaskResponse
+	err = json.Unmarshal(respBody, &taskResp)
+	if err != nil {
+		return nil, fmt.Errorf("parse response failed: %w", err)
+	}
+	if taskResp.Output.TaskID == "" {
+		return nil, fmt.Errorf("no task id in response, body: %s", string(respBody))
+	}
+	return &taskResp.Output, nil
+}
@@ -1,14 +1,28 @@
 package bailian
 
-import "context"
+import (
+	"context"
+	"time"
+)
 
 // BailianInterface 定义百炼客户端的接口
 type BailianInterface interface {
 	UploadFile(ctx context.Context, filename string) (string, error)
+	ListFiles(ctx context.Context, after string, limit int) (*ListFilesResponse, error)
+	DeleteFile(ctx context.Context, fileID string) error
 	ExtractSummary(ctx context.Context, fileID string) (string, error)
-	ExtractRoles(ctx context.Context, fileID string, summary string) ([]RoleInfo, error)
-	GenerateScenes(ctx context.Context, content string) ([]string, error)
-	GenerateImage(ctx context.Context, prompt string, summary string, roles []RoleInfo) (string, error)
+	ExtractRoles(ctx context.Context, fileID string, summary string, prompt string) ([]RoleInfo, error)
+	ExtractRolesFromText(ctx context.Context, content string, summary string, prompt string) ([]RoleInfo, error)
+	ConsolidateRole(ctx context.Context, roles []RoleInfo) (RoleInfo, error)
+	ExtractRoleRelations(ctx context.Context, fileID string, summary string, roles []string) ([]RoleRelation, error)
+	GenerateScenes(ctx context.Context, content string, prompt string) ([]SceneInfo, error)
+	SummarizeChapterStream(ctx context.Context, content string, fn func(delta string) error) (string, error)
+	AnswerQuestion(ctx context.Context, question string, sources []QASource) (QAAnswer, error)
+	TranslateChapter(ctx context.Context, title, content, language string) (TranslatedChapter, error)
+	SummarizeDocument(ctx context.Context, name string, summaries []string) (DocumentSynopsis, error)
+	GenerateImage(ctx context.Context, prompt string, summary string, roles []RoleInfo, opts GenerateOptions) (string, error)
 	GenerateCoverImage(ctx context.Context, summary string) (string, error)
-	GenerateTTS(ctx context.Context, text string) (string, error)
+	UpscaleImage(ctx context.Context, image string, scale int) (string, error)
+	GenerateTTS(ctx context.Context, text string, opts GenerateOptions) (string, error)
+	AudioDuration(ctx context.Context, audioURL string) (time.Duration, error)
 }
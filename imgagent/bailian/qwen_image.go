@@ -7,6 +7,7 @@ import (
 	"fmt"
 	"io"
 	"net/http"
+	"strings"
 
 	"imgagent/pkg/logger"
 )
@@ -23,7 +24,7 @@ func (c *Client) GenerateCoverImage(ctx context.Context, summary string) (string
 
 	// 构建请求
 	req := ImageGenerationRequest{
-		Model: "qwen-image-plus",
+		Model: c.Models().ImageModel,
 		Input: ImageInput{
 			Messages: []ImageMessage{
 				{
@@ -61,7 +62,7 @@ func (c *Client) GenerateCoverImage(ctx context.Context, summary string) (string
 	httpReq.Header.Set("Content-Type", "application/json")
 
 	// 发送请求
-	resp, err := c.httpClient.Do(httpReq)
+	resp, err := c.do(httpReq)
 	if err != nil {
 		log.Errorf("Failed to send request, err: %v", err)
 		return "", fmt.Errorf("send request failed: %w", err)
@@ -123,34 +124,48 @@ func buildCoverImagePrompt(summary string) string {
 	return prompt
 }
 
-// GenerateImage 根据场景描述生成图片
+// GenerateImage 根据场景描述生成图片，opts 中的风格、分辨率和模型覆盖默认值
 // 返回图片 URL
-func (c *Client) GenerateImage(ctx context.Context, sceneContent string, summary string, roles []RoleInfo) (string, error) {
+func (c *Client) GenerateImage(ctx context.Context, sceneContent string, summary string, roles []RoleInfo, opts GenerateOptions) (string, error) {
 	log := logger.FromContext(ctx)
 	log.Infof("Generating image for scene, content: %s", sceneContent)
 
-	// 构建完整的提示词
-	prompt := buildImagePrompt(sceneContent, summary, roles)
-	log.Infof("Full image prompt: %s", prompt)
+	prompt, references := ReferenceImagePrompt(sceneContent, summary, roles, opts)
+	log.Infof("Full image prompt: %s, references: %v", prompt, references)
+
+	content := make([]ImageContent, 0, len(references)+1)
+	for _, url := range references {
+		content = append(content, ImageContent{Image: url})
+	}
+	content = append(content, ImageContent{Text: prompt})
+
+	model, size := c.Models().ImageModel, c.config.ImageSize
+	if len(references) > 0 {
+		model = defaultReferenceImageModel
+	}
+	if opts.ImageModel != "" {
+		model = opts.ImageModel
+	}
+	if opts.ImageSize != "" {
+		size = opts.ImageSize
+	}
 
 	// 构建请求
 	req := ImageGenerationRequest{
-		Model: "qwen-image-plus",
+		Model: model,
 		Input: ImageInput{
 			Messages: []ImageMessage{
 				{
-					Role: "user",
-					Content: []ImageContent{
-						{Text: prompt},
-					},
+					Role:    "user",
+					Content: content,
 				},
 			},
 		},
 		Parameters: Parameters{
-			NegativePrompt: "",
+			NegativePrompt: opts.NegativePrompt,
 			PromptExtend:   true,
 			Watermark:      c.config.ImageWatermark,
-			Size:           c.config.ImageSize,
+			Size:           size,
 		},
 	}
 
@@ -173,7 +188,7 @@ func (c *Client) GenerateImage(ctx context.Context, sceneContent string, summary
 	httpReq.Header.Set("Content-Type", "application/json")
 
 	// 发送请求
-	resp, err := c.httpClient.Do(httpReq)
+	resp, err := c.do(httpReq)
 	if err != nil {
 		log.Errorf("Failed to send request, err: %v", err)
 		return "", fmt.Errorf("send request failed: %w", err)
@@ -221,14 +236,75 @@ func (c *Client) GenerateImage(ctx context.Context, sceneContent string, summary
 	return imageURL, nil
 }
 
-func buildImagePrompt(sceneContent string, summary string, roles []RoleInfo) string {
+// FeaturedRoles 返回场景描述中提到名字的角色
+func FeaturedRoles(sceneContent string, roles []RoleInfo) []RoleInfo {
+	var featured []RoleInfo
+	for _, role := range roles {
+		if role.Name != "" && strings.Contains(sceneContent, role.Name) {
+			featured = append(featured, role)
+		}
+	}
+	return featured
+}
+
+// roleReferences 返回角色的参考立绘，最多 maxReferenceImages 张
+func roleReferences(roles []RoleInfo) []string {
+	var refs []string
+	for _, role := range roles {
+		if role.PortraitURL != "" && len(refs) < maxReferenceImages {
+			refs = append(refs, role.PortraitURL)
+		}
+	}
+	return refs
+}
+
+// ReferenceImagePrompt GenerateImage 使用的完整提示词和角色参考立绘，开启角色一致性时只使用场景中出现的角色
+func ReferenceImagePrompt(sceneContent string, summary string, roles []RoleInfo, opts GenerateOptions) (string, []string) {
+	var references []string
+	if opts.RoleConsistency {
+		roles = FeaturedRoles(sceneContent, roles)
+		references = roleReferences(roles)
+	}
+	if opts.Prompt != "" {
+		return opts.Prompt, references
+	}
+	return buildImagePrompt(sceneContent, summary, roles, opts.Style, opts.RoleConsistency), references
+}
+
+// ImagePrompt 不支持参考图的图片服务使用的完整提示词，开启角色一致性时只包含场景中出现的角色
+func ImagePrompt(sceneContent string, summary string, roles []RoleInfo, opts GenerateOptions) string {
+	if opts.Prompt != "" {
+		return opts.Prompt
+	}
+	if opts.RoleConsistency {
+		roles = FeaturedRoles(sceneContent, roles)
+		for i := range roles {
+			roles[i].PortraitURL = ""
+		}
+	}
+	return buildImagePrompt(sceneContent, summary, roles, opts.Style, opts.RoleConsistency)
+}
+
+func buildImagePrompt(sceneContent string, summary string, roles []RoleInfo, style string, consistent bool) string {
 	var prompt string
 
 	if summary != "" {
 		prompt += fmt.Sprintf("小说概要：%s\n\n", summary)
 	}
 
-	if len(roles) > 0 {
+	if consistent && len(roles) > 0 {
+		prompt += "画面中出现的角色（外貌必须与以下设定严格一致，不得改变发型、发色、五官、服饰和体型）：\n"
+		refs := 0
+		for _, role := range roles {
+			prompt += fmt.Sprintf("- %s：性别：%s；外貌特征：%s", role.Name, role.Gender, role.Appearance)
+			if role.PortraitURL != "" && refs < maxReferenceImages {
+				refs++
+				prompt += fmt.Sprintf("；长相参考第 %d 张参考图", refs)
+			}
+			prompt += "\n"
+		}
+		prompt += "\n"
+	} else if len(roles) > 0 {
 		prompt += "主要角色信息：\n"
 		for _, role := range roles {
 			if role.Appearance != "" {
@@ -239,6 +315,9 @@ func buildImagePrompt(sceneContent string, summary string, roles []RoleInfo) str
 	}
 
 	prompt += fmt.Sprintf("根据以下场景描述生成一张动漫图片：%s\n", sceneContent)
+	if style != "" {
+		prompt += fmt.Sprintf("画面风格：%s\n", style)
+	}
 
 	return prompt
 }
@@ -19,7 +19,7 @@ func (c *Client) ExtractSummary(ctx context.Context, fileID string) (string, err
 	log.Infof("Extracting summary from document, fileID: %s", fileID)
 
 	req := ChatCompletionRequest{
-		Model: "qwen-long",
+		Model: c.Models().ChatModel,
 		Messages: []Message{
 			{Role: "system", Content: "You are a helpful assistant."},
 			{Role: "system", Content: fmt.Sprintf("fileid://%s", fileID)},
@@ -52,19 +52,21 @@ func (c *Client) ExtractSummary(ctx context.Context, fileID string) (string, err
 }
 
 // ExtractRoles 从文档中提取角色信息
-// 使用 qwen-long 分析整个文档
-func (c *Client) ExtractRoles(ctx context.Context, fileID string, summary string) ([]RoleInfo, error) {
+// 使用 qwen-long 分析整个文档，prompt 为自定义模板渲染出的完整提示词，为空时使用配置的角色提取 Prompt
+func (c *Client) ExtractRoles(ctx context.Context, fileID string, summary string, prompt string) ([]RoleInfo, error) {
 	log := logger.FromContext(ctx)
 	log.Infof("Extracting roles from document, fileID: %s", fileID)
 
 	// 构建请求
-	prompt := c.config.RolePrompt
-	if summary != "" {
-		prompt = fmt.Sprintf("小说摘要：\n%s\n\n%s", summary, c.config.RolePrompt)
+	if prompt == "" {
+		prompt = c.config.RolePrompt
+		if summary != "" {
+			prompt = fmt.Sprintf("小说摘要：\n%s\n\n%s", summary, c.config.RolePrompt)
+		}
 	}
 
 	req := ChatCompletionRequest{
-		Model: "qwen-long",
+		Model: c.Models().ChatModel,
 		Messages: []Message{
 			{Role: "system", Content: "You are a helpful assistant."},
 			{Role: "system", Content: fmt.Sprintf("fileid://%s", fileID)},
@@ -73,6 +75,35 @@ func (c *Client) ExtractRoles(ctx context.Context, fileID string, summary string
 		Stream: false,
 	}
 
+	return c.completeRoles(ctx, req)
+}
+
+// ExtractRolesFromText 从给定的章节文字中提取角色信息，用于章节修改后重新提取，不依赖上传的原始文件。
+// prompt 为空时使用配置的角色提取 Prompt
+func (c *Client) ExtractRolesFromText(ctx context.Context, content string, summary string, prompt string) ([]RoleInfo, error) {
+	logger.FromContext(ctx).Infof("Extracting roles from text, content length: %d", len(content))
+
+	if prompt == "" {
+		prompt = c.config.RolePrompt
+		if summary != "" {
+			prompt = fmt.Sprintf("小说摘要：\n%s\n\n%s", summary, c.config.RolePrompt)
+		}
+	}
+	req := ChatCompletionRequest{
+		Model: c.Models().ChatModel,
+		Messages: []Message{
+			{Role: "system", Content: "You are a helpful assistant."},
+			{Role: "user", Content: fmt.Sprintf("小说内容：\n%s\n\n%s", content, prompt)},
+		},
+		Stream: false,
+	}
+	return c.completeRoles(ctx, req)
+}
+
+// completeRoles 调用大模型并从响应中解析角色列表
+func (c *Client) completeRoles(ctx context.Context, req ChatCompletionRequest) ([]RoleInfo, error) {
+	log := logger.FromContext(ctx)
+
 	// 调用 API
 	respBody, err := c.callChatCompletion(ctx, req)
 	if err != nil {
@@ -106,18 +137,20 @@ func (c *Client) ExtractRoles(ctx context.Context, fileID string, summary string
 	return roles, nil
 }
 
-// GenerateScenes 为章节生成场景描述
-// 每章生成 0-3 个场景
-func (c *Client) GenerateScenes(ctx context.Context, chapterContent string) ([]string, error) {
+// GenerateScenes 为章节生成场景描述及地点、时间段、氛围和出场人物
+// 每章生成 0-3 个场景，prompt 为自定义模板渲染出的完整提示词，为空时使用配置的场景生成 Prompt
+func (c *Client) GenerateScenes(ctx context.Context, chapterContent string, prompt string) ([]SceneInfo, error) {
 	log := logger.FromContext(ctx)
 	log.Infof("Generating scenes for chapter, content length: %d", len(chapterContent))
 
 	// 构建 prompt
-	prompt := fmt.Sprintf(c.config.ScenePrompt, chapterContent)
+	if prompt == "" {
+		prompt = fmt.Sprintf(c.config.ScenePrompt, chapterContent)
+	}
 
 	// 构建请求
 	req := ChatCompletionRequest{
-		Model: "qwen-long",
+		Model: c.Models().ChatModel,
 		Messages: []Message{
 			{Role: "system", Content: "You are a helpful assistant."},
 			{Role: "user", Content: prompt},
@@ -141,7 +174,7 @@ func (c *Client) GenerateScenes(ctx context.Context, chapterContent string) ([]s
 
 	if len(chatResp.Choices) == 0 {
 		log.Warnf("No choices in response, body: %s", string(respBody))
-		return []string{}, nil
+		return []SceneInfo{}, nil
 	}
 
 	content := chatResp.Choices[0].Message.Content
@@ -164,6 +197,49 @@ func (c *Client) GenerateScenes(ctx context.Context, chapterContent string) ([]s
 	return scenes, nil
 }
 
+// ConsolidateRole 将指向同一人物的多条角色记录合并为一条，综合性格和外貌描述
+func (c *Client) ConsolidateRole(ctx context.Context, roles []RoleInfo) (RoleInfo, error) {
+	log := logger.FromContext(ctx)
+	log.Infof("Consolidating %d roles", len(roles))
+
+	data, err := json.Marshal(roles)
+	if err != nil {
+		return RoleInfo{}, fmt.Errorf("marshal roles failed: %w", err)
+	}
+	req := ChatCompletionRequest{
+		Model: c.Models().ChatModel,
+		Messages: []Message{
+			{Role: "system", Content: "You are a helpful assistant."},
+			{Role: "user", Content: fmt.Sprintf(consolidateRolePrompt, data)},
+		},
+		Stream: false,
+	}
+
+	respBody, err := c.callChatCompletion(ctx, req)
+	if err != nil {
+		return RoleInfo{}, err
+	}
+	var chatResp ChatCompletionResponse
+	err = json.Unmarshal(respBody, &chatResp)
+	if err != nil {
+		log.Errorf("Failed to parse chat response, err: %v, body: %s", err, string(respBody))
+		return RoleInfo{}, fmt.Errorf("parse chat response failed: %w", err)
+	}
+	if len(chatResp.Choices) == 0 {
+		log.Warnf("No choices in response, body: %s", string(respBody))
+		return RoleInfo{}, fmt.Errorf("no choices in response")
+	}
+
+	content := chatResp.Choices[0].Message.Content
+	log.Infof("Raw role consolidation response: %s", content)
+	role, err := extractRoleFromJSON(content)
+	if err != nil {
+		log.Errorf("Failed to extract role from JSON, err: %v, content: %s", err, content)
+		return RoleInfo{}, fmt.Errorf("extract role from JSON failed: %w", err)
+	}
+	return role, nil
+}
+
 // callChatCompletion 调用 chat completion API
 func (c *Client) callChatCompletion(ctx context.Context, req ChatCompletionRequest) ([]byte, error) {
 	log := logger.FromContext(ctx)
@@ -187,7 +263,7 @@ func (c *Client) callChatCompletion(ctx context.Context, req ChatCompletionReque
 	httpReq.Header.Set("Content-Type", "application/json")
 
 	// 发送请求
-	resp, err := c.httpClient.Do(httpReq)
+	resp, err := c.do(httpReq)
 	if err != nil {
 		log.Errorf("Failed to send request, err: %v", err)
 		return nil, fmt.Errorf("send request failed: %w", err)
@@ -233,34 +309,126 @@ func extractRolesFromJSON(content string) ([]RoleInfo, error) {
 	return []RoleInfo{}, nil
 }
 
+// extractRoleFromJSON 从 JSON 字符串中提取单个角色，内容可能包含在代码块或其他文字中
+func extractRoleFromJSON(content string) (RoleInfo, error) {
+	var role RoleInfo
+	err := json.Unmarshal([]byte(content), &role)
+	if err == nil {
+		return role, nil
+	}
+
+	jsonPattern := regexp.MustCompile(`\{[\s\S]*\}`)
+	match := jsonPattern.FindString(content)
+	if match == "" {
+		return RoleInfo{}, fmt.Errorf("no JSON object in content")
+	}
+	err = json.Unmarshal([]byte(match), &role)
+	if err != nil {
+		return RoleInfo{}, err
+	}
+	return role, nil
+}
+
 // extractScenesFromJSON 从 JSON 字符串中提取场景描述
-func extractScenesFromJSON(content string) ([]string, error) {
+// 元素可以是场景对象，也可以是只有描述的字符串（自定义模板沿用旧的返回格式）
+func extractScenesFromJSON(content string) ([]SceneInfo, error) {
 	// 尝试直接解析
-	var scenes []string
-	err := json.Unmarshal([]byte(content), &scenes)
+	var items []json.RawMessage
+	err := json.Unmarshal([]byte(content), &items)
 	if err == nil {
-		return scenes, nil
+		return parseScenes(items), nil
 	}
 
-	// 尝试提取 JSON 数组
-	jsonPattern := regexp.MustCompile(`\[[\s\S]*?\]`)
-	matches := jsonPattern.FindAllString(content, -1)
-
-	for _, match := range matches {
-		err = json.Unmarshal([]byte(match), &scenes)
-		if err == nil {
-			// 过滤空字符串
-			filtered := make([]string, 0)
-			for _, scene := range scenes {
-				scene = strings.TrimSpace(scene)
-				if scene != "" {
-					filtered = append(filtered, scene)
-				}
-			}
-			return filtered, nil
+	// 尝试提取 JSON 数组，场景对象中包含 characters_present 数组，先匹配到最后一个 ]，失败时再逐个尝试
+	if match := regexp.MustCompile(`\[[\s\S]*\]`).FindString(content); match != "" && json.Unmarshal([]byte(match), &items) == nil {
+		return parseScenes(items), nil
+	}
+	for _, match := range regexp.MustCompile(`\[[\s\S]*?\]`).FindAllString(content, -1) {
+		if json.Unmarshal([]byte(match), &items) == nil {
+			return parseScenes(items), nil
 		}
 	}
 
 	// 如果都失败，返回空数组（不报错，因为可能内容不适合生成场景）
-	return []string{}, nil
+	return []SceneInfo{}, nil
+}
+
+// parseScenes 解析场景数组的元素，过滤描述为空和无法解析的元素
+func parseScenes(items []json.RawMessage) []SceneInfo {
+	scenes := make([]SceneInfo, 0, len(items))
+	for _, item := range items {
+		var scene SceneInfo
+		if json.Unmarshal(item, &scene.Description) != nil && json.Unmarshal(item, &scene) != nil {
+			continue
+		}
+		scene.Description = strings.TrimSpace(scene.Description)
+		if scene.Description != "" {
+			scenes = append(scenes, scene)
+		}
+	}
+	return scenes
+}
+
+// ExtractRoleRelations 根据小说原文提取 roles 之间的关系，summary 不为空时作为辅助信息
+func (c *Client) ExtractRoleRelations(ctx context.Context, fileID string, summary string, roles []string) ([]RoleRelation, error) {
+	log := logger.FromContext(ctx)
+	log.Infof("Extracting role relations from document, fileID: %s, roles: %d", fileID, len(roles))
+
+	prompt := fmt.Sprintf(roleRelationPrompt, strings.Join(roles, "、"))
+	if summary != "" {
+		prompt = fmt.Sprintf("小说摘要：\n%s\n\n%s", summary, prompt)
+	}
+	req := ChatCompletionRequest{
+		Model: c.Models().ChatModel,
+		Messages: []Message{
+			{Role: "system", Content: "You are a helpful assistant."},
+			{Role: "system", Content: fmt.Sprintf("fileid://%s", fileID)},
+			{Role: "user", Content: prompt},
+		},
+		Stream: false,
+	}
+
+	respBody, err := c.callChatCompletion(ctx, req)
+	if err != nil {
+		return nil, err
+	}
+	var chatResp ChatCompletionResponse
+	err = json.Unmarshal(respBody, &chatResp)
+	if err != nil {
+		log.Errorf("Failed to parse chat response, err: %v, body: %s", err, string(respBody))
+		return nil, fmt.Errorf("parse chat response failed: %w", err)
+	}
+	if len(chatResp.Choices) == 0 {
+		log.Warnf("No choices in response, body: %s", string(respBody))
+		return []RoleRelation{}, nil
+	}
+
+	content := chatResp.Choices[0].Message.Content
+	relations, err := extractRelationsFromJSON(content)
+	if err != nil {
+		log.Errorf("Failed to extract role relations from JSON, err: %v, content: %s", err, content)
+		return nil, fmt.Errorf("extract role relations from JSON failed: %w", err)
+	}
+	log.Infof("Extracted %d role relations", len(relations))
+	return relations, nil
+}
+
+// extractRelationsFromJSON 从 JSON 字符串中提取角色关系，内容可能包含在代码块或其他文字中
+func extractRelationsFromJSON(content string) ([]RoleRelation, error) {
+	var relations []RoleRelation
+	err := json.Unmarshal([]byte(content), &relations)
+	if err == nil {
+		return relations, nil
+	}
+
+	jsonPattern := regexp.MustCompile(`\[[\s\S]*\]`)
+	match := jsonPattern.FindString(content)
+	if match == "" {
+		return nil, fmt.Errorf("no JSON array in content")
+	}
+	err = json.Unmarshal([]byte(match), &relations)
+	if err != nil {
+		return nil, err
+	}
+	return relations, nil
 }
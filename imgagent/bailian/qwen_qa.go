@@ -0,0 +1,84 @@
+package bailian
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"regexp"
+	"slices"
+	"strings"
+
+	"imgagent/pkg/logger"
+)
+
+// AnswerQuestion 根据参考片段回答问题，引用编号不在 sources 中的忽略
+func (c *Client) AnswerQuestion(ctx context.Context, question string, sources []QASource) (QAAnswer, error) {
+	log := logger.FromContext(ctx)
+	log.Infof("Answering question, sources: %d, question length: %d", len(sources), len(question))
+
+	var b strings.Builder
+	for _, source := range sources {
+		fmt.Fprintf(&b, "[%d] %s\n%s\n\n", source.Index, source.Title, source.Content)
+	}
+	req := ChatCompletionRequest{
+		Model: c.Models().ChatModel,
+		Messages: []Message{
+			{Role: "system", Content: "You are a helpful assistant."},
+			{Role: "user", Content: fmt.Sprintf(answerQuestionPrompt, strings.TrimSpace(b.String()), question)},
+		},
+		Stream: false,
+	}
+
+	respBody, err := c.callChatCompletion(ctx, req)
+	if err != nil {
+		return QAAnswer{}, err
+	}
+	var chatResp ChatCompletionResponse
+	err = json.Unmarshal(respBody, &chatResp)
+	if err != nil {
+		log.Errorf("Failed to parse chat response, err: %v, body: %s", err, string(respBody))
+		return QAAnswer{}, fmt.Errorf("parse chat response failed: %w", err)
+	}
+	if len(chatResp.Choices) == 0 {
+		log.Warnf("No choices in response, body: %s", string(respBody))
+		return QAAnswer{}, fmt.Errorf("no choices in response")
+	}
+
+	content := chatResp.Choices[0].Message.Content
+	log.Infof("Raw answer response: %s", content)
+	answer, err := extractAnswerFromJSON(content)
+	if err != nil {
+		log.Errorf("Failed to extract answer from JSON, err: %v, content: %s", err, content)
+		return QAAnswer{}, fmt.Errorf("extract answer from JSON failed: %w", err)
+	}
+
+	citations := make([]int, 0, len(answer.Citations))
+	for _, n := range answer.Citations {
+		valid := slices.ContainsFunc(sources, func(s QASource) bool { return s.Index == n })
+		if valid && !slices.Contains(citations, n) {
+			citations = append(citations, n)
+		}
+	}
+	answer.Citations = citations
+	return answer, nil
+}
+
+// extractAnswerFromJSON 从 JSON 字符串中提取回答，内容可能包含在代码块或其他文字中
+func extractAnswerFromJSON(content string) (QAAnswer, error) {
+	var answer QAAnswer
+	err := json.Unmarshal([]byte(content), &answer)
+	if err == nil {
+		return answer, nil
+	}
+
+	jsonPattern := regexp.MustCompile(`\{[\s\S]*\}`)
+	match := jsonPattern.FindString(content)
+	if match == "" {
+		return QAAnswer{}, fmt.Errorf("no JSON object in content")
+	}
+	err = json.Unmarshal([]byte(match), &answer)
+	if err != nil {
+		return QAAnswer{}, err
+	}
+	return answer, nil
+}
@@ -0,0 +1,115 @@
+package bailian
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"strings"
+
+	"imgagent/pkg/logger"
+)
+
+// maxStreamLine SSE 响应中单行的最大长度
+const maxStreamLine = 1 << 20
+
+// SummarizeChapterStream 流式生成章节摘要，每收到一段增量内容调用 fn，返回完整摘要
+func (c *Client) SummarizeChapterStream(ctx context.Context, content string, fn func(delta string) error) (string, error) {
+	log := logger.FromContext(ctx)
+	log.Infof("Summarizing chapter (stream), content length: %d", len(content))
+
+	req := ChatCompletionRequest{
+		Model: c.Models().ChatModel,
+		Messages: []Message{
+			{Role: "system", Content: "You are a helpful assistant."},
+			{Role: "user", Content: fmt.Sprintf(chapterSummaryPrompt, content)},
+		},
+	}
+	summary, err := c.StreamChatCompletion(ctx, req, fn)
+	if err != nil {
+		return "", err
+	}
+	summary = strings.TrimSpace(summary)
+	log.Infof("Summarized chapter (length: %d)", len(summary))
+	return summary, nil
+}
+
+// StreamChatCompletion 以 SSE 流式调用 chat completion，每收到一段增量内容调用 fn，返回拼接后的完整内容。
+// fn 返回错误时停止读取并返回该错误
+func (c *Client) StreamChatCompletion(ctx context.Context, req ChatCompletionRequest, fn func(delta string) error) (string, error) {
+	log := logger.FromContext(ctx)
+
+	req.Stream = true
+	reqBody, err := json.Marshal(req)
+	if err != nil {
+		log.Errorf("Failed to marshal request, err: %v", err)
+		return "", fmt.Errorf("marshal request failed: %w", err)
+	}
+
+	url := fmt.Sprintf("%s/compatible-mode/v1/chat/completions", c.config.BaseURL)
+	httpReq, err := http.NewRequestWithContext(ctx, "POST", url, bytes.NewReader(reqBody))
+	if err != nil {
+		log.Errorf("Failed to create request, err: %v", err)
+		return "", fmt.Errorf("create request failed: %w", err)
+	}
+	httpReq.Header.Set("Authorization", fmt.Sprintf("Bearer %s", c.config.APIKey))
+	httpReq.Header.Set("Content-Type", "application/json")
+	httpReq.Header.Set("Accept", "text/event-stream")
+
+	resp, err := c.do(httpReq)
+	if err != nil {
+		log.Errorf("Failed to send request, err: %v", err)
+		return "", fmt.Errorf("send request failed: %w", err)
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		respBody, _ := io.ReadAll(io.LimitReader(resp.Body, 4096))
+		log.Errorf("API call failed, status: %d, body: %s", resp.StatusCode, string(respBody))
+		return "", fmt.Errorf("API call failed, status: %d, body: %s", resp.StatusCode, string(respBody))
+	}
+
+	var content strings.Builder
+	scanner := bufio.NewScanner(resp.Body)
+	scanner.Buffer(make([]byte, 0, 64*1024), maxStreamLine)
+	for scanner.Scan() {
+		data, ok := strings.CutPrefix(scanner.Text(), "data:")
+		if !ok {
+			continue
+		}
+		data = strings.TrimSpace(data)
+		if data == "[DONE]" {
+			return content.String(), nil
+		}
+
+		var chunk ChatCompletionChunk
+		err := json.Unmarshal([]byte(data), &chunk)
+		if err != nil {
+			log.Errorf("Failed to parse stream chunk, err: %v, data: %s", err, data)
+			return "", fmt.Errorf("parse stream chunk failed: %w", err)
+		}
+		if chunk.Error != nil {
+			log.Errorf("Stream failed, code: %s, message: %s", chunk.Error.Code, chunk.Error.Message)
+			return "", fmt.Errorf("stream failed, code: %s, message: %s", chunk.Error.Code, chunk.Error.Message)
+		}
+		for _, choice := range chunk.Choices {
+			if choice.Delta.Content == "" {
+				continue
+			}
+			content.WriteString(choice.Delta.Content)
+			err = fn(choice.Delta.Content)
+			if err != nil {
+				return "", err
+			}
+		}
+	}
+	if err := scanner.Err(); err != nil {
+		log.Errorf("Failed to read stream, err: %v", err)
+		return "", fmt.Errorf("read stream failed: %w", err)
+	}
+	// 部分实现不发送 [DONE]，连接正常结束即视为完成
+	return content.String(), nil
+}
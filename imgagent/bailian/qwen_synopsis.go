@@ -0,0 +1,84 @@
+package bailian
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"regexp"
+	"strings"
+
+	"imgagent/pkg/logger"
+)
+
+// SummarizeDocument 把按顺序排列的各部分摘要归纳为全书梗概和关键情节，梗概为空时返回错误
+func (c *Client) SummarizeDocument(ctx context.Context, name string, summaries []string) (DocumentSynopsis, error) {
+	log := logger.FromContext(ctx)
+	log.Infof("Summarizing document, name: %s, summaries: %d", name, len(summaries))
+
+	var b strings.Builder
+	for i, summary := range summaries {
+		fmt.Fprintf(&b, "%d. %s\n", i+1, summary)
+	}
+	req := ChatCompletionRequest{
+		Model: c.Models().ChatModel,
+		Messages: []Message{
+			{Role: "system", Content: "You are a helpful assistant."},
+			{Role: "user", Content: fmt.Sprintf(documentSynopsisPrompt, name, b.String())},
+		},
+		Stream: false,
+	}
+
+	respBody, err := c.callChatCompletion(ctx, req)
+	if err != nil {
+		return DocumentSynopsis{}, err
+	}
+	var chatResp ChatCompletionResponse
+	err = json.Unmarshal(respBody, &chatResp)
+	if err != nil {
+		log.Errorf("Failed to parse chat response, err: %v, body: %s", err, string(respBody))
+		return DocumentSynopsis{}, fmt.Errorf("parse chat response failed: %w", err)
+	}
+	if len(chatResp.Choices) == 0 {
+		log.Warnf("No choices in response, body: %s", string(respBody))
+		return DocumentSynopsis{}, fmt.Errorf("no choices in response")
+	}
+
+	synopsis, err := extractSynopsisFromJSON(chatResp.Choices[0].Message.Content)
+	if err != nil {
+		log.Errorf("Failed to extract synopsis from JSON, err: %v", err)
+		return DocumentSynopsis{}, fmt.Errorf("extract synopsis from JSON failed: %w", err)
+	}
+	synopsis.Synopsis = strings.TrimSpace(synopsis.Synopsis)
+	if synopsis.Synopsis == "" {
+		return DocumentSynopsis{}, fmt.Errorf("empty synopsis")
+	}
+	points := synopsis.PlotPoints[:0]
+	for _, p := range synopsis.PlotPoints {
+		if p = strings.TrimSpace(p); p != "" {
+			points = append(points, p)
+		}
+	}
+	synopsis.PlotPoints = points
+	log.Infof("Summarized document, name: %s, length: %d, plot points: %d", name, len(synopsis.Synopsis), len(points))
+	return synopsis, nil
+}
+
+// extractSynopsisFromJSON 从 JSON 字符串中提取梗概，内容可能包含在代码块或其他文字中
+func extractSynopsisFromJSON(content string) (DocumentSynopsis, error) {
+	var synopsis DocumentSynopsis
+	err := json.Unmarshal([]byte(content), &synopsis)
+	if err == nil {
+		return synopsis, nil
+	}
+
+	jsonPattern := regexp.MustCompile(`\{[\s\S]*\}`)
+	match := jsonPattern.FindString(content)
+	if match == "" {
+		return DocumentSynopsis{}, fmt.Errorf("no JSON object in content")
+	}
+	err = json.Unmarshal([]byte(match), &synopsis)
+	if err != nil {
+		return DocumentSynopsis{}, err
+	}
+	return synopsis, nil
+}
@@ -0,0 +1,74 @@
+package bailian
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"regexp"
+	"strings"
+
+	"imgagent/pkg/logger"
+)
+
+// TranslateChapter 将章节标题和内容翻译为 language（如“英语”），译文内容为空时返回错误
+func (c *Client) TranslateChapter(ctx context.Context, title, content, language string) (TranslatedChapter, error) {
+	log := logger.FromContext(ctx)
+	log.Infof("Translating chapter, language: %s, content length: %d", language, len(content))
+
+	req := ChatCompletionRequest{
+		Model: c.Models().ChatModel,
+		Messages: []Message{
+			{Role: "system", Content: "You are a helpful assistant."},
+			{Role: "user", Content: fmt.Sprintf(translateChapterPrompt, language, title, content)},
+		},
+		Stream: false,
+	}
+
+	respBody, err := c.callChatCompletion(ctx, req)
+	if err != nil {
+		return TranslatedChapter{}, err
+	}
+	var chatResp ChatCompletionResponse
+	err = json.Unmarshal(respBody, &chatResp)
+	if err != nil {
+		log.Errorf("Failed to parse chat response, err: %v, body: %s", err, string(respBody))
+		return TranslatedChapter{}, fmt.Errorf("parse chat response failed: %w", err)
+	}
+	if len(chatResp.Choices) == 0 {
+		log.Warnf("No choices in response, body: %s", string(respBody))
+		return TranslatedChapter{}, fmt.Errorf("no choices in response")
+	}
+
+	translated, err := extractTranslationFromJSON(chatResp.Choices[0].Message.Content)
+	if err != nil {
+		log.Errorf("Failed to extract translation from JSON, err: %v", err)
+		return TranslatedChapter{}, fmt.Errorf("extract translation from JSON failed: %w", err)
+	}
+	translated.Title = strings.TrimSpace(translated.Title)
+	translated.Content = strings.TrimSpace(translated.Content)
+	if translated.Content == "" && strings.TrimSpace(content) != "" {
+		return TranslatedChapter{}, fmt.Errorf("empty translation")
+	}
+	log.Infof("Translated chapter, language: %s, length: %d", language, len(translated.Content))
+	return translated, nil
+}
+
+// extractTranslationFromJSON 从 JSON 字符串中提取译文，内容可能包含在代码块或其他文字中
+func extractTranslationFromJSON(content string) (TranslatedChapter, error) {
+	var translated TranslatedChapter
+	err := json.Unmarshal([]byte(content), &translated)
+	if err == nil {
+		return translated, nil
+	}
+
+	jsonPattern := regexp.MustCompile(`\{[\s\S]*\}`)
+	match := jsonPattern.FindString(content)
+	if match == "" {
+		return TranslatedChapter{}, fmt.Errorf("no JSON object in content")
+	}
+	err = json.Unmarshal([]byte(match), &translated)
+	if err != nil {
+		return TranslatedChapter{}, err
+	}
+	return translated, nil
+}
@@ -3,26 +3,44 @@ package bailian
 import (
 	"bytes"
 	"context"
+	"encoding/binary"
 	"encoding/json"
 	"fmt"
 	"io"
 	"net/http"
+	"strconv"
+	"strings"
+	"time"
 
 	"imgagent/pkg/logger"
 )
 
-func (c *Client) GenerateTTS(ctx context.Context, text string) (string, error) {
+// GenerateTTS 生成语音，opts 中的音色和模型覆盖默认值
+func (c *Client) GenerateTTS(ctx context.Context, text string, opts GenerateOptions) (string, error) {
 	log := logger.FromContext(ctx)
 	log.Infof("Generating TTS for text, length: %d", len(text))
 
+	model, voice := c.Models().TTSModel, defaultVoice
+	if opts.TTSModel != "" {
+		model = opts.TTSModel
+	}
+	if opts.Voice != "" {
+		voice = opts.Voice
+	}
 	req := TTSRequest{
-		Model: "qwen3-tts-flash",
+		Model: model,
 		Input: TTSInput{
 			Text:         text,
-			Voice:        "Cherry",
+			Voice:        voice,
 			LanguageType: "Chinese",
 		},
 	}
+	if opts.Emotion != "" {
+		req.Input.Instructions = "情感和朗读风格：" + opts.Emotion
+	}
+	if opts.Speed != 0 || opts.Pitch != 0 {
+		req.Parameters = &TTSParameters{Rate: opts.Speed, Pitch: opts.Pitch}
+	}
 
 	reqBody, err := json.Marshal(req)
 	if err != nil {
@@ -40,7 +58,7 @@ func (c *Client) GenerateTTS(ctx context.Context, text string) (string, error) {
 	httpReq.Header.Set("Authorization", fmt.Sprintf("Bearer %s", c.config.APIKey))
 	httpReq.Header.Set("Content-Type", "application/json")
 
-	resp, err := c.httpClient.Do(httpReq)
+	resp, err := c.do(httpReq)
 	if err != nil {
 		log.Errorf("Failed to send request, err: %v", err)
 		return "", fmt.Errorf("send request failed: %w", err)
@@ -73,3 +91,86 @@ func (c *Client) GenerateTTS(ctx context.Context, text string) (string, error) {
 	log.Infof("TTS generated successfully, URL: %s", ttsResp.Output.Audio.URL)
 	return ttsResp.Output.Audio.URL, nil
 }
+
+// AudioDuration 获取 TTS 生成的 WAV 音频时长
+// 只请求文件头部，根据 fmt 块中的字节率和文件总大小计算时长
+func (c *Client) AudioDuration(ctx context.Context, audioURL string) (time.Duration, error) {
+	log := logger.FromContext(ctx)
+
+	httpReq, err := http.NewRequestWithContext(ctx, "GET", audioURL, nil)
+	if err != nil {
+		log.Errorf("Failed to create request, err: %v", err)
+		return 0, fmt.Errorf("create request failed: %w", err)
+	}
+	httpReq.Header.Set("Range", fmt.Sprintf("bytes=0-%d", wavHeaderProbeSize-1))
+
+	resp, err := c.do(httpReq)
+	if err != nil {
+		log.Errorf("Failed to send request, err: %v", err)
+		return 0, fmt.Errorf("send request failed: %w", err)
+	}
+	defer resp.Body.Close()
+
+	var total int64
+	switch resp.StatusCode {
+	case http.StatusPartialContent:
+		// Content-Range: bytes 0-1023/123456
+		_, size, ok := strings.Cut(resp.Header.Get("Content-Range"), "/")
+		if ok {
+			total, _ = strconv.ParseInt(size, 10, 64)
+		}
+	case http.StatusOK:
+		total = resp.ContentLength
+	default:
+		return 0, fmt.Errorf("get audio failed, status: %d", resp.StatusCode)
+	}
+	if total <= 0 {
+		return 0, fmt.Errorf("unknown audio size")
+	}
+
+	header, err := io.ReadAll(io.LimitReader(resp.Body, wavHeaderProbeSize))
+	if err != nil {
+		log.Errorf("Failed to read audio header, err: %v", err)
+		return 0, fmt.Errorf("read audio header failed: %w", err)
+	}
+	byteRate, dataOffset, err := parseWAVHeader(header)
+	if err != nil {
+		return 0, err
+	}
+
+	d := time.Duration(float64(total-dataOffset) / float64(byteRate) * float64(time.Second))
+	log.Infof("Audio duration, url: %s, size: %d, duration: %v", audioURL, total, d)
+	return d, nil
+}
+
+// wavHeaderProbeSize 解析 WAV 文件头时读取的字节数
+const wavHeaderProbeSize = 1024
+
+// parseWAVHeader 解析 WAV 文件头，返回字节率和 data 块的起始偏移
+func parseWAVHeader(header []byte) (int64, int64, error) {
+	if len(header) < 12 || string(header[0:4]) != "RIFF" || string(header[8:12]) != "WAVE" {
+		return 0, 0, fmt.Errorf("not a wav file")
+	}
+
+	var byteRate int64
+	offset := 12
+	for offset+8 <= len(header) {
+		id := string(header[offset : offset+4])
+		size := int(binary.LittleEndian.Uint32(header[offset+4 : offset+8]))
+		body := offset + 8
+		switch id {
+		case "fmt ":
+			if body+12 > len(header) {
+				return 0, 0, fmt.Errorf("invalid wav fmt chunk")
+			}
+			byteRate = int64(binary.LittleEndian.Uint32(header[body+8 : body+12]))
+		case "data":
+			if byteRate == 0 {
+				return 0, 0, fmt.Errorf("wav fmt chunk not found")
+			}
+			return byteRate, int64(body), nil
+		}
+		offset = body + size + size%2
+	}
+	return 0, 0, fmt.Errorf("wav data chunk not found")
+}
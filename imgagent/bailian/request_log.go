@@ -0,0 +1,88 @@
+package bailian
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"io"
+	"mime"
+	"net/http"
+	"time"
+)
+
+// maxLoggedBody 解析请求 id 时最多读取的 JSON 响应大小
+const maxLoggedBody = 1 << 20
+
+// RequestLog 一次百炼接口调用的结果，用于按文档排查问题
+type RequestLog struct {
+	API        string // 接口路径
+	RequestID  string // 百炼返回的请求 id，提交工单时提供给百炼
+	StatusCode int    // HTTP 状态码，请求未完成时为 0
+	Duration   time.Duration
+	Err        error // 请求未完成时的错误
+}
+
+type requestLogKey struct{}
+
+// WithRequestLog ctx 上发出的百炼请求完成后调用 fn
+func WithRequestLog(ctx context.Context, fn func(RequestLog)) context.Context {
+	return context.WithValue(ctx, requestLogKey{}, fn)
+}
+
+// requestLogTransport 从响应头 X-Request-Id 或 JSON 响应体的 request_id / id 字段中获取请求 id
+type requestLogTransport struct {
+	base http.RoundTripper
+}
+
+func newRequestLogTransport(base http.RoundTripper) http.RoundTripper {
+	return &requestLogTransport{base: base}
+}
+
+func (t *requestLogTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	fn, _ := req.Context().Value(requestLogKey{}).(func(RequestLog))
+	if fn == nil {
+		return t.base.RoundTrip(req)
+	}
+
+	start := time.Now()
+	resp, err := t.base.RoundTrip(req)
+	l := RequestLog{API: req.URL.Path, Err: err}
+	if resp != nil {
+		l.StatusCode = resp.StatusCode
+		l.RequestID = resp.Header.Get("X-Request-Id")
+		if l.RequestID == "" {
+			l.RequestID = bodyRequestID(resp)
+		}
+	}
+	l.Duration = time.Since(start)
+	fn(l)
+	return resp, err
+}
+
+// bodyRequestID 读取 JSON 响应体中的请求 id，读取后恢复响应体供调用方解析
+func bodyRequestID(resp *http.Response) string {
+	mediaType, _, _ := mime.ParseMediaType(resp.Header.Get("Content-Type"))
+	if mediaType != "application/json" || resp.ContentLength > maxLoggedBody {
+		return ""
+	}
+	body, err := io.ReadAll(io.LimitReader(resp.Body, maxLoggedBody+1))
+	resp.Body = struct {
+		io.Reader
+		io.Closer
+	}{io.MultiReader(bytes.NewReader(body), resp.Body), resp.Body}
+	if err != nil || len(body) > maxLoggedBody {
+		return ""
+	}
+
+	var ids struct {
+		RequestID string `json:"request_id"`
+		ID        string `json:"id"`
+	}
+	if json.Unmarshal(body, &ids) != nil {
+		return ""
+	}
+	if ids.RequestID != "" {
+		return ids.RequestID
+	}
+	return ids.ID
+}
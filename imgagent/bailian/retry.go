@@ -0,0 +1,175 @@
+package bailian
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"math/rand/v2"
+	"net/http"
+	"strconv"
+	"sync"
+	"time"
+
+	"imgagent/pkg/logger"
+)
+
+// maxRetryWait 两次重试之间的最长等待时间，Retry-After 超出时也按此等待
+const maxRetryWait = time.Minute
+
+// do 发送请求：先按客户端限流等待，每次尝试使用独立的超时，429 和 5xx 响应或网络错误时按退避时间重试，
+// 响应带 Retry-After 时按其等待。返回的响应体关闭时释放该次尝试的超时 ctx
+func (c *Client) do(req *http.Request) (*http.Response, error) {
+	ctx := req.Context()
+	log := logger.FromContext(ctx)
+	for attempt := 0; ; attempt++ {
+		err := c.limiter.Wait(ctx)
+		if err != nil {
+			return nil, err
+		}
+
+		attemptReq := req
+		if attempt > 0 {
+			attemptReq, err = rewindRequest(req)
+			if err != nil {
+				return nil, err
+			}
+		}
+		attemptCtx, cancel := context.WithTimeout(ctx, time.Duration(c.config.RequestTimeout)*time.Second)
+		resp, err := c.httpClient.Do(attemptReq.WithContext(attemptCtx))
+		if err == nil && !retryableStatus(resp.StatusCode) {
+			resp.Body = &cancelBody{ReadCloser: resp.Body, cancel: cancel}
+			return resp, nil
+		}
+
+		retryAfter := time.Duration(0)
+		if err == nil {
+			retryAfter = parseRetryAfter(resp.Header.Get("Retry-After"), time.Now())
+		}
+		if attempt >= c.config.MaxRetries || ctx.Err() != nil || (req.Body != nil && req.GetBody == nil) {
+			if err != nil {
+				cancel()
+				return nil, err
+			}
+			resp.Body = &cancelBody{ReadCloser: resp.Body, cancel: cancel}
+			return resp, nil
+		}
+
+		wait := c.retryWait(attempt, retryAfter)
+		if err != nil {
+			log.Warnf("Bailian request failed, api: %s, attempt: %d, retry after: %v, err: %v", req.URL.Path, attempt+1, wait, err)
+		} else {
+			log.Warnf("Bailian request failed, api: %s, attempt: %d, status: %d, retry after: %v", req.URL.Path, attempt+1, resp.StatusCode, wait)
+			io.Copy(io.Discard, io.LimitReader(resp.Body, 4096))
+			resp.Body.Close()
+		}
+		cancel()
+
+		timer := time.NewTimer(wait)
+		select {
+		case <-ctx.Done():
+			timer.Stop()
+			return nil, ctx.Err()
+		case <-timer.C:
+		}
+	}
+}
+
+// retryWait 第 attempt 次重试前的等待时间：指数退避加随机抖动，响应指定了 Retry-After 时以其为准
+func (c *Client) retryWait(attempt int, retryAfter time.Duration) time.Duration {
+	wait := retryAfter
+	if wait <= 0 {
+		base := time.Duration(c.config.RetryBackoffMs) * time.Millisecond << attempt
+		wait = base/2 + rand.N(base/2+1)
+	}
+	return min(wait, maxRetryWait)
+}
+
+func retryableStatus(code int) bool {
+	return code == http.StatusTooManyRequests || code >= http.StatusInternalServerError
+}
+
+// rewindRequest 复制请求并重新获取请求体，用于重试
+func rewindRequest(req *http.Request) (*http.Request, error) {
+	r := req.Clone(req.Context())
+	if req.GetBody != nil {
+		body, err := req.GetBody()
+		if err != nil {
+			return nil, fmt.Errorf("rewind request body: %w", err)
+		}
+		r.Body = body
+	}
+	return r, nil
+}
+
+// parseRetryAfter 解析秒数或 HTTP 日期格式的 Retry-After，无法解析时返回 0
+func parseRetryAfter(value string, now time.Time) time.Duration {
+	if value == "" {
+		return 0
+	}
+	if secs, err := strconv.Atoi(value); err == nil {
+		return time.Duration(max(secs, 0)) * time.Second
+	}
+	if t, err := http.ParseTime(value); err == nil && t.After(now) {
+		return t.Sub(now)
+	}
+	return 0
+}
+
+type cancelBody struct {
+	io.ReadCloser
+	cancel context.CancelFunc
+}
+
+func (b *cancelBody) Close() error {
+	err := b.ReadCloser.Close()
+	b.cancel()
+	return err
+}
+
+// rateLimiter 令牌桶限流，每秒补充 rate 个令牌，最多积累 burst 个；rate 为 0 时不限流
+type rateLimiter struct {
+	rate  float64
+	burst float64
+
+	mu     sync.Mutex
+	tokens float64
+	last   time.Time
+}
+
+func newRateLimiter(rate float64, burst int) *rateLimiter {
+	if burst <= 0 {
+		burst = max(1, int(rate))
+	}
+	return &rateLimiter{rate: rate, burst: float64(burst), tokens: float64(burst), last: time.Now()}
+}
+
+// Wait 取得一个令牌，令牌不足时等待，ctx 结束时返回错误
+func (l *rateLimiter) Wait(ctx context.Context) error {
+	if l == nil || l.rate <= 0 {
+		return nil
+	}
+
+	l.mu.Lock()
+	now := time.Now()
+	l.tokens = min(l.burst, l.tokens+now.Sub(l.last).Seconds()*l.rate)
+	l.last = now
+	// 先扣除令牌再等待，并发请求依次排队
+	l.tokens--
+	wait := time.Duration(-l.tokens / l.rate * float64(time.Second))
+	l.mu.Unlock()
+	if wait <= 0 {
+		return nil
+	}
+
+	timer := time.NewTimer(wait)
+	defer timer.Stop()
+	select {
+	case <-ctx.Done():
+		l.mu.Lock()
+		l.tokens++
+		l.mu.Unlock()
+		return ctx.Err()
+	case <-timer.C:
+		return nil
+	}
+}
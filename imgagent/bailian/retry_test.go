@@ -0,0 +1,190 @@
+package bailian
+
+import (
+	"bytes"
+	"context"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// newRetryTestServer 依次返回 statuses 中的状态码，之后返回 200，记录每次收到的请求体
+func newRetryTestServer(t *testing.T, retryAfter string, statuses ...int) (*httptest.Server, *atomic.Int32, *[]string) {
+	var calls atomic.Int32
+	var bodies []string
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		body, err := io.ReadAll(r.Body)
+		require.NoError(t, err)
+		bodies = append(bodies, string(body))
+		n := int(calls.Add(1))
+		if n <= len(statuses) {
+			if retryAfter != "" {
+				w.Header().Set("Retry-After", retryAfter)
+			}
+			w.WriteHeader(statuses[n-1])
+			return
+		}
+		w.Write([]byte("ok"))
+	}))
+	t.Cleanup(server.Close)
+	return server, &calls, &bodies
+}
+
+func newRetryTestClient(t *testing.T, baseURL string, maxRetries int) *Client {
+	c, err := NewClient(Config{BaseURL: baseURL, APIKey: "test", MaxRetries: maxRetries, RetryBackoffMs: 1})
+	require.NoError(t, err)
+	return c
+}
+
+func TestDoRetry(t *testing.T) {
+	server, calls, bodies := newRetryTestServer(t, "0", http.StatusTooManyRequests, http.StatusServiceUnavailable)
+	c := newRetryTestClient(t, server.URL, 3)
+
+	// 重试时通过 GetBody 重新读取请求体
+	req, err := http.NewRequest(http.MethodPost, server.URL+"/api", bytes.NewReader([]byte("payload")))
+	require.NoError(t, err)
+	resp, err := c.do(req)
+	require.NoError(t, err)
+	defer resp.Body.Close()
+	assert.Equal(t, http.StatusOK, resp.StatusCode)
+	data, err := io.ReadAll(resp.Body)
+	require.NoError(t, err)
+	assert.Equal(t, "ok", string(data))
+	assert.Equal(t, int32(3), calls.Load())
+	assert.Equal(t, []string{"payload", "payload", "payload"}, *bodies)
+}
+
+func TestDoRetryExhausted(t *testing.T) {
+	server, calls, _ := newRetryTestServer(t, "", http.StatusServiceUnavailable, http.StatusServiceUnavailable, http.StatusServiceUnavailable)
+	c := newRetryTestClient(t, server.URL, 2)
+
+	// 重试次数用完后返回最后一次的响应
+	req, err := http.NewRequest(http.MethodGet, server.URL+"/api", nil)
+	require.NoError(t, err)
+	resp, err := c.do(req)
+	require.NoError(t, err)
+	resp.Body.Close()
+	assert.Equal(t, http.StatusServiceUnavailable, resp.StatusCode)
+	assert.Equal(t, int32(3), calls.Load())
+
+	// 4xx（429 除外）不重试
+	server, calls, _ = newRetryTestServer(t, "", http.StatusBadRequest)
+	req, err = http.NewRequest(http.MethodGet, server.URL+"/api", nil)
+	require.NoError(t, err)
+	resp, err = newRetryTestClient(t, server.URL, 2).do(req)
+	require.NoError(t, err)
+	resp.Body.Close()
+	assert.Equal(t, http.StatusBadRequest, resp.StatusCode)
+	assert.Equal(t, int32(1), calls.Load())
+}
+
+func TestDoRetryUnrewindableBody(t *testing.T) {
+	server, calls, _ := newRetryTestServer(t, "", http.StatusServiceUnavailable)
+	c := newRetryTestClient(t, server.URL, 3)
+
+	// 请求体不能重新读取时不重试
+	req, err := http.NewRequest(http.MethodPost, server.URL+"/api", io.MultiReader(strings.NewReader("payload")))
+	require.NoError(t, err)
+	require.Nil(t, req.GetBody)
+	resp, err := c.do(req)
+	require.NoError(t, err)
+	resp.Body.Close()
+	assert.Equal(t, http.StatusServiceUnavailable, resp.StatusCode)
+	assert.Equal(t, int32(1), calls.Load())
+}
+
+func TestDoRetryAfter(t *testing.T) {
+	server, calls, _ := newRetryTestServer(t, "1", http.StatusTooManyRequests)
+	c := newRetryTestClient(t, server.URL, 1)
+
+	// 按 Retry-After 等待后重试
+	req, err := http.NewRequest(http.MethodGet, server.URL+"/api", nil)
+	require.NoError(t, err)
+	start := time.Now()
+	resp, err := c.do(req)
+	require.NoError(t, err)
+	resp.Body.Close()
+	assert.Equal(t, http.StatusOK, resp.StatusCode)
+	assert.Equal(t, int32(2), calls.Load())
+	assert.GreaterOrEqual(t, time.Since(start), time.Second)
+
+	// 等待重试期间 ctx 结束时立即返回
+	server, calls, _ = newRetryTestServer(t, "30", http.StatusTooManyRequests)
+	ctx, cancel := context.WithTimeout(context.Background(), 50*time.Millisecond)
+	defer cancel()
+	req, err = http.NewRequestWithContext(ctx, http.MethodGet, server.URL+"/api", nil)
+	require.NoError(t, err)
+	start = time.Now()
+	_, err = newRetryTestClient(t, server.URL, 3).do(req)
+	assert.ErrorIs(t, err, context.DeadlineExceeded)
+	assert.Less(t, time.Since(start), 5*time.Second)
+	assert.Equal(t, int32(1), calls.Load())
+}
+
+func TestRetryWait(t *testing.T) {
+	c := &Client{config: Config{RetryBackoffMs: 100}}
+
+	// 指数退避加抖动，在 [base/2, base] 之间
+	for attempt, base := range []time.Duration{100 * time.Millisecond, 200 * time.Millisecond, 400 * time.Millisecond} {
+		wait := c.retryWait(attempt, 0)
+		assert.GreaterOrEqual(t, wait, base/2)
+		assert.LessOrEqual(t, wait, base)
+	}
+	assert.Equal(t, 3*time.Second, c.retryWait(0, 3*time.Second))
+	assert.Equal(t, maxRetryWait, c.retryWait(0, time.Hour))
+	assert.Equal(t, maxRetryWait, c.retryWait(20, 0))
+}
+
+func TestParseRetryAfter(t *testing.T) {
+	now := time.Date(2026, 1, 2, 3, 4, 5, 0, time.UTC)
+	for _, tc := range []struct {
+		value string
+		want  time.Duration
+	}{
+		{"", 0},
+		{"5", 5 * time.Second},
+		{"-1", 0},
+		{now.Add(10 * time.Second).Format(http.TimeFormat), 10 * time.Second},
+		{now.Add(-10 * time.Second).Format(http.TimeFormat), 0},
+		{"later", 0},
+	} {
+		assert.Equal(t, tc.want, parseRetryAfter(tc.value, now), tc.value)
+	}
+}
+
+func TestRateLimiter(t *testing.T) {
+	ctx := context.Background()
+
+	// 未开启限流
+	var nilLimiter *rateLimiter
+	require.NoError(t, nilLimiter.Wait(ctx))
+	require.NoError(t, newRateLimiter(0, 0).Wait(ctx))
+
+	// 突发请求用完令牌后按速率等待
+	l := newRateLimiter(20, 2)
+	start := time.Now()
+	for range 3 {
+		require.NoError(t, l.Wait(ctx))
+	}
+	assert.GreaterOrEqual(t, time.Since(start), 40*time.Millisecond)
+
+	// 等待期间 ctx 结束时返回错误并归还令牌
+	l = newRateLimiter(1, 1)
+	require.NoError(t, l.Wait(ctx))
+	ctx, cancel := context.WithTimeout(ctx, 20*time.Millisecond)
+	defer cancel()
+	start = time.Now()
+	assert.ErrorIs(t, l.Wait(ctx), context.DeadlineExceeded)
+	assert.Less(t, time.Since(start), 500*time.Millisecond)
+	l.mu.Lock()
+	tokens := l.tokens
+	l.mu.Unlock()
+	assert.Greater(t, tokens, -0.5)
+}
@@ -1,11 +1,56 @@
 package bailian
 
+// QASource 文档问答的参考片段，回答中以 [Index] 引用，Index 从 1 开始
+type QASource struct {
+	Index   int
+	Title   string
+	Content string
+}
+
+// QAAnswer 文档问答的回答，Citations 为回答引用的片段编号
+type QAAnswer struct {
+	Answer    string `json:"answer"`
+	Citations []int  `json:"citations"`
+}
+
+// TranslatedChapter 翻译后的章节标题和内容
+type TranslatedChapter struct {
+	Title   string `json:"title"`
+	Content string `json:"content"`
+}
+
+// DocumentSynopsis 由各部分摘要归纳出的全书梗概和关键情节
+type DocumentSynopsis struct {
+	Synopsis   string   `json:"synopsis"`
+	PlotPoints []string `json:"plot_points"`
+}
+
+// SceneInfo 场景描述及结构化属性，TimeOfDay 为 dawn|morning|noon|afternoon|evening|night，无法判断时为空；
+// CharactersPresent 为场景中出场的角色名字
+type SceneInfo struct {
+	Description       string   `json:"description"`
+	Location          string   `json:"location"`
+	TimeOfDay         string   `json:"time_of_day"`
+	Mood              string   `json:"mood"`
+	CharactersPresent []string `json:"characters_present"`
+}
+
+// RoleRelation 两个角色之间的关系，Source、Target 为角色名字，Type 描述 Source 相对 Target 的身份
+type RoleRelation struct {
+	Source      string `json:"source"`
+	Target      string `json:"target"`
+	Type        string `json:"type"`
+	Description string `json:"description"`
+}
+
 // RoleInfo 角色信息
 type RoleInfo struct {
 	Name       string `json:"name"`
 	Gender     string `json:"gender"`
 	Character  string `json:"character"`
 	Appearance string `json:"appearance"`
+	// PortraitURL 角色参考立绘，只在开启角色一致性时作为生成场景图片的参考图
+	PortraitURL string `json:"portrait_url,omitempty"`
 }
 
 // UploadFileResponse 文件上传响应
@@ -15,6 +60,23 @@ type UploadFileResponse struct {
 	CreatedAt int64  `json:"created_at"`
 }
 
+// FileInfo 百炼上的文件，Filename 为上传时的文件名
+type FileInfo struct {
+	ID        string `json:"id"`
+	Object    string `json:"object"`
+	Bytes     int64  `json:"bytes"`
+	CreatedAt int64  `json:"created_at"`
+	Filename  string `json:"filename"`
+	Purpose   string `json:"purpose"`
+}
+
+// ListFilesResponse 文件列表响应
+type ListFilesResponse struct {
+	Object  string     `json:"object"`
+	Data    []FileInfo `json:"data"`
+	HasMore bool       `json:"has_more"`
+}
+
 // ChatCompletionRequest qwen-long 请求
 type ChatCompletionRequest struct {
 	Model    string    `json:"model"`
@@ -45,6 +107,26 @@ type Choice struct {
 	FinishReason string  `json:"finish_reason"`
 }
 
+// ChatCompletionChunk 流式响应（stream 为 true）中的一个数据块，Choices 中的 Delta 为增量内容
+type ChatCompletionChunk struct {
+	ID      string        `json:"id"`
+	Choices []ChunkChoice `json:"choices"`
+	Error   *ChunkError   `json:"error,omitempty"`
+}
+
+// ChunkChoice 数据块中的增量内容
+type ChunkChoice struct {
+	Index        int     `json:"index"`
+	Delta        Message `json:"delta"`
+	FinishReason string  `json:"finish_reason"`
+}
+
+// ChunkError 流式响应中途返回的错误
+type ChunkError struct {
+	Code    string `json:"code"`
+	Message string `json:"message"`
+}
+
 // Usage 使用情况
 type Usage struct {
 	PromptTokens     int `json:"prompt_tokens"`
@@ -70,9 +152,10 @@ type ImageMessage struct {
 	Content []ImageContent `json:"content"`
 }
 
-// ImageContent 图片内容
+// ImageContent 图片内容，Image 为参考图 URL，与 Text 二选一
 type ImageContent struct {
-	Text string `json:"text"`
+	Image string `json:"image,omitempty"`
+	Text  string `json:"text,omitempty"`
 }
 
 // Parameters 参数
@@ -118,10 +201,31 @@ type ImageUsage struct {
 	ImageCount int `json:"image_count"`
 }
 
+// GenerateOptions 单次图片、语音生成的参数，空字段使用客户端配置或默认值
+type GenerateOptions struct {
+	Style      string // 画面风格，追加到图片提示词中
+	ImageSize  string // 图片分辨率，如 1328*1328
+	ImageModel string
+	Voice      string
+	TTSModel   string
+	// Speed、Pitch 语速和音调倍数，为 0 时使用默认值；Emotion 情感或朗读风格，作为朗读指令传入
+	Speed   float64
+	Pitch   float64
+	Emotion string
+	// RoleConsistency 只注入场景中出现的角色并要求外貌与设定严格一致，这些角色的参考立绘作为参考图，
+	// 有参考图且未指定 ImageModel 时使用图像编辑模型
+	RoleConsistency bool
+	// Prompt 自定义模板渲染出的完整图片提示词，为空时按场景描述、摘要和角色构建
+	Prompt string
+	// NegativePrompt 反向提示词，画面中需要避免的内容
+	NegativePrompt string
+}
+
 // TTSRequest TTS 生成请求
 type TTSRequest struct {
-	Model string   `json:"model"`
-	Input TTSInput `json:"input"`
+	Model      string         `json:"model"`
+	Input      TTSInput       `json:"input"`
+	Parameters *TTSParameters `json:"parameters,omitempty"`
 }
 
 // TTSInput TTS 输入
@@ -129,6 +233,13 @@ type TTSInput struct {
 	Text         string `json:"text"`
 	Voice        string `json:"voice"`
 	LanguageType string `json:"language_type"`
+	Instructions string `json:"instructions,omitempty"` // 情感和朗读风格指令
+}
+
+// TTSParameters TTS 语速和音调，为 0 时使用默认值
+type TTSParameters struct {
+	Rate  float64 `json:"rate,omitempty"`
+	Pitch float64 `json:"pitch,omitempty"`
 }
 
 // TTSResponse TTS 生成响应
@@ -162,3 +273,43 @@ type TTSUsage struct {
 	OutputTokens int `json:"output_tokens"`
 	Characters   int `json:"characters"`
 }
+
+// ImageEditRequest 通用图像编辑请求（异步任务）
+type ImageEditRequest struct {
+	Model      string              `json:"model"`
+	Input      ImageEditInput      `json:"input"`
+	Parameters ImageEditParameters `json:"parameters"`
+}
+
+// ImageEditInput 图像编辑输入，BaseImageURL 为图片 URL 或 data:image/png;base64 格式的数据
+type ImageEditInput struct {
+	Function     string `json:"function"`
+	Prompt       string `json:"prompt"`
+	BaseImageURL string `json:"base_image_url"`
+}
+
+// ImageEditParameters 图像编辑参数
+type ImageEditParameters struct {
+	UpscaleFactor int `json:"upscale_factor,omitempty"`
+	N             int `json:"n"`
+}
+
+// TaskResponse 异步任务的提交和查询响应
+type TaskResponse struct {
+	RequestID string     `json:"request_id"`
+	Output    TaskOutput `json:"output"`
+}
+
+// TaskOutput 异步任务状态，TaskStatus 为 PENDING|RUNNING|SUCCEEDED|FAILED|CANCELED|UNKNOWN
+type TaskOutput struct {
+	TaskID     string       `json:"task_id"`
+	TaskStatus string       `json:"task_status"`
+	Results    []TaskResult `json:"results"`
+	Code       string       `json:"code"`
+	Message    string       `json:"message"`
+}
+
+// TaskResult 异步任务的产出
+type TaskResult struct {
+	URL string `json:"url"`
+}
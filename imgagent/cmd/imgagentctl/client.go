@@ -0,0 +1,239 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"mime/multipart"
+	"net/http"
+	"net/url"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+
+	"imgagent/api"
+)
+
+// client 调用 imgagent http api，server 为带版本的地址，如 http://localhost:8000/v1
+type client struct {
+	server string
+	token  string
+	hc     *http.Client
+}
+
+func newClient(server, token string) *client {
+	return &client{server: strings.TrimRight(server, "/"), token: token, hc: http.DefaultClient}
+}
+
+// response 公共 body，data 按接口解析
+type response struct {
+	Code    int             `json:"code"`
+	Message string          `json:"message"`
+	Reqid   string          `json:"reqid"`
+	Data    json.RawMessage `json:"data"`
+}
+
+// apiError 业务处理失败
+type apiError struct {
+	Code    int
+	Message string
+	Reqid   string
+}
+
+func (e *apiError) Error() string {
+	return fmt.Sprintf("code: %d, message: %s, reqid: %s", e.Code, e.Message, e.Reqid)
+}
+
+func (c *client) newRequest(ctx context.Context, method, path string, body io.Reader) (*http.Request, error) {
+	req, err := http.NewRequestWithContext(ctx, method, c.server+path, body)
+	if err != nil {
+		return nil, err
+	}
+	if c.token != "" {
+		req.Header.Set("Authorization", "Bearer "+c.token)
+	}
+	return req, nil
+}
+
+// do 发送请求并把成功响应的 data 解析到 out，out 为 nil 时忽略 data
+func (c *client) do(req *http.Request, out any) error {
+	resp, err := c.hc.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	var r response
+	if err := json.NewDecoder(resp.Body).Decode(&r); err != nil {
+		return fmt.Errorf("%s %s: http status %d, decode body: %w", req.Method, req.URL.Path, resp.StatusCode, err)
+	}
+	if r.Code != http.StatusOK && r.Code != http.StatusAccepted {
+		return &apiError{Code: r.Code, Message: r.Message, Reqid: r.Reqid}
+	}
+	if out == nil || len(r.Data) == 0 {
+		return nil
+	}
+	return json.Unmarshal(r.Data, out)
+}
+
+func (c *client) doJSON(ctx context.Context, method, path string, in, out any) error {
+	var body io.Reader
+	if in != nil {
+		b, err := json.Marshal(in)
+		if err != nil {
+			return err
+		}
+		body = bytes.NewReader(b)
+	}
+	req, err := c.newRequest(ctx, method, path, body)
+	if err != nil {
+		return err
+	}
+	if in != nil {
+		req.Header.Set("Content-Type", "application/json")
+	}
+	return c.do(req, out)
+}
+
+// uploadOptions POST /documents 中文件以外的表单字段
+type uploadOptions struct {
+	Priority      string
+	AutoRename    bool
+	LinkDuplicate bool
+}
+
+// CreateDocument 上传文件创建文档，multipart 以管道写入，不把文件整个读入内存
+func (c *client) CreateDocument(ctx context.Context, name, filename string, opts uploadOptions) (*api.Document, error) {
+	f, err := os.Open(filename)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	pr, pw := io.Pipe()
+	mw := multipart.NewWriter(pw)
+	go func() {
+		pw.CloseWithError(writeUploadForm(mw, name, filename, f, opts))
+	}()
+	defer pr.Close()
+
+	req, err := c.newRequest(ctx, http.MethodPost, "/documents", pr)
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("Content-Type", mw.FormDataContentType())
+	var doc api.Document
+	if err := c.do(req, &doc); err != nil {
+		return nil, err
+	}
+	return &doc, nil
+}
+
+func writeUploadForm(mw *multipart.Writer, name, filename string, r io.Reader, opts uploadOptions) error {
+	fields := [][2]string{{"name", name}}
+	if opts.Priority != "" {
+		fields = append(fields, [2]string{"priority", opts.Priority})
+	}
+	if opts.AutoRename {
+		fields = append(fields, [2]string{"auto_rename", "true"})
+	}
+	if opts.LinkDuplicate {
+		fields = append(fields, [2]string{"link_duplicate", "true"})
+	}
+	for _, field := range fields {
+		if err := mw.WriteField(field[0], field[1]); err != nil {
+			return err
+		}
+	}
+	part, err := mw.CreateFormFile("file", filepath.Base(filename))
+	if err != nil {
+		return err
+	}
+	if _, err := io.Copy(part, r); err != nil {
+		return err
+	}
+	return mw.Close()
+}
+
+func (c *client) GetProgress(ctx context.Context, documentID string) (*api.ProcessingProgress, error) {
+	var progress api.ProcessingProgress
+	err := c.doJSON(ctx, http.MethodGet, "/documents/"+url.PathEscape(documentID)+"/progress", nil, &progress)
+	if err != nil {
+		return nil, err
+	}
+	return &progress, nil
+}
+
+func (c *client) CreateExport(ctx context.Context, documentID, format string) (*api.Export, error) {
+	var e api.Export
+	err := c.doJSON(ctx, http.MethodPost, "/documents/"+url.PathEscape(documentID)+"/exports", api.CreateExportArgs{Format: format}, &e)
+	if err != nil {
+		return nil, err
+	}
+	return &e, nil
+}
+
+func (c *client) ListExports(ctx context.Context, documentID string) ([]api.Export, error) {
+	var result api.ListExportsResult
+	err := c.doJSON(ctx, http.MethodGet, "/documents/"+url.PathEscape(documentID)+"/exports", nil, &result)
+	if err != nil {
+		return nil, err
+	}
+	return result.Exports, nil
+}
+
+// DownloadExport 把导出产物写入 w，成功时响应为文件本身而不是公共 body
+func (c *client) DownloadExport(ctx context.Context, exportID string, w io.Writer) error {
+	req, err := c.newRequest(ctx, http.MethodGet, "/exports/"+url.PathEscape(exportID)+"/download", nil)
+	if err != nil {
+		return err
+	}
+	resp, err := c.hc.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	if strings.HasPrefix(resp.Header.Get("Content-Type"), "application/json") {
+		var r response
+		if err := json.NewDecoder(resp.Body).Decode(&r); err != nil {
+			return fmt.Errorf("download export: http status %d, decode body: %w", resp.StatusCode, err)
+		}
+		return &apiError{Code: r.Code, Message: r.Message, Reqid: r.Reqid}
+	}
+	if resp.StatusCode != http.StatusOK {
+		return fmt.Errorf("download export: http status %d", resp.StatusCode)
+	}
+	_, err = io.Copy(w, resp.Body)
+	return err
+}
+
+// ListFailedJobs marker 为空时从头列取
+func (c *client) ListFailedJobs(ctx context.Context, jobType, marker string, limit int) (*api.ListFailedJobsResult, error) {
+	query := url.Values{}
+	query.Set("type", jobType)
+	if marker != "" {
+		query.Set("marker", marker)
+	}
+	if limit > 0 {
+		query.Set("limit", strconv.Itoa(limit))
+	}
+	var result api.ListFailedJobsResult
+	err := c.doJSON(ctx, http.MethodGet, "/admin/failed-jobs?"+query.Encode(), nil, &result)
+	if err != nil {
+		return nil, err
+	}
+	return &result, nil
+}
+
+func (c *client) RequeueDocument(ctx context.Context, documentID string) (*api.Document, error) {
+	var doc api.Document
+	err := c.doJSON(ctx, http.MethodPost, "/admin/failed-jobs/documents/"+url.PathEscape(documentID)+"/requeue", nil, &doc)
+	if err != nil {
+		return nil, err
+	}
+	return &doc, nil
+}
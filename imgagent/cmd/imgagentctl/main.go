@@ -0,0 +1,343 @@
+// imgagentctl 面向运维的命令行工具，通过 http api 批量上传原文、查看处理进度、导出结果和重新排队失败的文档
+package main
+
+import (
+	"context"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"os/signal"
+	"path/filepath"
+	"sort"
+	"strings"
+	"syscall"
+	"time"
+
+	"imgagent/api"
+)
+
+const usage = `usage: imgagentctl [-server url] [-token token] <command> [arguments]
+
+commands:
+  upload   [-priority p] [-auto-rename] [-link-duplicate] <dir>   upload every file in dir as a document
+  watch    [-interval d] <document_id>...                          poll processing progress until finished
+  export   [-format zip] [-o dir] [-interval d] <document_id>...   export documents and download the artifacts
+  requeue  [-all] [document_id...]                                 requeue failed documents
+`
+
+// finishedStatuses 文档处理结束的状态，与 db.DocumentStatus* 一致
+var finishedStatuses = map[string]bool{
+	"imgReady":            true,
+	"completedWithErrors": true,
+	"failed":              true,
+	"canceled":            true,
+}
+
+func main() {
+	fs := flag.NewFlagSet("imgagentctl", flag.ExitOnError)
+	fs.Usage = func() {
+		fmt.Fprint(os.Stderr, usage)
+		fs.PrintDefaults()
+	}
+	server := fs.String("server", envOr("IMGAGENT_SERVER", "http://localhost:8000/v1"), "imgagent api address with version, env IMGAGENT_SERVER")
+	token := fs.String("token", os.Getenv("IMGAGENT_TOKEN"), "bearer token, env IMGAGENT_TOKEN")
+	_ = fs.Parse(os.Args[1:])
+	if fs.NArg() == 0 {
+		fs.Usage()
+		os.Exit(2)
+	}
+
+	ctx, stop := signal.NotifyContext(context.Background(), syscall.SIGINT, syscall.SIGTERM)
+	defer stop()
+
+	c := newClient(*server, *token)
+	cmd, args := fs.Arg(0), fs.Args()[1:]
+	var err error
+	switch cmd {
+	case "upload":
+		err = runUpload(ctx, c, args, os.Stdout)
+	case "watch":
+		err = runWatch(ctx, c, args, os.Stdout)
+	case "export":
+		err = runExport(ctx, c, args, os.Stdout)
+	case "requeue":
+		err = runRequeue(ctx, c, args, os.Stdout)
+	default:
+		fmt.Fprintf(os.Stderr, "unknown command %q\n", cmd)
+		fs.Usage()
+		os.Exit(2)
+	}
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "imgagentctl %s: %v\n", cmd, err)
+		os.Exit(1)
+	}
+}
+
+func envOr(key, def string) string {
+	if v := os.Getenv(key); v != "" {
+		return v
+	}
+	return def
+}
+
+// runUpload 上传目录下的全部文件（不递归），文档名为去掉扩展名的文件名。
+// 单个文件失败不影响其他文件，最后有失败时返回错误
+func runUpload(ctx context.Context, c *client, args []string, out io.Writer) error {
+	fs := flag.NewFlagSet("upload", flag.ExitOnError)
+	var opts uploadOptions
+	fs.StringVar(&opts.Priority, "priority", "", "processing priority high|normal|low")
+	fs.BoolVar(&opts.AutoRename, "auto-rename", false, "rename the document instead of failing when the name exists")
+	fs.BoolVar(&opts.LinkDuplicate, "link-duplicate", false, "return the existing document when the content was uploaded before")
+	_ = fs.Parse(args)
+	if fs.NArg() != 1 {
+		return errors.New("exactly one directory is required")
+	}
+
+	dir := fs.Arg(0)
+	entries, err := os.ReadDir(dir)
+	if err != nil {
+		return err
+	}
+	failed := 0
+	for _, entry := range entries {
+		if entry.IsDir() || strings.HasPrefix(entry.Name(), ".") {
+			continue
+		}
+		if ctx.Err() != nil {
+			return ctx.Err()
+		}
+		name := strings.TrimSuffix(entry.Name(), filepath.Ext(entry.Name()))
+		doc, err := c.CreateDocument(ctx, name, filepath.Join(dir, entry.Name()), opts)
+		if err != nil {
+			failed++
+			fmt.Fprintf(out, "FAIL\t%s\t%v\n", entry.Name(), err)
+			continue
+		}
+		fmt.Fprintf(out, "OK\t%s\t%s\t%s\n", entry.Name(), doc.ID, doc.Name)
+	}
+	if failed > 0 {
+		return fmt.Errorf("%d files failed to upload", failed)
+	}
+	return nil
+}
+
+// runWatch 轮询文档进度直到全部处理结束，有文档失败时返回错误
+func runWatch(ctx context.Context, c *client, args []string, out io.Writer) error {
+	fs := flag.NewFlagSet("watch", flag.ExitOnError)
+	interval := fs.Duration("interval", 5*time.Second, "poll interval")
+	_ = fs.Parse(args)
+	if fs.NArg() == 0 {
+		return errors.New("document id is required")
+	}
+
+	pending := make(map[string]bool, fs.NArg())
+	for _, id := range fs.Args() {
+		pending[id] = true
+	}
+	var failed []string
+	for {
+		for _, id := range sortedKeys(pending) {
+			progress, err := c.GetProgress(ctx, id)
+			if err != nil {
+				return fmt.Errorf("get progress of %s: %w", id, err)
+			}
+			fmt.Fprintln(out, formatProgress(progress))
+			if finishedStatuses[progress.Status] {
+				delete(pending, id)
+				if progress.Status == "failed" || progress.Status == "canceled" {
+					failed = append(failed, id)
+				}
+			}
+		}
+		if len(pending) == 0 {
+			break
+		}
+		if err := sleep(ctx, *interval); err != nil {
+			return err
+		}
+	}
+	if len(failed) > 0 {
+		return fmt.Errorf("documents not completed: %s", strings.Join(failed, ","))
+	}
+	return nil
+}
+
+func formatProgress(p *api.ProcessingProgress) string {
+	var b strings.Builder
+	fmt.Fprintf(&b, "%s\t%s\t%d%%", p.DocumentID, p.Status, p.Percent)
+	if p.Stage != "" {
+		fmt.Fprintf(&b, "\tstage=%s", p.Stage)
+		for _, stage := range p.Stages {
+			if stage.Stage == p.Stage && stage.Total > 0 {
+				fmt.Fprintf(&b, " %d/%d", stage.Done, stage.Total)
+			}
+		}
+	}
+	if p.Paused {
+		b.WriteString("\tpaused")
+	}
+	if p.LastError != "" {
+		fmt.Fprintf(&b, "\terror=%s", p.LastError)
+	}
+	return b.String()
+}
+
+// runExport 为每个文档创建导出任务，完成后下载到 -o 目录，文件名为 <document_id>.<format>
+func runExport(ctx context.Context, c *client, args []string, out io.Writer) error {
+	fs := flag.NewFlagSet("export", flag.ExitOnError)
+	format := fs.String("format", "zip", "export format")
+	dir := fs.String("o", ".", "output directory")
+	interval := fs.Duration("interval", 2*time.Second, "poll interval")
+	_ = fs.Parse(args)
+	if fs.NArg() == 0 {
+		return errors.New("document id is required")
+	}
+	if err := os.MkdirAll(*dir, 0o755); err != nil {
+		return err
+	}
+
+	for _, id := range fs.Args() {
+		e, err := c.CreateExport(ctx, id, *format)
+		if err != nil {
+			return fmt.Errorf("create export of %s: %w", id, err)
+		}
+		e, err = waitExport(ctx, c, e, *interval)
+		if err != nil {
+			return fmt.Errorf("export %s: %w", id, err)
+		}
+		filename := filepath.Join(*dir, id+"."+e.Format)
+		if err := downloadExport(ctx, c, e.ID, filename); err != nil {
+			return fmt.Errorf("download export of %s: %w", id, err)
+		}
+		fmt.Fprintf(out, "%s\t%s\n", id, filename)
+	}
+	return nil
+}
+
+// waitExport 轮询导出任务直到成功，失败、取消或过期时返回错误
+func waitExport(ctx context.Context, c *client, e *api.Export, interval time.Duration) (*api.Export, error) {
+	for {
+		switch e.Status {
+		case "succeeded":
+			return e, nil
+		case "failed", "canceled", "expired":
+			return nil, fmt.Errorf("export %s %s: %s", e.ID, e.Status, e.Error)
+		}
+		if err := sleep(ctx, interval); err != nil {
+			return nil, err
+		}
+		exports, err := c.ListExports(ctx, e.DocumentID)
+		if err != nil {
+			return nil, err
+		}
+		found := false
+		for i := range exports {
+			if exports[i].ID == e.ID {
+				e, found = &exports[i], true
+				break
+			}
+		}
+		if !found {
+			return nil, fmt.Errorf("export %s not found", e.ID)
+		}
+	}
+}
+
+// downloadExport 先写入临时文件，成功后再改名，避免留下不完整的产物
+func downloadExport(ctx context.Context, c *client, exportID, filename string) error {
+	tmp := filename + ".part"
+	f, err := os.Create(tmp)
+	if err != nil {
+		return err
+	}
+	err = c.DownloadExport(ctx, exportID, f)
+	if cerr := f.Close(); err == nil {
+		err = cerr
+	}
+	if err != nil {
+		os.Remove(tmp)
+		return err
+	}
+	return os.Rename(tmp, filename)
+}
+
+// runRequeue 重新排队指定的失败文档，-all 时分页列取并重新排队全部失败文档
+func runRequeue(ctx context.Context, c *client, args []string, out io.Writer) error {
+	fs := flag.NewFlagSet("requeue", flag.ExitOnError)
+	all := fs.Bool("all", false, "requeue every failed document")
+	_ = fs.Parse(args)
+
+	ids := fs.Args()
+	if *all {
+		if len(ids) > 0 {
+			return errors.New("document ids can not be used with -all")
+		}
+		var err error
+		ids, err = listFailedDocuments(ctx, c)
+		if err != nil {
+			return err
+		}
+	}
+	if len(ids) == 0 {
+		if *all {
+			return nil
+		}
+		return errors.New("document id or -all is required")
+	}
+
+	failed := 0
+	for _, id := range ids {
+		doc, err := c.RequeueDocument(ctx, id)
+		if err != nil {
+			failed++
+			fmt.Fprintf(out, "FAIL\t%s\t%v\n", id, err)
+			continue
+		}
+		fmt.Fprintf(out, "OK\t%s\t%s\n", id, doc.Status)
+	}
+	if failed > 0 {
+		return fmt.Errorf("%d documents failed to requeue", failed)
+	}
+	return nil
+}
+
+// listFailedDocuments 先列取全部再重新排队，避免边列取边修改导致翻页遗漏
+func listFailedDocuments(ctx context.Context, c *client) ([]string, error) {
+	var ids []string
+	marker := ""
+	for {
+		result, err := c.ListFailedJobs(ctx, api.FailedJobDocument, marker, 0)
+		if err != nil {
+			return nil, err
+		}
+		for _, job := range result.Jobs {
+			ids = append(ids, job.ID)
+		}
+		if result.NextMarker == "" {
+			return ids, nil
+		}
+		marker = result.NextMarker
+	}
+}
+
+func sortedKeys(m map[string]bool) []string {
+	keys := make([]string, 0, len(m))
+	for k := range m {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	return keys
+}
+
+func sleep(ctx context.Context, d time.Duration) error {
+	t := time.NewTimer(d)
+	defer t.Stop()
+	select {
+	case <-ctx.Done():
+		return ctx.Err()
+	case <-t.C:
+		return nil
+	}
+}
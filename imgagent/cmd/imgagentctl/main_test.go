@@ -0,0 +1,172 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"imgagent/api"
+)
+
+func writeData(w http.ResponseWriter, code int, msg string, data any) {
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]any{"code": code, "message": msg, "reqid": "test", "data": data})
+}
+
+func TestUpload(t *testing.T) {
+	var mu sync.Mutex
+	uploaded := map[string]string{}
+	mux := http.NewServeMux()
+	mux.HandleFunc("POST /v1/documents", func(w http.ResponseWriter, r *http.Request) {
+		assert.Equal(t, "Bearer tk", r.Header.Get("Authorization"))
+		f, header, err := r.FormFile("file")
+		if !assert.NoError(t, err) {
+			return
+		}
+		defer f.Close()
+		if r.FormValue("name") == "bad" {
+			writeData(w, http.StatusBadRequest, "unsupported file type", nil)
+			return
+		}
+		assert.Equal(t, "high", r.FormValue("priority"))
+		assert.Equal(t, "true", r.FormValue("link_duplicate"))
+		mu.Lock()
+		uploaded[r.FormValue("name")] = header.Filename
+		mu.Unlock()
+		writeData(w, http.StatusOK, "", api.Document{ID: "d-" + r.FormValue("name"), Name: r.FormValue("name")})
+	})
+	ts := httptest.NewServer(mux)
+	defer ts.Close()
+
+	dir := t.TempDir()
+	require.NoError(t, os.WriteFile(filepath.Join(dir, "a.txt"), []byte("a"), 0o644))
+	require.NoError(t, os.WriteFile(filepath.Join(dir, "b.md"), []byte("b"), 0o644))
+	require.NoError(t, os.WriteFile(filepath.Join(dir, ".hidden"), []byte("h"), 0o644))
+	require.NoError(t, os.Mkdir(filepath.Join(dir, "sub"), 0o755))
+
+	c := newClient(ts.URL+"/v1/", "tk")
+	var out bytes.Buffer
+	err := runUpload(context.Background(), c, []string{"-priority", "high", "-link-duplicate", dir}, &out)
+	require.NoError(t, err)
+	assert.Equal(t, map[string]string{"a": "a.txt", "b": "b.md"}, uploaded)
+	assert.Contains(t, out.String(), "OK\ta.txt\td-a\ta")
+
+	// 单个文件失败时继续上传其他文件，最后返回错误
+	require.NoError(t, os.WriteFile(filepath.Join(dir, "bad.doc"), []byte("x"), 0o644))
+	out.Reset()
+	err = runUpload(context.Background(), c, []string{"-priority", "high", "-link-duplicate", dir}, &out)
+	require.EqualError(t, err, "1 files failed to upload")
+	assert.Contains(t, out.String(), "FAIL\tbad.doc\tcode: 400, message: unsupported file type")
+	assert.Contains(t, out.String(), "OK\tb.md")
+}
+
+func TestWatch(t *testing.T) {
+	polls := 0
+	mux := http.NewServeMux()
+	mux.HandleFunc("GET /v1/documents/{id}/progress", func(w http.ResponseWriter, r *http.Request) {
+		id := r.PathValue("id")
+		p := api.ProcessingProgress{DocumentID: id, Status: "failed", Stage: "roles", LastError: "timeout"}
+		if id == "d1" {
+			polls++
+			p = api.ProcessingProgress{DocumentID: id, Status: "chapterReady", Stage: "scenes", Percent: 40,
+				Stages: []api.StageProgress{{Stage: "scenes", Done: 2, Total: 5}}}
+			if polls > 1 {
+				p = api.ProcessingProgress{DocumentID: id, Status: "imgReady", Percent: 100}
+			}
+		}
+		writeData(w, http.StatusOK, "", p)
+	})
+	ts := httptest.NewServer(mux)
+	defer ts.Close()
+
+	var out bytes.Buffer
+	err := runWatch(context.Background(), newClient(ts.URL+"/v1", ""), []string{"-interval", "1ms", "d1", "d2"}, &out)
+	require.EqualError(t, err, "documents not completed: d2")
+	assert.Equal(t, 2, polls)
+	assert.Equal(t, "d1\tchapterReady\t40%\tstage=scenes 2/5\n"+
+		"d2\tfailed\t0%\tstage=roles\terror=timeout\n"+
+		"d1\timgReady\t100%\n", out.String())
+}
+
+func TestExport(t *testing.T) {
+	lists := 0
+	mux := http.NewServeMux()
+	mux.HandleFunc("POST /v1/documents/{id}/exports", func(w http.ResponseWriter, r *http.Request) {
+		var args api.CreateExportArgs
+		assert.NoError(t, json.NewDecoder(r.Body).Decode(&args))
+		assert.Equal(t, "zip", args.Format)
+		writeData(w, http.StatusAccepted, "", api.Export{ID: "e1", DocumentID: r.PathValue("id"), Format: "zip", Status: "pending"})
+	})
+	mux.HandleFunc("GET /v1/documents/{id}/exports", func(w http.ResponseWriter, r *http.Request) {
+		lists++
+		status := "running"
+		if lists > 1 {
+			status = "succeeded"
+		}
+		writeData(w, http.StatusOK, "", api.ListExportsResult{Exports: []api.Export{{ID: "e1", DocumentID: "d1", Format: "zip", Status: status}}})
+	})
+	mux.HandleFunc("GET /v1/exports/{id}/download", func(w http.ResponseWriter, r *http.Request) {
+		if r.PathValue("id") != "e1" {
+			writeData(w, http.StatusNotFound, "export artifact not available", nil)
+			return
+		}
+		w.Header().Set("Content-Type", "application/zip")
+		w.Write([]byte("zipdata"))
+	})
+	ts := httptest.NewServer(mux)
+	defer ts.Close()
+
+	c := newClient(ts.URL+"/v1", "")
+	dir := t.TempDir()
+	var out bytes.Buffer
+	err := runExport(context.Background(), c, []string{"-o", dir, "-interval", "1ms", "d1"}, &out)
+	require.NoError(t, err)
+	assert.Equal(t, 2, lists)
+	b, err := os.ReadFile(filepath.Join(dir, "d1.zip"))
+	require.NoError(t, err)
+	assert.Equal(t, "zipdata", string(b))
+
+	err = downloadExport(context.Background(), c, "e2", filepath.Join(dir, "e2.zip"))
+	assert.ErrorContains(t, err, "code: 404, message: export artifact not available")
+	assert.NoFileExists(t, filepath.Join(dir, "e2.zip.part"))
+}
+
+func TestRequeueAll(t *testing.T) {
+	var requeued []string
+	mux := http.NewServeMux()
+	mux.HandleFunc("GET /v1/admin/failed-jobs", func(w http.ResponseWriter, r *http.Request) {
+		assert.Equal(t, api.FailedJobDocument, r.URL.Query().Get("type"))
+		result := api.ListFailedJobsResult{Jobs: []api.FailedJob{{ID: "d1"}}, NextMarker: "d1"}
+		if r.URL.Query().Get("marker") == "d1" {
+			result = api.ListFailedJobsResult{Jobs: []api.FailedJob{{ID: "d2"}}}
+		}
+		writeData(w, http.StatusOK, "", result)
+	})
+	mux.HandleFunc("POST /v1/admin/failed-jobs/documents/{id}/requeue", func(w http.ResponseWriter, r *http.Request) {
+		requeued = append(requeued, r.PathValue("id"))
+		writeData(w, http.StatusOK, "", api.Document{ID: r.PathValue("id"), Status: "roleReady"})
+	})
+	ts := httptest.NewServer(mux)
+	defer ts.Close()
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	var out bytes.Buffer
+	err := runRequeue(ctx, newClient(ts.URL+"/v1", ""), []string{"-all"}, &out)
+	require.NoError(t, err)
+	assert.Equal(t, []string{"d1", "d2"}, requeued)
+	assert.Equal(t, "OK\td1\troleReady\nOK\td2\troleReady\n", out.String())
+
+	err = runRequeue(ctx, newClient(ts.URL+"/v1", ""), nil, &out)
+	assert.EqualError(t, err, "document id or -all is required")
+}
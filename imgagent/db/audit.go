@@ -0,0 +1,63 @@
+package db
+
+import (
+	"context"
+	"time"
+
+	"gorm.io/gorm"
+)
+
+// AuditLog 审计日志，记录谁在什么时间修改了哪个资源，以及修改前后的快照
+type AuditLog struct {
+	ID           int64     `gorm:"primaryKey;autoIncrement"`
+	UserID       int64     `gorm:"index:idx_audit_user_id;comment:'操作用户（租户） id'"`
+	UserName     string    `gorm:"size:64;comment:'操作用户名'"`
+	Action       string    `gorm:"size:32;comment:'操作 create|update|delete|regenerate 等'"`
+	ResourceType string    `gorm:"index:idx_audit_resource,priority:1;size:16;comment:'资源类型 document|chapter|scene|role|chapters|roles|prompt_template|backup'"`
+	ResourceID   string    `gorm:"index:idx_audit_resource,priority:2;size:32;comment:'资源 id'"`
+	DocumentID   string    `gorm:"index:idx_audit_document_id;size:32;comment:'资源所属文档 id'"`
+	Route        string    `gorm:"size:255;comment:'请求的方法和路由'"`
+	RequestID    string    `gorm:"size:64;comment:'请求 id'"`
+	Before       string    `gorm:"type:mediumtext;comment:'修改前的资源快照（json），新建时为空'"`
+	After        string    `gorm:"type:mediumtext;comment:'修改后的资源快照（json），删除时为空'"`
+	CreatedAt    time.Time `gorm:"comment:'创建时间'"`
+}
+
+func (AuditLog) TableName() string {
+	return "audit_logs"
+}
+
+// AuditLogFilter 审计日志的过滤条件，字段为零值时不过滤
+type AuditLogFilter struct {
+	ResourceType string
+	ResourceID   string
+	DocumentID   string
+	UserID       *int64
+}
+
+func (db *Database) CreateAuditLog(ctx context.Context, log *AuditLog) error {
+	return gorm.G[AuditLog](db.db).Create(ctx, log)
+}
+
+// ListAuditLogs 按时间倒序列取审计日志，marker 为上一页最后一条日志的 id，0 表示从最新开始
+func (db *Database) ListAuditLogs(ctx context.Context, filter AuditLogFilter, marker int64, limit int) ([]AuditLog, error) {
+	q := db.db.WithContext(ctx).Model(&AuditLog{})
+	if filter.ResourceType != "" {
+		q = q.Where("resource_type = ?", filter.ResourceType)
+	}
+	if filter.ResourceID != "" {
+		q = q.Where("resource_id = ?", filter.ResourceID)
+	}
+	if filter.DocumentID != "" {
+		q = q.Where("document_id = ?", filter.DocumentID)
+	}
+	if filter.UserID != nil {
+		q = q.Where("user_id = ?", *filter.UserID)
+	}
+	if marker > 0 {
+		q = q.Where("id < ?", marker)
+	}
+	var logs []AuditLog
+	err := q.Order("id DESC").Limit(limit).Find(&logs).Error
+	return logs, err
+}
@@ -0,0 +1,51 @@
+package db
+
+import (
+	"context"
+	"time"
+
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+)
+
+// BackfillProgress 回填进度，每个阶段一行。按文档 id 顺序处理，LastDocumentID 为已处理的最后一个文档，
+// 中断后从该位置继续
+type BackfillProgress struct {
+	Stage          string    `gorm:"primaryKey;size:32;comment:'回填阶段'"`
+	LastDocumentID string    `gorm:"size:32;comment:'已处理的最后一个文档 id'"`
+	Processed      int       `gorm:"comment:'已处理文档数'"`
+	Failed         int       `gorm:"comment:'处理失败文档数'"`
+	Done           bool      `gorm:"comment:'是否已完成'"`
+	UpdatedAt      time.Time `gorm:"comment:'更新时间'"`
+}
+
+func (BackfillProgress) TableName() string {
+	return "backfill_progress"
+}
+
+// GetBackfillProgress 获取阶段的回填进度，未开始时返回空进度
+func (db *Database) GetBackfillProgress(ctx context.Context, stage string) (BackfillProgress, error) {
+	progress, err := gorm.G[BackfillProgress](db.db).Where("stage = ?", stage).Find(ctx)
+	if err != nil {
+		return BackfillProgress{}, err
+	}
+	if len(progress) == 0 {
+		return BackfillProgress{Stage: stage}, nil
+	}
+	return progress[0], nil
+}
+
+func (db *Database) SaveBackfillProgress(ctx context.Context, progress *BackfillProgress) error {
+	progress.UpdatedAt = time.Now()
+	return db.db.WithContext(ctx).Clauses(clause.OnConflict{UpdateAll: true}).Create(progress).Error
+}
+
+func (db *Database) DeleteBackfillProgress(ctx context.Context, stage string) error {
+	_, err := gorm.G[BackfillProgress](db.db).Where("stage = ?", stage).Delete(ctx)
+	return err
+}
+
+// ListDocumentsAfter 按 id 顺序列取 id 大于 afterID 的文档
+func (db *Database) ListDocumentsAfter(ctx context.Context, afterID string, limit int) ([]Document, error) {
+	return gorm.G[Document](db.db).Where("id > ?", afterID).Order("id ASC").Limit(limit).Find(ctx)
+}
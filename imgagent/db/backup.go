@@ -0,0 +1,222 @@
+package db
+
+import (
+	"bufio"
+	"compress/gzip"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"reflect"
+	"slices"
+	"sort"
+	"time"
+
+	"gorm.io/gorm"
+)
+
+// SnapshotVersion 快照格式版本，不兼容的修改时递增
+const SnapshotVersion = 1
+
+// ErrInvalidSnapshot 快照格式错误或与当前库的 schema 不一致，不能恢复
+var ErrInvalidSnapshot = errors.New("invalid snapshot")
+
+// SnapshotHeader 快照的第一行。Migrations 为备份时已执行的迁移，恢复时要求与当前库一致
+type SnapshotHeader struct {
+	Version    int       `json:"version"`
+	CreatedAt  time.Time `json:"created_at"`
+	Migrations []string  `json:"migrations"`
+}
+
+// snapshotRow 快照中的一行数据，Row 为模型的 json
+type snapshotRow struct {
+	Table string          `json:"table"`
+	Row   json.RawMessage `json:"row"`
+}
+
+// SnapshotStats 快照的头部和各表的行数
+type SnapshotStats struct {
+	Header SnapshotHeader
+	Tables map[string]int64
+}
+
+// snapshotModels 按表名索引 models，用于解析快照中的行
+func snapshotModels() map[string]reflect.Type {
+	types := make(map[string]reflect.Type, len(models))
+	for _, m := range models {
+		t := reflect.TypeOf(m).Elem()
+		types[tableName(m)] = t
+	}
+	return types
+}
+
+func tableName(model any) string {
+	return model.(interface{ TableName() string }).TableName()
+}
+
+func (db *Database) appliedMigrationIDs(ctx context.Context) ([]string, error) {
+	applied, err := db.migrator().applied(ctx)
+	if err != nil {
+		return nil, err
+	}
+	ids := make([]string, 0, len(applied))
+	for id := range applied {
+		ids = append(ids, id)
+	}
+	sort.Strings(ids)
+	return ids, nil
+}
+
+// WriteSnapshot 把 models 中全部表的数据以 gzip 压缩的 json lines 写入 w，
+// 第一行为 SnapshotHeader，之后每行一条记录。在一个只读事务中读取，各表的数据保持一致
+func (db *Database) WriteSnapshot(ctx context.Context, w io.Writer) (SnapshotStats, error) {
+	migrations, err := db.appliedMigrationIDs(ctx)
+	if err != nil {
+		return SnapshotStats{}, err
+	}
+	stats := SnapshotStats{
+		Header: SnapshotHeader{Version: SnapshotVersion, CreatedAt: time.Now(), Migrations: migrations},
+		Tables: make(map[string]int64, len(models)),
+	}
+
+	zw := gzip.NewWriter(w)
+	enc := json.NewEncoder(zw)
+	if err = enc.Encode(stats.Header); err != nil {
+		return stats, err
+	}
+	err = db.db.WithContext(ctx).Transaction(func(tx *gorm.DB) error {
+		for _, m := range models {
+			table := tableName(m)
+			rows, err := tx.Model(m).Rows()
+			if err != nil {
+				return fmt.Errorf("read %s: %w", table, err)
+			}
+			t := reflect.TypeOf(m).Elem()
+			for rows.Next() {
+				v := reflect.New(t).Interface()
+				if err = tx.ScanRows(rows, v); err != nil {
+					rows.Close()
+					return fmt.Errorf("scan %s: %w", table, err)
+				}
+				row, err := json.Marshal(v)
+				if err != nil {
+					rows.Close()
+					return err
+				}
+				if err = enc.Encode(snapshotRow{Table: table, Row: row}); err != nil {
+					rows.Close()
+					return err
+				}
+				stats.Tables[table]++
+			}
+			err = rows.Err()
+			rows.Close()
+			if err != nil {
+				return fmt.Errorf("read %s: %w", table, err)
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		return stats, err
+	}
+	return stats, zw.Close()
+}
+
+// RestoreSnapshot 用 WriteSnapshot 写入的快照替换 models 中全部表的数据。
+// 在一个事务中先清空各表再写入，任一步失败时整体回滚；dryRun 为 true 时只校验快照和统计行数，不修改数据。
+// 快照格式错误或迁移与当前库不一致时返回 ErrInvalidSnapshot
+func (db *Database) RestoreSnapshot(ctx context.Context, r io.Reader, dryRun bool) (SnapshotStats, error) {
+	var stats SnapshotStats
+	zr, err := gzip.NewReader(r)
+	if err != nil {
+		return stats, fmt.Errorf("%w: %v", ErrInvalidSnapshot, err)
+	}
+	defer zr.Close()
+	dec := json.NewDecoder(bufio.NewReader(zr))
+	if err = dec.Decode(&stats.Header); err != nil {
+		return stats, fmt.Errorf("%w: read header: %v", ErrInvalidSnapshot, err)
+	}
+	if stats.Header.Version != SnapshotVersion {
+		return stats, fmt.Errorf("%w: unsupported version %d", ErrInvalidSnapshot, stats.Header.Version)
+	}
+	migrations, err := db.appliedMigrationIDs(ctx)
+	if err != nil {
+		return stats, err
+	}
+	if !slices.Equal(migrations, stats.Header.Migrations) {
+		return stats, fmt.Errorf("%w: snapshot migrations do not match the database, run migrations to the same version first", ErrInvalidSnapshot)
+	}
+
+	restore := func(tx *gorm.DB) error {
+		stats.Tables = make(map[string]int64, len(models))
+		if !dryRun {
+			for _, m := range models {
+				if err := tx.Session(&gorm.Session{AllowGlobalUpdate: true}).Delete(m).Error; err != nil {
+					return fmt.Errorf("clear %s: %w", tableName(m), err)
+				}
+			}
+		}
+		return db.restoreRows(tx, dec, dryRun, stats.Tables)
+	}
+	if dryRun {
+		err = restore(db.db.WithContext(ctx))
+	} else {
+		err = db.db.WithContext(ctx).Transaction(restore)
+	}
+	return stats, err
+}
+
+// restoreRows 按表分批写入快照中的行，dryRun 时只解析
+func (db *Database) restoreRows(tx *gorm.DB, dec *json.Decoder, dryRun bool, counts map[string]int64) error {
+	types := snapshotModels()
+	var (
+		table string
+		batch reflect.Value
+	)
+	flush := func() error {
+		if dryRun || !batch.IsValid() || batch.Elem().Len() == 0 {
+			return nil
+		}
+		if err := tx.CreateInBatches(batch.Interface(), batchSize).Error; err != nil {
+			return fmt.Errorf("restore %s: %w", table, err)
+		}
+		batch.Elem().SetLen(0)
+		return nil
+	}
+
+	for {
+		var row snapshotRow
+		err := dec.Decode(&row)
+		if errors.Is(err, io.EOF) {
+			break
+		}
+		if err != nil {
+			return fmt.Errorf("%w: %v", ErrInvalidSnapshot, err)
+		}
+		t, ok := types[row.Table]
+		if !ok {
+			return fmt.Errorf("%w: unknown table %s", ErrInvalidSnapshot, row.Table)
+		}
+		if row.Table != table {
+			if err = flush(); err != nil {
+				return err
+			}
+			table = row.Table
+			batch = reflect.New(reflect.SliceOf(t))
+		}
+		v := reflect.New(t)
+		if err = json.Unmarshal(row.Row, v.Interface()); err != nil {
+			return fmt.Errorf("%w: decode %s row: %v", ErrInvalidSnapshot, row.Table, err)
+		}
+		batch.Elem().Set(reflect.Append(batch.Elem(), v.Elem()))
+		counts[row.Table]++
+		if batch.Elem().Len() >= batchSize {
+			if err = flush(); err != nil {
+				return err
+			}
+		}
+	}
+	return flush()
+}
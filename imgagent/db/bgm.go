@@ -0,0 +1,47 @@
+package db
+
+import (
+	"context"
+	"time"
+
+	"gorm.io/gorm"
+)
+
+// BGMTrack 背景音乐曲目，由管理员上传到对象存储，文档通过 bgm_id 选用
+type BGMTrack struct {
+	ID        string    `gorm:"primaryKey;size:32;comment:'主键'"`
+	Name      string    `gorm:"size:100;comment:'名称'"`
+	Key       string    `gorm:"size:255;comment:'对象存储中的 key'"`
+	URL       string    `gorm:"size:500;comment:'音频url'"`
+	Volume    float64   `gorm:"comment:'叠加在旁白下的音量，0~1'"`
+	CreatedAt time.Time `gorm:"comment:'创建时间'"`
+	UpdatedAt time.Time `gorm:"comment:'更新时间'"`
+}
+
+func (BGMTrack) TableName() string {
+	return "bgm_tracks"
+}
+
+func (db *Database) CreateBGMTrack(ctx context.Context, track *BGMTrack) error {
+	return gorm.G[BGMTrack](db.db).Create(ctx, track)
+}
+
+func (db *Database) GetBGMTrack(ctx context.Context, id string) (BGMTrack, error) {
+	return gorm.G[BGMTrack](db.db).Where("id = ?", id).Take(ctx)
+}
+
+func (db *Database) ListBGMTracks(ctx context.Context) ([]BGMTrack, error) {
+	return gorm.G[BGMTrack](db.db).Order("created_at DESC").Find(ctx)
+}
+
+// DeleteBGMTrack 删除曲目，不修改选用该曲目的文档，播放清单中不再包含背景音乐。曲目不存在时返回 gorm.ErrRecordNotFound
+func (db *Database) DeleteBGMTrack(ctx context.Context, id string) error {
+	rowsAffected, err := gorm.G[BGMTrack](db.db).Where("id = ?", id).Delete(ctx)
+	if err != nil {
+		return err
+	}
+	if rowsAffected == 0 {
+		return gorm.ErrRecordNotFound
+	}
+	return nil
+}
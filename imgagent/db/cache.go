@@ -0,0 +1,429 @@
+package db
+
+import (
+	"context"
+	"encoding/json"
+	"io"
+	"time"
+
+	"go.uber.org/zap"
+
+	"imgagent/api"
+	"imgagent/pkg/cache"
+	"imgagent/pkg/dbutil"
+)
+
+// cachedDatabase 在 GetDocument、ListChapters、ListScenesByChapter 前加读缓存，
+// 修改文档、章节、场景的写路径在写入后删除受影响的 key。缓存读写失败时直接访问数据库
+type cachedDatabase struct {
+	IDataBase
+	cache cache.Cache
+}
+
+// NewCachedDatabase c 为 nil 时不启用缓存，直接返回 database
+func NewCachedDatabase(database IDataBase, c cache.Cache) IDataBase {
+	if c == nil {
+		return database
+	}
+	return &cachedDatabase{IDataBase: database, cache: c}
+}
+
+func documentKey(id string) string {
+	return "document:" + id
+}
+
+func chaptersKey(documentID string) string {
+	return "chapters:" + documentID
+}
+
+func scenesKey(chapterID string) string {
+	return "scenes:" + chapterID
+}
+
+func (d *cachedDatabase) Close() {
+	err := d.cache.Close()
+	if err != nil {
+		zap.S().Errorf("Failed to close cache, err: %v", err)
+	}
+	d.IDataBase.Close()
+}
+
+// readThrough 命中缓存时返回缓存的值，否则调用 load 并写入缓存。查询出错时不缓存；
+// 标记读从库的查询结果可能落后于主库，同样不缓存，避免默认读主库的请求读到旧数据
+func readThrough[T any](ctx context.Context, c cache.Cache, key string, load func() (T, error)) (T, error) {
+	data, ok, err := c.Get(ctx, key)
+	if err != nil {
+		zap.S().Warnf("Failed to get cache, key: %s, err: %v", key, err)
+	}
+	if ok {
+		var ret T
+		err = json.Unmarshal(data, &ret)
+		if err == nil {
+			return ret, nil
+		}
+		zap.S().Warnf("Failed to unmarshal cache, key: %s, err: %v", key, err)
+	}
+
+	ret, err := load()
+	if err != nil || dbutil.IsReplica(ctx) {
+		return ret, err
+	}
+	data, err = json.Marshal(ret)
+	if err == nil {
+		err = c.Set(ctx, key, data)
+	}
+	if err != nil {
+		zap.S().Warnf("Failed to set cache, key: %s, err: %v", key, err)
+	}
+	return ret, nil
+}
+
+func (d *cachedDatabase) invalidate(ctx context.Context, keys ...string) {
+	err := d.cache.Delete(ctx, keys...)
+	if err != nil {
+		zap.S().Errorf("Failed to delete cache, keys: %v, err: %v", keys, err)
+	}
+}
+
+// invalidateDocumentScenes 删除文档全部章节的场景缓存，用于插入、重排等会改变其他章节场景序号的写入
+func (d *cachedDatabase) invalidateDocumentScenes(ctx context.Context, documentID string) {
+	chapters, err := d.IDataBase.ListChapters(ctx, documentID)
+	if err != nil {
+		zap.S().Errorf("Failed to list chapters for cache invalidation, docID: %s, err: %v", documentID, err)
+		return
+	}
+	keys := make([]string, 0, len(chapters))
+	for _, ch := range chapters {
+		keys = append(keys, scenesKey(ch.ID))
+	}
+	d.invalidate(ctx, keys...)
+}
+
+// invalidateChapter 删除章节所属文档的章节列表缓存
+func (d *cachedDatabase) invalidateChapter(ctx context.Context, chapterID string) {
+	chapter, err := d.IDataBase.GetChapterByID(ctx, chapterID)
+	if err != nil {
+		zap.S().Errorf("Failed to get chapter for cache invalidation, id: %s, err: %v", chapterID, err)
+		return
+	}
+	d.invalidate(ctx, chaptersKey(chapter.DocumentID))
+}
+
+// invalidateScene 删除场景所属章节的场景列表缓存
+func (d *cachedDatabase) invalidateScene(ctx context.Context, sceneID string) {
+	scene, err := d.IDataBase.GetScene(ctx, sceneID)
+	if err != nil {
+		zap.S().Errorf("Failed to get scene for cache invalidation, id: %s, err: %v", sceneID, err)
+		return
+	}
+	d.invalidate(ctx, scenesKey(scene.ChapterID))
+}
+
+// ===== 读缓存 =====
+
+func (d *cachedDatabase) GetDocument(ctx context.Context, id string) (Document, error) {
+	return readThrough(ctx, d.cache, documentKey(id), func() (Document, error) {
+		return d.IDataBase.GetDocument(ctx, id)
+	})
+}
+
+func (d *cachedDatabase) ListChapters(ctx context.Context, documentID string) ([]Chapter, error) {
+	return readThrough(ctx, d.cache, chaptersKey(documentID), func() ([]Chapter, error) {
+		return d.IDataBase.ListChapters(ctx, documentID)
+	})
+}
+
+func (d *cachedDatabase) ListScenesByChapter(ctx context.Context, chapterID string) ([]Scene, error) {
+	return readThrough(ctx, d.cache, scenesKey(chapterID), func() ([]Scene, error) {
+		return d.IDataBase.ListScenesByChapter(ctx, chapterID)
+	})
+}
+
+// ===== Document =====
+
+func (d *cachedDatabase) UpdateDocumentNameKey(ctx context.Context, id string) error {
+	defer d.invalidate(ctx, documentKey(id))
+	return d.IDataBase.UpdateDocumentNameKey(ctx, id)
+}
+
+func (d *cachedDatabase) UpdateDocument(ctx context.Context, id string, args *api.UpdateDocumentArgs) error {
+	defer d.invalidate(ctx, documentKey(id))
+	return d.IDataBase.UpdateDocument(ctx, id, args)
+}
+
+func (d *cachedDatabase) UpdateDocumentStatus(ctx context.Context, id string, status string) error {
+	defer d.invalidate(ctx, documentKey(id))
+	return d.IDataBase.UpdateDocumentStatus(ctx, id, status)
+}
+
+func (d *cachedDatabase) UpdateDocumentAttempt(ctx context.Context, id string, attempts int, lastError string, nextAttemptAt time.Time) error {
+	defer d.invalidate(ctx, documentKey(id))
+	return d.IDataBase.UpdateDocumentAttempt(ctx, id, attempts, lastError, nextAttemptAt)
+}
+
+func (d *cachedDatabase) UpdateDocumentFileID(ctx context.Context, id string, fileID string) error {
+	defer d.invalidate(ctx, documentKey(id))
+	return d.IDataBase.UpdateDocumentFileID(ctx, id, fileID)
+}
+
+func (d *cachedDatabase) UpdateDocumentSummary(ctx context.Context, id string, summary string) error {
+	defer d.invalidate(ctx, documentKey(id))
+	return d.IDataBase.UpdateDocumentSummary(ctx, id, summary)
+}
+
+func (d *cachedDatabase) UpdateDocumentSynopsis(ctx context.Context, id, synopsis string, plotPoints []string) error {
+	defer d.invalidate(ctx, documentKey(id))
+	return d.IDataBase.UpdateDocumentSynopsis(ctx, id, synopsis, plotPoints)
+}
+
+func (d *cachedDatabase) UpdateDocumentSummaryImageURL(ctx context.Context, id string, imageURL string) error {
+	defer d.invalidate(ctx, documentKey(id))
+	return d.IDataBase.UpdateDocumentSummaryImageURL(ctx, id, imageURL)
+}
+
+func (d *cachedDatabase) UpdateDocumentSceneStats(ctx context.Context, id string, sceneCount, failedSceneCount int) error {
+	defer d.invalidate(ctx, documentKey(id))
+	return d.IDataBase.UpdateDocumentSceneStats(ctx, id, sceneCount, failedSceneCount)
+}
+
+func (d *cachedDatabase) UpdateDocumentPaused(ctx context.Context, id string, paused bool) error {
+	defer d.invalidate(ctx, documentKey(id))
+	return d.IDataBase.UpdateDocumentPaused(ctx, id, paused)
+}
+
+func (d *cachedDatabase) CancelDocument(ctx context.Context, id string) error {
+	defer d.invalidate(ctx, documentKey(id))
+	return d.IDataBase.CancelDocument(ctx, id)
+}
+
+func (d *cachedDatabase) FailDocument(ctx context.Context, id string, stage string, attempts int, lastError string) error {
+	defer d.invalidate(ctx, documentKey(id))
+	return d.IDataBase.FailDocument(ctx, id, stage, attempts, lastError)
+}
+
+func (d *cachedDatabase) DeleteDocument(ctx context.Context, id string) ([]string, error) {
+	defer d.invalidate(ctx, documentKey(id), chaptersKey(id))
+	d.invalidateDocumentScenes(ctx, id)
+	return d.IDataBase.DeleteDocument(ctx, id)
+}
+
+// ===== Chapter =====
+
+func (d *cachedDatabase) CreateChapters(ctx context.Context, documentID string, texts []string) error {
+	defer d.invalidate(ctx, chaptersKey(documentID))
+	return d.IDataBase.CreateChapters(ctx, documentID, texts)
+}
+
+func (d *cachedDatabase) UpdateChapter(ctx context.Context, id string, args *api.UpdateChapterArgs) error {
+	err := d.IDataBase.UpdateChapter(ctx, id, args)
+	d.invalidateChapter(ctx, id)
+	return err
+}
+
+func (d *cachedDatabase) UpdateChapterSummary(ctx context.Context, id, summary string) error {
+	err := d.IDataBase.UpdateChapterSummary(ctx, id, summary)
+	d.invalidateChapter(ctx, id)
+	return err
+}
+
+func (d *cachedDatabase) UpdateChapterSceneIDs(ctx context.Context, chapterID string, sceneIDs []string) error {
+	err := d.IDataBase.UpdateChapterSceneIDs(ctx, chapterID, sceneIDs)
+	d.invalidateChapter(ctx, chapterID)
+	return err
+}
+
+func (d *cachedDatabase) UpdateChapterCover(ctx context.Context, id, coverSceneID, coverURL string) error {
+	err := d.IDataBase.UpdateChapterCover(ctx, id, coverSceneID, coverURL)
+	d.invalidateChapter(ctx, id)
+	return err
+}
+
+func (d *cachedDatabase) DeleteChapter(ctx context.Context, id, documentID string) error {
+	defer d.invalidate(ctx, chaptersKey(documentID), scenesKey(id))
+	return d.IDataBase.DeleteChapter(ctx, id, documentID)
+}
+
+func (d *cachedDatabase) DeleteAllChapter(ctx context.Context, documentID string) error {
+	defer d.invalidate(ctx, chaptersKey(documentID))
+	d.invalidateDocumentScenes(ctx, documentID)
+	return d.IDataBase.DeleteAllChapter(ctx, documentID)
+}
+
+func (d *cachedDatabase) ReorderChapters(ctx context.Context, documentID string, chapterIDs []string) error {
+	err := d.IDataBase.ReorderChapters(ctx, documentID, chapterIDs)
+	d.invalidate(ctx, chaptersKey(documentID))
+	d.invalidateDocumentScenes(ctx, documentID)
+	return err
+}
+
+func (d *cachedDatabase) SplitChapter(ctx context.Context, documentID, id, head, tail string, keepScenes int) (Chapter, error) {
+	created, err := d.IDataBase.SplitChapter(ctx, documentID, id, head, tail, keepScenes)
+	d.invalidate(ctx, chaptersKey(documentID))
+	d.invalidateDocumentScenes(ctx, documentID)
+	return created, err
+}
+
+func (d *cachedDatabase) MarkDuplicateChapters(ctx context.Context, documentID string, duplicates []ChapterDuplicate) error {
+	defer d.invalidate(ctx, chaptersKey(documentID))
+	return d.IDataBase.MarkDuplicateChapters(ctx, documentID, duplicates)
+}
+
+// ===== Scene =====
+
+func (d *cachedDatabase) CreateScenes(ctx context.Context, scenes []Scene) error {
+	err := d.IDataBase.CreateScenes(ctx, scenes)
+	keys := make([]string, 0, len(scenes))
+	seen := make(map[string]bool)
+	for _, scene := range scenes {
+		if !seen[scene.ChapterID] {
+			seen[scene.ChapterID] = true
+			keys = append(keys, scenesKey(scene.ChapterID))
+		}
+	}
+	d.invalidate(ctx, keys...)
+	return err
+}
+
+// InsertScene 插入场景会改变文档后续场景的序号和章节的场景列表
+func (d *cachedDatabase) InsertScene(ctx context.Context, afterID string, scene *Scene) error {
+	err := d.IDataBase.InsertScene(ctx, afterID, scene)
+	if err != nil {
+		return err
+	}
+	d.invalidate(ctx, chaptersKey(scene.DocumentID))
+	d.invalidateDocumentScenes(ctx, scene.DocumentID)
+	return nil
+}
+
+func (d *cachedDatabase) MarkScenesMediaStale(ctx context.Context, documentID string, sceneIDs []string) (int, error) {
+	defer d.invalidateDocumentScenes(ctx, documentID)
+	return d.IDataBase.MarkScenesMediaStale(ctx, documentID, sceneIDs)
+}
+
+func (d *cachedDatabase) UpdateScene(ctx context.Context, id string, args *api.UpdateSceneArgs) error {
+	err := d.IDataBase.UpdateScene(ctx, id, args)
+	d.invalidateScene(ctx, id)
+	return err
+}
+
+func (d *cachedDatabase) UpdateSceneImageURL(ctx context.Context, sceneID string, imageURL string) error {
+	err := d.IDataBase.UpdateSceneImageURL(ctx, sceneID, imageURL)
+	d.invalidateScene(ctx, sceneID)
+	return err
+}
+
+func (d *cachedDatabase) UpdateSceneHDImage(ctx context.Context, sceneID string, hdImageURL, sourceURL string) error {
+	err := d.IDataBase.UpdateSceneHDImage(ctx, sceneID, hdImageURL, sourceURL)
+	d.invalidateScene(ctx, sceneID)
+	return err
+}
+
+func (d *cachedDatabase) UpdateSceneVoiceURL(ctx context.Context, sceneID string, voiceURL string) error {
+	err := d.IDataBase.UpdateSceneVoiceURL(ctx, sceneID, voiceURL)
+	d.invalidateScene(ctx, sceneID)
+	return err
+}
+
+func (d *cachedDatabase) UpdateSceneSubtitleURL(ctx context.Context, sceneID string, subtitleURL string) error {
+	err := d.IDataBase.UpdateSceneSubtitleURL(ctx, sceneID, subtitleURL)
+	d.invalidateScene(ctx, sceneID)
+	return err
+}
+
+func (d *cachedDatabase) UpdateSceneTiming(ctx context.Context, sceneID string, audioDurationMs, displayDurationMs int64) error {
+	err := d.IDataBase.UpdateSceneTiming(ctx, sceneID, audioDurationMs, displayDurationMs)
+	d.invalidateScene(ctx, sceneID)
+	return err
+}
+
+func (d *cachedDatabase) UpdateScenePlaceholder(ctx context.Context, sceneID string, imageURL, voiceURL string) error {
+	err := d.IDataBase.UpdateScenePlaceholder(ctx, sceneID, imageURL, voiceURL)
+	d.invalidateScene(ctx, sceneID)
+	return err
+}
+
+func (d *cachedDatabase) UpdateSceneStatus(ctx context.Context, sceneID string, status string, attempts int, errMsg string) error {
+	err := d.IDataBase.UpdateSceneStatus(ctx, sceneID, status, attempts, errMsg)
+	d.invalidateScene(ctx, sceneID)
+	return err
+}
+
+func (d *cachedDatabase) DeleteScenesByChapter(ctx context.Context, chapterID string) error {
+	defer d.invalidate(ctx, scenesKey(chapterID))
+	return d.IDataBase.DeleteScenesByChapter(ctx, chapterID)
+}
+
+func (d *cachedDatabase) DeleteScenesByDocument(ctx context.Context, documentID string) error {
+	defer d.invalidateDocumentScenes(ctx, documentID)
+	return d.IDataBase.DeleteScenesByDocument(ctx, documentID)
+}
+
+// MergeRoles 合并角色会改写文档中的场景描述
+func (d *cachedDatabase) MergeRoles(ctx context.Context, documentID string, target *Role, sourceIDs []string, rewrite func(string) string) error {
+	defer d.invalidateDocumentScenes(ctx, documentID)
+	return d.IDataBase.MergeRoles(ctx, documentID, target, sourceIDs, rewrite)
+}
+
+func (d *cachedDatabase) ActivateSceneGeneration(ctx context.Context, gen *SceneGeneration) error {
+	err := d.IDataBase.ActivateSceneGeneration(ctx, gen)
+	d.invalidateScene(ctx, gen.SceneID)
+	return err
+}
+
+func (d *cachedDatabase) RevertSceneImage(ctx context.Context, v *SceneImageVersion) error {
+	err := d.IDataBase.RevertSceneImage(ctx, v)
+	d.invalidateScene(ctx, v.SceneID)
+	return err
+}
+
+func (d *cachedDatabase) ResetFailedScenes(ctx context.Context, documentID string, sceneID string) (int64, error) {
+	defer d.invalidateDocumentScenes(ctx, documentID)
+	return d.IDataBase.ResetFailedScenes(ctx, documentID, sceneID)
+}
+
+func (d *cachedDatabase) BlockScene(ctx context.Context, sceneID string, reason string, heldImageURL string) error {
+	err := d.IDataBase.BlockScene(ctx, sceneID, reason, heldImageURL)
+	d.invalidateScene(ctx, sceneID)
+	return err
+}
+
+func (d *cachedDatabase) ResetBlockedScene(ctx context.Context, sceneID string, pass bool) error {
+	err := d.IDataBase.ResetBlockedScene(ctx, sceneID, pass)
+	d.invalidateScene(ctx, sceneID)
+	return err
+}
+
+// ===== Backup =====
+
+// RestoreSnapshot 恢复前后的文档都可能有缓存，恢复后删除两者的全部 key
+func (d *cachedDatabase) RestoreSnapshot(ctx context.Context, r io.Reader, dryRun bool) (SnapshotStats, error) {
+	if dryRun {
+		return d.IDataBase.RestoreSnapshot(ctx, r, dryRun)
+	}
+	before := d.allKeys(ctx)
+	stats, err := d.IDataBase.RestoreSnapshot(ctx, r, dryRun)
+	d.invalidate(ctx, append(before, d.allKeys(ctx)...)...)
+	return stats, err
+}
+
+// allKeys 全部文档、章节列表和场景列表的 key
+func (d *cachedDatabase) allKeys(ctx context.Context) []string {
+	docs, err := d.IDataBase.ListDocuments(ctx)
+	if err != nil {
+		zap.S().Errorf("Failed to list documents for cache invalidation, err: %v", err)
+		return nil
+	}
+	var keys []string
+	for _, doc := range docs {
+		keys = append(keys, documentKey(doc.ID), chaptersKey(doc.ID))
+		chapters, err := d.IDataBase.ListChapters(ctx, doc.ID)
+		if err != nil {
+			zap.S().Errorf("Failed to list chapters for cache invalidation, docID: %s, err: %v", doc.ID, err)
+			continue
+		}
+		for _, ch := range chapters {
+			keys = append(keys, scenesKey(ch.ID))
+		}
+	}
+	return keys
+}
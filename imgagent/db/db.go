@@ -1,6 +1,7 @@
 package db
 
 import (
+	"context"
 	"encoding/hex"
 
 	"github.com/google/uuid"
@@ -24,14 +25,19 @@ func NewDatabase(conf dbutil.Config) (*Database, error) {
 		db: db,
 	}
 
-	// 这里可以添加表创建逻辑，需要指定字符集为 utf8mb4，默认为 utf8mb3
-	err = db.Set("gorm:table_options", "CHARSET=utf8mb4 COLLATE=utf8mb4_unicode_ci").AutoMigrate(&Document{}, &Chapter{}, &Scene{}, &Role{})
-
+	// 多实例部署时应关闭启动迁移，发布前单独执行 -migrate up，避免多个实例同时迁移
+	if conf.SkipMigrate {
+		return database, nil
+	}
+	ids, err := database.Migrate(context.Background())
 	if err != nil {
-		zap.S().Errorf("Failed to auto migrate, err: %v", err)
+		zap.S().Errorf("Failed to migrate, err: %v", err)
 		database.Close()
 		return nil, err
 	}
+	if len(ids) > 0 {
+		zap.S().Infof("Migrations applied, ids: %v", ids)
+	}
 
 	return database, nil
 }
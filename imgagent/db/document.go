@@ -2,8 +2,12 @@ package db
 
 import (
 	"context"
+	"errors"
+	"slices"
+	"strings"
 	"time"
 
+	"golang.org/x/text/width"
 	"gorm.io/gorm"
 
 	"imgagent/api"
@@ -16,22 +20,98 @@ const (
 	DocumentStatusRoleReady    = "roleReady"
 	DocumentStatusSceneReady   = "sceneReady"
 	DocumentStatusImgReady     = "imgReady"
+	// DocumentStatusCompletedWithErrors 大部分场景生成成功，少量场景永久失败
+	DocumentStatusCompletedWithErrors = "completedWithErrors"
+	// DocumentStatusFailed 失败场景过多，文档处理失败
+	DocumentStatusFailed = "failed"
+	// DocumentStatusCanceled 用户取消处理，已生成的场景保留，不再继续处理
+	DocumentStatusCanceled = "canceled"
+
+	// SceneStatusReady 场景图片和语音均已生成
+	SceneStatusReady = "ready"
+	// SceneStatusFailed 场景重试次数耗尽，永久失败
+	SceneStatusFailed = "failed"
+	// SceneStatusBlocked 场景描述或图片未通过内容审核，等待人工复核
+	SceneStatusBlocked = "blocked"
+
+	// 文档处理优先级，同一阶段优先处理优先级高的文档，同优先级按创建时间先后
+	DocumentPriorityLow    = -1
+	DocumentPriorityNormal = 0
+	DocumentPriorityHigh   = 1
+)
+
+// 文档列表的排序字段
+const (
+	DocumentSortCreatedAt = "created_at"
+	DocumentSortUpdatedAt = "updated_at"
+	DocumentSortName      = "name"
 )
 
+// DocumentSortFields 文档列表支持的排序字段
+var DocumentSortFields = []string{DocumentSortCreatedAt, DocumentSortUpdatedAt, DocumentSortName}
+
+// DocumentFilter 文档列表的过滤和排序条件，字段为零值时不过滤；SortBy 为空时按更新时间排序，Asc 为 false 时倒序
+type DocumentFilter struct {
+	UpdatedSince time.Time
+	Statuses     []string
+	SortBy       string
+	Asc          bool
+}
+
 func (Role) TableName() string {
 	return "roles"
 }
 
 // Document 文档表
 type Document struct {
-	ID              string    `gorm:"primaryKey;size:32;comment:'主键'"`
-	Name            string    `gorm:"uniqueIndex:uk_name;size:128;comment:'文档名称'"`
-	FileID          string    `gorm:"size:255;comment:'存储在阿里云百炼的 fileid'"`
-	Summary         string    `gorm:"size:1000;comment:'小说摘要'"`
-	SummaryImageURL string    `gorm:"size:500;comment:'小说封面图URL'"`
-	Status          string    `gorm:"size:20;comment:'状态 indexing|ready'"`
-	CreatedAt       time.Time `gorm:"comment:'创建时间'"`
-	UpdatedAt       time.Time `gorm:"comment:'更新时间'"`
+	ID               string     `gorm:"primaryKey;size:32;comment:'主键'"`
+	Name             string     `gorm:"uniqueIndex:uk_name;size:128;comment:'文档名称'"`
+	UserID           int64      `gorm:"index:idx_document_user_id;comment:'所属用户（租户） id'"`
+	FileSize         int64      `gorm:"comment:'上传文件大小（字节）'"`
+	FileID           string     `gorm:"index:idx_document_file_id;size:255;comment:'存储在阿里云百炼的 fileid，删除文档时同时删除'"`
+	Summary          string     `gorm:"size:1000;comment:'小说摘要'"`
+	SummaryImageURL  string     `gorm:"size:500;comment:'小说封面图URL'"`
+	Status           string     `gorm:"size:20;comment:'状态 indexing|ready'"`
+	SceneCount       int        `gorm:"comment:'场景总数'"`
+	FailedSceneCount int        `gorm:"comment:'永久失败的场景数'"`
+	Priority         int        `gorm:"comment:'处理优先级 1 高 0 普通 -1 低'"`
+	Paused           bool       `gorm:"not null;default:false;comment:'是否暂停处理，暂停时保留当前阶段，恢复后从剩余场景继续'"`
+	RoleConsistency  bool       `gorm:"not null;default:false;comment:'场景图片是否只注入出现的角色并保持外貌一致'"`
+	ImageProvider    string     `gorm:"size:20;comment:'场景图片使用的生成服务，为空时使用配置的默认服务'"`
+	Attempts         int        `gorm:"comment:'当前处理阶段的失败次数，进入下一阶段时清零'"`
+	LastError        string     `gorm:"size:500;comment:'最近一次失败原因'"`
+	NextAttemptAt    *time.Time `gorm:"comment:'下次重试时间，为空表示立即处理'"`
+	FailedStage      string     `gorm:"size:20;comment:'永久失败时所处的处理阶段 role|scene|image，重新入队时从该阶段继续'"`
+	CreatedAt        time.Time  `gorm:"comment:'创建时间'"`
+	UpdatedAt        time.Time  `gorm:"index:idx_document_updated_at;comment:'更新时间'"`
+
+	// IngestReport 上传时的原文统计和质量检查报告，文档列表和详情只返回警告数
+	IngestReport api.IngestReport `gorm:"type:json;serializer:json;comment:'原文统计和质量检查报告'"`
+	// NameKey 归一化后的文档名，用于判断重名，历史文档为空，由回填阶段 name_key 补齐
+	NameKey string `gorm:"index:idx_document_name_key;size:128;comment:'归一化后的文档名'"`
+	// ImageStyle 文档级的场景图片风格，生成时合并到每个场景的图片请求
+	ImageStyle api.ImageStyle `gorm:"type:json;serializer:json;comment:'场景图片的风格预设、额外提示词和反向提示词'"`
+	// AspectRatio、ImageSize 文档级的场景图片画面比例和分辨率，场景指定的分辨率优先
+	AspectRatio string `gorm:"size:10;comment:'场景图片的画面比例 16:9|9:16|1:1'"`
+	ImageSize   string `gorm:"size:20;comment:'场景图片的分辨率 宽*高，优先于画面比例'"`
+	// Stages 各处理阶段的开始和完成时间，随状态变化更新，用于展示处理进度
+	Stages StageTimes `gorm:"type:json;serializer:json;comment:'各处理阶段的开始和完成时间'"`
+	// ContentHash 上传文件的 sha256，用于识别同一租户重复上传的内容，历史文档为空
+	ContentHash string `gorm:"index:idx_document_content_hash;size:64;comment:'上传文件的 sha256'"`
+	// AutoRegenerate 编辑场景描述使媒体过期后，是否自动回到图片生成阶段重新生成
+	AutoRegenerate bool `gorm:"not null;default:false;comment:'场景媒体过期后是否自动重新生成'"`
+	// VoiceProsody 文档级的场景语音参数，场景级参数优先
+	VoiceProsody api.VoiceProsody `gorm:"type:json;serializer:json;comment:'场景语音的语速、音调和情感风格'"`
+	// BGMID 视频合成时叠加在旁白下的背景音乐，曲目删除后不再使用
+	BGMID string `gorm:"column:bgm_id;size:32;comment:'背景音乐曲目 id，为空时不使用背景音乐'"`
+	// Synopsis、PlotPoints 由章节摘要归纳的全书梗概和关键情节，通过 :summarize 生成
+	Synopsis   string   `gorm:"size:2000;comment:'全书梗概'"`
+	PlotPoints []string `gorm:"type:json;serializer:json;comment:'关键情节'"`
+}
+
+// DocumentNameKey 文档名的归一化形式：去掉首尾空白，全角字符转半角，转为小写
+func DocumentNameKey(name string) string {
+	return strings.ToLower(width.Fold.String(strings.TrimSpace(name)))
 }
 
 func (Document) TableName() string {
@@ -40,14 +120,19 @@ func (Document) TableName() string {
 
 // Chapter 章节表
 type Chapter struct {
-	ID         string    `gorm:"primaryKey;size:32;comment:'主键'"`
-	Index      int       `gorm:"uniqueIndex:uk_document_index,priority:2;comment:'章节序号'"`
-	DocumentID string    `gorm:"uniqueIndex:uk_document_index,priority:1;size:32;comment:'文档 id'"`
-	Title      string    `gorm:"size:100;comment:'标题'"`
-	Content    string    `gorm:"size:10000;comment:'章节内容'"`
-	SceneIDs   []string  `gorm:"type:json;serializer:json;comment:'故事场景'"`
-	CreatedAt  time.Time `gorm:"comment:'创建时间'"`
-	UpdatedAt  time.Time `gorm:"comment:'更新时间'"`
+	ID           string    `gorm:"primaryKey;size:32;comment:'主键'"`
+	Index        int       `gorm:"uniqueIndex:uk_document_index,priority:2;comment:'章节序号'"`
+	DocumentID   string    `gorm:"uniqueIndex:uk_document_index,priority:1;index:idx_chapter_document_updated_at,priority:1;size:32;comment:'文档 id'"`
+	Title        string    `gorm:"size:100;comment:'标题'"`
+	Content      string    `gorm:"size:10000;comment:'章节内容'"`
+	SceneIDs     []string  `gorm:"type:json;serializer:json;comment:'故事场景'"`
+	CoverSceneID string    `gorm:"size:32;comment:'手动选择的封面场景 id，为空时自动选择'"`
+	CoverURL     string    `gorm:"size:500;comment:'封面图片url'"`
+	DuplicateOf  string    `gorm:"size:32;comment:'上传时检测到的高度相似的前文章节 id，修改内容后清除'"`
+	Similarity   float64   `gorm:"comment:'与 duplicate_of 章节的相似度'"`
+	Summary      string    `gorm:"size:1000;comment:'章节摘要，修改内容后清除'"`
+	CreatedAt    time.Time `gorm:"comment:'创建时间'"`
+	UpdatedAt    time.Time `gorm:"index:idx_chapter_document_updated_at,priority:2;comment:'更新时间'"`
 }
 
 func (Chapter) TableName() string {
@@ -56,15 +141,34 @@ func (Chapter) TableName() string {
 
 // Scene 场景表
 type Scene struct {
-	ID         string    `gorm:"primaryKey;size:32;comment:'主键'"`
-	ChapterID  string    `gorm:"index:idx_chapter_id;size:32;comment:'chapter id'"`
-	DocumentID string    `gorm:"index:idx_document_id;size:32;comment:'文档 id'"`
-	Index      int       `gorm:"comment:'场景序号'"`
-	Content    string    `gorm:"size:1000;comment:'场景描述'"`
-	ImageURL   string    `gorm:"size:500;comment:'场景图片url'"`
-	VoiceURL   string    `gorm:"size:500;comment:'音频url'"`
-	CreatedAt  time.Time `gorm:"comment:'创建时间'"`
-	UpdatedAt  time.Time `gorm:"comment:'更新时间'"`
+	ID                string             `gorm:"primaryKey;size:32;comment:'主键'"`
+	ChapterID         string             `gorm:"index:idx_chapter_id;index:idx_scene_chapter_updated_at,priority:1;size:32;comment:'chapter id'"`
+	DocumentID        string             `gorm:"index:idx_document_id;index:idx_scene_document_updated_at,priority:1;size:32;comment:'文档 id'"`
+	Index             int                `gorm:"comment:'场景序号'"`
+	Content           string             `gorm:"size:1000;comment:'场景描述'"`
+	ImageURL          string             `gorm:"size:500;comment:'场景图片url'"`
+	VoiceURL          string             `gorm:"size:500;comment:'音频url'"`
+	SubtitleURL       string             `gorm:"size:500;comment:'与语音对齐的字幕url'"`
+	Status            string             `gorm:"size:20;comment:'状态 ready|failed|blocked'"`
+	Attempts          int                `gorm:"comment:'生成失败次数'"`
+	Error             string             `gorm:"size:500;comment:'最近一次失败原因'"`
+	Placeholder       bool               `gorm:"comment:'图片和语音是否为占位媒体'"`
+	MediaStale        bool               `gorm:"not null;default:false;comment:'场景描述修改后图片和语音是否已过期，重新生成成功后清除'"`
+	AudioDurationMs   int64              `gorm:"comment:'语音时长（毫秒）'"`
+	DisplayDurationMs int64              `gorm:"comment:'建议展示时长（毫秒）'"`
+	Overrides         api.SceneOverrides `gorm:"type:json;serializer:json;comment:'场景级生成参数，覆盖默认配置'"`
+	HDImageURL        string             `gorm:"size:500;comment:'超分得到的高清图片url'"`
+	HDSourceURL       string             `gorm:"size:500;comment:'高清图片对应的原图url，与 image_url 不一致时高清图片已失效'"`
+	ModerationReason  string             `gorm:"size:500;comment:'未通过内容审核的原因'"`
+	HeldImageURL      string             `gorm:"size:500;comment:'未通过审核而扣留的生成图片url'"`
+	ModerationPassed  bool               `gorm:"comment:'管理员复核放行，重新生成时跳过内容审核'"`
+	// Location、TimeOfDay、Mood、CharactersPresent 场景拆分时提取的结构化属性，用于图片提示词和筛选
+	Location          string    `gorm:"size:100;comment:'地点'"`
+	TimeOfDay         string    `gorm:"size:20;comment:'时间段 dawn|morning|noon|afternoon|evening|night'"`
+	Mood              string    `gorm:"size:50;comment:'氛围'"`
+	CharactersPresent []string  `gorm:"type:json;serializer:json;comment:'出场人物'"`
+	CreatedAt         time.Time `gorm:"comment:'创建时间'"`
+	UpdatedAt         time.Time `gorm:"index:idx_scene_chapter_updated_at,priority:2;index:idx_scene_document_updated_at,priority:2;comment:'更新时间'"`
 }
 
 func (Scene) TableName() string {
@@ -73,14 +177,15 @@ func (Scene) TableName() string {
 
 // Role 任务角色表
 type Role struct {
-	ID         string    `gorm:"primaryKey;size:32;comment:'主键'"`
-	DocumentID string    `gorm:"index:idx_role_document_id;size:32;comment:'文档 id'"`
-	Name       string    `gorm:"size:50;comment:'角色名字'"`
-	Gender     string    `gorm:"size:10;comment:'性别'"`
-	Character  string    `gorm:"size:500;comment:'性格特点'"`
-	Appearance string    `gorm:"size:500;comment:'外貌描述'"`
-	CreatedAt  time.Time `gorm:"comment:'创建时间'"`
-	UpdatedAt  time.Time `gorm:"comment:'更新时间'"`
+	ID          string    `gorm:"primaryKey;size:32;comment:'主键'"`
+	DocumentID  string    `gorm:"index:idx_role_document_id;size:32;comment:'文档 id'"`
+	Name        string    `gorm:"size:50;comment:'角色名字'"`
+	Gender      string    `gorm:"size:10;comment:'性别'"`
+	Character   string    `gorm:"size:500;comment:'性格特点'"`
+	Appearance  string    `gorm:"size:500;comment:'外貌描述'"`
+	PortraitURL string    `gorm:"size:500;comment:'参考立绘url'"`
+	CreatedAt   time.Time `gorm:"comment:'创建时间'"`
+	UpdatedAt   time.Time `gorm:"comment:'更新时间'"`
 }
 
 // ===== Document DAO =====
@@ -91,31 +196,141 @@ func (db *Database) CreateDocument(ctx context.Context, docID, fileID string, ar
 		ID:        docID,
 		FileID:    fileID,
 		Name:      args.Name,
+		UserID:    args.UserID,
+		FileSize:  args.FileSize,
+		Priority:  args.Priority,
 		Status:    DocumentStatusChapterReady,
 		CreatedAt: now,
 		UpdatedAt: now,
 	}
+	doc.IngestReport = args.IngestReport
+	doc.NameKey = DocumentNameKey(args.Name)
+	doc.ContentHash = args.ContentHash
+	// 章节在创建文档前切分，开始时间由调用方记录
+	doc.Stages = StageTimes{}
+	if !args.SplitStartedAt.IsZero() {
+		doc.Stages[StageSplitting] = StageTime{StartedAt: &args.SplitStartedAt}
+	}
+	doc.Stages = doc.Stages.advance(doc.Status, now)
 	if err := gorm.G[Document](db.db).Create(ctx, &doc); err != nil {
 		return nil, err
 	}
 	return &doc, nil
 }
 
+// ImportDocument 在一个事务中创建导入的文档及其章节、角色和场景，并添加文档的索引任务。
+// 各记录的 id 和引用关系由调用方生成，文档的阶段时间按状态补齐
+func (db *Database) ImportDocument(ctx context.Context, doc *Document, chapters []Chapter, roles []Role, scenes []Scene) error {
+	now := time.Now()
+	doc.CreatedAt, doc.UpdatedAt = now, now
+	doc.NameKey = DocumentNameKey(doc.Name)
+	doc.Stages = StageTimes{}.advance(doc.Status, now)
+	return db.db.WithContext(ctx).Transaction(func(tx *gorm.DB) error {
+		if err := gorm.G[Document](tx).Create(ctx, doc); err != nil {
+			return err
+		}
+		if len(chapters) > 0 {
+			if err := gorm.G[Chapter](tx).CreateInBatches(ctx, &chapters, batchSize); err != nil {
+				return err
+			}
+		}
+		if len(roles) > 0 {
+			if err := gorm.G[Role](tx).CreateInBatches(ctx, &roles, batchSize); err != nil {
+				return err
+			}
+		}
+		if len(scenes) > 0 {
+			if err := gorm.G[Scene](tx).CreateInBatches(ctx, &scenes, batchSize); err != nil {
+				return err
+			}
+		}
+		return gorm.G[IndexTask](tx).Create(ctx, documentIndexTask(doc.ID))
+	})
+}
+
 func (db *Database) GetDocument(ctx context.Context, id string) (Document, error) {
 	return gorm.G[Document](db.db).Where("id = ?", id).Take(ctx)
 }
 
+// GetDocumentWithName 按归一化后的名称获取文档，尚未回填 NameKey 的历史文档按原名匹配
 func (db *Database) GetDocumentWithName(ctx context.Context, name string) (Document, error) {
-	return gorm.G[Document](db.db).Where("name = ?", name).Take(ctx)
+	return gorm.G[Document](db.db).Where("name_key = ? OR name = ?", DocumentNameKey(name), name).Take(ctx)
+}
+
+// GetDocumentWithContentHash 获取租户下上传内容相同的文档，有多个时返回最早创建的
+func (db *Database) GetDocumentWithContentHash(ctx context.Context, userID int64, hash string) (Document, error) {
+	return gorm.G[Document](db.db).Where("user_id = ? AND content_hash = ?", userID, hash).Order("created_at").First(ctx)
+}
+
+// ListExistingDocumentNameKeys 返回 names 中已被文档使用的名称，以归一化形式返回
+func (db *Database) ListExistingDocumentNameKeys(ctx context.Context, names []string) ([]string, error) {
+	keys := make([]string, 0, len(names))
+	for _, name := range names {
+		keys = append(keys, DocumentNameKey(name))
+	}
+	var existing []string
+	err := db.db.WithContext(ctx).Model(&Document{}).Where("name_key IN ? OR name IN ?", keys, names).Pluck("name", &existing).Error
+	if err != nil {
+		return nil, err
+	}
+	for i, name := range existing {
+		existing[i] = DocumentNameKey(name)
+	}
+	return existing, nil
+}
+
+// UpdateDocumentNameKey 回填历史文档的 NameKey
+func (db *Database) UpdateDocumentNameKey(ctx context.Context, id string) error {
+	doc, err := db.GetDocument(ctx, id)
+	if err != nil {
+		return err
+	}
+	_, err = gorm.G[Document](db.db).Where("id = ?", id).Update(ctx, "name_key", DocumentNameKey(doc.Name))
+	return err
 }
 
 func (db *Database) UpdateDocument(ctx context.Context, id string, args *api.UpdateDocumentArgs) error {
 	now := time.Now()
 	doc := Document{
 		Name:      args.Name,
+		NameKey:   DocumentNameKey(args.Name),
 		UpdatedAt: now,
 	}
-	rowsAffected, err := gorm.G[Document](db.db).Where("id = ?", id).Updates(ctx, doc)
+	// RoleConsistency、ImageProvider、ImageStyle、AspectRatio、ImageSize、AutoRegenerate、VoiceProsody、BGMID 为空时不更新，零值也需要显式指定列才会写入
+	columns := []any{"name_key", "updated_at"}
+	if args.RoleConsistency != nil {
+		doc.RoleConsistency = *args.RoleConsistency
+		columns = append(columns, "role_consistency")
+	}
+	if args.ImageProvider != nil {
+		doc.ImageProvider = *args.ImageProvider
+		columns = append(columns, "image_provider")
+	}
+	if args.ImageStyle != nil {
+		doc.ImageStyle = *args.ImageStyle
+		columns = append(columns, "image_style")
+	}
+	if args.AspectRatio != nil {
+		doc.AspectRatio = *args.AspectRatio
+		columns = append(columns, "aspect_ratio")
+	}
+	if args.ImageSize != nil {
+		doc.ImageSize = *args.ImageSize
+		columns = append(columns, "image_size")
+	}
+	if args.AutoRegenerate != nil {
+		doc.AutoRegenerate = *args.AutoRegenerate
+		columns = append(columns, "auto_regenerate")
+	}
+	if args.VoiceProsody != nil {
+		doc.VoiceProsody = *args.VoiceProsody
+		columns = append(columns, "voice_prosody")
+	}
+	if args.BGMID != nil {
+		doc.BGMID = *args.BGMID
+		columns = append(columns, "bgm_id")
+	}
+	rowsAffected, err := gorm.G[Document](db.db).Where("id = ?", id).Select("name", columns...).Updates(ctx, doc)
 	if err != nil {
 		return err
 	}
@@ -125,26 +340,274 @@ func (db *Database) UpdateDocument(ctx context.Context, id string, args *api.Upd
 	return nil
 }
 
+var (
+	// ErrDocumentCanceled 文档已取消处理，状态不再变化
+	ErrDocumentCanceled = errors.New("document canceled")
+	// ErrDocumentFinished 文档已处理结束
+	ErrDocumentFinished = errors.New("document processing has finished")
+)
+
+// UpdateDocumentStatus 更新文档状态和各阶段的开始、完成时间，同时清空上一阶段的重试记录。
+// 已取消的文档返回 ErrDocumentCanceled，避免取消前已完成的处理步骤覆盖取消状态
 func (db *Database) UpdateDocumentStatus(ctx context.Context, id string, status string) error {
-	rowsAffected, err := gorm.G[Document](db.db).Where("id = ?", id).Update(ctx, "status", status)
-	if err != nil {
+	return db.db.WithContext(ctx).Transaction(func(tx *gorm.DB) error {
+		doc, err := gorm.G[Document](tx).Select("id", "status", "stages").Where("id = ?", id).Take(ctx)
+		if err != nil {
+			return err
+		}
+		if doc.Status == DocumentStatusCanceled {
+			return ErrDocumentCanceled
+		}
+		doc = Document{Status: status, Stages: doc.Stages.advance(status, time.Now())}
+		_, err = gorm.G[Document](tx).Where("id = ?", id).
+			Select("status", "attempts", "last_error", "next_attempt_at", "failed_stage", "stages").Updates(ctx, doc)
 		return err
+	})
+}
+
+// UpdateDocumentAttempt 记录文档当前阶段的失败次数和原因，nextAttemptAt 之前不再处理该文档
+// UpdateDocumentPaused 暂停或恢复文档处理，恢复时立即处理，不再等待重试时间
+func (db *Database) UpdateDocumentPaused(ctx context.Context, id string, paused bool) error {
+	updates := map[string]interface{}{"paused": paused}
+	if !paused {
+		updates["next_attempt_at"] = nil
 	}
-	if rowsAffected == 0 {
+	return db.db.WithContext(ctx).Model(&Document{}).Where("id = ?", id).Updates(updates).Error
+}
+
+// CancelDocument 取消文档处理，已处理结束的文档返回 ErrDocumentFinished。
+// 保留阶段时间和已生成的场景，未完成的阶段即为取消时所处的阶段
+func (db *Database) CancelDocument(ctx context.Context, id string) error {
+	result := db.db.WithContext(ctx).Model(&Document{}).Where("id = ? AND status NOT IN ?", id, DocumentFinishedStatuses).Updates(map[string]interface{}{
+		"status":          DocumentStatusCanceled,
+		"next_attempt_at": nil,
+	})
+	if result.Error != nil {
+		return result.Error
+	}
+	if result.RowsAffected == 0 {
+		if _, err := db.GetDocument(ctx, id); err != nil {
+			return err
+		}
+		return ErrDocumentFinished
+	}
+	return nil
+}
+
+func (db *Database) UpdateDocumentAttempt(ctx context.Context, id string, attempts int, lastError string, nextAttemptAt time.Time) error {
+	result := db.db.WithContext(ctx).Model(&Document{}).Where("id = ?", id).Updates(map[string]interface{}{
+		"attempts":        attempts,
+		"last_error":      lastError,
+		"next_attempt_at": nextAttemptAt,
+	})
+	if result.Error != nil {
+		return result.Error
+	}
+	if result.RowsAffected == 0 {
 		return gorm.ErrRecordNotFound
 	}
 	return nil
 }
 
-func (db *Database) DeleteDocument(ctx context.Context, id string) error {
-	_, err := gorm.G[Document](db.db).Where("id = ?", id).Delete(ctx)
-	return err
+// DeleteDocument 在一个事务中删除文档及其章节、场景、生成记录、图片版本、角色、处理日志、章节向量、阅读记录、
+// 处理记录和导出任务，返回被删除数据引用的媒体 url，由调用方在提交后清理不再被引用的文件和导出产物
+func (db *Database) DeleteDocument(ctx context.Context, id string) ([]string, error) {
+	var mediaURLs []string
+	err := db.db.WithContext(ctx).Transaction(func(tx *gorm.DB) error {
+		urls, err := documentMediaURLs(ctx, tx, id)
+		if err != nil {
+			return err
+		}
+		sceneIDs := tx.Model(&Scene{}).Select("id").Where("document_id = ?", id)
+		if _, err = gorm.G[SceneGeneration](tx).Where("scene_id IN (?)", sceneIDs).Delete(ctx); err != nil {
+			return err
+		}
+		if _, err = gorm.G[SceneImageVersion](tx).Where("scene_id IN (?)", sceneIDs).Delete(ctx); err != nil {
+			return err
+		}
+		if _, err = gorm.G[Scene](tx).Where("document_id = ?", id).Delete(ctx); err != nil {
+			return err
+		}
+		if _, err = gorm.G[Chapter](tx).Where("document_id = ?", id).Delete(ctx); err != nil {
+			return err
+		}
+		if _, err = gorm.G[Role](tx).Where("document_id = ?", id).Delete(ctx); err != nil {
+			return err
+		}
+		if _, err = gorm.G[RoleRelation](tx).Where("document_id = ?", id).Delete(ctx); err != nil {
+			return err
+		}
+		if _, err = gorm.G[DocumentLog](tx).Where("document_id = ?", id).Delete(ctx); err != nil {
+			return err
+		}
+		if _, err = gorm.G[ChapterEmbedding](tx).Where("document_id = ?", id).Delete(ctx); err != nil {
+			return err
+		}
+		if _, err = gorm.G[ChapterTranslation](tx).Where("document_id = ?", id).Delete(ctx); err != nil {
+			return err
+		}
+		if _, err = gorm.G[DocumentTranslation](tx).Where("document_id = ?", id).Delete(ctx); err != nil {
+			return err
+		}
+		if _, err = gorm.G[Bookmark](tx).Where("document_id = ?", id).Delete(ctx); err != nil {
+			return err
+		}
+		if _, err = gorm.G[ReadPosition](tx).Where("document_id = ?", id).Delete(ctx); err != nil {
+			return err
+		}
+		runIDs := tx.Model(&DocumentRun{}).Select("id").Where("document_id = ?", id)
+		if _, err = gorm.G[RunScene](tx).Where("run_id IN (?)", runIDs).Delete(ctx); err != nil {
+			return err
+		}
+		if _, err = gorm.G[DocumentRun](tx).Where("document_id = ?", id).Delete(ctx); err != nil {
+			return err
+		}
+		if _, err = gorm.G[Export](tx).Where("document_id = ?", id).Delete(ctx); err != nil {
+			return err
+		}
+		if _, err = gorm.G[Document](tx).Where("id = ?", id).Delete(ctx); err != nil {
+			return err
+		}
+		// 文档级索引任务删除文档的全部搜索条目
+		if err = gorm.G[IndexTask](tx).Create(ctx, documentIndexTask(id)); err != nil {
+			return err
+		}
+		mediaURLs = urls
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	return mediaURLs, nil
+}
+
+// documentMediaURLs 返回文档封面、章节封面、角色立绘、场景、生成记录和处理记录引用的媒体 url，已去重
+func documentMediaURLs(ctx context.Context, tx *gorm.DB, id string) ([]string, error) {
+	var urls []string
+	docs, err := gorm.G[Document](tx).Select("summary_image_url").Where("id = ?", id).Find(ctx)
+	if err != nil {
+		return nil, err
+	}
+	for _, doc := range docs {
+		urls = append(urls, doc.SummaryImageURL)
+	}
+	chapters, err := gorm.G[Chapter](tx).Select("cover_url").Where("document_id = ?", id).Find(ctx)
+	if err != nil {
+		return nil, err
+	}
+	for _, ch := range chapters {
+		urls = append(urls, ch.CoverURL)
+	}
+	runScenes, err := gorm.G[RunScene](tx).Select("image_url", "voice_url").
+		Where("run_id IN (?)", tx.Model(&DocumentRun{}).Select("id").Where("document_id = ?", id)).Find(ctx)
+	if err != nil {
+		return nil, err
+	}
+	for _, rs := range runScenes {
+		urls = append(urls, rs.ImageURL, rs.VoiceURL)
+	}
+	roles, err := gorm.G[Role](tx).Select("portrait_url").Where("document_id = ?", id).Find(ctx)
+	if err != nil {
+		return nil, err
+	}
+	for _, role := range roles {
+		urls = append(urls, role.PortraitURL)
+	}
+	scenes, err := gorm.G[Scene](tx).Select("id", "image_url", "voice_url", "subtitle_url", "hd_image_url", "held_image_url").Where("document_id = ?", id).Find(ctx)
+	if err != nil {
+		return nil, err
+	}
+	sceneIDs := make([]string, 0, len(scenes))
+	for _, scene := range scenes {
+		sceneIDs = append(sceneIDs, scene.ID)
+		urls = append(urls, scene.ImageURL, scene.VoiceURL, scene.SubtitleURL, scene.HDImageURL, scene.HeldImageURL)
+	}
+	if len(sceneIDs) > 0 {
+		gens, err := gorm.G[SceneGeneration](tx).Select("image_url", "voice_url").Where("scene_id IN ?", sceneIDs).Find(ctx)
+		if err != nil {
+			return nil, err
+		}
+		for _, gen := range gens {
+			urls = append(urls, gen.ImageURL, gen.VoiceURL)
+		}
+		versions, err := gorm.G[SceneImageVersion](tx).Select("image_url").Where("scene_id IN ?", sceneIDs).Find(ctx)
+		if err != nil {
+			return nil, err
+		}
+		for _, v := range versions {
+			urls = append(urls, v.ImageURL)
+		}
+	}
+
+	slices.Sort(urls)
+	urls = slices.Compact(urls)
+	return slices.DeleteFunc(urls, func(url string) bool { return url == "" }), nil
+}
+
+// ListReferencedMediaURLs 返回 urls 中仍被文档封面、章节封面、角色立绘、场景、生成记录、图片版本或处理记录引用的媒体 url。
+// 媒体按内容去重，不同文档可能引用同一文件
+func (db *Database) ListReferencedMediaURLs(ctx context.Context, urls []string) ([]string, error) {
+	if len(urls) == 0 {
+		return nil, nil
+	}
+	refs := []struct {
+		model   any
+		columns []string
+	}{
+		{&Document{}, []string{"summary_image_url"}},
+		{&Chapter{}, []string{"cover_url"}},
+		{&Role{}, []string{"portrait_url"}},
+		{&Scene{}, []string{"image_url", "voice_url", "subtitle_url", "hd_image_url", "held_image_url"}},
+		{&SceneGeneration{}, []string{"image_url", "voice_url"}},
+		{&SceneImageVersion{}, []string{"image_url"}},
+		{&RunScene{}, []string{"image_url", "voice_url"}},
+	}
+	var referenced []string
+	for _, ref := range refs {
+		for _, column := range ref.columns {
+			var found []string
+			err := db.db.WithContext(ctx).Model(ref.model).Where(column+" IN ?", urls).Distinct().Pluck(column, &found).Error
+			if err != nil {
+				return nil, err
+			}
+			referenced = append(referenced, found...)
+		}
+	}
+	slices.Sort(referenced)
+	return slices.Compact(referenced), nil
 }
 
 func (db *Database) ListDocuments(ctx context.Context) ([]Document, error) {
 	return gorm.G[Document](db.db).Order("updated_at DESC").Find(ctx)
 }
 
+// ListDocumentsUpdatedSince 列取 since 及之后更新过的文档，用于增量同步
+func (db *Database) ListDocumentsUpdatedSince(ctx context.Context, since time.Time) ([]Document, error) {
+	return gorm.G[Document](db.db).Where("updated_at >= ?", since).Order("updated_at DESC").Find(ctx)
+}
+
+// ListDocumentsByFilter 按过滤条件列取文档并在数据库中排序，排序字段相同时按 id 排序保证顺序稳定
+func (db *Database) ListDocumentsByFilter(ctx context.Context, filter DocumentFilter) ([]Document, error) {
+	q := db.db.WithContext(ctx).Model(&Document{})
+	if !filter.UpdatedSince.IsZero() {
+		q = q.Where("updated_at >= ?", filter.UpdatedSince)
+	}
+	if len(filter.Statuses) > 0 {
+		q = q.Where("status IN ?", filter.Statuses)
+	}
+	sortBy := DocumentSortUpdatedAt
+	if slices.Contains(DocumentSortFields, filter.SortBy) {
+		sortBy = filter.SortBy
+	}
+	dir := "DESC"
+	if filter.Asc {
+		dir = "ASC"
+	}
+	var docs []Document
+	err := q.Order(sortBy + " " + dir).Order("id " + dir).Find(&docs).Error
+	return docs, err
+}
+
 func (db *Database) UpdateDocumentFileID(ctx context.Context, id string, fileID string) error {
 	rowsAffected, err := gorm.G[Document](db.db).Where("id = ?", id).Update(ctx, "file_id", fileID)
 	if err != nil {
@@ -156,6 +619,16 @@ func (db *Database) UpdateDocumentFileID(ctx context.Context, id string, fileID
 	return nil
 }
 
+// ListReferencedFileIDs 返回 fileIDs 中仍被文档引用的百炼文件 id
+func (db *Database) ListReferencedFileIDs(ctx context.Context, fileIDs []string) ([]string, error) {
+	if len(fileIDs) == 0 {
+		return nil, nil
+	}
+	var ids []string
+	err := db.db.WithContext(ctx).Model(&Document{}).Where("file_id IN ?", fileIDs).Distinct().Pluck("file_id", &ids).Error
+	return ids, err
+}
+
 func (db *Database) UpdateDocumentSummary(ctx context.Context, id string, summary string) error {
 	rowsAffected, err := gorm.G[Document](db.db).Where("id = ?", id).Update(ctx, "summary", summary)
 	if err != nil {
@@ -167,6 +640,19 @@ func (db *Database) UpdateDocumentSummary(ctx context.Context, id string, summar
 	return nil
 }
 
+// UpdateDocumentSynopsis 保存全书梗概和关键情节
+func (db *Database) UpdateDocumentSynopsis(ctx context.Context, id, synopsis string, plotPoints []string) error {
+	result := db.db.WithContext(ctx).Model(&Document{}).Where("id = ?", id).
+		Select("synopsis", "plot_points").Updates(&Document{Synopsis: synopsis, PlotPoints: plotPoints})
+	if result.Error != nil {
+		return result.Error
+	}
+	if result.RowsAffected == 0 {
+		return gorm.ErrRecordNotFound
+	}
+	return nil
+}
+
 func (db *Database) UpdateDocumentSummaryImageURL(ctx context.Context, id string, imageURL string) error {
 	rowsAffected, err := gorm.G[Document](db.db).Where("id = ?", id).Update(ctx, "summary_image_url", imageURL)
 	if err != nil {
@@ -178,16 +664,45 @@ func (db *Database) UpdateDocumentSummaryImageURL(ctx context.Context, id string
 	return nil
 }
 
+func (db *Database) UpdateDocumentSceneStats(ctx context.Context, id string, sceneCount, failedSceneCount int) error {
+	result := db.db.WithContext(ctx).Model(&Document{}).Where("id = ?", id).Updates(map[string]interface{}{
+		"scene_count":        sceneCount,
+		"failed_scene_count": failedSceneCount,
+	})
+	if result.Error != nil {
+		return result.Error
+	}
+	if result.RowsAffected == 0 {
+		return gorm.ErrRecordNotFound
+	}
+	return nil
+}
+
 func (db *Database) ListChapterReadyDocuments(ctx context.Context) ([]Document, error) {
-	return gorm.G[Document](db.db).Where("status = ?", DocumentStatusChapterReady).Order("created_at ASC").Find(ctx)
+	return db.listDueDocuments(ctx, DocumentStatusChapterReady)
 }
 
 func (db *Database) ListRoleReadyDocuments(ctx context.Context) ([]Document, error) {
-	return gorm.G[Document](db.db).Where("status = ?", DocumentStatusRoleReady).Order("created_at ASC").Find(ctx)
+	return db.listDueDocuments(ctx, DocumentStatusRoleReady)
 }
 
 func (db *Database) ListSceneReadyDocuments(ctx context.Context) ([]Document, error) {
-	return gorm.G[Document](db.db).Where("status = ?", DocumentStatusSceneReady).Order("created_at ASC").Find(ctx)
+	return db.listDueDocuments(ctx, DocumentStatusSceneReady)
+}
+
+// listDueDocuments 列取处于 status 状态、未暂停且已到重试时间的文档
+func (db *Database) listDueDocuments(ctx context.Context, status string) ([]Document, error) {
+	return gorm.G[Document](db.db).
+		Where("status = ? AND NOT paused AND (next_attempt_at IS NULL OR next_attempt_at <= ?)", status, time.Now()).
+		Order("priority DESC, created_at ASC").Find(ctx)
+}
+
+// HasDueDocumentAbove 是否有处于 status 状态、未暂停、已到重试时间且优先级高于 priority 的文档
+func (db *Database) HasDueDocumentAbove(ctx context.Context, status string, priority int) (bool, error) {
+	n, err := gorm.G[Document](db.db).
+		Where("status = ? AND priority > ? AND NOT paused AND (next_attempt_at IS NULL OR next_attempt_at <= ?)", status, priority, time.Now()).
+		Count(ctx, "*")
+	return n > 0, err
 }
 
 // ===== Chapter DAO =====
@@ -213,13 +728,19 @@ func (db *Database) GetChapter(ctx context.Context, id, documentID string) (Chap
 	return gorm.G[Chapter](db.db).Where("id = ? AND document_id = ?", id, documentID).Take(ctx)
 }
 
+// GetChapterByID 按 id 获取章节，用于只知道章节 id 的接口
+func (db *Database) GetChapterByID(ctx context.Context, id string) (Chapter, error) {
+	return gorm.G[Chapter](db.db).Where("id = ?", id).Take(ctx)
+}
+
+// UpdateChapter 修改章节内容，内容变化后不再标记为相似章节
 func (db *Database) UpdateChapter(ctx context.Context, id string, args *api.UpdateChapterArgs) error {
 	now := time.Now()
 	seg := Chapter{
 		Content:   args.Content,
 		UpdatedAt: now,
 	}
-	rowsAffected, err := gorm.G[Chapter](db.db).Where("id = ?", id).Updates(ctx, seg)
+	rowsAffected, err := gorm.G[Chapter](db.db).Where("id = ?", id).Select("content", "duplicate_of", "similarity", "summary", "updated_at").Updates(ctx, seg)
 	if err != nil {
 		return err
 	}
@@ -243,6 +764,161 @@ func (db *Database) ListChapters(ctx context.Context, documentID string) ([]Chap
 	return gorm.G[Chapter](db.db).Where("document_id = ?", documentID).Order("`index` ASC").Find(ctx)
 }
 
+// ListChaptersUpdatedSince 列取文档中 since 及之后更新过的章节
+func (db *Database) ListChaptersUpdatedSince(ctx context.Context, documentID string, since time.Time) ([]Chapter, error) {
+	return gorm.G[Chapter](db.db).Where("document_id = ? AND updated_at >= ?", documentID, since).Order("`index` ASC").Find(ctx)
+}
+
+// ReorderChapters 按 chapterIDs 的顺序重写章节序号，chapterIDs 需包含文档的全部章节；
+// 场景序号按新的章节顺序重新编号，章节内场景保持原有顺序。不匹配时返回 gorm.ErrRecordNotFound
+func (db *Database) ReorderChapters(ctx context.Context, documentID string, chapterIDs []string) error {
+	return db.db.WithContext(ctx).Transaction(func(tx *gorm.DB) error {
+		count, err := gorm.G[Chapter](tx).Where("document_id = ?", documentID).Count(ctx, "*")
+		if err != nil {
+			return err
+		}
+		if count != int64(len(chapterIDs)) {
+			return gorm.ErrRecordNotFound
+		}
+		// 先改为负数再改为目标序号，避免与 uk_document_index 冲突
+		for _, offset := range []int{-len(chapterIDs), 0} {
+			for i, id := range chapterIDs {
+				rowsAffected, err := gorm.G[Chapter](tx).Where("id = ? AND document_id = ?", id, documentID).Update(ctx, "index", i+offset)
+				if err != nil {
+					return err
+				}
+				if rowsAffected == 0 {
+					return gorm.ErrRecordNotFound
+				}
+			}
+		}
+
+		scenes, err := gorm.G[Scene](tx).Where("document_id = ?", documentID).Order("`index` ASC").Find(ctx)
+		if err != nil {
+			return err
+		}
+		byChapter := make(map[string][]Scene)
+		for _, scene := range scenes {
+			byChapter[scene.ChapterID] = append(byChapter[scene.ChapterID], scene)
+		}
+		index := 0
+		for _, id := range chapterIDs {
+			for _, scene := range byChapter[id] {
+				if scene.Index != index {
+					_, err = gorm.G[Scene](tx).Where("id = ?", scene.ID).Update(ctx, "index", index)
+					if err != nil {
+						return err
+					}
+				}
+				index++
+			}
+		}
+		return nil
+	})
+}
+
+// SplitChapter 将章节拆成两章：原章节内容改为 head，新章节内容为 tail，紧随原章节之后，后续章节序号加一；
+// 原章节按序号的前 keepScenes 个场景保留，其余场景移到新章节，场景序号不变。章节不存在时返回 gorm.ErrRecordNotFound
+func (db *Database) SplitChapter(ctx context.Context, documentID, id, head, tail string, keepScenes int) (Chapter, error) {
+	var created Chapter
+	err := db.db.WithContext(ctx).Transaction(func(tx *gorm.DB) error {
+		chapter, err := gorm.G[Chapter](tx).Where("id = ? AND document_id = ?", id, documentID).Take(ctx)
+		if err != nil {
+			return err
+		}
+		scenes, err := gorm.G[Scene](tx).Where("chapter_id = ?", id).Order("`index` ASC").Find(ctx)
+		if err != nil {
+			return err
+		}
+		keepScenes = min(keepScenes, len(scenes))
+
+		// 后续章节先改为负数再改为原序号加一，避免与 uk_document_index 冲突
+		_, err = gorm.G[Chapter](tx).Where("document_id = ? AND `index` > ?", documentID, chapter.Index).Update(ctx, "index", gorm.Expr("-`index` - 1"))
+		if err != nil {
+			return err
+		}
+		_, err = gorm.G[Chapter](tx).Where("document_id = ? AND `index` < 0", documentID).Update(ctx, "index", gorm.Expr("-`index`"))
+		if err != nil {
+			return err
+		}
+
+		now := time.Now()
+		var kept, moved []string
+		for i, scene := range scenes {
+			if i < keepScenes {
+				kept = append(kept, scene.ID)
+			} else {
+				moved = append(moved, scene.ID)
+			}
+		}
+		created = Chapter{
+			ID:         MakeUUID(),
+			Index:      chapter.Index + 1,
+			DocumentID: documentID,
+			Content:    tail,
+			SceneIDs:   moved,
+			CreatedAt:  now,
+			UpdatedAt:  now,
+		}
+		if err = gorm.G[Chapter](tx).Create(ctx, &created); err != nil {
+			return err
+		}
+		// 场景全部移走时 scene_ids 为空，需显式选择字段才会更新零值
+		err = tx.Model(&Chapter{}).Where("id = ?", id).Select("content", "scene_ids", "duplicate_of", "similarity", "summary", "updated_at").
+			Updates(Chapter{Content: head, SceneIDs: kept, UpdatedAt: now}).Error
+		if err != nil {
+			return err
+		}
+		if len(moved) == 0 {
+			return nil
+		}
+
+		// 移动的场景属于新章节，重建索引以更新搜索结果中的章节
+		_, err = gorm.G[Scene](tx).Where("id IN ?", moved).Updates(ctx, Scene{ChapterID: created.ID, UpdatedAt: now})
+		if err != nil {
+			return err
+		}
+		tasks := sceneIndexTasks(scenes[keepScenes:])
+		return gorm.G[IndexTask](tx).CreateInBatches(ctx, &tasks, batchSize)
+	})
+	return created, err
+}
+
+func (db *Database) CountChapters(ctx context.Context, documentID string) (int64, error) {
+	return gorm.G[Chapter](db.db).Where("document_id = ?", documentID).Count(ctx, "*")
+}
+
+// UpdateChapterCover 更新章节的封面场景和封面图片，coverSceneID 为空表示自动选择
+func (db *Database) UpdateChapterCover(ctx context.Context, id, coverSceneID, coverURL string) error {
+	result := db.db.WithContext(ctx).Model(&Chapter{}).Where("id = ?", id).Updates(map[string]any{
+		"cover_scene_id": coverSceneID,
+		"cover_url":      coverURL,
+		"updated_at":     time.Now(),
+	})
+	if result.Error != nil {
+		return result.Error
+	}
+	if result.RowsAffected == 0 {
+		return gorm.ErrRecordNotFound
+	}
+	return nil
+}
+
+// UpdateChapterSummary 保存章节摘要
+func (db *Database) UpdateChapterSummary(ctx context.Context, id, summary string) error {
+	result := db.db.WithContext(ctx).Model(&Chapter{}).Where("id = ?", id).Updates(map[string]any{
+		"summary":    summary,
+		"updated_at": time.Now(),
+	})
+	if result.Error != nil {
+		return result.Error
+	}
+	if result.RowsAffected == 0 {
+		return gorm.ErrRecordNotFound
+	}
+	return nil
+}
+
 func (db *Database) UpdateChapterSceneIDs(ctx context.Context, chapterID string, sceneIDs []string) error {
 	// GORM 使用 JSON tag 会自动序列化 []string
 	chapter := Chapter{
@@ -265,7 +941,70 @@ func (db *Database) CreateScenes(ctx context.Context, scenes []Scene) error {
 	if len(scenes) == 0 {
 		return nil
 	}
-	return gorm.G[Scene](db.db).CreateInBatches(ctx, &scenes, batchSize)
+	return db.db.WithContext(ctx).Transaction(func(tx *gorm.DB) error {
+		if err := gorm.G[Scene](tx).CreateInBatches(ctx, &scenes, batchSize); err != nil {
+			return err
+		}
+		tasks := sceneIndexTasks(scenes)
+		return gorm.G[IndexTask](tx).CreateInBatches(ctx, &tasks, batchSize)
+	})
+}
+
+// InsertScene 在 scene.ChapterID 章节中 afterID 场景之后插入场景，afterID 为空时插入到章节开头；
+// 文档中后续场景序号加一，scene 的 DocumentID 和 Index 由章节和插入位置决定。章节或 afterID 场景不存在时返回 gorm.ErrRecordNotFound
+func (db *Database) InsertScene(ctx context.Context, afterID string, scene *Scene) error {
+	return db.db.WithContext(ctx).Transaction(func(tx *gorm.DB) error {
+		chapter, err := gorm.G[Chapter](tx).Where("id = ?", scene.ChapterID).Take(ctx)
+		if err != nil {
+			return err
+		}
+
+		// 插入位置：afterID 场景之后；否则章节第一个场景的位置，空章节时为前面章节最后一个场景之后
+		var index int
+		if afterID != "" {
+			after, err := gorm.G[Scene](tx).Where("id = ? AND chapter_id = ?", afterID, chapter.ID).Take(ctx)
+			if err != nil {
+				return err
+			}
+			index = after.Index + 1
+		} else {
+			prev, err := gorm.G[Scene](tx).
+				Where("chapter_id IN (?)", tx.Model(&Chapter{}).Select("id").Where("document_id = ? AND `index` < ?", chapter.DocumentID, chapter.Index)).
+				Order("`index` DESC").Take(ctx)
+			if err == nil {
+				index = prev.Index + 1
+			} else if !errors.Is(err, gorm.ErrRecordNotFound) {
+				return err
+			}
+		}
+
+		_, err = gorm.G[Scene](tx).Where("document_id = ? AND `index` >= ?", chapter.DocumentID, index).Update(ctx, "index", gorm.Expr("`index` + 1"))
+		if err != nil {
+			return err
+		}
+		scene.DocumentID = chapter.DocumentID
+		scene.Index = index
+		if err = gorm.G[Scene](tx).Create(ctx, scene); err != nil {
+			return err
+		}
+
+		sceneIDs := make([]string, 0, len(chapter.SceneIDs)+1)
+		if afterID == "" {
+			sceneIDs = append(sceneIDs, scene.ID)
+		}
+		for _, id := range chapter.SceneIDs {
+			sceneIDs = append(sceneIDs, id)
+			if id == afterID {
+				sceneIDs = append(sceneIDs, scene.ID)
+			}
+		}
+		_, err = gorm.G[Chapter](tx).Where("id = ?", chapter.ID).Updates(ctx, Chapter{SceneIDs: sceneIDs, UpdatedAt: time.Now()})
+		if err != nil {
+			return err
+		}
+		tasks := sceneIndexTasks([]Scene{*scene})
+		return gorm.G[IndexTask](tx).CreateInBatches(ctx, &tasks, batchSize)
+	})
 }
 
 func (db *Database) GetScene(ctx context.Context, id string) (Scene, error) {
@@ -277,11 +1016,111 @@ func (db *Database) ListScenesByChapter(ctx context.Context, chapterID string) (
 }
 
 func (db *Database) ListScenesByDocument(ctx context.Context, documentID string) ([]Scene, error) {
-	return gorm.G[Scene](db.db).Where("document_id = ?", documentID).Order("chapter_id ASC, `index` ASC").Find(ctx)
+	return gorm.G[Scene](db.db).Where("document_id = ?", documentID).Order("`index` ASC").Find(ctx)
+}
+
+// ListScenesByChapterUpdatedSince 列取章节中 since 及之后更新过的场景
+func (db *Database) ListScenesByChapterUpdatedSince(ctx context.Context, chapterID string, since time.Time) ([]Scene, error) {
+	return gorm.G[Scene](db.db).Where("chapter_id = ? AND updated_at >= ?", chapterID, since).Order("`index` ASC").Find(ctx)
 }
 
+// ListScenesByDocumentUpdatedSince 列取文档中 since 及之后更新过的场景
+func (db *Database) ListScenesByDocumentUpdatedSince(ctx context.Context, documentID string, since time.Time) ([]Scene, error) {
+	return gorm.G[Scene](db.db).Where("document_id = ? AND updated_at >= ?", documentID, since).Order("chapter_id ASC, `index` ASC").Find(ctx)
+}
+
+// ListPendingImageScenes 列取未生成图片或媒体已过期，且未永久失败的场景
 func (db *Database) ListPendingImageScenes(ctx context.Context, documentID string) ([]Scene, error) {
-	return gorm.G[Scene](db.db).Where("document_id = ? AND (image_url = ? OR image_url IS NULL)", documentID, "").Order("`index` ASC").Find(ctx)
+	return gorm.G[Scene](db.db).
+		Where("document_id = ? AND (image_url = ? OR image_url IS NULL OR media_stale = ?) AND (status IS NULL OR status NOT IN ?)", documentID, "", true, []string{SceneStatusFailed, SceneStatusBlocked}).
+		Order("`index` ASC").Find(ctx)
+}
+
+// MarkScenesMediaStale 把已生成图片或语音的场景标记为媒体过期，由 DocumentMgr 在图片生成阶段重新生成，返回标记的场景数
+func (db *Database) MarkScenesMediaStale(ctx context.Context, documentID string, sceneIDs []string) (int, error) {
+	if len(sceneIDs) == 0 {
+		return 0, nil
+	}
+	result := db.db.WithContext(ctx).Model(&Scene{}).
+		Where("document_id = ? AND id IN ? AND (image_url <> ? OR voice_url <> ?)", documentID, sceneIDs, "", "").
+		Updates(map[string]any{"media_stale": true, "updated_at": time.Now()})
+	return int(result.RowsAffected), result.Error
+}
+
+// UpdateSceneTiming 更新场景的语音时长和建议展示时长
+func (db *Database) UpdateSceneTiming(ctx context.Context, sceneID string, audioDurationMs, displayDurationMs int64) error {
+	result := db.db.WithContext(ctx).Model(&Scene{}).Where("id = ?", sceneID).Updates(map[string]interface{}{
+		"audio_duration_ms":   audioDurationMs,
+		"display_duration_ms": displayDurationMs,
+		"updated_at":          time.Now(),
+	})
+	if result.Error != nil {
+		return result.Error
+	}
+	if result.RowsAffected == 0 {
+		return gorm.ErrRecordNotFound
+	}
+	return nil
+}
+
+// UpdateScenePlaceholder 将场景的图片和语音设置为占位媒体，占位语音为静音，清除字幕
+func (db *Database) UpdateScenePlaceholder(ctx context.Context, sceneID string, imageURL, voiceURL string) error {
+	result := db.db.WithContext(ctx).Model(&Scene{}).Where("id = ?", sceneID).Updates(map[string]interface{}{
+		"image_url":    imageURL,
+		"voice_url":    voiceURL,
+		"subtitle_url": "",
+		"placeholder":  true,
+		"updated_at":   time.Now(),
+	})
+	if result.Error != nil {
+		return result.Error
+	}
+	if result.RowsAffected == 0 {
+		return gorm.ErrRecordNotFound
+	}
+	return nil
+}
+
+// UpdateSceneStatus 更新场景生成状态、失败次数以及失败原因
+func (db *Database) UpdateSceneStatus(ctx context.Context, sceneID string, status string, attempts int, errMsg string) error {
+	updates := map[string]interface{}{
+		"status":     status,
+		"attempts":   attempts,
+		"error":      errMsg,
+		"updated_at": time.Now(),
+	}
+	// 场景生成成功后，图片和语音不再是占位媒体或过期媒体，审核记录一并清除
+	if status == SceneStatusReady {
+		updates["placeholder"] = false
+		updates["media_stale"] = false
+		updates["moderation_reason"] = ""
+		updates["held_image_url"] = ""
+		updates["moderation_passed"] = false
+	}
+	result := db.db.WithContext(ctx).Model(&Scene{}).Where("id = ?", sceneID).Updates(updates)
+	if result.Error != nil {
+		return result.Error
+	}
+	if result.RowsAffected == 0 {
+		return gorm.ErrRecordNotFound
+	}
+	return nil
+}
+
+// UpdateSceneHDImage 保存场景图片 sourceURL 超分后的高清图片，场景图片重新生成后 sourceURL 与 ImageURL 不一致，高清图片失效
+func (db *Database) UpdateSceneHDImage(ctx context.Context, sceneID string, hdImageURL, sourceURL string) error {
+	result := db.db.WithContext(ctx).Model(&Scene{}).Where("id = ?", sceneID).Updates(map[string]interface{}{
+		"hd_image_url":  hdImageURL,
+		"hd_source_url": sourceURL,
+		"updated_at":    time.Now(),
+	})
+	if result.Error != nil {
+		return result.Error
+	}
+	if result.RowsAffected == 0 {
+		return gorm.ErrRecordNotFound
+	}
+	return nil
 }
 
 func (db *Database) UpdateSceneImageURL(ctx context.Context, sceneID string, imageURL string) error {
@@ -312,14 +1151,58 @@ func (db *Database) UpdateSceneVoiceURL(ctx context.Context, sceneID string, voi
 	return nil
 }
 
+// UpdateSceneSubtitleURL 更新场景字幕 url，语音重新生成后字幕一并更新
+func (db *Database) UpdateSceneSubtitleURL(ctx context.Context, sceneID string, subtitleURL string) error {
+	result := db.db.WithContext(ctx).Model(&Scene{}).Where("id = ?", sceneID).Updates(map[string]interface{}{
+		"subtitle_url": subtitleURL,
+		"updated_at":   time.Now(),
+	})
+	if result.Error != nil {
+		return result.Error
+	}
+	if result.RowsAffected == 0 {
+		return gorm.ErrRecordNotFound
+	}
+	return nil
+}
+
 func (db *Database) DeleteScenesByChapter(ctx context.Context, chapterID string) error {
-	_, err := gorm.G[Scene](db.db).Where("chapter_id = ?", chapterID).Delete(ctx)
-	return err
+	return db.db.WithContext(ctx).Transaction(func(tx *gorm.DB) error {
+		scenes, err := gorm.G[Scene](tx).Select("id", "document_id").Where("chapter_id = ?", chapterID).Find(ctx)
+		if err != nil || len(scenes) == 0 {
+			return err
+		}
+		_, err = gorm.G[SceneGeneration](tx).Where("scene_id IN (?)", tx.Model(&Scene{}).Select("id").Where("chapter_id = ?", chapterID)).Delete(ctx)
+		if err != nil {
+			return err
+		}
+		_, err = gorm.G[SceneImageVersion](tx).Where("scene_id IN (?)", tx.Model(&Scene{}).Select("id").Where("chapter_id = ?", chapterID)).Delete(ctx)
+		if err != nil {
+			return err
+		}
+		if _, err = gorm.G[Scene](tx).Where("chapter_id = ?", chapterID).Delete(ctx); err != nil {
+			return err
+		}
+		tasks := sceneIndexTasks(scenes)
+		return gorm.G[IndexTask](tx).CreateInBatches(ctx, &tasks, batchSize)
+	})
 }
 
 func (db *Database) DeleteScenesByDocument(ctx context.Context, documentID string) error {
-	_, err := gorm.G[Scene](db.db).Where("document_id = ?", documentID).Delete(ctx)
-	return err
+	return db.db.WithContext(ctx).Transaction(func(tx *gorm.DB) error {
+		_, err := gorm.G[SceneGeneration](tx).Where("scene_id IN (?)", tx.Model(&Scene{}).Select("id").Where("document_id = ?", documentID)).Delete(ctx)
+		if err != nil {
+			return err
+		}
+		_, err = gorm.G[SceneImageVersion](tx).Where("scene_id IN (?)", tx.Model(&Scene{}).Select("id").Where("document_id = ?", documentID)).Delete(ctx)
+		if err != nil {
+			return err
+		}
+		if _, err := gorm.G[Scene](tx).Where("document_id = ?", documentID).Delete(ctx); err != nil {
+			return err
+		}
+		return gorm.G[IndexTask](tx).Create(ctx, documentIndexTask(documentID))
+	})
 }
 
 // ===== Role DAO =====
@@ -340,20 +1223,28 @@ func (db *Database) ListRolesByDocument(ctx context.Context, documentID string)
 }
 
 func (db *Database) DeleteRolesByDocument(ctx context.Context, documentID string) error {
-	_, err := gorm.G[Role](db.db).Where("document_id = ?", documentID).Delete(ctx)
-	return err
+	return db.db.WithContext(ctx).Transaction(func(tx *gorm.DB) error {
+		if _, err := gorm.G[RoleRelation](tx).Where("document_id = ?", documentID).Delete(ctx); err != nil {
+			return err
+		}
+		_, err := gorm.G[Role](tx).Where("document_id = ?", documentID).Delete(ctx)
+		return err
+	})
 }
 
 func (db *Database) UpdateRole(ctx context.Context, id string, args *api.UpdateRoleArgs) error {
 	now := time.Now()
 	role := Role{
-		Name:       args.Name,
-		Gender:     args.Gender,
-		Character:  args.Character,
-		Appearance: args.Appearance,
-		UpdatedAt:  now,
+		Name:        args.Name,
+		Gender:      args.Gender,
+		Character:   args.Character,
+		Appearance:  args.Appearance,
+		PortraitURL: args.PortraitURL,
+		UpdatedAt:   now,
 	}
-	rowsAffected, err := gorm.G[Role](db.db).Where("id = ?", id).Updates(ctx, role)
+	// 显式指定列，PortraitURL 为空时清除
+	rowsAffected, err := gorm.G[Role](db.db).Where("id = ?", id).
+		Select("name", "gender", "character", "appearance", "portrait_url", "updated_at").Updates(ctx, role)
 	if err != nil {
 		return err
 	}
@@ -363,17 +1254,93 @@ func (db *Database) UpdateRole(ctx context.Context, id string, args *api.UpdateR
 	return nil
 }
 
-func (db *Database) UpdateScene(ctx context.Context, id string, args *api.UpdateSceneArgs) error {
-	// 仅更新场景内容
-	result := db.db.WithContext(ctx).Model(&Scene{}).Where("id = ?", id).Updates(map[string]interface{}{
-		"content":    args.Content,
-		"updated_at": time.Now(),
+// MergeRoles 将 sourceIDs 角色合并到 target：更新 target 的角色信息，删除来源角色，
+// 文档场景描述用 rewrite 改写（如把来源角色名替换为 target 的名字），改写后的场景重建索引。
+// 任一角色不属于该文档时返回 gorm.ErrRecordNotFound
+func (db *Database) MergeRoles(ctx context.Context, documentID string, target *Role, sourceIDs []string, rewrite func(string) string) error {
+	return db.db.WithContext(ctx).Transaction(func(tx *gorm.DB) error {
+		target.UpdatedAt = time.Now()
+		rowsAffected, err := gorm.G[Role](tx).Where("id = ? AND document_id = ?", target.ID, documentID).
+			Select("name", "gender", "character", "appearance", "portrait_url", "updated_at").Updates(ctx, *target)
+		if err != nil {
+			return err
+		}
+		if rowsAffected == 0 {
+			return gorm.ErrRecordNotFound
+		}
+		rowsAffected, err = gorm.G[Role](tx).Where("id IN ? AND document_id = ?", sourceIDs, documentID).Delete(ctx)
+		if err != nil {
+			return err
+		}
+		if rowsAffected != len(sourceIDs) {
+			return gorm.ErrRecordNotFound
+		}
+		if err = mergeRoleRelations(ctx, tx, documentID, target.ID, sourceIDs); err != nil {
+			return err
+		}
+
+		scenes, err := gorm.G[Scene](tx).Select("id", "document_id", "content", "characters_present").Where("document_id = ?", documentID).Find(ctx)
+		if err != nil {
+			return err
+		}
+		var changed []Scene
+		for _, scene := range scenes {
+			content := rewrite(scene.Content)
+			characters := rewriteCharacters(scene.CharactersPresent, rewrite)
+			if content == scene.Content && slices.Equal(characters, scene.CharactersPresent) {
+				continue
+			}
+			err = tx.Model(&Scene{}).Where("id = ?", scene.ID).Select("content", "characters_present", "updated_at").
+				Updates(&Scene{Content: content, CharactersPresent: characters, UpdatedAt: target.UpdatedAt}).Error
+			if err != nil {
+				return err
+			}
+			changed = append(changed, scene)
+		}
+		if len(changed) == 0 {
+			return nil
+		}
+		tasks := sceneIndexTasks(changed)
+		return gorm.G[IndexTask](tx).CreateInBatches(ctx, &tasks, batchSize)
 	})
-	if result.Error != nil {
-		return result.Error
+}
+
+// rewriteCharacters 合并角色后改写场景的出场人物，去掉改写后重复的名字
+func rewriteCharacters(characters []string, rewrite func(string) string) []string {
+	if len(characters) == 0 {
+		return characters
 	}
-	if result.RowsAffected == 0 {
-		return gorm.ErrRecordNotFound
+	ret := make([]string, 0, len(characters))
+	for _, name := range characters {
+		if name = rewrite(name); !slices.Contains(ret, name) {
+			ret = append(ret, name)
+		}
 	}
-	return nil
+	return ret
+}
+
+func (db *Database) UpdateScene(ctx context.Context, id string, args *api.UpdateSceneArgs) error {
+	return db.db.WithContext(ctx).Transaction(func(tx *gorm.DB) error {
+		scene, err := gorm.G[Scene](tx).Select("id", "document_id", "content", "image_url", "voice_url").Where("id = ?", id).Take(ctx)
+		if err != nil {
+			return err
+		}
+		// 更新场景内容，传入 Overrides 时同时替换场景级生成参数；内容变化时已有的图片和语音标记为过期
+		updates := Scene{Content: args.Content, UpdatedAt: time.Now()}
+		columns := []string{"content", "updated_at"}
+		if args.Overrides != nil {
+			updates.Overrides = *args.Overrides
+			columns = append(columns, "overrides")
+		}
+		if args.Content != scene.Content && (scene.ImageURL != "" || scene.VoiceURL != "") {
+			updates.MediaStale = true
+			columns = append(columns, "media_stale")
+		}
+		err = tx.Model(&Scene{}).Where("id = ?", id).Select(columns).Updates(updates).Error
+		if err != nil {
+			return err
+		}
+		tasks := sceneIndexTasks([]Scene{scene})
+		return gorm.G[IndexTask](tx).CreateInBatches(ctx, &tasks, batchSize)
+	})
 }
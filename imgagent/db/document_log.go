@@ -0,0 +1,53 @@
+package db
+
+import (
+	"context"
+	"slices"
+	"time"
+
+	"gorm.io/gorm"
+)
+
+// 文档处理日志级别
+const (
+	LogLevelInfo  = "info"
+	LogLevelError = "error"
+)
+
+// DocumentLog 文档处理日志，记录每次百炼调用和阶段失败，用于生成排查日志包
+type DocumentLog struct {
+	ID         int64     `gorm:"primaryKey;autoIncrement"`
+	DocumentID string    `gorm:"index:idx_document_log_document_id;size:32;comment:'文档 id'"`
+	SceneID    string    `gorm:"size:32;comment:'关联场景 id'"`
+	Stage      string    `gorm:"size:20;comment:'处理阶段 role|scene|image'"`
+	Level      string    `gorm:"size:10;comment:'级别 info|error'"`
+	API        string    `gorm:"size:128;comment:'调用的百炼接口'"`
+	RequestID  string    `gorm:"size:64;comment:'百炼返回的请求 id'"`
+	StatusCode int       `gorm:"comment:'百炼接口 HTTP 状态码'"`
+	DurationMs int64     `gorm:"comment:'调用耗时（毫秒）'"`
+	Message    string    `gorm:"size:1024;comment:'日志内容'"`
+	CreatedAt  time.Time `gorm:"comment:'创建时间'"`
+}
+
+func (DocumentLog) TableName() string {
+	return "document_logs"
+}
+
+func (db *Database) CreateDocumentLog(ctx context.Context, l *DocumentLog) error {
+	return gorm.G[DocumentLog](db.db).Create(ctx, l)
+}
+
+// ListDocumentLogs 按时间顺序列取文档最近的 limit 条处理日志
+func (db *Database) ListDocumentLogs(ctx context.Context, documentID string, limit int) ([]DocumentLog, error) {
+	logs, err := gorm.G[DocumentLog](db.db).Where("document_id = ?", documentID).Order("id DESC").Limit(limit).Find(ctx)
+	if err != nil {
+		return nil, err
+	}
+	slices.Reverse(logs)
+	return logs, nil
+}
+
+func (db *Database) DeleteDocumentLogs(ctx context.Context, documentID string) error {
+	_, err := gorm.G[DocumentLog](db.db).Where("document_id = ?", documentID).Delete(ctx)
+	return err
+}
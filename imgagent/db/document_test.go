@@ -1,10 +1,16 @@
 package db
 
 import (
+	"bytes"
 	"context"
+	"sort"
+	"strings"
 	"testing"
+	"time"
 
 	"imgagent/api"
+	"imgagent/pkg/cache"
+	"imgagent/pkg/dbutil"
 
 	"github.com/stretchr/testify/assert"
 	"github.com/stretchr/testify/require"
@@ -21,7 +27,7 @@ func setupTestDB(t *testing.T) *Database {
 	require.NoError(t, err)
 
 	// AutoMigrate (SQLite 不需要表选项)
-	err = db.AutoMigrate(&Document{}, &Chapter{}, &Scene{}, &Role{})
+	err = db.AutoMigrate(&Document{}, &Chapter{}, &Scene{}, &Role{}, &IndexTask{}, &SearchEntry{}, &BackfillProgress{}, &SceneGeneration{}, &SceneImageVersion{}, &BGMTrack{}, &URLPolicy{}, &ImportBatch{}, &ImportItem{}, &DocumentLog{}, &ChapterEmbedding{}, &Bookmark{}, &ReadPosition{}, &AuditLog{}, &DocumentTranslation{}, &ChapterTranslation{}, &RoleRelation{}, &DocumentRun{}, &RunScene{}, &Export{})
 	require.NoError(t, err)
 
 	return &Database{db: db}
@@ -248,6 +254,52 @@ func TestListPendingImageScenes(t *testing.T) {
 	assert.Equal(t, 1, len(pendingScenes)) // 只有场景1需要生成图片
 }
 
+func TestUpdateSceneStatus(t *testing.T) {
+	db := setupTestDB(t)
+	ctx := context.Background()
+
+	docID := MakeUUID()
+	chapterID := MakeUUID()
+
+	scenes := []Scene{
+		{ID: MakeUUID(), ChapterID: chapterID, DocumentID: docID, Index: 0, Content: "场景1"},
+		{ID: MakeUUID(), ChapterID: chapterID, DocumentID: docID, Index: 1, Content: "场景2"},
+	}
+	err := db.CreateScenes(ctx, scenes)
+	require.NoError(t, err)
+
+	// 未达到最大次数，场景仍待生成
+	err = db.UpdateSceneStatus(ctx, scenes[0].ID, "", 1, "timeout")
+	require.NoError(t, err)
+	pendingScenes, err := db.ListPendingImageScenes(ctx, docID)
+	require.NoError(t, err)
+	assert.Equal(t, 2, len(pendingScenes))
+
+	// 永久失败的场景不再待生成
+	err = db.UpdateSceneStatus(ctx, scenes[0].ID, SceneStatusFailed, 3, "timeout")
+	require.NoError(t, err)
+	pendingScenes, err = db.ListPendingImageScenes(ctx, docID)
+	require.NoError(t, err)
+	require.Equal(t, 1, len(pendingScenes))
+	assert.Equal(t, scenes[1].ID, pendingScenes[0].ID)
+
+	scene, err := db.GetScene(ctx, scenes[0].ID)
+	require.NoError(t, err)
+	assert.Equal(t, SceneStatusFailed, scene.Status)
+	assert.Equal(t, 3, scene.Attempts)
+	assert.Equal(t, "timeout", scene.Error)
+
+	// 更新文档场景统计
+	_, err = db.CreateDocument(ctx, docID, "file-id", &api.CreateDocumentArgs{Name: "部分失败文档"})
+	require.NoError(t, err)
+	err = db.UpdateDocumentSceneStats(ctx, docID, 2, 1)
+	require.NoError(t, err)
+	doc, err := db.GetDocument(ctx, docID)
+	require.NoError(t, err)
+	assert.Equal(t, 2, doc.SceneCount)
+	assert.Equal(t, 1, doc.FailedSceneCount)
+}
+
 func TestListScenesByDocument(t *testing.T) {
 	db := setupTestDB(t)
 	ctx := context.Background()
@@ -271,6 +323,91 @@ func TestListScenesByDocument(t *testing.T) {
 	assert.Equal(t, 3, len(allScenes))
 }
 
+func TestListUpdatedSince(t *testing.T) {
+	db := setupTestDB(t)
+	ctx := context.Background()
+
+	docID1, docID2 := MakeUUID(), MakeUUID()
+	_, err := db.CreateDocument(ctx, docID1, "file-id-1", &api.CreateDocumentArgs{Name: "文档1"})
+	require.NoError(t, err)
+	_, err = db.CreateDocument(ctx, docID2, "file-id-2", &api.CreateDocumentArgs{Name: "文档2"})
+	require.NoError(t, err)
+	require.NoError(t, db.CreateChapters(ctx, docID1, []string{"第一章", "第二章"}))
+	chapters, err := db.ListChapters(ctx, docID1)
+	require.NoError(t, err)
+	scenes := []Scene{
+		{ID: MakeUUID(), ChapterID: chapters[0].ID, DocumentID: docID1, Index: 0, Content: "场景1"},
+		{ID: MakeUUID(), ChapterID: chapters[0].ID, DocumentID: docID1, Index: 1, Content: "场景2"},
+		{ID: MakeUUID(), ChapterID: chapters[1].ID, DocumentID: docID1, Index: 2, Content: "场景3"},
+	}
+	require.NoError(t, db.CreateScenes(ctx, scenes))
+
+	time.Sleep(10 * time.Millisecond)
+	since := time.Now()
+	require.NoError(t, db.UpdateDocumentStatus(ctx, docID2, DocumentStatusRoleReady))
+	require.NoError(t, db.UpdateChapterSceneIDs(ctx, chapters[1].ID, []string{scenes[2].ID}))
+	require.NoError(t, db.UpdateSceneImageURL(ctx, scenes[1].ID, "http://img/2"))
+
+	docs, err := db.ListDocumentsUpdatedSince(ctx, since)
+	require.NoError(t, err)
+	require.Len(t, docs, 1)
+	assert.Equal(t, docID2, docs[0].ID)
+
+	updatedChapters, err := db.ListChaptersUpdatedSince(ctx, docID1, since)
+	require.NoError(t, err)
+	require.Len(t, updatedChapters, 1)
+	assert.Equal(t, chapters[1].ID, updatedChapters[0].ID)
+
+	updatedScenes, err := db.ListScenesByDocumentUpdatedSince(ctx, docID1, since)
+	require.NoError(t, err)
+	require.Len(t, updatedScenes, 1)
+	assert.Equal(t, scenes[1].ID, updatedScenes[0].ID)
+	updatedScenes, err = db.ListScenesByChapterUpdatedSince(ctx, chapters[1].ID, since)
+	require.NoError(t, err)
+	assert.Empty(t, updatedScenes)
+
+	// 以最近一次更新时间再次查询，包含该时间更新的数据
+	docs, err = db.ListDocumentsUpdatedSince(ctx, docs[0].UpdatedAt)
+	require.NoError(t, err)
+	assert.Len(t, docs, 1)
+}
+
+func TestListDocumentsByFilter(t *testing.T) {
+	db := setupTestDB(t)
+	ctx := context.Background()
+
+	var ids []string
+	for _, name := range []string{"b", "c", "a"} {
+		id := MakeUUID()
+		_, err := db.CreateDocument(ctx, id, "file-id", &api.CreateDocumentArgs{Name: name})
+		require.NoError(t, err)
+		ids = append(ids, id)
+		time.Sleep(10 * time.Millisecond)
+	}
+	require.NoError(t, db.UpdateDocumentStatus(ctx, ids[0], DocumentStatusFailed))
+	time.Sleep(10 * time.Millisecond)
+	since := time.Now()
+	require.NoError(t, db.UpdateDocumentStatus(ctx, ids[1], DocumentStatusSceneReady))
+
+	docIDs := func(filter DocumentFilter) []string {
+		docs, err := db.ListDocumentsByFilter(ctx, filter)
+		require.NoError(t, err)
+		var ret []string
+		for _, d := range docs {
+			ret = append(ret, d.ID)
+		}
+		return ret
+	}
+	assert.Equal(t, []string{ids[1], ids[0], ids[2]}, docIDs(DocumentFilter{}))
+	assert.Equal(t, []string{ids[0], ids[1], ids[2]}, docIDs(DocumentFilter{SortBy: DocumentSortCreatedAt, Asc: true}))
+	assert.Equal(t, []string{ids[1], ids[0], ids[2]}, docIDs(DocumentFilter{SortBy: DocumentSortName, Asc: false}))
+	assert.Equal(t, []string{ids[2], ids[0], ids[1]}, docIDs(DocumentFilter{SortBy: DocumentSortName, Asc: true}))
+	assert.Equal(t, []string{ids[1], ids[0]}, docIDs(DocumentFilter{Statuses: []string{DocumentStatusFailed, DocumentStatusSceneReady}}))
+	assert.Equal(t, []string{ids[1]}, docIDs(DocumentFilter{UpdatedSince: since, Statuses: []string{DocumentStatusSceneReady}}))
+	// 不支持的排序字段按更新时间排序
+	assert.Equal(t, []string{ids[1], ids[0], ids[2]}, docIDs(DocumentFilter{SortBy: "id; DROP TABLE documents"}))
+}
+
 func TestUpdateDocumentFileID(t *testing.T) {
 	db := setupTestDB(t)
 	ctx := context.Background()
@@ -292,6 +429,24 @@ func TestUpdateDocumentFileID(t *testing.T) {
 	assert.Equal(t, fileID, doc.FileID)
 }
 
+func TestListReferencedFileIDs(t *testing.T) {
+	db := setupTestDB(t)
+	ctx := context.Background()
+
+	_, err := db.CreateDocument(ctx, MakeUUID(), "file-1", &api.CreateDocumentArgs{Name: "文档1"})
+	require.NoError(t, err)
+	_, err = db.CreateDocument(ctx, MakeUUID(), "file-2", &api.CreateDocumentArgs{Name: "文档2"})
+	require.NoError(t, err)
+
+	ids, err := db.ListReferencedFileIDs(ctx, []string{"file-1", "file-3"})
+	require.NoError(t, err)
+	assert.Equal(t, []string{"file-1"}, ids)
+
+	ids, err = db.ListReferencedFileIDs(ctx, nil)
+	require.NoError(t, err)
+	assert.Empty(t, ids)
+}
+
 func TestListRoleReadyDocuments(t *testing.T) {
 	db := setupTestDB(t)
 	ctx := context.Background()
@@ -369,6 +524,262 @@ func TestDeleteRolesByDocument(t *testing.T) {
 	assert.Equal(t, 0, len(foundRoles))
 }
 
+func TestReorderChapters(t *testing.T) {
+	db := setupTestDB(t)
+	ctx := context.Background()
+
+	docID := MakeUUID()
+	_, err := db.CreateDocument(ctx, docID, "file-id", &api.CreateDocumentArgs{Name: "重排文档"})
+	require.NoError(t, err)
+	require.NoError(t, db.CreateChapters(ctx, docID, []string{"第一章", "第二章", "第三章"}))
+	chapters, err := db.ListChapters(ctx, docID)
+	require.NoError(t, err)
+	require.NoError(t, db.CreateScenes(ctx, []Scene{
+		{ID: "s0", ChapterID: chapters[0].ID, DocumentID: docID, Index: 0},
+		{ID: "s1", ChapterID: chapters[0].ID, DocumentID: docID, Index: 1},
+		{ID: "s2", ChapterID: chapters[1].ID, DocumentID: docID, Index: 2},
+		{ID: "s3", ChapterID: chapters[2].ID, DocumentID: docID, Index: 3},
+	}))
+
+	order := []string{chapters[2].ID, chapters[0].ID, chapters[1].ID}
+	require.NoError(t, db.ReorderChapters(ctx, docID, order))
+	reordered, err := db.ListChapters(ctx, docID)
+	require.NoError(t, err)
+	for i, c := range reordered {
+		assert.Equal(t, order[i], c.ID)
+		assert.Equal(t, i, c.Index)
+	}
+	scenes, err := db.ListScenesByDocument(ctx, docID)
+	require.NoError(t, err)
+	var ids []string
+	for i, scene := range scenes {
+		assert.Equal(t, i, scene.Index)
+		ids = append(ids, scene.ID)
+	}
+	assert.Equal(t, []string{"s3", "s0", "s1", "s2"}, ids)
+
+	// 缺少章节或包含其他文档的章节时不修改
+	assert.ErrorIs(t, db.ReorderChapters(ctx, docID, order[:2]), gorm.ErrRecordNotFound)
+	assert.ErrorIs(t, db.ReorderChapters(ctx, docID, []string{order[0], order[1], "other"}), gorm.ErrRecordNotFound)
+	reordered, err = db.ListChapters(ctx, docID)
+	require.NoError(t, err)
+	assert.Equal(t, order[0], reordered[0].ID)
+}
+
+func TestSplitChapter(t *testing.T) {
+	db := setupTestDB(t)
+	ctx := context.Background()
+
+	docID := MakeUUID()
+	_, err := db.CreateDocument(ctx, docID, "file-id", &api.CreateDocumentArgs{Name: "拆分文档"})
+	require.NoError(t, err)
+	require.NoError(t, db.CreateChapters(ctx, docID, []string{"第一章第二章", "第三章", "第四章"}))
+	chapters, err := db.ListChapters(ctx, docID)
+	require.NoError(t, err)
+	require.NoError(t, db.CreateScenes(ctx, []Scene{
+		{ID: "s0", ChapterID: chapters[0].ID, DocumentID: docID, Index: 0},
+		{ID: "s1", ChapterID: chapters[0].ID, DocumentID: docID, Index: 1},
+		{ID: "s2", ChapterID: chapters[0].ID, DocumentID: docID, Index: 2},
+		{ID: "s3", ChapterID: chapters[1].ID, DocumentID: docID, Index: 3},
+	}))
+
+	created, err := db.SplitChapter(ctx, docID, chapters[0].ID, "第一章", "第二章", 1)
+	require.NoError(t, err)
+	assert.Equal(t, 1, created.Index)
+
+	split, err := db.ListChapters(ctx, docID)
+	require.NoError(t, err)
+	require.Len(t, split, 4)
+	var contents []string
+	for i, c := range split {
+		assert.Equal(t, i, c.Index)
+		contents = append(contents, c.Content)
+	}
+	assert.Equal(t, []string{"第一章", "第二章", "第三章", "第四章"}, contents)
+	assert.Equal(t, []string{"s0"}, split[0].SceneIDs)
+	assert.Equal(t, created.ID, split[1].ID)
+	assert.Equal(t, []string{"s1", "s2"}, split[1].SceneIDs)
+
+	moved, err := db.ListScenesByChapter(ctx, created.ID)
+	require.NoError(t, err)
+	require.Len(t, moved, 2)
+	assert.Equal(t, 1, moved[0].Index)
+
+	// 场景全部移到新章节
+	created, err = db.SplitChapter(ctx, docID, split[3].ID, "第四", "章", 0)
+	require.NoError(t, err)
+	assert.Equal(t, 4, created.Index)
+	last, err := db.GetChapter(ctx, split[3].ID, docID)
+	require.NoError(t, err)
+	assert.Empty(t, last.SceneIDs)
+
+	_, err = db.SplitChapter(ctx, docID, "other", "a", "b", 0)
+	assert.ErrorIs(t, err, gorm.ErrRecordNotFound)
+}
+
+func TestInsertScene(t *testing.T) {
+	db := setupTestDB(t)
+	ctx := context.Background()
+
+	docID := MakeUUID()
+	_, err := db.CreateDocument(ctx, docID, "file-id", &api.CreateDocumentArgs{Name: "插入场景文档"})
+	require.NoError(t, err)
+	require.NoError(t, db.CreateChapters(ctx, docID, []string{"第一章", "第二章", "第三章"}))
+	chapters, err := db.ListChapters(ctx, docID)
+	require.NoError(t, err)
+	require.NoError(t, db.CreateScenes(ctx, []Scene{
+		{ID: "s0", ChapterID: chapters[0].ID, DocumentID: docID, Index: 0},
+		{ID: "s1", ChapterID: chapters[0].ID, DocumentID: docID, Index: 1},
+		{ID: "s2", ChapterID: chapters[2].ID, DocumentID: docID, Index: 2},
+	}))
+	require.NoError(t, db.UpdateChapterSceneIDs(ctx, chapters[0].ID, []string{"s0", "s1"}))
+	require.NoError(t, db.UpdateChapterSceneIDs(ctx, chapters[2].ID, []string{"s2"}))
+
+	order := func() []string {
+		scenes, err := db.ListScenesByDocument(ctx, docID)
+		require.NoError(t, err)
+		var ids []string
+		for i, scene := range scenes {
+			assert.Equal(t, i, scene.Index)
+			ids = append(ids, scene.ID)
+		}
+		return ids
+	}
+
+	// 插入到场景之后
+	require.NoError(t, db.InsertScene(ctx, "s0", &Scene{ID: "a", ChapterID: chapters[0].ID, Content: "新场景"}))
+	assert.Equal(t, []string{"s0", "a", "s1", "s2"}, order())
+	chapter, err := db.GetChapter(ctx, chapters[0].ID, docID)
+	require.NoError(t, err)
+	assert.Equal(t, []string{"s0", "a", "s1"}, chapter.SceneIDs)
+	inserted, err := db.GetScene(ctx, "a")
+	require.NoError(t, err)
+	assert.Equal(t, docID, inserted.DocumentID)
+
+	// 插入到章节开头，空章节插入到前面章节的场景之后
+	require.NoError(t, db.InsertScene(ctx, "", &Scene{ID: "b", ChapterID: chapters[2].ID}))
+	require.NoError(t, db.InsertScene(ctx, "", &Scene{ID: "c", ChapterID: chapters[1].ID}))
+	require.NoError(t, db.InsertScene(ctx, "", &Scene{ID: "d", ChapterID: chapters[0].ID}))
+	assert.Equal(t, []string{"d", "s0", "a", "s1", "c", "b", "s2"}, order())
+	chapter, err = db.GetChapter(ctx, chapters[2].ID, docID)
+	require.NoError(t, err)
+	assert.Equal(t, []string{"b", "s2"}, chapter.SceneIDs)
+
+	assert.ErrorIs(t, db.InsertScene(ctx, "s2", &Scene{ID: "e", ChapterID: chapters[0].ID}), gorm.ErrRecordNotFound)
+	assert.ErrorIs(t, db.InsertScene(ctx, "", &Scene{ID: "e", ChapterID: "other"}), gorm.ErrRecordNotFound)
+	assert.Len(t, order(), 7)
+}
+
+func TestMergeRoles(t *testing.T) {
+	db := setupTestDB(t)
+	ctx := context.Background()
+
+	docID := MakeUUID()
+	require.NoError(t, db.CreateRoles(ctx, []Role{
+		{ID: "r0", DocumentID: docID, Name: "李雷", Gender: "男"},
+		{ID: "r1", DocumentID: docID, Name: "李雷（少年）", Appearance: "瘦高"},
+		{ID: "r2", DocumentID: MakeUUID(), Name: "韩梅梅"},
+	}))
+	require.NoError(t, db.CreateScenes(ctx, []Scene{
+		{ID: "s0", DocumentID: docID, Content: "李雷（少年）在操场上奔跑", CharactersPresent: []string{"李雷（少年）", "李雷"}},
+		{ID: "s1", DocumentID: docID, Content: "韩梅梅在看书"},
+	}))
+	rewrite := func(s string) string { return strings.ReplaceAll(s, "李雷（少年）", "李雷") }
+
+	// 来源角色属于其他文档
+	target := Role{ID: "r0", Name: "李雷", Gender: "男", Appearance: "瘦高"}
+	assert.ErrorIs(t, db.MergeRoles(ctx, docID, &target, []string{"r1", "r2"}, rewrite), gorm.ErrRecordNotFound)
+	roles, err := db.ListRolesByDocument(ctx, docID)
+	require.NoError(t, err)
+	assert.Len(t, roles, 2)
+
+	require.NoError(t, db.MergeRoles(ctx, docID, &target, []string{"r1"}, rewrite))
+	roles, err = db.ListRolesByDocument(ctx, docID)
+	require.NoError(t, err)
+	require.Len(t, roles, 1)
+	assert.Equal(t, "瘦高", roles[0].Appearance)
+	scene, err := db.GetScene(ctx, "s0")
+	require.NoError(t, err)
+	assert.Equal(t, "李雷在操场上奔跑", scene.Content)
+	assert.Equal(t, []string{"李雷"}, scene.CharactersPresent)
+	scene, err = db.GetScene(ctx, "s1")
+	require.NoError(t, err)
+	assert.Equal(t, "韩梅梅在看书", scene.Content)
+}
+
+func TestRoleRelations(t *testing.T) {
+	db := setupTestDB(t)
+	ctx := context.Background()
+
+	docID := MakeUUID()
+	require.NoError(t, db.CreateRoles(ctx, []Role{
+		{ID: "r0", DocumentID: docID, Name: "李雷"},
+		{ID: "r1", DocumentID: docID, Name: "李雷（少年）"},
+		{ID: "r2", DocumentID: docID, Name: "韩梅梅"},
+	}))
+	now := time.Now()
+	require.NoError(t, db.ReplaceRoleRelations(ctx, docID, []RoleRelation{
+		{ID: "e0", DocumentID: docID, SourceRoleID: "r1", TargetRoleID: "r2", Type: "friend", CreatedAt: now},
+		{ID: "e1", DocumentID: docID, SourceRoleID: "r0", TargetRoleID: "r1", Type: "other", CreatedAt: now.Add(time.Second)},
+	}))
+
+	// 合并后来源角色的关系转给目标角色，指向自身的关系删除
+	rewrite := func(s string) string { return s }
+	require.NoError(t, db.MergeRoles(ctx, docID, &Role{ID: "r0", Name: "李雷"}, []string{"r1"}, rewrite))
+	relations, err := db.ListRoleRelations(ctx, docID)
+	require.NoError(t, err)
+	require.Len(t, relations, 1)
+	assert.Equal(t, "r0", relations[0].SourceRoleID)
+	assert.Equal(t, "r2", relations[0].TargetRoleID)
+
+	// 替换已有关系
+	require.NoError(t, db.ReplaceRoleRelations(ctx, docID, []RoleRelation{
+		{ID: "e2", DocumentID: docID, SourceRoleID: "r2", TargetRoleID: "r0", Type: "lover", CreatedAt: now},
+	}))
+	relations, err = db.ListRoleRelations(ctx, docID)
+	require.NoError(t, err)
+	require.Len(t, relations, 1)
+	assert.Equal(t, "e2", relations[0].ID)
+
+	require.NoError(t, db.DeleteRolesByDocument(ctx, docID))
+	relations, err = db.ListRoleRelations(ctx, docID)
+	require.NoError(t, err)
+	assert.Empty(t, relations)
+}
+
+func TestImportBatch(t *testing.T) {
+	db := setupTestDB(t)
+	ctx := context.Background()
+
+	batch := ImportBatch{ID: MakeUUID(), UserID: 1, Filename: "books.zip", Status: ImportBatchStatusRunning}
+	items := []ImportItem{
+		{BatchID: batch.ID, Index: 1, Filename: "b.txt", Name: "b", Status: ImportItemStatusFailed, Error: "duplicate name in archive"},
+		{BatchID: batch.ID, Index: 0, Filename: "a.txt", Name: "a", Status: ImportItemStatusPending},
+	}
+	require.NoError(t, db.CreateImportBatch(ctx, &batch, items))
+	assert.NotZero(t, items[0].ID)
+	assert.NotZero(t, items[1].ID)
+
+	require.NoError(t, db.UpdateImportItem(ctx, items[1].ID, ImportItemStatusCreated, "doc-1", ""))
+	require.NoError(t, db.UpdateImportBatchStatus(ctx, batch.ID, ImportBatchStatusFinished))
+	assert.ErrorIs(t, db.UpdateImportItem(ctx, 12345, ImportItemStatusCreated, "doc-2", ""), gorm.ErrRecordNotFound)
+
+	got, err := db.GetImportBatch(ctx, batch.ID)
+	require.NoError(t, err)
+	assert.Equal(t, ImportBatchStatusFinished, got.Status)
+	assert.Equal(t, int64(1), got.UserID)
+
+	// 按归档中的顺序返回
+	list, err := db.ListImportItems(ctx, batch.ID)
+	require.NoError(t, err)
+	require.Len(t, list, 2)
+	assert.Equal(t, "a.txt", list[0].Filename)
+	assert.Equal(t, ImportItemStatusCreated, list[0].Status)
+	assert.Equal(t, "doc-1", list[0].DocumentID)
+	assert.Equal(t, ImportItemStatusFailed, list[1].Status)
+	assert.Equal(t, "duplicate name in archive", list[1].Error)
+}
+
 func TestDeleteScenesByDocument(t *testing.T) {
 	db := setupTestDB(t)
 	ctx := context.Background()
@@ -394,6 +805,180 @@ func TestDeleteScenesByDocument(t *testing.T) {
 	assert.Equal(t, 0, len(foundScenes))
 }
 
+func TestSceneOverrides(t *testing.T) {
+	db := setupTestDB(t)
+	ctx := context.Background()
+
+	scene := Scene{ID: MakeUUID(), ChapterID: MakeUUID(), DocumentID: MakeUUID(), Content: "场景"}
+	require.NoError(t, db.CreateScenes(ctx, []Scene{scene}))
+	got, err := db.GetScene(ctx, scene.ID)
+	require.NoError(t, err)
+	assert.Zero(t, got.Overrides)
+
+	overrides := api.SceneOverrides{Style: "水彩", ImageSize: "1664*928", Voice: "Ethan"}
+	require.NoError(t, db.UpdateScene(ctx, scene.ID, &api.UpdateSceneArgs{Content: "新场景", Overrides: &overrides}))
+	got, err = db.GetScene(ctx, scene.ID)
+	require.NoError(t, err)
+	assert.Equal(t, overrides, got.Overrides)
+
+	// 不传时保留，传空值时清除
+	require.NoError(t, db.UpdateScene(ctx, scene.ID, &api.UpdateSceneArgs{Content: "再次修改"}))
+	got, err = db.GetScene(ctx, scene.ID)
+	require.NoError(t, err)
+	assert.Equal(t, "再次修改", got.Content)
+	assert.Equal(t, overrides, got.Overrides)
+	require.NoError(t, db.UpdateScene(ctx, scene.ID, &api.UpdateSceneArgs{Content: "再次修改", Overrides: &api.SceneOverrides{}}))
+	got, err = db.GetScene(ctx, scene.ID)
+	require.NoError(t, err)
+	assert.Zero(t, got.Overrides)
+}
+
+func TestSceneIndexOutbox(t *testing.T) {
+	db := setupTestDB(t)
+	ctx := context.Background()
+
+	docID := MakeUUID()
+	chapterID := MakeUUID()
+	scenes := []Scene{
+		{ID: MakeUUID(), ChapterID: chapterID, DocumentID: docID, Index: 0, Content: "场景1"},
+		{ID: MakeUUID(), ChapterID: chapterID, DocumentID: docID, Index: 1, Content: "场景2"},
+	}
+	require.NoError(t, db.CreateScenes(ctx, scenes))
+	require.NoError(t, db.UpdateScene(ctx, scenes[0].ID, &api.UpdateSceneArgs{Content: "新场景1"}))
+	require.NoError(t, db.DeleteScenesByChapter(ctx, chapterID))
+	_, err := db.DeleteDocument(ctx, docID)
+	require.NoError(t, err)
+
+	// 每次写入都在同一事务中记录索引任务，文档级任务 SceneID 为空
+	tasks, err := db.ListIndexTasks(ctx, 100)
+	require.NoError(t, err)
+	require.Len(t, tasks, 6)
+	assert.Equal(t, scenes[0].ID, tasks[0].SceneID)
+	assert.Equal(t, scenes[1].ID, tasks[1].SceneID)
+	assert.Equal(t, scenes[0].ID, tasks[2].SceneID)
+	assert.Empty(t, tasks[5].SceneID)
+	assert.Equal(t, docID, tasks[5].DocumentID)
+
+	// 更新不存在的场景时不写入任务
+	err = db.UpdateScene(ctx, MakeUUID(), &api.UpdateSceneArgs{Content: "x"})
+	assert.ErrorIs(t, err, gorm.ErrRecordNotFound)
+
+	ids := make([]int64, 0, len(tasks))
+	for _, task := range tasks {
+		ids = append(ids, task.ID)
+	}
+	require.NoError(t, db.DeleteIndexTasks(ctx, ids))
+	tasks, err = db.ListIndexTasks(ctx, 100)
+	require.NoError(t, err)
+	assert.Empty(t, tasks)
+}
+
+func TestBackfillProgress(t *testing.T) {
+	db := setupTestDB(t)
+	ctx := context.Background()
+
+	// 未开始的阶段返回空进度
+	progress, err := db.GetBackfillProgress(ctx, "search")
+	require.NoError(t, err)
+	assert.Equal(t, "search", progress.Stage)
+	assert.Empty(t, progress.LastDocumentID)
+
+	ids := []string{MakeUUID(), MakeUUID(), MakeUUID()}
+	for _, id := range ids {
+		_, err := db.CreateDocument(ctx, id, "file-id", &api.CreateDocumentArgs{Name: id})
+		require.NoError(t, err)
+	}
+	sort.Strings(ids)
+
+	// 按 id 游标分页
+	docs, err := db.ListDocumentsAfter(ctx, "", 2)
+	require.NoError(t, err)
+	require.Len(t, docs, 2)
+	assert.Equal(t, ids[0], docs[0].ID)
+	assert.Equal(t, ids[1], docs[1].ID)
+	docs, err = db.ListDocumentsAfter(ctx, docs[1].ID, 2)
+	require.NoError(t, err)
+	require.Len(t, docs, 1)
+	assert.Equal(t, ids[2], docs[0].ID)
+
+	// 保存进度为 upsert
+	progress.LastDocumentID = ids[1]
+	progress.Processed = 2
+	require.NoError(t, db.SaveBackfillProgress(ctx, &progress))
+	progress.Processed = 3
+	progress.Done = true
+	require.NoError(t, db.SaveBackfillProgress(ctx, &progress))
+	found, err := db.GetBackfillProgress(ctx, "search")
+	require.NoError(t, err)
+	assert.Equal(t, 3, found.Processed)
+	assert.True(t, found.Done)
+
+	require.NoError(t, db.DeleteBackfillProgress(ctx, "search"))
+	found, err = db.GetBackfillProgress(ctx, "search")
+	require.NoError(t, err)
+	assert.Zero(t, found.Processed)
+}
+
+func TestFailedJobs(t *testing.T) {
+	db := setupTestDB(t)
+	ctx := context.Background()
+
+	docID := MakeUUID()
+	_, err := db.CreateDocument(ctx, docID, "file-id", &api.CreateDocumentArgs{Name: "失败文档"})
+	require.NoError(t, err)
+	scenes := []Scene{
+		{ID: MakeUUID(), DocumentID: docID, Index: 0, Content: "场景一"},
+		{ID: MakeUUID(), DocumentID: docID, Index: 1, Content: "场景二"},
+	}
+	require.NoError(t, db.CreateScenes(ctx, scenes))
+	for _, scene := range scenes {
+		require.NoError(t, db.UpdateSceneStatus(ctx, scene.ID, SceneStatusFailed, 3, "timeout"))
+		require.NoError(t, db.UpdateScenePlaceholder(ctx, scene.ID, "placeholder-image", "placeholder-voice"))
+	}
+
+	// 失败时保留失败阶段、次数和原因
+	require.NoError(t, db.FailDocument(ctx, docID, "image", 2, "2/2 scenes failed"))
+	docs, err := db.ListFailedDocuments(ctx, "", 10)
+	require.NoError(t, err)
+	require.Len(t, docs, 1)
+	assert.Equal(t, DocumentStatusFailed, docs[0].Status)
+	assert.Equal(t, "image", docs[0].FailedStage)
+	assert.Equal(t, 2, docs[0].Attempts)
+	assert.Equal(t, "2/2 scenes failed", docs[0].LastError)
+	docs, err = db.ListFailedDocuments(ctx, docID, 10)
+	require.NoError(t, err)
+	assert.Empty(t, docs)
+
+	failed, err := db.ListFailedScenes(ctx, "", 10)
+	require.NoError(t, err)
+	assert.Len(t, failed, 2)
+
+	// 只恢复一个场景，恢复后重新进入待生成列表
+	n, err := db.ResetFailedScenes(ctx, docID, scenes[0].ID)
+	require.NoError(t, err)
+	assert.Equal(t, int64(1), n)
+	pending, err := db.ListPendingImageScenes(ctx, docID)
+	require.NoError(t, err)
+	require.Len(t, pending, 1)
+	assert.Equal(t, scenes[0].ID, pending[0].ID)
+	assert.Zero(t, pending[0].Attempts)
+	assert.Equal(t, "placeholder-voice", pending[0].VoiceURL)
+
+	n, err = db.ResetFailedScenes(ctx, docID, "")
+	require.NoError(t, err)
+	assert.Equal(t, int64(1), n)
+	failed, err = db.ListFailedScenes(ctx, "", 10)
+	require.NoError(t, err)
+	assert.Empty(t, failed)
+
+	// 进入其它状态时清空失败阶段
+	require.NoError(t, db.UpdateDocumentStatus(ctx, docID, DocumentStatusSceneReady))
+	doc, err := db.GetDocument(ctx, docID)
+	require.NoError(t, err)
+	assert.Empty(t, doc.FailedStage)
+	assert.Zero(t, doc.Attempts)
+}
+
 func TestFullFlow(t *testing.T) {
 	db := setupTestDB(t)
 	ctx := context.Background()
@@ -452,3 +1037,504 @@ func TestFullFlow(t *testing.T) {
 	require.NoError(t, err)
 	assert.Equal(t, DocumentStatusImgReady, finalDoc.Status)
 }
+
+func TestCachedDatabase(t *testing.T) {
+	raw := setupTestDB(t)
+	ctx := context.Background()
+	assert.Same(t, raw, NewCachedDatabase(raw, nil))
+	db := NewCachedDatabase(raw, cache.NewMemory(cache.Config{ExpireSecs: 60}))
+
+	// 文档：绕过缓存的修改读不到，经过缓存的修改使缓存失效
+	docID := MakeUUID()
+	_, err := db.CreateDocument(ctx, docID, "file-id", &api.CreateDocumentArgs{Name: "缓存文档"})
+	require.NoError(t, err)
+	doc, err := db.GetDocument(ctx, docID)
+	require.NoError(t, err)
+	assert.Equal(t, DocumentStatusChapterReady, doc.Status)
+	require.NoError(t, raw.UpdateDocumentSummary(ctx, docID, "摘要"))
+	doc, err = db.GetDocument(ctx, docID)
+	require.NoError(t, err)
+	assert.Empty(t, doc.Summary)
+	require.NoError(t, db.UpdateDocumentStatus(ctx, docID, DocumentStatusRoleReady))
+	doc, err = db.GetDocument(ctx, docID)
+	require.NoError(t, err)
+	assert.Equal(t, DocumentStatusRoleReady, doc.Status)
+	assert.Equal(t, "摘要", doc.Summary)
+	_, err = db.GetDocument(ctx, MakeUUID())
+	assert.ErrorIs(t, err, gorm.ErrRecordNotFound)
+
+	// 章节
+	require.NoError(t, db.CreateChapters(ctx, docID, []string{"第一章", "第二章"}))
+	chapters, err := db.ListChapters(ctx, docID)
+	require.NoError(t, err)
+	require.Len(t, chapters, 2)
+	require.NoError(t, raw.UpdateChapter(ctx, chapters[0].ID, &api.UpdateChapterArgs{Content: "旧内容"}))
+	cached, err := db.ListChapters(ctx, docID)
+	require.NoError(t, err)
+	assert.Equal(t, "第一章", cached[0].Content)
+	require.NoError(t, db.UpdateChapter(ctx, chapters[0].ID, &api.UpdateChapterArgs{Content: "新内容"}))
+	cached, err = db.ListChapters(ctx, docID)
+	require.NoError(t, err)
+	assert.Equal(t, "新内容", cached[0].Content)
+
+	// 读从库的结果不写入缓存
+	require.NoError(t, db.UpdateChapter(ctx, chapters[1].ID, &api.UpdateChapterArgs{Content: "从库内容"}))
+	cached, err = db.ListChapters(dbutil.WithReplica(ctx), docID)
+	require.NoError(t, err)
+	assert.Equal(t, "从库内容", cached[1].Content)
+	require.NoError(t, raw.UpdateChapter(ctx, chapters[1].ID, &api.UpdateChapterArgs{Content: "第二章"}))
+	cached, err = db.ListChapters(ctx, docID)
+	require.NoError(t, err)
+	assert.Equal(t, "第二章", cached[1].Content)
+
+	// 场景
+	first := Scene{ID: MakeUUID(), ChapterID: chapters[0].ID, DocumentID: docID, Index: 0, Content: "场景1"}
+	second := Scene{ID: MakeUUID(), ChapterID: chapters[1].ID, DocumentID: docID, Index: 1, Content: "场景2"}
+	require.NoError(t, db.CreateScenes(ctx, []Scene{first, second}))
+	scenes, err := db.ListScenesByChapter(ctx, chapters[0].ID)
+	require.NoError(t, err)
+	require.Len(t, scenes, 1)
+	require.NoError(t, raw.UpdateSceneImageURL(ctx, first.ID, "http://img/1"))
+	scenes, err = db.ListScenesByChapter(ctx, chapters[0].ID)
+	require.NoError(t, err)
+	assert.Empty(t, scenes[0].ImageURL)
+	require.NoError(t, db.UpdateSceneStatus(ctx, first.ID, SceneStatusReady, 0, ""))
+	scenes, err = db.ListScenesByChapter(ctx, chapters[0].ID)
+	require.NoError(t, err)
+	assert.Equal(t, "http://img/1", scenes[0].ImageURL)
+	assert.Equal(t, SceneStatusReady, scenes[0].Status)
+
+	// 插入场景使后续章节的场景序号加一
+	scenes, err = db.ListScenesByChapter(ctx, chapters[1].ID)
+	require.NoError(t, err)
+	assert.Equal(t, 1, scenes[0].Index)
+	require.NoError(t, db.UpdateChapterSceneIDs(ctx, chapters[0].ID, []string{first.ID}))
+	require.NoError(t, db.InsertScene(ctx, first.ID, &Scene{ID: MakeUUID(), ChapterID: chapters[0].ID, Content: "插入"}))
+	scenes, err = db.ListScenesByChapter(ctx, chapters[1].ID)
+	require.NoError(t, err)
+	assert.Equal(t, 2, scenes[0].Index)
+	cached, err = db.ListChapters(ctx, docID)
+	require.NoError(t, err)
+	assert.Len(t, cached[0].SceneIDs, 2)
+
+	// 删除文档后不再读到缓存
+	_, err = db.DeleteDocument(ctx, docID)
+	require.NoError(t, err)
+	_, err = db.GetDocument(ctx, docID)
+	assert.ErrorIs(t, err, gorm.ErrRecordNotFound)
+}
+
+func TestMigrate(t *testing.T) {
+	ctx := context.Background()
+	gdb, err := gorm.Open(sqlite.Open(":memory:"), &gorm.Config{
+		DisableForeignKeyConstraintWhenMigrating: true,
+	})
+	require.NoError(t, err)
+	db := &Database{db: gdb}
+
+	// 新库：initSchema 建表，已有迁移全部记为已执行
+	ids, err := db.Migrate(ctx)
+	require.NoError(t, err)
+	assert.Len(t, ids, len(migrations))
+	assert.True(t, gdb.Migrator().HasTable(&Document{}))
+	assert.True(t, gdb.Migrator().HasColumn(&Chapter{}, "DuplicateOf"))
+
+	// 重复执行没有待执行的迁移
+	ids, err = db.Migrate(ctx)
+	require.NoError(t, err)
+	assert.Empty(t, ids)
+
+	// 回滚最近的迁移
+	ids, err = db.RollbackMigrations(ctx, 1)
+	require.NoError(t, err)
+	assert.Equal(t, []string{migrations[len(migrations)-1].ID}, ids)
+	assert.False(t, gdb.Migrator().HasColumn(&SearchEntry{}, "Display"))
+	statuses, err := db.MigrationStatus(ctx)
+	require.NoError(t, err)
+	require.Len(t, statuses, len(migrations))
+	assert.True(t, statuses[0].Applied)
+	assert.False(t, statuses[len(statuses)-1].Applied)
+
+	// 再次执行只补上回滚的迁移
+	ids, err = db.Migrate(ctx)
+	require.NoError(t, err)
+	assert.Equal(t, []string{migrations[len(migrations)-1].ID}, ids)
+	assert.True(t, gdb.Migrator().HasColumn(&SearchEntry{}, "Display"))
+
+	// 不可回滚的迁移
+	m := &migrator{
+		db: gdb,
+		migrations: append(migrations, Migration{
+			ID:      "209912310001_irreversible",
+			Migrate: func(tx *gorm.DB) error { return nil },
+		}),
+		initSchema: initSchema,
+	}
+	ids, err = m.migrate(ctx)
+	require.NoError(t, err)
+	assert.Equal(t, []string{"209912310001_irreversible"}, ids)
+	_, err = m.rollback(ctx, 1)
+	assert.ErrorIs(t, err, ErrIrreversible)
+}
+
+func TestDeleteDocumentCascade(t *testing.T) {
+	db := setupTestDB(t)
+	ctx := context.Background()
+
+	createDoc := func(imageURL, runImageURL string) (string, []Scene) {
+		docID := MakeUUID()
+		_, err := db.CreateDocument(ctx, docID, "", &api.CreateDocumentArgs{Name: "文档" + docID})
+		require.NoError(t, err)
+		require.NoError(t, db.UpdateDocumentSummaryImageURL(ctx, docID, "/v1/images/cover-"+docID))
+		require.NoError(t, db.CreateChapters(ctx, docID, []string{"第一章"}))
+		chapters, err := db.ListChapters(ctx, docID)
+		require.NoError(t, err)
+		scenes := []Scene{
+			{ID: MakeUUID(), ChapterID: chapters[0].ID, DocumentID: docID, Index: 0, ImageURL: imageURL, VoiceURL: "/v1/audio/voice-" + docID},
+		}
+		require.NoError(t, db.CreateScenes(ctx, scenes))
+		require.NoError(t, db.CreateSceneGenerations(ctx, scenes[0].ID, []SceneGeneration{{ImageURL: "/v1/images/old-" + docID}}, 5))
+		require.NoError(t, db.CreateSceneImageVersions(ctx, scenes[0].ID, []SceneImageVersion{{ImageURL: "/v1/images/version-" + docID}}, 5))
+		require.NoError(t, db.CreateRoles(ctx, []Role{{ID: MakeUUID(), DocumentID: docID, Name: "主角", PortraitURL: "/v1/images/role-" + docID}}))
+		require.NoError(t, db.CreateDocumentLog(ctx, &DocumentLog{DocumentID: docID, Message: "ok"}))
+		require.NoError(t, db.CreateBookmark(ctx, &Bookmark{ID: MakeUUID(), DocumentID: docID, ChapterID: chapters[0].ID}))
+		require.NoError(t, db.UpdateChapterCover(ctx, chapters[0].ID, scenes[0].ID, "/v1/images/chapter-"+docID))
+		require.NoError(t, db.CreateDocumentRun(ctx, &DocumentRun{ID: MakeUUID(), DocumentID: docID}, []RunScene{
+			{SceneID: scenes[0].ID, ChapterID: chapters[0].ID, ImageURL: runImageURL, VoiceURL: "/v1/audio/run-" + docID},
+		}))
+		require.NoError(t, db.CreateExport(ctx, &Export{ID: MakeUUID(), DocumentID: docID, Status: ExportStatusSucceeded}))
+		return docID, scenes
+	}
+	// 两个文档的场景都引用 shared；run-shared 只被处理记录引用，另一文档回滚到该次处理时仍需要
+	docID, scenes := createDoc("/v1/images/shared", "/v1/images/run-shared")
+	otherID, _ := createDoc("/v1/images/shared", "/v1/images/run-shared")
+
+	// 事务中途失败时整体回滚
+	require.NoError(t, db.db.Migrator().RenameTable(&ReadPosition{}, "read_positions_bak"))
+	_, err := db.DeleteDocument(ctx, docID)
+	require.Error(t, err)
+	_, err = db.GetDocument(ctx, docID)
+	require.NoError(t, err)
+	_, err = db.GetScene(ctx, scenes[0].ID)
+	require.NoError(t, err)
+	require.NoError(t, db.db.Migrator().RenameTable("read_positions_bak", &ReadPosition{}))
+
+	urls, err := db.DeleteDocument(ctx, docID)
+	require.NoError(t, err)
+	assert.ElementsMatch(t, []string{
+		"/v1/images/cover-" + docID, "/v1/images/role-" + docID, "/v1/images/shared",
+		"/v1/audio/voice-" + docID, "/v1/images/old-" + docID, "/v1/images/version-" + docID,
+		"/v1/images/chapter-" + docID, "/v1/images/run-shared", "/v1/audio/run-" + docID,
+	}, urls)
+	_, err = db.GetDocument(ctx, docID)
+	assert.ErrorIs(t, err, gorm.ErrRecordNotFound)
+	chapters, err := db.ListChapters(ctx, docID)
+	require.NoError(t, err)
+	assert.Empty(t, chapters)
+	_, err = db.GetScene(ctx, scenes[0].ID)
+	assert.ErrorIs(t, err, gorm.ErrRecordNotFound)
+	gens, err := db.ListSceneGenerations(ctx, scenes[0].ID)
+	require.NoError(t, err)
+	assert.Empty(t, gens)
+	versions, err := db.ListSceneImageVersions(ctx, scenes[0].ID)
+	require.NoError(t, err)
+	assert.Empty(t, versions)
+	roles, err := db.ListRolesByDocument(ctx, docID)
+	require.NoError(t, err)
+	assert.Empty(t, roles)
+	logs, err := db.ListDocumentLogs(ctx, docID, 10)
+	require.NoError(t, err)
+	assert.Empty(t, logs)
+	runs, err := db.ListDocumentRuns(ctx, docID)
+	require.NoError(t, err)
+	assert.Empty(t, runs)
+	var runScenes int64
+	require.NoError(t, db.db.Model(&RunScene{}).Where("scene_id = ?", scenes[0].ID).Count(&runScenes).Error)
+	assert.Zero(t, runScenes)
+	exports, err := db.ListExports(ctx, docID)
+	require.NoError(t, err)
+	assert.Empty(t, exports)
+
+	// 其他文档仍引用的媒体不清理，包括只被其处理记录引用的媒体
+	referenced, err := db.ListReferencedMediaURLs(ctx, urls)
+	require.NoError(t, err)
+	assert.Equal(t, []string{"/v1/images/run-shared", "/v1/images/shared"}, referenced)
+	_, err = db.GetDocument(ctx, otherID)
+	require.NoError(t, err)
+	runs, err = db.ListDocumentRuns(ctx, otherID)
+	require.NoError(t, err)
+	assert.Len(t, runs, 1)
+	exports, err = db.ListExports(ctx, otherID)
+	require.NoError(t, err)
+	assert.Len(t, exports, 1)
+}
+
+func TestAuditLogs(t *testing.T) {
+	db := setupTestDB(t)
+	ctx := context.Background()
+
+	for _, l := range []AuditLog{
+		{UserID: 1, Action: "update", ResourceType: "document", ResourceID: "doc1", DocumentID: "doc1"},
+		{UserID: 2, Action: "delete", ResourceType: "chapter", ResourceID: "ch1", DocumentID: "doc1"},
+		{UserID: 1, Action: "update", ResourceType: "scene", ResourceID: "s1", DocumentID: "doc2"},
+	} {
+		require.NoError(t, db.CreateAuditLog(ctx, &l))
+	}
+
+	logs, err := db.ListAuditLogs(ctx, AuditLogFilter{}, 0, 10)
+	require.NoError(t, err)
+	require.Len(t, logs, 3)
+	assert.Equal(t, "s1", logs[0].ResourceID)
+
+	logs, err = db.ListAuditLogs(ctx, AuditLogFilter{DocumentID: "doc1"}, 0, 10)
+	require.NoError(t, err)
+	assert.Len(t, logs, 2)
+	userID := int64(1)
+	logs, err = db.ListAuditLogs(ctx, AuditLogFilter{UserID: &userID, ResourceType: "document"}, 0, 10)
+	require.NoError(t, err)
+	require.Len(t, logs, 1)
+	assert.Equal(t, "doc1", logs[0].ResourceID)
+
+	// 按 marker 翻页
+	logs, err = db.ListAuditLogs(ctx, AuditLogFilter{}, 0, 2)
+	require.NoError(t, err)
+	require.Len(t, logs, 2)
+	logs, err = db.ListAuditLogs(ctx, AuditLogFilter{}, logs[1].ID, 2)
+	require.NoError(t, err)
+	require.Len(t, logs, 1)
+	assert.Equal(t, "doc1", logs[0].ResourceID)
+}
+
+func TestDocumentStages(t *testing.T) {
+	db := setupTestDB(t)
+	ctx := context.Background()
+
+	splitStartedAt := time.Now().Add(-time.Minute).Truncate(time.Second)
+	docID := MakeUUID()
+	_, err := db.CreateDocument(ctx, docID, "", &api.CreateDocumentArgs{Name: "阶段", SplitStartedAt: splitStartedAt})
+	require.NoError(t, err)
+	doc, err := db.GetDocument(ctx, docID)
+	require.NoError(t, err)
+	require.NotNil(t, doc.Stages[StageSplitting].StartedAt)
+	assert.True(t, doc.Stages[StageSplitting].StartedAt.Equal(splitStartedAt))
+	assert.NotNil(t, doc.Stages[StageSplitting].FinishedAt)
+	assert.NotNil(t, doc.Stages[StageRoles].StartedAt)
+	assert.Nil(t, doc.Stages[StageRoles].FinishedAt)
+	assert.NotContains(t, doc.Stages, StageScenes)
+
+	require.NoError(t, db.UpdateDocumentStatus(ctx, docID, DocumentStatusRoleReady))
+	require.NoError(t, db.UpdateDocumentStatus(ctx, docID, DocumentStatusSceneReady))
+	doc, err = db.GetDocument(ctx, docID)
+	require.NoError(t, err)
+	assert.NotNil(t, doc.Stages[StageScenes].FinishedAt)
+	assert.NotNil(t, doc.Stages[StageImages].StartedAt)
+	assert.NotNil(t, doc.Stages[StageVoices].StartedAt)
+
+	// 失败时保持不变，未完成的阶段即为失败所在的阶段
+	require.NoError(t, db.FailDocument(ctx, docID, "image", 3, "timeout"))
+	failed, err := db.GetDocument(ctx, docID)
+	require.NoError(t, err)
+	assert.Equal(t, doc.Stages, failed.Stages)
+
+	require.NoError(t, db.UpdateDocumentStatus(ctx, docID, DocumentStatusImgReady))
+	doc, err = db.GetDocument(ctx, docID)
+	require.NoError(t, err)
+	for _, stage := range Stages {
+		assert.NotNil(t, doc.Stages[stage].FinishedAt, stage)
+	}
+	assert.Empty(t, doc.LastError)
+
+	// 回到已完成的阶段时重新计时
+	require.NoError(t, db.UpdateDocumentStatus(ctx, docID, DocumentStatusRoleReady))
+	doc, err = db.GetDocument(ctx, docID)
+	require.NoError(t, err)
+	assert.NotNil(t, doc.Stages[StageRoles].FinishedAt)
+	assert.NotNil(t, doc.Stages[StageScenes].StartedAt)
+	assert.Nil(t, doc.Stages[StageScenes].FinishedAt)
+	assert.NotContains(t, doc.Stages, StageImages)
+
+	assert.ErrorIs(t, db.UpdateDocumentStatus(ctx, MakeUUID(), DocumentStatusRoleReady), gorm.ErrRecordNotFound)
+}
+
+func TestCancelDocument(t *testing.T) {
+	db := setupTestDB(t)
+	ctx := context.Background()
+
+	docID := MakeUUID()
+	_, err := db.CreateDocument(ctx, docID, "", &api.CreateDocumentArgs{Name: "取消"})
+	require.NoError(t, err)
+	require.NoError(t, db.UpdateDocumentStatus(ctx, docID, DocumentStatusRoleReady))
+	require.NoError(t, db.CancelDocument(ctx, docID))
+	doc, err := db.GetDocument(ctx, docID)
+	require.NoError(t, err)
+	assert.Equal(t, DocumentStatusCanceled, doc.Status)
+	assert.Nil(t, doc.Stages[StageScenes].FinishedAt)
+
+	// 取消后的处理结果不覆盖取消状态
+	assert.ErrorIs(t, db.UpdateDocumentStatus(ctx, docID, DocumentStatusSceneReady), ErrDocumentCanceled)
+	assert.ErrorIs(t, db.FailDocument(ctx, docID, "scene", 1, "timeout"), gorm.ErrRecordNotFound)
+	doc, err = db.GetDocument(ctx, docID)
+	require.NoError(t, err)
+	assert.Equal(t, DocumentStatusCanceled, doc.Status)
+
+	assert.ErrorIs(t, db.CancelDocument(ctx, docID), ErrDocumentFinished)
+	assert.ErrorIs(t, db.CancelDocument(ctx, MakeUUID()), gorm.ErrRecordNotFound)
+}
+
+func TestGetDocumentWithContentHash(t *testing.T) {
+	db := setupTestDB(t)
+	ctx := context.Background()
+
+	hash := strings.Repeat("a", 64)
+	_, err := db.CreateDocument(ctx, "doc1", "", &api.CreateDocumentArgs{Name: "原文", UserID: 1, ContentHash: hash})
+	require.NoError(t, err)
+	_, err = db.CreateDocument(ctx, "doc2", "", &api.CreateDocumentArgs{Name: "旧文档", UserID: 1})
+	require.NoError(t, err)
+
+	doc, err := db.GetDocumentWithContentHash(ctx, 1, hash)
+	require.NoError(t, err)
+	assert.Equal(t, "doc1", doc.ID)
+
+	// 其他租户的相同内容不算重复
+	_, err = db.GetDocumentWithContentHash(ctx, 2, hash)
+	assert.ErrorIs(t, err, gorm.ErrRecordNotFound)
+	_, err = db.GetDocumentWithContentHash(ctx, 1, strings.Repeat("b", 64))
+	assert.ErrorIs(t, err, gorm.ErrRecordNotFound)
+}
+
+func TestImportDocument(t *testing.T) {
+	db := setupTestDB(t)
+	ctx := context.Background()
+
+	doc := &Document{ID: "doc1", Name: "导入文档", UserID: 1, Status: DocumentStatusImgReady}
+	chapters := []Chapter{{ID: "c1", DocumentID: "doc1", Index: 0, Content: "内容", SceneIDs: []string{"s1"}}}
+	roles := []Role{{ID: "r1", DocumentID: "doc1", Name: "张三"}}
+	scenes := []Scene{{ID: "s1", ChapterID: "c1", DocumentID: "doc1", Content: "场景"}}
+	require.NoError(t, db.ImportDocument(ctx, doc, chapters, roles, scenes))
+
+	got, err := db.GetDocument(ctx, "doc1")
+	require.NoError(t, err)
+	assert.Equal(t, DocumentNameKey("导入文档"), got.NameKey)
+	// 已处理完的文档各阶段都记为完成
+	require.Contains(t, got.Stages, StageVoices)
+	assert.NotNil(t, got.Stages[StageVoices].FinishedAt)
+	chapter, err := db.GetChapter(ctx, "c1", "doc1")
+	require.NoError(t, err)
+	assert.Equal(t, []string{"s1"}, chapter.SceneIDs)
+	_, err = db.GetRole(ctx, "r1")
+	require.NoError(t, err)
+	tasks, err := db.ListIndexTasks(ctx, 10)
+	require.NoError(t, err)
+	require.Len(t, tasks, 1)
+	assert.Equal(t, "doc1", tasks[0].DocumentID)
+
+	// 任一记录失败时整体回滚
+	doc = &Document{ID: "doc2", Name: "导入文档2", Status: DocumentStatusImgReady}
+	err = db.ImportDocument(ctx, doc, []Chapter{{ID: "c1", DocumentID: "doc2"}}, nil, nil)
+	require.Error(t, err)
+	_, err = db.GetDocument(ctx, "doc2")
+	assert.ErrorIs(t, err, gorm.ErrRecordNotFound)
+}
+
+func TestSnapshot(t *testing.T) {
+	db := setupTestDB(t)
+	ctx := context.Background()
+	require.NoError(t, db.db.AutoMigrate(models...))
+
+	_, err := db.CreateDocument(ctx, "doc1", "file1", &api.CreateDocumentArgs{Name: "备份文档", UserID: 1})
+	require.NoError(t, err)
+	require.NoError(t, db.CreateChapters(ctx, "doc1", []string{"第一章", "第二章"}))
+	require.NoError(t, db.CreateScenes(ctx, []Scene{{ID: "s1", DocumentID: "doc1", Content: "场景", Overrides: api.SceneOverrides{Style: "水彩"}}}))
+
+	var buf bytes.Buffer
+	stats, err := db.WriteSnapshot(ctx, &buf)
+	require.NoError(t, err)
+	assert.Equal(t, SnapshotVersion, stats.Header.Version)
+	assert.Equal(t, int64(1), stats.Tables["documents"])
+	assert.Equal(t, int64(2), stats.Tables["chapters"])
+	assert.Equal(t, int64(1), stats.Tables["index_tasks"])
+	snapshot := buf.Bytes()
+
+	// 备份后的修改在恢复后丢失
+	_, err = db.CreateDocument(ctx, "doc2", "file2", &api.CreateDocumentArgs{Name: "新文档", UserID: 1})
+	require.NoError(t, err)
+	require.NoError(t, db.UpdateDocumentStatus(ctx, "doc1", DocumentStatusFailed))
+
+	// dry run 不修改数据
+	stats, err = db.RestoreSnapshot(ctx, bytes.NewReader(snapshot), true)
+	require.NoError(t, err)
+	assert.Equal(t, int64(1), stats.Tables["documents"])
+	_, err = db.GetDocument(ctx, "doc2")
+	require.NoError(t, err)
+
+	stats, err = db.RestoreSnapshot(ctx, bytes.NewReader(snapshot), false)
+	require.NoError(t, err)
+	assert.Equal(t, int64(2), stats.Tables["chapters"])
+	_, err = db.GetDocument(ctx, "doc2")
+	assert.ErrorIs(t, err, gorm.ErrRecordNotFound)
+	doc, err := db.GetDocument(ctx, "doc1")
+	require.NoError(t, err)
+	assert.Equal(t, DocumentStatusChapterReady, doc.Status)
+	assert.Equal(t, "备份文档", doc.Name)
+	scene, err := db.GetScene(ctx, "s1")
+	require.NoError(t, err)
+	assert.Equal(t, "水彩", scene.Overrides.Style)
+	chapters, err := db.ListChapters(ctx, "doc1")
+	require.NoError(t, err)
+	assert.Len(t, chapters, 2)
+
+	// 格式错误或迁移不一致时不修改数据
+	_, err = db.RestoreSnapshot(ctx, strings.NewReader("not gzip"), false)
+	assert.ErrorIs(t, err, ErrInvalidSnapshot)
+	require.NoError(t, db.migrator().record(db.db, []string{"202701010001_future"}))
+	_, err = db.RestoreSnapshot(ctx, bytes.NewReader(snapshot), false)
+	assert.ErrorIs(t, err, ErrInvalidSnapshot)
+	_, err = db.GetDocument(ctx, "doc1")
+	require.NoError(t, err)
+}
+
+func TestTranslation(t *testing.T) {
+	db := setupTestDB(t)
+	ctx := context.Background()
+
+	tr, err := db.StartTranslation(ctx, 1, "doc1", "en")
+	require.NoError(t, err)
+	assert.Equal(t, TranslationStatusPending, tr.Status)
+	pending, err := db.ListPendingTranslations(ctx, 10)
+	require.NoError(t, err)
+	require.Len(t, pending, 1)
+
+	// 领取后重复创建不修改任务
+	require.NoError(t, db.TransitTranslation(ctx, "doc1", "en", []string{TranslationStatusPending}, TranslationStatusRunning))
+	assert.ErrorIs(t, db.TransitTranslation(ctx, "doc1", "en", []string{TranslationStatusPending}, TranslationStatusRunning), gorm.ErrRecordNotFound)
+	tr, err = db.StartTranslation(ctx, 1, "doc1", "en")
+	require.NoError(t, err)
+	assert.Equal(t, TranslationStatusRunning, tr.Status)
+
+	require.NoError(t, db.UpdateTranslationProgress(ctx, "doc1", "en", 1, 2))
+	require.NoError(t, db.CompleteTranslation(ctx, "doc1", "en", TranslationStatusFailed, "boom"))
+	assert.ErrorIs(t, db.CompleteTranslation(ctx, "doc1", "en", TranslationStatusSucceeded, ""), gorm.ErrRecordNotFound)
+	tr, err = db.GetTranslation(ctx, "doc1", "en")
+	require.NoError(t, err)
+	assert.Equal(t, TranslationStatusFailed, tr.Status)
+	assert.Equal(t, 1, tr.Translated)
+	assert.Equal(t, "boom", tr.Error)
+
+	// 已结束的任务重新执行
+	tr, err = db.StartTranslation(ctx, 1, "doc1", "en")
+	require.NoError(t, err)
+	assert.Equal(t, TranslationStatusPending, tr.Status)
+	assert.Empty(t, tr.Error)
+	assert.Zero(t, tr.Translated)
+
+	require.NoError(t, db.SaveChapterTranslation(ctx, &ChapterTranslation{ChapterID: "c1", Lang: "en", DocumentID: "doc1", Content: "v1"}))
+	require.NoError(t, db.SaveChapterTranslation(ctx, &ChapterTranslation{ChapterID: "c1", Lang: "en", DocumentID: "doc1", Content: "v2"}))
+	require.NoError(t, db.SaveChapterTranslation(ctx, &ChapterTranslation{ChapterID: "c1", Lang: "ja", DocumentID: "doc1", Content: "ja"}))
+	translations, err := db.ListChapterTranslations(ctx, "doc1", "en")
+	require.NoError(t, err)
+	require.Len(t, translations, 1)
+	assert.Equal(t, "v2", translations[0].Content)
+	ct, err := db.GetChapterTranslation(ctx, "c1", "ja")
+	require.NoError(t, err)
+	assert.Equal(t, "ja", ct.Content)
+}
@@ -0,0 +1,33 @@
+package db
+
+import (
+	"context"
+
+	"gorm.io/gorm"
+)
+
+// ChapterDuplicate 上传时检测到的高度相似章节
+type ChapterDuplicate struct {
+	ChapterID   string
+	DuplicateOf string // 最相似的前文章节 id
+	Similarity  float64
+}
+
+// MarkDuplicateChapters 标记文档中与前文高度相似的章节
+func (db *Database) MarkDuplicateChapters(ctx context.Context, documentID string, duplicates []ChapterDuplicate) error {
+	return db.db.WithContext(ctx).Transaction(func(tx *gorm.DB) error {
+		for _, d := range duplicates {
+			_, err := gorm.G[Chapter](tx).Where("id = ? AND document_id = ?", d.ChapterID, documentID).
+				Updates(ctx, Chapter{DuplicateOf: d.DuplicateOf, Similarity: d.Similarity})
+			if err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+}
+
+// ListDuplicateChapters 按章节序号列取文档中被标记为相似的章节
+func (db *Database) ListDuplicateChapters(ctx context.Context, documentID string) ([]Chapter, error) {
+	return gorm.G[Chapter](db.db).Where("document_id = ? AND duplicate_of <> ''", documentID).Order("`index` ASC").Find(ctx)
+}
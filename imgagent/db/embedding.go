@@ -0,0 +1,50 @@
+package db
+
+import (
+	"context"
+	"time"
+
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+)
+
+// ChapterEmbedding 章节向量，默认的向量存储，相似度在服务端计算
+type ChapterEmbedding struct {
+	ChapterID  string    `gorm:"primaryKey;size:32;comment:'章节 id'"`
+	DocumentID string    `gorm:"index:idx_embedding_document_id;size:32;comment:'文档 id'"`
+	Hash       string    `gorm:"size:64;comment:'章节标题和内容的 sha256，变化后重新计算'"`
+	Model      string    `gorm:"size:100;comment:'向量模型'"`
+	Vector     []float32 `gorm:"type:json;serializer:json;comment:'向量'"`
+	UpdatedAt  time.Time `gorm:"comment:'更新时间'"`
+}
+
+func (ChapterEmbedding) TableName() string {
+	return "chapter_embeddings"
+}
+
+// SaveChapterEmbeddings 写入章节向量，已存在的章节覆盖
+func (db *Database) SaveChapterEmbeddings(ctx context.Context, embeddings []ChapterEmbedding) error {
+	if len(embeddings) == 0 {
+		return nil
+	}
+	return db.db.WithContext(ctx).Clauses(clause.OnConflict{UpdateAll: true}).CreateInBatches(&embeddings, batchSize).Error
+}
+
+// ListChapterEmbeddings 列取文档的章节向量，withVector 为 false 时不读取向量数据
+func (db *Database) ListChapterEmbeddings(ctx context.Context, documentID string, withVector bool) ([]ChapterEmbedding, error) {
+	q := gorm.G[ChapterEmbedding](db.db).Where("document_id = ?", documentID)
+	if !withVector {
+		q = q.Omit("vector")
+	}
+	return q.Find(ctx)
+}
+
+// DeleteChapterEmbeddings 删除文档的章节向量，chapterIDs 为空时删除文档的全部向量
+func (db *Database) DeleteChapterEmbeddings(ctx context.Context, documentID string, chapterIDs []string) error {
+	q := gorm.G[ChapterEmbedding](db.db).Where("document_id = ?", documentID)
+	if len(chapterIDs) > 0 {
+		q = q.Where("chapter_id IN ?", chapterIDs)
+	}
+	_, err := q.Delete(ctx)
+	return err
+}
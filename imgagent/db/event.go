@@ -0,0 +1,72 @@
+package db
+
+import (
+	"context"
+	"slices"
+	"time"
+
+	"gorm.io/gorm"
+)
+
+const (
+	// EventDocumentFinished 文档生成流程结束（imgReady / completedWithErrors / failed）
+	EventDocumentFinished = "document.finished"
+	// EventDocumentPaused 文档处理被暂停，EventDocumentResumed 文档恢复处理
+	EventDocumentPaused  = "document.paused"
+	EventDocumentResumed = "document.resumed"
+	// EventDocumentCanceled 文档处理被取消
+	EventDocumentCanceled = "document.canceled"
+	// EventDocumentRequeued 失败的文档或场景被管理员重新入队
+	EventDocumentRequeued = "document.requeued"
+	// EventSceneFlagged 场景重试耗尽被标记为失败，已替换为占位媒体
+	EventSceneFlagged = "scene.flagged"
+	// EventSceneBlocked 场景未通过内容审核，已替换为占位媒体，等待管理员复核
+	EventSceneBlocked = "scene.blocked"
+	// EventQuotaWarning 配额用量越过预警线
+	EventQuotaWarning = "quota.warning"
+	// EventQuotaExceeded 配额用量达到上限
+	EventQuotaExceeded = "quota.exceeded"
+	// EventPipelinePanic 文档或场景处理中发生 panic，对应文档或场景已标记为失败
+	EventPipelinePanic = "pipeline.panic"
+	// EventExportReady 导出任务完成，产物可下载
+	EventExportReady = "export.ready"
+)
+
+// Event 事件日志表，按用户（租户）记录其资源上发生的事件
+type Event struct {
+	ID         int64     `gorm:"primaryKey;autoIncrement"`
+	UserID     int64     `gorm:"index:idx_event_user_id;comment:'用户（租户） id'"`
+	Type       string    `gorm:"size:32;comment:'事件类型'"`
+	DocumentID string    `gorm:"size:32;comment:'关联文档 id'"`
+	SceneID    string    `gorm:"size:32;comment:'关联场景 id'"`
+	Message    string    `gorm:"size:1024;comment:'事件描述'"`
+	Detail     string    `gorm:"type:text;comment:'内部排查信息（如 panic 调用栈），不通过接口返回'"`
+	CreatedAt  time.Time `gorm:"comment:'创建时间'"`
+}
+
+func (Event) TableName() string {
+	return "events"
+}
+
+func (db *Database) CreateEvent(ctx context.Context, event *Event) error {
+	return gorm.G[Event](db.db).Create(ctx, event)
+}
+
+// ListDocumentEvents 按时间顺序列取文档最近的 limit 条事件
+func (db *Database) ListDocumentEvents(ctx context.Context, documentID string, limit int) ([]Event, error) {
+	events, err := gorm.G[Event](db.db).Where("document_id = ?", documentID).Order("id DESC").Limit(limit).Find(ctx)
+	if err != nil {
+		return nil, err
+	}
+	slices.Reverse(events)
+	return events, nil
+}
+
+// ListEvents 按时间倒序列取用户的事件，marker 为上一页最后一条事件的 id，0 表示从最新开始
+func (db *Database) ListEvents(ctx context.Context, userID int64, marker int64, limit int) ([]Event, error) {
+	q := gorm.G[Event](db.db).Where("user_id = ?", userID)
+	if marker > 0 {
+		q = q.Where("id < ?", marker)
+	}
+	return q.Order("id DESC").Limit(limit).Find(ctx)
+}
@@ -0,0 +1,105 @@
+package db
+
+import (
+	"context"
+	"time"
+
+	"gorm.io/gorm"
+)
+
+const (
+	ExportStatusPending   = "pending"
+	ExportStatusRunning   = "running"
+	ExportStatusSucceeded = "succeeded"
+	ExportStatusFailed    = "failed"
+	ExportStatusCanceled  = "canceled"
+	// ExportStatusExpired 产物已过期清理，记录保留供查询
+	ExportStatusExpired = "expired"
+)
+
+// Export 导出任务，各导出格式共用。产物保存在导出目录下，Path 为相对路径
+type Export struct {
+	ID         string    `gorm:"primaryKey;size:32;comment:'主键'"`
+	UserID     int64     `gorm:"comment:'用户（租户） id'"`
+	DocumentID string    `gorm:"index:idx_export_document_id;size:32;comment:'文档 id'"`
+	Format     string    `gorm:"size:16;comment:'导出格式'"`
+	Status     string    `gorm:"index:idx_export_status;size:16;comment:'状态'"`
+	Size       int64     `gorm:"comment:'产物大小（字节）'"`
+	Path       string    `gorm:"size:128;comment:'产物路径'"`
+	Error      string    `gorm:"size:500;comment:'失败原因'"`
+	ExpiresAt  time.Time `gorm:"comment:'产物过期时间'"`
+	CreatedAt  time.Time `gorm:"comment:'创建时间'"`
+	UpdatedAt  time.Time `gorm:"comment:'更新时间'"`
+}
+
+func (Export) TableName() string {
+	return "exports"
+}
+
+func (db *Database) CreateExport(ctx context.Context, export *Export) error {
+	return gorm.G[Export](db.db).Create(ctx, export)
+}
+
+func (db *Database) GetExport(ctx context.Context, id string) (Export, error) {
+	return gorm.G[Export](db.db).Where("id = ?", id).Take(ctx)
+}
+
+// ListExports 列取文档的导出任务，最新的在前
+func (db *Database) ListExports(ctx context.Context, documentID string) ([]Export, error) {
+	return gorm.G[Export](db.db).Where("document_id = ?", documentID).Order("created_at DESC").Find(ctx)
+}
+
+func (db *Database) ListPendingExports(ctx context.Context, limit int) ([]Export, error) {
+	return gorm.G[Export](db.db).Where("status = ?", ExportStatusPending).Order("created_at ASC").Limit(limit).Find(ctx)
+}
+
+// ListExpiredExports 列取产物已过期的导出任务
+func (db *Database) ListExpiredExports(ctx context.Context, now time.Time, limit int) ([]Export, error) {
+	return gorm.G[Export](db.db).Where("status = ? AND expires_at <= ?", ExportStatusSucceeded, now).Limit(limit).Find(ctx)
+}
+
+// TransitExport 仅当任务处于 from 中的某个状态时更新状态，用于领取任务和取消任务，
+// 状态已变化时返回 gorm.ErrRecordNotFound
+func (db *Database) TransitExport(ctx context.Context, id string, from []string, to string) error {
+	result := db.db.WithContext(ctx).Model(&Export{}).Where("id = ? AND status IN ?", id, from).Updates(map[string]interface{}{
+		"status":     to,
+		"updated_at": time.Now(),
+	})
+	if result.Error != nil {
+		return result.Error
+	}
+	if result.RowsAffected == 0 {
+		return gorm.ErrRecordNotFound
+	}
+	return nil
+}
+
+// CompleteExport 记录执行结果，任务已被取消时不更新并返回 gorm.ErrRecordNotFound
+func (db *Database) CompleteExport(ctx context.Context, id string, status string, size int64, path string, errMsg string, expiresAt time.Time) error {
+	result := db.db.WithContext(ctx).Model(&Export{}).Where("id = ? AND status = ?", id, ExportStatusRunning).Updates(map[string]interface{}{
+		"status":     status,
+		"size":       size,
+		"path":       path,
+		"error":      errMsg,
+		"expires_at": expiresAt,
+		"updated_at": time.Now(),
+	})
+	if result.Error != nil {
+		return result.Error
+	}
+	if result.RowsAffected == 0 {
+		return gorm.ErrRecordNotFound
+	}
+	return nil
+}
+
+func (db *Database) DeleteExport(ctx context.Context, id string) error {
+	rowsAffected, err := gorm.G[Export](db.db).Where("id = ?", id).Delete(ctx)
+	if err != nil {
+		return err
+	}
+	if rowsAffected == 0 {
+		return gorm.ErrRecordNotFound
+	}
+	return nil
+}
@@ -0,0 +1,54 @@
+package db
+
+import (
+	"context"
+	"time"
+
+	"gorm.io/gorm"
+)
+
+// FailDocument 文档在 stage 阶段永久失败，保留失败次数和原因，用于死信列表展示和重新入队
+func (db *Database) FailDocument(ctx context.Context, id string, stage string, attempts int, lastError string) error {
+	// 已取消的文档保持取消状态
+	result := db.db.WithContext(ctx).Model(&Document{}).Where("id = ? AND status <> ?", id, DocumentStatusCanceled).Updates(map[string]interface{}{
+		"status":          DocumentStatusFailed,
+		"failed_stage":    stage,
+		"attempts":        attempts,
+		"last_error":      lastError,
+		"next_attempt_at": nil,
+	})
+	if result.Error != nil {
+		return result.Error
+	}
+	if result.RowsAffected == 0 {
+		return gorm.ErrRecordNotFound
+	}
+	return nil
+}
+
+// ListFailedDocuments 按 id 升序列取 id 大于 marker 的失败文档
+func (db *Database) ListFailedDocuments(ctx context.Context, marker string, limit int) ([]Document, error) {
+	return gorm.G[Document](db.db).Where("status = ? AND id > ?", DocumentStatusFailed, marker).Order("id ASC").Limit(limit).Find(ctx)
+}
+
+// ListFailedScenes 按 id 升序列取 id 大于 marker 的永久失败场景
+func (db *Database) ListFailedScenes(ctx context.Context, marker string, limit int) ([]Scene, error) {
+	return gorm.G[Scene](db.db).Where("status = ? AND id > ?", SceneStatusFailed, marker).Order("id ASC").Limit(limit).Find(ctx)
+}
+
+// ResetFailedScenes 将文档的永久失败场景恢复为待生成，sceneID 不为空时只恢复该场景。
+// 清空图片 URL 使场景重新进入待生成列表，占位媒体在重新生成成功前保留
+func (db *Database) ResetFailedScenes(ctx context.Context, documentID string, sceneID string) (int64, error) {
+	tx := db.db.WithContext(ctx).Model(&Scene{}).Where("document_id = ? AND status = ?", documentID, SceneStatusFailed)
+	if sceneID != "" {
+		tx = tx.Where("id = ?", sceneID)
+	}
+	result := tx.Updates(map[string]interface{}{
+		"status":     "",
+		"attempts":   0,
+		"error":      "",
+		"image_url":  "",
+		"updated_at": time.Now(),
+	})
+	return result.RowsAffected, result.Error
+}
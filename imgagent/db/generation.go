@@ -0,0 +1,73 @@
+package db
+
+import (
+	"context"
+	"time"
+
+	"gorm.io/gorm"
+)
+
+// SceneGeneration 场景每次生成的图片和语音，保留最近几次用于回滚
+type SceneGeneration struct {
+	ID                int64     `gorm:"primaryKey;autoIncrement"`
+	SceneID           string    `gorm:"uniqueIndex:uk_generation_scene_seq,priority:1;size:32;comment:'场景 id'"`
+	Seq               int       `gorm:"uniqueIndex:uk_generation_scene_seq,priority:2;comment:'场景内的生成序号，从 1 开始'"`
+	ImageURL          string    `gorm:"size:500;comment:'场景图片url'"`
+	VoiceURL          string    `gorm:"size:500;comment:'音频url'"`
+	AudioDurationMs   int64     `gorm:"comment:'语音时长（毫秒）'"`
+	DisplayDurationMs int64     `gorm:"comment:'建议展示时长（毫秒）'"`
+	CreatedAt         time.Time `gorm:"comment:'创建时间'"`
+}
+
+func (SceneGeneration) TableName() string {
+	return "scene_generations"
+}
+
+// CreateSceneGenerations 按顺序追加场景的生成记录，Seq 取已有最大序号加 1，只保留最近 keep 次生成
+func (db *Database) CreateSceneGenerations(ctx context.Context, sceneID string, gens []SceneGeneration, keep int) error {
+	return db.db.WithContext(ctx).Transaction(func(tx *gorm.DB) error {
+		var seq int
+		err := tx.Model(&SceneGeneration{}).Where("scene_id = ?", sceneID).Select("COALESCE(MAX(seq), 0)").Scan(&seq).Error
+		if err != nil {
+			return err
+		}
+		for i := range gens {
+			seq++
+			gens[i].SceneID = sceneID
+			gens[i].Seq = seq
+			if err = gorm.G[SceneGeneration](tx).Create(ctx, &gens[i]); err != nil {
+				return err
+			}
+		}
+		_, err = gorm.G[SceneGeneration](tx).Where("scene_id = ? AND seq <= ?", sceneID, seq-keep).Delete(ctx)
+		return err
+	})
+}
+
+func (db *Database) GetSceneGeneration(ctx context.Context, sceneID string, seq int) (SceneGeneration, error) {
+	return gorm.G[SceneGeneration](db.db).Where("scene_id = ? AND seq = ?", sceneID, seq).Take(ctx)
+}
+
+// ListSceneGenerations 按序号倒序列取场景的生成记录
+func (db *Database) ListSceneGenerations(ctx context.Context, sceneID string) ([]SceneGeneration, error) {
+	return gorm.G[SceneGeneration](db.db).Where("scene_id = ?", sceneID).Order("seq DESC").Find(ctx)
+}
+
+// ActivateSceneGeneration 将场景的图片、语音和时长恢复为指定的生成记录
+func (db *Database) ActivateSceneGeneration(ctx context.Context, gen *SceneGeneration) error {
+	result := db.db.WithContext(ctx).Model(&Scene{}).Where("id = ?", gen.SceneID).Updates(map[string]interface{}{
+		"image_url":           gen.ImageURL,
+		"voice_url":           gen.VoiceURL,
+		"audio_duration_ms":   gen.AudioDurationMs,
+		"display_duration_ms": gen.DisplayDurationMs,
+		"placeholder":         false,
+		"updated_at":          time.Now(),
+	})
+	if result.Error != nil {
+		return result.Error
+	}
+	if result.RowsAffected == 0 {
+		return gorm.ErrRecordNotFound
+	}
+	return nil
+}
@@ -0,0 +1,67 @@
+package db
+
+import (
+	"context"
+	"time"
+
+	"gorm.io/gorm"
+)
+
+// SceneImageVersion 场景生成过的图片，只记录图片，回退时不改变语音，保留最近几个版本
+type SceneImageVersion struct {
+	ID        int64     `gorm:"primaryKey;autoIncrement"`
+	SceneID   string    `gorm:"uniqueIndex:uk_image_version_scene_version,priority:1;size:32;comment:'场景 id'"`
+	Version   int       `gorm:"uniqueIndex:uk_image_version_scene_version,priority:2;comment:'场景内的图片版本号，从 1 开始'"`
+	ImageURL  string    `gorm:"size:500;comment:'场景图片url'"`
+	CreatedAt time.Time `gorm:"comment:'创建时间'"`
+}
+
+func (SceneImageVersion) TableName() string {
+	return "scene_image_versions"
+}
+
+// CreateSceneImageVersions 按顺序追加场景的图片版本，Version 取已有最大版本号加 1，只保留最近 keep 个版本
+func (db *Database) CreateSceneImageVersions(ctx context.Context, sceneID string, versions []SceneImageVersion, keep int) error {
+	return db.db.WithContext(ctx).Transaction(func(tx *gorm.DB) error {
+		var version int
+		err := tx.Model(&SceneImageVersion{}).Where("scene_id = ?", sceneID).Select("COALESCE(MAX(version), 0)").Scan(&version).Error
+		if err != nil {
+			return err
+		}
+		for i := range versions {
+			version++
+			versions[i].SceneID = sceneID
+			versions[i].Version = version
+			if err = gorm.G[SceneImageVersion](tx).Create(ctx, &versions[i]); err != nil {
+				return err
+			}
+		}
+		_, err = gorm.G[SceneImageVersion](tx).Where("scene_id = ? AND version <= ?", sceneID, version-keep).Delete(ctx)
+		return err
+	})
+}
+
+func (db *Database) GetSceneImageVersion(ctx context.Context, sceneID string, version int) (SceneImageVersion, error) {
+	return gorm.G[SceneImageVersion](db.db).Where("scene_id = ? AND version = ?", sceneID, version).Take(ctx)
+}
+
+// ListSceneImageVersions 按版本号倒序列取场景的图片版本
+func (db *Database) ListSceneImageVersions(ctx context.Context, sceneID string) ([]SceneImageVersion, error) {
+	return gorm.G[SceneImageVersion](db.db).Where("scene_id = ?", sceneID).Order("version DESC").Find(ctx)
+}
+
+// RevertSceneImage 将场景图片恢复为指定的版本，语音和时长不变
+func (db *Database) RevertSceneImage(ctx context.Context, v *SceneImageVersion) error {
+	result := db.db.WithContext(ctx).Model(&Scene{}).Where("id = ?", v.SceneID).Updates(map[string]interface{}{
+		"image_url":   v.ImageURL,
+		"placeholder": false,
+		"updated_at":  time.Now(),
+	})
+	if result.Error != nil {
+		return result.Error
+	}
+	if result.RowsAffected == 0 {
+		return gorm.ErrRecordNotFound
+	}
+	return nil
+}
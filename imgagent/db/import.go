@@ -0,0 +1,96 @@
+package db
+
+import (
+	"context"
+	"time"
+
+	"gorm.io/gorm"
+)
+
+const (
+	ImportBatchStatusRunning  = "running"
+	ImportBatchStatusFinished = "finished"
+
+	ImportItemStatusPending = "pending"
+	ImportItemStatusCreated = "created"
+	ImportItemStatusFailed  = "failed"
+)
+
+// ImportBatch 批量导入任务，归档中的每个文件对应一个 ImportItem
+type ImportBatch struct {
+	ID        string    `gorm:"primaryKey;size:32;comment:'主键'"`
+	UserID    int64     `gorm:"comment:'用户（租户） id'"`
+	Filename  string    `gorm:"size:255;comment:'归档文件名'"`
+	Status    string    `gorm:"size:16;comment:'状态 running|finished'"`
+	CreatedAt time.Time `gorm:"comment:'创建时间'"`
+	UpdatedAt time.Time `gorm:"comment:'更新时间'"`
+}
+
+func (ImportBatch) TableName() string {
+	return "import_batches"
+}
+
+// ImportItem 批量导入中的单个文件，创建成功后记录文档 id
+type ImportItem struct {
+	ID         int64     `gorm:"primaryKey;autoIncrement;comment:'主键'"`
+	BatchID    string    `gorm:"index:idx_import_item_batch_id;size:32;comment:'批量导入 id'"`
+	Index      int       `gorm:"comment:'文件在归档中的序号'"`
+	Filename   string    `gorm:"size:255;comment:'文件在归档中的路径'"`
+	Name       string    `gorm:"size:128;comment:'文档名称'"`
+	DocumentID string    `gorm:"size:32;comment:'创建的文档 id'"`
+	Status     string    `gorm:"size:16;comment:'状态 pending|created|failed'"`
+	Error      string    `gorm:"size:500;comment:'失败原因'"`
+	CreatedAt  time.Time `gorm:"comment:'创建时间'"`
+	UpdatedAt  time.Time `gorm:"comment:'更新时间'"`
+}
+
+func (ImportItem) TableName() string {
+	return "import_items"
+}
+
+func (db *Database) CreateImportBatch(ctx context.Context, batch *ImportBatch, items []ImportItem) error {
+	return db.db.WithContext(ctx).Transaction(func(tx *gorm.DB) error {
+		if err := gorm.G[ImportBatch](tx).Create(ctx, batch); err != nil {
+			return err
+		}
+		if len(items) == 0 {
+			return nil
+		}
+		return gorm.G[ImportItem](tx).CreateInBatches(ctx, &items, batchSize)
+	})
+}
+
+func (db *Database) GetImportBatch(ctx context.Context, id string) (ImportBatch, error) {
+	return gorm.G[ImportBatch](db.db).Where("id = ?", id).Take(ctx)
+}
+
+func (db *Database) UpdateImportBatchStatus(ctx context.Context, id string, status string) error {
+	rowsAffected, err := gorm.G[ImportBatch](db.db).Where("id = ?", id).Update(ctx, "status", status)
+	if err != nil {
+		return err
+	}
+	if rowsAffected == 0 {
+		return gorm.ErrRecordNotFound
+	}
+	return nil
+}
+
+// ListImportItems 按归档中的顺序列取批量导入的文件
+func (db *Database) ListImportItems(ctx context.Context, batchID string) ([]ImportItem, error) {
+	return gorm.G[ImportItem](db.db).Where("batch_id = ?", batchID).Order("`index` ASC").Find(ctx)
+}
+
+func (db *Database) UpdateImportItem(ctx context.Context, id int64, status string, documentID string, errMsg string) error {
+	result := db.db.WithContext(ctx).Model(&ImportItem{}).Where("id = ?", id).Updates(map[string]interface{}{
+		"status":      status,
+		"document_id": documentID,
+		"error":       errMsg,
+	})
+	if result.Error != nil {
+		return result.Error
+	}
+	if result.RowsAffected == 0 {
+		return gorm.ErrRecordNotFound
+	}
+	return nil
+}
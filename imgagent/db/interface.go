@@ -2,48 +2,84 @@ package db
 
 import (
 	"context"
+	"io"
+	"time"
 
 	"imgagent/api"
 )
 
 type IDataBase interface {
+	Close()
+
 	UserToken(ctx context.Context, token string) (UserToken, error)
 	User(ctx context.Context, uid int64) (User, error)
 	GetAdminID(ctx context.Context) (int64, error)
 
 	// Document
 	CreateDocument(ctx context.Context, docID, fileID string, args *api.CreateDocumentArgs) (*Document, error)
+	ImportDocument(ctx context.Context, doc *Document, chapters []Chapter, roles []Role, scenes []Scene) error
 	GetDocument(ctx context.Context, id string) (Document, error)
 	GetDocumentWithName(ctx context.Context, name string) (Document, error)
+	GetDocumentWithContentHash(ctx context.Context, userID int64, hash string) (Document, error)
+	ListExistingDocumentNameKeys(ctx context.Context, names []string) ([]string, error)
+	UpdateDocumentNameKey(ctx context.Context, id string) error
 	UpdateDocument(ctx context.Context, id string, args *api.UpdateDocumentArgs) error
 	UpdateDocumentStatus(ctx context.Context, id string, status string) error
+	UpdateDocumentAttempt(ctx context.Context, id string, attempts int, lastError string, nextAttemptAt time.Time) error
 	UpdateDocumentFileID(ctx context.Context, id string, fileID string) error
+	ListReferencedFileIDs(ctx context.Context, fileIDs []string) ([]string, error)
 	UpdateDocumentSummary(ctx context.Context, id string, summary string) error
 	UpdateDocumentSummaryImageURL(ctx context.Context, id string, imageURL string) error
-	DeleteDocument(ctx context.Context, id string) error
+	UpdateDocumentSynopsis(ctx context.Context, id, synopsis string, plotPoints []string) error
+	UpdateDocumentSceneStats(ctx context.Context, id string, sceneCount, failedSceneCount int) error
+	DeleteDocument(ctx context.Context, id string) ([]string, error)
+	ListReferencedMediaURLs(ctx context.Context, urls []string) ([]string, error)
 	ListDocuments(ctx context.Context) ([]Document, error)
+	ListDocumentsUpdatedSince(ctx context.Context, since time.Time) ([]Document, error)
+	ListDocumentsByFilter(ctx context.Context, filter DocumentFilter) ([]Document, error)
 	ListChapterReadyDocuments(ctx context.Context) ([]Document, error)
 	ListRoleReadyDocuments(ctx context.Context) ([]Document, error)
 	ListSceneReadyDocuments(ctx context.Context) ([]Document, error)
+	HasDueDocumentAbove(ctx context.Context, status string, priority int) (bool, error)
+	UpdateDocumentPaused(ctx context.Context, id string, paused bool) error
+	CancelDocument(ctx context.Context, id string) error
 
 	// Chapter
 	CreateChapters(ctx context.Context, documentID string, texts []string) error
 	GetChapter(ctx context.Context, id, documentID string) (Chapter, error)
+	GetChapterByID(ctx context.Context, id string) (Chapter, error)
 	UpdateChapter(ctx context.Context, id string, args *api.UpdateChapterArgs) error
+	UpdateChapterSummary(ctx context.Context, id, summary string) error
 	UpdateChapterSceneIDs(ctx context.Context, chapterID string, sceneIDs []string) error
+	UpdateChapterCover(ctx context.Context, id, coverSceneID, coverURL string) error
 	DeleteChapter(ctx context.Context, id, documentID string) error
 	DeleteAllChapter(ctx context.Context, documentID string) error
 	ListChapters(ctx context.Context, documentID string) ([]Chapter, error)
+	ListChaptersUpdatedSince(ctx context.Context, documentID string, since time.Time) ([]Chapter, error)
+	CountChapters(ctx context.Context, documentID string) (int64, error)
+	ReorderChapters(ctx context.Context, documentID string, chapterIDs []string) error
+	SplitChapter(ctx context.Context, documentID, id, head, tail string, keepScenes int) (Chapter, error)
+	MarkDuplicateChapters(ctx context.Context, documentID string, duplicates []ChapterDuplicate) error
+	ListDuplicateChapters(ctx context.Context, documentID string) ([]Chapter, error)
 
 	// Scene
 	CreateScenes(ctx context.Context, scenes []Scene) error
+	InsertScene(ctx context.Context, afterID string, scene *Scene) error
 	GetScene(ctx context.Context, id string) (Scene, error)
 	ListScenesByChapter(ctx context.Context, chapterID string) ([]Scene, error)
 	ListScenesByDocument(ctx context.Context, documentID string) ([]Scene, error)
+	ListScenesByChapterUpdatedSince(ctx context.Context, chapterID string, since time.Time) ([]Scene, error)
+	ListScenesByDocumentUpdatedSince(ctx context.Context, documentID string, since time.Time) ([]Scene, error)
+	MarkScenesMediaStale(ctx context.Context, documentID string, sceneIDs []string) (int, error)
 	ListPendingImageScenes(ctx context.Context, documentID string) ([]Scene, error)
 	UpdateScene(ctx context.Context, id string, args *api.UpdateSceneArgs) error
 	UpdateSceneImageURL(ctx context.Context, sceneID string, imageURL string) error
+	UpdateSceneHDImage(ctx context.Context, sceneID string, hdImageURL, sourceURL string) error
 	UpdateSceneVoiceURL(ctx context.Context, sceneID string, voiceURL string) error
+	UpdateSceneSubtitleURL(ctx context.Context, sceneID string, subtitleURL string) error
+	UpdateSceneTiming(ctx context.Context, sceneID string, audioDurationMs, displayDurationMs int64) error
+	UpdateScenePlaceholder(ctx context.Context, sceneID string, imageURL, voiceURL string) error
+	UpdateSceneStatus(ctx context.Context, sceneID string, status string, attempts int, errMsg string) error
 	DeleteScenesByChapter(ctx context.Context, chapterID string) error
 	DeleteScenesByDocument(ctx context.Context, documentID string) error
 
@@ -52,5 +88,150 @@ type IDataBase interface {
 	GetRole(ctx context.Context, id string) (Role, error)
 	ListRolesByDocument(ctx context.Context, documentID string) ([]Role, error)
 	UpdateRole(ctx context.Context, id string, args *api.UpdateRoleArgs) error
+	MergeRoles(ctx context.Context, documentID string, target *Role, sourceIDs []string, rewrite func(string) string) error
 	DeleteRolesByDocument(ctx context.Context, documentID string) error
+	ReplaceRoleRelations(ctx context.Context, documentID string, relations []RoleRelation) error
+	ListRoleRelations(ctx context.Context, documentID string) ([]RoleRelation, error)
+
+	// Quota
+	GetDocumentUsage(ctx context.Context, userID int64) (int64, int64, error)
+	GetQuotaUsage(ctx context.Context, userID int64, date string) (QuotaUsage, error)
+	IncrQuotaUsage(ctx context.Context, userID int64, date string, images, voices int) error
+
+	// Stats
+	CountDocumentsByStatus(ctx context.Context, userID int64) ([]DocumentStatusCount, error)
+	SumQuotaUsage(ctx context.Context, userID int64, fromDate string) (QuotaUsage, error)
+	ListProcessingDurations(ctx context.Context, userID int64, since time.Time) ([]time.Duration, error)
+	CountPendingScenes(ctx context.Context, userID int64) (int64, error)
+
+	// Event
+	CreateEvent(ctx context.Context, event *Event) error
+	ListEvents(ctx context.Context, userID int64, marker int64, limit int) ([]Event, error)
+
+	// AuditLog
+	CreateAuditLog(ctx context.Context, log *AuditLog) error
+	ListAuditLogs(ctx context.Context, filter AuditLogFilter, marker int64, limit int) ([]AuditLog, error)
+	ListDocumentEvents(ctx context.Context, documentID string, limit int) ([]Event, error)
+
+	// Reading
+	CreateBookmark(ctx context.Context, bookmark *Bookmark) error
+	ListBookmarks(ctx context.Context, userID int64, documentID string) ([]Bookmark, error)
+	DeleteBookmark(ctx context.Context, id string, userID int64, documentID string) error
+	GetReadPosition(ctx context.Context, userID int64, documentID string) (ReadPosition, error)
+	SaveReadPosition(ctx context.Context, userID int64, documentID, chapterID, sceneID string) error
+
+	// Search
+	EnqueueDocumentIndexTasks(ctx context.Context, documentIDs []string) error
+	ListIndexTasks(ctx context.Context, limit int) ([]IndexTask, error)
+	DeleteIndexTasks(ctx context.Context, ids []int64) error
+	SaveSearchEntry(ctx context.Context, entry *SearchEntry) error
+	DeleteSearchEntry(ctx context.Context, sceneID string) error
+	DeleteSearchEntriesByDocument(ctx context.Context, documentID string) error
+	ListStaleIndexDocuments(ctx context.Context, version int) ([]string, error)
+	SearchScenes(ctx context.Context, userID int64, keyword string, limit int) ([]SearchEntry, error)
+
+	// Webhook
+	CreateWebhook(ctx context.Context, webhook *Webhook) error
+	GetWebhook(ctx context.Context, id string) (Webhook, error)
+	ListWebhooks(ctx context.Context, userID int64, documentID string) ([]Webhook, error)
+	ListDocumentWebhooks(ctx context.Context, userID int64, documentID string) ([]Webhook, error)
+	ListUserWebhooks(ctx context.Context, userID int64) ([]Webhook, error)
+	DeleteWebhook(ctx context.Context, id string, userID int64) error
+	CreateWebhookDeliveries(ctx context.Context, deliveries []WebhookDelivery) error
+	ListDueWebhookDeliveries(ctx context.Context, now time.Time, limit int) ([]WebhookDelivery, error)
+	UpdateWebhookDelivery(ctx context.Context, id string, status string, attempts int, nextAttemptAt time.Time, lastError string) error
+
+	// Backfill
+	GetBackfillProgress(ctx context.Context, stage string) (BackfillProgress, error)
+	SaveBackfillProgress(ctx context.Context, progress *BackfillProgress) error
+	DeleteBackfillProgress(ctx context.Context, stage string) error
+	ListDocumentsAfter(ctx context.Context, afterID string, limit int) ([]Document, error)
+
+	// Export
+	CreateExport(ctx context.Context, export *Export) error
+	GetExport(ctx context.Context, id string) (Export, error)
+	ListExports(ctx context.Context, documentID string) ([]Export, error)
+	ListPendingExports(ctx context.Context, limit int) ([]Export, error)
+	ListExpiredExports(ctx context.Context, now time.Time, limit int) ([]Export, error)
+	TransitExport(ctx context.Context, id string, from []string, to string) error
+	CompleteExport(ctx context.Context, id string, status string, size int64, path string, errMsg string, expiresAt time.Time) error
+	DeleteExport(ctx context.Context, id string) error
+
+	// Run
+	CreateDocumentRun(ctx context.Context, run *DocumentRun, scenes []RunScene) error
+	GetDocumentRun(ctx context.Context, documentID string, seq int) (DocumentRun, error)
+	ListDocumentRuns(ctx context.Context, documentID string) ([]DocumentRun, error)
+	ListRunScenes(ctx context.Context, runID string) ([]RunScene, error)
+
+	// SceneGeneration
+	CreateSceneGenerations(ctx context.Context, sceneID string, gens []SceneGeneration, keep int) error
+	GetSceneGeneration(ctx context.Context, sceneID string, seq int) (SceneGeneration, error)
+	ListSceneGenerations(ctx context.Context, sceneID string) ([]SceneGeneration, error)
+	ActivateSceneGeneration(ctx context.Context, gen *SceneGeneration) error
+
+	// SceneImageVersion
+	CreateSceneImageVersions(ctx context.Context, sceneID string, versions []SceneImageVersion, keep int) error
+	GetSceneImageVersion(ctx context.Context, sceneID string, version int) (SceneImageVersion, error)
+	ListSceneImageVersions(ctx context.Context, sceneID string) ([]SceneImageVersion, error)
+	RevertSceneImage(ctx context.Context, v *SceneImageVersion) error
+
+	// FailedJob
+	FailDocument(ctx context.Context, id string, stage string, attempts int, lastError string) error
+	ListFailedDocuments(ctx context.Context, marker string, limit int) ([]Document, error)
+	ListFailedScenes(ctx context.Context, marker string, limit int) ([]Scene, error)
+	ResetFailedScenes(ctx context.Context, documentID string, sceneID string) (int64, error)
+
+	// ChapterEmbedding
+	SaveChapterEmbeddings(ctx context.Context, embeddings []ChapterEmbedding) error
+	ListChapterEmbeddings(ctx context.Context, documentID string, withVector bool) ([]ChapterEmbedding, error)
+	DeleteChapterEmbeddings(ctx context.Context, documentID string, chapterIDs []string) error
+
+	// Translation
+	StartTranslation(ctx context.Context, userID int64, documentID, lang string) (DocumentTranslation, error)
+	GetTranslation(ctx context.Context, documentID, lang string) (DocumentTranslation, error)
+	ListPendingTranslations(ctx context.Context, limit int) ([]DocumentTranslation, error)
+	TransitTranslation(ctx context.Context, documentID, lang string, from []string, to string) error
+	UpdateTranslationProgress(ctx context.Context, documentID, lang string, translated, total int) error
+	CompleteTranslation(ctx context.Context, documentID, lang string, status string, errMsg string) error
+	SaveChapterTranslation(ctx context.Context, t *ChapterTranslation) error
+	ListChapterTranslations(ctx context.Context, documentID, lang string) ([]ChapterTranslation, error)
+	GetChapterTranslation(ctx context.Context, chapterID, lang string) (ChapterTranslation, error)
+
+	// Moderation
+	BlockScene(ctx context.Context, sceneID string, reason string, heldImageURL string) error
+	ListBlockedScenes(ctx context.Context, marker string, limit int) ([]Scene, error)
+	ResetBlockedScene(ctx context.Context, sceneID string, pass bool) error
+
+	// DocumentLog
+	CreateDocumentLog(ctx context.Context, l *DocumentLog) error
+	ListDocumentLogs(ctx context.Context, documentID string, limit int) ([]DocumentLog, error)
+	DeleteDocumentLogs(ctx context.Context, documentID string) error
+
+	// Import
+	CreateImportBatch(ctx context.Context, batch *ImportBatch, items []ImportItem) error
+	GetImportBatch(ctx context.Context, id string) (ImportBatch, error)
+	UpdateImportBatchStatus(ctx context.Context, id string, status string) error
+	ListImportItems(ctx context.Context, batchID string) ([]ImportItem, error)
+	UpdateImportItem(ctx context.Context, id int64, status string, documentID string, errMsg string) error
+
+	// URLPolicy
+	GetURLPolicy(ctx context.Context, userID int64) (URLPolicy, error)
+	SaveURLPolicy(ctx context.Context, policy *URLPolicy) error
+	DeleteURLPolicy(ctx context.Context, userID int64) error
+
+	// BGMTrack
+	CreateBGMTrack(ctx context.Context, track *BGMTrack) error
+	GetBGMTrack(ctx context.Context, id string) (BGMTrack, error)
+	ListBGMTracks(ctx context.Context) ([]BGMTrack, error)
+	DeleteBGMTrack(ctx context.Context, id string) error
+
+	// PromptTemplate
+	GetPromptTemplate(ctx context.Context, kind string) (PromptTemplate, error)
+	ListPromptTemplates(ctx context.Context) ([]PromptTemplate, error)
+	SavePromptTemplate(ctx context.Context, tmpl *PromptTemplate) error
+	DeletePromptTemplate(ctx context.Context, kind string) error
+
+	// Backup
+	WriteSnapshot(ctx context.Context, w io.Writer) (SnapshotStats, error)
+	RestoreSnapshot(ctx context.Context, r io.Reader, dryRun bool) (SnapshotStats, error)
 }
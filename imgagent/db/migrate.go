@@ -0,0 +1,199 @@
+package db
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"time"
+
+	"go.uber.org/zap"
+	"gorm.io/gorm"
+)
+
+// ErrIrreversible 迁移未提供 Rollback，不能回滚
+var ErrIrreversible = errors.New("migration is irreversible")
+
+// Migration 一次版本化的 schema 变更。ID 以时间开头保证顺序，发布后不能修改；
+// Migrate/Rollback 中使用迁移时的表结构快照，不直接引用会继续变化的模型
+type Migration struct {
+	ID       string
+	Migrate  func(tx *gorm.DB) error
+	Rollback func(tx *gorm.DB) error // 为 nil 时不可回滚
+}
+
+// SchemaMigration 已执行的迁移
+type SchemaMigration struct {
+	ID        string    `gorm:"primaryKey;size:100;comment:'迁移 id'"`
+	AppliedAt time.Time `gorm:"comment:'执行时间'"`
+}
+
+func (SchemaMigration) TableName() string {
+	return "schema_migrations"
+}
+
+// MigrationStatus 迁移的执行状态
+type MigrationStatus struct {
+	ID        string
+	Applied   bool
+	AppliedAt time.Time
+}
+
+// models 当前的全部表，用于初始化没有迁移记录的数据库
+var models = []any{&Document{}, &Chapter{}, &Scene{}, &Role{}, &QuotaUsage{}, &Event{}, &Bookmark{}, &ReadPosition{}, &IndexTask{}, &SearchEntry{}, &Webhook{}, &WebhookDelivery{}, &BackfillProgress{}, &Export{}, &DocumentRun{}, &RunScene{}, &SceneGeneration{}, &SceneImageVersion{}, &BGMTrack{}, &URLPolicy{}, &DocumentLog{}, &ImportBatch{}, &ImportItem{}, &PromptTemplate{}, &ChapterEmbedding{}, &AuditLog{}, &DocumentTranslation{}, &ChapterTranslation{}, &RoleRelation{}}
+
+// initSchema 按当前模型建表。新库和迁移引入前由 AutoMigrate 维护的旧库都从这里开始，
+// 之后的 schema 变更只通过 migrations 执行
+func initSchema(tx *gorm.DB) error {
+	return tx.AutoMigrate(models...)
+}
+
+type migrator struct {
+	db         *gorm.DB
+	migrations []Migration
+	initSchema func(tx *gorm.DB) error
+}
+
+func (m *migrator) applied(ctx context.Context) (map[string]SchemaMigration, error) {
+	if err := m.db.WithContext(ctx).AutoMigrate(&SchemaMigration{}); err != nil {
+		return nil, err
+	}
+	records, err := gorm.G[SchemaMigration](m.db).Find(ctx)
+	if err != nil {
+		return nil, err
+	}
+	applied := make(map[string]SchemaMigration, len(records))
+	for _, r := range records {
+		applied[r.ID] = r
+	}
+	return applied, nil
+}
+
+// migrate 执行未执行的迁移，返回本次执行的迁移 id。
+// 没有任何迁移记录时先 initSchema，并把已有的迁移全部记为已执行
+func (m *migrator) migrate(ctx context.Context) ([]string, error) {
+	applied, err := m.applied(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	if len(applied) == 0 {
+		ids := make([]string, 0, len(m.migrations))
+		for _, mig := range m.migrations {
+			ids = append(ids, mig.ID)
+		}
+		// MySQL 的 DDL 会隐式提交，事务只保证迁移记录和数据变更一致
+		err = m.db.WithContext(ctx).Transaction(func(tx *gorm.DB) error {
+			if err := m.initSchema(tx); err != nil {
+				return fmt.Errorf("init schema: %w", err)
+			}
+			return m.record(tx, ids)
+		})
+		if err != nil {
+			return nil, err
+		}
+		return ids, nil
+	}
+
+	var done []string
+	for _, mig := range m.migrations {
+		if _, ok := applied[mig.ID]; ok {
+			continue
+		}
+		err = m.db.WithContext(ctx).Transaction(func(tx *gorm.DB) error {
+			if err := mig.Migrate(tx); err != nil {
+				return err
+			}
+			return m.record(tx, []string{mig.ID})
+		})
+		if err != nil {
+			return done, fmt.Errorf("migrate %s: %w", mig.ID, err)
+		}
+		zap.S().Infof("Migration applied, id: %s", mig.ID)
+		done = append(done, mig.ID)
+	}
+	return done, nil
+}
+
+func (m *migrator) record(tx *gorm.DB, ids []string) error {
+	if len(ids) == 0 {
+		return nil
+	}
+	now := time.Now()
+	records := make([]SchemaMigration, 0, len(ids))
+	for _, id := range ids {
+		records = append(records, SchemaMigration{ID: id, AppliedAt: now})
+	}
+	return tx.Create(&records).Error
+}
+
+// rollback 按执行的倒序回滚最近 steps 个迁移，返回本次回滚的迁移 id
+func (m *migrator) rollback(ctx context.Context, steps int) ([]string, error) {
+	applied, err := m.applied(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	var done []string
+	for i := len(m.migrations) - 1; i >= 0 && len(done) < steps; i-- {
+		mig := m.migrations[i]
+		if _, ok := applied[mig.ID]; !ok {
+			continue
+		}
+		if mig.Rollback == nil {
+			return done, fmt.Errorf("rollback %s: %w", mig.ID, ErrIrreversible)
+		}
+		err = m.db.WithContext(ctx).Transaction(func(tx *gorm.DB) error {
+			if err := mig.Rollback(tx); err != nil {
+				return err
+			}
+			return tx.Where("id = ?", mig.ID).Delete(&SchemaMigration{}).Error
+		})
+		if err != nil {
+			return done, fmt.Errorf("rollback %s: %w", mig.ID, err)
+		}
+		zap.S().Infof("Migration rolled back, id: %s", mig.ID)
+		done = append(done, mig.ID)
+	}
+	return done, nil
+}
+
+func (m *migrator) status(ctx context.Context) ([]MigrationStatus, error) {
+	applied, err := m.applied(ctx)
+	if err != nil {
+		return nil, err
+	}
+	statuses := make([]MigrationStatus, 0, len(m.migrations))
+	for _, mig := range m.migrations {
+		r, ok := applied[mig.ID]
+		statuses = append(statuses, MigrationStatus{ID: mig.ID, Applied: ok, AppliedAt: r.AppliedAt})
+	}
+	return statuses, nil
+}
+
+func (db *Database) migrator() *migrator {
+	gdb := db.db
+	if gdb.Dialector.Name() == "mysql" {
+		// 需要指定字符集为 utf8mb4，默认为 utf8mb3
+		gdb = gdb.Set("gorm:table_options", "CHARSET=utf8mb4 COLLATE=utf8mb4_unicode_ci")
+	}
+	return &migrator{
+		db:         gdb,
+		migrations: migrations,
+		initSchema: initSchema,
+	}
+}
+
+// Migrate 执行未执行的迁移
+func (db *Database) Migrate(ctx context.Context) ([]string, error) {
+	return db.migrator().migrate(ctx)
+}
+
+// RollbackMigrations 回滚最近执行的 steps 个迁移
+func (db *Database) RollbackMigrations(ctx context.Context, steps int) ([]string, error) {
+	return db.migrator().rollback(ctx, steps)
+}
+
+// MigrationStatus 按顺序返回全部迁移的执行状态
+func (db *Database) MigrationStatus(ctx context.Context) ([]MigrationStatus, error) {
+	return db.migrator().status(ctx)
+}
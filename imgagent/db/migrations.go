@@ -0,0 +1,491 @@
+package db
+
+import (
+	"time"
+
+	"gorm.io/gorm"
+
+	"imgagent/api"
+)
+
+// migrations 按顺序执行的 schema 迁移，只能在末尾追加。
+// 修改模型的同时在这里追加迁移，已有的库通过迁移得到新结构，新库由 initSchema 直接建表
+var migrations = []Migration{
+	{
+		ID: "202610150001_create_chapter_embeddings",
+		Migrate: func(tx *gorm.DB) error {
+			if tx.Migrator().HasTable(&chapterEmbeddingV1{}) {
+				return nil
+			}
+			return tx.Migrator().CreateTable(&chapterEmbeddingV1{})
+		},
+		Rollback: func(tx *gorm.DB) error {
+			return tx.Migrator().DropTable(&chapterEmbeddingV1{})
+		},
+	},
+	{
+		ID: "202610160001_add_chapter_duplicate_of",
+		Migrate: func(tx *gorm.DB) error {
+			for _, field := range []string{"DuplicateOf", "Similarity"} {
+				if tx.Migrator().HasColumn(&chapterDuplicateV1{}, field) {
+					continue
+				}
+				if err := tx.Migrator().AddColumn(&chapterDuplicateV1{}, field); err != nil {
+					return err
+				}
+			}
+			return nil
+		},
+		Rollback: func(tx *gorm.DB) error {
+			for _, field := range []string{"DuplicateOf", "Similarity"} {
+				if !tx.Migrator().HasColumn(&chapterDuplicateV1{}, field) {
+					continue
+				}
+				if err := tx.Migrator().DropColumn(&chapterDuplicateV1{}, field); err != nil {
+					return err
+				}
+			}
+			return nil
+		},
+	},
+	{
+		ID: "202610160002_create_audit_logs",
+		Migrate: func(tx *gorm.DB) error {
+			return tx.Migrator().CreateTable(&auditLogV1{})
+		},
+		Rollback: func(tx *gorm.DB) error {
+			return tx.Migrator().DropTable(&auditLogV1{})
+		},
+	},
+	{
+		ID: "202610160003_add_document_stages",
+		Migrate: func(tx *gorm.DB) error {
+			if tx.Migrator().HasColumn(&documentStagesV1{}, "Stages") {
+				return nil
+			}
+			return tx.Migrator().AddColumn(&documentStagesV1{}, "Stages")
+		},
+		Rollback: func(tx *gorm.DB) error {
+			if !tx.Migrator().HasColumn(&documentStagesV1{}, "Stages") {
+				return nil
+			}
+			return tx.Migrator().DropColumn(&documentStagesV1{}, "Stages")
+		},
+	},
+	{
+		ID: "202610160004_add_document_content_hash",
+		Migrate: func(tx *gorm.DB) error {
+			if !tx.Migrator().HasColumn(&documentContentHashV1{}, "ContentHash") {
+				if err := tx.Migrator().AddColumn(&documentContentHashV1{}, "ContentHash"); err != nil {
+					return err
+				}
+			}
+			if tx.Migrator().HasIndex(&documentContentHashV1{}, "idx_document_content_hash") {
+				return nil
+			}
+			return tx.Migrator().CreateIndex(&documentContentHashV1{}, "idx_document_content_hash")
+		},
+		Rollback: func(tx *gorm.DB) error {
+			if tx.Migrator().HasIndex(&documentContentHashV1{}, "idx_document_content_hash") {
+				if err := tx.Migrator().DropIndex(&documentContentHashV1{}, "idx_document_content_hash"); err != nil {
+					return err
+				}
+			}
+			if !tx.Migrator().HasColumn(&documentContentHashV1{}, "ContentHash") {
+				return nil
+			}
+			return tx.Migrator().DropColumn(&documentContentHashV1{}, "ContentHash")
+		},
+	},
+	{
+		ID: "202610160005_create_translations",
+		Migrate: func(tx *gorm.DB) error {
+			return tx.Migrator().CreateTable(&documentTranslationV1{}, &chapterTranslationV1{})
+		},
+		Rollback: func(tx *gorm.DB) error {
+			return tx.Migrator().DropTable(&chapterTranslationV1{}, &documentTranslationV1{})
+		},
+	},
+	{
+		ID: "202610160006_add_scene_media_stale",
+		Migrate: func(tx *gorm.DB) error {
+			if !tx.Migrator().HasColumn(&sceneMediaStaleV1{}, "MediaStale") {
+				if err := tx.Migrator().AddColumn(&sceneMediaStaleV1{}, "MediaStale"); err != nil {
+					return err
+				}
+			}
+			if tx.Migrator().HasColumn(&documentAutoRegenerateV1{}, "AutoRegenerate") {
+				return nil
+			}
+			return tx.Migrator().AddColumn(&documentAutoRegenerateV1{}, "AutoRegenerate")
+		},
+		Rollback: func(tx *gorm.DB) error {
+			if tx.Migrator().HasColumn(&documentAutoRegenerateV1{}, "AutoRegenerate") {
+				if err := tx.Migrator().DropColumn(&documentAutoRegenerateV1{}, "AutoRegenerate"); err != nil {
+					return err
+				}
+			}
+			if !tx.Migrator().HasColumn(&sceneMediaStaleV1{}, "MediaStale") {
+				return nil
+			}
+			return tx.Migrator().DropColumn(&sceneMediaStaleV1{}, "MediaStale")
+		},
+	},
+	{
+		ID: "202610160007_create_scene_image_versions",
+		Migrate: func(tx *gorm.DB) error {
+			return tx.Migrator().CreateTable(&sceneImageVersionV1{})
+		},
+		Rollback: func(tx *gorm.DB) error {
+			return tx.Migrator().DropTable(&sceneImageVersionV1{})
+		},
+	},
+	{
+		ID: "202610160008_add_document_voice_prosody",
+		Migrate: func(tx *gorm.DB) error {
+			if tx.Migrator().HasColumn(&documentVoiceProsodyV1{}, "VoiceProsody") {
+				return nil
+			}
+			return tx.Migrator().AddColumn(&documentVoiceProsodyV1{}, "VoiceProsody")
+		},
+		Rollback: func(tx *gorm.DB) error {
+			if !tx.Migrator().HasColumn(&documentVoiceProsodyV1{}, "VoiceProsody") {
+				return nil
+			}
+			return tx.Migrator().DropColumn(&documentVoiceProsodyV1{}, "VoiceProsody")
+		},
+	},
+	{
+		ID: "202610160009_add_scene_subtitle_url",
+		Migrate: func(tx *gorm.DB) error {
+			if tx.Migrator().HasColumn(&sceneSubtitleURLV1{}, "SubtitleURL") {
+				return nil
+			}
+			return tx.Migrator().AddColumn(&sceneSubtitleURLV1{}, "SubtitleURL")
+		},
+		Rollback: func(tx *gorm.DB) error {
+			if !tx.Migrator().HasColumn(&sceneSubtitleURLV1{}, "SubtitleURL") {
+				return nil
+			}
+			return tx.Migrator().DropColumn(&sceneSubtitleURLV1{}, "SubtitleURL")
+		},
+	},
+	{
+		ID: "202610160010_create_bgm_tracks",
+		Migrate: func(tx *gorm.DB) error {
+			return tx.Migrator().CreateTable(&bgmTrackV1{})
+		},
+		Rollback: func(tx *gorm.DB) error {
+			return tx.Migrator().DropTable(&bgmTrackV1{})
+		},
+	},
+	{
+		ID: "202610160011_add_document_bgm_id",
+		Migrate: func(tx *gorm.DB) error {
+			if tx.Migrator().HasColumn(&documentBGMIDV1{}, "BGMID") {
+				return nil
+			}
+			return tx.Migrator().AddColumn(&documentBGMIDV1{}, "BGMID")
+		},
+		Rollback: func(tx *gorm.DB) error {
+			if !tx.Migrator().HasColumn(&documentBGMIDV1{}, "BGMID") {
+				return nil
+			}
+			return tx.Migrator().DropColumn(&documentBGMIDV1{}, "BGMID")
+		},
+	},
+	{
+		ID: "202610160012_add_chapter_summary",
+		Migrate: func(tx *gorm.DB) error {
+			if tx.Migrator().HasColumn(&chapterSummaryV1{}, "Summary") {
+				return nil
+			}
+			return tx.Migrator().AddColumn(&chapterSummaryV1{}, "Summary")
+		},
+		Rollback: func(tx *gorm.DB) error {
+			if !tx.Migrator().HasColumn(&chapterSummaryV1{}, "Summary") {
+				return nil
+			}
+			return tx.Migrator().DropColumn(&chapterSummaryV1{}, "Summary")
+		},
+	},
+	{
+		ID: "202610160013_add_document_synopsis",
+		Migrate: func(tx *gorm.DB) error {
+			for _, field := range []string{"Synopsis", "PlotPoints"} {
+				if tx.Migrator().HasColumn(&documentSynopsisV1{}, field) {
+					continue
+				}
+				if err := tx.Migrator().AddColumn(&documentSynopsisV1{}, field); err != nil {
+					return err
+				}
+			}
+			return nil
+		},
+		Rollback: func(tx *gorm.DB) error {
+			for _, field := range []string{"Synopsis", "PlotPoints"} {
+				if !tx.Migrator().HasColumn(&documentSynopsisV1{}, field) {
+					continue
+				}
+				if err := tx.Migrator().DropColumn(&documentSynopsisV1{}, field); err != nil {
+					return err
+				}
+			}
+			return nil
+		},
+	},
+	{
+		ID: "202610160014_create_role_relations",
+		Migrate: func(tx *gorm.DB) error {
+			return tx.Migrator().CreateTable(&roleRelationV1{})
+		},
+		Rollback: func(tx *gorm.DB) error {
+			return tx.Migrator().DropTable(&roleRelationV1{})
+		},
+	},
+	{
+		ID: "202610160015_add_scene_attributes",
+		Migrate: func(tx *gorm.DB) error {
+			for _, field := range []string{"Location", "TimeOfDay", "Mood", "CharactersPresent"} {
+				if tx.Migrator().HasColumn(&sceneAttributesV1{}, field) {
+					continue
+				}
+				if err := tx.Migrator().AddColumn(&sceneAttributesV1{}, field); err != nil {
+					return err
+				}
+			}
+			return nil
+		},
+		Rollback: func(tx *gorm.DB) error {
+			for _, field := range []string{"Location", "TimeOfDay", "Mood", "CharactersPresent"} {
+				if !tx.Migrator().HasColumn(&sceneAttributesV1{}, field) {
+					continue
+				}
+				if err := tx.Migrator().DropColumn(&sceneAttributesV1{}, field); err != nil {
+					return err
+				}
+			}
+			return nil
+		},
+	},
+	{
+		ID: "202610160016_add_search_entry_display",
+		Migrate: func(tx *gorm.DB) error {
+			if tx.Migrator().HasColumn(&searchEntryDisplayV1{}, "Display") {
+				return nil
+			}
+			return tx.Migrator().AddColumn(&searchEntryDisplayV1{}, "Display")
+		},
+		Rollback: func(tx *gorm.DB) error {
+			if !tx.Migrator().HasColumn(&searchEntryDisplayV1{}, "Display") {
+				return nil
+			}
+			return tx.Migrator().DropColumn(&searchEntryDisplayV1{}, "Display")
+		},
+	},
+}
+
+// 以下为迁移时的表结构快照，模型之后的变化通过新的迁移完成
+
+type chapterEmbeddingV1 struct {
+	ChapterID  string    `gorm:"primaryKey;size:32;comment:'章节 id'"`
+	DocumentID string    `gorm:"index:idx_embedding_document_id;size:32;comment:'文档 id'"`
+	Hash       string    `gorm:"size:64;comment:'章节标题和内容的 sha256，变化后重新计算'"`
+	Model      string    `gorm:"size:100;comment:'向量模型'"`
+	Vector     []float32 `gorm:"type:json;serializer:json;comment:'向量'"`
+	UpdatedAt  time.Time `gorm:"comment:'更新时间'"`
+}
+
+func (chapterEmbeddingV1) TableName() string {
+	return "chapter_embeddings"
+}
+
+type chapterDuplicateV1 struct {
+	DuplicateOf string  `gorm:"size:32;comment:'上传时检测到的高度相似的前文章节 id，修改内容后清除'"`
+	Similarity  float64 `gorm:"comment:'与 duplicate_of 章节的相似度'"`
+}
+
+func (chapterDuplicateV1) TableName() string {
+	return "chapters"
+}
+
+type auditLogV1 struct {
+	ID           int64     `gorm:"primaryKey;autoIncrement"`
+	UserID       int64     `gorm:"index:idx_audit_user_id;comment:'操作用户（租户） id'"`
+	UserName     string    `gorm:"size:64;comment:'操作用户名'"`
+	Action       string    `gorm:"size:32;comment:'操作 create|update|delete|regenerate 等'"`
+	ResourceType string    `gorm:"index:idx_audit_resource,priority:1;size:16;comment:'资源类型 document|chapter|scene|role|chapters|roles'"`
+	ResourceID   string    `gorm:"index:idx_audit_resource,priority:2;size:32;comment:'资源 id'"`
+	DocumentID   string    `gorm:"index:idx_audit_document_id;size:32;comment:'资源所属文档 id'"`
+	Route        string    `gorm:"size:255;comment:'请求的方法和路由'"`
+	RequestID    string    `gorm:"size:64;comment:'请求 id'"`
+	Before       string    `gorm:"type:mediumtext;comment:'修改前的资源快照（json），新建时为空'"`
+	After        string    `gorm:"type:mediumtext;comment:'修改后的资源快照（json），删除时为空'"`
+	CreatedAt    time.Time `gorm:"comment:'创建时间'"`
+}
+
+func (auditLogV1) TableName() string {
+	return "audit_logs"
+}
+
+type documentStagesV1 struct {
+	Stages string `gorm:"type:json;comment:'各处理阶段的开始和完成时间'"`
+}
+
+func (documentStagesV1) TableName() string {
+	return "documents"
+}
+
+type documentContentHashV1 struct {
+	ContentHash string `gorm:"index:idx_document_content_hash;size:64;comment:'上传文件的 sha256'"`
+}
+
+func (documentContentHashV1) TableName() string {
+	return "documents"
+}
+
+type documentTranslationV1 struct {
+	DocumentID string    `gorm:"primaryKey;size:32;comment:'文档 id'"`
+	Lang       string    `gorm:"primaryKey;size:16;comment:'目标语言'"`
+	UserID     int64     `gorm:"comment:'用户（租户） id'"`
+	Status     string    `gorm:"index:idx_translation_status;size:16;comment:'状态'"`
+	Translated int       `gorm:"comment:'已翻译的章节数'"`
+	Total      int       `gorm:"comment:'章节总数'"`
+	Error      string    `gorm:"size:500;comment:'失败原因'"`
+	CreatedAt  time.Time `gorm:"comment:'创建时间'"`
+	UpdatedAt  time.Time `gorm:"comment:'更新时间'"`
+}
+
+func (documentTranslationV1) TableName() string {
+	return "document_translations"
+}
+
+type chapterTranslationV1 struct {
+	ChapterID  string    `gorm:"primaryKey;size:32;comment:'章节 id'"`
+	Lang       string    `gorm:"primaryKey;size:16;comment:'目标语言'"`
+	DocumentID string    `gorm:"index:idx_chapter_translation_document_id;size:32;comment:'文档 id'"`
+	Title      string    `gorm:"size:255;comment:'译文标题'"`
+	Content    string    `gorm:"type:mediumtext;comment:'译文内容'"`
+	SourceHash string    `gorm:"size:64;comment:'原文的 sha256'"`
+	UpdatedAt  time.Time `gorm:"comment:'更新时间'"`
+}
+
+func (chapterTranslationV1) TableName() string {
+	return "chapter_translations"
+}
+
+type sceneMediaStaleV1 struct {
+	MediaStale bool `gorm:"not null;default:false;comment:'场景描述修改后图片和语音是否已过期，重新生成成功后清除'"`
+}
+
+func (sceneMediaStaleV1) TableName() string {
+	return "scenes"
+}
+
+type documentAutoRegenerateV1 struct {
+	AutoRegenerate bool `gorm:"not null;default:false;comment:'场景媒体过期后是否自动重新生成'"`
+}
+
+func (documentAutoRegenerateV1) TableName() string {
+	return "documents"
+}
+
+type sceneImageVersionV1 struct {
+	ID        int64     `gorm:"primaryKey;autoIncrement"`
+	SceneID   string    `gorm:"uniqueIndex:uk_image_version_scene_version,priority:1;size:32;comment:'场景 id'"`
+	Version   int       `gorm:"uniqueIndex:uk_image_version_scene_version,priority:2;comment:'场景内的图片版本号，从 1 开始'"`
+	ImageURL  string    `gorm:"size:500;comment:'场景图片url'"`
+	CreatedAt time.Time `gorm:"comment:'创建时间'"`
+}
+
+func (sceneImageVersionV1) TableName() string {
+	return "scene_image_versions"
+}
+
+type documentVoiceProsodyV1 struct {
+	VoiceProsody api.VoiceProsody `gorm:"type:json;serializer:json;comment:'场景语音的语速、音调和情感风格'"`
+}
+
+func (documentVoiceProsodyV1) TableName() string {
+	return "documents"
+}
+
+type sceneSubtitleURLV1 struct {
+	SubtitleURL string `gorm:"size:500;comment:'与语音对齐的字幕url'"`
+}
+
+func (sceneSubtitleURLV1) TableName() string {
+	return "scenes"
+}
+
+type bgmTrackV1 struct {
+	ID        string    `gorm:"primaryKey;size:32;comment:'主键'"`
+	Name      string    `gorm:"size:100;comment:'名称'"`
+	Key       string    `gorm:"size:255;comment:'对象存储中的 key'"`
+	URL       string    `gorm:"size:500;comment:'音频url'"`
+	Volume    float64   `gorm:"comment:'叠加在旁白下的音量，0~1'"`
+	CreatedAt time.Time `gorm:"comment:'创建时间'"`
+	UpdatedAt time.Time `gorm:"comment:'更新时间'"`
+}
+
+func (bgmTrackV1) TableName() string {
+	return "bgm_tracks"
+}
+
+type documentBGMIDV1 struct {
+	BGMID string `gorm:"column:bgm_id;size:32;comment:'背景音乐曲目 id，为空时不使用背景音乐'"`
+}
+
+func (documentBGMIDV1) TableName() string {
+	return "documents"
+}
+
+type chapterSummaryV1 struct {
+	Summary string `gorm:"size:1000;comment:'章节摘要，修改内容后清除'"`
+}
+
+func (chapterSummaryV1) TableName() string {
+	return "chapters"
+}
+
+type documentSynopsisV1 struct {
+	Synopsis   string   `gorm:"size:2000;comment:'全书梗概'"`
+	PlotPoints []string `gorm:"type:json;serializer:json;comment:'关键情节'"`
+}
+
+func (documentSynopsisV1) TableName() string {
+	return "documents"
+}
+
+type roleRelationV1 struct {
+	ID           string    `gorm:"primaryKey;size:32;comment:'主键'"`
+	DocumentID   string    `gorm:"index:idx_role_relation_document_id;size:32;comment:'文档 id'"`
+	SourceRoleID string    `gorm:"size:32;comment:'来源角色 id'"`
+	TargetRoleID string    `gorm:"size:32;comment:'目标角色 id'"`
+	Type         string    `gorm:"size:20;comment:'关系类型'"`
+	Description  string    `gorm:"size:255;comment:'关系说明'"`
+	CreatedAt    time.Time `gorm:"comment:'创建时间'"`
+}
+
+func (roleRelationV1) TableName() string {
+	return "role_relations"
+}
+
+type sceneAttributesV1 struct {
+	Location          string   `gorm:"size:100;comment:'地点'"`
+	TimeOfDay         string   `gorm:"size:20;comment:'时间段 dawn|morning|noon|afternoon|evening|night'"`
+	Mood              string   `gorm:"size:50;comment:'氛围'"`
+	CharactersPresent []string `gorm:"type:json;serializer:json;comment:'出场人物'"`
+}
+
+func (sceneAttributesV1) TableName() string {
+	return "scenes"
+}
+
+type searchEntryDisplayV1 struct {
+	Display string `gorm:"size:1000;comment:'原始场景内容，作为搜索结果返回'"`
+}
+
+func (searchEntryDisplayV1) TableName() string {
+	return "search_entries"
+}
@@ -0,0 +1,55 @@
+package db
+
+import (
+	"context"
+	"time"
+
+	"gorm.io/gorm"
+)
+
+// BlockScene 场景未通过内容审核，记录原因和扣留的生成图片，等待人工复核
+func (db *Database) BlockScene(ctx context.Context, sceneID string, reason string, heldImageURL string) error {
+	result := db.db.WithContext(ctx).Model(&Scene{}).Where("id = ?", sceneID).Updates(map[string]interface{}{
+		"status":            SceneStatusBlocked,
+		"moderation_reason": reason,
+		"held_image_url":    heldImageURL,
+		"moderation_passed": false,
+		"updated_at":        time.Now(),
+	})
+	if result.Error != nil {
+		return result.Error
+	}
+	if result.RowsAffected == 0 {
+		return gorm.ErrRecordNotFound
+	}
+	return nil
+}
+
+// ListBlockedScenes 按 id 升序列取 id 大于 marker 的待复核场景
+func (db *Database) ListBlockedScenes(ctx context.Context, marker string, limit int) ([]Scene, error) {
+	return gorm.G[Scene](db.db).Where("status = ? AND id > ?", SceneStatusBlocked, marker).Order("id ASC").Limit(limit).Find(ctx)
+}
+
+// ResetBlockedScene 复核待审核场景，将其恢复为待生成。pass 为 true 时放行，重新生成时跳过审核并使用扣留的图片；
+// 否则丢弃扣留的图片，重新生成后再次审核
+func (db *Database) ResetBlockedScene(ctx context.Context, sceneID string, pass bool) error {
+	updates := map[string]interface{}{
+		"status":            "",
+		"attempts":          0,
+		"error":             "",
+		"image_url":         "",
+		"moderation_passed": pass,
+		"updated_at":        time.Now(),
+	}
+	if !pass {
+		updates["held_image_url"] = ""
+	}
+	result := db.db.WithContext(ctx).Model(&Scene{}).Where("id = ? AND status = ?", sceneID, SceneStatusBlocked).Updates(updates)
+	if result.Error != nil {
+		return result.Error
+	}
+	if result.RowsAffected == 0 {
+		return gorm.ErrRecordNotFound
+	}
+	return nil
+}
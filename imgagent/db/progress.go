@@ -0,0 +1,72 @@
+package db
+
+import (
+	"time"
+)
+
+// 文档处理阶段，各阶段的开始和完成时间记录在 Document.Stages
+const (
+	StageSplitting = "splitting"
+	StageRoles     = "roles"
+	StageScenes    = "scenes"
+	StageImages    = "images"
+	StageVoices    = "voices"
+)
+
+// Stages 文档处理阶段的先后顺序，图片和语音按场景同时生成
+var Stages = []string{StageSplitting, StageRoles, StageScenes, StageImages, StageVoices}
+
+// StageTime 处理阶段的开始和完成时间，未开始或未完成时为空
+type StageTime struct {
+	StartedAt  *time.Time `json:"started_at,omitempty"`
+	FinishedAt *time.Time `json:"finished_at,omitempty"`
+}
+
+// StageTimes 各处理阶段的开始和完成时间，按阶段名索引
+type StageTimes map[string]StageTime
+
+// statusStages 文档状态对应的已完成阶段数和进行中的阶段数，failed 等不对应阶段的状态返回 false
+func statusStages(status string) (done int, active int, ok bool) {
+	switch status {
+	case DocumentStatusChapterReady:
+		return 1, 1, true
+	case DocumentStatusRoleReady:
+		return 2, 1, true
+	case DocumentStatusSceneReady:
+		return 3, 2, true
+	case DocumentStatusImgReady, DocumentStatusCompletedWithErrors:
+		return len(Stages), 0, true
+	}
+	return 0, 0, false
+}
+
+// advance 返回文档进入 status 后的阶段时间：之前的阶段记为完成，进行中的阶段从 now 开始计时
+// （重新生成等回到已完成的阶段时重新计时），之后的阶段清空。
+// failed 保持不变，未完成的阶段即为失败所在的阶段
+func (t StageTimes) advance(status string, now time.Time) StageTimes {
+	done, active, ok := statusStages(status)
+	if !ok {
+		return t
+	}
+	ret := make(StageTimes, len(Stages))
+	for i, stage := range Stages {
+		st := t[stage]
+		switch {
+		case i < done:
+			if st.StartedAt == nil {
+				st.StartedAt = &now
+			}
+			if st.FinishedAt == nil {
+				st.FinishedAt = &now
+			}
+		case i < done+active:
+			if st.StartedAt == nil || st.FinishedAt != nil {
+				st = StageTime{StartedAt: &now}
+			}
+		default:
+			continue
+		}
+		ret[stage] = st
+	}
+	return ret
+}
@@ -0,0 +1,53 @@
+package db
+
+import (
+	"context"
+	"time"
+
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+)
+
+// 提示词模板类型
+const (
+	PromptKindRole  = "role"  // 角色提取
+	PromptKindScene = "scene" // 场景拆分
+	PromptKindImage = "image" // 场景图片生成
+)
+
+// PromptKinds 可自定义的提示词模板类型
+var PromptKinds = []string{PromptKindRole, PromptKindScene, PromptKindImage}
+
+// PromptTemplate 管理员自定义的提示词模板，没有记录时使用内置的默认提示词
+type PromptTemplate struct {
+	Kind      string    `gorm:"primaryKey;size:32;comment:'模板类型 role|scene|image'"`
+	Content   string    `gorm:"type:text;comment:'text/template 格式的模板内容'"`
+	CreatedAt time.Time `gorm:"comment:'创建时间'"`
+	UpdatedAt time.Time `gorm:"comment:'更新时间'"`
+}
+
+func (PromptTemplate) TableName() string {
+	return "prompt_templates"
+}
+
+func (db *Database) GetPromptTemplate(ctx context.Context, kind string) (PromptTemplate, error) {
+	return gorm.G[PromptTemplate](db.db).Where("kind = ?", kind).Take(ctx)
+}
+
+func (db *Database) ListPromptTemplates(ctx context.Context) ([]PromptTemplate, error) {
+	return gorm.G[PromptTemplate](db.db).Order("kind").Find(ctx)
+}
+
+// SavePromptTemplate 创建或覆盖提示词模板
+func (db *Database) SavePromptTemplate(ctx context.Context, tmpl *PromptTemplate) error {
+	return db.db.WithContext(ctx).Clauses(clause.OnConflict{
+		Columns:   []clause.Column{{Name: "kind"}},
+		DoUpdates: clause.AssignmentColumns([]string{"content", "updated_at"}),
+	}).Create(tmpl).Error
+}
+
+// DeletePromptTemplate 删除自定义模板，恢复为默认提示词
+func (db *Database) DeletePromptTemplate(ctx context.Context, kind string) error {
+	_, err := gorm.G[PromptTemplate](db.db).Where("kind = ?", kind).Delete(ctx)
+	return err
+}
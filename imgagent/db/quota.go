@@ -0,0 +1,73 @@
+package db
+
+import (
+	"context"
+	"time"
+
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+)
+
+// QuotaUsage 租户每日生成用量表
+type QuotaUsage struct {
+	ID        int64     `gorm:"primaryKey;autoIncrement"`
+	UserID    int64     `gorm:"uniqueIndex:uk_user_date,priority:1;comment:'用户（租户） id'"`
+	Date      string    `gorm:"uniqueIndex:uk_user_date,priority:2;size:10;comment:'日期 2006-01-02'"`
+	Images    int       `gorm:"comment:'当日生成图片数'"`
+	Voices    int       `gorm:"comment:'当日生成语音数'"`
+	CreatedAt time.Time `gorm:"comment:'创建时间'"`
+	UpdatedAt time.Time `gorm:"comment:'更新时间'"`
+}
+
+func (QuotaUsage) TableName() string {
+	return "quota_usages"
+}
+
+// GetDocumentUsage 获取用户的文档数和上传文件总字节数
+func (db *Database) GetDocumentUsage(ctx context.Context, userID int64) (int64, int64, error) {
+	var ret struct {
+		Count int64
+		Bytes int64
+	}
+	err := db.db.WithContext(ctx).Model(&Document{}).
+		Select("COUNT(*) AS count, COALESCE(SUM(file_size), 0) AS bytes").
+		Where("user_id = ?", userID).
+		Scan(&ret).Error
+	if err != nil {
+		return 0, 0, err
+	}
+	return ret.Count, ret.Bytes, nil
+}
+
+// GetQuotaUsage 获取用户某天的生成用量，不存在时返回零值
+func (db *Database) GetQuotaUsage(ctx context.Context, userID int64, date string) (QuotaUsage, error) {
+	usages, err := gorm.G[QuotaUsage](db.db).Where("user_id = ? AND date = ?", userID, date).Limit(1).Find(ctx)
+	if err != nil {
+		return QuotaUsage{}, err
+	}
+	if len(usages) == 0 {
+		return QuotaUsage{UserID: userID, Date: date}, nil
+	}
+	return usages[0], nil
+}
+
+// IncrQuotaUsage 累加用户某天的图片和语音生成数
+func (db *Database) IncrQuotaUsage(ctx context.Context, userID int64, date string, images, voices int) error {
+	now := time.Now()
+	usage := QuotaUsage{
+		UserID:    userID,
+		Date:      date,
+		Images:    images,
+		Voices:    voices,
+		CreatedAt: now,
+		UpdatedAt: now,
+	}
+	return db.db.WithContext(ctx).Clauses(clause.OnConflict{
+		Columns: []clause.Column{{Name: "user_id"}, {Name: "date"}},
+		DoUpdates: clause.Assignments(map[string]interface{}{
+			"images":     gorm.Expr("images + ?", images),
+			"voices":     gorm.Expr("voices + ?", voices),
+			"updated_at": now,
+		}),
+	}).Create(&usage).Error
+}
@@ -0,0 +1,83 @@
+package db
+
+import (
+	"context"
+	"time"
+
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+)
+
+// Bookmark 用户书签表
+type Bookmark struct {
+	ID         string    `gorm:"primaryKey;size:32;comment:'主键'"`
+	UserID     int64     `gorm:"index:idx_bookmark_user_document,priority:1;comment:'用户 id'"`
+	DocumentID string    `gorm:"index:idx_bookmark_user_document,priority:2;size:32;comment:'文档 id'"`
+	ChapterID  string    `gorm:"size:32;comment:'章节 id'"`
+	SceneID    string    `gorm:"size:32;comment:'场景 id，为空表示章节书签'"`
+	Note       string    `gorm:"size:512;comment:'备注'"`
+	CreatedAt  time.Time `gorm:"comment:'创建时间'"`
+}
+
+func (Bookmark) TableName() string {
+	return "bookmarks"
+}
+
+// ReadPosition 用户在文档中的最后阅读位置，每个用户每个文档一条
+type ReadPosition struct {
+	ID         int64     `gorm:"primaryKey;autoIncrement"`
+	UserID     int64     `gorm:"uniqueIndex:uk_user_document,priority:1;comment:'用户 id'"`
+	DocumentID string    `gorm:"uniqueIndex:uk_user_document,priority:2;size:32;comment:'文档 id'"`
+	ChapterID  string    `gorm:"size:32;comment:'章节 id'"`
+	SceneID    string    `gorm:"size:32;comment:'场景 id'"`
+	CreatedAt  time.Time `gorm:"comment:'创建时间'"`
+	UpdatedAt  time.Time `gorm:"comment:'更新时间'"`
+}
+
+func (ReadPosition) TableName() string {
+	return "read_positions"
+}
+
+func (db *Database) CreateBookmark(ctx context.Context, bookmark *Bookmark) error {
+	return gorm.G[Bookmark](db.db).Create(ctx, bookmark)
+}
+
+func (db *Database) ListBookmarks(ctx context.Context, userID int64, documentID string) ([]Bookmark, error) {
+	return gorm.G[Bookmark](db.db).Where("user_id = ? AND document_id = ?", userID, documentID).Order("created_at ASC").Find(ctx)
+}
+
+func (db *Database) DeleteBookmark(ctx context.Context, id string, userID int64, documentID string) error {
+	rowsAffected, err := gorm.G[Bookmark](db.db).Where("id = ? AND user_id = ? AND document_id = ?", id, userID, documentID).Delete(ctx)
+	if err != nil {
+		return err
+	}
+	if rowsAffected == 0 {
+		return gorm.ErrRecordNotFound
+	}
+	return nil
+}
+
+func (db *Database) GetReadPosition(ctx context.Context, userID int64, documentID string) (ReadPosition, error) {
+	return gorm.G[ReadPosition](db.db).Where("user_id = ? AND document_id = ?", userID, documentID).Take(ctx)
+}
+
+// SaveReadPosition 保存用户在文档中的阅读位置，已存在时覆盖
+func (db *Database) SaveReadPosition(ctx context.Context, userID int64, documentID, chapterID, sceneID string) error {
+	now := time.Now()
+	pos := ReadPosition{
+		UserID:     userID,
+		DocumentID: documentID,
+		ChapterID:  chapterID,
+		SceneID:    sceneID,
+		CreatedAt:  now,
+		UpdatedAt:  now,
+	}
+	return db.db.WithContext(ctx).Clauses(clause.OnConflict{
+		Columns: []clause.Column{{Name: "user_id"}, {Name: "document_id"}},
+		DoUpdates: clause.Assignments(map[string]interface{}{
+			"chapter_id": chapterID,
+			"scene_id":   sceneID,
+			"updated_at": now,
+		}),
+	}).Create(&pos).Error
+}
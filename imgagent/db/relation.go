@@ -0,0 +1,55 @@
+package db
+
+import (
+	"context"
+	"time"
+
+	"gorm.io/gorm"
+)
+
+// RoleRelation 角色关系，由角色提取阶段生成。Type 为 SourceRoleID 相对 TargetRoleID 的身份，如 parent 表示来源角色是目标角色的父母
+type RoleRelation struct {
+	ID           string    `gorm:"primaryKey;size:32;comment:'主键'"`
+	DocumentID   string    `gorm:"index:idx_role_relation_document_id;size:32;comment:'文档 id'"`
+	SourceRoleID string    `gorm:"size:32;comment:'来源角色 id'"`
+	TargetRoleID string    `gorm:"size:32;comment:'目标角色 id'"`
+	Type         string    `gorm:"size:20;comment:'关系类型'"`
+	Description  string    `gorm:"size:255;comment:'关系说明'"`
+	CreatedAt    time.Time `gorm:"comment:'创建时间'"`
+}
+
+func (RoleRelation) TableName() string {
+	return "role_relations"
+}
+
+// ReplaceRoleRelations 用 relations 替换文档已有的角色关系
+func (db *Database) ReplaceRoleRelations(ctx context.Context, documentID string, relations []RoleRelation) error {
+	return db.db.WithContext(ctx).Transaction(func(tx *gorm.DB) error {
+		if _, err := gorm.G[RoleRelation](tx).Where("document_id = ?", documentID).Delete(ctx); err != nil {
+			return err
+		}
+		if len(relations) == 0 {
+			return nil
+		}
+		return gorm.G[RoleRelation](tx).CreateInBatches(ctx, &relations, batchSize)
+	})
+}
+
+// ListRoleRelations 列取文档的角色关系，按创建顺序排列
+func (db *Database) ListRoleRelations(ctx context.Context, documentID string) ([]RoleRelation, error) {
+	return gorm.G[RoleRelation](db.db).Where("document_id = ?", documentID).Order("created_at, id").Find(ctx)
+}
+
+// mergeRoleRelations 合并角色时把来源角色的关系转给目标角色，删除合并后指向自身的关系
+func mergeRoleRelations(ctx context.Context, tx *gorm.DB, documentID, targetID string, sourceIDs []string) error {
+	_, err := gorm.G[RoleRelation](tx).Where("document_id = ? AND source_role_id IN ?", documentID, sourceIDs).Update(ctx, "source_role_id", targetID)
+	if err != nil {
+		return err
+	}
+	_, err = gorm.G[RoleRelation](tx).Where("document_id = ? AND target_role_id IN ?", documentID, sourceIDs).Update(ctx, "target_role_id", targetID)
+	if err != nil {
+		return err
+	}
+	_, err = gorm.G[RoleRelation](tx).Where("document_id = ? AND source_role_id = target_role_id", documentID).Delete(ctx)
+	return err
+}
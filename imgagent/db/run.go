@@ -0,0 +1,76 @@
+package db
+
+import (
+	"context"
+	"time"
+
+	"gorm.io/gorm"
+)
+
+// DocumentRun 文档的一次处理结果快照，文档每次处理结束时记录，用于对比不同处理结果和回滚媒体
+type DocumentRun struct {
+	ID         string    `gorm:"primaryKey;size:32;comment:'主键'"`
+	DocumentID string    `gorm:"uniqueIndex:uk_run_document_seq,priority:1;size:32;comment:'文档 id'"`
+	Seq        int       `gorm:"uniqueIndex:uk_run_document_seq,priority:2;comment:'文档内的处理序号，从 1 开始'"`
+	Status     string    `gorm:"size:20;comment:'处理结束时的文档状态'"`
+	SceneCount int       `gorm:"comment:'场景总数'"`
+	ImageCount int       `gorm:"comment:'生成的图片数，不含占位媒体'"`
+	VoiceCount int       `gorm:"comment:'生成的语音数，不含占位媒体'"`
+	CreatedAt  time.Time `gorm:"comment:'创建时间'"`
+}
+
+func (DocumentRun) TableName() string {
+	return "document_runs"
+}
+
+// RunScene 处理结束时场景的 prompt 和媒体
+type RunScene struct {
+	RunID       string `gorm:"primaryKey;size:32;comment:'处理 id'"`
+	SceneID     string `gorm:"primaryKey;size:32;comment:'场景 id'"`
+	ChapterID   string `gorm:"size:32;comment:'章节 id'"`
+	Index       int    `gorm:"comment:'场景序号'"`
+	Prompt      string `gorm:"size:1000;comment:'场景描述，即生成媒体的 prompt'"`
+	ImageURL    string `gorm:"size:500;comment:'场景图片url'"`
+	VoiceURL    string `gorm:"size:500;comment:'音频url'"`
+	Placeholder bool   `gorm:"comment:'图片和语音是否为占位媒体'"`
+}
+
+func (RunScene) TableName() string {
+	return "run_scenes"
+}
+
+// CreateDocumentRun 记录一次处理结果，Seq 取文档已有处理的最大序号加 1
+func (db *Database) CreateDocumentRun(ctx context.Context, run *DocumentRun, scenes []RunScene) error {
+	return db.db.WithContext(ctx).Transaction(func(tx *gorm.DB) error {
+		var seq int
+		err := tx.Model(&DocumentRun{}).Where("document_id = ?", run.DocumentID).Select("COALESCE(MAX(seq), 0)").Scan(&seq).Error
+		if err != nil {
+			return err
+		}
+		run.Seq = seq + 1
+		err = gorm.G[DocumentRun](tx).Create(ctx, run)
+		if err != nil {
+			return err
+		}
+		for i := range scenes {
+			scenes[i].RunID = run.ID
+		}
+		if len(scenes) == 0 {
+			return nil
+		}
+		return gorm.G[RunScene](tx).CreateInBatches(ctx, &scenes, batchSize)
+	})
+}
+
+func (db *Database) GetDocumentRun(ctx context.Context, documentID string, seq int) (DocumentRun, error) {
+	return gorm.G[DocumentRun](db.db).Where("document_id = ? AND seq = ?", documentID, seq).Take(ctx)
+}
+
+// ListDocumentRuns 按序号倒序列取文档的处理记录
+func (db *Database) ListDocumentRuns(ctx context.Context, documentID string) ([]DocumentRun, error) {
+	return gorm.G[DocumentRun](db.db).Where("document_id = ?", documentID).Order("seq DESC").Find(ctx)
+}
+
+func (db *Database) ListRunScenes(ctx context.Context, runID string) ([]RunScene, error) {
+	return gorm.G[RunScene](db.db).Where("run_id = ?", runID).Order("`index` ASC").Find(ctx)
+}
@@ -0,0 +1,109 @@
+package db
+
+import (
+	"context"
+	"strings"
+	"time"
+
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+)
+
+// IndexTask 搜索索引 outbox，与场景写入在同一事务中写入，由索引任务异步消费。
+// SceneID 为空表示需要重建整个文档的索引
+type IndexTask struct {
+	ID         int64     `gorm:"primaryKey;autoIncrement"`
+	DocumentID string    `gorm:"size:32;comment:'文档 id'"`
+	SceneID    string    `gorm:"size:32;comment:'场景 id'"`
+	CreatedAt  time.Time `gorm:"comment:'创建时间'"`
+}
+
+func (IndexTask) TableName() string {
+	return "index_tasks"
+}
+
+// SearchEntry 场景全文索引
+type SearchEntry struct {
+	SceneID      string    `gorm:"primaryKey;size:32;comment:'场景 id'"`
+	DocumentID   string    `gorm:"index:idx_search_document_id;size:32;comment:'文档 id'"`
+	ChapterID    string    `gorm:"size:32;comment:'章节 id'"`
+	UserID       int64     `gorm:"index:idx_search_user_id;comment:'用户（租户） id'"`
+	Content      string    `gorm:"size:1000;comment:'归一化后的场景内容'"`
+	Display      string    `gorm:"size:1000;comment:'原始场景内容，作为搜索结果返回'"`
+	IndexVersion int       `gorm:"comment:'索引版本，低于当前版本的文档会被重建'"`
+	IndexedAt    time.Time `gorm:"comment:'索引时间'"`
+}
+
+func (SearchEntry) TableName() string {
+	return "search_entries"
+}
+
+// likeEscaper 转义 LIKE 通配符，使用 ! 作为转义符以兼容 MySQL 和 SQLite
+var likeEscaper = strings.NewReplacer("!", "!!", "%", "!%", "_", "!_")
+
+func sceneIndexTasks(scenes []Scene) []IndexTask {
+	now := time.Now()
+	tasks := make([]IndexTask, 0, len(scenes))
+	for _, s := range scenes {
+		tasks = append(tasks, IndexTask{DocumentID: s.DocumentID, SceneID: s.ID, CreatedAt: now})
+	}
+	return tasks
+}
+
+func documentIndexTask(documentID string) *IndexTask {
+	return &IndexTask{DocumentID: documentID, CreatedAt: time.Now()}
+}
+
+// EnqueueDocumentIndexTasks 为文档添加重建索引任务
+func (db *Database) EnqueueDocumentIndexTasks(ctx context.Context, documentIDs []string) error {
+	if len(documentIDs) == 0 {
+		return nil
+	}
+	tasks := make([]IndexTask, 0, len(documentIDs))
+	for _, id := range documentIDs {
+		tasks = append(tasks, *documentIndexTask(id))
+	}
+	return gorm.G[IndexTask](db.db).CreateInBatches(ctx, &tasks, batchSize)
+}
+
+func (db *Database) ListIndexTasks(ctx context.Context, limit int) ([]IndexTask, error) {
+	return gorm.G[IndexTask](db.db).Order("id ASC").Limit(limit).Find(ctx)
+}
+
+func (db *Database) DeleteIndexTasks(ctx context.Context, ids []int64) error {
+	if len(ids) == 0 {
+		return nil
+	}
+	_, err := gorm.G[IndexTask](db.db).Where("id IN ?", ids).Delete(ctx)
+	return err
+}
+
+func (db *Database) SaveSearchEntry(ctx context.Context, entry *SearchEntry) error {
+	return db.db.WithContext(ctx).Clauses(clause.OnConflict{UpdateAll: true}).Create(entry).Error
+}
+
+func (db *Database) DeleteSearchEntry(ctx context.Context, sceneID string) error {
+	_, err := gorm.G[SearchEntry](db.db).Where("scene_id = ?", sceneID).Delete(ctx)
+	return err
+}
+
+func (db *Database) DeleteSearchEntriesByDocument(ctx context.Context, documentID string) error {
+	_, err := gorm.G[SearchEntry](db.db).Where("document_id = ?", documentID).Delete(ctx)
+	return err
+}
+
+// ListStaleIndexDocuments 列取存在低版本索引的文档
+func (db *Database) ListStaleIndexDocuments(ctx context.Context, version int) ([]string, error) {
+	var ids []string
+	err := db.db.WithContext(ctx).Model(&SearchEntry{}).
+		Where("index_version < ?", version).
+		Distinct().Pluck("document_id", &ids).Error
+	return ids, err
+}
+
+// SearchScenes 在用户的场景索引中按关键字匹配
+func (db *Database) SearchScenes(ctx context.Context, userID int64, keyword string, limit int) ([]SearchEntry, error) {
+	return gorm.G[SearchEntry](db.db).
+		Where("user_id = ? AND content LIKE ? ESCAPE '!'", userID, "%"+likeEscaper.Replace(keyword)+"%").
+		Order("indexed_at DESC").Limit(limit).Find(ctx)
+}
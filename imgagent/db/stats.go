@@ -0,0 +1,70 @@
+package db
+
+import (
+	"context"
+	"time"
+)
+
+// DocumentStatusCount 按状态和是否暂停分组的文档数
+type DocumentStatusCount struct {
+	Status string
+	Paused bool
+	Count  int64
+}
+
+// CountDocumentsByStatus 按状态和是否暂停统计用户的文档数
+func (db *Database) CountDocumentsByStatus(ctx context.Context, userID int64) ([]DocumentStatusCount, error) {
+	var counts []DocumentStatusCount
+	err := db.db.WithContext(ctx).Model(&Document{}).
+		Select("status, paused, COUNT(*) AS count").
+		Where("user_id = ?", userID).
+		Group("status, paused").
+		Scan(&counts).Error
+	return counts, err
+}
+
+// SumQuotaUsage 累加用户从 fromDate（含）起每日的生成用量
+func (db *Database) SumQuotaUsage(ctx context.Context, userID int64, fromDate string) (QuotaUsage, error) {
+	var ret QuotaUsage
+	err := db.db.WithContext(ctx).Model(&QuotaUsage{}).
+		Select("COALESCE(SUM(images), 0) AS images, COALESCE(SUM(voices), 0) AS voices").
+		Where("user_id = ? AND date >= ?", userID, fromDate).
+		Scan(&ret).Error
+	ret.UserID = userID
+	return ret, err
+}
+
+// ListProcessingDurations 用户在 since 之后首次处理结束的文档，从创建到处理结束的耗时
+func (db *Database) ListProcessingDurations(ctx context.Context, userID int64, since time.Time) ([]time.Duration, error) {
+	var rows []struct {
+		CreatedAt  time.Time
+		FinishedAt time.Time
+	}
+	err := db.db.WithContext(ctx).Table("document_runs").
+		Select("documents.created_at AS created_at, document_runs.created_at AS finished_at").
+		Joins("JOIN documents ON documents.id = document_runs.document_id").
+		Where("documents.user_id = ? AND document_runs.seq = 1 AND document_runs.created_at >= ?", userID, since).
+		Scan(&rows).Error
+	if err != nil {
+		return nil, err
+	}
+	durations := make([]time.Duration, 0, len(rows))
+	for _, r := range rows {
+		durations = append(durations, r.FinishedAt.Sub(r.CreatedAt))
+	}
+	return durations, nil
+}
+
+// DocumentFinishedStatuses 处理已结束的文档状态
+var DocumentFinishedStatuses = []string{DocumentStatusImgReady, DocumentStatusCompletedWithErrors, DocumentStatusFailed, DocumentStatusCanceled}
+
+// CountPendingScenes 统计用户处理中且未暂停的文档里，尚未生成完成、也未永久失败的场景数
+func (db *Database) CountPendingScenes(ctx context.Context, userID int64) (int64, error) {
+	var count int64
+	err := db.db.WithContext(ctx).Model(&Scene{}).
+		Joins("JOIN documents ON documents.id = scenes.document_id").
+		Where("documents.user_id = ? AND documents.paused = ? AND documents.status NOT IN ?", userID, false, DocumentFinishedStatuses).
+		Where("scenes.status NOT IN ?", []string{SceneStatusReady, SceneStatusFailed}).
+		Count(&count).Error
+	return count, err
+}
@@ -0,0 +1,143 @@
+package db
+
+import (
+	"context"
+	"errors"
+	"slices"
+	"time"
+
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+)
+
+const (
+	TranslationStatusPending   = "pending"
+	TranslationStatusRunning   = "running"
+	TranslationStatusSucceeded = "succeeded"
+	TranslationStatusFailed    = "failed"
+)
+
+// DocumentTranslation 文档翻译任务，每个文档的每种目标语言一条，重新翻译时复用
+type DocumentTranslation struct {
+	DocumentID string    `gorm:"primaryKey;size:32;comment:'文档 id'"`
+	Lang       string    `gorm:"primaryKey;size:16;comment:'目标语言'"`
+	UserID     int64     `gorm:"comment:'用户（租户） id'"`
+	Status     string    `gorm:"index:idx_translation_status;size:16;comment:'状态'"`
+	Translated int       `gorm:"comment:'已翻译的章节数'"`
+	Total      int       `gorm:"comment:'章节总数'"`
+	Error      string    `gorm:"size:500;comment:'失败原因'"`
+	CreatedAt  time.Time `gorm:"comment:'创建时间'"`
+	UpdatedAt  time.Time `gorm:"comment:'更新时间'"`
+}
+
+func (DocumentTranslation) TableName() string {
+	return "document_translations"
+}
+
+// ChapterTranslation 章节译文，与原文分开保存。SourceHash 为翻译时原文标题和内容的 sha256，原文修改后重新翻译
+type ChapterTranslation struct {
+	ChapterID  string    `gorm:"primaryKey;size:32;comment:'章节 id'"`
+	Lang       string    `gorm:"primaryKey;size:16;comment:'目标语言'"`
+	DocumentID string    `gorm:"index:idx_chapter_translation_document_id;size:32;comment:'文档 id'"`
+	Title      string    `gorm:"size:255;comment:'译文标题'"`
+	Content    string    `gorm:"type:mediumtext;comment:'译文内容'"`
+	SourceHash string    `gorm:"size:64;comment:'原文的 sha256'"`
+	UpdatedAt  time.Time `gorm:"comment:'更新时间'"`
+}
+
+func (ChapterTranslation) TableName() string {
+	return "chapter_translations"
+}
+
+// StartTranslation 创建文档的翻译任务，已结束的任务重新置为 pending；任务待执行或执行中时不修改，返回当前任务
+func (db *Database) StartTranslation(ctx context.Context, userID int64, documentID, lang string) (DocumentTranslation, error) {
+	var t DocumentTranslation
+	err := db.db.WithContext(ctx).Transaction(func(tx *gorm.DB) error {
+		var err error
+		t, err = gorm.G[DocumentTranslation](tx).Where("document_id = ? AND lang = ?", documentID, lang).Take(ctx)
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			t = DocumentTranslation{DocumentID: documentID, Lang: lang, UserID: userID, Status: TranslationStatusPending}
+			return gorm.G[DocumentTranslation](tx).Create(ctx, &t)
+		}
+		if err != nil {
+			return err
+		}
+		if slices.Contains([]string{TranslationStatusPending, TranslationStatusRunning}, t.Status) {
+			return nil
+		}
+		t.UserID = userID
+		t.Status = TranslationStatusPending
+		t.Translated = 0
+		t.Error = ""
+		t.UpdatedAt = time.Now()
+		return tx.Model(&DocumentTranslation{}).Where("document_id = ? AND lang = ?", documentID, lang).Updates(map[string]interface{}{
+			"user_id":    t.UserID,
+			"status":     t.Status,
+			"translated": t.Translated,
+			"error":      t.Error,
+			"updated_at": t.UpdatedAt,
+		}).Error
+	})
+	return t, err
+}
+
+func (db *Database) GetTranslation(ctx context.Context, documentID, lang string) (DocumentTranslation, error) {
+	return gorm.G[DocumentTranslation](db.db).Where("document_id = ? AND lang = ?", documentID, lang).Take(ctx)
+}
+
+func (db *Database) ListPendingTranslations(ctx context.Context, limit int) ([]DocumentTranslation, error) {
+	return gorm.G[DocumentTranslation](db.db).Where("status = ?", TranslationStatusPending).Order("updated_at ASC").Limit(limit).Find(ctx)
+}
+
+// TransitTranslation 仅当任务处于 from 中的某个状态时更新状态，用于领取任务，状态已变化时返回 gorm.ErrRecordNotFound
+func (db *Database) TransitTranslation(ctx context.Context, documentID, lang string, from []string, to string) error {
+	result := db.db.WithContext(ctx).Model(&DocumentTranslation{}).Where("document_id = ? AND lang = ? AND status IN ?", documentID, lang, from).Updates(map[string]interface{}{
+		"status":     to,
+		"updated_at": time.Now(),
+	})
+	if result.Error != nil {
+		return result.Error
+	}
+	if result.RowsAffected == 0 {
+		return gorm.ErrRecordNotFound
+	}
+	return nil
+}
+
+// UpdateTranslationProgress 更新执行中任务的已翻译章节数
+func (db *Database) UpdateTranslationProgress(ctx context.Context, documentID, lang string, translated, total int) error {
+	return db.db.WithContext(ctx).Model(&DocumentTranslation{}).Where("document_id = ? AND lang = ? AND status = ?", documentID, lang, TranslationStatusRunning).Updates(map[string]interface{}{
+		"translated": translated,
+		"total":      total,
+		"updated_at": time.Now(),
+	}).Error
+}
+
+// CompleteTranslation 记录执行结果，任务不在执行中时不更新并返回 gorm.ErrRecordNotFound
+func (db *Database) CompleteTranslation(ctx context.Context, documentID, lang string, status string, errMsg string) error {
+	result := db.db.WithContext(ctx).Model(&DocumentTranslation{}).Where("document_id = ? AND lang = ? AND status = ?", documentID, lang, TranslationStatusRunning).Updates(map[string]interface{}{
+		"status":     status,
+		"error":      errMsg,
+		"updated_at": time.Now(),
+	})
+	if result.Error != nil {
+		return result.Error
+	}
+	if result.RowsAffected == 0 {
+		return gorm.ErrRecordNotFound
+	}
+	return nil
+}
+
+// SaveChapterTranslation 写入章节译文，已存在时覆盖
+func (db *Database) SaveChapterTranslation(ctx context.Context, t *ChapterTranslation) error {
+	return db.db.WithContext(ctx).Clauses(clause.OnConflict{UpdateAll: true}).Create(t).Error
+}
+
+func (db *Database) ListChapterTranslations(ctx context.Context, documentID, lang string) ([]ChapterTranslation, error) {
+	return gorm.G[ChapterTranslation](db.db).Where("document_id = ? AND lang = ?", documentID, lang).Find(ctx)
+}
+
+func (db *Database) GetChapterTranslation(ctx context.Context, chapterID, lang string) (ChapterTranslation, error) {
+	return gorm.G[ChapterTranslation](db.db).Where("chapter_id = ? AND lang = ?", chapterID, lang).Take(ctx)
+}
@@ -0,0 +1,41 @@
+package db
+
+import (
+	"context"
+	"time"
+
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+)
+
+// URLPolicy 租户通过 URL 创建文档时允许访问的来源，由管理员维护，没有记录时使用配置的默认策略
+type URLPolicy struct {
+	UserID         int64     `gorm:"primaryKey;autoIncrement:false;comment:'租户 id'"`
+	AllowedDomains []string  `gorm:"type:json;serializer:json;comment:'允许的域名，为空时不限制'"`
+	DeniedDomains  []string  `gorm:"type:json;serializer:json;comment:'禁止的域名，优先于允许的域名'"`
+	AllowPrivate   bool      `gorm:"not null;default:false;comment:'是否允许访问内网地址'"`
+	CreatedAt      time.Time `gorm:"comment:'创建时间'"`
+	UpdatedAt      time.Time `gorm:"comment:'更新时间'"`
+}
+
+func (URLPolicy) TableName() string {
+	return "url_policies"
+}
+
+func (db *Database) GetURLPolicy(ctx context.Context, userID int64) (URLPolicy, error) {
+	return gorm.G[URLPolicy](db.db).Where("user_id = ?", userID).Take(ctx)
+}
+
+// SaveURLPolicy 创建或覆盖租户的 URL 来源策略
+func (db *Database) SaveURLPolicy(ctx context.Context, policy *URLPolicy) error {
+	return db.db.WithContext(ctx).Clauses(clause.OnConflict{
+		Columns:   []clause.Column{{Name: "user_id"}},
+		DoUpdates: clause.AssignmentColumns([]string{"allowed_domains", "denied_domains", "allow_private", "updated_at"}),
+	}).Create(policy).Error
+}
+
+// DeleteURLPolicy 删除租户的策略，恢复为默认策略
+func (db *Database) DeleteURLPolicy(ctx context.Context, userID int64) error {
+	_, err := gorm.G[URLPolicy](db.db).Where("user_id = ?", userID).Delete(ctx)
+	return err
+}
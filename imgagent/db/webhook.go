@@ -0,0 +1,113 @@
+package db
+
+import (
+	"context"
+	"time"
+
+	"gorm.io/gorm"
+)
+
+const (
+	WebhookDeliveryPending   = "pending"
+	WebhookDeliverySucceeded = "succeeded"
+	WebhookDeliveryFailed    = "failed"
+)
+
+// Webhook 用户注册的回调地址，DocumentID 为空表示接收该用户所有文档的事件
+type Webhook struct {
+	ID          string    `gorm:"primaryKey;size:32;comment:'主键'"`
+	UserID      int64     `gorm:"index:idx_webhook_user_id;comment:'用户（租户） id'"`
+	DocumentID  string    `gorm:"size:32;comment:'文档 id，为空表示全部文档'"`
+	URL         string    `gorm:"size:512;comment:'回调地址'"`
+	Secret      string    `gorm:"size:64;comment:'签名密钥'"`
+	BatchScenes bool      `gorm:"comment:'场景就绪事件按章节合并投递'"`
+	Template    string    `gorm:"type:text;comment:'回调 body 模板'"`
+	ContentType string    `gorm:"size:100;comment:'回调 Content-Type'"`
+	CreatedAt   time.Time `gorm:"comment:'创建时间'"`
+}
+
+func (Webhook) TableName() string {
+	return "webhooks"
+}
+
+// WebhookDelivery 待投递的回调，失败后按指数退避重试，服务重启后继续投递
+type WebhookDelivery struct {
+	ID            string    `gorm:"primaryKey;size:32;comment:'主键'"`
+	WebhookID     string    `gorm:"size:32;comment:'webhook id'"`
+	EventType     string    `gorm:"size:32;comment:'事件类型'"`
+	Payload       string    `gorm:"type:text;comment:'请求 body'"`
+	Status        string    `gorm:"index:idx_delivery_status_next,priority:1;size:16;comment:'投递状态'"`
+	Attempts      int       `gorm:"comment:'已投递次数'"`
+	NextAttemptAt time.Time `gorm:"index:idx_delivery_status_next,priority:2;comment:'下次投递时间'"`
+	LastError     string    `gorm:"size:512;comment:'最近一次投递错误'"`
+	CreatedAt     time.Time `gorm:"comment:'创建时间'"`
+	UpdatedAt     time.Time `gorm:"comment:'更新时间'"`
+}
+
+func (WebhookDelivery) TableName() string {
+	return "webhook_deliveries"
+}
+
+func (db *Database) CreateWebhook(ctx context.Context, webhook *Webhook) error {
+	return gorm.G[Webhook](db.db).Create(ctx, webhook)
+}
+
+func (db *Database) GetWebhook(ctx context.Context, id string) (Webhook, error) {
+	return gorm.G[Webhook](db.db).Where("id = ?", id).Take(ctx)
+}
+
+// ListWebhooks 列取用户的 webhook，documentID 非空时只列取该文档的
+func (db *Database) ListWebhooks(ctx context.Context, userID int64, documentID string) ([]Webhook, error) {
+	q := gorm.G[Webhook](db.db).Where("user_id = ?", userID)
+	if documentID != "" {
+		q = q.Where("document_id = ?", documentID)
+	}
+	return q.Order("created_at ASC").Find(ctx)
+}
+
+// ListDocumentWebhooks 列取应接收该文档事件的 webhook：注册在该文档上的和用户全局的
+func (db *Database) ListDocumentWebhooks(ctx context.Context, userID int64, documentID string) ([]Webhook, error) {
+	return gorm.G[Webhook](db.db).Where("user_id = ? AND (document_id = ? OR document_id = '')", userID, documentID).Find(ctx)
+}
+
+// ListUserWebhooks 列取用户全局的 webhook，用于不属于某个文档的事件
+func (db *Database) ListUserWebhooks(ctx context.Context, userID int64) ([]Webhook, error) {
+	return gorm.G[Webhook](db.db).Where("user_id = ? AND document_id = ''", userID).Find(ctx)
+}
+
+func (db *Database) DeleteWebhook(ctx context.Context, id string, userID int64) error {
+	rowsAffected, err := gorm.G[Webhook](db.db).Where("id = ? AND user_id = ?", id, userID).Delete(ctx)
+	if err != nil {
+		return err
+	}
+	if rowsAffected == 0 {
+		return gorm.ErrRecordNotFound
+	}
+	return nil
+}
+
+func (db *Database) CreateWebhookDeliveries(ctx context.Context, deliveries []WebhookDelivery) error {
+	if len(deliveries) == 0 {
+		return nil
+	}
+	return gorm.G[WebhookDelivery](db.db).CreateInBatches(ctx, &deliveries, batchSize)
+}
+
+// ListDueWebhookDeliveries 列取到期待投递的回调
+func (db *Database) ListDueWebhookDeliveries(ctx context.Context, now time.Time, limit int) ([]WebhookDelivery, error) {
+	return gorm.G[WebhookDelivery](db.db).
+		Where("status = ? AND next_attempt_at <= ?", WebhookDeliveryPending, now).
+		Order("next_attempt_at ASC").Limit(limit).Find(ctx)
+}
+
+// UpdateWebhookDelivery 记录一次投递结果
+func (db *Database) UpdateWebhookDelivery(ctx context.Context, id string, status string, attempts int, nextAttemptAt time.Time, lastError string) error {
+	updates := map[string]interface{}{
+		"status":          status,
+		"attempts":        attempts,
+		"next_attempt_at": nextAttemptAt,
+		"last_error":      lastError,
+		"updated_at":      time.Now(),
+	}
+	return db.db.WithContext(ctx).Model(&WebhookDelivery{}).Where("id = ?", id).Updates(updates).Error
+}
@@ -0,0 +1,98 @@
+// Package embedding 文本向量。Provider 屏蔽不同向量服务的差异，章节上传后计算向量，用于文档内的语义搜索。
+// 未配置向量服务时不计算向量，语义搜索不可用。
+package embedding
+
+import (
+	"context"
+	"errors"
+	"math"
+	"sort"
+)
+
+// ErrDimensionMismatch 向量维度不一致，通常是更换了向量模型
+var ErrDimensionMismatch = errors.New("vector dimension mismatch")
+
+// Provider 向量服务，按输入顺序返回每段文本的向量
+type Provider interface {
+	Embed(ctx context.Context, texts []string) ([][]float32, error)
+	// Model 向量模型名，更换模型后已保存的向量需要重新计算
+	Model() string
+}
+
+// Config 兼容 OpenAI /v1/embeddings 接口的向量服务，百炼可使用兼容模式地址
+type Config struct {
+	URL            string `json:"url"`             // 接口完整地址，如 https://dashscope.aliyuncs.com/compatible-mode/v1/embeddings，为空时不计算向量
+	Model          string `json:"model"`           // 向量模型，如 text-embedding-v3
+	APIKey         string `json:"api_key"`         // API 密钥
+	Dimensions     int    `json:"dimensions"`      // 向量维度，为 0 时使用模型默认维度
+	BatchSize      int    `json:"batch_size"`      // 单次请求的文本数，默认 10
+	MaxInputChars  int    `json:"max_input_chars"` // 单段文本的最大字符数，超出部分截断，默认 8000
+	RequestTimeout int    `json:"request_timeout"` // 请求超时时间（秒），默认 60
+}
+
+// New 按配置创建向量服务，未配置时返回 nil
+func New(conf Config) (Provider, error) {
+	if conf.URL == "" {
+		return nil, nil
+	}
+	return NewOpenAI(conf)
+}
+
+// Record 一段文本的向量，Hash 为文本内容的摘要，内容变化后需要重新计算
+type Record struct {
+	ID         string
+	DocumentID string
+	Hash       string
+	Model      string
+	Vector     []float32
+}
+
+// Hit 语义搜索命中的文本，Score 为余弦相似度
+type Hit struct {
+	ID    string
+	Score float64
+}
+
+// Store 向量存储，按文档隔离
+type Store interface {
+	Save(ctx context.Context, records []Record) error
+	// List 列取文档的向量，不含向量数据，用于判断哪些文本需要重新计算
+	List(ctx context.Context, documentID string) ([]Record, error)
+	Delete(ctx context.Context, documentID string, ids []string) error
+	// Search 返回文档中与 vector 最相似的 limit 段文本，按相似度降序
+	Search(ctx context.Context, documentID string, vector []float32, limit int) ([]Hit, error)
+}
+
+// Cosine 余弦相似度，任一向量为零向量时返回 0
+func Cosine(a, b []float32) (float64, error) {
+	if len(a) != len(b) {
+		return 0, ErrDimensionMismatch
+	}
+	var dot, na, nb float64
+	for i := range a {
+		dot += float64(a[i]) * float64(b[i])
+		na += float64(a[i]) * float64(a[i])
+		nb += float64(b[i]) * float64(b[i])
+	}
+	if na == 0 || nb == 0 {
+		return 0, nil
+	}
+	return dot / (math.Sqrt(na) * math.Sqrt(nb)), nil
+}
+
+// Rank 计算 records 与 vector 的相似度，返回相似度最高的 limit 条，维度不一致的向量跳过
+func Rank(records []Record, vector []float32, limit int) []Hit {
+	hits := make([]Hit, 0, len(records))
+	for _, r := range records {
+		score, err := Cosine(r.Vector, vector)
+		if err != nil {
+			continue
+		}
+		hits = append(hits, Hit{ID: r.ID, Score: score})
+	}
+	sort.SliceStable(hits, func(i, j int) bool { return hits[i].Score > hits[j].Score })
+	if len(hits) > limit {
+		hits = hits[:limit]
+	}
+	return hits
+}
@@ -0,0 +1,83 @@
+package embedding
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestNew(t *testing.T) {
+	p, err := New(Config{})
+	require.NoError(t, err)
+	assert.Nil(t, p)
+
+	_, err = New(Config{URL: "http://localhost/v1/embeddings"})
+	require.Error(t, err)
+	p, err = New(Config{URL: "http://localhost/v1/embeddings", Model: "text-embedding-v3", APIKey: "test"})
+	require.NoError(t, err)
+	assert.Equal(t, "text-embedding-v3", p.Model())
+}
+
+func TestRank(t *testing.T) {
+	score, err := Cosine([]float32{1, 0}, []float32{1, 0})
+	require.NoError(t, err)
+	assert.InDelta(t, 1, score, 1e-9)
+	score, err = Cosine([]float32{0, 0}, []float32{1, 0})
+	require.NoError(t, err)
+	assert.Zero(t, score)
+	_, err = Cosine([]float32{1}, []float32{1, 0})
+	assert.ErrorIs(t, err, ErrDimensionMismatch)
+
+	records := []Record{
+		{ID: "a", Vector: []float32{0, 1}},
+		{ID: "b", Vector: []float32{1, 0}},
+		{ID: "c", Vector: []float32{1, 1}},
+		{ID: "d", Vector: []float32{1}},
+	}
+	hits := Rank(records, []float32{1, 0.1}, 2)
+	require.Len(t, hits, 2)
+	assert.Equal(t, "b", hits[0].ID)
+	assert.Equal(t, "c", hits[1].ID)
+}
+
+func TestOpenAI(t *testing.T) {
+	var batches [][]string
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		assert.Equal(t, "Bearer test", r.Header.Get("Authorization"))
+		var req openAIEmbeddingRequest
+		require.NoError(t, json.NewDecoder(r.Body).Decode(&req))
+		assert.Equal(t, "text-embedding-v3", req.Model)
+		assert.Equal(t, 4, req.Dimensions)
+		batches = append(batches, req.Input)
+		if req.Input[0] == "err" {
+			w.WriteHeader(http.StatusBadRequest)
+			return
+		}
+		// 倒序返回，按 index 对应输入
+		w.Write([]byte(`{"data":[`))
+		for i := len(req.Input) - 1; i >= 0; i-- {
+			fmt.Fprintf(w, `{"index":%d,"embedding":[%d,0,0,0]}`, i, len([]rune(req.Input[i])))
+			if i > 0 {
+				w.Write([]byte(","))
+			}
+		}
+		w.Write([]byte(`]}`))
+	}))
+	defer server.Close()
+
+	p, err := NewOpenAI(Config{URL: server.URL, Model: "text-embedding-v3", APIKey: "test", Dimensions: 4, BatchSize: 2, MaxInputChars: 3})
+	require.NoError(t, err)
+	vectors, err := p.Embed(context.Background(), []string{"a", "bb", "章节内容很长"})
+	require.NoError(t, err)
+	assert.Equal(t, [][]float32{{1, 0, 0, 0}, {2, 0, 0, 0}, {3, 0, 0, 0}}, vectors)
+	assert.Equal(t, [][]string{{"a", "bb"}, {"章节内"}}, batches)
+
+	_, err = p.Embed(context.Background(), []string{"err"})
+	require.Error(t, err)
+}
@@ -0,0 +1,140 @@
+package embedding
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"time"
+
+	"imgagent/pkg/faults"
+	"imgagent/pkg/logger"
+	"imgagent/pkg/tracing"
+)
+
+type openAIEmbeddingRequest struct {
+	Model          string   `json:"model"`
+	Input          []string `json:"input"`
+	Dimensions     int      `json:"dimensions,omitempty"`
+	EncodingFormat string   `json:"encoding_format"`
+}
+
+type openAIEmbeddingResponse struct {
+	Data []struct {
+		Index     int       `json:"index"`
+		Embedding []float32 `json:"embedding"`
+	} `json:"data"`
+}
+
+// OpenAI 兼容 OpenAI 向量接口的服务
+type OpenAI struct {
+	config     Config
+	httpClient *http.Client
+}
+
+func NewOpenAI(config Config) (*OpenAI, error) {
+	if config.Model == "" {
+		return nil, errors.New("embedding model is required")
+	}
+	if config.APIKey == "" {
+		return nil, errors.New("embedding api key is required")
+	}
+	if config.BatchSize <= 0 {
+		config.BatchSize = 10
+	}
+	if config.MaxInputChars <= 0 {
+		config.MaxInputChars = 8000
+	}
+	if config.RequestTimeout == 0 {
+		config.RequestTimeout = 60
+	}
+	return &OpenAI{
+		config: config,
+		httpClient: &http.Client{
+			Timeout:   time.Duration(config.RequestTimeout) * time.Second,
+			Transport: tracing.NewTransport(logger.NewTransport(faults.NewTransport(http.DefaultTransport, faults.TargetEmbedding))),
+		},
+	}, nil
+}
+
+func (o *OpenAI) Model() string {
+	return o.config.Model
+}
+
+// Embed 按 BatchSize 分批请求，超长文本截断到 MaxInputChars
+func (o *OpenAI) Embed(ctx context.Context, texts []string) ([][]float32, error) {
+	vectors := make([][]float32, 0, len(texts))
+	for start := 0; start < len(texts); start += o.config.BatchSize {
+		batch := texts[start:min(start+o.config.BatchSize, len(texts))]
+		input := make([]string, 0, len(batch))
+		for _, text := range batch {
+			if r := []rune(text); len(r) > o.config.MaxInputChars {
+				text = string(r[:o.config.MaxInputChars])
+			}
+			input = append(input, text)
+		}
+		ret, err := o.embed(ctx, input)
+		if err != nil {
+			return nil, err
+		}
+		vectors = append(vectors, ret...)
+	}
+	return vectors, nil
+}
+
+func (o *OpenAI) embed(ctx context.Context, input []string) ([][]float32, error) {
+	log := logger.FromContext(ctx)
+
+	reqBody, err := json.Marshal(openAIEmbeddingRequest{
+		Model:          o.config.Model,
+		Input:          input,
+		Dimensions:     o.config.Dimensions,
+		EncodingFormat: "float",
+	})
+	if err != nil {
+		return nil, fmt.Errorf("marshal request failed: %w", err)
+	}
+	httpReq, err := http.NewRequestWithContext(ctx, http.MethodPost, o.config.URL, bytes.NewReader(reqBody))
+	if err != nil {
+		return nil, fmt.Errorf("create request failed: %w", err)
+	}
+	httpReq.Header.Set("Authorization", "Bearer "+o.config.APIKey)
+	httpReq.Header.Set("Content-Type", "application/json")
+
+	resp, err := o.httpClient.Do(httpReq)
+	if err != nil {
+		log.Errorf("Failed to send request, err: %v", err)
+		return nil, fmt.Errorf("send request failed: %w", err)
+	}
+	defer resp.Body.Close()
+
+	respBody, err := io.ReadAll(resp.Body)
+	if err != nil {
+		return nil, fmt.Errorf("read response failed: %w", err)
+	}
+	if resp.StatusCode != http.StatusOK {
+		log.Errorf("Embedding failed, status: %d, body: %s", resp.StatusCode, string(respBody))
+		return nil, fmt.Errorf("embedding failed, status: %d, body: %s", resp.StatusCode, string(respBody))
+	}
+	var embResp openAIEmbeddingResponse
+	if err = json.Unmarshal(respBody, &embResp); err != nil {
+		return nil, fmt.Errorf("parse response failed: %w", err)
+	}
+	if len(embResp.Data) != len(input) {
+		return nil, fmt.Errorf("expected %d embeddings, got %d", len(input), len(embResp.Data))
+	}
+
+	// 响应按 index 对应输入，不依赖返回顺序
+	vectors := make([][]float32, len(input))
+	for _, d := range embResp.Data {
+		if d.Index < 0 || d.Index >= len(input) || vectors[d.Index] != nil {
+			return nil, fmt.Errorf("invalid embedding index: %d", d.Index)
+		}
+		vectors[d.Index] = d.Embedding
+	}
+	log.Infof("Embedding generated, count: %d, model: %s", len(input), o.config.Model)
+	return vectors, nil
+}
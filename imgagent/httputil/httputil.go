@@ -22,6 +22,15 @@ func WriteData(c *gin.Context, data any) {
 	})
 }
 
+// WriteAccepted 请求已接受但尚未处理完成，data 一般为可轮询的任务信息
+func WriteAccepted(c *gin.Context, data any) {
+	c.JSON(http.StatusAccepted, proto.BaseResponse{
+		Code:  http.StatusAccepted,
+		Reqid: c.MustGet(middleware.XReqID).(string),
+		Data:  data,
+	})
+}
+
 // AbortError 失败时返回的错误信息，code 表示业务错误码，msg 为错误信息
 func AbortError(c *gin.Context, code int, msg string) {
 	c.AbortWithStatusJSON(http.StatusOK, proto.BaseResponse{
@@ -36,16 +45,19 @@ func AbortError(c *gin.Context, code int, msg string) {
 func AbortErr(c *gin.Context, err error) {
 	code := ErrServerInternalCode
 	var msg string
+	var data any
 	ae, ok := err.(*proto.ApiError)
 	if ok {
 		code = ae.Code
 		msg = ae.Message
+		data = ae.Data
 	} else {
 		msg = err.Error()
 	}
 	c.AbortWithStatusJSON(http.StatusOK, proto.BaseResponse{
 		Code:    code,
 		Message: msg,
+		Data:    data,
 		// xReqID 必须存在。
 		Reqid: c.MustGet(middleware.XReqID).(string),
 	})
@@ -57,3 +69,12 @@ func NewApiError(code int, msg string) *proto.ApiError {
 		Message: msg,
 	}
 }
+
+// NewApiErrorWithData 带错误详情的错误，data 写入响应的 data 字段
+func NewApiErrorWithData(code int, msg string, data any) *proto.ApiError {
+	return &proto.ApiError{
+		Code:    code,
+		Message: msg,
+		Data:    data,
+	}
+}
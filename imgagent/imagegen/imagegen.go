@@ -0,0 +1,271 @@
+// Package imagegen 场景图片生成。Provider 屏蔽不同图片服务的差异，默认使用百炼，
+// 可在配置中启用 OpenAI Images 或自建的 Stable Diffusion 服务，文档可单独指定使用的服务。
+package imagegen
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+
+	"imgagent/bailian"
+	"imgagent/pkg/mediastore"
+)
+
+// 可选的图片服务
+const (
+	ProviderBailian         = "bailian"
+	ProviderOpenAI          = "openai"
+	ProviderStableDiffusion = "stable_diffusion"
+)
+
+// 画面比例，Options.Size 为空时各服务按比例选择支持的分辨率
+const (
+	AspectRatioLandscape = "16:9"
+	AspectRatioPortrait  = "9:16"
+	AspectRatioSquare    = "1:1"
+)
+
+// AspectRatios 支持的画面比例
+var AspectRatios = []string{AspectRatioLandscape, AspectRatioPortrait, AspectRatioSquare}
+
+// Options 场景图片的生成参数，为空的字段使用服务的默认值
+type Options struct {
+	Summary         string             // 小说摘要
+	Roles           []bailian.RoleInfo // 文档的角色信息
+	Style           string             // 画面风格
+	NegativePrompt  string             // 反向提示词，画面中需要避免的内容
+	Size            string             // 图片分辨率，格式为 宽*高，如 1328*1328
+	AspectRatio     string             // 画面比例 16:9|9:16|1:1，Size 为空时使用
+	Model           string             // 图片模型
+	RoleConsistency bool               // 只注入场景中出现的角色并要求外貌与设定严格一致
+	Prompt          string             // 自定义模板渲染出的完整提示词，为空时由服务按场景描述构建
+}
+
+// Provider 图片生成服务，prompt 为场景描述，返回可访问的图片 URL
+type Provider interface {
+	Generate(ctx context.Context, prompt string, opts Options) (string, error)
+}
+
+// Request 服务实际收到的提示词
+type Request struct {
+	Prompt         string   // 完整的正向提示词
+	NegativePrompt string   // 反向提示词，服务不支持时已追加到 Prompt 中
+	References     []string // 角色参考立绘
+}
+
+// Previewer 不调用服务，返回 Generate 会发送的提示词，用于调试提示词
+type Previewer interface {
+	Preview(prompt string, opts Options) Request
+}
+
+// Config 图片生成配置
+type Config struct {
+	Default         string                `json:"default"`          // 文档未指定时使用的服务，默认 bailian
+	Dir             string                `json:"dir"`              // 服务直接返回图片数据时的保存目录，默认 ./images，多实例部署时需为共享目录
+	OpenAI          OpenAIConfig          `json:"openai"`           // APIKey 为空时不启用
+	StableDiffusion StableDiffusionConfig `json:"stable_diffusion"` // BaseURL 为空时不启用
+	Upscale         UpscaleConfig         `json:"upscale"`          // 场景图片超分
+}
+
+// Providers 已启用的图片服务
+type Providers struct {
+	def       string
+	providers map[string]Provider
+	upscaler  Upscaler
+	upscale   int
+}
+
+// New 按配置创建已启用的图片服务，服务直接返回图片数据时保存到 store
+func New(conf Config, bailianClient *bailian.Client, store *mediastore.Store) (*Providers, error) {
+	p := Default(bailianClient)
+	if conf.OpenAI.APIKey != "" {
+		p.providers[ProviderOpenAI] = NewOpenAI(conf.OpenAI, store)
+	}
+	if conf.StableDiffusion.BaseURL != "" {
+		p.providers[ProviderStableDiffusion] = NewStableDiffusion(conf.StableDiffusion, store)
+	}
+	switch conf.Upscale.Provider {
+	case "", ProviderBailian:
+	case ProviderStableDiffusion:
+		sd, ok := p.providers[ProviderStableDiffusion].(*StableDiffusion)
+		if !ok {
+			return nil, errors.New("upscale provider stable_diffusion is not enabled")
+		}
+		p.upscaler = sd
+	default:
+		return nil, fmt.Errorf("unsupported upscale provider %s", conf.Upscale.Provider)
+	}
+	if conf.Upscale.Scale != 0 {
+		if conf.Upscale.Scale < 1 || conf.Upscale.Scale > MaxUpscale {
+			return nil, fmt.Errorf("upscale scale must be between 1 and %d", MaxUpscale)
+		}
+		p.upscale = conf.Upscale.Scale
+	}
+	if conf.Default != "" {
+		if !p.Has(conf.Default) {
+			return nil, fmt.Errorf("default image provider %s is not enabled", conf.Default)
+		}
+		p.def = conf.Default
+	}
+	return p, nil
+}
+
+// Default 只启用百炼
+func Default(bailianClient *bailian.Client) *Providers {
+	return &Providers{
+		def:       ProviderBailian,
+		providers: map[string]Provider{ProviderBailian: NewBailian(bailianClient)},
+		upscaler:  &BailianUpscaler{client: bailianClient},
+		upscale:   DefaultUpscale,
+	}
+}
+
+// Has 服务是否已启用
+func (p *Providers) Has(name string) bool {
+	_, ok := p.providers[name]
+	return ok
+}
+
+// Names 已启用的服务名称
+func (p *Providers) Names() []string {
+	names := make([]string, 0, len(p.providers))
+	for name := range p.providers {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+// Get 返回名称对应的服务，名称为空或服务未启用时返回默认服务
+func (p *Providers) Get(name string) Provider {
+	return p.providers[p.Resolve(name)]
+}
+
+// Resolve 返回 Get 实际使用的服务名称
+func (p *Providers) Resolve(name string) string {
+	if p.Has(name) {
+		return name
+	}
+	return p.def
+}
+
+// Bailian 百炼图片生成，支持角色参考立绘
+type Bailian struct {
+	client *bailian.Client
+}
+
+func NewBailian(client *bailian.Client) *Bailian {
+	return &Bailian{client: client}
+}
+
+// bailianSizes qwen-image 各画面比例推荐的分辨率
+var bailianSizes = map[string]string{
+	AspectRatioLandscape: "1664*928",
+	AspectRatioPortrait:  "928*1664",
+	AspectRatioSquare:    "1328*1328",
+}
+
+func (b *Bailian) Generate(ctx context.Context, prompt string, opts Options) (string, error) {
+	size := opts.Size
+	if size == "" {
+		size = bailianSizes[opts.AspectRatio]
+	}
+	return b.client.GenerateImage(ctx, prompt, opts.Summary, opts.Roles, bailian.GenerateOptions{
+		Style:           opts.Style,
+		ImageSize:       size,
+		ImageModel:      opts.Model,
+		RoleConsistency: opts.RoleConsistency,
+		Prompt:          opts.Prompt,
+		NegativePrompt:  opts.NegativePrompt,
+	})
+}
+
+func (b *Bailian) Preview(prompt string, opts Options) Request {
+	full, references := bailian.ReferenceImagePrompt(prompt, opts.Summary, opts.Roles, bailian.GenerateOptions{Style: opts.Style, RoleConsistency: opts.RoleConsistency, Prompt: opts.Prompt})
+	return Request{Prompt: full, NegativePrompt: opts.NegativePrompt, References: references}
+}
+
+// fullPrompt 不支持参考图的服务使用的完整提示词
+func fullPrompt(prompt string, opts Options) string {
+	return bailian.ImagePrompt(prompt, opts.Summary, opts.Roles, bailian.GenerateOptions{Style: opts.Style, RoleConsistency: opts.RoleConsistency, Prompt: opts.Prompt})
+}
+
+// joinPrompts 合并非空的提示词
+func joinPrompts(prompts ...string) string {
+	var parts []string
+	for _, prompt := range prompts {
+		if prompt != "" {
+			parts = append(parts, prompt)
+		}
+	}
+	return strings.Join(parts, ", ")
+}
+
+// aspectSize 保持长边不变，按画面比例计算宽高，宽高取 8 的倍数
+func aspectSize(width, height int, ratio string) (int, int, bool) {
+	long := max(width, height)
+	short := long * 9 / 16 / 8 * 8
+	switch ratio {
+	case AspectRatioLandscape:
+		return long, short, true
+	case AspectRatioPortrait:
+		return short, long, true
+	case AspectRatioSquare:
+		return long, long, true
+	}
+	return 0, 0, false
+}
+
+// parseSize 解析 宽*高 格式的分辨率
+func parseSize(size string) (int, int, bool) {
+	w, h, ok := strings.Cut(size, "*")
+	if !ok {
+		return 0, 0, false
+	}
+	width, err1 := strconv.Atoi(w)
+	height, err2 := strconv.Atoi(h)
+	if err1 != nil || err2 != nil || width <= 0 || height <= 0 {
+		return 0, 0, false
+	}
+	return width, height, true
+}
+
+// postJSON 发送 JSON 请求并解析 JSON 响应，apiKey 为空时不设置认证头
+func postJSON(ctx context.Context, client *http.Client, url, apiKey string, req, resp any) error {
+	reqBody, err := json.Marshal(req)
+	if err != nil {
+		return fmt.Errorf("marshal request failed: %w", err)
+	}
+	httpReq, err := http.NewRequestWithContext(ctx, http.MethodPost, url, bytes.NewReader(reqBody))
+	if err != nil {
+		return fmt.Errorf("create request failed: %w", err)
+	}
+	if apiKey != "" {
+		httpReq.Header.Set("Authorization", "Bearer "+apiKey)
+	}
+	httpReq.Header.Set("Content-Type", "application/json")
+
+	httpResp, err := client.Do(httpReq)
+	if err != nil {
+		return fmt.Errorf("send request failed: %w", err)
+	}
+	defer httpResp.Body.Close()
+	respBody, err := io.ReadAll(httpResp.Body)
+	if err != nil {
+		return fmt.Errorf("read response failed: %w", err)
+	}
+	if httpResp.StatusCode != http.StatusOK {
+		return fmt.Errorf("generate image failed, status: %d, body: %s", httpResp.StatusCode, string(respBody))
+	}
+	if err = json.Unmarshal(respBody, resp); err != nil {
+		return fmt.Errorf("parse response failed: %w", err)
+	}
+	return nil
+}
@@ -0,0 +1,186 @@
+package imagegen
+
+import (
+	"context"
+	"encoding/base64"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"imgagent/bailian"
+	"imgagent/pkg/mediastore"
+)
+
+func TestProviders(t *testing.T) {
+	store := mediastore.New(t.TempDir(), "/v1/images")
+
+	p, err := New(Config{}, nil, store)
+	require.NoError(t, err)
+	assert.Equal(t, []string{ProviderBailian}, p.Names())
+	assert.IsType(t, &Bailian{}, p.Get(""))
+	// 未启用的服务使用默认服务
+	assert.IsType(t, &Bailian{}, p.Get(ProviderOpenAI))
+
+	_, err = New(Config{Default: ProviderOpenAI}, nil, store)
+	require.Error(t, err)
+
+	p, err = New(Config{
+		Default:         ProviderStableDiffusion,
+		OpenAI:          OpenAIConfig{APIKey: "test"},
+		StableDiffusion: StableDiffusionConfig{BaseURL: "http://127.0.0.1:7860"},
+	}, nil, store)
+	require.NoError(t, err)
+	assert.Equal(t, []string{ProviderBailian, ProviderOpenAI, ProviderStableDiffusion}, p.Names())
+	assert.IsType(t, &StableDiffusion{}, p.Get(""))
+	assert.IsType(t, &OpenAI{}, p.Get(ProviderOpenAI))
+}
+
+func TestOpenAI(t *testing.T) {
+	png := []byte("\x89PNG openai")
+	var got openAIImageRequest
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		assert.Equal(t, "/v1/images/generations", r.URL.Path)
+		assert.Equal(t, "Bearer test", r.Header.Get("Authorization"))
+		require.NoError(t, json.NewDecoder(r.Body).Decode(&got))
+		if got.Model == "dall-e-3" {
+			w.Write([]byte(`{"data":[{"url":"http://img/1"}]}`))
+			return
+		}
+		json.NewEncoder(w).Encode(map[string]any{"data": []map[string]string{{"b64_json": base64.StdEncoding.EncodeToString(png)}}})
+	}))
+	defer server.Close()
+
+	store := mediastore.New(t.TempDir(), "/v1/images")
+	o := NewOpenAI(OpenAIConfig{BaseURL: server.URL, APIKey: "test"}, store)
+	ctx := context.Background()
+	roles := []bailian.RoleInfo{{Name: "张三", Appearance: "短发", PortraitURL: "http://portrait/1"}, {Name: "李四", Appearance: "长发"}}
+
+	url, err := o.Generate(ctx, "张三走进房间", Options{Summary: "摘要", Roles: roles, Style: "水彩", Size: "1328*1328", RoleConsistency: true})
+	require.NoError(t, err)
+	assert.Equal(t, defaultOpenAIModel, got.Model)
+	assert.Equal(t, "1328x1328", got.Size)
+	assert.Contains(t, got.Prompt, "张三走进房间")
+	assert.Contains(t, got.Prompt, "画面风格：水彩")
+	// 只包含场景中出现的角色，不支持参考图
+	assert.Contains(t, got.Prompt, "短发")
+	assert.NotContains(t, got.Prompt, "李四")
+	assert.NotContains(t, got.Prompt, "参考图")
+
+	file, err := store.Path(path.Base(url))
+	require.NoError(t, err)
+	data, err := os.ReadFile(file)
+	require.NoError(t, err)
+	assert.Equal(t, png, data)
+
+	url, err = o.Generate(ctx, "场景", Options{Model: "dall-e-3"})
+	require.NoError(t, err)
+	assert.Equal(t, "http://img/1", url)
+	assert.Equal(t, defaultOpenAISize, got.Size)
+	assert.NotContains(t, got.Prompt, "避免")
+
+	// 不支持反向提示词，追加到提示词中
+	_, err = o.Generate(ctx, "场景", Options{Model: "dall-e-3", NegativePrompt: "文字，水印"})
+	require.NoError(t, err)
+	assert.Contains(t, got.Prompt, "画面中避免出现：文字，水印")
+
+	// 画面比例映射到支持的分辨率，指定分辨率时优先
+	_, err = o.Generate(ctx, "场景", Options{AspectRatio: AspectRatioPortrait})
+	require.NoError(t, err)
+	assert.Equal(t, "1024x1536", got.Size)
+	_, err = o.Generate(ctx, "场景", Options{AspectRatio: AspectRatioPortrait, Size: "1328*1328"})
+	require.NoError(t, err)
+	assert.Equal(t, "1328x1328", got.Size)
+}
+
+func TestStableDiffusion(t *testing.T) {
+	png := []byte("\x89PNG sd")
+	var got sdRequest
+	status := http.StatusOK
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		assert.Equal(t, "/sdapi/v1/txt2img", r.URL.Path)
+		assert.Empty(t, r.Header.Get("Authorization"))
+		got = sdRequest{}
+		require.NoError(t, json.NewDecoder(r.Body).Decode(&got))
+		w.WriteHeader(status)
+		json.NewEncoder(w).Encode(sdResponse{Images: []string{base64.StdEncoding.EncodeToString(png)}})
+	}))
+	defer server.Close()
+
+	store := mediastore.New(t.TempDir(), "/v1/images")
+	s := NewStableDiffusion(StableDiffusionConfig{BaseURL: server.URL + "/", NegativePrompt: "lowres"}, store)
+	ctx := context.Background()
+
+	url, err := s.Generate(ctx, "场景", Options{Size: "1664*928", Model: "anime.safetensors"})
+	require.NoError(t, err)
+	assert.Regexp(t, `^/v1/images/[0-9a-f]{64}\.png$`, url)
+	assert.Equal(t, 1664, got.Width)
+	assert.Equal(t, 928, got.Height)
+	assert.Equal(t, 30, got.Steps)
+	assert.Equal(t, "lowres", got.NegativePrompt)
+	assert.Equal(t, map[string]any{"sd_model_checkpoint": "anime.safetensors"}, got.OverrideSettings)
+
+	_, err = s.Generate(ctx, "场景", Options{NegativePrompt: "text"})
+	require.NoError(t, err)
+	assert.Equal(t, 1024, got.Width)
+	assert.Nil(t, got.OverrideSettings)
+	assert.Equal(t, "lowres, text", got.NegativePrompt)
+
+	_, err = s.Generate(ctx, "场景", Options{AspectRatio: AspectRatioPortrait})
+	require.NoError(t, err)
+	assert.Equal(t, 576, got.Width)
+	assert.Equal(t, 1024, got.Height)
+	_, err = s.Generate(ctx, "场景", Options{AspectRatio: AspectRatioLandscape})
+	require.NoError(t, err)
+	assert.Equal(t, 1024, got.Width)
+	assert.Equal(t, 576, got.Height)
+
+	status = http.StatusInternalServerError
+	_, err = s.Generate(ctx, "场景", Options{})
+	require.Error(t, err)
+}
+
+func TestUpscale(t *testing.T) {
+	hd := []byte("\x89PNG hd")
+	var got sdUpscaleRequest
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		assert.Equal(t, "/sdapi/v1/extra-single-image", r.URL.Path)
+		require.NoError(t, json.NewDecoder(r.Body).Decode(&got))
+		json.NewEncoder(w).Encode(sdUpscaleResponse{Image: base64.StdEncoding.EncodeToString(hd)})
+	}))
+	defer server.Close()
+
+	store := mediastore.New(t.TempDir(), "/v1/images")
+	_, err := New(Config{Upscale: UpscaleConfig{Provider: ProviderStableDiffusion}}, nil, store)
+	require.Error(t, err)
+	_, err = New(Config{Upscale: UpscaleConfig{Provider: ProviderOpenAI}, OpenAI: OpenAIConfig{APIKey: "test"}}, nil, store)
+	require.Error(t, err)
+	_, err = New(Config{Upscale: UpscaleConfig{Scale: 8}}, nil, store)
+	require.Error(t, err)
+
+	p, err := New(Config{
+		StableDiffusion: StableDiffusionConfig{BaseURL: server.URL},
+		Upscale:         UpscaleConfig{Provider: ProviderStableDiffusion, Scale: 3},
+	}, nil, store)
+	require.NoError(t, err)
+	ctx := context.Background()
+	url, err := p.Upscale(ctx, []byte("\x89PNG scene"), 0)
+	require.NoError(t, err)
+	assert.Equal(t, 3, got.UpscalingResize)
+	assert.Equal(t, defaultSDUpscaler, got.Upscaler1)
+	assert.Equal(t, base64.StdEncoding.EncodeToString([]byte("\x89PNG scene")), got.Image)
+
+	file, err := store.Path(path.Base(url))
+	require.NoError(t, err)
+	data, err := os.ReadFile(file)
+	require.NoError(t, err)
+	assert.Equal(t, hd, data)
+
+	_, err = p.Upscale(ctx, []byte("\x89PNG scene"), 5)
+	require.Error(t, err)
+}
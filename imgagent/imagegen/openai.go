@@ -0,0 +1,136 @@
+package imagegen
+
+import (
+	"context"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"net/http"
+	"strings"
+	"time"
+
+	"imgagent/pkg/faults"
+	"imgagent/pkg/logger"
+	"imgagent/pkg/mediastore"
+	"imgagent/pkg/tracing"
+)
+
+// 未配置时使用的模型和分辨率
+const (
+	defaultOpenAIModel = "gpt-image-1"
+	defaultOpenAISize  = "1024x1024"
+)
+
+// openAISizes gpt-image-1 各画面比例最接近的分辨率
+var openAISizes = map[string]string{
+	AspectRatioLandscape: "1536x1024",
+	AspectRatioPortrait:  "1024x1536",
+	AspectRatioSquare:    "1024x1024",
+}
+
+// OpenAIConfig 兼容 OpenAI /v1/images/generations 接口的服务
+type OpenAIConfig struct {
+	BaseURL        string `json:"base_url"`        // 默认 https://api.openai.com
+	APIKey         string `json:"api_key"`         // API 密钥
+	Model          string `json:"model"`           // 默认 gpt-image-1
+	Size           string `json:"size"`            // 默认 1024x1024，场景指定分辨率时使用场景的值
+	RequestTimeout int    `json:"request_timeout"` // 请求超时时间（秒），默认 300
+}
+
+type openAIImageRequest struct {
+	Model  string `json:"model"`
+	Prompt string `json:"prompt"`
+	N      int    `json:"n"`
+	Size   string `json:"size"`
+}
+
+type openAIImageResponse struct {
+	Data []struct {
+		URL     string `json:"url"`
+		B64JSON string `json:"b64_json"`
+	} `json:"data"`
+}
+
+// OpenAI 兼容 OpenAI 图片接口的服务，返回 base64 图片数据时保存到 store
+type OpenAI struct {
+	config     OpenAIConfig
+	store      *mediastore.Store
+	httpClient *http.Client
+}
+
+func NewOpenAI(config OpenAIConfig, store *mediastore.Store) *OpenAI {
+	if config.BaseURL == "" {
+		config.BaseURL = "https://api.openai.com"
+	}
+	config.BaseURL = strings.TrimSuffix(config.BaseURL, "/")
+	if config.Model == "" {
+		config.Model = defaultOpenAIModel
+	}
+	if config.Size == "" {
+		config.Size = defaultOpenAISize
+	}
+	if config.RequestTimeout == 0 {
+		config.RequestTimeout = 300
+	}
+	return &OpenAI{
+		config: config,
+		store:  store,
+		httpClient: &http.Client{
+			Timeout:   time.Duration(config.RequestTimeout) * time.Second,
+			Transport: tracing.NewTransport(logger.NewTransport(faults.NewTransport(http.DefaultTransport, faults.TargetImageGen))),
+		},
+	}
+}
+
+// Preview OpenAI 不支持反向提示词，追加到提示词中
+func (o *OpenAI) Preview(prompt string, opts Options) Request {
+	prompt = fullPrompt(prompt, opts)
+	if opts.NegativePrompt != "" {
+		prompt += fmt.Sprintf("画面中避免出现：%s\n", opts.NegativePrompt)
+	}
+	return Request{Prompt: prompt}
+}
+
+func (o *OpenAI) Generate(ctx context.Context, prompt string, opts Options) (string, error) {
+	log := logger.FromContext(ctx)
+
+	req := openAIImageRequest{
+		Model:  o.config.Model,
+		Prompt: o.Preview(prompt, opts).Prompt,
+		N:      1,
+		Size:   o.config.Size,
+	}
+	if opts.Model != "" {
+		req.Model = opts.Model
+	}
+	if w, h, ok := parseSize(opts.Size); ok {
+		req.Size = fmt.Sprintf("%dx%d", w, h)
+	} else if size, ok := openAISizes[opts.AspectRatio]; ok {
+		req.Size = size
+	}
+	log.Infof("Generating OpenAI image, model: %s, size: %s, prompt: %s", req.Model, req.Size, req.Prompt)
+
+	var resp openAIImageResponse
+	err := postJSON(ctx, o.httpClient, o.config.BaseURL+"/v1/images/generations", o.config.APIKey, req, &resp)
+	if err != nil {
+		log.Errorf("Failed to generate OpenAI image, err: %v", err)
+		return "", err
+	}
+	if len(resp.Data) == 0 {
+		return "", errors.New("no image in response")
+	}
+	if resp.Data[0].URL != "" {
+		return resp.Data[0].URL, nil
+	}
+	data, err := base64.StdEncoding.DecodeString(resp.Data[0].B64JSON)
+	if err != nil || len(data) == 0 {
+		return "", fmt.Errorf("invalid image data: %v", err)
+	}
+	url, err := o.store.Save(data, "png")
+	if err != nil {
+		log.Errorf("Failed to save image, err: %v", err)
+		return "", fmt.Errorf("save image failed: %w", err)
+	}
+	log.Infof("Image generated successfully, URL: %s", url)
+	return url, nil
+}
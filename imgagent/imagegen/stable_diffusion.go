@@ -0,0 +1,128 @@
+package imagegen
+
+import (
+	"context"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"net/http"
+	"strings"
+	"time"
+
+	"imgagent/pkg/faults"
+	"imgagent/pkg/logger"
+	"imgagent/pkg/mediastore"
+	"imgagent/pkg/tracing"
+)
+
+// StableDiffusionConfig 自建的 Stable Diffusion WebUI 服务（/sdapi/v1/txt2img 接口）
+type StableDiffusionConfig struct {
+	BaseURL        string `json:"base_url"`        // 服务地址，如 http://127.0.0.1:7860
+	APIKey         string `json:"api_key"`         // 服务前有认证网关时填写，为空时不设置认证头
+	Model          string `json:"model"`           // checkpoint 名称，为空时使用服务当前加载的模型
+	NegativePrompt string `json:"negative_prompt"` // 反向提示词
+	Width          int    `json:"width"`           // 默认 1024，场景指定分辨率时使用场景的值
+	Height         int    `json:"height"`          // 默认 1024
+	Steps          int    `json:"steps"`           // 采样步数，默认 30
+	Upscaler       string `json:"upscaler"`        // 超分使用的放大算法，默认 R-ESRGAN 4x+
+	RequestTimeout int    `json:"request_timeout"` // 请求超时时间（秒），默认 300
+}
+
+type sdRequest struct {
+	Prompt           string         `json:"prompt"`
+	NegativePrompt   string         `json:"negative_prompt,omitempty"`
+	Width            int            `json:"width"`
+	Height           int            `json:"height"`
+	Steps            int            `json:"steps"`
+	OverrideSettings map[string]any `json:"override_settings,omitempty"`
+}
+
+type sdResponse struct {
+	Images []string `json:"images"`
+}
+
+// StableDiffusion 自建的 Stable Diffusion 服务，返回的图片保存到 store
+type StableDiffusion struct {
+	config     StableDiffusionConfig
+	store      *mediastore.Store
+	httpClient *http.Client
+}
+
+func NewStableDiffusion(config StableDiffusionConfig, store *mediastore.Store) *StableDiffusion {
+	config.BaseURL = strings.TrimSuffix(config.BaseURL, "/")
+	if config.Width <= 0 {
+		config.Width = 1024
+	}
+	if config.Height <= 0 {
+		config.Height = 1024
+	}
+	if config.Steps <= 0 {
+		config.Steps = 30
+	}
+	if config.Upscaler == "" {
+		config.Upscaler = defaultSDUpscaler
+	}
+	if config.RequestTimeout == 0 {
+		config.RequestTimeout = 300
+	}
+	return &StableDiffusion{
+		config: config,
+		store:  store,
+		httpClient: &http.Client{
+			Timeout:   time.Duration(config.RequestTimeout) * time.Second,
+			Transport: tracing.NewTransport(logger.NewTransport(faults.NewTransport(http.DefaultTransport, faults.TargetImageGen))),
+		},
+	}
+}
+
+// Preview 反向提示词为配置的默认值加上场景的反向提示词
+func (s *StableDiffusion) Preview(prompt string, opts Options) Request {
+	return Request{Prompt: fullPrompt(prompt, opts), NegativePrompt: joinPrompts(s.config.NegativePrompt, opts.NegativePrompt)}
+}
+
+func (s *StableDiffusion) Generate(ctx context.Context, prompt string, opts Options) (string, error) {
+	log := logger.FromContext(ctx)
+
+	preview := s.Preview(prompt, opts)
+	req := sdRequest{
+		Prompt:         preview.Prompt,
+		NegativePrompt: preview.NegativePrompt,
+		Width:          s.config.Width,
+		Height:         s.config.Height,
+		Steps:          s.config.Steps,
+	}
+	if w, h, ok := parseSize(opts.Size); ok {
+		req.Width, req.Height = w, h
+	} else if w, h, ok := aspectSize(s.config.Width, s.config.Height, opts.AspectRatio); ok {
+		req.Width, req.Height = w, h
+	}
+	model := s.config.Model
+	if opts.Model != "" {
+		model = opts.Model
+	}
+	if model != "" {
+		req.OverrideSettings = map[string]any{"sd_model_checkpoint": model}
+	}
+	log.Infof("Generating Stable Diffusion image, model: %s, size: %dx%d, prompt: %s", model, req.Width, req.Height, req.Prompt)
+
+	var resp sdResponse
+	err := postJSON(ctx, s.httpClient, s.config.BaseURL+"/sdapi/v1/txt2img", s.config.APIKey, req, &resp)
+	if err != nil {
+		log.Errorf("Failed to generate Stable Diffusion image, err: %v", err)
+		return "", err
+	}
+	if len(resp.Images) == 0 {
+		return "", errors.New("no image in response")
+	}
+	data, err := base64.StdEncoding.DecodeString(resp.Images[0])
+	if err != nil || len(data) == 0 {
+		return "", fmt.Errorf("invalid image data: %v", err)
+	}
+	url, err := s.store.Save(data, "png")
+	if err != nil {
+		log.Errorf("Failed to save image, err: %v", err)
+		return "", fmt.Errorf("save image failed: %w", err)
+	}
+	log.Infof("Image generated successfully, URL: %s", url)
+	return url, nil
+}
@@ -6,28 +6,48 @@ import (
 	"flag"
 	"io"
 	"log"
+	"net"
 	"net/http"
 	"os"
 	"os/signal"
+	"strings"
 	"syscall"
 	"time"
 
 	"go.uber.org/zap"
+	"google.golang.org/grpc"
 
+	"imgagent/api"
 	"imgagent/bailian"
+	"imgagent/pkg/confwatch"
+	"imgagent/pkg/faults"
 	"imgagent/pkg/logger"
+	"imgagent/pkg/tracing"
 	"imgagent/svr"
 )
 
 var (
 	confFile = flag.String("f", "imgagent.json", "image agent config filename")
+	reindex  = flag.Bool("reindex", false, "enqueue search reindex tasks for all documents and exit")
+
+	migrate      = flag.String("migrate", "", "run schema migrations and exit: up, down (rollback -migrate-steps migrations) or status")
+	migrateSteps = flag.Int("migrate-steps", 1, "migrations to rollback with -migrate down")
+
+	backfill      = flag.String("backfill", "", "comma separated stages to backfill over existing documents and exit, available: "+strings.Join(svr.BackfillStages(), ","))
+	backfillBatch = flag.Int("backfill-batch", 50, "documents per backfill batch, progress is saved after each batch")
+	backfillReset = flag.Bool("backfill-reset", false, "ignore saved backfill progress and start over")
 )
 
 type Config struct {
 	LogConf         logger.Config      `json:"log_conf"`
 	BindHost        string             `json:"bind_host"`
+	GRPCBindHost    string             `json:"grpc_bind_host"` // 为空时不启动 gRPC 服务
 	BailianConf     bailian.Config     `json:"bailian"`
 	DocumentMgrConf svr.DocumentConfig `json:"document_mgr"`
+	TracingConf     tracing.Config     `json:"tracing"`
+	FaultsConf      faults.Config      `json:"faults"`                // 故障注入，仅允许在 debug 日志级别下开启
+	ShutdownSecs    int                `json:"shutdown_timeout_secs"` // 退出时等待请求和文档任务结束的最长时间
+	ReloadSecs      int                `json:"reload_interval_secs"`  // 检查配置文件变化的间隔，变化时应用日志级别、限流、文档处理并发和百炼模型，为 0 时不检查
 
 	svr.Config
 }
@@ -60,22 +80,71 @@ func main() {
 	}
 	defer wc.Close()
 
+	if *migrate != "" {
+		err = svr.Migrate(logger.NewContext("migrate"), conf.Config, *migrate, *migrateSteps)
+		if err != nil {
+			log.Fatalf("Failed to migrate, err: %v", err)
+		}
+		zap.S().Info("Migrate done")
+		return
+	}
+
+	if *reindex {
+		err = svr.Reindex(logger.NewContext("reindex"), conf.Config)
+		if err != nil {
+			log.Fatalf("Failed to reindex, err: %v", err)
+		}
+		zap.S().Info("Reindex tasks enqueued")
+		return
+	}
+
+	shutdownTracing, err := tracing.Init(conf.TracingConf)
+	if err != nil {
+		log.Fatalf("Failed to init tracing, err: %v", err)
+	}
+	defer shutdownTracing(context.Background())
+
+	// 故障注入只用于测试环境，避免误带到生产配置
+	if conf.FaultsConf.Enable {
+		if conf.LogConf.Level != "debug" {
+			log.Fatalf("Fault injection requires debug log level")
+		}
+		zap.S().Warnf("Fault injection enabled, rules: %+v", conf.FaultsConf.Rules)
+	}
+	faults.Init(conf.FaultsConf)
+
 	// 创建百炼客户端
 	bailianClient, err := bailian.NewClient(conf.BailianConf)
 	if err != nil {
 		log.Fatalf("Failed to new bailian client, err: %v", err)
 	}
 
+	// 回填中断（Ctrl-C）时保存进度后退出，再次执行从中断处继续
+	if *backfill != "" {
+		ctx, stop := signal.NotifyContext(logger.NewContext("backfill"), os.Interrupt, syscall.SIGTERM)
+		defer stop()
+		err = svr.Backfill(ctx, conf.Config, bailianClient, svr.BackfillOptions{
+			Stages:    strings.Split(*backfill, ","),
+			BatchSize: *backfillBatch,
+			Reset:     *backfillReset,
+		})
+		if err != nil {
+			log.Fatalf("Failed to backfill, err: %v", err)
+		}
+		zap.S().Info("Backfill done")
+		return
+	}
+
 	// 将百炼配置和文档管理配置传递给 Service
 	conf.Config.BailianConfig = conf.BailianConf
 	conf.Config.DocumentConfig = conf.DocumentMgrConf
 
-	svr, err := svr.New(conf.Config, bailianClient)
+	service, err := svr.New(conf.Config, bailianClient)
 	if err != nil {
 		log.Fatalf("Failed to new server, err: %v", err)
 	}
 
-	router := svr.RegisterRouter(wc)
+	router := service.RegisterRouter(wc)
 	server := &http.Server{
 		Addr:              conf.BindHost,
 		Handler:           router,
@@ -89,10 +158,57 @@ func main() {
 		}
 	}()
 
-	SetupGracefulShutdown(server)
+	var grpcServer *grpc.Server
+	if conf.GRPCBindHost != "" {
+		lis, err := net.Listen("tcp", conf.GRPCBindHost)
+		if err != nil {
+			log.Fatalf("Failed to listen grpc, err: %v", err)
+		}
+		grpcServer = service.NewGRPCServer()
+		zap.S().Info("gRPC server is running at ", conf.GRPCBindHost)
+		go func() {
+			if err := grpcServer.Serve(lis); err != nil {
+				zap.S().Fatalf("gRPC server failed, err: %s", err)
+			}
+		}()
+	}
+
+	if conf.ReloadSecs > 0 {
+		go confwatch.Watch(context.Background(), confwatch.NewFile(*confFile), b, time.Duration(conf.ReloadSecs)*time.Second, func(data []byte) {
+			var newConf Config
+			if err := json.Unmarshal(data, &newConf); err != nil {
+				zap.S().Errorf("Failed to unmarshal reloaded config, err: %v", err)
+				return
+			}
+			service.UpdateRuntimeConfig(logger.NewContext("reload"), runtimeConfig(newConf))
+		})
+	}
+
+	if conf.ShutdownSecs == 0 {
+		conf.ShutdownSecs = 60
+	}
+	SetupGracefulShutdown(server, grpcServer, service, time.Duration(conf.ShutdownSecs)*time.Second)
+}
+
+// runtimeConfig 配置中可在运行时修改的部分
+func runtimeConfig(conf Config) api.RuntimeConfig {
+	return api.RuntimeConfig{
+		LogLevel:  conf.LogConf.Level,
+		RateLimit: api.RateLimits{MaxConcurrent: conf.Limit.MaxConcurrent, MaxQueued: conf.Limit.MaxQueued},
+		DocumentMgr: &api.DocumentConcurrency{
+			Workers:                conf.DocumentMgrConf.Workers,
+			MaxConcurrentImageJobs: conf.DocumentMgrConf.MaxConcurrentImageJobs,
+			MaxConcurrentTTSJobs:   conf.DocumentMgrConf.MaxConcurrentTTSJobs,
+		},
+		Bailian: &api.BailianModels{
+			ChatModel:  conf.BailianConf.ChatModel,
+			ImageModel: conf.BailianConf.ImageModel,
+			TTSModel:   conf.BailianConf.TTSModel,
+		},
+	}
 }
 
-func SetupGracefulShutdown(server *http.Server) {
+func SetupGracefulShutdown(server *http.Server, grpcServer *grpc.Server, service *svr.Service, timeout time.Duration) {
 	quit := make(chan os.Signal, 1)
 	signal.Notify(quit, syscall.SIGINT, syscall.SIGTERM)
 
@@ -100,13 +216,34 @@ func SetupGracefulShutdown(server *http.Server) {
 	<-quit
 	zap.S().Info("Shutting down server...")
 
-	// 设置5秒超时
-	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	ctx, cancel := context.WithTimeout(context.Background(), timeout)
 	defer cancel()
 
+	// 先停止接收新请求，再等待后台文档任务和排队任务结束
 	if err := server.Shutdown(ctx); err != nil {
 		zap.S().Fatalf("Server forced to shutdown, err: %v", err)
 	}
+	if grpcServer != nil {
+		stopGRPCServer(ctx, grpcServer)
+	}
+	if err := service.Shutdown(ctx); err != nil {
+		zap.S().Fatalf("Service forced to shutdown, err: %v", err)
+	}
 
 	zap.S().Info("Server exited gracefully")
 }
+
+// stopGRPCServer 等待进行中的 RPC 结束，超时后强制关闭连接
+func stopGRPCServer(ctx context.Context, server *grpc.Server) {
+	done := make(chan struct{})
+	go func() {
+		server.GracefulStop()
+		close(done)
+	}()
+	select {
+	case <-done:
+	case <-ctx.Done():
+		zap.S().Warn("gRPC server forced to stop")
+		server.Stop()
+	}
+}
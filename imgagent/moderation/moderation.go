@@ -0,0 +1,77 @@
+// Package moderation 场景内容审核。Provider 屏蔽不同审核服务的差异，场景描述在生成前、生成的图片在发布前审核，
+// 未通过的场景标记为 blocked，由管理员复核。未配置审核服务时不审核。
+package moderation
+
+import (
+	"context"
+	"fmt"
+	"strings"
+)
+
+// 可选的审核服务
+const (
+	ProviderKeyword = "keyword"
+	ProviderOpenAI  = "openai"
+)
+
+// Result 审核结果，Flagged 为 true 时 Reason 为未通过的原因
+type Result struct {
+	Flagged bool
+	Reason  string
+}
+
+// Provider 内容审核服务。image 为图片 URL 或 data:image/png;base64 格式的数据
+type Provider interface {
+	ModerateText(ctx context.Context, text string) (Result, error)
+	ModerateImage(ctx context.Context, image string) (Result, error)
+}
+
+// Config 内容审核配置
+type Config struct {
+	Provider string       `json:"provider"` // keyword|openai，为空时不审核
+	Keywords []string     `json:"keywords"` // keyword 服务的敏感词，忽略大小写，只审核文字
+	OpenAI   OpenAIConfig `json:"openai"`
+}
+
+// New 按配置创建审核服务，未配置时返回 nil
+func New(conf Config) (Provider, error) {
+	switch conf.Provider {
+	case "":
+		return nil, nil
+	case ProviderKeyword:
+		return NewKeyword(conf.Keywords), nil
+	case ProviderOpenAI:
+		return NewOpenAI(conf.OpenAI)
+	default:
+		return nil, fmt.Errorf("unknown moderation provider: %s", conf.Provider)
+	}
+}
+
+// Keyword 按敏感词审核文字，不审核图片
+type Keyword struct {
+	keywords []string
+}
+
+func NewKeyword(keywords []string) *Keyword {
+	k := &Keyword{}
+	for _, keyword := range keywords {
+		if keyword = strings.ToLower(strings.TrimSpace(keyword)); keyword != "" {
+			k.keywords = append(k.keywords, keyword)
+		}
+	}
+	return k
+}
+
+func (k *Keyword) ModerateText(ctx context.Context, text string) (Result, error) {
+	text = strings.ToLower(text)
+	for _, keyword := range k.keywords {
+		if strings.Contains(text, keyword) {
+			return Result{Flagged: true, Reason: "contains keyword: " + keyword}, nil
+		}
+	}
+	return Result{}, nil
+}
+
+func (k *Keyword) ModerateImage(ctx context.Context, image string) (Result, error) {
+	return Result{}, nil
+}
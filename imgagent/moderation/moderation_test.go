@@ -0,0 +1,76 @@
+package moderation
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestNew(t *testing.T) {
+	p, err := New(Config{})
+	require.NoError(t, err)
+	assert.Nil(t, p)
+
+	p, err = New(Config{Provider: ProviderKeyword})
+	require.NoError(t, err)
+	assert.IsType(t, &Keyword{}, p)
+
+	_, err = New(Config{Provider: ProviderOpenAI})
+	require.Error(t, err)
+	_, err = New(Config{Provider: "unknown"})
+	require.Error(t, err)
+}
+
+func TestKeyword(t *testing.T) {
+	ctx := context.Background()
+	k := NewKeyword([]string{" Blood ", "", "血腥"})
+
+	ret, err := k.ModerateText(ctx, "满地 BLOOD")
+	require.NoError(t, err)
+	assert.True(t, ret.Flagged)
+	assert.Equal(t, "contains keyword: blood", ret.Reason)
+
+	ret, err = k.ModerateText(ctx, "春天的花园")
+	require.NoError(t, err)
+	assert.False(t, ret.Flagged)
+
+	ret, err = k.ModerateImage(ctx, "http://img/1")
+	require.NoError(t, err)
+	assert.False(t, ret.Flagged)
+}
+
+func TestOpenAI(t *testing.T) {
+	var got openAIModerationRequest
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		assert.Equal(t, "/v1/moderations", r.URL.Path)
+		assert.Equal(t, "Bearer test", r.Header.Get("Authorization"))
+		require.NoError(t, json.NewDecoder(r.Body).Decode(&got))
+		if got.Input[0].Type == "text" {
+			w.Write([]byte(`{"results":[{"flagged":false,"categories":{"violence":false}}]}`))
+			return
+		}
+		w.Write([]byte(`{"results":[{"flagged":true,"categories":{"violence":true,"sexual":false,"self-harm":true}}]}`))
+	}))
+	defer server.Close()
+
+	o, err := NewOpenAI(OpenAIConfig{BaseURL: server.URL + "/", APIKey: "test"})
+	require.NoError(t, err)
+	ctx := context.Background()
+
+	ret, err := o.ModerateText(ctx, "场景")
+	require.NoError(t, err)
+	assert.False(t, ret.Flagged)
+	assert.Equal(t, defaultOpenAIModel, got.Model)
+	assert.Equal(t, "场景", got.Input[0].Text)
+
+	ret, err = o.ModerateImage(ctx, "data:image/png;base64,AAAA")
+	require.NoError(t, err)
+	assert.True(t, ret.Flagged)
+	assert.Equal(t, "flagged: self-harm,violence", ret.Reason)
+	assert.Equal(t, "data:image/png;base64,AAAA", got.Input[0].ImageURL.URL)
+}
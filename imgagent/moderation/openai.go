@@ -0,0 +1,140 @@
+package moderation
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"sort"
+	"strings"
+	"time"
+
+	"imgagent/pkg/faults"
+	"imgagent/pkg/logger"
+	"imgagent/pkg/tracing"
+)
+
+const defaultOpenAIModel = "omni-moderation-latest"
+
+// OpenAIConfig 兼容 OpenAI /v1/moderations 接口的审核服务
+type OpenAIConfig struct {
+	BaseURL        string `json:"base_url"`        // 默认 https://api.openai.com
+	APIKey         string `json:"api_key"`         // API 密钥
+	Model          string `json:"model"`           // 默认 omni-moderation-latest，支持文字和图片
+	RequestTimeout int    `json:"request_timeout"` // 请求超时时间（秒），默认 60
+}
+
+type openAIModerationRequest struct {
+	Model string                  `json:"model"`
+	Input []openAIModerationInput `json:"input"`
+}
+
+type openAIModerationInput struct {
+	Type     string               `json:"type"`
+	Text     string               `json:"text,omitempty"`
+	ImageURL *openAIModerationURL `json:"image_url,omitempty"`
+}
+
+type openAIModerationURL struct {
+	URL string `json:"url"`
+}
+
+type openAIModerationResponse struct {
+	Results []struct {
+		Flagged    bool            `json:"flagged"`
+		Categories map[string]bool `json:"categories"`
+	} `json:"results"`
+}
+
+// OpenAI 兼容 OpenAI 审核接口的服务
+type OpenAI struct {
+	config     OpenAIConfig
+	httpClient *http.Client
+}
+
+func NewOpenAI(config OpenAIConfig) (*OpenAI, error) {
+	if config.APIKey == "" {
+		return nil, errors.New("openai moderation api key is required")
+	}
+	if config.BaseURL == "" {
+		config.BaseURL = "https://api.openai.com"
+	}
+	config.BaseURL = strings.TrimSuffix(config.BaseURL, "/")
+	if config.Model == "" {
+		config.Model = defaultOpenAIModel
+	}
+	if config.RequestTimeout == 0 {
+		config.RequestTimeout = 60
+	}
+	return &OpenAI{
+		config: config,
+		httpClient: &http.Client{
+			Timeout:   time.Duration(config.RequestTimeout) * time.Second,
+			Transport: tracing.NewTransport(logger.NewTransport(faults.NewTransport(http.DefaultTransport, faults.TargetModeration))),
+		},
+	}, nil
+}
+
+func (o *OpenAI) ModerateText(ctx context.Context, text string) (Result, error) {
+	return o.moderate(ctx, openAIModerationInput{Type: "text", Text: text})
+}
+
+func (o *OpenAI) ModerateImage(ctx context.Context, image string) (Result, error) {
+	return o.moderate(ctx, openAIModerationInput{Type: "image_url", ImageURL: &openAIModerationURL{URL: image}})
+}
+
+// moderate 审核单个输入，未通过时原因为命中的类别
+func (o *OpenAI) moderate(ctx context.Context, input openAIModerationInput) (Result, error) {
+	log := logger.FromContext(ctx)
+
+	reqBody, err := json.Marshal(openAIModerationRequest{Model: o.config.Model, Input: []openAIModerationInput{input}})
+	if err != nil {
+		return Result{}, fmt.Errorf("marshal request failed: %w", err)
+	}
+	httpReq, err := http.NewRequestWithContext(ctx, http.MethodPost, o.config.BaseURL+"/v1/moderations", bytes.NewReader(reqBody))
+	if err != nil {
+		return Result{}, fmt.Errorf("create request failed: %w", err)
+	}
+	httpReq.Header.Set("Authorization", "Bearer "+o.config.APIKey)
+	httpReq.Header.Set("Content-Type", "application/json")
+
+	resp, err := o.httpClient.Do(httpReq)
+	if err != nil {
+		log.Errorf("Failed to send request, err: %v", err)
+		return Result{}, fmt.Errorf("send request failed: %w", err)
+	}
+	defer resp.Body.Close()
+
+	respBody, err := io.ReadAll(resp.Body)
+	if err != nil {
+		return Result{}, fmt.Errorf("read response failed: %w", err)
+	}
+	if resp.StatusCode != http.StatusOK {
+		log.Errorf("Moderation failed, status: %d, body: %s", resp.StatusCode, string(respBody))
+		return Result{}, fmt.Errorf("moderation failed, status: %d, body: %s", resp.StatusCode, string(respBody))
+	}
+	var modResp openAIModerationResponse
+	if err = json.Unmarshal(respBody, &modResp); err != nil {
+		return Result{}, fmt.Errorf("parse response failed: %w", err)
+	}
+	if len(modResp.Results) == 0 {
+		return Result{}, errors.New("no result in response")
+	}
+
+	result := modResp.Results[0]
+	if !result.Flagged {
+		return Result{}, nil
+	}
+	var categories []string
+	for category, hit := range result.Categories {
+		if hit {
+			categories = append(categories, category)
+		}
+	}
+	sort.Strings(categories)
+	log.Infof("Content flagged, type: %s, categories: %v", input.Type, categories)
+	return Result{Flagged: true, Reason: "flagged: " + strings.Join(categories, ",")}, nil
+}
@@ -0,0 +1,154 @@
+package cache
+
+import (
+	"context"
+	"errors"
+	"sync"
+	"time"
+
+	"github.com/redis/go-redis/v9"
+)
+
+// Config 读缓存配置，ExpireSecs 为 0 时不启用缓存
+type Config struct {
+	// Prefix redis key 前缀，默认 imgagent:cache
+	Prefix string `json:"prefix"`
+	// ExpireSecs 缓存的保留时间，写路径未能失效缓存时最多读到这么久之前的数据
+	ExpireSecs int `json:"expire_secs"`
+}
+
+func (c Config) withDefaults() Config {
+	if c.Prefix == "" {
+		c.Prefix = "imgagent:cache"
+	}
+	return c
+}
+
+// Cache 保存序列化后的读结果，数据变更后由写路径删除对应的 key
+type Cache interface {
+	// Get 读取 key，不存在或已过期时 ok 为 false
+	Get(ctx context.Context, key string) (val []byte, ok bool, err error)
+	Set(ctx context.Context, key string, val []byte) error
+	Delete(ctx context.Context, keys ...string) error
+	Close() error
+}
+
+// New 未配置 ExpireSecs 时返回 nil；client 为 nil 时使用进程内实现，只适用于单实例部署
+func New(conf Config, client *redis.Client) Cache {
+	if conf.ExpireSecs <= 0 {
+		return nil
+	}
+	if client == nil {
+		return NewMemory(conf)
+	}
+	return NewRedis(conf, client)
+}
+
+type redisCache struct {
+	client *redis.Client
+	prefix string
+	expire time.Duration
+}
+
+// NewRedis 使用共享的 redis 连接，连接由调用方关闭
+func NewRedis(conf Config, client *redis.Client) Cache {
+	conf = conf.withDefaults()
+	return &redisCache{
+		client: client,
+		prefix: conf.Prefix,
+		expire: time.Duration(conf.ExpireSecs) * time.Second,
+	}
+}
+
+func (c *redisCache) key(key string) string {
+	return c.prefix + ":" + key
+}
+
+func (c *redisCache) Get(ctx context.Context, key string) ([]byte, bool, error) {
+	val, err := c.client.Get(ctx, c.key(key)).Bytes()
+	if errors.Is(err, redis.Nil) {
+		return nil, false, nil
+	}
+	if err != nil {
+		return nil, false, err
+	}
+	return val, true, nil
+}
+
+func (c *redisCache) Set(ctx context.Context, key string, val []byte) error {
+	return c.client.Set(ctx, c.key(key), val, c.expire).Err()
+}
+
+func (c *redisCache) Delete(ctx context.Context, keys ...string) error {
+	if len(keys) == 0 {
+		return nil
+	}
+	full := make([]string, 0, len(keys))
+	for _, key := range keys {
+		full = append(full, c.key(key))
+	}
+	return c.client.Del(ctx, full...).Err()
+}
+
+func (c *redisCache) Close() error {
+	return nil
+}
+
+type memoryEntry struct {
+	val      []byte
+	expireAt time.Time
+}
+
+type memoryCache struct {
+	mu      sync.Mutex
+	expire  time.Duration
+	entries map[string]memoryEntry
+}
+
+func NewMemory(conf Config) Cache {
+	return &memoryCache{
+		expire:  time.Duration(conf.ExpireSecs) * time.Second,
+		entries: make(map[string]memoryEntry),
+	}
+}
+
+func (c *memoryCache) Get(ctx context.Context, key string) ([]byte, bool, error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	e, ok := c.entries[key]
+	if !ok {
+		return nil, false, nil
+	}
+	if time.Now().After(e.expireAt) {
+		delete(c.entries, key)
+		return nil, false, nil
+	}
+	return e.val, true, nil
+}
+
+func (c *memoryCache) Set(ctx context.Context, key string, val []byte) error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	now := time.Now()
+	// 写入时顺带清理过期的 key
+	for k, e := range c.entries {
+		if now.After(e.expireAt) {
+			delete(c.entries, k)
+		}
+	}
+	c.entries[key] = memoryEntry{val: val, expireAt: now.Add(c.expire)}
+	return nil
+}
+
+func (c *memoryCache) Delete(ctx context.Context, keys ...string) error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	for _, key := range keys {
+		delete(c.entries, key)
+	}
+	return nil
+}
+
+func (c *memoryCache) Close() error {
+	return nil
+}
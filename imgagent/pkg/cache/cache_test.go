@@ -0,0 +1,42 @@
+package cache
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestMemory(t *testing.T) {
+	ctx := context.Background()
+	assert.Nil(t, New(Config{}, nil))
+
+	c := New(Config{ExpireSecs: 60}, nil)
+	defer c.Close()
+
+	_, ok, err := c.Get(ctx, "k1")
+	require.NoError(t, err)
+	assert.False(t, ok)
+
+	require.NoError(t, c.Set(ctx, "k1", []byte("v1")))
+	require.NoError(t, c.Set(ctx, "k2", []byte("v2")))
+	val, ok, err := c.Get(ctx, "k1")
+	require.NoError(t, err)
+	assert.True(t, ok)
+	assert.Equal(t, []byte("v1"), val)
+
+	// 删除后不再命中
+	require.NoError(t, c.Delete(ctx, "k1", "k2"))
+	_, ok, err = c.Get(ctx, "k2")
+	require.NoError(t, err)
+	assert.False(t, ok)
+
+	// 过期后不再命中
+	require.NoError(t, c.Set(ctx, "k1", []byte("v1")))
+	c.(*memoryCache).entries["k1"] = memoryEntry{val: []byte("v1"), expireAt: time.Now().Add(-time.Second)}
+	_, ok, err = c.Get(ctx, "k1")
+	require.NoError(t, err)
+	assert.False(t, ok)
+}
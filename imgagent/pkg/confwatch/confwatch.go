@@ -0,0 +1,56 @@
+// Package confwatch 定期从配置源加载配置，内容变化时回调，用于运行时修改配置。
+// 配置源只需返回完整的配置内容，文件以外的配置中心（如 etcd、consul）实现 Source 即可接入
+package confwatch
+
+import (
+	"bytes"
+	"context"
+	"os"
+	"time"
+
+	"go.uber.org/zap"
+)
+
+// Source 配置源
+type Source interface {
+	Load(ctx context.Context) ([]byte, error)
+}
+
+// File 本地配置文件
+type File struct {
+	path string
+}
+
+func NewFile(path string) *File {
+	return &File{path: path}
+}
+
+func (f *File) Load(ctx context.Context) ([]byte, error) {
+	return os.ReadFile(f.path)
+}
+
+// Watch 每隔 interval 加载一次配置，内容与上次不同时调用 fn，直到 ctx 结束。
+// initial 为启动时已加载的内容，作为比较的基准；加载失败时记录日志，下次继续。
+// 内容为空视为文件正在写入，同样下次继续
+func Watch(ctx context.Context, src Source, initial []byte, interval time.Duration, fn func(data []byte)) {
+	last := initial
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+		data, err := src.Load(ctx)
+		if err != nil {
+			zap.S().Errorf("Failed to load config, err: %v", err)
+			continue
+		}
+		if len(data) == 0 || bytes.Equal(data, last) {
+			continue
+		}
+		last = data
+		fn(data)
+	}
+}
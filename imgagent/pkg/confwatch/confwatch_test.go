@@ -0,0 +1,61 @@
+package confwatch
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestWatch(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "imgagent.json")
+	require.NoError(t, os.WriteFile(path, []byte(`{"v":1}`), 0644))
+
+	ctx, cancel := context.WithCancel(context.Background())
+	changes := make(chan string, 10)
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		Watch(ctx, NewFile(path), []byte(`{"v":1}`), 10*time.Millisecond, func(data []byte) {
+			changes <- string(data)
+		})
+	}()
+
+	// 内容未变化时不回调
+	time.Sleep(50 * time.Millisecond)
+	assert.Empty(t, changes)
+
+	require.NoError(t, os.WriteFile(path, []byte(`{"v":2}`), 0644))
+	select {
+	case data := <-changes:
+		assert.Equal(t, `{"v":2}`, data)
+	case <-time.After(time.Second):
+		t.Fatal("change not detected")
+	}
+
+	// 内容为空时视为正在写入，不回调，也不作为比较的基准
+	require.NoError(t, os.WriteFile(path, nil, 0644))
+	time.Sleep(50 * time.Millisecond)
+	assert.Empty(t, changes)
+	require.NoError(t, os.WriteFile(path, []byte(`{"v":2}`), 0644))
+	time.Sleep(50 * time.Millisecond)
+	assert.Empty(t, changes)
+
+	// 加载失败时保留上次的内容，恢复后相同的内容不再回调
+	require.NoError(t, os.Remove(path))
+	time.Sleep(50 * time.Millisecond)
+	require.NoError(t, os.WriteFile(path, []byte(`{"v":2}`), 0644))
+	time.Sleep(50 * time.Millisecond)
+	assert.Empty(t, changes)
+
+	cancel()
+	select {
+	case <-done:
+	case <-time.After(time.Second):
+		t.Fatal("watch not stopped")
+	}
+}
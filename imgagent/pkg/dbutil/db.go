@@ -19,6 +19,11 @@ type Config struct {
 	MaxIdleConns   int    `json:"max_idle_conns"`
 	MaxIdleTimeSec int    `json:"max_idle_time_sec"`
 	EnableLog      bool   `json:"enable_log"`
+	// Replicas 从库 DSN，如 user:password@tcp(host:3306)/imgagent?charset=utf8mb4&parseTime=True&loc=Local，
+	// 为空时全部读写走主库
+	Replicas []string `json:"replicas"`
+	// SkipMigrate 启动时不执行 schema 迁移，由 -migrate 命令单独执行
+	SkipMigrate bool `json:"skip_migrate"`
 }
 
 // NewDatabase 初始化数据库
@@ -49,6 +54,21 @@ func NewDatabase(conf Config) (*gorm.DB, error) {
 	if err != nil {
 		return nil, err
 	}
+	if err = db.Use(TracingPlugin{}); err != nil {
+		return nil, err
+	}
+	if err = db.Use(FaultsPlugin{}); err != nil {
+		return nil, err
+	}
+	if len(conf.Replicas) > 0 {
+		replicas := make([]gorm.Dialector, 0, len(conf.Replicas))
+		for _, dsn := range conf.Replicas {
+			replicas = append(replicas, mysql.Open(dsn))
+		}
+		if err = useReplicas(db, replicas, conf); err != nil {
+			return nil, err
+		}
+	}
 	sqlDB, err := db.DB()
 	if err != nil {
 		return nil, err
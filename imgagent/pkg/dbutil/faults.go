@@ -0,0 +1,40 @@
+package dbutil
+
+import (
+	"errors"
+
+	"gorm.io/gorm"
+
+	"imgagent/pkg/faults"
+)
+
+const faultsPluginName = "imgagent:faults"
+
+// FaultsPlugin 在 gorm 语句执行前注入故障，注入点为 db.<操作>，如 db.query
+type FaultsPlugin struct{}
+
+func (FaultsPlugin) Name() string {
+	return faultsPluginName
+}
+
+func (p FaultsPlugin) Initialize(db *gorm.DB) error {
+	cb := db.Callback()
+	name := func(op string) string { return faultsPluginName + ":" + op }
+	return errors.Join(
+		cb.Create().Before("gorm:create").Register(name("create"), p.inject("create")),
+		cb.Query().Before("gorm:query").Register(name("query"), p.inject("query")),
+		cb.Update().Before("gorm:update").Register(name("update"), p.inject("update")),
+		cb.Delete().Before("gorm:delete").Register(name("delete"), p.inject("delete")),
+		cb.Row().Before("gorm:row").Register(name("row"), p.inject("row")),
+		cb.Raw().Before("gorm:raw").Register(name("raw"), p.inject("raw")),
+	)
+}
+
+// inject 写入 db.Error 后 gorm 不再执行语句，错误按普通数据库错误返回给调用方
+func (FaultsPlugin) inject(op string) func(*gorm.DB) {
+	return func(db *gorm.DB) {
+		if err := faults.Inject(db.Statement.Context, faults.TargetDB+"."+op); err != nil {
+			db.AddError(err)
+		}
+	}
+}
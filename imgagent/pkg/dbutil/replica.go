@@ -0,0 +1,65 @@
+package dbutil
+
+import (
+	"context"
+	"errors"
+	"time"
+
+	"gorm.io/gorm"
+	"gorm.io/plugin/dbresolver"
+)
+
+const replicaPluginName = "imgagent:replica"
+
+type replicaKey struct{}
+
+// WithReplica 标记 ctx 中的查询可以读从库，只用于能容忍复制延迟的列表和统计查询
+func WithReplica(ctx context.Context) context.Context {
+	return context.WithValue(ctx, replicaKey{}, true)
+}
+
+// IsReplica ctx 是否由 WithReplica 标记，标记的查询结果可能落后于主库
+func IsReplica(ctx context.Context) bool {
+	allowed, _ := ctx.Value(replicaKey{}).(bool)
+	return allowed
+}
+
+// ReplicaPlugin 查询默认走主库，只有 WithReplica 标记的查询由 dbresolver 分发到从库，
+// 避免写入后立即读取时读到复制延迟前的旧数据。写入和事务内的查询始终走主库
+type ReplicaPlugin struct{}
+
+func (ReplicaPlugin) Name() string {
+	return replicaPluginName
+}
+
+func (p ReplicaPlugin) Initialize(db *gorm.DB) error {
+	cb := db.Callback()
+	name := func(op string) string { return replicaPluginName + ":" + op }
+	return errors.Join(
+		cb.Query().Before("gorm:query").Register(name("query"), p.primaryByDefault),
+		cb.Row().Before("gorm:row").Register(name("row"), p.primaryByDefault),
+		cb.Raw().Before("gorm:raw").Register(name("raw"), p.primaryByDefault),
+	)
+}
+
+// primaryByDefault 标记为写操作后 dbresolver 会立即切换到主库，与 dbresolver 回调的先后顺序无关
+func (ReplicaPlugin) primaryByDefault(db *gorm.DB) {
+	if !IsReplica(db.Statement.Context) {
+		dbresolver.Write.ModifyStatement(db.Statement)
+	}
+}
+
+// useReplicas 注册从库，多个从库随机选择
+func useReplicas(db *gorm.DB, replicas []gorm.Dialector, conf Config) error {
+	resolver := dbresolver.Register(dbresolver.Config{
+		Replicas: replicas,
+		Policy:   dbresolver.RandomPolicy{},
+	}).
+		SetMaxIdleConns(conf.MaxIdleConns).
+		SetConnMaxIdleTime(time.Duration(conf.MaxIdleTimeSec) * time.Second).
+		SetConnMaxLifetime(time.Hour)
+	if err := db.Use(resolver); err != nil {
+		return err
+	}
+	return db.Use(ReplicaPlugin{})
+}
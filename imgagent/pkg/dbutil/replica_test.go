@@ -0,0 +1,56 @@
+package dbutil
+
+import (
+	"context"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"gorm.io/driver/sqlite"
+	"gorm.io/gorm"
+)
+
+type replicaItem struct {
+	ID   int
+	Name string
+}
+
+func TestReplica(t *testing.T) {
+	ctx := context.Background()
+	dir := t.TempDir()
+	replica, err := gorm.Open(sqlite.Open(filepath.Join(dir, "replica.db")), &gorm.Config{})
+	require.NoError(t, err)
+	require.NoError(t, replica.AutoMigrate(&replicaItem{}))
+
+	db, err := gorm.Open(sqlite.Open(filepath.Join(dir, "primary.db")), &gorm.Config{})
+	require.NoError(t, err)
+	require.NoError(t, db.AutoMigrate(&replicaItem{}))
+	require.NoError(t, useReplicas(db, []gorm.Dialector{sqlite.Open(filepath.Join(dir, "replica.db"))}, Config{}))
+
+	// 写入走主库，从库模拟尚未同步
+	require.NoError(t, db.WithContext(ctx).Create(&replicaItem{ID: 1, Name: "a"}).Error)
+
+	count := func(ctx context.Context) int64 {
+		var n int64
+		require.NoError(t, db.WithContext(ctx).Model(&replicaItem{}).Count(&n).Error)
+		return n
+	}
+	// 默认读主库
+	assert.Equal(t, int64(1), count(ctx))
+	// 标记后读从库
+	assert.Equal(t, int64(0), count(WithReplica(ctx)))
+
+	var items []replicaItem
+	require.NoError(t, db.WithContext(WithReplica(ctx)).Find(&items).Error)
+	assert.Empty(t, items)
+	require.NoError(t, db.WithContext(ctx).Raw("SELECT * FROM replica_items").Scan(&items).Error)
+	assert.Len(t, items, 1)
+
+	// 事务内始终读主库
+	err = db.WithContext(WithReplica(ctx)).Transaction(func(tx *gorm.DB) error {
+		return tx.Find(&items).Error
+	})
+	require.NoError(t, err)
+	assert.Len(t, items, 1)
+}
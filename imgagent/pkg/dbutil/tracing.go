@@ -0,0 +1,74 @@
+package dbutil
+
+import (
+	"errors"
+
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/trace"
+	"gorm.io/gorm"
+
+	"imgagent/pkg/tracing"
+)
+
+const (
+	tracingPluginName = "imgagent:tracing"
+	tracingSpanKey    = "imgagent:tracing_span"
+)
+
+// TracingPlugin 为每条 gorm 语句创建 span，
+// 依赖调用方通过 WithContext 传入的 ctx 串联到请求链路上
+type TracingPlugin struct{}
+
+func (TracingPlugin) Name() string {
+	return tracingPluginName
+}
+
+func (p TracingPlugin) Initialize(db *gorm.DB) error {
+	cb := db.Callback()
+	before := func(op string) string { return tracingPluginName + ":before_" + op }
+	after := func(op string) string { return tracingPluginName + ":after_" + op }
+	return errors.Join(
+		cb.Create().Before("gorm:create").Register(before("create"), p.before("create")),
+		cb.Create().After("gorm:create").Register(after("create"), p.after),
+		cb.Query().Before("gorm:query").Register(before("query"), p.before("query")),
+		cb.Query().After("gorm:query").Register(after("query"), p.after),
+		cb.Update().Before("gorm:update").Register(before("update"), p.before("update")),
+		cb.Update().After("gorm:update").Register(after("update"), p.after),
+		cb.Delete().Before("gorm:delete").Register(before("delete"), p.before("delete")),
+		cb.Delete().After("gorm:delete").Register(after("delete"), p.after),
+		cb.Row().Before("gorm:row").Register(before("row"), p.before("row")),
+		cb.Row().After("gorm:row").Register(after("row"), p.after),
+		cb.Raw().Before("gorm:raw").Register(before("raw"), p.before("raw")),
+		cb.Raw().After("gorm:raw").Register(after("raw"), p.after),
+	)
+}
+
+func (TracingPlugin) before(op string) func(*gorm.DB) {
+	return func(db *gorm.DB) {
+		ctx, span := tracing.Tracer().Start(db.Statement.Context, "gorm."+op,
+			trace.WithSpanKind(trace.SpanKindClient),
+			trace.WithAttributes(attribute.String("db.operation.name", op)),
+		)
+		db.Statement.Context = ctx
+		db.InstanceSet(tracingSpanKey, span)
+	}
+}
+
+func (TracingPlugin) after(db *gorm.DB) {
+	v, ok := db.InstanceGet(tracingSpanKey)
+	if !ok {
+		return
+	}
+	span := v.(trace.Span)
+	defer span.End()
+
+	span.SetAttributes(
+		attribute.String("db.collection.name", db.Statement.Table),
+		attribute.String("db.query.text", db.Statement.SQL.String()),
+		attribute.Int64("db.rows_affected", db.RowsAffected),
+	)
+	// 未找到记录属于正常业务分支，不标记为错误
+	if db.Error != nil && !errors.Is(db.Error, gorm.ErrRecordNotFound) {
+		tracing.RecordError(span, db.Error)
+	}
+}
@@ -0,0 +1,136 @@
+package dlock
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"errors"
+	"sync"
+	"time"
+
+	"github.com/redis/go-redis/v9"
+)
+
+var (
+	// ErrLocked 锁已被其他持有者占用
+	ErrLocked = errors.New("lock held by another owner")
+	// ErrLockLost 锁已过期或被其他持有者占用，持有期间的独占不再成立
+	ErrLockLost = errors.New("lock lost")
+)
+
+// Config 分布式锁配置
+type Config struct {
+	// Prefix redis key 前缀，默认 imgagent:lock
+	Prefix string `json:"prefix"`
+	// TTLSecs 锁的有效期，持有者退出或崩溃后最多经过该时间锁自动释放，默认 60s
+	TTLSecs int `json:"ttl_secs"`
+}
+
+func (c Config) withDefaults() Config {
+	if c.Prefix == "" {
+		c.Prefix = "imgagent:lock"
+	}
+	if c.TTLSecs <= 0 {
+		c.TTLSecs = 60
+	}
+	return c
+}
+
+// Lock 获取到的锁，Token 标识持有者，续期和释放时校验
+type Lock struct {
+	Key   string
+	Token string
+}
+
+// Locker 带有效期的互斥锁。持有期间定期 Extend 续期，结束后 Unlock；
+// 未及时续期的锁过期后可被其他持有者获取
+type Locker interface {
+	// TryLock 获取 key 的锁，已被占用时返回 ErrLocked，不等待
+	TryLock(ctx context.Context, key string) (*Lock, error)
+	// Extend 续期，锁已失效时返回 ErrLockLost
+	Extend(ctx context.Context, lock *Lock) error
+	// Unlock 释放锁，锁已失效时返回 ErrLockLost
+	Unlock(ctx context.Context, lock *Lock) error
+	// TTL 锁的有效期，续期间隔应明显小于该值
+	TTL() time.Duration
+	Close() error
+}
+
+// New client 为 nil 时使用进程内实现，只适用于单实例部署
+func New(conf Config, client *redis.Client) Locker {
+	if client == nil {
+		return NewMemory(conf)
+	}
+	return NewRedis(conf, client)
+}
+
+func newToken() string {
+	b := make([]byte, 16)
+	rand.Read(b)
+	return hex.EncodeToString(b)
+}
+
+type memoryLock struct {
+	token    string
+	expireAt time.Time
+}
+
+type memoryLocker struct {
+	mu    sync.Mutex
+	ttl   time.Duration
+	locks map[string]memoryLock
+}
+
+func NewMemory(conf Config) Locker {
+	conf = conf.withDefaults()
+	return &memoryLocker{
+		ttl:   time.Duration(conf.TTLSecs) * time.Second,
+		locks: make(map[string]memoryLock),
+	}
+}
+
+func (l *memoryLocker) TryLock(ctx context.Context, key string) (*Lock, error) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	now := time.Now()
+	if held, ok := l.locks[key]; ok && now.Before(held.expireAt) {
+		return nil, ErrLocked
+	}
+	lock := &Lock{Key: key, Token: newToken()}
+	l.locks[key] = memoryLock{token: lock.Token, expireAt: now.Add(l.ttl)}
+	return lock, nil
+}
+
+// held 调用方需持有 l.mu
+func (l *memoryLocker) held(lock *Lock) bool {
+	held, ok := l.locks[lock.Key]
+	return ok && held.token == lock.Token && time.Now().Before(held.expireAt)
+}
+
+func (l *memoryLocker) Extend(ctx context.Context, lock *Lock) error {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	if !l.held(lock) {
+		return ErrLockLost
+	}
+	l.locks[lock.Key] = memoryLock{token: lock.Token, expireAt: time.Now().Add(l.ttl)}
+	return nil
+}
+
+func (l *memoryLocker) Unlock(ctx context.Context, lock *Lock) error {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	if !l.held(lock) {
+		return ErrLockLost
+	}
+	delete(l.locks, lock.Key)
+	return nil
+}
+
+func (l *memoryLocker) TTL() time.Duration {
+	return l.ttl
+}
+
+func (l *memoryLocker) Close() error {
+	return nil
+}
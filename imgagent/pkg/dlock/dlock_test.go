@@ -0,0 +1,41 @@
+package dlock
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestMemory(t *testing.T) {
+	ctx := context.Background()
+	l := New(Config{}, nil)
+	defer l.Close()
+	assert.Equal(t, 60*time.Second, l.TTL())
+
+	lock, err := l.TryLock(ctx, "doc1")
+	require.NoError(t, err)
+	_, err = l.TryLock(ctx, "doc1")
+	assert.ErrorIs(t, err, ErrLocked)
+	other, err := l.TryLock(ctx, "doc2")
+	require.NoError(t, err)
+	require.NoError(t, l.Unlock(ctx, other))
+
+	require.NoError(t, l.Extend(ctx, lock))
+	require.NoError(t, l.Unlock(ctx, lock))
+	assert.ErrorIs(t, l.Unlock(ctx, lock), ErrLockLost)
+	assert.ErrorIs(t, l.Extend(ctx, lock), ErrLockLost)
+
+	// 过期后可被其他持有者获取，原持有者不能续期或释放
+	lock, err = l.TryLock(ctx, "doc1")
+	require.NoError(t, err)
+	l.(*memoryLocker).locks["doc1"] = memoryLock{token: lock.Token, expireAt: time.Now().Add(-time.Second)}
+	next, err := l.TryLock(ctx, "doc1")
+	require.NoError(t, err)
+	assert.NotEqual(t, lock.Token, next.Token)
+	assert.ErrorIs(t, l.Extend(ctx, lock), ErrLockLost)
+	assert.ErrorIs(t, l.Unlock(ctx, lock), ErrLockLost)
+	require.NoError(t, l.Unlock(ctx, next))
+}
@@ -0,0 +1,86 @@
+package dlock
+
+import (
+	"context"
+	"time"
+
+	"github.com/redis/go-redis/v9"
+)
+
+// 锁的值为持有者的 token，续期和释放时先校验 token，避免误操作已被其他持有者获取的锁
+var (
+	extendScript = redis.NewScript(`
+if redis.call('GET', KEYS[1]) ~= ARGV[1] then
+	return 0
+end
+redis.call('PEXPIRE', KEYS[1], ARGV[2])
+return 1
+`)
+
+	unlockScript = redis.NewScript(`
+if redis.call('GET', KEYS[1]) ~= ARGV[1] then
+	return 0
+end
+redis.call('DEL', KEYS[1])
+return 1
+`)
+)
+
+type redisLocker struct {
+	client *redis.Client
+	prefix string
+	ttl    time.Duration
+}
+
+// NewRedis 使用共享的 redis 连接，连接由调用方关闭
+func NewRedis(conf Config, client *redis.Client) Locker {
+	conf = conf.withDefaults()
+	return &redisLocker{
+		client: client,
+		prefix: conf.Prefix,
+		ttl:    time.Duration(conf.TTLSecs) * time.Second,
+	}
+}
+
+func (l *redisLocker) key(key string) string {
+	return l.prefix + ":" + key
+}
+
+func (l *redisLocker) TryLock(ctx context.Context, key string) (*Lock, error) {
+	lock := &Lock{Key: key, Token: newToken()}
+	ok, err := l.client.SetNX(ctx, l.key(key), lock.Token, l.ttl).Result()
+	if err != nil {
+		return nil, err
+	}
+	if !ok {
+		return nil, ErrLocked
+	}
+	return lock, nil
+}
+
+func (l *redisLocker) Extend(ctx context.Context, lock *Lock) error {
+	return l.run(ctx, extendScript, lock, l.ttl.Milliseconds())
+}
+
+func (l *redisLocker) Unlock(ctx context.Context, lock *Lock) error {
+	return l.run(ctx, unlockScript, lock)
+}
+
+func (l *redisLocker) run(ctx context.Context, script *redis.Script, lock *Lock, args ...any) error {
+	n, err := script.Run(ctx, l.client, []string{l.key(lock.Key)}, append([]any{lock.Token}, args...)...).Int()
+	if err != nil {
+		return err
+	}
+	if n == 0 {
+		return ErrLockLost
+	}
+	return nil
+}
+
+func (l *redisLocker) TTL() time.Duration {
+	return l.ttl
+}
+
+func (l *redisLocker) Close() error {
+	return nil
+}
@@ -0,0 +1,131 @@
+package faults
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"math/rand/v2"
+	"net/http"
+	"strings"
+	"sync/atomic"
+	"time"
+
+	"imgagent/pkg/logger"
+)
+
+// 注入点名称，规则未配置时按 . 逐级回退，如 db.query 未配置时使用 db 的规则
+const (
+	TargetBailian    = "bailian"
+	TargetStorage    = "storage"
+	TargetDB         = "db"
+	TargetWebhook    = "webhook"
+	TargetTTS        = "tts"
+	TargetImageGen   = "imagegen"
+	TargetModeration = "moderation"
+	TargetEmbedding  = "embedding"
+)
+
+// ErrInjected 注入的故障，调用方按普通错误处理，可通过 errors.Is 区分
+var ErrInjected = errors.New("injected fault")
+
+// Rule 一个注入点的故障规则
+type Rule struct {
+	ErrorRate   float64 `json:"error_rate"`   // 返回错误的概率，取值 [0, 1]
+	LatencyRate float64 `json:"latency_rate"` // 注入延迟的概率，取值 [0, 1]，为 0 时每次都注入
+	LatencyMs   int     `json:"latency_ms"`   // 注入的固定延迟
+	JitterMs    int     `json:"jitter_ms"`    // 在固定延迟上叠加的随机延迟上限
+}
+
+// Config 故障注入配置，仅用于测试环境演练重试、熔断等容错逻辑
+type Config struct {
+	Enable bool            `json:"enable"`
+	Rules  map[string]Rule `json:"rules"` // key 为注入点，如 bailian、storage、db、db.query
+}
+
+var rules atomic.Pointer[map[string]Rule]
+
+// Init 设置全局故障规则，未开启时清空规则，各注入点直接返回
+func Init(conf Config) {
+	if !conf.Enable || len(conf.Rules) == 0 {
+		rules.Store(nil)
+		return
+	}
+	m := make(map[string]Rule, len(conf.Rules))
+	for k, v := range conf.Rules {
+		m[k] = v
+	}
+	rules.Store(&m)
+}
+
+// Enabled 是否有生效的故障规则
+func Enabled() bool {
+	return rules.Load() != nil
+}
+
+func lookup(target string) (Rule, bool) {
+	m := rules.Load()
+	if m == nil {
+		return Rule{}, false
+	}
+	for {
+		if r, ok := (*m)[target]; ok {
+			return r, true
+		}
+		i := strings.LastIndex(target, ".")
+		if i < 0 {
+			return Rule{}, false
+		}
+		target = target[:i]
+	}
+}
+
+// Inject 按 target 的规则注入延迟和错误，ctx 取消时提前结束延迟并返回 ctx 的错误
+func Inject(ctx context.Context, target string) error {
+	r, ok := lookup(target)
+	if !ok {
+		return nil
+	}
+	log := logger.FromContext(ctx)
+
+	if r.LatencyMs > 0 || r.JitterMs > 0 {
+		if r.LatencyRate <= 0 || rand.Float64() < r.LatencyRate {
+			d := time.Duration(r.LatencyMs) * time.Millisecond
+			if r.JitterMs > 0 {
+				d += time.Duration(rand.IntN(r.JitterMs)) * time.Millisecond
+			}
+			log.Warnf("Inject latency, target: %s, latency: %v", target, d)
+			timer := time.NewTimer(d)
+			select {
+			case <-timer.C:
+			case <-ctx.Done():
+				timer.Stop()
+				return ctx.Err()
+			}
+		}
+	}
+	if r.ErrorRate > 0 && rand.Float64() < r.ErrorRate {
+		log.Warnf("Inject error, target: %s", target)
+		return fmt.Errorf("%w: %s", ErrInjected, target)
+	}
+	return nil
+}
+
+// Transport 在出站 HTTP 请求前按 Target 的规则注入故障，注入的错误与网络错误一样由调用方重试
+type Transport struct {
+	Base   http.RoundTripper
+	Target string
+}
+
+func NewTransport(base http.RoundTripper, target string) *Transport {
+	if base == nil {
+		base = http.DefaultTransport
+	}
+	return &Transport{Base: base, Target: target}
+}
+
+func (t *Transport) RoundTrip(req *http.Request) (*http.Response, error) {
+	if err := Inject(req.Context(), t.Target); err != nil {
+		return nil, err
+	}
+	return t.Base.RoundTrip(req)
+}
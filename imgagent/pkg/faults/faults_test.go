@@ -0,0 +1,64 @@
+package faults
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestInject(t *testing.T) {
+	ctx := context.Background()
+	defer Init(Config{})
+
+	// 未开启时不注入
+	Init(Config{Rules: map[string]Rule{TargetDB: {ErrorRate: 1}}})
+	require.NoError(t, Inject(ctx, "db.query"))
+	assert.False(t, Enabled())
+
+	Init(Config{Enable: true, Rules: map[string]Rule{
+		TargetDB:      {ErrorRate: 1},
+		"db.query":    {},
+		TargetStorage: {LatencyMs: 50},
+	}})
+	assert.True(t, Enabled())
+	// 按 . 逐级回退到 db 的规则
+	assert.ErrorIs(t, Inject(ctx, "db.create"), ErrInjected)
+	assert.NoError(t, Inject(ctx, "db.query"))
+	assert.NoError(t, Inject(ctx, TargetBailian))
+
+	start := time.Now()
+	require.NoError(t, Inject(ctx, "storage.upload_token"))
+	assert.GreaterOrEqual(t, time.Since(start), 50*time.Millisecond)
+
+	// ctx 取消时提前结束延迟
+	cctx, cancel := context.WithCancel(ctx)
+	cancel()
+	assert.ErrorIs(t, Inject(cctx, TargetStorage), context.Canceled)
+}
+
+func TestTransport(t *testing.T) {
+	defer Init(Config{})
+
+	var calls int
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		calls++
+	}))
+	defer srv.Close()
+
+	client := &http.Client{Transport: NewTransport(nil, TargetBailian)}
+	Init(Config{Enable: true, Rules: map[string]Rule{TargetBailian: {ErrorRate: 1}}})
+	_, err := client.Get(srv.URL)
+	assert.ErrorIs(t, err, ErrInjected)
+	assert.Equal(t, 0, calls)
+
+	Init(Config{})
+	resp, err := client.Get(srv.URL)
+	require.NoError(t, err)
+	resp.Body.Close()
+	assert.Equal(t, 1, calls)
+}
@@ -0,0 +1,219 @@
+package httpclient
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"mime"
+	"net"
+	"net/http"
+	"net/url"
+	"slices"
+	"strings"
+	"syscall"
+	"time"
+)
+
+var (
+	// ErrForbiddenURL URL 的协议、域名或解析出的地址不允许访问
+	ErrForbiddenURL = errors.New("url not allowed")
+	// ErrTooLarge 响应体超过 MaxBytes
+	ErrTooLarge = errors.New("response body too large")
+	// ErrContentType 响应的 Content-Type 不在允许范围内
+	ErrContentType = errors.New("content type not allowed")
+)
+
+// Config 访问外部 URL（用户提供的文件地址、webhook 地址）的 HTTP 客户端配置，防止 SSRF 和超大响应
+type Config struct {
+	TimeoutSecs    int      `json:"timeout_secs"`    // 单次请求的总超时，默认 30s
+	MaxBytes       int64    `json:"max_bytes"`       // 下载的最大字节数，默认 50MB
+	MaxRedirects   int      `json:"max_redirects"`   // 最多跟随的重定向次数，默认 3，为负数时不跟随
+	AllowedSchemes []string `json:"allowed_schemes"` // 允许的协议，默认 http、https
+	AllowedHosts   []string `json:"allowed_hosts"`   // 允许的域名，支持 *.example.com，为空时不限制
+	DeniedHosts    []string `json:"denied_hosts"`    // 禁止的域名，优先于 AllowedHosts
+	AllowPrivate   bool     `json:"allow_private"`   // 是否允许访问回环、内网、链路本地等地址，默认禁止
+}
+
+// Rules 单次请求额外的访问限制，与 Config 同时生效，只能进一步收紧
+type Rules struct {
+	AllowedHosts []string // 允许的域名，为空时不限制
+	DeniedHosts  []string // 禁止的域名，优先于 AllowedHosts
+	BlockPrivate bool     // 即使 Config.AllowPrivate 也禁止访问内网地址
+}
+
+type rulesCtxKey struct{}
+
+// WithRules 为 ctx 上发出的请求（包括重定向）附加访问限制
+func WithRules(ctx context.Context, rules Rules) context.Context {
+	return context.WithValue(ctx, rulesCtxKey{}, rules)
+}
+
+func rulesFromContext(ctx context.Context) Rules {
+	rules, _ := ctx.Value(rulesCtxKey{}).(Rules)
+	return rules
+}
+
+// Client 按 Config 校验每次请求和重定向的目标地址。连接时校验解析出的 IP，避免域名解析到内网地址绕过检查
+type Client struct {
+	conf   Config
+	client *http.Client
+}
+
+// New 创建客户端，wrap 不为 nil 时用于包装底层 Transport，如增加链路追踪和故障注入
+func New(conf Config, wrap func(http.RoundTripper) http.RoundTripper) *Client {
+	if conf.TimeoutSecs <= 0 {
+		conf.TimeoutSecs = 30
+	}
+	if conf.MaxBytes <= 0 {
+		conf.MaxBytes = 50 << 20
+	}
+	if conf.MaxRedirects == 0 {
+		conf.MaxRedirects = 3
+	}
+	if len(conf.AllowedSchemes) == 0 {
+		conf.AllowedSchemes = []string{"http", "https"}
+	}
+
+	c := &Client{conf: conf}
+	dialer := &net.Dialer{
+		Timeout:        10 * time.Second,
+		KeepAlive:      30 * time.Second,
+		ControlContext: c.control,
+	}
+	transport := http.DefaultTransport.(*http.Transport).Clone()
+	// 经代理访问时无法校验目标地址
+	transport.Proxy = nil
+	transport.DialContext = dialer.DialContext
+	var rt http.RoundTripper = transport
+	if wrap != nil {
+		rt = wrap(rt)
+	}
+	c.client = &http.Client{
+		Timeout:       time.Duration(conf.TimeoutSecs) * time.Second,
+		Transport:     rt,
+		CheckRedirect: c.checkRedirect,
+	}
+	return c
+}
+
+// CheckURL 校验协议和域名，域名为 IP 时同时校验地址；域名解析出的地址在连接时校验。ctx 上的 Rules 同时生效
+func (c *Client) CheckURL(ctx context.Context, rawURL string) error {
+	u, err := url.Parse(rawURL)
+	if err != nil {
+		return fmt.Errorf("%w: %v", ErrForbiddenURL, err)
+	}
+	return c.checkURL(ctx, u)
+}
+
+func (c *Client) checkURL(ctx context.Context, u *url.URL) error {
+	if !slices.Contains(c.conf.AllowedSchemes, strings.ToLower(u.Scheme)) {
+		return fmt.Errorf("%w: scheme %q", ErrForbiddenURL, u.Scheme)
+	}
+	host := strings.ToLower(u.Hostname())
+	if host == "" {
+		return fmt.Errorf("%w: empty host", ErrForbiddenURL)
+	}
+	rules := rulesFromContext(ctx)
+	if !allowHost(host, c.conf.AllowedHosts, c.conf.DeniedHosts) || !allowHost(host, rules.AllowedHosts, rules.DeniedHosts) {
+		return fmt.Errorf("%w: host %q", ErrForbiddenURL, host)
+	}
+	if ip := net.ParseIP(host); ip != nil && !c.allowIP(ip, rules) {
+		return fmt.Errorf("%w: address %s", ErrForbiddenURL, ip)
+	}
+	return nil
+}
+
+// allowHost denied 中的域名禁止访问，allowed 不为空时只允许其中的域名
+func allowHost(host string, allowed, denied []string) bool {
+	match := func(pattern string) bool {
+		return matchHost(strings.ToLower(pattern), host)
+	}
+	if slices.ContainsFunc(denied, match) {
+		return false
+	}
+	return len(allowed) == 0 || slices.ContainsFunc(allowed, match)
+}
+
+func matchHost(pattern, host string) bool {
+	if suffix, ok := strings.CutPrefix(pattern, "*."); ok {
+		return strings.HasSuffix(host, "."+suffix)
+	}
+	return pattern == host
+}
+
+func (c *Client) allowIP(ip net.IP, rules Rules) bool {
+	if c.conf.AllowPrivate && !rules.BlockPrivate {
+		return true
+	}
+	return !(ip.IsLoopback() || ip.IsPrivate() || ip.IsUnspecified() || ip.IsLinkLocalUnicast() ||
+		ip.IsLinkLocalMulticast() || ip.IsInterfaceLocalMulticast() || ip.IsMulticast())
+}
+
+// control 在建立连接前校验实际连接的地址
+func (c *Client) control(ctx context.Context, network, address string, _ syscall.RawConn) error {
+	host, _, err := net.SplitHostPort(address)
+	if err != nil {
+		return err
+	}
+	ip := net.ParseIP(host)
+	if ip == nil || !c.allowIP(ip, rulesFromContext(ctx)) {
+		return fmt.Errorf("%w: address %s", ErrForbiddenURL, host)
+	}
+	return nil
+}
+
+func (c *Client) checkRedirect(req *http.Request, via []*http.Request) error {
+	if len(via) > c.conf.MaxRedirects {
+		return fmt.Errorf("stopped after %d redirects", len(via)-1)
+	}
+	return c.checkURL(req.Context(), req.URL)
+}
+
+// Do 校验目标地址后发送请求，调用方负责关闭响应体
+func (c *Client) Do(req *http.Request) (*http.Response, error) {
+	if err := c.checkURL(req.Context(), req.URL); err != nil {
+		return nil, err
+	}
+	return c.client.Do(req)
+}
+
+// MaxBytes 下载的最大字节数
+func (c *Client) MaxBytes() int64 {
+	return c.conf.MaxBytes
+}
+
+// Download 下载 rawURL 写入 w，返回写入的字节数。contentTypes 不为空时响应的 Content-Type 必须是其中之一
+func (c *Client) Download(ctx context.Context, rawURL string, w io.Writer, contentTypes ...string) (int64, error) {
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, rawURL, nil)
+	if err != nil {
+		return 0, err
+	}
+	resp, err := c.Do(req)
+	if err != nil {
+		return 0, err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		return 0, fmt.Errorf("unexpected status code %d", resp.StatusCode)
+	}
+	if len(contentTypes) > 0 {
+		mediaType, _, _ := mime.ParseMediaType(resp.Header.Get("Content-Type"))
+		if !slices.Contains(contentTypes, mediaType) {
+			return 0, fmt.Errorf("%w: %q", ErrContentType, resp.Header.Get("Content-Type"))
+		}
+	}
+	if resp.ContentLength > c.conf.MaxBytes {
+		return 0, ErrTooLarge
+	}
+
+	n, err := io.Copy(w, io.LimitReader(resp.Body, c.conf.MaxBytes+1))
+	if err != nil {
+		return n, err
+	}
+	if n > c.conf.MaxBytes {
+		return n, ErrTooLarge
+	}
+	return n, nil
+}
@@ -0,0 +1,123 @@
+package httpclient
+
+import (
+	"bytes"
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestCheckURL(t *testing.T) {
+	ctx := context.Background()
+	c := New(Config{AllowedHosts: []string{"example.com", "*.oss.aliyuncs.com"}}, nil)
+	assert.NoError(t, c.CheckURL(ctx, "https://example.com/a.txt"))
+	assert.NoError(t, c.CheckURL(ctx, "http://bucket.oss.aliyuncs.com/a.txt"))
+	assert.ErrorIs(t, c.CheckURL(ctx, "https://oss.aliyuncs.com/a.txt"), ErrForbiddenURL)
+	assert.ErrorIs(t, c.CheckURL(ctx, "https://evil.com/a.txt"), ErrForbiddenURL)
+	assert.ErrorIs(t, c.CheckURL(ctx, "file:///etc/passwd"), ErrForbiddenURL)
+	assert.ErrorIs(t, c.CheckURL(ctx, "gopher://example.com"), ErrForbiddenURL)
+
+	c = New(Config{}, nil)
+	assert.NoError(t, c.CheckURL(ctx, "https://evil.com/a.txt"))
+	for _, u := range []string{"http://127.0.0.1/", "http://10.0.0.1/", "http://192.168.1.1/", "http://169.254.169.254/latest/meta-data", "http://[::1]/", "http://0.0.0.0/"} {
+		assert.ErrorIs(t, c.CheckURL(ctx, u), ErrForbiddenURL, u)
+	}
+	assert.NoError(t, New(Config{AllowPrivate: true}, nil).CheckURL(ctx, "http://127.0.0.1/"))
+
+	c = New(Config{DeniedHosts: []string{"*.internal.example.com"}}, nil)
+	assert.ErrorIs(t, c.CheckURL(ctx, "https://a.internal.example.com/a.txt"), ErrForbiddenURL)
+	assert.NoError(t, c.CheckURL(ctx, "https://example.com/a.txt"))
+}
+
+func TestRules(t *testing.T) {
+	ctx := WithRules(context.Background(), Rules{
+		AllowedHosts: []string{"*.example.com", "127.0.0.1"},
+		DeniedHosts:  []string{"private.example.com"},
+		BlockPrivate: true,
+	})
+	c := New(Config{AllowPrivate: true}, nil)
+	assert.NoError(t, c.CheckURL(ctx, "https://a.example.com/a.txt"))
+	assert.ErrorIs(t, c.CheckURL(ctx, "https://private.example.com/a.txt"), ErrForbiddenURL)
+	assert.ErrorIs(t, c.CheckURL(ctx, "https://evil.com/a.txt"), ErrForbiddenURL)
+	assert.ErrorIs(t, c.CheckURL(ctx, "http://127.0.0.1/"), ErrForbiddenURL)
+	assert.NoError(t, c.CheckURL(context.Background(), "http://127.0.0.1/"))
+
+	// 规则只能收紧 Config，域名解析出的地址在连接时同样按规则校验
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte("hello"))
+	}))
+	defer srv.Close()
+	var buf bytes.Buffer
+	_, err := c.Download(WithRules(context.Background(), Rules{BlockPrivate: true}), strings.Replace(srv.URL, "127.0.0.1", "localhost", 1), &buf)
+	assert.ErrorIs(t, err, ErrForbiddenURL)
+	_, err = c.Download(context.Background(), srv.URL, &buf)
+	assert.NoError(t, err)
+	_, err = New(Config{}, nil).Download(WithRules(context.Background(), Rules{}), srv.URL, &buf)
+	assert.ErrorIs(t, err, ErrForbiddenURL)
+}
+
+func TestDownload(t *testing.T) {
+	ctx := context.Background()
+	mux := http.NewServeMux()
+	mux.HandleFunc("/a.txt", func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "text/plain; charset=utf-8")
+		w.Write([]byte("hello world"))
+	})
+	mux.HandleFunc("/a.html", func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "text/html")
+		w.Write([]byte("<html></html>"))
+	})
+	mux.HandleFunc("/redirect/", func(w http.ResponseWriter, r *http.Request) {
+		n := strings.TrimPrefix(r.URL.Path, "/redirect/")
+		if n == "0" {
+			http.Redirect(w, r, "/a.txt", http.StatusFound)
+			return
+		}
+		http.Redirect(w, r, "/redirect/"+string(n[0]-1), http.StatusFound)
+	})
+	mux.HandleFunc("/metadata", func(w http.ResponseWriter, r *http.Request) {
+		http.Redirect(w, r, "http://169.254.169.254/latest/meta-data", http.StatusFound)
+	})
+	srv := httptest.NewServer(mux)
+	defer srv.Close()
+
+	// 默认禁止访问回环地址，域名解析出的地址在连接时校验
+	var buf bytes.Buffer
+	_, err := New(Config{}, nil).Download(ctx, srv.URL+"/a.txt", &buf)
+	assert.ErrorIs(t, err, ErrForbiddenURL)
+	_, err = New(Config{}, nil).Download(ctx, strings.Replace(srv.URL, "127.0.0.1", "localhost", 1)+"/a.txt", &buf)
+	assert.ErrorIs(t, err, ErrForbiddenURL)
+
+	c := New(Config{AllowPrivate: true, MaxBytes: 11, MaxRedirects: 2}, nil)
+	buf.Reset()
+	n, err := c.Download(ctx, srv.URL+"/a.txt", &buf, "text/plain")
+	require.NoError(t, err)
+	assert.Equal(t, int64(11), n)
+	assert.Equal(t, "hello world", buf.String())
+
+	_, err = c.Download(ctx, srv.URL+"/a.html", &buf, "text/plain")
+	assert.ErrorIs(t, err, ErrContentType)
+	_, err = New(Config{AllowPrivate: true, MaxBytes: 5}, nil).Download(ctx, srv.URL+"/a.txt", &buf)
+	assert.ErrorIs(t, err, ErrTooLarge)
+
+	// 重定向次数限制，重定向的目标同样校验
+	_, err = c.Download(ctx, srv.URL+"/redirect/1", &buf)
+	assert.NoError(t, err)
+	_, err = c.Download(ctx, srv.URL+"/redirect/2", &buf)
+	assert.Error(t, err)
+	_, err = New(Config{AllowPrivate: true, MaxRedirects: -1}, nil).Download(ctx, srv.URL+"/redirect/0", &buf)
+	assert.Error(t, err)
+
+	hosts := New(Config{AllowPrivate: true, AllowedHosts: []string{"127.0.0.1"}}, nil)
+	_, err = hosts.Download(ctx, srv.URL+"/a.txt", &buf)
+	assert.NoError(t, err)
+	_, err = hosts.Download(ctx, srv.URL+"/metadata", &buf)
+	assert.ErrorIs(t, err, ErrForbiddenURL)
+	_, err = New(Config{}, nil).Download(ctx, "http://169.254.169.254/latest/meta-data", &buf)
+	assert.ErrorIs(t, err, ErrForbiddenURL)
+}
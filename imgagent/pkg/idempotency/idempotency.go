@@ -0,0 +1,168 @@
+package idempotency
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"sync"
+	"time"
+
+	"github.com/redis/go-redis/v9"
+)
+
+// Config 幂等键存储配置
+type Config struct {
+	// Prefix redis key 前缀，默认 imgagent:idempotency
+	Prefix string `json:"prefix"`
+	// TTLSecs 幂等键及其响应的保留时间，默认 24 小时
+	TTLSecs int `json:"ttl_secs"`
+}
+
+func (c Config) withDefaults() Config {
+	if c.Prefix == "" {
+		c.Prefix = "imgagent:idempotency"
+	}
+	if c.TTLSecs <= 0 {
+		c.TTLSecs = 24 * 3600
+	}
+	return c
+}
+
+// Response 保存的响应，重复请求时原样返回
+type Response struct {
+	Status      int    `json:"status"`
+	ContentType string `json:"content_type"`
+	Body        []byte `json:"body"`
+}
+
+// Store 保存幂等键到响应的映射。请求开始时占用 key，处理完成后保存响应；
+// 处理失败需要允许客户端重试时释放 key
+type Store interface {
+	// Reserve 占用 key，成功返回 true；key 已被占用时返回 false，已保存响应时一并返回，仍在处理中时 resp 为 nil
+	Reserve(ctx context.Context, key string) (ok bool, resp *Response, err error)
+	// Save 保存 key 对应的响应，保留时间从保存时重新计算
+	Save(ctx context.Context, key string, resp *Response) error
+	// Release 释放 key，之后使用相同 key 的请求重新处理
+	Release(ctx context.Context, key string) error
+	Close() error
+}
+
+// New client 为 nil 时使用进程内实现，只适用于单实例部署
+func New(conf Config, client *redis.Client) Store {
+	if client == nil {
+		return NewMemory(conf)
+	}
+	return NewRedis(conf, client)
+}
+
+type redisStore struct {
+	client *redis.Client
+	prefix string
+	ttl    time.Duration
+}
+
+// NewRedis 使用共享的 redis 连接，连接由调用方关闭
+func NewRedis(conf Config, client *redis.Client) Store {
+	conf = conf.withDefaults()
+	return &redisStore{
+		client: client,
+		prefix: conf.Prefix,
+		ttl:    time.Duration(conf.TTLSecs) * time.Second,
+	}
+}
+
+func (s *redisStore) key(key string) string {
+	return s.prefix + ":" + key
+}
+
+// Reserve 处理中的 key 值为空，保存响应后为 json
+func (s *redisStore) Reserve(ctx context.Context, key string) (bool, *Response, error) {
+	ok, err := s.client.SetNX(ctx, s.key(key), "", s.ttl).Result()
+	if err != nil || ok {
+		return ok, nil, err
+	}
+	val, err := s.client.Get(ctx, s.key(key)).Bytes()
+	if errors.Is(err, redis.Nil) {
+		// 刚好过期或被释放，重新占用
+		return s.Reserve(ctx, key)
+	}
+	if err != nil || len(val) == 0 {
+		return false, nil, err
+	}
+	var resp Response
+	err = json.Unmarshal(val, &resp)
+	if err != nil {
+		return false, nil, err
+	}
+	return false, &resp, nil
+}
+
+func (s *redisStore) Save(ctx context.Context, key string, resp *Response) error {
+	val, err := json.Marshal(resp)
+	if err != nil {
+		return err
+	}
+	return s.client.Set(ctx, s.key(key), val, s.ttl).Err()
+}
+
+func (s *redisStore) Release(ctx context.Context, key string) error {
+	return s.client.Del(ctx, s.key(key)).Err()
+}
+
+func (s *redisStore) Close() error {
+	return nil
+}
+
+type memoryEntry struct {
+	resp     *Response
+	expireAt time.Time
+}
+
+type memoryStore struct {
+	mu      sync.Mutex
+	ttl     time.Duration
+	entries map[string]memoryEntry
+}
+
+func NewMemory(conf Config) Store {
+	conf = conf.withDefaults()
+	return &memoryStore{
+		ttl:     time.Duration(conf.TTLSecs) * time.Second,
+		entries: make(map[string]memoryEntry),
+	}
+}
+
+func (s *memoryStore) Reserve(ctx context.Context, key string) (bool, *Response, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	now := time.Now()
+	// 占用时顺带清理过期的 key
+	for k, e := range s.entries {
+		if now.After(e.expireAt) {
+			delete(s.entries, k)
+		}
+	}
+	if e, ok := s.entries[key]; ok {
+		return false, e.resp, nil
+	}
+	s.entries[key] = memoryEntry{expireAt: now.Add(s.ttl)}
+	return true, nil, nil
+}
+
+func (s *memoryStore) Save(ctx context.Context, key string, resp *Response) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.entries[key] = memoryEntry{resp: resp, expireAt: time.Now().Add(s.ttl)}
+	return nil
+}
+
+func (s *memoryStore) Release(ctx context.Context, key string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	delete(s.entries, key)
+	return nil
+}
+
+func (s *memoryStore) Close() error {
+	return nil
+}
@@ -0,0 +1,48 @@
+package idempotency
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestMemory(t *testing.T) {
+	ctx := context.Background()
+	s := New(Config{}, nil)
+	defer s.Close()
+
+	ok, resp, err := s.Reserve(ctx, "k1")
+	require.NoError(t, err)
+	assert.True(t, ok)
+	assert.Nil(t, resp)
+
+	// 处理中重复请求不返回响应
+	ok, resp, err = s.Reserve(ctx, "k1")
+	require.NoError(t, err)
+	assert.False(t, ok)
+	assert.Nil(t, resp)
+
+	// 保存后返回保存的响应
+	want := &Response{Status: 200, ContentType: "application/json", Body: []byte(`{"code":200}`)}
+	require.NoError(t, s.Save(ctx, "k1", want))
+	ok, resp, err = s.Reserve(ctx, "k1")
+	require.NoError(t, err)
+	assert.False(t, ok)
+	assert.Equal(t, want, resp)
+
+	// 释放后重新处理
+	require.NoError(t, s.Release(ctx, "k1"))
+	ok, _, err = s.Reserve(ctx, "k1")
+	require.NoError(t, err)
+	assert.True(t, ok)
+
+	// 过期后重新处理
+	s.(*memoryStore).entries["k1"] = memoryEntry{resp: want, expireAt: time.Now().Add(-time.Second)}
+	ok, resp, err = s.Reserve(ctx, "k1")
+	require.NoError(t, err)
+	assert.True(t, ok)
+	assert.Nil(t, resp)
+}
@@ -0,0 +1,187 @@
+package jobqueue
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"errors"
+	"sync"
+	"time"
+
+	"github.com/redis/go-redis/v9"
+)
+
+// ErrLeaseLost 租约已失效：超时未续约被重新入队，任务可能已被其他实例领取
+var ErrLeaseLost = errors.New("job lease lost")
+
+// Config 任务队列配置
+type Config struct {
+	// Prefix redis key 前缀，默认 imgagent:jobs
+	Prefix string `json:"prefix"`
+	// VisibilityTimeoutSecs 领取后超过该时间未续约的任务重新入队，由其他实例处理，默认 120s
+	VisibilityTimeoutSecs int `json:"visibility_timeout_secs"`
+}
+
+func (c Config) withDefaults() Config {
+	if c.Prefix == "" {
+		c.Prefix = "imgagent:jobs"
+	}
+	if c.VisibilityTimeoutSecs <= 0 {
+		c.VisibilityTimeoutSecs = 120
+	}
+	return c
+}
+
+// Lease 领取的任务，处理期间定期 Heartbeat 续约，处理结束后 Ack
+type Lease struct {
+	Queue string
+	JobID string
+	Token string
+}
+
+// Queue 可靠任务队列。任务按 score 从小到大出队，领取后进入处理中，超过可见性超时未续约时重新入队，
+// 保证实例退出或崩溃后任务不丢失
+type Queue interface {
+	// Enqueue 任务入队，等待中的任务更新 score，处理中的任务不重复入队
+	Enqueue(ctx context.Context, queue string, jobID string, score float64) error
+	// Dequeue 领取 score 最小的任务，没有任务时返回 nil；领取前先将超时的任务重新入队
+	Dequeue(ctx context.Context, queue string) (*Lease, error)
+	// Heartbeat 续约，租约已失效时返回 ErrLeaseLost
+	Heartbeat(ctx context.Context, lease *Lease) error
+	// Ack 任务处理结束，从队列删除，租约已失效时返回 ErrLeaseLost
+	Ack(ctx context.Context, lease *Lease) error
+	// VisibilityTimeout 租约的有效期，续约间隔应明显小于该值
+	VisibilityTimeout() time.Duration
+	Close() error
+}
+
+// New client 为 nil 时使用进程内实现，只适用于单实例部署
+func New(conf Config, client *redis.Client) Queue {
+	if client == nil {
+		return NewMemory(conf)
+	}
+	return NewRedis(conf, client)
+}
+
+func newToken() string {
+	b := make([]byte, 16)
+	rand.Read(b)
+	return hex.EncodeToString(b)
+}
+
+type memoryJob struct {
+	score    float64
+	seq      uint64
+	token    string
+	deadline time.Time
+}
+
+type memoryQueue struct {
+	mu      sync.Mutex
+	timeout time.Duration
+	seq     uint64
+	// pending、inflight 按队列名保存等待中和处理中的任务
+	pending  map[string]map[string]*memoryJob
+	inflight map[string]map[string]*memoryJob
+}
+
+func NewMemory(conf Config) Queue {
+	conf = conf.withDefaults()
+	return &memoryQueue{
+		timeout:  time.Duration(conf.VisibilityTimeoutSecs) * time.Second,
+		pending:  make(map[string]map[string]*memoryJob),
+		inflight: make(map[string]map[string]*memoryJob),
+	}
+}
+
+func (q *memoryQueue) jobs(m map[string]map[string]*memoryJob, queue string) map[string]*memoryJob {
+	if m[queue] == nil {
+		m[queue] = make(map[string]*memoryJob)
+	}
+	return m[queue]
+}
+
+func (q *memoryQueue) Enqueue(ctx context.Context, queue string, jobID string, score float64) error {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	if _, ok := q.inflight[queue][jobID]; ok {
+		return nil
+	}
+	pending := q.jobs(q.pending, queue)
+	if job, ok := pending[jobID]; ok {
+		job.score = score
+		return nil
+	}
+	q.seq++
+	pending[jobID] = &memoryJob{score: score, seq: q.seq}
+	return nil
+}
+
+func (q *memoryQueue) Dequeue(ctx context.Context, queue string) (*Lease, error) {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+
+	now := time.Now()
+	pending, inflight := q.jobs(q.pending, queue), q.jobs(q.inflight, queue)
+	for id, job := range inflight {
+		if now.After(job.deadline) {
+			delete(inflight, id)
+			job.token = ""
+			pending[id] = job
+		}
+	}
+
+	// score 相同时先入队的先出队
+	var id string
+	var next *memoryJob
+	for jobID, job := range pending {
+		if next == nil || job.score < next.score || (job.score == next.score && job.seq < next.seq) {
+			id, next = jobID, job
+		}
+	}
+	if next == nil {
+		return nil, nil
+	}
+	delete(pending, id)
+	next.token = newToken()
+	next.deadline = now.Add(q.timeout)
+	inflight[id] = next
+	return &Lease{Queue: queue, JobID: id, Token: next.token}, nil
+}
+
+func (q *memoryQueue) lease(lease *Lease) (*memoryJob, error) {
+	job, ok := q.inflight[lease.Queue][lease.JobID]
+	if !ok || job.token != lease.Token {
+		return nil, ErrLeaseLost
+	}
+	return job, nil
+}
+
+func (q *memoryQueue) Heartbeat(ctx context.Context, lease *Lease) error {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	job, err := q.lease(lease)
+	if err != nil {
+		return err
+	}
+	job.deadline = time.Now().Add(q.timeout)
+	return nil
+}
+
+func (q *memoryQueue) Ack(ctx context.Context, lease *Lease) error {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	if _, err := q.lease(lease); err != nil {
+		return err
+	}
+	delete(q.inflight[lease.Queue], lease.JobID)
+	return nil
+}
+
+func (q *memoryQueue) VisibilityTimeout() time.Duration {
+	return q.timeout
+}
+
+func (q *memoryQueue) Close() error {
+	return nil
+}
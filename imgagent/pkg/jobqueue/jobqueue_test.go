@@ -0,0 +1,68 @@
+package jobqueue
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestMemory(t *testing.T) {
+	ctx := context.Background()
+	q := New(Config{}, nil)
+	defer q.Close()
+	assert.Equal(t, 120*time.Second, q.VisibilityTimeout())
+
+	// 空队列返回 nil
+	lease, err := q.Dequeue(ctx, "a")
+	require.NoError(t, err)
+	assert.Nil(t, lease)
+
+	// 按 score 从小到大出队，score 相同时先入队的先出队，重复入队更新 score
+	require.NoError(t, q.Enqueue(ctx, "a", "j1", 2))
+	require.NoError(t, q.Enqueue(ctx, "a", "j2", 1))
+	require.NoError(t, q.Enqueue(ctx, "a", "j3", 1))
+	require.NoError(t, q.Enqueue(ctx, "a", "j1", 0))
+	require.NoError(t, q.Enqueue(ctx, "b", "j4", 0))
+	var ids []string
+	var leases []*Lease
+	for {
+		lease, err := q.Dequeue(ctx, "a")
+		require.NoError(t, err)
+		if lease == nil {
+			break
+		}
+		ids = append(ids, lease.JobID)
+		leases = append(leases, lease)
+	}
+	assert.Equal(t, []string{"j1", "j2", "j3"}, ids)
+
+	// 处理中的任务不重复入队
+	require.NoError(t, q.Enqueue(ctx, "a", "j1", 0))
+	lease, err = q.Dequeue(ctx, "a")
+	require.NoError(t, err)
+	assert.Nil(t, lease)
+
+	require.NoError(t, q.Heartbeat(ctx, leases[0]))
+	require.NoError(t, q.Ack(ctx, leases[0]))
+	assert.ErrorIs(t, q.Ack(ctx, leases[0]), ErrLeaseLost)
+	assert.ErrorIs(t, q.Heartbeat(ctx, leases[0]), ErrLeaseLost)
+
+	// 超时未续约的任务重新入队，原租约失效
+	q.(*memoryQueue).inflight["a"]["j2"].deadline = time.Now().Add(-time.Second)
+	lease, err = q.Dequeue(ctx, "a")
+	require.NoError(t, err)
+	require.NotNil(t, lease)
+	assert.Equal(t, "j2", lease.JobID)
+	assert.NotEqual(t, leases[1].Token, lease.Token)
+	assert.ErrorIs(t, q.Heartbeat(ctx, leases[1]), ErrLeaseLost)
+	assert.ErrorIs(t, q.Ack(ctx, leases[1]), ErrLeaseLost)
+	require.NoError(t, q.Ack(ctx, lease))
+
+	lease, err = q.Dequeue(ctx, "b")
+	require.NoError(t, err)
+	require.NotNil(t, lease)
+	assert.Equal(t, "j4", lease.JobID)
+}
@@ -0,0 +1,135 @@
+package jobqueue
+
+import (
+	"context"
+	"errors"
+	"strconv"
+	"time"
+
+	"github.com/redis/go-redis/v9"
+)
+
+// 每个队列使用三个 key：pending 等待中的任务（zset，score 为优先级），inflight 处理中的任务（zset，score 为租约到期毫秒时间戳），
+// leases 处理中任务的租约（hash，值为 "token score"，超时重新入队时恢复原 score）
+var (
+	enqueueScript = redis.NewScript(`
+if redis.call('ZSCORE', KEYS[2], ARGV[2]) then
+	return 0
+end
+redis.call('ZADD', KEYS[1], ARGV[1], ARGV[2])
+return 1
+`)
+
+	dequeueScript = redis.NewScript(`
+local expired = redis.call('ZRANGEBYSCORE', KEYS[2], '-inf', ARGV[1])
+for _, id in ipairs(expired) do
+	local lease = redis.call('HGET', KEYS[3], id)
+	local score = lease and string.match(lease, ' (.*)$') or '0'
+	redis.call('ZREM', KEYS[2], id)
+	redis.call('HDEL', KEYS[3], id)
+	redis.call('ZADD', KEYS[1], score, id)
+end
+local items = redis.call('ZRANGE', KEYS[1], 0, 0, 'WITHSCORES')
+if #items == 0 then
+	return false
+end
+redis.call('ZREM', KEYS[1], items[1])
+redis.call('ZADD', KEYS[2], ARGV[2], items[1])
+redis.call('HSET', KEYS[3], items[1], ARGV[3] .. ' ' .. items[2])
+return items[1]
+`)
+
+	heartbeatScript = redis.NewScript(`
+local lease = redis.call('HGET', KEYS[2], ARGV[1])
+if not lease or string.sub(lease, 1, #ARGV[2] + 1) ~= ARGV[2] .. ' ' then
+	return 0
+end
+redis.call('ZADD', KEYS[1], 'XX', ARGV[3], ARGV[1])
+return 1
+`)
+
+	ackScript = redis.NewScript(`
+local lease = redis.call('HGET', KEYS[2], ARGV[1])
+if not lease or string.sub(lease, 1, #ARGV[2] + 1) ~= ARGV[2] .. ' ' then
+	return 0
+end
+redis.call('ZREM', KEYS[1], ARGV[1])
+redis.call('HDEL', KEYS[2], ARGV[1])
+return 1
+`)
+)
+
+type redisQueue struct {
+	client  *redis.Client
+	prefix  string
+	timeout time.Duration
+}
+
+// NewRedis 使用共享的 redis 连接，连接由调用方关闭
+func NewRedis(conf Config, client *redis.Client) Queue {
+	conf = conf.withDefaults()
+	return &redisQueue{
+		client:  client,
+		prefix:  conf.Prefix,
+		timeout: time.Duration(conf.VisibilityTimeoutSecs) * time.Second,
+	}
+}
+
+func (q *redisQueue) keys(queue string) (pending, inflight, leases string) {
+	base := q.prefix + ":" + queue
+	return base + ":pending", base + ":inflight", base + ":leases"
+}
+
+func (q *redisQueue) deadline() int64 {
+	return time.Now().Add(q.timeout).UnixMilli()
+}
+
+func (q *redisQueue) Enqueue(ctx context.Context, queue string, jobID string, score float64) error {
+	pending, inflight, _ := q.keys(queue)
+	return enqueueScript.Run(ctx, q.client, []string{pending, inflight}, strconv.FormatFloat(score, 'f', -1, 64), jobID).Err()
+}
+
+func (q *redisQueue) Dequeue(ctx context.Context, queue string) (*Lease, error) {
+	pending, inflight, leases := q.keys(queue)
+	token := newToken()
+	id, err := dequeueScript.Run(ctx, q.client, []string{pending, inflight, leases}, time.Now().UnixMilli(), q.deadline(), token).Text()
+	if errors.Is(err, redis.Nil) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	return &Lease{Queue: queue, JobID: id, Token: token}, nil
+}
+
+func (q *redisQueue) Heartbeat(ctx context.Context, lease *Lease) error {
+	_, inflight, leases := q.keys(lease.Queue)
+	ok, err := heartbeatScript.Run(ctx, q.client, []string{inflight, leases}, lease.JobID, lease.Token, q.deadline()).Int()
+	if err != nil {
+		return err
+	}
+	if ok == 0 {
+		return ErrLeaseLost
+	}
+	return nil
+}
+
+func (q *redisQueue) Ack(ctx context.Context, lease *Lease) error {
+	_, inflight, leases := q.keys(lease.Queue)
+	ok, err := ackScript.Run(ctx, q.client, []string{inflight, leases}, lease.JobID, lease.Token).Int()
+	if err != nil {
+		return err
+	}
+	if ok == 0 {
+		return ErrLeaseLost
+	}
+	return nil
+}
+
+func (q *redisQueue) VisibilityTimeout() time.Duration {
+	return q.timeout
+}
+
+func (q *redisQueue) Close() error {
+	return nil
+}
@@ -0,0 +1,26 @@
+package logger
+
+import (
+	"fmt"
+
+	"go.uber.org/zap"
+)
+
+// atomicLevel 全局 logger 的日志级别，New 时按配置设置，运行时通过 SetLevel 修改
+var atomicLevel = zap.NewAtomicLevel()
+
+// SetLevel 修改全局 logger 的日志级别，已创建的 logger 立即生效
+func SetLevel(level string) error {
+	switch level {
+	case "debug", "info", "warn", "error":
+	default:
+		return fmt.Errorf("unknown log level: %s", level)
+	}
+	atomicLevel.SetLevel(logLevel(level))
+	return nil
+}
+
+// Level 当前的日志级别
+func Level() string {
+	return atomicLevel.String()
+}
@@ -0,0 +1,26 @@
+package logger
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"go.uber.org/zap"
+)
+
+func TestSetLevel(t *testing.T) {
+	_, err := New(Config{Level: "info"})
+	require.NoError(t, err)
+	log := NewLogger("level")
+	assert.Equal(t, "info", Level())
+	assert.False(t, log.Desugar().Core().Enabled(zap.DebugLevel))
+
+	// 已创建的 logger 立即按新级别输出
+	require.NoError(t, SetLevel("debug"))
+	assert.Equal(t, "debug", Level())
+	assert.True(t, log.Desugar().Core().Enabled(zap.DebugLevel))
+
+	assert.Error(t, SetLevel("verbose"))
+	assert.Equal(t, "debug", Level())
+	require.NoError(t, SetLevel("info"))
+}
@@ -7,6 +7,7 @@ import (
 
 	"github.com/gin-gonic/gin"
 	"github.com/google/uuid"
+	"go.opentelemetry.io/otel/trace"
 	"go.uber.org/zap"
 	"go.uber.org/zap/zapcore"
 	"gopkg.in/natefinch/lumberjack.v2"
@@ -43,7 +44,7 @@ func New(conf Config) (*zap.Logger, error) {
 	ecfg.EncodeTime = zapcore.ISO8601TimeEncoder
 	ecfg.EncodeLevel = zapcore.CapitalLevelEncoder
 	encoder := zapcore.NewConsoleEncoder(ecfg)
-	level := zap.NewAtomicLevelAt(logLevel(conf.Level))
+	atomicLevel.SetLevel(logLevel(conf.Level))
 
 	// 创建 WriteSyncer
 	var ws zapcore.WriteSyncer
@@ -62,7 +63,7 @@ func New(conf Config) (*zap.Logger, error) {
 		ws = zapcore.AddSync(lumberLogger)
 	}
 	// 构建 logger
-	core := zapcore.NewCore(encoder, ws, level)
+	core := zapcore.NewCore(encoder, ws, atomicLevel)
 	logger := zap.New(core, zap.AddStacktrace(zap.PanicLevel), zap.AddCaller())
 
 	zap.ReplaceGlobals(logger)
@@ -118,6 +119,19 @@ func NewContext(reqID string) context.Context {
 	return context.WithValue(context.Background(), LoggerKey, NewLogger(reqID))
 }
 
+// WithTrace 将 ctx 中 span 的 trace_id 附加到 logger 上，便于日志与链路互查
+func WithTrace(ctx context.Context) context.Context {
+	sc := trace.SpanContextFromContext(ctx)
+	if !sc.IsValid() {
+		return ctx
+	}
+	log := FromContext(ctx)
+	return context.WithValue(ctx, LoggerKey, &Logger{
+		ReqID:         log.ReqID,
+		SugaredLogger: log.With("trace_id", sc.TraceID().String()),
+	})
+}
+
 func FromGinContext(c *gin.Context) *Logger {
 	// ReqLogger 在 gin 上下文中一定存在
 	return c.MustGet(ReqLogger).(*Logger)
@@ -0,0 +1,38 @@
+package logger
+
+import (
+	"context"
+	"net/http"
+)
+
+// RequestIDHeader 出站请求携带请求 id 的头，下游服务的日志可据此与本服务的日志关联
+const RequestIDHeader = "X-Request-ID"
+
+// RequestID 返回 ctx 中 logger 的请求 id，ctx 中没有 logger 时返回空
+func RequestID(ctx context.Context) string {
+	if logger, ok := ctx.Value(LoggerKey).(*Logger); ok {
+		return logger.ReqID
+	}
+	return ""
+}
+
+// Transport 为出站 HTTP 请求添加 X-Request-ID 头，值为请求 ctx 中 logger 的请求 id
+type Transport struct {
+	Base http.RoundTripper
+}
+
+func NewTransport(base http.RoundTripper) *Transport {
+	if base == nil {
+		base = http.DefaultTransport
+	}
+	return &Transport{Base: base}
+}
+
+func (t *Transport) RoundTrip(req *http.Request) (*http.Response, error) {
+	if reqID := RequestID(req.Context()); reqID != "" && req.Header.Get(RequestIDHeader) == "" {
+		// RoundTripper 不应修改原请求
+		req = req.Clone(req.Context())
+		req.Header.Set(RequestIDHeader, reqID)
+	}
+	return t.Base.RoundTrip(req)
+}
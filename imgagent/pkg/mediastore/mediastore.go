@@ -0,0 +1,120 @@
+// Package mediastore 保存服务端生成的媒体文件（非百炼服务返回的音频、图片数据及字幕），由服务按文件名对外提供访问。
+package mediastore
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"os"
+	"path/filepath"
+	"regexp"
+	"strings"
+)
+
+// namePattern 媒体文件名，由内容哈希和扩展名组成
+var namePattern = regexp.MustCompile(`^[0-9a-f]{64}\.(wav|mp3|png|jpg|webp|srt|vtt)$`)
+
+// Store 将媒体文件保存在本地目录，文件的 URL 为 baseURL/{name}
+type Store struct {
+	dir     string
+	baseURL string
+}
+
+func New(dir, baseURL string) *Store {
+	return &Store{dir: dir, baseURL: baseURL}
+}
+
+// Save 按内容哈希命名保存文件并返回 URL，相同内容只保存一份。ext 为不带点的扩展名
+func (s *Store) Save(data []byte, ext string) (string, error) {
+	sum := sha256.Sum256(data)
+	name := hex.EncodeToString(sum[:]) + "." + ext
+	if !namePattern.MatchString(name) {
+		return "", errors.New("unsupported media type: " + ext)
+	}
+	path := filepath.Join(s.dir, name)
+	if _, err := os.Stat(path); err == nil {
+		return s.baseURL + "/" + name, nil
+	}
+
+	err := os.MkdirAll(s.dir, 0755)
+	if err != nil {
+		return "", err
+	}
+	// 先写临时文件再改名，避免读到写了一半的文件
+	tmp, err := os.CreateTemp(s.dir, name+".*.tmp")
+	if err != nil {
+		return "", err
+	}
+	defer os.Remove(tmp.Name())
+	if _, err = tmp.Write(data); err != nil {
+		tmp.Close()
+		return "", err
+	}
+	if err = tmp.Close(); err != nil {
+		return "", err
+	}
+	if err = os.Rename(tmp.Name(), path); err != nil {
+		return "", err
+	}
+	return s.baseURL + "/" + name, nil
+}
+
+// Read 读取 Save 返回的 URL 对应的文件，url 不属于该 store 时 ok 为 false
+func (s *Store) Read(url string) (data []byte, ok bool, err error) {
+	name, found := strings.CutPrefix(url, s.baseURL+"/")
+	if !found || !namePattern.MatchString(name) {
+		return nil, false, nil
+	}
+	data, err = os.ReadFile(filepath.Join(s.dir, name))
+	return data, true, err
+}
+
+// Delete 删除 Save 返回的 URL 对应的文件，url 不属于该 store 时 ok 为 false，文件不存在不视为错误
+func (s *Store) Delete(url string) (ok bool, err error) {
+	name, found := strings.CutPrefix(url, s.baseURL+"/")
+	if !found || !namePattern.MatchString(name) {
+		return false, nil
+	}
+	err = os.Remove(filepath.Join(s.dir, name))
+	if errors.Is(err, os.ErrNotExist) {
+		err = nil
+	}
+	return true, err
+}
+
+// Path 返回文件的本地路径，name 不是 Save 生成的文件名时返回错误
+func (s *Store) Path(name string) (string, error) {
+	if !namePattern.MatchString(name) {
+		return "", errors.New("invalid media name")
+	}
+	return filepath.Join(s.dir, name), nil
+}
+
+// File 目录中的媒体文件
+type File struct {
+	Name string `json:"name"`
+	Size int64  `json:"size"`
+}
+
+// List 按文件名排序返回目录中 Save 生成的全部文件，目录不存在时为空
+func (s *Store) List() ([]File, error) {
+	entries, err := os.ReadDir(s.dir)
+	if errors.Is(err, os.ErrNotExist) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	var files []File
+	for _, entry := range entries {
+		if entry.IsDir() || !namePattern.MatchString(entry.Name()) {
+			continue
+		}
+		info, err := entry.Info()
+		if err != nil {
+			return nil, err
+		}
+		files = append(files, File{Name: entry.Name(), Size: info.Size()})
+	}
+	return files, nil
+}
@@ -0,0 +1,71 @@
+package mediastore
+
+import (
+	"os"
+	"path"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestStore(t *testing.T) {
+	s := New(t.TempDir(), "http://localhost/v1/audio")
+
+	url, err := s.Save([]byte("RIFF"), "wav")
+	require.NoError(t, err)
+	assert.Regexp(t, `^http://localhost/v1/audio/[0-9a-f]{64}\.wav$`, url)
+
+	// 相同内容返回相同 URL
+	url2, err := s.Save([]byte("RIFF"), "wav")
+	require.NoError(t, err)
+	assert.Equal(t, url, url2)
+
+	p, err := s.Path(path.Base(url))
+	require.NoError(t, err)
+	data, err := os.ReadFile(p)
+	require.NoError(t, err)
+	assert.Equal(t, []byte("RIFF"), data)
+
+	data, ok, err := s.Read(url)
+	require.NoError(t, err)
+	assert.True(t, ok)
+	assert.Equal(t, []byte("RIFF"), data)
+	_, ok, _ = s.Read("http://other/v1/audio/" + path.Base(url))
+	assert.False(t, ok)
+
+	ok, err = s.Delete(url)
+	require.NoError(t, err)
+	assert.True(t, ok)
+	_, err = os.Stat(p)
+	assert.True(t, os.IsNotExist(err))
+	// 重复删除和不属于该 store 的 url
+	ok, err = s.Delete(url)
+	require.NoError(t, err)
+	assert.True(t, ok)
+	ok, _ = s.Delete("http://other/v1/audio/" + path.Base(url))
+	assert.False(t, ok)
+
+	_, err = s.Save([]byte("x"), "exe")
+	require.Error(t, err)
+	_, err = s.Path("../secret.wav")
+	require.Error(t, err)
+}
+
+func TestStoreList(t *testing.T) {
+	dir := t.TempDir()
+	s := New(filepath.Join(dir, "media"), "http://localhost/v1/images")
+
+	files, err := s.List()
+	require.NoError(t, err)
+	assert.Empty(t, files)
+
+	url, err := s.Save([]byte("PNG"), "png")
+	require.NoError(t, err)
+	require.NoError(t, os.WriteFile(filepath.Join(dir, "media", "other.txt"), []byte("x"), 0644))
+
+	files, err = s.List()
+	require.NoError(t, err)
+	assert.Equal(t, []File{{Name: path.Base(url), Size: 3}}, files)
+}
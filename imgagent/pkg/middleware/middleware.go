@@ -10,12 +10,21 @@ import (
 
 	"github.com/gin-gonic/gin"
 	"github.com/google/uuid"
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/codes"
+	"go.opentelemetry.io/otel/propagation"
+	"go.opentelemetry.io/otel/trace"
 
 	"imgagent/pkg/logger"
+	"imgagent/pkg/tracing"
 )
 
 const (
 	XReqID = "X-Reqid"
+
+	// maxRequestIDLen 客户端传入的请求 id 的最大长度，与审计日志等记录请求 id 的列宽一致
+	maxRequestIDLen = 64
 )
 
 func NewRouter(writer io.Writer) *gin.Engine {
@@ -38,6 +47,7 @@ func NewRouter(writer io.Writer) *gin.Engine {
 		},
 		Output: writer,
 	}))
+	router.Use(Trace())
 	router.Use(Logger())
 	router.Use(Cors())
 	router.Use(gin.Recovery())
@@ -46,8 +56,12 @@ func NewRouter(writer io.Writer) *gin.Engine {
 
 func Logger() gin.HandlerFunc {
 	return func(c *gin.Context) {
-		// 尝试从请求头中获取 XReqID，没有则重新生成
+		// 尝试从请求头中获取 XReqID 或 X-Request-ID，没有则重新生成
 		reqID := c.Request.Header.Get(XReqID)
+		if reqID == "" {
+			reqID = c.Request.Header.Get(logger.RequestIDHeader)
+		}
+		reqID = sanitizeRequestID(reqID)
 		if reqID == "" {
 			uid := uuid.New()
 			reqID = hex.EncodeToString(uid[:])
@@ -56,13 +70,56 @@ func Logger() gin.HandlerFunc {
 		// 将 XReqID、log 设置到上下文中
 		c.Set(XReqID, reqID)
 		c.Writer.Header().Set(XReqID, reqID)
-		// 将 reqid 设置到 log 中
-		log := logger.NewLogger(reqID)
-		c.Set(logger.ReqLogger, log)
-		ctx := context.WithValue(c.Request.Context(), logger.LoggerKey, log)
+		c.Writer.Header().Set(logger.RequestIDHeader, reqID)
+		// 将 reqid、trace_id 设置到 log 中
+		ctx := context.WithValue(c.Request.Context(), logger.LoggerKey, logger.NewLogger(reqID))
+		ctx = logger.WithTrace(ctx)
+		c.Set(logger.ReqLogger, logger.FromContext(ctx))
+		c.Request = c.Request.WithContext(ctx)
+
+		c.Next()
+	}
+}
+
+// sanitizeRequestID 客户端传入的请求 id 只接受可打印的 ASCII 字符，否则丢弃后重新生成；超长时截断
+func sanitizeRequestID(reqID string) string {
+	for i := 0; i < len(reqID); i++ {
+		if reqID[i] < 0x21 || reqID[i] > 0x7e {
+			return ""
+		}
+	}
+	if len(reqID) > maxRequestIDLen {
+		reqID = reqID[:maxRequestIDLen]
+	}
+	return reqID
+}
+
+// Trace 为每个请求创建 server span，并延续上游传入的 traceparent
+func Trace() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		ctx := otel.GetTextMapPropagator().Extract(c.Request.Context(), propagation.HeaderCarrier(c.Request.Header))
+		route := c.FullPath()
+		if route == "" {
+			route = c.Request.URL.Path
+		}
+		ctx, span := tracing.Tracer().Start(ctx, c.Request.Method+" "+route,
+			trace.WithSpanKind(trace.SpanKindServer),
+			trace.WithAttributes(
+				attribute.String("http.request.method", c.Request.Method),
+				attribute.String("http.route", route),
+				attribute.String("client.address", c.ClientIP()),
+			),
+		)
+		defer span.End()
 		c.Request = c.Request.WithContext(ctx)
 
 		c.Next()
+
+		status := c.Writer.Status()
+		span.SetAttributes(attribute.Int("http.response.status_code", status))
+		if status >= http.StatusInternalServerError {
+			span.SetStatus(codes.Error, http.StatusText(status))
+		}
 	}
 }
 
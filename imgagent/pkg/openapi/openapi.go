@@ -0,0 +1,305 @@
+package openapi
+
+import (
+	"embed"
+	"io/fs"
+	"net/http"
+	"reflect"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Document OpenAPI 3 文档，只包含本服务用到的字段
+type Document struct {
+	OpenAPI    string                           `json:"openapi"`
+	Info       Info                             `json:"info"`
+	Paths      map[string]map[string]*Operation `json:"paths"`
+	Components Components                       `json:"components"`
+}
+
+type Info struct {
+	Title   string `json:"title"`
+	Version string `json:"version"`
+}
+
+type Components struct {
+	Schemas map[string]*Schema `json:"schemas"`
+}
+
+type Operation struct {
+	Tags        []string             `json:"tags,omitempty"`
+	Summary     string               `json:"summary,omitempty"`
+	OperationID string               `json:"operationId,omitempty"`
+	Parameters  []Parameter          `json:"parameters,omitempty"`
+	RequestBody *RequestBody         `json:"requestBody,omitempty"`
+	Responses   map[string]*Response `json:"responses"`
+}
+
+type Parameter struct {
+	Name        string  `json:"name"`
+	In          string  `json:"in"`
+	Description string  `json:"description,omitempty"`
+	Required    bool    `json:"required,omitempty"`
+	Schema      *Schema `json:"schema"`
+}
+
+type RequestBody struct {
+	Required bool                  `json:"required,omitempty"`
+	Content  map[string]*MediaType `json:"content"`
+}
+
+type Response struct {
+	Description string                `json:"description"`
+	Content     map[string]*MediaType `json:"content,omitempty"`
+}
+
+type MediaType struct {
+	Schema *Schema `json:"schema"`
+}
+
+type Schema struct {
+	Ref                  string             `json:"$ref,omitempty"`
+	Type                 string             `json:"type,omitempty"`
+	Format               string             `json:"format,omitempty"`
+	Description          string             `json:"description,omitempty"`
+	MaxLength            *int               `json:"maxLength,omitempty"`
+	Items                *Schema            `json:"items,omitempty"`
+	Properties           map[string]*Schema `json:"properties,omitempty"`
+	Required             []string           `json:"required,omitempty"`
+	AdditionalProperties *Schema            `json:"additionalProperties,omitempty"`
+	AllOf                []*Schema          `json:"allOf,omitempty"`
+}
+
+// Route 一个接口的描述，Body、Result 为 api 包中的请求、响应结构体零值，为 nil 表示没有
+type Route struct {
+	Method  string
+	Path    string // gin 风格路径，如 /v1/documents/:document_id
+	Tag     string
+	Summary string
+	Query   []Parameter
+	Header  []Parameter
+	Form    []Parameter // multipart/form-data 字段
+	Body    any
+	Result  any
+	// Produces 非空时响应为该类型的原始内容，不使用 BaseResponse 包装
+	Produces string
+}
+
+// Envelope 业务响应的公共 body，Data 字段由各接口的 Result 替换
+type Envelope struct {
+	Code    int    `json:"code"`
+	Message string `json:"message,omitempty"`
+	Reqid   string `json:"reqid"`
+}
+
+var pathParamRe = regexp.MustCompile(`:(\w+)`)
+
+// Build 根据路由表生成 OpenAPI 文档，结构体 schema 通过反射 json/binding tag 生成
+func Build(info Info, routes []Route) *Document {
+	g := &generator{schemas: make(map[string]*Schema)}
+	doc := &Document{
+		OpenAPI:    "3.0.3",
+		Info:       info,
+		Paths:      make(map[string]map[string]*Operation),
+		Components: Components{Schemas: g.schemas},
+	}
+	envelope := g.schemaOf(reflect.TypeOf(Envelope{}))
+
+	for _, r := range routes {
+		path := pathParamRe.ReplaceAllString(r.Path, "{$1}")
+		op := &Operation{
+			Summary:     r.Summary,
+			OperationID: strings.ToLower(r.Method) + strings.NewReplacer("/", "_", "{", "", "}", "").Replace(path),
+			Responses:   make(map[string]*Response),
+		}
+		if r.Tag != "" {
+			op.Tags = []string{r.Tag}
+		}
+		for _, m := range pathParamRe.FindAllStringSubmatch(r.Path, -1) {
+			op.Parameters = append(op.Parameters, Parameter{Name: m[1], In: "path", Required: true, Schema: &Schema{Type: "string"}})
+		}
+		for _, q := range r.Query {
+			q.In = "query"
+			op.Parameters = append(op.Parameters, q)
+		}
+		for _, h := range r.Header {
+			h.In = "header"
+			op.Parameters = append(op.Parameters, h)
+		}
+
+		switch {
+		case r.Body != nil:
+			op.RequestBody = &RequestBody{
+				Required: true,
+				Content:  map[string]*MediaType{"application/json": {Schema: g.schemaOf(reflect.TypeOf(r.Body))}},
+			}
+		case len(r.Form) > 0:
+			form := &Schema{Type: "object", Properties: make(map[string]*Schema)}
+			for _, f := range r.Form {
+				form.Properties[f.Name] = f.Schema
+				if f.Required {
+					form.Required = append(form.Required, f.Name)
+				}
+			}
+			op.RequestBody = &RequestBody{
+				Required: true,
+				Content:  map[string]*MediaType{"multipart/form-data": {Schema: form}},
+			}
+		}
+
+		if r.Produces != "" {
+			op.Responses[strconv.Itoa(http.StatusOK)] = &Response{
+				Description: "OK",
+				Content:     map[string]*MediaType{r.Produces: {Schema: &Schema{Type: "string", Format: "binary"}}},
+			}
+		} else {
+			data := &Schema{Type: "object", Properties: map[string]*Schema{"data": {}}}
+			if r.Result != nil {
+				data.Properties["data"] = g.schemaOf(reflect.TypeOf(r.Result))
+			}
+			op.Responses[strconv.Itoa(http.StatusOK)] = &Response{
+				Description: "业务结果，body 中 code 为 200 表示成功，非 200 为业务错误码",
+				Content:     map[string]*MediaType{"application/json": {Schema: &Schema{AllOf: []*Schema{envelope, data}}}},
+			}
+		}
+
+		if doc.Paths[path] == nil {
+			doc.Paths[path] = make(map[string]*Operation)
+		}
+		doc.Paths[path][strings.ToLower(r.Method)] = op
+	}
+	return doc
+}
+
+type generator struct {
+	schemas map[string]*Schema
+}
+
+var timeType = reflect.TypeOf(time.Time{})
+
+func (g *generator) schemaOf(t reflect.Type) *Schema {
+	for t.Kind() == reflect.Pointer {
+		t = t.Elem()
+	}
+	if t == timeType {
+		return &Schema{Type: "string", Format: "date-time"}
+	}
+
+	switch t.Kind() {
+	case reflect.Bool:
+		return &Schema{Type: "boolean"}
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32,
+		reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32:
+		return &Schema{Type: "integer", Format: "int32"}
+	case reflect.Int64, reflect.Uint64:
+		return &Schema{Type: "integer", Format: "int64"}
+	case reflect.Float32:
+		return &Schema{Type: "number", Format: "float"}
+	case reflect.Float64:
+		return &Schema{Type: "number", Format: "double"}
+	case reflect.String:
+		return &Schema{Type: "string"}
+	case reflect.Slice, reflect.Array:
+		if t.Elem().Kind() == reflect.Uint8 {
+			return &Schema{Type: "string", Format: "byte"}
+		}
+		return &Schema{Type: "array", Items: g.schemaOf(t.Elem())}
+	case reflect.Map:
+		return &Schema{Type: "object", AdditionalProperties: g.schemaOf(t.Elem())}
+	case reflect.Struct:
+		if t.Name() == "" {
+			return g.structSchema(t)
+		}
+		// 具名结构体放到 components 中复用，先占位避免递归类型死循环
+		if _, ok := g.schemas[t.Name()]; !ok {
+			g.schemas[t.Name()] = &Schema{}
+			*g.schemas[t.Name()] = *g.structSchema(t)
+		}
+		return &Schema{Ref: "#/components/schemas/" + t.Name()}
+	default:
+		// interface 等任意类型
+		return &Schema{}
+	}
+}
+
+func (g *generator) structSchema(t reflect.Type) *Schema {
+	s := &Schema{Type: "object", Properties: make(map[string]*Schema)}
+	g.addFields(s, t)
+	return s
+}
+
+func (g *generator) addFields(s *Schema, t reflect.Type) {
+	for i := 0; i < t.NumField(); i++ {
+		f := t.Field(i)
+		tag := f.Tag.Get("json")
+		if tag == "-" || (!f.IsExported() && !f.Anonymous) {
+			continue
+		}
+		name := strings.Split(tag, ",")[0]
+		// 匿名嵌入且没有 json 名字的结构体，字段提升到外层
+		if f.Anonymous && name == "" {
+			ft := f.Type
+			if ft.Kind() == reflect.Pointer {
+				ft = ft.Elem()
+			}
+			if ft.Kind() == reflect.Struct {
+				g.addFields(s, ft)
+				continue
+			}
+		}
+		if name == "" {
+			name = f.Name
+		}
+
+		fs := g.schemaOf(f.Type)
+		for _, rule := range strings.Split(f.Tag.Get("binding"), ",") {
+			switch {
+			case rule == "required":
+				s.Required = append(s.Required, name)
+			case strings.HasPrefix(rule, "max=") && fs.Type == "string":
+				if n, err := strconv.Atoi(strings.TrimPrefix(rule, "max=")); err == nil {
+					fs.MaxLength = &n
+				}
+			}
+		}
+		s.Properties[name] = fs
+	}
+}
+
+// swaggerUIFiles swagger-ui-dist 4.15.5 的静态资源，随二进制发布，不依赖外部 CDN
+//
+//go:embed swaggerui/swagger-ui-bundle.js swaggerui/swagger-ui.css
+var swaggerUIFiles embed.FS
+
+// SwaggerUIAssets Swagger UI 静态资源，文件位于根目录
+func SwaggerUIAssets() http.FileSystem {
+	sub, err := fs.Sub(swaggerUIFiles, "swaggerui")
+	if err != nil {
+		panic(err)
+	}
+	return http.FS(sub)
+}
+
+// SwaggerUI 返回加载 specURL 的 Swagger UI 页面，静态资源从 assetsURL 下加载，见 SwaggerUIAssets
+func SwaggerUI(title, specURL, assetsURL string) []byte {
+	return []byte(`<!DOCTYPE html>
+<html lang="en">
+<head>
+  <meta charset="utf-8" />
+  <title>` + title + `</title>
+  <link rel="stylesheet" href="` + assetsURL + `/swagger-ui.css" />
+</head>
+<body>
+  <div id="swagger-ui"></div>
+  <script src="` + assetsURL + `/swagger-ui-bundle.js"></script>
+  <script>
+    window.onload = () => {
+      window.ui = SwaggerUIBundle({ url: "` + specURL + `", dom_id: "#swagger-ui" });
+    };
+  </script>
+</body>
+</html>
+`)
+}
@@ -0,0 +1,70 @@
+package openapi
+
+import (
+	"io"
+	"net/http"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+type testBase struct {
+	ID string `json:"id"`
+}
+
+type testNode struct {
+	testBase
+	Name     string            `json:"name" binding:"required,max=20"`
+	Children []testNode        `json:"children"`
+	Labels   map[string]string `json:"labels,omitempty"`
+	Internal string            `json:"-"`
+	Any      any               `json:"any"`
+}
+
+func TestBuild(t *testing.T) {
+	doc := Build(Info{Title: "test", Version: "v1"}, []Route{
+		{Method: http.MethodPut, Path: "/v1/nodes/:node_id", Body: testNode{}, Result: testNode{},
+			Header: []Parameter{{Name: "X-Token", Schema: &Schema{Type: "string"}}}},
+		{Method: http.MethodGet, Path: "/v1/nodes/:node_id/image", Produces: "image/png"},
+	})
+
+	op := doc.Paths["/v1/nodes/{node_id}"]["put"]
+	require.NotNil(t, op)
+	require.Len(t, op.Parameters, 2)
+	assert.Equal(t, "node_id", op.Parameters[0].Name)
+	assert.Equal(t, "header", op.Parameters[1].In)
+	assert.Equal(t, "#/components/schemas/testNode", op.RequestBody.Content["application/json"].Schema.Ref)
+
+	node := doc.Components.Schemas["testNode"]
+	require.NotNil(t, node)
+	// 嵌入结构体字段提升，json:"-" 字段忽略
+	assert.Contains(t, node.Properties, "id")
+	assert.NotContains(t, node.Properties, "Internal")
+	assert.Equal(t, []string{"name"}, node.Required)
+	assert.Equal(t, 20, *node.Properties["name"].MaxLength)
+	// 递归类型通过引用表示
+	assert.Equal(t, "#/components/schemas/testNode", node.Properties["children"].Items.Ref)
+	assert.Equal(t, "string", node.Properties["labels"].AdditionalProperties.Type)
+
+	image := doc.Paths["/v1/nodes/{node_id}/image"]["get"]
+	assert.Equal(t, "binary", image.Responses["200"].Content["image/png"].Schema.Format)
+}
+
+func TestSwaggerUI(t *testing.T) {
+	page := string(SwaggerUI("test", "/openapi.json", "/swagger-ui"))
+	assert.Contains(t, page, `href="/swagger-ui/swagger-ui.css"`)
+	assert.Contains(t, page, `src="/swagger-ui/swagger-ui-bundle.js"`)
+	assert.NotContains(t, page, "https://")
+
+	// 页面引用的资源均已内嵌
+	assets := SwaggerUIAssets()
+	for _, name := range []string{"/swagger-ui.css", "/swagger-ui-bundle.js"} {
+		f, err := assets.Open(name)
+		require.NoError(t, err)
+		data, err := io.ReadAll(f)
+		f.Close()
+		require.NoError(t, err)
+		assert.NotEmpty(t, data)
+	}
+}
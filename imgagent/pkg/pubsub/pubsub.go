@@ -0,0 +1,117 @@
+package pubsub
+
+import (
+	"context"
+	"sync"
+
+	"github.com/redis/go-redis/v9"
+)
+
+// subscriberBuffer 订阅者缓冲的消息数，消费过慢时丢弃新消息，不阻塞发布方
+const subscriberBuffer = 64
+
+// PubSub 按频道发布订阅消息，消息不持久化，订阅之前发布的消息收不到
+type PubSub interface {
+	Publish(ctx context.Context, channel string, msg []byte) error
+	// Subscribe 订阅频道，调用返回的 cancel 取消订阅并关闭消息 channel
+	Subscribe(ctx context.Context, channel string) (<-chan []byte, func(), error)
+	Close() error
+}
+
+// New client 为 nil 时使用进程内实现，只适用于单实例部署
+func New(client *redis.Client) PubSub {
+	if client == nil {
+		return NewMemory()
+	}
+	return NewRedis(client)
+}
+
+type redisPubSub struct {
+	client *redis.Client
+}
+
+// NewRedis 使用共享的 redis 连接，连接由调用方关闭
+func NewRedis(client *redis.Client) PubSub {
+	return &redisPubSub{client: client}
+}
+
+func (p *redisPubSub) Publish(ctx context.Context, channel string, msg []byte) error {
+	return p.client.Publish(ctx, channel, msg).Err()
+}
+
+func (p *redisPubSub) Subscribe(ctx context.Context, channel string) (<-chan []byte, func(), error) {
+	sub := p.client.Subscribe(ctx, channel)
+	// 等待订阅确认，保证返回后发布的消息都能收到
+	_, err := sub.Receive(ctx)
+	if err != nil {
+		sub.Close()
+		return nil, nil, err
+	}
+
+	ch := make(chan []byte, subscriberBuffer)
+	go func() {
+		defer close(ch)
+		for msg := range sub.Channel() {
+			select {
+			case ch <- []byte(msg.Payload):
+			default:
+			}
+		}
+	}()
+	return ch, func() { sub.Close() }, nil
+}
+
+func (p *redisPubSub) Close() error {
+	return nil
+}
+
+type memoryPubSub struct {
+	mu   sync.Mutex
+	subs map[string]map[chan []byte]struct{}
+}
+
+func NewMemory() PubSub {
+	return &memoryPubSub{
+		subs: make(map[string]map[chan []byte]struct{}),
+	}
+}
+
+func (p *memoryPubSub) Publish(ctx context.Context, channel string, msg []byte) error {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	for ch := range p.subs[channel] {
+		select {
+		case ch <- msg:
+		default:
+		}
+	}
+	return nil
+}
+
+func (p *memoryPubSub) Subscribe(ctx context.Context, channel string) (<-chan []byte, func(), error) {
+	ch := make(chan []byte, subscriberBuffer)
+	p.mu.Lock()
+	if p.subs[channel] == nil {
+		p.subs[channel] = make(map[chan []byte]struct{})
+	}
+	p.subs[channel][ch] = struct{}{}
+	p.mu.Unlock()
+
+	var once sync.Once
+	cancel := func() {
+		once.Do(func() {
+			p.mu.Lock()
+			defer p.mu.Unlock()
+			delete(p.subs[channel], ch)
+			if len(p.subs[channel]) == 0 {
+				delete(p.subs, channel)
+			}
+			close(ch)
+		})
+	}
+	return ch, cancel, nil
+}
+
+func (p *memoryPubSub) Close() error {
+	return nil
+}
@@ -0,0 +1,46 @@
+package pubsub
+
+import (
+	"context"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestMemory(t *testing.T) {
+	ctx := context.Background()
+	p := New(nil)
+	defer p.Close()
+
+	// 没有订阅者时发布直接丢弃
+	require.NoError(t, p.Publish(ctx, "a", []byte("lost")))
+
+	a1, cancel1, err := p.Subscribe(ctx, "a")
+	require.NoError(t, err)
+	a2, cancel2, err := p.Subscribe(ctx, "a")
+	require.NoError(t, err)
+	b, cancelB, err := p.Subscribe(ctx, "b")
+	require.NoError(t, err)
+	defer cancelB()
+
+	require.NoError(t, p.Publish(ctx, "a", []byte("hello")))
+	assert.Equal(t, []byte("hello"), <-a1)
+	assert.Equal(t, []byte("hello"), <-a2)
+	assert.Empty(t, b)
+
+	// 取消订阅后关闭 channel，重复取消无副作用
+	cancel1()
+	cancel1()
+	_, ok := <-a1
+	assert.False(t, ok)
+	require.NoError(t, p.Publish(ctx, "a", []byte("again")))
+	assert.Equal(t, []byte("again"), <-a2)
+	cancel2()
+
+	// 订阅者消费过慢时丢弃消息，不阻塞发布
+	for i := 0; i < subscriberBuffer+10; i++ {
+		require.NoError(t, p.Publish(ctx, "b", []byte("x")))
+	}
+	assert.Len(t, b, subscriberBuffer)
+}
@@ -0,0 +1,77 @@
+package redisutil
+
+import (
+	"context"
+	"errors"
+	"net"
+
+	"github.com/redis/go-redis/v9"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/trace"
+
+	"imgagent/pkg/tracing"
+)
+
+// Config redis 连接配置，发布订阅、任务队列、分布式锁、幂等键和读缓存共用同一个连接
+type Config struct {
+	Addr     string `json:"addr"`
+	Password string `json:"password"`
+	DB       int    `json:"db"`
+}
+
+// NewClient Addr 为空时返回 nil，各组件使用进程内实现，只适用于单实例部署。
+// 每条命令创建 span，串联到 ctx 所在的请求链路上
+func NewClient(conf Config) *redis.Client {
+	if conf.Addr == "" {
+		return nil
+	}
+	client := redis.NewClient(&redis.Options{
+		Addr:     conf.Addr,
+		Password: conf.Password,
+		DB:       conf.DB,
+	})
+	client.AddHook(tracingHook{})
+	return client
+}
+
+// tracingHook 为 redis 命令和 pipeline 创建 span
+type tracingHook struct{}
+
+func (tracingHook) DialHook(next redis.DialHook) redis.DialHook {
+	return func(ctx context.Context, network, addr string) (net.Conn, error) {
+		return next(ctx, network, addr)
+	}
+}
+
+func (tracingHook) ProcessHook(next redis.ProcessHook) redis.ProcessHook {
+	return func(ctx context.Context, cmd redis.Cmder) error {
+		ctx, span := tracing.Start(ctx, "redis."+cmd.Name(),
+			attribute.String("db.system.name", "redis"),
+			attribute.String("db.operation.name", cmd.Name()),
+		)
+		defer span.End()
+		err := next(ctx, cmd)
+		recordError(span, err)
+		return err
+	}
+}
+
+func (tracingHook) ProcessPipelineHook(next redis.ProcessPipelineHook) redis.ProcessPipelineHook {
+	return func(ctx context.Context, cmds []redis.Cmder) error {
+		ctx, span := tracing.Start(ctx, "redis.pipeline",
+			attribute.String("db.system.name", "redis"),
+			attribute.Int("db.operation.batch.size", len(cmds)),
+		)
+		defer span.End()
+		err := next(ctx, cmds)
+		recordError(span, err)
+		return err
+	}
+}
+
+// recordError key 不存在属于正常业务分支，不标记为错误
+func recordError(span trace.Span, err error) {
+	if err != nil && !errors.Is(err, redis.Nil) {
+		tracing.RecordError(span, err)
+	}
+}
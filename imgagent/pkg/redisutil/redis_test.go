@@ -0,0 +1,42 @@
+package redisutil
+
+import (
+	"context"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/codes"
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+	"go.opentelemetry.io/otel/sdk/trace/tracetest"
+
+	"imgagent/pkg/tracing"
+)
+
+func TestTracingHook(t *testing.T) {
+	recorder := tracetest.NewSpanRecorder()
+	otel.SetTracerProvider(sdktrace.NewTracerProvider(sdktrace.WithSpanProcessor(recorder)))
+
+	assert.Nil(t, NewClient(Config{}))
+
+	// 连接失败的命令记录为失败的子 span
+	client := NewClient(Config{Addr: "127.0.0.1:1"})
+	defer client.Close()
+	ctx, parent := tracing.Start(context.Background(), "parent")
+	require.Error(t, client.Get(ctx, "key").Err())
+	pipe := client.Pipeline()
+	pipe.Get(ctx, "a")
+	pipe.Get(ctx, "b")
+	_, err := pipe.Exec(ctx)
+	require.Error(t, err)
+	parent.End()
+
+	spans := recorder.Ended()
+	require.Len(t, spans, 3)
+	assert.Equal(t, "redis.get", spans[0].Name())
+	assert.Equal(t, parent.SpanContext().SpanID(), spans[0].Parent().SpanID())
+	assert.Equal(t, codes.Error, spans[0].Status().Code)
+	assert.Equal(t, "redis.pipeline", spans[1].Name())
+	assert.Equal(t, codes.Error, spans[1].Status().Code)
+}
@@ -0,0 +1,179 @@
+package textclean
+
+import (
+	"fmt"
+	"regexp"
+	"strings"
+	"unicode"
+	"unicode/utf8"
+)
+
+// adLineMaxRunes 广告、水印行一般很短，超过该长度的行即使命中广告规则也保留，避免误删整段正文
+const adLineMaxRunes = 80
+
+// 重复页眉页脚的长度范围，过短的行多为 “嗯。”、“……” 之类的正文对白
+const (
+	repeatedLineMinRunes = 5
+	repeatedLineMaxRunes = 50
+)
+
+// adPatterns 盗版 txt 中常见的广告、水印行
+var adPatterns = []*regexp.Regexp{
+	regexp.MustCompile(`(?i)https?://|www\.|\.(com|net|org|cc|cn|la|info|xyz)\b`),
+	regexp.MustCompile(`(?i)txt\s*(全集)?\s*下载|电子书下载|免费阅读|无弹窗|全文阅读`),
+	regexp.MustCompile(`最新章节|首发域名|记住本站|本站地址|手机(用户|版)?(请)?(访问|阅读)|更多精彩`),
+	regexp.MustCompile(`本书由.{0,20}(整理|提供|首发)|(?i)qq\s*群|微信公众号|求月票|求推荐票|求收藏`),
+}
+
+var (
+	zeroWidthReplacer = strings.NewReplacer("\u200b", "", "\u200c", "", "\u200d", "", "\ufeff", "", "\u00a0", " ")
+	blankLinesPattern = regexp.MustCompile(`\n{3,}`)
+	ellipsisPattern   = regexp.MustCompile(`\.{3,}|。{3,}|…+`)
+)
+
+// fullWidthPunct 与中文相邻时转换为全角的半角标点
+var fullWidthPunct = map[rune]rune{
+	',': '，',
+	'!': '！',
+	'?': '？',
+	':': '：',
+	';': '；',
+}
+
+// Rule 自定义正则替换规则，Replace 为空时删除匹配内容，支持 $1 引用分组
+type Rule struct {
+	Pattern string `json:"pattern"`
+	Replace string `json:"replace"`
+}
+
+// Config 文本清洗配置，零值只统一换行符为 \n
+type Config struct {
+	StripAds             bool   `json:"strip_ads"`               // 删除广告、水印行
+	NormalizeWhitespace  bool   `json:"normalize_whitespace"`    // 去掉零宽字符和行首尾空白（含全角缩进），合并多余空行
+	NormalizePunctuation bool   `json:"normalize_punctuation"`   // 与中文相邻的半角标点转为全角，省略号统一为 ……
+	RepeatedLineMinCount int    `json:"repeated_line_min_count"` // 同一行出现不少于该次数时视为页眉页脚删除，为 0 时不处理
+	Rules                []Rule `json:"rules"`                   // 自定义规则，在内置规则之前执行
+}
+
+type rule struct {
+	re      *regexp.Regexp
+	replace string
+}
+
+// Cleaner 在分割前清洗原文，可并发使用
+type Cleaner struct {
+	conf  Config
+	rules []rule
+}
+
+// New 编译自定义规则，规则不合法时返回错误
+func New(conf Config) (*Cleaner, error) {
+	c := &Cleaner{conf: conf}
+	for i, r := range conf.Rules {
+		re, err := regexp.Compile(r.Pattern)
+		if err != nil {
+			return nil, fmt.Errorf("invalid rule %d %q: %w", i, r.Pattern, err)
+		}
+		c.rules = append(c.rules, rule{re: re, replace: r.Replace})
+	}
+	return c, nil
+}
+
+// Clean 按 自定义规则、广告行、页眉页脚、空白、标点 的顺序清洗文本
+func (c *Cleaner) Clean(text string) string {
+	text = strings.ReplaceAll(text, "\r\n", "\n")
+	text = strings.ReplaceAll(text, "\r", "\n")
+
+	for _, r := range c.rules {
+		text = r.re.ReplaceAllString(text, r.replace)
+	}
+	if c.conf.StripAds || c.conf.RepeatedLineMinCount > 0 {
+		text = c.filterLines(text)
+	}
+	if c.conf.NormalizeWhitespace {
+		text = normalizeWhitespace(text)
+	}
+	if c.conf.NormalizePunctuation {
+		text = normalizePunctuation(text)
+	}
+	return text
+}
+
+// filterLines 删除广告行和重复出现的页眉页脚
+func (c *Cleaner) filterLines(text string) string {
+	lines := strings.Split(text, "\n")
+
+	var repeated map[string]bool
+	if c.conf.RepeatedLineMinCount > 0 {
+		counts := make(map[string]int)
+		for _, line := range lines {
+			line = strings.TrimSpace(line)
+			n := utf8.RuneCountInString(line)
+			if n >= repeatedLineMinRunes && n <= repeatedLineMaxRunes {
+				counts[line]++
+			}
+		}
+		repeated = make(map[string]bool)
+		for line, count := range counts {
+			if count >= c.conf.RepeatedLineMinCount {
+				repeated[line] = true
+			}
+		}
+	}
+
+	kept := lines[:0]
+	for _, line := range lines {
+		trimmed := strings.TrimSpace(line)
+		if repeated[trimmed] {
+			continue
+		}
+		if c.conf.StripAds && isAdLine(trimmed) {
+			continue
+		}
+		kept = append(kept, line)
+	}
+	return strings.Join(kept, "\n")
+}
+
+func isAdLine(line string) bool {
+	if line == "" || utf8.RuneCountInString(line) > adLineMaxRunes {
+		return false
+	}
+	for _, re := range adPatterns {
+		if re.MatchString(line) {
+			return true
+		}
+	}
+	return false
+}
+
+func normalizeWhitespace(text string) string {
+	text = zeroWidthReplacer.Replace(text)
+	lines := strings.Split(text, "\n")
+	for i, line := range lines {
+		lines[i] = strings.TrimFunc(line, unicode.IsSpace)
+	}
+	text = strings.Join(lines, "\n")
+	text = blankLinesPattern.ReplaceAllString(text, "\n\n")
+	return strings.TrimSpace(text)
+}
+
+func normalizePunctuation(text string) string {
+	text = ellipsisPattern.ReplaceAllString(text, "……")
+
+	runes := []rune(text)
+	for i, r := range runes {
+		full, ok := fullWidthPunct[r]
+		if !ok {
+			continue
+		}
+		if (i > 0 && isHan(runes[i-1])) || (i+1 < len(runes) && isHan(runes[i+1])) {
+			runes[i] = full
+		}
+	}
+	return string(runes)
+}
+
+func isHan(r rune) bool {
+	return unicode.Is(unicode.Han, r)
+}
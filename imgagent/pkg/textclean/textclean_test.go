@@ -0,0 +1,57 @@
+package textclean
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestClean(t *testing.T) {
+	// 零值只统一换行符
+	c, err := New(Config{})
+	require.NoError(t, err)
+	assert.Equal(t, "第一章\n\n  正文", c.Clean("第一章\r\n\r\n  正文"))
+
+	c, err = New(Config{StripAds: true})
+	require.NoError(t, err)
+	text := "第一章 开始\n请记住本站首发域名：www.example.com\n他走进了房间。\n本书由某某书友整理\n手机用户请访问m.example.la\n"
+	assert.Equal(t, "第一章 开始\n他走进了房间。\n", c.Clean(text))
+	// 长段落即使包含广告关键词也保留
+	long := strings.Repeat("他说这是最新章节的内容，", 10)
+	assert.Equal(t, long, c.Clean(long))
+
+	// 重复出现的页眉页脚删除，短对白保留
+	c, err = New(Config{RepeatedLineMinCount: 3})
+	require.NoError(t, err)
+	var b strings.Builder
+	for i := 0; i < 3; i++ {
+		b.WriteString("某某小说网 独家连载\n“嗯。”\n正文第" + string(rune('一'+i)) + "段。\n")
+	}
+	cleaned := c.Clean(b.String())
+	assert.NotContains(t, cleaned, "独家连载")
+	assert.Equal(t, 3, strings.Count(cleaned, "“嗯。”"))
+	assert.Contains(t, cleaned, "正文第一段。")
+
+	c, err = New(Config{NormalizeWhitespace: true, NormalizePunctuation: true})
+	require.NoError(t, err)
+	text = "\ufeff　　他说:你好,世界!\t\n\n\n\n　　她问?...Hello, world.\n"
+	assert.Equal(t, "他说：你好，世界！\n\n她问？……Hello, world.", c.Clean(text))
+}
+
+func TestCleanRules(t *testing.T) {
+	_, err := New(Config{Rules: []Rule{{Pattern: "("}}})
+	require.Error(t, err)
+
+	// 自定义规则先于内置规则执行，替换后的空行再由空白规范化合并
+	c, err := New(Config{
+		NormalizeWhitespace: true,
+		Rules: []Rule{
+			{Pattern: `(?m)^【.*?】$`},
+			{Pattern: `张(三|四)`, Replace: "李$1"},
+		},
+	})
+	require.NoError(t, err)
+	assert.Equal(t, "李三走了。\n\n李四来了。", c.Clean("张三走了。\n\n【本章由某站提供】\n\n张四来了。"))
+}
@@ -0,0 +1,162 @@
+package tracing
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/codes"
+	"go.opentelemetry.io/otel/exporters/stdout/stdouttrace"
+	"go.opentelemetry.io/otel/propagation"
+	"go.opentelemetry.io/otel/sdk/resource"
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+	"go.opentelemetry.io/otel/trace"
+)
+
+const (
+	ExporterStdout = "stdout"
+
+	defaultServiceName  = "imgagent"
+	instrumentationName = "imgagent"
+)
+
+// Config 链路追踪配置
+type Config struct {
+	Enable      bool    `json:"enable"`       // 是否开启
+	ServiceName string  `json:"service_name"` // 上报的服务名
+	Exporter    string  `json:"exporter"`     // 导出器，目前支持 stdout
+	File        string  `json:"file"`         // 导出文件，为空时输出到标准输出
+	SampleRatio float64 `json:"sample_ratio"` // 采样率，取值 (0, 1]，默认全采样
+}
+
+// Init 初始化全局 TracerProvider，返回的函数用于退出时刷新并关闭导出器。
+// 未开启时使用 otel 默认的 noop 实现，各处埋点不产生开销。
+func Init(conf Config) (func(context.Context) error, error) {
+	otel.SetTextMapPropagator(propagation.NewCompositeTextMapPropagator(
+		propagation.TraceContext{}, propagation.Baggage{},
+	))
+	if !conf.Enable {
+		return func(context.Context) error { return nil }, nil
+	}
+
+	if conf.ServiceName == "" {
+		conf.ServiceName = defaultServiceName
+	}
+	if conf.Exporter == "" {
+		conf.Exporter = ExporterStdout
+	}
+	if conf.SampleRatio <= 0 || conf.SampleRatio > 1 {
+		conf.SampleRatio = 1
+	}
+
+	var (
+		exporter sdktrace.SpanExporter
+		closer   io.Closer
+	)
+	switch conf.Exporter {
+	case ExporterStdout:
+		var w io.Writer = os.Stdout
+		if conf.File != "" {
+			f, err := os.OpenFile(conf.File, os.O_RDWR|os.O_APPEND|os.O_CREATE, 0666)
+			if err != nil {
+				return nil, err
+			}
+			w, closer = f, f
+		}
+		exp, err := stdouttrace.New(stdouttrace.WithWriter(w))
+		if err != nil {
+			return nil, err
+		}
+		exporter = exp
+	default:
+		return nil, fmt.Errorf("unsupported trace exporter: %s", conf.Exporter)
+	}
+
+	tp := sdktrace.NewTracerProvider(
+		sdktrace.WithBatcher(exporter),
+		sdktrace.WithSampler(sdktrace.ParentBased(sdktrace.TraceIDRatioBased(conf.SampleRatio))),
+		sdktrace.WithResource(resource.NewSchemaless(
+			attribute.String("service.name", conf.ServiceName),
+		)),
+	)
+	otel.SetTracerProvider(tp)
+
+	return func(ctx context.Context) error {
+		err := tp.Shutdown(ctx)
+		if closer != nil {
+			closer.Close()
+		}
+		return err
+	}, nil
+}
+
+// Tracer 返回项目统一使用的 tracer
+func Tracer() trace.Tracer {
+	return otel.Tracer(instrumentationName)
+}
+
+// Start 创建子 span，调用方负责 End
+func Start(ctx context.Context, name string, attrs ...attribute.KeyValue) (context.Context, trace.Span) {
+	return Tracer().Start(ctx, name, trace.WithAttributes(attrs...))
+}
+
+// RecordError 记录错误并把 span 标记为失败
+func RecordError(span trace.Span, err error) {
+	if err == nil {
+		return
+	}
+	span.RecordError(err)
+	span.SetStatus(codes.Error, err.Error())
+}
+
+// TraceID 返回 ctx 中 span 的 trace id，无有效 span 时返回空串
+func TraceID(ctx context.Context) string {
+	sc := trace.SpanContextFromContext(ctx)
+	if !sc.IsValid() {
+		return ""
+	}
+	return sc.TraceID().String()
+}
+
+// Transport 为出站 HTTP 请求创建 client span，并注入 traceparent 头
+type Transport struct {
+	Base http.RoundTripper
+}
+
+func NewTransport(base http.RoundTripper) *Transport {
+	if base == nil {
+		base = http.DefaultTransport
+	}
+	return &Transport{Base: base}
+}
+
+func (t *Transport) RoundTrip(req *http.Request) (*http.Response, error) {
+	ctx, span := Tracer().Start(req.Context(), "HTTP "+req.Method,
+		trace.WithSpanKind(trace.SpanKindClient),
+		trace.WithAttributes(
+			attribute.String("http.request.method", req.Method),
+			attribute.String("server.address", req.URL.Host),
+			attribute.String("url.path", req.URL.Path),
+		),
+	)
+	defer span.End()
+
+	// RoundTripper 不应修改原请求
+	req = req.Clone(ctx)
+	otel.GetTextMapPropagator().Inject(ctx, propagation.HeaderCarrier(req.Header))
+
+	resp, err := t.Base.RoundTrip(req)
+	if err != nil {
+		RecordError(span, err)
+		return nil, err
+	}
+	span.SetAttributes(attribute.Int("http.response.status_code", resp.StatusCode))
+	if resp.StatusCode >= http.StatusBadRequest {
+		span.SetStatus(codes.Error, resp.Status)
+	}
+	return resp, nil
+}
@@ -0,0 +1,57 @@
+package tracing
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/codes"
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+	"go.opentelemetry.io/otel/sdk/trace/tracetest"
+)
+
+func TestTransport(t *testing.T) {
+	recorder := tracetest.NewSpanRecorder()
+	tp := sdktrace.NewTracerProvider(sdktrace.WithSpanProcessor(recorder))
+	otel.SetTracerProvider(tp)
+	_, err := Init(Config{})
+	require.NoError(t, err)
+
+	var traceparent string
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		traceparent = r.Header.Get("traceparent")
+		w.WriteHeader(http.StatusTooManyRequests)
+	}))
+	defer srv.Close()
+
+	ctx, parent := Start(context.Background(), "parent")
+	traceID := TraceID(ctx)
+	require.NotEmpty(t, traceID)
+
+	client := &http.Client{Transport: NewTransport(nil)}
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, srv.URL+"/api/v1/tasks", nil)
+	require.NoError(t, err)
+	resp, err := client.Do(req)
+	require.NoError(t, err)
+	resp.Body.Close()
+	parent.End()
+
+	// 下游收到的 traceparent 延续同一条链路
+	assert.Contains(t, traceparent, traceID)
+	// 原请求头不应被修改
+	assert.Empty(t, req.Header.Get("traceparent"))
+
+	spans := recorder.Ended()
+	require.Len(t, spans, 2)
+	client0 := spans[0]
+	assert.Equal(t, "HTTP GET", client0.Name())
+	assert.Equal(t, traceID, client0.SpanContext().TraceID().String())
+	assert.Equal(t, parent.SpanContext().SpanID(), client0.Parent().SpanID())
+	assert.Equal(t, codes.Error, client0.Status().Code)
+
+	assert.Empty(t, TraceID(context.Background()))
+}
@@ -0,0 +1,99 @@
+// Package placeholder 为生成失败的场景提供占位媒体：带场景文字的卡片图片与对应时长的静音音频，
+// 保证导出和播放清单在场景等待人工重试期间依然完整可用。
+package placeholder
+
+import (
+	"bytes"
+	"encoding/binary"
+	"fmt"
+	"html"
+	"strings"
+	"time"
+	"unicode/utf8"
+)
+
+const (
+	// 卡片图片尺寸，与默认图片尺寸 1328*1328 保持一致
+	cardWidth  = 1328
+	cardHeight = 1328
+	// 每行最多字符数
+	lineRunes = 20
+	// 最多显示的行数，超出部分省略
+	maxLines = 16
+
+	// 语音时长估算：中文朗读约每秒 4 个字，最短 2 秒
+	runesPerSecond = 4
+	minDuration    = 2 * time.Second
+
+	// 静音音频参数：16kHz 单声道 16bit PCM
+	sampleRate    = 16000
+	bitsPerSample = 16
+	channels      = 1
+)
+
+// Image 生成带场景文字的 SVG 卡片图片
+func Image(text string) []byte {
+	lines := wrap(strings.TrimSpace(text), lineRunes, maxLines)
+
+	var buf bytes.Buffer
+	fmt.Fprintf(&buf, `<svg xmlns="http://www.w3.org/2000/svg" width="%d" height="%d" viewBox="0 0 %d %d">`, cardWidth, cardHeight, cardWidth, cardHeight)
+	buf.WriteString(`<rect width="100%" height="100%" fill="#2b2d42"/>`)
+	fmt.Fprintf(&buf, `<rect x="64" y="64" width="%d" height="%d" rx="32" fill="#edf2f4"/>`, cardWidth-128, cardHeight-128)
+	buf.WriteString(`<text x="50%" y="180" text-anchor="middle" font-size="48" fill="#8d99ae">图片生成中，请稍后重试</text>`)
+
+	lineHeight := 64
+	y := (cardHeight-len(lines)*lineHeight)/2 + lineHeight
+	for _, line := range lines {
+		fmt.Fprintf(&buf, `<text x="50%%" y="%d" text-anchor="middle" font-size="52" fill="#2b2d42">%s</text>`, y, html.EscapeString(line))
+		y += lineHeight
+	}
+	buf.WriteString(`</svg>`)
+	return buf.Bytes()
+}
+
+// Duration 根据场景文字长度估算朗读时长
+func Duration(text string) time.Duration {
+	d := time.Duration(utf8.RuneCountInString(text)) * time.Second / runesPerSecond
+	return max(d, minDuration)
+}
+
+// SilentWAV 生成时长为 d 的静音 WAV 音频
+func SilentWAV(d time.Duration) []byte {
+	blockAlign := channels * bitsPerSample / 8
+	dataSize := int(d.Seconds()*sampleRate) * blockAlign
+
+	var buf bytes.Buffer
+	buf.Grow(44 + dataSize)
+	buf.WriteString("RIFF")
+	binary.Write(&buf, binary.LittleEndian, uint32(36+dataSize))
+	buf.WriteString("WAVE")
+	buf.WriteString("fmt ")
+	binary.Write(&buf, binary.LittleEndian, uint32(16))
+	binary.Write(&buf, binary.LittleEndian, uint16(1)) // PCM
+	binary.Write(&buf, binary.LittleEndian, uint16(channels))
+	binary.Write(&buf, binary.LittleEndian, uint32(sampleRate))
+	binary.Write(&buf, binary.LittleEndian, uint32(sampleRate*blockAlign))
+	binary.Write(&buf, binary.LittleEndian, uint16(blockAlign))
+	binary.Write(&buf, binary.LittleEndian, uint16(bitsPerSample))
+	buf.WriteString("data")
+	binary.Write(&buf, binary.LittleEndian, uint32(dataSize))
+	buf.Write(make([]byte, dataSize))
+	return buf.Bytes()
+}
+
+// wrap 按字符数折行，超过 maxLines 行时截断并以省略号结尾
+func wrap(text string, width, maxLines int) []string {
+	var lines []string
+	runes := []rune(strings.ReplaceAll(text, "\n", ""))
+	for len(runes) > 0 {
+		if len(lines) == maxLines {
+			last := []rune(lines[maxLines-1])
+			lines[maxLines-1] = string(last[:max(len(last)-1, 0)]) + "…"
+			break
+		}
+		n := min(width, len(runes))
+		lines = append(lines, string(runes[:n]))
+		runes = runes[n:]
+	}
+	return lines
+}
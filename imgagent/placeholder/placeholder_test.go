@@ -0,0 +1,37 @@
+package placeholder
+
+import (
+	"encoding/binary"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestImage(t *testing.T) {
+	img := string(Image("祥子拉着车<走>在北平的街头"))
+	assert.True(t, strings.HasPrefix(img, "<svg"))
+	assert.Contains(t, img, "祥子拉着车&lt;走&gt;在北平的街头")
+
+	// 超长文字被截断
+	img = string(Image(strings.Repeat("长", lineRunes*maxLines+10)))
+	assert.Contains(t, img, "…")
+}
+
+func TestDuration(t *testing.T) {
+	assert.Equal(t, minDuration, Duration("短"))
+	assert.Equal(t, 10*time.Second, Duration(strings.Repeat("字", 40)))
+}
+
+func TestSilentWAV(t *testing.T) {
+	wav := SilentWAV(2 * time.Second)
+	require.Greater(t, len(wav), 44)
+	assert.Equal(t, "RIFF", string(wav[0:4]))
+	assert.Equal(t, "WAVE", string(wav[8:12]))
+
+	dataSize := binary.LittleEndian.Uint32(wav[40:44])
+	assert.Equal(t, uint32(2*sampleRate*2), dataSize)
+	assert.Equal(t, 44+int(dataSize), len(wav))
+}
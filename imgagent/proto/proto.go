@@ -19,6 +19,8 @@ type ApiError struct {
 	Code int
 	// Message 错误信息。
 	Message string
+	// Data 错误详情，写入响应的 data 字段，可为空。
+	Data any
 }
 
 func (e *ApiError) Error() string {
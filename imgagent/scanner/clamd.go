@@ -0,0 +1,113 @@
+package scanner
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"strings"
+	"time"
+
+	"imgagent/pkg/logger"
+)
+
+const (
+	// clamdChunkSize INSTREAM 每次发送的字节数，需小于 clamd 的 StreamMaxLength
+	clamdChunkSize = 64 << 10
+)
+
+// ClamdConfig ClamAV 守护进程，通过 INSTREAM 命令把文件内容发送给 clamd 扫描
+type ClamdConfig struct {
+	Addr           string `json:"addr"`            // tcp://127.0.0.1:3310 或 unix:///var/run/clamav/clamd.ctl，不带协议时按 tcp
+	RequestTimeout int    `json:"request_timeout"` // 单个文件的扫描超时时间（秒），默认 60
+}
+
+// Clamd ClamAV 扫描服务
+type Clamd struct {
+	network string
+	address string
+	timeout time.Duration
+}
+
+func NewClamd(config ClamdConfig) (*Clamd, error) {
+	if config.Addr == "" {
+		return nil, errors.New("clamd addr is required")
+	}
+	if config.RequestTimeout == 0 {
+		config.RequestTimeout = 60
+	}
+	c := &Clamd{network: "tcp", address: config.Addr, timeout: time.Duration(config.RequestTimeout) * time.Second}
+	if addr, ok := strings.CutPrefix(config.Addr, "unix://"); ok {
+		c.network, c.address = "unix", addr
+	} else {
+		c.address = strings.TrimPrefix(config.Addr, "tcp://")
+	}
+	return c, nil
+}
+
+// Scan 以 INSTREAM 分块发送内容，clamd 返回 "stream: OK" 或 "stream: <特征名> FOUND"
+func (c *Clamd) Scan(ctx context.Context, r io.Reader) (Result, error) {
+	log := logger.FromContext(ctx)
+
+	ctx, cancel := context.WithTimeout(ctx, c.timeout)
+	defer cancel()
+	var dialer net.Dialer
+	conn, err := dialer.DialContext(ctx, c.network, c.address)
+	if err != nil {
+		log.Errorf("Failed to connect clamd, addr: %s, err: %v", c.address, err)
+		return Result{}, fmt.Errorf("connect clamd failed: %w", err)
+	}
+	defer conn.Close()
+	if deadline, ok := ctx.Deadline(); ok {
+		conn.SetDeadline(deadline)
+	}
+
+	if _, err = conn.Write([]byte("zINSTREAM\x00")); err != nil {
+		return Result{}, fmt.Errorf("send command failed: %w", err)
+	}
+	buf := make([]byte, 4+clamdChunkSize)
+	for {
+		n, err := io.ReadFull(r, buf[4:])
+		if n > 0 {
+			binary.BigEndian.PutUint32(buf[:4], uint32(n))
+			if _, werr := conn.Write(buf[:4+n]); werr != nil {
+				return Result{}, fmt.Errorf("send chunk failed: %w", werr)
+			}
+		}
+		if err == io.EOF || err == io.ErrUnexpectedEOF {
+			break
+		}
+		if err != nil {
+			return Result{}, fmt.Errorf("read file failed: %w", err)
+		}
+	}
+	// 长度为 0 的块表示内容结束
+	if _, err = conn.Write([]byte{0, 0, 0, 0}); err != nil {
+		return Result{}, fmt.Errorf("send chunk failed: %w", err)
+	}
+
+	reply, err := bufio.NewReader(conn).ReadBytes(0)
+	if err != nil && !(err == io.EOF && len(reply) > 0) {
+		return Result{}, fmt.Errorf("read reply failed: %w", err)
+	}
+	return parseClamdReply(string(bytes.TrimRight(reply, "\x00\n")))
+}
+
+func parseClamdReply(reply string) (Result, error) {
+	status, ok := strings.CutPrefix(reply, "stream: ")
+	if !ok {
+		return Result{}, fmt.Errorf("unexpected clamd reply: %s", reply)
+	}
+	switch {
+	case status == "OK":
+		return Result{}, nil
+	case strings.HasSuffix(status, " FOUND"):
+		return Result{Infected: true, Reason: strings.TrimSuffix(status, " FOUND")}, nil
+	default:
+		return Result{}, fmt.Errorf("clamd scan failed: %s", status)
+	}
+}
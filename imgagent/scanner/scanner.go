@@ -0,0 +1,43 @@
+// Package scanner 上传文件的病毒扫描。Provider 屏蔽不同扫描服务的差异，原文保存为临时文件后、
+// 拆分章节和上传到百炼前扫描，未通过的文件直接拒绝。未配置扫描服务时不扫描。
+package scanner
+
+import (
+	"context"
+	"fmt"
+	"io"
+)
+
+// 可选的扫描服务
+const (
+	ProviderClamd = "clamd"
+)
+
+// Result 扫描结果，Infected 为 true 时 Reason 为命中的特征名称
+type Result struct {
+	Infected bool
+	Reason   string
+}
+
+// Provider 文件扫描服务
+type Provider interface {
+	Scan(ctx context.Context, r io.Reader) (Result, error)
+}
+
+// Config 文件扫描配置
+type Config struct {
+	Provider string      `json:"provider"` // clamd，为空时不扫描
+	Clamd    ClamdConfig `json:"clamd"`
+}
+
+// New 按配置创建扫描服务，未配置时返回 nil
+func New(conf Config) (Provider, error) {
+	switch conf.Provider {
+	case "":
+		return nil, nil
+	case ProviderClamd:
+		return NewClamd(conf.Clamd)
+	default:
+		return nil, fmt.Errorf("unknown scanner provider: %s", conf.Provider)
+	}
+}
@@ -0,0 +1,100 @@
+package scanner
+
+import (
+	"bufio"
+	"context"
+	"encoding/binary"
+	"io"
+	"net"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestNew(t *testing.T) {
+	p, err := New(Config{})
+	require.NoError(t, err)
+	assert.Nil(t, p)
+
+	p, err = New(Config{Provider: ProviderClamd, Clamd: ClamdConfig{Addr: "unix:///var/run/clamav/clamd.ctl"}})
+	require.NoError(t, err)
+	assert.Equal(t, "unix", p.(*Clamd).network)
+	assert.Equal(t, "/var/run/clamav/clamd.ctl", p.(*Clamd).address)
+
+	_, err = New(Config{Provider: ProviderClamd})
+	require.Error(t, err)
+	_, err = New(Config{Provider: "unknown"})
+	require.Error(t, err)
+}
+
+// serveClamd 模拟 clamd 的 INSTREAM 命令，收到的内容交给 reply 生成回复
+func serveClamd(t *testing.T, reply func(content string) string) string {
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	require.NoError(t, err)
+	t.Cleanup(func() { ln.Close() })
+	go func() {
+		for {
+			conn, err := ln.Accept()
+			if err != nil {
+				return
+			}
+			go func() {
+				defer conn.Close()
+				r := bufio.NewReader(conn)
+				cmd, err := r.ReadString(0)
+				if err != nil || cmd != "zINSTREAM\x00" {
+					return
+				}
+				var content strings.Builder
+				for {
+					var size uint32
+					if err := binary.Read(r, binary.BigEndian, &size); err != nil {
+						return
+					}
+					if size == 0 {
+						break
+					}
+					if _, err := io.CopyN(&content, r, int64(size)); err != nil {
+						return
+					}
+				}
+				conn.Write([]byte(reply(content.String()) + "\x00"))
+			}()
+		}
+	}()
+	return ln.Addr().String()
+}
+
+func TestClamd(t *testing.T) {
+	addr := serveClamd(t, func(content string) string {
+		switch {
+		case strings.Contains(content, "EICAR"):
+			return "stream: Eicar-Test-Signature FOUND"
+		case strings.HasPrefix(content, "huge"):
+			return "INSTREAM size limit exceeded. ERROR"
+		}
+		return "stream: OK"
+	})
+	c, err := NewClamd(ClamdConfig{Addr: "tcp://" + addr})
+	require.NoError(t, err)
+	ctx := context.Background()
+
+	// 超过一个块的内容分块发送
+	ret, err := c.Scan(ctx, strings.NewReader(strings.Repeat("祥子拉车。", clamdChunkSize/5)+"EICAR"))
+	require.NoError(t, err)
+	assert.True(t, ret.Infected)
+	assert.Equal(t, "Eicar-Test-Signature", ret.Reason)
+
+	ret, err = c.Scan(ctx, strings.NewReader("第一章"))
+	require.NoError(t, err)
+	assert.False(t, ret.Infected)
+
+	ret, err = c.Scan(ctx, strings.NewReader(""))
+	require.NoError(t, err)
+	assert.False(t, ret.Infected)
+
+	_, err = c.Scan(ctx, strings.NewReader("huge"))
+	assert.ErrorContains(t, err, "size limit exceeded")
+}
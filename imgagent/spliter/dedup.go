@@ -0,0 +1,56 @@
+package spliter
+
+import (
+	"crypto/sha256"
+	"strings"
+	"unicode"
+	"unicode/utf8"
+)
+
+// DuplicateChapter 与前文内容相同的章节，序号为去重前的分割结果中的位置，从 0 开始
+type DuplicateChapter struct {
+	Index      int    // 重复章节的序号
+	FirstIndex int    // 首次出现的章节序号
+	Title      string // 章节第一行
+}
+
+// chunkHash 去掉所有空白后计算内容哈希，排版差异不影响判断
+func chunkHash(chunk string) [sha256.Size]byte {
+	normalized := strings.Map(func(r rune) rune {
+		if unicode.IsSpace(r) {
+			return -1
+		}
+		return r
+	}, chunk)
+	return sha256.Sum256([]byte(normalized))
+}
+
+// dedupChunks 检测与前文内容相同的章节，remove 为 true 时删除重复章节只保留首次出现的
+func dedupChunks(chunks []string, remove bool) ([]string, []DuplicateChapter) {
+	first := make(map[[sha256.Size]byte]int, len(chunks))
+	var dups []DuplicateChapter
+	kept := chunks[:0:0]
+	for i, chunk := range chunks {
+		h := chunkHash(chunk)
+		if j, ok := first[h]; ok {
+			dups = append(dups, DuplicateChapter{Index: i, FirstIndex: j, Title: chunkTitle(chunk)})
+			if remove {
+				continue
+			}
+		} else {
+			first[h] = i
+		}
+		kept = append(kept, chunk)
+	}
+	return kept, dups
+}
+
+// chunkTitle 章节第一行，不超过 maxTitleRunes 字
+func chunkTitle(chunk string) string {
+	title, _, _ := strings.Cut(strings.TrimSpace(chunk), "\n")
+	title = strings.TrimSpace(title)
+	if utf8.RuneCountInString(title) > maxTitleRunes {
+		title = string([]rune(title)[:maxTitleRunes])
+	}
+	return title
+}
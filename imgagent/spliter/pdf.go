@@ -0,0 +1,373 @@
+package spliter
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"math"
+	"regexp"
+	"sort"
+	"strings"
+	"unicode"
+	"unicode/utf8"
+
+	"github.com/ledongthuc/pdf"
+)
+
+// FootnoteMode PDF 脚注的处理方式
+type FootnoteMode string
+
+const (
+	FootnotesDrop   FootnoteMode = "drop"   // 删除脚注和正文中的脚注标号
+	FootnotesAppend FootnoteMode = "append" // 脚注按页顺序移到全文末尾
+)
+
+const (
+	// footnoteSizeRatio 字号小于正文字号该比例的文字视为脚注或脚注标号
+	footnoteSizeRatio = 0.85
+	// minColumnLines 每栏至少的行数，避免把单栏中偶尔缩进的短行识别成分栏
+	minColumnLines  = 3
+	defaultFontSize = 10
+)
+
+var pageNumberPattern = regexp.MustCompile(`(?i)^[-—\s]*(\d+|[ivxlc]+|第\s*\d+\s*页)[-—\s]*$`)
+
+// pdfLine 版面分析得到的一行文字，坐标单位为点，y 向上增大
+type pdfLine struct {
+	x0, x1 float64
+	y      float64
+	size   float64
+	text   string
+}
+
+// pdfBlock 阅读顺序中连续的一组行：分栏中的一栏，或跨栏的一行（标题等）。
+// right 为块的右边界，用于判断段落最后一行
+type pdfBlock struct {
+	lines []pdfLine
+	right float64
+}
+
+// readPDF 按版面提取 PDF 文本：识别分栏后按先左栏后右栏的阅读顺序输出，
+// 去掉页码，脚注按 footnotes 删除或移到全文末尾，行按段落合并
+func readPDF(filename string, footnotes FootnoteMode) (string, error) {
+	f, r, err := pdf.Open(filename)
+	if err != nil {
+		return "", err
+	}
+	defer f.Close()
+
+	var body strings.Builder
+	var notes []string
+	for i := 1; i <= r.NumPage(); i++ {
+		p := r.Page(i)
+		if p.V.IsNull() {
+			continue
+		}
+		texts, err := pageTexts(p)
+		if err != nil {
+			return "", fmt.Errorf("page %d: %w", i, err)
+		}
+		text, note := layoutPage(texts, footnotes)
+		if text != "" {
+			body.WriteString(text)
+			body.WriteString("\n")
+		}
+		if note != "" {
+			notes = append(notes, note)
+		}
+	}
+	if footnotes == FootnotesAppend && len(notes) > 0 {
+		body.WriteString("\n")
+		body.WriteString(strings.Join(notes, "\n"))
+	}
+	return body.String(), nil
+}
+
+// pageTexts 获取页面上每个字符的位置，解析出错时 pdf 库会 panic
+func pageTexts(p pdf.Page) (texts []pdf.Text, err error) {
+	defer func() {
+		if r := recover(); r != nil {
+			err = fmt.Errorf("parse page content failed: %v", r)
+		}
+	}()
+	return p.Content().Text, nil
+}
+
+// readPDFPlainText 按内容流顺序提取文本，多栏时各栏文字可能交错
+func readPDFPlainText(filename string) (string, error) {
+	f, r, err := pdf.Open(filename)
+	if err != nil {
+		return "", err
+	}
+	defer f.Close()
+	var buf bytes.Buffer
+	// 获取 pdf 文本数据
+	pt, err := r.GetPlainText()
+	if err != nil {
+		return "", err
+	}
+	if _, err := io.Copy(&buf, pt); err != nil {
+		return "", err
+	}
+	return buf.String(), nil
+}
+
+// layoutPage 返回页面按阅读顺序的正文和脚注
+func layoutPage(texts []pdf.Text, footnotes FootnoteMode) (string, string) {
+	lines := groupLines(texts, footnotes != FootnotesAppend)
+	if len(lines) == 0 {
+		return "", ""
+	}
+	bodySize := bodyFontSize(lines)
+
+	// 页面最上、最下只有页码的行直接丢弃
+	if pageNumberPattern.MatchString(lines[len(lines)-1].text) {
+		lines = lines[:len(lines)-1]
+	}
+	if len(lines) > 0 && pageNumberPattern.MatchString(lines[0].text) {
+		lines = lines[1:]
+	}
+
+	// 正文最低一行以下、字号明显小于正文的行为脚注
+	minBodyY := math.Inf(1)
+	for _, l := range lines {
+		if l.size >= bodySize*footnoteSizeRatio {
+			minBodyY = min(minBodyY, l.y)
+		}
+	}
+	var body, notes []pdfLine
+	for _, l := range lines {
+		if l.y < minBodyY && l.size < bodySize*footnoteSizeRatio {
+			notes = append(notes, l)
+		} else {
+			body = append(body, l)
+		}
+	}
+
+	note := ""
+	if len(notes) > 0 {
+		note = joinBlocks([]pdfBlock{{lines: notes, right: maxRight(notes)}}, bodySize)
+	}
+	return joinBlocks(readingOrder(body, maxRight(body), bodySize), bodySize), note
+}
+
+// groupLines 将字符按基线分行，同一基线上间距过大的字符分属不同栏；
+// dropMarkers 时去掉字号较小且上移的脚注标号
+func groupLines(texts []pdf.Text, dropMarkers bool) []pdfLine {
+	glyphs := make([]pdf.Text, 0, len(texts))
+	for _, t := range texts {
+		if t.S == "" {
+			continue
+		}
+		t.FontSize = math.Abs(t.FontSize)
+		if t.FontSize == 0 {
+			t.FontSize = defaultFontSize
+		}
+		glyphs = append(glyphs, t)
+	}
+	sort.SliceStable(glyphs, func(i, j int) bool {
+		return glyphs[i].Y > glyphs[j].Y
+	})
+
+	var lines []pdfLine
+	for start := 0; start < len(glyphs); {
+		// 上标和正文基线相差不到半个字号，属于同一行
+		end := start + 1
+		for end < len(glyphs) && glyphs[start].Y-glyphs[end].Y <= glyphs[start].FontSize*0.6 {
+			end++
+		}
+		lines = append(lines, splitRow(glyphs[start:end], dropMarkers)...)
+		start = end
+	}
+	return lines
+}
+
+// splitRow 按水平间距把同一基线上的字符拆成多行
+func splitRow(row []pdf.Text, dropMarkers bool) []pdfLine {
+	base := row[0]
+	for _, t := range row {
+		if t.FontSize > base.FontSize {
+			base = t
+		}
+	}
+	size := base.FontSize
+
+	var glyphs []pdf.Text
+	for _, t := range row {
+		if dropMarkers && t.FontSize < size*footnoteSizeRatio && t.Y > base.Y+size*0.15 {
+			continue
+		}
+		glyphs = append(glyphs, t)
+	}
+	sort.SliceStable(glyphs, func(i, j int) bool {
+		return glyphs[i].X < glyphs[j].X
+	})
+
+	var lines []pdfLine
+	var b strings.Builder
+	cur := pdfLine{y: base.Y, size: size}
+	flush := func() {
+		cur.text = strings.TrimSpace(b.String())
+		if cur.text != "" {
+			lines = append(lines, cur)
+		}
+		b.Reset()
+	}
+	for i, t := range glyphs {
+		if i == 0 {
+			cur.x0 = t.X
+		} else {
+			gap := t.X - cur.x1
+			if gap > size*1.5 {
+				flush()
+				cur = pdfLine{x0: t.X, y: base.Y, size: size}
+			} else if gap > size*0.25 && !strings.HasSuffix(b.String(), " ") && t.S != " " {
+				b.WriteString(" ")
+			}
+		}
+		b.WriteString(t.S)
+		cur.x1 = t.X + glyphWidth(t)
+	}
+	flush()
+	return lines
+}
+
+func glyphWidth(t pdf.Text) float64 {
+	if t.W > 0 {
+		return t.W
+	}
+	r, _ := utf8.DecodeRuneInString(t.S)
+	if unicode.Is(unicode.Han, r) {
+		return t.FontSize
+	}
+	return t.FontSize * 0.5
+}
+
+// bodyFontSize 按字数加权取出现最多的字号作为正文字号
+func bodyFontSize(lines []pdfLine) float64 {
+	weights := make(map[float64]int)
+	for _, l := range lines {
+		weights[math.Round(l.size*2)/2] += utf8.RuneCountInString(l.text)
+	}
+	size, weight := float64(defaultFontSize), 0
+	for s, w := range weights {
+		if w > weight || (w == weight && s > size) {
+			size, weight = s, w
+		}
+	}
+	return size
+}
+
+// readingOrder 识别分栏并返回阅读顺序的块：跨栏的行把页面分成上下几段，每段内先左栏后右栏，
+// 每栏再递归识别更多分栏
+func readingOrder(lines []pdfLine, right float64, size float64) []pdfBlock {
+	if len(lines) == 0 {
+		return nil
+	}
+	gutter, ok := findGutter(lines, size)
+	if !ok {
+		return []pdfBlock{{lines: lines, right: maxRight(lines)}}
+	}
+
+	var blocks []pdfBlock
+	var left, rightCol []pdfLine
+	flush := func() {
+		blocks = append(blocks, readingOrder(left, gutter, size)...)
+		blocks = append(blocks, readingOrder(rightCol, right, size)...)
+		left, rightCol = nil, nil
+	}
+	for _, l := range lines {
+		switch {
+		case l.x1 <= gutter:
+			left = append(left, l)
+		case l.x0 >= gutter:
+			rightCol = append(rightCol, l)
+		default:
+			flush()
+			// 跨栏的行按整个区域的右边界判断是否为短行，标题总是单独成段
+			blocks = append(blocks, pdfBlock{lines: []pdfLine{l}, right: right})
+		}
+	}
+	flush()
+	return blocks
+}
+
+// findGutter 在区域中间一半的范围内寻找穿过行数最少的竖线作为栏间距，
+// 穿过的行只能是少数跨栏标题（不超过分栏行数的四分之一），否则视为单栏
+func findGutter(lines []pdfLine, size float64) (float64, bool) {
+	if len(lines) < 2*minColumnLines {
+		return 0, false
+	}
+	minX, maxX := math.Inf(1), math.Inf(-1)
+	for _, l := range lines {
+		minX = min(minX, l.x0)
+		maxX = max(maxX, l.x1)
+	}
+	width := maxX - minX
+	if width < size*10 {
+		return 0, false
+	}
+
+	best, bestCross := 0.0, len(lines)+1
+	for x := minX + width*0.25; x <= minX+width*0.75; x += size / 2 {
+		cross, left, right := 0, 0, 0
+		for _, l := range lines {
+			switch {
+			case l.x1 <= x:
+				left++
+			case l.x0 >= x:
+				right++
+			default:
+				cross++
+			}
+		}
+		if left >= minColumnLines && right >= minColumnLines && cross < bestCross {
+			best, bestCross = x, cross
+		}
+	}
+	if bestCross > len(lines) || bestCross*4 > len(lines)-bestCross {
+		return 0, false
+	}
+	return best, true
+}
+
+func maxRight(lines []pdfLine) float64 {
+	right := math.Inf(-1)
+	for _, l := range lines {
+		right = max(right, l.x1)
+	}
+	return right
+}
+
+// joinBlocks 将行合并为段落：上一行明显短于块宽、行距过大或本行缩进时另起一段，
+// 段内中文直接相连，西文单词之间补空格
+func joinBlocks(blocks []pdfBlock, size float64) string {
+	var b strings.Builder
+	var prev *pdfLine
+	var prevRight float64
+	for _, block := range blocks {
+		for i := range block.lines {
+			l := &block.lines[i]
+			if prev != nil {
+				gap := prev.y - l.y
+				switch {
+				case prev.x1 < prevRight-size*2, gap > size*1.8, l.x0 > prev.x0+size*1.5 && gap > 0:
+					b.WriteString("\n")
+				default:
+					last, _ := utf8.DecodeLastRuneInString(prev.text)
+					first, _ := utf8.DecodeRuneInString(l.text)
+					if isLatin(last) && isLatin(first) {
+						b.WriteString(" ")
+					}
+				}
+			}
+			b.WriteString(l.text)
+			prev, prevRight = l, block.right
+		}
+	}
+	return b.String()
+}
+
+func isLatin(r rune) bool {
+	return r < unicode.MaxLatin1 && (unicode.IsLetter(r) || unicode.IsDigit(r) || unicode.IsPunct(r))
+}
@@ -0,0 +1,186 @@
+package spliter
+
+import (
+	"bytes"
+	"fmt"
+	"strings"
+	"unicode"
+	"unicode/utf8"
+)
+
+const (
+	// ShortChapterRunes 字数少于该值的章节多为目录、误识别的章节标题或缺失正文
+	ShortChapterRunes = 200
+	// longParagraphRunes 超过该字数的段落通常是原文换行丢失，场景拆分效果差
+	longParagraphRunes = 2000
+	// nonTextWarnRatio 非文本字符占比超过该值时提示原文可能是乱码或二进制内容
+	nonTextWarnRatio = 0.01
+	// maxTitleRunes 章节第一行不超过该字数时才视为标题
+	maxTitleRunes = 30
+	// maxReportItems 报告中列出的章节序号、标题数上限
+	maxReportItems = 100
+)
+
+// 检测到的原文编码
+const (
+	EncodingUTF8    = "utf-8"
+	EncodingUTF8BOM = "utf-8-bom"
+	EncodingUTF16LE = "utf-16le"
+	EncodingUTF16BE = "utf-16be"
+	EncodingGBK     = "gbk"
+	EncodingUnknown = "unknown"
+)
+
+// Report 原文统计和质量检查结果，帮助用户在生成前修正源文件
+type Report struct {
+	Encoding         string   // 原文编码，doc/docx/pdf 由解析库解码，固定为 utf-8
+	Chars            int      // 清洗后的字数
+	Paragraphs       int      // 非空段落数
+	Chapters         int      // 分割后的章节数
+	NonTextRatio     float64  // 控制字符、替换符、私用区字符的占比
+	ShortChapters    []int    // 字数少于 ShortChapterRunes 的章节序号，从 0 开始
+	LongParagraphs   int      // 字数超过 longParagraphRunes 的段落数
+	LongestParagraph int      // 最长段落的字数
+	DuplicateTitles  []string // 出现多次的章节标题
+	Warnings         []string // 面向用户的问题描述
+
+	DuplicateChapters []DuplicateChapter // 与前文内容相同的章节
+	DuplicatesRemoved bool               // 重复章节是否已删除，删除后 Chapters、ShortChapters 等统计不包含重复章节
+
+	NearDuplicateChapters []NearDuplicateChapter // 与前文内容高度相似的章节，不会自动删除
+}
+
+// detectEncoding 根据 BOM 和字节特征检测文本编码，无法识别为 UTF-8 时按 GBK 双字节特征判断
+func detectEncoding(data []byte) string {
+	switch {
+	case bytes.HasPrefix(data, []byte{0xEF, 0xBB, 0xBF}):
+		return EncodingUTF8BOM
+	case bytes.HasPrefix(data, []byte{0xFF, 0xFE}):
+		return EncodingUTF16LE
+	case bytes.HasPrefix(data, []byte{0xFE, 0xFF}):
+		return EncodingUTF16BE
+	case utf8.Valid(data):
+		return EncodingUTF8
+	}
+	if looksLikeGBK(data) {
+		return EncodingGBK
+	}
+	return EncodingUnknown
+}
+
+// looksLikeGBK 所有非 ASCII 字节都能组成 GBK 双字节字符（首字节 0x81-0xFE，尾字节 0x40-0xFE 且不为 0x7F）
+func looksLikeGBK(data []byte) bool {
+	for i := 0; i < len(data); i++ {
+		b := data[i]
+		if b < 0x80 {
+			continue
+		}
+		if b == 0x80 || b == 0xFF || i+1 >= len(data) {
+			return false
+		}
+		t := data[i+1]
+		if t < 0x40 || t == 0x7F || t == 0xFF {
+			return false
+		}
+		i++
+	}
+	return true
+}
+
+func isNonText(r rune) bool {
+	if r == '\n' || r == '\r' || r == '\t' {
+		return false
+	}
+	return r == utf8.RuneError || unicode.IsControl(r) || unicode.Is(unicode.Co, r)
+}
+
+// buildReport 统计清洗后的原文 content 和分割得到的章节 chunks（合并换行前）
+func buildReport(encoding, content string, chunks []string) *Report {
+	r := &Report{Encoding: encoding, Chapters: len(chunks)}
+
+	nonText := 0
+	for _, c := range content {
+		r.Chars++
+		if isNonText(c) {
+			nonText++
+		}
+	}
+	if r.Chars > 0 {
+		r.NonTextRatio = float64(nonText) / float64(r.Chars)
+	}
+
+	for _, line := range strings.Split(content, "\n") {
+		n := utf8.RuneCountInString(strings.TrimSpace(line))
+		if n == 0 {
+			continue
+		}
+		r.Paragraphs++
+		r.LongestParagraph = max(r.LongestParagraph, n)
+		if n > longParagraphRunes {
+			r.LongParagraphs++
+		}
+	}
+
+	titles := make(map[string]int)
+	var order []string
+	for i, chunk := range chunks {
+		chunk = strings.TrimSpace(chunk)
+		if len(chunks) > 1 && utf8.RuneCountInString(chunk) < ShortChapterRunes && len(r.ShortChapters) < maxReportItems {
+			r.ShortChapters = append(r.ShortChapters, i)
+		}
+		title, _, _ := strings.Cut(chunk, "\n")
+		title = strings.TrimSpace(title)
+		if title == "" || utf8.RuneCountInString(title) > maxTitleRunes {
+			continue
+		}
+		if titles[title] == 0 {
+			order = append(order, title)
+		}
+		titles[title]++
+	}
+	for _, title := range order {
+		if titles[title] > 1 && len(r.DuplicateTitles) < maxReportItems {
+			r.DuplicateTitles = append(r.DuplicateTitles, title)
+		}
+	}
+
+	if r.Encoding != EncodingUTF8 && r.Encoding != EncodingUTF8BOM {
+		r.Warnings = append(r.Warnings, fmt.Sprintf("原文编码为 %s，不是 UTF-8，内容可能是乱码，请转换为 UTF-8 后重新上传", r.Encoding))
+	}
+	if r.NonTextRatio > nonTextWarnRatio {
+		r.Warnings = append(r.Warnings, fmt.Sprintf("非文本字符占比 %.1f%%，原文可能损坏或包含二进制内容", r.NonTextRatio*100))
+	}
+	if len(r.ShortChapters) > 0 {
+		r.Warnings = append(r.Warnings, fmt.Sprintf("%d 个章节少于 %d 字，可能是目录或误识别的章节标题", len(r.ShortChapters), ShortChapterRunes))
+	}
+	if r.LongParagraphs > 0 {
+		r.Warnings = append(r.Warnings, fmt.Sprintf("%d 个段落超过 %d 字，原文可能丢失了换行", r.LongParagraphs, longParagraphRunes))
+	}
+	if len(r.DuplicateTitles) > 0 {
+		r.Warnings = append(r.Warnings, fmt.Sprintf("%d 个章节标题重复出现，原文可能有重复章节", len(r.DuplicateTitles)))
+	}
+	return r
+}
+
+// addDuplicates 将检测到的重复章节加入报告
+func addDuplicates(r *Report, dups []DuplicateChapter, removed bool) {
+	if len(dups) == 0 {
+		return
+	}
+	r.DuplicateChapters = dups[:min(len(dups), maxReportItems)]
+	r.DuplicatesRemoved = removed
+	if removed {
+		r.Warnings = append(r.Warnings, fmt.Sprintf("%d 个章节与前文内容重复，已自动删除", len(dups)))
+	} else {
+		r.Warnings = append(r.Warnings, fmt.Sprintf("%d 个章节与前文内容重复，请删除重复章节后重新上传", len(dups)))
+	}
+}
+
+// addNearDuplicates 将检测到的高度相似章节加入报告
+func addNearDuplicates(r *Report, dups []NearDuplicateChapter) {
+	if len(dups) == 0 {
+		return
+	}
+	r.NearDuplicateChapters = dups[:min(len(dups), maxReportItems)]
+	r.Warnings = append(r.Warnings, fmt.Sprintf("%d 个章节与前文内容高度相似，可能是重复章节，可在上传后查看并删除", len(dups)))
+}
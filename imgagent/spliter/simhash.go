@@ -0,0 +1,98 @@
+package spliter
+
+import (
+	"crypto/sha256"
+	"hash/fnv"
+	"math/bits"
+	"unicode"
+)
+
+const (
+	// nearDupMaxDistance simhash 海明距离不超过该值的章节视为高度相似
+	nearDupMaxDistance = 3
+	// nearDupMinRunes 去掉空白和标点后少于该字数的章节特征太少，不做相似检测
+	nearDupMinRunes = 100
+	// shingleRunes 计算 simhash 时每个特征包含的连续字数
+	shingleRunes = 3
+)
+
+// NearDuplicateChapter 与前文内容高度相似但不完全相同的章节，序号为去重后分割结果中的位置，即章节序号
+type NearDuplicateChapter struct {
+	Index      int    // 相似章节的序号
+	FirstIndex int    // 最相似的前文章节序号
+	Title      string // 章节第一行
+	Distance   int    // 两个章节 simhash 的海明距离
+}
+
+// Similarity 按海明距离换算的相似度，1 表示 simhash 相同
+func (d NearDuplicateChapter) Similarity() float64 {
+	return 1 - float64(d.Distance)/64
+}
+
+// simhash 以连续 shingleRunes 个字为特征计算 64 位 simhash，忽略空白和标点，返回参与计算的字数
+func simhash(text string) (uint64, int) {
+	runes := make([]rune, 0, len(text))
+	for _, r := range text {
+		if unicode.IsSpace(r) || unicode.IsPunct(r) || unicode.IsSymbol(r) {
+			continue
+		}
+		runes = append(runes, r)
+	}
+
+	var weights [64]int
+	h := fnv.New64a()
+	for i := 0; i+shingleRunes <= len(runes); i++ {
+		h.Reset()
+		h.Write([]byte(string(runes[i : i+shingleRunes])))
+		v := h.Sum64()
+		for b := range weights {
+			if v&(1<<b) != 0 {
+				weights[b]++
+			} else {
+				weights[b]--
+			}
+		}
+	}
+	var ret uint64
+	for b, w := range weights {
+		if w > 0 {
+			ret |= 1 << b
+		}
+	}
+	return ret, len(runes)
+}
+
+// findNearDuplicates 检测与前文高度相似的章节。内容相同的章节已由 dedupChunks 列出，不重复报告
+func findNearDuplicates(chunks []string) []NearDuplicateChapter {
+	type fingerprint struct {
+		index int
+		hash  uint64
+	}
+	seen := make(map[[sha256.Size]byte]bool, len(chunks))
+	var prev []fingerprint
+	var dups []NearDuplicateChapter
+	for i, chunk := range chunks {
+		h := chunkHash(chunk)
+		if seen[h] {
+			continue
+		}
+		seen[h] = true
+		hash, n := simhash(chunk)
+		if n < nearDupMinRunes {
+			continue
+		}
+
+		best := -1
+		distance := nearDupMaxDistance + 1
+		for _, p := range prev {
+			if d := bits.OnesCount64(hash ^ p.hash); d < distance {
+				best, distance = p.index, d
+			}
+		}
+		if best >= 0 {
+			dups = append(dups, NearDuplicateChapter{Index: i, FirstIndex: best, Title: chunkTitle(chunk), Distance: distance})
+		}
+		prev = append(prev, fingerprint{index: i, hash: hash})
+	}
+	return dups
+}
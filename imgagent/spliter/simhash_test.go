@@ -0,0 +1,61 @@
+package spliter
+
+import (
+	"context"
+	"fmt"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+// novelText 生成内容各不相同的章节正文
+func novelText(seed int) string {
+	subjects := []string{"老人", "少年", "掌柜", "书生", "船夫", "猎户", "姑娘"}
+	verbs := []string{"走进", "望着", "离开", "守着", "想起", "路过", "打量"}
+	places := []string{"山顶的寺庙", "湖边的小屋", "热闹的集市", "荒凉的驿站", "城外的渡口", "幽深的竹林", "破旧的祠堂"}
+	var b strings.Builder
+	for i := range 40 {
+		n := seed*31 + i*7
+		fmt.Fprintf(&b, "%s%s%s，第%d次。", subjects[n%7], verbs[(n/7)%7], places[(n/3+seed)%7], n)
+	}
+	return b.String()
+}
+
+func TestFindNearDuplicates(t *testing.T) {
+	t.Parallel()
+
+	chapter1 := "第一章 开始\n" + novelText(1)
+	chapter2 := "第二章 渡口\n" + novelText(2)
+	// 只改动一句话的重复章节
+	edited := strings.Replace(chapter1, "，第31次。", "，第三十一次。", 1)
+	require.NotEqual(t, chapter1, edited)
+
+	dups := findNearDuplicates([]string{chapter1, chapter2, edited, chapter1, "第四章 目录"})
+	require.Len(t, dups, 1)
+	require.Equal(t, 2, dups[0].Index)
+	require.Equal(t, 0, dups[0].FirstIndex)
+	require.Equal(t, "第一章 开始", dups[0].Title)
+	require.LessOrEqual(t, dups[0].Distance, nearDupMaxDistance)
+	require.Greater(t, dups[0].Similarity(), 0.95)
+}
+
+func TestSplitNearDuplicates(t *testing.T) {
+	t.Parallel()
+	ctx := context.Background()
+
+	chapter1 := "第一章 开始\n" + novelText(1)
+	chapter2 := "第二章 渡口\n" + novelText(2)
+	edited := strings.Replace(chapter1, "第一章 开始", "第三章 开始", 1)
+	file := writeTempFile(t, t.TempDir(), "near.txt", chapter1+"\n\n"+chapter2+"\n\n"+edited)
+
+	chunks, report, err := SplitWithReport(ctx, file, Option{ChunkSize: 5000, Dedup: true})
+	require.NoError(t, err)
+	require.Len(t, chunks, 3)
+	require.Empty(t, report.DuplicateChapters)
+	require.Len(t, report.NearDuplicateChapters, 1)
+	require.Equal(t, 2, report.NearDuplicateChapters[0].Index)
+	require.Equal(t, 0, report.NearDuplicateChapters[0].FirstIndex)
+	require.Equal(t, "第三章 开始", report.NearDuplicateChapters[0].Title)
+	require.Contains(t, report.Warnings, "1 个章节与前文内容高度相似，可能是重复章节，可在上传后查看并删除")
+}
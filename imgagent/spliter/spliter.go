@@ -1,10 +1,8 @@
 package spliter
 
 import (
-	"bytes"
 	"context"
 	"errors"
-	"io"
 	"os"
 	"path/filepath"
 	"regexp"
@@ -13,26 +11,46 @@ import (
 	"unicode/utf8"
 
 	worddoc "baliance.com/gooxml/document"
-	"github.com/ledongthuc/pdf"
 	"github.com/tmc/langchaingo/textsplitter"
 
 	"imgagent/pkg/logger"
 )
 
+// 分隔符级别中的特殊值，其余值按字面分隔符处理
+const (
+	SeparatorChapter  = "<chapter>"  // 按章节标题（第X章、第X回、Chapter N 等）分割
+	SeparatorSentence = "<sentence>" // 按句末标点分割，标点保留在句子末尾
+)
+
+// DefaultSeparators 默认的分隔符级别：章节标题 → 空行 → 换行 → 句子
+var DefaultSeparators = []string{SeparatorChapter, "\n\n", "\n", SeparatorSentence}
+
 type Option struct {
 	ChunkSize    int
 	ChunkOverlap int
-	Separator    string
+	// Separators 按顺序逐级使用的分隔符，为空时使用 DefaultSeparators。
+	// 第一个能分割原文的级别决定块的边界，超过 ChunkSize 的块再用后续级别递归分割，并合并成不超过 ChunkSize 的块
+	Separators []string
+	Clean      func(string) string // 分割前清洗原文，为 nil 时不处理
+	Footnotes  FootnoteMode        // PDF 脚注的处理方式，默认删除
+	Dedup      bool                // 删除与前文内容相同的重复章节，为 false 时只在报告中列出
 }
 
 func Split(ctx context.Context, filename string, opt Option) ([]string, error) {
+	texts, _, err := SplitWithReport(ctx, filename, opt)
+	return texts, err
+}
+
+// SplitWithReport 分割文本，同时返回原文统计和质量检查报告
+func SplitWithReport(ctx context.Context, filename string, opt Option) ([]string, *Report, error) {
 	var content string
+	encoding := EncodingUTF8
 
 	start := time.Now()
 	log := logger.FromContext(ctx)
-	separators := []string{"\n\n", "\n", " ", ""}
-	if opt.Separator == "\n" {
-		separators = []string{"\n", " ", ""}
+	separators := opt.Separators
+	if len(separators) == 0 {
+		separators = DefaultSeparators
 	}
 
 	ext := filepath.Ext(filename)
@@ -40,13 +58,14 @@ func Split(ctx context.Context, filename string, opt Option) ([]string, error) {
 	case ".txt", ".md":
 		bytes, err := os.ReadFile(filename)
 		if err != nil {
-			return nil, err
+			return nil, nil, err
 		}
+		encoding = detectEncoding(bytes)
 		content = string(bytes)
 	case ".doc", ".docx":
 		d, err := worddoc.Open(filename)
 		if err != nil {
-			return nil, err
+			return nil, nil, err
 		}
 		for _, para := range d.Paragraphs() {
 			for _, run := range para.Runs() {
@@ -55,56 +74,53 @@ func Split(ctx context.Context, filename string, opt Option) ([]string, error) {
 			content += "\n"
 		}
 	case ".pdf":
-		f, r, err := pdf.Open(filename)
-		if err != nil {
-			return nil, err
-		}
-		defer f.Close()
-		var buf bytes.Buffer
-		// 获取 pdf 文本数据
-		pt, err := r.GetPlainText()
-		if err != nil {
-			return nil, err
-		}
-		if _, err := io.Copy(&buf, pt); err != nil {
-			return nil, err
+		var err error
+		content, err = readPDF(filename, opt.Footnotes)
+		if err != nil || strings.TrimSpace(content) == "" {
+			// 版面分析失败时退回按内容流顺序提取
+			log.Warnf("Failed to read pdf by layout, fallback to plain text, err: %v", err)
+			content, err = readPDFPlainText(filename)
+			if err != nil {
+				return nil, nil, err
+			}
 		}
-		content = buf.String()
 	default:
-		return nil, errors.New("unknown file ext")
+		return nil, nil, errors.New("unknown file ext")
+	}
+	if opt.Clean != nil {
+		content = opt.Clean(content)
 	}
 	if content == "" {
-		return nil, errors.New("empty content")
+		return nil, nil, errors.New("empty content")
 	}
 
-	// 3. 创建文本分割器
-	var err error
+	// 3. 分割文本
 	var texts []string
-	var splitter textsplitter.TextSplitter
 	if ext == ".md" {
 		mdSparators := []string{"#", "##", "###", "####"}
-		mdSparators = append(mdSparators, separators...)
-		splitter = textsplitter.NewMarkdownTextSplitter(
+		for _, sep := range separators {
+			if sep != SeparatorChapter && sep != SeparatorSentence {
+				mdSparators = append(mdSparators, sep)
+			}
+		}
+		mdSparators = append(mdSparators, "")
+		splitter := textsplitter.NewMarkdownTextSplitter(
 			textsplitter.WithChunkSize(opt.ChunkSize),
 			textsplitter.WithChunkOverlap(opt.ChunkOverlap),
 			textsplitter.WithSeparators(mdSparators),
 		)
+		var err error
 		texts, err = splitter.SplitText(content)
 		if err != nil {
-			return nil, err
+			return nil, nil, err
 		}
 	} else {
-		splitter = textsplitter.NewRecursiveCharacter(
-			textsplitter.WithChunkSize(opt.ChunkSize),
-			textsplitter.WithChunkOverlap(opt.ChunkOverlap),
-			textsplitter.WithSeparators(separators),
-		)
-		// 使用 SplitText 方法分割文本内容
-		texts, err = splitText(ctx, splitter, content, opt.Separator, opt.ChunkSize)
-		if err != nil {
-			return nil, err
-		}
+		texts = splitText(ctx, content, separators, opt.ChunkSize, opt.ChunkOverlap)
 	}
+	texts, dups := dedupChunks(texts, opt.Dedup)
+	report := buildReport(encoding, content, texts)
+	addDuplicates(report, dups, opt.Dedup)
+	addNearDuplicates(report, findNearDuplicates(texts))
 
 	// 数据清洗
 	for i, text := range texts {
@@ -115,54 +131,163 @@ func Split(ctx context.Context, filename string, opt Option) ([]string, error) {
 		log.Debugf("Splite content, i: %d, len: %d,  %s", i, len(texts[i]), texts[i][:min(48, len(texts[i]))])
 	}
 	log.Infof("Split costMS: %d", time.Since(start).Milliseconds())
-	return texts, nil
+	return texts, report, nil
 }
 
-func splitText(ctx context.Context, splitter textsplitter.TextSplitter, content string, separator string, chunkSize int) ([]string, error) {
+// splitText 按分隔符级别分割文本：第一个能把原文分成多块的级别决定块的边界，
+// 超过 chunkSize 的块交给后续级别递归分割
+func splitText(ctx context.Context, content string, separators []string, chunkSize, chunkOverlap int) []string {
 	log := logger.FromContext(ctx)
 
-	// 优先按章节分割
-	chapterChunks := splitByChapters(ctx, content)
-	if len(chapterChunks) > 1 {
-		log.Infof("按章节分割成功，共 %d 个章节", len(chapterChunks))
-		return chapterChunks, nil
+	content = strings.ReplaceAll(content, "\r\n", "\n")
+	for i, sep := range separators {
+		parts := splitBySeparator(ctx, content, sep)
+		if len(parts) < 2 {
+			continue
+		}
+		log.Infof("按分隔符 %q 分割成功，共 %d 块", sep, len(parts))
+		var chunks []string
+		for _, part := range parts {
+			chunks = append(chunks, splitRecursive(ctx, part, separators[i+1:], chunkSize, chunkOverlap)...)
+		}
+		return chunks
 	}
 
-	// 如果章节分割失败，使用传统方式分割
-	log.Infof("章节分割失败，使用传统方式分割")
-	finalChunks := make([]string, 0)
-	sep := "\n\n"
-	if separator == "\n" {
-		sep = separator
+	log.Infof("所有分隔符都无法分割原文，按长度切分")
+	content = strings.TrimSpace(content)
+	if content == "" {
+		return nil
 	}
-	splits := strings.Split(content, sep)
-	// 若 \n\n 未分割文本则尝试 \r\n\r\n (windows 换行符)
-	if sep == "\n\n" && len(splits) == 1 {
-		splits = strings.Split(content, "\r\n\r\n")
+	return splitRecursive(ctx, content, nil, chunkSize, chunkOverlap)
+}
+
+// splitRecursive 将超过 chunkSize 的文本用 separators 中第一个能分割它的级别拆开，
+// 过长的部分继续用后续级别拆分，相邻的短部分再合并成不超过 chunkSize 的块；所有级别都无法拆分时按字数硬切
+func splitRecursive(ctx context.Context, text string, separators []string, chunkSize, chunkOverlap int) []string {
+	if chunkSize <= 0 || utf8.RuneCountInString(text) <= chunkSize {
+		return []string{text}
 	}
-	for _, split := range splits {
-		// 去掉空白符号
-		split = strings.TrimSpace(split)
-		if split == "" {
+	for i, sep := range separators {
+		parts := splitBySeparator(ctx, text, sep)
+		if len(parts) < 2 {
 			continue
 		}
-		if utf8.RuneCountInString(split) > chunkSize {
-			texts, err := splitter.SplitText(split)
-			if err != nil {
-				finalChunks = append(finalChunks, split)
-				log.Warnf("Failed to split text, err: %v", err)
-			} else {
-				finalChunks = append(finalChunks, texts...)
-			}
+		var pieces []string
+		for _, part := range parts {
+			pieces = append(pieces, splitRecursive(ctx, part, separators[i+1:], chunkSize, chunkOverlap)...)
+		}
+		return mergePieces(pieces, joinSeparator(sep), chunkSize, chunkOverlap)
+	}
+	return splitByRunes(text, chunkSize, chunkOverlap)
+}
+
+// splitBySeparator 按一个级别分割文本，去掉首尾空白和空块
+func splitBySeparator(ctx context.Context, text, sep string) []string {
+	var parts []string
+	switch sep {
+	case SeparatorChapter:
+		parts = splitByChapters(ctx, text)
+	case SeparatorSentence:
+		parts = splitBySentence(text)
+	case "":
+		return nil
+	default:
+		parts = strings.Split(text, sep)
+	}
+	ret := make([]string, 0, len(parts))
+	for _, part := range parts {
+		if part = strings.TrimSpace(part); part != "" {
+			ret = append(ret, part)
+		}
+	}
+	return ret
+}
+
+// joinSeparator 合并同一级别拆出的相邻部分时使用的连接符，章节和句子不需要额外的连接符
+func joinSeparator(sep string) string {
+	if sep == SeparatorChapter || sep == SeparatorSentence {
+		return ""
+	}
+	return sep
+}
+
+// isSentenceEnd 句末标点
+func isSentenceEnd(r rune) bool {
+	return strings.ContainsRune("。！？!?…；;", r)
+}
+
+// isClosingQuote 句末标点之后仍属于本句的右引号、右括号
+func isClosingQuote(r rune) bool {
+	return strings.ContainsRune("”’」』）)\"'", r)
+}
+
+// splitBySentence 在句末标点（及其后的右引号）之后断句
+func splitBySentence(text string) []string {
+	var parts []string
+	runes := []rune(text)
+	start := 0
+	for i := 0; i < len(runes); i++ {
+		if !isSentenceEnd(runes[i]) {
 			continue
 		}
+		end := i + 1
+		for end < len(runes) && (isSentenceEnd(runes[end]) || isClosingQuote(runes[end])) {
+			end++
+		}
+		parts = append(parts, string(runes[start:end]))
+		start, i = end, end-1
+	}
+	if start < len(runes) {
+		parts = append(parts, string(runes[start:]))
+	}
+	return parts
+}
+
+// mergePieces 将相邻部分用 sep 连接成不超过 chunkSize 的块，新块开头保留上一块末尾不超过 chunkOverlap 字的部分
+func mergePieces(pieces []string, sep string, chunkSize, chunkOverlap int) []string {
+	sepLen := utf8.RuneCountInString(sep)
+	var chunks, cur []string
+	curLen := 0
+	for _, piece := range pieces {
+		n := utf8.RuneCountInString(piece)
+		if len(cur) > 0 && curLen+sepLen+n > chunkSize {
+			chunks = append(chunks, strings.Join(cur, sep))
+			// 从末尾保留重叠部分，同时保证加入当前部分后不超过 chunkSize
+			for len(cur) > 0 && (curLen > chunkOverlap || curLen+sepLen+n > chunkSize) {
+				curLen -= utf8.RuneCountInString(cur[0])
+				if len(cur) > 1 {
+					curLen -= sepLen
+				}
+				cur = cur[1:]
+			}
+		}
+		if len(cur) > 0 {
+			curLen += sepLen
+		}
+		cur = append(cur, piece)
+		curLen += n
+	}
+	if len(cur) > 0 {
+		chunks = append(chunks, strings.Join(cur, sep))
+	}
+	return chunks
+}
 
-		finalChunks = append(finalChunks, split)
+// splitByRunes 按字数硬切，相邻块重叠 chunkOverlap 字
+func splitByRunes(text string, chunkSize, chunkOverlap int) []string {
+	runes := []rune(text)
+	step := chunkSize - min(max(chunkOverlap, 0), chunkSize-1)
+	var chunks []string
+	for start := 0; start < len(runes); start += step {
+		end := min(start+chunkSize, len(runes))
+		chunks = append(chunks, string(runes[start:end]))
+		if end == len(runes) {
+			break
+		}
 	}
-	return finalChunks, nil
+	return chunks
 }
 
-// splitByChapters 按章节分割文本
 func splitByChapters(ctx context.Context, content string) []string {
 	log := logger.FromContext(ctx)
 
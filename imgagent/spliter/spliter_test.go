@@ -7,8 +7,8 @@ import (
 	"strings"
 	"testing"
 
+	"github.com/ledongthuc/pdf"
 	"github.com/stretchr/testify/require"
-	"github.com/tmc/langchaingo/textsplitter"
 )
 
 func writeTempFile(t *testing.T, dir, name, content string) string {
@@ -28,7 +28,7 @@ func TestSplitTXT_Basic(t *testing.T) {
 	content := "Hello world\nThis is a simple test.\nLine3"
 	file := writeTempFile(t, dir, "sample.txt", content)
 
-	opts := Option{ChunkSize: 32, ChunkOverlap: 4, Separator: "\n"}
+	opts := Option{ChunkSize: 32, ChunkOverlap: 4, Separators: []string{"\n", SeparatorSentence}}
 	chunks, err := Split(ctx, file, opts)
 	require.NoError(t, err)
 	require.NotEmpty(t, chunks)
@@ -38,6 +38,87 @@ func TestSplitTXT_Basic(t *testing.T) {
 	}
 }
 
+func TestSplitTXT_Clean(t *testing.T) {
+	t.Parallel()
+	ctx := context.Background()
+
+	dir := t.TempDir()
+	file := writeTempFile(t, dir, "ads.txt", "AD line\nHello world\n\nAD line\nSecond paragraph")
+
+	opts := Option{ChunkSize: 32, ChunkOverlap: 0, Separators: []string{"\n\n"}, Clean: func(s string) string {
+		return strings.ReplaceAll(s, "AD line\n", "")
+	}}
+	chunks, err := Split(ctx, file, opts)
+	require.NoError(t, err)
+	require.Equal(t, []string{"Hello world", "Second paragraph"}, chunks)
+
+	// 清洗后为空时按空文件处理
+	opts.Clean = func(string) string { return "" }
+	_, err = Split(ctx, file, opts)
+	require.Error(t, err)
+}
+
+func TestSplitWithReport(t *testing.T) {
+	t.Parallel()
+	ctx := context.Background()
+
+	dir := t.TempDir()
+	content := "第一章 开始\n" + strings.Repeat("他走进了房间。", 40) + "\n\n第二章 目录\n\n第三章 结束\n" + strings.Repeat("她离开了。", 500)
+	file := writeTempFile(t, dir, "report.txt", content)
+
+	chunks, report, err := SplitWithReport(ctx, file, Option{ChunkSize: 5000})
+	require.NoError(t, err)
+	require.Len(t, chunks, 3)
+	require.Equal(t, EncodingUTF8, report.Encoding)
+	require.Equal(t, 3, report.Chapters)
+	require.Equal(t, []int{1}, report.ShortChapters)
+	require.Equal(t, 1, report.LongParagraphs)
+	require.Equal(t, 2500, report.LongestParagraph)
+	require.Empty(t, report.DuplicateTitles)
+	require.Len(t, report.Warnings, 2)
+}
+
+func TestSplitDedup(t *testing.T) {
+	t.Parallel()
+	ctx := context.Background()
+
+	dir := t.TempDir()
+	chapter1 := "第一章 开始\n他走进了房间。"
+	chapter2 := "第二章 结束\n她离开了。"
+	// 重复章节只有排版差异
+	content := chapter1 + "\n\n" + chapter2 + "\n\n第一章 开始\n  他走进了 房间。\n\n" + chapter2
+	file := writeTempFile(t, dir, "dup.txt", content)
+
+	chunks, report, err := SplitWithReport(ctx, file, Option{ChunkSize: 5000})
+	require.NoError(t, err)
+	require.Len(t, chunks, 4)
+	require.False(t, report.DuplicatesRemoved)
+	require.Equal(t, []DuplicateChapter{{Index: 2, FirstIndex: 0, Title: "第一章 开始"}, {Index: 3, FirstIndex: 1, Title: "第二章 结束"}}, report.DuplicateChapters)
+
+	chunks, report, err = SplitWithReport(ctx, file, Option{ChunkSize: 5000, Dedup: true})
+	require.NoError(t, err)
+	require.Equal(t, []string{"第一章 开始,他走进了房间。", "第二章 结束,她离开了。"}, chunks)
+	require.True(t, report.DuplicatesRemoved)
+	require.Len(t, report.DuplicateChapters, 2)
+	require.Equal(t, 2, report.Chapters)
+	require.Empty(t, report.DuplicateTitles)
+	require.Contains(t, report.Warnings, "2 个章节与前文内容重复，已自动删除")
+}
+
+func TestDetectEncoding(t *testing.T) {
+	t.Parallel()
+	require.Equal(t, EncodingUTF8, detectEncoding([]byte("你好")))
+	require.Equal(t, EncodingUTF8BOM, detectEncoding([]byte("\xef\xbb\xbf你好")))
+	require.Equal(t, EncodingUTF16LE, detectEncoding([]byte("\xff\xfe`O}Y")))
+	require.Equal(t, EncodingGBK, detectEncoding([]byte("\xc4\xe3\xba\xc3")))
+	require.Equal(t, EncodingUnknown, detectEncoding([]byte("\xc4")))
+
+	r := buildReport(EncodingGBK, "a\x00\x01b", []string{"a\x00\x01b"})
+	require.InDelta(t, 0.5, r.NonTextRatio, 0.001)
+	require.Empty(t, r.ShortChapters)
+	require.Len(t, r.Warnings, 2)
+}
+
 func TestSplitMD_Headings(t *testing.T) {
 	t.Parallel()
 	ctx := context.Background()
@@ -47,7 +128,7 @@ func TestSplitMD_Headings(t *testing.T) {
 	file := writeTempFile(t, dir, "doc.md", md)
 
 	// Use small chunk to encourage splitting by headings/separators
-	opts := Option{ChunkSize: 40, ChunkOverlap: 0, Separator: "\n"}
+	opts := Option{ChunkSize: 40, ChunkOverlap: 0, Separators: []string{"\n"}}
 	chunks, err := Split(ctx, file, opts)
 	require.NoError(t, err)
 	require.GreaterOrEqual(t, len(chunks), 2, "expected multiple chunks for markdown")
@@ -59,13 +140,7 @@ func TestSplitText_SeparatorChoiceAndChunking(t *testing.T) {
 
 	// Case 1: spaces only, explicitly set separator to space
 	content1 := "alpha beta gamma delta epsilon zeta eta theta iota"
-	splitter1 := textsplitter.NewRecursiveCharacter(
-		textsplitter.WithChunkSize(10),
-		textsplitter.WithChunkOverlap(0),
-		textsplitter.WithSeparators([]string{" ", ""}),
-	)
-	chunks, err := splitText(ctx, splitter1, content1, " ", 10)
-	require.NoError(t, err)
+	chunks := splitText(ctx, content1, []string{" "}, 10, 0)
 	require.NotEmpty(t, chunks)
 	for _, c := range chunks {
 		require.False(t, strings.Contains(c, "\n"), "unexpected newline in chunk: %q", c)
@@ -74,52 +149,41 @@ func TestSplitText_SeparatorChoiceAndChunking(t *testing.T) {
 		require.LessOrEqual(t, utfLen, 10, "chunk size out of bound: %q (len=%d)", c, utfLen)
 	}
 
-	// Case 2: contains newlines, set separator to "\n"
+	// Case 2: contains newlines, long line split further by spaces
 	content2 := "row1\nrow2\nrow3 with long content to be split further"
-	splitter2 := textsplitter.NewRecursiveCharacter(
-		textsplitter.WithChunkSize(8),
-		textsplitter.WithChunkOverlap(0),
-		textsplitter.WithSeparators([]string{"\n", " ", ""}),
-	)
-	chunks, err = splitText(ctx, splitter2, content2, "\n", 8)
-	require.NoError(t, err)
-	require.NotEmpty(t, chunks)
-	for _, c := range chunks {
-		utfLen := len([]rune(c))
-		require.Greater(t, utfLen, 0)
-		require.LessOrEqual(t, utfLen, 8)
-	}
+	chunks = splitText(ctx, content2, []string{"\n", " "}, 8, 0)
+	require.Equal(t, []string{"row1", "row2", "row3", "with", "long", "content", "to be", "split", "further"}, chunks)
 
-	// Case 3: no explicit separator provided (defaults to "\n\n"), content has none
-	// Should rely on splitter when chunkSize exceeded
+	// Case 3: no separator matches, cut by length
 	content3 := "ABCDEFGHIJKL" // 12 runes, chunkSize 5 => expect multiple chunks
-	splitter3 := textsplitter.NewRecursiveCharacter(
-		textsplitter.WithChunkSize(5),
-		textsplitter.WithChunkOverlap(0),
-		textsplitter.WithSeparators([]string{""}),
-	)
-	chunks, err = splitText(ctx, splitter3, content3, "", 5)
-	require.NoError(t, err)
-	require.NotEmpty(t, chunks)
-	for _, c := range chunks {
-		utfLen := len([]rune(c))
-		require.Greater(t, utfLen, 0)
-		require.LessOrEqual(t, utfLen, 5)
-	}
+	chunks = splitText(ctx, content3, nil, 5, 0)
+	require.Equal(t, []string{"ABCDE", "FGHIJ", "KL"}, chunks)
 
-	// Case 4: mixture with double newlines, choose "\n\n" as separator via default
+	// Case 4: mixture with double newlines, default separators
 	content4 := "  part1  \n\n  part2  \n  \npart3  "
-	splitter4 := textsplitter.NewRecursiveCharacter(
-		textsplitter.WithChunkSize(20),
-		textsplitter.WithChunkOverlap(0),
-		textsplitter.WithSeparators([]string{"\n\n", "\n", " ", ""}),
-	)
-	chunks, err = splitText(ctx, splitter4, content4, "", 20)
-	require.NoError(t, err)
+	chunks = splitText(ctx, content4, DefaultSeparators, 20, 0)
 	require.NotEmpty(t, chunks)
 	for _, c := range chunks {
 		require.NotEmpty(t, strings.TrimSpace(c))
 	}
+
+	// Case 5: no blank lines, long paragraphs split by sentence and merged up to chunk size
+	content5 := strings.Repeat("他走进了房间。“你来了？”她问。", 3) + "\n" + strings.Repeat("雨下了一整夜。", 6)
+	chunks = splitText(ctx, content5, DefaultSeparators, 20, 0)
+	require.Equal(t, []string{
+		"他走进了房间。“你来了？”她问。",
+		"他走进了房间。“你来了？”她问。",
+		"他走进了房间。“你来了？”她问。",
+		"雨下了一整夜。雨下了一整夜。",
+		"雨下了一整夜。雨下了一整夜。",
+		"雨下了一整夜。雨下了一整夜。",
+	}, chunks)
+
+	// Case 6: overlap keeps the tail of the previous chunk
+	chunks = splitText(ctx, "序\n一。二。三。四。五。", []string{"\n", SeparatorSentence}, 6, 2)
+	require.Equal(t, []string{"序", "一。二。三。", "三。四。五。"}, chunks)
+	chunks = splitText(ctx, "ABCDEFGHIJKL", nil, 5, 2)
+	require.Equal(t, []string{"ABCDE", "DEFGH", "GHIJK", "JKL"}, chunks)
 }
 
 // TestSplitBooks_ChapterDetection 测试小说章节检测功能（使用 mock 文件）
@@ -199,7 +263,6 @@ func TestSplitBooks_ChapterDetection(t *testing.T) {
 			opts := Option{
 				ChunkSize:    1000, // 较大的块大小，优先按章节分割
 				ChunkOverlap: 100,
-				Separator:    "\n\n",
 			}
 
 			chunks, err := Split(ctx, filePath, opts)
@@ -303,7 +366,6 @@ func TestSplitBooks_SpecificNovels(t *testing.T) {
 			opts := Option{
 				ChunkSize:    2000,
 				ChunkOverlap: 200,
-				Separator:    "\n\n",
 			}
 
 			chunks, err := Split(ctx, filePath, opts)
@@ -399,3 +461,61 @@ func TestSplitByChapters_Unit(t *testing.T) {
 		})
 	}
 }
+
+// glyphs 按字符生成 PDF 文本，中文字宽为字号，其他字符为半个字号
+func glyphs(s string, x, y, size float64) []pdf.Text {
+	var texts []pdf.Text
+	for _, r := range s {
+		w := size / 2
+		if r > 0x2e80 {
+			w = size
+		}
+		texts = append(texts, pdf.Text{FontSize: size, X: x, Y: y, W: w, S: string(r)})
+		x += w
+	}
+	return texts
+}
+
+func TestLayoutPage_Columns(t *testing.T) {
+	t.Parallel()
+
+	var texts []pdf.Text
+	// 跨栏标题
+	texts = append(texts, glyphs("双栏测试标题", 250, 780, 16)...)
+	// 左右两栏同一基线，内容流中先右栏后左栏，各 3 行
+	for i, y := range []float64{740, 724, 708} {
+		texts = append(texts, glyphs(strings.Repeat([]string{"甲", "乙", "丙"}[i], 18), 320, y, 12)...)
+		texts = append(texts, glyphs(strings.Repeat([]string{"一", "二", "三"}[i], 18), 50, y, 12)...)
+	}
+	// 左栏末尾的脚注标号
+	texts = append(texts, glyphs("1", 266, 712, 7)...)
+	// 脚注和页码
+	texts = append(texts, glyphs("1 注释内容", 50, 60, 9)...)
+	texts = append(texts, glyphs("12", 295, 30, 10)...)
+
+	left := strings.Repeat("一", 18) + strings.Repeat("二", 18) + strings.Repeat("三", 18)
+	right := strings.Repeat("甲", 18) + strings.Repeat("乙", 18) + strings.Repeat("丙", 18)
+	body, note := layoutPage(texts, FootnotesDrop)
+	require.Equal(t, "双栏测试标题\n"+left+right, body)
+	require.Equal(t, "1 注释内容", note)
+
+	// 保留脚注时同时保留正文中的脚注标号
+	body, _ = layoutPage(texts, FootnotesAppend)
+	require.Equal(t, "双栏测试标题\n"+left+"1"+right, body)
+}
+
+func TestLayoutPage_Paragraphs(t *testing.T) {
+	t.Parallel()
+
+	var texts []pdf.Text
+	// 首行缩进的两段，西文行之间补空格
+	texts = append(texts, glyphs("  The quick brown fox jumps over", 50, 700, 12)...)
+	texts = append(texts, glyphs("the lazy dog and keeps running far", 50, 686, 12)...)
+	texts = append(texts, glyphs("away.", 50, 672, 12)...)
+	texts = append(texts, glyphs("第二段开始的一行中文内容比较长", 74, 658, 12)...)
+	texts = append(texts, glyphs("接着的一行中文内容也比较长一些", 50, 644, 12)...)
+
+	body, note := layoutPage(texts, FootnotesDrop)
+	require.Equal(t, "The quick brown fox jumps over the lazy dog and keeps running far away.\n第二段开始的一行中文内容比较长接着的一行中文内容也比较长一些", body)
+	require.Empty(t, note)
+}
@@ -4,10 +4,15 @@ import (
 	"context"
 	"errors"
 	"fmt"
+	"io"
+	"mime/multipart"
+	"net/http"
 	"time"
 
 	"github.com/qiniu/go-sdk/v7/storagev2/credentials"
 	"github.com/qiniu/go-sdk/v7/storagev2/uptoken"
+
+	"imgagent/pkg/faults"
 )
 
 type Config struct {
@@ -16,6 +21,7 @@ type Config struct {
 	Bucket      string `json:"bucket"`
 	ExpiresHour int    `json:"expires_hour"`
 	Domain      string `json:"domain"`
+	UpHost      string `json:"up_host"` // 上传地址，默认华东区域 https://up.qiniup.com
 }
 
 type Storage struct {
@@ -29,12 +35,18 @@ func NewStorage(conf Config) (*Storage, error) {
 	if conf.ExpiresHour == 0 {
 		conf.ExpiresHour = 2
 	}
+	if conf.UpHost == "" {
+		conf.UpHost = "https://up.qiniup.com"
+	}
 	return &Storage{
 		conf: conf,
 	}, nil
 }
 
 func (s *Storage) GenerateUploadToken(userID int64) (string, error) {
+	if err := faults.Inject(context.Background(), faults.TargetStorage+".upload_token"); err != nil {
+		return "", err
+	}
 	saveKey := fmt.Sprintf("voices/${year}/${mon}/${day}/${hour}${min}${sec}-%d-${fname}", userID)
 	mac := credentials.NewCredentials(s.conf.AccessKey, s.conf.SecretKey)
 	policy, err := uptoken.NewPutPolicy(s.conf.Bucket, time.Now().Add(time.Duration(s.conf.ExpiresHour)*time.Hour))
@@ -51,6 +63,91 @@ func (s *Storage) MakeURL(key string) string {
 	return "https://" + s.conf.Domain + "/" + key
 }
 
+// ErrObjectNotFound 对象不存在
+var ErrObjectNotFound = errors.New("object not found")
+
+// PutObject 以表单上传方式把 r 的内容上传到 key，已存在时覆盖
+func (s *Storage) PutObject(ctx context.Context, key string, r io.Reader) error {
+	if err := faults.Inject(ctx, faults.TargetStorage+".put"); err != nil {
+		return err
+	}
+	mac := credentials.NewCredentials(s.conf.AccessKey, s.conf.SecretKey)
+	policy, err := uptoken.NewPutPolicyWithKey(s.conf.Bucket, key, time.Now().Add(time.Duration(s.conf.ExpiresHour)*time.Hour))
+	if err != nil {
+		return err
+	}
+	token, err := uptoken.NewSigner(policy, mac).GetUpToken(ctx)
+	if err != nil {
+		return err
+	}
+
+	pr, pw := io.Pipe()
+	mw := multipart.NewWriter(pw)
+	go func() {
+		pw.CloseWithError(writeUploadForm(mw, token, key, r))
+	}()
+	defer pr.Close()
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, s.conf.UpHost, pr)
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", mw.FormDataContentType())
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		body, _ := io.ReadAll(io.LimitReader(resp.Body, 512))
+		return fmt.Errorf("put object %s: http status %d, body: %s", key, resp.StatusCode, body)
+	}
+	return nil
+}
+
+func writeUploadForm(mw *multipart.Writer, token, key string, r io.Reader) error {
+	if err := mw.WriteField("token", token); err != nil {
+		return err
+	}
+	if err := mw.WriteField("key", key); err != nil {
+		return err
+	}
+	part, err := mw.CreateFormFile("file", key)
+	if err != nil {
+		return err
+	}
+	if _, err = io.Copy(part, r); err != nil {
+		return err
+	}
+	return mw.Close()
+}
+
+// GetObject 通过私有下载地址读取 key 的内容，调用方负责关闭
+func (s *Storage) GetObject(ctx context.Context, key string) (io.ReadCloser, error) {
+	if err := faults.Inject(ctx, faults.TargetStorage+".get"); err != nil {
+		return nil, err
+	}
+	mac := credentials.NewCredentials(s.conf.AccessKey, s.conf.SecretKey)
+	u := fmt.Sprintf("%s?e=%d", s.MakeURL(key), time.Now().Add(time.Duration(s.conf.ExpiresHour)*time.Hour).Unix())
+	u += "&token=" + mac.Sign([]byte(u))
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, u, nil)
+	if err != nil {
+		return nil, err
+	}
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	if resp.StatusCode != http.StatusOK {
+		resp.Body.Close()
+		if resp.StatusCode == http.StatusNotFound {
+			return nil, ErrObjectNotFound
+		}
+		return nil, fmt.Errorf("get object %s: http status %d", key, resp.StatusCode)
+	}
+	return resp.Body, nil
+}
+
 type UploadFileRet struct {
 	Key    string
 	Hash   string
@@ -0,0 +1,138 @@
+// Package subtitle 按语音时长把场景文字切分为字幕条目，并输出 SRT、WebVTT 字幕。
+// 语音服务不返回逐字时间戳，各条目的时长按字数在语音时长内等比例分配。
+package subtitle
+
+import (
+	"bytes"
+	"fmt"
+	"strings"
+	"time"
+	"unicode"
+	"unicode/utf8"
+)
+
+// maxCueRunes 每条字幕最多字符数，超长的句子在逗号处或按长度拆分
+const maxCueRunes = 24
+
+// Cue 一条字幕，Start、End 为相对语音开始的时间
+type Cue struct {
+	Start time.Duration
+	End   time.Duration
+	Text  string
+}
+
+// Cues 把 text 切分为字幕条目，在 [0, d) 内按字数分配时长。text 为空或 d 不为正时返回 nil
+func Cues(text string, d time.Duration) []Cue {
+	if d <= 0 {
+		return nil
+	}
+	parts := split(text)
+	total := 0
+	for _, p := range parts {
+		total += weight(p)
+	}
+	if total == 0 {
+		return nil
+	}
+
+	cues := make([]Cue, 0, len(parts))
+	n := 0
+	for _, p := range parts {
+		start := d * time.Duration(n) / time.Duration(total)
+		n += weight(p)
+		end := d * time.Duration(n) / time.Duration(total)
+		cues = append(cues, Cue{Start: start, End: end, Text: p})
+	}
+	return cues
+}
+
+// Shift 返回所有条目向后平移 offset 的副本，用于把场景字幕拼接为文档字幕
+func Shift(cues []Cue, offset time.Duration) []Cue {
+	ret := make([]Cue, len(cues))
+	for i, c := range cues {
+		ret[i] = Cue{Start: c.Start + offset, End: c.End + offset, Text: c.Text}
+	}
+	return ret
+}
+
+// SRT 输出 SubRip 格式字幕
+func SRT(cues []Cue) []byte {
+	var buf bytes.Buffer
+	for i, c := range cues {
+		fmt.Fprintf(&buf, "%d\n%s --> %s\n%s\n\n", i+1, timestamp(c.Start, ','), timestamp(c.End, ','), c.Text)
+	}
+	return buf.Bytes()
+}
+
+// VTT 输出 WebVTT 格式字幕
+func VTT(cues []Cue) []byte {
+	var buf bytes.Buffer
+	buf.WriteString("WEBVTT\n\n")
+	for _, c := range cues {
+		fmt.Fprintf(&buf, "%s --> %s\n%s\n\n", timestamp(c.Start, '.'), timestamp(c.End, '.'), c.Text)
+	}
+	return buf.Bytes()
+}
+
+// timestamp 格式化为 hh:mm:ss,mmm，sep 为秒和毫秒之间的分隔符
+func timestamp(d time.Duration, sep byte) string {
+	ms := d.Milliseconds()
+	return fmt.Sprintf("%02d:%02d:%02d%c%03d", ms/3600000, ms/60000%60, ms/1000%60, sep, ms%1000)
+}
+
+// weight 条目分配时长的权重，不计空白和标点
+func weight(s string) int {
+	n := 0
+	for _, r := range s {
+		if !unicode.IsSpace(r) && !unicode.IsPunct(r) {
+			n++
+		}
+	}
+	return max(n, 1)
+}
+
+// split 按句末标点和换行切分句子，超过 maxCueRunes 的句子再拆分
+func split(text string) []string {
+	var parts []string
+	var cur strings.Builder
+	flush := func() {
+		s := strings.TrimSpace(cur.String())
+		cur.Reset()
+		if s != "" {
+			parts = append(parts, splitLong(s)...)
+		}
+	}
+	for _, r := range text {
+		if r == '\n' || r == '\r' {
+			flush()
+			continue
+		}
+		cur.WriteRune(r)
+		if strings.ContainsRune("。！？；!?;…", r) {
+			flush()
+		}
+	}
+	flush()
+	return parts
+}
+
+// splitLong 在不超过 maxCueRunes 的最后一个逗号处拆分，没有逗号时按长度拆分
+func splitLong(s string) []string {
+	var parts []string
+	for utf8.RuneCountInString(s) > maxCueRunes {
+		runes := []rune(s)
+		cut := maxCueRunes
+		for i := maxCueRunes - 1; i > 0; i-- {
+			if strings.ContainsRune("，、,：:", runes[i]) {
+				cut = i + 1
+				break
+			}
+		}
+		parts = append(parts, strings.TrimSpace(string(runes[:cut])))
+		s = strings.TrimSpace(string(runes[cut:]))
+	}
+	if s != "" {
+		parts = append(parts, s)
+	}
+	return parts
+}
@@ -0,0 +1,38 @@
+package subtitle
+
+import (
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestCues(t *testing.T) {
+	cues := Cues("祥子拉车。\n走在街头！", 4*time.Second)
+	require.Len(t, cues, 2)
+	assert.Equal(t, Cue{Start: 0, End: 2 * time.Second, Text: "祥子拉车。"}, cues[0])
+	assert.Equal(t, Cue{Start: 2 * time.Second, End: 4 * time.Second, Text: "走在街头！"}, cues[1])
+
+	// 超长句子在逗号处拆分
+	long := strings.Repeat("字", 20) + "，" + strings.Repeat("字", 10) + "。"
+	cues = Cues(long, 3*time.Second)
+	require.Len(t, cues, 2)
+	assert.Equal(t, strings.Repeat("字", 20)+"，", cues[0].Text)
+	assert.Equal(t, 2*time.Second, cues[0].End)
+
+	// 没有逗号时按长度拆分
+	cues = Cues(strings.Repeat("字", maxCueRunes*2+1), time.Second)
+	require.Len(t, cues, 3)
+	assert.Equal(t, time.Second, cues[2].End)
+
+	assert.Nil(t, Cues("", time.Second))
+	assert.Nil(t, Cues("祥子", 0))
+}
+
+func TestFormat(t *testing.T) {
+	cues := Shift([]Cue{{Start: 0, End: 1500 * time.Millisecond, Text: "祥子"}}, time.Hour+2*time.Minute)
+	assert.Equal(t, "1\n01:02:00,000 --> 01:02:01,500\n祥子\n\n", string(SRT(cues)))
+	assert.Equal(t, "WEBVTT\n\n01:02:00.000 --> 01:02:01.500\n祥子\n\n", string(VTT(cues)))
+}
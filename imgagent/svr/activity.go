@@ -0,0 +1,80 @@
+package svr
+
+import (
+	"context"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/gin-gonic/gin"
+
+	"imgagent/api"
+	"imgagent/db"
+	hutil "imgagent/httputil"
+	"imgagent/pkg/logger"
+)
+
+const (
+	defaultActivityLimit = 20
+	maxActivityLimit     = 100
+)
+
+// recordEvent 写入事件日志，失败只记录日志，不影响主流程
+func recordEvent(ctx context.Context, database db.IDataBase, event db.Event) {
+	event.CreatedAt = time.Now()
+	err := database.CreateEvent(ctx, &event)
+	if err != nil {
+		logger.FromContext(ctx).Errorf("Failed to create event, event: %+v, err: %v", event, err)
+	}
+}
+
+// HandleListActivity 按时间倒序分页列取当前租户的动态
+func (s *Service) HandleListActivity(c *gin.Context) {
+	ctx := c.Request.Context()
+	log := logger.FromGinContext(c)
+	ui := GetUserInfo(c)
+
+	var marker int64
+	if m := c.Query("marker"); m != "" {
+		v, err := strconv.ParseInt(m, 10, 64)
+		if err != nil || v <= 0 {
+			hutil.AbortError(c, http.StatusBadRequest, "invalid marker")
+			return
+		}
+		marker = v
+	}
+	limit := defaultActivityLimit
+	if l := c.Query("limit"); l != "" {
+		v, err := strconv.Atoi(l)
+		if err != nil || v <= 0 {
+			hutil.AbortError(c, http.StatusBadRequest, "invalid limit")
+			return
+		}
+		limit = min(v, maxActivityLimit)
+	}
+
+	log.Infof("List activity, userID: %d, marker: %d, limit: %d", ui.ID, marker, limit)
+	events, err := s.db.ListEvents(ctx, ui.ID, marker, limit)
+	if err != nil {
+		log.Errorf("Failed to list events, err: %v", err)
+		hutil.AbortError(c, hutil.ErrServerInternalCode, "list activity failed")
+		return
+	}
+
+	ret := &api.ListActivityResult{Activities: []api.Activity{}}
+	for _, e := range events {
+		ret.Activities = append(ret.Activities, api.Activity{
+			ID:         strconv.FormatInt(e.ID, 10),
+			Type:       e.Type,
+			DocumentID: e.DocumentID,
+			SceneID:    e.SceneID,
+			Message:    e.Message,
+			CreatedAt:  e.CreatedAt.Format(time.DateTime),
+		})
+	}
+	// 取满一页说明可能还有更多
+	if len(events) == limit {
+		ret.NextMarker = ret.Activities[len(ret.Activities)-1].ID
+	}
+	hutil.WriteData(c, ret)
+}
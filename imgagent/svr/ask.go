@@ -0,0 +1,115 @@
+package svr
+
+import (
+	"context"
+	"net/http"
+	"strings"
+
+	"github.com/gin-gonic/gin"
+
+	"imgagent/api"
+	"imgagent/bailian"
+	"imgagent/db"
+	hutil "imgagent/httputil"
+	"imgagent/pkg/logger"
+)
+
+const (
+	defaultAskTopK = 3
+	// maxAskSourceChars 每个章节片段提交给模型的最大字符数
+	maxAskSourceChars = 3000
+	// askNoAnswer 没有检索到章节时的回答，与提示词中要求模型的回答一致
+	askNoAnswer = "根据文档内容无法回答该问题"
+)
+
+// HandleAskDocument 检索与问题语义最相近的章节，由百炼根据章节内容回答并标注引用的章节，需要同步调用大模型，按租户限制并发
+func (s *Service) HandleAskDocument(c *gin.Context) {
+	log := logger.FromGinContext(c)
+
+	var args api.AskDocumentArgs
+	if err := c.ShouldBindJSON(&args); err != nil {
+		log.Errorf("Invalid request body, err: %v", err)
+		hutil.AbortError(c, http.StatusBadRequest, "invalid request body")
+		return
+	}
+	docID := c.Param("document_id")
+	s.limiter.Run(c, GetUserInfo(c).ID, func(ctx context.Context) (any, error) {
+		return s.AskDocument(ctx, docID, &args)
+	})
+}
+
+func (s *Service) AskDocument(ctx context.Context, docID string, args *api.AskDocumentArgs) (*api.AskDocumentResult, error) {
+	log := logger.FromContext(ctx)
+
+	question := strings.TrimSpace(args.Question)
+	if question == "" {
+		return nil, hutil.NewApiError(http.StatusBadRequest, "invalid question")
+	}
+	if s.embedder == nil {
+		return nil, hutil.NewApiError(http.StatusBadRequest, "semantic search not enabled")
+	}
+	if s.bailianClient == nil {
+		return nil, hutil.NewApiError(http.StatusBadRequest, "bailian client not configured")
+	}
+	_, err := s.db.GetDocument(ctx, docID)
+	if err != nil {
+		log.Errorf("Failed to get document, id: %s, err: %v", docID, err)
+		return nil, documentError(err, "get document failed")
+	}
+	topK := args.TopK
+	if topK == 0 {
+		topK = defaultAskTopK
+	}
+
+	log.Infof("Ask document, docID: %s, question: %s, topK: %d", docID, question, topK)
+	hits, err := s.embedder.Search(ctx, docID, question, topK)
+	if err != nil {
+		log.Errorf("Failed to search chapters, docID: %s, err: %v", docID, err)
+		return nil, hutil.NewApiError(hutil.ErrServerInternalCode, "search chapters failed")
+	}
+	chapters, err := s.db.ListChapters(ctx, docID)
+	if err != nil {
+		log.Errorf("Failed to list chapters, docID: %s, err: %v", docID, err)
+		return nil, hutil.NewApiError(hutil.ErrServerInternalCode, "list chapters failed")
+	}
+	byID := make(map[string]*db.Chapter, len(chapters))
+	for i := range chapters {
+		byID[chapters[i].ID] = &chapters[i]
+	}
+
+	ret := &api.AskDocumentResult{Citations: []string{}, Sources: []api.AskSource{}}
+	var sources []bailian.QASource
+	for _, hit := range hits {
+		ch, ok := byID[hit.ID]
+		if !ok {
+			continue
+		}
+		content := []rune(ch.Content)
+		if len(content) > maxAskSourceChars {
+			content = content[:maxAskSourceChars]
+		}
+		ret.Sources = append(ret.Sources, api.AskSource{
+			ChapterID: ch.ID,
+			Index:     ch.Index,
+			Title:     ch.Title,
+			Content:   string(content),
+			Score:     hit.Score,
+		})
+		sources = append(sources, bailian.QASource{Index: len(sources) + 1, Title: ch.Title, Content: string(content)})
+	}
+	if len(sources) == 0 {
+		ret.Answer = askNoAnswer
+		return ret, nil
+	}
+
+	answer, err := s.bailianClient.AnswerQuestion(ctx, question, sources)
+	if err != nil {
+		log.Errorf("Failed to answer question, docID: %s, err: %v", docID, err)
+		return nil, hutil.NewApiError(hutil.ErrServerInternalCode, "answer question failed")
+	}
+	ret.Answer = answer.Answer
+	for _, n := range answer.Citations {
+		ret.Citations = append(ret.Citations, ret.Sources[n-1].ChapterID)
+	}
+	return ret, nil
+}
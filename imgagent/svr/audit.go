@@ -0,0 +1,284 @@
+package svr
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"gorm.io/gorm"
+
+	"imgagent/api"
+	"imgagent/db"
+	hutil "imgagent/httputil"
+	"imgagent/pkg/logger"
+	"imgagent/pkg/middleware"
+)
+
+const (
+	defaultAuditLogLimit = 20
+	maxAuditLogLimit     = 100
+
+	// auditResourceKey 新建资源的接口通过 setAuditResource 写入新资源的 id
+	auditResourceKey = "auditResource"
+
+	// 注册路由时 api 被路由分组遮蔽，使用以下别名
+	auditDocument       = api.AuditResourceDocument
+	auditChapter        = api.AuditResourceChapter
+	auditScene          = api.AuditResourceScene
+	auditRole           = api.AuditResourceRole
+	auditChapters       = api.AuditResourceChapters
+	auditRoles          = api.AuditResourceRoles
+	auditPromptTemplate = api.AuditResourcePromptTemplate
+	auditBackup         = api.AuditResourceBackup
+)
+
+// auditChapterItem 章节集合快照中的章节，不包含正文
+type auditChapterItem struct {
+	ID    string `json:"id"`
+	Index int    `json:"index"`
+	Title string `json:"title"`
+}
+
+// Audit 记录修改类接口的审计日志：处理前后分别读取资源快照，请求成功后写入 audit_logs，失败只记录日志。
+// action 为空时取路由中的操作名，如 /scenes/:id/image:upscale 为 upscale
+func (s *Service) Audit(resource, action string) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		ctx := c.Request.Context()
+		id := auditResourceID(c, resource)
+		var before any
+		if id != "" {
+			before = s.auditSnapshot(ctx, resource, id)
+		}
+
+		c.Next()
+		if c.IsAborted() {
+			return
+		}
+
+		if v, ok := c.Get(auditResourceKey); ok {
+			id = v.(string)
+		}
+		after := s.auditSnapshot(ctx, resource, id)
+		if action == "" {
+			action = auditRouteAction(c)
+		}
+		log := newAuditLog(c, resource, action)
+		log.ResourceID = id
+		log.DocumentID = auditDocumentID(resource, id, before, after)
+		log.Before = marshalAuditSnapshot(before)
+		log.After = marshalAuditSnapshot(after)
+		s.recordAudit(ctx, log)
+	}
+}
+
+// newAuditLog 按请求生成审计日志，资源 id 和快照由调用方填写
+func newAuditLog(c *gin.Context, resource, action string) db.AuditLog {
+	ui := GetUserInfo(c)
+	return db.AuditLog{
+		UserID:       ui.ID,
+		UserName:     ui.Name,
+		Action:       action,
+		ResourceType: resource,
+		Route:        c.Request.Method + " " + c.FullPath(),
+		RequestID:    c.GetString(middleware.XReqID),
+	}
+}
+
+// auditCreated 记录不经过 Audit 中间件新建的资源，如一个请求创建多个文档的批量导入
+func (s *Service) auditCreated(ctx context.Context, log db.AuditLog, id string) {
+	after := s.auditSnapshot(ctx, log.ResourceType, id)
+	log.ResourceID = id
+	log.DocumentID = auditDocumentID(log.ResourceType, id, after)
+	log.After = marshalAuditSnapshot(after)
+	s.recordAudit(ctx, log)
+}
+
+// setAuditResource 新建资源的接口在成功后写入新资源的 id
+func setAuditResource(c *gin.Context, id string) {
+	c.Set(auditResourceKey, id)
+}
+
+// auditResourceID 从路由参数中取资源 id，章节的操作路由形如 /chapters/{id}:split
+func auditResourceID(c *gin.Context, resource string) string {
+	switch resource {
+	case api.AuditResourcePromptTemplate:
+		return c.Param("kind")
+	case api.AuditResourceDocument, api.AuditResourceChapters, api.AuditResourceRoles:
+		// 管理接口中文档 id 为 :id
+		if id, _, _ := strings.Cut(c.Param("document_id"), ":"); id != "" {
+			return id
+		}
+	}
+	id, _, _ := strings.Cut(c.Param("id"), ":")
+	return id
+}
+
+// auditRouteAction 取路由中 ":" 之后的操作名
+func auditRouteAction(c *gin.Context) string {
+	if action := c.Param("action"); action != "" {
+		_, action, _ = strings.Cut(action, ":")
+		return action
+	}
+	if id := c.Param("id"); id != "" {
+		_, action, _ := strings.Cut(id, ":")
+		return action
+	}
+	_, action, _ := strings.Cut(c.Param("document_id"), ":")
+	return action
+}
+
+// auditSnapshot 读取资源的当前状态，资源不存在时返回 nil
+func (s *Service) auditSnapshot(ctx context.Context, resource, id string) any {
+	if id == "" {
+		return nil
+	}
+	var snapshot any
+	var err error
+	switch resource {
+	case api.AuditResourceDocument:
+		snapshot, err = s.db.GetDocument(ctx, id)
+	case api.AuditResourceChapter:
+		snapshot, err = s.db.GetChapterByID(ctx, id)
+	case api.AuditResourceScene:
+		snapshot, err = s.db.GetScene(ctx, id)
+	case api.AuditResourceRole:
+		snapshot, err = s.db.GetRole(ctx, id)
+	case api.AuditResourceChapters:
+		var chapters []db.Chapter
+		chapters, err = s.db.ListChapters(ctx, id)
+		list := make([]auditChapterItem, 0, len(chapters))
+		for _, ch := range chapters {
+			list = append(list, auditChapterItem{ID: ch.ID, Index: ch.Index, Title: ch.Title})
+		}
+		snapshot = list
+	case api.AuditResourceRoles:
+		snapshot, err = s.db.ListRolesByDocument(ctx, id)
+	case api.AuditResourcePromptTemplate:
+		snapshot, err = s.db.GetPromptTemplate(ctx, id)
+	}
+	if errors.Is(err, gorm.ErrRecordNotFound) {
+		return nil
+	}
+	if err != nil {
+		logger.FromContext(ctx).Errorf("Failed to get audit snapshot, resource: %s, id: %s, err: %v", resource, id, err)
+		return nil
+	}
+	return snapshot
+}
+
+// auditDocumentID 资源所属的文档 id，便于按文档查看全部修改
+func auditDocumentID(resource, id string, snapshots ...any) string {
+	switch resource {
+	case api.AuditResourceDocument, api.AuditResourceChapters, api.AuditResourceRoles:
+		return id
+	}
+	for _, snapshot := range snapshots {
+		switch v := snapshot.(type) {
+		case db.Chapter:
+			return v.DocumentID
+		case db.Scene:
+			return v.DocumentID
+		case db.Role:
+			return v.DocumentID
+		}
+	}
+	return ""
+}
+
+func marshalAuditSnapshot(snapshot any) string {
+	if snapshot == nil {
+		return ""
+	}
+	b, err := json.Marshal(snapshot)
+	if err != nil {
+		return ""
+	}
+	return string(b)
+}
+
+// recordAudit 写入审计日志，失败只记录日志，不影响已完成的修改
+func (s *Service) recordAudit(ctx context.Context, log db.AuditLog) {
+	log.CreatedAt = time.Now()
+	if err := s.db.CreateAuditLog(ctx, &log); err != nil {
+		logger.FromContext(ctx).Errorf("Failed to create audit log, action: %s, resource: %s, id: %s, err: %v", log.Action, log.ResourceType, log.ResourceID, err)
+	}
+}
+
+// HandleListAuditLogs 按时间倒序分页列取审计日志，可按资源、文档和用户过滤，需超级管理员
+func (s *Service) HandleListAuditLogs(c *gin.Context) {
+	ctx := c.Request.Context()
+	log := logger.FromGinContext(c)
+
+	filter := db.AuditLogFilter{
+		ResourceType: c.Query("resource_type"),
+		ResourceID:   c.Query("resource_id"),
+		DocumentID:   c.Query("document_id"),
+	}
+	if u := c.Query("user_id"); u != "" {
+		v, err := strconv.ParseInt(u, 10, 64)
+		if err != nil {
+			hutil.AbortError(c, http.StatusBadRequest, "invalid user_id")
+			return
+		}
+		filter.UserID = &v
+	}
+	var marker int64
+	if m := c.Query("marker"); m != "" {
+		v, err := strconv.ParseInt(m, 10, 64)
+		if err != nil || v <= 0 {
+			hutil.AbortError(c, http.StatusBadRequest, "invalid marker")
+			return
+		}
+		marker = v
+	}
+	limit := defaultAuditLogLimit
+	if l := c.Query("limit"); l != "" {
+		v, err := strconv.Atoi(l)
+		if err != nil || v <= 0 {
+			hutil.AbortError(c, http.StatusBadRequest, "invalid limit")
+			return
+		}
+		limit = min(v, maxAuditLogLimit)
+	}
+
+	log.Infof("List audit logs, filter: %+v, marker: %d, limit: %d", filter, marker, limit)
+	logs, err := s.db.ListAuditLogs(ctx, filter, marker, limit)
+	if err != nil {
+		log.Errorf("Failed to list audit logs, err: %v", err)
+		hutil.AbortError(c, hutil.ErrServerInternalCode, "list audit logs failed")
+		return
+	}
+
+	ret := &api.ListAuditLogsResult{AuditLogs: []api.AuditLog{}}
+	for _, l := range logs {
+		item := api.AuditLog{
+			ID:           strconv.FormatInt(l.ID, 10),
+			UserID:       l.UserID,
+			UserName:     l.UserName,
+			Action:       l.Action,
+			ResourceType: l.ResourceType,
+			ResourceID:   l.ResourceID,
+			DocumentID:   l.DocumentID,
+			Route:        l.Route,
+			RequestID:    l.RequestID,
+			CreatedAt:    l.CreatedAt.Format(time.DateTime),
+		}
+		if l.Before != "" {
+			item.Before = json.RawMessage(l.Before)
+		}
+		if l.After != "" {
+			item.After = json.RawMessage(l.After)
+		}
+		ret.AuditLogs = append(ret.AuditLogs, item)
+	}
+	// 取满一页说明可能还有更多
+	if len(logs) == limit {
+		ret.NextMarker = ret.AuditLogs[len(ret.AuditLogs)-1].ID
+	}
+	hutil.WriteData(c, ret)
+}
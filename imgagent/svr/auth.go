@@ -82,8 +82,22 @@ func (s *Service) Auth() gin.HandlerFunc {
 	}
 }
 
+// NilAuth 不做认证，所有请求视为默认租户（id 为 0）的普通用户；
+// 管理接口必须使用 Auth 认证，不能挂在 NilAuth 之后
 func (s *Service) NilAuth() gin.HandlerFunc {
 	return func(c *gin.Context) {
+		c.Set(userInfoKey, UserInfo{})
+		c.Next()
+	}
+}
+
+// SuperAdminOnly 只允许超级管理员访问，需在认证之后使用
+func (s *Service) SuperAdminOnly() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		if !GetUserInfo(c).SuperAdmin {
+			hutil.AbortError(c, http.StatusForbidden, "super admin required")
+			return
+		}
 		c.Next()
 	}
 }
@@ -0,0 +1,183 @@
+package svr
+
+import (
+	"context"
+	"fmt"
+	"sort"
+
+	"imgagent/bailian"
+	"imgagent/db"
+	"imgagent/pkg/logger"
+	"imgagent/pkg/redisutil"
+)
+
+const defaultBackfillBatchSize = 50
+
+// backfillStage 对单个历史文档补跑新增的处理步骤，必须可重复执行
+type backfillStage func(ctx context.Context, b *Backfiller, doc db.Document) error
+
+// backfillStages 可回填的阶段，新增处理步骤需要覆盖历史文档时在此注册
+var backfillStages = map[string]backfillStage{
+	"search":   backfillSearch,
+	"timing":   backfillTiming,
+	"name_key": backfillNameKey,
+}
+
+// BackfillStages 返回已注册的回填阶段
+func BackfillStages() []string {
+	stages := make([]string, 0, len(backfillStages))
+	for name := range backfillStages {
+		stages = append(stages, name)
+	}
+	sort.Strings(stages)
+	return stages
+}
+
+type BackfillOptions struct {
+	Stages    []string
+	BatchSize int  // 每批处理的文档数，每批结束后保存进度
+	Reset     bool // 忽略已保存的进度，从头开始
+}
+
+// Backfiller 按文档 id 顺序分批对历史文档执行回填阶段，进度保存在数据库中，中断后重新执行会从上次位置继续
+type Backfiller struct {
+	db            db.IDataBase
+	bailianClient *bailian.Client
+}
+
+func newBackfiller(database db.IDataBase, bailianClient *bailian.Client) *Backfiller {
+	return &Backfiller{
+		db:            database,
+		bailianClient: bailianClient,
+	}
+}
+
+// Backfill 执行回填命令
+func Backfill(ctx context.Context, conf Config, bailianClient *bailian.Client, opts BackfillOptions) error {
+	rdb := redisutil.NewClient(conf.Redis)
+	if rdb != nil {
+		defer rdb.Close()
+	}
+	database, err := openDatabase(conf, rdb)
+	if err != nil {
+		return err
+	}
+	defer database.Close()
+
+	return newBackfiller(database, bailianClient).Run(ctx, opts)
+}
+
+// Run 依次执行各阶段，ctx 取消时保存当前进度后返回
+func (b *Backfiller) Run(ctx context.Context, opts BackfillOptions) error {
+	if opts.BatchSize <= 0 {
+		opts.BatchSize = defaultBackfillBatchSize
+	}
+	if len(opts.Stages) == 0 {
+		return fmt.Errorf("no backfill stage, available: %v", BackfillStages())
+	}
+	for _, name := range opts.Stages {
+		if _, ok := backfillStages[name]; !ok {
+			return fmt.Errorf("unknown backfill stage %q, available: %v", name, BackfillStages())
+		}
+	}
+
+	for _, name := range opts.Stages {
+		err := b.runStage(ctx, name, opts)
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (b *Backfiller) runStage(ctx context.Context, name string, opts BackfillOptions) error {
+	log := logger.FromContext(ctx)
+	stage := backfillStages[name]
+
+	if opts.Reset {
+		err := b.db.DeleteBackfillProgress(ctx, name)
+		if err != nil {
+			return err
+		}
+	}
+	progress, err := b.db.GetBackfillProgress(ctx, name)
+	if err != nil {
+		return err
+	}
+	if progress.Done {
+		log.Infof("Backfill stage already done, stage: %s, processed: %d, failed: %d", name, progress.Processed, progress.Failed)
+		return nil
+	}
+	log.Infof("Backfill stage start, stage: %s, after: %s, processed: %d", name, progress.LastDocumentID, progress.Processed)
+
+	for {
+		docs, err := b.db.ListDocumentsAfter(ctx, progress.LastDocumentID, opts.BatchSize)
+		if err != nil {
+			return err
+		}
+		if len(docs) == 0 {
+			progress.Done = true
+			log.Infof("Backfill stage done, stage: %s, processed: %d, failed: %d", name, progress.Processed, progress.Failed)
+			return b.db.SaveBackfillProgress(ctx, &progress)
+		}
+
+		for _, doc := range docs {
+			if ctx.Err() != nil {
+				break
+			}
+			// 单个文档失败只记录，不阻塞后续文档
+			err := safeCall(ctx, func(ctx context.Context) error {
+				return stage(ctx, b, doc)
+			})
+			if err != nil {
+				log.Errorf("Failed to backfill document, stage: %s, doc: %s, err: %v", name, doc.ID, err)
+				progress.Failed++
+			}
+			progress.Processed++
+			progress.LastDocumentID = doc.ID
+		}
+
+		// 进度保存与 ctx 无关，确保中断时已处理的文档不会重做
+		err = b.db.SaveBackfillProgress(context.WithoutCancel(ctx), &progress)
+		if err != nil {
+			return err
+		}
+		log.Infof("Backfill progress, stage: %s, processed: %d, failed: %d, last: %s", name, progress.Processed, progress.Failed, progress.LastDocumentID)
+		if ctx.Err() != nil {
+			return ctx.Err()
+		}
+	}
+}
+
+// backfillSearch 重建文档的搜索索引
+func backfillSearch(ctx context.Context, b *Backfiller, doc db.Document) error {
+	x := &Indexer{db: b.db}
+	return x.indexDocument(ctx, doc.ID)
+}
+
+// backfillTiming 为语音时长尚未记录的场景补充时间轴
+func backfillTiming(ctx context.Context, b *Backfiller, doc db.Document) error {
+	scenes, err := b.db.ListScenesByDocument(ctx, doc.ID)
+	if err != nil {
+		return err
+	}
+	for _, scene := range scenes {
+		if scene.VoiceURL == "" || scene.AudioDurationMs > 0 {
+			continue
+		}
+		audioMs, displayMs := sceneTiming(ctx, b.bailianClient, scene.VoiceURL, scene.Content)
+		err = b.db.UpdateSceneTiming(ctx, scene.ID, audioMs, displayMs)
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// backfillNameKey 为历史文档补齐归一化后的文档名，用于重名判断
+func backfillNameKey(ctx context.Context, b *Backfiller, doc db.Document) error {
+	if doc.NameKey == db.DocumentNameKey(doc.Name) {
+		return nil
+	}
+	return b.db.UpdateDocumentNameKey(ctx, doc.ID)
+}
@@ -0,0 +1,296 @@
+package svr
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"io"
+	"net/http"
+	"os"
+	"regexp"
+	"time"
+
+	"github.com/gin-gonic/gin"
+
+	"imgagent/api"
+	"imgagent/db"
+	hutil "imgagent/httputil"
+	"imgagent/pkg/logger"
+	"imgagent/pkg/mediastore"
+	"imgagent/storage"
+)
+
+const defaultBackupPrefix = "backups/"
+
+// backupIDPattern 备份 id 由创建时间和随机后缀组成，恢复时校验，避免拼出任意的对象 key
+var backupIDPattern = regexp.MustCompile(`^\d{8}T\d{6}-[0-9a-f]{8}$`)
+
+// BackupConfig 备份保存在 storage 配置的对象存储中，key 为 <prefix><id>/db.jsonl.gz 和 <prefix><id>/media.json
+type BackupConfig struct {
+	Prefix string `json:"prefix"` // 对象 key 的前缀，默认 backups/
+	// AllowRestore 恢复会清空并覆盖全部表，默认只允许 dry_run，需要恢复时显式开启
+	AllowRestore bool `json:"allow_restore"`
+}
+
+// objectStore 保存备份的对象存储，由 storage.Storage 实现
+type objectStore interface {
+	PutObject(ctx context.Context, key string, r io.Reader) error
+	GetObject(ctx context.Context, key string) (io.ReadCloser, error)
+}
+
+func (s *Service) backupKey(id, name string) string {
+	prefix := s.conf.Backup.Prefix
+	if prefix == "" {
+		prefix = defaultBackupPrefix
+	}
+	return prefix + id + "/" + name
+}
+
+// HandleCreateBackup 把数据库快照（可选媒体文件清单）保存到对象存储
+func (s *Service) HandleCreateBackup(c *gin.Context) {
+	log := logger.FromGinContext(c)
+
+	var args api.CreateBackupArgs
+	if c.Request.ContentLength != 0 {
+		if err := c.ShouldBindJSON(&args); err != nil {
+			log.Errorf("Invalid request body, err: %v", err)
+			hutil.AbortError(c, http.StatusBadRequest, "invalid request body")
+			return
+		}
+	}
+	ret, err := s.CreateBackup(c.Request.Context(), args.IncludeMedia)
+	if err != nil {
+		hutil.AbortErr(c, err)
+		return
+	}
+	hutil.WriteData(c, ret)
+}
+
+// CreateBackup 快照先写入临时文件再上传，上传期间不占用数据库事务
+func (s *Service) CreateBackup(ctx context.Context, includeMedia bool) (*api.Backup, error) {
+	log := logger.FromContext(ctx)
+
+	if s.backups == nil {
+		return nil, hutil.NewApiError(http.StatusServiceUnavailable, "backup disabled")
+	}
+	if !s.backupMu.TryLock() {
+		return nil, hutil.NewApiError(http.StatusConflict, "backup or restore in progress")
+	}
+	defer s.backupMu.Unlock()
+
+	now := time.Now()
+	id := now.Format("20060102T150405") + "-" + db.MakeUUID()[:8]
+	log.Infof("Create backup, id: %s, includeMedia: %v", id, includeMedia)
+
+	f, err := os.CreateTemp(s.conf.Temp, "backup-*.jsonl.gz")
+	if err != nil {
+		log.Errorf("Failed to create temp file, err: %v", err)
+		return nil, hutil.NewApiError(hutil.ErrServerInternalCode, "create backup failed")
+	}
+	defer os.Remove(f.Name())
+	defer f.Close()
+	stats, err := s.db.WriteSnapshot(ctx, f)
+	if err != nil {
+		log.Errorf("Failed to write snapshot, err: %v", err)
+		return nil, hutil.NewApiError(hutil.ErrServerInternalCode, "create backup failed")
+	}
+	size, err := f.Seek(0, io.SeekCurrent)
+	if err == nil {
+		_, err = f.Seek(0, io.SeekStart)
+	}
+	if err != nil {
+		log.Errorf("Failed to seek snapshot, err: %v", err)
+		return nil, hutil.NewApiError(hutil.ErrServerInternalCode, "create backup failed")
+	}
+
+	ret := &api.Backup{
+		ID:        id,
+		Key:       s.backupKey(id, "db.jsonl.gz"),
+		Size:      size,
+		Tables:    stats.Tables,
+		CreatedAt: now.Format(time.DateTime),
+	}
+	if includeMedia {
+		manifest, err := s.mediaManifest()
+		if err != nil {
+			log.Errorf("Failed to list media files, err: %v", err)
+			return nil, hutil.NewApiError(hutil.ErrServerInternalCode, "create backup failed")
+		}
+		data, err := json.Marshal(manifest)
+		if err != nil {
+			return nil, hutil.NewApiError(hutil.ErrServerInternalCode, "create backup failed")
+		}
+		if err = s.backups.PutObject(ctx, s.backupKey(id, "media.json"), bytes.NewReader(data)); err != nil {
+			log.Errorf("Failed to upload media manifest, err: %v", err)
+			return nil, hutil.NewApiError(hutil.ErrServerInternalCode, "upload backup failed")
+		}
+		ret.MediaFiles = len(manifest.Images) + len(manifest.Audio)
+	}
+	// 快照最后上传，存在快照的备份都是完整的
+	if err = s.backups.PutObject(ctx, ret.Key, f); err != nil {
+		log.Errorf("Failed to upload snapshot, err: %v", err)
+		return nil, hutil.NewApiError(hutil.ErrServerInternalCode, "upload backup failed")
+	}
+	log.Infof("Backup created, id: %s, size: %d, tables: %v", id, size, stats.Tables)
+	return ret, nil
+}
+
+func (s *Service) mediaManifest() (*api.MediaManifest, error) {
+	images, err := listMediaFiles(s.imageStore)
+	if err != nil {
+		return nil, err
+	}
+	audio, err := listMediaFiles(s.audioStore)
+	if err != nil {
+		return nil, err
+	}
+	return &api.MediaManifest{Images: images, Audio: audio}, nil
+}
+
+func listMediaFiles(store *mediastore.Store) ([]api.MediaFile, error) {
+	ret := []api.MediaFile{}
+	if store == nil {
+		return ret, nil
+	}
+	files, err := store.List()
+	if err != nil {
+		return nil, err
+	}
+	for _, f := range files {
+		ret = append(ret, api.MediaFile{Name: f.Name, Size: f.Size})
+	}
+	return ret, nil
+}
+
+// HandleRestoreBackup 用备份替换数据库中的全部数据，dry_run 时只校验；非 dry_run 时 confirm 需与 id 相同。
+// 恢复期间其他请求和后台任务仍在运行，应在维护窗口中执行
+func (s *Service) HandleRestoreBackup(c *gin.Context) {
+	log := logger.FromGinContext(c)
+
+	var args api.RestoreBackupArgs
+	if err := c.ShouldBindJSON(&args); err != nil {
+		log.Errorf("Invalid request body, err: %v", err)
+		hutil.AbortError(c, http.StatusBadRequest, "invalid request body")
+		return
+	}
+	if !args.DryRun && args.Confirm != args.ID {
+		hutil.AbortError(c, http.StatusBadRequest, "confirm must be the backup id")
+		return
+	}
+	setAuditResource(c, args.ID)
+	ret, err := s.RestoreBackup(c.Request.Context(), args.ID, args.DryRun)
+	if err != nil {
+		hutil.AbortErr(c, err)
+		return
+	}
+	hutil.WriteData(c, ret)
+}
+
+// RestoreBackup 快照先下载到临时文件，再在一个事务中恢复
+func (s *Service) RestoreBackup(ctx context.Context, id string, dryRun bool) (*api.RestoreResult, error) {
+	log := logger.FromContext(ctx)
+
+	if s.backups == nil {
+		return nil, hutil.NewApiError(http.StatusServiceUnavailable, "backup disabled")
+	}
+	if !backupIDPattern.MatchString(id) {
+		return nil, hutil.NewApiError(http.StatusBadRequest, "invalid backup id")
+	}
+	if !dryRun && !s.conf.Backup.AllowRestore {
+		return nil, hutil.NewApiError(http.StatusForbidden, "restore disabled")
+	}
+	if !s.backupMu.TryLock() {
+		return nil, hutil.NewApiError(http.StatusConflict, "backup or restore in progress")
+	}
+	defer s.backupMu.Unlock()
+	log.Infof("Restore backup, id: %s, dryRun: %v", id, dryRun)
+
+	rc, err := s.backups.GetObject(ctx, s.backupKey(id, "db.jsonl.gz"))
+	if err != nil {
+		log.Errorf("Failed to get snapshot, id: %s, err: %v", id, err)
+		return nil, backupError(err, "get backup failed")
+	}
+	f, err := os.CreateTemp(s.conf.Temp, "restore-*.jsonl.gz")
+	if err != nil {
+		rc.Close()
+		log.Errorf("Failed to create temp file, err: %v", err)
+		return nil, hutil.NewApiError(hutil.ErrServerInternalCode, "restore backup failed")
+	}
+	defer os.Remove(f.Name())
+	defer f.Close()
+	_, err = io.Copy(f, rc)
+	rc.Close()
+	if err == nil {
+		_, err = f.Seek(0, io.SeekStart)
+	}
+	if err != nil {
+		log.Errorf("Failed to download snapshot, id: %s, err: %v", id, err)
+		return nil, hutil.NewApiError(hutil.ErrServerInternalCode, "get backup failed")
+	}
+
+	stats, err := s.db.RestoreSnapshot(ctx, f, dryRun)
+	if err != nil {
+		log.Errorf("Failed to restore snapshot, id: %s, dryRun: %v, err: %v", id, dryRun, err)
+		if errors.Is(err, db.ErrInvalidSnapshot) {
+			return nil, hutil.NewApiError(http.StatusBadRequest, err.Error())
+		}
+		return nil, hutil.NewApiError(hutil.ErrServerInternalCode, "restore backup failed")
+	}
+	ret := &api.RestoreResult{
+		ID:              id,
+		DryRun:          dryRun,
+		BackupCreatedAt: stats.Header.CreatedAt.Format(time.DateTime),
+		Tables:          stats.Tables,
+	}
+	ret.MissingMedia, err = s.missingMedia(ctx, id)
+	if err != nil {
+		log.Errorf("Failed to check media files, id: %s, err: %v", id, err)
+		return nil, backupError(err, "get media manifest failed")
+	}
+	log.Infof("Backup restored, id: %s, dryRun: %v, tables: %v, missingMedia: %d", id, dryRun, stats.Tables, len(ret.MissingMedia))
+	return ret, nil
+}
+
+// missingMedia 返回备份的媒体文件清单中本地不存在的文件，备份不包含清单时为空
+func (s *Service) missingMedia(ctx context.Context, id string) ([]string, error) {
+	rc, err := s.backups.GetObject(ctx, s.backupKey(id, "media.json"))
+	if errors.Is(err, storage.ErrObjectNotFound) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	defer rc.Close()
+	var manifest api.MediaManifest
+	if err = json.NewDecoder(rc).Decode(&manifest); err != nil {
+		return nil, err
+	}
+	current, err := s.mediaManifest()
+	if err != nil {
+		return nil, err
+	}
+	var missing []string
+	for _, pair := range []struct{ want, have []api.MediaFile }{
+		{manifest.Images, current.Images},
+		{manifest.Audio, current.Audio},
+	} {
+		have := make(map[string]bool, len(pair.have))
+		for _, f := range pair.have {
+			have[f.Name] = true
+		}
+		for _, f := range pair.want {
+			if !have[f.Name] {
+				missing = append(missing, f.Name)
+			}
+		}
+	}
+	return missing, nil
+}
+
+func backupError(err error, errMsg string) error {
+	if errors.Is(err, storage.ErrObjectNotFound) {
+		return hutil.NewApiError(http.StatusNotFound, "backup not found")
+	}
+	return hutil.NewApiError(hutil.ErrServerInternalCode, errMsg)
+}
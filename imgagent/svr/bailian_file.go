@@ -0,0 +1,117 @@
+package svr
+
+import (
+	"context"
+	"fmt"
+	"path/filepath"
+	"regexp"
+	"time"
+
+	"imgagent/pkg/logger"
+	"imgagent/pkg/tracing"
+)
+
+const reconcileFilesPageSize = 100
+
+// bailianFilePrefixPattern 百炼文件名前缀只允许字母、数字和 -
+var bailianFilePrefixPattern = regexp.MustCompile(`^[A-Za-z0-9-]{1,32}$`)
+
+// uploadTempFilename 上传到百炼的原文临时文件名，文件名同时是百炼上的文件名：
+// 配置了 BailianFilePrefix 时为 <prefix>_<id>_temp.<ext>，孤立文件清理按 uploadedFilePattern 识别
+func uploadTempFilename(dir, prefix, id, ext string) string {
+	name := id + "_temp" + ext
+	if prefix != "" {
+		name = prefix + "_" + name
+	}
+	return filepath.Join(dir, name)
+}
+
+// uploadedFilePattern 本部署上传到百炼的文件名。清理孤立文件时只处理匹配的文件，
+// 共用同一 API key 的其他服务、以及本服务的其他部署（如 staging 与 prod）使用不同前缀，互不影响
+func uploadedFilePattern(prefix string) *regexp.Regexp {
+	return regexp.MustCompile(`^` + regexp.QuoteMeta(prefix) + `_[0-9a-f]{32}_temp\.\w+$`)
+}
+
+// deleteRemoteFile 删除文档在百炼上的文件，失败只记录日志，由孤立文件清理兜底
+func (s *Service) deleteRemoteFile(ctx context.Context, fileID string) {
+	if fileID == "" || s.bailianClient == nil {
+		return
+	}
+	err := s.bailianClient.DeleteFile(ctx, fileID)
+	if err != nil {
+		logger.FromContext(ctx).Warnf("Failed to delete Bailian file, fileID: %s, err: %v", fileID, err)
+	}
+}
+
+func (m *DocumentMgr) loopReconcileFiles() {
+	ticker := time.NewTicker(time.Second * time.Duration(m.config.ReconcileFilesIntervalSecs))
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ticker.C:
+			ctx, span := tracing.Start(logger.NewContext(fmt.Sprintf("ReconcileFiles-%d", time.Now().Unix())), "DocumentMgr.ReconcileFiles")
+			m.ReconcileFiles(logger.WithTrace(ctx))
+			span.End()
+		case <-m.close:
+			return
+		}
+	}
+}
+
+// ReconcileFiles 删除百炼上不被任何文档引用的文件：文档删除时远端删除失败、或上传后创建文档失败留下的文件。
+// 上传后 OrphanFileGraceSecs 内的文件可能属于正在创建的文档，不做处理
+func (m *DocumentMgr) ReconcileFiles(ctx context.Context) {
+	log := logger.FromContext(ctx)
+	deadline := time.Now().Add(-time.Duration(m.config.OrphanFileGraceSecs) * time.Second).Unix()
+	if m.config.BailianFilePrefix == "" {
+		log.Warnf("Bailian file prefix not set, skip reconciling files")
+		return
+	}
+	pattern := uploadedFilePattern(m.config.BailianFilePrefix)
+
+	var after string
+	deleted := 0
+	for !m.stopping() {
+		page, err := m.bailianClient.ListFiles(ctx, after, reconcileFilesPageSize)
+		if err != nil {
+			log.Errorf("Failed to list Bailian files, err: %v", err)
+			return
+		}
+
+		var candidates []string
+		for _, f := range page.Data {
+			if pattern.MatchString(f.Filename) && f.CreatedAt < deadline {
+				candidates = append(candidates, f.ID)
+			}
+		}
+		referenced, err := m.db.ListReferencedFileIDs(ctx, candidates)
+		if err != nil {
+			log.Errorf("Failed to list referenced files, err: %v", err)
+			return
+		}
+		inUse := make(map[string]bool, len(referenced))
+		for _, id := range referenced {
+			inUse[id] = true
+		}
+		for _, id := range candidates {
+			if inUse[id] {
+				continue
+			}
+			err = m.bailianClient.DeleteFile(ctx, id)
+			if err != nil {
+				log.Errorf("Failed to delete orphaned Bailian file, fileID: %s, err: %v", id, err)
+				continue
+			}
+			deleted++
+		}
+
+		if !page.HasMore || len(page.Data) == 0 {
+			break
+		}
+		after = page.Data[len(page.Data)-1].ID
+	}
+	if deleted > 0 {
+		log.Infof("Deleted orphaned Bailian files, count: %d", deleted)
+	}
+}
@@ -0,0 +1,177 @@
+package svr
+
+import (
+	"context"
+	"errors"
+	"io"
+	"net/http"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"gorm.io/gorm"
+
+	"imgagent/api"
+	"imgagent/db"
+	hutil "imgagent/httputil"
+	"imgagent/pkg/logger"
+)
+
+const (
+	// bgmKeyPrefix 背景音乐在对象存储中的 key 前缀
+	bgmKeyPrefix = "bgm/"
+	// defaultBGMVolume 上传时未指定音量的默认值，保证旁白清晰
+	defaultBGMVolume = 0.2
+)
+
+// bgmExts 允许上传的背景音乐格式
+var bgmExts = map[string]bool{".mp3": true, ".wav": true, ".m4a": true, ".aac": true, ".ogg": true}
+
+// bgmStore 保存背景音乐的对象存储，由 storage.Storage 实现
+type bgmStore interface {
+	PutObject(ctx context.Context, key string, r io.Reader) error
+	MakeURL(key string) string
+}
+
+// HandleCreateBGMTrack 上传背景音乐到对象存储并加入曲目库
+func (s *Service) HandleCreateBGMTrack(c *gin.Context) {
+	ctx := c.Request.Context()
+	log := logger.FromGinContext(c)
+
+	if s.bgm == nil {
+		hutil.AbortError(c, http.StatusServiceUnavailable, "bgm storage disabled")
+		return
+	}
+	name := strings.TrimSpace(c.PostForm("name"))
+	if name == "" || len([]rune(name)) > 100 {
+		hutil.AbortError(c, http.StatusBadRequest, "invalid name")
+		return
+	}
+	volume := defaultBGMVolume
+	if v := c.PostForm("volume"); v != "" {
+		var err error
+		volume, err = strconv.ParseFloat(v, 64)
+		if err != nil || volume < 0 || volume > 1 {
+			hutil.AbortError(c, http.StatusBadRequest, "volume must be between 0 and 1")
+			return
+		}
+	}
+	file, err := c.FormFile("file")
+	if err != nil {
+		log.Errorf("Failed to get file, err: %v", err)
+		hutil.AbortError(c, http.StatusBadRequest, "file is required")
+		return
+	}
+	if file.Size > s.uploads.maxBytes() {
+		hutil.AbortErr(c, s.uploads.sizeError())
+		return
+	}
+	ext := strings.ToLower(filepath.Ext(file.Filename))
+	if !bgmExts[ext] {
+		hutil.AbortError(c, http.StatusBadRequest, "unsupported audio format, available: .aac,.m4a,.mp3,.ogg,.wav")
+		return
+	}
+	f, err := file.Open()
+	if err != nil {
+		log.Errorf("Failed to open file, err: %v", err)
+		hutil.AbortError(c, http.StatusBadRequest, "file is required")
+		return
+	}
+	defer f.Close()
+
+	id := db.MakeUUID()
+	key := bgmKeyPrefix + id + ext
+	log.Infof("Create bgm track, id: %s, name: %s, key: %s", id, name, key)
+	if err = s.bgm.PutObject(ctx, key, f); err != nil {
+		log.Errorf("Failed to upload bgm, key: %s, err: %v", key, err)
+		hutil.AbortError(c, hutil.ErrServerInternalCode, "upload bgm failed")
+		return
+	}
+	now := time.Now()
+	track := db.BGMTrack{ID: id, Name: name, Key: key, URL: s.bgm.MakeURL(key), Volume: volume, CreatedAt: now, UpdatedAt: now}
+	if err = s.db.CreateBGMTrack(ctx, &track); err != nil {
+		log.Errorf("Failed to create bgm track, err: %v", err)
+		hutil.AbortError(c, hutil.ErrServerInternalCode, "create bgm track failed")
+		return
+	}
+	hutil.WriteData(c, makeBGMTrack(&track))
+}
+
+// HandleListBGMTracks 列取背景音乐曲目，文档通过 bgm_id 选用
+func (s *Service) HandleListBGMTracks(c *gin.Context) {
+	log := logger.FromGinContext(c)
+
+	tracks, err := s.db.ListBGMTracks(c.Request.Context())
+	if err != nil {
+		log.Errorf("Failed to list bgm tracks, err: %v", err)
+		hutil.AbortError(c, hutil.ErrServerInternalCode, "list bgm tracks failed")
+		return
+	}
+	ret := &api.ListBGMTracksResult{Tracks: make([]api.BGMTrack, 0, len(tracks))}
+	for i := range tracks {
+		ret.Tracks = append(ret.Tracks, makeBGMTrack(&tracks[i]))
+	}
+	hutil.WriteData(c, ret)
+}
+
+// HandleDeleteBGMTrack 从曲目库中删除背景音乐，对象存储中的文件保留，已导出的播放清单仍可使用
+func (s *Service) HandleDeleteBGMTrack(c *gin.Context) {
+	log := logger.FromGinContext(c)
+
+	id := c.Param("id")
+	log.Infof("Delete bgm track, id: %s", id)
+	err := s.db.DeleteBGMTrack(c.Request.Context(), id)
+	if errors.Is(err, gorm.ErrRecordNotFound) {
+		hutil.AbortError(c, http.StatusNotFound, "bgm track not found")
+		return
+	}
+	if err != nil {
+		log.Errorf("Failed to delete bgm track, id: %s, err: %v", id, err)
+		hutil.AbortError(c, hutil.ErrServerInternalCode, "delete bgm track failed")
+		return
+	}
+	hutil.WriteData(c, nil)
+}
+
+// checkBGM 校验文档选用的曲目存在，空字符串表示不使用背景音乐
+func (s *Service) checkBGM(ctx context.Context, id string) error {
+	if id == "" {
+		return nil
+	}
+	_, err := s.db.GetBGMTrack(ctx, id)
+	if errors.Is(err, gorm.ErrRecordNotFound) {
+		return hutil.NewApiError(http.StatusBadRequest, "unknown bgm_id")
+	}
+	if err != nil {
+		logger.FromContext(ctx).Errorf("Failed to get bgm track, id: %s, err: %v", id, err)
+		return hutil.NewApiError(hutil.ErrServerInternalCode, "get bgm track failed")
+	}
+	return nil
+}
+
+// manifestBGM 文档选用的背景音乐，未选用或曲目已删除时返回 nil
+func manifestBGM(ctx context.Context, database db.IDataBase, doc *db.Document) (*api.ManifestBGM, error) {
+	if doc.BGMID == "" {
+		return nil, nil
+	}
+	track, err := database.GetBGMTrack(ctx, doc.BGMID)
+	if errors.Is(err, gorm.ErrRecordNotFound) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	return &api.ManifestBGM{ID: track.ID, Name: track.Name, URL: track.URL, Volume: track.Volume}, nil
+}
+
+func makeBGMTrack(t *db.BGMTrack) api.BGMTrack {
+	return api.BGMTrack{
+		ID:        t.ID,
+		Name:      t.Name,
+		URL:       t.URL,
+		Volume:    t.Volume,
+		CreatedAt: t.CreatedAt.Format(time.DateTime),
+	}
+}
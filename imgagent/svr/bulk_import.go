@@ -0,0 +1,423 @@
+package svr
+
+import (
+	"archive/tar"
+	"archive/zip"
+	"compress/gzip"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"mime/multipart"
+	"net/http"
+	"os"
+	"path"
+	"path/filepath"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"gorm.io/gorm"
+
+	"imgagent/api"
+	"imgagent/db"
+	hutil "imgagent/httputil"
+	"imgagent/pkg/logger"
+	"imgagent/proto"
+)
+
+const (
+	maxImportEntries   = 100       // 归档中最多的文件数
+	maxImportEntrySize = 50 << 20  // 单个文件解压后的最大字节数
+	maxImportTotalSize = 200 << 20 // 归档解压后的最大总字节数
+)
+
+// documentExts 可上传和导入的文件类型，与 spliter 支持的格式一致
+var documentExts = map[string]bool{".txt": true, ".md": true, ".doc": true, ".docx": true, ".pdf": true}
+
+// archiveExts 批量导入支持的归档格式，见 archiveWalker
+var archiveExts = []string{".zip", ".tar.gz", ".tgz"}
+
+// importRunner 后台逐个创建批量导入的文档，停止时未处理的文件标记为失败
+type importRunner struct {
+	close     chan bool
+	closeOnce sync.Once
+	wg        sync.WaitGroup
+}
+
+func newImportRunner() *importRunner {
+	return &importRunner{close: make(chan bool)}
+}
+
+func (r *importRunner) Go(fn func()) {
+	r.wg.Add(1)
+	go func() {
+		defer r.wg.Done()
+		fn()
+	}()
+}
+
+// Stop 不再开始新的文件，等待处理中的文件完成
+func (r *importRunner) Stop(ctx context.Context) error {
+	r.closeOnce.Do(func() { close(r.close) })
+	done := make(chan struct{})
+	go func() {
+		r.wg.Wait()
+		close(done)
+	}()
+	select {
+	case <-done:
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+func (r *importRunner) stopping() bool {
+	select {
+	case <-r.close:
+		return true
+	default:
+		return false
+	}
+}
+
+// HandleDocumentsAction 处理 /documents:<action> 形式的集合操作，目前只有 :bulk
+func (s *Service) HandleDocumentsAction(c *gin.Context) {
+	switch c.Param("action") {
+	case ":bulk":
+		s.HandleBulkImport(c)
+	case ":import":
+		s.HandleImportBundle(c)
+	default:
+		hutil.AbortError(c, http.StatusNotFound, "not found")
+	}
+}
+
+// HandleBulkImport 上传 zip 或 tar.gz 归档，归档中的每个文件创建一个文档，文档名称为去掉扩展名的文件名。
+// 解压和校验同步完成，文档在后台逐个创建，返回批量导入 id，通过 GET /imports/:id 查看每个文件的结果
+func (s *Service) HandleBulkImport(c *gin.Context) {
+	ctx := c.Request.Context()
+	log := logger.FromGinContext(c)
+	ui := GetUserInfo(c)
+
+	priority := c.PostForm("priority")
+	if _, err := parseDocumentPriority(priority); err != nil {
+		hutil.AbortErr(c, err)
+		return
+	}
+	autoRename := c.PostForm("auto_rename") == "true"
+	file, err := c.FormFile("file")
+	if err != nil {
+		log.Errorf("Failed to get file, err: %v", err)
+		hutil.AbortError(c, http.StatusBadRequest, "file is required")
+		return
+	}
+	walk, ok := archiveWalker(file.Filename)
+	if !ok {
+		hutil.AbortError(c, http.StatusBadRequest, "archive must be .zip, .tar.gz or .tgz")
+		return
+	}
+	f, err := file.Open()
+	if err != nil {
+		log.Errorf("Failed to open file, err: %v", err)
+		hutil.AbortError(c, http.StatusBadRequest, "file is required")
+		return
+	}
+	defer f.Close()
+
+	batch := db.ImportBatch{
+		ID:       db.MakeUUID(),
+		UserID:   ui.ID,
+		Filename: file.Filename,
+		Status:   db.ImportBatchStatusRunning,
+	}
+	log.Infof("Bulk import, batch: %s, archive: %s, size: %d, userID: %d", batch.ID, file.Filename, file.Size, ui.ID)
+
+	// 每个文件按序号保存，不使用归档中的路径，避免路径穿越
+	dir := filepath.Join(s.conf.Temp, "import_"+batch.ID)
+	err = os.MkdirAll(dir, 0776)
+	if err != nil {
+		log.Errorf("Failed to mkdir, err: %v", err)
+		hutil.AbortError(c, hutil.ErrServerInternalCode, "save file failed")
+		return
+	}
+	items, err := extractImport(walk, f, file.Size, batch.ID, dir, s.names, s.uploads)
+	if err == nil && len(items) == 0 {
+		err = hutil.NewApiError(http.StatusBadRequest, "archive contains no files")
+	}
+	if err != nil {
+		log.Warnf("Failed to extract archive, batch: %s, err: %v", batch.ID, err)
+		os.RemoveAll(dir)
+		hutil.AbortErr(c, err)
+		return
+	}
+
+	err = s.db.CreateImportBatch(ctx, &batch, items)
+	if err != nil {
+		log.Errorf("Failed to create import batch, err: %v", err)
+		os.RemoveAll(dir)
+		hutil.AbortError(c, hutil.ErrServerInternalCode, "create import batch failed")
+		return
+	}
+
+	// 请求返回后继续执行，保留 logger 和 trace
+	runCtx := context.WithoutCancel(ctx)
+	audit := newAuditLog(c, auditDocument, "bulk_import")
+	s.imports.Go(func() {
+		s.runImport(runCtx, batch, items, dir, priority, autoRename, audit)
+	})
+	hutil.WriteAccepted(c, makeImportBatch(&batch, items, s.baseURL()))
+}
+
+// archiveWalker 按扩展名选择归档格式，fn 对每个普通文件调用一次
+func archiveWalker(filename string) (func(f multipart.File, size int64, fn func(name string, r io.Reader) error) error, bool) {
+	lower := strings.ToLower(filename)
+	switch {
+	case strings.HasSuffix(lower, ".zip"):
+		return walkZip, true
+	case strings.HasSuffix(lower, ".tar.gz"), strings.HasSuffix(lower, ".tgz"):
+		return walkTarGz, true
+	}
+	return nil, false
+}
+
+func walkZip(f multipart.File, size int64, fn func(name string, r io.Reader) error) error {
+	zr, err := zip.NewReader(f, size)
+	if err != nil {
+		return hutil.NewApiError(http.StatusBadRequest, "invalid zip archive")
+	}
+	for _, zf := range zr.File {
+		if zf.FileInfo().IsDir() {
+			continue
+		}
+		rc, err := zf.Open()
+		if err != nil {
+			return hutil.NewApiError(http.StatusBadRequest, "invalid zip archive")
+		}
+		err = fn(zf.Name, rc)
+		rc.Close()
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func walkTarGz(f multipart.File, size int64, fn func(name string, r io.Reader) error) error {
+	gr, err := gzip.NewReader(f)
+	if err != nil {
+		return hutil.NewApiError(http.StatusBadRequest, "invalid tar.gz archive")
+	}
+	defer gr.Close()
+	tr := tar.NewReader(gr)
+	for {
+		hdr, err := tr.Next()
+		if err == io.EOF {
+			return nil
+		}
+		if err != nil {
+			return hutil.NewApiError(http.StatusBadRequest, "invalid tar.gz archive")
+		}
+		if hdr.Typeflag != tar.TypeReg {
+			continue
+		}
+		err = fn(hdr.Name, tr)
+		if err != nil {
+			return err
+		}
+	}
+}
+
+// extractImport 解压归档到 dir 并生成导入文件列表，无法导入的文件直接标记为失败；
+// 文件数或解压后的总大小超出限制时整个归档被拒绝
+func extractImport(walk func(f multipart.File, size int64, fn func(name string, r io.Reader) error) error,
+	f multipart.File, size int64, batchID, dir string, rules *documentNameRules, uploads *uploadRules) ([]db.ImportItem, error) {
+	var items []db.ImportItem
+	var total int64
+	names := make(map[string]bool)
+	err := walk(f, size, func(name string, r io.Reader) error {
+		base := path.Base(name)
+		// 跳过隐藏文件和 macOS 生成的元数据
+		if strings.HasPrefix(base, ".") || strings.HasPrefix(name, "__MACOSX/") {
+			return nil
+		}
+		if len(items) >= maxImportEntries {
+			return hutil.NewApiError(http.StatusBadRequest, fmt.Sprintf("archive exceeds maximum of %d files", maxImportEntries))
+		}
+		ext := strings.ToLower(path.Ext(base))
+		item := db.ImportItem{
+			BatchID:  batchID,
+			Index:    len(items),
+			Filename: name,
+			Name:     strings.TrimSpace(strings.TrimSuffix(base, path.Ext(base))),
+			Status:   db.ImportItemStatusPending,
+		}
+		defer func() { items = append(items, item) }()
+
+		if !uploads.allowed(ext) {
+			item.Status, item.Error = db.ImportItemStatusFailed, "unsupported file type"
+			return nil
+		}
+		if err := rules.Validate(item.Name); err != nil {
+			item.Status, item.Error = db.ImportItemStatusFailed, err.(*proto.ApiError).Message
+			return nil
+		}
+		key := db.DocumentNameKey(item.Name)
+		if names[key] {
+			item.Status, item.Error = db.ImportItemStatusFailed, "duplicate name in archive"
+			return nil
+		}
+		names[key] = true
+
+		filename := importItemPath(dir, item)
+		n, err := saveImportFile(filename, io.LimitReader(r, maxImportEntrySize+1))
+		if err != nil {
+			return err
+		}
+		if n > maxImportEntrySize {
+			os.Remove(filename)
+			item.Status, item.Error = db.ImportItemStatusFailed, fmt.Sprintf("file exceeds maximum size of %d bytes", maxImportEntrySize)
+			return nil
+		}
+		total += n
+		if total > maxImportTotalSize {
+			return hutil.NewApiError(http.StatusBadRequest, fmt.Sprintf("archive exceeds maximum total size of %d bytes", maxImportTotalSize))
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	return items, nil
+}
+
+func importItemPath(dir string, item db.ImportItem) string {
+	return filepath.Join(dir, fmt.Sprintf("%d%s", item.Index, strings.ToLower(path.Ext(item.Filename))))
+}
+
+func saveImportFile(filename string, r io.Reader) (int64, error) {
+	f, err := os.Create(filename)
+	if err != nil {
+		return 0, err
+	}
+	n, err := io.Copy(f, r)
+	if err != nil {
+		f.Close()
+		os.Remove(filename)
+		// 解压失败一般为归档损坏
+		return 0, hutil.NewApiError(http.StatusBadRequest, "invalid archive")
+	}
+	return n, f.Close()
+}
+
+// runImport 逐个创建待处理的文件，每个文件的结果在创建后立即更新，全部完成后删除解压目录。
+// 每个创建的文档按 audit 记录一条审计日志
+func (s *Service) runImport(ctx context.Context, batch db.ImportBatch, items []db.ImportItem, dir, priority string, autoRename bool, audit db.AuditLog) {
+	log := logger.FromContext(ctx)
+	defer os.RemoveAll(dir)
+
+	for _, item := range items {
+		if item.Status != db.ImportItemStatusPending {
+			continue
+		}
+		status, docID, errMsg := db.ImportItemStatusCreated, "", ""
+		if s.imports.stopping() {
+			status, errMsg = db.ImportItemStatusFailed, "interrupted by shutdown"
+		} else {
+			doc, err := s.importDocument(ctx, batch.UserID, item, dir, priority, autoRename)
+			if err != nil {
+				log.Warnf("Failed to import file, batch: %s, file: %s, err: %v", batch.ID, item.Filename, err)
+				status, errMsg = db.ImportItemStatusFailed, err.Error()
+				if ae, ok := err.(*proto.ApiError); ok {
+					errMsg = ae.Message
+				}
+			} else {
+				docID = doc.ID
+				s.auditCreated(ctx, audit, docID)
+			}
+		}
+		err := s.db.UpdateImportItem(ctx, item.ID, status, docID, errMsg)
+		if err != nil {
+			log.Errorf("Failed to update import item, batch: %s, item: %d, err: %v", batch.ID, item.ID, err)
+		}
+	}
+
+	err := s.db.UpdateImportBatchStatus(ctx, batch.ID, db.ImportBatchStatusFinished)
+	if err != nil {
+		log.Errorf("Failed to update import batch, batch: %s, err: %v", batch.ID, err)
+		return
+	}
+	log.Infof("Bulk import finished, batch: %s", batch.ID)
+}
+
+func (s *Service) importDocument(ctx context.Context, userID int64, item db.ImportItem, dir, priority string, autoRename bool) (*api.Document, error) {
+	f, err := os.Open(importItemPath(dir, item))
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	fi, err := f.Stat()
+	if err != nil {
+		return nil, err
+	}
+	return s.CreateDocument(ctx, userID, item.Name, priority, autoRename, false, item.Filename, fi.Size(), f)
+}
+
+func (s *Service) HandleGetImport(c *gin.Context) {
+	ctx := c.Request.Context()
+	log := logger.FromGinContext(c)
+	ui := GetUserInfo(c)
+
+	id := c.Param("id")
+	batch, err := s.db.GetImportBatch(ctx, id)
+	if err == nil && batch.UserID != ui.ID {
+		err = gorm.ErrRecordNotFound
+	}
+	if err != nil {
+		log.Errorf("Failed to get import batch, id: %s, err: %v", id, err)
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			hutil.AbortError(c, http.StatusNotFound, "import not found")
+		} else {
+			hutil.AbortError(c, hutil.ErrServerInternalCode, "get import failed")
+		}
+		return
+	}
+	items, err := s.db.ListImportItems(ctx, id)
+	if err != nil {
+		log.Errorf("Failed to list import items, id: %s, err: %v", id, err)
+		hutil.AbortError(c, hutil.ErrServerInternalCode, "get import failed")
+		return
+	}
+	hutil.WriteData(c, makeImportBatch(&batch, items, s.baseURL()))
+}
+
+func makeImportBatch(batch *db.ImportBatch, items []db.ImportItem, baseURL string) api.ImportBatch {
+	ret := api.ImportBatch{
+		ID:           batch.ID,
+		Filename:     batch.Filename,
+		Status:       batch.Status,
+		Total:        len(items),
+		Items:        make([]api.ImportItem, 0, len(items)),
+		OperationURL: operationURL(baseURL, batch.ID),
+		CreatedAt:    batch.CreatedAt.Format(time.DateTime),
+		UpdatedAt:    batch.UpdatedAt.Format(time.DateTime),
+	}
+	for _, item := range items {
+		switch item.Status {
+		case db.ImportItemStatusCreated:
+			ret.Created++
+		case db.ImportItemStatusFailed:
+			ret.Failed++
+		}
+		ret.Items = append(ret.Items, api.ImportItem{
+			Filename:   item.Filename,
+			Name:       item.Name,
+			DocumentID: item.DocumentID,
+			Status:     item.Status,
+			Error:      item.Error,
+		})
+	}
+	return ret
+}
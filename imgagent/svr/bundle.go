@@ -0,0 +1,389 @@
+package svr
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/gin-gonic/gin"
+
+	"imgagent/api"
+	"imgagent/db"
+	hutil "imgagent/httputil"
+	"imgagent/pkg/logger"
+)
+
+// bundleImportStatuses 允许导入的文档状态。文档包不包含原文文件，导入的文档没有百炼文件，
+// 不能再提取摘要和角色，只接受已提取角色的文档，导入后从场景阶段继续处理
+var bundleImportStatuses = map[string]bool{
+	db.DocumentStatusRoleReady:           true,
+	db.DocumentStatusSceneReady:          true,
+	db.DocumentStatusImgReady:            true,
+	db.DocumentStatusCompletedWithErrors: true,
+}
+
+// buildBundle 生成文档包，章节和场景按序号排序。审核扣留的图片和原因不导出
+func buildBundle(doc *db.Document, chapters []db.Chapter, roles []db.Role, scenes []db.Scene) *api.DocumentBundle {
+	sort.SliceStable(chapters, func(i, j int) bool {
+		return chapters[i].Index < chapters[j].Index
+	})
+	sort.SliceStable(scenes, func(i, j int) bool {
+		return scenes[i].Index < scenes[j].Index
+	})
+
+	bundle := &api.DocumentBundle{
+		Version:    api.BundleVersion,
+		ExportedAt: time.Now().Format(time.RFC3339),
+		Document: api.BundleDocument{
+			ID:              doc.ID,
+			Name:            doc.Name,
+			Status:          doc.Status,
+			Priority:        documentPriorityName(doc.Priority),
+			FileSize:        doc.FileSize,
+			Summary:         doc.Summary,
+			SummaryImageURL: doc.SummaryImageURL,
+			RoleConsistency: doc.RoleConsistency,
+			ImageProvider:   doc.ImageProvider,
+			ImageStyle:      doc.ImageStyle,
+			AspectRatio:     doc.AspectRatio,
+			ImageSize:       doc.ImageSize,
+			IngestReport:    doc.IngestReport,
+			AutoRegenerate:  doc.AutoRegenerate,
+			VoiceProsody:    doc.VoiceProsody,
+		},
+		Chapters: make([]api.BundleChapter, 0, len(chapters)),
+		Roles:    make([]api.BundleRole, 0, len(roles)),
+		Scenes:   make([]api.BundleScene, 0, len(scenes)),
+	}
+	for _, c := range chapters {
+		bundle.Chapters = append(bundle.Chapters, api.BundleChapter{
+			ID:           c.ID,
+			Index:        c.Index,
+			Title:        c.Title,
+			Content:      c.Content,
+			SceneIDs:     c.SceneIDs,
+			CoverSceneID: c.CoverSceneID,
+			CoverURL:     c.CoverURL,
+			DuplicateOf:  c.DuplicateOf,
+			Similarity:   c.Similarity,
+		})
+	}
+	for _, r := range roles {
+		bundle.Roles = append(bundle.Roles, api.BundleRole{
+			ID:          r.ID,
+			Name:        r.Name,
+			Gender:      r.Gender,
+			Character:   r.Character,
+			Appearance:  r.Appearance,
+			PortraitURL: r.PortraitURL,
+		})
+	}
+	for _, s := range scenes {
+		bundle.Scenes = append(bundle.Scenes, api.BundleScene{
+			ID:                s.ID,
+			ChapterID:         s.ChapterID,
+			Index:             s.Index,
+			Content:           s.Content,
+			Status:            s.Status,
+			Error:             s.Error,
+			ImageURL:          s.ImageURL,
+			HDImageURL:        s.HDImageURL,
+			HDSourceURL:       s.HDSourceURL,
+			VoiceURL:          s.VoiceURL,
+			Placeholder:       s.Placeholder,
+			MediaStale:        s.MediaStale,
+			AudioDurationMs:   s.AudioDurationMs,
+			DisplayDurationMs: s.DisplayDurationMs,
+			Overrides:         s.Overrides,
+			Location:          s.Location,
+			TimeOfDay:         s.TimeOfDay,
+			Mood:              s.Mood,
+			CharactersPresent: s.CharactersPresent,
+		})
+	}
+	return bundle
+}
+
+func writeBundleExport(ctx context.Context, database db.IDataBase, doc db.Document, w io.Writer) error {
+	chapters, err := database.ListChapters(ctx, doc.ID)
+	if err != nil {
+		return err
+	}
+	roles, err := database.ListRolesByDocument(ctx, doc.ID)
+	if err != nil {
+		return err
+	}
+	scenes, err := database.ListScenesByDocument(ctx, doc.ID)
+	if err != nil {
+		return err
+	}
+	enc := json.NewEncoder(w)
+	enc.SetIndent("", "  ")
+	return enc.Encode(buildBundle(&doc, chapters, roles, scenes))
+}
+
+// HandleExportDocument 同步导出文档，format 默认为 bundle，响应为导出产物本身。
+// 与导出任务使用相同的格式，产物先写入内存，失败时仍能返回错误
+func (s *Service) HandleExportDocument(c *gin.Context) {
+	ctx := c.Request.Context()
+	log := logger.FromGinContext(c)
+
+	name := c.DefaultQuery("format", "bundle")
+	format, ok := exportFormats[name]
+	if !ok {
+		hutil.AbortError(c, http.StatusBadRequest, "unsupported format")
+		return
+	}
+
+	docID := c.Param("document_id")
+	log.Infof("Export document, docID: %s, format: %s", docID, name)
+	doc, err := s.db.GetDocument(ctx, docID)
+	if err != nil {
+		log.Errorf("Failed to get document, id: %s, err: %v", docID, err)
+		documentErr(c, err, "get document failed")
+		return
+	}
+
+	var buf bytes.Buffer
+	if err = format.write(ctx, s.db, doc, &buf); err != nil {
+		log.Errorf("Failed to export document, id: %s, err: %v", docID, err)
+		hutil.AbortError(c, hutil.ErrServerInternalCode, "export document failed")
+		return
+	}
+	c.Header("Content-Disposition", fmt.Sprintf(`attachment; filename="%s.%s"`, doc.ID, format.ext))
+	c.Data(http.StatusOK, format.contentType, buf.Bytes())
+}
+
+// HandleImportBundle 上传 GET /documents/:document_id/export?format=bundle 导出的文档包创建文档，
+// priority 为空时使用文档包中的优先级
+func (s *Service) HandleImportBundle(c *gin.Context) {
+	ctx := c.Request.Context()
+	log := logger.FromGinContext(c)
+	ui := GetUserInfo(c)
+
+	file, err := c.FormFile("file")
+	if err != nil {
+		log.Errorf("Failed to get file, err: %v", err)
+		hutil.AbortError(c, http.StatusBadRequest, "file is required")
+		return
+	}
+	if file.Size > s.uploads.maxBytes() {
+		hutil.AbortErr(c, s.uploads.sizeError())
+		return
+	}
+	f, err := file.Open()
+	if err != nil {
+		log.Errorf("Failed to open file, err: %v", err)
+		hutil.AbortError(c, http.StatusBadRequest, "file is required")
+		return
+	}
+	defer f.Close()
+
+	var bundle api.DocumentBundle
+	if err = json.NewDecoder(f).Decode(&bundle); err != nil {
+		log.Errorf("Invalid bundle, err: %v", err)
+		hutil.AbortError(c, http.StatusBadRequest, "invalid bundle")
+		return
+	}
+	doc, err := s.ImportBundle(ctx, ui.ID, c.PostForm("priority"), c.PostForm("auto_rename") == "true", &bundle)
+	if err != nil {
+		hutil.AbortErr(c, err)
+		return
+	}
+	s.auditCreated(ctx, newAuditLog(c, auditDocument, "import"), doc.ID)
+	hutil.WriteData(c, doc)
+}
+
+// ImportBundle 按文档包创建文档，章节、角色和场景使用新的 id，包内的引用关系随之替换；
+// 章节和场景按包中的序号重新编号。文档名已存在时的处理与上传相同
+func (s *Service) ImportBundle(ctx context.Context, userID int64, priority string, autoRename bool, bundle *api.DocumentBundle) (*api.Document, error) {
+	log := logger.FromContext(ctx)
+
+	if bundle.Version != api.BundleVersion {
+		return nil, hutil.NewApiError(http.StatusBadRequest, fmt.Sprintf("unsupported bundle version %d", bundle.Version))
+	}
+	if !bundleImportStatuses[bundle.Document.Status] {
+		return nil, hutil.NewApiError(http.StatusBadRequest, fmt.Sprintf("bundle document status %s can not be imported", bundle.Document.Status))
+	}
+	if len(bundle.Chapters) == 0 {
+		return nil, hutil.NewApiError(http.StatusBadRequest, "bundle has no chapters")
+	}
+	name := strings.TrimSpace(bundle.Document.Name)
+	if err := s.names.Validate(name); err != nil {
+		return nil, err
+	}
+	if priority == "" {
+		priority = bundle.Document.Priority
+	}
+	prio, err := parseDocumentPriority(priority)
+	if err != nil {
+		return nil, err
+	}
+	log.Infof("Import bundle, name: %s, source: %s, chapters: %d, scenes: %d, userID: %d",
+		name, bundle.Document.ID, len(bundle.Chapters), len(bundle.Scenes), userID)
+
+	doc, chapters, roles, scenes, err := remapBundle(bundle)
+	if err != nil {
+		log.Warnf("Invalid bundle, err: %v", err)
+		return nil, hutil.NewApiError(http.StatusBadRequest, err.Error())
+	}
+	doc.UserID = userID
+	doc.Priority = prio
+
+	err = s.quota.CheckDocument(ctx, userID, doc.FileSize)
+	if err != nil {
+		log.Warnf("Quota check failed, userID: %d, err: %v", userID, err)
+		return nil, quotaError(err, "check quota failed")
+	}
+	doc.Name, err = s.resolveDocumentName(ctx, name, autoRename)
+	if err != nil {
+		return nil, err
+	}
+
+	err = s.db.ImportDocument(ctx, doc, chapters, roles, scenes)
+	if err != nil {
+		log.Errorf("Failed to import document, err: %v", err)
+		return nil, documentError(err, "import document failed")
+	}
+	err = s.quota.RecordDocument(ctx, userID, doc.FileSize)
+	if err != nil {
+		log.Errorf("Failed to check quota threshold, userID: %d, err: %v", userID, err)
+	}
+
+	log.Infof("Bundle imported, doc: %s, source: %s", doc.ID, bundle.Document.ID)
+	ret := makeDocument(doc)
+	return &ret, nil
+}
+
+// remapBundle 把文档包转换为新 id 的记录，包内引用了不存在的章节或场景时返回错误。
+// 文档名、所属用户和优先级由调用方设置。包内的审核结果不可信，未通过审核的场景回到待生成，
+// 重新生成并审核，已完成的文档随之回到图片生成阶段
+func remapBundle(bundle *api.DocumentBundle) (*db.Document, []db.Chapter, []db.Role, []db.Scene, error) {
+	d := &bundle.Document
+	doc := &db.Document{
+		ID:              db.MakeUUID(),
+		Status:          d.Status,
+		FileSize:        d.FileSize,
+		Summary:         d.Summary,
+		SummaryImageURL: d.SummaryImageURL,
+		RoleConsistency: d.RoleConsistency,
+		ImageProvider:   d.ImageProvider,
+		ImageStyle:      d.ImageStyle,
+		AspectRatio:     d.AspectRatio,
+		ImageSize:       d.ImageSize,
+		IngestReport:    d.IngestReport,
+		AutoRegenerate:  d.AutoRegenerate,
+		VoiceProsody:    d.VoiceProsody,
+	}
+
+	chapterIDs := make(map[string]string, len(bundle.Chapters))
+	for _, c := range bundle.Chapters {
+		if _, ok := chapterIDs[c.ID]; ok || c.ID == "" {
+			return nil, nil, nil, nil, fmt.Errorf("invalid chapter id %q", c.ID)
+		}
+		chapterIDs[c.ID] = db.MakeUUID()
+	}
+	sceneIDs := make(map[string]string, len(bundle.Scenes))
+	for _, s := range bundle.Scenes {
+		if _, ok := sceneIDs[s.ID]; ok || s.ID == "" {
+			return nil, nil, nil, nil, fmt.Errorf("invalid scene id %q", s.ID)
+		}
+		if _, ok := chapterIDs[s.ChapterID]; !ok {
+			return nil, nil, nil, nil, fmt.Errorf("scene %s references unknown chapter %s", s.ID, s.ChapterID)
+		}
+		sceneIDs[s.ID] = db.MakeUUID()
+	}
+
+	bundleChapters := append([]api.BundleChapter(nil), bundle.Chapters...)
+	sort.SliceStable(bundleChapters, func(i, j int) bool {
+		return bundleChapters[i].Index < bundleChapters[j].Index
+	})
+	chapters := make([]db.Chapter, 0, len(bundleChapters))
+	for i, c := range bundleChapters {
+		ids := make([]string, 0, len(c.SceneIDs))
+		for _, id := range c.SceneIDs {
+			newID, ok := sceneIDs[id]
+			if !ok {
+				return nil, nil, nil, nil, fmt.Errorf("chapter %s references unknown scene %s", c.ID, id)
+			}
+			ids = append(ids, newID)
+		}
+		chapter := db.Chapter{
+			ID:         chapterIDs[c.ID],
+			Index:      i,
+			DocumentID: doc.ID,
+			Title:      c.Title,
+			Content:    c.Content,
+			SceneIDs:   ids,
+			CoverURL:   c.CoverURL,
+		}
+		// 封面场景和重复章节引用不存在时丢弃，不影响导入
+		chapter.CoverSceneID = sceneIDs[c.CoverSceneID]
+		if dup, ok := chapterIDs[c.DuplicateOf]; ok {
+			chapter.DuplicateOf, chapter.Similarity = dup, c.Similarity
+		}
+		chapters = append(chapters, chapter)
+	}
+
+	roles := make([]db.Role, 0, len(bundle.Roles))
+	for _, r := range bundle.Roles {
+		roles = append(roles, db.Role{
+			ID:          db.MakeUUID(),
+			DocumentID:  doc.ID,
+			Name:        r.Name,
+			Gender:      r.Gender,
+			Character:   r.Character,
+			Appearance:  r.Appearance,
+			PortraitURL: r.PortraitURL,
+		})
+	}
+
+	bundleScenes := append([]api.BundleScene(nil), bundle.Scenes...)
+	sort.SliceStable(bundleScenes, func(i, j int) bool {
+		return bundleScenes[i].Index < bundleScenes[j].Index
+	})
+	scenes := make([]db.Scene, 0, len(bundleScenes))
+	for i, s := range bundleScenes {
+		scenes = append(scenes, db.Scene{
+			ID:                sceneIDs[s.ID],
+			ChapterID:         chapterIDs[s.ChapterID],
+			DocumentID:        doc.ID,
+			Index:             i,
+			Content:           s.Content,
+			Status:            s.Status,
+			Error:             s.Error,
+			ImageURL:          s.ImageURL,
+			HDImageURL:        s.HDImageURL,
+			HDSourceURL:       s.HDSourceURL,
+			VoiceURL:          s.VoiceURL,
+			Placeholder:       s.Placeholder,
+			MediaStale:        s.MediaStale,
+			AudioDurationMs:   s.AudioDurationMs,
+			DisplayDurationMs: s.DisplayDurationMs,
+			Overrides:         s.Overrides,
+			Location:          s.Location,
+			TimeOfDay:         s.TimeOfDay,
+			Mood:              s.Mood,
+			CharactersPresent: s.CharactersPresent,
+		})
+		if s.Status == db.SceneStatusBlocked {
+			scene := &scenes[len(scenes)-1]
+			scene.Status, scene.Error = "", ""
+			scene.MediaStale = scene.MediaStale || scene.ImageURL != ""
+			if documentFinished(doc.Status) {
+				doc.Status = db.DocumentStatusSceneReady
+			}
+		}
+		doc.SceneCount++
+		if s.Status == db.SceneStatusFailed {
+			doc.FailedSceneCount++
+		}
+	}
+	return doc, chapters, roles, scenes, nil
+}
@@ -0,0 +1,101 @@
+package svr
+
+import (
+	"context"
+
+	"github.com/gin-gonic/gin"
+
+	"imgagent/api"
+	"imgagent/db"
+	hutil "imgagent/httputil"
+	"imgagent/pkg/logger"
+	"imgagent/spliter"
+)
+
+// markDuplicateChapters 按上传报告标记与前文高度相似的章节。标记只用于提示用户清理，失败时不影响文档创建
+func (s *Service) markDuplicateChapters(ctx context.Context, docID string, dups []spliter.NearDuplicateChapter) {
+	if len(dups) == 0 {
+		return
+	}
+	log := logger.FromContext(ctx)
+
+	chapters, err := s.db.ListChapters(ctx, docID)
+	if err != nil {
+		log.Errorf("Failed to list chapters, docID: %s, err: %v", docID, err)
+		return
+	}
+	ids := make(map[int]string, len(chapters))
+	for _, ch := range chapters {
+		ids[ch.Index] = ch.ID
+	}
+	duplicates := make([]db.ChapterDuplicate, 0, len(dups))
+	for _, d := range dups {
+		id, first := ids[d.Index], ids[d.FirstIndex]
+		if id == "" || first == "" {
+			continue
+		}
+		duplicates = append(duplicates, db.ChapterDuplicate{ChapterID: id, DuplicateOf: first, Similarity: d.Similarity()})
+	}
+	err = s.db.MarkDuplicateChapters(ctx, docID, duplicates)
+	if err != nil {
+		log.Errorf("Failed to mark duplicate chapters, docID: %s, err: %v", docID, err)
+		return
+	}
+	log.Infof("Near-duplicate chapters marked, docID: %s, count: %d", docID, len(duplicates))
+}
+
+// HandleListChapterDuplicates 列取上传时检测到的与前文高度相似的章节，用户确认后可删除重复章节
+func (s *Service) HandleListChapterDuplicates(c *gin.Context) {
+	result, err := s.ListChapterDuplicates(c.Request.Context(), c.Param("document_id"))
+	if err != nil {
+		hutil.AbortErr(c, err)
+		return
+	}
+	hutil.WriteData(c, result)
+}
+
+// ListChapterDuplicates 前文章节已删除的相似章节不再列出，修改过内容的章节不再标记
+func (s *Service) ListChapterDuplicates(ctx context.Context, docID string) (*api.ListChapterDuplicatesResult, error) {
+	log := logger.FromContext(ctx)
+	log.Infof("List chapter duplicates, docID: %s", docID)
+
+	_, err := s.db.GetDocument(ctx, docID)
+	if err != nil {
+		log.Errorf("Failed to get document, id: %s, err: %v", docID, err)
+		return nil, documentError(err, "get document failed")
+	}
+	dups, err := s.db.ListDuplicateChapters(ctx, docID)
+	if err != nil {
+		log.Errorf("Failed to list duplicate chapters, docID: %s, err: %v", docID, err)
+		return nil, hutil.NewApiError(hutil.ErrServerInternalCode, "list duplicate chapters failed")
+	}
+	ret := &api.ListChapterDuplicatesResult{Duplicates: []api.ChapterDuplicate{}}
+	if len(dups) == 0 {
+		return ret, nil
+	}
+	chapters, err := s.db.ListChapters(ctx, docID)
+	if err != nil {
+		log.Errorf("Failed to list chapters, docID: %s, err: %v", docID, err)
+		return nil, hutil.NewApiError(hutil.ErrServerInternalCode, "list duplicate chapters failed")
+	}
+	indexes := make(map[string]int, len(chapters))
+	for _, ch := range chapters {
+		indexes[ch.ID] = ch.Index
+	}
+
+	for _, ch := range dups {
+		index, ok := indexes[ch.DuplicateOf]
+		if !ok {
+			continue
+		}
+		ret.Duplicates = append(ret.Duplicates, api.ChapterDuplicate{
+			ChapterID:        ch.ID,
+			Index:            ch.Index,
+			Title:            ch.Title,
+			DuplicateOf:      ch.DuplicateOf,
+			DuplicateOfIndex: index,
+			Similarity:       ch.Similarity,
+		})
+	}
+	return ret, nil
+}
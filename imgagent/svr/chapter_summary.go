@@ -0,0 +1,131 @@
+package svr
+
+import (
+	"context"
+	"errors"
+	"net/http"
+
+	"github.com/gin-gonic/gin"
+	"gorm.io/gorm"
+
+	"imgagent/api"
+	"imgagent/db"
+	hutil "imgagent/httputil"
+	"imgagent/pkg/logger"
+)
+
+// maxChapterSummaryRunes 与 chapters.summary 字段的长度一致
+const maxChapterSummaryRunes = 1000
+
+// HandleStreamChapterSummary 以 SSE 流式返回章节摘要：生成过程中推送 delta 事件，结束时推送 done 事件，
+// 开始推送后失败时推送 error 事件。推送期间一直占用租户的并发名额，没有空闲名额时返回 429
+func (s *Service) HandleStreamChapterSummary(c *gin.Context) {
+	ctx := c.Request.Context()
+	log := logger.FromGinContext(c)
+
+	docID, id := c.Param("document_id"), c.Param("id")
+	if err := checkChapterParams(docID, id); err != nil {
+		hutil.AbortErr(c, err)
+		return
+	}
+
+	log.Infof("Stream chapter summary, docID: %s, id: %s", docID, id)
+	chapter, err := s.db.GetChapter(ctx, id, docID)
+	if err != nil {
+		log.Errorf("Failed to get chapter, err: %v", err)
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			hutil.AbortError(c, http.StatusNotFound, "chapter not found")
+			return
+		}
+		hutil.AbortError(c, hutil.ErrServerInternalCode, "get chapter failed")
+		return
+	}
+
+	_, err = s.limiter.TryRun(ctx, GetUserInfo(c).ID, func(ctx context.Context) (any, error) {
+		return nil, s.streamChapterSummary(ctx, c, &chapter)
+	})
+	if err != nil {
+		hutil.AbortErr(c, err)
+	}
+}
+
+// streamChapterSummary 推送章节摘要，开始推送前失败时返回错误，开始推送后失败时推送 error 事件
+func (s *Service) streamChapterSummary(ctx context.Context, c *gin.Context, chapter *db.Chapter) error {
+	log := logger.FromContext(ctx)
+
+	c.Header("Cache-Control", "no-cache")
+	c.Header("X-Accel-Buffering", "no")
+	started := false
+	summary, err := s.bailianClient.SummarizeChapterStream(ctx, chapter.Content, func(delta string) error {
+		started = true
+		c.SSEvent("delta", api.ChapterSummaryDelta{Content: delta})
+		c.Writer.Flush()
+		return ctx.Err()
+	})
+	if err != nil {
+		log.Errorf("Failed to summarize chapter, id: %s, err: %v", chapter.ID, err)
+		if !started {
+			return hutil.NewApiError(hutil.ErrServerInternalCode, "summarize chapter failed")
+		}
+		c.SSEvent("error", api.StreamError{Message: "summarize chapter failed"})
+		return nil
+	}
+	summary = truncateSummary(summary)
+	if err = s.db.UpdateChapterSummary(ctx, chapter.ID, summary); err != nil {
+		log.Warnf("Failed to save chapter summary, id: %s, err: %v", chapter.ID, err)
+	}
+	c.SSEvent("done", api.ChapterSummary{ChapterID: chapter.ID, Summary: summary})
+	return nil
+}
+
+// HandleSummarizeChapter 生成章节摘要并保存，前端用于章节导航的预览。需要同步调用大模型，按租户限制并发
+func (s *Service) HandleSummarizeChapter(c *gin.Context, id string) {
+	docID := c.Param("document_id")
+	s.limiter.Run(c, GetUserInfo(c).ID, func(ctx context.Context) (any, error) {
+		return s.SummarizeChapter(ctx, docID, id)
+	})
+}
+
+// SummarizeChapter 调用百炼生成章节摘要，保存到章节后返回；章节内容修改后摘要清空，需重新生成
+func (s *Service) SummarizeChapter(ctx context.Context, docID, id string) (*api.ChapterSummary, error) {
+	log := logger.FromContext(ctx)
+
+	if err := checkChapterParams(docID, id); err != nil {
+		return nil, err
+	}
+	if s.bailianClient == nil {
+		return nil, hutil.NewApiError(http.StatusBadRequest, "bailian client not configured")
+	}
+	chapter, err := s.db.GetChapter(ctx, id, docID)
+	if err != nil {
+		log.Errorf("Failed to get chapter, err: %v", err)
+		return nil, chapterError(err, "get chapter failed")
+	}
+
+	log.Infof("Summarize chapter, docID: %s, id: %s", docID, id)
+	summary, err := s.bailianClient.SummarizeChapterStream(ctx, chapter.Content, func(string) error { return nil })
+	if err != nil {
+		log.Errorf("Failed to summarize chapter, id: %s, err: %v", id, err)
+		return nil, hutil.NewApiError(hutil.ErrServerInternalCode, "summarize chapter failed")
+	}
+	summary = truncateSummary(summary)
+	if err = s.db.UpdateChapterSummary(ctx, chapter.ID, summary); err != nil {
+		log.Errorf("Failed to save chapter summary, id: %s, err: %v", id, err)
+		return nil, chapterError(err, "save chapter summary failed")
+	}
+	return &api.ChapterSummary{ChapterID: chapter.ID, Summary: summary}, nil
+}
+
+func chapterError(err error, errMsg string) error {
+	if errors.Is(err, gorm.ErrRecordNotFound) {
+		return hutil.NewApiError(http.StatusNotFound, "chapter not found")
+	}
+	return hutil.NewApiError(hutil.ErrServerInternalCode, errMsg)
+}
+
+func truncateSummary(summary string) string {
+	if runes := []rune(summary); len(runes) > maxChapterSummaryRunes {
+		return string(runes[:maxChapterSummaryRunes])
+	}
+	return summary
+}
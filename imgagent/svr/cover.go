@@ -0,0 +1,130 @@
+package svr
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"slices"
+
+	"github.com/gin-gonic/gin"
+	"gorm.io/gorm"
+
+	"imgagent/api"
+	"imgagent/db"
+	hutil "imgagent/httputil"
+	"imgagent/pkg/logger"
+)
+
+// hasCoverImage 场景是否可作为章节封面：已生成且不是占位图
+func hasCoverImage(scene *db.Scene) bool {
+	return scene.Status == db.SceneStatusReady && !scene.Placeholder && scene.ImageURL != ""
+}
+
+// chapterCover 选择章节封面图片：优先使用手动选择的场景，该场景不可用时按场景顺序取第一个可用的场景
+func chapterCover(chapter *db.Chapter, scenes []db.Scene) string {
+	byID := make(map[string]*db.Scene, len(scenes))
+	for i := range scenes {
+		byID[scenes[i].ID] = &scenes[i]
+	}
+	if scene, ok := byID[chapter.CoverSceneID]; ok && hasCoverImage(scene) {
+		return scene.ImageURL
+	}
+	for _, id := range chapter.SceneIDs {
+		if scene, ok := byID[id]; ok && hasCoverImage(scene) {
+			return scene.ImageURL
+		}
+	}
+	return ""
+}
+
+// refreshChapterCovers 重新选择文档各章节的封面，只更新封面有变化的章节
+func refreshChapterCovers(ctx context.Context, database db.IDataBase, docID string) error {
+	chapters, err := database.ListChapters(ctx, docID)
+	if err != nil {
+		return err
+	}
+	scenes, err := database.ListScenesByDocument(ctx, docID)
+	if err != nil {
+		return err
+	}
+	byChapter := make(map[string][]db.Scene)
+	for _, scene := range scenes {
+		byChapter[scene.ChapterID] = append(byChapter[scene.ChapterID], scene)
+	}
+	for _, chapter := range chapters {
+		cover := chapterCover(&chapter, byChapter[chapter.ID])
+		if cover == chapter.CoverURL {
+			continue
+		}
+		err = database.UpdateChapterCover(ctx, chapter.ID, chapter.CoverSceneID, cover)
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (s *Service) HandleSetChapterCover(c *gin.Context, id string) {
+	log := logger.FromGinContext(c)
+
+	var args api.SetChapterCoverArgs
+	if err := c.ShouldBindJSON(&args); err != nil {
+		log.Errorf("Invalid request body, err: %v", err)
+		hutil.AbortError(c, http.StatusBadRequest, "invalid request body")
+		return
+	}
+	chapter, err := s.SetChapterCover(c.Request.Context(), c.Param("document_id"), id, &args)
+	if err != nil {
+		hutil.AbortErr(c, err)
+		return
+	}
+	hutil.WriteData(c, chapter)
+}
+
+// SetChapterCover 手动选择章节封面场景，args.SceneID 为空时恢复自动选择
+func (s *Service) SetChapterCover(ctx context.Context, docID, id string, args *api.SetChapterCoverArgs) (*api.Chapter, error) {
+	log := logger.FromContext(ctx)
+
+	if err := checkChapterParams(docID, id); err != nil {
+		return nil, err
+	}
+	chapter, err := s.db.GetChapter(ctx, id, docID)
+	if err != nil {
+		log.Errorf("Failed to get chapter, id: %s, err: %v", id, err)
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			return nil, hutil.NewApiError(http.StatusNotFound, "chapter not found")
+		}
+		return nil, hutil.NewApiError(hutil.ErrServerInternalCode, "get chapter failed")
+	}
+	if args.SceneID != "" && !slices.Contains(chapter.SceneIDs, args.SceneID) {
+		return nil, hutil.NewApiError(http.StatusBadRequest, "scene not in chapter")
+	}
+	scenes, err := s.db.ListScenesByChapter(ctx, id)
+	if err != nil {
+		log.Errorf("Failed to list scenes, chapter: %s, err: %v", id, err)
+		return nil, hutil.NewApiError(hutil.ErrServerInternalCode, "list scenes failed")
+	}
+	if args.SceneID != "" {
+		i := slices.IndexFunc(scenes, func(scene db.Scene) bool { return scene.ID == args.SceneID })
+		if i < 0 || !hasCoverImage(&scenes[i]) {
+			return nil, hutil.NewApiError(http.StatusConflict, "scene image not ready")
+		}
+	}
+
+	log.Infof("Set chapter cover, docID: %s, id: %s, sceneID: %s", docID, id, args.SceneID)
+	chapter.CoverSceneID = args.SceneID
+	chapter.CoverURL = chapterCover(&chapter, scenes)
+	err = s.db.UpdateChapterCover(ctx, id, chapter.CoverSceneID, chapter.CoverURL)
+	if err != nil {
+		log.Errorf("Failed to update chapter cover, id: %s, err: %v", id, err)
+		return nil, hutil.NewApiError(hutil.ErrServerInternalCode, "update chapter cover failed")
+	}
+	chapter, err = s.db.GetChapter(ctx, id, docID)
+	if err != nil {
+		log.Errorf("Failed to get chapter, id: %s, err: %v", id, err)
+		return nil, hutil.NewApiError(hutil.ErrServerInternalCode, "get chapter failed")
+	}
+
+	ret := makeChapter(&chapter)
+	return &ret, nil
+}
@@ -0,0 +1,197 @@
+package svr
+
+import (
+	"archive/zip"
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"regexp"
+	"strings"
+	"time"
+
+	"github.com/gin-gonic/gin"
+
+	"imgagent/api"
+	"imgagent/bailian"
+	"imgagent/db"
+	hutil "imgagent/httputil"
+	"imgagent/pkg/logger"
+)
+
+const (
+	// 处理日志中流水线阶段以外的操作
+	logStageUpload     = "upload"
+	logStageRegenerate = "regenerate"
+
+	// 日志包中每类记录的最大条数，只保留最近的记录
+	maxBundleLogs   = 2000
+	maxBundleEvents = 1000
+)
+
+// recordDocumentLog 写入文档处理日志，失败只记录日志，不影响主流程
+func recordDocumentLog(ctx context.Context, database db.IDataBase, l db.DocumentLog) {
+	l.CreatedAt = time.Now()
+	if len(l.Message) > 1024 {
+		l.Message = strings.ToValidUTF8(l.Message[:1024], "")
+	}
+	err := database.CreateDocumentLog(ctx, &l)
+	if err != nil {
+		logger.FromContext(ctx).Errorf("Failed to create document log, log: %+v, err: %v", l, err)
+	}
+}
+
+// withRequestLog ctx 上发出的百炼请求记录到文档处理日志，包括请求 id、状态码和耗时
+func withRequestLog(ctx context.Context, database db.IDataBase, docID, stage, sceneID string) context.Context {
+	return bailian.WithRequestLog(ctx, func(r bailian.RequestLog) {
+		l := db.DocumentLog{
+			DocumentID: docID,
+			SceneID:    sceneID,
+			Stage:      stage,
+			Level:      db.LogLevelInfo,
+			API:        r.API,
+			RequestID:  r.RequestID,
+			StatusCode: r.StatusCode,
+			DurationMs: r.Duration.Milliseconds(),
+			Message:    "request succeeded",
+		}
+		if r.Err != nil {
+			l.Level, l.Message = db.LogLevelError, r.Err.Error()
+		} else if r.StatusCode >= http.StatusBadRequest {
+			l.Level, l.Message = db.LogLevelError, fmt.Sprintf("request failed with status %d", r.StatusCode)
+		}
+		recordDocumentLog(ctx, database, l)
+	})
+}
+
+// 日志包脱敏规则：认证信息、密钥和 URL 的查询参数（签名 URL 中带有临时凭证）
+var (
+	bearerPattern = regexp.MustCompile(`(?i)\bbearer\s+[^\s"',]+`)
+	apiKeyPattern = regexp.MustCompile(`\bsk-[A-Za-z0-9_-]{8,}`)
+	urlPattern    = regexp.MustCompile(`https?://[^\s"'<>]+`)
+)
+
+// sanitizeLog 去除日志中的敏感信息
+func sanitizeLog(s string) string {
+	s = bearerPattern.ReplaceAllString(s, "Bearer [REDACTED]")
+	s = apiKeyPattern.ReplaceAllString(s, "sk-[REDACTED]")
+	return urlPattern.ReplaceAllStringFunc(s, func(u string) string {
+		if i := strings.IndexAny(u, "?#"); i >= 0 {
+			return u[:i] + "?[REDACTED]"
+		}
+		return u
+	})
+}
+
+// HandleGetDocumentLogs 下载文档的处理日志包（zip），内容已脱敏，可直接附在工单中
+func (s *Service) HandleGetDocumentLogs(c *gin.Context) {
+	ctx := c.Request.Context()
+	log := logger.FromGinContext(c)
+
+	docID := c.Param("document_id")
+	log.Infof("Get document logs, docID: %s", docID)
+	doc, err := s.db.GetDocument(ctx, docID)
+	if err != nil {
+		log.Errorf("Failed to get document, id: %s, err: %v", docID, err)
+		documentErr(c, err, "get document failed")
+		return
+	}
+	events, err := s.db.ListDocumentEvents(ctx, docID, maxBundleEvents)
+	if err != nil {
+		log.Errorf("Failed to list document events, err: %v", err)
+		hutil.AbortError(c, hutil.ErrServerInternalCode, "list document events failed")
+		return
+	}
+	logs, err := s.db.ListDocumentLogs(ctx, docID, maxBundleLogs)
+	if err != nil {
+		log.Errorf("Failed to list document logs, err: %v", err)
+		hutil.AbortError(c, hutil.ErrServerInternalCode, "list document logs failed")
+		return
+	}
+	scenes, err := s.db.ListScenesByDocument(ctx, docID)
+	if err != nil {
+		log.Errorf("Failed to list scenes, err: %v", err)
+		hutil.AbortError(c, hutil.ErrServerInternalCode, "list scenes failed")
+		return
+	}
+
+	data, err := writeLogBundle(&doc, events, logs, scenes)
+	if err != nil {
+		log.Errorf("Failed to write log bundle, err: %v", err)
+		hutil.AbortError(c, hutil.ErrServerInternalCode, "write log bundle failed")
+		return
+	}
+	c.Header("Content-Disposition", fmt.Sprintf(`attachment; filename="%s-logs.zip"`, docID))
+	c.Data(http.StatusOK, "application/zip", data)
+}
+
+// writeLogBundle 生成日志包：document.json 文档状态，events.log 流水线事件，
+// pipeline.log 百炼调用（含请求 id）和阶段失败，scenes.log 失败或重试中的场景
+func writeLogBundle(doc *db.Document, events []db.Event, logs []db.DocumentLog, scenes []db.Scene) ([]byte, error) {
+	var buf bytes.Buffer
+	zw := zip.NewWriter(&buf)
+
+	info := makeDocument(doc)
+	info.LastError = sanitizeLog(info.LastError)
+	info.SummaryImageURL = sanitizeLog(info.SummaryImageURL)
+	summary, err := json.MarshalIndent(struct {
+		api.Document
+		GeneratedAt string `json:"generated_at"`
+	}{info, time.Now().Format(time.DateTime)}, "", "  ")
+	if err != nil {
+		return nil, err
+	}
+
+	var eventLog, pipelineLog, sceneLog strings.Builder
+	// 事件的 Detail 为内部排查信息（如调用栈），不放入日志包
+	for _, e := range events {
+		fmt.Fprintf(&eventLog, "%s %s", e.CreatedAt.Format(time.DateTime), e.Type)
+		if e.SceneID != "" {
+			fmt.Fprintf(&eventLog, " scene=%s", e.SceneID)
+		}
+		fmt.Fprintf(&eventLog, " %s\n", sanitizeLog(e.Message))
+	}
+	for _, l := range logs {
+		fmt.Fprintf(&pipelineLog, "%s %s stage=%s", l.CreatedAt.Format(time.DateTime), strings.ToUpper(l.Level), l.Stage)
+		if l.SceneID != "" {
+			fmt.Fprintf(&pipelineLog, " scene=%s", l.SceneID)
+		}
+		if l.API != "" {
+			fmt.Fprintf(&pipelineLog, " api=%s status=%d request_id=%s duration=%dms", l.API, l.StatusCode, l.RequestID, l.DurationMs)
+		}
+		fmt.Fprintf(&pipelineLog, " %s\n", sanitizeLog(l.Message))
+	}
+	for _, scene := range scenes {
+		if scene.Status == db.SceneStatusReady || (scene.Status == "" && scene.Attempts == 0) {
+			continue
+		}
+		status := scene.Status
+		if status == "" {
+			status = "retrying"
+		}
+		fmt.Fprintf(&sceneLog, "scene=%s index=%d status=%s attempts=%d %s\n", scene.ID, scene.Index, status, scene.Attempts, sanitizeLog(scene.Error))
+	}
+
+	for _, f := range []struct {
+		name string
+		data []byte
+	}{
+		{"document.json", summary},
+		{"events.log", []byte(eventLog.String())},
+		{"pipeline.log", []byte(pipelineLog.String())},
+		{"scenes.log", []byte(sceneLog.String())},
+	} {
+		w, err := zw.Create(f.name)
+		if err != nil {
+			return nil, err
+		}
+		if _, err = w.Write(f.data); err != nil {
+			return nil, err
+		}
+	}
+	if err = zw.Close(); err != nil {
+		return nil, err
+	}
+	return buf.Bytes(), nil
+}
@@ -2,18 +2,60 @@ package svr
 
 import (
 	"context"
+	"errors"
 	"fmt"
+	"math/rand/v2"
+	"sync"
+	"sync/atomic"
 	"time"
 
+	"go.opentelemetry.io/otel/attribute"
+
+	"imgagent/api"
 	"imgagent/bailian"
 	"imgagent/db"
+	"imgagent/imagegen"
+	"imgagent/moderation"
+	"imgagent/pkg/dlock"
+	"imgagent/pkg/jobqueue"
 	"imgagent/pkg/logger"
+	"imgagent/pkg/mediastore"
+	"imgagent/pkg/pubsub"
+	"imgagent/pkg/tracing"
+	"imgagent/placeholder"
+	"imgagent/tts"
 )
 
 type DocumentConfigEx struct {
 	config DocumentConfig
 
-	db db.IDataBase
+	db    db.IDataBase
+	quota *QuotaMgr
+	// webhooks 为 nil 表示未开启回调
+	webhooks *WebhookMgr
+	// pubsub 发布文档处理进度，为 nil 时不发布
+	pubsub pubsub.PubSub
+	// queue 各处理阶段的任务队列，多实例共享同一队列分担处理；为 nil 时使用进程内队列
+	queue jobqueue.Queue
+	// locker 按文档 id 加锁，保证同一文档同一时间只由一个 worker 处理；为 nil 时使用进程内锁
+	locker dlock.Locker
+	// tts 场景语音合成服务，为 nil 时使用百炼
+	tts tts.Provider
+	// images 已启用的场景图片服务，为 nil 时只使用百炼
+	images *imagegen.Providers
+	// styles 画面风格预设，为 nil 时只使用内置预设
+	styles *stylePresets
+	// moderation 场景内容审核服务，为 nil 时不审核
+	moderation moderation.Provider
+	// imageStore 保存在本地的场景图片，审核时读取图片内容
+	imageStore *mediastore.Store
+	// audioStore 保存在本地的场景语音，场景字幕也保存在其中
+	audioStore *mediastore.Store
+	// embedder 计算章节向量，为 nil 时不计算
+	embedder *chapterEmbedder
+
+	// mediaBaseURL 服务端生成媒体（如占位媒体）的 URL 前缀
+	mediaBaseURL string
 }
 
 type DocumentConfig struct {
@@ -21,14 +63,121 @@ type DocumentConfig struct {
 	HandleRoleIntervalSecs     int  `json:"handle_role_interval_secs"`
 	HandleSceneIntervalSecs    int  `json:"handle_scene_interval_secs"`
 	HandleImageGenIntervalSecs int  `json:"handle_image_gen_interval_secs"`
+	// HandleTranslationIntervalSecs 扫描待执行翻译任务的间隔，默认 30
+	HandleTranslationIntervalSecs int `json:"handle_translation_interval_secs"`
+	// MaxSceneAttempts 单个场景生成失败的最大次数，超过后场景标记为永久失败
+	MaxSceneAttempts int `json:"max_scene_attempts"`
+	// MinSceneSuccessRatio 存在永久失败场景时，成功比例不低于该值则文档标记为 completedWithErrors，否则为 failed
+	MinSceneSuccessRatio float64 `json:"min_scene_success_ratio"`
+	// Retry 各处理阶段（role、scene、image）的重试策略，未配置的字段使用默认值
+	Retry map[string]RetryPolicy `json:"retry"`
+	// Workers 每个处理阶段同时处理的文档数，默认 1
+	Workers int `json:"workers"`
+	// MaxConcurrentImageJobs、MaxConcurrentTTSJobs 同时调用百炼生成图片、语音的最大数量，默认等于 Workers
+	MaxConcurrentImageJobs int `json:"max_concurrent_image_jobs"`
+	MaxConcurrentTTSJobs   int `json:"max_concurrent_tts_jobs"`
+	// KeepGenerations 每个场景保留的历史图片和语音生成次数（不含当前），用于回滚，默认 3
+	KeepGenerations int `json:"keep_generations"`
+	// KeepImageVersions 每个场景保留的图片版本数（含当前），用于单独回退图片，默认 10
+	KeepImageVersions int `json:"keep_image_versions"`
+	// BailianFilePrefix 上传到百炼的文件名前缀，用于区分共用同一 API key 的部署，只允许字母、数字和 -
+	BailianFilePrefix string `json:"bailian_file_prefix"`
+	// ReconcileFilesIntervalSecs 清理百炼上孤立文件的间隔，默认 3600，小于 0 或未配置 BailianFilePrefix 时不清理
+	ReconcileFilesIntervalSecs int `json:"reconcile_files_interval_secs"`
+	// OrphanFileGraceSecs 上传超过该时长仍未被文档引用的百炼文件视为孤立文件，默认 3600
+	OrphanFileGraceSecs int `json:"orphan_file_grace_secs"`
+}
+
+// 文档处理阶段，用于选择重试策略
+const (
+	stageRole  = "role"
+	stageScene = "scene"
+	stageImage = "image"
+)
+
+// stageStatus 各处理阶段待处理文档所处的状态
+var stageStatus = map[string]string{
+	stageRole:  db.DocumentStatusChapterReady,
+	stageScene: db.DocumentStatusRoleReady,
+	stageImage: db.DocumentStatusSceneReady,
+}
+
+// priorityScoreStep 任务队列 score 中优先级的权重，大于毫秒时间戳，保证先按优先级再按创建时间出队
+const priorityScoreStep = 1e13
+
+// RetryPolicy 文档处理阶段失败后的重试策略。第 n 次失败后等待 min(BackoffSecs*2^(n-1), MaxBackoffSecs)，
+// 再叠加 ±Jitter 比例的随机抖动；失败 MaxAttempts 次后文档标记为失败
+type RetryPolicy struct {
+	MaxAttempts    int     `json:"max_attempts"`
+	BackoffSecs    int     `json:"backoff_secs"`
+	MaxBackoffSecs int     `json:"max_backoff_secs"`
+	Jitter         float64 `json:"jitter"`
+}
+
+var defaultRetryPolicy = RetryPolicy{
+	MaxAttempts:    5,
+	BackoffSecs:    30,
+	MaxBackoffSecs: 1800,
+	Jitter:         0.2,
+}
+
+func (p RetryPolicy) withDefaults() RetryPolicy {
+	if p.MaxAttempts == 0 {
+		p.MaxAttempts = defaultRetryPolicy.MaxAttempts
+	}
+	if p.BackoffSecs == 0 {
+		p.BackoffSecs = defaultRetryPolicy.BackoffSecs
+	}
+	if p.MaxBackoffSecs == 0 {
+		p.MaxBackoffSecs = defaultRetryPolicy.MaxBackoffSecs
+	}
+	if p.Jitter == 0 {
+		p.Jitter = defaultRetryPolicy.Jitter
+	}
+	return p
+}
+
+// backoff 第 attempts 次失败后的等待时间
+func (p RetryPolicy) backoff(attempts int) time.Duration {
+	d := time.Duration(p.MaxBackoffSecs) * time.Second
+	if attempts-1 < 32 {
+		d = min(time.Duration(p.BackoffSecs)*time.Second<<(attempts-1), d)
+	}
+	if p.Jitter > 0 {
+		d += time.Duration((rand.Float64()*2 - 1) * p.Jitter * float64(d))
+	}
+	return max(d, 0)
 }
 
+// errDocumentMgrStopping 服务退出时中断文档处理，已完成的场景已落库，重启后从剩余场景继续
+var errDocumentMgrStopping = errors.New("document manager stopping")
+
+// errScenesRetrying 部分场景生成失败，等待重试
+var errScenesRetrying = errors.New("scenes failed and will be retried")
+
+// errDocumentPaused 文档被暂停，中断当前文档，已完成的场景已落库，恢复后从剩余场景继续
+var errDocumentPaused = errors.New("document paused")
+
+// errDocumentPreempted 有更高优先级的文档等待生成图片，中断当前文档，剩余场景稍后继续
+var errDocumentPreempted = errors.New("document preempted by higher priority document")
+
+// errDocumentCanceled 文档被取消，取消正在处理该文档的 ctx，进行中的百炼调用随之中断
+var errDocumentCanceled = errors.New("document canceled")
+
 type DocumentMgr struct {
 	DocumentConfigEx
 
 	close         chan bool
+	closeOnce     sync.Once
+	wg            sync.WaitGroup
 	db            db.IDataBase
 	bailianClient *bailian.Client
+
+	// running 本实例正在处理的文档，用于取消。并发设置和调用百炼的名额运行时可修改，同样由 mu 保护
+	mu         sync.Mutex
+	running    map[string]context.CancelCauseFunc
+	imageSlots chan struct{}
+	ttsSlots   chan struct{}
 }
 
 func newDocumentMgr(confEx DocumentConfigEx, bailianClient *bailian.Client) (*DocumentMgr, error) {
@@ -42,19 +191,141 @@ func newDocumentMgr(confEx DocumentConfigEx, bailianClient *bailian.Client) (*Do
 	if confEx.config.HandleImageGenIntervalSecs == 0 {
 		confEx.config.HandleImageGenIntervalSecs = 30
 	}
+	if confEx.config.HandleTranslationIntervalSecs == 0 {
+		confEx.config.HandleTranslationIntervalSecs = 30
+	}
+	if confEx.config.MaxSceneAttempts == 0 {
+		confEx.config.MaxSceneAttempts = 3
+	}
+	if confEx.config.MinSceneSuccessRatio == 0 {
+		confEx.config.MinSceneSuccessRatio = 0.8
+	}
+	concurrency := documentConcurrency(confEx.config)
+	confEx.config.Workers = concurrency.Workers
+	confEx.config.MaxConcurrentImageJobs = concurrency.MaxConcurrentImageJobs
+	confEx.config.MaxConcurrentTTSJobs = concurrency.MaxConcurrentTTSJobs
+	if confEx.config.ReconcileFilesIntervalSecs == 0 {
+		confEx.config.ReconcileFilesIntervalSecs = 3600
+	}
+	if confEx.config.OrphanFileGraceSecs <= 0 {
+		confEx.config.OrphanFileGraceSecs = 3600
+	}
+	retry := make(map[string]RetryPolicy)
+	for _, stage := range []string{stageRole, stageScene, stageImage} {
+		retry[stage] = confEx.config.Retry[stage].withDefaults()
+	}
+	confEx.config.Retry = retry
+	if confEx.queue == nil {
+		confEx.queue = jobqueue.NewMemory(jobqueue.Config{})
+	}
+	if confEx.locker == nil {
+		confEx.locker = dlock.NewMemory(dlock.Config{})
+	}
+	if confEx.tts == nil {
+		confEx.tts = tts.NewBailian(bailianClient)
+	}
+	if confEx.images == nil {
+		confEx.images = imagegen.Default(bailianClient)
+	}
 
 	return &DocumentMgr{
 		DocumentConfigEx: confEx,
 		db:               confEx.db,
 		bailianClient:    bailianClient,
 		close:            make(chan bool),
+		imageSlots:       make(chan struct{}, confEx.config.MaxConcurrentImageJobs),
+		ttsSlots:         make(chan struct{}, confEx.config.MaxConcurrentTTSJobs),
+		running:          make(map[string]context.CancelCauseFunc),
 	}, nil
 }
 
+// documentConcurrency 返回填充默认值后的并发设置
+func documentConcurrency(conf DocumentConfig) api.DocumentConcurrency {
+	c := api.DocumentConcurrency{
+		Workers:                conf.Workers,
+		MaxConcurrentImageJobs: conf.MaxConcurrentImageJobs,
+		MaxConcurrentTTSJobs:   conf.MaxConcurrentTTSJobs,
+	}
+	if c.Workers <= 0 {
+		c.Workers = 1
+	}
+	if c.MaxConcurrentImageJobs <= 0 {
+		c.MaxConcurrentImageJobs = c.Workers
+	}
+	if c.MaxConcurrentTTSJobs <= 0 {
+		c.MaxConcurrentTTSJobs = c.Workers
+	}
+	return c
+}
+
+// Concurrency 当前的并发设置
+func (m *DocumentMgr) Concurrency() api.DocumentConcurrency {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	return documentConcurrency(m.config)
+}
+
+// SetConcurrency 运行时修改并发设置，不大于 0 时使用默认值。Workers 在下一轮处理时生效；
+// 调用百炼的名额修改后新的调用使用新的名额，进行中的调用在原名额上释放
+func (m *DocumentMgr) SetConcurrency(c api.DocumentConcurrency) {
+	c = documentConcurrency(DocumentConfig{Workers: c.Workers, MaxConcurrentImageJobs: c.MaxConcurrentImageJobs, MaxConcurrentTTSJobs: c.MaxConcurrentTTSJobs})
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.config.Workers = c.Workers
+	if c.MaxConcurrentImageJobs != m.config.MaxConcurrentImageJobs {
+		m.config.MaxConcurrentImageJobs = c.MaxConcurrentImageJobs
+		m.imageSlots = make(chan struct{}, c.MaxConcurrentImageJobs)
+	}
+	if c.MaxConcurrentTTSJobs != m.config.MaxConcurrentTTSJobs {
+		m.config.MaxConcurrentTTSJobs = c.MaxConcurrentTTSJobs
+		m.ttsSlots = make(chan struct{}, c.MaxConcurrentTTSJobs)
+	}
+}
+
+// slots 返回当前调用百炼生成图片或语音的名额
+func (m *DocumentMgr) slots(image bool) chan struct{} {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if image {
+		return m.imageSlots
+	}
+	return m.ttsSlots
+}
+
 func (m *DocumentMgr) Run() {
-	go m.loopHandleDocumentRoleTasks()
-	go m.loopHandleDocumentScenceTasks()
-	go m.loopHandleImageGenTasks()
+	m.supervise("HandleDocumentRoleTasks", m.loopHandleDocumentRoleTasks)
+	m.supervise("HandleDocumentScenceTasks", m.loopHandleDocumentScenceTasks)
+	m.supervise("HandleImageGenTasks", m.loopHandleImageGenTasks)
+	m.supervise("HandleTranslationTasks", m.loopHandleTranslationTasks)
+	if m.config.ReconcileFilesIntervalSecs > 0 && m.config.BailianFilePrefix != "" {
+		m.supervise("ReconcileFiles", m.loopReconcileFiles)
+	}
+}
+
+// Stop 通知各处理循环不再领取新文档，并等待正在处理的文档结束或在场景间中断
+func (m *DocumentMgr) Stop(ctx context.Context) error {
+	m.closeOnce.Do(func() { close(m.close) })
+
+	done := make(chan struct{})
+	go func() {
+		m.wg.Wait()
+		close(done)
+	}()
+	select {
+	case <-done:
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+func (m *DocumentMgr) stopping() bool {
+	select {
+	case <-m.close:
+		return true
+	default:
+		return false
+	}
 }
 
 func (m *DocumentMgr) loopHandleDocumentRoleTasks() {
@@ -64,8 +335,9 @@ func (m *DocumentMgr) loopHandleDocumentRoleTasks() {
 	for {
 		select {
 		case <-ticker.C:
-			ctx := logger.NewContext(fmt.Sprintf("HandleDocumentRoleTasks-%d", time.Now().Unix()))
-			m.HandleDocumentRoleTasks(ctx)
+			ctx, span := tracing.Start(logger.NewContext(fmt.Sprintf("HandleDocumentRoleTasks-%d", time.Now().Unix())), "DocumentMgr.HandleDocumentRoleTasks")
+			m.HandleDocumentRoleTasks(logger.WithTrace(ctx))
+			span.End()
 		case <-m.close:
 			return
 		}
@@ -79,8 +351,9 @@ func (m *DocumentMgr) loopHandleDocumentScenceTasks() {
 	for {
 		select {
 		case <-ticker.C:
-			ctx := logger.NewContext(fmt.Sprintf("HandleDocumentScenceTasks-%d", time.Now().Unix()))
-			m.HandleDocumentScenceTasks(ctx)
+			ctx, span := tracing.Start(logger.NewContext(fmt.Sprintf("HandleDocumentScenceTasks-%d", time.Now().Unix())), "DocumentMgr.HandleDocumentScenceTasks")
+			m.HandleDocumentScenceTasks(logger.WithTrace(ctx))
+			span.End()
 		case <-m.close:
 			return
 		}
@@ -94,8 +367,9 @@ func (m *DocumentMgr) loopHandleImageGenTasks() {
 	for {
 		select {
 		case <-ticker.C:
-			ctx := logger.NewContext(fmt.Sprintf("HandleImageGenTasks-%d", time.Now().Unix()))
-			m.HandleImageGenTasks(ctx)
+			ctx, span := tracing.Start(logger.NewContext(fmt.Sprintf("HandleImageGenTasks-%d", time.Now().Unix())), "DocumentMgr.HandleImageGenTasks")
+			m.HandleImageGenTasks(logger.WithTrace(ctx))
+			span.End()
 		case <-m.close:
 			return
 		}
@@ -111,18 +385,22 @@ func (m *DocumentMgr) HandleDocumentRoleTasks(ctx context.Context) {
 		return
 	}
 
-	for _, doc := range docs {
-		err = m.HandleDocumentRole(ctx, doc)
-		if err != nil {
-			log.Errorf("Failed to handle document role, doc: %v, err: %v", doc, err)
-			continue
-		}
-		err = m.db.UpdateDocumentStatus(ctx, doc.ID, db.DocumentStatusRoleReady)
-		if err != nil {
-			log.Errorf("Failed to update document status, err: %v", err)
-			continue
-		}
-	}
+	m.forEachDocument(ctx, stageRole, docs, func(ctx context.Context, doc db.Document) {
+		m.traceDocument(ctx, "DocumentMgr.HandleDocumentRole", stageRole, doc, func(ctx context.Context) error {
+			err := m.HandleDocumentRole(ctx, doc)
+			if err != nil {
+				log.Errorf("Failed to handle document role, doc: %v, err: %v", doc, err)
+				return err
+			}
+			err = m.db.UpdateDocumentStatus(ctx, doc.ID, db.DocumentStatusRoleReady)
+			if err != nil {
+				log.Errorf("Failed to update document status, err: %v", err)
+				return err
+			}
+			m.publishProgress(ctx, doc.ID, ProgressRolesExtracted)
+			return nil
+		})
+	})
 }
 
 func (m *DocumentMgr) HandleDocumentRole(ctx context.Context, doc db.Document) error {
@@ -172,6 +450,14 @@ func (m *DocumentMgr) HandleDocumentRole(ctx context.Context, doc db.Document) e
 		log.Infof("Summary already exists for doc: %s", doc.ID)
 	}
 
+	// 计算章节向量，失败不影响后续流程，搜索时会补算
+	if m.embedder != nil {
+		err := m.embedder.Sync(ctx, doc.ID)
+		if err != nil {
+			log.Errorf("Failed to sync chapter embeddings, doc: %s, err: %v", doc.ID, err)
+		}
+	}
+
 	// 2. 检查是否已有角色
 	existingRoles, err := m.db.ListRolesByDocument(ctx, doc.ID)
 	if err != nil {
@@ -181,12 +467,18 @@ func (m *DocumentMgr) HandleDocumentRole(ctx context.Context, doc db.Document) e
 
 	if len(existingRoles) > 0 {
 		log.Infof("Roles already exist for doc: %s, count: %d", doc.ID, len(existingRoles))
+		m.extractRoleRelations(ctx, doc, existingRoles)
 		return nil
 	}
 
 	// 3. 提取角色（传入摘要以获得更好的结果）
 	log.Infof("Extracting roles, docID: %s", doc.ID)
-	roles, err := m.bailianClient.ExtractRoles(ctx, doc.FileID, doc.Summary)
+	prompt, err := renderPrompt(ctx, m.db, db.PromptKindRole, &promptVars{Document: newPromptDocument(&doc)})
+	if err != nil {
+		log.Errorf("Failed to render role prompt, doc: %s, err: %v", doc.ID, err)
+		return err
+	}
+	roles, err := m.bailianClient.ExtractRoles(ctx, doc.FileID, doc.Summary, prompt)
 	if err != nil {
 		log.Errorf("Failed to extract roles, doc: %s, err: %v", doc.ID, err)
 		return err
@@ -221,6 +513,9 @@ func (m *DocumentMgr) HandleDocumentRole(ctx context.Context, doc db.Document) e
 	}
 
 	log.Infof("Created %d roles for doc: %s", len(dbRoles), doc.ID)
+
+	// 5. 提取角色关系
+	m.extractRoleRelations(ctx, doc, dbRoles)
 	return nil
 }
 
@@ -233,18 +528,22 @@ func (m *DocumentMgr) HandleDocumentScenceTasks(ctx context.Context) {
 		return
 	}
 
-	for _, doc := range docs {
-		err = m.HandleDocumentScence(ctx, doc)
-		if err != nil {
-			log.Errorf("Failed to handle document scene, doc: %v, err: %v", doc, err)
-			continue
-		}
-		err = m.db.UpdateDocumentStatus(ctx, doc.ID, db.DocumentStatusSceneReady)
-		if err != nil {
-			log.Errorf("Failed to update document status, err: %v", err)
-			continue
-		}
-	}
+	m.forEachDocument(ctx, stageScene, docs, func(ctx context.Context, doc db.Document) {
+		m.traceDocument(ctx, "DocumentMgr.HandleDocumentScence", stageScene, doc, func(ctx context.Context) error {
+			err := m.HandleDocumentScence(ctx, doc)
+			if err != nil {
+				log.Errorf("Failed to handle document scene, doc: %v, err: %v", doc, err)
+				return err
+			}
+			err = m.db.UpdateDocumentStatus(ctx, doc.ID, db.DocumentStatusSceneReady)
+			if err != nil {
+				log.Errorf("Failed to update document status, err: %v", err)
+				return err
+			}
+			m.publishProgress(ctx, doc.ID, ProgressScenesSplit)
+			return nil
+		})
+	})
 }
 
 func (m *DocumentMgr) HandleDocumentScence(ctx context.Context, doc db.Document) error {
@@ -263,12 +562,24 @@ func (m *DocumentMgr) HandleDocumentScence(ctx context.Context, doc db.Document)
 		return nil
 	}
 
-	// 2. 为每个章节生成场景
+	// 2. 为每个章节生成场景，重试时跳过已切分出场景的章节
 	sceneIndex := 0
 	for _, chapter := range chapters {
+		if len(chapter.SceneIDs) > 0 {
+			sceneIndex += len(chapter.SceneIDs)
+			continue
+		}
 		log.Infof("Generating scenes for chapter, chapterID: %s, index: %d", chapter.ID, chapter.Index)
 
-		scenes, err := m.bailianClient.GenerateScenes(ctx, chapter.Content)
+		prompt, err := renderPrompt(ctx, m.db, db.PromptKindScene, &promptVars{
+			Document: newPromptDocument(&doc),
+			Chapter:  promptChapter{Index: chapter.Index, Content: chapter.Content},
+		})
+		if err != nil {
+			log.Errorf("Failed to render scene prompt, chapter: %s, err: %v", chapter.ID, err)
+			return err
+		}
+		scenes, err := m.bailianClient.GenerateScenes(ctx, chapter.Content, prompt)
 		if err != nil {
 			log.Errorf("Failed to generate scenes, chapter: %s, err: %v", chapter.ID, err)
 			return err
@@ -282,18 +593,15 @@ func (m *DocumentMgr) HandleDocumentScence(ctx context.Context, doc db.Document)
 			sceneIDs := make([]string, 0, len(scenes))
 			now := time.Now()
 
-			for _, sceneContent := range scenes {
-				sceneID := db.MakeUUID()
-				sceneIDs = append(sceneIDs, sceneID)
-				dbScenes = append(dbScenes, db.Scene{
-					ID:         sceneID,
-					ChapterID:  chapter.ID,
-					DocumentID: doc.ID,
-					Index:      sceneIndex,
-					Content:    sceneContent,
-					CreatedAt:  now,
-					UpdatedAt:  now,
-				})
+			for _, info := range scenes {
+				scene := newScene(info)
+				scene.ID = db.MakeUUID()
+				scene.ChapterID = chapter.ID
+				scene.DocumentID = doc.ID
+				scene.Index = sceneIndex
+				scene.CreatedAt, scene.UpdatedAt = now, now
+				sceneIDs = append(sceneIDs, scene.ID)
+				dbScenes = append(dbScenes, scene)
 				sceneIndex++
 			}
 
@@ -320,34 +628,328 @@ func (m *DocumentMgr) HandleDocumentScence(ctx context.Context, doc db.Document)
 func (m *DocumentMgr) HandleImageGenTasks(ctx context.Context) {
 	log := logger.FromContext(ctx)
 
-	// 查询 sceneReady 状态的文档
-	docs, err := m.db.ListSceneReadyDocuments(ctx)
-	if err != nil {
-		log.Errorf("Failed to list sceneReady documents, err: %v", err)
-		return
+	// 文档被高优先级文档中断后重新查询，按优先级从高到低处理；
+	// 每个文档每轮最多让出一次，避免高优先级文档无法推进（如配额不足）时反复让出
+	var mu sync.Mutex
+	yielded := make(map[string]bool)
+	for preempted := true; preempted; {
+		// 查询 sceneReady 状态的文档
+		docs, err := m.db.ListSceneReadyDocuments(ctx)
+		if err != nil {
+			log.Errorf("Failed to list sceneReady documents, err: %v", err)
+			return
+		}
+
+		var yielding atomic.Bool
+		m.forEachDocument(ctx, stageImage, docs, func(ctx context.Context, doc db.Document) {
+			// 有文档让出后不再开始新的文档，重新查询
+			if yielding.Load() {
+				return
+			}
+			mu.Lock()
+			preemptible := !yielded[doc.ID]
+			mu.Unlock()
+
+			m.traceDocument(ctx, "DocumentMgr.HandleDocumentImageGen", stageImage, doc, func(ctx context.Context) error {
+				err := m.handleDocumentImageGen(ctx, doc, preemptible)
+				if errors.Is(err, errDocumentPreempted) {
+					log.Infof("Document preempted by higher priority document, doc: %s", doc.ID)
+					mu.Lock()
+					yielded[doc.ID] = true
+					mu.Unlock()
+					yielding.Store(true)
+					return err
+				}
+				if err != nil {
+					log.Errorf("Failed to handle document image gen, doc: %s, err: %v", doc.ID, err)
+					return err // 失败保持状态，按重试策略稍后继续处理
+				}
+
+				status, err := m.completeDocumentImageGen(ctx, doc)
+				if err != nil {
+					log.Errorf("Failed to complete document image gen, doc: %s, err: %v", doc.ID, err)
+					return err
+				}
+
+				log.Infof("Image generation completed for doc: %s, status: %s", doc.ID, status)
+				return nil
+			})
+		})
+		preempted = yielding.Load()
 	}
+}
 
-	// 逐个处理文档
+// forEachDocument 将文档按优先级加入 stage 的任务队列，由 Workers 个 goroutine 从队列领取并处理，队列为空后返回；
+// 多实例部署时共享同一队列，同一文档同一时间只由一个实例处理。服务退出时不再领取新文档
+func (m *DocumentMgr) forEachDocument(ctx context.Context, stage string, docs []db.Document, fn func(ctx context.Context, doc db.Document)) {
+	log := logger.FromContext(ctx)
 	for _, doc := range docs {
-		err = m.HandleDocumentImageGen(ctx, doc)
+		err := m.queue.Enqueue(ctx, stage, doc.ID, float64(doc.CreatedAt.UnixMilli())-float64(doc.Priority)*priorityScoreStep)
 		if err != nil {
-			log.Errorf("Failed to handle document image gen, doc: %s, err: %v", doc.ID, err)
-			continue // 失败保持状态，下次继续处理
+			log.Errorf("Failed to enqueue document, doc: %s, stage: %s, err: %v", doc.ID, stage, err)
+		}
+	}
+
+	var wg sync.WaitGroup
+	for i := 0; i < m.Concurrency().Workers; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for {
+				if m.stopping() {
+					log.Infof("Document manager stopping, skip remaining documents")
+					return
+				}
+				lease, err := m.queue.Dequeue(ctx, stage)
+				if err != nil {
+					log.Errorf("Failed to dequeue document, stage: %s, err: %v", stage, err)
+					return
+				}
+				if lease == nil {
+					return
+				}
+				m.handleLease(ctx, stage, lease, fn)
+			}
+		}()
+	}
+	wg.Wait()
+}
+
+// handleLease 处理领取的文档，处理期间定期续约，结束后从队列删除。文档可能已由其他实例处理完或被暂停，
+// 处理前重新检查状态；续约失败说明租约已超时，文档可能已被其他实例领取，取消当前处理。
+// 文档在本实例被取消时立即取消处理，在其他实例被取消时在续约时发现并取消。
+// 处理期间持有文档锁，租约超时重新入队或不同阶段的队列同时领取到同一文档时，只有一个 worker 处理，
+// 避免重复创建场景和重复调用百炼
+func (m *DocumentMgr) handleLease(ctx context.Context, stage string, lease *jobqueue.Lease, fn func(ctx context.Context, doc db.Document)) {
+	log := logger.FromContext(ctx)
+	leaseCtx, cancel := context.WithCancelCause(ctx)
+	defer cancel(nil)
+
+	lock, err := m.locker.TryLock(ctx, documentLockKey(lease.JobID))
+	if err != nil {
+		// 文档正由其他 worker 处理，或无法确认独占时跳过，下一轮重新入队
+		if errors.Is(err, dlock.ErrLocked) {
+			log.Infof("Document is being processed by another worker, skip, doc: %s, stage: %s", lease.JobID, stage)
+		} else {
+			log.Errorf("Failed to lock document, doc: %s, stage: %s, err: %v", lease.JobID, stage, err)
+		}
+		m.ackLease(ctx, stage, lease)
+		return
+	}
+	m.track(lease.JobID, cancel)
+	defer m.untrack(lease.JobID)
+
+	done := make(chan struct{})
+	heartbeatDone := make(chan struct{})
+	go func() {
+		defer close(heartbeatDone)
+		ticker := time.NewTicker(min(m.queue.VisibilityTimeout(), m.locker.TTL()) / 3)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-ticker.C:
+				err := m.queue.Heartbeat(ctx, lease)
+				if errors.Is(err, jobqueue.ErrLeaseLost) {
+					log.Warnf("Document lease lost, cancel processing, doc: %s, stage: %s", lease.JobID, stage)
+					cancel(err)
+					return
+				}
+				if err != nil {
+					log.Errorf("Failed to heartbeat document lease, doc: %s, err: %v", lease.JobID, err)
+				}
+				err = m.locker.Extend(ctx, lock)
+				if errors.Is(err, dlock.ErrLockLost) {
+					log.Warnf("Document lock lost, cancel processing, doc: %s, stage: %s", lease.JobID, stage)
+					cancel(err)
+					return
+				}
+				if err != nil {
+					log.Errorf("Failed to extend document lock, doc: %s, err: %v", lease.JobID, err)
+				}
+				if m.canceled(ctx, lease.JobID) {
+					log.Infof("Document canceled, cancel processing, doc: %s, stage: %s", lease.JobID, stage)
+					cancel(errDocumentCanceled)
+					return
+				}
+			case <-done:
+				return
+			}
 		}
+	}()
+
+	doc, err := m.db.GetDocument(leaseCtx, lease.JobID)
+	if err != nil {
+		log.Errorf("Failed to get document, doc: %s, err: %v", lease.JobID, err)
+	} else if doc.Status == stageStatus[stage] && !doc.Paused && (doc.NextAttemptAt == nil || !doc.NextAttemptAt.After(time.Now())) {
+		fn(leaseCtx, doc)
+	}
+	close(done)
+	<-heartbeatDone
+
+	err = m.locker.Unlock(ctx, lock)
+	if err != nil && !errors.Is(err, dlock.ErrLockLost) {
+		log.Errorf("Failed to unlock document, doc: %s, stage: %s, err: %v", lease.JobID, stage, err)
+	}
+	m.ackLease(ctx, stage, lease)
+}
+
+// ackLease 租约已失效时任务由其他实例处理，不影响当前文档
+func (m *DocumentMgr) ackLease(ctx context.Context, stage string, lease *jobqueue.Lease) {
+	err := m.queue.Ack(ctx, lease)
+	if err != nil && !errors.Is(err, jobqueue.ErrLeaseLost) {
+		logger.FromContext(ctx).Errorf("Failed to ack document, doc: %s, stage: %s, err: %v", lease.JobID, stage, err)
+	}
+}
+
+func documentLockKey(docID string) string {
+	return "document:" + docID
+}
+
+func (m *DocumentMgr) track(docID string, cancel context.CancelCauseFunc) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.running[docID] = cancel
+}
+
+func (m *DocumentMgr) untrack(docID string) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	delete(m.running, docID)
+}
+
+// Cancel 取消本实例中正在处理的文档，返回文档是否正在处理
+func (m *DocumentMgr) Cancel(docID string) bool {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	cancel, ok := m.running[docID]
+	if ok {
+		cancel(errDocumentCanceled)
+	}
+	return ok
+}
+
+// canceled 文档是否已被取消，查询失败时继续处理
+func (m *DocumentMgr) canceled(ctx context.Context, docID string) bool {
+	doc, err := m.db.GetDocument(ctx, docID)
+	if err != nil {
+		logger.FromContext(ctx).Errorf("Failed to get document, doc: %s, err: %v", docID, err)
+		return false
+	}
+	return doc.Status == db.DocumentStatusCanceled
+}
+
+// withSlot 占用一个调用百炼的并发名额执行 fn，fn 返回或 panic 后释放；服务退出或文档处理被取消时不再等待名额
+func (m *DocumentMgr) withSlot(ctx context.Context, slots chan struct{}, fn func() error) error {
+	select {
+	case slots <- struct{}{}:
+	case <-m.close:
+		return errDocumentMgrStopping
+	case <-ctx.Done():
+		return context.Cause(ctx)
+	}
+	defer func() { <-slots }()
+	return fn()
+}
 
-		// 更新文档状态为 imgReady
-		err = m.db.UpdateDocumentStatus(ctx, doc.ID, db.DocumentStatusImgReady)
+// paused 文档是否已被暂停，查询失败时继续处理
+func (m *DocumentMgr) paused(ctx context.Context, docID string) bool {
+	doc, err := m.db.GetDocument(ctx, docID)
+	if err != nil {
+		logger.FromContext(ctx).Errorf("Failed to get document, doc: %s, err: %v", docID, err)
+		return false
+	}
+	return doc.Paused
+}
+
+// preempted 是否有更高优先级的文档等待生成图片，查询失败时继续处理当前文档
+func (m *DocumentMgr) preempted(ctx context.Context, doc db.Document) bool {
+	above, err := m.db.HasDueDocumentAbove(ctx, db.DocumentStatusSceneReady, doc.Priority)
+	if err != nil {
+		logger.FromContext(ctx).Errorf("Failed to check higher priority documents, err: %v", err)
+		return false
+	}
+	return above
+}
+
+// traceDocument 为单个文档的处理创建 span，带上 document.id 便于跨多轮处理检索同一文档的链路；
+// 处理中 panic 时文档标记为失败，其他错误按阶段的重试策略推迟处理，不影响同一轮的其他文档
+func (m *DocumentMgr) traceDocument(ctx context.Context, name string, stage string, doc db.Document, fn func(ctx context.Context) error) {
+	ctx, span := tracing.Start(ctx, name, attribute.String("document.id", doc.ID))
+	defer span.End()
+
+	ctx = withRequestLog(ctx, m.db, doc.ID, stage, "")
+	err := safeCall(ctx, fn)
+	tracing.RecordError(span, err)
+	var pe *panicError
+	if errors.As(err, &pe) {
+		m.failDocumentOnPanic(ctx, stage, doc, pe)
+	} else if err != nil {
+		m.retryDocument(ctx, stage, doc, err)
+	}
+}
+
+// retryDocument 记录文档阶段失败：未达到最大次数时按退避时间推迟下次处理，达到后文档标记为失败。
+// 服务退出、暂停、取消、被高优先级文档中断、配额不足和租约或文档锁失效不是处理失败，不计入次数
+func (m *DocumentMgr) retryDocument(ctx context.Context, stage string, doc db.Document, err error) {
+	if errors.Is(err, errDocumentMgrStopping) || errors.Is(err, errDocumentPaused) || errors.Is(err, errDocumentPreempted) ||
+		errors.Is(err, errQuotaExceeded) || errors.Is(err, db.ErrDocumentCanceled) || errors.Is(context.Cause(ctx), jobqueue.ErrLeaseLost) ||
+		errors.Is(context.Cause(ctx), dlock.ErrLockLost) || errors.Is(context.Cause(ctx), errDocumentCanceled) {
+		return
+	}
+	log := logger.FromContext(ctx)
+
+	policy := m.config.Retry[stage]
+	attempts := doc.Attempts + 1
+	errMsg := truncateError(err.Error())
+
+	// 场景生成失败的次数记录在场景上，由 MaxSceneAttempts 限制，文档只做退避
+	if attempts >= policy.MaxAttempts && !errors.Is(err, errScenesRetrying) {
+		log.Warnf("Document failed permanently, doc: %s, stage: %s, attempts: %d, err: %v", doc.ID, stage, attempts, err)
+		recordDocumentLog(ctx, m.db, db.DocumentLog{
+			DocumentID: doc.ID,
+			Stage:      stage,
+			Level:      db.LogLevelError,
+			Message:    fmt.Sprintf("failed permanently after %d attempts: %s", attempts, errMsg),
+		})
+		err = m.db.FailDocument(ctx, doc.ID, stage, attempts, errMsg)
 		if err != nil {
-			log.Errorf("Failed to update document status, doc: %s, err: %v", doc.ID, err)
-			continue
+			log.Errorf("Failed to fail document, doc: %s, err: %v", doc.ID, err)
+			return
 		}
+		recordEvent(ctx, m.db, db.Event{
+			UserID:     doc.UserID,
+			Type:       db.EventDocumentFinished,
+			DocumentID: doc.ID,
+			Message:    fmt.Sprintf("document %s failed at %s stage after %d attempts", doc.Name, stage, attempts),
+		})
+		m.publishProgress(ctx, doc.ID, ProgressDocumentFailed)
+		m.notifyDocument(ctx, doc.ID, WebhookDocumentFailed)
+		return
+	}
 
-		log.Infof("Image generation completed for doc: %s", doc.ID)
+	next := time.Now().Add(policy.backoff(attempts))
+	log.Infof("Document will be retried, doc: %s, stage: %s, attempts: %d, next: %s", doc.ID, stage, attempts, next.Format(time.DateTime))
+	recordDocumentLog(ctx, m.db, db.DocumentLog{
+		DocumentID: doc.ID,
+		Stage:      stage,
+		Level:      db.LogLevelError,
+		Message:    fmt.Sprintf("attempt %d failed, retry at %s: %s", attempts, next.Format(time.DateTime), errMsg),
+	})
+	err = m.db.UpdateDocumentAttempt(ctx, doc.ID, attempts, errMsg, next)
+	if err != nil {
+		log.Errorf("Failed to update document attempt, doc: %s, err: %v", doc.ID, err)
 	}
 }
 
 // HandleDocumentImageGen 处理单个文档的图片生成
+// 单个场景失败不会中断整个文档，失败次数记录在场景上，达到上限后场景永久失败；
+// 仍有待重试的场景时返回 error，文档保持 sceneReady 状态等待下次处理
 func (m *DocumentMgr) HandleDocumentImageGen(ctx context.Context, doc db.Document) error {
+	return m.handleDocumentImageGen(ctx, doc, true)
+}
+
+// handleDocumentImageGen preemptible 为 false 时不因更高优先级的文档让出
+func (m *DocumentMgr) handleDocumentImageGen(ctx context.Context, doc db.Document, preemptible bool) error {
 	log := logger.FromContext(ctx)
 	log.Infof("Handling document image generation, docID: %s", doc.ID)
 
@@ -361,12 +963,7 @@ func (m *DocumentMgr) HandleDocumentImageGen(ctx context.Context, doc db.Documen
 	// 转换为 bailian.RoleInfo
 	roles := make([]bailian.RoleInfo, 0, len(dbRoles))
 	for _, r := range dbRoles {
-		roles = append(roles, bailian.RoleInfo{
-			Name:       r.Name,
-			Gender:     r.Gender,
-			Character:  r.Character,
-			Appearance: r.Appearance,
-		})
+		roles = append(roles, roleInfo(&r))
 	}
 
 	// 2. 获取所有未生成图片的场景
@@ -384,41 +981,305 @@ func (m *DocumentMgr) HandleDocumentImageGen(ctx context.Context, doc db.Documen
 	log.Infof("Found %d pending image scenes for doc: %s", len(scenes), doc.ID)
 
 	// 3. 为每个场景生成图片和语音（包含摘要和角色信息）
+	retrying := 0
 	for _, scene := range scenes {
-		log.Infof("Generating image and voice for scene, sceneID: %s, content: %s", scene.ID, scene.Content)
+		// 每个场景处理完即落库，退出时在场景之间中断
+		if m.stopping() {
+			log.Infof("Document manager stopping, interrupt doc: %s", doc.ID)
+			return errDocumentMgrStopping
+		}
+		if m.paused(ctx, doc.ID) {
+			log.Infof("Document paused, interrupt doc: %s", doc.ID)
+			return errDocumentPaused
+		}
+		// 大文档场景很多，每个场景前检查是否有更高优先级的文档，避免其长时间等待
+		if preemptible && m.preempted(ctx, doc) {
+			return errDocumentPreempted
+		}
 
-		imageURL, err := m.bailianClient.GenerateImage(ctx, scene.Content, doc.Summary, roles)
+		// 生成前检查租户当日配额，配额不足时文档保持 sceneReady，待配额恢复后继续
+		err = m.quota.CheckGeneration(ctx, doc.UserID, 1, 1)
 		if err != nil {
-			log.Errorf("Failed to generate image, scene: %s, err: %v", scene.ID, err)
-			return err // 失败则整个文档重试
+			log.Warnf("Quota check failed, doc: %s, userID: %d, err: %v", doc.ID, doc.UserID, err)
+			return err
 		}
 
-		// 更新场景图片 URL
-		err = m.db.UpdateSceneImageURL(ctx, scene.ID, imageURL)
+		err = safeCall(withRequestLog(ctx, m.db, doc.ID, stageImage, scene.ID), func(ctx context.Context) error {
+			return m.handleSceneImageGen(ctx, doc, scene, roles)
+		})
+		if errors.Is(err, errDocumentMgrStopping) {
+			log.Infof("Document manager stopping, interrupt doc: %s", doc.ID)
+			return err
+		}
+		// 处理被取消或租约失效时中断的调用不计入场景的失败次数
+		if ctx.Err() != nil {
+			log.Infof("Document processing canceled, interrupt doc: %s, cause: %v", doc.ID, context.Cause(ctx))
+			return context.Cause(ctx)
+		}
+		// 未通过审核的场景不重试，等待管理员复核
+		if errors.Is(err, errSceneBlocked) {
+			m.publishProgress(ctx, doc.ID, ProgressSceneBlocked)
+			continue
+		}
+		if err == nil {
+			err = m.quota.RecordGeneration(ctx, doc.UserID, 1, 1)
+			if err != nil {
+				log.Errorf("Failed to record quota usage, doc: %s, err: %v", doc.ID, err)
+			}
+			m.publishProgress(ctx, doc.ID, ProgressSceneGenerated)
+			continue
+		}
+
+		// panic 不会因重试而恢复，场景直接永久失败，调用栈记录到事件日志
+		attempts := scene.Attempts + 1
+		status := ""
+		var pe *panicError
+		if errors.As(err, &pe) {
+			status = db.SceneStatusFailed
+			log.Errorf("Scene processing panicked, scene: %s, err: %v, stack: %s", scene.ID, pe, pe.stack)
+			recordEvent(ctx, m.db, db.Event{
+				UserID:     doc.UserID,
+				Type:       db.EventPipelinePanic,
+				DocumentID: doc.ID,
+				SceneID:    scene.ID,
+				Message:    fmt.Sprintf("scene %d of %s failed due to an internal error", scene.Index, doc.Name),
+				Detail:     fmt.Sprintf("%v\n%s", pe, pe.stack),
+			})
+		} else if attempts >= m.config.MaxSceneAttempts {
+			status = db.SceneStatusFailed
+			log.Warnf("Scene failed permanently, scene: %s, attempts: %d, err: %v", scene.ID, attempts, err)
+		} else {
+			retrying++
+		}
+		recordDocumentLog(ctx, m.db, db.DocumentLog{
+			DocumentID: doc.ID,
+			SceneID:    scene.ID,
+			Stage:      stageImage,
+			Level:      db.LogLevelError,
+			Message:    fmt.Sprintf("scene attempt %d failed: %s", attempts, err),
+		})
+		err = m.db.UpdateSceneStatus(ctx, scene.ID, status, attempts, truncateError(err.Error()))
 		if err != nil {
-			log.Errorf("Failed to update scene imageURL, scene: %s, err: %v", scene.ID, err)
+			log.Errorf("Failed to update scene status, scene: %s, err: %v", scene.ID, err)
 			return err
 		}
 
-		log.Infof("Image generated for scene: %s, URL: %s", scene.ID, imageURL)
+		// 永久失败的场景使用占位媒体，保证导出和播放清单完整
+		if status == db.SceneStatusFailed {
+			imageURL, voiceURL := placeholderURLs(m.mediaBaseURL, scene.ID)
+			err = m.db.UpdateScenePlaceholder(ctx, scene.ID, imageURL, voiceURL)
+			if err != nil {
+				log.Errorf("Failed to update scene placeholder, scene: %s, err: %v", scene.ID, err)
+				return err
+			}
+			audioMs := placeholder.Duration(scene.Content).Milliseconds()
+			err = m.db.UpdateSceneTiming(ctx, scene.ID, audioMs, displayDurationMs(audioMs))
+			if err != nil {
+				log.Errorf("Failed to update scene timing, scene: %s, err: %v", scene.ID, err)
+				return err
+			}
+			recordEvent(ctx, m.db, db.Event{
+				UserID:     doc.UserID,
+				Type:       db.EventSceneFlagged,
+				DocumentID: doc.ID,
+				SceneID:    scene.ID,
+				Message:    fmt.Sprintf("scene %d of %s failed after %d attempts, placeholder media used", scene.Index, doc.Name, attempts),
+			})
+			m.publishProgress(ctx, doc.ID, ProgressSceneFailed)
+		}
+	}
+
+	if retrying > 0 {
+		return fmt.Errorf("%d %w", retrying, errScenesRetrying)
+	}
 
-		// 生成语音
-		voiceURL, err := m.bailianClient.GenerateTTS(ctx, scene.Content)
+	log.Infof("All images generated for doc: %s", doc.ID)
+	return nil
+}
+
+// handleSceneImageGen 为单个场景生成图片和语音，两者都成功后才写入 URL
+func (m *DocumentMgr) handleSceneImageGen(ctx context.Context, doc db.Document, scene db.Scene, roles []bailian.RoleInfo) error {
+	log := logger.FromContext(ctx)
+	log.Infof("Generating image and voice for scene, sceneID: %s, content: %s", scene.ID, scene.Content)
+
+	opts := sceneImageOptions(m.styles, &doc, &scene, roles)
+	imageContent := sceneImageContent(&scene, scene.Content)
+	prompt, err := renderPrompt(ctx, m.db, db.PromptKindImage, imagePromptVars(&doc, &scene, imageContent, opts))
+	if err != nil {
+		log.Errorf("Failed to render image prompt, scene: %s, err: %v", scene.ID, err)
+		return err
+	}
+	opts.Prompt = prompt
+
+	// 管理员复核放行的场景不再审核
+	moderated := m.moderation != nil && !scene.ModerationPassed
+	if moderated {
+		reason, err := moderateSceneText(ctx, m.moderation, scene.Content)
 		if err != nil {
-			log.Errorf("Failed to generate TTS, scene: %s, err: %v", scene.ID, err)
+			log.Errorf("Failed to moderate scene content, scene: %s, err: %v", scene.ID, err)
 			return err
 		}
+		if reason != "" {
+			return m.blockScene(ctx, doc, scene, reason, "")
+		}
+	}
 
-		// 更新场景语音 URL
-		err = m.db.UpdateSceneVoiceURL(ctx, scene.ID, voiceURL)
+	// 放行的场景使用审核时扣留的图片，不再重新生成
+	imageURL := scene.HeldImageURL
+	if !scene.ModerationPassed || imageURL == "" {
+		err = m.withSlot(ctx, m.slots(true), func() (err error) {
+			imageURL, err = m.images.Get(doc.ImageProvider).Generate(ctx, imageContent, opts)
+			return err
+		})
+		if err != nil {
+			log.Errorf("Failed to generate image, scene: %s, err: %v", scene.ID, err)
+			return err
+		}
+		log.Infof("Image generated for scene: %s, URL: %s", scene.ID, imageURL)
+	}
+	if moderated {
+		reason, err := moderateSceneImage(ctx, m.moderation, m.imageStore, imageURL)
 		if err != nil {
-			log.Errorf("Failed to update scene voiceURL, scene: %s, err: %v", scene.ID, err)
+			log.Errorf("Failed to moderate scene image, scene: %s, err: %v", scene.ID, err)
 			return err
 		}
+		if reason != "" {
+			return m.blockScene(ctx, doc, scene, reason, imageURL)
+		}
+	}
 
-		log.Infof("Voice generated for scene: %s, URL: %s", scene.ID, voiceURL)
+	// 生成语音
+	var voiceURL string
+	err = m.withSlot(ctx, m.slots(false), func() (err error) {
+		voiceURL, err = m.tts.Synthesize(ctx, scene.Content, sceneTTSOptions(&doc, &scene))
+		return err
+	})
+	if err != nil {
+		log.Errorf("Failed to generate TTS, scene: %s, err: %v", scene.ID, err)
+		return err
 	}
+	log.Infof("Voice generated for scene: %s, URL: %s", scene.ID, voiceURL)
 
-	log.Infof("All images generated for doc: %s", doc.ID)
+	audioMs, displayMs := sceneTiming(ctx, m.bailianClient, voiceURL, scene.Content)
+	err = m.db.UpdateSceneTiming(ctx, scene.ID, audioMs, displayMs)
+	if err != nil {
+		log.Errorf("Failed to update scene timing, scene: %s, err: %v", scene.ID, err)
+		return err
+	}
+
+	// 更新场景语音 URL（图片 URL 最后写入，图片 URL 非空即表示场景不再待生成）
+	err = m.db.UpdateSceneVoiceURL(ctx, scene.ID, voiceURL)
+	if err != nil {
+		log.Errorf("Failed to update scene voiceURL, scene: %s, err: %v", scene.ID, err)
+		return err
+	}
+	updateSceneSubtitle(ctx, m.db, m.audioStore, scene.ID, scene.Content, audioMs)
+
+	// 更新场景图片 URL
+	err = m.db.UpdateSceneImageURL(ctx, scene.ID, imageURL)
+	if err != nil {
+		log.Errorf("Failed to update scene imageURL, scene: %s, err: %v", scene.ID, err)
+		return err
+	}
+
+	err = m.db.UpdateSceneStatus(ctx, scene.ID, db.SceneStatusReady, scene.Attempts, "")
+	if err != nil {
+		return err
+	}
+	recordSceneGeneration(ctx, m.db, m.config, scene, db.SceneGeneration{
+		ImageURL:          imageURL,
+		VoiceURL:          voiceURL,
+		AudioDurationMs:   audioMs,
+		DisplayDurationMs: displayMs,
+	})
+	recordSceneImageVersion(ctx, m.db, m.config, scene, imageURL)
+
+	m.notifyScene(ctx, doc, scene.ID, WebhookSceneImageReady, WebhookSceneVoiceReady)
 	return nil
 }
+
+// completeDocumentImageGen 统计文档的场景生成结果，更新文档的场景计数和最终状态
+// 全部成功为 imgReady；存在永久失败场景但成功比例达标为 completedWithErrors；否则为 failed
+func (m *DocumentMgr) completeDocumentImageGen(ctx context.Context, doc db.Document) (string, error) {
+	scenes, err := m.db.ListScenesByDocument(ctx, doc.ID)
+	if err != nil {
+		return "", err
+	}
+
+	failed := 0
+	for _, scene := range scenes {
+		if sceneFailed(&scene) {
+			failed++
+		}
+	}
+	err = m.db.UpdateDocumentSceneStats(ctx, doc.ID, len(scenes), failed)
+	if err != nil {
+		return "", err
+	}
+
+	status := db.DocumentStatusImgReady
+	if failed > 0 {
+		ratio := float64(len(scenes)-failed) / float64(len(scenes))
+		if ratio >= m.config.MinSceneSuccessRatio {
+			status = db.DocumentStatusCompletedWithErrors
+		} else {
+			status = db.DocumentStatusFailed
+		}
+	}
+	if status == db.DocumentStatusFailed {
+		err = m.db.FailDocument(ctx, doc.ID, stageImage, 0, fmt.Sprintf("%d/%d scenes failed", failed, len(scenes)))
+	} else {
+		err = m.db.UpdateDocumentStatus(ctx, doc.ID, status)
+	}
+	if err != nil {
+		return "", err
+	}
+
+	// 封面选择失败不影响文档完成，下次生成完成时重新选择
+	err = refreshChapterCovers(ctx, m.db, doc.ID)
+	if err != nil {
+		logger.FromContext(ctx).Errorf("Failed to refresh chapter covers, doc: %s, err: %v", doc.ID, err)
+	}
+
+	recordEvent(ctx, m.db, db.Event{
+		UserID:     doc.UserID,
+		Type:       db.EventDocumentFinished,
+		DocumentID: doc.ID,
+		Message:    fmt.Sprintf("document %s finished with status %s, %d/%d scenes failed", doc.Name, status, failed, len(scenes)),
+	})
+	m.recordRun(ctx, doc, status, scenes)
+	if status == db.DocumentStatusFailed {
+		m.publishProgress(ctx, doc.ID, ProgressDocumentFailed)
+		m.notifyDocument(ctx, doc.ID, WebhookDocumentFailed)
+	} else {
+		m.publishProgress(ctx, doc.ID, ProgressDocumentFinished)
+		m.notifyDocument(ctx, doc.ID, WebhookDocumentProcessed)
+	}
+	return status, nil
+}
+
+// notifyDocument 以文档最新状态发送回调
+func (m *DocumentMgr) notifyDocument(ctx context.Context, docID string, eventType string) {
+	if m.webhooks == nil {
+		return
+	}
+	doc, err := m.db.GetDocument(ctx, docID)
+	if err != nil {
+		logger.FromContext(ctx).Errorf("Failed to get document for webhook, doc: %s, err: %v", docID, err)
+		return
+	}
+	m.webhooks.Notify(ctx, doc, eventType, makeDocument(&doc))
+}
+
+// notifyScene 以场景最新数据发送回调
+func (m *DocumentMgr) notifyScene(ctx context.Context, doc db.Document, sceneID string, eventTypes ...string) {
+	if m.webhooks == nil {
+		return
+	}
+	scene, err := m.db.GetScene(ctx, sceneID)
+	if err != nil {
+		logger.FromContext(ctx).Errorf("Failed to get scene for webhook, scene: %s, err: %v", sceneID, err)
+		return
+	}
+	m.webhooks.NotifyScene(ctx, doc, scene, eventTypes...)
+}
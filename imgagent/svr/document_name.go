@@ -0,0 +1,100 @@
+package svr
+
+import (
+	"fmt"
+	"net/http"
+	"regexp"
+	"unicode"
+	"unicode/utf8"
+
+	"imgagent/api"
+	"imgagent/db"
+	hutil "imgagent/httputil"
+)
+
+const (
+	// defaultDocumentNameLen 未配置 max_length 时文档名的最大字符数
+	defaultDocumentNameLen = 50
+	// maxDocumentNameLen 文档名的最大字符数，与数据库字段长度一致
+	maxDocumentNameLen = 128
+)
+
+// DocumentNameConfig 文档名校验规则。名称总是去掉首尾空白、不允许控制字符；
+// 判断重名和保留名称时按 db.DocumentNameKey 归一化（全角转半角、忽略大小写）后比较
+type DocumentNameConfig struct {
+	MaxLength     int      `json:"max_length"`     // 最大字符数，默认 50，最大 128
+	Pattern       string   `json:"pattern"`        // 名称需匹配的正则，如 ^[\p{Han}\w .()-]+$，为空时不限制字符
+	ReservedNames []string `json:"reserved_names"` // 保留名称，不能用作文档名
+}
+
+type documentNameRules struct {
+	conf     DocumentNameConfig
+	pattern  *regexp.Regexp
+	reserved map[string]bool
+}
+
+// newDocumentNameRules 启动时编译校验规则，规则不合法时拒绝启动
+func newDocumentNameRules(conf DocumentNameConfig) (*documentNameRules, error) {
+	if conf.MaxLength <= 0 {
+		conf.MaxLength = defaultDocumentNameLen
+	}
+	if conf.MaxLength > maxDocumentNameLen {
+		return nil, fmt.Errorf("document name max_length exceeds %d", maxDocumentNameLen)
+	}
+	r := &documentNameRules{conf: conf, reserved: make(map[string]bool, len(conf.ReservedNames))}
+	if conf.Pattern != "" {
+		var err error
+		r.pattern, err = regexp.Compile(conf.Pattern)
+		if err != nil {
+			return nil, fmt.Errorf("document name pattern: %w", err)
+		}
+	}
+	for _, name := range conf.ReservedNames {
+		r.reserved[db.DocumentNameKey(name)] = true
+	}
+	return r, nil
+}
+
+func (r *documentNameRules) maxLength() int {
+	if r == nil {
+		return defaultDocumentNameLen
+	}
+	return r.conf.MaxLength
+}
+
+// Validate 校验已去掉首尾空白的文档名
+func (r *documentNameRules) Validate(name string) error {
+	if name == "" {
+		return hutil.NewApiError(http.StatusBadRequest, "name is required")
+	}
+	if n := r.maxLength(); utf8.RuneCountInString(name) > n {
+		return hutil.NewApiError(http.StatusBadRequest, fmt.Sprintf("name exceeds maximum length of %d", n))
+	}
+	for _, c := range name {
+		if unicode.IsControl(c) || c == utf8.RuneError {
+			return hutil.NewApiError(http.StatusBadRequest, "name contains invalid characters")
+		}
+	}
+	if r == nil {
+		return nil
+	}
+	if r.reserved[db.DocumentNameKey(name)] {
+		return hutil.NewApiError(http.StatusBadRequest, "name is reserved")
+	}
+	if r.pattern != nil && !r.pattern.MatchString(name) {
+		return hutil.NewApiError(http.StatusBadRequest, "name contains disallowed characters")
+	}
+	return nil
+}
+
+// Limits 返回给客户端的文档名规则
+func (r *documentNameRules) Limits() api.DocumentNameLimits {
+	limits := api.DocumentNameLimits{MaxLength: r.maxLength(), ReservedNames: []string{}}
+	if r != nil {
+		limits.Pattern = r.conf.Pattern
+		if len(r.conf.ReservedNames) > 0 {
+			limits.ReservedNames = r.conf.ReservedNames
+		}
+	}
+	return limits
+}
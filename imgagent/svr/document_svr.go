@@ -1,48 +1,104 @@
 package svr
 
 import (
+	"cmp"
 	"context"
+	"crypto/sha256"
 	"encoding/hex"
 	"errors"
+	"fmt"
 	"io"
 	"net/http"
 	"net/url"
 	"os"
+	"regexp"
+	"slices"
+	"sort"
+	"strconv"
 	"strings"
 	"time"
+	"unicode/utf8"
 
 	"github.com/gin-gonic/gin"
 	"github.com/go-sql-driver/mysql"
 	"github.com/google/uuid"
 	"github.com/mattn/go-sqlite3"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/trace"
 	"gorm.io/gorm"
 
 	"imgagent/api"
 	"imgagent/bailian"
 	"imgagent/db"
 	hutil "imgagent/httputil"
+	"imgagent/imagegen"
 	"imgagent/pkg/logger"
 	"imgagent/spliter"
+	"imgagent/tts"
 )
 
 const (
-	ErrNoSuchDocumentCode   = 612
-	ErrExistingDocumentCode = 614
-	ErrNoSuchDocument       = "no such document"
-	ErrExistingDocument     = "existing document"
+	ErrNoSuchDocumentCode    = 612
+	ErrExistingDocumentCode  = 614
+	ErrDuplicateDocumentCode = 619
+	ErrNoSuchDocument        = "no such document"
+	ErrExistingDocument      = "existing document"
+	ErrDuplicateDocument     = "duplicate document content"
+
+	// maxNameSuggestions 文档名已存在时返回的候选名称数，nameCandidateBatch 为每次查询的候选数，
+	// 最多尝试 maxNameCandidates 个序号
+	maxNameSuggestions = 3
+	nameCandidateBatch = 10
+	maxNameCandidates  = 100
+
+	// chapterChunkSize 单个章节的最大字符数，chapterChunkOverlap 为继续分割时相邻块重叠的字符数
+	chapterChunkSize    = 5000
+	chapterChunkOverlap = 100
+
+	// maxDocumentWait 获取文档时长轮询的最大等待时间
+	maxDocumentWait = 60 * time.Second
+	// documentWaitInterval 长轮询时检查文档状态的间隔
+	documentWaitInterval = time.Second
 )
 
 func (s *Service) HandleCreateDocument(c *gin.Context) {
 	ctx := c.Request.Context()
 	log := logger.FromGinContext(c)
+	ui := GetUserInfo(c)
 
-	name := c.PostForm("name")
-	if name == "" {
-		hutil.AbortError(c, http.StatusBadRequest, "name is required")
+	// 超出大小限制的请求不再解析，避免把整个文件写到磁盘
+	maxBytes := s.uploads.maxBytes() + maxUploadFormOverhead
+	if c.Request.ContentLength > maxBytes {
+		hutil.AbortErr(c, s.uploads.sizeError())
 		return
 	}
-	if len(name) > 50 {
-		hutil.AbortError(c, http.StatusBadRequest, "name exceeds maximum length of 50")
+	c.Request.Body = http.MaxBytesReader(c.Writer, c.Request.Body, maxBytes)
+	if _, err := c.MultipartForm(); err != nil {
+		var maxErr *http.MaxBytesError
+		if errors.As(err, &maxErr) {
+			hutil.AbortErr(c, s.uploads.sizeError())
+			return
+		}
+	}
+
+	name := strings.TrimSpace(c.PostForm("name"))
+	if err := s.names.Validate(name); err != nil {
+		hutil.AbortErr(c, err)
+		return
+	}
+	priority := c.PostForm("priority")
+	autoRename := c.PostForm("auto_rename") == "true"
+	linkDuplicate := c.PostForm("link_duplicate") == "true"
+
+	// 通过 URL 创建时按租户的来源策略下载原文
+	if textURL := c.PostForm("url"); textURL != "" {
+		doc, err := s.CreateDocumentFromURL(ctx, ui.ID, name, priority, autoRename, linkDuplicate, textURL)
+		if err != nil {
+			hutil.AbortErr(c, err)
+			return
+		}
+		setAuditResource(c, doc.ID)
+		hutil.WriteData(c, doc)
 		return
 	}
 
@@ -52,644 +108,2096 @@ func (s *Service) HandleCreateDocument(c *gin.Context) {
 		hutil.AbortError(c, http.StatusBadRequest, "file is required")
 		return
 	}
-
-	log.Infof("Create document, name: %s, file: %s", name, file.Filename)
-
-	_, err = s.db.GetDocumentWithName(ctx, name)
+	f, err := file.Open()
 	if err != nil {
-		if !errors.Is(err, gorm.ErrRecordNotFound) {
-			log.Errorf("Failed to get document, err: %v", err)
-			hutil.AbortError(c, hutil.ErrServerInternalCode, "get document failed")
-			return
-		}
-	} else {
-		log.Warnf("Document existing")
-		hutil.AbortError(c, ErrExistingDocumentCode, ErrExistingDocument)
+		log.Errorf("Failed to open file, err: %v", err)
+		hutil.AbortError(c, http.StatusBadRequest, "file is required")
 		return
 	}
+	defer f.Close()
 
-	index := strings.LastIndex(file.Filename, ".")
-	if index == -1 {
-		hutil.AbortError(c, http.StatusBadRequest, "file has no extension")
+	doc, err := s.CreateDocument(ctx, ui.ID, name, priority, autoRename, linkDuplicate, file.Filename, file.Size, f)
+	if err != nil {
+		hutil.AbortErr(c, err)
 		return
 	}
-	ext := file.Filename[index+1:]
+	setAuditResource(c, doc.ID)
+	hutil.WriteData(c, doc)
+}
+
+// documentNameSuffix 匹配带序号后缀的文档名，如 "name (2)"
+var documentNameSuffix = regexp.MustCompile(`^(.+) \((\d+)\)$`)
+
+// suggestDocumentNames 为已存在的文档名生成最多 n 个当前可用的候选名称，形如 "name (2)"，
+// 原名已带序号后缀时从下一个序号开始，加上后缀超出长度限制时截断原名
+func (s *Service) suggestDocumentNames(ctx context.Context, name string, n int) ([]string, error) {
+	base, next := name, 2
+	if m := documentNameSuffix.FindStringSubmatch(name); m != nil {
+		if i, err := strconv.Atoi(m[2]); err == nil && i < maxNameCandidates {
+			base, next = m[1], i+1
+		}
+	}
+
+	var suggestions []string
+	for start := next; len(suggestions) < n && start < next+maxNameCandidates; start += nameCandidateBatch {
+		candidates := make([]string, 0, nameCandidateBatch)
+		for i := start; i < start+nameCandidateBatch; i++ {
+			candidates = append(candidates, numberedDocumentName(base, i, s.names.maxLength()))
+		}
+		existing, err := s.db.ListExistingDocumentNameKeys(ctx, candidates)
+		if err != nil {
+			return nil, err
+		}
+		for _, c := range candidates {
+			if !slices.Contains(existing, db.DocumentNameKey(c)) && len(suggestions) < n {
+				suggestions = append(suggestions, c)
+			}
+		}
+	}
+	return suggestions, nil
+}
+
+func numberedDocumentName(base string, i, maxLength int) string {
+	suffix := fmt.Sprintf(" (%d)", i)
+	for utf8.RuneCountInString(base)+len(suffix) > maxLength {
+		_, size := utf8.DecodeLastRuneInString(base)
+		base = base[:len(base)-size]
+	}
+	return base + suffix
+}
+
+var documentPriorities = map[string]int{
+	"high":   db.DocumentPriorityHigh,
+	"normal": db.DocumentPriorityNormal,
+	"low":    db.DocumentPriorityLow,
+}
+
+// parseDocumentPriority 解析处理优先级，为空时为 normal
+func parseDocumentPriority(priority string) (int, error) {
+	if priority == "" {
+		return db.DocumentPriorityNormal, nil
+	}
+	p, ok := documentPriorities[priority]
+	if !ok {
+		return 0, hutil.NewApiError(http.StatusBadRequest, "priority must be one of high, normal, low")
+	}
+	return p, nil
+}
+
+func documentPriorityName(priority int) string {
+	switch {
+	case priority > db.DocumentPriorityNormal:
+		return "high"
+	case priority < db.DocumentPriorityNormal:
+		return "low"
+	}
+	return "normal"
+}
+
+// CreateDocumentFromURL 下载 textURL 的原文后创建文档，下载受租户的 URL 来源策略限制
+func (s *Service) CreateDocumentFromURL(ctx context.Context, userID int64, name, priority string, autoRename, linkDuplicate bool, textURL string) (*api.Document, error) {
+	log := logger.FromContext(ctx)
+
+	name = strings.TrimSpace(name)
+	if err := s.names.Validate(name); err != nil {
+		return nil, err
+	}
+	filename, err := s.downloadDocument(ctx, userID, textURL)
+	if err != nil {
+		return nil, err
+	}
+	defer os.Remove(filename)
+
+	f, err := os.Open(filename)
+	if err != nil {
+		log.Errorf("Failed to open file, err: %v", err)
+		return nil, hutil.NewApiError(hutil.ErrServerInternalCode, "open file failed")
+	}
+	defer f.Close()
+	fi, err := f.Stat()
+	if err != nil {
+		log.Errorf("Failed to stat file, err: %v", err)
+		return nil, hutil.NewApiError(hutil.ErrServerInternalCode, "open file failed")
+	}
+	return s.CreateDocument(ctx, userID, name, priority, autoRename, linkDuplicate, filename, fi.Size(), f)
+}
+
+// CreateDocument 保存原文并拆分章节，上传到百炼后创建文档，后续由 DocumentMgr 异步处理。
+// 租户已上传过相同内容时返回已有文档的信息，linkDuplicate 为 true 时直接返回已有文档；
+// 文档名已存在时返回候选名称，autoRename 为 true 时改用第一个候选名称创建
+func (s *Service) CreateDocument(ctx context.Context, userID int64, name, priority string, autoRename, linkDuplicate bool, filename string, size int64, r io.Reader) (*api.Document, error) {
+	log := logger.FromContext(ctx)
+
+	name = strings.TrimSpace(name)
+	if err := s.names.Validate(name); err != nil {
+		return nil, err
+	}
+	prio, err := parseDocumentPriority(priority)
+	if err != nil {
+		return nil, err
+	}
+	log.Infof("Create document, name: %s, priority: %s, file: %s, size: %d, userID: %d", name, priority, filename, size, userID)
+
+	err = s.quota.CheckDocument(ctx, userID, size)
+	if err != nil {
+		log.Warnf("Quota check failed, userID: %d, err: %v", userID, err)
+		return nil, quotaError(err, "check quota failed")
+	}
+
+	ext, err := s.uploads.Check(filename, size)
+	if err != nil {
+		log.Warnf("File rejected, file: %s, size: %d, err: %v", filename, size, err)
+		return nil, err
+	}
+	// 在写入磁盘前检查内容类型
+	r, err = s.uploads.Sniff(ext, r)
+	if err != nil {
+		log.Warnf("File rejected, file: %s, err: %v", filename, err)
+		return nil, err
+	}
 
 	// 生成文档 ID
 	docID := db.MakeUUID()
+	trace.SpanFromContext(ctx).SetAttributes(attribute.String("document.id", docID))
 
-	// 保存临时文件用于分割
-	tempFilename := s.conf.Temp + "/" + docID + "_temp." + ext
-	err = c.SaveUploadedFile(file, tempFilename)
+	// 保存临时文件用于分割，文件名同时是百炼上的文件名
+	tempFilename := uploadTempFilename(s.conf.Temp, s.conf.DocumentConfig.BailianFilePrefix, docID, ext)
+	contentHash, err := saveTempFile(tempFilename, r)
 	if err != nil {
 		log.Errorf("Failed to save temp file, err: %v", err)
-		hutil.AbortError(c, hutil.ErrServerInternalCode, "save file failed")
-		return
+		return nil, hutil.NewApiError(hutil.ErrServerInternalCode, "save file failed")
 	}
 	defer os.Remove(tempFilename) // 临时文件使用后删除
 
+	// 扫描通过后才拆分章节和上传到百炼
+	if err = s.scanFile(ctx, tempFilename); err != nil {
+		return nil, err
+	}
+
+	// 内容相同的文档不再重复处理，名称不同也视为重复
+	existing, err := s.db.GetDocumentWithContentHash(ctx, userID, contentHash)
+	if err != nil {
+		if !errors.Is(err, gorm.ErrRecordNotFound) {
+			log.Errorf("Failed to get document, err: %v", err)
+			return nil, hutil.NewApiError(hutil.ErrServerInternalCode, "get document failed")
+		}
+	} else {
+		if !linkDuplicate {
+			log.Warnf("Document content duplicated, name: %s, existing: %s", name, existing.ID)
+			return nil, hutil.NewApiErrorWithData(ErrDuplicateDocumentCode, ErrDuplicateDocument, &api.DuplicateDocument{DocumentID: existing.ID, Name: existing.Name})
+		}
+		log.Infof("Document content duplicated, linked to existing document, name: %s, existing: %s", name, existing.ID)
+		ret := makeDocument(&existing)
+		return &ret, nil
+	}
+
+	name, err = s.resolveDocumentName(ctx, name, autoRename)
+	if err != nil {
+		return nil, err
+	}
+
 	// 分割章节
-	chunkOverlap := 100
-	texts, err := spliter.Split(ctx, tempFilename, spliter.Option{
-		ChunkSize:    5000,
-		ChunkOverlap: chunkOverlap,
-		Separator:    "\n\n",
+	splitStartedAt := time.Now()
+	texts, report, err := spliter.SplitWithReport(ctx, tempFilename, spliter.Option{
+		ChunkSize:    chapterChunkSize,
+		ChunkOverlap: chapterChunkOverlap,
+		Separators:   s.conf.Separators,
+		Clean:        s.cleaners.cleanFunc(userID),
+		Footnotes:    s.conf.PDFFootnotes,
+		Dedup:        s.conf.DedupChapters,
 	})
 	if err != nil {
 		log.Errorf("Failed to split text, err: %v", err)
-		hutil.AbortError(c, hutil.ErrServerInternalCode, "split text failed")
-		return
+		return nil, hutil.NewApiError(hutil.ErrServerInternalCode, "split text failed")
+	}
+	if len(report.Warnings) > 0 {
+		log.Warnf("Ingest report has warnings, doc: %s, warnings: %v", docID, report.Warnings)
 	}
 
 	err = s.db.CreateChapters(ctx, docID, texts)
 	if err != nil {
 		log.Errorf("Failed to create chapters, err: %v", err)
-		hutil.AbortError(c, hutil.ErrServerInternalCode, "create chapters failed")
-		return
+		return nil, hutil.NewApiError(hutil.ErrServerInternalCode, "create chapters failed")
 	}
+	s.markDuplicateChapters(ctx, docID, report.NearDuplicateChapters)
 
 	// 上传文件到百炼
 	log.Infof("Uploading file to Bailian, filename: %s", tempFilename)
-	fileID, err := s.bailianClient.UploadFile(ctx, tempFilename)
+	fileID, err := s.bailianClient.UploadFile(withRequestLog(ctx, s.db, docID, logStageUpload, ""), tempFilename)
 	if err != nil {
 		log.Errorf("Failed to upload file to Bailian, doc: %s, filename: %s, err: %v", docID, tempFilename, err)
-		hutil.AbortError(c, hutil.ErrServerInternalCode, "upload file to Bailian failed")
-		return
+		return nil, hutil.NewApiError(hutil.ErrServerInternalCode, "upload file to Bailian failed")
 	}
 
 	args := &api.CreateDocumentArgs{
-		Name: name,
+		Name:     name,
+		UserID:   userID,
+		FileSize: size,
+		Priority: prio,
+
+		IngestReport:   makeIngestReport(report),
+		SplitStartedAt: splitStartedAt,
+		ContentHash:    contentHash,
 	}
 	doc, err := s.db.CreateDocument(ctx, docID, fileID, args)
 	if err != nil {
 		log.Errorf("Failed to create document, err: %v", err)
-		documentErr(c, err, "create document failed")
-		return
+		s.deleteRemoteFile(context.WithoutCancel(ctx), fileID)
+		return nil, documentError(err, "create document failed")
 	}
 
-	hutil.WriteData(c, makeDocument(doc))
+	err = s.quota.RecordDocument(ctx, userID, size)
+	if err != nil {
+		log.Errorf("Failed to check quota threshold, userID: %d, err: %v", userID, err)
+	}
+
+	ret := makeDocument(doc)
+	return &ret, nil
 }
 
-func (s *Service) HandleGetDocument(c *gin.Context) {
-	ctx := c.Request.Context()
-	log := logger.FromGinContext(c)
-	//ui := GetUserInfo(c)
+// resolveDocumentName 文档名已存在时返回候选名称，autoRename 为 true 时返回第一个候选名称
+func (s *Service) resolveDocumentName(ctx context.Context, name string, autoRename bool) (string, error) {
+	log := logger.FromContext(ctx)
 
-	docID := c.Param("document_id")
-	if docID == "" {
-		hutil.AbortError(c, http.StatusBadRequest, "invalid doc id")
-		return
+	_, err := s.db.GetDocumentWithName(ctx, name)
+	if err != nil {
+		if !errors.Is(err, gorm.ErrRecordNotFound) {
+			log.Errorf("Failed to get document, err: %v", err)
+			return "", hutil.NewApiError(hutil.ErrServerInternalCode, "get document failed")
+		}
+		return name, nil
+	}
+	suggestions, err := s.suggestDocumentNames(ctx, name, maxNameSuggestions)
+	if err != nil {
+		log.Errorf("Failed to suggest document names, err: %v", err)
+		return "", hutil.NewApiError(hutil.ErrServerInternalCode, "get document failed")
+	}
+	if !autoRename || len(suggestions) == 0 {
+		log.Warnf("Document existing, name: %s, suggestions: %v", name, suggestions)
+		return "", hutil.NewApiErrorWithData(ErrExistingDocumentCode, ErrExistingDocument, &api.DocumentNameConflict{Name: name, Suggestions: suggestions})
 	}
+	log.Infof("Document existing, auto renamed, name: %s, renamed: %s", name, suggestions[0])
+	return suggestions[0], nil
+}
 
-	log.Infof("Get document, docID: %s", docID)
-	doc, err := s.db.GetDocument(ctx, docID)
+// saveTempFile 保存文件，返回内容的 sha256
+func saveTempFile(filename string, r io.Reader) (string, error) {
+	f, err := os.Create(filename)
 	if err != nil {
-		log.Errorf("get document failed, id: %s, err: %v", docID, err)
-		documentErr(c, err, "get document failed")
-		return
+		return "", err
+	}
+	h := sha256.New()
+	_, err = io.Copy(io.MultiWriter(f, h), r)
+	if err != nil {
+		f.Close()
+		os.Remove(filename)
+		return "", err
+	}
+	if err = f.Close(); err != nil {
+		return "", err
 	}
-	hutil.WriteData(c, makeDocument(&doc))
+	return hex.EncodeToString(h.Sum(nil)), nil
 }
 
-func (s *Service) HandleUpdateDocument(c *gin.Context) {
+func (s *Service) HandleGetDocument(c *gin.Context) {
 	ctx := c.Request.Context()
-	log := logger.FromGinContext(c)
 
-	docID := c.Param("document_id")
-	if docID == "" {
-		hutil.AbortError(c, http.StatusBadRequest, "invalid doc id")
-		return
+	var wait time.Duration
+	if w := c.Query("wait"); w != "" {
+		var err error
+		wait, err = time.ParseDuration(w)
+		if err != nil || wait < 0 {
+			hutil.AbortError(c, http.StatusBadRequest, "invalid wait")
+			return
+		}
 	}
-	var args api.UpdateDocumentArgs
-	if err := c.ShouldBindJSON(&args); err != nil {
-		log.Errorf("Invalid request body, err: %v", err)
-		hutil.AbortError(c, http.StatusBadRequest, "invalid request body")
+
+	row, err := s.getDocument(ctx, c.Param("document_id"), wait)
+	if err != nil {
+		hutil.AbortErr(c, err)
 		return
 	}
-
-	log.Infof("Update document, docID: %s", docID)
-	if err := s.db.UpdateDocument(ctx, docID, &args); err != nil {
-		log.Errorf("Failed update document failed, id: %s, err: %v", docID, err)
-		documentErr(c, err, "update document failed")
+	lang := c.Query("lang")
+	if lang == "" && notModified(c, documentsETag([]db.Document{row})) {
 		return
 	}
-	doc, err := s.db.GetDocument(ctx, docID)
+	doc := makeDocument(&row)
+	if lang != "" {
+		doc.Translation, err = s.GetTranslation(ctx, doc.ID, lang)
+		if err != nil {
+			hutil.AbortErr(c, err)
+			return
+		}
+	}
+	hutil.WriteData(c, doc)
+}
+
+// GetDocument 获取文档，wait 大于 0 时长轮询等待状态变化，最长 maxDocumentWait
+func (s *Service) GetDocument(ctx context.Context, docID string, wait time.Duration) (*api.Document, error) {
+	doc, err := s.getDocument(ctx, docID, wait)
 	if err != nil {
-		log.Errorf("get document failed, id: %s, err: %v", docID, err)
-		documentErr(c, err, "get document failed")
-		return
+		return nil, err
 	}
-	hutil.WriteData(c, makeDocument(&doc))
+	ret := makeDocument(&doc)
+	return &ret, nil
 }
 
-func (s *Service) HandleDeleteDocument(c *gin.Context) {
-	ctx := c.Request.Context()
-	log := logger.FromGinContext(c)
-	// ui := GetUserInfo(c)
+func (s *Service) getDocument(ctx context.Context, docID string, wait time.Duration) (db.Document, error) {
+	log := logger.FromContext(ctx)
 
-	docID := c.Param("document_id")
 	if docID == "" {
-		hutil.AbortError(c, http.StatusBadRequest, "invalid doc id")
-		return
+		return db.Document{}, hutil.NewApiError(http.StatusBadRequest, "invalid doc id")
 	}
+	wait = min(wait, maxDocumentWait)
 
-	log.Infof("Delete document, docID: %s", docID)
-	// 删除对应的 Chapter
-	err := s.db.DeleteAllChapter(ctx, docID)
+	log.Infof("Get document, docID: %s, wait: %v", docID, wait)
+	doc, err := s.db.GetDocument(ctx, docID)
 	if err != nil {
-		log.Errorf("Failed to delete document Chapter, err: %v", err)
-		hutil.AbortError(c, hutil.ErrServerInternalCode, "delete document Chapter failed")
+		log.Errorf("get document failed, id: %s, err: %v", docID, err)
+		return db.Document{}, documentError(err, "get document failed")
 	}
-	err = s.db.DeleteDocument(ctx, docID)
-	if err != nil {
-		log.Errorf("Failed to delete document, err: %v", err)
-		hutil.AbortError(c, hutil.ErrServerInternalCode, "delete document failed")
-		return
+	if wait > 0 {
+		doc, err = s.waitDocumentStatus(ctx, doc, wait)
+		if err != nil {
+			log.Errorf("wait document failed, id: %s, err: %v", docID, err)
+			return db.Document{}, documentError(err, "get document failed")
+		}
 	}
-	hutil.WriteData(c, nil)
+	return doc, nil
 }
 
-func (s *Service) HandleListDocuments(c *gin.Context) {
-	ctx := c.Request.Context()
-	log := logger.FromGinContext(c)
-	// ui := GetUserInfo(c)
-
-	log.Infof("List documents")
-	docs, err := s.db.ListDocuments(ctx)
-	if err != nil {
-		log.Errorf("Failed to list documents, err: %v", err)
-		hutil.AbortError(c, hutil.ErrServerInternalCode, "list documents failed")
-		return
-	}
-
-	ret := &api.ListDocumentsResult{}
-	for _, d := range docs {
-		ret.Documents = append(ret.Documents, makeDocument(&d))
+// waitDocumentStatus 长轮询：阻塞直到文档状态发生变化或等待超时，返回最新的文档
+func (s *Service) waitDocumentStatus(ctx context.Context, doc db.Document, wait time.Duration) (db.Document, error) {
+	ctx, cancel := context.WithTimeout(ctx, wait)
+	defer cancel()
+
+	ticker := time.NewTicker(documentWaitInterval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			// 超时或客户端断开，返回当前状态
+			return doc, nil
+		case <-ticker.C:
+			latest, err := s.db.GetDocument(ctx, doc.ID)
+			if err != nil {
+				if ctx.Err() != nil {
+					return doc, nil
+				}
+				return doc, err
+			}
+			if latest.Status != doc.Status {
+				return latest, nil
+			}
+		}
 	}
-	hutil.WriteData(c, ret)
 }
 
-func (s *Service) HandleGetChapter(c *gin.Context) {
+func (s *Service) HandleUpdateDocument(c *gin.Context) {
 	ctx := c.Request.Context()
 	log := logger.FromGinContext(c)
 
-	docID := c.Param("document_id")
-	id := c.Param("id")
-	if docID == "" {
-		hutil.AbortError(c, http.StatusBadRequest, "invalid doc id")
-		return
-	}
-	if id == "" {
-		hutil.AbortError(c, http.StatusBadRequest, "invalid id")
+	var args api.UpdateDocumentArgs
+	if err := c.ShouldBindJSON(&args); err != nil {
+		log.Errorf("Invalid request body, err: %v", err)
+		hutil.AbortError(c, http.StatusBadRequest, "invalid request body")
 		return
 	}
 
-	log.Infof("Get Chapter, docID: %s, id: %s", docID, id)
-	Chapter, err := s.db.GetChapter(ctx, id, docID)
+	doc, err := s.UpdateDocument(ctx, c.Param("document_id"), &args)
 	if err != nil {
-		log.Errorf("Failed to get Chapter, err: %v", err)
-		hutil.AbortError(c, http.StatusInternalServerError, "get Chapter failed")
+		hutil.AbortErr(c, err)
 		return
 	}
-
-	hutil.WriteData(c, makeChapter(&Chapter))
+	hutil.WriteData(c, doc)
 }
 
-func (s *Service) HandleUpdateChapter(c *gin.Context) {
-	ctx := c.Request.Context()
-	log := logger.FromGinContext(c)
+func (s *Service) UpdateDocument(ctx context.Context, docID string, args *api.UpdateDocumentArgs) (*api.Document, error) {
+	log := logger.FromContext(ctx)
 
-	docID := c.Param("document_id")
-	id := c.Param("id")
 	if docID == "" {
-		hutil.AbortError(c, http.StatusBadRequest, "invalid doc id")
-		return
-	}
-	if id == "" {
-		hutil.AbortError(c, http.StatusBadRequest, "invalid id")
-		return
+		return nil, hutil.NewApiError(http.StatusBadRequest, "invalid doc id")
 	}
 
-	var args api.UpdateChapterArgs
-	if err := c.ShouldBindJSON(&args); err != nil {
-		log.Errorf("Invalid request body, err: %v", err)
-		hutil.AbortError(c, http.StatusBadRequest, "invalid request body")
-		return
+	args.Name = strings.TrimSpace(args.Name)
+	if err := s.names.Validate(args.Name); err != nil {
+		return nil, err
+	}
+	if p := args.ImageProvider; p != nil && *p != "" && !s.images().Has(*p) {
+		return nil, hutil.NewApiError(http.StatusBadRequest, "unknown image provider, available: "+strings.Join(s.images().Names(), ","))
+	}
+	if r := args.AspectRatio; r != nil && *r != "" && !slices.Contains(imagegen.AspectRatios, *r) {
+		return nil, hutil.NewApiError(http.StatusBadRequest, "invalid aspect_ratio, available: "+strings.Join(imagegen.AspectRatios, ","))
+	}
+	if size := args.ImageSize; size != nil && *size != "" && !imageSizePattern.MatchString(*size) {
+		return nil, hutil.NewApiError(http.StatusBadRequest, "invalid image_size, expected <width>*<height>")
+	}
+	if style := args.ImageStyle; style != nil && style.Preset != "" {
+		if _, ok := s.styles.Get(style.Preset); !ok {
+			return nil, hutil.NewApiError(http.StatusBadRequest, "unknown style preset, available: "+strings.Join(s.styles.Names(), ","))
+		}
+	}
+	if p := args.VoiceProsody; p != nil {
+		if err := s.checkProsody(p.Speed, p.Pitch, p.Emotion); err != nil {
+			return nil, err
+		}
+	}
+	if args.BGMID != nil {
+		if err := s.checkBGM(ctx, *args.BGMID); err != nil {
+			return nil, err
+		}
 	}
 
-	log.Infof("Update Chapter, docID: %s, id: %s", docID, id)
-	err := s.db.UpdateChapter(ctx, id, &args)
+	// 改名时同样按归一化后的名称判断重名，只改大小写或全半角时与自身匹配，不算重名
+	existing, err := s.db.GetDocumentWithName(ctx, args.Name)
 	if err != nil {
-		log.Errorf("Failed to update db Chapter, err: %v", err)
-		hutil.AbortError(c, http.StatusInternalServerError, "update Chapter failed")
-		return
+		if !errors.Is(err, gorm.ErrRecordNotFound) {
+			log.Errorf("Failed to get document, err: %v", err)
+			return nil, hutil.NewApiError(hutil.ErrServerInternalCode, "get document failed")
+		}
+	} else if existing.ID != docID {
+		suggestions, err := s.suggestDocumentNames(ctx, args.Name, maxNameSuggestions)
+		if err != nil {
+			log.Errorf("Failed to suggest document names, err: %v", err)
+			return nil, hutil.NewApiError(hutil.ErrServerInternalCode, "get document failed")
+		}
+		log.Warnf("Document existing, name: %s, suggestions: %v", args.Name, suggestions)
+		return nil, hutil.NewApiErrorWithData(ErrExistingDocumentCode, ErrExistingDocument, &api.DocumentNameConflict{Name: args.Name, Suggestions: suggestions})
+	}
+
+	log.Infof("Update document, docID: %s", docID)
+	if err := s.db.UpdateDocument(ctx, docID, args); err != nil {
+		log.Errorf("Failed update document failed, id: %s, err: %v", docID, err)
+		return nil, documentError(err, "update document failed")
 	}
-	Chapter, err := s.db.GetChapter(ctx, id, docID)
+	doc, err := s.db.GetDocument(ctx, docID)
 	if err != nil {
-		log.Errorf("Failed to get Chapter, err: %v", err)
-		hutil.AbortError(c, http.StatusInternalServerError, "get Chapter failed")
-		return
+		log.Errorf("get document failed, id: %s, err: %v", docID, err)
+		return nil, documentError(err, "get document failed")
 	}
+	ret := makeDocument(&doc)
+	return &ret, nil
+}
 
-	hutil.WriteData(c, makeChapter(&Chapter))
+// HandleDocumentAction 处理 /documents/:document_id:<action> 形式的单个文档操作，action 为 :cancel、:retry、:translate 或 :summarize
+func (s *Service) HandleDocumentAction(c *gin.Context) {
+	id, action, _ := strings.Cut(c.Param("document_id"), ":")
+	switch action {
+	case "cancel":
+		doc, err := s.CancelDocument(c.Request.Context(), GetUserInfo(c).ID, id)
+		if err != nil {
+			hutil.AbortErr(c, err)
+			return
+		}
+		hutil.WriteData(c, doc)
+	case "retry":
+		s.HandleRetryDocument(c, id)
+	case "translate":
+		s.HandleTranslateDocument(c, id)
+	case "summarize":
+		// 生成梗概需要多次同步调用大模型，按租户限制并发
+		s.limiter.Run(c, GetUserInfo(c).ID, func(ctx context.Context) (any, error) {
+			return s.SummarizeDocument(ctx, id)
+		})
+	default:
+		hutil.AbortError(c, http.StatusNotFound, "not found")
+	}
 }
 
-func (s *Service) HandleDeleteChapter(c *gin.Context) {
-	ctx := c.Request.Context()
-	log := logger.FromGinContext(c)
+// CancelDocument 取消文档处理。文档标记为 canceled 后不再处理，本实例正在处理时立即取消，进行中的百炼调用随之中断，
+// 其他实例在下次续约时取消；已生成的场景保留。已处理结束的文档不能取消
+func (s *Service) CancelDocument(ctx context.Context, userID int64, docID string) (*api.Document, error) {
+	log := logger.FromContext(ctx)
 
-	docID := c.Param("document_id")
-	id := c.Param("id")
-	if docID == "" {
-		hutil.AbortError(c, http.StatusBadRequest, "invalid doc id")
-		return
+	log.Infof("Cancel document, docID: %s", docID)
+	err := s.db.CancelDocument(ctx, docID)
+	if err != nil {
+		log.Errorf("Failed to cancel document, id: %s, err: %v", docID, err)
+		return nil, documentError(err, "cancel document failed")
 	}
-	if id == "" {
-		hutil.AbortError(c, http.StatusBadRequest, "invalid id")
-		return
+	if s.documentMgr != nil && s.documentMgr.Cancel(docID) {
+		log.Infof("Canceled running document processing, docID: %s", docID)
 	}
 
-	log.Infof("Delete Chapter, docID: %s, id: %s", docID, id)
-	err := s.db.DeleteChapter(ctx, id, docID)
+	doc, err := s.db.GetDocument(ctx, docID)
 	if err != nil {
-		log.Errorf("Failed to delete db Chapter, err: %v", err)
-		hutil.AbortError(c, http.StatusInternalServerError, "delete Chapter failed")
+		log.Errorf("Failed to get document, id: %s, err: %v", docID, err)
+		return nil, documentError(err, "get document failed")
+	}
+	recordEvent(ctx, s.db, db.Event{
+		UserID:     userID,
+		Type:       db.EventDocumentCanceled,
+		DocumentID: docID,
+		Message:    fmt.Sprintf("document %s canceled", doc.Name),
+	})
+	publishProgress(ctx, s.pubsub, s.db, docID, ProgressDocumentCanceled)
+
+	ret := makeDocument(&doc)
+	return &ret, nil
+}
+
+func (s *Service) HandlePauseDocument(c *gin.Context) {
+	doc, err := s.PauseDocument(c.Request.Context(), GetUserInfo(c).ID, c.Param("document_id"), true)
+	if err != nil {
+		hutil.AbortErr(c, err)
 		return
 	}
-
-	hutil.WriteData(c, nil)
+	hutil.WriteData(c, doc)
 }
 
-func (s *Service) HandleListChapters(c *gin.Context) {
+func (s *Service) HandleResumeDocument(c *gin.Context) {
+	doc, err := s.PauseDocument(c.Request.Context(), GetUserInfo(c).ID, c.Param("document_id"), false)
+	if err != nil {
+		hutil.AbortErr(c, err)
+		return
+	}
+	hutil.WriteData(c, doc)
+}
+
+// PauseDocument 暂停或恢复文档处理。暂停后 DocumentMgr 不再处理该文档，正在生成的文档在当前场景完成后中断，
+// 已生成的场景保留，恢复后从剩余场景继续；重复暂停或恢复不报错
+func (s *Service) PauseDocument(ctx context.Context, userID int64, docID string, paused bool) (*api.Document, error) {
+	log := logger.FromContext(ctx)
+
+	log.Infof("Pause document, docID: %s, paused: %v", docID, paused)
+	doc, err := s.db.GetDocument(ctx, docID)
+	if err != nil {
+		log.Errorf("Failed to get document, id: %s, err: %v", docID, err)
+		return nil, documentError(err, "get document failed")
+	}
+	if doc.Paused != paused {
+		if paused && documentFinished(doc.Status) {
+			return nil, hutil.NewApiError(http.StatusBadRequest, "document processing has finished")
+		}
+		err = s.db.UpdateDocumentPaused(ctx, docID, paused)
+		if err != nil {
+			log.Errorf("Failed to update document paused, id: %s, err: %v", docID, err)
+			return nil, documentError(err, "update document failed")
+		}
+
+		eventType, progress, action := db.EventDocumentResumed, ProgressDocumentResumed, "resumed"
+		if paused {
+			eventType, progress, action = db.EventDocumentPaused, ProgressDocumentPaused, "paused"
+		}
+		recordEvent(ctx, s.db, db.Event{
+			UserID:     userID,
+			Type:       eventType,
+			DocumentID: docID,
+			Message:    fmt.Sprintf("document %s %s", doc.Name, action),
+		})
+		publishProgress(ctx, s.pubsub, s.db, docID, progress)
+
+		doc, err = s.db.GetDocument(ctx, docID)
+		if err != nil {
+			log.Errorf("Failed to get document, id: %s, err: %v", docID, err)
+			return nil, documentError(err, "get document failed")
+		}
+	}
+	ret := makeDocument(&doc)
+	return &ret, nil
+}
+
+func (s *Service) HandleDeleteDocument(c *gin.Context) {
+	err := s.DeleteDocument(c.Request.Context(), c.Param("document_id"))
+	if err != nil {
+		hutil.AbortErr(c, err)
+		return
+	}
+	hutil.WriteData(c, nil)
+}
+
+func (s *Service) DeleteDocument(ctx context.Context, docID string) error {
+	log := logger.FromContext(ctx)
+
+	if docID == "" {
+		return hutil.NewApiError(http.StatusBadRequest, "invalid doc id")
+	}
+
+	log.Infof("Delete document, docID: %s", docID)
+	doc, err := s.db.GetDocument(ctx, docID)
+	if err != nil && !errors.Is(err, gorm.ErrRecordNotFound) {
+		log.Errorf("Failed to get document, err: %v", err)
+		return hutil.NewApiError(hutil.ErrServerInternalCode, "get document failed")
+	}
+	exports, err := s.db.ListExports(ctx, docID)
+	if err != nil {
+		log.Errorf("Failed to list exports, err: %v", err)
+		return hutil.NewApiError(hutil.ErrServerInternalCode, "list exports failed")
+	}
+	if s.exports != nil {
+		// 先停止执行中的导出，执行中途删除的任务在完成时丢弃产物
+		for _, e := range exports {
+			if err = s.exports.Cancel(ctx, e.ID); err != nil && !errors.Is(err, gorm.ErrRecordNotFound) {
+				log.Warnf("Failed to cancel export, export: %s, err: %v", e.ID, err)
+			}
+		}
+	}
+	// 文档和关联数据在同一事务中删除，失败时整体回滚；存储中的文件和导出产物在提交后清理
+	mediaURLs, err := s.db.DeleteDocument(ctx, docID)
+	if err != nil {
+		log.Errorf("Failed to delete document, err: %v", err)
+		return hutil.NewApiError(hutil.ErrServerInternalCode, "delete document failed")
+	}
+	s.deleteRemoteFile(ctx, doc.FileID)
+	s.deleteUnreferencedMedia(ctx, mediaURLs)
+	if s.exports != nil {
+		for _, e := range exports {
+			s.exports.removeArtifact(ctx, e.Path)
+		}
+	}
+	return nil
+}
+
+func (s *Service) HandleListDocuments(c *gin.Context) {
+	filter, err := parseDocumentFilter(c)
+	if err != nil {
+		hutil.AbortErr(c, err)
+		return
+	}
+	docs, err := s.listDocuments(c.Request.Context(), filter)
+	if err != nil {
+		hutil.AbortErr(c, err)
+		return
+	}
+	if notModified(c, documentsETag(docs)) {
+		return
+	}
+	hutil.WriteData(c, makeDocuments(docs))
+}
+
+// parseUpdatedSince 解析 updated_since 查询参数，支持 RFC3339 和响应中 updated_at 的格式（本地时间），未传时返回零值
+func parseUpdatedSince(c *gin.Context) (time.Time, error) {
+	v := c.Query("updated_since")
+	if v == "" {
+		return time.Time{}, nil
+	}
+	if t, err := time.Parse(time.RFC3339, v); err == nil {
+		return t, nil
+	}
+	t, err := time.ParseInLocation(time.DateTime, v, time.Local)
+	if err != nil {
+		return time.Time{}, hutil.NewApiError(http.StatusBadRequest, "invalid updated_since")
+	}
+	return t, nil
+}
+
+// documentStatusProcessing 查询参数 status 中表示全部处理中（尚未结束）状态的别名
+const documentStatusProcessing = "processing"
+
+// documentProcessingStatuses 处理中的文档状态
+var documentProcessingStatuses = []string{db.DocumentStatusChapterReady, db.DocumentStatusRoleReady, db.DocumentStatusSceneReady}
+
+// parseDocumentFilter 解析文档列表的查询参数：updated_since；status 为逗号分隔的状态，processing 表示全部处理中的状态；
+// sort_by 为 created_at|updated_at|name，默认 updated_at；order 为 asc|desc，默认 desc
+func parseDocumentFilter(c *gin.Context) (db.DocumentFilter, error) {
+	updatedSince, err := parseUpdatedSince(c)
+	if err != nil {
+		return db.DocumentFilter{}, err
+	}
+	filter := db.DocumentFilter{UpdatedSince: updatedSince, SortBy: db.DocumentSortUpdatedAt}
+	if v := c.Query("status"); v != "" {
+		for _, status := range strings.Split(v, ",") {
+			status = strings.TrimSpace(status)
+			switch {
+			case status == documentStatusProcessing:
+				filter.Statuses = append(filter.Statuses, documentProcessingStatuses...)
+			case slices.Contains(documentProcessingStatuses, status) || slices.Contains(db.DocumentFinishedStatuses, status):
+				filter.Statuses = append(filter.Statuses, status)
+			default:
+				return db.DocumentFilter{}, hutil.NewApiError(http.StatusBadRequest, "invalid status")
+			}
+		}
+		slices.Sort(filter.Statuses)
+		filter.Statuses = slices.Compact(filter.Statuses)
+	}
+	if v := c.Query("sort_by"); v != "" {
+		if !slices.Contains(db.DocumentSortFields, v) {
+			return db.DocumentFilter{}, hutil.NewApiError(http.StatusBadRequest, "invalid sort_by")
+		}
+		filter.SortBy = v
+	}
+	switch c.Query("order") {
+	case "", "desc":
+	case "asc":
+		filter.Asc = true
+	default:
+		return db.DocumentFilter{}, hutil.NewApiError(http.StatusBadRequest, "invalid order")
+	}
+	return filter, nil
+}
+
+// ListDocuments 按 filter 过滤并排序列取文档，过滤和排序在数据库中完成
+func (s *Service) ListDocuments(ctx context.Context, filter db.DocumentFilter) (*api.ListDocumentsResult, error) {
+	docs, err := s.listDocuments(ctx, filter)
+	if err != nil {
+		return nil, err
+	}
+	return makeDocuments(docs), nil
+}
+
+func (s *Service) listDocuments(ctx context.Context, filter db.DocumentFilter) ([]db.Document, error) {
+	log := logger.FromContext(ctx)
+
+	log.Infof("List documents, filter: %+v", filter)
+	docs, err := s.db.ListDocumentsByFilter(ctx, filter)
+	if err != nil {
+		log.Errorf("Failed to list documents, err: %v", err)
+		return nil, hutil.NewApiError(hutil.ErrServerInternalCode, "list documents failed")
+	}
+	return docs, nil
+}
+
+func makeDocuments(docs []db.Document) *api.ListDocumentsResult {
+	ret := &api.ListDocumentsResult{}
+	for _, d := range docs {
+		ret.Documents = append(ret.Documents, makeDocument(&d))
+	}
+	return ret
+}
+
+func (s *Service) HandleGetChapter(c *gin.Context) {
+	row, err := s.getChapter(c.Request.Context(), c.Param("document_id"), c.Param("id"))
+	if err != nil {
+		hutil.AbortErr(c, err)
+		return
+	}
+	lang := c.Query("lang")
+	if lang == "" && notModified(c, chaptersETag([]db.Chapter{row})) {
+		return
+	}
+	chapter := makeChapter(&row)
+	if lang != "" {
+		chapters := []api.Chapter{chapter}
+		err = s.applyTranslations(c.Request.Context(), chapter.DocumentID, lang, chapters)
+		if err != nil {
+			hutil.AbortErr(c, err)
+			return
+		}
+		chapter = chapters[0]
+	}
+	hutil.WriteData(c, chapter)
+}
+
+func checkChapterParams(docID, id string) error {
+	if docID == "" {
+		return hutil.NewApiError(http.StatusBadRequest, "invalid doc id")
+	}
+	if id == "" {
+		return hutil.NewApiError(http.StatusBadRequest, "invalid id")
+	}
+	return nil
+}
+
+func (s *Service) GetChapter(ctx context.Context, docID, id string) (*api.Chapter, error) {
+	chapter, err := s.getChapter(ctx, docID, id)
+	if err != nil {
+		return nil, err
+	}
+	ret := makeChapter(&chapter)
+	return &ret, nil
+}
+
+func (s *Service) getChapter(ctx context.Context, docID, id string) (db.Chapter, error) {
+	log := logger.FromContext(ctx)
+
+	if err := checkChapterParams(docID, id); err != nil {
+		return db.Chapter{}, err
+	}
+
+	log.Infof("Get Chapter, docID: %s, id: %s", docID, id)
+	chapter, err := s.db.GetChapter(ctx, id, docID)
+	if err != nil {
+		log.Errorf("Failed to get Chapter, err: %v", err)
+		return db.Chapter{}, hutil.NewApiError(http.StatusInternalServerError, "get Chapter failed")
+	}
+	return chapter, nil
+}
+
+func (s *Service) HandleUpdateChapter(c *gin.Context) {
 	ctx := c.Request.Context()
 	log := logger.FromGinContext(c)
 
 	docID := c.Param("document_id")
-	if docID == "" {
-		hutil.AbortError(c, http.StatusBadRequest, "invalid doc id")
+	id := c.Param("id")
+	if err := checkChapterParams(docID, id); err != nil {
+		hutil.AbortErr(c, err)
 		return
 	}
 
+	var args api.UpdateChapterArgs
+	if err := c.ShouldBindJSON(&args); err != nil {
+		log.Errorf("Invalid request body, err: %v", err)
+		hutil.AbortError(c, http.StatusBadRequest, "invalid request body")
+		return
+	}
+
+	chapter, err := s.UpdateChapter(ctx, docID, id, &args)
+	if err != nil {
+		hutil.AbortErr(c, err)
+		return
+	}
+	hutil.WriteData(c, chapter)
+}
+
+func (s *Service) UpdateChapter(ctx context.Context, docID, id string, args *api.UpdateChapterArgs) (*api.Chapter, error) {
+	log := logger.FromContext(ctx)
+
+	if err := checkChapterParams(docID, id); err != nil {
+		return nil, err
+	}
+
+	log.Infof("Update Chapter, docID: %s, id: %s", docID, id)
+	err := s.db.UpdateChapter(ctx, id, args)
+	if err != nil {
+		log.Errorf("Failed to update db Chapter, err: %v", err)
+		return nil, hutil.NewApiError(http.StatusInternalServerError, "update Chapter failed")
+	}
+	chapter, err := s.db.GetChapter(ctx, id, docID)
+	if err != nil {
+		log.Errorf("Failed to get Chapter, err: %v", err)
+		return nil, hutil.NewApiError(http.StatusInternalServerError, "get Chapter failed")
+	}
+
+	ret := makeChapter(&chapter)
+	return &ret, nil
+}
+
+func (s *Service) HandleDeleteChapter(c *gin.Context) {
+	err := s.DeleteChapter(c.Request.Context(), c.Param("document_id"), c.Param("id"))
+	if err != nil {
+		hutil.AbortErr(c, err)
+		return
+	}
+	hutil.WriteData(c, nil)
+}
+
+func (s *Service) DeleteChapter(ctx context.Context, docID, id string) error {
+	log := logger.FromContext(ctx)
+
+	if err := checkChapterParams(docID, id); err != nil {
+		return err
+	}
+
+	log.Infof("Delete Chapter, docID: %s, id: %s", docID, id)
+	err := s.db.DeleteChapter(ctx, id, docID)
+	if err != nil {
+		log.Errorf("Failed to delete db Chapter, err: %v", err)
+		return hutil.NewApiError(http.StatusInternalServerError, "delete Chapter failed")
+	}
+	return nil
+}
+
+// HandleChaptersAction 处理 /documents/:document_id/chapters:<action> 形式的章节集合操作，目前只有 :reorder
+func (s *Service) HandleChaptersAction(c *gin.Context) {
+	switch c.Param("action") {
+	case ":reorder":
+		s.HandleReorderChapters(c)
+	default:
+		hutil.AbortError(c, http.StatusNotFound, "not found")
+	}
+}
+
+func (s *Service) HandleReorderChapters(c *gin.Context) {
+	log := logger.FromGinContext(c)
+
+	var args api.ReorderChaptersArgs
+	if err := c.ShouldBindJSON(&args); err != nil {
+		log.Errorf("Invalid request body, err: %v", err)
+		hutil.AbortError(c, http.StatusBadRequest, "invalid request body")
+		return
+	}
+	result, err := s.ReorderChapters(c.Request.Context(), c.Param("document_id"), args.ChapterIDs)
+	if err != nil {
+		hutil.AbortErr(c, err)
+		return
+	}
+	hutil.WriteData(c, result)
+}
+
+// ReorderChapters 按 chapterIDs 的顺序重排文档的全部章节，场景序号随章节顺序重新编号。
+// 正在拆分场景的文档不允许重排，避免新生成的场景与重排后的序号冲突
+func (s *Service) ReorderChapters(ctx context.Context, docID string, chapterIDs []string) (*api.ListChaptersResult, error) {
+	log := logger.FromContext(ctx)
+
+	doc, err := s.db.GetDocument(ctx, docID)
+	if err != nil {
+		log.Errorf("Failed to get document, id: %s, err: %v", docID, err)
+		return nil, documentError(err, "get document failed")
+	}
+	if doc.Status == db.DocumentStatusRoleReady {
+		return nil, hutil.NewApiError(http.StatusConflict, "chapters cannot be reordered while scenes are being generated")
+	}
+	seen := make(map[string]bool, len(chapterIDs))
+	for _, id := range chapterIDs {
+		if seen[id] {
+			return nil, hutil.NewApiError(http.StatusBadRequest, "duplicate chapter id: "+id)
+		}
+		seen[id] = true
+	}
+
+	log.Infof("Reorder chapters, docID: %s, chapters: %d", docID, len(chapterIDs))
+	err = s.db.ReorderChapters(ctx, docID, chapterIDs)
+	if err != nil {
+		log.Errorf("Failed to reorder chapters, docID: %s, err: %v", docID, err)
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			return nil, hutil.NewApiError(http.StatusBadRequest, "chapter_ids must list every chapter of the document exactly once")
+		}
+		return nil, hutil.NewApiError(hutil.ErrServerInternalCode, "reorder chapters failed")
+	}
+	return s.ListChapters(ctx, docID, time.Time{})
+}
+
+// HandleChapterAction 处理 /documents/:document_id/chapters/:id:<action> 形式的单个章节操作，目前只有 :split
+func (s *Service) HandleChapterAction(c *gin.Context) {
+	id, action, _ := strings.Cut(c.Param("id"), ":")
+	switch action {
+	case "split":
+		s.HandleSplitChapter(c, id)
+	case "setCover":
+		s.HandleSetChapterCover(c, id)
+	case "summarize":
+		s.HandleSummarizeChapter(c, id)
+	default:
+		hutil.AbortError(c, http.StatusNotFound, "not found")
+	}
+}
+
+func (s *Service) HandleSplitChapter(c *gin.Context, id string) {
+	log := logger.FromGinContext(c)
+
+	var args api.SplitChapterArgs
+	if err := c.ShouldBindJSON(&args); err != nil {
+		log.Errorf("Invalid request body, err: %v", err)
+		hutil.AbortError(c, http.StatusBadRequest, "invalid request body")
+		return
+	}
+	result, err := s.SplitChapter(c.Request.Context(), c.Param("document_id"), id, &args)
+	if err != nil {
+		hutil.AbortErr(c, err)
+		return
+	}
+	hutil.WriteData(c, result)
+}
+
+// SplitChapter 将章节拆成两章，后半部分作为紧随其后的新章节，章节的场景按在原文中的位置分配到两章。
+// 与重排相同，正在拆分场景的文档不允许拆分章节
+func (s *Service) SplitChapter(ctx context.Context, docID, id string, args *api.SplitChapterArgs) (*api.ListChaptersResult, error) {
+	log := logger.FromContext(ctx)
+
+	if err := checkChapterParams(docID, id); err != nil {
+		return nil, err
+	}
+	if (args.Offset == nil) == (args.Marker == "") {
+		return nil, hutil.NewApiError(http.StatusBadRequest, "exactly one of offset and marker is required")
+	}
+
+	doc, err := s.db.GetDocument(ctx, docID)
+	if err != nil {
+		log.Errorf("Failed to get document, id: %s, err: %v", docID, err)
+		return nil, documentError(err, "get document failed")
+	}
+	if doc.Status == db.DocumentStatusRoleReady {
+		return nil, hutil.NewApiError(http.StatusConflict, "chapters cannot be split while scenes are being generated")
+	}
+	chapter, err := s.db.GetChapter(ctx, id, docID)
+	if err != nil {
+		log.Errorf("Failed to get chapter, id: %s, err: %v", id, err)
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			return nil, hutil.NewApiError(http.StatusNotFound, "chapter not found")
+		}
+		return nil, hutil.NewApiError(hutil.ErrServerInternalCode, "get chapter failed")
+	}
+
+	var offset int
+	if args.Marker != "" {
+		i := strings.Index(chapter.Content, args.Marker)
+		if i < 0 {
+			return nil, hutil.NewApiError(http.StatusBadRequest, "marker not found in chapter")
+		}
+		offset = utf8.RuneCountInString(chapter.Content[:i])
+	} else {
+		offset = *args.Offset
+	}
+	runes := []rune(chapter.Content)
+	if offset <= 0 || offset >= len(runes) {
+		return nil, hutil.NewApiError(http.StatusBadRequest, "split position must be inside the chapter")
+	}
+
+	scenes, err := s.db.ListScenesByChapter(ctx, id)
+	if err != nil {
+		log.Errorf("Failed to list scenes, chapter: %s, err: %v", id, err)
+		return nil, hutil.NewApiError(hutil.ErrServerInternalCode, "list scenes failed")
+	}
+	keep := scenesBeforeOffset(chapter.Content, scenes, offset)
+
+	log.Infof("Split chapter, docID: %s, id: %s, offset: %d, keepScenes: %d/%d", docID, id, offset, keep, len(scenes))
+	_, err = s.db.SplitChapter(ctx, docID, id, string(runes[:offset]), string(runes[offset:]), keep)
+	if err != nil {
+		log.Errorf("Failed to split chapter, id: %s, err: %v", id, err)
+		return nil, hutil.NewApiError(hutil.ErrServerInternalCode, "split chapter failed")
+	}
+	return s.ListChapters(ctx, docID, time.Time{})
+}
+
+// scenesBeforeOffset 返回位于拆分位置之前的场景数。场景描述能在原文中找到时按找到的位置，
+// 否则假设场景在章节中均匀分布；场景保持原有顺序，第一个位于拆分位置之后的场景及其后的场景都移到新章节
+func scenesBeforeOffset(content string, scenes []db.Scene, offset int) int {
+	total := utf8.RuneCountInString(content)
+	for i, scene := range scenes {
+		pos := total * (2*i + 1) / (2 * len(scenes))
+		if text := strings.TrimSpace(scene.Content); text != "" {
+			if j := strings.Index(content, text); j >= 0 {
+				pos = utf8.RuneCountInString(content[:j])
+			}
+		}
+		if pos >= offset {
+			return i
+		}
+	}
+	return len(scenes)
+}
+
+func (s *Service) HandleListChapters(c *gin.Context) {
+	updatedSince, err := parseUpdatedSince(c)
+	if err != nil {
+		hutil.AbortErr(c, err)
+		return
+	}
+	chapters, err := s.listChapters(c.Request.Context(), c.Param("document_id"), updatedSince)
+	if err != nil {
+		hutil.AbortErr(c, err)
+		return
+	}
+	lang := c.Query("lang")
+	if lang == "" && notModified(c, chaptersETag(chapters)) {
+		return
+	}
+	result := makeChapters(chapters)
+	if lang != "" {
+		err = s.applyTranslations(c.Request.Context(), c.Param("document_id"), lang, result.Chapters)
+		if err != nil {
+			hutil.AbortErr(c, err)
+			return
+		}
+	}
+	hutil.WriteData(c, result)
+}
+
+// ListChapters 列取文档的章节，updatedSince 非零时只列取该时间及之后更新过的章节
+func (s *Service) ListChapters(ctx context.Context, docID string, updatedSince time.Time) (*api.ListChaptersResult, error) {
+	chapters, err := s.listChapters(ctx, docID, updatedSince)
+	if err != nil {
+		return nil, err
+	}
+	return makeChapters(chapters), nil
+}
+
+func (s *Service) listChapters(ctx context.Context, docID string, updatedSince time.Time) ([]db.Chapter, error) {
+	log := logger.FromContext(ctx)
+
+	if docID == "" {
+		return nil, hutil.NewApiError(http.StatusBadRequest, "invalid doc id")
+	}
+
 	// todo： 后续需要考虑分页
-	log.Infof("List chapters, docID: %s", docID)
-	chapters, err := s.db.ListChapters(ctx, docID)
+	var (
+		chapters []db.Chapter
+		err      error
+	)
+	if updatedSince.IsZero() {
+		log.Infof("List chapters, docID: %s", docID)
+		chapters, err = s.db.ListChapters(ctx, docID)
+	} else {
+		log.Infof("List chapters, docID: %s, updatedSince: %s", docID, updatedSince.Format(time.RFC3339))
+		chapters, err = s.db.ListChaptersUpdatedSince(ctx, docID, updatedSince)
+	}
 	if err != nil {
 		log.Errorf("list chapters failed, err: %v", err)
-		hutil.AbortError(c, http.StatusBadRequest, "list chapters failed")
+		return nil, hutil.NewApiError(http.StatusBadRequest, "list chapters failed")
+	}
+	return chapters, nil
+}
+
+func makeChapters(chapters []db.Chapter) *api.ListChaptersResult {
+	result := &api.ListChaptersResult{}
+	for _, seg := range chapters {
+		result.Chapters = append(result.Chapters, makeChapter(&seg))
+	}
+	return result
+}
+
+func makeDocument(d *db.Document) api.Document {
+	ret := api.Document{
+		ID:               d.ID,
+		Name:             d.Name,
+		FileID:           d.FileID,
+		SummaryImageURL:  d.SummaryImageURL,
+		Status:           d.Status,
+		SceneCount:       d.SceneCount,
+		FailedSceneCount: d.FailedSceneCount,
+		Priority:         documentPriorityName(d.Priority),
+		Paused:           d.Paused,
+		RoleConsistency:  d.RoleConsistency,
+		ImageProvider:    d.ImageProvider,
+		ImageStyle:       d.ImageStyle,
+		AspectRatio:      d.AspectRatio,
+		ImageSize:        d.ImageSize,
+		Attempts:         d.Attempts,
+		LastError:        d.LastError,
+		FailedStage:      d.FailedStage,
+		AutoRegenerate:   d.AutoRegenerate,
+		VoiceProsody:     d.VoiceProsody,
+		BGMID:            d.BGMID,
+		Synopsis:         d.Synopsis,
+		PlotPoints:       d.PlotPoints,
+		CreatedAt:        d.CreatedAt.Format(time.DateTime),
+		UpdatedAt:        d.UpdatedAt.Format(time.DateTime),
+	}
+	ret.IngestWarnings = len(d.IngestReport.Warnings)
+	if d.NextAttemptAt != nil && d.Status != db.DocumentStatusFailed {
+		ret.NextAttemptAt = d.NextAttemptAt.Format(time.DateTime)
+	}
+	return ret
+}
+
+func makeChapter(d *db.Chapter) api.Chapter {
+	return api.Chapter{
+		ID:           d.ID,
+		DocumentID:   d.DocumentID,
+		Index:        d.Index,
+		Title:        d.Title,
+		Content:      d.Content,
+		SceneIDs:     d.SceneIDs,
+		CoverSceneID: d.CoverSceneID,
+		CoverURL:     d.CoverURL,
+		DuplicateOf:  d.DuplicateOf,
+		Summary:      d.Summary,
+		CreatedAt:    d.CreatedAt.Format(time.DateTime),
+		UpdatedAt:    d.UpdatedAt.Format(time.DateTime),
+	}
+}
+
+func documentErr(c *gin.Context, err error, errMsg string) {
+	hutil.AbortErr(c, documentError(err, errMsg))
+}
+
+// documentError 将数据库错误转换为文档相关的业务错误
+func documentError(err error, errMsg string) error {
+	var mysqlErr *mysql.MySQLError
+	if errors.As(err, &mysqlErr) && mysqlErr.Number == 1062 {
+		return hutil.NewApiError(ErrExistingDocumentCode, ErrExistingDocument)
+	}
+	// sqlite for test
+	if sqliteErr, ok := err.(sqlite3.Error); ok {
+		if sqliteErr.Code == 19 && sqliteErr.ExtendedCode == 2067 {
+			return hutil.NewApiError(ErrExistingDocumentCode, ErrExistingDocument)
+		}
+	}
+	if errors.Is(err, gorm.ErrRecordNotFound) {
+		return hutil.NewApiError(ErrNoSuchDocumentCode, ErrNoSuchDocument)
+	}
+	if errors.Is(err, db.ErrDocumentCanceled) {
+		return hutil.NewApiError(http.StatusConflict, "document has been canceled")
+	}
+	if errors.Is(err, db.ErrDocumentFinished) {
+		return hutil.NewApiError(http.StatusBadRequest, "document processing has finished")
+	}
+	return hutil.NewApiError(hutil.ErrServerInternalCode, errMsg)
+}
+
+// documentContentTypes 下载原文时允许的 Content-Type，对应 spliter 支持的 txt、md、doc、docx、pdf
+var documentContentTypes = []string{
+	"text/plain",
+	"text/markdown",
+	"application/msword",
+	"application/vnd.openxmlformats-officedocument.wordprocessingml.document",
+	"application/pdf",
+	"application/octet-stream",
+}
+
+func (s *Service) downloadFile(ctx context.Context, textURL string) (string, error) {
+	log := logger.FromContext(ctx)
+
+	url, err := url.ParseRequestURI(textURL)
+	if err != nil {
+		return "", err
+	}
+	index := strings.LastIndex(url.Path, ".")
+	if index == -1 {
+		return "", errors.New("unknown ext")
+	}
+	ext := url.Path[index+1:]
+	id := uuid.New()
+	uid := hex.EncodeToString(id[:])
+	filename := s.conf.Temp + "/" + uid + "." + ext
+	file, err := os.Create(filename)
+	if err != nil {
+		return "", err
+	}
+	defer file.Close()
+
+	n, err := s.httpClient.Download(ctx, textURL, file, documentContentTypes...)
+	if err != nil {
+		log.Warnf("Failed to download %s, err: %v", textURL, err)
+		os.Remove(filename)
+		return "", err
+	}
+
+	log.Infof("Download url %s, filename: %s, n: %d", textURL, filename, n)
+	return filename, nil
+}
+
+// HandleGetRoles 获取文档的角色列表
+func (s *Service) HandleGetRoles(c *gin.Context) {
+	result, err := s.ListRoles(c.Request.Context(), c.Param("document_id"))
+	if err != nil {
+		hutil.AbortErr(c, err)
+		return
+	}
+	hutil.WriteData(c, result)
+}
+
+func (s *Service) ListRoles(ctx context.Context, docID string) (*api.ListRolesResult, error) {
+	log := logger.FromContext(ctx)
+
+	if docID == "" {
+		return nil, hutil.NewApiError(http.StatusBadRequest, "invalid doc id")
+	}
+
+	log.Infof("Get roles, docID: %s", docID)
+	roles, err := s.db.ListRolesByDocument(ctx, docID)
+	if err != nil {
+		log.Errorf("Failed to list roles, err: %v", err)
+		return nil, hutil.NewApiError(http.StatusInternalServerError, "list roles failed")
+	}
+
+	result := &api.ListRolesResult{}
+	for _, role := range roles {
+		result.Roles = append(result.Roles, makeRole(&role))
+	}
+	return result, nil
+}
+
+// HandleListScenesByDocument 获取文档的所有场景
+func (s *Service) HandleListScenesByDocument(c *gin.Context) {
+	docID := c.Param("document_id")
+	if docID == "" {
+		hutil.AbortError(c, http.StatusBadRequest, "invalid doc id")
+		return
+	}
+
+	updatedSince, err := parseUpdatedSince(c)
+	if err != nil {
+		hutil.AbortErr(c, err)
+		return
+	}
+	filter, err := parseSceneFilter(c)
+	if err != nil {
+		hutil.AbortErr(c, err)
+		return
+	}
+	scenes, err := s.listScenes(c.Request.Context(), docID, "", updatedSince)
+	if err != nil {
+		hutil.AbortErr(c, err)
+		return
+	}
+	if notModified(c, scenesETag(scenes)) {
+		return
+	}
+	result := makeScenes(scenes)
+	result.Scenes = filter.apply(result.Scenes)
+	hutil.WriteData(c, result)
+}
+
+// HandleListScenesByChapter 获取章节的场景列表
+func (s *Service) HandleListScenesByChapter(c *gin.Context) {
+	chapterID := c.Param("chapter_id")
+	if chapterID == "" {
+		hutil.AbortError(c, http.StatusBadRequest, "invalid chapter id")
+		return
+	}
+
+	updatedSince, err := parseUpdatedSince(c)
+	if err != nil {
+		hutil.AbortErr(c, err)
+		return
+	}
+	filter, err := parseSceneFilter(c)
+	if err != nil {
+		hutil.AbortErr(c, err)
+		return
+	}
+	scenes, err := s.listScenes(c.Request.Context(), "", chapterID, updatedSince)
+	if err != nil {
+		hutil.AbortErr(c, err)
+		return
+	}
+	if notModified(c, scenesETag(scenes)) {
 		return
 	}
+	result := makeScenes(scenes)
+	result.Scenes = filter.apply(result.Scenes)
+	hutil.WriteData(c, result)
+}
+
+// ListScenes 列取文档或章节的场景，chapterID 非空时按章节列取；updatedSince 非零时只列取该时间及之后更新过的场景
+func (s *Service) ListScenes(ctx context.Context, docID, chapterID string, updatedSince time.Time) (*api.ListScenesResult, error) {
+	scenes, err := s.listScenes(ctx, docID, chapterID, updatedSince)
+	if err != nil {
+		return nil, err
+	}
+	return makeScenes(scenes), nil
+}
+
+func (s *Service) listScenes(ctx context.Context, docID, chapterID string, updatedSince time.Time) ([]db.Scene, error) {
+	log := logger.FromContext(ctx)
+
+	var (
+		scenes []db.Scene
+		err    error
+	)
+	switch {
+	case chapterID != "" && !updatedSince.IsZero():
+		log.Infof("List scenes by chapter, chapterID: %s, updatedSince: %s", chapterID, updatedSince.Format(time.RFC3339))
+		scenes, err = s.db.ListScenesByChapterUpdatedSince(ctx, chapterID, updatedSince)
+	case chapterID != "":
+		log.Infof("List scenes by chapter, chapterID: %s", chapterID)
+		scenes, err = s.db.ListScenesByChapter(ctx, chapterID)
+	case docID != "" && !updatedSince.IsZero():
+		log.Infof("List scenes by document, docID: %s, updatedSince: %s", docID, updatedSince.Format(time.RFC3339))
+		scenes, err = s.db.ListScenesByDocumentUpdatedSince(ctx, docID, updatedSince)
+	case docID != "":
+		log.Infof("List scenes by document, docID: %s", docID)
+		scenes, err = s.db.ListScenesByDocument(ctx, docID)
+	default:
+		return nil, hutil.NewApiError(http.StatusBadRequest, "invalid doc id or chapter id")
+	}
+	if err != nil {
+		log.Errorf("Failed to list scenes, err: %v", err)
+		return nil, hutil.NewApiError(http.StatusInternalServerError, "list scenes failed")
+	}
+	return scenes, nil
+}
+
+func makeScenes(scenes []db.Scene) *api.ListScenesResult {
+	result := &api.ListScenesResult{}
+	for _, scene := range scenes {
+		result.Scenes = append(result.Scenes, makeScene(&scene))
+	}
+	return result
+}
+
+func makeRole(r *db.Role) api.Role {
+	return api.Role{
+		ID:          r.ID,
+		DocumentID:  r.DocumentID,
+		Name:        r.Name,
+		Gender:      r.Gender,
+		Character:   r.Character,
+		Appearance:  r.Appearance,
+		PortraitURL: r.PortraitURL,
+		CreatedAt:   r.CreatedAt.Format(time.DateTime),
+		UpdatedAt:   r.UpdatedAt.Format(time.DateTime),
+	}
+}
+
+func makeScene(s *db.Scene) api.Scene {
+	var overrides *api.SceneOverrides
+	if s.Overrides != (api.SceneOverrides{}) {
+		overrides = &s.Overrides
+	}
+	return api.Scene{
+		ID:                s.ID,
+		ChapterID:         s.ChapterID,
+		DocumentID:        s.DocumentID,
+		Index:             s.Index,
+		Content:           s.Content,
+		ImageURL:          s.ImageURL,
+		VoiceURL:          s.VoiceURL,
+		SubtitleURL:       s.SubtitleURL,
+		Status:            s.Status,
+		Error:             s.Error,
+		ModerationReason:  s.ModerationReason,
+		Placeholder:       s.Placeholder,
+		MediaStale:        s.MediaStale,
+		AudioDurationMs:   s.AudioDurationMs,
+		DisplayDurationMs: s.DisplayDurationMs,
+		Overrides:         overrides,
+		HDImageURL:        sceneHDImageURL(s),
+		Location:          s.Location,
+		TimeOfDay:         s.TimeOfDay,
+		Mood:              s.Mood,
+		CharactersPresent: s.CharactersPresent,
+		CreatedAt:         s.CreatedAt.Format(time.DateTime),
+		UpdatedAt:         s.UpdatedAt.Format(time.DateTime),
+	}
+}
+
+// imageSizePattern 图片分辨率，宽*高
+var imageSizePattern = regexp.MustCompile(`^[1-9]\d{2,3}\*[1-9]\d{2,3}$`)
+
+// sceneImageOptions 场景级图片生成参数，文档的画面风格与场景的 style 合并，场景的分辨率优先于文档的设置，
+// 未设置的字段使用图片服务的默认配置
+func sceneImageOptions(styles *stylePresets, doc *db.Document, s *db.Scene, roles []bailian.RoleInfo) imagegen.Options {
+	style, negative := styles.sceneStyle(doc.ImageStyle, s.Overrides.Style)
+	return imagegen.Options{
+		Summary:         doc.Summary,
+		Roles:           roles,
+		Style:           style,
+		NegativePrompt:  negative,
+		Size:            cmp.Or(s.Overrides.ImageSize, doc.ImageSize),
+		AspectRatio:     doc.AspectRatio,
+		Model:           s.Overrides.ImageModel,
+		RoleConsistency: doc.RoleConsistency,
+	}
+}
+
+// sceneTTSOptions 场景级的语速、音调和情感风格优先，未指定时使用文档的设置
+func sceneTTSOptions(doc *db.Document, s *db.Scene) tts.Options {
+	return tts.Options{
+		Voice:   s.Overrides.Voice,
+		Model:   s.Overrides.TTSModel,
+		Speed:   cmp.Or(s.Overrides.Speed, doc.VoiceProsody.Speed),
+		Pitch:   cmp.Or(s.Overrides.Pitch, doc.VoiceProsody.Pitch),
+		Emotion: cmp.Or(s.Overrides.Emotion, doc.VoiceProsody.Emotion),
+	}
+}
+
+// checkProsody 按语音服务支持的范围校验语速、音调和情感风格
+func (s *Service) checkProsody(speed, pitch float64, emotion string) error {
+	err := tts.Limits(s.voices()).Check(tts.Options{Speed: speed, Pitch: pitch, Emotion: emotion})
+	if err != nil {
+		return hutil.NewApiError(http.StatusBadRequest, err.Error())
+	}
+	return nil
+}
+
+// HandleUpdateRole 更新角色信息
+func (s *Service) HandleUpdateRole(c *gin.Context) {
+	ctx := c.Request.Context()
+	log := logger.FromGinContext(c)
+
+	roleID := c.Param("id")
+	if roleID == "" {
+		hutil.AbortError(c, http.StatusBadRequest, "invalid role id")
+		return
+	}
+
+	var args api.UpdateRoleArgs
+	if err := c.ShouldBindJSON(&args); err != nil {
+		log.Errorf("Invalid request body, err: %v", err)
+		hutil.AbortError(c, http.StatusBadRequest, "invalid request body")
+		return
+	}
+
+	role, err := s.UpdateRole(ctx, roleID, &args)
+	if err != nil {
+		hutil.AbortErr(c, err)
+		return
+	}
+	hutil.WriteData(c, role)
+}
+
+// HandleRolesAction 处理 /documents/:document_id/roles:<action> 形式的角色集合操作，支持 :merge、:extract
+func (s *Service) HandleRolesAction(c *gin.Context) {
+	switch c.Param("action") {
+	case ":merge":
+		s.HandleMergeRoles(c)
+	case ":extract":
+		s.HandleExtractRoles(c)
+	default:
+		hutil.AbortError(c, http.StatusNotFound, "not found")
+	}
+}
+
+func (s *Service) HandleMergeRoles(c *gin.Context) {
+	log := logger.FromGinContext(c)
+
+	var args api.MergeRolesArgs
+	if err := c.ShouldBindJSON(&args); err != nil {
+		log.Errorf("Invalid request body, err: %v", err)
+		hutil.AbortError(c, http.StatusBadRequest, "invalid request body")
+		return
+	}
+	docID := c.Param("document_id")
+	if !args.Consolidate {
+		role, err := s.MergeRoles(c.Request.Context(), docID, &args)
+		if err != nil {
+			hutil.AbortErr(c, err)
+			return
+		}
+		hutil.WriteData(c, role)
+		return
+	}
+
+	// 综合角色描述需要同步调用大模型，按租户限制并发
+	s.limiter.Run(c, GetUserInfo(c).ID, func(ctx context.Context) (any, error) {
+		return s.MergeRoles(ctx, docID, &args)
+	})
+}
+
+// MergeRoles 将重复提取的角色合并到目标角色，返回合并后的目标角色
+func (s *Service) MergeRoles(ctx context.Context, docID string, args *api.MergeRolesArgs) (*api.Role, error) {
+	log := logger.FromContext(ctx)
+
+	seen := map[string]bool{args.TargetID: true}
+	for _, id := range args.SourceIDs {
+		if seen[id] {
+			return nil, hutil.NewApiError(http.StatusBadRequest, "source_ids must be distinct and must not contain target_id")
+		}
+		seen[id] = true
+	}
+	_, err := s.db.GetDocument(ctx, docID)
+	if err != nil {
+		log.Errorf("Failed to get document, id: %s, err: %v", docID, err)
+		return nil, documentError(err, "get document failed")
+	}
+	roles, err := s.db.ListRolesByDocument(ctx, docID)
+	if err != nil {
+		log.Errorf("Failed to list roles, err: %v", err)
+		return nil, hutil.NewApiError(http.StatusInternalServerError, "list roles failed")
+	}
+	byID := make(map[string]db.Role, len(roles))
+	for _, role := range roles {
+		byID[role.ID] = role
+	}
+	target, ok := byID[args.TargetID]
+	if !ok {
+		return nil, hutil.NewApiError(http.StatusNotFound, "role not found")
+	}
+	sources := make([]db.Role, 0, len(args.SourceIDs))
+	for _, id := range args.SourceIDs {
+		role, ok := byID[id]
+		if !ok {
+			return nil, hutil.NewApiError(http.StatusNotFound, "role not found")
+		}
+		sources = append(sources, role)
+	}
 
-	result := &api.ListChaptersResult{}
-	for _, seg := range chapters {
-		result.Chapters = append(result.Chapters, makeChapter(&seg))
+	merged := mergeRoleInfo(target, sources)
+	if args.Consolidate {
+		infos := []bailian.RoleInfo{roleInfo(&target)}
+		for i := range sources {
+			infos = append(infos, roleInfo(&sources[i]))
+		}
+		info, err := s.bailianClient.ConsolidateRole(ctx, infos)
+		if err != nil {
+			log.Errorf("Failed to consolidate roles, docID: %s, err: %v", docID, err)
+			return nil, hutil.NewApiError(hutil.ErrServerInternalCode, "consolidate roles failed")
+		}
+		if info.Gender != "" {
+			merged.Gender = info.Gender
+		}
+		if info.Character != "" {
+			merged.Character = info.Character
+		}
+		if info.Appearance != "" {
+			merged.Appearance = info.Appearance
+		}
 	}
-	hutil.WriteData(c, result)
-}
 
-func makeDocument(d *db.Document) api.Document {
-	return api.Document{
-		ID:               d.ID,
-		Name:             d.Name,
-		FileID:           d.FileID,
-		SummaryImageURL:  d.SummaryImageURL,
-		Status:           d.Status,
-		CreatedAt:        d.CreatedAt.Format(time.DateTime),
-		UpdatedAt:        d.UpdatedAt.Format(time.DateTime),
+	log.Infof("Merge roles, docID: %s, target: %s, sources: %v, consolidate: %v", docID, target.ID, args.SourceIDs, args.Consolidate)
+	err = s.db.MergeRoles(ctx, docID, &merged, args.SourceIDs, roleNameRewriter(target.Name, sources))
+	if err != nil {
+		log.Errorf("Failed to merge roles, docID: %s, err: %v", docID, err)
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			return nil, hutil.NewApiError(http.StatusNotFound, "role not found")
+		}
+		return nil, hutil.NewApiError(hutil.ErrServerInternalCode, "merge roles failed")
 	}
+	ret := makeRole(&merged)
+	return &ret, nil
 }
 
-func makeChapter(d *db.Chapter) api.Chapter {
-	return api.Chapter{
-		ID:         d.ID,
-		DocumentID: d.DocumentID,
-		Index:      d.Index,
-		Title:      d.Title,
-		Content:    d.Content,
-		SceneIDs:   d.SceneIDs,
-		CreatedAt:  d.CreatedAt.Format(time.DateTime),
-		UpdatedAt:  d.UpdatedAt.Format(time.DateTime),
+// mergeRoleInfo 保留目标角色的描述，目标角色为空或未知的字段按顺序取来源角色中第一个有效值
+func mergeRoleInfo(target db.Role, sources []db.Role) db.Role {
+	fill := func(field *string, value string) {
+		if (*field == "" || *field == "未知") && value != "" && value != "未知" {
+			*field = value
+		}
+	}
+	for _, s := range sources {
+		fill(&target.Gender, s.Gender)
+		fill(&target.Character, s.Character)
+		fill(&target.Appearance, s.Appearance)
+		fill(&target.PortraitURL, s.PortraitURL)
 	}
+	return target
 }
 
-func documentErr(c *gin.Context, err error, errMsg string) {
-	var mysqlErr *mysql.MySQLError
-	if errors.As(err, &mysqlErr) && mysqlErr.Number == 1062 {
-		hutil.AbortError(c, ErrExistingDocumentCode, ErrExistingDocument)
-		return
-	}
-	// sqlite for test
-	if sqliteErr, ok := err.(sqlite3.Error); ok {
-		if sqliteErr.Code == 19 && sqliteErr.ExtendedCode == 2067 {
-			hutil.AbortError(c, ErrExistingDocumentCode, ErrExistingDocument)
-			return
+// roleNameRewriter 将场景描述中的来源角色名替换为目标角色名，长名字优先匹配；
+// 来源角色名是目标角色名的一部分时（如 李雷 合并到 李雷（少年））不替换，避免重复
+func roleNameRewriter(name string, sources []db.Role) func(string) string {
+	names := make([]string, 0, len(sources))
+	for _, s := range sources {
+		if s.Name != "" && !strings.Contains(name, s.Name) {
+			names = append(names, s.Name)
 		}
 	}
-	if errors.Is(err, gorm.ErrRecordNotFound) {
-		hutil.AbortError(c, ErrNoSuchDocumentCode, ErrNoSuchDocument)
-	} else {
-		hutil.AbortError(c, hutil.ErrServerInternalCode, errMsg)
+	sort.SliceStable(names, func(i, j int) bool {
+		return utf8.RuneCountInString(names[i]) > utf8.RuneCountInString(names[j])
+	})
+	oldnew := make([]string, 0, 2*len(names))
+	for _, n := range names {
+		oldnew = append(oldnew, n, name)
 	}
+	return strings.NewReplacer(oldnew...).Replace
 }
 
-func (s *Service) downloadFile(ctx context.Context, textURL string) (string, error) {
+func roleInfo(r *db.Role) bailian.RoleInfo {
+	return bailian.RoleInfo{
+		Name:        r.Name,
+		Gender:      r.Gender,
+		Character:   r.Character,
+		Appearance:  r.Appearance,
+		PortraitURL: r.PortraitURL,
+	}
+}
+
+func (s *Service) UpdateRole(ctx context.Context, roleID string, args *api.UpdateRoleArgs) (*api.Role, error) {
 	log := logger.FromContext(ctx)
 
-	url, err := url.ParseRequestURI(textURL)
-	if err != nil {
-		return "", err
-	}
-	index := strings.LastIndex(url.Path, ".")
-	if index == -1 {
-		return "", errors.New("unknown ext")
+	if roleID == "" {
+		return nil, hutil.NewApiError(http.StatusBadRequest, "invalid role id")
 	}
-	ext := url.Path[index+1:]
-	id := uuid.New()
-	uid := hex.EncodeToString(id[:])
-	filename := s.conf.Temp + "/" + uid + "." + ext
-	resp, err := http.Get(textURL)
+
+	// 修改前的角色用于判断外貌是否变化，并按旧名字查找场景
+	old, err := s.db.GetRole(ctx, roleID)
 	if err != nil {
-		return "", err
+		log.Errorf("Failed to get role, err: %v", err)
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			return nil, hutil.NewApiError(http.StatusNotFound, "role not found")
+		}
+		return nil, hutil.NewApiError(http.StatusInternalServerError, "get role failed")
 	}
-	defer resp.Body.Close()
 
-	if resp.StatusCode != http.StatusOK {
-		log.Warnf("Failed to get %s, code: %d", textURL, resp.StatusCode)
-		return "", errors.New("unexpected status code")
+	log.Infof("Update role, roleID: %s, regenerateScenes: %v", roleID, args.RegenerateScenes)
+	err = s.db.UpdateRole(ctx, roleID, args)
+	if err != nil {
+		log.Errorf("Failed to update role, err: %v", err)
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			return nil, hutil.NewApiError(http.StatusNotFound, "role not found")
+		}
+		return nil, hutil.NewApiError(http.StatusInternalServerError, "update role failed")
 	}
 
-	file, err := os.Create(filename)
+	role, err := s.db.GetRole(ctx, roleID)
 	if err != nil {
-		return "", err
+		log.Errorf("Failed to get role, err: %v", err)
+		return nil, hutil.NewApiError(http.StatusInternalServerError, "get role failed")
 	}
-	defer file.Close()
 
-	n, err := io.Copy(file, resp.Body)
-	if err != nil {
-		os.Remove(filename)
-		return "", err
+	ret := makeRole(&role)
+	if args.RegenerateScenes && roleLookChanged(&old, &role) {
+		ret.RegeneratingScenes, err = s.regenerateRoleScenes(ctx, &old, &role)
+		if err != nil {
+			return nil, err
+		}
 	}
+	return &ret, nil
+}
 
-	log.Infof("Download url %s, filename: %s, n: %d", textURL, filename, n)
-	return filename, nil
+// roleLookChanged 角色的外貌、性别或参考立绘是否变化，只有这些字段影响场景图片
+func roleLookChanged(old, role *db.Role) bool {
+	return old.Appearance != role.Appearance || old.Gender != role.Gender || old.PortraitURL != role.PortraitURL
 }
 
-// HandleGetRoles 获取文档的角色列表
-func (s *Service) HandleGetRoles(c *gin.Context) {
-	ctx := c.Request.Context()
-	log := logger.FromGinContext(c)
+// regenerateRoleScenes 把场景描述或出场人物中有该角色（修改前或修改后的名字）的场景标记为媒体过期，返回标记的场景数。
+// 文档已处理结束时回到图片生成阶段，由 DocumentMgr 重新生成；文档仍在图片生成阶段时过期场景随文档一起生成
+func (s *Service) regenerateRoleScenes(ctx context.Context, old, role *db.Role) (int, error) {
+	log := logger.FromContext(ctx)
 
-	docID := c.Param("document_id")
-	if docID == "" {
-		hutil.AbortError(c, http.StatusBadRequest, "invalid doc id")
-		return
+	doc, err := s.db.GetDocument(ctx, role.DocumentID)
+	if err != nil {
+		log.Errorf("Failed to get document, id: %s, err: %v", role.DocumentID, err)
+		return 0, documentError(err, "get document failed")
 	}
-
-	log.Infof("Get roles, docID: %s", docID)
-	roles, err := s.db.ListRolesByDocument(ctx, docID)
+	scenes, err := s.db.ListScenesByDocument(ctx, doc.ID)
 	if err != nil {
-		log.Errorf("Failed to list roles, err: %v", err)
-		hutil.AbortError(c, http.StatusInternalServerError, "list roles failed")
-		return
+		log.Errorf("Failed to list scenes, docID: %s, err: %v", doc.ID, err)
+		return 0, hutil.NewApiError(hutil.ErrServerInternalCode, "list scenes failed")
+	}
+	names := []string{old.Name, role.Name}
+	var sceneIDs []string
+	for _, scene := range scenes {
+		for _, name := range names {
+			if name != "" && (strings.Contains(scene.Content, name) || slices.Contains(scene.CharactersPresent, name)) {
+				sceneIDs = append(sceneIDs, scene.ID)
+				break
+			}
+		}
 	}
 
-	result := &api.ListRolesResult{}
-	for _, role := range roles {
-		result.Roles = append(result.Roles, makeRole(&role))
+	n, err := s.db.MarkScenesMediaStale(ctx, doc.ID, sceneIDs)
+	if err != nil {
+		log.Errorf("Failed to mark scenes media stale, docID: %s, err: %v", doc.ID, err)
+		return 0, hutil.NewApiError(hutil.ErrServerInternalCode, "queue scene regeneration failed")
+	}
+	log.Infof("Role look changed, queue scene regeneration, docID: %s, roleID: %s, scenes: %d", doc.ID, role.ID, n)
+	if n > 0 && documentFinished(doc.Status) {
+		err = s.db.UpdateDocumentStatus(ctx, doc.ID, db.DocumentStatusSceneReady)
+		if err != nil {
+			log.Errorf("Failed to update document status, docID: %s, err: %v", doc.ID, err)
+			return 0, documentError(err, "queue scene regeneration failed")
+		}
 	}
-	hutil.WriteData(c, result)
+	return n, nil
 }
 
-// HandleListScenesByDocument 获取文档的所有场景
-func (s *Service) HandleListScenesByDocument(c *gin.Context) {
-	ctx := c.Request.Context()
+// HandleCreateScene 在章节中插入用户编写的场景
+func (s *Service) HandleCreateScene(c *gin.Context) {
 	log := logger.FromGinContext(c)
 
-	docID := c.Param("document_id")
-	if docID == "" {
-		hutil.AbortError(c, http.StatusBadRequest, "invalid doc id")
+	var args api.CreateSceneArgs
+	if err := c.ShouldBindJSON(&args); err != nil {
+		log.Errorf("Invalid request body, err: %v", err)
+		hutil.AbortError(c, http.StatusBadRequest, "invalid request body")
 		return
 	}
-
-	log.Infof("List scenes by document, docID: %s", docID)
-	scenes, err := s.db.ListScenesByDocument(ctx, docID)
+	ret, err := s.CreateScene(c.Request.Context(), c.Param("chapter_id"), &args)
 	if err != nil {
-		log.Errorf("Failed to list scenes, err: %v", err)
-		hutil.AbortError(c, http.StatusInternalServerError, "list scenes failed")
+		hutil.AbortErr(c, err)
 		return
 	}
-
-	result := &api.ListScenesResult{}
-	for _, scene := range scenes {
-		result.Scenes = append(result.Scenes, makeScene(&scene))
-	}
-	hutil.WriteData(c, result)
+	setAuditResource(c, ret.ID)
+	hutil.WriteData(c, ret)
 }
 
-// HandleListScenesByChapter 获取章节的场景列表
-func (s *Service) HandleListScenesByChapter(c *gin.Context) {
-	ctx := c.Request.Context()
-	log := logger.FromGinContext(c)
+// CreateScene 在章节中 InsertAfter 场景之后插入场景，后续场景序号加一。只能在场景切分完成后插入，
+// 否则场景切分会覆盖章节的场景列表
+func (s *Service) CreateScene(ctx context.Context, chapterID string, args *api.CreateSceneArgs) (*api.Scene, error) {
+	log := logger.FromContext(ctx)
 
-	chapterID := c.Param("chapter_id")
 	if chapterID == "" {
-		hutil.AbortError(c, http.StatusBadRequest, "invalid chapter id")
-		return
+		return nil, hutil.NewApiError(http.StatusBadRequest, "invalid chapter id")
+	}
+	if args.Overrides != nil && args.Overrides.ImageSize != "" && !imageSizePattern.MatchString(args.Overrides.ImageSize) {
+		return nil, hutil.NewApiError(http.StatusBadRequest, "invalid image_size, expected <width>*<height>")
+	}
+	if o := args.Overrides; o != nil {
+		if err := s.checkProsody(o.Speed, o.Pitch, o.Emotion); err != nil {
+			return nil, err
+		}
 	}
 
-	log.Infof("List scenes by chapter, chapterID: %s", chapterID)
-	scenes, err := s.db.ListScenesByChapter(ctx, chapterID)
+	chapter, err := s.db.GetChapterByID(ctx, chapterID)
 	if err != nil {
-		log.Errorf("Failed to list scenes, err: %v", err)
-		hutil.AbortError(c, http.StatusInternalServerError, "list scenes failed")
-		return
+		log.Errorf("Failed to get chapter, id: %s, err: %v", chapterID, err)
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			return nil, hutil.NewApiError(http.StatusNotFound, "chapter not found")
+		}
+		return nil, hutil.NewApiError(hutil.ErrServerInternalCode, "get chapter failed")
 	}
-
-	result := &api.ListScenesResult{}
-	for _, scene := range scenes {
-		result.Scenes = append(result.Scenes, makeScene(&scene))
+	doc, err := s.db.GetDocument(ctx, chapter.DocumentID)
+	if err != nil {
+		log.Errorf("Failed to get document, id: %s, err: %v", chapter.DocumentID, err)
+		return nil, documentError(err, "get document failed")
+	}
+	if doc.Status != db.DocumentStatusSceneReady && !documentFinished(doc.Status) {
+		return nil, hutil.NewApiError(http.StatusConflict, "scenes cannot be inserted before scene extraction finishes")
+	}
+	if args.InsertAfter != "" && !slices.Contains(chapter.SceneIDs, args.InsertAfter) {
+		return nil, hutil.NewApiError(http.StatusBadRequest, "insert_after must be a scene of the chapter")
 	}
-	hutil.WriteData(c, result)
-}
 
-func makeRole(r *db.Role) api.Role {
-	return api.Role{
-		ID:         r.ID,
-		DocumentID: r.DocumentID,
-		Name:       r.Name,
-		Gender:     r.Gender,
-		Character:  r.Character,
-		Appearance: r.Appearance,
-		CreatedAt:  r.CreatedAt.Format(time.DateTime),
-		UpdatedAt:  r.UpdatedAt.Format(time.DateTime),
+	now := time.Now()
+	scene := db.Scene{
+		ID:        db.MakeUUID(),
+		ChapterID: chapterID,
+		Content:   args.Content,
+		CreatedAt: now,
+		UpdatedAt: now,
+	}
+	if args.Overrides != nil {
+		scene.Overrides = *args.Overrides
+	}
+	log.Infof("Insert scene, chapterID: %s, insertAfter: %s, generate: %v", chapterID, args.InsertAfter, args.Generate)
+	err = s.db.InsertScene(ctx, args.InsertAfter, &scene)
+	if err != nil {
+		log.Errorf("Failed to insert scene, chapterID: %s, err: %v", chapterID, err)
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			return nil, hutil.NewApiError(http.StatusBadRequest, "insert_after must be a scene of the chapter")
+		}
+		return nil, hutil.NewApiError(hutil.ErrServerInternalCode, "insert scene failed")
 	}
-}
 
-func makeScene(s *db.Scene) api.Scene {
-	return api.Scene{
-		ID:         s.ID,
-		ChapterID:  s.ChapterID,
-		DocumentID: s.DocumentID,
-		Index:      s.Index,
-		Content:    s.Content,
-		ImageURL:   s.ImageURL,
-		VoiceURL:   s.VoiceURL,
-		CreatedAt:  s.CreatedAt.Format(time.DateTime),
-		UpdatedAt:  s.UpdatedAt.Format(time.DateTime),
+	// 新场景没有图片，图片生成阶段会处理；文档已处理结束时回到图片生成阶段
+	if args.Generate && documentFinished(doc.Status) {
+		err = s.db.UpdateDocumentStatus(ctx, doc.ID, db.DocumentStatusSceneReady)
+		if err != nil {
+			log.Errorf("Failed to update document status, docID: %s, err: %v", doc.ID, err)
+			return nil, documentError(err, "queue scene generation failed")
+		}
 	}
+
+	ret := makeScene(&scene)
+	return &ret, nil
 }
 
-// HandleUpdateRole 更新角色信息
-func (s *Service) HandleUpdateRole(c *gin.Context) {
+// HandleUpdateScene 更新场景内容，立即重新生成图片和语音
+func (s *Service) HandleUpdateScene(c *gin.Context) {
 	ctx := c.Request.Context()
 	log := logger.FromGinContext(c)
 
-	roleID := c.Param("id")
-	if roleID == "" {
-		hutil.AbortError(c, http.StatusBadRequest, "invalid role id")
+	sceneID := c.Param("id")
+	if sceneID == "" {
+		hutil.AbortError(c, http.StatusBadRequest, "invalid scene id")
 		return
 	}
 
-	var args api.UpdateRoleArgs
+	var args api.UpdateSceneArgs
 	if err := c.ShouldBindJSON(&args); err != nil {
 		log.Errorf("Invalid request body, err: %v", err)
 		hutil.AbortError(c, http.StatusBadRequest, "invalid request body")
 		return
 	}
 
-	log.Infof("Update role, roleID: %s", roleID)
-	err := s.db.UpdateRole(ctx, roleID, &args)
+	doc, roles, err := s.updateSceneContent(ctx, sceneID, &args)
 	if err != nil {
-		log.Errorf("Failed to update role, err: %v", err)
-		if errors.Is(err, gorm.ErrRecordNotFound) {
-			hutil.AbortError(c, http.StatusNotFound, "role not found")
-		} else {
-			hutil.AbortError(c, http.StatusInternalServerError, "update role failed")
-		}
+		hutil.AbortErr(c, err)
 		return
 	}
 
-	role, err := s.db.GetRole(ctx, roleID)
+	// 重新生成图片和语音，租户并发已满时排队异步执行
+	s.limiter.Run(c, doc.UserID, func(ctx context.Context) (any, error) {
+		return s.regenerateScene(ctx, doc, sceneID, args.Content, roles)
+	})
+}
+
+// UpdateScene 更新场景内容并同步重新生成图片和语音，租户并发已满时直接返回 429，不排队
+func (s *Service) UpdateScene(ctx context.Context, sceneID string, args *api.UpdateSceneArgs) (*api.Scene, error) {
+	doc, roles, err := s.updateSceneContent(ctx, sceneID, args)
 	if err != nil {
-		log.Errorf("Failed to get role, err: %v", err)
-		hutil.AbortError(c, http.StatusInternalServerError, "get role failed")
-		return
+		return nil, err
 	}
-
-	hutil.WriteData(c, makeRole(&role))
+	ret, err := s.limiter.TryRun(ctx, doc.UserID, func(ctx context.Context) (any, error) {
+		return s.regenerateScene(ctx, doc, sceneID, args.Content, roles)
+	})
+	if err != nil {
+		return nil, err
+	}
+	return ret.(*api.Scene), nil
 }
 
-// HandleUpdateScene 更新场景内容，立即重新生成图片和语音
-func (s *Service) HandleUpdateScene(c *gin.Context) {
-	ctx := c.Request.Context()
+// HandleEditScene 只更新场景内容，不立即重新生成
+func (s *Service) HandleEditScene(c *gin.Context) {
 	log := logger.FromGinContext(c)
 
-	sceneID := c.Param("id")
-	if sceneID == "" {
-		hutil.AbortError(c, http.StatusBadRequest, "invalid scene id")
-		return
-	}
-
 	var args api.UpdateSceneArgs
 	if err := c.ShouldBindJSON(&args); err != nil {
 		log.Errorf("Invalid request body, err: %v", err)
 		hutil.AbortError(c, http.StatusBadRequest, "invalid request body")
 		return
 	}
+	scene, err := s.EditScene(c.Request.Context(), c.Param("id"), &args)
+	if err != nil {
+		hutil.AbortErr(c, err)
+		return
+	}
+	hutil.WriteData(c, scene)
+}
+
+// EditScene 更新场景内容，内容变化时已有的图片和语音标记为过期。文档开启 auto_regenerate 且已处理结束时回到图片生成阶段，
+// 由 DocumentMgr 重新生成过期场景；文档仍在图片生成阶段时过期场景随文档一起生成
+func (s *Service) EditScene(ctx context.Context, sceneID string, args *api.UpdateSceneArgs) (*api.Scene, error) {
+	log := logger.FromContext(ctx)
+
+	doc, _, err := s.updateSceneContent(ctx, sceneID, args)
+	if err != nil {
+		return nil, err
+	}
+	scene, err := s.db.GetScene(ctx, sceneID)
+	if err != nil {
+		log.Errorf("Failed to get scene, err: %v", err)
+		return nil, hutil.NewApiError(http.StatusInternalServerError, "get scene failed")
+	}
+
+	if scene.MediaStale && doc.AutoRegenerate && documentFinished(doc.Status) {
+		log.Infof("Scene media stale, queue regeneration, docID: %s, sceneID: %s", doc.ID, sceneID)
+		err = s.db.UpdateDocumentStatus(ctx, doc.ID, db.DocumentStatusSceneReady)
+		if err != nil {
+			log.Errorf("Failed to update document status, docID: %s, err: %v", doc.ID, err)
+			return nil, documentError(err, "queue scene generation failed")
+		}
+	}
+
+	ret := makeScene(&scene)
+	return &ret, nil
+}
+
+// updateSceneContent 更新场景内容，返回重新生成所需的文档和角色信息
+func (s *Service) updateSceneContent(ctx context.Context, sceneID string, args *api.UpdateSceneArgs) (db.Document, []bailian.RoleInfo, error) {
+	log := logger.FromContext(ctx)
+
+	if sceneID == "" {
+		return db.Document{}, nil, hutil.NewApiError(http.StatusBadRequest, "invalid scene id")
+	}
+	if args.Overrides != nil && args.Overrides.ImageSize != "" && !imageSizePattern.MatchString(args.Overrides.ImageSize) {
+		return db.Document{}, nil, hutil.NewApiError(http.StatusBadRequest, "invalid image_size, expected <width>*<height>")
+	}
+	if o := args.Overrides; o != nil {
+		if err := s.checkProsody(o.Speed, o.Pitch, o.Emotion); err != nil {
+			return db.Document{}, nil, err
+		}
+	}
 
 	// 1. 获取场景信息
 	scene, err := s.db.GetScene(ctx, sceneID)
 	if err != nil {
 		log.Errorf("Failed to get scene, err: %v", err)
 		if errors.Is(err, gorm.ErrRecordNotFound) {
-			hutil.AbortError(c, http.StatusNotFound, "scene not found")
-		} else {
-			hutil.AbortError(c, http.StatusInternalServerError, "get scene failed")
+			return db.Document{}, nil, hutil.NewApiError(http.StatusNotFound, "scene not found")
 		}
-		return
+		return db.Document{}, nil, hutil.NewApiError(http.StatusInternalServerError, "get scene failed")
 	}
 
 	// 2. 更新场景内容
 	log.Infof("Update scene content, sceneID: %s", sceneID)
-	err = s.db.UpdateScene(ctx, sceneID, &args)
+	err = s.db.UpdateScene(ctx, sceneID, args)
 	if err != nil {
 		log.Errorf("Failed to update scene, err: %v", err)
-		hutil.AbortError(c, http.StatusInternalServerError, "update scene failed")
-		return
+		return db.Document{}, nil, hutil.NewApiError(http.StatusInternalServerError, "update scene failed")
 	}
 
 	// 3. 获取文档信息（需要摘要和角色信息）
 	doc, err := s.db.GetDocument(ctx, scene.DocumentID)
 	if err != nil {
 		log.Errorf("Failed to get document, err: %v", err)
-		hutil.AbortError(c, http.StatusInternalServerError, "get document failed")
-		return
+		return db.Document{}, nil, hutil.NewApiError(http.StatusInternalServerError, "get document failed")
 	}
 
 	// 4. 获取角色信息
 	dbRoles, err := s.db.ListRolesByDocument(ctx, doc.ID)
 	if err != nil {
 		log.Errorf("Failed to list roles, err: %v", err)
-		hutil.AbortError(c, http.StatusInternalServerError, "list roles failed")
-		return
+		return db.Document{}, nil, hutil.NewApiError(http.StatusInternalServerError, "list roles failed")
 	}
 
 	// 转换为 bailian.RoleInfo
 	roles := make([]bailian.RoleInfo, 0, len(dbRoles))
 	for _, r := range dbRoles {
-		roles = append(roles, bailian.RoleInfo{
-			Name:       r.Name,
-			Gender:     r.Gender,
-			Character:  r.Character,
-			Appearance: r.Appearance,
-		})
+		roles = append(roles, roleInfo(&r))
+	}
+	return doc, roles, nil
+}
+
+// regenerateScene 为场景重新生成图片和语音，返回更新后的场景
+func (s *Service) regenerateScene(ctx context.Context, doc db.Document, sceneID, content string, roles []bailian.RoleInfo) (*api.Scene, error) {
+	log := logger.FromContext(ctx)
+
+	// 排队任务执行时配额可能已经用完，执行前再检查当日生成配额
+	err := s.quota.CheckGeneration(ctx, doc.UserID, 1, 1)
+	if err != nil {
+		log.Warnf("Quota check failed, userID: %d, err: %v", doc.UserID, err)
+		if errors.Is(err, errQuotaExceeded) {
+			return nil, hutil.NewApiError(ErrQuotaExceededCode, ErrQuotaExceeded)
+		}
+		return nil, hutil.NewApiError(hutil.ErrServerInternalCode, "check quota failed")
+	}
+
+	ctx = withRequestLog(ctx, s.db, doc.ID, logStageRegenerate, sceneID)
+
+	// 覆盖前的场景，用于补记已有媒体的生成记录，其中的场景级生成参数为本次更新后的值
+	old, err := s.db.GetScene(ctx, sceneID)
+	if err != nil {
+		log.Errorf("Failed to get scene, err: %v", err)
+		return nil, hutil.NewApiError(http.StatusInternalServerError, "get scene failed")
 	}
 
-	// 5. 生成图片
+	reason, err := moderateSceneText(ctx, s.moderation, content)
+	if err != nil {
+		log.Errorf("Failed to moderate scene content, scene: %s, err: %v", sceneID, err)
+		return nil, hutil.NewApiError(http.StatusInternalServerError, "moderate scene failed")
+	}
+	if reason != "" {
+		return s.blockScene(ctx, doc, old, content, reason, "")
+	}
+
+	// 生成图片
 	log.Infof("Generating image for scene, sceneID: %s", sceneID)
-	imageURL, err := s.bailianClient.GenerateImage(ctx, args.Content, doc.Summary, roles)
+	opts := sceneImageOptions(s.styles, &doc, &old, roles)
+	imageContent := sceneImageContent(&old, content)
+	opts.Prompt, err = renderPrompt(ctx, s.db, db.PromptKindImage, imagePromptVars(&doc, &old, imageContent, opts))
+	if err != nil {
+		log.Errorf("Failed to render image prompt, scene: %s, err: %v", sceneID, err)
+		return nil, hutil.NewApiError(http.StatusInternalServerError, "generate image failed")
+	}
+	imageURL, err := s.images().Get(doc.ImageProvider).Generate(ctx, imageContent, opts)
 	if err != nil {
 		log.Errorf("Failed to generate image, scene: %s, err: %v", sceneID, err)
-		hutil.AbortError(c, http.StatusInternalServerError, "generate image failed")
-		return
+		return nil, hutil.NewApiError(http.StatusInternalServerError, "generate image failed")
+	}
+	reason, err = moderateSceneImage(ctx, s.moderation, s.imageStore, imageURL)
+	if err != nil {
+		log.Errorf("Failed to moderate scene image, scene: %s, err: %v", sceneID, err)
+		return nil, hutil.NewApiError(http.StatusInternalServerError, "moderate scene failed")
+	}
+	if reason != "" {
+		return s.blockScene(ctx, doc, old, content, reason, imageURL)
 	}
 
 	// 更新图片 URL
 	err = s.db.UpdateSceneImageURL(ctx, sceneID, imageURL)
 	if err != nil {
 		log.Errorf("Failed to update scene imageURL, err: %v", err)
-		hutil.AbortError(c, http.StatusInternalServerError, "update image failed")
-		return
+		return nil, hutil.NewApiError(http.StatusInternalServerError, "update image failed")
 	}
 
 	log.Infof("Image generated for scene: %s, URL: %s", sceneID, imageURL)
 
-	// 6. 生成语音
+	// 生成语音
 	log.Infof("Generating TTS for scene, sceneID: %s", sceneID)
-	voiceURL, err := s.bailianClient.GenerateTTS(ctx, args.Content)
+	voiceURL, err := s.synthesize(ctx, content, sceneTTSOptions(&doc, &old))
 	if err != nil {
 		log.Errorf("Failed to generate TTS, scene: %s, err: %v", sceneID, err)
-		hutil.AbortError(c, http.StatusInternalServerError, "generate voice failed")
-		return
+		return nil, hutil.NewApiError(http.StatusInternalServerError, "generate voice failed")
 	}
 
 	// 更新语音 URL
 	err = s.db.UpdateSceneVoiceURL(ctx, sceneID, voiceURL)
 	if err != nil {
 		log.Errorf("Failed to update scene voiceURL, err: %v", err)
-		hutil.AbortError(c, http.StatusInternalServerError, "update voice failed")
-		return
+		return nil, hutil.NewApiError(http.StatusInternalServerError, "update voice failed")
 	}
 
 	log.Infof("Voice generated for scene: %s, URL: %s", sceneID, voiceURL)
 
-	// 7. 返回更新后的场景
-	scene, err = s.db.GetScene(ctx, sceneID)
+	audioMs, displayMs := s.sceneTiming(ctx, voiceURL, content)
+	err = s.db.UpdateSceneTiming(ctx, sceneID, audioMs, displayMs)
+	if err != nil {
+		log.Errorf("Failed to update scene timing, err: %v", err)
+		return nil, hutil.NewApiError(http.StatusInternalServerError, "update scene timing failed")
+	}
+	updateSceneSubtitle(ctx, s.db, s.audioStore, sceneID, content, audioMs)
+
+	err = s.quota.RecordGeneration(ctx, doc.UserID, 1, 1)
+	if err != nil {
+		log.Errorf("Failed to record quota usage, err: %v", err)
+	}
+
+	// 手动重新生成成功后清除场景的失败状态
+	err = s.db.UpdateSceneStatus(ctx, sceneID, db.SceneStatusReady, 0, "")
+	if err != nil {
+		log.Errorf("Failed to update scene status, err: %v", err)
+		return nil, hutil.NewApiError(http.StatusInternalServerError, "update scene status failed")
+	}
+	recordSceneGeneration(ctx, s.db, s.conf.DocumentConfig, old, db.SceneGeneration{
+		ImageURL:          imageURL,
+		VoiceURL:          voiceURL,
+		AudioDurationMs:   audioMs,
+		DisplayDurationMs: displayMs,
+	})
+	recordSceneImageVersion(ctx, s.db, s.conf.DocumentConfig, old, imageURL)
+
+	// 返回更新后的场景
+	scene, err := s.db.GetScene(ctx, sceneID)
 	if err != nil {
 		log.Errorf("Failed to get scene, err: %v", err)
-		hutil.AbortError(c, http.StatusInternalServerError, "get scene failed")
-		return
+		return nil, hutil.NewApiError(http.StatusInternalServerError, "get scene failed")
 	}
 
 	log.Infof("Scene updated and regenerated, sceneID: %s", sceneID)
-	hutil.WriteData(c, makeScene(&scene))
+	ret := makeScene(&scene)
+	return &ret, nil
 }
@@ -1,31 +1,78 @@
 package svr
 
 import (
+	"archive/zip"
+	"bufio"
 	"bytes"
+	"context"
+	"encoding/base64"
 	"encoding/json"
+	"errors"
 	"fmt"
 	"io"
 	"mime/multipart"
+	"net"
 	"net/http"
 	"net/http/httptest"
+	"net/url"
 	"os"
 	"path/filepath"
+	"regexp"
+	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
 	"testing"
+	"time"
 
+	"github.com/gin-gonic/gin"
 	"github.com/stretchr/testify/assert"
 	"github.com/stretchr/testify/require"
 	"go.uber.org/zap"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/credentials/insecure"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/status"
+	"google.golang.org/grpc/test/bufconn"
 	"gorm.io/driver/sqlite"
 	"gorm.io/gorm"
 
 	"imgagent/api"
+	"imgagent/api/pb"
 	"imgagent/bailian"
 	"imgagent/db"
+	hutil "imgagent/httputil"
+	"imgagent/imagegen"
+	"imgagent/moderation"
+	"imgagent/pkg/dlock"
+	"imgagent/pkg/faults"
+	"imgagent/pkg/httpclient"
+	"imgagent/pkg/idempotency"
+	"imgagent/pkg/jobqueue"
 	"imgagent/pkg/logger"
+	"imgagent/pkg/mediastore"
+	"imgagent/pkg/middleware"
+	"imgagent/pkg/pubsub"
+	"imgagent/pkg/textclean"
+	"imgagent/placeholder"
 	"imgagent/proto"
+	"imgagent/scanner"
 	"imgagent/storage"
+	"imgagent/tts"
 )
 
+var pathParamPattern = regexp.MustCompile(`:(\w+)`)
+
+// testAdminToken 为 setupTestService 创建的超级管理员 token，管理接口需要使用
+const testAdminToken = "test-admin-token"
+
+// asAdmin 为请求设置超级管理员认证
+func asAdmin(req *http.Request) *http.Request {
+	req.Header.Set("Authorization", "Bearer "+testAdminToken)
+	return req
+}
+
 func setupTestService(t *testing.T) (*Service, func()) {
 	// 初始化日志
 	logConf := logger.Config{
@@ -38,13 +85,19 @@ func setupTestService(t *testing.T) (*Service, func()) {
 	tempDir, err := os.MkdirTemp("", "imgagent-test-*")
 	require.NoError(t, err)
 
-	// 使用 SQLite 内存数据库进行测试
+	// 使用 SQLite 内存数据库进行测试。每个连接各自是一个空的内存库，并发处理文档时只能共用一个连接
 	gormDB, err := gorm.Open(sqlite.Open(":memory:"), &gorm.Config{})
 	require.NoError(t, err)
+	sqlDB, err := gormDB.DB()
+	require.NoError(t, err)
+	sqlDB.SetMaxOpenConns(1)
 
 	// 自动迁移表结构
-	err = gormDB.AutoMigrate(&db.Document{}, &db.Chapter{})
+	err = gormDB.AutoMigrate(&db.Document{}, &db.Chapter{}, &db.Scene{}, &db.Role{}, &db.QuotaUsage{}, &db.Event{}, &db.Bookmark{}, &db.ReadPosition{}, &db.IndexTask{}, &db.SearchEntry{}, &db.Webhook{}, &db.WebhookDelivery{}, &db.BackfillProgress{}, &db.Export{}, &db.DocumentRun{}, &db.RunScene{}, &db.SceneGeneration{}, &db.SceneImageVersion{}, &db.BGMTrack{}, &db.URLPolicy{}, &db.DocumentLog{}, &db.ImportBatch{}, &db.ImportItem{}, &db.PromptTemplate{}, &db.ChapterEmbedding{}, &db.AuditLog{}, &db.DocumentTranslation{}, &db.ChapterTranslation{}, &db.RoleRelation{}, &db.User{}, &db.UserToken{})
 	require.NoError(t, err)
+	admin := db.User{Username: "admin", SuperAdmin: 1, Status: 1}
+	require.NoError(t, gormDB.Create(&admin).Error)
+	require.NoError(t, gormDB.Create(&db.UserToken{UserID: admin.ID, Token: testAdminToken, ExpireDate: time.Now().Add(time.Hour)}).Error)
 
 	database := &db.Database{}
 	database.SetDB(gormDB)
@@ -72,6 +125,13 @@ func setupTestService(t *testing.T) (*Service, func()) {
 		},
 		db:            database,
 		bailianClient: bailianClient,
+		quota:         newQuotaMgr(QuotaConfig{}, database),
+		limiter:       newLimiter(LimitConfig{}, "/v1"),
+		imports:       newImportRunner(),
+		pubsub:        pubsub.NewMemory(),
+		queue:         jobqueue.NewMemory(jobqueue.Config{}),
+		idempotency:   idempotency.NewMemory(idempotency.Config{}),
+		httpClient:    httpclient.New(httpclient.Config{AllowPrivate: true}, nil),
 	}
 
 	// 返回清理函数
@@ -547,3 +607,6719 @@ func TestCreateDocumentWithSampleFile(t *testing.T) {
 	assert.Equal(t, "测试文档", doc.Name)
 	zap.S().Infof("使用临时文件创建文档成功，ID: %s", doc.ID)
 }
+
+func TestGetDocumentWait(t *testing.T) {
+	service, cleanup := setupTestService(t)
+	defer cleanup()
+
+	router := service.RegisterRouter(os.Stdout)
+	ctx := context.Background()
+
+	docID := db.MakeUUID()
+	_, err := service.db.CreateDocument(ctx, docID, "file-id", &api.CreateDocumentArgs{Name: "长轮询文档"})
+	require.NoError(t, err)
+
+	getDocument := func(query string) proto.BaseResponse {
+		req := httptest.NewRequest(http.MethodGet, "/v1/documents/"+docID+query, nil)
+		w := httptest.NewRecorder()
+		router.ServeHTTP(w, req)
+		require.Equal(t, http.StatusOK, w.Code)
+
+		var resp proto.BaseResponse
+		require.NoError(t, json.Unmarshal(w.Body.Bytes(), &resp))
+		return resp
+	}
+
+	t.Run("无效的 wait 参数", func(t *testing.T) {
+		resp := getDocument("?wait=abc")
+		assert.Equal(t, http.StatusBadRequest, resp.Code)
+	})
+
+	t.Run("状态未变化时等待超时返回", func(t *testing.T) {
+		start := time.Now()
+		resp := getDocument("?wait=1s")
+		assert.Equal(t, http.StatusOK, resp.Code)
+		assert.GreaterOrEqual(t, time.Since(start), time.Second)
+	})
+
+	t.Run("状态变化后立即返回", func(t *testing.T) {
+		go func() {
+			time.Sleep(500 * time.Millisecond)
+			service.db.UpdateDocumentStatus(ctx, docID, db.DocumentStatusRoleReady)
+		}()
+
+		start := time.Now()
+		resp := getDocument("?wait=30s")
+		require.Equal(t, http.StatusOK, resp.Code)
+		assert.Less(t, time.Since(start), 10*time.Second)
+
+		docData, err := json.Marshal(resp.Data)
+		require.NoError(t, err)
+		var doc api.Document
+		require.NoError(t, json.Unmarshal(docData, &doc))
+		assert.Equal(t, db.DocumentStatusRoleReady, doc.Status)
+	})
+}
+
+func TestQuota(t *testing.T) {
+	service, cleanup := setupTestService(t)
+	defer cleanup()
+
+	service.quota = newQuotaMgr(QuotaConfig{
+		Default: api.QuotaLimits{MaxUploadBytes: 10, MaxImagesPerDay: 5},
+	}, service.db)
+	router := service.RegisterRouter(os.Stdout)
+	ctx := context.Background()
+
+	t.Run("上传超过配额的文件", func(t *testing.T) {
+		body := &bytes.Buffer{}
+		writer := multipart.NewWriter(body)
+		writer.WriteField("name", "超配额文档")
+		part, err := writer.CreateFormFile("file", "test.txt")
+		require.NoError(t, err)
+		part.Write([]byte("这是一个超过配额的文件内容"))
+		writer.Close()
+
+		req := httptest.NewRequest(http.MethodPost, "/v1/documents", body)
+		req.Header.Set("Content-Type", writer.FormDataContentType())
+		w := httptest.NewRecorder()
+		router.ServeHTTP(w, req)
+
+		var resp proto.BaseResponse
+		require.NoError(t, json.Unmarshal(w.Body.Bytes(), &resp))
+		assert.Equal(t, ErrQuotaExceededCode, resp.Code)
+	})
+
+	t.Run("查询配额用量", func(t *testing.T) {
+		require.NoError(t, service.quota.RecordGeneration(ctx, 0, 2, 1))
+		require.NoError(t, service.quota.RecordGeneration(ctx, 0, 1, 1))
+
+		req := httptest.NewRequest(http.MethodGet, "/v1/quota", nil)
+		w := httptest.NewRecorder()
+		router.ServeHTTP(w, req)
+
+		var resp proto.BaseResponse
+		require.NoError(t, json.Unmarshal(w.Body.Bytes(), &resp))
+		require.Equal(t, http.StatusOK, resp.Code)
+
+		data, err := json.Marshal(resp.Data)
+		require.NoError(t, err)
+		var result api.GetQuotaResult
+		require.NoError(t, json.Unmarshal(data, &result))
+		assert.Equal(t, int64(10), result.Limits.MaxUploadBytes)
+		assert.Equal(t, 3, result.Usage.ImagesToday)
+		assert.Equal(t, 2, result.Usage.VoicesToday)
+
+		assert.NoError(t, service.quota.CheckGeneration(ctx, 0, 2, 0))
+		assert.ErrorIs(t, service.quota.CheckGeneration(ctx, 0, 3, 0), errQuotaExceeded)
+	})
+}
+
+func TestQuotaWarning(t *testing.T) {
+	service, cleanup := setupTestService(t)
+	defer cleanup()
+
+	service.quota = newQuotaMgr(QuotaConfig{
+		Default: api.QuotaLimits{MaxImagesPerDay: 100},
+		Tenants: map[string]api.QuotaLimits{"0": {MaxImagesPerDay: 4, MaxDocuments: 10, WarningThresholds: []int{50, 75}}},
+	}, service.db)
+	service.quota.webhooks = newWebhookMgr(WebhookConfig{}, httpclient.Config{}, service.db)
+	router := service.RegisterRouter(os.Stdout)
+	ctx := context.Background()
+
+	// 文档上的 webhook 不接收配额事件
+	require.NoError(t, service.db.CreateWebhook(ctx, &db.Webhook{ID: db.MakeUUID(), UserID: 0, URL: "http://a", Secret: "s"}))
+	require.NoError(t, service.db.CreateWebhook(ctx, &db.Webhook{ID: db.MakeUUID(), UserID: 0, DocumentID: "doc1", URL: "http://b", Secret: "s"}))
+	assert.Equal(t, []int{80, 90}, service.quota.Limits(1).WarningThresholds)
+
+	quotaHeader := func() string {
+		req := httptest.NewRequest(http.MethodGet, "/v1/quota", nil)
+		w := httptest.NewRecorder()
+		router.ServeHTTP(w, req)
+		return w.Header().Get(quotaWarningHeader)
+	}
+	assert.Empty(t, quotaHeader())
+
+	// 0 -> 3 同时越过 50% 和 75%，只提醒最高的一条；3 -> 4 达到上限
+	require.NoError(t, service.quota.RecordGeneration(ctx, 0, 3, 0))
+	assert.Equal(t, "images_per_day=75", quotaHeader())
+	require.NoError(t, service.quota.RecordGeneration(ctx, 0, 1, 0))
+	assert.Equal(t, "images_per_day=100", quotaHeader())
+
+	events, err := service.db.ListEvents(ctx, 0, 0, 10)
+	require.NoError(t, err)
+	require.Len(t, events, 2)
+	assert.Equal(t, db.EventQuotaExceeded, events[0].Type)
+	assert.Equal(t, db.EventQuotaWarning, events[1].Type)
+	assert.Equal(t, "images_per_day quota reached 75%: 3/4", events[1].Message)
+
+	deliveries, err := service.db.ListDueWebhookDeliveries(ctx, time.Now(), 10)
+	require.NoError(t, err)
+	require.Len(t, deliveries, 2)
+	var alerts []string
+	for _, d := range deliveries {
+		var event struct {
+			Type string         `json:"type"`
+			Data api.QuotaAlert `json:"data"`
+		}
+		require.NoError(t, json.Unmarshal([]byte(d.Payload), &event))
+		assert.Equal(t, "images_per_day", event.Data.Kind)
+		alerts = append(alerts, fmt.Sprintf("%s:%d", event.Type, event.Data.Threshold))
+	}
+	assert.ElementsMatch(t, []string{"quota.warning:75", "quota.exceeded:100"}, alerts)
+}
+
+func TestBailianFileLifecycle(t *testing.T) {
+	service, cleanup := setupTestService(t)
+	defer cleanup()
+
+	ctx := context.Background()
+	old := time.Now().Add(-2 * time.Hour).Unix()
+	files := []bailian.FileInfo{
+		{ID: "file-doc", Filename: "prod_" + db.MakeUUID() + "_temp.txt", CreatedAt: old},
+		{ID: "file-orphan", Filename: "prod_" + db.MakeUUID() + "_temp.txt", CreatedAt: old},
+		{ID: "file-new", Filename: "prod_" + db.MakeUUID() + "_temp.txt", CreatedAt: time.Now().Unix()},
+		{ID: "file-staging", Filename: "staging_" + db.MakeUUID() + "_temp.txt", CreatedAt: old},
+		{ID: "file-unprefixed", Filename: db.MakeUUID() + "_temp.txt", CreatedAt: old},
+		{ID: "file-other", Filename: "report.pdf", CreatedAt: old},
+	}
+	var mu sync.Mutex
+	var deleted []string
+	bailianServer := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		mu.Lock()
+		defer mu.Unlock()
+		if r.Method == http.MethodDelete {
+			deleted = append(deleted, strings.TrimPrefix(r.URL.Path, "/compatible-mode/v1/files/"))
+			w.Write([]byte(`{"deleted":true}`))
+			return
+		}
+		// 每页 2 个文件
+		start := 0
+		for i, f := range files {
+			if f.ID == r.URL.Query().Get("after") {
+				start = i + 1
+			}
+		}
+		end := min(start+2, len(files))
+		json.NewEncoder(w).Encode(bailian.ListFilesResponse{Data: files[start:end], HasMore: end < len(files)})
+	}))
+	defer bailianServer.Close()
+	bailianClient, err := bailian.NewClient(bailian.Config{BaseURL: bailianServer.URL, APIKey: "test"})
+	require.NoError(t, err)
+	service.bailianClient = bailianClient
+	docID := db.MakeUUID()
+	_, err = service.db.CreateDocument(ctx, docID, "file-doc", &api.CreateDocumentArgs{Name: "百炼文件"})
+	require.NoError(t, err)
+
+	// 未配置前缀时无法区分部署，不清理
+	mgr, err := newDocumentMgr(DocumentConfigEx{config: DocumentConfig{Enable: true}, db: service.db, quota: service.quota}, bailianClient)
+	require.NoError(t, err)
+	mgr.ReconcileFiles(ctx)
+	assert.Empty(t, deleted)
+
+	// 只删除超过宽限期、由本部署上传且不被文档引用的文件
+	mgr, err = newDocumentMgr(DocumentConfigEx{config: DocumentConfig{Enable: true, BailianFilePrefix: "prod"}, db: service.db, quota: service.quota}, bailianClient)
+	require.NoError(t, err)
+	mgr.ReconcileFiles(ctx)
+	assert.Equal(t, []string{"file-orphan"}, deleted)
+	assert.Equal(t, "/tmp/prod_id_temp.txt", uploadTempFilename("/tmp", "prod", "id", ".txt"))
+	assert.Equal(t, "/tmp/id_temp.txt", uploadTempFilename("/tmp", "", "id", ".txt"))
+
+	// 删除文档时同时删除百炼上的文件
+	deleted = nil
+	require.NoError(t, service.DeleteDocument(ctx, docID))
+	assert.Equal(t, []string{"file-doc"}, deleted)
+}
+
+func TestTextClean(t *testing.T) {
+	service, cleanup := setupTestService(t)
+	defer cleanup()
+
+	_, err := newTextCleaners(TextCleanConfig{Tenants: map[string]textclean.Config{"1": {Rules: []textclean.Rule{{Pattern: "["}}}}})
+	require.Error(t, err)
+
+	service.cleaners, err = newTextCleaners(TextCleanConfig{
+		Tenants: map[string]textclean.Config{"0": {StripAds: true, Rules: []textclean.Rule{{Pattern: `【.*?】`}}}},
+	})
+	require.NoError(t, err)
+	// 未单独配置的租户使用默认配置，默认配置不做处理
+	assert.Equal(t, "www.example.com", service.cleaners.cleanFunc(1)("www.example.com"))
+
+	bailianServer := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte(`{"id":"file-clean"}`))
+	}))
+	defer bailianServer.Close()
+	service.bailianClient, err = bailian.NewClient(bailian.Config{BaseURL: bailianServer.URL, APIKey: "test"})
+	require.NoError(t, err)
+
+	ctx := context.Background()
+	content := "第一章 开始\n请记住本站域名 www.example.com\n他走进了【某站水印】房间。\n\n第二章 结束\n她离开了。\n"
+	doc, err := service.CreateDocument(ctx, 0, "清洗", "", false, false, "clean.txt", int64(len(content)), strings.NewReader(content))
+	require.NoError(t, err)
+
+	chapters, err := service.db.ListChapters(ctx, doc.ID)
+	require.NoError(t, err)
+	require.Len(t, chapters, 2)
+	assert.Contains(t, chapters[0].Content, "他走进了房间。")
+	for _, ch := range chapters {
+		assert.NotContains(t, ch.Content, "www.example.com")
+		assert.NotContains(t, ch.Content, "水印")
+	}
+}
+
+func TestIngestReport(t *testing.T) {
+	service, cleanup := setupTestService(t)
+	defer cleanup()
+
+	bailianServer := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte(`{"id":"file-report"}`))
+	}))
+	defer bailianServer.Close()
+	var err error
+	service.bailianClient, err = bailian.NewClient(bailian.Config{BaseURL: bailianServer.URL, APIKey: "test"})
+	require.NoError(t, err)
+
+	router := service.RegisterRouter(os.Stdout)
+	ctx := context.Background()
+	content := "第一章 开始\n他走进了房间。\n\n第一章 开始\n她离开了。\n"
+	doc, err := service.CreateDocument(ctx, 0, "报告", "", false, false, "report.txt", int64(len(content)), strings.NewReader(content))
+	require.NoError(t, err)
+	assert.Equal(t, 2, doc.IngestWarnings)
+
+	req := httptest.NewRequest(http.MethodGet, "/v1/documents/"+doc.ID+"/ingest-report", nil)
+	w := httptest.NewRecorder()
+	router.ServeHTTP(w, req)
+
+	var resp proto.BaseResponse
+	require.NoError(t, json.Unmarshal(w.Body.Bytes(), &resp))
+	require.Equal(t, http.StatusOK, resp.Code)
+	data, err := json.Marshal(resp.Data)
+	require.NoError(t, err)
+	var report api.IngestReport
+	require.NoError(t, json.Unmarshal(data, &report))
+	assert.Equal(t, "utf-8", report.Encoding)
+	assert.Equal(t, 2, report.Chapters)
+	assert.Equal(t, []int{0, 1}, report.ShortChapters)
+	assert.Equal(t, []string{"第一章 开始"}, report.DuplicateTitles)
+	assert.Len(t, report.Warnings, 2)
+
+	// 开启去重后重复章节在分割时删除，并记录在报告中
+	service.conf.DedupChapters = true
+	content = "第一章 开始\n他走进了房间。\n\n第二章 结束\n她离开了。\n\n第一章 开始\n他走进了房间。\n"
+	doc, err = service.CreateDocument(ctx, 0, "去重", "", false, false, "dedup.txt", int64(len(content)), strings.NewReader(content))
+	require.NoError(t, err)
+	chapters, err := service.db.ListChapters(ctx, doc.ID)
+	require.NoError(t, err)
+	require.Len(t, chapters, 2)
+	stored, err := service.db.GetDocument(ctx, doc.ID)
+	require.NoError(t, err)
+	assert.True(t, stored.IngestReport.DuplicatesRemoved)
+	assert.Equal(t, []api.DuplicateChapter{{Index: 2, FirstIndex: 0, Title: "第一章 开始"}}, stored.IngestReport.DuplicateChapters)
+
+	req = httptest.NewRequest(http.MethodGet, "/v1/documents/not-exist/ingest-report", nil)
+	w = httptest.NewRecorder()
+	router.ServeHTTP(w, req)
+	require.NoError(t, json.Unmarshal(w.Body.Bytes(), &resp))
+	assert.Equal(t, ErrNoSuchDocumentCode, resp.Code)
+}
+
+func TestChapterDuplicates(t *testing.T) {
+	service, cleanup := setupTestService(t)
+	defer cleanup()
+
+	bailianServer := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte(`{"id":"file-near"}`))
+	}))
+	defer bailianServer.Close()
+	var err error
+	service.bailianClient, err = bailian.NewClient(bailian.Config{BaseURL: bailianServer.URL, APIKey: "test"})
+	require.NoError(t, err)
+
+	router := service.RegisterRouter(os.Stdout)
+	ctx := context.Background()
+
+	places := []string{"山顶的寺庙", "湖边的小屋", "热闹的集市", "荒凉的驿站", "城外的渡口", "幽深的竹林", "破旧的祠堂"}
+	body := func(seed int) string {
+		var b strings.Builder
+		for i := range 40 {
+			n := seed*31 + i*7
+			fmt.Fprintf(&b, "他第%d次来到%s。", n, places[(n/3+seed)%7])
+		}
+		return b.String()
+	}
+	chapter1 := "第一章 开始\n" + body(1)
+	// 第三章只改动了一句话
+	content := chapter1 + "\n\n第二章 渡口\n" + body(2) + "\n\n" + strings.Replace(chapter1, "第31次", "第三十一次", 1)
+	doc, err := service.CreateDocument(ctx, 0, "相似章节", "", false, false, "near.txt", int64(len(content)), strings.NewReader(content))
+	require.NoError(t, err)
+	chapters, err := service.db.ListChapters(ctx, doc.ID)
+	require.NoError(t, err)
+	require.Len(t, chapters, 3)
+	stored, err := service.db.GetDocument(ctx, doc.ID)
+	require.NoError(t, err)
+	require.Len(t, stored.IngestReport.NearDuplicateChapters, 1)
+	assert.Equal(t, 2, stored.IngestReport.NearDuplicateChapters[0].Index)
+
+	list := func(docID string) (proto.BaseResponse, api.ListChapterDuplicatesResult) {
+		req := httptest.NewRequest(http.MethodGet, "/v1/documents/"+docID+"/duplicate-chapters", nil)
+		w := httptest.NewRecorder()
+		router.ServeHTTP(w, req)
+		var resp proto.BaseResponse
+		require.NoError(t, json.Unmarshal(w.Body.Bytes(), &resp))
+		var ret api.ListChapterDuplicatesResult
+		data, err := json.Marshal(resp.Data)
+		require.NoError(t, err)
+		require.NoError(t, json.Unmarshal(data, &ret))
+		return resp, ret
+	}
+	resp, ret := list(doc.ID)
+	require.Equal(t, http.StatusOK, resp.Code)
+	require.Len(t, ret.Duplicates, 1)
+	assert.Equal(t, chapters[2].ID, ret.Duplicates[0].ChapterID)
+	assert.Equal(t, 2, ret.Duplicates[0].Index)
+	assert.Equal(t, chapters[0].ID, ret.Duplicates[0].DuplicateOf)
+	assert.Equal(t, 0, ret.Duplicates[0].DuplicateOfIndex)
+	assert.Greater(t, ret.Duplicates[0].Similarity, 0.95)
+
+	// 修改内容后不再标记
+	require.NoError(t, service.db.UpdateChapter(ctx, chapters[2].ID, &api.UpdateChapterArgs{Content: "新的内容"}))
+	chapter, err := service.db.GetChapter(ctx, chapters[2].ID, doc.ID)
+	require.NoError(t, err)
+	assert.Empty(t, chapter.DuplicateOf)
+	_, ret = list(doc.ID)
+	assert.Empty(t, ret.Duplicates)
+
+	// 前文章节删除后不再列出
+	require.NoError(t, service.db.MarkDuplicateChapters(ctx, doc.ID, []db.ChapterDuplicate{{ChapterID: chapters[2].ID, DuplicateOf: chapters[0].ID, Similarity: 1}}))
+	_, ret = list(doc.ID)
+	require.Len(t, ret.Duplicates, 1)
+	require.NoError(t, service.db.DeleteChapter(ctx, chapters[0].ID, doc.ID))
+	_, ret = list(doc.ID)
+	assert.Empty(t, ret.Duplicates)
+
+	resp, _ = list("not-exist")
+	assert.Equal(t, ErrNoSuchDocumentCode, resp.Code)
+}
+
+func TestDocumentNameConflict(t *testing.T) {
+	service, cleanup := setupTestService(t)
+	defer cleanup()
+
+	bailianServer := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte(`{"id":"file-rename"}`))
+	}))
+	defer bailianServer.Close()
+	var err error
+	service.bailianClient, err = bailian.NewClient(bailian.Config{BaseURL: bailianServer.URL, APIKey: "test"})
+	require.NoError(t, err)
+
+	ctx := context.Background()
+	for _, name := range []string{"小说", "小说 (2)", "小说 (4)"} {
+		_, err := service.db.CreateDocument(ctx, db.MakeUUID(), "file-id", &api.CreateDocumentArgs{Name: name})
+		require.NoError(t, err)
+	}
+
+	// 名称已存在时返回可用的候选名称
+	_, err = service.CreateDocument(ctx, 0, "小说", "", false, false, "a.txt", 1, strings.NewReader("x"))
+	var ae *proto.ApiError
+	require.ErrorAs(t, err, &ae)
+	assert.Equal(t, ErrExistingDocumentCode, ae.Code)
+	assert.Equal(t, &api.DocumentNameConflict{Name: "小说", Suggestions: []string{"小说 (3)", "小说 (5)", "小说 (6)"}}, ae.Data)
+
+	// 带序号的名称从下一个序号开始
+	suggestions, err := service.suggestDocumentNames(ctx, "小说 (4)", 2)
+	require.NoError(t, err)
+	assert.Equal(t, []string{"小说 (5)", "小说 (6)"}, suggestions)
+
+	// 加上后缀超出长度限制时按字符截断原名
+	long := strings.Repeat("长", 49) + "ab"
+	suggestions, err = service.suggestDocumentNames(ctx, long, 1)
+	require.NoError(t, err)
+	assert.Equal(t, []string{strings.Repeat("长", 46) + " (2)"}, suggestions)
+
+	// auto_rename 时改用第一个候选名称
+	doc, err := service.CreateDocument(ctx, 0, "小说", "", true, false, "a.txt", 1, strings.NewReader("x"))
+	require.NoError(t, err)
+	assert.Equal(t, "小说 (3)", doc.Name)
+
+	// HTTP 接口在 data 中返回候选名称
+	router := service.RegisterRouter(os.Stdout)
+	body := &bytes.Buffer{}
+	writer := multipart.NewWriter(body)
+	require.NoError(t, writer.WriteField("name", "小说"))
+	part, err := writer.CreateFormFile("file", "a.txt")
+	require.NoError(t, err)
+	part.Write([]byte("y"))
+	require.NoError(t, writer.Close())
+	req := httptest.NewRequest(http.MethodPost, "/v1/documents", body)
+	req.Header.Set("Content-Type", writer.FormDataContentType())
+	w := httptest.NewRecorder()
+	router.ServeHTTP(w, req)
+	var resp proto.BaseResponse
+	require.NoError(t, json.Unmarshal(w.Body.Bytes(), &resp))
+	assert.Equal(t, ErrExistingDocumentCode, resp.Code)
+	assert.Equal(t, []any{"小说 (5)", "小说 (6)", "小说 (7)"}, resp.Data.(map[string]any)["suggestions"])
+}
+
+func TestDuplicateDocumentContent(t *testing.T) {
+	service, cleanup := setupTestService(t)
+	defer cleanup()
+
+	bailianServer := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte(`{"id":"file-dup"}`))
+	}))
+	defer bailianServer.Close()
+	var err error
+	service.bailianClient, err = bailian.NewClient(bailian.Config{BaseURL: bailianServer.URL, APIKey: "test"})
+	require.NoError(t, err)
+
+	ctx := context.Background()
+	content := "第一章\n\n祥子拉车。"
+	doc, err := service.CreateDocument(ctx, 1, "骆驼祥子", "", false, false, "a.txt", int64(len(content)), strings.NewReader(content))
+	require.NoError(t, err)
+	stored, err := service.db.GetDocument(ctx, doc.ID)
+	require.NoError(t, err)
+	assert.Len(t, stored.ContentHash, 64)
+
+	// 名称不同、内容相同时拒绝，data 中返回已有文档
+	_, err = service.CreateDocument(ctx, 1, "祥子", "", false, false, "b.md", int64(len(content)), strings.NewReader(content))
+	var ae *proto.ApiError
+	require.ErrorAs(t, err, &ae)
+	assert.Equal(t, ErrDuplicateDocumentCode, ae.Code)
+	assert.Equal(t, &api.DuplicateDocument{DocumentID: doc.ID, Name: "骆驼祥子"}, ae.Data)
+
+	// link_duplicate 时返回已有文档，不新建
+	linked, err := service.CreateDocument(ctx, 1, "祥子", "", false, true, "b.md", int64(len(content)), strings.NewReader(content))
+	require.NoError(t, err)
+	assert.Equal(t, doc.ID, linked.ID)
+	_, err = service.db.GetDocumentWithName(ctx, "祥子")
+	assert.ErrorIs(t, err, gorm.ErrRecordNotFound)
+
+	// 其他租户可以上传相同内容
+	other, err := service.CreateDocument(ctx, 2, "祥子", "", false, false, "a.txt", int64(len(content)), strings.NewReader(content))
+	require.NoError(t, err)
+	assert.NotEqual(t, doc.ID, other.ID)
+}
+
+func TestDocumentNameRules(t *testing.T) {
+	service, cleanup := setupTestService(t)
+	defer cleanup()
+
+	_, err := newDocumentNameRules(DocumentNameConfig{MaxLength: 200})
+	assert.Error(t, err)
+	_, err = newDocumentNameRules(DocumentNameConfig{Pattern: "["})
+	assert.Error(t, err)
+
+	// 重名判断忽略首尾空白、大小写和全半角差异
+	ctx := context.Background()
+	docID, otherID := db.MakeUUID(), db.MakeUUID()
+	_, err = service.db.CreateDocument(ctx, docID, "file-id", &api.CreateDocumentArgs{Name: "ABC"})
+	require.NoError(t, err)
+	_, err = service.db.CreateDocument(ctx, otherID, "file-id", &api.CreateDocumentArgs{Name: "other"})
+	require.NoError(t, err)
+
+	_, err = service.CreateDocument(ctx, 0, " ａｂｃ ", "", false, false, "a.txt", 1, strings.NewReader("x"))
+	var ae *proto.ApiError
+	require.ErrorAs(t, err, &ae)
+	assert.Equal(t, ErrExistingDocumentCode, ae.Code)
+	assert.Equal(t, &api.DocumentNameConflict{Name: "ａｂｃ", Suggestions: []string{"ａｂｃ (2)", "ａｂｃ (3)", "ａｂｃ (4)"}}, ae.Data)
+
+	_, err = service.UpdateDocument(ctx, otherID, &api.UpdateDocumentArgs{Name: "abc"})
+	require.ErrorAs(t, err, &ae)
+	assert.Equal(t, ErrExistingDocumentCode, ae.Code)
+
+	// 只改大小写时与自身匹配，不算重名
+	doc, err := service.UpdateDocument(ctx, docID, &api.UpdateDocumentArgs{Name: " abc "})
+	require.NoError(t, err)
+	assert.Equal(t, "abc", doc.Name)
+
+	// 按配置校验长度、字符和保留名称，保留名称同样忽略大小写和全半角
+	service.names, err = newDocumentNameRules(DocumentNameConfig{
+		MaxLength:     10,
+		Pattern:       `^[\p{Han}\w ()-]+$`,
+		ReservedNames: []string{"Admin"},
+	})
+	require.NoError(t, err)
+
+	for name, msg := range map[string]string{
+		"":                      "name is required",
+		strings.Repeat("长", 11): "name exceeds maximum length of 10",
+		"a\tb":                  "name contains invalid characters",
+		"a/b":                   "name contains disallowed characters",
+		"ＡＤＭＩＮ":                 "name is reserved",
+	} {
+		err := service.names.Validate(name)
+		var ae *proto.ApiError
+		require.ErrorAs(t, err, &ae, name)
+		assert.Equal(t, msg, ae.Message, name)
+	}
+	assert.NoError(t, service.names.Validate(strings.Repeat("长", 10)))
+
+	router := service.RegisterRouter(os.Stdout)
+	req := httptest.NewRequest(http.MethodGet, "/v1/limits", nil)
+	w := httptest.NewRecorder()
+	router.ServeHTTP(w, req)
+	var resp proto.BaseResponse
+	require.NoError(t, json.Unmarshal(w.Body.Bytes(), &resp))
+	require.Equal(t, http.StatusOK, resp.Code)
+	data, err := json.Marshal(resp.Data)
+	require.NoError(t, err)
+	var limits api.Limits
+	require.NoError(t, json.Unmarshal(data, &limits))
+	assert.Equal(t, api.DocumentNameLimits{MaxLength: 10, Pattern: `^[\p{Han}\w ()-]+$`, ReservedNames: []string{"Admin"}}, limits.DocumentName)
+}
+
+func TestStreamChapterSummary(t *testing.T) {
+	service, cleanup := setupTestService(t)
+	defer cleanup()
+
+	var gotStream bool
+	bailianServer := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		var req bailian.ChatCompletionRequest
+		json.NewDecoder(r.Body).Decode(&req)
+		gotStream = req.Stream
+		w.Header().Set("Content-Type", "text/event-stream")
+		for _, delta := range []string{"主角", "进入", "房间。"} {
+			fmt.Fprintf(w, "data: {\"choices\":[{\"delta\":{\"content\":%q}}]}\n\n", delta)
+		}
+		fmt.Fprint(w, "data: [DONE]\n\n")
+	}))
+	defer bailianServer.Close()
+	var err error
+	service.bailianClient, err = bailian.NewClient(bailian.Config{BaseURL: bailianServer.URL, APIKey: "test"})
+	require.NoError(t, err)
+
+	ctx := context.Background()
+	docID := db.MakeUUID()
+	_, err = service.db.CreateDocument(ctx, docID, "file-id", &api.CreateDocumentArgs{Name: "摘要文档"})
+	require.NoError(t, err)
+	require.NoError(t, service.db.CreateChapters(ctx, docID, []string{"第一章 他走进了房间。"}))
+	chapters, err := service.db.ListChapters(ctx, docID)
+	require.NoError(t, err)
+
+	router := service.RegisterRouter(os.Stdout)
+	req := httptest.NewRequest(http.MethodGet, "/v1/documents/"+docID+"/chapters/"+chapters[0].ID+"/summary", nil)
+	w := httptest.NewRecorder()
+	router.ServeHTTP(w, req)
+	assert.True(t, gotStream)
+	assert.Contains(t, w.Header().Get("Content-Type"), "text/event-stream")
+	body := w.Body.String()
+	assert.Equal(t, 3, strings.Count(body, "event:delta"))
+	assert.Contains(t, body, `data:{"content":"进入"}`)
+	assert.Contains(t, body, `event:done`)
+	assert.Contains(t, body, `"summary":"主角进入房间。"`)
+	chapter, err := service.db.GetChapterByID(ctx, chapters[0].ID)
+	require.NoError(t, err)
+	assert.Equal(t, "主角进入房间。", chapter.Summary)
+
+	req = httptest.NewRequest(http.MethodGet, "/v1/documents/"+docID+"/chapters/"+db.MakeUUID()+"/summary", nil)
+	w = httptest.NewRecorder()
+	router.ServeHTTP(w, req)
+	var resp proto.BaseResponse
+	require.NoError(t, json.Unmarshal(w.Body.Bytes(), &resp))
+	assert.Equal(t, http.StatusNotFound, resp.Code)
+
+	// 租户的并发名额已占满时不推送，返回 429
+	slots := service.limiter.tenantSlots(0)
+	for range cap(slots) {
+		slots <- struct{}{}
+	}
+	req = httptest.NewRequest(http.MethodGet, "/v1/documents/"+docID+"/chapters/"+chapters[0].ID+"/summary", nil)
+	w = httptest.NewRecorder()
+	router.ServeHTTP(w, req)
+	require.NoError(t, json.Unmarshal(w.Body.Bytes(), &resp))
+	assert.Equal(t, http.StatusTooManyRequests, resp.Code)
+	assert.NotContains(t, w.Header().Get("Content-Type"), "text/event-stream")
+}
+
+func TestSummarizeChapter(t *testing.T) {
+	service, cleanup := setupTestService(t)
+	defer cleanup()
+
+	bailianServer := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "text/event-stream")
+		for _, delta := range []string{"主角", "进入房间。"} {
+			fmt.Fprintf(w, "data: {\"choices\":[{\"delta\":{\"content\":%q}}]}\n\n", delta)
+		}
+		fmt.Fprint(w, "data: [DONE]\n\n")
+	}))
+	defer bailianServer.Close()
+	var err error
+	service.bailianClient, err = bailian.NewClient(bailian.Config{BaseURL: bailianServer.URL, APIKey: "test"})
+	require.NoError(t, err)
+
+	ctx := context.Background()
+	docID := db.MakeUUID()
+	_, err = service.db.CreateDocument(ctx, docID, "file-id", &api.CreateDocumentArgs{Name: "摘要文档"})
+	require.NoError(t, err)
+	require.NoError(t, service.db.CreateChapters(ctx, docID, []string{"第一章 他走进了房间。"}))
+	chapters, err := service.db.ListChapters(ctx, docID)
+	require.NoError(t, err)
+	chapterID := chapters[0].ID
+
+	router := service.RegisterRouter(os.Stdout)
+	do := func(method, path string, body any) proto.BaseResponse {
+		var reader io.Reader
+		if body != nil {
+			data, err := json.Marshal(body)
+			require.NoError(t, err)
+			reader = bytes.NewReader(data)
+		}
+		req := httptest.NewRequest(method, "/v1/documents/"+docID+"/chapters/"+path, reader)
+		req.Header.Set("Content-Type", "application/json")
+		w := httptest.NewRecorder()
+		router.ServeHTTP(w, req)
+		var resp proto.BaseResponse
+		require.NoError(t, json.Unmarshal(w.Body.Bytes(), &resp))
+		return resp
+	}
+	getChapter := func() api.Chapter {
+		resp := do(http.MethodGet, chapterID, nil)
+		require.Equal(t, http.StatusOK, resp.Code)
+		data, err := json.Marshal(resp.Data)
+		require.NoError(t, err)
+		var ch api.Chapter
+		require.NoError(t, json.Unmarshal(data, &ch))
+		return ch
+	}
+
+	resp := do(http.MethodPost, chapterID+":summarize", nil)
+	require.Equal(t, http.StatusOK, resp.Code, resp.Message)
+	data, err := json.Marshal(resp.Data)
+	require.NoError(t, err)
+	var summary api.ChapterSummary
+	require.NoError(t, json.Unmarshal(data, &summary))
+	assert.Equal(t, api.ChapterSummary{ChapterID: chapterID, Summary: "主角进入房间。"}, summary)
+	assert.Equal(t, "主角进入房间。", getChapter().Summary)
+
+	// 修改内容后摘要清空
+	resp = do(http.MethodPut, chapterID, api.UpdateChapterArgs{Content: "第一章 他离开了房间。"})
+	require.Equal(t, http.StatusOK, resp.Code, resp.Message)
+	assert.Empty(t, getChapter().Summary)
+
+	assert.Equal(t, http.StatusNotFound, do(http.MethodPost, db.MakeUUID()+":summarize", nil).Code)
+
+	// 按租户限制并发，请求 respond-async 时排队异步执行
+	req := httptest.NewRequest(http.MethodPost, "/v1/documents/"+docID+"/chapters/"+chapterID+":summarize", nil)
+	req.Header.Set("Prefer", "respond-async")
+	w := httptest.NewRecorder()
+	router.ServeHTTP(w, req)
+	require.Equal(t, http.StatusAccepted, w.Code)
+	require.Eventually(t, func() bool {
+		job, _ := service.limiter.Get(0, strings.TrimPrefix(w.Header().Get("Location"), "/v1/operations/"))
+		return job.Status == JobStatusSucceeded
+	}, 2*time.Second, 10*time.Millisecond)
+	assert.Equal(t, "主角进入房间。", getChapter().Summary)
+}
+
+func TestSummarizeDocument(t *testing.T) {
+	service, cleanup := setupTestService(t)
+	defer cleanup()
+	ctx := context.Background()
+
+	var streamCalls, reduceCalls atomic.Int32
+	bailianServer := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		var req bailian.ChatCompletionRequest
+		require.NoError(t, json.NewDecoder(r.Body).Decode(&req))
+		if req.Stream {
+			streamCalls.Add(1)
+			w.Header().Set("Content-Type", "text/event-stream")
+			fmt.Fprint(w, "data: {\"choices\":[{\"delta\":{\"content\":\"章节摘要\"}}]}\n\n")
+			fmt.Fprint(w, "data: [DONE]\n\n")
+			return
+		}
+		n := reduceCalls.Add(1)
+		assert.Contains(t, req.Messages[len(req.Messages)-1].Content, "梗概文档")
+		content, err := json.Marshal(fmt.Sprintf(`{"synopsis":"梗概 %d","plot_points":["情节一"," ","情节二"]}`, n))
+		require.NoError(t, err)
+		fmt.Fprintf(w, `{"choices":[{"message":{"role":"assistant","content":%s}}]}`, content)
+	}))
+	defer bailianServer.Close()
+	var err error
+	service.bailianClient, err = bailian.NewClient(bailian.Config{BaseURL: bailianServer.URL, APIKey: "test"})
+	require.NoError(t, err)
+	router := service.RegisterRouter(os.Stdout)
+
+	docID := db.MakeUUID()
+	_, err = service.db.CreateDocument(ctx, docID, "file-id", &api.CreateDocumentArgs{Name: "梗概文档"})
+	require.NoError(t, err)
+	contents := make([]string, synopsisBatchSize+1)
+	for i := range contents {
+		contents[i] = fmt.Sprintf("第%d章的内容", i+1)
+	}
+	require.NoError(t, service.db.CreateChapters(ctx, docID, contents))
+	chapters, err := service.db.ListChapters(ctx, docID)
+	require.NoError(t, err)
+	require.NoError(t, service.db.UpdateChapterSummary(ctx, chapters[0].ID, "已有摘要"))
+
+	summarize := func(id string) proto.BaseResponse {
+		req := httptest.NewRequest(http.MethodPost, "/v1/documents/"+id+":summarize", nil)
+		w := httptest.NewRecorder()
+		router.ServeHTTP(w, req)
+		var resp proto.BaseResponse
+		require.NoError(t, json.Unmarshal(w.Body.Bytes(), &resp))
+		return resp
+	}
+
+	resp := summarize(docID)
+	require.Equal(t, http.StatusOK, resp.Code, resp.Message)
+	data, err := json.Marshal(resp.Data)
+	require.NoError(t, err)
+	var doc api.Document
+	require.NoError(t, json.Unmarshal(data, &doc))
+	// 已有摘要的章节不再生成；21 条摘要先分两批归纳，再归纳出全书梗概
+	assert.Equal(t, int32(synopsisBatchSize), streamCalls.Load())
+	assert.Equal(t, int32(3), reduceCalls.Load())
+	assert.Equal(t, "梗概 3", doc.Synopsis)
+	assert.Equal(t, []string{"情节一", "情节二"}, doc.PlotPoints)
+
+	chapters, err = service.db.ListChapters(ctx, docID)
+	require.NoError(t, err)
+	assert.Equal(t, "已有摘要", chapters[0].Summary)
+	assert.Equal(t, "章节摘要", chapters[1].Summary)
+	saved, err := service.db.GetDocument(ctx, docID)
+	require.NoError(t, err)
+	assert.Equal(t, "梗概 3", saved.Synopsis)
+	assert.Equal(t, []string{"情节一", "情节二"}, saved.PlotPoints)
+
+	// 再次生成时复用章节摘要
+	resp = summarize(docID)
+	require.Equal(t, http.StatusOK, resp.Code, resp.Message)
+	assert.Equal(t, int32(synopsisBatchSize), streamCalls.Load())
+	assert.Equal(t, int32(6), reduceCalls.Load())
+
+	assert.Equal(t, ErrNoSuchDocumentCode, summarize(db.MakeUUID()).Code)
+
+	// 按租户限制并发，请求 respond-async 时排队异步执行
+	req := httptest.NewRequest(http.MethodPost, "/v1/documents/"+docID+":summarize", nil)
+	req.Header.Set("Prefer", "respond-async")
+	w := httptest.NewRecorder()
+	router.ServeHTTP(w, req)
+	require.Equal(t, http.StatusAccepted, w.Code)
+	var job api.Job
+	require.Eventually(t, func() bool {
+		job, _ = service.limiter.Get(0, strings.TrimPrefix(w.Header().Get("Location"), "/v1/operations/"))
+		return job.Status == JobStatusSucceeded
+	}, 2*time.Second, 10*time.Millisecond)
+	assert.Equal(t, int32(9), reduceCalls.Load())
+}
+
+func TestReorderChapters(t *testing.T) {
+	service, cleanup := setupTestService(t)
+	defer cleanup()
+
+	router := service.RegisterRouter(os.Stdout)
+	ctx := context.Background()
+
+	docID := db.MakeUUID()
+	_, err := service.db.CreateDocument(ctx, docID, "file-id", &api.CreateDocumentArgs{Name: "重排文档"})
+	require.NoError(t, err)
+	require.NoError(t, service.db.CreateChapters(ctx, docID, []string{"第一章", "第二章"}))
+	chapters, err := service.db.ListChapters(ctx, docID)
+	require.NoError(t, err)
+
+	reorder := func(ids ...string) proto.BaseResponse {
+		body, err := json.Marshal(api.ReorderChaptersArgs{ChapterIDs: ids})
+		require.NoError(t, err)
+		req := httptest.NewRequest(http.MethodPost, "/v1/documents/"+docID+"/chapters:reorder", bytes.NewReader(body))
+		req.Header.Set("Content-Type", "application/json")
+		w := httptest.NewRecorder()
+		router.ServeHTTP(w, req)
+		var resp proto.BaseResponse
+		require.NoError(t, json.Unmarshal(w.Body.Bytes(), &resp))
+		return resp
+	}
+
+	resp := reorder(chapters[1].ID, chapters[0].ID)
+	require.Equal(t, http.StatusOK, resp.Code)
+	data, err := json.Marshal(resp.Data)
+	require.NoError(t, err)
+	var result api.ListChaptersResult
+	require.NoError(t, json.Unmarshal(data, &result))
+	require.Len(t, result.Chapters, 2)
+	assert.Equal(t, chapters[1].ID, result.Chapters[0].ID)
+	assert.Equal(t, 0, result.Chapters[0].Index)
+
+	assert.Equal(t, http.StatusBadRequest, reorder(chapters[0].ID).Code)
+	assert.Equal(t, http.StatusBadRequest, reorder(chapters[0].ID, chapters[0].ID).Code)
+
+	// 拆分场景期间不允许重排
+	require.NoError(t, service.db.UpdateDocumentStatus(ctx, docID, db.DocumentStatusRoleReady))
+	assert.Equal(t, http.StatusConflict, reorder(chapters[0].ID, chapters[1].ID).Code)
+}
+
+func TestSplitChapter(t *testing.T) {
+	service, cleanup := setupTestService(t)
+	defer cleanup()
+
+	router := service.RegisterRouter(os.Stdout)
+	ctx := context.Background()
+
+	docID := db.MakeUUID()
+	_, err := service.db.CreateDocument(ctx, docID, "file-id", &api.CreateDocumentArgs{Name: "拆分文档"})
+	require.NoError(t, err)
+	require.NoError(t, service.db.CreateChapters(ctx, docID, []string{"他走进房间。她推开窗户。第二章 雨夜他们出发了。", "第三章"}))
+	chapters, err := service.db.ListChapters(ctx, docID)
+	require.NoError(t, err)
+	// 前两个场景能在原文中找到位置，最后一个按均匀分布估计在章节末尾
+	require.NoError(t, service.db.CreateScenes(ctx, []db.Scene{
+		{ID: "s0", ChapterID: chapters[0].ID, DocumentID: docID, Index: 0, Content: "他走进房间"},
+		{ID: "s1", ChapterID: chapters[0].ID, DocumentID: docID, Index: 1, Content: "她推开窗户"},
+		{ID: "s2", ChapterID: chapters[0].ID, DocumentID: docID, Index: 2, Content: "三人在雨中策马远去"},
+	}))
+
+	split := func(id string, args api.SplitChapterArgs) proto.BaseResponse {
+		body, err := json.Marshal(args)
+		require.NoError(t, err)
+		req := httptest.NewRequest(http.MethodPost, "/v1/documents/"+docID+"/chapters/"+id, bytes.NewReader(body))
+		req.Header.Set("Content-Type", "application/json")
+		w := httptest.NewRecorder()
+		router.ServeHTTP(w, req)
+		var resp proto.BaseResponse
+		require.NoError(t, json.Unmarshal(w.Body.Bytes(), &resp))
+		return resp
+	}
+	offset := func(n int) *int { return &n }
+
+	resp := split(chapters[0].ID+":split", api.SplitChapterArgs{Marker: "第二章"})
+	require.Equal(t, http.StatusOK, resp.Code, resp.Message)
+	data, err := json.Marshal(resp.Data)
+	require.NoError(t, err)
+	var result api.ListChaptersResult
+	require.NoError(t, json.Unmarshal(data, &result))
+	require.Len(t, result.Chapters, 3)
+	assert.Equal(t, "他走进房间。她推开窗户。", result.Chapters[0].Content)
+	assert.Equal(t, []string{"s0", "s1"}, result.Chapters[0].SceneIDs)
+	assert.Equal(t, "第二章 雨夜他们出发了。", result.Chapters[1].Content)
+	assert.Equal(t, []string{"s2"}, result.Chapters[1].SceneIDs)
+	assert.Equal(t, chapters[1].ID, result.Chapters[2].ID)
+	assert.Equal(t, 2, result.Chapters[2].Index)
+
+	// 按字符偏移拆分
+	resp = split(chapters[0].ID+":split", api.SplitChapterArgs{Offset: offset(6)})
+	require.Equal(t, http.StatusOK, resp.Code, resp.Message)
+	got, err := service.db.GetChapter(ctx, chapters[0].ID, docID)
+	require.NoError(t, err)
+	assert.Equal(t, "他走进房间。", got.Content)
+	assert.Equal(t, []string{"s0"}, got.SceneIDs)
+
+	assert.Equal(t, http.StatusBadRequest, split(chapters[0].ID+":split", api.SplitChapterArgs{}).Code)
+	assert.Equal(t, http.StatusBadRequest, split(chapters[0].ID+":split", api.SplitChapterArgs{Offset: offset(6)}).Code)
+	assert.Equal(t, http.StatusBadRequest, split(chapters[0].ID+":split", api.SplitChapterArgs{Marker: "不存在"}).Code)
+	assert.Equal(t, http.StatusNotFound, split("other:split", api.SplitChapterArgs{Offset: offset(1)}).Code)
+	assert.Equal(t, http.StatusNotFound, split(chapters[0].ID+":merge", api.SplitChapterArgs{Offset: offset(1)}).Code)
+
+	// 拆分场景期间不允许拆分章节
+	require.NoError(t, service.db.UpdateDocumentStatus(ctx, docID, db.DocumentStatusRoleReady))
+	assert.Equal(t, http.StatusConflict, split(chapters[1].ID+":split", api.SplitChapterArgs{Offset: offset(1)}).Code)
+}
+
+func TestChapterCover(t *testing.T) {
+	service, cleanup := setupTestService(t)
+	defer cleanup()
+
+	router := service.RegisterRouter(os.Stdout)
+	ctx := context.Background()
+
+	docID := db.MakeUUID()
+	_, err := service.db.CreateDocument(ctx, docID, "file-id", &api.CreateDocumentArgs{Name: "封面文档"})
+	require.NoError(t, err)
+	require.NoError(t, service.db.CreateChapters(ctx, docID, []string{"第一章", "第二章"}))
+	chapters, err := service.db.ListChapters(ctx, docID)
+	require.NoError(t, err)
+	// 占位图和未生成的场景不作为封面
+	require.NoError(t, service.db.CreateScenes(ctx, []db.Scene{
+		{ID: "s0", ChapterID: chapters[0].ID, DocumentID: docID, Index: 0, Status: db.SceneStatusReady, ImageURL: "http://img/s0.png", Placeholder: true},
+		{ID: "s1", ChapterID: chapters[0].ID, DocumentID: docID, Index: 1, Status: db.SceneStatusReady, ImageURL: "http://img/s1.png"},
+		{ID: "s2", ChapterID: chapters[0].ID, DocumentID: docID, Index: 2, Status: db.SceneStatusReady, ImageURL: "http://img/s2.png"},
+		{ID: "s3", ChapterID: chapters[0].ID, DocumentID: docID, Index: 3},
+		{ID: "s4", ChapterID: chapters[1].ID, DocumentID: docID, Index: 4, Status: db.SceneStatusFailed},
+	}))
+	require.NoError(t, service.db.UpdateChapterSceneIDs(ctx, chapters[0].ID, []string{"s0", "s1", "s2", "s3"}))
+	require.NoError(t, service.db.UpdateChapterSceneIDs(ctx, chapters[1].ID, []string{"s4"}))
+
+	require.NoError(t, refreshChapterCovers(ctx, service.db, docID))
+	list, err := service.ListChapters(ctx, docID, time.Time{})
+	require.NoError(t, err)
+	assert.Equal(t, "http://img/s1.png", list.Chapters[0].CoverURL)
+	assert.Empty(t, list.Chapters[0].CoverSceneID)
+	assert.Empty(t, list.Chapters[1].CoverURL)
+
+	setCover := func(id string, sceneID string) proto.BaseResponse {
+		body, err := json.Marshal(api.SetChapterCoverArgs{SceneID: sceneID})
+		require.NoError(t, err)
+		req := httptest.NewRequest(http.MethodPost, "/v1/documents/"+docID+"/chapters/"+id+":setCover", bytes.NewReader(body))
+		req.Header.Set("Content-Type", "application/json")
+		w := httptest.NewRecorder()
+		router.ServeHTTP(w, req)
+		var resp proto.BaseResponse
+		require.NoError(t, json.Unmarshal(w.Body.Bytes(), &resp))
+		return resp
+	}
+
+	// 手动选择后重新自动选择时保留
+	resp := setCover(chapters[0].ID, "s2")
+	require.Equal(t, http.StatusOK, resp.Code, resp.Message)
+	assert.Equal(t, "http://img/s2.png", resp.Data.(map[string]any)["cover_url"])
+	assert.Equal(t, "s2", resp.Data.(map[string]any)["cover_scene_id"])
+	require.NoError(t, refreshChapterCovers(ctx, service.db, docID))
+	got, err := service.db.GetChapter(ctx, chapters[0].ID, docID)
+	require.NoError(t, err)
+	assert.Equal(t, "http://img/s2.png", got.CoverURL)
+
+	// 播放清单（导出的 manifest.json 相同）按章节顺序带封面和开始时间
+	manifest := buildManifest(&db.Document{ID: docID}, []db.Chapter{chapters[1], got}, []db.Scene{
+		{ID: "s4", ChapterID: chapters[1].ID, Index: 4, DisplayDurationMs: 3000},
+		{ID: "s1", ChapterID: chapters[0].ID, Index: 1, DisplayDurationMs: 5000},
+	})
+	require.Len(t, manifest.Chapters, 2)
+	assert.Equal(t, chapters[0].ID, manifest.Chapters[0].ID)
+	assert.Equal(t, "http://img/s2.png", manifest.Chapters[0].CoverURL)
+	assert.Equal(t, int64(5000), manifest.Chapters[1].StartMs)
+
+	assert.Equal(t, http.StatusConflict, setCover(chapters[0].ID, "s0").Code)
+	assert.Equal(t, http.StatusConflict, setCover(chapters[0].ID, "s3").Code)
+	assert.Equal(t, http.StatusBadRequest, setCover(chapters[0].ID, "s4").Code)
+	assert.Equal(t, http.StatusNotFound, setCover("other", "s1").Code)
+
+	// scene_id 为空时恢复自动选择
+	resp = setCover(chapters[0].ID, "")
+	require.Equal(t, http.StatusOK, resp.Code, resp.Message)
+	assert.Equal(t, "http://img/s1.png", resp.Data.(map[string]any)["cover_url"])
+	assert.Empty(t, resp.Data.(map[string]any)["cover_scene_id"])
+}
+
+func TestCreateScene(t *testing.T) {
+	service, cleanup := setupTestService(t)
+	defer cleanup()
+
+	router := service.RegisterRouter(os.Stdout)
+	ctx := context.Background()
+
+	docID := db.MakeUUID()
+	_, err := service.db.CreateDocument(ctx, docID, "file-id", &api.CreateDocumentArgs{Name: "插入场景文档"})
+	require.NoError(t, err)
+	require.NoError(t, service.db.CreateChapters(ctx, docID, []string{"第一章", "第二章"}))
+	chapters, err := service.db.ListChapters(ctx, docID)
+	require.NoError(t, err)
+	require.NoError(t, service.db.CreateScenes(ctx, []db.Scene{
+		{ID: "s0", ChapterID: chapters[0].ID, DocumentID: docID, Index: 0, ImageURL: "http://img/0", Status: db.SceneStatusReady},
+		{ID: "s1", ChapterID: chapters[1].ID, DocumentID: docID, Index: 1, ImageURL: "http://img/1", Status: db.SceneStatusReady},
+	}))
+	require.NoError(t, service.db.UpdateChapterSceneIDs(ctx, chapters[0].ID, []string{"s0"}))
+	require.NoError(t, service.db.UpdateChapterSceneIDs(ctx, chapters[1].ID, []string{"s1"}))
+
+	create := func(chapterID string, args api.CreateSceneArgs) proto.BaseResponse {
+		body, err := json.Marshal(args)
+		require.NoError(t, err)
+		req := httptest.NewRequest(http.MethodPost, "/v1/chapters/"+chapterID+"/scenes", bytes.NewReader(body))
+		req.Header.Set("Content-Type", "application/json")
+		w := httptest.NewRecorder()
+		router.ServeHTTP(w, req)
+		var resp proto.BaseResponse
+		require.NoError(t, json.Unmarshal(w.Body.Bytes(), &resp))
+		return resp
+	}
+
+	// 场景切分完成前不能插入
+	require.NoError(t, service.db.UpdateDocumentStatus(ctx, docID, db.DocumentStatusRoleReady))
+	assert.Equal(t, http.StatusConflict, create(chapters[0].ID, api.CreateSceneArgs{Content: "新场景"}).Code)
+
+	// 不立即生成时文档状态不变
+	require.NoError(t, service.db.UpdateDocumentStatus(ctx, docID, db.DocumentStatusImgReady))
+	resp := create(chapters[0].ID, api.CreateSceneArgs{InsertAfter: "s0", Content: "新场景", Overrides: &api.SceneOverrides{Style: "水彩"}})
+	require.Equal(t, http.StatusOK, resp.Code, resp.Message)
+	data, err := json.Marshal(resp.Data)
+	require.NoError(t, err)
+	var scene api.Scene
+	require.NoError(t, json.Unmarshal(data, &scene))
+	assert.Equal(t, 1, scene.Index)
+	assert.Equal(t, docID, scene.DocumentID)
+	assert.Empty(t, scene.ImageURL)
+	require.NotNil(t, scene.Overrides)
+	assert.Equal(t, "水彩", scene.Overrides.Style)
+	doc, err := service.db.GetDocument(ctx, docID)
+	require.NoError(t, err)
+	assert.Equal(t, db.DocumentStatusImgReady, doc.Status)
+
+	// 立即生成时文档回到图片生成阶段，新场景待生成
+	resp = create(chapters[1].ID, api.CreateSceneArgs{Content: "开场", Generate: true})
+	require.Equal(t, http.StatusOK, resp.Code, resp.Message)
+	doc, err = service.db.GetDocument(ctx, docID)
+	require.NoError(t, err)
+	assert.Equal(t, db.DocumentStatusSceneReady, doc.Status)
+	pending, err := service.db.ListPendingImageScenes(ctx, docID)
+	require.NoError(t, err)
+	require.Len(t, pending, 2)
+	assert.Equal(t, []int{1, 2}, []int{pending[0].Index, pending[1].Index})
+	got, err := service.db.GetChapter(ctx, chapters[1].ID, docID)
+	require.NoError(t, err)
+	assert.Equal(t, []string{pending[1].ID, "s1"}, got.SceneIDs)
+
+	assert.Equal(t, http.StatusBadRequest, create(chapters[0].ID, api.CreateSceneArgs{InsertAfter: "s1", Content: "新场景"}).Code)
+	assert.Equal(t, http.StatusBadRequest, create(chapters[0].ID, api.CreateSceneArgs{}).Code)
+	assert.Equal(t, http.StatusBadRequest, create(chapters[0].ID, api.CreateSceneArgs{Content: "新场景", Overrides: &api.SceneOverrides{ImageSize: "large"}}).Code)
+	assert.Equal(t, http.StatusNotFound, create("other", api.CreateSceneArgs{Content: "新场景"}).Code)
+}
+
+func TestExtractRoles(t *testing.T) {
+	service, cleanup := setupTestService(t)
+	defer cleanup()
+	ctx := context.Background()
+
+	var calls atomic.Int32
+	bailianServer := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		var req bailian.ChatCompletionRequest
+		require.NoError(t, json.NewDecoder(r.Body).Decode(&req))
+		prompt := req.Messages[len(req.Messages)-1].Content
+		assert.Contains(t, prompt, "小说内容")
+		roles := `[{"name":"李雷","gender":"男","character":"模型描述","appearance":"短发"},{"name":"韩梅梅","gender":"女","character":"","appearance":"未知"}]`
+		if calls.Add(1) == 2 {
+			assert.Contains(t, prompt, "第二章")
+			assert.NotContains(t, prompt, "第一章")
+			roles = `[{"name":"韩梅梅","gender":"女","character":"文静","appearance":"长发"},{"name":" ","gender":"男"}]`
+		}
+		content, err := json.Marshal(roles)
+		require.NoError(t, err)
+		fmt.Fprintf(w, `{"choices":[{"message":{"role":"assistant","content":%s}}]}`, content)
+	}))
+	defer bailianServer.Close()
+	var err error
+	service.bailianClient, err = bailian.NewClient(bailian.Config{BaseURL: bailianServer.URL, APIKey: "test"})
+	require.NoError(t, err)
+	router := service.RegisterRouter(os.Stdout)
+
+	extract := func(docID string) proto.BaseResponse {
+		req := httptest.NewRequest(http.MethodPost, "/v1/documents/"+docID+"/roles:extract", nil)
+		w := httptest.NewRecorder()
+		router.ServeHTTP(w, req)
+		var resp proto.BaseResponse
+		require.NoError(t, json.Unmarshal(w.Body.Bytes(), &resp))
+		return resp
+	}
+
+	docID := db.MakeUUID()
+	_, err = service.db.CreateDocument(ctx, docID, "file-id", &api.CreateDocumentArgs{Name: "重新提取"})
+	require.NoError(t, err)
+	// 两章合计超过批次字数，分两次提取
+	long := "第一章" + strings.Repeat("字", roleExtractBatchRunes-10)
+	require.NoError(t, service.db.CreateChapters(ctx, docID, []string{long, "第二章 韩梅梅出场"}))
+
+	// 角色提取阶段未完成时由流水线提取
+	assert.Equal(t, http.StatusConflict, extract(docID).Code)
+	assert.Zero(t, calls.Load())
+
+	require.NoError(t, service.db.CreateRoles(ctx, []db.Role{{ID: "r1", DocumentID: docID, Name: "李雷", Gender: "男", Character: "人工修改"}}))
+	require.NoError(t, service.db.UpdateDocumentStatus(ctx, docID, db.DocumentStatusImgReady))
+	resp := extract(docID)
+	require.Equal(t, http.StatusOK, resp.Code, resp.Message)
+	assert.EqualValues(t, 2, calls.Load())
+	data, err := json.Marshal(resp.Data)
+	require.NoError(t, err)
+	var ret api.ExtractRolesResult
+	require.NoError(t, json.Unmarshal(data, &ret))
+	assert.Equal(t, 1, ret.Created)
+	assert.Equal(t, 1, ret.Updated)
+	require.Len(t, ret.Roles, 2)
+
+	roles, err := service.db.ListRolesByDocument(ctx, docID)
+	require.NoError(t, err)
+	require.Len(t, roles, 2)
+	byName := map[string]db.Role{}
+	for _, r := range roles {
+		byName[r.Name] = r
+	}
+	// 已有角色只补全空字段
+	assert.Equal(t, "r1", byName["李雷"].ID)
+	assert.Equal(t, "人工修改", byName["李雷"].Character)
+	assert.Equal(t, "短发", byName["李雷"].Appearance)
+	// 多批中出现的新角色合并为一条
+	assert.Equal(t, "文静", byName["韩梅梅"].Character)
+	assert.Equal(t, "长发", byName["韩梅梅"].Appearance)
+
+	// 再次提取不重复创建
+	calls.Store(0)
+	resp = extract(docID)
+	require.Equal(t, http.StatusOK, resp.Code, resp.Message)
+	data, err = json.Marshal(resp.Data)
+	require.NoError(t, err)
+	ret = api.ExtractRolesResult{}
+	require.NoError(t, json.Unmarshal(data, &ret))
+	assert.Zero(t, ret.Created)
+	assert.Zero(t, ret.Updated)
+	assert.Len(t, ret.Roles, 2)
+
+	assert.Equal(t, ErrNoSuchDocumentCode, extract(db.MakeUUID()).Code)
+}
+
+func TestRoleGraph(t *testing.T) {
+	service, cleanup := setupTestService(t)
+	defer cleanup()
+	ctx := context.Background()
+
+	var calls atomic.Int32
+	bailianServer := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		calls.Add(1)
+		var req bailian.ChatCompletionRequest
+		require.NoError(t, json.NewDecoder(r.Body).Decode(&req))
+		assert.Contains(t, req.Messages[len(req.Messages)-1].Content, "祥子、虎妞、刘四")
+		relations := `[{"source":"刘四","target":"虎妞","type":"Parent","description":"刘四是虎妞的父亲"},` +
+			`{"source":"虎妞","target":"刘四","type":"child","description":"重复的关系"},` +
+			`{"source":"祥子","target":"虎妞","type":"husband","description":"祥子娶了虎妞"},` +
+			`{"source":"祥子","target":"小福子","type":"lover","description":"不在角色列表中"},` +
+			`{"source":"祥子","target":"祥子","type":"other","description":"指向自身"}]`
+		content, err := json.Marshal("```json\n" + relations + "\n```")
+		require.NoError(t, err)
+		fmt.Fprintf(w, `{"choices":[{"message":{"role":"assistant","content":%s}}]}`, content)
+	}))
+	defer bailianServer.Close()
+	var err error
+	service.bailianClient, err = bailian.NewClient(bailian.Config{BaseURL: bailianServer.URL, APIKey: "test"})
+	require.NoError(t, err)
+	service.documentMgr, err = newDocumentMgr(DocumentConfigEx{config: DocumentConfig{Enable: true}, db: service.db, quota: service.quota}, service.bailianClient)
+	require.NoError(t, err)
+	router := service.RegisterRouter(os.Stdout)
+
+	docID := db.MakeUUID()
+	_, err = service.db.CreateDocument(ctx, docID, "file-id", &api.CreateDocumentArgs{Name: "骆驼祥子"})
+	require.NoError(t, err)
+	require.NoError(t, service.db.UpdateDocumentSummary(ctx, docID, "祥子在北平拉车"))
+	require.NoError(t, service.db.CreateRoles(ctx, []db.Role{
+		{ID: "r0", DocumentID: docID, Name: "祥子", Gender: "男"},
+		{ID: "r1", DocumentID: docID, Name: "虎妞", Gender: "女"},
+		{ID: "r2", DocumentID: docID, Name: "刘四", Gender: "男"},
+	}))
+
+	getGraph := func(id string) (int, api.RoleGraph) {
+		req := httptest.NewRequest(http.MethodGet, "/v1/documents/"+id+"/roles/graph", nil)
+		w := httptest.NewRecorder()
+		router.ServeHTTP(w, req)
+		var resp proto.BaseResponse
+		require.NoError(t, json.Unmarshal(w.Body.Bytes(), &resp))
+		data, err := json.Marshal(resp.Data)
+		require.NoError(t, err)
+		var graph api.RoleGraph
+		require.NoError(t, json.Unmarshal(data, &graph))
+		return resp.Code, graph
+	}
+
+	// 尚未提取关系时只有角色
+	code, graph := getGraph(docID)
+	require.Equal(t, http.StatusOK, code)
+	assert.Len(t, graph.Nodes, 3)
+	assert.Empty(t, graph.Edges)
+
+	// 角色已存在时角色阶段只提取关系，再次执行不重复提取
+	doc, err := service.db.GetDocument(ctx, docID)
+	require.NoError(t, err)
+	require.NoError(t, service.documentMgr.HandleDocumentRole(ctx, doc))
+	require.NoError(t, service.documentMgr.HandleDocumentRole(ctx, doc))
+	assert.Equal(t, int32(1), calls.Load())
+
+	code, graph = getGraph(docID)
+	require.Equal(t, http.StatusOK, code)
+	assert.ElementsMatch(t, []api.RoleGraphEdge{
+		{Source: "r2", Target: "r1", Type: "parent", Description: "刘四是虎妞的父亲"},
+		{Source: "r0", Target: "r1", Type: "other", Description: "祥子娶了虎妞"},
+	}, graph.Edges)
+	degrees := map[string]int{}
+	for _, n := range graph.Nodes {
+		degrees[n.Name] = n.Degree
+	}
+	assert.Equal(t, map[string]int{"祥子": 1, "虎妞": 2, "刘四": 1}, degrees)
+
+	code, _ = getGraph(db.MakeUUID())
+	assert.Equal(t, ErrNoSuchDocumentCode, code)
+}
+
+func TestMergeRoles(t *testing.T) {
+	service, cleanup := setupTestService(t)
+	defer cleanup()
+
+	router := service.RegisterRouter(os.Stdout)
+	ctx := context.Background()
+
+	bailianServer := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte(`{"choices":[{"message":{"content":"{\"name\":\"李雷同学\",\"gender\":\"男\",\"character\":\"开朗\",\"appearance\":\"少年时瘦高，成年后健壮\"}"}}]}`))
+	}))
+	defer bailianServer.Close()
+	var err error
+	service.bailianClient, err = bailian.NewClient(bailian.Config{BaseURL: bailianServer.URL, APIKey: "test"})
+	require.NoError(t, err)
+
+	docID := db.MakeUUID()
+	_, err = service.db.CreateDocument(ctx, docID, "file-id", &api.CreateDocumentArgs{Name: "角色文档"})
+	require.NoError(t, err)
+	require.NoError(t, service.db.CreateRoles(ctx, []db.Role{
+		{ID: "r0", DocumentID: docID, Name: "李雷", Gender: "男", Appearance: "健壮"},
+		{ID: "r1", DocumentID: docID, Name: "李雷（少年）", Character: "调皮", Appearance: "瘦高"},
+		{ID: "r2", DocumentID: docID, Name: "雷哥"},
+		{ID: "r3", DocumentID: docID, Name: "韩梅梅"},
+	}))
+	require.NoError(t, service.db.CreateScenes(ctx, []db.Scene{
+		{ID: "s0", DocumentID: docID, Content: "李雷（少年）和雷哥在操场上奔跑"},
+	}))
+
+	merge := func(args api.MergeRolesArgs) (int, api.Role) {
+		body, err := json.Marshal(args)
+		require.NoError(t, err)
+		req := httptest.NewRequest(http.MethodPost, "/v1/documents/"+docID+"/roles:merge", bytes.NewReader(body))
+		req.Header.Set("Content-Type", "application/json")
+		w := httptest.NewRecorder()
+		router.ServeHTTP(w, req)
+		var resp proto.BaseResponse
+		require.NoError(t, json.Unmarshal(w.Body.Bytes(), &resp))
+		data, err := json.Marshal(resp.Data)
+		require.NoError(t, err)
+		var role api.Role
+		require.NoError(t, json.Unmarshal(data, &role))
+		return resp.Code, role
+	}
+
+	// 只补全空字段，场景中的来源角色名改为目标角色名
+	code, role := merge(api.MergeRolesArgs{TargetID: "r0", SourceIDs: []string{"r1", "r2"}})
+	require.Equal(t, http.StatusOK, code)
+	assert.Equal(t, "李雷", role.Name)
+	assert.Equal(t, "健壮", role.Appearance)
+	assert.Equal(t, "调皮", role.Character)
+	scene, err := service.db.GetScene(ctx, "s0")
+	require.NoError(t, err)
+	assert.Equal(t, "李雷和李雷在操场上奔跑", scene.Content)
+	roles, err := service.db.ListRolesByDocument(ctx, docID)
+	require.NoError(t, err)
+	assert.Len(t, roles, 2)
+
+	code, _ = merge(api.MergeRolesArgs{TargetID: "r0", SourceIDs: []string{"r1"}})
+	assert.Equal(t, http.StatusNotFound, code)
+	code, _ = merge(api.MergeRolesArgs{TargetID: "r0", SourceIDs: []string{"r0"}})
+	assert.Equal(t, http.StatusBadRequest, code)
+
+	// 大模型综合描述，姓名保持目标角色
+	code, role = merge(api.MergeRolesArgs{TargetID: "r3", SourceIDs: []string{"r0"}, Consolidate: true})
+	require.Equal(t, http.StatusOK, code)
+	assert.Equal(t, "韩梅梅", role.Name)
+	assert.Equal(t, "少年时瘦高，成年后健壮", role.Appearance)
+
+	assert.Equal(t, "李雷（少年）", roleNameRewriter("李雷（少年）", []db.Role{{Name: "李雷"}})("李雷（少年）"))
+}
+
+func TestGetManifest(t *testing.T) {
+	service, cleanup := setupTestService(t)
+	defer cleanup()
+
+	router := service.RegisterRouter(os.Stdout)
+	ctx := context.Background()
+
+	docID := db.MakeUUID()
+	_, err := service.db.CreateDocument(ctx, docID, "file-id", &api.CreateDocumentArgs{Name: "清单文档"})
+	require.NoError(t, err)
+
+	chapterID := db.MakeUUID()
+	scenes := []db.Scene{
+		{ID: db.MakeUUID(), ChapterID: chapterID, DocumentID: docID, Index: 1, Content: "场景2", AudioDurationMs: 4000, DisplayDurationMs: 5000},
+		{ID: db.MakeUUID(), ChapterID: chapterID, DocumentID: docID, Index: 0, Content: "场景1", AudioDurationMs: 1500, DisplayDurationMs: 3000},
+	}
+	require.NoError(t, service.db.CreateScenes(ctx, scenes))
+
+	req := httptest.NewRequest(http.MethodGet, "/v1/documents/"+docID+"/manifest", nil)
+	w := httptest.NewRecorder()
+	router.ServeHTTP(w, req)
+
+	var resp proto.BaseResponse
+	require.NoError(t, json.Unmarshal(w.Body.Bytes(), &resp))
+	require.Equal(t, http.StatusOK, resp.Code)
+
+	data, err := json.Marshal(resp.Data)
+	require.NoError(t, err)
+	var manifest api.Manifest
+	require.NoError(t, json.Unmarshal(data, &manifest))
+
+	require.Len(t, manifest.Scenes, 2)
+	assert.Equal(t, "场景1", manifest.Scenes[0].Content)
+	assert.Equal(t, int64(0), manifest.Scenes[0].StartMs)
+	assert.Equal(t, int64(3000), manifest.Scenes[1].StartMs)
+	assert.Equal(t, int64(4000), manifest.Scenes[1].AudioDurationMs)
+	assert.Equal(t, int64(8000), manifest.TotalDurationMs)
+}
+
+func TestListUpdatedSince(t *testing.T) {
+	service, cleanup := setupTestService(t)
+	defer cleanup()
+
+	router := service.RegisterRouter(os.Stdout)
+	ctx := context.Background()
+	get := func(path string) proto.BaseResponse {
+		req := httptest.NewRequest(http.MethodGet, path, nil)
+		w := httptest.NewRecorder()
+		router.ServeHTTP(w, req)
+		var resp proto.BaseResponse
+		require.NoError(t, json.Unmarshal(w.Body.Bytes(), &resp))
+		return resp
+	}
+
+	oldID, newID := db.MakeUUID(), db.MakeUUID()
+	_, err := service.db.CreateDocument(ctx, oldID, "file-id", &api.CreateDocumentArgs{Name: "旧文档"})
+	require.NoError(t, err)
+	_, err = service.db.CreateDocument(ctx, newID, "file-id", &api.CreateDocumentArgs{Name: "新文档"})
+	require.NoError(t, err)
+	sceneID := db.MakeUUID()
+	require.NoError(t, service.db.CreateScenes(ctx, []db.Scene{
+		{ID: db.MakeUUID(), ChapterID: db.MakeUUID(), DocumentID: newID, Index: 0, Content: "场景1"},
+		{ID: sceneID, ChapterID: db.MakeUUID(), DocumentID: newID, Index: 1, Content: "场景2"},
+	}))
+
+	time.Sleep(10 * time.Millisecond)
+	since := time.Now().Format(time.RFC3339Nano)
+	require.NoError(t, service.db.UpdateDocumentStatus(ctx, newID, db.DocumentStatusRoleReady))
+	require.NoError(t, service.db.UpdateSceneImageURL(ctx, sceneID, "http://img/2"))
+
+	resp := get("/v1/documents?updated_since=" + url.QueryEscape(since))
+	require.Equal(t, http.StatusOK, resp.Code, resp.Message)
+	data, err := json.Marshal(resp.Data)
+	require.NoError(t, err)
+	var docs api.ListDocumentsResult
+	require.NoError(t, json.Unmarshal(data, &docs))
+	require.Len(t, docs.Documents, 1)
+	assert.Equal(t, newID, docs.Documents[0].ID)
+
+	resp = get("/v1/documents/" + newID + "/scenes?updated_since=" + url.QueryEscape(since))
+	require.Equal(t, http.StatusOK, resp.Code, resp.Message)
+	data, err = json.Marshal(resp.Data)
+	require.NoError(t, err)
+	var scenes api.ListScenesResult
+	require.NoError(t, json.Unmarshal(data, &scenes))
+	require.Len(t, scenes.Scenes, 1)
+	assert.Equal(t, sceneID, scenes.Scenes[0].ID)
+
+	// 不传时返回全部，格式错误返回 400
+	data, err = json.Marshal(get("/v1/documents").Data)
+	require.NoError(t, err)
+	require.NoError(t, json.Unmarshal(data, &docs))
+	assert.Len(t, docs.Documents, 2)
+	assert.Equal(t, http.StatusBadRequest, get("/v1/documents/"+newID+"/chapters?updated_since=yesterday").Code)
+}
+
+func TestListDocumentsFilter(t *testing.T) {
+	service, cleanup := setupTestService(t)
+	defer cleanup()
+
+	router := service.RegisterRouter(os.Stdout)
+	ctx := context.Background()
+	list := func(query string) (int, []string) {
+		req := httptest.NewRequest(http.MethodGet, "/v1/documents?"+query, nil)
+		w := httptest.NewRecorder()
+		router.ServeHTTP(w, req)
+		var resp proto.BaseResponse
+		require.NoError(t, json.Unmarshal(w.Body.Bytes(), &resp))
+		data, err := json.Marshal(resp.Data)
+		require.NoError(t, err)
+		var docs api.ListDocumentsResult
+		require.NoError(t, json.Unmarshal(data, &docs))
+		var names []string
+		for _, d := range docs.Documents {
+			names = append(names, d.Name)
+		}
+		return resp.Code, names
+	}
+
+	statuses := map[string]string{"甲": db.DocumentStatusFailed, "乙": db.DocumentStatusSceneReady, "丙": db.DocumentStatusImgReady, "丁": db.DocumentStatusChapterReady}
+	for _, name := range []string{"甲", "乙", "丙", "丁"} {
+		id := db.MakeUUID()
+		_, err := service.db.CreateDocument(ctx, id, "file-id", &api.CreateDocumentArgs{Name: name})
+		require.NoError(t, err)
+		require.NoError(t, service.db.UpdateDocumentStatus(ctx, id, statuses[name]))
+		time.Sleep(10 * time.Millisecond)
+	}
+
+	code, names := list("status=processing,failed&sort_by=created_at&order=asc")
+	require.Equal(t, http.StatusOK, code)
+	assert.Equal(t, []string{"甲", "乙", "丁"}, names)
+	code, names = list("status=imgReady")
+	require.Equal(t, http.StatusOK, code)
+	assert.Equal(t, []string{"丙"}, names)
+	// 默认按更新时间倒序
+	code, names = list("")
+	require.Equal(t, http.StatusOK, code)
+	assert.Equal(t, []string{"丁", "丙", "乙", "甲"}, names)
+
+	for _, query := range []string{"status=done", "sort_by=status", "order=up"} {
+		code, _ = list(query)
+		assert.Equal(t, http.StatusBadRequest, code, query)
+	}
+}
+
+func TestETag(t *testing.T) {
+	service, cleanup := setupTestService(t)
+	defer cleanup()
+
+	router := service.RegisterRouter(os.Stdout)
+	ctx := context.Background()
+	get := func(path, ifNoneMatch string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest(http.MethodGet, path, nil)
+		if ifNoneMatch != "" {
+			req.Header.Set(ifNoneMatchHeader, ifNoneMatch)
+		}
+		w := httptest.NewRecorder()
+		router.ServeHTTP(w, req)
+		return w
+	}
+
+	docID := db.MakeUUID()
+	_, err := service.db.CreateDocument(ctx, docID, "file-id", &api.CreateDocumentArgs{Name: "缓存文档"})
+	require.NoError(t, err)
+	require.NoError(t, service.db.CreateChapters(ctx, docID, []string{"第一章", "第二章"}))
+	chapters, err := service.db.ListChapters(ctx, docID)
+	require.NoError(t, err)
+	sceneID := db.MakeUUID()
+	require.NoError(t, service.db.CreateScenes(ctx, []db.Scene{{ID: sceneID, ChapterID: chapters[0].ID, DocumentID: docID, Content: "场景"}}))
+
+	paths := []string{
+		"/v1/documents",
+		"/v1/documents/" + docID,
+		"/v1/documents/" + docID + "/chapters",
+		"/v1/documents/" + docID + "/chapters/" + chapters[0].ID,
+		"/v1/documents/" + docID + "/scenes",
+		"/v1/chapters/" + chapters[0].ID + "/scenes",
+	}
+	etags := map[string]string{}
+	for _, path := range paths {
+		w := get(path, "")
+		require.Equal(t, http.StatusOK, w.Code, path)
+		etag := w.Header().Get(etagHeader)
+		require.True(t, strings.HasPrefix(etag, `W/"`), path)
+		etags[path] = etag
+
+		// 未变化时返回 304，不返回响应体；强比较形式和多个 ETag 同样匹配
+		w = get(path, etag)
+		assert.Equal(t, http.StatusNotModified, w.Code, path)
+		assert.Empty(t, w.Body.Bytes(), path)
+		assert.Equal(t, etag, w.Header().Get(etagHeader), path)
+		assert.Equal(t, http.StatusNotModified, get(path, `"other", `+strings.TrimPrefix(etag, "W/")).Code, path)
+		assert.Equal(t, http.StatusOK, get(path, `W/"other"`).Code, path)
+	}
+
+	// 更新场景后场景列表的 ETag 变化，章节不受影响
+	require.NoError(t, service.db.UpdateSceneImageURL(ctx, sceneID, "http://img/1"))
+	for _, path := range paths[4:] {
+		w := get(path, etags[path])
+		assert.Equal(t, http.StatusOK, w.Code, path)
+		assert.NotEqual(t, etags[path], w.Header().Get(etagHeader), path)
+	}
+	assert.Equal(t, http.StatusNotModified, get(paths[3], etags[paths[3]]).Code)
+
+	// 章节更新后章节列表的 ETag 变化，其他章节不受影响
+	require.NoError(t, service.db.UpdateChapter(ctx, chapters[1].ID, &api.UpdateChapterArgs{Content: "新的第二章"}))
+	assert.Equal(t, http.StatusOK, get(paths[2], etags[paths[2]]).Code)
+	assert.Equal(t, http.StatusNotModified, get(paths[3], etags[paths[3]]).Code)
+
+	// 带 lang 时内容还依赖译文，不返回 ETag
+	w := get(paths[3]+"?lang=en", etags[paths[3]])
+	assert.Equal(t, http.StatusOK, w.Code)
+	assert.Empty(t, w.Header().Get(etagHeader))
+}
+
+func TestBulkImport(t *testing.T) {
+	service, cleanup := setupTestService(t)
+	defer cleanup()
+
+	bailianServer := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte(`{"id":"file-x"}`))
+	}))
+	defer bailianServer.Close()
+	bailianClient, err := bailian.NewClient(bailian.Config{BaseURL: bailianServer.URL, APIKey: "test"})
+	require.NoError(t, err)
+	service.bailianClient = bailianClient
+	router := service.RegisterRouter(os.Stdout)
+
+	// 已存在同名文档
+	_, err = service.db.CreateDocument(context.Background(), db.MakeUUID(), "file-id", &api.CreateDocumentArgs{Name: "已存在"})
+	require.NoError(t, err)
+
+	archive := &bytes.Buffer{}
+	zw := zip.NewWriter(archive)
+	for _, name := range []string{"第一卷.txt", "dir/第二卷.md", "dir/第一卷.md", "封面.png", "__MACOSX/._第一卷.txt", ".DS_Store", "已存在.txt"} {
+		fw, err := zw.Create(name)
+		require.NoError(t, err)
+		fw.Write([]byte("第一章\n\n" + name))
+	}
+	require.NoError(t, zw.Close())
+
+	upload := func(filename string, data []byte) (*httptest.ResponseRecorder, api.ImportBatch) {
+		body := &bytes.Buffer{}
+		writer := multipart.NewWriter(body)
+		fw, err := writer.CreateFormFile("file", filename)
+		require.NoError(t, err)
+		fw.Write(data)
+		writer.Close()
+		req := httptest.NewRequest(http.MethodPost, "/v1/documents:bulk", body)
+		req.Header.Set("Content-Type", writer.FormDataContentType())
+		w := httptest.NewRecorder()
+		router.ServeHTTP(w, req)
+		var batch api.ImportBatch
+		var resp proto.BaseResponse
+		require.NoError(t, json.Unmarshal(w.Body.Bytes(), &resp))
+		if resp.Data != nil {
+			data, err := json.Marshal(resp.Data)
+			require.NoError(t, err)
+			require.NoError(t, json.Unmarshal(data, &batch))
+		}
+		return w, batch
+	}
+
+	t.Run("不支持的归档格式", func(t *testing.T) {
+		w, _ := upload("books.rar", archive.Bytes())
+		assert.Contains(t, w.Body.String(), `"code":400`)
+	})
+
+	t.Run("未知操作", func(t *testing.T) {
+		req := httptest.NewRequest(http.MethodPost, "/v1/documents:merge", nil)
+		w := httptest.NewRecorder()
+		router.ServeHTTP(w, req)
+		assert.Contains(t, w.Body.String(), `"code":404`)
+	})
+
+	w, batch := upload("books.zip", archive.Bytes())
+	require.Equal(t, http.StatusAccepted, w.Code)
+	assert.Equal(t, db.ImportBatchStatusRunning, batch.Status)
+	assert.Equal(t, 5, batch.Total)
+
+	// 轮询直到全部处理完成
+	require.Eventually(t, func() bool {
+		req := httptest.NewRequest(http.MethodGet, "/v1/imports/"+batch.ID, nil)
+		w := httptest.NewRecorder()
+		router.ServeHTTP(w, req)
+		var resp proto.BaseResponse
+		require.NoError(t, json.Unmarshal(w.Body.Bytes(), &resp))
+		require.Equal(t, http.StatusOK, resp.Code)
+		data, err := json.Marshal(resp.Data)
+		require.NoError(t, err)
+		require.NoError(t, json.Unmarshal(data, &batch))
+		return batch.Status == db.ImportBatchStatusFinished
+	}, 10*time.Second, 50*time.Millisecond)
+
+	assert.Equal(t, 2, batch.Created)
+	assert.Equal(t, 3, batch.Failed)
+	byFile := make(map[string]api.ImportItem)
+	for _, item := range batch.Items {
+		byFile[item.Filename] = item
+	}
+	assert.Equal(t, db.ImportItemStatusCreated, byFile["第一卷.txt"].Status)
+	assert.Equal(t, db.ImportItemStatusCreated, byFile["dir/第二卷.md"].Status)
+	assert.Equal(t, "duplicate name in archive", byFile["dir/第一卷.md"].Error)
+	assert.Equal(t, "unsupported file type", byFile["封面.png"].Error)
+	assert.Equal(t, ErrExistingDocument, byFile["已存在.txt"].Error)
+
+	doc, err := service.db.GetDocument(context.Background(), byFile["dir/第二卷.md"].DocumentID)
+	if assert.NoError(t, err) {
+		assert.Equal(t, "第二卷", doc.Name)
+	}
+	// 每个创建的文档记录一条审计日志
+	for _, file := range []string{"第一卷.txt", "dir/第二卷.md"} {
+		logs, err := service.db.ListAuditLogs(context.Background(), db.AuditLogFilter{DocumentID: byFile[file].DocumentID}, 0, 10)
+		require.NoError(t, err)
+		require.Len(t, logs, 1)
+		assert.Equal(t, "bulk_import", logs[0].Action)
+		assert.Equal(t, "POST /v1/documents:action", logs[0].Route)
+		assert.NotEmpty(t, logs[0].After)
+	}
+	_, err = os.Stat(filepath.Join(service.conf.Temp, "import_"+batch.ID))
+	assert.True(t, os.IsNotExist(err))
+
+	t.Run("批量导入不存在", func(t *testing.T) {
+		req := httptest.NewRequest(http.MethodGet, "/v1/imports/"+db.MakeUUID(), nil)
+		w := httptest.NewRecorder()
+		router.ServeHTTP(w, req)
+		assert.Contains(t, w.Body.String(), `"code":404`)
+	})
+}
+
+func TestListActivity(t *testing.T) {
+	service, cleanup := setupTestService(t)
+	defer cleanup()
+
+	service.quota = newQuotaMgr(QuotaConfig{
+		Default: api.QuotaLimits{MaxImagesPerDay: 5},
+	}, service.db)
+	router := service.RegisterRouter(os.Stdout)
+	ctx := context.Background()
+
+	// 其他租户的事件不可见
+	recordEvent(ctx, service.db, db.Event{UserID: 1, Type: db.EventDocumentFinished, DocumentID: "other"})
+	recordEvent(ctx, service.db, db.Event{UserID: 0, Type: db.EventDocumentFinished, DocumentID: "doc1"})
+	recordEvent(ctx, service.db, db.Event{UserID: 0, Type: db.EventSceneFlagged, DocumentID: "doc1", SceneID: "scene1"})
+	// 3 -> 4 越过预警线，4 -> 5 达到上限，5 -> 5 不再重复提醒
+	require.NoError(t, service.quota.RecordGeneration(ctx, 0, 3, 0))
+	require.NoError(t, service.quota.RecordGeneration(ctx, 0, 1, 0))
+	require.NoError(t, service.quota.RecordGeneration(ctx, 0, 1, 0))
+
+	list := func(query string) api.ListActivityResult {
+		req := httptest.NewRequest(http.MethodGet, "/v1/activity"+query, nil)
+		w := httptest.NewRecorder()
+		router.ServeHTTP(w, req)
+
+		var resp proto.BaseResponse
+		require.NoError(t, json.Unmarshal(w.Body.Bytes(), &resp))
+		require.Equal(t, http.StatusOK, resp.Code)
+		data, err := json.Marshal(resp.Data)
+		require.NoError(t, err)
+		var result api.ListActivityResult
+		require.NoError(t, json.Unmarshal(data, &result))
+		return result
+	}
+
+	page1 := list("?limit=2")
+	require.Len(t, page1.Activities, 2)
+	assert.Equal(t, db.EventQuotaExceeded, page1.Activities[0].Type)
+	assert.Equal(t, db.EventQuotaWarning, page1.Activities[1].Type)
+	require.NotEmpty(t, page1.NextMarker)
+
+	page2 := list("?limit=2&marker=" + page1.NextMarker)
+	require.Len(t, page2.Activities, 2)
+	assert.Equal(t, db.EventSceneFlagged, page2.Activities[0].Type)
+	assert.Equal(t, "scene1", page2.Activities[0].SceneID)
+	assert.Equal(t, db.EventDocumentFinished, page2.Activities[1].Type)
+	assert.Equal(t, "doc1", page2.Activities[1].DocumentID)
+
+	page3 := list("?limit=2&marker=" + page2.NextMarker)
+	assert.Empty(t, page3.Activities)
+	assert.Empty(t, page3.NextMarker)
+
+	req := httptest.NewRequest(http.MethodGet, "/v1/activity?marker=abc", nil)
+	w := httptest.NewRecorder()
+	router.ServeHTTP(w, req)
+	var resp proto.BaseResponse
+	require.NoError(t, json.Unmarshal(w.Body.Bytes(), &resp))
+	assert.Equal(t, http.StatusBadRequest, resp.Code)
+}
+
+func TestStatsSummary(t *testing.T) {
+	service, cleanup := setupTestService(t)
+	defer cleanup()
+
+	router := service.RegisterRouter(os.Stdout)
+	ctx := context.Background()
+
+	// 已完成、处理中、暂停的文档各一个，另有其他租户的文档
+	docs := make([]*db.Document, 3)
+	for i := range docs {
+		doc, err := service.db.CreateDocument(ctx, db.MakeUUID(), "file-id", &api.CreateDocumentArgs{Name: fmt.Sprintf("统计文档%d", i)})
+		require.NoError(t, err)
+		docs[i] = doc
+	}
+	_, err := service.db.CreateDocument(ctx, db.MakeUUID(), "file-id", &api.CreateDocumentArgs{Name: "其他租户", UserID: 1})
+	require.NoError(t, err)
+	require.NoError(t, service.db.UpdateDocumentStatus(ctx, docs[0].ID, db.DocumentStatusImgReady))
+	require.NoError(t, service.db.UpdateDocumentStatus(ctx, docs[1].ID, db.DocumentStatusSceneReady))
+	require.NoError(t, service.db.UpdateDocumentPaused(ctx, docs[2].ID, true))
+
+	require.NoError(t, service.db.CreateDocumentRun(ctx, &db.DocumentRun{
+		ID:         db.MakeUUID(),
+		DocumentID: docs[0].ID,
+		Status:     db.DocumentStatusImgReady,
+		CreatedAt:  docs[0].CreatedAt.Add(90 * time.Second),
+	}, nil))
+	// 第二次处理不计入平均耗时
+	require.NoError(t, service.db.CreateDocumentRun(ctx, &db.DocumentRun{
+		ID:         db.MakeUUID(),
+		DocumentID: docs[0].ID,
+		Status:     db.DocumentStatusImgReady,
+		CreatedAt:  docs[0].CreatedAt.Add(time.Hour),
+	}, nil))
+
+	require.NoError(t, service.db.CreateChapters(ctx, docs[1].ID, []string{"第一章"}))
+	chapters, err := service.db.ListChapters(ctx, docs[1].ID)
+	require.NoError(t, err)
+	require.NoError(t, service.db.CreateScenes(ctx, []db.Scene{
+		{ID: db.MakeUUID(), ChapterID: chapters[0].ID, DocumentID: docs[1].ID, Index: 0, Status: db.SceneStatusReady},
+		{ID: db.MakeUUID(), ChapterID: chapters[0].ID, DocumentID: docs[1].ID, Index: 1},
+		{ID: db.MakeUUID(), ChapterID: chapters[0].ID, DocumentID: docs[1].ID, Index: 2},
+	}))
+	require.NoError(t, service.quota.RecordGeneration(ctx, 0, 3, 2))
+	require.NoError(t, service.db.IncrQuotaUsage(ctx, 0, time.Now().AddDate(0, -1, 0).Format(time.DateOnly), 10, 10))
+
+	req := httptest.NewRequest(http.MethodGet, "/v1/stats/summary", nil)
+	w := httptest.NewRecorder()
+	router.ServeHTTP(w, req)
+	var resp proto.BaseResponse
+	require.NoError(t, json.Unmarshal(w.Body.Bytes(), &resp))
+	require.Equal(t, http.StatusOK, resp.Code)
+	data, err := json.Marshal(resp.Data)
+	require.NoError(t, err)
+	var summary api.StatsSummary
+	require.NoError(t, json.Unmarshal(data, &summary))
+
+	assert.Equal(t, map[string]int64{db.DocumentStatusImgReady: 1, db.DocumentStatusSceneReady: 1, db.DocumentStatusChapterReady: 1}, summary.DocumentsByStatus)
+	assert.Equal(t, int64(3), summary.ScenesThisMonth)
+	assert.Equal(t, int64(2), summary.VoicesThisMonth)
+	assert.Equal(t, 1, summary.ProcessedThisMonth)
+	assert.InDelta(t, 90, summary.AvgProcessingSecs, 1)
+	assert.Equal(t, api.StatsQueue{Documents: 1, PausedDocuments: 1, Scenes: 2}, summary.Queue)
+}
+
+func TestLimiter(t *testing.T) {
+	service, cleanup := setupTestService(t)
+	defer cleanup()
+
+	service.limiter = newLimiter(LimitConfig{MaxConcurrent: 1, MaxQueued: 1}, "/v1")
+	router := service.RegisterRouter(os.Stdout)
+
+	started := make(chan struct{}, 2)
+	release := make(chan struct{})
+	router.GET("/v1/test/limited", service.NilAuth(), func(c *gin.Context) {
+		service.limiter.Run(c, GetUserInfo(c).ID, func(ctx context.Context) (any, error) {
+			started <- struct{}{}
+			<-release
+			return map[string]string{"ok": "yes"}, nil
+		})
+	})
+
+	call := func(path string) (int, proto.BaseResponse) {
+		req := httptest.NewRequest(http.MethodGet, path, nil)
+		w := httptest.NewRecorder()
+		router.ServeHTTP(w, req)
+		var resp proto.BaseResponse
+		require.NoError(t, json.Unmarshal(w.Body.Bytes(), &resp))
+		return w.Code, resp
+	}
+
+	// 第一个请求占满并发，同步执行
+	done := make(chan proto.BaseResponse)
+	go func() {
+		_, resp := call("/v1/test/limited")
+		done <- resp
+	}()
+	<-started
+
+	// 第二个请求排队，返回 202 和任务 id
+	httpCode, resp := call("/v1/test/limited")
+	require.Equal(t, http.StatusAccepted, httpCode)
+	require.Equal(t, http.StatusAccepted, resp.Code)
+	data, err := json.Marshal(resp.Data)
+	require.NoError(t, err)
+	var job api.Job
+	require.NoError(t, json.Unmarshal(data, &job))
+	assert.Equal(t, JobStatusQueued, job.Status)
+
+	// 队列已满，返回 429
+	_, resp = call("/v1/test/limited")
+	assert.Equal(t, http.StatusTooManyRequests, resp.Code)
+
+	close(release)
+	assert.Equal(t, http.StatusOK, (<-done).Code)
+
+	// 轮询排队任务的结果
+	require.Eventually(t, func() bool {
+		got, ok := service.limiter.Get(0, job.ID)
+		return ok && got.Status == JobStatusSucceeded
+	}, 2*time.Second, 10*time.Millisecond)
+
+	_, resp = call("/v1/jobs/" + job.ID)
+	require.Equal(t, http.StatusOK, resp.Code)
+	data, err = json.Marshal(resp.Data)
+	require.NoError(t, err)
+	require.NoError(t, json.Unmarshal(data, &job))
+	assert.Equal(t, JobStatusSucceeded, job.Status)
+	assert.Equal(t, map[string]any{"ok": "yes"}, job.Result)
+
+	// 其他租户看不到该任务
+	_, ok := service.limiter.Get(1, job.ID)
+	assert.False(t, ok)
+
+	// 有空闲并发时，请求 respond-async 也排队异步执行
+	release = make(chan struct{})
+	req := httptest.NewRequest(http.MethodGet, "/v1/test/limited", nil)
+	req.Header.Set("Prefer", "wait=10, respond-async")
+	w := httptest.NewRecorder()
+	router.ServeHTTP(w, req)
+	require.Equal(t, http.StatusAccepted, w.Code)
+	assert.Equal(t, "respond-async", w.Header().Get("Preference-Applied"))
+	resp = proto.BaseResponse{}
+	require.NoError(t, json.Unmarshal(w.Body.Bytes(), &resp))
+	data, err = json.Marshal(resp.Data)
+	require.NoError(t, err)
+	job = api.Job{}
+	require.NoError(t, json.Unmarshal(data, &job))
+	assert.Equal(t, "/v1/operations/"+job.ID, job.OperationURL)
+	assert.Equal(t, job.OperationURL, w.Header().Get("Location"))
+	close(release)
+	require.Eventually(t, func() bool {
+		got, ok := service.limiter.Get(0, job.ID)
+		return ok && got.Status == JobStatusSucceeded
+	}, 2*time.Second, 10*time.Millisecond)
+
+	assert.True(t, preferAsync(&http.Request{Header: http.Header{"Prefer": {"return=minimal", "Respond-Async"}}}))
+	assert.False(t, preferAsync(&http.Request{Header: http.Header{"Prefer": {"respond-sync"}}}))
+}
+
+func TestOperations(t *testing.T) {
+	service, cleanup := setupTestService(t)
+	defer cleanup()
+
+	router := service.RegisterRouter(os.Stdout)
+	ctx := context.Background()
+
+	get := func(id string) (int, api.Operation) {
+		req := httptest.NewRequest(http.MethodGet, "/v1/operations/"+id, nil)
+		w := httptest.NewRecorder()
+		router.ServeHTTP(w, req)
+		var resp proto.BaseResponse
+		require.NoError(t, json.Unmarshal(w.Body.Bytes(), &resp))
+		data, err := json.Marshal(resp.Data)
+		require.NoError(t, err)
+		var op api.Operation
+		require.NoError(t, json.Unmarshal(data, &op))
+		return resp.Code, op
+	}
+
+	// 导出
+	docID := db.MakeUUID()
+	_, err := service.db.CreateDocument(ctx, docID, "file-id", &api.CreateDocumentArgs{Name: "操作文档"})
+	require.NoError(t, err)
+	now := time.Now()
+	export := db.Export{ID: db.MakeUUID(), DocumentID: docID, Format: "zip", Status: db.ExportStatusSucceeded, CreatedAt: now, UpdatedAt: now}
+	require.NoError(t, service.db.CreateExport(ctx, &export))
+	code, op := get(export.ID)
+	require.Equal(t, http.StatusOK, code)
+	assert.Equal(t, api.OperationKindExport, op.Kind)
+	assert.Equal(t, api.OperationStatusSucceeded, op.Status)
+	assert.Equal(t, 100, op.Progress)
+	assert.Equal(t, "/v1/exports/"+export.ID+"/download", op.ResultURL)
+	other := db.Export{ID: db.MakeUUID(), UserID: 1, DocumentID: docID, Format: "zip", Status: db.ExportStatusPending, CreatedAt: now, UpdatedAt: now}
+	require.NoError(t, service.db.CreateExport(ctx, &other))
+	code, _ = get(other.ID)
+	assert.Equal(t, http.StatusNotFound, code)
+
+	// 批量导入，部分文件已处理
+	batch := db.ImportBatch{ID: db.MakeUUID(), Filename: "books.zip", Status: db.ImportBatchStatusRunning}
+	require.NoError(t, service.db.CreateImportBatch(ctx, &batch, []db.ImportItem{
+		{BatchID: batch.ID, Index: 0, Filename: "a.txt", Name: "a", Status: db.ImportItemStatusFailed, Error: "empty file"},
+		{BatchID: batch.ID, Index: 1, Filename: "b.txt", Name: "b", Status: db.ImportItemStatusPending},
+	}))
+	code, op = get(batch.ID)
+	require.Equal(t, http.StatusOK, code)
+	assert.Equal(t, api.OperationKindImport, op.Kind)
+	assert.Equal(t, api.OperationStatusRunning, op.Status)
+	assert.Equal(t, 50, op.Progress)
+	assert.Equal(t, "/v1/imports/"+batch.ID, op.ResultURL)
+
+	// 文档处理
+	require.NoError(t, service.db.UpdateDocumentStatus(ctx, docID, db.DocumentStatusRoleReady))
+	code, op = get(docID)
+	require.Equal(t, http.StatusOK, code)
+	assert.Equal(t, api.OperationKindDocument, op.Kind)
+	assert.Equal(t, api.OperationStatusRunning, op.Status)
+	assert.Equal(t, 20, op.Progress)
+	require.NoError(t, service.db.UpdateDocumentStatus(ctx, docID, db.DocumentStatusImgReady))
+	_, op = get(docID)
+	assert.Equal(t, api.OperationStatusSucceeded, op.Status)
+	assert.Equal(t, "/v1/documents/"+docID, op.ResultURL)
+
+	// 排队执行的请求
+	router.GET("/v1/test/async", service.NilAuth(), func(c *gin.Context) {
+		service.limiter.Run(c, GetUserInfo(c).ID, func(ctx context.Context) (any, error) {
+			return nil, hutil.NewApiError(http.StatusConflict, "conflict")
+		})
+	})
+	req := httptest.NewRequest(http.MethodGet, "/v1/test/async", nil)
+	req.Header.Set("Prefer", "respond-async")
+	w := httptest.NewRecorder()
+	router.ServeHTTP(w, req)
+	require.Equal(t, http.StatusAccepted, w.Code)
+	jobURL := w.Header().Get("Location")
+	jobID := strings.TrimPrefix(jobURL, "/v1/operations/")
+	require.NotEqual(t, jobURL, jobID)
+	require.Eventually(t, func() bool {
+		_, op = get(jobID)
+		return op.Status == api.OperationStatusFailed
+	}, 2*time.Second, 10*time.Millisecond)
+	assert.Equal(t, api.OperationKindJob, op.Kind)
+	assert.Equal(t, http.StatusConflict, op.Code)
+	assert.Equal(t, "conflict", op.Error)
+
+	code, _ = get(db.MakeUUID())
+	assert.Equal(t, http.StatusNotFound, code)
+}
+
+func TestReading(t *testing.T) {
+	service, cleanup := setupTestService(t)
+	defer cleanup()
+
+	router := service.RegisterRouter(os.Stdout)
+	ctx := context.Background()
+
+	docID := db.MakeUUID()
+	_, err := service.db.CreateDocument(ctx, docID, "file-id", &api.CreateDocumentArgs{Name: "阅读文档"})
+	require.NoError(t, err)
+	require.NoError(t, service.db.CreateChapters(ctx, docID, []string{"第一章", "第二章"}))
+	chapters, err := service.db.ListChapters(ctx, docID)
+	require.NoError(t, err)
+	require.Len(t, chapters, 2)
+	sceneID := db.MakeUUID()
+	require.NoError(t, service.db.CreateScenes(ctx, []db.Scene{
+		{ID: sceneID, ChapterID: chapters[1].ID, DocumentID: docID, Content: "场景"},
+	}))
+
+	call := func(method, path string, body any) proto.BaseResponse {
+		var reader io.Reader
+		if body != nil {
+			b, err := json.Marshal(body)
+			require.NoError(t, err)
+			reader = bytes.NewReader(b)
+		}
+		req := httptest.NewRequest(method, path, reader)
+		req.Header.Set("Content-Type", "application/json")
+		w := httptest.NewRecorder()
+		router.ServeHTTP(w, req)
+		var resp proto.BaseResponse
+		require.NoError(t, json.Unmarshal(w.Body.Bytes(), &resp))
+		return resp
+	}
+	decode := func(resp proto.BaseResponse, v any) {
+		require.Equal(t, http.StatusOK, resp.Code, resp.Message)
+		data, err := json.Marshal(resp.Data)
+		require.NoError(t, err)
+		require.NoError(t, json.Unmarshal(data, v))
+	}
+	base := "/v1/documents/" + docID
+
+	t.Run("书签", func(t *testing.T) {
+		var bookmark api.Bookmark
+		decode(call(http.MethodPost, base+"/bookmarks", api.CreateBookmarkArgs{ChapterID: chapters[0].ID, Note: "开头"}), &bookmark)
+		assert.Equal(t, "开头", bookmark.Note)
+
+		// 场景不属于该章节
+		resp := call(http.MethodPost, base+"/bookmarks", api.CreateBookmarkArgs{ChapterID: chapters[0].ID, SceneID: sceneID})
+		assert.Equal(t, http.StatusNotFound, resp.Code)
+
+		var ret api.ListBookmarksResult
+		decode(call(http.MethodGet, base+"/bookmarks", nil), &ret)
+		require.Len(t, ret.Bookmarks, 1)
+		assert.Equal(t, bookmark.ID, ret.Bookmarks[0].ID)
+
+		decode(call(http.MethodDelete, base+"/bookmarks/"+bookmark.ID, nil), &struct{}{})
+		resp = call(http.MethodDelete, base+"/bookmarks/"+bookmark.ID, nil)
+		assert.Equal(t, http.StatusNotFound, resp.Code)
+	})
+
+	t.Run("阅读位置", func(t *testing.T) {
+		var pos api.ReadPosition
+		decode(call(http.MethodGet, base+"/position", nil), &pos)
+		assert.Empty(t, pos.ChapterID)
+
+		decode(call(http.MethodPut, base+"/position", api.UpdateReadPositionArgs{ChapterID: chapters[0].ID}), &struct{}{})
+		decode(call(http.MethodPut, base+"/position", api.UpdateReadPositionArgs{ChapterID: chapters[1].ID, SceneID: sceneID}), &struct{}{})
+		decode(call(http.MethodGet, base+"/position", nil), &pos)
+		assert.Equal(t, chapters[1].ID, pos.ChapterID)
+		assert.Equal(t, sceneID, pos.SceneID)
+
+		resp := call(http.MethodPut, "/v1/documents/nonexistent/position", api.UpdateReadPositionArgs{ChapterID: chapters[0].ID})
+		assert.Equal(t, http.StatusNotFound, resp.Code)
+	})
+}
+
+func TestDocumentMgrStop(t *testing.T) {
+	service, cleanup := setupTestService(t)
+	defer cleanup()
+
+	ctx := context.Background()
+	mgr, err := newDocumentMgr(DocumentConfigEx{
+		config: DocumentConfig{Enable: true},
+		db:     service.db,
+		quota:  service.quota,
+	}, nil)
+	require.NoError(t, err)
+	mgr.Run()
+
+	docID := db.MakeUUID()
+	_, err = service.db.CreateDocument(ctx, docID, "file-id", &api.CreateDocumentArgs{Name: "退出文档"})
+	require.NoError(t, err)
+	require.NoError(t, service.db.CreateScenes(ctx, []db.Scene{
+		{ID: db.MakeUUID(), ChapterID: db.MakeUUID(), DocumentID: docID, Content: "场景"},
+	}))
+
+	stopCtx, cancel := context.WithTimeout(ctx, time.Second)
+	defer cancel()
+	require.NoError(t, mgr.Stop(stopCtx))
+	// 重复调用不会 panic
+	require.NoError(t, mgr.Stop(stopCtx))
+
+	// 退出后不再处理剩余场景，文档保持原状态等待重启后继续
+	doc, err := service.db.GetDocument(ctx, docID)
+	require.NoError(t, err)
+	err = mgr.HandleDocumentImageGen(ctx, doc)
+	assert.ErrorIs(t, err, errDocumentMgrStopping)
+}
+
+func TestSearchIndexer(t *testing.T) {
+	service, cleanup := setupTestService(t)
+	defer cleanup()
+
+	router := service.RegisterRouter(os.Stdout)
+	ctx := context.Background()
+	indexer := newIndexer(SearchConfig{}, service.db)
+
+	docID := db.MakeUUID()
+	_, err := service.db.CreateDocument(ctx, docID, "file-id", &api.CreateDocumentArgs{Name: "搜索文档"})
+	require.NoError(t, err)
+	otherID := db.MakeUUID()
+	_, err = service.db.CreateDocument(ctx, otherID, "file-id", &api.CreateDocumentArgs{Name: "其他租户", UserID: 1})
+	require.NoError(t, err)
+	scenes := []db.Scene{
+		{ID: db.MakeUUID(), ChapterID: db.MakeUUID(), DocumentID: docID, Content: "Rainy   Night in 北平"},
+		{ID: db.MakeUUID(), ChapterID: db.MakeUUID(), DocumentID: docID, Content: "100% 晴天"},
+		{ID: db.MakeUUID(), ChapterID: db.MakeUUID(), DocumentID: otherID, Content: "rainy night"},
+	}
+	require.NoError(t, service.db.CreateScenes(ctx, scenes))
+
+	search := func(q string) api.SearchResult {
+		req := httptest.NewRequest(http.MethodGet, "/v1/search?q="+url.QueryEscape(q), nil)
+		w := httptest.NewRecorder()
+		router.ServeHTTP(w, req)
+
+		var resp proto.BaseResponse
+		require.NoError(t, json.Unmarshal(w.Body.Bytes(), &resp))
+		require.Equal(t, http.StatusOK, resp.Code)
+		data, err := json.Marshal(resp.Data)
+		require.NoError(t, err)
+		var result api.SearchResult
+		require.NoError(t, json.Unmarshal(data, &result))
+		return result
+	}
+
+	// 索引异步构建，写入后消费任务前搜不到
+	assert.Empty(t, search("rainy").Hits)
+	indexer.HandleIndexTasks(ctx)
+
+	result := search("RAINY night")
+	require.Len(t, result.Hits, 1)
+	assert.Equal(t, scenes[0].ID, result.Hits[0].SceneID)
+	// 返回原始内容，而不是归一化后的索引内容
+	assert.Equal(t, "Rainy   Night in 北平", result.Hits[0].Content)
+	// 通配符按字面匹配
+	require.Len(t, search("100%").Hits, 1)
+	assert.Empty(t, search("%晴").Hits)
+
+	// 修改和删除场景后索引同步更新
+	require.NoError(t, service.db.UpdateScene(ctx, scenes[0].ID, &api.UpdateSceneArgs{Content: "大雪"}))
+	require.NoError(t, service.db.DeleteScenesByChapter(ctx, scenes[1].ChapterID))
+	indexer.HandleIndexTasks(ctx)
+	assert.Empty(t, search("rainy").Hits)
+	assert.Empty(t, search("晴天").Hits)
+	require.Len(t, search("大雪").Hits, 1)
+
+	// 低版本索引在启动时重建
+	require.NoError(t, service.db.SaveSearchEntry(ctx, &db.SearchEntry{SceneID: "stale", DocumentID: docID, Content: "大雪", IndexVersion: searchIndexVersion - 1}))
+	require.Len(t, search("大雪").Hits, 2)
+	indexer.EnqueueStaleDocuments(ctx)
+	indexer.HandleIndexTasks(ctx)
+	require.Len(t, search("大雪").Hits, 1)
+
+	// 删除文档后清理索引
+	_, err = service.db.DeleteDocument(ctx, docID)
+	require.NoError(t, err)
+	indexer.HandleIndexTasks(ctx)
+	assert.Empty(t, search("大雪").Hits)
+
+	req := httptest.NewRequest(http.MethodGet, "/v1/search", nil)
+	w := httptest.NewRecorder()
+	router.ServeHTTP(w, req)
+	var resp proto.BaseResponse
+	require.NoError(t, json.Unmarshal(w.Body.Bytes(), &resp))
+	assert.Equal(t, http.StatusBadRequest, resp.Code)
+}
+
+func TestGRPC(t *testing.T) {
+	service, cleanup := setupTestService(t)
+	defer cleanup()
+
+	lis := bufconn.Listen(1024 * 1024)
+	server := service.NewGRPCServer()
+	go server.Serve(lis)
+	defer server.Stop()
+
+	conn, err := grpc.NewClient("passthrough:///bufnet",
+		grpc.WithContextDialer(func(ctx context.Context, _ string) (net.Conn, error) { return lis.DialContext(ctx) }),
+		grpc.WithTransportCredentials(insecure.NewCredentials()),
+	)
+	require.NoError(t, err)
+	defer conn.Close()
+	client := pb.NewImgAgentClient(conn)
+	ctx := context.Background()
+
+	docID := db.MakeUUID()
+	_, err = service.db.CreateDocument(ctx, docID, "file-id", &api.CreateDocumentArgs{Name: "gRPC 文档"})
+	require.NoError(t, err)
+	chapterID := db.MakeUUID()
+	require.NoError(t, service.db.CreateScenes(ctx, []db.Scene{
+		{ID: db.MakeUUID(), ChapterID: chapterID, DocumentID: docID, Index: 0, Content: "场景1"},
+		{ID: db.MakeUUID(), ChapterID: chapterID, DocumentID: docID, Index: 1, Content: "场景2"},
+	}))
+
+	var header metadata.MD
+	doc, err := client.GetDocument(ctx, &pb.GetDocumentRequest{DocumentId: docID}, grpc.Header(&header))
+	require.NoError(t, err)
+	assert.Equal(t, "gRPC 文档", doc.Name)
+	assert.NotEmpty(t, header.Get("x-reqid"))
+
+	doc, err = client.UpdateDocument(ctx, &pb.UpdateDocumentRequest{DocumentId: docID, Name: "新名称"})
+	require.NoError(t, err)
+	assert.Equal(t, "新名称", doc.Name)
+
+	scenes, err := client.ListScenes(ctx, &pb.ListScenesRequest{ChapterId: chapterID})
+	require.NoError(t, err)
+	require.Len(t, scenes.Scenes, 2)
+	assert.Equal(t, "场景1", scenes.Scenes[0].Content)
+
+	docs, err := client.ListDocuments(ctx, &pb.ListDocumentsRequest{})
+	require.NoError(t, err)
+	require.Len(t, docs.Documents, 1)
+
+	// 业务错误映射为 gRPC code，原始错误码在 trailer 中
+	var trailer metadata.MD
+	_, err = client.GetDocument(ctx, &pb.GetDocumentRequest{DocumentId: "missing"}, grpc.Trailer(&trailer))
+	assert.Equal(t, codes.NotFound, status.Code(err))
+	assert.Equal(t, []string{strconv.Itoa(ErrNoSuchDocumentCode)}, trailer.Get("x-code"))
+
+	_, err = client.UpdateDocument(ctx, &pb.UpdateDocumentRequest{DocumentId: docID})
+	assert.Equal(t, codes.InvalidArgument, status.Code(err))
+	_, err = client.ListScenes(ctx, &pb.ListScenesRequest{})
+	assert.Equal(t, codes.InvalidArgument, status.Code(err))
+	_, err = client.CreateDocument(ctx, &pb.CreateDocumentRequest{Name: "空文件", Filename: "a.txt"})
+	assert.Equal(t, codes.InvalidArgument, status.Code(err))
+
+	// 租户并发已满时不排队，直接返回 RESOURCE_EXHAUSTED
+	service.limiter = newLimiter(LimitConfig{MaxConcurrent: 1}, "/v1")
+	service.limiter.tenantSlots(0) <- struct{}{}
+	_, err = client.UpdateScene(ctx, &pb.UpdateSceneRequest{Id: scenes.Scenes[0].Id, Content: "新场景"})
+	assert.Equal(t, codes.ResourceExhausted, status.Code(err))
+
+	_, err = client.DeleteDocument(ctx, &pb.DeleteDocumentRequest{DocumentId: docID})
+	require.NoError(t, err)
+	_, err = client.GetDocument(ctx, &pb.GetDocumentRequest{DocumentId: docID})
+	assert.Equal(t, codes.NotFound, status.Code(err))
+}
+
+func TestDocumentMgrPanic(t *testing.T) {
+	service, cleanup := setupTestService(t)
+	defer cleanup()
+
+	ctx := context.Background()
+	// bailian 客户端为 nil，调用大模型时 panic
+	mgr, err := newDocumentMgr(DocumentConfigEx{
+		config: DocumentConfig{Enable: true},
+		db:     service.db,
+		quota:  service.quota,
+	}, nil)
+	require.NoError(t, err)
+
+	roleDocID := db.MakeUUID()
+	_, err = service.db.CreateDocument(ctx, roleDocID, "file-id", &api.CreateDocumentArgs{Name: "角色 panic"})
+	require.NoError(t, err)
+	imgDocID := db.MakeUUID()
+	_, err = service.db.CreateDocument(ctx, imgDocID, "file-id", &api.CreateDocumentArgs{Name: "场景 panic"})
+	require.NoError(t, err)
+	require.NoError(t, service.db.UpdateDocumentStatus(ctx, imgDocID, db.DocumentStatusSceneReady))
+	sceneID := db.MakeUUID()
+	require.NoError(t, service.db.CreateScenes(ctx, []db.Scene{
+		{ID: sceneID, ChapterID: db.MakeUUID(), DocumentID: imgDocID, Content: "场景"},
+	}))
+
+	// 文档级 panic：文档标记为失败
+	mgr.HandleDocumentRoleTasks(ctx)
+	doc, err := service.db.GetDocument(ctx, roleDocID)
+	require.NoError(t, err)
+	assert.Equal(t, db.DocumentStatusFailed, doc.Status)
+
+	// 场景级 panic：场景直接永久失败，文档照常结束
+	mgr.HandleImageGenTasks(ctx)
+	scene, err := service.db.GetScene(ctx, sceneID)
+	require.NoError(t, err)
+	assert.Equal(t, db.SceneStatusFailed, scene.Status)
+	assert.Equal(t, 1, scene.Attempts)
+	assert.True(t, scene.Placeholder)
+	doc, err = service.db.GetDocument(ctx, imgDocID)
+	require.NoError(t, err)
+	assert.Equal(t, db.DocumentStatusFailed, doc.Status)
+
+	events, err := service.db.ListEvents(ctx, 0, 0, 100)
+	require.NoError(t, err)
+	var panics []db.Event
+	for _, e := range events {
+		if e.Type == db.EventPipelinePanic {
+			panics = append(panics, e)
+		}
+	}
+	require.Len(t, panics, 2)
+	assert.Equal(t, sceneID, panics[0].SceneID)
+	assert.Equal(t, roleDocID, panics[1].DocumentID)
+	for _, e := range panics {
+		assert.Contains(t, e.Detail, "goroutine")
+		assert.NotContains(t, e.Message, "goroutine")
+	}
+
+	// 处理循环 panic 后被重启，直到 Stop
+	var runs atomic.Int32
+	mgr.supervise("test", func() {
+		if runs.Add(1) == 1 {
+			panic("boom")
+		}
+		<-mgr.close
+	})
+	require.Eventually(t, func() bool { return runs.Load() == 2 }, 3*time.Second, 10*time.Millisecond)
+	stopCtx, cancel := context.WithTimeout(ctx, time.Second)
+	defer cancel()
+	require.NoError(t, mgr.Stop(stopCtx))
+}
+
+func TestWebhooks(t *testing.T) {
+	service, cleanup := setupTestService(t)
+	defer cleanup()
+
+	router := service.RegisterRouter(os.Stdout)
+	ctx := context.Background()
+	webhooks := newWebhookMgr(WebhookConfig{MaxAttempts: 2}, httpclient.Config{AllowPrivate: true}, service.db)
+
+	type received struct {
+		header http.Header
+		body   []byte
+	}
+	got := make(chan received, 10)
+	okServer := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		body, _ := io.ReadAll(r.Body)
+		got <- received{header: r.Header, body: body}
+	}))
+	defer okServer.Close()
+	var failedCalls atomic.Int32
+	failServer := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		failedCalls.Add(1)
+		w.WriteHeader(http.StatusInternalServerError)
+	}))
+	defer failServer.Close()
+
+	docID := db.MakeUUID()
+	_, err := service.db.CreateDocument(ctx, docID, "file-id", &api.CreateDocumentArgs{Name: "回调文档"})
+	require.NoError(t, err)
+
+	do := func(method, path string, body any) proto.BaseResponse {
+		var reader io.Reader
+		if body != nil {
+			data, err := json.Marshal(body)
+			require.NoError(t, err)
+			reader = bytes.NewReader(data)
+		}
+		req := httptest.NewRequest(method, path, reader)
+		req.Header.Set("Content-Type", "application/json")
+		w := httptest.NewRecorder()
+		router.ServeHTTP(w, req)
+
+		var resp proto.BaseResponse
+		require.NoError(t, json.Unmarshal(w.Body.Bytes(), &resp))
+		return resp
+	}
+	create := func(args api.CreateWebhookArgs) api.Webhook {
+		resp := do(http.MethodPost, "/v1/webhooks", args)
+		require.Equal(t, http.StatusOK, resp.Code)
+		data, err := json.Marshal(resp.Data)
+		require.NoError(t, err)
+		var hook api.Webhook
+		require.NoError(t, json.Unmarshal(data, &hook))
+		return hook
+	}
+
+	// 参数校验
+	assert.Equal(t, http.StatusBadRequest, do(http.MethodPost, "/v1/webhooks", api.CreateWebhookArgs{URL: "not-a-url"}).Code)
+	assert.Equal(t, 612, do(http.MethodPost, "/v1/webhooks", api.CreateWebhookArgs{URL: okServer.URL, DocumentID: db.MakeUUID()}).Code)
+
+	docHook := create(api.CreateWebhookArgs{URL: okServer.URL, DocumentID: docID, Secret: "s3cret"})
+	assert.Equal(t, "s3cret", docHook.Secret)
+	globalHook := create(api.CreateWebhookArgs{URL: failServer.URL})
+	assert.Len(t, globalHook.Secret, 32)
+
+	// 列取时不返回 secret
+	resp := do(http.MethodGet, "/v1/webhooks?document_id="+docID, nil)
+	require.Equal(t, http.StatusOK, resp.Code)
+	data, err := json.Marshal(resp.Data)
+	require.NoError(t, err)
+	var list api.ListWebhooksResult
+	require.NoError(t, json.Unmarshal(data, &list))
+	require.Len(t, list.Webhooks, 1)
+	assert.Equal(t, docHook.ID, list.Webhooks[0].ID)
+	assert.Empty(t, list.Webhooks[0].Secret)
+
+	// 文档处理 panic 后发送 document.failed，两个 webhook 各一条
+	mgr, err := newDocumentMgr(DocumentConfigEx{
+		config:   DocumentConfig{Enable: true},
+		db:       service.db,
+		quota:    service.quota,
+		webhooks: webhooks,
+	}, nil)
+	require.NoError(t, err)
+	mgr.HandleDocumentRoleTasks(ctx)
+	due, err := service.db.ListDueWebhookDeliveries(ctx, time.Now(), 10)
+	require.NoError(t, err)
+	require.Len(t, due, 2)
+
+	webhooks.HandleDeliveries(ctx)
+	select {
+	case r := <-got:
+		assert.Equal(t, WebhookDocumentFailed, r.header.Get(webhookEventHeader))
+		timestamp := r.header.Get(webhookTimestampHeader)
+		assert.Equal(t, "sha256="+signWebhook("s3cret", timestamp, string(r.body)), r.header.Get(webhookSignatureHeader))
+		var event api.WebhookEvent
+		require.NoError(t, json.Unmarshal(r.body, &event))
+		assert.Equal(t, WebhookDocumentFailed, event.Type)
+		assert.Equal(t, r.header.Get(webhookDeliveryHeader), event.ID)
+		assert.Equal(t, docID, event.Data.(map[string]any)["id"])
+		assert.Equal(t, db.DocumentStatusFailed, event.Data.(map[string]any)["status"])
+	case <-time.After(time.Second):
+		t.Fatal("webhook not delivered")
+	}
+	assert.Equal(t, int32(1), failedCalls.Load())
+
+	// 投递失败后按退避时间重试，达到最大次数后放弃
+	due, err = service.db.ListDueWebhookDeliveries(ctx, time.Now(), 10)
+	require.NoError(t, err)
+	assert.Empty(t, due)
+	due, err = service.db.ListDueWebhookDeliveries(ctx, time.Now().Add(webhooks.backoff(1)), 10)
+	require.NoError(t, err)
+	require.Len(t, due, 1)
+	assert.Equal(t, 1, due[0].Attempts)
+	assert.Contains(t, due[0].LastError, "500")
+	assert.Equal(t, 20*time.Second, webhooks.backoff(2))
+
+	require.NoError(t, service.db.UpdateWebhookDelivery(ctx, due[0].ID, db.WebhookDeliveryPending, 1, time.Now(), due[0].LastError))
+	webhooks.HandleDeliveries(ctx)
+	assert.Equal(t, int32(2), failedCalls.Load())
+	due, err = service.db.ListDueWebhookDeliveries(ctx, time.Now().Add(time.Hour), 10)
+	require.NoError(t, err)
+	assert.Empty(t, due)
+
+	// 删除
+	assert.Equal(t, http.StatusOK, do(http.MethodDelete, "/v1/webhooks/"+globalHook.ID, nil).Code)
+	assert.Equal(t, http.StatusNotFound, do(http.MethodDelete, "/v1/webhooks/"+globalHook.ID, nil).Code)
+
+	stopCtx, cancel := context.WithTimeout(ctx, time.Second)
+	defer cancel()
+	require.NoError(t, webhooks.Stop(stopCtx))
+}
+
+func TestWebhookTemplates(t *testing.T) {
+	service, cleanup := setupTestService(t)
+	defer cleanup()
+
+	router := service.RegisterRouter(os.Stdout)
+	ctx := context.Background()
+	webhooks := newWebhookMgr(WebhookConfig{}, httpclient.Config{AllowPrivate: true}, service.db)
+
+	type received struct {
+		contentType string
+		body        string
+	}
+	got := make(chan received, 10)
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		body, _ := io.ReadAll(r.Body)
+		got <- received{contentType: r.Header.Get("Content-Type"), body: string(body)}
+	}))
+	defer server.Close()
+
+	docID := db.MakeUUID()
+	doc, err := service.db.CreateDocument(ctx, docID, "file-id", &api.CreateDocumentArgs{Name: "模板文档"})
+	require.NoError(t, err)
+
+	create := func(args api.CreateWebhookArgs) proto.BaseResponse {
+		data, err := json.Marshal(args)
+		require.NoError(t, err)
+		req := httptest.NewRequest(http.MethodPost, "/v1/webhooks", bytes.NewReader(data))
+		req.Header.Set("Content-Type", "application/json")
+		w := httptest.NewRecorder()
+		router.ServeHTTP(w, req)
+
+		var resp proto.BaseResponse
+		require.NoError(t, json.Unmarshal(w.Body.Bytes(), &resp))
+		return resp
+	}
+	receive := func() received {
+		select {
+		case r := <-got:
+			return r
+		case <-time.After(time.Second):
+			t.Fatal("webhook not delivered")
+		}
+		return received{}
+	}
+
+	// 模板语法错误、引用不存在的模板时拒绝注册
+	assert.Equal(t, http.StatusBadRequest, create(api.CreateWebhookArgs{URL: server.URL, Template: "{{.type"}).Code)
+	assert.Equal(t, http.StatusBadRequest, create(api.CreateWebhookArgs{URL: server.URL, Template: `{{template "missing" .}}`}).Code)
+
+	// 自引用、逐层翻倍的模板在渲染时中止，不影响服务
+	assert.Equal(t, http.StatusBadRequest, create(api.CreateWebhookArgs{URL: server.URL, Template: `{{define "l"}}{{template "l" .}}{{end}}{{template "l" .}}`}).Code)
+	assert.Equal(t, http.StatusBadRequest, create(api.CreateWebhookArgs{URL: server.URL, Template: `{{define "l"}}{{render "l" .}}{{end}}{{template "l" .}}`}).Code)
+	var nested strings.Builder
+	nested.WriteString(`{{define "t0"}}0123456789{{end}}`)
+	for i := 1; i <= 20; i++ {
+		fmt.Fprintf(&nested, `{{define "t%d"}}{{template "t%d" .}}{{template "t%d" .}}{{end}}`, i, i-1, i-1)
+	}
+	nested.WriteString(`{{template "t20" .}}`)
+	assert.Equal(t, http.StatusBadRequest, create(api.CreateWebhookArgs{URL: server.URL, Template: nested.String()}).Code)
+
+	// 自定义 JSON 结构
+	resp := create(api.CreateWebhookArgs{
+		URL:      server.URL,
+		Template: `{"event":{{json .type}},"doc":{{json .data.name}},"note":{{.data.missing | default "none" | json}}}`,
+	})
+	require.Equal(t, http.StatusOK, resp.Code)
+	webhooks.Notify(ctx, *doc, WebhookDocumentProcessed, makeDocument(doc))
+	webhooks.HandleDeliveries(ctx)
+	r := receive()
+	assert.Equal(t, "application/json", r.contentType)
+	assert.JSONEq(t, `{"event":"document.processed","doc":"模板文档","note":"none"}`, r.body)
+	req := httptest.NewRequest(http.MethodDelete, "/v1/webhooks/"+resp.Data.(map[string]any)["id"].(string), nil)
+	w := httptest.NewRecorder()
+	router.ServeHTTP(w, req)
+	require.Equal(t, http.StatusOK, w.Code)
+
+	// 内置钉钉消息模板和纯文本
+	resp = create(api.CreateWebhookArgs{URL: server.URL, Template: `{{template "dingtalk" .}}`})
+	require.Equal(t, http.StatusOK, resp.Code)
+	textResp := create(api.CreateWebhookArgs{URL: server.URL, Template: `{{template "text" .}}`, ContentType: "text/plain; charset=utf-8"})
+	require.Equal(t, http.StatusOK, textResp.Code)
+	webhooks.Notify(ctx, *doc, WebhookDocumentProcessed, makeDocument(doc))
+	webhooks.HandleDeliveries(ctx)
+	bodies := map[string]string{}
+	for range 2 {
+		r := receive()
+		bodies[r.contentType] = r.body
+	}
+	var msg struct {
+		MsgType string `json:"msgtype"`
+		Text    struct {
+			Content string `json:"content"`
+		} `json:"text"`
+	}
+	require.NoError(t, json.Unmarshal([]byte(bodies["application/json"]), &msg))
+	assert.Equal(t, "text", msg.MsgType)
+	assert.Equal(t, "[imgagent] 文档处理完成 文档「模板文档」 状态："+doc.Status, msg.Text.Content)
+	assert.Equal(t, msg.Text.Content, bodies["text/plain; charset=utf-8"])
+}
+
+func TestWebhookSceneBatches(t *testing.T) {
+	service, cleanup := setupTestService(t)
+	defer cleanup()
+
+	ctx := context.Background()
+	webhooks := newWebhookMgr(WebhookConfig{SceneBatchWindowSecs: 10, SceneBatchMaxWaitSecs: 15}, httpclient.Config{AllowPrivate: true}, service.db)
+
+	var mu sync.Mutex
+	events := make(map[string][]api.WebhookEvent)
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		var event api.WebhookEvent
+		require.NoError(t, json.NewDecoder(r.Body).Decode(&event))
+		mu.Lock()
+		events[r.URL.Path] = append(events[r.URL.Path], event)
+		mu.Unlock()
+	}))
+	defer server.Close()
+
+	docID := db.MakeUUID()
+	doc, err := service.db.CreateDocument(ctx, docID, "file-id", &api.CreateDocumentArgs{Name: "合并回调"})
+	require.NoError(t, err)
+	for _, hook := range []db.Webhook{
+		{ID: db.MakeUUID(), URL: server.URL + "/single", DocumentID: docID},
+		{ID: db.MakeUUID(), URL: server.URL + "/batch", BatchScenes: true},
+	} {
+		require.NoError(t, service.db.CreateWebhook(ctx, &hook))
+	}
+
+	// 普通 webhook 每个场景立即记录两条回调，合并的 webhook 按章节累积
+	start := time.Now()
+	scenes := []db.Scene{
+		{ID: "s2", DocumentID: docID, ChapterID: "c1", Index: 2},
+		{ID: "s1", DocumentID: docID, ChapterID: "c1", Index: 1},
+		{ID: "s3", DocumentID: docID, ChapterID: "c2", Index: 3},
+		{ID: "s1", DocumentID: docID, ChapterID: "c1", Index: 1, ImageURL: "http://img/s1-new.png"},
+	}
+	for _, scene := range scenes {
+		webhooks.NotifyScene(ctx, *doc, scene, WebhookSceneImageReady, WebhookSceneVoiceReady)
+	}
+	webhooks.HandleDeliveries(ctx)
+	assert.Len(t, events["/single"], 8)
+	assert.Empty(t, events["/batch"])
+
+	// 防抖窗口内没有新的场景就绪后按章节各投递一次，同一场景只保留最新的
+	webhooks.flushSceneBatches(ctx, start.Add(9*time.Second))
+	due, err := service.db.ListDueWebhookDeliveries(ctx, time.Now(), 10)
+	require.NoError(t, err)
+	assert.Empty(t, due)
+	webhooks.flushSceneBatches(ctx, time.Now().Add(10*time.Second))
+	webhooks.HandleDeliveries(ctx)
+	require.Len(t, events["/batch"], 2)
+	byChapter := make(map[string]api.ChapterScenesReady)
+	for _, event := range events["/batch"] {
+		assert.Equal(t, WebhookChapterScenesReady, event.Type)
+		data, err := json.Marshal(event.Data)
+		require.NoError(t, err)
+		var ready api.ChapterScenesReady
+		require.NoError(t, json.Unmarshal(data, &ready))
+		assert.Equal(t, docID, ready.DocumentID)
+		byChapter[ready.ChapterID] = ready
+	}
+	require.Len(t, byChapter["c1"].Scenes, 2)
+	assert.Equal(t, "s1", byChapter["c1"].Scenes[0].ID)
+	assert.Equal(t, "http://img/s1-new.png", byChapter["c1"].Scenes[0].ImageURL)
+	assert.Equal(t, "s2", byChapter["c1"].Scenes[1].ID)
+	require.Len(t, byChapter["c2"].Scenes, 1)
+
+	// 停止时未到期的批次也落库
+	webhooks.NotifyScene(ctx, *doc, scenes[0], WebhookSceneImageReady)
+	webhooks.NotifyScene(ctx, *doc, scenes[2], WebhookSceneImageReady)
+
+	stopCtx, cancel := context.WithTimeout(ctx, time.Second)
+	defer cancel()
+	require.NoError(t, webhooks.Stop(stopCtx))
+	due, err = service.db.ListDueWebhookDeliveries(ctx, time.Now(), 10)
+	require.NoError(t, err)
+	n := 0
+	for _, d := range due {
+		if d.EventType == WebhookChapterScenesReady {
+			n++
+		}
+	}
+	assert.Equal(t, 2, n)
+}
+
+func TestBackfill(t *testing.T) {
+	service, cleanup := setupTestService(t)
+	defer cleanup()
+
+	ctx := context.Background()
+	// 语音地址不可访问时按文本估算时长
+	voiceServer := httptest.NewServer(http.NotFoundHandler())
+	defer voiceServer.Close()
+	bailianClient, err := bailian.NewClient(bailian.Config{})
+	require.NoError(t, err)
+	b := newBackfiller(service.db, bailianClient)
+
+	var sceneIDs []string
+	for i := 0; i < 3; i++ {
+		docID := db.MakeUUID()
+		_, err := service.db.CreateDocument(ctx, docID, "file-id", &api.CreateDocumentArgs{Name: fmt.Sprintf("历史文档 %d", i)})
+		require.NoError(t, err)
+		sceneID := db.MakeUUID()
+		require.NoError(t, service.db.CreateScenes(ctx, []db.Scene{
+			{ID: sceneID, ChapterID: db.MakeUUID(), DocumentID: docID, Content: "旧场景", VoiceURL: voiceServer.URL + "/voice.wav"},
+		}))
+		sceneIDs = append(sceneIDs, sceneID)
+	}
+
+	err = b.Run(ctx, BackfillOptions{Stages: []string{"thumbnail"}})
+	assert.ErrorContains(t, err, "unknown backfill stage")
+
+	// 中断后保存进度，再次执行从中断处继续
+	cancelCtx, cancel := context.WithCancel(ctx)
+	cancel()
+	err = b.Run(cancelCtx, BackfillOptions{Stages: []string{"timing"}, BatchSize: 2})
+	assert.ErrorIs(t, err, context.Canceled)
+	progress, err := service.db.GetBackfillProgress(ctx, "timing")
+	require.NoError(t, err)
+	assert.False(t, progress.Done)
+	assert.Equal(t, 0, progress.Processed)
+
+	require.NoError(t, b.Run(ctx, BackfillOptions{Stages: []string{"timing", "search"}, BatchSize: 2}))
+	for _, stage := range []string{"timing", "search"} {
+		progress, err = service.db.GetBackfillProgress(ctx, stage)
+		require.NoError(t, err)
+		assert.True(t, progress.Done)
+		assert.Equal(t, 3, progress.Processed)
+		assert.Equal(t, 0, progress.Failed)
+	}
+	for _, sceneID := range sceneIDs {
+		scene, err := service.db.GetScene(ctx, sceneID)
+		require.NoError(t, err)
+		assert.Positive(t, scene.AudioDurationMs)
+		assert.Positive(t, scene.DisplayDurationMs)
+	}
+	entries, err := service.db.SearchScenes(ctx, 0, "旧场景", 10)
+	require.NoError(t, err)
+	assert.Len(t, entries, 3)
+
+	// 已完成的阶段不重复执行，Reset 后重新开始
+	require.NoError(t, b.Run(ctx, BackfillOptions{Stages: []string{"search"}}))
+	progress, err = service.db.GetBackfillProgress(ctx, "search")
+	require.NoError(t, err)
+	assert.Equal(t, 3, progress.Processed)
+	require.NoError(t, b.Run(ctx, BackfillOptions{Stages: []string{"search"}, Reset: true}))
+	progress, err = service.db.GetBackfillProgress(ctx, "search")
+	require.NoError(t, err)
+	assert.Equal(t, 3, progress.Processed)
+	assert.True(t, progress.Done)
+
+	// 单个文档失败（panic）只计数，不阻塞回填
+	require.NoError(t, service.db.UpdateSceneTiming(ctx, sceneIDs[1], 0, 0))
+	require.NoError(t, newBackfiller(service.db, nil).Run(ctx, BackfillOptions{Stages: []string{"timing"}, Reset: true}))
+	progress, err = service.db.GetBackfillProgress(ctx, "timing")
+	require.NoError(t, err)
+	assert.Equal(t, 3, progress.Processed)
+	assert.Equal(t, 1, progress.Failed)
+}
+
+func TestDocumentEvents(t *testing.T) {
+	service, cleanup := setupTestService(t)
+	defer cleanup()
+
+	server := httptest.NewServer(service.RegisterRouter(os.Stdout))
+	defer server.Close()
+	ctx := context.Background()
+
+	docID := db.MakeUUID()
+	_, err := service.db.CreateDocument(ctx, docID, "file-id", &api.CreateDocumentArgs{Name: "进度文档"})
+	require.NoError(t, err)
+	require.NoError(t, service.db.UpdateDocumentStatus(ctx, docID, db.DocumentStatusSceneReady))
+	require.NoError(t, service.db.CreateScenes(ctx, []db.Scene{
+		{ID: db.MakeUUID(), ChapterID: db.MakeUUID(), DocumentID: docID, Index: 0, Content: "场景1"},
+		{ID: db.MakeUUID(), ChapterID: db.MakeUUID(), DocumentID: docID, Index: 1, Content: "场景2"},
+	}))
+
+	// 不存在的文档
+	resp, err := http.Get(server.URL + "/v1/documents/" + db.MakeUUID() + "/events")
+	require.NoError(t, err)
+	var base proto.BaseResponse
+	require.NoError(t, json.NewDecoder(resp.Body).Decode(&base))
+	resp.Body.Close()
+	assert.Equal(t, 612, base.Code)
+
+	resp, err = http.Get(server.URL + "/v1/documents/" + docID + "/events")
+	require.NoError(t, err)
+	defer resp.Body.Close()
+	assert.True(t, strings.HasPrefix(resp.Header.Get("Content-Type"), "text/event-stream"))
+	reader := bufio.NewReader(resp.Body)
+
+	readEvent := func() (string, api.DocumentProgress) {
+		var name string
+		var p api.DocumentProgress
+		for {
+			line, err := reader.ReadString('\n')
+			require.NoError(t, err)
+			line = strings.TrimRight(line, "\n")
+			switch {
+			case line == "":
+				return name, p
+			case strings.HasPrefix(line, "event:"):
+				name = line[len("event:"):]
+			case strings.HasPrefix(line, "data:"):
+				require.NoError(t, json.Unmarshal([]byte(line[len("data:"):]), &p))
+			}
+		}
+	}
+
+	name, p := readEvent()
+	assert.Equal(t, ProgressSnapshot, name)
+	assert.Equal(t, docID, p.DocumentID)
+	assert.Equal(t, db.DocumentStatusSceneReady, p.Status)
+	assert.Equal(t, 2, p.SceneCount)
+	assert.Equal(t, 30, p.Percent)
+
+	// bailian 客户端为 nil，场景生成 panic 后永久失败，文档失败
+	mgr, err := newDocumentMgr(DocumentConfigEx{
+		config: DocumentConfig{Enable: true},
+		db:     service.db,
+		quota:  service.quota,
+		pubsub: service.pubsub,
+	}, nil)
+	require.NoError(t, err)
+	mgr.HandleImageGenTasks(ctx)
+
+	name, p = readEvent()
+	assert.Equal(t, ProgressSceneFailed, name)
+	assert.Equal(t, 1, p.FailedSceneCount)
+	assert.Equal(t, 65, p.Percent)
+	name, p = readEvent()
+	assert.Equal(t, ProgressSceneFailed, name)
+	assert.Equal(t, 2, p.FailedSceneCount)
+	assert.Equal(t, 100, p.Percent)
+	name, p = readEvent()
+	assert.Equal(t, ProgressDocumentFailed, name)
+	assert.Equal(t, db.DocumentStatusFailed, p.Status)
+
+	// 文档处理结束后服务端关闭连接
+	_, err = reader.ReadString('\n')
+	assert.ErrorIs(t, err, io.EOF)
+
+	// 已结束的文档只推送当前进度
+	resp2, err := http.Get(server.URL + "/v1/documents/" + docID + "/events")
+	require.NoError(t, err)
+	defer resp2.Body.Close()
+	body, err := io.ReadAll(resp2.Body)
+	require.NoError(t, err)
+	assert.Equal(t, 1, strings.Count(string(body), "event:"))
+	assert.Contains(t, string(body), "event:"+ProgressSnapshot)
+}
+
+func TestExports(t *testing.T) {
+	service, cleanup := setupTestService(t)
+	defer cleanup()
+
+	router := service.RegisterRouter(os.Stdout)
+	ctx := context.Background()
+	// 产物立即过期，便于验证清理
+	exports := newExportMgr(ExportConfig{Dir: filepath.Join(service.conf.Temp, "exports"), TTLHours: -1}, service.db)
+	require.NoError(t, os.MkdirAll(exports.conf.Dir, 0755))
+	service.exports = exports
+
+	// 执行到取消为止的导出格式
+	started := make(chan struct{})
+	exportFormats["block"] = exportFormat{ext: "bin", contentType: "application/octet-stream",
+		write: func(ctx context.Context, database db.IDataBase, doc db.Document, w io.Writer) error {
+			close(started)
+			<-ctx.Done()
+			return ctx.Err()
+		}}
+	defer delete(exportFormats, "block")
+
+	docID := db.MakeUUID()
+	_, err := service.db.CreateDocument(ctx, docID, "file-id", &api.CreateDocumentArgs{Name: "导出文档"})
+	require.NoError(t, err)
+	require.NoError(t, service.db.CreateChapters(ctx, docID, []string{"第一章内容", "第二章内容"}))
+	require.NoError(t, service.db.CreateScenes(ctx, []db.Scene{
+		{ID: db.MakeUUID(), ChapterID: db.MakeUUID(), DocumentID: docID, Index: 0, Content: "场景1", ImageURL: "http://img/1.png", AudioDurationMs: 2000, DisplayDurationMs: 3000},
+		{ID: db.MakeUUID(), ChapterID: db.MakeUUID(), DocumentID: docID, Index: 1, Content: "场景2", ImageURL: "http://img/2.png", AudioDurationMs: 1500, DisplayDurationMs: 3000},
+	}))
+
+	do := func(method, path string, body any) *httptest.ResponseRecorder {
+		var reader io.Reader
+		if body != nil {
+			data, err := json.Marshal(body)
+			require.NoError(t, err)
+			reader = bytes.NewReader(data)
+		}
+		req := httptest.NewRequest(method, path, reader)
+		req.Header.Set("Content-Type", "application/json")
+		w := httptest.NewRecorder()
+		router.ServeHTTP(w, req)
+		return w
+	}
+	code := func(w *httptest.ResponseRecorder) int {
+		var resp proto.BaseResponse
+		require.NoError(t, json.Unmarshal(w.Body.Bytes(), &resp))
+		return resp.Code
+	}
+	create := func(format string) api.Export {
+		var resp proto.BaseResponse
+		require.NoError(t, json.Unmarshal(do(http.MethodPost, "/v1/documents/"+docID+"/exports", api.CreateExportArgs{Format: format}).Body.Bytes(), &resp))
+		require.Equal(t, http.StatusOK, resp.Code)
+		data, err := json.Marshal(resp.Data)
+		require.NoError(t, err)
+		var e api.Export
+		require.NoError(t, json.Unmarshal(data, &e))
+		assert.Equal(t, db.ExportStatusPending, e.Status)
+		return e
+	}
+	list := func() []api.Export {
+		var resp proto.BaseResponse
+		require.NoError(t, json.Unmarshal(do(http.MethodGet, "/v1/documents/"+docID+"/exports", nil).Body.Bytes(), &resp))
+		require.Equal(t, http.StatusOK, resp.Code)
+		data, err := json.Marshal(resp.Data)
+		require.NoError(t, err)
+		var result api.ListExportsResult
+		require.NoError(t, json.Unmarshal(data, &result))
+		return result.Exports
+	}
+
+	// 参数校验
+	assert.Equal(t, http.StatusBadRequest, code(do(http.MethodPost, "/v1/documents/"+docID+"/exports", api.CreateExportArgs{Format: "pdf"})))
+	assert.Equal(t, 612, code(do(http.MethodPost, "/v1/documents/"+db.MakeUUID()+"/exports", api.CreateExportArgs{Format: "zip"})))
+	assert.Equal(t, http.StatusNotFound, code(do(http.MethodDelete, "/v1/exports/"+db.MakeUUID(), nil)))
+
+	// 待执行的任务取消后不再执行
+	canceled := create("zip")
+	assert.Equal(t, http.StatusOK, code(do(http.MethodDelete, "/v1/exports/"+canceled.ID, nil)))
+
+	// 执行 zip 导出并下载
+	zipped := create("zip")
+	assert.Equal(t, http.StatusNotFound, code(do(http.MethodGet, "/v1/exports/"+zipped.ID+"/download", nil)))
+	exports.HandleExports(ctx)
+	e, err := service.db.GetExport(ctx, zipped.ID)
+	require.NoError(t, err)
+	assert.Equal(t, db.ExportStatusSucceeded, e.Status)
+	assert.Positive(t, e.Size)
+
+	// 导出完成记录到租户动态
+	var resp proto.BaseResponse
+	require.NoError(t, json.Unmarshal(do(http.MethodGet, "/v1/activity", nil).Body.Bytes(), &resp))
+	require.Equal(t, http.StatusOK, resp.Code)
+	data, err := json.Marshal(resp.Data)
+	require.NoError(t, err)
+	var activity api.ListActivityResult
+	require.NoError(t, json.Unmarshal(data, &activity))
+	require.Len(t, activity.Activities, 1)
+	assert.Equal(t, db.EventExportReady, activity.Activities[0].Type)
+	assert.Equal(t, docID, activity.Activities[0].DocumentID)
+	assert.Contains(t, activity.Activities[0].Message, zipped.ID)
+
+	w := do(http.MethodGet, "/v1/exports/"+zipped.ID+"/download", nil)
+	require.Equal(t, http.StatusOK, w.Code)
+	assert.Equal(t, "application/zip", w.Header().Get("Content-Type"))
+	assert.EqualValues(t, e.Size, w.Body.Len())
+	zr, err := zip.NewReader(bytes.NewReader(w.Body.Bytes()), int64(w.Body.Len()))
+	require.NoError(t, err)
+	files := map[string]string{}
+	for _, f := range zr.File {
+		rc, err := f.Open()
+		require.NoError(t, err)
+		b, err := io.ReadAll(rc)
+		require.NoError(t, err)
+		rc.Close()
+		files[f.Name] = string(b)
+	}
+	require.Len(t, files, 5)
+	var manifest api.Manifest
+	require.NoError(t, json.Unmarshal([]byte(files["manifest.json"]), &manifest))
+	assert.Equal(t, docID, manifest.DocumentID)
+	require.Len(t, manifest.Scenes, 2)
+	assert.Equal(t, "http://img/1.png", manifest.Scenes[0].ImageURL)
+	assert.Contains(t, files["chapters/002.txt"], "第二章内容")
+	// 字幕按 manifest 的时间轴拼接
+	assert.Equal(t, "1\n00:00:00,000 --> 00:00:02,000\n场景1\n\n2\n00:00:03,000 --> 00:00:04,500\n场景2\n\n", files["subtitles.srt"])
+	assert.True(t, strings.HasPrefix(files["subtitles.vtt"], "WEBVTT\n\n00:00:00.000 --> 00:00:02.000\n"))
+
+	// 执行中的任务被取消后中断，不保留产物
+	blocked := create("block")
+	done := make(chan struct{})
+	go func() {
+		exports.HandleExports(ctx)
+		close(done)
+	}()
+	<-started
+	assert.Equal(t, http.StatusOK, code(do(http.MethodDelete, "/v1/exports/"+blocked.ID, nil)))
+	select {
+	case <-done:
+	case <-time.After(3 * time.Second):
+		t.Fatal("running export not canceled")
+	}
+
+	statuses := map[string]string{}
+	for _, e := range list() {
+		statuses[e.ID] = e.Status
+	}
+	assert.Equal(t, map[string]string{
+		canceled.ID: db.ExportStatusCanceled,
+		zipped.ID:   db.ExportStatusSucceeded,
+		blocked.ID:  db.ExportStatusCanceled,
+	}, statuses)
+	entries, err := os.ReadDir(exports.conf.Dir)
+	require.NoError(t, err)
+	assert.Len(t, entries, 1)
+
+	// 过期产物被清理，记录保留
+	exports.CleanupExpired(ctx)
+	e, err = service.db.GetExport(ctx, zipped.ID)
+	require.NoError(t, err)
+	assert.Equal(t, db.ExportStatusExpired, e.Status)
+	_, err = os.Stat(filepath.Join(exports.conf.Dir, e.Path))
+	assert.True(t, os.IsNotExist(err))
+	assert.Equal(t, http.StatusNotFound, code(do(http.MethodGet, "/v1/exports/"+zipped.ID+"/download", nil)))
+
+	// 已结束的任务删除记录
+	assert.Equal(t, http.StatusOK, code(do(http.MethodDelete, "/v1/exports/"+zipped.ID, nil)))
+	assert.Len(t, list(), 2)
+}
+
+func TestDocumentBundle(t *testing.T) {
+	service, cleanup := setupTestService(t)
+	defer cleanup()
+
+	router := service.RegisterRouter(os.Stdout)
+	ctx := context.Background()
+	importBundle := func(b []byte, autoRename bool) proto.BaseResponse {
+		body := &bytes.Buffer{}
+		writer := multipart.NewWriter(body)
+		if autoRename {
+			writer.WriteField("auto_rename", "true")
+		}
+		part, err := writer.CreateFormFile("file", "bundle.json")
+		require.NoError(t, err)
+		part.Write(b)
+		writer.Close()
+
+		req := httptest.NewRequest(http.MethodPost, "/v1/documents:import", body)
+		req.Header.Set("Content-Type", writer.FormDataContentType())
+		w := httptest.NewRecorder()
+		router.ServeHTTP(w, req)
+		var resp proto.BaseResponse
+		require.NoError(t, json.Unmarshal(w.Body.Bytes(), &resp))
+		return resp
+	}
+
+	docID := db.MakeUUID()
+	_, err := service.db.CreateDocument(ctx, docID, "file-id", &api.CreateDocumentArgs{Name: "迁移文档", Priority: db.DocumentPriorityHigh})
+	require.NoError(t, err)
+	require.NoError(t, service.db.CreateChapters(ctx, docID, []string{"第一章内容", "第二章内容"}))
+	chapters, err := service.db.ListChapters(ctx, docID)
+	require.NoError(t, err)
+	require.Len(t, chapters, 2)
+	scenes := []db.Scene{
+		{ID: db.MakeUUID(), ChapterID: chapters[0].ID, DocumentID: docID, Index: 0, Content: "场景1", ImageURL: "http://media/1.png", Status: db.SceneStatusReady},
+		{ID: db.MakeUUID(), ChapterID: chapters[1].ID, DocumentID: docID, Index: 1, Content: "场景2", Status: db.SceneStatusFailed, Error: "timeout"},
+	}
+	require.NoError(t, service.db.CreateScenes(ctx, scenes))
+	require.NoError(t, service.db.UpdateChapterSceneIDs(ctx, chapters[0].ID, []string{scenes[0].ID}))
+	require.NoError(t, service.db.UpdateChapterCover(ctx, chapters[0].ID, scenes[0].ID, "http://media/1.png"))
+	require.NoError(t, service.db.CreateRoles(ctx, []db.Role{{ID: db.MakeUUID(), DocumentID: docID, Name: "张三", PortraitURL: "http://media/p.png"}}))
+	require.NoError(t, service.db.UpdateDocumentStatus(ctx, docID, db.DocumentStatusCompletedWithErrors))
+
+	req := httptest.NewRequest(http.MethodGet, "/v1/documents/"+docID+"/export?format=bundle", nil)
+	w := httptest.NewRecorder()
+	router.ServeHTTP(w, req)
+	require.Equal(t, http.StatusOK, w.Code)
+	assert.Equal(t, "application/json", w.Header().Get("Content-Type"))
+	assert.Contains(t, w.Header().Get("Content-Disposition"), docID+".json")
+	exported := w.Body.Bytes()
+
+	var bundle api.DocumentBundle
+	require.NoError(t, json.Unmarshal(exported, &bundle))
+	assert.Equal(t, api.BundleVersion, bundle.Version)
+	assert.Equal(t, "迁移文档", bundle.Document.Name)
+	assert.Equal(t, "high", bundle.Document.Priority)
+	require.Len(t, bundle.Chapters, 2)
+	require.Len(t, bundle.Scenes, 2)
+	require.Len(t, bundle.Roles, 1)
+	assert.Equal(t, []string{scenes[0].ID}, bundle.Chapters[0].SceneIDs)
+
+	req = httptest.NewRequest(http.MethodGet, "/v1/documents/"+docID+"/export?format=tar", nil)
+	w = httptest.NewRecorder()
+	router.ServeHTTP(w, req)
+	var resp proto.BaseResponse
+	require.NoError(t, json.Unmarshal(w.Body.Bytes(), &resp))
+	assert.Equal(t, http.StatusBadRequest, resp.Code)
+
+	// 同一环境中导入时名称冲突，auto_rename 时改名
+	resp = importBundle(exported, false)
+	assert.Equal(t, ErrExistingDocumentCode, resp.Code)
+	resp = importBundle(exported, true)
+	require.Equal(t, http.StatusOK, resp.Code, resp.Message)
+	data, err := json.Marshal(resp.Data)
+	require.NoError(t, err)
+	var imported api.Document
+	require.NoError(t, json.Unmarshal(data, &imported))
+	assert.NotEqual(t, docID, imported.ID)
+	assert.Equal(t, "迁移文档 (2)", imported.Name)
+	assert.Equal(t, db.DocumentStatusCompletedWithErrors, imported.Status)
+	assert.Equal(t, "high", imported.Priority)
+	assert.Equal(t, 2, imported.SceneCount)
+	assert.Equal(t, 1, imported.FailedSceneCount)
+
+	// 导入的章节、场景和角色使用新的 id，引用关系随之替换
+	newChapters, err := service.db.ListChapters(ctx, imported.ID)
+	require.NoError(t, err)
+	require.Len(t, newChapters, 2)
+	newScenes, err := service.db.ListScenesByDocument(ctx, imported.ID)
+	require.NoError(t, err)
+	require.Len(t, newScenes, 2)
+	assert.NotEqual(t, chapters[0].ID, newChapters[0].ID)
+	assert.Equal(t, "第一章内容", newChapters[0].Content)
+	assert.Equal(t, []string{newScenes[0].ID}, newChapters[0].SceneIDs)
+	assert.Equal(t, newScenes[0].ID, newChapters[0].CoverSceneID)
+	assert.Equal(t, newChapters[0].ID, newScenes[0].ChapterID)
+	assert.Equal(t, "http://media/1.png", newScenes[0].ImageURL)
+	assert.Equal(t, "timeout", newScenes[1].Error)
+	roles, err := service.db.ListRolesByDocument(ctx, imported.ID)
+	require.NoError(t, err)
+	require.Len(t, roles, 1)
+	assert.Equal(t, "http://media/p.png", roles[0].PortraitURL)
+	logs, err := service.db.ListAuditLogs(ctx, db.AuditLogFilter{DocumentID: imported.ID}, 0, 10)
+	require.NoError(t, err)
+	require.Len(t, logs, 1)
+	assert.Equal(t, "import", logs[0].Action)
+
+	// 审核扣留的图片和原因不导出；导入时不信任包内的审核结果，未通过审核的场景重新生成并审核
+	require.NoError(t, service.db.BlockScene(ctx, scenes[0].ID, "violence", "http://media/held.png"))
+	req = httptest.NewRequest(http.MethodGet, "/v1/documents/"+docID+"/export?format=bundle", nil)
+	w = httptest.NewRecorder()
+	router.ServeHTTP(w, req)
+	require.Equal(t, http.StatusOK, w.Code)
+	assert.NotContains(t, w.Body.String(), "http://media/held.png")
+	assert.NotContains(t, w.Body.String(), "violence")
+	var raw map[string]any
+	require.NoError(t, json.Unmarshal(w.Body.Bytes(), &raw))
+	for _, scene := range raw["scenes"].([]any) {
+		scene.(map[string]any)["held_image_url"] = "http://evil/held.png"
+		scene.(map[string]any)["moderation_passed"] = true
+	}
+	b, err := json.Marshal(raw)
+	require.NoError(t, err)
+	resp = importBundle(b, true)
+	require.Equal(t, http.StatusOK, resp.Code, resp.Message)
+	data, err = json.Marshal(resp.Data)
+	require.NoError(t, err)
+	require.NoError(t, json.Unmarshal(data, &imported))
+	assert.Equal(t, db.DocumentStatusSceneReady, imported.Status)
+	newScenes, err = service.db.ListScenesByDocument(ctx, imported.ID)
+	require.NoError(t, err)
+	require.Len(t, newScenes, 2)
+	assert.Empty(t, newScenes[0].Status)
+	assert.True(t, newScenes[0].MediaStale)
+	for _, scene := range newScenes {
+		assert.Empty(t, scene.HeldImageURL)
+		assert.Empty(t, scene.ModerationReason)
+		assert.False(t, scene.ModerationPassed)
+	}
+
+	// 未提取角色的文档和引用不存在章节的场景不能导入
+	bundle.Document.Status = db.DocumentStatusChapterReady
+	b, err = json.Marshal(bundle)
+	require.NoError(t, err)
+	assert.Equal(t, http.StatusBadRequest, importBundle(b, true).Code)
+	bundle.Document.Status = db.DocumentStatusImgReady
+	bundle.Scenes[0].ChapterID = "unknown"
+	b, err = json.Marshal(bundle)
+	require.NoError(t, err)
+	resp = importBundle(b, true)
+	assert.Equal(t, http.StatusBadRequest, resp.Code)
+	assert.Contains(t, resp.Message, "unknown chapter")
+	assert.Equal(t, http.StatusBadRequest, importBundle([]byte("{"), true).Code)
+}
+
+func TestDocumentRetry(t *testing.T) {
+	service, cleanup := setupTestService(t)
+	defer cleanup()
+
+	router := service.RegisterRouter(os.Stdout)
+	ctx := context.Background()
+
+	// 百炼接口始终失败
+	var calls atomic.Int32
+	bailianServer := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		calls.Add(1)
+		w.WriteHeader(http.StatusInternalServerError)
+	}))
+	defer bailianServer.Close()
+	bailianClient, err := bailian.NewClient(bailian.Config{BaseURL: bailianServer.URL, APIKey: "test"})
+	require.NoError(t, err)
+	mgr, err := newDocumentMgr(DocumentConfigEx{
+		config: DocumentConfig{Enable: true, Retry: map[string]RetryPolicy{
+			stageScene: {MaxAttempts: 2, BackoffSecs: 60},
+		}},
+		db:    service.db,
+		quota: service.quota,
+	}, bailianClient)
+	require.NoError(t, err)
+	assert.Equal(t, defaultRetryPolicy, mgr.config.Retry[stageImage])
+	assert.Equal(t, 1800, mgr.config.Retry[stageScene].MaxBackoffSecs)
+
+	docID := db.MakeUUID()
+	_, err = service.db.CreateDocument(ctx, docID, "file-id", &api.CreateDocumentArgs{Name: "重试文档"})
+	require.NoError(t, err)
+	require.NoError(t, service.db.CreateChapters(ctx, docID, []string{"第一章"}))
+	require.NoError(t, service.db.UpdateDocumentStatus(ctx, docID, db.DocumentStatusRoleReady))
+
+	getDocument := func() api.Document {
+		req := httptest.NewRequest(http.MethodGet, "/v1/documents/"+docID, nil)
+		w := httptest.NewRecorder()
+		router.ServeHTTP(w, req)
+		var resp proto.BaseResponse
+		require.NoError(t, json.Unmarshal(w.Body.Bytes(), &resp))
+		require.Equal(t, http.StatusOK, resp.Code)
+		data, err := json.Marshal(resp.Data)
+		require.NoError(t, err)
+		var doc api.Document
+		require.NoError(t, json.Unmarshal(data, &doc))
+		return doc
+	}
+
+	// 第一次失败后按退避时间推迟，期间不再处理
+	mgr.HandleDocumentScenceTasks(ctx)
+	doc, err := service.db.GetDocument(ctx, docID)
+	require.NoError(t, err)
+	assert.Equal(t, db.DocumentStatusRoleReady, doc.Status)
+	assert.Equal(t, 1, doc.Attempts)
+	assert.NotEmpty(t, doc.LastError)
+	require.NotNil(t, doc.NextAttemptAt)
+	assert.WithinRange(t, *doc.NextAttemptAt, time.Now().Add(47*time.Second), time.Now().Add(73*time.Second))
+
+	apiDoc := getDocument()
+	assert.Equal(t, 1, apiDoc.Attempts)
+	assert.Equal(t, doc.LastError, apiDoc.LastError)
+	assert.NotEmpty(t, apiDoc.NextAttemptAt)
+
+	n := calls.Load()
+	mgr.HandleDocumentScenceTasks(ctx)
+	assert.Equal(t, n, calls.Load())
+
+	// 达到最大次数后文档失败，保留失败原因
+	require.NoError(t, service.db.UpdateDocumentAttempt(ctx, docID, 1, doc.LastError, time.Now()))
+	mgr.HandleDocumentScenceTasks(ctx)
+	apiDoc = getDocument()
+	assert.Equal(t, db.DocumentStatusFailed, apiDoc.Status)
+	assert.Equal(t, 2, apiDoc.Attempts)
+	assert.NotEmpty(t, apiDoc.LastError)
+	assert.Empty(t, apiDoc.NextAttemptAt)
+	assert.Equal(t, stageScene, apiDoc.FailedStage)
+
+	// 进入下一阶段时清空重试记录
+	require.NoError(t, service.db.UpdateDocumentStatus(ctx, docID, db.DocumentStatusSceneReady))
+	doc, err = service.db.GetDocument(ctx, docID)
+	require.NoError(t, err)
+	assert.Zero(t, doc.Attempts)
+	assert.Empty(t, doc.LastError)
+	assert.Nil(t, doc.NextAttemptAt)
+
+	// 退避时间指数增长，不超过上限
+	policy := RetryPolicy{BackoffSecs: 10, MaxBackoffSecs: 60}
+	assert.Equal(t, 10*time.Second, policy.backoff(1))
+	assert.Equal(t, 40*time.Second, policy.backoff(3))
+	assert.Equal(t, 60*time.Second, policy.backoff(4))
+	assert.Equal(t, 60*time.Second, policy.backoff(100))
+	policy.Jitter = 0.5
+	for i := 0; i < 20; i++ {
+		d := policy.backoff(2)
+		assert.True(t, d >= 10*time.Second && d <= 30*time.Second, d)
+	}
+}
+
+func TestRunCompare(t *testing.T) {
+	service, cleanup := setupTestService(t)
+	defer cleanup()
+
+	router := service.RegisterRouter(os.Stdout)
+	ctx := context.Background()
+
+	mgr, err := newDocumentMgr(DocumentConfigEx{config: DocumentConfig{Enable: true}, db: service.db}, nil)
+	require.NoError(t, err)
+
+	docID := db.MakeUUID()
+	_, err = service.db.CreateDocument(ctx, docID, "file-id", &api.CreateDocumentArgs{Name: "对比文档"})
+	require.NoError(t, err)
+	doc, err := service.db.GetDocument(ctx, docID)
+	require.NoError(t, err)
+
+	// 第一次处理：两个场景
+	chapterID := db.MakeUUID()
+	require.NoError(t, service.db.CreateScenes(ctx, []db.Scene{
+		{ID: db.MakeUUID(), ChapterID: chapterID, DocumentID: docID, Index: 0, Content: "少年站在山顶", ImageURL: "http://img/a0", VoiceURL: "http://voice/a0", Status: db.SceneStatusReady},
+		{ID: db.MakeUUID(), ChapterID: chapterID, DocumentID: docID, Index: 1, Content: "大雨落下", ImageURL: "http://img/a1", VoiceURL: "http://voice/a1", Status: db.SceneStatusReady},
+	}))
+	_, err = mgr.completeDocumentImageGen(ctx, doc)
+	require.NoError(t, err)
+
+	// 重新处理：第一个场景不变，第二个场景改写，新增第三个场景
+	require.NoError(t, service.db.DeleteScenesByDocument(ctx, docID))
+	require.NoError(t, service.db.CreateScenes(ctx, []db.Scene{
+		{ID: db.MakeUUID(), ChapterID: chapterID, DocumentID: docID, Index: 0, Content: "少年站在山顶", ImageURL: "http://img/a0", VoiceURL: "http://voice/a0", Status: db.SceneStatusReady},
+		{ID: db.MakeUUID(), ChapterID: chapterID, DocumentID: docID, Index: 1, Content: "大雪落下", ImageURL: "http://img/b1", VoiceURL: "http://voice/b1", Status: db.SceneStatusReady},
+		{ID: db.MakeUUID(), ChapterID: chapterID, DocumentID: docID, Index: 2, Content: "天亮了", ImageURL: "http://img/b2", VoiceURL: "http://voice/b2", Status: db.SceneStatusReady},
+	}))
+	_, err = mgr.completeDocumentImageGen(ctx, doc)
+	require.NoError(t, err)
+
+	get := func(path string) (int, []byte) {
+		req := httptest.NewRequest(http.MethodGet, "/v1/documents/"+docID+path, nil)
+		w := httptest.NewRecorder()
+		router.ServeHTTP(w, req)
+		var resp proto.BaseResponse
+		require.NoError(t, json.Unmarshal(w.Body.Bytes(), &resp))
+		data, err := json.Marshal(resp.Data)
+		require.NoError(t, err)
+		return resp.Code, data
+	}
+
+	code, data := get("/runs")
+	require.Equal(t, http.StatusOK, code)
+	var runs api.ListDocumentRunsResult
+	require.NoError(t, json.Unmarshal(data, &runs))
+	require.Len(t, runs.Runs, 2)
+	assert.Equal(t, 2, runs.Runs[0].Seq)
+	assert.Equal(t, 3, runs.Runs[0].ImageCount)
+	assert.Equal(t, db.DocumentStatusImgReady, runs.Runs[0].Status)
+
+	code, data = get("/runs/1/compare/2")
+	require.Equal(t, http.StatusOK, code)
+	var cmp api.RunComparison
+	require.NoError(t, json.Unmarshal(data, &cmp))
+	assert.Equal(t, 1, cmp.Old.Seq)
+	assert.Equal(t, 2, cmp.New.Seq)
+	assert.Equal(t, api.CostDelta{Images: 1, Voices: 1}, cmp.CostDelta)
+	require.Len(t, cmp.Scenes, 3)
+
+	assert.Equal(t, SceneUnchanged, cmp.Scenes[0].Change)
+	assert.Empty(t, cmp.Scenes[0].PromptDiff)
+
+	assert.Equal(t, SceneChanged, cmp.Scenes[1].Change)
+	assert.Equal(t, "http://img/a1", cmp.Scenes[1].OldImageURL)
+	assert.Equal(t, "http://img/b1", cmp.Scenes[1].NewImageURL)
+	assert.Equal(t, []api.DiffSegment{
+		{Op: "equal", Text: "大"},
+		{Op: "delete", Text: "雨"},
+		{Op: "insert", Text: "雪"},
+		{Op: "equal", Text: "落下"},
+	}, cmp.Scenes[1].PromptDiff)
+
+	assert.Equal(t, SceneAdded, cmp.Scenes[2].Change)
+	assert.Empty(t, cmp.Scenes[2].OldSceneID)
+	assert.Equal(t, "http://voice/b2", cmp.Scenes[2].NewVoiceURL)
+
+	// 反向对比时新增场景变为删除
+	code, data = get("/runs/2/compare/1")
+	require.Equal(t, http.StatusOK, code)
+	require.NoError(t, json.Unmarshal(data, &cmp))
+	assert.Equal(t, api.CostDelta{Images: -1, Voices: -1}, cmp.CostDelta)
+	require.Len(t, cmp.Scenes, 3)
+	assert.Equal(t, SceneRemoved, cmp.Scenes[2].Change)
+
+	code, _ = get("/runs/1/compare/9")
+	assert.Equal(t, http.StatusNotFound, code)
+	code, _ = get("/runs/1/compare/x")
+	assert.Equal(t, http.StatusBadRequest, code)
+}
+
+func TestDocumentPriority(t *testing.T) {
+	service, cleanup := setupTestService(t)
+	defer cleanup()
+
+	router := service.RegisterRouter(os.Stdout)
+	ctx := context.Background()
+
+	_, err := service.CreateDocument(ctx, 0, "优先级文档", "urgent", false, false, "a.txt", 1, strings.NewReader("x"))
+	var apiErr *proto.ApiError
+	require.ErrorAs(t, err, &apiErr)
+	assert.Equal(t, http.StatusBadRequest, apiErr.Code)
+
+	create := func(name string, priority int) string {
+		docID := db.MakeUUID()
+		_, err := service.db.CreateDocument(ctx, docID, "file-id", &api.CreateDocumentArgs{Name: name, Priority: priority})
+		require.NoError(t, err)
+		return docID
+	}
+	lowID := create("大长篇", db.DocumentPriorityLow)
+	normalID := create("普通文档", db.DocumentPriorityNormal)
+	highID := create("加急文档", db.DocumentPriorityHigh)
+
+	// 同一阶段按优先级从高到低处理
+	for _, id := range []string{lowID, normalID} {
+		require.NoError(t, service.db.UpdateDocumentStatus(ctx, id, db.DocumentStatusSceneReady))
+	}
+	docs, err := service.db.ListSceneReadyDocuments(ctx)
+	require.NoError(t, err)
+	require.Len(t, docs, 2)
+	assert.Equal(t, normalID, docs[0].ID)
+	assert.Equal(t, lowID, docs[1].ID)
+	require.NoError(t, service.db.UpdateDocumentStatus(ctx, normalID, db.DocumentStatusImgReady))
+
+	req := httptest.NewRequest(http.MethodGet, "/v1/documents/"+highID, nil)
+	w := httptest.NewRecorder()
+	router.ServeHTTP(w, req)
+	assert.Contains(t, w.Body.String(), `"priority":"high"`)
+
+	// 低优先级文档生成第一个场景时，加急文档进入生成阶段，低优先级文档让出
+	var once sync.Once
+	bailianServer := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		once.Do(func() {
+			require.NoError(t, service.db.UpdateDocumentStatus(ctx, highID, db.DocumentStatusSceneReady))
+		})
+		w.WriteHeader(http.StatusInternalServerError)
+	}))
+	defer bailianServer.Close()
+	bailianClient, err := bailian.NewClient(bailian.Config{BaseURL: bailianServer.URL, APIKey: "test"})
+	require.NoError(t, err)
+	mgr, err := newDocumentMgr(DocumentConfigEx{config: DocumentConfig{Enable: true}, db: service.db, quota: service.quota}, bailianClient)
+	require.NoError(t, err)
+
+	chapterID := db.MakeUUID()
+	var scenes []db.Scene
+	for i := 0; i < 3; i++ {
+		scenes = append(scenes, db.Scene{ID: db.MakeUUID(), ChapterID: chapterID, DocumentID: lowID, Index: i, Content: fmt.Sprintf("场景%d", i)})
+	}
+	require.NoError(t, service.db.CreateScenes(ctx, scenes))
+
+	mgr.HandleImageGenTasks(ctx)
+
+	high, err := service.db.GetDocument(ctx, highID)
+	require.NoError(t, err)
+	assert.Equal(t, db.DocumentStatusImgReady, high.Status)
+
+	// 让出不计入文档失败次数，让出前处理过的场景在本轮再次处理
+	low, err := service.db.GetDocument(ctx, lowID)
+	require.NoError(t, err)
+	assert.Equal(t, db.DocumentStatusSceneReady, low.Status)
+	assert.Equal(t, 1, low.Attempts)
+	for i, want := range []int{2, 1, 1} {
+		scene, err := service.db.GetScene(ctx, scenes[i].ID)
+		require.NoError(t, err)
+		assert.Equal(t, want, scene.Attempts, i)
+	}
+
+	// 高优先级文档配额不足无法推进时，低优先级文档每轮只让出一次
+	quotaMgr := newQuotaMgr(QuotaConfig{Tenants: map[string]api.QuotaLimits{"7": {MaxImagesPerDay: 1}}}, service.db)
+	require.NoError(t, quotaMgr.RecordGeneration(ctx, 7, 1, 1))
+	mgr.quota = quotaMgr
+	stuckID := db.MakeUUID()
+	_, err = service.db.CreateDocument(ctx, stuckID, "file-id", &api.CreateDocumentArgs{Name: "配额不足", UserID: 7, Priority: db.DocumentPriorityHigh})
+	require.NoError(t, err)
+	require.NoError(t, service.db.UpdateDocumentStatus(ctx, stuckID, db.DocumentStatusSceneReady))
+	require.NoError(t, service.db.CreateScenes(ctx, []db.Scene{{ID: db.MakeUUID(), ChapterID: chapterID, DocumentID: stuckID, Content: "场景"}}))
+	require.NoError(t, service.db.UpdateDocumentAttempt(ctx, lowID, 0, "", time.Now()))
+
+	done := make(chan struct{})
+	go func() {
+		mgr.HandleImageGenTasks(ctx)
+		close(done)
+	}()
+	select {
+	case <-done:
+	case <-time.After(10 * time.Second):
+		t.Fatal("image generation keeps yielding to a stuck document")
+	}
+	for i, want := range []int{3, 2, 2} {
+		scene, err := service.db.GetScene(ctx, scenes[i].ID)
+		require.NoError(t, err)
+		assert.Equal(t, want, scene.Attempts, i)
+	}
+}
+
+func TestPauseDocument(t *testing.T) {
+	service, cleanup := setupTestService(t)
+	defer cleanup()
+
+	router := service.RegisterRouter(os.Stdout)
+	ctx := context.Background()
+
+	post := func(path string) (int, api.Document) {
+		req := httptest.NewRequest(http.MethodPost, "/v1/documents/"+path, nil)
+		w := httptest.NewRecorder()
+		router.ServeHTTP(w, req)
+		var resp proto.BaseResponse
+		require.NoError(t, json.Unmarshal(w.Body.Bytes(), &resp))
+		var doc api.Document
+		if resp.Code == http.StatusOK {
+			data, err := json.Marshal(resp.Data)
+			require.NoError(t, err)
+			require.NoError(t, json.Unmarshal(data, &doc))
+		}
+		return resp.Code, doc
+	}
+
+	docID := db.MakeUUID()
+	_, err := service.db.CreateDocument(ctx, docID, "file-id", &api.CreateDocumentArgs{Name: "暂停文档"})
+	require.NoError(t, err)
+	require.NoError(t, service.db.UpdateDocumentStatus(ctx, docID, db.DocumentStatusSceneReady))
+	chapterID := db.MakeUUID()
+	var scenes []db.Scene
+	for i := 0; i < 3; i++ {
+		scenes = append(scenes, db.Scene{ID: db.MakeUUID(), ChapterID: chapterID, DocumentID: docID, Index: i, Content: fmt.Sprintf("场景%d", i)})
+	}
+	require.NoError(t, service.db.CreateScenes(ctx, scenes))
+
+	// 生成第一个场景时暂停，当前场景完成后中断
+	var calls atomic.Int32
+	bailianServer := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if calls.Add(1) == 1 {
+			code, doc := post(docID + "/pause")
+			assert.Equal(t, http.StatusOK, code)
+			assert.True(t, doc.Paused)
+		}
+		w.WriteHeader(http.StatusInternalServerError)
+	}))
+	defer bailianServer.Close()
+	bailianClient, err := bailian.NewClient(bailian.Config{BaseURL: bailianServer.URL, APIKey: "test"})
+	require.NoError(t, err)
+	mgr, err := newDocumentMgr(DocumentConfigEx{config: DocumentConfig{Enable: true}, db: service.db, quota: service.quota}, bailianClient)
+	require.NoError(t, err)
+
+	mgr.HandleImageGenTasks(ctx)
+	for i, want := range []int{1, 0, 0} {
+		scene, err := service.db.GetScene(ctx, scenes[i].ID)
+		require.NoError(t, err)
+		assert.Equal(t, want, scene.Attempts, i)
+	}
+	doc, err := service.db.GetDocument(ctx, docID)
+	require.NoError(t, err)
+	assert.True(t, doc.Paused)
+	assert.Equal(t, db.DocumentStatusSceneReady, doc.Status)
+	assert.Zero(t, doc.Attempts)
+
+	// 暂停期间不再处理，重复暂停不报错
+	n := calls.Load()
+	mgr.HandleImageGenTasks(ctx)
+	assert.Equal(t, n, calls.Load())
+	code, _ := post(docID + "/pause")
+	assert.Equal(t, http.StatusOK, code)
+
+	// 恢复后从剩余场景继续
+	code, apiDoc := post(docID + "/resume")
+	require.Equal(t, http.StatusOK, code)
+	assert.False(t, apiDoc.Paused)
+	mgr.HandleImageGenTasks(ctx)
+	assert.Greater(t, calls.Load(), n)
+	for i, want := range []int{2, 1, 1} {
+		scene, err := service.db.GetScene(ctx, scenes[i].ID)
+		require.NoError(t, err)
+		assert.Equal(t, want, scene.Attempts, i)
+	}
+
+	events, err := service.db.ListEvents(ctx, 0, 0, 10)
+	require.NoError(t, err)
+	var types []string
+	for _, e := range events {
+		if e.DocumentID == docID {
+			types = append(types, e.Type)
+		}
+	}
+	assert.ElementsMatch(t, []string{db.EventDocumentPaused, db.EventDocumentResumed}, types)
+
+	// 已结束的文档不能暂停
+	require.NoError(t, service.db.UpdateDocumentStatus(ctx, docID, db.DocumentStatusImgReady))
+	code, _ = post(docID + "/pause")
+	assert.Equal(t, http.StatusBadRequest, code)
+	code, _ = post(db.MakeUUID() + "/pause")
+	assert.Equal(t, 612, code)
+}
+
+func TestSceneGenerations(t *testing.T) {
+	service, cleanup := setupTestService(t)
+	defer cleanup()
+
+	router := service.RegisterRouter(os.Stdout)
+	ctx := context.Background()
+
+	// 百炼图片和语音接口共用同一地址，返回同时包含图片和语音的响应
+	var calls atomic.Int32
+	var bailianServer *httptest.Server
+	bailianServer = httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodPost {
+			w.WriteHeader(http.StatusNotFound)
+			return
+		}
+		n := calls.Add(1)
+		fmt.Fprintf(w, `{"output":{"choices":[{"message":{"content":[{"image":"http://img/%d"}]}}],"audio":{"url":"%s/voice/%d"}}}`, n, bailianServer.URL, n)
+	}))
+	defer bailianServer.Close()
+	bailianClient, err := bailian.NewClient(bailian.Config{BaseURL: bailianServer.URL, APIKey: "test"})
+	require.NoError(t, err)
+	mgr, err := newDocumentMgr(DocumentConfigEx{config: DocumentConfig{Enable: true, KeepGenerations: 2}, db: service.db}, bailianClient)
+	require.NoError(t, err)
+
+	docID := db.MakeUUID()
+	doc, err := service.db.CreateDocument(ctx, docID, "file-id", &api.CreateDocumentArgs{Name: "生成记录文档"})
+	require.NoError(t, err)
+	scene := db.Scene{ID: db.MakeUUID(), ChapterID: db.MakeUUID(), DocumentID: docID, Content: "场景", ImageURL: "http://img/old", VoiceURL: "http://voice/old"}
+	require.NoError(t, service.db.CreateScenes(ctx, []db.Scene{scene}))
+
+	// 已有媒体在首次生成时补记，保留当前和最近 2 次历史生成
+	for i := 0; i < 3; i++ {
+		current, err := service.db.GetScene(ctx, scene.ID)
+		require.NoError(t, err)
+		require.NoError(t, mgr.handleSceneImageGen(ctx, *doc, current, nil))
+	}
+
+	do := func(method, path string) (int, []byte) {
+		req := httptest.NewRequest(method, "/v1/scenes/"+path, nil)
+		w := httptest.NewRecorder()
+		router.ServeHTTP(w, req)
+		var resp proto.BaseResponse
+		require.NoError(t, json.Unmarshal(w.Body.Bytes(), &resp))
+		data, err := json.Marshal(resp.Data)
+		require.NoError(t, err)
+		return resp.Code, data
+	}
+	list := func() []api.SceneGeneration {
+		code, data := do(http.MethodGet, scene.ID+"/generations")
+		require.Equal(t, http.StatusOK, code)
+		var ret api.ListSceneGenerationsResult
+		require.NoError(t, json.Unmarshal(data, &ret))
+		return ret.Generations
+	}
+
+	gens := list()
+	require.Len(t, gens, 3)
+	assert.Equal(t, []int{4, 3, 2}, []int{gens[0].Seq, gens[1].Seq, gens[2].Seq})
+	assert.True(t, gens[0].Active)
+	assert.False(t, gens[1].Active)
+	assert.Equal(t, "http://img/5", gens[0].ImageURL)
+	assert.Equal(t, "http://img/1", gens[2].ImageURL)
+
+	// 回滚到第 2 次生成
+	code, data := do(http.MethodPost, scene.ID+"/generations/2/activate")
+	require.Equal(t, http.StatusOK, code)
+	var apiScene api.Scene
+	require.NoError(t, json.Unmarshal(data, &apiScene))
+	assert.Equal(t, "http://img/1", apiScene.ImageURL)
+	assert.Equal(t, gens[2].VoiceURL, apiScene.VoiceURL)
+	gens = list()
+	assert.False(t, gens[0].Active)
+	assert.True(t, gens[2].Active)
+
+	// 超出保留次数的生成已清理
+	code, _ = do(http.MethodPost, scene.ID+"/generations/1/activate")
+	assert.Equal(t, http.StatusNotFound, code)
+	code, _ = do(http.MethodPost, scene.ID+"/generations/x/activate")
+	assert.Equal(t, http.StatusBadRequest, code)
+	code, _ = do(http.MethodGet, db.MakeUUID()+"/generations")
+	assert.Equal(t, http.StatusNotFound, code)
+
+	// 删除场景时一并删除生成记录
+	require.NoError(t, service.db.DeleteScenesByDocument(ctx, docID))
+	left, err := service.db.ListSceneGenerations(ctx, scene.ID)
+	require.NoError(t, err)
+	assert.Empty(t, left)
+}
+
+func TestSceneImageVersions(t *testing.T) {
+	service, cleanup := setupTestService(t)
+	defer cleanup()
+
+	router := service.RegisterRouter(os.Stdout)
+	ctx := context.Background()
+
+	var calls atomic.Int32
+	var bailianServer *httptest.Server
+	bailianServer = httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodPost {
+			w.WriteHeader(http.StatusNotFound)
+			return
+		}
+		n := calls.Add(1)
+		fmt.Fprintf(w, `{"output":{"choices":[{"message":{"content":[{"image":"http://img/%d"}]}}],"audio":{"url":"%s/voice/%d"}}}`, n, bailianServer.URL, n)
+	}))
+	defer bailianServer.Close()
+	bailianClient, err := bailian.NewClient(bailian.Config{BaseURL: bailianServer.URL, APIKey: "test"})
+	require.NoError(t, err)
+	mgr, err := newDocumentMgr(DocumentConfigEx{config: DocumentConfig{Enable: true, KeepImageVersions: 3}, db: service.db}, bailianClient)
+	require.NoError(t, err)
+
+	docID := db.MakeUUID()
+	doc, err := service.db.CreateDocument(ctx, docID, "file-id", &api.CreateDocumentArgs{Name: "图片版本文档"})
+	require.NoError(t, err)
+	scene := db.Scene{ID: db.MakeUUID(), ChapterID: db.MakeUUID(), DocumentID: docID, Content: "场景", ImageURL: "http://img/old", VoiceURL: "http://voice/old"}
+	require.NoError(t, service.db.CreateScenes(ctx, []db.Scene{scene}))
+
+	// 已有图片在首次生成时补记，只保留最近 3 个版本
+	for i := 0; i < 3; i++ {
+		current, err := service.db.GetScene(ctx, scene.ID)
+		require.NoError(t, err)
+		require.NoError(t, mgr.handleSceneImageGen(ctx, *doc, current, nil))
+	}
+
+	do := func(method, path string, body any) (int, []byte) {
+		var reader io.Reader
+		if body != nil {
+			data, err := json.Marshal(body)
+			require.NoError(t, err)
+			reader = bytes.NewReader(data)
+		}
+		req := httptest.NewRequest(method, "/v1/scenes/"+path, reader)
+		req.Header.Set("Content-Type", "application/json")
+		w := httptest.NewRecorder()
+		router.ServeHTTP(w, req)
+		var resp proto.BaseResponse
+		require.NoError(t, json.Unmarshal(w.Body.Bytes(), &resp))
+		data, err := json.Marshal(resp.Data)
+		require.NoError(t, err)
+		return resp.Code, data
+	}
+	list := func() []api.SceneImageVersion {
+		code, data := do(http.MethodGet, scene.ID+"/image/history", nil)
+		require.Equal(t, http.StatusOK, code)
+		var ret api.ListSceneImageVersionsResult
+		require.NoError(t, json.Unmarshal(data, &ret))
+		return ret.Versions
+	}
+
+	versions := list()
+	require.Len(t, versions, 3)
+	assert.Equal(t, []int{4, 3, 2}, []int{versions[0].Version, versions[1].Version, versions[2].Version})
+	assert.True(t, versions[0].Active)
+	assert.Equal(t, "http://img/5", versions[0].ImageURL)
+	assert.Equal(t, "http://img/1", versions[2].ImageURL)
+
+	// 回退图片不改变语音
+	current, err := service.db.GetScene(ctx, scene.ID)
+	require.NoError(t, err)
+	code, data := do(http.MethodPost, scene.ID+"/image:revert", api.RevertSceneImageArgs{Version: 2})
+	require.Equal(t, http.StatusOK, code)
+	var apiScene api.Scene
+	require.NoError(t, json.Unmarshal(data, &apiScene))
+	assert.Equal(t, "http://img/1", apiScene.ImageURL)
+	assert.Equal(t, current.VoiceURL, apiScene.VoiceURL)
+	versions = list()
+	assert.False(t, versions[0].Active)
+	assert.True(t, versions[2].Active)
+
+	// 超出保留数量的版本已清理
+	code, _ = do(http.MethodPost, scene.ID+"/image:revert", api.RevertSceneImageArgs{Version: 1})
+	assert.Equal(t, http.StatusNotFound, code)
+	code, _ = do(http.MethodPost, scene.ID+"/image:revert", map[string]int{})
+	assert.Equal(t, http.StatusBadRequest, code)
+	code, _ = do(http.MethodGet, db.MakeUUID()+"/image/history", nil)
+	assert.Equal(t, http.StatusNotFound, code)
+
+	// 删除文档时一并删除图片版本
+	urls, err := service.db.DeleteDocument(ctx, docID)
+	require.NoError(t, err)
+	assert.Contains(t, urls, "http://img/3")
+	left, err := service.db.ListSceneImageVersions(ctx, scene.ID)
+	require.NoError(t, err)
+	assert.Empty(t, left)
+}
+
+func TestSceneOverrides(t *testing.T) {
+	service, cleanup := setupTestService(t)
+	defer cleanup()
+
+	ctx := context.Background()
+
+	// 记录图片和语音请求，校验场景级参数是否生效
+	var mu sync.Mutex
+	var bodies []string
+	var bailianServer *httptest.Server
+	bailianServer = httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodPost {
+			w.WriteHeader(http.StatusNotFound)
+			return
+		}
+		body, _ := io.ReadAll(r.Body)
+		mu.Lock()
+		bodies = append(bodies, string(body))
+		mu.Unlock()
+		fmt.Fprintf(w, `{"output":{"choices":[{"message":{"content":[{"image":"http://img/1"}]}}],"audio":{"url":"%s/voice/1"}}}`, bailianServer.URL)
+	}))
+	defer bailianServer.Close()
+	var err error
+	service.bailianClient, err = bailian.NewClient(bailian.Config{BaseURL: bailianServer.URL, APIKey: "test", ImageSize: "1328*1328"})
+	require.NoError(t, err)
+
+	docID := db.MakeUUID()
+	_, err = service.db.CreateDocument(ctx, docID, "file-id", &api.CreateDocumentArgs{Name: "场景参数文档"})
+	require.NoError(t, err)
+	scene := db.Scene{ID: db.MakeUUID(), ChapterID: db.MakeUUID(), DocumentID: docID, Content: "场景"}
+	require.NoError(t, service.db.CreateScenes(ctx, []db.Scene{scene}))
+
+	_, err = service.UpdateScene(ctx, scene.ID, &api.UpdateSceneArgs{Content: "新场景", Overrides: &api.SceneOverrides{ImageSize: "large"}})
+	var apiErr *proto.ApiError
+	require.ErrorAs(t, err, &apiErr)
+	assert.Equal(t, http.StatusBadRequest, apiErr.Code)
+
+	ret, err := service.UpdateScene(ctx, scene.ID, &api.UpdateSceneArgs{
+		Content:   "新场景",
+		Overrides: &api.SceneOverrides{Style: "水彩", ImageSize: "1664*928", Voice: "Ethan"},
+	})
+	require.NoError(t, err)
+	require.NotNil(t, ret.Overrides)
+	assert.Equal(t, "水彩", ret.Overrides.Style)
+
+	mu.Lock()
+	require.GreaterOrEqual(t, len(bodies), 2)
+	image, tts := bodies[0], bodies[1]
+	mu.Unlock()
+	assert.Contains(t, image, `"size":"1664*928"`)
+	assert.Contains(t, image, "画面风格：水彩")
+	assert.Contains(t, image, `"model":"qwen-image-plus"`)
+	assert.Contains(t, tts, `"voice":"Ethan"`)
+
+	// 不传 overrides 时保留原值，传空对象时清除，恢复默认参数
+	ret, err = service.UpdateScene(ctx, scene.ID, &api.UpdateSceneArgs{Content: "新场景"})
+	require.NoError(t, err)
+	require.NotNil(t, ret.Overrides)
+	assert.Equal(t, "Ethan", ret.Overrides.Voice)
+	ret, err = service.UpdateScene(ctx, scene.ID, &api.UpdateSceneArgs{Content: "新场景", Overrides: &api.SceneOverrides{}})
+	require.NoError(t, err)
+	assert.Nil(t, ret.Overrides)
+	mu.Lock()
+	image = bodies[len(bodies)-2]
+	mu.Unlock()
+	assert.Contains(t, image, `"size":"1328*1328"`)
+	assert.NotContains(t, image, "画面风格")
+}
+
+func TestTTSProvider(t *testing.T) {
+	service, cleanup := setupTestService(t)
+	defer cleanup()
+
+	ctx := context.Background()
+	router := service.RegisterRouter(os.Stdout)
+
+	bailianServer := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte(`{"output":{"choices":[{"message":{"content":[{"image":"http://img/1"}]}}]}}`))
+	}))
+	defer bailianServer.Close()
+	var err error
+	service.bailianClient, err = bailian.NewClient(bailian.Config{BaseURL: bailianServer.URL, APIKey: "test"})
+	require.NoError(t, err)
+
+	// 语音由兼容 OpenAI 接口的服务生成，音频保存在本地并通过 /audio/{name} 访问
+	wav := placeholder.SilentWAV(time.Second)
+	var voice string
+	ttsServer := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		var req struct {
+			Voice string `json:"voice"`
+		}
+		json.NewDecoder(r.Body).Decode(&req)
+		voice = req.Voice
+		w.Write(wav)
+	}))
+	defer ttsServer.Close()
+	service.audioStore = mediastore.New(t.TempDir(), "/v1/audio")
+	service.ttsProvider, err = tts.New(tts.Config{Provider: tts.ProviderOpenAI, OpenAI: tts.OpenAIConfig{BaseURL: ttsServer.URL, APIKey: "test"}}, service.bailianClient, service.audioStore)
+	require.NoError(t, err)
+
+	docID := db.MakeUUID()
+	_, err = service.db.CreateDocument(ctx, docID, "file-id", &api.CreateDocumentArgs{Name: "语音服务文档"})
+	require.NoError(t, err)
+	scene := db.Scene{ID: db.MakeUUID(), ChapterID: db.MakeUUID(), DocumentID: docID, Content: "场景"}
+	require.NoError(t, service.db.CreateScenes(ctx, []db.Scene{scene}))
+
+	ret, err := service.UpdateScene(ctx, scene.ID, &api.UpdateSceneArgs{Content: "新场景", Overrides: &api.SceneOverrides{Voice: "nova"}})
+	require.NoError(t, err)
+	assert.Equal(t, "nova", voice)
+	require.True(t, strings.HasPrefix(ret.VoiceURL, "/v1/audio/"), ret.VoiceURL)
+
+	req := httptest.NewRequest(http.MethodGet, ret.VoiceURL, nil)
+	w := httptest.NewRecorder()
+	router.ServeHTTP(w, req)
+	assert.Equal(t, http.StatusOK, w.Code)
+	assert.Equal(t, "audio/wav", w.Header().Get("Content-Type"))
+	assert.Equal(t, wav, w.Body.Bytes())
+
+	req = httptest.NewRequest(http.MethodGet, "/v1/audio/unknown.wav", nil)
+	w = httptest.NewRecorder()
+	router.ServeHTTP(w, req)
+	var resp proto.BaseResponse
+	require.NoError(t, json.Unmarshal(w.Body.Bytes(), &resp))
+	assert.Equal(t, http.StatusBadRequest, resp.Code)
+}
+
+func TestSceneSubtitles(t *testing.T) {
+	service, cleanup := setupTestService(t)
+	defer cleanup()
+
+	router := service.RegisterRouter(os.Stdout)
+	ctx := context.Background()
+	service.audioStore = mediastore.New(filepath.Join(service.conf.Temp, "audio"), "/v1/audio")
+
+	bailianServer := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodPost {
+			w.WriteHeader(http.StatusNotFound)
+			return
+		}
+		fmt.Fprint(w, `{"output":{"choices":[{"message":{"content":[{"image":"http://img/1"}]}}],"audio":{"url":"http://voice/1"}}}`)
+	}))
+	defer bailianServer.Close()
+	bailianClient, err := bailian.NewClient(bailian.Config{BaseURL: bailianServer.URL, APIKey: "test"})
+	require.NoError(t, err)
+	mgr, err := newDocumentMgr(DocumentConfigEx{config: DocumentConfig{Enable: true}, db: service.db, audioStore: service.audioStore}, bailianClient)
+	require.NoError(t, err)
+
+	docID := db.MakeUUID()
+	doc, err := service.db.CreateDocument(ctx, docID, "file-id", &api.CreateDocumentArgs{Name: "字幕文档"})
+	require.NoError(t, err)
+	scene := db.Scene{ID: db.MakeUUID(), ChapterID: db.MakeUUID(), DocumentID: docID, Content: "祥子拉车。走在街头！"}
+	require.NoError(t, service.db.CreateScenes(ctx, []db.Scene{scene}))
+
+	// 语音生成后按语音时长生成字幕，无法获取音频时长时按文字长度估算
+	require.NoError(t, mgr.handleSceneImageGen(ctx, *doc, scene, nil))
+	scene, err = service.db.GetScene(ctx, scene.ID)
+	require.NoError(t, err)
+	require.True(t, strings.HasPrefix(scene.SubtitleURL, "/v1/audio/"))
+	assert.Equal(t, scene.SubtitleURL, makeScene(&scene).SubtitleURL)
+
+	req := httptest.NewRequest(http.MethodGet, scene.SubtitleURL, nil)
+	w := httptest.NewRecorder()
+	router.ServeHTTP(w, req)
+	require.Equal(t, http.StatusOK, w.Code)
+	assert.Equal(t, "text/vtt; charset=utf-8", w.Header().Get("Content-Type"))
+	assert.Equal(t, "WEBVTT\n\n00:00:00.000 --> 00:00:01.250\n祥子拉车。\n\n00:00:01.250 --> 00:00:02.500\n走在街头！\n\n", w.Body.String())
+
+	// 字幕被场景引用，清理媒体文件时保留
+	referenced, err := service.db.ListReferencedMediaURLs(ctx, []string{scene.SubtitleURL})
+	require.NoError(t, err)
+	assert.Equal(t, []string{scene.SubtitleURL}, referenced)
+
+	// 占位语音为静音，不再使用字幕
+	require.NoError(t, service.db.UpdateScenePlaceholder(ctx, scene.ID, "http://img/placeholder", "http://voice/placeholder"))
+	scene, err = service.db.GetScene(ctx, scene.ID)
+	require.NoError(t, err)
+	assert.Empty(t, scene.SubtitleURL)
+}
+
+func TestVoiceProsody(t *testing.T) {
+	service, cleanup := setupTestService(t)
+	defer cleanup()
+
+	ctx := context.Background()
+
+	bailianServer := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte(`{"output":{"choices":[{"message":{"content":[{"image":"http://img/1"}]}}]}}`))
+	}))
+	defer bailianServer.Close()
+	var err error
+	service.bailianClient, err = bailian.NewClient(bailian.Config{BaseURL: bailianServer.URL, APIKey: "test"})
+	require.NoError(t, err)
+
+	var got struct {
+		Speed        float64 `json:"speed"`
+		Instructions string  `json:"instructions"`
+	}
+	ttsServer := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		json.NewDecoder(r.Body).Decode(&got)
+		w.Write(placeholder.SilentWAV(time.Second))
+	}))
+	defer ttsServer.Close()
+	service.audioStore = mediastore.New(t.TempDir(), "/v1/audio")
+	service.ttsProvider, err = tts.New(tts.Config{Provider: tts.ProviderOpenAI, OpenAI: tts.OpenAIConfig{BaseURL: ttsServer.URL, APIKey: "test"}}, service.bailianClient, service.audioStore)
+	require.NoError(t, err)
+
+	docID := db.MakeUUID()
+	_, err = service.db.CreateDocument(ctx, docID, "file-id", &api.CreateDocumentArgs{Name: "语音参数文档"})
+	require.NoError(t, err)
+	scene := db.Scene{ID: db.MakeUUID(), ChapterID: db.MakeUUID(), DocumentID: docID, Content: "场景"}
+	require.NoError(t, service.db.CreateScenes(ctx, []db.Scene{scene}))
+
+	// 按语音服务支持的范围校验，OpenAI 不支持音调
+	var ae *proto.ApiError
+	_, err = service.UpdateDocument(ctx, docID, &api.UpdateDocumentArgs{Name: "语音参数文档", VoiceProsody: &api.VoiceProsody{Speed: 5}})
+	require.ErrorAs(t, err, &ae)
+	assert.Equal(t, http.StatusBadRequest, ae.Code)
+	_, err = service.UpdateScene(ctx, scene.ID, &api.UpdateSceneArgs{Content: "新场景", Overrides: &api.SceneOverrides{Pitch: 1.2}})
+	require.ErrorAs(t, err, &ae)
+	assert.Equal(t, "pitch is not supported by the tts provider", ae.Message)
+
+	prosody := api.VoiceProsody{Speed: 1.5, Emotion: "低沉"}
+	doc, err := service.UpdateDocument(ctx, docID, &api.UpdateDocumentArgs{Name: "语音参数文档", VoiceProsody: &prosody})
+	require.NoError(t, err)
+	assert.Equal(t, prosody, doc.VoiceProsody)
+
+	// 文档的设置用于每个场景，场景级参数逐项覆盖
+	_, err = service.UpdateScene(ctx, scene.ID, &api.UpdateSceneArgs{Content: "新场景"})
+	require.NoError(t, err)
+	assert.Equal(t, 1.5, got.Speed)
+	assert.Equal(t, "情感和朗读风格：低沉", got.Instructions)
+	_, err = service.UpdateScene(ctx, scene.ID, &api.UpdateSceneArgs{Content: "新场景", Overrides: &api.SceneOverrides{Speed: 0.8}})
+	require.NoError(t, err)
+	assert.Equal(t, 0.8, got.Speed)
+	assert.Equal(t, "情感和朗读风格：低沉", got.Instructions)
+
+	router := service.RegisterRouter(os.Stdout)
+	req := httptest.NewRequest(http.MethodGet, "/v1/limits", nil)
+	w := httptest.NewRecorder()
+	router.ServeHTTP(w, req)
+	var resp proto.BaseResponse
+	require.NoError(t, json.Unmarshal(w.Body.Bytes(), &resp))
+	require.Equal(t, http.StatusOK, resp.Code)
+	data, err := json.Marshal(resp.Data)
+	require.NoError(t, err)
+	var limits api.Limits
+	require.NoError(t, json.Unmarshal(data, &limits))
+	assert.Equal(t, api.TTSLimits{Speed: api.ValueRange{Min: 0.25, Max: 4}, Emotion: true}, limits.TTS)
+}
+
+func TestImageProvider(t *testing.T) {
+	service, cleanup := setupTestService(t)
+	defer cleanup()
+
+	ctx := context.Background()
+	router := service.RegisterRouter(os.Stdout)
+
+	var bailianServer *httptest.Server
+	bailianServer = httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		fmt.Fprintf(w, `{"output":{"choices":[{"message":{"content":[{"image":"http://img/bailian"}]}}],"audio":{"url":"%s/voice/1"}}}`, bailianServer.URL)
+	}))
+	defer bailianServer.Close()
+	var err error
+	service.bailianClient, err = bailian.NewClient(bailian.Config{BaseURL: bailianServer.URL, APIKey: "test"})
+	require.NoError(t, err)
+
+	png := []byte("\x89PNG sd")
+	sdServer := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		fmt.Fprintf(w, `{"images":["%s"]}`, base64.StdEncoding.EncodeToString(png))
+	}))
+	defer sdServer.Close()
+	service.imageStore = mediastore.New(t.TempDir(), "/v1/images")
+	service.imageGen, err = imagegen.New(imagegen.Config{StableDiffusion: imagegen.StableDiffusionConfig{BaseURL: sdServer.URL}}, service.bailianClient, service.imageStore)
+	require.NoError(t, err)
+
+	docID := db.MakeUUID()
+	_, err = service.db.CreateDocument(ctx, docID, "file-id", &api.CreateDocumentArgs{Name: "图片服务文档"})
+	require.NoError(t, err)
+	scene := db.Scene{ID: db.MakeUUID(), ChapterID: db.MakeUUID(), DocumentID: docID, Content: "场景"}
+	require.NoError(t, service.db.CreateScenes(ctx, []db.Scene{scene}))
+
+	// 未启用的服务不能选择
+	provider := imagegen.ProviderOpenAI
+	_, err = service.UpdateDocument(ctx, docID, &api.UpdateDocumentArgs{Name: "图片服务文档", ImageProvider: &provider})
+	var apiErr *proto.ApiError
+	require.ErrorAs(t, err, &apiErr)
+	assert.Equal(t, http.StatusBadRequest, apiErr.Code)
+
+	// 默认使用百炼
+	ret, err := service.UpdateScene(ctx, scene.ID, &api.UpdateSceneArgs{Content: "新场景"})
+	require.NoError(t, err)
+	assert.Equal(t, "http://img/bailian", ret.ImageURL)
+
+	provider = imagegen.ProviderStableDiffusion
+	doc, err := service.UpdateDocument(ctx, docID, &api.UpdateDocumentArgs{Name: "图片服务文档", ImageProvider: &provider})
+	require.NoError(t, err)
+	assert.Equal(t, imagegen.ProviderStableDiffusion, doc.ImageProvider)
+	// 不传时保持不变
+	doc, err = service.UpdateDocument(ctx, docID, &api.UpdateDocumentArgs{Name: "图片服务文档"})
+	require.NoError(t, err)
+	assert.Equal(t, imagegen.ProviderStableDiffusion, doc.ImageProvider)
+
+	ret, err = service.UpdateScene(ctx, scene.ID, &api.UpdateSceneArgs{Content: "新场景"})
+	require.NoError(t, err)
+	require.True(t, strings.HasPrefix(ret.ImageURL, "/v1/images/"), ret.ImageURL)
+
+	req := httptest.NewRequest(http.MethodGet, ret.ImageURL, nil)
+	w := httptest.NewRecorder()
+	router.ServeHTTP(w, req)
+	assert.Equal(t, http.StatusOK, w.Code)
+	assert.Equal(t, "image/png", w.Header().Get("Content-Type"))
+	assert.Equal(t, png, w.Body.Bytes())
+}
+
+func TestRoleConsistency(t *testing.T) {
+	service, cleanup := setupTestService(t)
+	defer cleanup()
+
+	ctx := context.Background()
+
+	var mu sync.Mutex
+	var bodies []string
+	var bailianServer *httptest.Server
+	bailianServer = httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodPost {
+			w.WriteHeader(http.StatusNotFound)
+			return
+		}
+		body, _ := io.ReadAll(r.Body)
+		mu.Lock()
+		bodies = append(bodies, string(body))
+		mu.Unlock()
+		fmt.Fprintf(w, `{"output":{"choices":[{"message":{"content":[{"image":"http://img/1"}]}}],"audio":{"url":"%s/voice/1"}}}`, bailianServer.URL)
+	}))
+	defer bailianServer.Close()
+	var err error
+	service.bailianClient, err = bailian.NewClient(bailian.Config{BaseURL: bailianServer.URL, APIKey: "test"})
+	require.NoError(t, err)
+	lastImageRequest := func() string {
+		mu.Lock()
+		defer mu.Unlock()
+		require.GreaterOrEqual(t, len(bodies), 2)
+		return bodies[len(bodies)-2]
+	}
+
+	docID := db.MakeUUID()
+	_, err = service.db.CreateDocument(ctx, docID, "file-id", &api.CreateDocumentArgs{Name: "角色一致性文档"})
+	require.NoError(t, err)
+	roles := []db.Role{
+		{ID: db.MakeUUID(), DocumentID: docID, Name: "李雷", Gender: "男", Appearance: "短发，戴眼镜"},
+		{ID: db.MakeUUID(), DocumentID: docID, Name: "韩梅梅", Gender: "女", Appearance: "马尾辫"},
+	}
+	require.NoError(t, service.db.CreateRoles(ctx, roles))
+	scene := db.Scene{ID: db.MakeUUID(), ChapterID: db.MakeUUID(), DocumentID: docID, Content: "场景"}
+	require.NoError(t, service.db.CreateScenes(ctx, []db.Scene{scene}))
+
+	role, err := service.UpdateRole(ctx, roles[0].ID, &api.UpdateRoleArgs{
+		Name: "李雷", Gender: "男", Character: "开朗", Appearance: "短发，戴眼镜", PortraitURL: "http://img/lilei.png",
+	})
+	require.NoError(t, err)
+	assert.Equal(t, "http://img/lilei.png", role.PortraitURL)
+
+	// 未开启时注入全部角色，不使用参考图
+	_, err = service.UpdateScene(ctx, scene.ID, &api.UpdateSceneArgs{Content: "李雷走进教室"})
+	require.NoError(t, err)
+	image := lastImageRequest()
+	assert.Contains(t, image, "韩梅梅")
+	assert.NotContains(t, image, "lilei.png")
+	assert.Contains(t, image, `"model":"qwen-image-plus"`)
+
+	// 开启后只注入场景中出现的角色，参考立绘作为参考图
+	enable := true
+	doc, err := service.UpdateDocument(ctx, docID, &api.UpdateDocumentArgs{Name: "角色一致性文档", RoleConsistency: &enable})
+	require.NoError(t, err)
+	assert.True(t, doc.RoleConsistency)
+	_, err = service.UpdateScene(ctx, scene.ID, &api.UpdateSceneArgs{Content: "李雷走进教室"})
+	require.NoError(t, err)
+	image = lastImageRequest()
+	assert.Contains(t, image, "画面中出现的角色")
+	assert.Contains(t, image, "李雷：性别：男；外貌特征：短发，戴眼镜；长相参考第 1 张参考图")
+	assert.NotContains(t, image, "韩梅梅")
+	assert.Contains(t, image, `{"image":"http://img/lilei.png"}`)
+	assert.Contains(t, image, `"model":"qwen-image-edit-plus"`)
+
+	// 没有参考立绘的角色只注入外貌描述
+	_, err = service.UpdateScene(ctx, scene.ID, &api.UpdateSceneArgs{Content: "韩梅梅在操场"})
+	require.NoError(t, err)
+	image = lastImageRequest()
+	assert.Contains(t, image, "韩梅梅：性别：女；外貌特征：马尾辫")
+	assert.NotContains(t, image, "李雷")
+	assert.Contains(t, image, `"model":"qwen-image-plus"`)
+
+	// 修改名称时不传 role_consistency 保持不变，清除立绘
+	doc, err = service.UpdateDocument(ctx, docID, &api.UpdateDocumentArgs{Name: "改名文档"})
+	require.NoError(t, err)
+	assert.True(t, doc.RoleConsistency)
+	role, err = service.UpdateRole(ctx, roles[0].ID, &api.UpdateRoleArgs{Name: "李雷", Gender: "男", Character: "开朗", Appearance: "短发"})
+	require.NoError(t, err)
+	assert.Empty(t, role.PortraitURL)
+}
+
+func TestDocumentMgrConcurrency(t *testing.T) {
+	service, cleanup := setupTestService(t)
+	defer cleanup()
+
+	ctx := context.Background()
+
+	// 记录同时进行的图片、语音请求数
+	var images, voices, maxImages, maxVoices atomic.Int32
+	track := func(cur, peak *atomic.Int32) func() {
+		n := cur.Add(1)
+		for {
+			p := peak.Load()
+			if n <= p || peak.CompareAndSwap(p, n) {
+				break
+			}
+		}
+		return func() { cur.Add(-1) }
+	}
+	var bailianServer *httptest.Server
+	bailianServer = httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodPost {
+			w.WriteHeader(http.StatusNotFound)
+			return
+		}
+		body, _ := io.ReadAll(r.Body)
+		if strings.Contains(string(body), "qwen-image") {
+			defer track(&images, &maxImages)()
+		} else {
+			defer track(&voices, &maxVoices)()
+		}
+		time.Sleep(50 * time.Millisecond)
+		fmt.Fprintf(w, `{"output":{"choices":[{"message":{"content":[{"image":"http://img/1"}]}}],"audio":{"url":"%s/voice"}}}`, bailianServer.URL)
+	}))
+	defer bailianServer.Close()
+	bailianClient, err := bailian.NewClient(bailian.Config{BaseURL: bailianServer.URL, APIKey: "test"})
+	require.NoError(t, err)
+	mgr, err := newDocumentMgr(DocumentConfigEx{
+		config: DocumentConfig{Enable: true, Workers: 4, MaxConcurrentImageJobs: 2, MaxConcurrentTTSJobs: 1},
+		db:     service.db,
+		quota:  service.quota,
+	}, bailianClient)
+	require.NoError(t, err)
+
+	var docIDs []string
+	for i := 0; i < 6; i++ {
+		docID := db.MakeUUID()
+		_, err := service.db.CreateDocument(ctx, docID, "file-id", &api.CreateDocumentArgs{Name: fmt.Sprintf("并发文档%d", i)})
+		require.NoError(t, err)
+		require.NoError(t, service.db.UpdateDocumentStatus(ctx, docID, db.DocumentStatusSceneReady))
+		require.NoError(t, service.db.CreateScenes(ctx, []db.Scene{
+			{ID: db.MakeUUID(), ChapterID: db.MakeUUID(), DocumentID: docID, Content: "场景"},
+		}))
+		docIDs = append(docIDs, docID)
+	}
+
+	mgr.HandleImageGenTasks(ctx)
+	for _, docID := range docIDs {
+		doc, err := service.db.GetDocument(ctx, docID)
+		require.NoError(t, err)
+		assert.Equal(t, db.DocumentStatusImgReady, doc.Status)
+	}
+	assert.Equal(t, int32(2), maxImages.Load())
+	assert.Equal(t, int32(1), maxVoices.Load())
+
+	// 未配置时按 Workers 限制
+	mgr, err = newDocumentMgr(DocumentConfigEx{config: DocumentConfig{Workers: 3}, db: service.db}, bailianClient)
+	require.NoError(t, err)
+	assert.Equal(t, 3, cap(mgr.imageSlots))
+	assert.Equal(t, 3, cap(mgr.ttsSlots))
+}
+
+func TestDocumentMgrSharedQueue(t *testing.T) {
+	service, cleanup := setupTestService(t)
+	defer cleanup()
+
+	ctx := context.Background()
+	queue := jobqueue.NewMemory(jobqueue.Config{})
+	// 未配置百炼客户端，文档被处理时会 panic 并标记为失败
+	mgr, err := newDocumentMgr(DocumentConfigEx{config: DocumentConfig{Enable: true}, db: service.db, queue: queue}, nil)
+	require.NoError(t, err)
+
+	docID := db.MakeUUID()
+	_, err = service.db.CreateDocument(ctx, docID, "file-id", &api.CreateDocumentArgs{Name: "共享队列"})
+	require.NoError(t, err)
+	require.NoError(t, service.db.UpdateDocumentStatus(ctx, docID, db.DocumentStatusChapterReady))
+
+	// 其他实例正在处理的文档不重复处理
+	lease, err := queue.Dequeue(ctx, stageRole)
+	require.NoError(t, err)
+	assert.Nil(t, lease)
+	require.NoError(t, queue.Enqueue(ctx, stageRole, docID, 0))
+	lease, err = queue.Dequeue(ctx, stageRole)
+	require.NoError(t, err)
+	require.NotNil(t, lease)
+	mgr.HandleDocumentRoleTasks(ctx)
+	doc, err := service.db.GetDocument(ctx, docID)
+	require.NoError(t, err)
+	assert.Equal(t, db.DocumentStatusChapterReady, doc.Status)
+
+	// 其他实例处理完成后，队列中残留的任务领取后跳过
+	require.NoError(t, service.db.UpdateDocumentStatus(ctx, docID, db.DocumentStatusRoleReady))
+	require.NoError(t, queue.Ack(ctx, lease))
+	require.NoError(t, queue.Enqueue(ctx, stageRole, docID, 0))
+	mgr.HandleDocumentRoleTasks(ctx)
+	doc, err = service.db.GetDocument(ctx, docID)
+	require.NoError(t, err)
+	assert.Equal(t, db.DocumentStatusRoleReady, doc.Status)
+	lease, err = queue.Dequeue(ctx, stageRole)
+	require.NoError(t, err)
+	assert.Nil(t, lease)
+}
+
+func TestDocumentMgrLock(t *testing.T) {
+	service, cleanup := setupTestService(t)
+	defer cleanup()
+
+	ctx := context.Background()
+	locker := dlock.NewMemory(dlock.Config{})
+	// 未配置百炼客户端，文档被处理时会 panic 并标记为失败
+	mgr, err := newDocumentMgr(DocumentConfigEx{config: DocumentConfig{Enable: true}, db: service.db, locker: locker}, nil)
+	require.NoError(t, err)
+
+	docID := db.MakeUUID()
+	_, err = service.db.CreateDocument(ctx, docID, "file-id", &api.CreateDocumentArgs{Name: "文档锁"})
+	require.NoError(t, err)
+	require.NoError(t, service.db.UpdateDocumentStatus(ctx, docID, db.DocumentStatusChapterReady))
+
+	// 其他 worker 持有文档锁时跳过，任务从队列删除，下一轮重新入队
+	lock, err := locker.TryLock(ctx, documentLockKey(docID))
+	require.NoError(t, err)
+	mgr.HandleDocumentRoleTasks(ctx)
+	doc, err := service.db.GetDocument(ctx, docID)
+	require.NoError(t, err)
+	assert.Equal(t, db.DocumentStatusChapterReady, doc.Status)
+	lease, err := mgr.queue.Dequeue(ctx, stageRole)
+	require.NoError(t, err)
+	assert.Nil(t, lease)
+
+	// 锁释放后处理，处理结束释放锁
+	require.NoError(t, locker.Unlock(ctx, lock))
+	mgr.HandleDocumentRoleTasks(ctx)
+	doc, err = service.db.GetDocument(ctx, docID)
+	require.NoError(t, err)
+	assert.Equal(t, db.DocumentStatusFailed, doc.Status)
+	lock, err = locker.TryLock(ctx, documentLockKey(docID))
+	require.NoError(t, err)
+	require.NoError(t, locker.Unlock(ctx, lock))
+}
+
+func TestIdempotency(t *testing.T) {
+	service, cleanup := setupTestService(t)
+	defer cleanup()
+
+	var calls atomic.Int32
+	release := make(chan struct{})
+	router := middleware.NewRouter(io.Discard)
+	router.Use(service.NilAuth())
+	router.POST("/items/:id", service.Idempotent(), func(c *gin.Context) {
+		n := calls.Add(1)
+		if c.Query("block") != "" {
+			<-release
+		}
+		if c.Query("fail") != "" {
+			hutil.AbortError(c, hutil.ErrServerInternalCode, "internal error")
+			return
+		}
+		hutil.WriteData(c, map[string]int32{"n": n})
+	})
+	do := func(path, key string) (*httptest.ResponseRecorder, proto.BaseResponse) {
+		req := httptest.NewRequest(http.MethodPost, path, nil)
+		if key != "" {
+			req.Header.Set(idempotencyKeyHeader, key)
+		}
+		w := httptest.NewRecorder()
+		router.ServeHTTP(w, req)
+		var resp proto.BaseResponse
+		require.NoError(t, json.Unmarshal(w.Body.Bytes(), &resp))
+		return w, resp
+	}
+
+	// 相同幂等键重复请求返回首次的响应，不重复处理
+	w1, resp1 := do("/items/1", "k1")
+	w2, resp2 := do("/items/1", "k1")
+	assert.Equal(t, http.StatusOK, resp1.Code)
+	assert.Equal(t, w1.Body.String(), w2.Body.String())
+	assert.Equal(t, "true", w2.Header().Get(idempotentReplayedHeader))
+	assert.Equal(t, resp1.Data, resp2.Data)
+	assert.Equal(t, int32(1), calls.Load())
+
+	// 不同路径或没有幂等键时正常处理
+	do("/items/2", "k1")
+	do("/items/1", "")
+	assert.Equal(t, int32(3), calls.Load())
+
+	// 服务端错误不保存，允许重试
+	_, resp := do("/items/3?fail=1", "k2")
+	assert.Equal(t, hutil.ErrServerInternalCode, resp.Code)
+	_, resp = do("/items/3", "k2")
+	assert.Equal(t, http.StatusOK, resp.Code)
+	assert.Equal(t, int32(5), calls.Load())
+
+	// 首次请求处理中时拒绝相同幂等键的请求
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		do("/items/4?block=1", "k3")
+	}()
+	require.Eventually(t, func() bool { return calls.Load() == 6 }, time.Second, 10*time.Millisecond)
+	_, resp = do("/items/4?block=1", "k3")
+	assert.Equal(t, ErrIdempotencyKeyInUseCode, resp.Code)
+	close(release)
+	<-done
+	assert.Equal(t, int32(6), calls.Load())
+
+	_, resp = do("/items/1", strings.Repeat("k", maxIdempotencyKeyLen+1))
+	assert.Equal(t, http.StatusBadRequest, resp.Code)
+}
+
+func TestURLPolicy(t *testing.T) {
+	service, cleanup := setupTestService(t)
+	defer cleanup()
+
+	router := service.RegisterRouter(os.Stdout)
+	ctx := context.Background()
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "text/plain")
+		w.Write([]byte("第一章"))
+	}))
+	defer srv.Close()
+	textURL := srv.URL + "/a.txt"
+
+	do := func(method, path string, body any) proto.BaseResponse {
+		var reader io.Reader
+		if body != nil {
+			data, err := json.Marshal(body)
+			require.NoError(t, err)
+			reader = bytes.NewReader(data)
+		}
+		req := asAdmin(httptest.NewRequest(method, path, reader))
+		req.Header.Set("Content-Type", "application/json")
+		w := httptest.NewRecorder()
+		router.ServeHTTP(w, req)
+
+		var resp proto.BaseResponse
+		require.NoError(t, json.Unmarshal(w.Body.Bytes(), &resp))
+		return resp
+	}
+	policy := func(resp proto.BaseResponse) api.URLPolicy {
+		require.Equal(t, http.StatusOK, resp.Code, resp.Message)
+		data, err := json.Marshal(resp.Data)
+		require.NoError(t, err)
+		var p api.URLPolicy
+		require.NoError(t, json.Unmarshal(data, &p))
+		return p
+	}
+
+	// 默认策略禁止访问内网地址
+	p := policy(do(http.MethodGet, "/v1/tenants/0/url_policy", nil))
+	assert.True(t, p.Default)
+	assert.False(t, p.AllowPrivate)
+	form := url.Values{"name": {"URL 文档"}, "url": {textURL}}
+	req := httptest.NewRequest(http.MethodPost, "/v1/documents", strings.NewReader(form.Encode()))
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	w := httptest.NewRecorder()
+	router.ServeHTTP(w, req)
+	var resp proto.BaseResponse
+	require.NoError(t, json.Unmarshal(w.Body.Bytes(), &resp))
+	assert.Equal(t, ErrURLNotAllowedCode, resp.Code)
+
+	// 管理员为租户放开内网地址，并限制域名
+	assert.Equal(t, http.StatusBadRequest, do(http.MethodPut, "/v1/tenants/0/url_policy", api.UpdateURLPolicyArgs{AllowedDomains: []string{"http://evil.com"}}).Code)
+	p = policy(do(http.MethodPut, "/v1/tenants/0/url_policy", api.UpdateURLPolicyArgs{
+		AllowedDomains: []string{"127.0.0.1", "*.Example.com"},
+		DeniedDomains:  []string{"internal.example.com"},
+		AllowPrivate:   true,
+	}))
+	assert.False(t, p.Default)
+	assert.Equal(t, []string{"127.0.0.1", "*.example.com"}, p.AllowedDomains)
+
+	errCode := func(err error) int {
+		var apiErr *proto.ApiError
+		require.ErrorAs(t, err, &apiErr)
+		return apiErr.Code
+	}
+	filename, err := service.downloadDocument(ctx, 0, textURL)
+	require.NoError(t, err)
+	data, err := os.ReadFile(filename)
+	require.NoError(t, err)
+	assert.Equal(t, "第一章", string(data))
+	os.Remove(filename)
+
+	_, err = service.downloadDocument(ctx, 0, strings.Replace(textURL, "127.0.0.1", "localhost", 1))
+	assert.Equal(t, ErrURLNotAllowedCode, errCode(err))
+	_, err = service.downloadDocument(ctx, 0, "https://internal.example.com/a.txt")
+	assert.Equal(t, ErrURLNotAllowedCode, errCode(err))
+	_, err = service.downloadDocument(ctx, 0, "file:///etc/passwd")
+	assert.Equal(t, ErrURLNotAllowedCode, errCode(err))
+	// 策略只对该租户生效
+	_, err = service.downloadDocument(ctx, 1, textURL)
+	assert.Equal(t, ErrURLNotAllowedCode, errCode(err))
+
+	// 删除后恢复为默认策略
+	assert.Equal(t, http.StatusOK, do(http.MethodDelete, "/v1/tenants/0/url_policy", nil).Code)
+	assert.True(t, policy(do(http.MethodGet, "/v1/tenants/0/url_policy", nil)).Default)
+
+	// 只有超级管理员可以管理策略
+	gin.SetMode(gin.TestMode)
+	w = httptest.NewRecorder()
+	c, _ := gin.CreateTestContext(w)
+	c.Request = httptest.NewRequest(http.MethodGet, "/v1/tenants/1/url_policy", nil)
+	c.Params = gin.Params{{Key: "user_id", Value: "1"}}
+	c.Set(middleware.XReqID, "reqid")
+	c.Set(userInfoKey, UserInfo{ID: 1})
+	service.HandleGetURLPolicy(c)
+	require.NoError(t, json.Unmarshal(w.Body.Bytes(), &resp))
+	assert.Equal(t, http.StatusForbidden, resp.Code)
+
+	// 未认证的请求不能访问租户及管理接口
+	for _, path := range []string{"/v1/tenants/0/url_policy", "/v1/admin/failed-jobs", "/v1/admin/config"} {
+		w = httptest.NewRecorder()
+		router.ServeHTTP(w, httptest.NewRequest(http.MethodGet, path, nil))
+		require.NoError(t, json.Unmarshal(w.Body.Bytes(), &resp))
+		assert.Equal(t, http.StatusUnauthorized, resp.Code, path)
+	}
+}
+
+func TestFailedJobs(t *testing.T) {
+	service, cleanup := setupTestService(t)
+	defer cleanup()
+
+	router := service.RegisterRouter(os.Stdout)
+	ctx := context.Background()
+
+	var fail atomic.Bool
+	fail.Store(true)
+	var bailianServer *httptest.Server
+	bailianServer = httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodPost || fail.Load() {
+			w.WriteHeader(http.StatusInternalServerError)
+			return
+		}
+		fmt.Fprintf(w, `{"output":{"choices":[{"message":{"content":[{"image":"http://img/1"}]}}],"audio":{"url":"%s/voice"}}}`, bailianServer.URL)
+	}))
+	defer bailianServer.Close()
+	bailianClient, err := bailian.NewClient(bailian.Config{BaseURL: bailianServer.URL, APIKey: "test"})
+	require.NoError(t, err)
+	mgr, err := newDocumentMgr(DocumentConfigEx{
+		config: DocumentConfig{Enable: true, MaxSceneAttempts: 1, MinSceneSuccessRatio: 1},
+		db:     service.db,
+		quota:  service.quota,
+	}, bailianClient)
+	require.NoError(t, err)
+
+	docID := db.MakeUUID()
+	_, err = service.db.CreateDocument(ctx, docID, "file-id", &api.CreateDocumentArgs{Name: "死信文档", UserID: 7})
+	require.NoError(t, err)
+	require.NoError(t, service.db.UpdateDocumentStatus(ctx, docID, db.DocumentStatusSceneReady))
+	sceneIDs := []string{db.MakeUUID(), db.MakeUUID()}
+	for i, id := range sceneIDs {
+		require.NoError(t, service.db.CreateScenes(ctx, []db.Scene{
+			{ID: id, ChapterID: db.MakeUUID(), DocumentID: docID, Index: i, Content: "场景"},
+		}))
+	}
+
+	do := func(method, path string) proto.BaseResponse {
+		req := asAdmin(httptest.NewRequest(method, path, nil))
+		w := httptest.NewRecorder()
+		router.ServeHTTP(w, req)
+		var resp proto.BaseResponse
+		require.NoError(t, json.Unmarshal(w.Body.Bytes(), &resp))
+		return resp
+	}
+	list := func(query string) api.ListFailedJobsResult {
+		resp := do(http.MethodGet, "/v1/admin/failed-jobs"+query)
+		require.Equal(t, http.StatusOK, resp.Code, resp.Message)
+		data, err := json.Marshal(resp.Data)
+		require.NoError(t, err)
+		var ret api.ListFailedJobsResult
+		require.NoError(t, json.Unmarshal(data, &ret))
+		return ret
+	}
+
+	// 场景重试耗尽，失败场景过多，文档在图片阶段失败
+	mgr.HandleImageGenTasks(ctx)
+	docs := list("")
+	require.Len(t, docs.Jobs, 1)
+	assert.Equal(t, api.FailedJob{
+		Type: api.FailedJobDocument, ID: docID, DocumentID: docID, UserID: 7, Stage: stageImage,
+		Error: "2/2 scenes failed", FailedAt: docs.Jobs[0].FailedAt,
+	}, docs.Jobs[0])
+	assert.Empty(t, docs.NextMarker)
+
+	scenes := list("?type=scene&limit=1")
+	require.Len(t, scenes.Jobs, 1)
+	assert.Equal(t, api.FailedJobScene, scenes.Jobs[0].Type)
+	assert.Equal(t, int64(7), scenes.Jobs[0].UserID)
+	assert.Equal(t, 1, scenes.Jobs[0].Attempts)
+	assert.NotEmpty(t, scenes.Jobs[0].Error)
+	require.NotEmpty(t, scenes.NextMarker)
+	next := list("?type=scene&limit=1&marker=" + scenes.NextMarker)
+	require.Len(t, next.Jobs, 1)
+	assert.ElementsMatch(t, sceneIDs, []string{scenes.Jobs[0].ID, next.Jobs[0].ID})
+	assert.Equal(t, http.StatusBadRequest, do(http.MethodGet, "/v1/admin/failed-jobs?type=chapter").Code)
+
+	// 只重新生成一个场景，另一个仍失败，文档再次失败
+	fail.Store(false)
+	resp := do(http.MethodPost, "/v1/admin/failed-jobs/scenes/"+sceneIDs[0]+"/requeue")
+	require.Equal(t, http.StatusOK, resp.Code, resp.Message)
+	doc, err := service.db.GetDocument(ctx, docID)
+	require.NoError(t, err)
+	assert.Equal(t, db.DocumentStatusSceneReady, doc.Status)
+	assert.Empty(t, doc.FailedStage)
+	assert.Equal(t, http.StatusBadRequest, do(http.MethodPost, "/v1/admin/failed-jobs/scenes/"+sceneIDs[0]+"/requeue").Code)
+
+	mgr.HandleImageGenTasks(ctx)
+	scene, err := service.db.GetScene(ctx, sceneIDs[0])
+	require.NoError(t, err)
+	assert.Equal(t, db.SceneStatusReady, scene.Status)
+	assert.Equal(t, "http://img/1", scene.ImageURL)
+	doc, err = service.db.GetDocument(ctx, docID)
+	require.NoError(t, err)
+	assert.Equal(t, db.DocumentStatusFailed, doc.Status)
+	assert.Equal(t, "1/2 scenes failed", doc.LastError)
+
+	// 文档重新入队，剩余失败场景重新生成
+	resp = do(http.MethodPost, "/v1/admin/failed-jobs/documents/"+docID+"/requeue")
+	require.Equal(t, http.StatusOK, resp.Code, resp.Message)
+	mgr.HandleImageGenTasks(ctx)
+	doc, err = service.db.GetDocument(ctx, docID)
+	require.NoError(t, err)
+	assert.Equal(t, db.DocumentStatusImgReady, doc.Status)
+	assert.Empty(t, list("").Jobs)
+	assert.Empty(t, list("?type=scene").Jobs)
+	assert.Equal(t, http.StatusBadRequest, do(http.MethodPost, "/v1/admin/failed-jobs/documents/"+docID+"/requeue").Code)
+
+	events, err := service.db.ListEvents(ctx, 7, 0, 100)
+	require.NoError(t, err)
+	requeued := 0
+	for _, e := range events {
+		if e.Type == db.EventDocumentRequeued {
+			requeued++
+		}
+	}
+	assert.Equal(t, 2, requeued)
+}
+
+func TestDocumentLogs(t *testing.T) {
+	service, cleanup := setupTestService(t)
+	defer cleanup()
+
+	router := service.RegisterRouter(os.Stdout)
+	ctx := context.Background()
+
+	// 第一次生成图片失败，错误中带有密钥和签名 URL；之后的请求 id 在响应体中
+	var calls atomic.Int32
+	var bailianServer *httptest.Server
+	bailianServer = httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodPost {
+			w.WriteHeader(http.StatusNotFound)
+			return
+		}
+		n := calls.Add(1)
+		w.Header().Set("Content-Type", "application/json")
+		if n == 1 {
+			w.Header().Set("X-Request-Id", "req-failed")
+			w.WriteHeader(http.StatusInternalServerError)
+			fmt.Fprint(w, `{"message":"invalid Bearer sk-abcdefgh12345678 for https://oss.example.com/a.png?Signature=secret"}`)
+			return
+		}
+		fmt.Fprintf(w, `{"request_id":"req-%d","output":{"choices":[{"message":{"content":[{"image":"http://img/1"}]}}],"audio":{"url":"%s/voice"}}}`, n, bailianServer.URL)
+	}))
+	defer bailianServer.Close()
+	bailianClient, err := bailian.NewClient(bailian.Config{BaseURL: bailianServer.URL, APIKey: "test"})
+	require.NoError(t, err)
+	mgr, err := newDocumentMgr(DocumentConfigEx{config: DocumentConfig{Enable: true}, db: service.db, quota: service.quota}, bailianClient)
+	require.NoError(t, err)
+
+	docID := db.MakeUUID()
+	_, err = service.db.CreateDocument(ctx, docID, "file-id", &api.CreateDocumentArgs{Name: "日志文档"})
+	require.NoError(t, err)
+	require.NoError(t, service.db.UpdateDocumentStatus(ctx, docID, db.DocumentStatusSceneReady))
+	sceneID := db.MakeUUID()
+	require.NoError(t, service.db.CreateScenes(ctx, []db.Scene{{ID: sceneID, ChapterID: db.MakeUUID(), DocumentID: docID, Content: "场景"}}))
+	recordEvent(ctx, service.db, db.Event{Type: db.EventPipelinePanic, DocumentID: docID, Message: "document failed", Detail: "goroutine 1 internal stack"})
+
+	mgr.HandleImageGenTasks(ctx)
+	require.NoError(t, service.db.UpdateDocumentAttempt(ctx, docID, 1, "", time.Now()))
+	mgr.HandleImageGenTasks(ctx)
+	doc, err := service.db.GetDocument(ctx, docID)
+	require.NoError(t, err)
+	require.Equal(t, db.DocumentStatusImgReady, doc.Status)
+
+	req := httptest.NewRequest(http.MethodGet, "/v1/documents/"+docID+"/logs", nil)
+	w := httptest.NewRecorder()
+	router.ServeHTTP(w, req)
+	require.Equal(t, http.StatusOK, w.Code)
+	assert.Equal(t, "application/zip", w.Header().Get("Content-Type"))
+	assert.Contains(t, w.Header().Get("Content-Disposition"), docID+"-logs.zip")
+
+	zr, err := zip.NewReader(bytes.NewReader(w.Body.Bytes()), int64(w.Body.Len()))
+	require.NoError(t, err)
+	files := map[string]string{}
+	for _, f := range zr.File {
+		rc, err := f.Open()
+		require.NoError(t, err)
+		data, err := io.ReadAll(rc)
+		require.NoError(t, err)
+		rc.Close()
+		files[f.Name] = string(data)
+	}
+	require.Len(t, files, 4)
+
+	var info api.Document
+	require.NoError(t, json.Unmarshal([]byte(files["document.json"]), &info))
+	assert.Equal(t, docID, info.ID)
+	assert.Equal(t, db.DocumentStatusImgReady, info.Status)
+
+	pipeline := files["pipeline.log"]
+	assert.Contains(t, pipeline, "ERROR stage=image scene="+sceneID)
+	assert.Contains(t, pipeline, "status=500 request_id=req-failed")
+	assert.Contains(t, pipeline, "request_id=req-2")
+	assert.Contains(t, pipeline, "request_id=req-3")
+	assert.Contains(t, pipeline, "scene attempt 1 failed")
+	assert.Contains(t, pipeline, "Bearer [REDACTED]")
+	assert.Contains(t, pipeline, "https://oss.example.com/a.png?[REDACTED]")
+	assert.NotContains(t, pipeline, "sk-abcdefgh12345678")
+	assert.NotContains(t, pipeline, "Signature=secret")
+
+	assert.Contains(t, files["events.log"], "pipeline.panic document failed")
+	assert.Contains(t, files["events.log"], db.EventDocumentFinished)
+	assert.NotContains(t, files["events.log"], "internal stack")
+	assert.Empty(t, files["scenes.log"])
+
+	req = httptest.NewRequest(http.MethodGet, "/v1/documents/"+db.MakeUUID()+"/logs", nil)
+	w = httptest.NewRecorder()
+	router.ServeHTTP(w, req)
+	var resp proto.BaseResponse
+	require.NoError(t, json.Unmarshal(w.Body.Bytes(), &resp))
+	assert.Equal(t, ErrNoSuchDocumentCode, resp.Code)
+
+	// 删除文档时删除处理日志
+	require.NoError(t, service.DeleteDocument(ctx, docID))
+	logs, err := service.db.ListDocumentLogs(ctx, docID, 10)
+	require.NoError(t, err)
+	assert.Empty(t, logs)
+}
+
+func TestOpenAPI(t *testing.T) {
+	service, cleanup := setupTestService(t)
+	defer cleanup()
+
+	router := service.RegisterRouter(os.Stdout)
+
+	req := httptest.NewRequest(http.MethodGet, "/openapi.json", nil)
+	w := httptest.NewRecorder()
+	router.ServeHTTP(w, req)
+	require.Equal(t, http.StatusOK, w.Code)
+
+	var doc map[string]any
+	require.NoError(t, json.Unmarshal(w.Body.Bytes(), &doc))
+	assert.Equal(t, "3.0.3", doc["openapi"])
+	paths := doc["paths"].(map[string]any)
+
+	// 路由表与实际注册的路由保持一致
+	documented := 0
+	for _, r := range router.Routes() {
+		if r.Path == openAPIPath || r.Path == swaggerUIPath || strings.HasPrefix(r.Path, swaggerAssetsPath+"/") {
+			continue
+		}
+		path := pathParamPattern.ReplaceAllString(r.Path, "{$1}")
+		ops, ok := paths[path].(map[string]any)
+		require.True(t, ok, "undocumented route: %s %s", r.Method, r.Path)
+		assert.Contains(t, ops, strings.ToLower(r.Method), "undocumented route: %s %s", r.Method, r.Path)
+		documented++
+	}
+	total := 0
+	for _, ops := range paths {
+		total += len(ops.(map[string]any))
+	}
+	assert.Equal(t, documented, total)
+
+	schemas := doc["components"].(map[string]any)["schemas"].(map[string]any)
+	args := schemas["UpdateDocumentArgs"].(map[string]any)
+	assert.Equal(t, []any{"name"}, args["required"])
+
+	req = httptest.NewRequest(http.MethodGet, "/swagger", nil)
+	w = httptest.NewRecorder()
+	router.ServeHTTP(w, req)
+	assert.Equal(t, http.StatusOK, w.Code)
+	assert.Contains(t, w.Body.String(), openAPIPath)
+	assert.NotContains(t, w.Body.String(), "unpkg.com")
+
+	// 静态资源由服务自身提供
+	req = httptest.NewRequest(http.MethodGet, swaggerAssetsPath+"/swagger-ui-bundle.js", nil)
+	w = httptest.NewRecorder()
+	router.ServeHTTP(w, req)
+	assert.Equal(t, http.StatusOK, w.Code)
+	assert.Contains(t, w.Body.String(), "SwaggerUIBundle")
+	req = httptest.NewRequest(http.MethodGet, swaggerAssetsPath+"/missing.js", nil)
+	w = httptest.NewRecorder()
+	router.ServeHTTP(w, req)
+	assert.Equal(t, http.StatusNotFound, w.Code)
+}
+
+func TestPromptTemplates(t *testing.T) {
+	service, cleanup := setupTestService(t)
+	defer cleanup()
+
+	// 默认模板与内置提示词一致
+	roles := []bailian.RoleInfo{
+		{Name: "张三", Gender: "男", Character: "勇敢", Appearance: "浓眉大眼"},
+		{Name: "李四", Gender: "女", Character: "聪明", Appearance: "长发"},
+		{Name: "王五", Gender: "男"},
+	}
+	for _, consistent := range []bool{false, true} {
+		doc := db.Document{Name: "小说", Summary: "摘要", RoleConsistency: consistent}
+		scene := db.Scene{Overrides: api.SceneOverrides{Style: "水彩"}}
+		prompt, err := executePromptTemplate(db.PromptKindImage, defaultImagePromptTemplate, imagePromptVars(&doc, &scene, "张三走进房间", sceneImageOptions(nil, &doc, &scene, roles)))
+		require.NoError(t, err)
+		assert.Equal(t, bailian.ImagePrompt("张三走进房间", "摘要", roles, bailian.GenerateOptions{Style: "水彩", RoleConsistency: consistent}), prompt)
+	}
+	prompt, err := executePromptTemplate(db.PromptKindScene, defaultPromptTemplates[db.PromptKindScene], &promptVars{Chapter: promptChapter{Content: "第一章"}})
+	require.NoError(t, err)
+	assert.Equal(t, fmt.Sprintf(bailian.DefaultScenePrompt, "第一章"), prompt)
+
+	router := service.RegisterRouter(os.Stdout)
+	do := func(method, path string, body any) proto.BaseResponse {
+		var reader io.Reader
+		if body != nil {
+			data, err := json.Marshal(body)
+			require.NoError(t, err)
+			reader = bytes.NewReader(data)
+		}
+		req := asAdmin(httptest.NewRequest(method, path, reader))
+		req.Header.Set("Content-Type", "application/json")
+		w := httptest.NewRecorder()
+		router.ServeHTTP(w, req)
+		var resp proto.BaseResponse
+		require.NoError(t, json.Unmarshal(w.Body.Bytes(), &resp))
+		return resp
+	}
+	decode := func(resp proto.BaseResponse, v any) {
+		require.Equal(t, http.StatusOK, resp.Code, resp.Message)
+		data, err := json.Marshal(resp.Data)
+		require.NoError(t, err)
+		require.NoError(t, json.Unmarshal(data, v))
+	}
+
+	var list api.ListPromptTemplatesResult
+	decode(do(http.MethodGet, "/v1/admin/prompt-templates", nil), &list)
+	require.Len(t, list.Templates, 3)
+	for _, tmpl := range list.Templates {
+		assert.True(t, tmpl.Default)
+		assert.Equal(t, defaultPromptTemplates[tmpl.Kind], tmpl.Content)
+	}
+
+	assert.Equal(t, http.StatusBadRequest, do(http.MethodGet, "/v1/admin/prompt-templates/summary", nil).Code)
+	assert.Equal(t, http.StatusBadRequest, do(http.MethodPut, "/v1/admin/prompt-templates/scene", api.UpdatePromptTemplateArgs{Content: "{{.Chapter.Title}}"}).Code)
+	assert.Equal(t, http.StatusBadRequest, do(http.MethodPut, "/v1/admin/prompt-templates/scene", api.UpdatePromptTemplateArgs{Content: "{{if}}"}).Code)
+
+	// 自定义模板在运行时按文档和章节渲染
+	var tmpl api.PromptTemplate
+	decode(do(http.MethodPut, "/v1/admin/prompt-templates/scene", api.UpdatePromptTemplateArgs{Content: "《{{.Document.Name}}》第 {{.Chapter.Index}} 章：{{.Chapter.Content}}"}), &tmpl)
+	assert.False(t, tmpl.Default)
+	decode(do(http.MethodGet, "/v1/admin/prompt-templates/scene", nil), &tmpl)
+	assert.Equal(t, "《{{.Document.Name}}》第 {{.Chapter.Index}} 章：{{.Chapter.Content}}", tmpl.Content)
+
+	ctx := context.Background()
+	prompt, err = renderPrompt(ctx, service.db, db.PromptKindScene, &promptVars{
+		Document: promptDocument{Name: "小说"},
+		Chapter:  promptChapter{Index: 2, Content: "他走进了房间。"},
+	})
+	require.NoError(t, err)
+	assert.Equal(t, "《小说》第 2 章：他走进了房间。", prompt)
+
+	// 未自定义的类型返回空字符串，由百炼使用内置提示词
+	prompt, err = renderPrompt(ctx, service.db, db.PromptKindRole, &promptVars{})
+	require.NoError(t, err)
+	assert.Empty(t, prompt)
+
+	decode(do(http.MethodDelete, "/v1/admin/prompt-templates/scene", nil), &tmpl)
+	assert.True(t, tmpl.Default)
+	prompt, err = renderPrompt(ctx, service.db, db.PromptKindScene, &promptVars{})
+	require.NoError(t, err)
+	assert.Empty(t, prompt)
+}
+
+func TestLimits(t *testing.T) {
+	service, cleanup := setupTestService(t)
+	defer cleanup()
+
+	service.quota = newQuotaMgr(QuotaConfig{
+		Default: api.QuotaLimits{MaxDocuments: 3, MaxImagesPerDay: 10},
+	}, service.db)
+	ctx := context.Background()
+	_, err := service.db.CreateDocument(ctx, db.MakeUUID(), "file-id", &api.CreateDocumentArgs{Name: "限制文档", FileSize: 100})
+	require.NoError(t, err)
+
+	// 不支持的文件类型在上传时直接拒绝
+	_, err = service.CreateDocument(ctx, 0, "可执行文件", "", false, false, "a.exe", 1, strings.NewReader("x"))
+	var ae *proto.ApiError
+	require.ErrorAs(t, err, &ae)
+	assert.Equal(t, "unsupported file type", ae.Message)
+
+	router := service.RegisterRouter(os.Stdout)
+	req := httptest.NewRequest(http.MethodGet, "/v1/limits", nil)
+	w := httptest.NewRecorder()
+	router.ServeHTTP(w, req)
+	var resp proto.BaseResponse
+	require.NoError(t, json.Unmarshal(w.Body.Bytes(), &resp))
+	require.Equal(t, http.StatusOK, resp.Code)
+	data, err := json.Marshal(resp.Data)
+	require.NoError(t, err)
+	var limits api.Limits
+	require.NoError(t, json.Unmarshal(data, &limits))
+
+	assert.Equal(t, []string{".doc", ".docx", ".md", ".pdf", ".txt"}, limits.Upload.FileTypes)
+	assert.Equal(t, int64(50<<20), limits.Upload.MaxURLBytes)
+	assert.Equal(t, []string{".zip", ".tar.gz", ".tgz"}, limits.Upload.ArchiveTypes)
+	assert.Equal(t, api.ChapterLimits{MaxChars: 5000, OverlapChars: 100, ShortChars: 200}, limits.Chapter)
+	assert.Equal(t, api.RateLimits{MaxConcurrent: 2, MaxQueued: 10}, limits.RateLimit)
+	assert.Equal(t, 50, limits.DocumentName.MaxLength)
+	assert.Equal(t, 3, limits.Quota.MaxDocuments)
+
+	// 未限制的配额项不返回剩余量
+	require.NotNil(t, limits.QuotaRemaining.Documents)
+	assert.Equal(t, int64(2), *limits.QuotaRemaining.Documents)
+	require.NotNil(t, limits.QuotaRemaining.ImagesToday)
+	assert.Equal(t, int64(10), *limits.QuotaRemaining.ImagesToday)
+	assert.Nil(t, limits.QuotaRemaining.UploadBytes)
+	assert.Nil(t, limits.QuotaRemaining.VoicesToday)
+}
+
+func TestStylePresets(t *testing.T) {
+	service, cleanup := setupTestService(t)
+	defer cleanup()
+
+	_, err := newStylePresets([]api.StylePreset{{Prompt: "无名称"}})
+	assert.Error(t, err)
+	service.styles, err = newStylePresets([]api.StylePreset{
+		{Name: "ink", Title: "水墨", Prompt: "淡墨山水"},
+		{Name: "pixel", Title: "像素", Prompt: "像素风格", NegativePrompt: "模糊"},
+	})
+	require.NoError(t, err)
+
+	router := service.RegisterRouter(os.Stdout)
+	req := httptest.NewRequest(http.MethodGet, "/v1/style-presets", nil)
+	w := httptest.NewRecorder()
+	router.ServeHTTP(w, req)
+	var resp proto.BaseResponse
+	require.NoError(t, json.Unmarshal(w.Body.Bytes(), &resp))
+	require.Equal(t, http.StatusOK, resp.Code)
+	data, err := json.Marshal(resp.Data)
+	require.NoError(t, err)
+	var result api.ListStylePresetsResult
+	require.NoError(t, json.Unmarshal(data, &result))
+	require.Len(t, result.Presets, len(builtinStylePresets)+1)
+	assert.Equal(t, api.StylePreset{Name: "ink", Title: "水墨", Prompt: "淡墨山水"}, result.Presets[2])
+	assert.Equal(t, "pixel", result.Presets[len(result.Presets)-1].Name)
+
+	ctx := context.Background()
+	docID := db.MakeUUID()
+	_, err = service.db.CreateDocument(ctx, docID, "file-id", &api.CreateDocumentArgs{Name: "风格文档"})
+	require.NoError(t, err)
+
+	_, err = service.UpdateDocument(ctx, docID, &api.UpdateDocumentArgs{Name: "风格文档", ImageStyle: &api.ImageStyle{Preset: "oil"}})
+	var ae *proto.ApiError
+	require.ErrorAs(t, err, &ae)
+	assert.Equal(t, http.StatusBadRequest, ae.Code)
+
+	style := api.ImageStyle{Preset: "pixel", Prompt: "夜景", NegativePrompt: "人群"}
+	doc, err := service.UpdateDocument(ctx, docID, &api.UpdateDocumentArgs{Name: "风格文档", ImageStyle: &style})
+	require.NoError(t, err)
+	assert.Equal(t, style, doc.ImageStyle)
+
+	// 不传 ImageStyle 时保持不变
+	doc, err = service.UpdateDocument(ctx, docID, &api.UpdateDocumentArgs{Name: "风格文档"})
+	require.NoError(t, err)
+	assert.Equal(t, style, doc.ImageStyle)
+
+	// 预设、文档和场景的风格依次合并到每个场景的图片请求
+	stored, err := service.db.GetDocument(ctx, docID)
+	require.NoError(t, err)
+	scene := db.Scene{Overrides: api.SceneOverrides{Style: "冷色调"}}
+	opts := sceneImageOptions(service.styles, &stored, &scene, nil)
+	assert.Equal(t, "像素风格，夜景，冷色调", opts.Style)
+	assert.Equal(t, "模糊，人群", opts.NegativePrompt)
+	opts = sceneImageOptions(service.styles, &stored, &db.Scene{}, nil)
+	assert.Equal(t, "像素风格，夜景", opts.Style)
+
+	doc, err = service.UpdateDocument(ctx, docID, &api.UpdateDocumentArgs{Name: "风格文档", ImageStyle: &api.ImageStyle{}})
+	require.NoError(t, err)
+	assert.Equal(t, api.ImageStyle{}, doc.ImageStyle)
+}
+
+func TestSceneAttributes(t *testing.T) {
+	service, cleanup := setupTestService(t)
+	defer cleanup()
+	ctx := context.Background()
+
+	bailianServer := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		scenes := `[{"description":"祥子拉着车跑过街口","location":"北平街头","time_of_day":"Evening","mood":"焦急","characters_present":["祥子"," ","祥子"]},` +
+			`{"description":"虎妞在车厂等祥子","location":"人和车厂","time_of_day":"midnight","mood":"温馨","characters_present":["虎妞","祥子"]},` +
+			`"旧格式的场景描述"]`
+		content, err := json.Marshal("```json\n" + scenes + "\n```")
+		require.NoError(t, err)
+		fmt.Fprintf(w, `{"choices":[{"message":{"role":"assistant","content":%s}}]}`, content)
+	}))
+	defer bailianServer.Close()
+	var err error
+	service.bailianClient, err = bailian.NewClient(bailian.Config{BaseURL: bailianServer.URL, APIKey: "test"})
+	require.NoError(t, err)
+	service.documentMgr, err = newDocumentMgr(DocumentConfigEx{config: DocumentConfig{Enable: true}, db: service.db, quota: service.quota}, service.bailianClient)
+	require.NoError(t, err)
+	service.styles, err = newStylePresets(nil)
+	require.NoError(t, err)
+	router := service.RegisterRouter(os.Stdout)
+
+	docID := db.MakeUUID()
+	_, err = service.db.CreateDocument(ctx, docID, "file-id", &api.CreateDocumentArgs{Name: "骆驼祥子"})
+	require.NoError(t, err)
+	require.NoError(t, service.db.CreateChapters(ctx, docID, []string{"第一章 祥子拉车"}))
+	doc, err := service.db.GetDocument(ctx, docID)
+	require.NoError(t, err)
+	require.NoError(t, service.documentMgr.HandleDocumentScence(ctx, doc))
+
+	listScenes := func(query string) (int, []api.Scene) {
+		req := httptest.NewRequest(http.MethodGet, "/v1/documents/"+docID+"/scenes"+query, nil)
+		w := httptest.NewRecorder()
+		router.ServeHTTP(w, req)
+		var resp proto.BaseResponse
+		require.NoError(t, json.Unmarshal(w.Body.Bytes(), &resp))
+		data, err := json.Marshal(resp.Data)
+		require.NoError(t, err)
+		var ret api.ListScenesResult
+		require.NoError(t, json.Unmarshal(data, &ret))
+		return resp.Code, ret.Scenes
+	}
+
+	// 无法识别的时间段记为空，出场人物去重，旧格式的场景没有属性
+	code, scenes := listScenes("")
+	require.Equal(t, http.StatusOK, code)
+	require.Len(t, scenes, 3)
+	assert.Equal(t, "北平街头", scenes[0].Location)
+	assert.Equal(t, "evening", scenes[0].TimeOfDay)
+	assert.Equal(t, "焦急", scenes[0].Mood)
+	assert.Equal(t, []string{"祥子"}, scenes[0].CharactersPresent)
+	assert.Empty(t, scenes[1].TimeOfDay)
+	assert.Equal(t, "旧格式的场景描述", scenes[2].Content)
+	assert.Empty(t, scenes[2].Location)
+
+	_, scenes = listScenes("?character=" + url.QueryEscape("虎妞"))
+	require.Len(t, scenes, 1)
+	assert.Equal(t, "人和车厂", scenes[0].Location)
+	_, scenes = listScenes("?time_of_day=evening&location=" + url.QueryEscape("北平"))
+	require.Len(t, scenes, 1)
+	assert.Equal(t, "祥子拉着车跑过街口", scenes[0].Content)
+	_, scenes = listScenes("?mood=" + url.QueryEscape("悲伤"))
+	assert.Empty(t, scenes)
+	code, _ = listScenes("?time_of_day=midnight")
+	assert.Equal(t, http.StatusBadRequest, code)
+
+	// 生成图片的场景描述补充地点、时间段、氛围和出场人物
+	allScenes, err := service.db.ListScenesByDocument(ctx, docID)
+	require.NoError(t, err)
+	ret, err := service.PreviewScenePrompt(ctx, &allScenes[0])
+	require.NoError(t, err)
+	assert.Contains(t, ret.Prompt, "祥子拉着车跑过街口（地点：北平街头；时间：傍晚；氛围：焦急；人物：祥子）")
+	ret, err = service.PreviewScenePrompt(ctx, &allScenes[2])
+	require.NoError(t, err)
+	assert.Contains(t, ret.Prompt, "根据以下场景描述生成一张动漫图片：旧格式的场景描述\n")
+}
+
+func TestPreviewScenePrompt(t *testing.T) {
+	service, cleanup := setupTestService(t)
+	defer cleanup()
+
+	var err error
+	service.styles, err = newStylePresets([]api.StylePreset{{Name: "ink", Title: "水墨", Prompt: "淡墨山水", NegativePrompt: "鲜艳"}})
+	require.NoError(t, err)
+
+	ctx := context.Background()
+	docID := db.MakeUUID()
+	_, err = service.db.CreateDocument(ctx, docID, "file-id", &api.CreateDocumentArgs{Name: "预览文档"})
+	require.NoError(t, err)
+	enable := true
+	_, err = service.UpdateDocument(ctx, docID, &api.UpdateDocumentArgs{Name: "预览文档", RoleConsistency: &enable, ImageStyle: &api.ImageStyle{Preset: "ink", Prompt: "夜景"}})
+	require.NoError(t, err)
+	require.NoError(t, service.db.CreateRoles(ctx, []db.Role{
+		{ID: db.MakeUUID(), DocumentID: docID, Name: "李雷", Gender: "男", Appearance: "短发，戴眼镜", PortraitURL: "http://img/lilei.png"},
+		{ID: db.MakeUUID(), DocumentID: docID, Name: "韩梅梅", Gender: "女", Appearance: "马尾辫"},
+	}))
+	scene := db.Scene{ID: db.MakeUUID(), ChapterID: db.MakeUUID(), DocumentID: docID, Content: "李雷走进教室", Overrides: api.SceneOverrides{Style: "冷色调"}}
+	require.NoError(t, service.db.CreateScenes(ctx, []db.Scene{scene}))
+
+	router := service.RegisterRouter(os.Stdout)
+	preview := func(id string) (proto.BaseResponse, api.ScenePromptPreview) {
+		req := httptest.NewRequest(http.MethodGet, "/v1/scenes/"+id+"/prompt-preview", nil)
+		w := httptest.NewRecorder()
+		router.ServeHTTP(w, req)
+		var resp proto.BaseResponse
+		require.NoError(t, json.Unmarshal(w.Body.Bytes(), &resp))
+		var ret api.ScenePromptPreview
+		if resp.Code == http.StatusOK {
+			data, err := json.Marshal(resp.Data)
+			require.NoError(t, err)
+			require.NoError(t, json.Unmarshal(data, &ret))
+		}
+		return resp, ret
+	}
+
+	// 合并风格预设，只注入场景中出现的角色，参考立绘随提示词发送
+	resp, ret := preview(scene.ID)
+	require.Equal(t, http.StatusOK, resp.Code, resp.Message)
+	assert.Equal(t, imagegen.ProviderBailian, ret.Provider)
+	assert.False(t, ret.Template)
+	assert.Contains(t, ret.Prompt, "根据以下场景描述生成一张动漫图片：李雷走进教室")
+	assert.Contains(t, ret.Prompt, "画面风格：淡墨山水，夜景，冷色调")
+	assert.Contains(t, ret.Prompt, "外貌特征：短发，戴眼镜；长相参考第 1 张参考图")
+	assert.NotContains(t, ret.Prompt, "韩梅梅")
+	assert.Equal(t, "鲜艳", ret.NegativePrompt)
+	assert.Equal(t, []string{"http://img/lilei.png"}, ret.References)
+
+	// 自定义模板渲染的提示词原样发送
+	require.NoError(t, service.db.SavePromptTemplate(ctx, &db.PromptTemplate{Kind: db.PromptKindImage, Content: "{{.Scene.Content}}｜{{.Scene.Style}}"}))
+	resp, ret = preview(scene.ID)
+	require.Equal(t, http.StatusOK, resp.Code, resp.Message)
+	assert.True(t, ret.Template)
+	assert.Equal(t, "李雷走进教室｜淡墨山水，夜景，冷色调", ret.Prompt)
+
+	resp, _ = preview(db.MakeUUID())
+	assert.Equal(t, http.StatusNotFound, resp.Code)
+}
+
+func TestImageAspectRatio(t *testing.T) {
+	service, cleanup := setupTestService(t)
+	defer cleanup()
+
+	ctx := context.Background()
+	docID := db.MakeUUID()
+	_, err := service.db.CreateDocument(ctx, docID, "file-id", &api.CreateDocumentArgs{Name: "竖屏文档"})
+	require.NoError(t, err)
+
+	var ae *proto.ApiError
+	ratio, size := "4:3", "1080x1920"
+	_, err = service.UpdateDocument(ctx, docID, &api.UpdateDocumentArgs{Name: "竖屏文档", AspectRatio: &ratio})
+	require.ErrorAs(t, err, &ae)
+	assert.Equal(t, http.StatusBadRequest, ae.Code)
+	_, err = service.UpdateDocument(ctx, docID, &api.UpdateDocumentArgs{Name: "竖屏文档", ImageSize: &size})
+	require.ErrorAs(t, err, &ae)
+	assert.Equal(t, http.StatusBadRequest, ae.Code)
+
+	ratio = imagegen.AspectRatioPortrait
+	doc, err := service.UpdateDocument(ctx, docID, &api.UpdateDocumentArgs{Name: "竖屏文档", AspectRatio: &ratio})
+	require.NoError(t, err)
+	assert.Equal(t, imagegen.AspectRatioPortrait, doc.AspectRatio)
+	assert.Empty(t, doc.ImageSize)
+
+	// 不传时保持不变
+	size = "720*1280"
+	doc, err = service.UpdateDocument(ctx, docID, &api.UpdateDocumentArgs{Name: "竖屏文档", ImageSize: &size})
+	require.NoError(t, err)
+	assert.Equal(t, imagegen.AspectRatioPortrait, doc.AspectRatio)
+	assert.Equal(t, "720*1280", doc.ImageSize)
+
+	// 场景指定的分辨率优先于文档的设置
+	stored, err := service.db.GetDocument(ctx, docID)
+	require.NoError(t, err)
+	opts := sceneImageOptions(nil, &stored, &db.Scene{}, nil)
+	assert.Equal(t, "720*1280", opts.Size)
+	assert.Equal(t, imagegen.AspectRatioPortrait, opts.AspectRatio)
+	opts = sceneImageOptions(nil, &stored, &db.Scene{Overrides: api.SceneOverrides{ImageSize: "928*1664"}}, nil)
+	assert.Equal(t, "928*1664", opts.Size)
+
+	empty := ""
+
+	doc, err = service.UpdateDocument(ctx, docID, &api.UpdateDocumentArgs{Name: "竖屏文档", AspectRatio: &empty, ImageSize: &empty})
+	require.NoError(t, err)
+	assert.Empty(t, doc.AspectRatio)
+	assert.Empty(t, doc.ImageSize)
+}
+
+func TestSelfTest(t *testing.T) {
+	service, cleanup := setupTestService(t)
+	defer cleanup()
+
+	router := service.RegisterRouter(os.Stdout)
+	selfTest := func(query string) proto.BaseResponse {
+		req := asAdmin(httptest.NewRequest(http.MethodPost, "/v1/admin/selftest"+query, nil))
+		w := httptest.NewRecorder()
+		router.ServeHTTP(w, req)
+		var resp proto.BaseResponse
+		require.NoError(t, json.Unmarshal(w.Body.Bytes(), &resp))
+		return resp
+	}
+	assert.Equal(t, http.StatusBadRequest, selfTest("?provider=fake").Code)
+	if service.bailianClient == nil {
+		assert.Equal(t, http.StatusBadRequest, selfTest("?provider=real").Code)
+	}
+
+	// 图片目录未配置时图片阶段失败，后续阶段跳过
+	ctx := context.Background()
+	ret, err := service.SelfTest(ctx, api.SelfTestProviderMock)
+	require.NoError(t, err)
+	assert.False(t, ret.OK)
+	require.Len(t, ret.Stages, len(selfTestStages))
+	assert.True(t, ret.Stages[4].OK)
+	assert.Equal(t, selfTestImage, ret.Stages[5].Name)
+	assert.False(t, ret.Stages[5].OK)
+	assert.Equal(t, "image store not configured", ret.Stages[5].Error)
+	assert.True(t, ret.Stages[6].Skipped)
+
+	service.imageStore = mediastore.New(filepath.Join(service.conf.Temp, "images"), "/v1/images")
+	service.audioStore = mediastore.New(filepath.Join(service.conf.Temp, "audio"), "/v1/audio")
+	resp := selfTest("")
+	require.Equal(t, http.StatusOK, resp.Code, resp.Message)
+	data, err := json.Marshal(resp.Data)
+	require.NoError(t, err)
+	ret = &api.SelfTestResult{}
+	require.NoError(t, json.Unmarshal(data, ret))
+	assert.True(t, ret.OK)
+	assert.Equal(t, api.SelfTestProviderMock, ret.Provider)
+	for i, stage := range ret.Stages {
+		assert.Equal(t, selfTestStages[i], stage.Name)
+		assert.True(t, stage.OK, stage.Error)
+	}
+	assert.Equal(t, "林晓,老人", ret.Stages[3].Detail)
+	assert.Regexp(t, `^/v1/images/[0-9a-f]{64}\.png$`, ret.Stages[5].Detail)
+	assert.Regexp(t, `^/v1/audio/[0-9a-f]{64}\.wav$`, ret.Stages[6].Detail)
+
+	// 临时文件已删除
+	matches, err := filepath.Glob(filepath.Join(service.conf.Temp, "*_temp.txt"))
+	require.NoError(t, err)
+	assert.Empty(t, matches)
+}
+
+func TestUpscaleSceneImage(t *testing.T) {
+	service, cleanup := setupTestService(t)
+	defer cleanup()
+
+	ctx := context.Background()
+	var got bailian.ImageEditRequest
+	bailianServer := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		assert.Equal(t, "/api/v1/services/aigc/image2image/image-synthesis", r.URL.Path)
+		assert.Equal(t, "enable", r.Header.Get("X-DashScope-Async"))
+		require.NoError(t, json.NewDecoder(r.Body).Decode(&got))
+		w.Write([]byte(`{"output":{"task_id":"task-1","task_status":"SUCCEEDED","results":[{"url":"http://img/hd"}]}}`))
+	}))
+	defer bailianServer.Close()
+	var err error
+	service.bailianClient, err = bailian.NewClient(bailian.Config{BaseURL: bailianServer.URL, APIKey: "test"})
+	require.NoError(t, err)
+	service.imageStore = mediastore.New(t.TempDir(), "/v1/images")
+	service.imageGen, err = imagegen.New(imagegen.Config{}, service.bailianClient, service.imageStore)
+	require.NoError(t, err)
+
+	docID := db.MakeUUID()
+	_, err = service.db.CreateDocument(ctx, docID, "file-id", &api.CreateDocumentArgs{Name: "超分文档"})
+	require.NoError(t, err)
+	imageURL, err := service.imageStore.Save([]byte("\x89PNG\r\n\x1a\n scene"), "png")
+	require.NoError(t, err)
+	scene := db.Scene{ID: db.MakeUUID(), ChapterID: db.MakeUUID(), DocumentID: docID, Content: "场景", ImageURL: imageURL, Status: db.SceneStatusReady}
+	pending := db.Scene{ID: db.MakeUUID(), ChapterID: scene.ChapterID, DocumentID: docID, Index: 1, Content: "待生成"}
+	require.NoError(t, service.db.CreateScenes(ctx, []db.Scene{scene, pending}))
+
+	router := service.RegisterRouter(os.Stdout)
+	upscale := func(path, body string) proto.BaseResponse {
+		req := httptest.NewRequest(http.MethodPost, path, strings.NewReader(body))
+		req.Header.Set("Content-Type", "application/json")
+		w := httptest.NewRecorder()
+		router.ServeHTTP(w, req)
+		var resp proto.BaseResponse
+		require.NoError(t, json.Unmarshal(w.Body.Bytes(), &resp))
+		return resp
+	}
+	assert.Equal(t, http.StatusNotFound, upscale("/v1/scenes/"+scene.ID+"/image:rotate", "").Code)
+	assert.Equal(t, http.StatusBadRequest, upscale("/v1/scenes/"+scene.ID+"/image:upscale", `{"scale":5}`).Code)
+	assert.Equal(t, http.StatusBadRequest, upscale("/v1/scenes/"+pending.ID+"/image:upscale", "").Code)
+	assert.Equal(t, http.StatusNotFound, upscale("/v1/scenes/"+db.MakeUUID()+"/image:upscale", "").Code)
+
+	// 不传倍数时使用默认倍数，本地图片以 base64 传给百炼
+	resp := upscale("/v1/scenes/"+scene.ID+"/image:upscale", "")
+	require.Equal(t, http.StatusOK, resp.Code, resp.Message)
+	assert.Equal(t, imagegen.DefaultUpscale, got.Parameters.UpscaleFactor)
+	assert.Equal(t, "super_resolution", got.Input.Function)
+	assert.True(t, strings.HasPrefix(got.Input.BaseImageURL, "data:image/png;base64,"), got.Input.BaseImageURL)
+
+	ret, err := service.UpscaleSceneImage(ctx, scene.ID, &api.UpscaleSceneImageArgs{Scale: 4})
+	require.NoError(t, err)
+	assert.Equal(t, 4, got.Parameters.UpscaleFactor)
+	assert.Equal(t, "http://img/hd", ret.HDImageURL)
+	assert.Equal(t, imageURL, ret.ImageURL)
+
+	usage, err := service.quota.Usage(ctx, 0)
+	require.NoError(t, err)
+	assert.EqualValues(t, 2, usage.ImagesToday)
+
+	stored, err := service.db.GetScene(ctx, scene.ID)
+	require.NoError(t, err)
+	manifest := buildManifest(&db.Document{ID: docID}, nil, []db.Scene{stored})
+	assert.Equal(t, "http://img/hd", manifest.Scenes[0].HDImageURL)
+
+	// 场景图片重新生成后高清图片失效
+	require.NoError(t, service.db.UpdateSceneImageURL(ctx, scene.ID, "http://img/new"))
+	stored, err = service.db.GetScene(ctx, scene.ID)
+	require.NoError(t, err)
+	assert.Empty(t, makeScene(&stored).HDImageURL)
+}
+
+// testModerator 按敏感词审核文字，图片 URL 在 flagged 中的图片不通过
+type testModerator struct {
+	*moderation.Keyword
+	flagged map[string]bool
+}
+
+func (m *testModerator) ModerateImage(ctx context.Context, image string) (moderation.Result, error) {
+	if m.flagged[image] {
+		return moderation.Result{Flagged: true, Reason: "flagged: violence"}, nil
+	}
+	return moderation.Result{}, nil
+}
+
+func TestModeration(t *testing.T) {
+	service, cleanup := setupTestService(t)
+	defer cleanup()
+
+	router := service.RegisterRouter(os.Stdout)
+	ctx := context.Background()
+
+	// 图片和语音请求依次编号，每个场景先生成图片再生成语音
+	var calls atomic.Int32
+	var bailianServer *httptest.Server
+	bailianServer = httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodPost {
+			w.WriteHeader(http.StatusNotFound)
+			return
+		}
+		n := calls.Add(1)
+		fmt.Fprintf(w, `{"output":{"choices":[{"message":{"content":[{"image":"http://img/%d"}]}}],"audio":{"url":"%s/voice/%d"}}}`, n, bailianServer.URL, n)
+	}))
+	defer bailianServer.Close()
+	bailianClient, err := bailian.NewClient(bailian.Config{BaseURL: bailianServer.URL, APIKey: "test"})
+	require.NoError(t, err)
+	moderator := &testModerator{Keyword: moderation.NewKeyword([]string{"打斗"}), flagged: map[string]bool{"http://img/3": true}}
+	mgr, err := newDocumentMgr(DocumentConfigEx{
+		config:     DocumentConfig{Enable: true, MinSceneSuccessRatio: 0.3},
+		db:         service.db,
+		quota:      service.quota,
+		moderation: moderator,
+
+		mediaBaseURL: "http://localhost/v1",
+	}, bailianClient)
+	require.NoError(t, err)
+
+	docID := db.MakeUUID()
+	_, err = service.db.CreateDocument(ctx, docID, "file-id", &api.CreateDocumentArgs{Name: "审核文档", UserID: 7})
+	require.NoError(t, err)
+	require.NoError(t, service.db.UpdateDocumentStatus(ctx, docID, db.DocumentStatusSceneReady))
+	sceneIDs := []string{db.MakeUUID(), db.MakeUUID(), db.MakeUUID()}
+	for i, content := range []string{"平静的湖面", "街头打斗", "夜晚的街道"} {
+		require.NoError(t, service.db.CreateScenes(ctx, []db.Scene{
+			{ID: sceneIDs[i], ChapterID: db.MakeUUID(), DocumentID: docID, Index: i, Content: content},
+		}))
+	}
+
+	do := func(method, path string) proto.BaseResponse {
+		req := asAdmin(httptest.NewRequest(method, path, nil))
+		w := httptest.NewRecorder()
+		router.ServeHTTP(w, req)
+		var resp proto.BaseResponse
+		require.NoError(t, json.Unmarshal(w.Body.Bytes(), &resp))
+		return resp
+	}
+	list := func(query string) api.ListBlockedScenesResult {
+		resp := do(http.MethodGet, "/v1/admin/moderation/scenes"+query)
+		require.Equal(t, http.StatusOK, resp.Code, resp.Message)
+		data, err := json.Marshal(resp.Data)
+		require.NoError(t, err)
+		var ret api.ListBlockedScenesResult
+		require.NoError(t, json.Unmarshal(data, &ret))
+		return ret
+	}
+	getScene := func(id string) db.Scene {
+		scene, err := service.db.GetScene(ctx, id)
+		require.NoError(t, err)
+		return scene
+	}
+
+	// 第二个场景描述未通过，不生成图片；第三个场景图片未通过，图片被扣留，两者都使用占位媒体且不重试
+	mgr.HandleImageGenTasks(ctx)
+	assert.Equal(t, int32(3), calls.Load())
+	assert.Equal(t, db.SceneStatusReady, getScene(sceneIDs[0]).Status)
+	blocked := getScene(sceneIDs[1])
+	assert.Equal(t, db.SceneStatusBlocked, blocked.Status)
+	assert.Equal(t, "content contains keyword: 打斗", blocked.ModerationReason)
+	assert.True(t, blocked.Placeholder)
+	assert.Equal(t, "http://localhost/v1/scenes/"+sceneIDs[1]+"/placeholder/image", blocked.ImageURL)
+	assert.Equal(t, "content contains keyword: 打斗", makeScene(&blocked).ModerationReason)
+	doc, err := service.db.GetDocument(ctx, docID)
+	require.NoError(t, err)
+	assert.Equal(t, db.DocumentStatusCompletedWithErrors, doc.Status)
+	assert.Equal(t, 2, doc.FailedSceneCount)
+
+	scenes := list("?limit=1")
+	require.Len(t, scenes.Scenes, 1)
+	require.NotEmpty(t, scenes.NextMarker)
+	next := list("?limit=1&marker=" + scenes.NextMarker)
+	require.Len(t, next.Scenes, 1)
+	all := map[string]api.BlockedScene{scenes.Scenes[0].ID: scenes.Scenes[0], next.Scenes[0].ID: next.Scenes[0]}
+	assert.Equal(t, api.BlockedScene{
+		ID: sceneIDs[2], DocumentID: docID, UserID: 7, Index: 2, Content: "夜晚的街道",
+		Reason: "image flagged: violence", HeldImageURL: "http://img/3", BlockedAt: all[sceneIDs[2]].BlockedAt,
+	}, all[sceneIDs[2]])
+	assert.Empty(t, all[sceneIDs[1]].HeldImageURL)
+
+	// 放行第三个场景，使用扣留的图片，只重新生成语音
+	resp := do(http.MethodPost, "/v1/admin/moderation/scenes/"+sceneIDs[2]+"/approve")
+	require.Equal(t, http.StatusOK, resp.Code, resp.Message)
+	doc, err = service.db.GetDocument(ctx, docID)
+	require.NoError(t, err)
+	assert.Equal(t, db.DocumentStatusSceneReady, doc.Status)
+	assert.Equal(t, http.StatusBadRequest, do(http.MethodPost, "/v1/admin/moderation/scenes/"+sceneIDs[2]+"/approve").Code)
+	mgr.HandleImageGenTasks(ctx)
+	assert.Equal(t, int32(4), calls.Load())
+	approved := getScene(sceneIDs[2])
+	assert.Equal(t, db.SceneStatusReady, approved.Status)
+	assert.Equal(t, "http://img/3", approved.ImageURL)
+	assert.False(t, approved.Placeholder)
+	assert.Empty(t, approved.HeldImageURL)
+	assert.Empty(t, approved.ModerationReason)
+	assert.False(t, approved.ModerationPassed)
+
+	// 重新生成的场景再次审核，描述仍未通过
+	resp = do(http.MethodPost, "/v1/admin/moderation/scenes/"+sceneIDs[1]+"/regenerate")
+	require.Equal(t, http.StatusOK, resp.Code, resp.Message)
+	mgr.HandleImageGenTasks(ctx)
+	assert.Equal(t, db.SceneStatusBlocked, getScene(sceneIDs[1]).Status)
+
+	// 放行后跳过审核重新生成图片，文档全部完成
+	resp = do(http.MethodPost, "/v1/admin/moderation/scenes/"+sceneIDs[1]+"/approve")
+	require.Equal(t, http.StatusOK, resp.Code, resp.Message)
+	mgr.HandleImageGenTasks(ctx)
+	assert.Equal(t, "http://img/5", getScene(sceneIDs[1]).ImageURL)
+	doc, err = service.db.GetDocument(ctx, docID)
+	require.NoError(t, err)
+	assert.Equal(t, db.DocumentStatusImgReady, doc.Status)
+	assert.Empty(t, list("").Scenes)
+	assert.Equal(t, http.StatusBadRequest, do(http.MethodPost, "/v1/admin/moderation/scenes/"+sceneIDs[0]+"/regenerate").Code)
+
+	events, err := service.db.ListEvents(ctx, 7, 0, 100)
+	require.NoError(t, err)
+	blockedEvents := 0
+	for _, e := range events {
+		if e.Type == db.EventSceneBlocked {
+			blockedEvents++
+		}
+	}
+	assert.Equal(t, 3, blockedEvents)
+}
+
+// testEmbedder 按关键字出现次数生成向量，记录每次计算的文本
+type testEmbedder struct {
+	keywords []string
+	texts    []string
+}
+
+func (e *testEmbedder) Model() string {
+	return "test"
+}
+
+func (e *testEmbedder) Embed(ctx context.Context, texts []string) ([][]float32, error) {
+	e.texts = append(e.texts, texts...)
+	vectors := make([][]float32, 0, len(texts))
+	for _, text := range texts {
+		vector := make([]float32, len(e.keywords))
+		for i, keyword := range e.keywords {
+			vector[i] = float32(strings.Count(text, keyword))
+		}
+		vectors = append(vectors, vector)
+	}
+	return vectors, nil
+}
+
+func TestSemanticSearch(t *testing.T) {
+	service, cleanup := setupTestService(t)
+	defer cleanup()
+
+	router := service.RegisterRouter(os.Stdout)
+	ctx := context.Background()
+
+	docID := db.MakeUUID()
+	_, err := service.db.CreateDocument(ctx, docID, "file-id", &api.CreateDocumentArgs{Name: "语义搜索文档"})
+	require.NoError(t, err)
+	require.NoError(t, service.db.CreateChapters(ctx, docID, []string{"湖边的小屋，湖水清澈", "山顶的寺庙", "城市的夜晚，山影模糊"}))
+	chapters, err := service.db.ListChapters(ctx, docID)
+	require.NoError(t, err)
+
+	search := func(docID, q string) proto.BaseResponse {
+		req := httptest.NewRequest(http.MethodGet, "/v1/documents/"+docID+"/search?"+url.Values{"q": {q}, "limit": {"2"}}.Encode(), nil)
+		w := httptest.NewRecorder()
+		router.ServeHTTP(w, req)
+		var resp proto.BaseResponse
+		require.NoError(t, json.Unmarshal(w.Body.Bytes(), &resp))
+		return resp
+	}
+	hits := func(docID, q string) []api.SemanticSearchHit {
+		resp := search(docID, q)
+		require.Equal(t, http.StatusOK, resp.Code, resp.Message)
+		data, err := json.Marshal(resp.Data)
+		require.NoError(t, err)
+		var ret api.SemanticSearchResult
+		require.NoError(t, json.Unmarshal(data, &ret))
+		return ret.Hits
+	}
+
+	// 未配置向量服务
+	assert.Equal(t, http.StatusBadRequest, search(docID, "山").Code)
+
+	provider := &testEmbedder{keywords: []string{"湖", "山", "城"}}
+	service.embedder = newChapterEmbedder(service.db, provider, nil)
+
+	// 首次搜索时计算全部章节的向量
+	ret := hits(docID, "山")
+	require.Len(t, ret, 2)
+	assert.Equal(t, chapters[1].ID, ret[0].ChapterID)
+	assert.InDelta(t, 1, ret[0].Score, 1e-6)
+	assert.Equal(t, chapters[2].ID, ret[1].ChapterID)
+	assert.Equal(t, "山顶的寺庙", ret[0].Snippet)
+	assert.Len(t, provider.texts, 4)
+
+	// 向量已是最新，只计算查询文本
+	assert.Equal(t, chapters[0].ID, hits(docID, "湖")[0].ChapterID)
+	assert.Len(t, provider.texts, 5)
+
+	// 修改的章节重新计算，删除的章节不再返回
+	require.NoError(t, service.db.UpdateChapter(ctx, chapters[0].ID, &api.UpdateChapterArgs{Content: "城中的湖"}))
+	require.NoError(t, service.db.DeleteChapter(ctx, chapters[2].ID, docID))
+	ret = hits(docID, "城")
+	require.Len(t, ret, 2)
+	assert.Equal(t, chapters[0].ID, ret[0].ChapterID)
+	assert.Equal(t, []string{"\n城中的湖", "城"}, provider.texts[5:])
+	embeddings, err := service.db.ListChapterEmbeddings(ctx, docID, false)
+	require.NoError(t, err)
+	assert.Len(t, embeddings, 2)
+
+	assert.Equal(t, http.StatusBadRequest, search(docID, " ").Code)
+	assert.Equal(t, 612, search(db.MakeUUID(), "山").Code)
+
+	// 删除文档时删除向量
+	require.NoError(t, service.DeleteDocument(ctx, docID))
+	embeddings, err = service.db.ListChapterEmbeddings(ctx, docID, false)
+	require.NoError(t, err)
+	assert.Empty(t, embeddings)
+}
+
+func TestAskDocument(t *testing.T) {
+	service, cleanup := setupTestService(t)
+	defer cleanup()
+
+	router := service.RegisterRouter(os.Stdout)
+	ctx := context.Background()
+
+	var prompt string
+	bailianServer := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		assert.Equal(t, "/compatible-mode/v1/chat/completions", r.URL.Path)
+		var req bailian.ChatCompletionRequest
+		require.NoError(t, json.NewDecoder(r.Body).Decode(&req))
+		prompt = req.Messages[len(req.Messages)-1].Content
+		// 引用编号去重，不存在的编号忽略
+		content, err := json.Marshal(`{"answer":"老人住在山顶的寺庙[2]。","citations":[2,5,2]}`)
+		require.NoError(t, err)
+		fmt.Fprintf(w, `{"choices":[{"message":{"role":"assistant","content":%s}}]}`, content)
+	}))
+	defer bailianServer.Close()
+	var err error
+	service.bailianClient, err = bailian.NewClient(bailian.Config{BaseURL: bailianServer.URL, APIKey: "test"})
+	require.NoError(t, err)
+
+	docID := db.MakeUUID()
+	_, err = service.db.CreateDocument(ctx, docID, "file-id", &api.CreateDocumentArgs{Name: "问答文档"})
+	require.NoError(t, err)
+	require.NoError(t, service.db.CreateChapters(ctx, docID, []string{"湖边的小屋", "老人住在山顶的寺庙", "城市的夜晚"}))
+	chapters, err := service.db.ListChapters(ctx, docID)
+	require.NoError(t, err)
+
+	ask := func(docID string, args any) proto.BaseResponse {
+		data, err := json.Marshal(args)
+		require.NoError(t, err)
+		req := httptest.NewRequest(http.MethodPost, "/v1/documents/"+docID+"/ask", bytes.NewReader(data))
+		req.Header.Set("Content-Type", "application/json")
+		w := httptest.NewRecorder()
+		router.ServeHTTP(w, req)
+		var resp proto.BaseResponse
+		require.NoError(t, json.Unmarshal(w.Body.Bytes(), &resp))
+		return resp
+	}
+
+	// 未配置向量服务
+	assert.Equal(t, http.StatusBadRequest, ask(docID, api.AskDocumentArgs{Question: "老人住在哪里"}).Code)
+	service.embedder = newChapterEmbedder(service.db, &testEmbedder{keywords: []string{"湖", "山", "城"}}, nil)
+
+	resp := ask(docID, api.AskDocumentArgs{Question: "老人住在哪座山", TopK: 2})
+	require.Equal(t, http.StatusOK, resp.Code, resp.Message)
+	data, err := json.Marshal(resp.Data)
+	require.NoError(t, err)
+	var ret api.AskDocumentResult
+	require.NoError(t, json.Unmarshal(data, &ret))
+	assert.Equal(t, "老人住在山顶的寺庙[2]。", ret.Answer)
+	require.Len(t, ret.Sources, 2)
+	assert.Equal(t, chapters[1].ID, ret.Sources[0].ChapterID)
+	assert.Equal(t, "老人住在山顶的寺庙", ret.Sources[0].Content)
+	assert.Equal(t, []string{ret.Sources[1].ChapterID}, ret.Citations)
+	assert.Contains(t, prompt, "[1] \n老人住在山顶的寺庙")
+	assert.Contains(t, prompt, "问题：老人住在哪座山")
+
+	assert.Equal(t, http.StatusBadRequest, ask(docID, api.AskDocumentArgs{Question: " "}).Code)
+	assert.Equal(t, http.StatusBadRequest, ask(docID, api.AskDocumentArgs{Question: "山", TopK: 11}).Code)
+	assert.Equal(t, 612, ask(db.MakeUUID(), api.AskDocumentArgs{Question: "山"}).Code)
+
+	// 按租户限制并发，请求 respond-async 时排队异步执行
+	data, err = json.Marshal(api.AskDocumentArgs{Question: "老人住在哪座山"})
+	require.NoError(t, err)
+	req := httptest.NewRequest(http.MethodPost, "/v1/documents/"+docID+"/ask", bytes.NewReader(data))
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set("Prefer", "respond-async")
+	w := httptest.NewRecorder()
+	router.ServeHTTP(w, req)
+	require.Equal(t, http.StatusAccepted, w.Code)
+	var job api.Job
+	require.Eventually(t, func() bool {
+		job, _ = service.limiter.Get(0, strings.TrimPrefix(w.Header().Get("Location"), "/v1/operations/"))
+		return job.Status == JobStatusSucceeded
+	}, 2*time.Second, 10*time.Millisecond)
+	assert.Equal(t, "老人住在山顶的寺庙[2]。", job.Result.(*api.AskDocumentResult).Answer)
+}
+
+func TestDeleteDocumentMedia(t *testing.T) {
+	service, cleanup := setupTestService(t)
+	defer cleanup()
+	ctx := context.Background()
+	service.imageStore = mediastore.New(t.TempDir(), "/v1/images")
+	service.audioStore = mediastore.New(t.TempDir(), "/v1/audio")
+
+	ownImage, err := service.imageStore.Save([]byte("\x89PNG own"), "png")
+	require.NoError(t, err)
+	sharedImage, err := service.imageStore.Save([]byte("\x89PNG shared"), "png")
+	require.NoError(t, err)
+	voice, err := service.audioStore.Save([]byte("RIFF voice"), "wav")
+	require.NoError(t, err)
+	runImage, err := service.imageStore.Save([]byte("\x89PNG run"), "png")
+	require.NoError(t, err)
+	service.exports = newExportMgr(ExportConfig{Dir: t.TempDir()}, service.db)
+
+	createDoc := func(scene db.Scene) string {
+		docID := db.MakeUUID()
+		_, err := service.db.CreateDocument(ctx, docID, "", &api.CreateDocumentArgs{Name: "文档" + docID})
+		require.NoError(t, err)
+		require.NoError(t, service.db.CreateChapters(ctx, docID, []string{"第一章"}))
+		chapters, err := service.db.ListChapters(ctx, docID)
+		require.NoError(t, err)
+		scene.ID, scene.ChapterID, scene.DocumentID = db.MakeUUID(), chapters[0].ID, docID
+		require.NoError(t, service.db.CreateScenes(ctx, []db.Scene{scene}))
+		return docID
+	}
+	// 媒体按内容去重：runImage 是被删除文档场景的待审核图片，也是另一文档处理记录中的图片
+	docID := createDoc(db.Scene{ImageURL: ownImage, HDImageURL: sharedImage, VoiceURL: voice, HeldImageURL: runImage})
+	otherID := createDoc(db.Scene{ImageURL: sharedImage})
+	require.NoError(t, service.db.CreateDocumentRun(ctx, &db.DocumentRun{ID: db.MakeUUID(), DocumentID: otherID}, []db.RunScene{
+		{SceneID: db.MakeUUID(), ImageURL: runImage},
+	}))
+	export := db.Export{ID: db.MakeUUID(), DocumentID: docID, Status: db.ExportStatusSucceeded, Path: "artifact.zip"}
+	require.NoError(t, service.db.CreateExport(ctx, &export))
+	artifact := filepath.Join(service.exports.conf.Dir, export.Path)
+	require.NoError(t, os.WriteFile(artifact, []byte("zip"), 0644))
+
+	require.NoError(t, service.DeleteDocument(ctx, docID))
+
+	// 只删除不再被引用的文件
+	_, ok, err := service.imageStore.Read(ownImage)
+	assert.True(t, ok)
+	assert.True(t, os.IsNotExist(err))
+	_, _, err = service.audioStore.Read(voice)
+	assert.True(t, os.IsNotExist(err))
+	_, _, err = service.imageStore.Read(sharedImage)
+	require.NoError(t, err)
+	// 另一文档的处理记录仍引用的图片保留，对比和回滚不受影响
+	_, _, err = service.imageStore.Read(runImage)
+	require.NoError(t, err)
+	runs, err := service.db.ListDocumentRuns(ctx, otherID)
+	require.NoError(t, err)
+	assert.Len(t, runs, 1)
+
+	// 导出任务和产物一并删除
+	exports, err := service.db.ListExports(ctx, docID)
+	require.NoError(t, err)
+	assert.Empty(t, exports)
+	_, err = os.Stat(artifact)
+	assert.True(t, os.IsNotExist(err))
+}
+
+func TestAuditLogs(t *testing.T) {
+	service, cleanup := setupTestService(t)
+	defer cleanup()
+	router := service.RegisterRouter(os.Stdout)
+	ctx := context.Background()
+
+	docID := db.MakeUUID()
+	_, err := service.db.CreateDocument(ctx, docID, "", &api.CreateDocumentArgs{Name: "审计"})
+	require.NoError(t, err)
+	require.NoError(t, service.db.CreateChapters(ctx, docID, []string{"第一章", "第二章"}))
+	chapters, err := service.db.ListChapters(ctx, docID)
+	require.NoError(t, err)
+
+	do := func(method, path string, body any) proto.BaseResponse {
+		b, err := json.Marshal(body)
+		require.NoError(t, err)
+		req := asAdmin(httptest.NewRequest(method, path, bytes.NewReader(b)))
+		req.Header.Set("Content-Type", "application/json")
+		w := httptest.NewRecorder()
+		router.ServeHTTP(w, req)
+		var resp proto.BaseResponse
+		require.NoError(t, json.Unmarshal(w.Body.Bytes(), &resp))
+		return resp
+	}
+	list := func(query string) api.ListAuditLogsResult {
+		resp := do(http.MethodGet, "/v1/admin/audit-logs"+query, nil)
+		require.Equal(t, http.StatusOK, resp.Code)
+		data, err := json.Marshal(resp.Data)
+		require.NoError(t, err)
+		var result api.ListAuditLogsResult
+		require.NoError(t, json.Unmarshal(data, &result))
+		return result
+	}
+
+	require.Equal(t, http.StatusOK, do(http.MethodPut, "/v1/documents/"+docID, api.UpdateDocumentArgs{Name: "审计2"}).Code)
+	// 失败的请求不记录
+	require.NotEqual(t, http.StatusOK, do(http.MethodPut, "/v1/documents/"+docID, api.UpdateDocumentArgs{}).Code)
+	require.Equal(t, http.StatusOK, do(http.MethodPost, "/v1/documents/"+docID+"/chapters:reorder",
+		api.ReorderChaptersArgs{ChapterIDs: []string{chapters[1].ID, chapters[0].ID}}).Code)
+	require.Equal(t, http.StatusOK, do(http.MethodDelete, "/v1/documents/"+docID+"/chapters/"+chapters[0].ID, nil).Code)
+
+	all := list("")
+	require.Len(t, all.AuditLogs, 3)
+	del := all.AuditLogs[0]
+	assert.Equal(t, "delete", del.Action)
+	assert.Equal(t, api.AuditResourceChapter, del.ResourceType)
+	assert.Equal(t, chapters[0].ID, del.ResourceID)
+	assert.Equal(t, docID, del.DocumentID)
+	assert.Equal(t, "DELETE /v1/documents/:document_id/chapters/:id", del.Route)
+	assert.Contains(t, string(del.Before), "第一章")
+	assert.Empty(t, del.After)
+
+	reorder := all.AuditLogs[1]
+	assert.Equal(t, "reorder", reorder.Action)
+	assert.Equal(t, api.AuditResourceChapters, reorder.ResourceType)
+	var before, after []auditChapterItem
+	require.NoError(t, json.Unmarshal(reorder.Before, &before))
+	require.NoError(t, json.Unmarshal(reorder.After, &after))
+	assert.Equal(t, chapters[0].ID, before[0].ID)
+	assert.Equal(t, chapters[1].ID, after[0].ID)
+
+	update := all.AuditLogs[2]
+	assert.Equal(t, "update", update.Action)
+	assert.Contains(t, string(update.Before), `"Name":"审计"`)
+	assert.Contains(t, string(update.After), `"Name":"审计2"`)
+
+	// 过滤和分页
+	filtered := list("?resource_type=document&resource_id=" + docID)
+	require.Len(t, filtered.AuditLogs, 1)
+	assert.Equal(t, update.ID, filtered.AuditLogs[0].ID)
+	assert.Len(t, list("?document_id="+docID).AuditLogs, 3)
+	assert.Empty(t, list("?user_id=1").AuditLogs)
+	page := list("?limit=2")
+	require.Len(t, page.AuditLogs, 2)
+	assert.Len(t, list("?limit=2&marker="+page.NextMarker).AuditLogs, 1)
+	assert.Equal(t, http.StatusBadRequest, do(http.MethodGet, "/v1/admin/audit-logs?user_id=x", nil).Code)
+
+	// 管理接口的修改同样记录
+	require.Equal(t, http.StatusOK, do(http.MethodPut, "/v1/admin/prompt-templates/scene", api.UpdatePromptTemplateArgs{Content: "{{.Chapter.Content}}"}).Code)
+	require.Equal(t, http.StatusOK, do(http.MethodDelete, "/v1/admin/prompt-templates/scene", nil).Code)
+	templates := list("?resource_type=prompt_template&resource_id=scene")
+	require.Len(t, templates.AuditLogs, 2)
+	assert.Equal(t, "delete", templates.AuditLogs[0].Action)
+	assert.Contains(t, string(templates.AuditLogs[0].Before), "{{.Chapter.Content}}")
+	assert.Empty(t, templates.AuditLogs[0].After)
+	assert.Equal(t, "update", templates.AuditLogs[1].Action)
+	assert.Empty(t, templates.AuditLogs[1].Before)
+}
+
+func TestRequestID(t *testing.T) {
+	service, cleanup := setupTestService(t)
+	defer cleanup()
+	router := service.RegisterRouter(os.Stdout)
+
+	// 请求携带的 X-Request-ID 出现在响应头和错误响应体中
+	req := httptest.NewRequest(http.MethodGet, "/v1/documents/"+db.MakeUUID(), nil)
+	req.Header.Set(logger.RequestIDHeader, "support-1")
+	w := httptest.NewRecorder()
+	router.ServeHTTP(w, req)
+	var resp proto.BaseResponse
+	require.NoError(t, json.Unmarshal(w.Body.Bytes(), &resp))
+	assert.NotEqual(t, http.StatusOK, resp.Code)
+	assert.Equal(t, "support-1", resp.Reqid)
+	assert.Equal(t, "support-1", w.Header().Get(logger.RequestIDHeader))
+	assert.Equal(t, "support-1", w.Header().Get(middleware.XReqID))
+
+	// 没有携带时生成
+	req = httptest.NewRequest(http.MethodGet, "/v1/documents/"+db.MakeUUID(), nil)
+	w = httptest.NewRecorder()
+	router.ServeHTTP(w, req)
+	assert.NotEmpty(t, w.Header().Get(logger.RequestIDHeader))
+
+	// 超长时截断，包含不可打印字符时重新生成
+	req = httptest.NewRequest(http.MethodGet, "/v1/documents/"+db.MakeUUID(), nil)
+	req.Header.Set(logger.RequestIDHeader, strings.Repeat("a", 100))
+	w = httptest.NewRecorder()
+	router.ServeHTTP(w, req)
+	assert.Equal(t, strings.Repeat("a", 64), w.Header().Get(logger.RequestIDHeader))
+	req = httptest.NewRequest(http.MethodGet, "/v1/documents/"+db.MakeUUID(), nil)
+	req.Header.Set(logger.RequestIDHeader, "请求")
+	w = httptest.NewRecorder()
+	router.ServeHTTP(w, req)
+	assert.NotEqual(t, "请求", w.Header().Get(logger.RequestIDHeader))
+	assert.NotEmpty(t, w.Header().Get(logger.RequestIDHeader))
+
+	// 调用百炼时转发
+	var got string
+	bailianServer := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		got = r.Header.Get(logger.RequestIDHeader)
+		fmt.Fprint(w, `{"choices":[{"message":{"content":"摘要"}}]}`)
+	}))
+	defer bailianServer.Close()
+	bailianClient, err := bailian.NewClient(bailian.Config{BaseURL: bailianServer.URL, APIKey: "test"})
+	require.NoError(t, err)
+	_, _ = bailianClient.ExtractSummary(logger.NewContext("support-1"), "file-id")
+	assert.Equal(t, "support-1", got)
+}
+
+func TestDocumentProgress(t *testing.T) {
+	service, cleanup := setupTestService(t)
+	defer cleanup()
+	router := service.RegisterRouter(os.Stdout)
+	ctx := context.Background()
+
+	docID := db.MakeUUID()
+	_, err := service.db.CreateDocument(ctx, docID, "", &api.CreateDocumentArgs{Name: "进度", SplitStartedAt: time.Now()})
+	require.NoError(t, err)
+	require.NoError(t, service.db.CreateChapters(ctx, docID, []string{"第一章", "第二章"}))
+	chapters, err := service.db.ListChapters(ctx, docID)
+	require.NoError(t, err)
+
+	get := func() api.ProcessingProgress {
+		req := httptest.NewRequest(http.MethodGet, "/v1/documents/"+docID+"/progress", nil)
+		w := httptest.NewRecorder()
+		router.ServeHTTP(w, req)
+		var resp proto.BaseResponse
+		require.NoError(t, json.Unmarshal(w.Body.Bytes(), &resp))
+		require.Equal(t, http.StatusOK, resp.Code)
+		data, err := json.Marshal(resp.Data)
+		require.NoError(t, err)
+		var p api.ProcessingProgress
+		require.NoError(t, json.Unmarshal(data, &p))
+		return p
+	}
+	stage := func(p api.ProcessingProgress, name string) api.StageProgress {
+		for _, sp := range p.Stages {
+			if sp.Stage == name {
+				return sp
+			}
+		}
+		t.Fatalf("stage %s not found", name)
+		return api.StageProgress{}
+	}
+
+	p := get()
+	assert.Equal(t, db.StageRoles, p.Stage)
+	assert.Equal(t, 10, p.Percent)
+	require.Len(t, p.Stages, len(db.Stages))
+	assert.Equal(t, db.StageSplitting, p.Stages[0].Stage)
+	assert.Equal(t, 2, stage(p, db.StageSplitting).Done)
+	assert.NotEmpty(t, stage(p, db.StageSplitting).FinishedAt)
+	assert.Empty(t, stage(p, db.StageImages).StartedAt)
+
+	// 场景生成中：第一章切分出两个场景，一个已生成图片和语音，一个永久失败
+	require.NoError(t, service.db.UpdateDocumentStatus(ctx, docID, db.DocumentStatusRoleReady))
+	require.NoError(t, service.db.UpdateDocumentStatus(ctx, docID, db.DocumentStatusSceneReady))
+	sceneIDs := []string{db.MakeUUID(), db.MakeUUID(), db.MakeUUID()}
+	for i, id := range sceneIDs {
+		require.NoError(t, service.db.CreateScenes(ctx, []db.Scene{{ID: id, ChapterID: chapters[0].ID, DocumentID: docID, Index: i, Content: "场景"}}))
+	}
+	require.NoError(t, service.db.UpdateChapterSceneIDs(ctx, chapters[0].ID, sceneIDs))
+	require.NoError(t, service.db.UpdateSceneImageURL(ctx, sceneIDs[0], "http://img/1"))
+	require.NoError(t, service.db.UpdateSceneStatus(ctx, sceneIDs[1], db.SceneStatusFailed, 3, "image timeout"))
+
+	p = get()
+	assert.Equal(t, db.StageImages, p.Stage)
+	assert.Equal(t, 1, stage(p, db.StageScenes).Done)
+	assert.Equal(t, 2, stage(p, db.StageScenes).Total)
+	assert.NotEmpty(t, stage(p, db.StageScenes).FinishedAt)
+	assert.Equal(t, 2, stage(p, db.StageImages).Done)
+	assert.Equal(t, 3, stage(p, db.StageImages).Total)
+	assert.Equal(t, 1, stage(p, db.StageVoices).Done)
+	assert.Equal(t, 30+70*2/3, p.Percent)
+	assert.Equal(t, "image timeout", p.LastError)
+
+	require.NoError(t, service.db.UpdateDocumentStatus(ctx, docID, db.DocumentStatusCompletedWithErrors))
+	p = get()
+	assert.Empty(t, p.Stage)
+	assert.Equal(t, 100, p.Percent)
+	assert.NotEmpty(t, stage(p, db.StageVoices).FinishedAt)
+
+	req := httptest.NewRequest(http.MethodGet, "/v1/documents/"+db.MakeUUID()+"/progress", nil)
+	w := httptest.NewRecorder()
+	router.ServeHTTP(w, req)
+	var resp proto.BaseResponse
+	require.NoError(t, json.Unmarshal(w.Body.Bytes(), &resp))
+	assert.NotEqual(t, http.StatusOK, resp.Code)
+}
+
+func TestCancelDocument(t *testing.T) {
+	service, cleanup := setupTestService(t)
+	defer cleanup()
+	router := service.RegisterRouter(os.Stdout)
+	ctx := context.Background()
+
+	started := make(chan struct{})
+	var once sync.Once
+	interrupted := make(chan struct{})
+	bailianServer := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		// 读完请求体后服务端才能感知客户端断开连接
+		io.Copy(io.Discard, r.Body)
+		once.Do(func() { close(started) })
+		<-r.Context().Done()
+		close(interrupted)
+	}))
+	defer bailianServer.Close()
+	bailianClient, err := bailian.NewClient(bailian.Config{BaseURL: bailianServer.URL, APIKey: "test"})
+	require.NoError(t, err)
+	mgr, err := newDocumentMgr(DocumentConfigEx{
+		config: DocumentConfig{Enable: true, MaxSceneAttempts: 3},
+		db:     service.db,
+		quota:  service.quota,
+	}, bailianClient)
+	require.NoError(t, err)
+
+	docID := db.MakeUUID()
+	_, err = service.db.CreateDocument(ctx, docID, "file-id", &api.CreateDocumentArgs{Name: "取消"})
+	require.NoError(t, err)
+	require.NoError(t, service.db.UpdateDocumentStatus(ctx, docID, db.DocumentStatusSceneReady))
+	readyID, pendingID := db.MakeUUID(), db.MakeUUID()
+	require.NoError(t, service.db.CreateScenes(ctx, []db.Scene{
+		{ID: readyID, ChapterID: db.MakeUUID(), DocumentID: docID, Index: 0, Content: "已生成"},
+		{ID: pendingID, ChapterID: db.MakeUUID(), DocumentID: docID, Index: 1, Content: "生成中"},
+	}))
+	require.NoError(t, service.db.UpdateSceneImageURL(ctx, readyID, "http://img/ready"))
+	require.NoError(t, service.db.UpdateSceneStatus(ctx, readyID, db.SceneStatusReady, 0, ""))
+
+	cancel := func(id string) proto.BaseResponse {
+		req := httptest.NewRequest(http.MethodPost, "/v1/documents/"+id+":cancel", nil)
+		w := httptest.NewRecorder()
+		router.ServeHTTP(w, req)
+		var resp proto.BaseResponse
+		require.NoError(t, json.Unmarshal(w.Body.Bytes(), &resp))
+		return resp
+	}
+
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		mgr.HandleImageGenTasks(ctx)
+	}()
+	<-started
+
+	resp := cancel(docID)
+	require.Equal(t, http.StatusOK, resp.Code)
+	data, err := json.Marshal(resp.Data)
+	require.NoError(t, err)
+	var doc api.Document
+	require.NoError(t, json.Unmarshal(data, &doc))
+	assert.Equal(t, db.DocumentStatusCanceled, doc.Status)
+
+	// 正在处理的文档立即取消，进行中的百炼调用被中断
+	assert.True(t, mgr.Cancel(docID))
+	select {
+	case <-done:
+	case <-time.After(5 * time.Second):
+		t.Fatal("document processing not canceled")
+	}
+	<-interrupted
+	assert.False(t, mgr.Cancel(docID))
+
+	// 取消不计入失败次数，已生成的场景保留
+	got, err := service.db.GetDocument(ctx, docID)
+	require.NoError(t, err)
+	assert.Equal(t, db.DocumentStatusCanceled, got.Status)
+	assert.Zero(t, got.Attempts)
+	pending, err := service.db.GetScene(ctx, pendingID)
+	require.NoError(t, err)
+	assert.Zero(t, pending.Attempts)
+	assert.Empty(t, pending.ImageURL)
+	ready, err := service.db.GetScene(ctx, readyID)
+	require.NoError(t, err)
+	assert.Equal(t, "http://img/ready", ready.ImageURL)
+
+	// 已取消的文档不再处理，也不能重复取消
+	mgr.HandleImageGenTasks(ctx)
+	assert.Equal(t, http.StatusBadRequest, cancel(docID).Code)
+	assert.Equal(t, ErrNoSuchDocumentCode, cancel(db.MakeUUID()).Code)
+	req := httptest.NewRequest(http.MethodPost, "/v1/documents/"+docID+":stop", nil)
+	w := httptest.NewRecorder()
+	router.ServeHTTP(w, req)
+	require.NoError(t, json.Unmarshal(w.Body.Bytes(), &resp))
+	assert.Equal(t, http.StatusNotFound, resp.Code)
+
+	// 未在处理的文档直接标记为取消，审计记录操作
+	service.documentMgr = mgr
+	idleID := db.MakeUUID()
+	_, err = service.db.CreateDocument(ctx, idleID, "file-id", &api.CreateDocumentArgs{Name: "未开始"})
+	require.NoError(t, err)
+	assert.Equal(t, http.StatusOK, cancel(idleID).Code)
+	logs, err := service.db.ListAuditLogs(ctx, db.AuditLogFilter{DocumentID: idleID}, 0, 10)
+	require.NoError(t, err)
+	require.Len(t, logs, 1)
+	assert.Equal(t, "cancel", logs[0].Action)
+	assert.Equal(t, idleID, logs[0].ResourceID)
+}
+
+func TestRetryFailedDocument(t *testing.T) {
+	service, cleanup := setupTestService(t)
+	defer cleanup()
+	router := service.RegisterRouter(os.Stdout)
+	ctx := context.Background()
+
+	var contents []string
+	bailianServer := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		var req bailian.ChatCompletionRequest
+		require.NoError(t, json.NewDecoder(r.Body).Decode(&req))
+		contents = append(contents, req.Messages[len(req.Messages)-1].Content)
+		fmt.Fprint(w, `{"choices":[{"message":{"content":"[\"场景甲\",\"场景乙\"]"}}]}`)
+	}))
+	defer bailianServer.Close()
+	bailianClient, err := bailian.NewClient(bailian.Config{BaseURL: bailianServer.URL, APIKey: "test", ScenePrompt: "%s"})
+	require.NoError(t, err)
+	mgr, err := newDocumentMgr(DocumentConfigEx{
+		config: DocumentConfig{Enable: true},
+		db:     service.db,
+		quota:  service.quota,
+	}, bailianClient)
+	require.NoError(t, err)
+
+	retry := func(id string, body any) proto.BaseResponse {
+		var reader io.Reader = http.NoBody
+		if body != nil {
+			b, err := json.Marshal(body)
+			require.NoError(t, err)
+			reader = bytes.NewReader(b)
+		}
+		req := httptest.NewRequest(http.MethodPost, "/v1/documents/"+id+":retry", reader)
+		req.Header.Set("Content-Type", "application/json")
+		w := httptest.NewRecorder()
+		router.ServeHTTP(w, req)
+		var resp proto.BaseResponse
+		require.NoError(t, json.Unmarshal(w.Body.Bytes(), &resp))
+		return resp
+	}
+
+	// 场景阶段失败：只为尚未切分出场景的章节切分
+	docID := db.MakeUUID()
+	_, err = service.db.CreateDocument(ctx, docID, "file-id", &api.CreateDocumentArgs{Name: "场景失败"})
+	require.NoError(t, err)
+	require.NoError(t, service.db.CreateChapters(ctx, docID, []string{"第一章", "第二章"}))
+	chapters, err := service.db.ListChapters(ctx, docID)
+	require.NoError(t, err)
+	doneID := db.MakeUUID()
+	require.NoError(t, service.db.CreateScenes(ctx, []db.Scene{{ID: doneID, ChapterID: chapters[0].ID, DocumentID: docID, Content: "已切分"}}))
+	require.NoError(t, service.db.UpdateChapterSceneIDs(ctx, chapters[0].ID, []string{doneID}))
+	require.NoError(t, service.db.UpdateDocumentStatus(ctx, docID, db.DocumentStatusRoleReady))
+	require.NoError(t, service.db.FailDocument(ctx, docID, stageScene, 5, "timeout"))
+
+	assert.Equal(t, http.StatusBadRequest, retry(docID, api.RetryDocumentArgs{Stage: db.StageRoles}).Code)
+	assert.Equal(t, http.StatusBadRequest, retry(docID, map[string]string{"stage": "covers"}).Code)
+	resp := retry(docID, nil)
+	require.Equal(t, http.StatusOK, resp.Code)
+	doc, err := service.db.GetDocument(ctx, docID)
+	require.NoError(t, err)
+	assert.Equal(t, db.DocumentStatusRoleReady, doc.Status)
+	assert.Zero(t, doc.Attempts)
+
+	mgr.HandleDocumentScenceTasks(ctx)
+	assert.Equal(t, []string{"第二章"}, contents)
+	scenes, err := service.db.ListScenesByDocument(ctx, docID)
+	require.NoError(t, err)
+	require.Len(t, scenes, 3)
+	assert.Equal(t, doneID, scenes[0].ID)
+	assert.Equal(t, []int{0, 1, 2}, []int{scenes[0].Index, scenes[1].Index, scenes[2].Index})
+	doc, err = service.db.GetDocument(ctx, docID)
+	require.NoError(t, err)
+	assert.Equal(t, db.DocumentStatusSceneReady, doc.Status)
+
+	// 部分场景失败：只重新生成失败的场景
+	partialID := db.MakeUUID()
+	_, err = service.db.CreateDocument(ctx, partialID, "file-id", &api.CreateDocumentArgs{Name: "部分失败"})
+	require.NoError(t, err)
+	readyID, failedID := db.MakeUUID(), db.MakeUUID()
+	require.NoError(t, service.db.CreateScenes(ctx, []db.Scene{
+		{ID: readyID, ChapterID: db.MakeUUID(), DocumentID: partialID, Index: 0, Content: "成功"},
+		{ID: failedID, ChapterID: db.MakeUUID(), DocumentID: partialID, Index: 1, Content: "失败"},
+	}))
+	require.NoError(t, service.db.UpdateSceneImageURL(ctx, readyID, "http://img/ready"))
+	require.NoError(t, service.db.UpdateSceneStatus(ctx, readyID, db.SceneStatusReady, 0, ""))
+	require.NoError(t, service.db.UpdateScenePlaceholder(ctx, failedID, "http://img/placeholder", "http://voice/placeholder"))
+	require.NoError(t, service.db.UpdateSceneStatus(ctx, failedID, db.SceneStatusFailed, 3, "tts failed"))
+	require.NoError(t, service.db.UpdateDocumentStatus(ctx, partialID, db.DocumentStatusCompletedWithErrors))
+
+	assert.Equal(t, http.StatusBadRequest, retry(partialID, api.RetryDocumentArgs{Stage: db.StageScenes}).Code)
+	require.Equal(t, http.StatusOK, retry(partialID, api.RetryDocumentArgs{Stage: db.StageVoices}).Code)
+	doc, err = service.db.GetDocument(ctx, partialID)
+	require.NoError(t, err)
+	assert.Equal(t, db.DocumentStatusSceneReady, doc.Status)
+	pending, err := service.db.ListPendingImageScenes(ctx, partialID)
+	require.NoError(t, err)
+	require.Len(t, pending, 1)
+	assert.Equal(t, failedID, pending[0].ID)
+	ready, err := service.db.GetScene(ctx, readyID)
+	require.NoError(t, err)
+	assert.Equal(t, "http://img/ready", ready.ImageURL)
+
+	// 处理中或没有失败的文档不能重试
+	assert.Equal(t, http.StatusBadRequest, retry(partialID, nil).Code)
+	assert.Equal(t, ErrNoSuchDocumentCode, retry(db.MakeUUID(), nil).Code)
+}
+
+func TestUploadRules(t *testing.T) {
+	_, err := newUploadRules(UploadConfig{Extensions: []string{".exe"}})
+	assert.Error(t, err)
+
+	rules, err := newUploadRules(UploadConfig{MaxBytes: 1024, Extensions: []string{"TXT", ".pdf"}})
+	require.NoError(t, err)
+	assert.Equal(t, []string{".pdf", ".txt"}, rules.fileTypes())
+
+	ext, err := rules.Check("小说.TXT", 1024)
+	require.NoError(t, err)
+	assert.Equal(t, ".txt", ext)
+	_, err = rules.Check("小说.docx", 10)
+	assert.ErrorContains(t, err, "unsupported file type")
+	_, err = rules.Check("小说.txt", 1025)
+	assert.ErrorContains(t, err, "file exceeds maximum size of 1024 bytes")
+
+	// 内容类型与扩展名一致时返回的 Reader 包含完整内容
+	content := "<p>第一章</p>\n\n" + strings.Repeat("祥子拉车。", 200)
+	r, err := rules.Sniff(".md", strings.NewReader(content))
+	require.NoError(t, err)
+	data, err := io.ReadAll(r)
+	require.NoError(t, err)
+	assert.Equal(t, content, string(data))
+
+	_, err = rules.Sniff(".pdf", strings.NewReader(content))
+	assert.ErrorContains(t, err, "file content (text/html) does not match extension .pdf")
+	_, err = rules.Sniff(".txt", strings.NewReader("MZ\x90\x00\x03\x00\x00\x00"))
+	assert.Error(t, err)
+	_, err = rules.Sniff(".pdf", strings.NewReader("%PDF-1.7\n"))
+	assert.NoError(t, err)
+	_, err = rules.Sniff(".docx", strings.NewReader("PK\x03\x04\x14\x00"))
+	assert.NoError(t, err)
+	_, err = rules.Sniff(".doc", bytes.NewReader(append(oleSignature, 0, 0)))
+	assert.NoError(t, err)
+	_, err = rules.Sniff(".txt", strings.NewReader(""))
+	assert.NoError(t, err)
+
+	rules, err = newUploadRules(UploadConfig{DisableSniff: true})
+	require.NoError(t, err)
+	_, err = rules.Sniff(".pdf", strings.NewReader(content))
+	assert.NoError(t, err)
+	assert.Equal(t, int64(defaultMaxUploadBytes), rules.maxBytes())
+}
+
+func TestUploadLimits(t *testing.T) {
+	service, cleanup := setupTestService(t)
+	defer cleanup()
+	var err error
+	service.uploads, err = newUploadRules(UploadConfig{MaxBytes: 16})
+	require.NoError(t, err)
+	router := service.RegisterRouter(os.Stdout)
+
+	upload := func(filename, content string, chunked bool) proto.BaseResponse {
+		body := &bytes.Buffer{}
+		writer := multipart.NewWriter(body)
+		require.NoError(t, writer.WriteField("name", "小说"))
+		part, err := writer.CreateFormFile("file", filename)
+		require.NoError(t, err)
+		part.Write([]byte(content))
+		require.NoError(t, writer.Close())
+		req := httptest.NewRequest(http.MethodPost, "/v1/documents", body)
+		req.Header.Set("Content-Type", writer.FormDataContentType())
+		if chunked {
+			req.ContentLength = -1
+		}
+		w := httptest.NewRecorder()
+		router.ServeHTTP(w, req)
+		var resp proto.BaseResponse
+		require.NoError(t, json.Unmarshal(w.Body.Bytes(), &resp))
+		return resp
+	}
+
+	resp := upload("a.txt", strings.Repeat("x", 17), false)
+	assert.Equal(t, http.StatusBadRequest, resp.Code)
+	assert.Equal(t, "file exceeds maximum size of 16 bytes", resp.Message)
+
+	// 超出请求体上限时不再读取剩余内容
+	huge := strings.Repeat("x", maxUploadFormOverhead+32)
+	resp = upload("a.txt", huge, false)
+	assert.Equal(t, "file exceeds maximum size of 16 bytes", resp.Message)
+	resp = upload("a.txt", huge, true)
+	assert.Equal(t, "file exceeds maximum size of 16 bytes", resp.Message)
+
+	resp = upload("a.pdf", "第一章", false)
+	assert.Equal(t, http.StatusBadRequest, resp.Code)
+	assert.Equal(t, "file content (text/plain) does not match extension .pdf", resp.Message)
+
+	req := httptest.NewRequest(http.MethodGet, "/v1/limits", nil)
+	w := httptest.NewRecorder()
+	router.ServeHTTP(w, req)
+	assert.Contains(t, w.Body.String(), `"max_file_bytes":16`)
+}
+
+// testScanner 内容包含 EICAR 的文件不通过，err 不为空时模拟扫描服务不可用
+type testScanner struct {
+	scanned int
+	err     error
+}
+
+func (s *testScanner) Scan(ctx context.Context, r io.Reader) (scanner.Result, error) {
+	s.scanned++
+	if s.err != nil {
+		return scanner.Result{}, s.err
+	}
+	data, err := io.ReadAll(r)
+	if err != nil {
+		return scanner.Result{}, err
+	}
+	if bytes.Contains(data, []byte("EICAR")) {
+		return scanner.Result{Infected: true, Reason: "Eicar-Test-Signature"}, nil
+	}
+	return scanner.Result{}, nil
+}
+
+func TestScanUpload(t *testing.T) {
+	service, cleanup := setupTestService(t)
+	defer cleanup()
+
+	var uploads int
+	bailianServer := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		uploads++
+		w.Write([]byte(`{"id":"file-scan"}`))
+	}))
+	defer bailianServer.Close()
+	var err error
+	service.bailianClient, err = bailian.NewClient(bailian.Config{BaseURL: bailianServer.URL, APIKey: "test"})
+	require.NoError(t, err)
+	fake := &testScanner{}
+	service.scanner = fake
+	ctx := context.Background()
+
+	// 未通过扫描的文件不拆分章节、不上传到百炼
+	content := "第一章\n\nEICAR"
+	_, err = service.CreateDocument(ctx, 0, "病毒", "", false, false, "a.txt", int64(len(content)), strings.NewReader(content))
+	var ae *proto.ApiError
+	require.ErrorAs(t, err, &ae)
+	assert.Equal(t, http.StatusUnprocessableEntity, ae.Code)
+	assert.Equal(t, "file rejected by scanner: Eicar-Test-Signature", ae.Message)
+	assert.Zero(t, uploads)
+	_, err = service.db.GetDocumentWithName(ctx, "病毒")
+	assert.ErrorIs(t, err, gorm.ErrRecordNotFound)
+	entries, err := os.ReadDir(service.conf.Temp)
+	require.NoError(t, err)
+	assert.Empty(t, entries)
+
+	// 扫描服务不可用时拒绝上传
+	fake.err = errors.New("connection refused")
+	_, err = service.CreateDocument(ctx, 0, "小说", "", false, false, "a.txt", 9, strings.NewReader("第一章"))
+	require.ErrorAs(t, err, &ae)
+	assert.Equal(t, hutil.ErrServerInternalCode, ae.Code)
+
+	fake.err = nil
+	doc, err := service.CreateDocument(ctx, 0, "小说", "", false, false, "a.txt", 9, strings.NewReader("第一章"))
+	require.NoError(t, err)
+	assert.Equal(t, "小说", doc.Name)
+	assert.Equal(t, 3, fake.scanned)
+	assert.Equal(t, 1, uploads)
+}
+
+func TestRuntimeConfig(t *testing.T) {
+	service, cleanup := setupTestService(t)
+	defer cleanup()
+	defer logger.SetLevel("debug")
+	ctx := context.Background()
+
+	var model string
+	bailianServer := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		var req bailian.ChatCompletionRequest
+		require.NoError(t, json.NewDecoder(r.Body).Decode(&req))
+		model = req.Model
+		fmt.Fprint(w, `{"choices":[{"message":{"content":"[\"场景\"]"}}]}`)
+	}))
+	defer bailianServer.Close()
+	var err error
+	service.bailianClient, err = bailian.NewClient(bailian.Config{BaseURL: bailianServer.URL, APIKey: "test"})
+	require.NoError(t, err)
+	service.documentMgr, err = newDocumentMgr(DocumentConfigEx{
+		config: DocumentConfig{Enable: true, Workers: 2},
+		db:     service.db,
+		quota:  service.quota,
+	}, service.bailianClient)
+	require.NoError(t, err)
+	router := service.RegisterRouter(os.Stdout)
+
+	get := func() api.RuntimeConfig {
+		req := asAdmin(httptest.NewRequest(http.MethodGet, "/v1/admin/config", nil))
+		w := httptest.NewRecorder()
+		router.ServeHTTP(w, req)
+		var resp proto.BaseResponse
+		require.NoError(t, json.Unmarshal(w.Body.Bytes(), &resp))
+		require.Equal(t, http.StatusOK, resp.Code)
+		data, err := json.Marshal(resp.Data)
+		require.NoError(t, err)
+		var conf api.RuntimeConfig
+		require.NoError(t, json.Unmarshal(data, &conf))
+		return conf
+	}
+
+	conf := get()
+	assert.Equal(t, "debug", conf.LogLevel)
+	assert.Equal(t, api.RateLimits{MaxConcurrent: 2, MaxQueued: 10}, conf.RateLimit)
+	assert.Equal(t, &api.DocumentConcurrency{Workers: 2, MaxConcurrentImageJobs: 2, MaxConcurrentTTSJobs: 2}, conf.DocumentMgr)
+	assert.Equal(t, &api.BailianModels{ChatModel: "qwen-long", ImageModel: "qwen-image-plus", TTSModel: "qwen3-tts-flash"}, conf.Bailian)
+	assert.Nil(t, conf.ReloadedAt)
+
+	_, err = service.bailianClient.GenerateScenes(ctx, "第一章", "%s")
+	require.NoError(t, err)
+	assert.Equal(t, "qwen-long", model)
+	oldSlots := service.limiter.tenantSlots(0)
+
+	// 不合法的日志级别不修改任何配置
+	err = service.UpdateRuntimeConfig(ctx, api.RuntimeConfig{LogLevel: "verbose", RateLimit: api.RateLimits{MaxConcurrent: 5}})
+	require.Error(t, err)
+	assert.Equal(t, 2, get().RateLimit.MaxConcurrent)
+
+	// 保持 debug 级别时故障注入继续生效，调高级别后关闭
+	defer faults.Init(faults.Config{})
+	faults.Init(faults.Config{Enable: true, Rules: map[string]faults.Rule{faults.TargetDB: {ErrorRate: 1}}})
+	require.NoError(t, service.UpdateRuntimeConfig(ctx, api.RuntimeConfig{LogLevel: "debug"}))
+	assert.ErrorIs(t, faults.Inject(ctx, faults.TargetDB), faults.ErrInjected)
+
+	require.NoError(t, service.UpdateRuntimeConfig(ctx, api.RuntimeConfig{
+		LogLevel:    "warn",
+		RateLimit:   api.RateLimits{MaxConcurrent: 5, MaxQueued: 20},
+		DocumentMgr: &api.DocumentConcurrency{Workers: 4, MaxConcurrentImageJobs: 3},
+		Bailian:     &api.BailianModels{ChatModel: "qwen-plus"},
+	}))
+	conf = get()
+	assert.Equal(t, "warn", conf.LogLevel)
+	assert.Equal(t, api.RateLimits{MaxConcurrent: 5, MaxQueued: 20}, conf.RateLimit)
+	assert.Equal(t, &api.DocumentConcurrency{Workers: 4, MaxConcurrentImageJobs: 3, MaxConcurrentTTSJobs: 4}, conf.DocumentMgr)
+	assert.Equal(t, &api.BailianModels{ChatModel: "qwen-plus", ImageModel: "qwen-image-plus", TTSModel: "qwen3-tts-flash"}, conf.Bailian)
+	assert.NotNil(t, conf.ReloadedAt)
+	assert.False(t, faults.Enabled())
+	assert.NoError(t, faults.Inject(ctx, faults.TargetDB))
+
+	// 新请求使用新的并发名额，之后的百炼调用使用新模型
+	assert.Equal(t, 5, cap(service.limiter.tenantSlots(0)))
+	assert.NotEqual(t, oldSlots, service.limiter.tenantSlots(0))
+	assert.Equal(t, 3, cap(service.documentMgr.slots(true)))
+	assert.Equal(t, 4, cap(service.documentMgr.slots(false)))
+	_, err = service.bailianClient.GenerateScenes(ctx, "第一章", "%s")
+	require.NoError(t, err)
+	assert.Equal(t, "qwen-plus", model)
+
+	// 配置中删除的值恢复默认值
+	require.NoError(t, service.UpdateRuntimeConfig(ctx, api.RuntimeConfig{}))
+	conf = get()
+	assert.Equal(t, "info", conf.LogLevel)
+	assert.Equal(t, api.RateLimits{MaxConcurrent: 2, MaxQueued: 10}, conf.RateLimit)
+	assert.Equal(t, 4, conf.DocumentMgr.Workers)
+}
+
+// memObjectStore 内存中的对象存储，用于测试备份
+type memObjectStore struct {
+	objects map[string][]byte
+}
+
+func (m *memObjectStore) PutObject(ctx context.Context, key string, r io.Reader) error {
+	data, err := io.ReadAll(r)
+	if err != nil {
+		return err
+	}
+	m.objects[key] = data
+	return nil
+}
+
+func (m *memObjectStore) GetObject(ctx context.Context, key string) (io.ReadCloser, error) {
+	data, ok := m.objects[key]
+	if !ok {
+		return nil, storage.ErrObjectNotFound
+	}
+	return io.NopCloser(bytes.NewReader(data)), nil
+}
+
+func (m *memObjectStore) MakeURL(key string) string {
+	return "https://cdn.example.com/" + key
+}
+
+func TestBGM(t *testing.T) {
+	service, cleanup := setupTestService(t)
+	defer cleanup()
+	ctx := context.Background()
+	router := service.RegisterRouter(os.Stdout)
+
+	serve := func(req *http.Request) proto.BaseResponse {
+		w := httptest.NewRecorder()
+		router.ServeHTTP(w, req)
+		var resp proto.BaseResponse
+		require.NoError(t, json.Unmarshal(w.Body.Bytes(), &resp))
+		return resp
+	}
+	upload := func(name, volume, filename string) proto.BaseResponse {
+		body := &bytes.Buffer{}
+		writer := multipart.NewWriter(body)
+		require.NoError(t, writer.WriteField("name", name))
+		if volume != "" {
+			require.NoError(t, writer.WriteField("volume", volume))
+		}
+		part, err := writer.CreateFormFile("file", filename)
+		require.NoError(t, err)
+		_, err = part.Write([]byte("audio data"))
+		require.NoError(t, err)
+		require.NoError(t, writer.Close())
+		req := asAdmin(httptest.NewRequest(http.MethodPost, "/v1/admin/bgm", body))
+		req.Header.Set("Content-Type", writer.FormDataContentType())
+		return serve(req)
+	}
+	decode := func(resp proto.BaseResponse, v any) {
+		require.Equal(t, http.StatusOK, resp.Code, resp.Message)
+		data, err := json.Marshal(resp.Data)
+		require.NoError(t, err)
+		require.NoError(t, json.Unmarshal(data, v))
+	}
+	updateDoc := func(docID string, bgmID string) proto.BaseResponse {
+		data, err := json.Marshal(api.UpdateDocumentArgs{Name: "配乐文档", BGMID: &bgmID})
+		require.NoError(t, err)
+		req := httptest.NewRequest(http.MethodPut, "/v1/documents/"+docID, bytes.NewReader(data))
+		req.Header.Set("Content-Type", "application/json")
+		return serve(req)
+	}
+	manifest := func(docID string) api.Manifest {
+		var ret api.Manifest
+		decode(serve(httptest.NewRequest(http.MethodGet, "/v1/documents/"+docID+"/manifest", nil)), &ret)
+		return ret
+	}
+
+	// 未配置对象存储
+	assert.Equal(t, http.StatusServiceUnavailable, upload("雨夜", "", "rain.mp3").Code)
+
+	store := &memObjectStore{objects: map[string][]byte{}}
+	service.bgm = store
+	assert.Equal(t, http.StatusBadRequest, upload("雨夜", "", "rain.txt").Code)
+	assert.Equal(t, http.StatusBadRequest, upload("雨夜", "1.5", "rain.mp3").Code)
+	assert.Equal(t, http.StatusBadRequest, upload(" ", "", "rain.mp3").Code)
+
+	var track api.BGMTrack
+	decode(upload("雨夜", "", "rain.MP3"), &track)
+	assert.Equal(t, "雨夜", track.Name)
+	assert.Equal(t, defaultBGMVolume, track.Volume)
+	assert.Equal(t, "https://cdn.example.com/bgm/"+track.ID+".mp3", track.URL)
+	assert.Equal(t, []byte("audio data"), store.objects["bgm/"+track.ID+".mp3"])
+	var quiet api.BGMTrack
+	decode(upload("轻音乐", "0.1", "quiet.wav"), &quiet)
+
+	var list api.ListBGMTracksResult
+	decode(serve(httptest.NewRequest(http.MethodGet, "/v1/bgm", nil)), &list)
+	assert.Len(t, list.Tracks, 2)
+
+	// 文档选用曲目后播放清单包含背景音乐
+	docID := db.MakeUUID()
+	_, err := service.db.CreateDocument(ctx, docID, "file-id", &api.CreateDocumentArgs{Name: "配乐文档"})
+	require.NoError(t, err)
+	assert.Nil(t, manifest(docID).BGM)
+	assert.Equal(t, http.StatusBadRequest, updateDoc(docID, db.MakeUUID()).Code)
+	var doc api.Document
+	decode(updateDoc(docID, track.ID), &doc)
+	assert.Equal(t, track.ID, doc.BGMID)
+	assert.Equal(t, &api.ManifestBGM{ID: track.ID, Name: "雨夜", URL: track.URL, Volume: defaultBGMVolume}, manifest(docID).BGM)
+
+	// 曲目删除后不再使用，空字符串取消选用
+	assert.Equal(t, http.StatusOK, serve(asAdmin(httptest.NewRequest(http.MethodDelete, "/v1/admin/bgm/"+track.ID, nil))).Code)
+	assert.Equal(t, http.StatusNotFound, serve(asAdmin(httptest.NewRequest(http.MethodDelete, "/v1/admin/bgm/"+track.ID, nil))).Code)
+	assert.Nil(t, manifest(docID).BGM)
+	decode(updateDoc(docID, ""), &doc)
+	assert.Empty(t, doc.BGMID)
+}
+
+func TestBackupRestore(t *testing.T) {
+	service, cleanup := setupTestService(t)
+	defer cleanup()
+	ctx := context.Background()
+	router := service.RegisterRouter(os.Stdout)
+
+	do := func(path string, body any) proto.BaseResponse {
+		data, err := json.Marshal(body)
+		require.NoError(t, err)
+		req := asAdmin(httptest.NewRequest(http.MethodPost, path, bytes.NewReader(data)))
+		req.Header.Set("Content-Type", "application/json")
+		w := httptest.NewRecorder()
+		router.ServeHTTP(w, req)
+		var resp proto.BaseResponse
+		require.NoError(t, json.Unmarshal(w.Body.Bytes(), &resp))
+		return resp
+	}
+	decode := func(resp proto.BaseResponse, v any) {
+		require.Equal(t, http.StatusOK, resp.Code, resp.Message)
+		data, err := json.Marshal(resp.Data)
+		require.NoError(t, err)
+		require.NoError(t, json.Unmarshal(data, v))
+	}
+
+	// 未配置对象存储
+	assert.Equal(t, http.StatusServiceUnavailable, do("/v1/admin/backup", api.CreateBackupArgs{}).Code)
+
+	store := &memObjectStore{objects: map[string][]byte{}}
+	service.backups = store
+	_, err := service.db.CreateDocument(ctx, "doc1", "file1", &api.CreateDocumentArgs{Name: "备份文档", UserID: 1})
+	require.NoError(t, err)
+
+	var backup api.Backup
+	decode(do("/v1/admin/backup", api.CreateBackupArgs{IncludeMedia: true}), &backup)
+	assert.Regexp(t, backupIDPattern, backup.ID)
+	assert.Equal(t, "backups/"+backup.ID+"/db.jsonl.gz", backup.Key)
+	assert.Equal(t, int64(1), backup.Tables["documents"])
+	assert.Contains(t, store.objects, backup.Key)
+	assert.Contains(t, store.objects, "backups/"+backup.ID+"/media.json")
+
+	_, err = service.db.CreateDocument(ctx, "doc2", "file2", &api.CreateDocumentArgs{Name: "新文档", UserID: 1})
+	require.NoError(t, err)
+
+	// dry run 只校验
+	var ret api.RestoreResult
+	decode(do("/v1/admin/restore", api.RestoreBackupArgs{ID: backup.ID, DryRun: true}), &ret)
+	assert.True(t, ret.DryRun)
+	assert.Equal(t, int64(1), ret.Tables["documents"])
+	assert.Empty(t, ret.MissingMedia)
+	_, err = service.db.GetDocument(ctx, "doc2")
+	require.NoError(t, err)
+
+	// 恢复需要确认 id，且需要在配置中开启
+	assert.Equal(t, http.StatusBadRequest, do("/v1/admin/restore", api.RestoreBackupArgs{ID: backup.ID}).Code)
+	assert.Equal(t, http.StatusForbidden, do("/v1/admin/restore", api.RestoreBackupArgs{ID: backup.ID, Confirm: backup.ID}).Code)
+	_, err = service.db.GetDocument(ctx, "doc2")
+	require.NoError(t, err)
+
+	service.conf.Backup.AllowRestore = true
+	decode(do("/v1/admin/restore", api.RestoreBackupArgs{ID: backup.ID, Confirm: backup.ID}), &ret)
+	assert.False(t, ret.DryRun)
+	_, err = service.db.GetDocument(ctx, "doc2")
+	assert.ErrorIs(t, err, gorm.ErrRecordNotFound)
+	doc, err := service.db.GetDocument(ctx, "doc1")
+	require.NoError(t, err)
+	assert.Equal(t, "备份文档", doc.Name)
+	logs, err := service.db.ListAuditLogs(ctx, db.AuditLogFilter{ResourceType: api.AuditResourceBackup, ResourceID: backup.ID}, 0, 1)
+	require.NoError(t, err)
+	require.Len(t, logs, 1)
+	assert.Equal(t, "restore", logs[0].Action)
+
+	assert.Equal(t, http.StatusBadRequest, do("/v1/admin/restore", api.RestoreBackupArgs{ID: "../secret", Confirm: "../secret"}).Code)
+	assert.Equal(t, http.StatusNotFound, do("/v1/admin/restore", api.RestoreBackupArgs{ID: "20260101T000000-00000000", DryRun: true}).Code)
+
+	// 损坏的快照
+	store.objects["backups/20260101T000000-00000001/db.jsonl.gz"] = []byte("not gzip")
+	assert.Equal(t, http.StatusBadRequest, do("/v1/admin/restore", api.RestoreBackupArgs{ID: "20260101T000000-00000001", DryRun: true}).Code)
+}
+
+func TestTranslateDocument(t *testing.T) {
+	service, cleanup := setupTestService(t)
+	defer cleanup()
+	ctx := context.Background()
+
+	var calls atomic.Int32
+	var fail atomic.Bool
+	bailianServer := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		var req bailian.ChatCompletionRequest
+		require.NoError(t, json.NewDecoder(r.Body).Decode(&req))
+		assert.Contains(t, req.Messages[len(req.Messages)-1].Content, "英语")
+		if fail.Load() {
+			w.WriteHeader(http.StatusInternalServerError)
+			return
+		}
+		n := calls.Add(1)
+		content, err := json.Marshal(fmt.Sprintf(`{"title":"Chapter %d","content":"translated %d"}`, n, n))
+		require.NoError(t, err)
+		fmt.Fprintf(w, `{"choices":[{"message":{"role":"assistant","content":%s}}]}`, content)
+	}))
+	defer bailianServer.Close()
+	var err error
+	service.bailianClient, err = bailian.NewClient(bailian.Config{BaseURL: bailianServer.URL, APIKey: "test"})
+	require.NoError(t, err)
+	service.documentMgr, err = newDocumentMgr(DocumentConfigEx{config: DocumentConfig{Enable: true}, db: service.db, quota: service.quota}, service.bailianClient)
+	require.NoError(t, err)
+	router := service.RegisterRouter(os.Stdout)
+
+	docID := db.MakeUUID()
+	_, err = service.db.CreateDocument(ctx, docID, "file-id", &api.CreateDocumentArgs{Name: "翻译文档"})
+	require.NoError(t, err)
+	require.NoError(t, service.db.CreateChapters(ctx, docID, []string{"湖边的小屋", "山顶的寺庙"}))
+
+	do := func(method, path string, body any) *httptest.ResponseRecorder {
+		var reader io.Reader
+		if body != nil {
+			data, err := json.Marshal(body)
+			require.NoError(t, err)
+			reader = bytes.NewReader(data)
+		}
+		req := httptest.NewRequest(method, path, reader)
+		req.Header.Set("Content-Type", "application/json")
+		w := httptest.NewRecorder()
+		router.ServeHTTP(w, req)
+		return w
+	}
+	decode := func(w *httptest.ResponseRecorder, v any) {
+		var resp proto.BaseResponse
+		require.NoError(t, json.Unmarshal(w.Body.Bytes(), &resp))
+		require.Contains(t, []int{http.StatusOK, http.StatusAccepted}, resp.Code, resp.Message)
+		data, err := json.Marshal(resp.Data)
+		require.NoError(t, err)
+		require.NoError(t, json.Unmarshal(data, v))
+	}
+	code := func(w *httptest.ResponseRecorder) int {
+		var resp proto.BaseResponse
+		require.NoError(t, json.Unmarshal(w.Body.Bytes(), &resp))
+		return resp.Code
+	}
+
+	assert.Equal(t, http.StatusBadRequest, code(do(http.MethodPost, "/v1/documents/"+docID+":translate", api.TranslateDocumentArgs{Lang: "xx"})))
+	assert.Equal(t, ErrNoSuchDocumentCode, code(do(http.MethodPost, "/v1/documents/"+db.MakeUUID()+":translate", api.TranslateDocumentArgs{Lang: "en"})))
+
+	var translation api.Translation
+	w := do(http.MethodPost, "/v1/documents/"+docID+":translate", api.TranslateDocumentArgs{Lang: "en"})
+	assert.Equal(t, http.StatusAccepted, w.Code)
+	decode(w, &translation)
+	assert.Equal(t, db.TranslationStatusPending, translation.Status)
+
+	// 翻译前返回原文
+	var list api.ListChaptersResult
+	decode(do(http.MethodGet, "/v1/documents/"+docID+"/chapters?lang=en", nil), &list)
+	require.Len(t, list.Chapters, 2)
+	assert.Empty(t, list.Chapters[0].Lang)
+	assert.Equal(t, http.StatusBadRequest, code(do(http.MethodGet, "/v1/documents/"+docID+"/chapters?lang=xx", nil)))
+
+	service.documentMgr.HandleTranslationTasks(ctx)
+	var doc api.Document
+	decode(do(http.MethodGet, "/v1/documents/"+docID+"?lang=en", nil), &doc)
+	require.NotNil(t, doc.Translation)
+	assert.Equal(t, db.TranslationStatusSucceeded, doc.Translation.Status)
+	assert.Equal(t, 2, doc.Translation.Translated)
+	assert.Equal(t, 2, doc.Translation.Total)
+	var original api.Document
+	decode(do(http.MethodGet, "/v1/documents/"+docID, nil), &original)
+	assert.Nil(t, original.Translation)
+
+	decode(do(http.MethodGet, "/v1/documents/"+docID+"/chapters?lang=en", nil), &list)
+	origTitle := list.Chapters[0].Title
+	for _, ch := range list.Chapters {
+		assert.Equal(t, "en", ch.Lang)
+		assert.Contains(t, ch.Content, "translated")
+	}
+	var chapter api.Chapter
+	decode(do(http.MethodGet, "/v1/documents/"+docID+"/chapters/"+list.Chapters[1].ID+"?lang=en", nil), &chapter)
+	assert.Equal(t, "en", chapter.Lang)
+	assert.Equal(t, list.Chapters[1].Content, chapter.Content)
+	var originalChapter api.Chapter
+	decode(do(http.MethodGet, "/v1/documents/"+docID+"/chapters/"+list.Chapters[1].ID, nil), &originalChapter)
+	assert.Empty(t, originalChapter.Lang)
+	assert.Equal(t, "山顶的寺庙", originalChapter.Content)
+
+	// 原文修改后返回原文，重新翻译只翻译修改过的章节
+	content := "山顶的寺庙和钟声"
+	require.NoError(t, service.db.UpdateChapter(ctx, list.Chapters[1].ID, &api.UpdateChapterArgs{Content: content}))
+	list = api.ListChaptersResult{}
+	decode(do(http.MethodGet, "/v1/documents/"+docID+"/chapters?lang=en", nil), &list)
+	assert.Equal(t, "en", list.Chapters[0].Lang)
+	assert.Empty(t, list.Chapters[1].Lang)
+	assert.Equal(t, content, list.Chapters[1].Content)
+
+	decode(do(http.MethodPost, "/v1/documents/"+docID+":translate", api.TranslateDocumentArgs{Lang: "en"}), &translation)
+	assert.Equal(t, db.TranslationStatusPending, translation.Status)
+	service.documentMgr.HandleTranslationTasks(ctx)
+	assert.Equal(t, int32(3), calls.Load())
+	decode(do(http.MethodGet, "/v1/documents/"+docID+"/chapters?lang=en", nil), &list)
+	assert.Equal(t, origTitle, list.Chapters[0].Title)
+	assert.Equal(t, "translated 3", list.Chapters[1].Content)
+
+	// 翻译失败记录原因
+	fail.Store(true)
+	require.NoError(t, service.db.UpdateChapter(ctx, list.Chapters[0].ID, &api.UpdateChapterArgs{Content: content}))
+	decode(do(http.MethodPost, "/v1/documents/"+docID+":translate", api.TranslateDocumentArgs{Lang: "en"}), &translation)
+	service.documentMgr.HandleTranslationTasks(ctx)
+	decode(do(http.MethodGet, "/v1/documents/"+docID+"?lang=en", nil), &doc)
+	assert.Equal(t, db.TranslationStatusFailed, doc.Translation.Status)
+	assert.NotEmpty(t, doc.Translation.Error)
+
+	// 删除文档同时删除译文
+	_, err = service.db.DeleteDocument(ctx, docID)
+	require.NoError(t, err)
+	translations, err := service.db.ListChapterTranslations(ctx, docID, "en")
+	require.NoError(t, err)
+	assert.Empty(t, translations)
+}
+
+func TestUpdateRoleRegenerateScenes(t *testing.T) {
+	service, cleanup := setupTestService(t)
+	defer cleanup()
+	ctx := context.Background()
+	router := service.RegisterRouter(os.Stdout)
+
+	docID := db.MakeUUID()
+	_, err := service.db.CreateDocument(ctx, docID, "file-id", &api.CreateDocumentArgs{Name: "角色重绘"})
+	require.NoError(t, err)
+	require.NoError(t, service.db.CreateChapters(ctx, docID, []string{"第一章"}))
+	chapters, err := service.db.ListChapters(ctx, docID)
+	require.NoError(t, err)
+	require.NoError(t, service.db.CreateRoles(ctx, []db.Role{{ID: "r1", DocumentID: docID, Name: "李雷", Gender: "男", Appearance: "短发"}}))
+	img := "https://example.com/1.png"
+	require.NoError(t, service.db.CreateScenes(ctx, []db.Scene{
+		{ID: "s1", ChapterID: chapters[0].ID, DocumentID: docID, Content: "李雷走进教室", ImageURL: img, Status: db.SceneStatusReady},
+		{ID: "s2", ChapterID: chapters[0].ID, DocumentID: docID, Index: 1, Content: "他坐在窗边", ImageURL: img, Status: db.SceneStatusReady, CharactersPresent: []string{"李雷"}},
+		{ID: "s3", ChapterID: chapters[0].ID, DocumentID: docID, Index: 2, Content: "韩梅梅在看书", ImageURL: img, Status: db.SceneStatusReady},
+		{ID: "s4", ChapterID: chapters[0].ID, DocumentID: docID, Index: 3, Content: "李雷回家"},
+	}))
+	require.NoError(t, service.db.UpdateDocumentStatus(ctx, docID, db.DocumentStatusImgReady))
+
+	update := func(args api.UpdateRoleArgs) api.Role {
+		data, err := json.Marshal(args)
+		require.NoError(t, err)
+		req := httptest.NewRequest(http.MethodPut, "/v1/roles/r1", bytes.NewReader(data))
+		req.Header.Set("Content-Type", "application/json")
+		w := httptest.NewRecorder()
+		router.ServeHTTP(w, req)
+		var resp proto.BaseResponse
+		require.NoError(t, json.Unmarshal(w.Body.Bytes(), &resp))
+		require.Equal(t, http.StatusOK, resp.Code, resp.Message)
+		data, err = json.Marshal(resp.Data)
+		require.NoError(t, err)
+		var role api.Role
+		require.NoError(t, json.Unmarshal(data, &role))
+		return role
+	}
+	stale := func() []string {
+		scenes, err := service.db.ListScenesByDocument(ctx, docID)
+		require.NoError(t, err)
+		var ids []string
+		for _, s := range scenes {
+			if s.MediaStale {
+				ids = append(ids, s.ID)
+			}
+		}
+		return ids
+	}
+	status := func() string {
+		doc, err := service.db.GetDocument(ctx, docID)
+		require.NoError(t, err)
+		return doc.Status
+	}
+
+	// 未指定 regenerate_scenes 或外貌未变化时不重新生成
+	assert.Zero(t, update(api.UpdateRoleArgs{Name: "李雷", Gender: "男", Character: "开朗", Appearance: "长发"}).RegeneratingScenes)
+	assert.Zero(t, update(api.UpdateRoleArgs{Name: "李雷", Gender: "男", Character: "沉稳", Appearance: "长发", RegenerateScenes: true}).RegeneratingScenes)
+	assert.Empty(t, stale())
+	assert.Equal(t, db.DocumentStatusImgReady, status())
+
+	// 改名并重新设计外貌，按旧名字和出场人物找到场景，尚未生成媒体的场景随文档生成
+	role := update(api.UpdateRoleArgs{Name: "李磊", Gender: "男", Character: "沉稳", Appearance: "寸头，穿校服", RegenerateScenes: true})
+	assert.Equal(t, 2, role.RegeneratingScenes)
+	assert.Equal(t, []string{"s1", "s2"}, stale())
+	assert.Equal(t, db.DocumentStatusSceneReady, status())
+	pending, err := service.db.ListPendingImageScenes(ctx, docID)
+	require.NoError(t, err)
+	assert.Len(t, pending, 3)
+}
+
+func TestEditScene(t *testing.T) {
+	service, cleanup := setupTestService(t)
+	defer cleanup()
+	ctx := context.Background()
+	router := service.RegisterRouter(os.Stdout)
+
+	docID := db.MakeUUID()
+	_, err := service.db.CreateDocument(ctx, docID, "file-id", &api.CreateDocumentArgs{Name: "编辑场景"})
+	require.NoError(t, err)
+	require.NoError(t, service.db.CreateChapters(ctx, docID, []string{"第一章"}))
+	chapters, err := service.db.ListChapters(ctx, docID)
+	require.NoError(t, err)
+	require.NoError(t, service.db.CreateScenes(ctx, []db.Scene{
+		{ID: "s1", ChapterID: chapters[0].ID, DocumentID: docID, Content: "湖边", ImageURL: "https://example.com/1.png", VoiceURL: "https://example.com/1.mp3", Status: db.SceneStatusReady},
+		{ID: "s2", ChapterID: chapters[0].ID, DocumentID: docID, Index: 1, Content: "山顶"},
+	}))
+	require.NoError(t, service.db.UpdateDocumentStatus(ctx, docID, db.DocumentStatusImgReady))
+
+	do := func(method, path string, body any) proto.BaseResponse {
+		data, err := json.Marshal(body)
+		require.NoError(t, err)
+		req := httptest.NewRequest(method, path, bytes.NewReader(data))
+		req.Header.Set("Content-Type", "application/json")
+		w := httptest.NewRecorder()
+		router.ServeHTTP(w, req)
+		var resp proto.BaseResponse
+		require.NoError(t, json.Unmarshal(w.Body.Bytes(), &resp))
+		return resp
+	}
+	edit := func(id, content string) api.Scene {
+		resp := do(http.MethodPatch, "/v1/scenes/"+id, api.UpdateSceneArgs{Content: content})
+		require.Equal(t, http.StatusOK, resp.Code, resp.Message)
+		data, err := json.Marshal(resp.Data)
+		require.NoError(t, err)
+		var scene api.Scene
+		require.NoError(t, json.Unmarshal(data, &scene))
+		return scene
+	}
+	status := func() string {
+		doc, err := service.db.GetDocument(ctx, docID)
+		require.NoError(t, err)
+		return doc.Status
+	}
+
+	// 内容未变化或场景尚无媒体时不过期
+	assert.False(t, edit("s1", "湖边").MediaStale)
+	assert.False(t, edit("s2", "山顶的寺庙").MediaStale)
+
+	// 内容变化后媒体过期，未开启 auto_regenerate 时文档状态不变
+	scene := edit("s1", "湖边的小屋")
+	assert.True(t, scene.MediaStale)
+	assert.Equal(t, "https://example.com/1.png", scene.ImageURL)
+	assert.Equal(t, db.DocumentStatusImgReady, status())
+	pending, err := service.db.ListPendingImageScenes(ctx, docID)
+	require.NoError(t, err)
+	assert.Len(t, pending, 2)
+
+	autoRegenerate := true
+	resp := do(http.MethodPut, "/v1/documents/"+docID, api.UpdateDocumentArgs{Name: "编辑场景", AutoRegenerate: &autoRegenerate})
+	require.Equal(t, http.StatusOK, resp.Code, resp.Message)
+	doc, err := service.db.GetDocument(ctx, docID)
+	require.NoError(t, err)
+	assert.True(t, doc.AutoRegenerate)
+
+	// 开启 auto_regenerate 后回到图片生成阶段
+	assert.True(t, edit("s1", "湖边的小屋和渔船").MediaStale)
+	assert.Equal(t, db.DocumentStatusSceneReady, status())
+
+	// 重新生成成功后清除过期标记
+	require.NoError(t, service.db.UpdateSceneStatus(ctx, "s1", db.SceneStatusReady, 0, ""))
+	s1, err := service.db.GetScene(ctx, "s1")
+	require.NoError(t, err)
+	assert.False(t, s1.MediaStale)
+
+	assert.Equal(t, http.StatusNotFound, do(http.MethodPatch, "/v1/scenes/missing", api.UpdateSceneArgs{Content: "x"}).Code)
+}
@@ -0,0 +1,66 @@
+package svr
+
+import (
+	"fmt"
+	"hash/fnv"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/gin-gonic/gin"
+
+	"imgagent/db"
+)
+
+// 轮询的前端带上次响应的 ETag 请求，数据未变化时返回 304，不再重复传输章节内容
+const (
+	etagHeader        = "ETag"
+	ifNoneMatchHeader = "If-None-Match"
+)
+
+// weakETag 由资源的 id 和更新时间（纳秒）计算弱 ETag，任一资源更新、新增或删除后变化。
+// 只反映资源本身，带 lang 的请求还依赖译文，不使用 ETag
+func weakETag(n int, item func(i int) (string, time.Time)) string {
+	h := fnv.New64a()
+	for i := range n {
+		id, updatedAt := item(i)
+		fmt.Fprintf(h, "%s:%d\n", id, updatedAt.UnixNano())
+	}
+	return fmt.Sprintf(`W/"%x"`, h.Sum64())
+}
+
+func documentsETag(docs []db.Document) string {
+	return weakETag(len(docs), func(i int) (string, time.Time) { return docs[i].ID, docs[i].UpdatedAt })
+}
+
+func chaptersETag(chapters []db.Chapter) string {
+	return weakETag(len(chapters), func(i int) (string, time.Time) { return chapters[i].ID, chapters[i].UpdatedAt })
+}
+
+func scenesETag(scenes []db.Scene) string {
+	return weakETag(len(scenes), func(i int) (string, time.Time) { return scenes[i].ID, scenes[i].UpdatedAt })
+}
+
+// notModified 在响应中写入 ETag，请求的 If-None-Match 与之匹配时返回 304 且不写响应体，返回 true
+func notModified(c *gin.Context, etag string) bool {
+	c.Header(etagHeader, etag)
+	if !etagMatch(c.GetHeader(ifNoneMatchHeader), etag) {
+		return false
+	}
+	c.AbortWithStatus(http.StatusNotModified)
+	return true
+}
+
+// etagMatch If-None-Match 使用弱比较（RFC 9110），可以是逗号分隔的多个 ETag，* 匹配任意
+func etagMatch(header, etag string) bool {
+	if header == "" {
+		return false
+	}
+	for _, tag := range strings.Split(header, ",") {
+		tag = strings.TrimSpace(tag)
+		if tag == "*" || strings.TrimPrefix(tag, "W/") == strings.TrimPrefix(etag, "W/") {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,520 @@
+package svr
+
+import (
+	"archive/zip"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"path/filepath"
+	"sync"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"gorm.io/gorm"
+
+	"imgagent/api"
+	"imgagent/db"
+	hutil "imgagent/httputil"
+	"imgagent/pkg/logger"
+	"imgagent/pkg/tracing"
+	"imgagent/subtitle"
+)
+
+// ExportConfig 导出任务。产物保存在 Dir 下，多实例部署时 Dir 需为共享目录
+type ExportConfig struct {
+	Enable       bool   `json:"enable"`
+	Dir          string `json:"dir"`
+	IntervalSecs int    `json:"interval_secs"` // 扫描待执行任务和过期产物的间隔
+	TTLHours     int    `json:"ttl_hours"`     // 产物保留时间
+}
+
+// exportFormat 导出格式。新增格式实现 write 并在 exportFormats 中注册，
+// 任务状态、取消、产物存储和过期清理由 ExportMgr 统一处理
+type exportFormat struct {
+	ext         string
+	contentType string
+	write       func(ctx context.Context, database db.IDataBase, doc db.Document, w io.Writer) error
+}
+
+var exportFormats = map[string]exportFormat{
+	"zip":    {ext: "zip", contentType: "application/zip", write: writeZipExport},
+	"bundle": {ext: "json", contentType: "application/json", write: writeBundleExport},
+}
+
+// ExportMgr 后台逐个执行导出任务，并清理过期产物
+type ExportMgr struct {
+	conf ExportConfig
+	db   db.IDataBase
+
+	mu      sync.Mutex
+	running map[string]context.CancelFunc
+
+	close     chan bool
+	closeOnce sync.Once
+	wg        sync.WaitGroup
+}
+
+func newExportMgr(conf ExportConfig, database db.IDataBase) *ExportMgr {
+	if conf.Dir == "" {
+		conf.Dir = "./exports"
+	}
+	if conf.IntervalSecs == 0 {
+		conf.IntervalSecs = 5
+	}
+	if conf.TTLHours == 0 {
+		conf.TTLHours = 24
+	}
+	return &ExportMgr{
+		conf:    conf,
+		db:      database,
+		running: make(map[string]context.CancelFunc),
+		close:   make(chan bool),
+	}
+}
+
+func (m *ExportMgr) Run() error {
+	err := os.MkdirAll(m.conf.Dir, 0755)
+	if err != nil {
+		return err
+	}
+	m.wg.Add(1)
+	go m.loopHandleExports()
+	return nil
+}
+
+// Stop 中断执行中的任务并等待循环退出，被中断的任务恢复为 pending，重启后重新执行
+func (m *ExportMgr) Stop(ctx context.Context) error {
+	m.closeOnce.Do(func() { close(m.close) })
+	m.mu.Lock()
+	for _, cancel := range m.running {
+		cancel()
+	}
+	m.mu.Unlock()
+
+	done := make(chan struct{})
+	go func() {
+		m.wg.Wait()
+		close(done)
+	}()
+	select {
+	case <-done:
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+func (m *ExportMgr) stopping() bool {
+	select {
+	case <-m.close:
+		return true
+	default:
+		return false
+	}
+}
+
+func (m *ExportMgr) loopHandleExports() {
+	defer m.wg.Done()
+	ticker := time.NewTicker(time.Second * time.Duration(m.conf.IntervalSecs))
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ticker.C:
+			ctx, span := tracing.Start(logger.NewContext(fmt.Sprintf("HandleExports-%d", time.Now().Unix())), "ExportMgr.HandleExports")
+			ctx = logger.WithTrace(ctx)
+			m.HandleExports(ctx)
+			m.CleanupExpired(ctx)
+			span.End()
+		case <-m.close:
+			return
+		}
+	}
+}
+
+// HandleExports 领取并执行待执行的导出任务
+func (m *ExportMgr) HandleExports(ctx context.Context) {
+	log := logger.FromContext(ctx)
+
+	exports, err := m.db.ListPendingExports(ctx, 10)
+	if err != nil {
+		log.Errorf("Failed to list pending exports, err: %v", err)
+		return
+	}
+	for _, e := range exports {
+		if m.stopping() {
+			return
+		}
+		// 状态已变化说明任务被其他实例领取或已取消
+		err = m.db.TransitExport(ctx, e.ID, []string{db.ExportStatusPending}, db.ExportStatusRunning)
+		if err != nil {
+			if !errors.Is(err, gorm.ErrRecordNotFound) {
+				log.Errorf("Failed to claim export, export: %s, err: %v", e.ID, err)
+			}
+			continue
+		}
+		m.runExport(ctx, e)
+	}
+}
+
+func (m *ExportMgr) runExport(ctx context.Context, e db.Export) {
+	log := logger.FromContext(ctx)
+	log.Infof("Run export, export: %s, doc: %s, format: %s", e.ID, e.DocumentID, e.Format)
+
+	jobCtx, cancel := context.WithCancel(ctx)
+	m.mu.Lock()
+	m.running[e.ID] = cancel
+	m.mu.Unlock()
+	defer func() {
+		m.mu.Lock()
+		delete(m.running, e.ID)
+		m.mu.Unlock()
+		cancel()
+	}()
+
+	path := e.ID + "." + exportFormats[e.Format].ext
+	size, err := m.writeArtifact(jobCtx, e, path)
+	if err == nil {
+		expiresAt := time.Now().Add(time.Duration(m.conf.TTLHours) * time.Hour)
+		err = m.db.CompleteExport(ctx, e.ID, db.ExportStatusSucceeded, size, path, "", expiresAt)
+		if err != nil {
+			// 执行期间被取消，丢弃产物
+			log.Warnf("Failed to complete export, export: %s, err: %v", e.ID, err)
+			m.removeArtifact(ctx, path)
+			return
+		}
+		recordEvent(ctx, m.db, db.Event{
+			UserID:     e.UserID,
+			Type:       db.EventExportReady,
+			DocumentID: e.DocumentID,
+			Message:    fmt.Sprintf("export %s (%s) ready", e.ID, e.Format),
+		})
+		return
+	}
+
+	switch {
+	case m.stopping():
+		log.Infof("Export interrupted by shutdown, export: %s", e.ID)
+		err = m.db.TransitExport(context.WithoutCancel(ctx), e.ID, []string{db.ExportStatusRunning}, db.ExportStatusPending)
+	case jobCtx.Err() != nil:
+		log.Infof("Export canceled, export: %s", e.ID)
+		return
+	default:
+		log.Errorf("Failed to export, export: %s, err: %v", e.ID, err)
+		err = m.db.CompleteExport(ctx, e.ID, db.ExportStatusFailed, 0, "", err.Error(), time.Time{})
+	}
+	if err != nil && !errors.Is(err, gorm.ErrRecordNotFound) {
+		log.Errorf("Failed to update export, export: %s, err: %v", e.ID, err)
+	}
+}
+
+// writeArtifact 先写临时文件，完成后再改名，避免下载到不完整的产物
+func (m *ExportMgr) writeArtifact(ctx context.Context, e db.Export, path string) (int64, error) {
+	doc, err := m.db.GetDocument(ctx, e.DocumentID)
+	if err != nil {
+		return 0, err
+	}
+
+	tmp := filepath.Join(m.conf.Dir, path+".tmp")
+	f, err := os.Create(tmp)
+	if err != nil {
+		return 0, err
+	}
+	defer os.Remove(tmp)
+
+	err = safeCall(ctx, func(ctx context.Context) error {
+		return exportFormats[e.Format].write(ctx, m.db, doc, f)
+	})
+	if err == nil {
+		err = ctx.Err()
+	}
+	if closeErr := f.Close(); err == nil {
+		err = closeErr
+	}
+	if err != nil {
+		return 0, err
+	}
+
+	fi, err := os.Stat(tmp)
+	if err != nil {
+		return 0, err
+	}
+	err = os.Rename(tmp, filepath.Join(m.conf.Dir, path))
+	if err != nil {
+		return 0, err
+	}
+	return fi.Size(), nil
+}
+
+func (m *ExportMgr) removeArtifact(ctx context.Context, path string) {
+	if path == "" {
+		return
+	}
+	err := os.Remove(filepath.Join(m.conf.Dir, path))
+	if err != nil && !os.IsNotExist(err) {
+		logger.FromContext(ctx).Errorf("Failed to remove export artifact, path: %s, err: %v", path, err)
+	}
+}
+
+// Cancel 取消待执行或执行中的任务
+func (m *ExportMgr) Cancel(ctx context.Context, id string) error {
+	err := m.db.TransitExport(ctx, id, []string{db.ExportStatusPending, db.ExportStatusRunning}, db.ExportStatusCanceled)
+	if err != nil {
+		return err
+	}
+	m.mu.Lock()
+	if cancel, ok := m.running[id]; ok {
+		cancel()
+	}
+	m.mu.Unlock()
+	return nil
+}
+
+// CleanupExpired 删除过期产物，任务记录保留为 expired
+func (m *ExportMgr) CleanupExpired(ctx context.Context) {
+	log := logger.FromContext(ctx)
+
+	exports, err := m.db.ListExpiredExports(ctx, time.Now(), 100)
+	if err != nil {
+		log.Errorf("Failed to list expired exports, err: %v", err)
+		return
+	}
+	for _, e := range exports {
+		err = m.db.TransitExport(ctx, e.ID, []string{db.ExportStatusSucceeded}, db.ExportStatusExpired)
+		if err != nil {
+			log.Errorf("Failed to expire export, export: %s, err: %v", e.ID, err)
+			continue
+		}
+		m.removeArtifact(ctx, e.Path)
+	}
+}
+
+// writeZipExport 导出播放清单和各章节文本，媒体以 URL 形式保留在播放清单中
+func writeZipExport(ctx context.Context, database db.IDataBase, doc db.Document, w io.Writer) error {
+	scenes, err := database.ListScenesByDocument(ctx, doc.ID)
+	if err != nil {
+		return err
+	}
+	chapters, err := database.ListChapters(ctx, doc.ID)
+	if err != nil {
+		return err
+	}
+	zw := zip.NewWriter(w)
+	f, err := zw.Create("manifest.json")
+	if err != nil {
+		return err
+	}
+	enc := json.NewEncoder(f)
+	enc.SetIndent("", "  ")
+	// buildManifest 按章节序号排序 chapters，章节文本同样按该顺序写入
+	manifest := buildManifest(&doc, chapters, scenes)
+	manifest.BGM, err = manifestBGM(ctx, database, &doc)
+	if err != nil {
+		return err
+	}
+	err = enc.Encode(manifest)
+	if err != nil {
+		return err
+	}
+
+	// 整个文档的字幕，时间轴与 manifest.json 一致，视频导出时直接使用
+	cues := manifestSubtitles(manifest)
+	for _, sub := range []struct {
+		name string
+		data []byte
+	}{
+		{"subtitles.srt", subtitle.SRT(cues)},
+		{"subtitles.vtt", subtitle.VTT(cues)},
+	} {
+		f, err := zw.Create(sub.name)
+		if err != nil {
+			return err
+		}
+		if _, err = f.Write(sub.data); err != nil {
+			return err
+		}
+	}
+
+	for _, chapter := range chapters {
+		if ctx.Err() != nil {
+			return ctx.Err()
+		}
+		f, err := zw.Create(fmt.Sprintf("chapters/%03d.txt", chapter.Index+1))
+		if err != nil {
+			return err
+		}
+		_, err = fmt.Fprintf(f, "%s\n\n%s\n", chapter.Title, chapter.Content)
+		if err != nil {
+			return err
+		}
+	}
+	return zw.Close()
+}
+
+func makeExport(e *db.Export, baseURL string) api.Export {
+	ret := api.Export{
+		ID:           e.ID,
+		DocumentID:   e.DocumentID,
+		Format:       e.Format,
+		Status:       e.Status,
+		Size:         e.Size,
+		Error:        e.Error,
+		OperationURL: operationURL(baseURL, e.ID),
+		CreatedAt:    e.CreatedAt.Format(time.DateTime),
+		UpdatedAt:    e.UpdatedAt.Format(time.DateTime),
+	}
+	if !e.ExpiresAt.IsZero() {
+		ret.ExpiresAt = e.ExpiresAt.Format(time.DateTime)
+	}
+	return ret
+}
+
+// getUserExport 获取当前租户的导出任务，其他租户的任务视为不存在
+func (s *Service) getUserExport(c *gin.Context) (db.Export, bool) {
+	log := logger.FromGinContext(c)
+	ui := GetUserInfo(c)
+
+	e, err := s.db.GetExport(c.Request.Context(), c.Param("id"))
+	if err == nil && e.UserID != ui.ID {
+		err = gorm.ErrRecordNotFound
+	}
+	if err != nil {
+		log.Errorf("Failed to get export, id: %s, err: %v", c.Param("id"), err)
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			hutil.AbortError(c, http.StatusNotFound, "export not found")
+		} else {
+			hutil.AbortError(c, hutil.ErrServerInternalCode, "get export failed")
+		}
+		return db.Export{}, false
+	}
+	return e, true
+}
+
+func (s *Service) HandleCreateExport(c *gin.Context) {
+	ctx := c.Request.Context()
+	log := logger.FromGinContext(c)
+	ui := GetUserInfo(c)
+
+	if s.exports == nil {
+		hutil.AbortError(c, http.StatusServiceUnavailable, "export disabled")
+		return
+	}
+	var args api.CreateExportArgs
+	if err := c.ShouldBindJSON(&args); err != nil {
+		log.Errorf("Invalid request body, err: %v", err)
+		hutil.AbortError(c, http.StatusBadRequest, "invalid request body")
+		return
+	}
+	if _, ok := exportFormats[args.Format]; !ok {
+		hutil.AbortError(c, http.StatusBadRequest, "unsupported format")
+		return
+	}
+
+	docID := c.Param("document_id")
+	log.Infof("Create export, docID: %s, format: %s", docID, args.Format)
+	_, err := s.db.GetDocument(ctx, docID)
+	if err != nil {
+		log.Errorf("Failed to get document, id: %s, err: %v", docID, err)
+		documentErr(c, err, "get document failed")
+		return
+	}
+
+	now := time.Now()
+	e := db.Export{
+		ID:         db.MakeUUID(),
+		UserID:     ui.ID,
+		DocumentID: docID,
+		Format:     args.Format,
+		Status:     db.ExportStatusPending,
+		CreatedAt:  now,
+		UpdatedAt:  now,
+	}
+	err = s.db.CreateExport(ctx, &e)
+	if err != nil {
+		log.Errorf("Failed to create export, err: %v", err)
+		hutil.AbortError(c, hutil.ErrServerInternalCode, "create export failed")
+		return
+	}
+	hutil.WriteData(c, makeExport(&e, s.baseURL()))
+}
+
+func (s *Service) HandleListExports(c *gin.Context) {
+	ctx := c.Request.Context()
+	log := logger.FromGinContext(c)
+	ui := GetUserInfo(c)
+
+	docID := c.Param("document_id")
+	log.Infof("List exports, docID: %s", docID)
+	exports, err := s.db.ListExports(ctx, docID)
+	if err != nil {
+		log.Errorf("Failed to list exports, err: %v", err)
+		hutil.AbortError(c, hutil.ErrServerInternalCode, "list exports failed")
+		return
+	}
+
+	ret := &api.ListExportsResult{Exports: []api.Export{}}
+	for _, e := range exports {
+		if e.UserID == ui.ID {
+			ret.Exports = append(ret.Exports, makeExport(&e, s.baseURL()))
+		}
+	}
+	hutil.WriteData(c, ret)
+}
+
+func (s *Service) HandleDownloadExport(c *gin.Context) {
+	log := logger.FromGinContext(c)
+
+	e, ok := s.getUserExport(c)
+	if !ok {
+		return
+	}
+	if s.exports == nil || e.Status != db.ExportStatusSucceeded {
+		hutil.AbortError(c, http.StatusNotFound, "export artifact not available")
+		return
+	}
+
+	log.Infof("Download export, id: %s", e.ID)
+	format := exportFormats[e.Format]
+	c.Header("Content-Type", format.contentType)
+	c.FileAttachment(filepath.Join(s.exports.conf.Dir, e.Path), e.DocumentID+"."+format.ext)
+}
+
+// HandleDeleteExport 待执行或执行中的任务被取消，其他状态的任务删除记录和产物
+func (s *Service) HandleDeleteExport(c *gin.Context) {
+	ctx := c.Request.Context()
+	log := logger.FromGinContext(c)
+
+	e, ok := s.getUserExport(c)
+	if !ok {
+		return
+	}
+	if s.exports == nil {
+		hutil.AbortError(c, http.StatusServiceUnavailable, "export disabled")
+		return
+	}
+
+	log.Infof("Delete export, id: %s, status: %s", e.ID, e.Status)
+	err := s.exports.Cancel(ctx, e.ID)
+	if err == nil {
+		hutil.WriteData(c, nil)
+		return
+	}
+	if !errors.Is(err, gorm.ErrRecordNotFound) {
+		log.Errorf("Failed to cancel export, err: %v", err)
+		hutil.AbortError(c, hutil.ErrServerInternalCode, "cancel export failed")
+		return
+	}
+
+	err = s.db.DeleteExport(ctx, e.ID)
+	if err != nil && !errors.Is(err, gorm.ErrRecordNotFound) {
+		log.Errorf("Failed to delete export, err: %v", err)
+		hutil.AbortError(c, hutil.ErrServerInternalCode, "delete export failed")
+		return
+	}
+	s.exports.removeArtifact(ctx, e.Path)
+	hutil.WriteData(c, nil)
+}
@@ -0,0 +1,328 @@
+package svr
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"gorm.io/gorm"
+
+	"imgagent/api"
+	"imgagent/db"
+	hutil "imgagent/httputil"
+	"imgagent/pkg/logger"
+)
+
+const (
+	defaultFailedJobLimit = 20
+	maxFailedJobLimit     = 100
+)
+
+// stageStatuses 各处理阶段对应的文档状态，失败的文档重新入队时恢复为该状态
+var stageStatuses = map[string]string{
+	stageRole:  db.DocumentStatusChapterReady,
+	stageScene: db.DocumentStatusRoleReady,
+	stageImage: db.DocumentStatusSceneReady,
+}
+
+// truncateError 截断失败原因，与 last_error、error 字段的长度一致
+func truncateError(errMsg string) string {
+	if len(errMsg) > 500 {
+		errMsg = strings.ToValidUTF8(errMsg[:500], "")
+	}
+	return errMsg
+}
+
+// HandleListFailedJobs 按 id 分页列取永久失败的文档（type=document，默认）或场景（type=scene）
+func (s *Service) HandleListFailedJobs(c *gin.Context) {
+	jobType := c.DefaultQuery("type", api.FailedJobDocument)
+	if jobType != api.FailedJobDocument && jobType != api.FailedJobScene {
+		hutil.AbortError(c, http.StatusBadRequest, "type must be one of document, scene")
+		return
+	}
+	limit := defaultFailedJobLimit
+	if l := c.Query("limit"); l != "" {
+		v, err := strconv.Atoi(l)
+		if err != nil || v <= 0 {
+			hutil.AbortError(c, http.StatusBadRequest, "invalid limit")
+			return
+		}
+		limit = min(v, maxFailedJobLimit)
+	}
+
+	result, err := s.ListFailedJobs(c.Request.Context(), jobType, c.Query("marker"), limit)
+	if err != nil {
+		hutil.AbortErr(c, err)
+		return
+	}
+	hutil.WriteData(c, result)
+}
+
+func (s *Service) ListFailedJobs(ctx context.Context, jobType, marker string, limit int) (*api.ListFailedJobsResult, error) {
+	log := logger.FromContext(ctx)
+	log.Infof("List failed jobs, type: %s, marker: %s, limit: %d", jobType, marker, limit)
+
+	ret := &api.ListFailedJobsResult{Jobs: []api.FailedJob{}}
+	if jobType == api.FailedJobDocument {
+		docs, err := s.db.ListFailedDocuments(ctx, marker, limit)
+		if err != nil {
+			log.Errorf("Failed to list failed documents, err: %v", err)
+			return nil, hutil.NewApiError(hutil.ErrServerInternalCode, "list failed jobs failed")
+		}
+		for _, doc := range docs {
+			ret.Jobs = append(ret.Jobs, api.FailedJob{
+				Type:       api.FailedJobDocument,
+				ID:         doc.ID,
+				DocumentID: doc.ID,
+				UserID:     doc.UserID,
+				Stage:      doc.FailedStage,
+				Attempts:   doc.Attempts,
+				Error:      doc.LastError,
+				FailedAt:   doc.UpdatedAt.Format(time.DateTime),
+			})
+		}
+	} else {
+		scenes, err := s.db.ListFailedScenes(ctx, marker, limit)
+		if err != nil {
+			log.Errorf("Failed to list failed scenes, err: %v", err)
+			return nil, hutil.NewApiError(hutil.ErrServerInternalCode, "list failed jobs failed")
+		}
+		users := make(map[string]int64)
+		for _, scene := range scenes {
+			userID, ok := users[scene.DocumentID]
+			if !ok {
+				doc, err := s.db.GetDocument(ctx, scene.DocumentID)
+				if err != nil && !errors.Is(err, gorm.ErrRecordNotFound) {
+					log.Errorf("Failed to get document, id: %s, err: %v", scene.DocumentID, err)
+					return nil, hutil.NewApiError(hutil.ErrServerInternalCode, "list failed jobs failed")
+				}
+				userID = doc.UserID
+				users[scene.DocumentID] = userID
+			}
+			ret.Jobs = append(ret.Jobs, api.FailedJob{
+				Type:       api.FailedJobScene,
+				ID:         scene.ID,
+				DocumentID: scene.DocumentID,
+				UserID:     userID,
+				Stage:      stageImage,
+				Attempts:   scene.Attempts,
+				Error:      scene.Error,
+				FailedAt:   scene.UpdatedAt.Format(time.DateTime),
+			})
+		}
+	}
+	// 取满一页说明可能还有更多
+	if len(ret.Jobs) == limit {
+		ret.NextMarker = ret.Jobs[len(ret.Jobs)-1].ID
+	}
+	return ret, nil
+}
+
+// HandleRequeueDocument 将失败的文档从失败阶段重新入队，图片阶段失败时同时重新生成失败的场景
+func (s *Service) HandleRequeueDocument(c *gin.Context) {
+	doc, err := s.RequeueDocument(c.Request.Context(), c.Param("id"))
+	if err != nil {
+		hutil.AbortErr(c, err)
+		return
+	}
+	hutil.WriteData(c, doc)
+}
+
+func (s *Service) RequeueDocument(ctx context.Context, docID string) (*api.Document, error) {
+	log := logger.FromContext(ctx)
+
+	log.Infof("Requeue document, docID: %s", docID)
+	doc, err := s.db.GetDocument(ctx, docID)
+	if err != nil {
+		log.Errorf("Failed to get document, id: %s, err: %v", docID, err)
+		return nil, documentError(err, "get document failed")
+	}
+	if doc.Status != db.DocumentStatusFailed {
+		return nil, hutil.NewApiError(http.StatusBadRequest, "document has not failed")
+	}
+	status, ok := stageStatuses[doc.FailedStage]
+	if !ok {
+		log.Warnf("Unknown failed stage, docID: %s, stage: %q", docID, doc.FailedStage)
+		return nil, hutil.NewApiError(http.StatusBadRequest, "failed stage unknown, document cannot be requeued")
+	}
+
+	if doc.FailedStage == stageImage {
+		n, err := s.db.ResetFailedScenes(ctx, docID, "")
+		if err != nil {
+			log.Errorf("Failed to reset failed scenes, docID: %s, err: %v", docID, err)
+			return nil, hutil.NewApiError(hutil.ErrServerInternalCode, "requeue document failed")
+		}
+		log.Infof("Reset %d failed scenes, docID: %s", n, docID)
+	}
+	err = s.db.UpdateDocumentStatus(ctx, docID, status)
+	if err != nil {
+		log.Errorf("Failed to update document status, docID: %s, err: %v", docID, err)
+		return nil, documentError(err, "requeue document failed")
+	}
+
+	recordEvent(ctx, s.db, db.Event{
+		UserID:     doc.UserID,
+		Type:       db.EventDocumentRequeued,
+		DocumentID: docID,
+		Message:    fmt.Sprintf("document %s requeued at %s stage", doc.Name, doc.FailedStage),
+	})
+	publishProgress(ctx, s.pubsub, s.db, docID, ProgressDocumentRequeued)
+
+	doc, err = s.db.GetDocument(ctx, docID)
+	if err != nil {
+		log.Errorf("Failed to get document, id: %s, err: %v", docID, err)
+		return nil, documentError(err, "get document failed")
+	}
+	ret := makeDocument(&doc)
+	return &ret, nil
+}
+
+// retryStages 重试时可指定的阶段对应的处理阶段，图片和语音在同一阶段生成
+var retryStages = map[string]string{
+	db.StageRoles:  stageRole,
+	db.StageScenes: stageScene,
+	db.StageImages: stageImage,
+	db.StageVoices: stageImage,
+}
+
+func (s *Service) HandleRetryDocument(c *gin.Context, id string) {
+	log := logger.FromGinContext(c)
+
+	var args api.RetryDocumentArgs
+	if c.Request.ContentLength != 0 {
+		if err := c.ShouldBindJSON(&args); err != nil {
+			log.Errorf("Invalid request body, err: %v", err)
+			hutil.AbortError(c, http.StatusBadRequest, "invalid request body")
+			return
+		}
+	}
+	doc, err := s.RetryDocument(c.Request.Context(), GetUserInfo(c).ID, id, args.Stage)
+	if err != nil {
+		hutil.AbortErr(c, err)
+		return
+	}
+	hutil.WriteData(c, doc)
+}
+
+// RetryDocument 只重新处理失败的阶段中失败的部分，无需重新上传：角色阶段重新提取角色，场景阶段只为尚未切分出场景的章节切分，
+// 图片和语音阶段只重新生成永久失败的场景，已生成的场景保留。stage 为空时重试文档失败的阶段，
+// completedWithErrors 的文档只有图片和语音阶段可以重试
+func (s *Service) RetryDocument(ctx context.Context, userID int64, docID string, stage string) (*api.Document, error) {
+	log := logger.FromContext(ctx)
+
+	log.Infof("Retry document, docID: %s, stage: %s", docID, stage)
+	doc, err := s.db.GetDocument(ctx, docID)
+	if err != nil {
+		log.Errorf("Failed to get document, id: %s, err: %v", docID, err)
+		return nil, documentError(err, "get document failed")
+	}
+	failedStage := doc.FailedStage
+	switch doc.Status {
+	case db.DocumentStatusFailed:
+	case db.DocumentStatusCompletedWithErrors:
+		failedStage = stageImage
+	default:
+		return nil, hutil.NewApiError(http.StatusBadRequest, "document has not failed")
+	}
+	if stage != "" && retryStages[stage] != failedStage {
+		return nil, hutil.NewApiError(http.StatusBadRequest, fmt.Sprintf("stage %s has not failed", stage))
+	}
+	status, ok := stageStatuses[failedStage]
+	if !ok {
+		log.Warnf("Unknown failed stage, docID: %s, stage: %q", docID, failedStage)
+		return nil, hutil.NewApiError(http.StatusBadRequest, "failed stage unknown, document cannot be retried")
+	}
+
+	if failedStage == stageImage {
+		n, err := s.db.ResetFailedScenes(ctx, docID, "")
+		if err != nil {
+			log.Errorf("Failed to reset failed scenes, docID: %s, err: %v", docID, err)
+			return nil, hutil.NewApiError(hutil.ErrServerInternalCode, "retry document failed")
+		}
+		// 未通过审核的场景等待管理员复核，不在重试范围内
+		if n == 0 {
+			return nil, hutil.NewApiError(http.StatusBadRequest, "document has no failed scenes to retry")
+		}
+		log.Infof("Reset %d failed scenes, docID: %s", n, docID)
+	}
+	err = s.db.UpdateDocumentStatus(ctx, docID, status)
+	if err != nil {
+		log.Errorf("Failed to update document status, docID: %s, err: %v", docID, err)
+		return nil, documentError(err, "retry document failed")
+	}
+
+	recordEvent(ctx, s.db, db.Event{
+		UserID:     userID,
+		Type:       db.EventDocumentRequeued,
+		DocumentID: docID,
+		Message:    fmt.Sprintf("document %s retried at %s stage", doc.Name, failedStage),
+	})
+	publishProgress(ctx, s.pubsub, s.db, docID, ProgressDocumentRequeued)
+
+	doc, err = s.db.GetDocument(ctx, docID)
+	if err != nil {
+		log.Errorf("Failed to get document, id: %s, err: %v", docID, err)
+		return nil, documentError(err, "get document failed")
+	}
+	ret := makeDocument(&doc)
+	return &ret, nil
+}
+
+// HandleRequeueScene 重新生成永久失败的场景，文档已处理结束时回到图片生成阶段
+func (s *Service) HandleRequeueScene(c *gin.Context) {
+	ctx := c.Request.Context()
+	log := logger.FromGinContext(c)
+
+	scene, ok := s.getScene(c, c.Param("id"))
+	if !ok {
+		return
+	}
+	log.Infof("Requeue scene, sceneID: %s, docID: %s", scene.ID, scene.DocumentID)
+	if scene.Status != db.SceneStatusFailed {
+		hutil.AbortError(c, http.StatusBadRequest, "scene has not failed")
+		return
+	}
+	doc, err := s.db.GetDocument(ctx, scene.DocumentID)
+	if err != nil {
+		log.Errorf("Failed to get document, id: %s, err: %v", scene.DocumentID, err)
+		documentErr(c, err, "get document failed")
+		return
+	}
+
+	_, err = s.db.ResetFailedScenes(ctx, doc.ID, scene.ID)
+	if err != nil {
+		log.Errorf("Failed to reset failed scene, sceneID: %s, err: %v", scene.ID, err)
+		hutil.AbortError(c, hutil.ErrServerInternalCode, "requeue scene failed")
+		return
+	}
+	if documentFinished(doc.Status) {
+		err = s.db.UpdateDocumentStatus(ctx, doc.ID, db.DocumentStatusSceneReady)
+		if err != nil {
+			log.Errorf("Failed to update document status, docID: %s, err: %v", doc.ID, err)
+			documentErr(c, err, "requeue scene failed")
+			return
+		}
+	}
+
+	recordEvent(ctx, s.db, db.Event{
+		UserID:     doc.UserID,
+		Type:       db.EventDocumentRequeued,
+		DocumentID: doc.ID,
+		SceneID:    scene.ID,
+		Message:    fmt.Sprintf("scene %d of %s requeued", scene.Index, doc.Name),
+	})
+	publishProgress(ctx, s.pubsub, s.db, doc.ID, ProgressDocumentRequeued)
+
+	scene, err = s.db.GetScene(ctx, scene.ID)
+	if err != nil {
+		log.Errorf("Failed to get scene, id: %s, err: %v", scene.ID, err)
+		hutil.AbortError(c, hutil.ErrServerInternalCode, "get scene failed")
+		return
+	}
+	hutil.WriteData(c, makeScene(&scene))
+}
@@ -0,0 +1,150 @@
+package svr
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"gorm.io/gorm"
+
+	"imgagent/api"
+	"imgagent/db"
+	hutil "imgagent/httputil"
+	"imgagent/pkg/logger"
+)
+
+// defaultKeepGenerations 默认保留的历史生成次数（不含当前生成）
+const defaultKeepGenerations = 3
+
+func (c DocumentConfig) keepGenerations() int {
+	if c.KeepGenerations <= 0 {
+		return defaultKeepGenerations
+	}
+	return c.KeepGenerations
+}
+
+// recordSceneGeneration 记录场景新生成的媒体，scene 为写入新媒体前的场景。
+// 场景首次记录时先补记其已有的媒体，保证可以回滚到启用生成记录之前的结果；失败只记录日志
+func recordSceneGeneration(ctx context.Context, database db.IDataBase, conf DocumentConfig, scene db.Scene, gen db.SceneGeneration) {
+	log := logger.FromContext(ctx)
+
+	now := time.Now()
+	gen.CreatedAt = now
+	gens := []db.SceneGeneration{gen}
+	if scene.ImageURL != "" && !scene.Placeholder {
+		existing, err := database.ListSceneGenerations(ctx, scene.ID)
+		if err != nil {
+			log.Errorf("Failed to list scene generations, scene: %s, err: %v", scene.ID, err)
+			return
+		}
+		if len(existing) == 0 {
+			gens = append([]db.SceneGeneration{{
+				ImageURL:          scene.ImageURL,
+				VoiceURL:          scene.VoiceURL,
+				AudioDurationMs:   scene.AudioDurationMs,
+				DisplayDurationMs: scene.DisplayDurationMs,
+				CreatedAt:         scene.UpdatedAt,
+			}}, gens...)
+		}
+	}
+
+	err := database.CreateSceneGenerations(ctx, scene.ID, gens, conf.keepGenerations()+1)
+	if err != nil {
+		log.Errorf("Failed to create scene generations, scene: %s, err: %v", scene.ID, err)
+	}
+}
+
+func makeSceneGeneration(g *db.SceneGeneration, scene *db.Scene) api.SceneGeneration {
+	return api.SceneGeneration{
+		Seq:               g.Seq,
+		ImageURL:          g.ImageURL,
+		VoiceURL:          g.VoiceURL,
+		AudioDurationMs:   g.AudioDurationMs,
+		DisplayDurationMs: g.DisplayDurationMs,
+		Active:            g.ImageURL == scene.ImageURL && g.VoiceURL == scene.VoiceURL,
+		CreatedAt:         g.CreatedAt.Format(time.DateTime),
+	}
+}
+
+// getScene 获取场景，不存在时返回 404
+func (s *Service) getScene(c *gin.Context, sceneID string) (db.Scene, bool) {
+	scene, err := s.db.GetScene(c.Request.Context(), sceneID)
+	if err != nil {
+		logger.FromGinContext(c).Errorf("Failed to get scene, id: %s, err: %v", sceneID, err)
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			hutil.AbortError(c, http.StatusNotFound, "scene not found")
+		} else {
+			hutil.AbortError(c, hutil.ErrServerInternalCode, "get scene failed")
+		}
+		return db.Scene{}, false
+	}
+	return scene, true
+}
+
+// HandleListSceneGenerations 按序号倒序列取场景保留的生成记录
+func (s *Service) HandleListSceneGenerations(c *gin.Context) {
+	ctx := c.Request.Context()
+	log := logger.FromGinContext(c)
+
+	sceneID := c.Param("id")
+	log.Infof("List scene generations, sceneID: %s", sceneID)
+	scene, ok := s.getScene(c, sceneID)
+	if !ok {
+		return
+	}
+	gens, err := s.db.ListSceneGenerations(ctx, sceneID)
+	if err != nil {
+		log.Errorf("Failed to list scene generations, err: %v", err)
+		hutil.AbortError(c, hutil.ErrServerInternalCode, "list scene generations failed")
+		return
+	}
+
+	ret := &api.ListSceneGenerationsResult{Generations: []api.SceneGeneration{}}
+	for _, g := range gens {
+		ret.Generations = append(ret.Generations, makeSceneGeneration(&g, &scene))
+	}
+	hutil.WriteData(c, ret)
+}
+
+// HandleActivateSceneGeneration 将场景的媒体回滚为指定的生成记录，不重新生成，不消耗配额
+func (s *Service) HandleActivateSceneGeneration(c *gin.Context) {
+	ctx := c.Request.Context()
+	log := logger.FromGinContext(c)
+
+	sceneID := c.Param("id")
+	seq, err := strconv.Atoi(c.Param("n"))
+	if err != nil {
+		hutil.AbortError(c, http.StatusBadRequest, "invalid generation seq")
+		return
+	}
+
+	log.Infof("Activate scene generation, sceneID: %s, seq: %d", sceneID, seq)
+	if _, ok := s.getScene(c, sceneID); !ok {
+		return
+	}
+	gen, err := s.db.GetSceneGeneration(ctx, sceneID, seq)
+	if err != nil {
+		log.Errorf("Failed to get scene generation, err: %v", err)
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			hutil.AbortError(c, http.StatusNotFound, "generation not found")
+		} else {
+			hutil.AbortError(c, hutil.ErrServerInternalCode, "get scene generation failed")
+		}
+		return
+	}
+	err = s.db.ActivateSceneGeneration(ctx, &gen)
+	if err != nil {
+		log.Errorf("Failed to activate scene generation, err: %v", err)
+		hutil.AbortError(c, hutil.ErrServerInternalCode, "activate scene generation failed")
+		return
+	}
+
+	scene, ok := s.getScene(c, sceneID)
+	if !ok {
+		return
+	}
+	hutil.WriteData(c, makeScene(&scene))
+}
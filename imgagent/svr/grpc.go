@@ -0,0 +1,332 @@
+package svr
+
+import (
+	"bytes"
+	"context"
+	"encoding/hex"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/gin-gonic/gin/binding"
+	"github.com/google/uuid"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/status"
+	"google.golang.org/protobuf/types/known/emptypb"
+
+	"imgagent/api"
+	"imgagent/api/pb"
+	"imgagent/db"
+	hutil "imgagent/httputil"
+	"imgagent/pkg/logger"
+	"imgagent/pkg/middleware"
+	"imgagent/pkg/tracing"
+	"imgagent/proto"
+)
+
+// grpcCodeKey 业务错误码放在 trailer 中，便于调用方区分同一 gRPC code 下的不同业务错误
+const grpcCodeKey = "x-code"
+
+type userInfoCtxKey struct{}
+
+// NewGRPCServer 创建 gRPC 服务，与 HTTP 接口共用 Service 的业务方法
+func (s *Service) NewGRPCServer(opts ...grpc.ServerOption) *grpc.Server {
+	opts = append(opts, grpc.ChainUnaryInterceptor(grpcLogger, s.grpcNilAuth))
+	server := grpc.NewServer(opts...)
+	pb.RegisterImgAgentServer(server, &grpcServer{s: s})
+	return server
+}
+
+// grpcLogger 与 HTTP 的 Logger 中间件一致：从 metadata 获取或生成 reqid，注入 logger，并把业务错误转换为 gRPC status
+func grpcLogger(ctx context.Context, req any, info *grpc.UnaryServerInfo, handler grpc.UnaryHandler) (any, error) {
+	var reqID string
+	if md, ok := metadata.FromIncomingContext(ctx); ok {
+		if v := md.Get(middleware.XReqID); len(v) > 0 {
+			reqID = v[0]
+		}
+	}
+	if reqID == "" {
+		uid := uuid.New()
+		reqID = hex.EncodeToString(uid[:])
+	}
+	grpc.SetHeader(ctx, metadata.Pairs(middleware.XReqID, reqID))
+
+	ctx, span := tracing.Start(ctx, info.FullMethod)
+	defer span.End()
+	ctx = logger.WithTrace(context.WithValue(ctx, logger.LoggerKey, logger.NewLogger(reqID)))
+	log := logger.FromContext(ctx)
+
+	start := time.Now()
+	resp, err := handler(ctx, req)
+	if err != nil {
+		tracing.RecordError(span, err)
+		err = grpcError(ctx, err)
+	}
+	log.Infof("gRPC %s, code: %s, latency: %v", info.FullMethod, status.Code(err), time.Since(start))
+	return resp, err
+}
+
+// grpcNilAuth 与 NilAuth 一致，暂不做认证，所有请求视为默认租户的普通用户
+func (s *Service) grpcNilAuth(ctx context.Context, req any, info *grpc.UnaryServerInfo, handler grpc.UnaryHandler) (any, error) {
+	return handler(context.WithValue(ctx, userInfoCtxKey{}, UserInfo{}), req)
+}
+
+func grpcUserInfo(ctx context.Context) UserInfo {
+	ui, _ := ctx.Value(userInfoCtxKey{}).(UserInfo)
+	return ui
+}
+
+// grpcError 将业务错误码映射为 gRPC status code
+func grpcError(ctx context.Context, err error) error {
+	ae, ok := err.(*proto.ApiError)
+	if !ok {
+		return status.Error(codes.Internal, err.Error())
+	}
+	grpc.SetTrailer(ctx, metadata.Pairs(grpcCodeKey, strconv.Itoa(ae.Code)))
+
+	code := codes.Internal
+	switch ae.Code {
+	case http.StatusBadRequest:
+		code = codes.InvalidArgument
+	case http.StatusUnauthorized:
+		code = codes.Unauthenticated
+	case http.StatusForbidden:
+		code = codes.PermissionDenied
+	case http.StatusNotFound, ErrNoSuchDocumentCode:
+		code = codes.NotFound
+	case ErrExistingDocumentCode, ErrDuplicateDocumentCode:
+		code = codes.AlreadyExists
+	case http.StatusTooManyRequests, ErrQuotaExceededCode:
+		code = codes.ResourceExhausted
+	}
+	return status.Error(code, ae.Message)
+}
+
+// validate 使用与 HTTP 相同的 binding tag 校验请求参数
+func validate(args any) error {
+	if err := binding.Validator.ValidateStruct(args); err != nil {
+		return hutil.NewApiError(http.StatusBadRequest, "invalid request body")
+	}
+	return nil
+}
+
+type grpcServer struct {
+	pb.UnimplementedImgAgentServer
+	s *Service
+}
+
+func (g *grpcServer) CreateDocument(ctx context.Context, req *pb.CreateDocumentRequest) (*pb.Document, error) {
+	ui := grpcUserInfo(ctx)
+	if len(req.GetContent()) == 0 {
+		return nil, hutil.NewApiError(http.StatusBadRequest, "file is required")
+	}
+	doc, err := g.s.CreateDocument(ctx, ui.ID, req.GetName(), req.GetPriority(), false, false, req.GetFilename(), int64(len(req.GetContent())), bytes.NewReader(req.GetContent()))
+	if err != nil {
+		return nil, err
+	}
+	return toPBDocument(doc), nil
+}
+
+func (g *grpcServer) GetDocument(ctx context.Context, req *pb.GetDocumentRequest) (*pb.Document, error) {
+	var wait time.Duration
+	if req.GetWait() != nil {
+		wait = req.GetWait().AsDuration()
+		if wait < 0 {
+			return nil, hutil.NewApiError(http.StatusBadRequest, "invalid wait")
+		}
+	}
+	doc, err := g.s.GetDocument(ctx, req.GetDocumentId(), wait)
+	if err != nil {
+		return nil, err
+	}
+	return toPBDocument(doc), nil
+}
+
+func (g *grpcServer) UpdateDocument(ctx context.Context, req *pb.UpdateDocumentRequest) (*pb.Document, error) {
+	args := &api.UpdateDocumentArgs{Name: req.GetName()}
+	if err := validate(args); err != nil {
+		return nil, err
+	}
+	doc, err := g.s.UpdateDocument(ctx, req.GetDocumentId(), args)
+	if err != nil {
+		return nil, err
+	}
+	return toPBDocument(doc), nil
+}
+
+func (g *grpcServer) DeleteDocument(ctx context.Context, req *pb.DeleteDocumentRequest) (*emptypb.Empty, error) {
+	if err := g.s.DeleteDocument(ctx, req.GetDocumentId()); err != nil {
+		return nil, err
+	}
+	return &emptypb.Empty{}, nil
+}
+
+func (g *grpcServer) ListDocuments(ctx context.Context, req *pb.ListDocumentsRequest) (*pb.ListDocumentsResponse, error) {
+	result, err := g.s.ListDocuments(ctx, db.DocumentFilter{})
+	if err != nil {
+		return nil, err
+	}
+	resp := &pb.ListDocumentsResponse{}
+	for i := range result.Documents {
+		resp.Documents = append(resp.Documents, toPBDocument(&result.Documents[i]))
+	}
+	return resp, nil
+}
+
+func (g *grpcServer) GetChapter(ctx context.Context, req *pb.GetChapterRequest) (*pb.Chapter, error) {
+	chapter, err := g.s.GetChapter(ctx, req.GetDocumentId(), req.GetId())
+	if err != nil {
+		return nil, err
+	}
+	return toPBChapter(chapter), nil
+}
+
+func (g *grpcServer) UpdateChapter(ctx context.Context, req *pb.UpdateChapterRequest) (*pb.Chapter, error) {
+	args := &api.UpdateChapterArgs{Content: req.GetContent()}
+	if err := validate(args); err != nil {
+		return nil, err
+	}
+	chapter, err := g.s.UpdateChapter(ctx, req.GetDocumentId(), req.GetId(), args)
+	if err != nil {
+		return nil, err
+	}
+	return toPBChapter(chapter), nil
+}
+
+func (g *grpcServer) DeleteChapter(ctx context.Context, req *pb.DeleteChapterRequest) (*emptypb.Empty, error) {
+	if err := g.s.DeleteChapter(ctx, req.GetDocumentId(), req.GetId()); err != nil {
+		return nil, err
+	}
+	return &emptypb.Empty{}, nil
+}
+
+func (g *grpcServer) ListChapters(ctx context.Context, req *pb.ListChaptersRequest) (*pb.ListChaptersResponse, error) {
+	result, err := g.s.ListChapters(ctx, req.GetDocumentId(), time.Time{})
+	if err != nil {
+		return nil, err
+	}
+	resp := &pb.ListChaptersResponse{}
+	for i := range result.Chapters {
+		resp.Chapters = append(resp.Chapters, toPBChapter(&result.Chapters[i]))
+	}
+	return resp, nil
+}
+
+func (g *grpcServer) ListRoles(ctx context.Context, req *pb.ListRolesRequest) (*pb.ListRolesResponse, error) {
+	result, err := g.s.ListRoles(ctx, req.GetDocumentId())
+	if err != nil {
+		return nil, err
+	}
+	resp := &pb.ListRolesResponse{}
+	for i := range result.Roles {
+		resp.Roles = append(resp.Roles, toPBRole(&result.Roles[i]))
+	}
+	return resp, nil
+}
+
+func (g *grpcServer) UpdateRole(ctx context.Context, req *pb.UpdateRoleRequest) (*pb.Role, error) {
+	args := &api.UpdateRoleArgs{
+		Name:       req.GetName(),
+		Gender:     req.GetGender(),
+		Character:  req.GetCharacter(),
+		Appearance: req.GetAppearance(),
+	}
+	if err := validate(args); err != nil {
+		return nil, err
+	}
+	role, err := g.s.UpdateRole(ctx, req.GetId(), args)
+	if err != nil {
+		return nil, err
+	}
+	return toPBRole(role), nil
+}
+
+func (g *grpcServer) ListScenes(ctx context.Context, req *pb.ListScenesRequest) (*pb.ListScenesResponse, error) {
+	result, err := g.s.ListScenes(ctx, req.GetDocumentId(), req.GetChapterId(), time.Time{})
+	if err != nil {
+		return nil, err
+	}
+	resp := &pb.ListScenesResponse{}
+	for i := range result.Scenes {
+		resp.Scenes = append(resp.Scenes, toPBScene(&result.Scenes[i]))
+	}
+	return resp, nil
+}
+
+func (g *grpcServer) UpdateScene(ctx context.Context, req *pb.UpdateSceneRequest) (*pb.Scene, error) {
+	args := &api.UpdateSceneArgs{Content: req.GetContent()}
+	if err := validate(args); err != nil {
+		return nil, err
+	}
+	scene, err := g.s.UpdateScene(ctx, req.GetId(), args)
+	if err != nil {
+		return nil, err
+	}
+	return toPBScene(scene), nil
+}
+
+func toPBDocument(d *api.Document) *pb.Document {
+	return &pb.Document{
+		Id:               d.ID,
+		Name:             d.Name,
+		FileId:           d.FileID,
+		SummaryImageUrl:  d.SummaryImageURL,
+		Status:           d.Status,
+		SceneCount:       int32(d.SceneCount),
+		FailedSceneCount: int32(d.FailedSceneCount),
+		CreatedAt:        d.CreatedAt,
+		UpdatedAt:        d.UpdatedAt,
+		Attempts:         int32(d.Attempts),
+		LastError:        d.LastError,
+		NextAttemptAt:    d.NextAttemptAt,
+		Priority:         d.Priority,
+		Paused:           d.Paused,
+	}
+}
+
+func toPBChapter(c *api.Chapter) *pb.Chapter {
+	return &pb.Chapter{
+		Id:         c.ID,
+		Index:      int32(c.Index),
+		DocumentId: c.DocumentID,
+		Title:      c.Title,
+		Content:    c.Content,
+		SceneIds:   c.SceneIDs,
+		CreatedAt:  c.CreatedAt,
+		UpdatedAt:  c.UpdatedAt,
+	}
+}
+
+func toPBRole(r *api.Role) *pb.Role {
+	return &pb.Role{
+		Id:         r.ID,
+		DocumentId: r.DocumentID,
+		Name:       r.Name,
+		Gender:     r.Gender,
+		Character:  r.Character,
+		Appearance: r.Appearance,
+		CreatedAt:  r.CreatedAt,
+		UpdatedAt:  r.UpdatedAt,
+	}
+}
+
+func toPBScene(s *api.Scene) *pb.Scene {
+	return &pb.Scene{
+		Id:                s.ID,
+		ChapterId:         s.ChapterID,
+		DocumentId:        s.DocumentID,
+		Index:             int32(s.Index),
+		Content:           s.Content,
+		ImageUrl:          s.ImageURL,
+		VoiceUrl:          s.VoiceURL,
+		Status:            s.Status,
+		Error:             s.Error,
+		Placeholder:       s.Placeholder,
+		AudioDurationMs:   s.AudioDurationMs,
+		DisplayDurationMs: s.DisplayDurationMs,
+		CreatedAt:         s.CreatedAt,
+		UpdatedAt:         s.UpdatedAt,
+	}
+}
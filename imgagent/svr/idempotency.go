@@ -0,0 +1,113 @@
+package svr
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+
+	"github.com/gin-gonic/gin"
+
+	hutil "imgagent/httputil"
+	"imgagent/pkg/idempotency"
+	"imgagent/pkg/logger"
+)
+
+const (
+	idempotencyKeyHeader = "Idempotency-Key"
+	// idempotentReplayedHeader 响应来自之前相同幂等键的请求
+	idempotentReplayedHeader = "Idempotent-Replayed"
+	maxIdempotencyKeyLen     = 255
+
+	ErrIdempotencyKeyInUseCode = 618
+	ErrIdempotencyKeyInUse     = "request with the same idempotency key is in progress"
+)
+
+// idempotencyWriter 记录写出的响应，处理完成后保存
+type idempotencyWriter struct {
+	gin.ResponseWriter
+	body bytes.Buffer
+}
+
+func (w *idempotencyWriter) Write(data []byte) (int, error) {
+	w.body.Write(data)
+	return w.ResponseWriter.Write(data)
+}
+
+func (w *idempotencyWriter) WriteString(s string) (int, error) {
+	w.body.WriteString(s)
+	return w.ResponseWriter.WriteString(s)
+}
+
+// Idempotent 支持 Idempotency-Key 请求头，相同租户、路径和幂等键的请求在保留时间内只处理一次，
+// 重复请求返回首次的响应，避免客户端超时重试时重复创建文档或生成任务。
+// 服务端错误和并发超限不保存，允许使用相同幂等键重试；存储不可用时按普通请求处理
+func (s *Service) Idempotent() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		key := c.GetHeader(idempotencyKeyHeader)
+		if key == "" {
+			c.Next()
+			return
+		}
+		if len(key) > maxIdempotencyKeyLen {
+			hutil.AbortError(c, http.StatusBadRequest, fmt.Sprintf("idempotency key exceeds maximum length of %d", maxIdempotencyKeyLen))
+			return
+		}
+
+		ctx := c.Request.Context()
+		log := logger.FromGinContext(c)
+		key = fmt.Sprintf("%d:%s:%s:%s", GetUserInfo(c).ID, c.Request.Method, c.Request.URL.Path, key)
+		ok, resp, err := s.idempotency.Reserve(ctx, key)
+		if err != nil {
+			log.Errorf("Failed to reserve idempotency key, key: %s, err: %v", key, err)
+			c.Next()
+			return
+		}
+		if !ok {
+			if resp == nil {
+				log.Warnf("Idempotency key in use, key: %s", key)
+				hutil.AbortError(c, ErrIdempotencyKeyInUseCode, ErrIdempotencyKeyInUse)
+				return
+			}
+			log.Infof("Replay idempotent response, key: %s", key)
+			c.Header(idempotentReplayedHeader, "true")
+			c.Data(resp.Status, resp.ContentType, resp.Body)
+			c.Abort()
+			return
+		}
+
+		// 客户端超时断开后仍需保存或释放幂等键
+		ctx = context.WithoutCancel(ctx)
+		defer func() {
+			if r := recover(); r != nil {
+				s.idempotency.Release(ctx, key)
+				panic(r)
+			}
+		}()
+		w := &idempotencyWriter{ResponseWriter: c.Writer}
+		c.Writer = w
+		c.Next()
+
+		var body struct {
+			Code int `json:"code"`
+		}
+		json.Unmarshal(w.body.Bytes(), &body)
+		if w.Status() >= http.StatusInternalServerError || body.Code == http.StatusTooManyRequests ||
+			(body.Code >= http.StatusInternalServerError && body.Code < 600) {
+			err = s.idempotency.Release(ctx, key)
+			if err != nil {
+				log.Errorf("Failed to release idempotency key, key: %s, err: %v", key, err)
+			}
+			return
+		}
+		err = s.idempotency.Save(ctx, key, &idempotency.Response{
+			Status:      w.Status(),
+			ContentType: w.Header().Get("Content-Type"),
+			Body:        w.body.Bytes(),
+		})
+		if err != nil {
+			log.Errorf("Failed to save idempotent response, key: %s, err: %v", key, err)
+		}
+	}
+}
@@ -0,0 +1,122 @@
+package svr
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"gorm.io/gorm"
+
+	"imgagent/api"
+	"imgagent/db"
+	hutil "imgagent/httputil"
+	"imgagent/pkg/logger"
+)
+
+// defaultKeepImageVersions 默认保留的图片版本数（含当前图片）
+const defaultKeepImageVersions = 10
+
+func (c DocumentConfig) keepImageVersions() int {
+	if c.KeepImageVersions <= 0 {
+		return defaultKeepImageVersions
+	}
+	return c.KeepImageVersions
+}
+
+// recordSceneImageVersion 记录场景新生成的图片，scene 为写入新图片前的场景。
+// 场景首次记录时先补记其已有的图片，保证可以回退到启用图片版本之前的结果；失败只记录日志
+func recordSceneImageVersion(ctx context.Context, database db.IDataBase, conf DocumentConfig, scene db.Scene, imageURL string) {
+	log := logger.FromContext(ctx)
+
+	versions := []db.SceneImageVersion{{ImageURL: imageURL, CreatedAt: time.Now()}}
+	if scene.ImageURL != "" && scene.ImageURL != imageURL && !scene.Placeholder {
+		existing, err := database.ListSceneImageVersions(ctx, scene.ID)
+		if err != nil {
+			log.Errorf("Failed to list scene image versions, scene: %s, err: %v", scene.ID, err)
+			return
+		}
+		if len(existing) == 0 {
+			versions = append([]db.SceneImageVersion{{ImageURL: scene.ImageURL, CreatedAt: scene.UpdatedAt}}, versions...)
+		}
+	}
+
+	err := database.CreateSceneImageVersions(ctx, scene.ID, versions, conf.keepImageVersions())
+	if err != nil {
+		log.Errorf("Failed to create scene image versions, scene: %s, err: %v", scene.ID, err)
+	}
+}
+
+func makeSceneImageVersion(v *db.SceneImageVersion, scene *db.Scene) api.SceneImageVersion {
+	return api.SceneImageVersion{
+		Version:   v.Version,
+		ImageURL:  v.ImageURL,
+		Active:    v.ImageURL == scene.ImageURL,
+		CreatedAt: v.CreatedAt.Format(time.DateTime),
+	}
+}
+
+// HandleListSceneImageVersions 按版本号倒序列取场景保留的图片版本
+func (s *Service) HandleListSceneImageVersions(c *gin.Context) {
+	ctx := c.Request.Context()
+	log := logger.FromGinContext(c)
+
+	sceneID := c.Param("id")
+	log.Infof("List scene image versions, sceneID: %s", sceneID)
+	scene, ok := s.getScene(c, sceneID)
+	if !ok {
+		return
+	}
+	versions, err := s.db.ListSceneImageVersions(ctx, sceneID)
+	if err != nil {
+		log.Errorf("Failed to list scene image versions, err: %v", err)
+		hutil.AbortError(c, hutil.ErrServerInternalCode, "list scene image versions failed")
+		return
+	}
+
+	ret := &api.ListSceneImageVersionsResult{Versions: []api.SceneImageVersion{}}
+	for _, v := range versions {
+		ret.Versions = append(ret.Versions, makeSceneImageVersion(&v, &scene))
+	}
+	hutil.WriteData(c, ret)
+}
+
+// HandleRevertSceneImage 将场景图片回退为指定版本，不重新生成，不消耗配额
+func (s *Service) HandleRevertSceneImage(c *gin.Context) {
+	ctx := c.Request.Context()
+	log := logger.FromGinContext(c)
+
+	var args api.RevertSceneImageArgs
+	if err := c.ShouldBindJSON(&args); err != nil {
+		log.Errorf("Invalid request body, err: %v", err)
+		hutil.AbortError(c, http.StatusBadRequest, "invalid request body")
+		return
+	}
+	sceneID := c.Param("id")
+	log.Infof("Revert scene image, sceneID: %s, version: %d", sceneID, args.Version)
+	if _, ok := s.getScene(c, sceneID); !ok {
+		return
+	}
+	v, err := s.db.GetSceneImageVersion(ctx, sceneID, args.Version)
+	if err != nil {
+		log.Errorf("Failed to get scene image version, err: %v", err)
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			hutil.AbortError(c, http.StatusNotFound, "image version not found")
+		} else {
+			hutil.AbortError(c, hutil.ErrServerInternalCode, "get scene image version failed")
+		}
+		return
+	}
+	if err = s.db.RevertSceneImage(ctx, &v); err != nil {
+		log.Errorf("Failed to revert scene image, err: %v", err)
+		hutil.AbortError(c, hutil.ErrServerInternalCode, "revert scene image failed")
+		return
+	}
+
+	scene, ok := s.getScene(c, sceneID)
+	if !ok {
+		return
+	}
+	hutil.WriteData(c, makeScene(&scene))
+}
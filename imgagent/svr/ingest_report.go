@@ -0,0 +1,60 @@
+package svr
+
+import (
+	"net/http"
+
+	"github.com/gin-gonic/gin"
+
+	"imgagent/api"
+	hutil "imgagent/httputil"
+	"imgagent/pkg/logger"
+	"imgagent/spliter"
+)
+
+func makeIngestReport(r *spliter.Report) api.IngestReport {
+	ret := api.IngestReport{
+		Encoding:         r.Encoding,
+		Chars:            r.Chars,
+		Paragraphs:       r.Paragraphs,
+		Chapters:         r.Chapters,
+		NonTextRatio:     r.NonTextRatio,
+		ShortChapters:    r.ShortChapters,
+		LongParagraphs:   r.LongParagraphs,
+		LongestParagraph: r.LongestParagraph,
+		DuplicateTitles:  r.DuplicateTitles,
+		Warnings:         r.Warnings,
+
+		DuplicatesRemoved: r.DuplicatesRemoved,
+	}
+	for _, d := range r.DuplicateChapters {
+		ret.DuplicateChapters = append(ret.DuplicateChapters, api.DuplicateChapter{Index: d.Index, FirstIndex: d.FirstIndex, Title: d.Title})
+	}
+	for _, d := range r.NearDuplicateChapters {
+		ret.NearDuplicateChapters = append(ret.NearDuplicateChapters, api.NearDuplicateChapter{
+			Index:      d.Index,
+			FirstIndex: d.FirstIndex,
+			Title:      d.Title,
+			Similarity: d.Similarity(),
+		})
+	}
+	return ret
+}
+
+// HandleGetIngestReport 获取上传时的原文统计和质量检查报告，用户可在生成前修正源文件后重新上传
+func (s *Service) HandleGetIngestReport(c *gin.Context) {
+	ctx := c.Request.Context()
+	log := logger.FromGinContext(c)
+
+	docID := c.Param("document_id")
+	if docID == "" {
+		hutil.AbortError(c, http.StatusBadRequest, "invalid doc id")
+		return
+	}
+	doc, err := s.db.GetDocument(ctx, docID)
+	if err != nil {
+		log.Errorf("Failed to get document, id: %s, err: %v", docID, err)
+		documentErr(c, err, "get document failed")
+		return
+	}
+	hutil.WriteData(c, doc.IngestReport)
+}
@@ -0,0 +1,291 @@
+package svr
+
+import (
+	"context"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/gin-gonic/gin"
+
+	"imgagent/api"
+	"imgagent/db"
+	hutil "imgagent/httputil"
+	"imgagent/pkg/logger"
+	"imgagent/proto"
+)
+
+const (
+	JobStatusQueued    = "queued"
+	JobStatusRunning   = "running"
+	JobStatusSucceeded = "succeeded"
+	JobStatusFailed    = "failed"
+)
+
+// 客户端通过 Prefer: respond-async（RFC 7240）要求异步执行，服务端接受时在响应中回写 Preference-Applied
+const (
+	preferHeader            = "Prefer"
+	preferenceAppliedHeader = "Preference-Applied"
+	preferRespondAsync      = "respond-async"
+)
+
+// LimitConfig 同步调用大模型的接口按租户限制并发，超出并发的请求排队异步执行
+type LimitConfig struct {
+	MaxConcurrent int `json:"max_concurrent"` // 每个租户同时执行的请求数
+	MaxQueued     int `json:"max_queued"`     // 每个租户排队的请求数，超出返回 429
+	JobTTLSecs    int `json:"job_ttl_secs"`   // 异步任务结果保留时间
+}
+
+type limitJob struct {
+	api.Job
+	userID    int64
+	updatedAt time.Time
+}
+
+// Limiter 租户级并发限制，任务结果只保存在内存中，服务重启后丢失
+type Limiter struct {
+	conf    LimitConfig
+	baseURL string // 对外地址加 API 版本，用于生成任务的操作地址
+
+	wg     sync.WaitGroup
+	mu     sync.Mutex
+	slots  map[int64]chan struct{}
+	queued map[int64]int
+	jobs   map[string]*limitJob
+}
+
+func (c LimitConfig) withDefaults() LimitConfig {
+	if c.MaxConcurrent <= 0 {
+		c.MaxConcurrent = 2
+	}
+	if c.MaxQueued <= 0 {
+		c.MaxQueued = 10
+	}
+	if c.JobTTLSecs <= 0 {
+		c.JobTTLSecs = 3600
+	}
+	return c
+}
+
+func newLimiter(conf LimitConfig, baseURL string) *Limiter {
+	return &Limiter{
+		conf:    conf.withDefaults(),
+		baseURL: baseURL,
+		slots:   make(map[int64]chan struct{}),
+		queued:  make(map[int64]int),
+		jobs:    make(map[string]*limitJob),
+	}
+}
+
+// Limits 当前的并发和排队限制
+func (l *Limiter) Limits() api.RateLimits {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	return api.RateLimits{MaxConcurrent: l.conf.MaxConcurrent, MaxQueued: l.conf.MaxQueued}
+}
+
+// SetLimits 运行时修改并发和排队限制，不大于 0 时使用默认值。修改并发后新请求使用新的名额，
+// 执行中的请求在原名额上释放，因此调小并发时在这些请求结束前总并发可能超出新的限制
+func (l *Limiter) SetLimits(limits api.RateLimits) {
+	conf := LimitConfig{MaxConcurrent: limits.MaxConcurrent, MaxQueued: limits.MaxQueued}.withDefaults()
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	if conf.MaxConcurrent != l.conf.MaxConcurrent {
+		l.conf.MaxConcurrent = conf.MaxConcurrent
+		l.slots = make(map[int64]chan struct{})
+	}
+	l.conf.MaxQueued = conf.MaxQueued
+}
+
+func (l *Limiter) tenantSlots(userID int64) chan struct{} {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	slots, ok := l.slots[userID]
+	if !ok {
+		slots = make(chan struct{}, l.conf.MaxConcurrent)
+		l.slots[userID] = slots
+	}
+	return slots
+}
+
+// Run 有空闲并发时同步执行 fn 并返回结果；否则排队异步执行，返回 202 和任务，客户端通过任务的 operation_url 轮询结果。
+// 请求带 Prefer: respond-async 时总是排队异步执行
+func (l *Limiter) Run(c *gin.Context, userID int64, fn func(ctx context.Context) (any, error)) {
+	log := logger.FromGinContext(c)
+	slots := l.tenantSlots(userID)
+
+	async := preferAsync(c.Request)
+	if !async {
+		select {
+		case slots <- struct{}{}:
+			defer func() { <-slots }()
+			data, err := fn(c.Request.Context())
+			if err != nil {
+				hutil.AbortErr(c, err)
+				return
+			}
+			hutil.WriteData(c, data)
+			return
+		default:
+		}
+	}
+
+	job, ok := l.enqueue(userID)
+	if !ok {
+		log.Warnf("Too many queued requests, userID: %d", userID)
+		hutil.AbortError(c, http.StatusTooManyRequests, "too many requests")
+		return
+	}
+	if async {
+		log.Infof("Async response requested, request queued, userID: %d, job: %s", userID, job.ID)
+		c.Header(preferenceAppliedHeader, preferRespondAsync)
+	} else {
+		log.Infof("Concurrency limit reached, request queued, userID: %d, job: %s", userID, job.ID)
+	}
+
+	// 请求返回后继续执行，保留 logger 和 trace
+	ctx := context.WithoutCancel(c.Request.Context())
+	l.wg.Add(1)
+	go func() {
+		defer l.wg.Done()
+		slots <- struct{}{}
+		defer func() { <-slots }()
+		l.update(job.ID, JobStatusRunning, nil, nil)
+		data, err := fn(ctx)
+		if err != nil {
+			logger.FromContext(ctx).Errorf("Queued job failed, job: %s, err: %v", job.ID, err)
+		}
+		l.update(job.ID, "", data, err)
+	}()
+
+	c.Header("Location", job.OperationURL)
+	hutil.WriteAccepted(c, job)
+}
+
+// preferAsync 请求的 Prefer 头是否包含 respond-async，可能有多个头或以逗号分隔多个偏好，偏好可带参数
+func preferAsync(r *http.Request) bool {
+	for _, value := range r.Header.Values(preferHeader) {
+		for _, pref := range strings.Split(value, ",") {
+			token, _, _ := strings.Cut(pref, ";")
+			token, _, _ = strings.Cut(token, "=")
+			if strings.EqualFold(strings.TrimSpace(token), preferRespondAsync) {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// TryRun 有空闲并发时同步执行 fn，否则直接返回 429，供不支持轮询异步任务的调用方使用
+func (l *Limiter) TryRun(ctx context.Context, userID int64, fn func(ctx context.Context) (any, error)) (any, error) {
+	slots := l.tenantSlots(userID)
+	select {
+	case slots <- struct{}{}:
+		defer func() { <-slots }()
+		return fn(ctx)
+	default:
+		logger.FromContext(ctx).Warnf("Concurrency limit reached, userID: %d", userID)
+		return nil, hutil.NewApiError(http.StatusTooManyRequests, "too many requests")
+	}
+}
+
+func (l *Limiter) enqueue(userID int64) (api.Job, bool) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	l.cleanup()
+
+	if l.queued[userID] >= l.conf.MaxQueued {
+		return api.Job{}, false
+	}
+	l.queued[userID]++
+	id := db.MakeUUID()
+	job := &limitJob{
+		Job:       api.Job{ID: id, Status: JobStatusQueued, OperationURL: operationURL(l.baseURL, id)},
+		userID:    userID,
+		updatedAt: time.Now(),
+	}
+	l.jobs[job.ID] = job
+	return job.Job, true
+}
+
+// update 更新任务状态，status 为空时根据 err 判断任务成功或失败
+func (l *Limiter) update(id string, status string, data any, err error) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	job, ok := l.jobs[id]
+	if !ok {
+		return
+	}
+	job.updatedAt = time.Now()
+	if status == JobStatusRunning {
+		job.Status = status
+		l.queued[job.userID]--
+		return
+	}
+
+	if err == nil {
+		job.Status = JobStatusSucceeded
+		job.Result = data
+		return
+	}
+	job.Status = JobStatusFailed
+	job.Code = hutil.ErrServerInternalCode
+	job.Message = err.Error()
+	if ae, ok := err.(*proto.ApiError); ok {
+		job.Code = ae.Code
+		job.Message = ae.Message
+	}
+}
+
+// cleanup 清理超过保留时间的已结束任务，调用方需持有锁
+func (l *Limiter) cleanup() {
+	expire := time.Now().Add(-time.Duration(l.conf.JobTTLSecs) * time.Second)
+	for id, job := range l.jobs {
+		finished := job.Status == JobStatusSucceeded || job.Status == JobStatusFailed
+		if finished && job.updatedAt.Before(expire) {
+			delete(l.jobs, id)
+		}
+	}
+}
+
+// Get 获取租户的任务，其他租户的任务视为不存在
+func (l *Limiter) Get(userID int64, id string) (api.Job, bool) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	job, ok := l.jobs[id]
+	if !ok || job.userID != userID {
+		return api.Job{}, false
+	}
+	return job.Job, true
+}
+
+// Wait 等待排队中和执行中的任务全部结束
+func (l *Limiter) Wait(ctx context.Context) error {
+	done := make(chan struct{})
+	go func() {
+		l.wg.Wait()
+		close(done)
+	}()
+	select {
+	case <-done:
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+// HandleGetJob 轮询排队请求的执行结果
+func (s *Service) HandleGetJob(c *gin.Context) {
+	log := logger.FromGinContext(c)
+	ui := GetUserInfo(c)
+
+	id := c.Param("id")
+	log.Infof("Get job, userID: %d, id: %s", ui.ID, id)
+	job, ok := s.limiter.Get(ui.ID, id)
+	if !ok {
+		hutil.AbortError(c, http.StatusNotFound, "job not found")
+		return
+	}
+	hutil.WriteData(c, &job)
+}
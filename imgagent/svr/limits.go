@@ -0,0 +1,70 @@
+package svr
+
+import (
+	"github.com/gin-gonic/gin"
+
+	"imgagent/api"
+	hutil "imgagent/httputil"
+	"imgagent/pkg/logger"
+	"imgagent/spliter"
+	"imgagent/tts"
+)
+
+// quotaRemaining 剩余配额，limit 为 0 表示不限制，返回 nil
+func quotaRemaining(limit, used int64) *int64 {
+	if limit <= 0 {
+		return nil
+	}
+	remaining := max(limit-used, 0)
+	return &remaining
+}
+
+// HandleGetLimits 返回当前实例对租户生效的上传、章节分割、并发、文档名、配额限制和语音参数范围
+func (s *Service) HandleGetLimits(c *gin.Context) {
+	ctx := c.Request.Context()
+	log := logger.FromGinContext(c)
+	ui := GetUserInfo(c)
+
+	usage, err := s.quota.Usage(ctx, ui.ID)
+	if err != nil {
+		log.Errorf("Failed to get quota usage, err: %v", err)
+		hutil.AbortError(c, hutil.ErrServerInternalCode, "get quota usage failed")
+		return
+	}
+	quota := s.quota.Limits(ui.ID)
+
+	hutil.WriteData(c, &api.Limits{
+		Upload: api.UploadLimits{
+			FileTypes:            s.uploads.fileTypes(),
+			MaxFileBytes:         s.uploads.maxBytes(),
+			MaxURLBytes:          s.httpClient.MaxBytes(),
+			ArchiveTypes:         archiveExts,
+			MaxArchiveFiles:      maxImportEntries,
+			MaxArchiveFileBytes:  maxImportEntrySize,
+			MaxArchiveTotalBytes: maxImportTotalSize,
+		},
+		Chapter: api.ChapterLimits{
+			MaxChars:     chapterChunkSize,
+			OverlapChars: chapterChunkOverlap,
+			ShortChars:   spliter.ShortChapterRunes,
+		},
+		RateLimit:    s.limiter.Limits(),
+		DocumentName: s.names.Limits(),
+		Quota:        quota,
+		QuotaRemaining: api.QuotaRemaining{
+			Documents:   quotaRemaining(int64(quota.MaxDocuments), usage.Documents),
+			UploadBytes: quotaRemaining(quota.MaxUploadBytes, usage.UploadBytes),
+			ImagesToday: quotaRemaining(int64(quota.MaxImagesPerDay), int64(usage.ImagesToday)),
+			VoicesToday: quotaRemaining(int64(quota.MaxVoicesPerDay), int64(usage.VoicesToday)),
+		},
+		TTS: ttsLimits(tts.Limits(s.voices())),
+	})
+}
+
+func ttsLimits(l tts.ProsodyLimits) api.TTSLimits {
+	return api.TTSLimits{
+		Speed:   api.ValueRange{Min: l.Speed.Min, Max: l.Speed.Max},
+		Pitch:   api.ValueRange{Min: l.Pitch.Min, Max: l.Pitch.Max},
+		Emotion: l.Emotion,
+	}
+}
@@ -0,0 +1,142 @@
+package svr
+
+import (
+	"context"
+	"net/http"
+	"sort"
+
+	"github.com/gin-gonic/gin"
+
+	"imgagent/api"
+	"imgagent/bailian"
+	"imgagent/db"
+	hutil "imgagent/httputil"
+	"imgagent/pkg/logger"
+	"imgagent/placeholder"
+)
+
+const (
+	// displayPaddingMs 语音播放完后图片继续停留的时间
+	displayPaddingMs = 1000
+	// minDisplayDurationMs 场景最短展示时长
+	minDisplayDurationMs = 3000
+)
+
+// displayDurationMs 根据语音时长计算场景建议展示时长
+func displayDurationMs(audioDurationMs int64) int64 {
+	return max(audioDurationMs+displayPaddingMs, minDisplayDurationMs)
+}
+
+// sceneTiming 获取场景语音时长和建议展示时长（毫秒），无法获取音频时长时按文字长度估算
+func sceneTiming(ctx context.Context, client *bailian.Client, voiceURL, text string) (int64, int64) {
+	log := logger.FromContext(ctx)
+
+	d, err := client.AudioDuration(ctx, voiceURL)
+	if err != nil {
+		log.Warnf("Failed to get audio duration, url: %s, err: %v", voiceURL, err)
+		d = placeholder.Duration(text)
+	}
+	audioMs := d.Milliseconds()
+	return audioMs, displayDurationMs(audioMs)
+}
+
+func (s *Service) sceneTiming(ctx context.Context, voiceURL, text string) (int64, int64) {
+	return sceneTiming(ctx, s.bailianClient, voiceURL, text)
+}
+
+// HandleGetManifest 获取文档播放清单：按顺序列出场景媒体和时间轴，客户端播放器与视频导出共用同一时间来源
+func (s *Service) HandleGetManifest(c *gin.Context) {
+	ctx := c.Request.Context()
+	log := logger.FromGinContext(c)
+
+	docID := c.Param("document_id")
+	if docID == "" {
+		hutil.AbortError(c, http.StatusBadRequest, "invalid doc id")
+		return
+	}
+
+	log.Infof("Get manifest, docID: %s", docID)
+	doc, err := s.db.GetDocument(ctx, docID)
+	if err != nil {
+		log.Errorf("Failed to get document, id: %s, err: %v", docID, err)
+		documentErr(c, err, "get document failed")
+		return
+	}
+	chapters, err := s.db.ListChapters(ctx, docID)
+	if err != nil {
+		log.Errorf("Failed to list chapters, err: %v", err)
+		hutil.AbortError(c, http.StatusInternalServerError, "list chapters failed")
+		return
+	}
+	scenes, err := s.db.ListScenesByDocument(ctx, docID)
+	if err != nil {
+		log.Errorf("Failed to list scenes, err: %v", err)
+		hutil.AbortError(c, http.StatusInternalServerError, "list scenes failed")
+		return
+	}
+
+	manifest := buildManifest(&doc, chapters, scenes)
+	manifest.BGM, err = manifestBGM(ctx, s.db, &doc)
+	if err != nil {
+		log.Errorf("Failed to get bgm track, id: %s, err: %v", doc.BGMID, err)
+		hutil.AbortError(c, http.StatusInternalServerError, "get bgm track failed")
+		return
+	}
+	hutil.WriteData(c, manifest)
+}
+
+// buildManifest 按播放顺序生成文档播放清单和章节目录，chapters、scenes 会被原地排序
+func buildManifest(doc *db.Document, chapters []db.Chapter, scenes []db.Scene) *api.Manifest {
+	// 场景序号在文档内全局递增，按序号排列即为播放顺序
+	sort.SliceStable(scenes, func(i, j int) bool {
+		return scenes[i].Index < scenes[j].Index
+	})
+
+	manifest := &api.Manifest{
+		DocumentID: doc.ID,
+		Name:       doc.Name,
+		Status:     doc.Status,
+		Chapters:   make([]api.ManifestChapter, 0, len(chapters)),
+		Scenes:     make([]api.ManifestScene, 0, len(scenes)),
+	}
+	chapterStartMs := make(map[string]int64)
+	for _, scene := range scenes {
+		displayMs := scene.DisplayDurationMs
+		if displayMs == 0 {
+			// 尚未生成语音的场景按文字长度估算
+			displayMs = displayDurationMs(placeholder.Duration(scene.Content).Milliseconds())
+		}
+		manifest.Scenes = append(manifest.Scenes, api.ManifestScene{
+			ID:                scene.ID,
+			ChapterID:         scene.ChapterID,
+			Index:             scene.Index,
+			Content:           scene.Content,
+			ImageURL:          scene.ImageURL,
+			HDImageURL:        sceneHDImageURL(&scene),
+			VoiceURL:          scene.VoiceURL,
+			SubtitleURL:       scene.SubtitleURL,
+			Placeholder:       scene.Placeholder,
+			StartMs:           manifest.TotalDurationMs,
+			AudioDurationMs:   scene.AudioDurationMs,
+			DisplayDurationMs: displayMs,
+		})
+		if _, ok := chapterStartMs[scene.ChapterID]; !ok {
+			chapterStartMs[scene.ChapterID] = manifest.TotalDurationMs
+		}
+		manifest.TotalDurationMs += displayMs
+	}
+
+	sort.SliceStable(chapters, func(i, j int) bool {
+		return chapters[i].Index < chapters[j].Index
+	})
+	for _, chapter := range chapters {
+		manifest.Chapters = append(manifest.Chapters, api.ManifestChapter{
+			ID:       chapter.ID,
+			Index:    chapter.Index,
+			Title:    chapter.Title,
+			CoverURL: chapter.CoverURL,
+			StartMs:  chapterStartMs[chapter.ID],
+		})
+	}
+	return manifest
+}
@@ -0,0 +1,109 @@
+package svr
+
+import (
+	"context"
+	"net/http"
+	"os"
+	"path/filepath"
+	"slices"
+
+	"github.com/gin-gonic/gin"
+
+	hutil "imgagent/httputil"
+	"imgagent/imagegen"
+	"imgagent/pkg/logger"
+	"imgagent/pkg/mediastore"
+	"imgagent/tts"
+)
+
+// synthesize 合成场景语音
+func (s *Service) synthesize(ctx context.Context, text string, opts tts.Options) (string, error) {
+	return s.voices().Synthesize(ctx, text, opts)
+}
+
+// voices 配置的语音服务，未配置时使用百炼
+func (s *Service) voices() tts.Provider {
+	if s.ttsProvider == nil {
+		return tts.NewBailian(s.bailianClient)
+	}
+	return s.ttsProvider
+}
+
+// images 已启用的场景图片服务，未配置时只使用百炼
+func (s *Service) images() *imagegen.Providers {
+	if s.imageGen == nil {
+		return imagegen.Default(s.bailianClient)
+	}
+	return s.imageGen
+}
+
+// deleteUnreferencedMedia 删除不再被引用的本地媒体文件。文件按内容去重，其他文档可能引用相同的文件，
+// 只删除已没有引用的；失败只记录日志
+func (s *Service) deleteUnreferencedMedia(ctx context.Context, urls []string) {
+	log := logger.FromContext(ctx)
+	if len(urls) == 0 {
+		return
+	}
+	referenced, err := s.db.ListReferencedMediaURLs(ctx, urls)
+	if err != nil {
+		log.Errorf("Failed to list referenced media, err: %v", err)
+		return
+	}
+	for _, url := range urls {
+		if slices.Contains(referenced, url) {
+			continue
+		}
+		for _, store := range []*mediastore.Store{s.audioStore, s.imageStore} {
+			if store == nil {
+				continue
+			}
+			ok, err := store.Delete(url)
+			if err != nil {
+				log.Warnf("Failed to delete media, url: %s, err: %v", url, err)
+			}
+			if ok {
+				break
+			}
+		}
+	}
+}
+
+// HandleGetAudio 返回保存在本地的场景语音，播放器直接引用，不经过认证
+func (s *Service) HandleGetAudio(c *gin.Context) {
+	serveMedia(c, s.audioStore)
+}
+
+// HandleGetImage 返回保存在本地的场景图片，播放器直接引用，不经过认证
+func (s *Service) HandleGetImage(c *gin.Context) {
+	serveMedia(c, s.imageStore)
+}
+
+// mediaContentTypes 系统 mime 表中可能缺少的扩展名，播放器加载字幕要求 text/vtt
+var mediaContentTypes = map[string]string{
+	".vtt": "text/vtt; charset=utf-8",
+	".srt": "application/x-subrip; charset=utf-8",
+}
+
+// serveMedia 按文件名返回 store 中的媒体文件，Content-Type 由扩展名决定
+func serveMedia(c *gin.Context, store *mediastore.Store) {
+	log := logger.FromGinContext(c)
+
+	if store == nil {
+		hutil.AbortError(c, http.StatusNotFound, "media not found")
+		return
+	}
+	path, err := store.Path(c.Param("name"))
+	if err != nil {
+		hutil.AbortError(c, http.StatusBadRequest, "invalid media name")
+		return
+	}
+	if _, err = os.Stat(path); err != nil {
+		log.Warnf("Media not found, path: %s, err: %v", path, err)
+		hutil.AbortError(c, http.StatusNotFound, "media not found")
+		return
+	}
+	if contentType, ok := mediaContentTypes[filepath.Ext(path)]; ok {
+		c.Header("Content-Type", contentType)
+	}
+	c.File(path)
+}
@@ -0,0 +1,54 @@
+package svr
+
+import (
+	"context"
+	"fmt"
+
+	"imgagent/db"
+	"imgagent/pkg/logger"
+)
+
+// Migrate 执行 schema 迁移命令：up 执行未执行的迁移，down 回滚最近 steps 个迁移，status 打印迁移状态
+func Migrate(ctx context.Context, conf Config, action string, steps int) error {
+	// 由命令显式执行迁移，打开数据库时不自动迁移，否则 down 之前会先执行 up
+	conf.DB.SkipMigrate = true
+	database, err := db.NewDatabase(conf.DB)
+	if err != nil {
+		return err
+	}
+	defer database.Close()
+
+	log := logger.FromContext(ctx)
+	switch action {
+	case "up":
+		ids, err := database.Migrate(ctx)
+		if err != nil {
+			return err
+		}
+		log.Infof("Migrations applied, count: %d, ids: %v", len(ids), ids)
+	case "down":
+		if steps <= 0 {
+			return fmt.Errorf("invalid migrate steps: %d", steps)
+		}
+		ids, err := database.RollbackMigrations(ctx, steps)
+		if err != nil {
+			return err
+		}
+		log.Infof("Migrations rolled back, count: %d, ids: %v", len(ids), ids)
+	case "status":
+		statuses, err := database.MigrationStatus(ctx)
+		if err != nil {
+			return err
+		}
+		for _, st := range statuses {
+			if st.Applied {
+				log.Infof("Migration %s applied at %s", st.ID, st.AppliedAt.Format("2006-01-02 15:04:05"))
+			} else {
+				log.Infof("Migration %s pending", st.ID)
+			}
+		}
+	default:
+		return fmt.Errorf("unknown migrate action: %s", action)
+	}
+	return nil
+}
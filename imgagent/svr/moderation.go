@@ -0,0 +1,257 @@
+package svr
+
+import (
+	"context"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"gorm.io/gorm"
+
+	"imgagent/api"
+	"imgagent/db"
+	hutil "imgagent/httputil"
+	"imgagent/moderation"
+	"imgagent/pkg/logger"
+	"imgagent/pkg/mediastore"
+	"imgagent/placeholder"
+)
+
+// errSceneBlocked 场景未通过内容审核，已标记为 blocked，不再重试
+var errSceneBlocked = errors.New("scene blocked by moderation")
+
+// sceneFailed 场景是否未能发布，包括重试耗尽和未通过审核的场景
+func sceneFailed(scene *db.Scene) bool {
+	return scene.Status == db.SceneStatusFailed || scene.Status == db.SceneStatusBlocked
+}
+
+// moderateSceneText 审核场景描述，返回未通过的原因，未配置审核服务时不审核
+func moderateSceneText(ctx context.Context, provider moderation.Provider, content string) (string, error) {
+	if provider == nil {
+		return "", nil
+	}
+	ret, err := provider.ModerateText(ctx, content)
+	if err != nil || !ret.Flagged {
+		return "", err
+	}
+	return "content " + ret.Reason, nil
+}
+
+// moderateSceneImage 审核生成的场景图片，返回未通过的原因。保存在本地的图片以 data URL 提交，审核服务无需访问本服务
+func moderateSceneImage(ctx context.Context, provider moderation.Provider, store *mediastore.Store, imageURL string) (string, error) {
+	if provider == nil {
+		return "", nil
+	}
+	image := imageURL
+	if store != nil {
+		data, ok, err := store.Read(imageURL)
+		if err != nil {
+			return "", err
+		}
+		if ok {
+			image = "data:" + http.DetectContentType(data) + ";base64," + base64.StdEncoding.EncodeToString(data)
+		}
+	}
+	ret, err := provider.ModerateImage(ctx, image)
+	if err != nil || !ret.Flagged {
+		return "", err
+	}
+	return "image " + ret.Reason, nil
+}
+
+// blockScene 场景未通过审核，使用占位媒体保证导出和播放清单完整，扣留生成的图片等待管理员复核
+func blockScene(ctx context.Context, database db.IDataBase, mediaBaseURL string, doc db.Document, scene db.Scene, reason, heldImageURL string) error {
+	log := logger.FromContext(ctx)
+	log.Warnf("Scene blocked by moderation, scene: %s, reason: %s", scene.ID, reason)
+
+	err := database.BlockScene(ctx, scene.ID, truncateError(reason), heldImageURL)
+	if err != nil {
+		log.Errorf("Failed to block scene, scene: %s, err: %v", scene.ID, err)
+		return err
+	}
+	imageURL, voiceURL := placeholderURLs(mediaBaseURL, scene.ID)
+	err = database.UpdateScenePlaceholder(ctx, scene.ID, imageURL, voiceURL)
+	if err != nil {
+		log.Errorf("Failed to update scene placeholder, scene: %s, err: %v", scene.ID, err)
+		return err
+	}
+	audioMs := placeholder.Duration(scene.Content).Milliseconds()
+	err = database.UpdateSceneTiming(ctx, scene.ID, audioMs, displayDurationMs(audioMs))
+	if err != nil {
+		log.Errorf("Failed to update scene timing, scene: %s, err: %v", scene.ID, err)
+		return err
+	}
+
+	recordDocumentLog(ctx, database, db.DocumentLog{
+		DocumentID: doc.ID,
+		SceneID:    scene.ID,
+		Stage:      stageImage,
+		Level:      db.LogLevelError,
+		Message:    "scene blocked by moderation: " + reason,
+	})
+	recordEvent(ctx, database, db.Event{
+		UserID:     doc.UserID,
+		Type:       db.EventSceneBlocked,
+		DocumentID: doc.ID,
+		SceneID:    scene.ID,
+		Message:    fmt.Sprintf("scene %d of %s blocked by moderation, placeholder media used", scene.Index, doc.Name),
+		Detail:     reason,
+	})
+	return nil
+}
+
+// blockScene 标记场景未通过审核，返回 errSceneBlocked 使场景不再重试
+func (m *DocumentMgr) blockScene(ctx context.Context, doc db.Document, scene db.Scene, reason, heldImageURL string) error {
+	err := blockScene(ctx, m.db, m.mediaBaseURL, doc, scene, reason, heldImageURL)
+	if err != nil {
+		return err
+	}
+	return errSceneBlocked
+}
+
+// HandleListBlockedScenes 按 id 分页列取未通过内容审核、等待复核的场景
+func (s *Service) HandleListBlockedScenes(c *gin.Context) {
+	limit := defaultFailedJobLimit
+	if l := c.Query("limit"); l != "" {
+		v, err := strconv.Atoi(l)
+		if err != nil || v <= 0 {
+			hutil.AbortError(c, http.StatusBadRequest, "invalid limit")
+			return
+		}
+		limit = min(v, maxFailedJobLimit)
+	}
+
+	result, err := s.ListBlockedScenes(c.Request.Context(), c.Query("marker"), limit)
+	if err != nil {
+		hutil.AbortErr(c, err)
+		return
+	}
+	hutil.WriteData(c, result)
+}
+
+func (s *Service) ListBlockedScenes(ctx context.Context, marker string, limit int) (*api.ListBlockedScenesResult, error) {
+	log := logger.FromContext(ctx)
+	log.Infof("List blocked scenes, marker: %s, limit: %d", marker, limit)
+
+	scenes, err := s.db.ListBlockedScenes(ctx, marker, limit)
+	if err != nil {
+		log.Errorf("Failed to list blocked scenes, err: %v", err)
+		return nil, hutil.NewApiError(hutil.ErrServerInternalCode, "list blocked scenes failed")
+	}
+	ret := &api.ListBlockedScenesResult{Scenes: []api.BlockedScene{}}
+	users := make(map[string]int64)
+	for _, scene := range scenes {
+		userID, ok := users[scene.DocumentID]
+		if !ok {
+			doc, err := s.db.GetDocument(ctx, scene.DocumentID)
+			if err != nil && !errors.Is(err, gorm.ErrRecordNotFound) {
+				log.Errorf("Failed to get document, id: %s, err: %v", scene.DocumentID, err)
+				return nil, hutil.NewApiError(hutil.ErrServerInternalCode, "list blocked scenes failed")
+			}
+			userID = doc.UserID
+			users[scene.DocumentID] = userID
+		}
+		ret.Scenes = append(ret.Scenes, api.BlockedScene{
+			ID:           scene.ID,
+			DocumentID:   scene.DocumentID,
+			UserID:       userID,
+			Index:        scene.Index,
+			Content:      scene.Content,
+			Reason:       scene.ModerationReason,
+			HeldImageURL: scene.HeldImageURL,
+			BlockedAt:    scene.UpdatedAt.Format(time.DateTime),
+		})
+	}
+	// 取满一页说明可能还有更多
+	if len(ret.Scenes) == limit {
+		ret.NextMarker = ret.Scenes[len(ret.Scenes)-1].ID
+	}
+	return ret, nil
+}
+
+// HandleApproveBlockedScene 复核放行未通过审核的场景，使用扣留的图片重新发布，文档已处理结束时回到图片生成阶段
+func (s *Service) HandleApproveBlockedScene(c *gin.Context) {
+	s.reviewBlockedScene(c, true)
+}
+
+// HandleRegenerateBlockedScene 丢弃扣留的图片，场景重新生成后再次审核
+func (s *Service) HandleRegenerateBlockedScene(c *gin.Context) {
+	s.reviewBlockedScene(c, false)
+}
+
+func (s *Service) reviewBlockedScene(c *gin.Context, pass bool) {
+	ctx := c.Request.Context()
+	log := logger.FromGinContext(c)
+
+	scene, ok := s.getScene(c, c.Param("id"))
+	if !ok {
+		return
+	}
+	log.Infof("Review blocked scene, sceneID: %s, docID: %s, pass: %v", scene.ID, scene.DocumentID, pass)
+	if scene.Status != db.SceneStatusBlocked {
+		hutil.AbortError(c, http.StatusBadRequest, "scene is not blocked")
+		return
+	}
+	doc, err := s.db.GetDocument(ctx, scene.DocumentID)
+	if err != nil {
+		log.Errorf("Failed to get document, id: %s, err: %v", scene.DocumentID, err)
+		documentErr(c, err, "get document failed")
+		return
+	}
+
+	err = s.db.ResetBlockedScene(ctx, scene.ID, pass)
+	if err != nil {
+		log.Errorf("Failed to reset blocked scene, sceneID: %s, err: %v", scene.ID, err)
+		hutil.AbortError(c, hutil.ErrServerInternalCode, "review scene failed")
+		return
+	}
+	if documentFinished(doc.Status) {
+		err = s.db.UpdateDocumentStatus(ctx, doc.ID, db.DocumentStatusSceneReady)
+		if err != nil {
+			log.Errorf("Failed to update document status, docID: %s, err: %v", doc.ID, err)
+			documentErr(c, err, "review scene failed")
+			return
+		}
+	}
+
+	action := "regenerated"
+	if pass {
+		action = "approved"
+	}
+	recordEvent(ctx, s.db, db.Event{
+		UserID:     doc.UserID,
+		Type:       db.EventDocumentRequeued,
+		DocumentID: doc.ID,
+		SceneID:    scene.ID,
+		Message:    fmt.Sprintf("blocked scene %d of %s %s by moderator", scene.Index, doc.Name, action),
+	})
+	publishProgress(ctx, s.pubsub, s.db, doc.ID, ProgressDocumentRequeued)
+
+	scene, err = s.db.GetScene(ctx, scene.ID)
+	if err != nil {
+		log.Errorf("Failed to get scene, id: %s, err: %v", scene.ID, err)
+		hutil.AbortError(c, hutil.ErrServerInternalCode, "get scene failed")
+		return
+	}
+	hutil.WriteData(c, makeScene(&scene))
+}
+
+// blockScene 手动重新生成的场景未通过审核，标记为 blocked 后返回场景，由调用方查看状态和原因
+func (s *Service) blockScene(ctx context.Context, doc db.Document, scene db.Scene, content, reason, heldImageURL string) (*api.Scene, error) {
+	scene.Content = content
+	err := blockScene(ctx, s.db, s.conf.PublicURL+s.conf.APIVersion, doc, scene, reason, heldImageURL)
+	if err != nil {
+		return nil, hutil.NewApiError(hutil.ErrServerInternalCode, "block scene failed")
+	}
+	scene, err = s.db.GetScene(ctx, scene.ID)
+	if err != nil {
+		logger.FromContext(ctx).Errorf("Failed to get scene, id: %s, err: %v", scene.ID, err)
+		return nil, hutil.NewApiError(hutil.ErrServerInternalCode, "get scene failed")
+	}
+	ret := makeScene(&scene)
+	return &ret, nil
+}
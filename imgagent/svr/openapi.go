@@ -0,0 +1,330 @@
+package svr
+
+import (
+	"net/http"
+
+	"github.com/gin-gonic/gin"
+
+	"imgagent/api"
+	"imgagent/pkg/openapi"
+)
+
+const (
+	openAPIPath       = "/openapi.json"
+	swaggerUIPath     = "/swagger"
+	swaggerAssetsPath = "/swagger-ui"
+)
+
+var swaggerUIAssets = openapi.SwaggerUIAssets()
+
+// openAPIRoutes 接口描述表，与 RegisterRouter 中注册的路由一一对应，新增路由时需同步补充
+func (s *Service) openAPIRoutes() []openapi.Route {
+	v := s.conf.APIVersion
+	str := &openapi.Schema{Type: "string"}
+	integer := &openapi.Schema{Type: "integer"}
+	lang := openapi.Parameter{Name: "lang", Description: "译文语言 en|ja|ko|fr|de|es|ru|zh-Hant，通过 POST /documents/{document_id}:translate 翻译", Schema: str}
+	updatedSince := openapi.Parameter{Name: "updated_since", Description: "只列取该时间及之后更新过的数据，RFC3339 或 2006-01-02 15:04:05（服务端本地时间），用于增量同步；已删除的数据不返回", Schema: str}
+	sceneFilters := []openapi.Parameter{
+		updatedSince,
+		{Name: "location", Description: "只列取地点包含该值的场景", Schema: str},
+		{Name: "time_of_day", Description: "只列取该时间段的场景 dawn|morning|noon|afternoon|evening|night", Schema: str},
+		{Name: "mood", Description: "只列取氛围包含该值的场景", Schema: str},
+		{Name: "character", Description: "只列取该人物出场的场景", Schema: str},
+	}
+	ifNoneMatch := []openapi.Parameter{{Name: ifNoneMatchHeader, Description: "上次响应的 ETag，数据未变化时返回 304 且没有响应体；带 lang 时不返回 ETag", Schema: str}}
+	idempotent := []openapi.Parameter{{Name: idempotencyKeyHeader, Description: "幂等键，相同的键在保留时间内重复请求返回首次的响应", Schema: str}}
+	respondAsync := openapi.Parameter{Name: preferHeader, Description: "为 respond-async 时总是排队异步执行，返回 202 和任务，Location 头为任务状态地址", Schema: str}
+	return []openapi.Route{
+		// Document
+		{Method: http.MethodPost, Path: v + "/documents", Tag: "Document", Summary: "上传文档，异步拆分章节、提取角色、生成场景和图片",
+			Header: idempotent,
+			Form: []openapi.Parameter{
+				{Name: "name", Required: true, Schema: str},
+				{Name: "priority", Description: "处理优先级 high|normal|low，默认 normal", Schema: str},
+				{Name: "file", Description: "原文文件，与 url 二选一；配置了病毒扫描时未通过扫描返回 422", Schema: &openapi.Schema{Type: "string", Format: "binary"}},
+				{Name: "url", Description: "原文地址，受租户的 URL 来源策略限制", Schema: str},
+				{Name: "auto_rename", Description: "为 true 时名称已存在则自动改用 \"name (2)\" 形式的可用名称；否则返回 614，data 为 DocumentNameConflict", Schema: str},
+				{Name: "link_duplicate", Description: "为 true 时已上传过相同内容（sha256 相同）则直接返回已有文档；否则返回 619，data 为 DuplicateDocument", Schema: str},
+			},
+			Result: api.Document{}},
+		{Method: http.MethodPost, Path: v + "/documents:action", Tag: "Document", Summary: "批量导入或导入文档包。action 为 :bulk 时上传 zip 或 tar.gz 归档，每个文件创建一个文档，名称为去掉扩展名的文件名；文档在后台逐个创建，通过 /imports/{id} 查看每个文件的结果。" +
+			"action 为 :import 时上传 GET /documents/{document_id}/export?format=bundle 导出的文档包，同步创建文档、章节、角色和场景，返回 Document；只能导入已提取角色的文档，导入后从场景阶段继续处理",
+			Header: idempotent,
+			Form: []openapi.Parameter{
+				{Name: "file", Required: true, Description: ":bulk 为 zip 或 tar.gz 归档，最多 100 个文件；:import 为 JSON 文档包", Schema: &openapi.Schema{Type: "string", Format: "binary"}},
+				{Name: "priority", Description: "处理优先级 high|normal|low，:bulk 默认 normal，:import 默认使用文档包中的优先级", Schema: str},
+				{Name: "auto_rename", Description: "为 true 时名称已存在的文件自动改用 \"name (2)\" 形式的可用名称", Schema: str},
+			},
+			Result: api.ImportBatch{}},
+		{Method: http.MethodGet, Path: v + "/imports/:id", Tag: "Document", Summary: "获取批量导入的进度和每个文件的结果",
+			Result: api.ImportBatch{}},
+		{Method: http.MethodGet, Path: v + "/documents/:document_id", Tag: "Document", Summary: "获取文档",
+			Header: ifNoneMatch, Query: []openapi.Parameter{
+				{Name: "wait", Description: "长轮询等待状态变化的时长，如 30s，最长 60s", Schema: str},
+				{Name: "lang", Description: "同时返回该语言的翻译任务（translation）", Schema: str},
+			},
+			Result: api.Document{}},
+		{Method: http.MethodPut, Path: v + "/documents/:document_id", Tag: "Document", Summary: "修改文档名称",
+			Body: api.UpdateDocumentArgs{}, Result: api.Document{}},
+		{Method: http.MethodDelete, Path: v + "/documents/:document_id", Tag: "Document", Summary: "删除文档，同时删除上传到百炼的原文文件"},
+		{Method: http.MethodPost, Path: v + "/documents/:document_id", Tag: "Document", Summary: "取消、重试、翻译文档或生成梗概，document_id 为 <document_id>:cancel、<document_id>:retry、<document_id>:translate 或 <document_id>:summarize。" +
+			"取消时进行中的百炼调用随之中断，文档标记为 canceled 后不再处理，已生成的场景保留，已处理结束的文档不能取消；" +
+			"重试只重新处理失败的阶段（stage 为空时为文档失败的阶段）中失败的部分：roles 重新提取角色，scenes 只切分尚无场景的章节，images、voices 重新生成永久失败的场景；" +
+			"翻译时 body 为 TranslateDocumentArgs，返回 202 和 Translation，章节在后台逐章翻译，译文与原文分开保存，重新翻译只翻译尚无译文或原文已修改的章节；" +
+			"生成梗概时先为尚无摘要的章节生成摘要，再分批归纳各章摘要，得到全书梗概和关键情节，保存到文档后返回 Document，并发超限或请求异步执行时返回 202 和任务",
+			Header: []openapi.Parameter{respondAsync}, Body: api.RetryDocumentArgs{}, Result: api.Document{}},
+		{Method: http.MethodPost, Path: v + "/documents/:document_id/pause", Tag: "Document", Summary: "暂停文档处理，正在生成的文档在当前场景完成后暂停，已生成的场景保留",
+			Result: api.Document{}},
+		{Method: http.MethodPost, Path: v + "/documents/:document_id/resume", Tag: "Document", Summary: "恢复文档处理，从剩余场景继续",
+			Result: api.Document{}},
+		{Method: http.MethodGet, Path: v + "/documents", Tag: "Document", Summary: "列取文档，可按状态过滤并按创建时间、更新时间或名称排序",
+			Header: ifNoneMatch, Query: []openapi.Parameter{
+				updatedSince,
+				{Name: "status", Description: "只列取这些状态的文档，逗号分隔，processing 表示全部处理中的状态 chapterReady|roleReady|sceneReady", Schema: str},
+				{Name: "sort_by", Description: "排序字段 created_at|updated_at|name，默认 updated_at", Schema: str},
+				{Name: "order", Description: "排序方向 asc|desc，默认 desc", Schema: str},
+			}, Result: api.ListDocumentsResult{}},
+		{Method: http.MethodGet, Path: v + "/documents/:document_id/events", Tag: "Document", Summary: "以 SSE 推送文档处理进度，每个事件的 data 为 DocumentProgress，文档处理结束后关闭连接",
+			Produces: "text/event-stream"},
+		{Method: http.MethodGet, Path: v + "/documents/:document_id/progress", Tag: "Document", Summary: "获取文档的结构化处理进度：当前阶段，splitting|roles|scenes|images|voices 各阶段的完成数、开始和完成时间，最近一次失败原因",
+			Result: api.ProcessingProgress{}},
+		{Method: http.MethodGet, Path: v + "/documents/:document_id/logs", Tag: "Document", Summary: "下载脱敏后的文档处理日志包（zip），包含流水线事件、百炼请求 id 和失败原因，可附在工单中",
+			Produces: "application/zip"},
+		{Method: http.MethodGet, Path: v + "/documents/:document_id/ingest-report", Tag: "Document", Summary: "获取上传时的原文统计和质量检查报告：编码、过短章节、超长段落、重复章节标题、非文本字符占比",
+			Result: api.IngestReport{}},
+		{Method: http.MethodGet, Path: v + "/style-presets", Tag: "Document", Summary: "列取可用的画面风格预设，修改文档时通过 image_style.preset 指定",
+			Result: api.ListStylePresetsResult{}},
+
+		// Chapter
+		{Method: http.MethodGet, Path: v + "/documents/:document_id/chapters/:id", Tag: "Chapter", Summary: "获取章节，指定 lang 时返回与当前原文一致的译文，尚无译文时返回原文",
+			Header: ifNoneMatch, Query: []openapi.Parameter{lang}, Result: api.Chapter{}},
+		{Method: http.MethodGet, Path: v + "/documents/:document_id/chapters/:id/summary", Tag: "Chapter", Summary: "以 SSE 流式生成章节摘要并保存到章节的 summary，delta 事件的 data 为 ChapterSummaryDelta，结束时推送 done 事件（ChapterSummary），中途失败推送 error 事件（StreamError）。推送期间占用租户的并发名额，超出并发时返回 429",
+			Produces: "text/event-stream"},
+		{Method: http.MethodPut, Path: v + "/documents/:document_id/chapters/:id", Tag: "Chapter", Summary: "修改章节内容",
+			Body: api.UpdateChapterArgs{}, Result: api.Chapter{}},
+		{Method: http.MethodDelete, Path: v + "/documents/:document_id/chapters/:id", Tag: "Chapter", Summary: "删除章节"},
+		{Method: http.MethodGet, Path: v + "/documents/:document_id/chapters", Tag: "Chapter", Summary: "列取章节，指定 lang 时有与当前原文一致译文的章节返回译文，其余返回原文",
+			Header: ifNoneMatch, Query: []openapi.Parameter{updatedSince, lang}, Result: api.ListChaptersResult{}},
+		{Method: http.MethodPost, Path: v + "/documents/:document_id/chapters:action", Tag: "Chapter", Summary: "重排章节，action 为 :reorder。chapter_ids 需包含文档的全部章节，场景序号随章节顺序重新编号；正在拆分场景的文档不允许重排",
+			Body: api.ReorderChaptersArgs{}, Result: api.ListChaptersResult{}},
+		{Method: http.MethodPost, Path: v + "/documents/:document_id/chapters/:id", Tag: "Chapter", Summary: "拆分章节，id 为 <chapter_id>:split。按 offset（字符数）或 marker（第二章开头的文本）拆成两章，后续章节序号加一，场景按在原文中的位置分配到两章；" +
+			"id 为 <chapter_id>:setCover 时 body 为 {\"scene_id\": \"...\"}，选择章节内已生成图片的场景作为封面并返回章节，scene_id 为空时恢复自动选择（第一个已生成图片的场景）；" +
+			"id 为 <chapter_id>:summarize 时无 body，调用百炼生成章节摘要并保存到章节的 summary，返回 ChapterSummary，修改章节内容后摘要清空，并发超限或请求异步执行时返回 202 和任务",
+			Header: []openapi.Parameter{respondAsync}, Body: api.SplitChapterArgs{}, Result: api.ListChaptersResult{}},
+		{Method: http.MethodGet, Path: v + "/documents/:document_id/duplicate-chapters", Tag: "Chapter", Summary: "列取上传时检测到的与前文内容高度相似的章节（simhash），确认后可删除重复章节；修改过内容的章节不再列出",
+			Result: api.ListChapterDuplicatesResult{}},
+
+		// Role
+		{Method: http.MethodGet, Path: v + "/documents/:document_id/roles", Tag: "Role", Summary: "列取文档角色",
+			Result: api.ListRolesResult{}},
+		{Method: http.MethodGet, Path: v + "/documents/:document_id/roles/graph", Tag: "Role", Summary: "获取角色关系图，nodes 为角色，edges 为角色之间的关系（父母、对手、恋人等），在角色提取阶段生成，合并角色后来源角色的关系转给目标角色",
+			Result: api.RoleGraph{}},
+		{Method: http.MethodPost, Path: v + "/documents/:document_id/roles:action", Tag: "Role", Summary: "角色集合操作，action 为 :merge 或 :extract。:merge 合并重复角色，source_ids 合并到 target_id 后删除，场景描述中的来源角色名改为目标角色名，consolidate 时由大模型综合角色描述；" +
+			":extract 无请求体，按当前章节内容重新提取角色，同名角色只补全空字段，新角色追加到文档，返回 created、updated 和全部角色，角色提取阶段未完成时返回 409。并发超限时返回 202 和任务",
+			Body: api.MergeRolesArgs{}, Result: api.Role{}},
+		{Method: http.MethodPut, Path: v + "/roles/:id", Tag: "Role", Summary: "修改角色。regenerate_scenes 为 true 且外貌、性别或参考立绘有变化时，出现该角色的场景标记为媒体过期，" +
+			"文档已处理结束时回到图片生成阶段重新生成，regenerating_scenes 为排队的场景数",
+			Body: api.UpdateRoleArgs{}, Result: api.Role{}},
+
+		// Scene
+		{Method: http.MethodGet, Path: v + "/documents/:document_id/scenes", Tag: "Scene", Summary: "列取文档场景，可按场景拆分时提取的地点、时间段、氛围和出场人物筛选",
+			Header: ifNoneMatch, Query: sceneFilters, Result: api.ListScenesResult{}},
+		{Method: http.MethodGet, Path: v + "/documents/:document_id/manifest", Tag: "Scene", Summary: "获取播放清单",
+			Result: api.Manifest{}},
+		{Method: http.MethodGet, Path: v + "/documents/:document_id/runs", Tag: "Scene", Summary: "列取文档的处理记录，文档每次处理结束时记录一次",
+			Result: api.ListDocumentRunsResult{}},
+		{Method: http.MethodGet, Path: v + "/documents/:document_id/runs/:a/compare/:b", Tag: "Scene", Summary: "逐场景对比两次处理结果（a 为旧、b 为新的处理序号），用于决定是否回滚到旧媒体",
+			Result: api.RunComparison{}},
+
+		// Export
+		{Method: http.MethodGet, Path: v + "/documents/:document_id/export", Tag: "Export", Summary: "同步导出文档，响应为导出产物本身。format 为 bundle 时为 JSON 文档包（DocumentBundle），包含文档、章节、角色、场景和媒体 url，可通过 POST /documents:import 导入到其他环境",
+			Query:    []openapi.Parameter{{Name: "format", Description: "导出格式 bundle|zip，默认 bundle", Schema: str}},
+			Produces: "application/json"},
+		{Method: http.MethodPost, Path: v + "/documents/:document_id/exports", Tag: "Export", Summary: "创建导出任务，format 支持 zip、bundle，任务异步执行",
+			Header: idempotent, Body: api.CreateExportArgs{}, Result: api.Export{}},
+		{Method: http.MethodGet, Path: v + "/documents/:document_id/exports", Tag: "Export", Summary: "列取文档的导出任务",
+			Result: api.ListExportsResult{}},
+		{Method: http.MethodGet, Path: v + "/exports/:id/download", Tag: "Export", Summary: "下载导出产物",
+			Produces: "application/octet-stream"},
+		{Method: http.MethodDelete, Path: v + "/exports/:id", Tag: "Export", Summary: "取消待执行或执行中的任务，其他状态删除任务和产物"},
+		{Method: http.MethodGet, Path: v + "/chapters/:chapter_id/scenes", Tag: "Scene", Summary: "列取章节场景，可按场景拆分时提取的地点、时间段、氛围和出场人物筛选",
+			Header: ifNoneMatch, Query: sceneFilters, Result: api.ListScenesResult{}},
+		{Method: http.MethodPost, Path: v + "/chapters/:chapter_id/scenes", Tag: "Scene", Summary: "在章节中插入用户编写的场景，insert_after 为空时插入到章节开头，后续场景序号加一",
+			Header: idempotent, Body: api.CreateSceneArgs{}, Result: api.Scene{}},
+		{Method: http.MethodPut, Path: v + "/scenes/:id", Tag: "Scene", Summary: "修改场景并重新生成图片和语音，并发超限或请求异步执行时返回 202 和任务，通过 /jobs/:id 轮询",
+			Header: []openapi.Parameter{idempotent[0], respondAsync}, Body: api.UpdateSceneArgs{}, Result: api.Scene{}},
+		{Method: http.MethodPatch, Path: v + "/scenes/:id", Tag: "Scene", Summary: "只修改场景，不立即重新生成。内容变化时已有的图片和语音标记为过期（media_stale），" +
+			"文档开启 auto_regenerate 且已处理结束时回到图片生成阶段，在后台重新生成过期场景",
+			Header: idempotent, Body: api.UpdateSceneArgs{}, Result: api.Scene{}},
+		{Method: http.MethodGet, Path: v + "/scenes/:id/generations", Tag: "Scene", Summary: "列取场景保留的历史图片和语音生成",
+			Result: api.ListSceneGenerationsResult{}},
+		{Method: http.MethodGet, Path: v + "/scenes/:id/prompt-preview", Tag: "Scene", Summary: "预览生成场景图片时发送给图片服务的提示词，包含模板渲染、风格预设和角色外貌，不生成图片，不消耗配额",
+			Result: api.ScenePromptPreview{}},
+		{Method: http.MethodPost, Path: v + "/scenes/:id/generations/:n/activate", Tag: "Scene", Summary: "将场景的图片和语音回滚为第 n 次生成",
+			Result: api.Scene{}},
+		{Method: http.MethodGet, Path: v + "/scenes/:id/image/history", Tag: "Scene", Summary: "列取场景保留的历史图片版本",
+			Result: api.ListSceneImageVersionsResult{}},
+		{Method: http.MethodPost, Path: v + "/scenes/:id/image:action", Tag: "Scene", Summary: "场景图片操作。action 为 :upscale 时使用配置的超分服务放大当前图片，结果保存为 hd_image_url 供导出视频使用，计入当日图片配额；" +
+			"为 :revert 时将图片回退为指定的历史版本，body 为 RevertSceneImageArgs，语音不变",
+			Body: api.UpscaleSceneImageArgs{}, Result: api.Scene{}},
+		{Method: http.MethodGet, Path: v + "/scenes/:id/placeholder/image", Tag: "Scene", Summary: "失败场景的占位图片",
+			Produces: "image/svg+xml"},
+		{Method: http.MethodGet, Path: v + "/scenes/:id/placeholder/voice", Tag: "Scene", Summary: "失败场景的占位静音",
+			Produces: "audio/wav"},
+		{Method: http.MethodGet, Path: v + "/audio/:name", Tag: "Scene", Summary: "非百炼语音服务生成的场景语音和场景字幕",
+			Produces: "audio/wav"},
+		{Method: http.MethodGet, Path: v + "/images/:name", Tag: "Scene", Summary: "非百炼图片服务生成的场景图片",
+			Produces: "image/png"},
+
+		// Reading
+		{Method: http.MethodGet, Path: v + "/documents/:document_id/bookmarks", Tag: "Reading", Summary: "列取书签",
+			Result: api.ListBookmarksResult{}},
+		{Method: http.MethodPost, Path: v + "/documents/:document_id/bookmarks", Tag: "Reading", Summary: "创建书签",
+			Body: api.CreateBookmarkArgs{}, Result: api.Bookmark{}},
+		{Method: http.MethodDelete, Path: v + "/documents/:document_id/bookmarks/:id", Tag: "Reading", Summary: "删除书签"},
+		{Method: http.MethodGet, Path: v + "/documents/:document_id/position", Tag: "Reading", Summary: "获取阅读位置",
+			Result: api.ReadPosition{}},
+		{Method: http.MethodPut, Path: v + "/documents/:document_id/position", Tag: "Reading", Summary: "更新阅读位置",
+			Body: api.UpdateReadPositionArgs{}},
+
+		// Search
+		{Method: http.MethodGet, Path: v + "/search", Tag: "Search", Summary: "按关键字搜索场景，索引异步构建，存在短暂延迟",
+			Query: []openapi.Parameter{
+				{Name: "q", Required: true, Description: "关键字，忽略大小写", Schema: str},
+				{Name: "limit", Description: "返回条数，默认 20，最大 100", Schema: integer},
+			},
+			Result: api.SearchResult{}},
+		{Method: http.MethodGet, Path: v + "/documents/:document_id/search", Tag: "Search", Summary: "在文档的章节中按语义搜索，按相似度降序返回章节，需配置向量服务",
+			Query: []openapi.Parameter{
+				{Name: "q", Required: true, Description: "查询文本，最多 500 字", Schema: str},
+				{Name: "limit", Description: "返回条数，默认 5，最大 20", Schema: integer},
+			},
+			Result: api.SemanticSearchResult{}},
+		{Method: http.MethodPost, Path: v + "/documents/:document_id/ask", Tag: "Search", Summary: "检索与问题语义最相近的章节，由百炼根据章节内容回答，返回回答、引用的章节 id 和参考的章节片段，需配置向量服务。并发超限或请求异步执行时返回 202 和任务",
+			Header: []openapi.Parameter{respondAsync}, Body: api.AskDocumentArgs{}, Result: api.AskDocumentResult{}},
+
+		// Webhook
+		{Method: http.MethodPost, Path: v + "/webhooks", Tag: "Webhook", Summary: "注册回调，事件 POST 到 url，X-Imgagent-Signature 为 sha256=hex(HMAC-SHA256(secret, timestamp.body))，template 可自定义 body（如钉钉机器人消息）",
+			Body: api.CreateWebhookArgs{}, Result: api.Webhook{}},
+		{Method: http.MethodGet, Path: v + "/webhooks", Tag: "Webhook", Summary: "列取回调",
+			Query: []openapi.Parameter{
+				{Name: "document_id", Description: "只列取该文档的回调", Schema: str},
+			},
+			Result: api.ListWebhooksResult{}},
+		{Method: http.MethodDelete, Path: v + "/webhooks/:id", Tag: "Webhook", Summary: "删除回调"},
+
+		// Tenant
+		{Method: http.MethodGet, Path: v + "/tenants/:user_id/url_policy", Tag: "Tenant", Summary: "获取租户通过 URL 创建文档的来源策略，需超级管理员",
+			Result: api.URLPolicy{}},
+		{Method: http.MethodPut, Path: v + "/tenants/:user_id/url_policy", Tag: "Tenant", Summary: "设置租户的 URL 来源策略，需超级管理员",
+			Body: api.UpdateURLPolicyArgs{}, Result: api.URLPolicy{}},
+		{Method: http.MethodDelete, Path: v + "/tenants/:user_id/url_policy", Tag: "Tenant", Summary: "删除租户的 URL 来源策略，恢复为默认策略，需超级管理员"},
+
+		// Admin
+		{Method: http.MethodGet, Path: v + "/admin/failed-jobs", Tag: "Admin", Summary: "列取重试耗尽后永久失败的文档或场景，需超级管理员",
+			Query: []openapi.Parameter{
+				{Name: "type", Description: "document|scene，默认 document", Schema: str},
+				{Name: "marker", Description: "上一页返回的 next_marker", Schema: str},
+				{Name: "limit", Description: "每页条数，默认 20，最大 100", Schema: integer},
+			},
+			Result: api.ListFailedJobsResult{}},
+		{Method: http.MethodPost, Path: v + "/admin/failed-jobs/documents/:id/requeue", Tag: "Admin", Summary: "失败的文档从失败阶段重新处理，图片阶段失败时同时重新生成失败的场景，需超级管理员",
+			Header: idempotent, Result: api.Document{}},
+		{Method: http.MethodPost, Path: v + "/admin/failed-jobs/scenes/:id/requeue", Tag: "Admin", Summary: "重新生成永久失败的场景，需超级管理员",
+			Header: idempotent, Result: api.Scene{}},
+		{Method: http.MethodGet, Path: v + "/admin/moderation/scenes", Tag: "Admin", Summary: "列取未通过内容审核、等待复核的场景，需超级管理员",
+			Query: []openapi.Parameter{
+				{Name: "marker", Description: "上一页返回的 next_marker", Schema: str},
+				{Name: "limit", Description: "每页条数，默认 20，最大 100", Schema: integer},
+			},
+			Result: api.ListBlockedScenesResult{}},
+		{Method: http.MethodPost, Path: v + "/admin/moderation/scenes/:id/approve", Tag: "Admin", Summary: "复核放行未通过审核的场景，使用扣留的图片重新发布，不再审核，需超级管理员",
+			Header: idempotent, Result: api.Scene{}},
+		{Method: http.MethodPost, Path: v + "/admin/moderation/scenes/:id/regenerate", Tag: "Admin", Summary: "丢弃未通过审核的场景图片，重新生成后再次审核，需超级管理员",
+			Header: idempotent, Result: api.Scene{}},
+		{Method: http.MethodGet, Path: v + "/admin/prompt-templates", Tag: "Admin", Summary: "列取角色提取、场景拆分和图片生成的提示词模板，未自定义的返回默认模板，需超级管理员",
+			Result: api.ListPromptTemplatesResult{}},
+		{Method: http.MethodGet, Path: v + "/admin/prompt-templates/:kind", Tag: "Admin", Summary: "获取提示词模板，kind 为 role|scene|image，需超级管理员",
+			Result: api.PromptTemplate{}},
+		{Method: http.MethodPut, Path: v + "/admin/prompt-templates/:kind", Tag: "Admin", Summary: "创建或覆盖提示词模板，使用 Go text/template 语法，保存前试渲染校验，需超级管理员",
+			Body: api.UpdatePromptTemplateArgs{}, Result: api.PromptTemplate{}},
+		{Method: http.MethodDelete, Path: v + "/admin/prompt-templates/:kind", Tag: "Admin", Summary: "删除自定义的提示词模板，恢复为默认模板，需超级管理员",
+			Result: api.PromptTemplate{}},
+		{Method: http.MethodPost, Path: v + "/admin/selftest", Tag: "Admin", Summary: "用内置的短篇文档走一遍分割、上传、摘要、角色、场景、图片和语音流程，返回各阶段结果和耗时，不创建文档，需超级管理员",
+			Query: []openapi.Parameter{
+				{Name: "provider", Description: "mock|real，默认 mock。mock 使用模拟服务，只验证本实例；real 调用实际配置的服务，会产生少量费用", Schema: str},
+			},
+			Result: api.SelfTestResult{}},
+		{Method: http.MethodGet, Path: v + "/admin/audit-logs", Tag: "Admin", Summary: "按时间倒序列取文档、章节、场景、角色的修改记录，包含修改前后的快照，需超级管理员",
+			Query: []openapi.Parameter{
+				{Name: "resource_type", Description: "document|chapter|scene|role|chapters|roles", Schema: str},
+				{Name: "resource_id", Description: "资源 id，chapters、roles 为文档 id", Schema: str},
+				{Name: "document_id", Description: "资源所属的文档 id", Schema: str},
+				{Name: "user_id", Description: "操作用户 id", Schema: integer},
+				{Name: "marker", Description: "上一页返回的 next_marker", Schema: str},
+				{Name: "limit", Description: "每页条数，默认 20，最大 100", Schema: integer},
+			},
+			Result: api.ListAuditLogsResult{}},
+		{Method: http.MethodPost, Path: v + "/admin/backup", Tag: "Admin", Summary: "把全部表的数据快照保存到 storage 配置的对象存储，include_media 时同时保存本地图片和语音文件清单；同一时间只能执行一个备份或恢复，否则返回 409，需超级管理员",
+			Body: api.CreateBackupArgs{}, Result: api.Backup{}},
+		{Method: http.MethodPost, Path: v + "/admin/restore", Tag: "Admin", Summary: "用备份替换全部表的数据，在一个事务中执行，失败时不修改数据；快照的迁移版本需与当前库一致，否则返回 400。" +
+			"dry_run 时只校验快照并统计行数。非 dry_run 时需在配置中开启 backup.allow_restore（否则返回 403），且 confirm 与 id 相同（否则返回 400）。" +
+			"备份包含媒体文件清单时返回本地缺失的文件。恢复不会停止后台任务，应在维护窗口中执行，需超级管理员",
+			Body: api.RestoreBackupArgs{}, Result: api.RestoreResult{}},
+		{Method: http.MethodGet, Path: v + "/admin/config", Tag: "Admin", Summary: "获取可在运行时修改的配置（日志级别、限流、文档处理并发、百炼模型）的当前生效值，配置文件修改后按 reload_interval_secs 自动加载，需超级管理员",
+			Result: api.RuntimeConfig{}},
+		{Method: http.MethodPost, Path: v + "/admin/bgm", Tag: "Admin", Summary: "上传背景音乐到 storage 配置的对象存储并加入曲目库，需超级管理员",
+			Form: []openapi.Parameter{
+				{Name: "name", Required: true, Description: "曲目名称，最多 100 个字符", Schema: str},
+				{Name: "volume", Description: "视频合成时叠加在旁白下的音量 0~1，默认 0.2", Schema: &openapi.Schema{Type: "number"}},
+				{Name: "file", Required: true, Description: "音频文件 mp3|wav|m4a|aac|ogg", Schema: &openapi.Schema{Type: "string", Format: "binary"}},
+			},
+			Result: api.BGMTrack{}},
+		{Method: http.MethodDelete, Path: v + "/admin/bgm/:id", Tag: "Admin", Summary: "从曲目库删除背景音乐，选用该曲目的文档的播放清单不再包含背景音乐，需超级管理员"},
+
+		// BGM
+		{Method: http.MethodGet, Path: v + "/bgm", Tag: "BGM", Summary: "列取背景音乐曲目，通过 PUT /documents/{document_id} 的 bgm_id 为文档选用",
+			Result: api.ListBGMTracksResult{}},
+
+		// Quota
+		{Method: http.MethodGet, Path: v + "/quota", Tag: "Quota", Summary: "获取配额上限与用量。该接口及上传文档、修改场景的响应在用量越过预警线时返回 X-Quota-Warning 头，如 images_per_day=92, documents=80",
+			Result: api.GetQuotaResult{}},
+		{Method: http.MethodGet, Path: v + "/limits", Tag: "Quota", Summary: "获取当前实例生效的上传文件类型与大小、章节分割、并发、文档名规则和配额上限与剩余量，便于客户端提交前校验",
+			Result: api.Limits{}},
+
+		// Activity
+		{Method: http.MethodGet, Path: v + "/activity", Tag: "Activity", Summary: "按时间倒序列取动态",
+			Query: []openapi.Parameter{
+				{Name: "marker", Description: "上一页返回的 next_marker", Schema: str},
+				{Name: "limit", Description: "每页条数，默认 20，最大 100", Schema: integer},
+			},
+			Result: api.ListActivityResult{}},
+
+		// Stats
+		{Method: http.MethodGet, Path: v + "/stats/summary", Tag: "Stats", Summary: "获取当前租户的文档状态分布、本月生成量、平均处理耗时和排队情况",
+			Result: api.StatsSummary{}},
+
+		// Job
+		{Method: http.MethodGet, Path: v + "/jobs/:id", Tag: "Job", Summary: "获取排队任务的执行结果",
+			Result: api.Job{}},
+
+		// Operation
+		{Method: http.MethodGet, Path: v + "/operations/:id", Tag: "Operation", Summary: "获取导出、批量导入、排队请求或文档处理的统一操作状态，id 为对应资源的 id",
+			Result: api.Operation{}},
+	}
+}
+
+// HandleOpenAPI 返回 OpenAPI 3 文档
+func (s *Service) HandleOpenAPI(c *gin.Context) {
+	c.JSON(http.StatusOK, s.openAPI)
+}
+
+// HandleSwaggerUI 返回 Swagger UI 页面
+func (s *Service) HandleSwaggerUI(c *gin.Context) {
+	c.Data(http.StatusOK, "text/html; charset=utf-8", openapi.SwaggerUI("imgagent API", openAPIPath, swaggerAssetsPath))
+}
+
+// HandleSwaggerAssets 返回内嵌的 Swagger UI 静态资源
+func (s *Service) HandleSwaggerAssets(c *gin.Context) {
+	c.FileFromFS(c.Param("filepath"), swaggerUIAssets)
+}
@@ -0,0 +1,199 @@
+package svr
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"gorm.io/gorm"
+
+	"imgagent/api"
+	"imgagent/db"
+	hutil "imgagent/httputil"
+	"imgagent/pkg/logger"
+)
+
+// operationURL 统一操作资源的地址，baseURL 为对外地址加 API 版本
+func operationURL(baseURL, id string) string {
+	return baseURL + "/operations/" + id
+}
+
+// baseURL 对外地址加 API 版本，用于生成返回给客户端的资源地址
+func (s *Service) baseURL() string {
+	return s.conf.PublicURL + s.conf.APIVersion
+}
+
+// HandleGetOperation 获取长时间运行操作的状态，客户端用同一方式轮询导出、批量导入、排队请求和文档处理
+func (s *Service) HandleGetOperation(c *gin.Context) {
+	ui := GetUserInfo(c)
+
+	id := c.Param("id")
+	logger.FromGinContext(c).Infof("Get operation, userID: %d, id: %s", ui.ID, id)
+	op, err := s.GetOperation(c.Request.Context(), ui.ID, id)
+	if err != nil {
+		hutil.AbortErr(c, err)
+		return
+	}
+	hutil.WriteData(c, op)
+}
+
+// GetOperation 依次按排队任务、导出、批量导入、文档查找 id，其他租户的资源视为不存在
+func (s *Service) GetOperation(ctx context.Context, userID int64, id string) (*api.Operation, error) {
+	log := logger.FromContext(ctx)
+	notFound := hutil.NewApiError(http.StatusNotFound, "operation not found")
+
+	if job, ok := s.limiter.Get(userID, id); ok {
+		op := jobOperation(&job)
+		return &op, nil
+	}
+
+	e, err := s.db.GetExport(ctx, id)
+	if err == nil {
+		if e.UserID != userID {
+			return nil, notFound
+		}
+		op := exportOperation(&e, s.baseURL())
+		return &op, nil
+	}
+	if !errors.Is(err, gorm.ErrRecordNotFound) {
+		log.Errorf("Failed to get export, id: %s, err: %v", id, err)
+		return nil, hutil.NewApiError(hutil.ErrServerInternalCode, "get operation failed")
+	}
+
+	batch, err := s.db.GetImportBatch(ctx, id)
+	if err == nil {
+		if batch.UserID != userID {
+			return nil, notFound
+		}
+		items, err := s.db.ListImportItems(ctx, id)
+		if err != nil {
+			log.Errorf("Failed to list import items, id: %s, err: %v", id, err)
+			return nil, hutil.NewApiError(hutil.ErrServerInternalCode, "get operation failed")
+		}
+		op := importOperation(&batch, items, s.baseURL())
+		return &op, nil
+	}
+	if !errors.Is(err, gorm.ErrRecordNotFound) {
+		log.Errorf("Failed to get import batch, id: %s, err: %v", id, err)
+		return nil, hutil.NewApiError(hutil.ErrServerInternalCode, "get operation failed")
+	}
+
+	doc, err := s.db.GetDocument(ctx, id)
+	if err == nil && doc.UserID != userID {
+		err = gorm.ErrRecordNotFound
+	}
+	if err != nil {
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			return nil, notFound
+		}
+		log.Errorf("Failed to get document, id: %s, err: %v", id, err)
+		return nil, hutil.NewApiError(hutil.ErrServerInternalCode, "get operation failed")
+	}
+	p, err := documentProgress(ctx, s.db, id, "")
+	if err != nil {
+		log.Errorf("Failed to get document progress, id: %s, err: %v", id, err)
+		return nil, hutil.NewApiError(hutil.ErrServerInternalCode, "get operation failed")
+	}
+	op := documentOperation(&doc, p.Percent, s.baseURL())
+	return &op, nil
+}
+
+func jobOperation(job *api.Job) api.Operation {
+	op := api.Operation{
+		ID:     job.ID,
+		Kind:   api.OperationKindJob,
+		Status: api.OperationStatusRunning,
+		Result: job.Result,
+		Code:   job.Code,
+		Error:  job.Message,
+	}
+	switch job.Status {
+	case JobStatusQueued:
+		op.Status = api.OperationStatusPending
+	case JobStatusSucceeded:
+		op.Status = api.OperationStatusSucceeded
+	case JobStatusFailed:
+		op.Status = api.OperationStatusFailed
+	}
+	if op.Status == api.OperationStatusSucceeded || op.Status == api.OperationStatusFailed {
+		op.Progress = 100
+	}
+	return op
+}
+
+func exportOperation(e *db.Export, baseURL string) api.Operation {
+	op := api.Operation{
+		ID:        e.ID,
+		Kind:      api.OperationKindExport,
+		Status:    e.Status,
+		Error:     e.Error,
+		CreatedAt: e.CreatedAt.Format(time.DateTime),
+		UpdatedAt: e.UpdatedAt.Format(time.DateTime),
+	}
+	// 导出状态与操作状态同名
+	if e.Status != db.ExportStatusPending && e.Status != db.ExportStatusRunning {
+		op.Progress = 100
+	}
+	if e.Status == db.ExportStatusSucceeded {
+		op.ResultURL = baseURL + "/exports/" + e.ID + "/download"
+	}
+	return op
+}
+
+func importOperation(batch *db.ImportBatch, items []db.ImportItem, baseURL string) api.Operation {
+	op := api.Operation{
+		ID:        batch.ID,
+		Kind:      api.OperationKindImport,
+		Status:    api.OperationStatusRunning,
+		ResultURL: baseURL + "/imports/" + batch.ID,
+		CreatedAt: batch.CreatedAt.Format(time.DateTime),
+		UpdatedAt: batch.UpdatedAt.Format(time.DateTime),
+	}
+	done, failed := 0, 0
+	for _, item := range items {
+		switch item.Status {
+		case db.ImportItemStatusCreated:
+			done++
+		case db.ImportItemStatusFailed:
+			done++
+			failed++
+		}
+	}
+	if len(items) > 0 {
+		op.Progress = 100 * done / len(items)
+	}
+	if batch.Status == db.ImportBatchStatusFinished {
+		// 部分文件失败时导入仍视为成功，每个文件的结果见 ResultURL
+		op.Status = api.OperationStatusSucceeded
+		op.Progress = 100
+		if failed > 0 {
+			op.Error = fmt.Sprintf("%d/%d files failed", failed, len(items))
+		}
+	}
+	return op
+}
+
+func documentOperation(doc *db.Document, percent int, baseURL string) api.Operation {
+	op := api.Operation{
+		ID:        doc.ID,
+		Kind:      api.OperationKindDocument,
+		Status:    api.OperationStatusRunning,
+		Progress:  percent,
+		ResultURL: baseURL + "/documents/" + doc.ID,
+		CreatedAt: doc.CreatedAt.Format(time.DateTime),
+		UpdatedAt: doc.UpdatedAt.Format(time.DateTime),
+	}
+	switch doc.Status {
+	case db.DocumentStatusImgReady, db.DocumentStatusCompletedWithErrors:
+		op.Status = api.OperationStatusSucceeded
+	case db.DocumentStatusFailed:
+		op.Status = api.OperationStatusFailed
+		op.Error = doc.LastError
+	case db.DocumentStatusCanceled:
+		op.Status = api.OperationStatusCanceled
+	}
+	return op
+}
@@ -0,0 +1,62 @@
+package svr
+
+import (
+	"errors"
+	"net/http"
+	"strconv"
+
+	"github.com/gin-gonic/gin"
+	"gorm.io/gorm"
+
+	hutil "imgagent/httputil"
+	"imgagent/pkg/logger"
+	"imgagent/placeholder"
+)
+
+// placeholderURLs 生成场景占位图片和占位语音的 URL
+func placeholderURLs(baseURL, sceneID string) (string, string) {
+	prefix := baseURL + "/scenes/" + sceneID + "/placeholder"
+	return prefix + "/image", prefix + "/voice"
+}
+
+// HandleGetPlaceholderImage 返回场景的占位卡片图片（SVG）
+func (s *Service) HandleGetPlaceholderImage(c *gin.Context) {
+	ctx := c.Request.Context()
+	log := logger.FromGinContext(c)
+
+	sceneID := c.Param("id")
+	scene, err := s.db.GetScene(ctx, sceneID)
+	if err != nil {
+		log.Errorf("Failed to get scene, id: %s, err: %v", sceneID, err)
+		placeholderErr(c, err)
+		return
+	}
+
+	c.Data(http.StatusOK, "image/svg+xml", placeholder.Image(scene.Content))
+}
+
+// HandleGetPlaceholderVoice 返回场景的占位静音音频（WAV），时长按场景文字估算
+func (s *Service) HandleGetPlaceholderVoice(c *gin.Context) {
+	ctx := c.Request.Context()
+	log := logger.FromGinContext(c)
+
+	sceneID := c.Param("id")
+	scene, err := s.db.GetScene(ctx, sceneID)
+	if err != nil {
+		log.Errorf("Failed to get scene, id: %s, err: %v", sceneID, err)
+		placeholderErr(c, err)
+		return
+	}
+
+	wav := placeholder.SilentWAV(placeholder.Duration(scene.Content))
+	c.Header("Content-Length", strconv.Itoa(len(wav)))
+	c.Data(http.StatusOK, "audio/wav", wav)
+}
+
+func placeholderErr(c *gin.Context, err error) {
+	if errors.Is(err, gorm.ErrRecordNotFound) {
+		hutil.AbortError(c, http.StatusNotFound, "scene not found")
+		return
+	}
+	hutil.AbortError(c, http.StatusInternalServerError, "get scene failed")
+}
@@ -0,0 +1,293 @@
+package svr
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"slices"
+	"time"
+
+	"github.com/gin-gonic/gin"
+
+	"imgagent/api"
+	"imgagent/db"
+	hutil "imgagent/httputil"
+	"imgagent/pkg/logger"
+	"imgagent/pkg/pubsub"
+)
+
+// 进度事件
+const (
+	ProgressSnapshot         = "snapshot"
+	ProgressRolesExtracted   = "roles.extracted"
+	ProgressScenesSplit      = "scenes.split"
+	ProgressSceneGenerated   = "scene.generated"
+	ProgressSceneFailed      = "scene.failed"
+	ProgressSceneBlocked     = "scene.blocked"
+	ProgressDocumentPaused   = "document.paused"
+	ProgressDocumentResumed  = "document.resumed"
+	ProgressDocumentRequeued = "document.requeued"
+	ProgressDocumentFinished = "document.finished"
+	ProgressDocumentFailed   = "document.failed"
+	ProgressDocumentCanceled = "document.canceled"
+)
+
+// progressHeartbeat SSE 心跳间隔，避免连接被代理因空闲断开
+var progressHeartbeat = 15 * time.Second
+
+func progressChannel(docID string) string {
+	return "imgagent:progress:" + docID
+}
+
+// documentFinished 文档是否已处理结束，结束后不再有进度
+func documentFinished(status string) bool {
+	return slices.Contains(db.DocumentFinishedStatuses, status)
+}
+
+// documentProgress 根据数据库中的文档、角色和场景计算进度。
+// 章节切分 10%，角色提取 20%，场景切分 30%，剩余 70% 按已生成（含永久失败）的场景比例计算
+func documentProgress(ctx context.Context, database db.IDataBase, docID string, event string) (api.DocumentProgress, error) {
+	doc, err := database.GetDocument(ctx, docID)
+	if err != nil {
+		return api.DocumentProgress{}, err
+	}
+	chapters, err := database.CountChapters(ctx, docID)
+	if err != nil {
+		return api.DocumentProgress{}, err
+	}
+	roles, err := database.ListRolesByDocument(ctx, docID)
+	if err != nil {
+		return api.DocumentProgress{}, err
+	}
+	scenes, err := database.ListScenesByDocument(ctx, docID)
+	if err != nil {
+		return api.DocumentProgress{}, err
+	}
+
+	p := api.DocumentProgress{
+		DocumentID:   docID,
+		Event:        event,
+		Status:       doc.Status,
+		Paused:       doc.Paused,
+		ChapterCount: int(chapters),
+		RoleCount:    len(roles),
+		SceneCount:   len(scenes),
+	}
+	for _, scene := range scenes {
+		if sceneFailed(&scene) {
+			p.FailedSceneCount++
+		} else if scene.ImageURL != "" {
+			p.ReadySceneCount++
+		}
+	}
+
+	p.Percent = progressPercent(doc.Status, p.SceneCount, p.ReadySceneCount+p.FailedSceneCount)
+	return p, nil
+}
+
+// progressPercent 按状态和已生成（含永久失败）的场景数计算进度百分比
+func progressPercent(status string, sceneCount, doneSceneCount int) int {
+	switch {
+	case status == db.DocumentStatusCanceled:
+		// 取消后停在取消时的进度，按已有的场景估算
+		if sceneCount > 0 {
+			return 30 + 70*doneSceneCount/sceneCount
+		}
+		return 10
+	case documentFinished(status):
+		return 100
+	case status == db.DocumentStatusSceneReady:
+		if sceneCount > 0 {
+			return 30 + 70*doneSceneCount/sceneCount
+		}
+		return 30
+	case status == db.DocumentStatusRoleReady:
+		return 20
+	default:
+		return 10
+	}
+}
+
+// processingProgress 文档的结构化处理进度：当前阶段、各阶段的完成数和开始、完成时间以及最近一次失败原因
+func processingProgress(ctx context.Context, database db.IDataBase, docID string) (api.ProcessingProgress, error) {
+	doc, err := database.GetDocument(ctx, docID)
+	if err != nil {
+		return api.ProcessingProgress{}, err
+	}
+	chapters, err := database.ListChapters(ctx, docID)
+	if err != nil {
+		return api.ProcessingProgress{}, err
+	}
+	roles, err := database.ListRolesByDocument(ctx, docID)
+	if err != nil {
+		return api.ProcessingProgress{}, err
+	}
+	scenes, err := database.ListScenesByDocument(ctx, docID)
+	if err != nil {
+		return api.ProcessingProgress{}, err
+	}
+
+	p := api.ProcessingProgress{
+		DocumentID: docID,
+		Status:     doc.Status,
+		Paused:     doc.Paused,
+		LastError:  doc.LastError,
+	}
+	p.Stages = make([]api.StageProgress, 0, len(db.Stages))
+	for _, stage := range db.Stages {
+		sp := api.StageProgress{Stage: stage}
+		if t, ok := doc.Stages[stage]; ok {
+			if t.StartedAt != nil {
+				sp.StartedAt = t.StartedAt.Format(time.DateTime)
+			}
+			if t.FinishedAt != nil {
+				sp.FinishedAt = t.FinishedAt.Format(time.DateTime)
+			} else if t.StartedAt != nil && p.Stage == "" {
+				p.Stage = stage
+			}
+		}
+		p.Stages = append(p.Stages, sp)
+	}
+	counts := make(map[string]*api.StageProgress, len(p.Stages))
+	for i := range p.Stages {
+		counts[p.Stages[i].Stage] = &p.Stages[i]
+	}
+
+	counts[db.StageSplitting].Done, counts[db.StageSplitting].Total = len(chapters), len(chapters)
+	counts[db.StageRoles].Done, counts[db.StageRoles].Total = len(roles), len(roles)
+	counts[db.StageScenes].Total = len(chapters)
+	for _, chapter := range chapters {
+		if len(chapter.SceneIDs) > 0 {
+			counts[db.StageScenes].Done++
+		}
+	}
+	counts[db.StageImages].Total, counts[db.StageVoices].Total = len(scenes), len(scenes)
+	var lastFailed *db.Scene
+	for i, scene := range scenes {
+		failed := sceneFailed(&scene)
+		if failed || scene.ImageURL != "" {
+			counts[db.StageImages].Done++
+		}
+		if failed || scene.VoiceURL != "" {
+			counts[db.StageVoices].Done++
+		}
+		if scene.Error != "" && (lastFailed == nil || scene.UpdatedAt.After(lastFailed.UpdatedAt)) {
+			lastFailed = &scenes[i]
+		}
+	}
+	if p.LastError == "" && lastFailed != nil {
+		p.LastError = lastFailed.Error
+	}
+	p.Percent = progressPercent(doc.Status, len(scenes), counts[db.StageImages].Done)
+	return p, nil
+}
+
+// publishProgress 发布文档最新进度，失败只记录日志，不影响文档处理
+func (m *DocumentMgr) publishProgress(ctx context.Context, docID string, event string) {
+	publishProgress(ctx, m.pubsub, m.db, docID, event)
+}
+
+func publishProgress(ctx context.Context, ps pubsub.PubSub, database db.IDataBase, docID string, event string) {
+	if ps == nil {
+		return
+	}
+	log := logger.FromContext(ctx)
+
+	p, err := documentProgress(ctx, database, docID, event)
+	if err != nil {
+		log.Errorf("Failed to get document progress, doc: %s, err: %v", docID, err)
+		return
+	}
+	msg, err := json.Marshal(p)
+	if err != nil {
+		log.Errorf("Failed to marshal document progress, err: %v", err)
+		return
+	}
+	err = ps.Publish(ctx, progressChannel(docID), msg)
+	if err != nil {
+		log.Errorf("Failed to publish document progress, doc: %s, event: %s, err: %v", docID, event, err)
+	}
+}
+
+// HandleGetDocumentProgress 获取文档的结构化处理进度，用于展示进度条
+func (s *Service) HandleGetDocumentProgress(c *gin.Context) {
+	ctx := c.Request.Context()
+	log := logger.FromGinContext(c)
+
+	docID := c.Param("document_id")
+	if docID == "" {
+		hutil.AbortError(c, http.StatusBadRequest, "invalid doc id")
+		return
+	}
+	p, err := processingProgress(ctx, s.db, docID)
+	if err != nil {
+		log.Errorf("Failed to get document progress, id: %s, err: %v", docID, err)
+		documentErr(c, err, "get document failed")
+		return
+	}
+	hutil.WriteData(c, p)
+}
+
+// HandleDocumentEvents 以 SSE 推送文档处理进度：连接建立时先推送当前进度，之后推送 DocumentMgr 发布的进度，
+// 文档处理结束后关闭连接
+func (s *Service) HandleDocumentEvents(c *gin.Context) {
+	ctx := c.Request.Context()
+	log := logger.FromGinContext(c)
+
+	docID := c.Param("document_id")
+	if docID == "" {
+		hutil.AbortError(c, http.StatusBadRequest, "invalid doc id")
+		return
+	}
+
+	log.Infof("Subscribe document events, docID: %s", docID)
+	// 先订阅再取当前进度，避免两者之间发布的进度丢失
+	msgs, cancel, err := s.pubsub.Subscribe(ctx, progressChannel(docID))
+	if err != nil {
+		log.Errorf("Failed to subscribe document progress, err: %v", err)
+		hutil.AbortError(c, hutil.ErrServerInternalCode, "subscribe document events failed")
+		return
+	}
+	defer cancel()
+
+	p, err := documentProgress(ctx, s.db, docID, ProgressSnapshot)
+	if err != nil {
+		log.Errorf("Failed to get document progress, id: %s, err: %v", docID, err)
+		documentErr(c, err, "get document failed")
+		return
+	}
+
+	c.Header("Cache-Control", "no-cache")
+	c.Header("X-Accel-Buffering", "no")
+	c.SSEvent(p.Event, p)
+	c.Writer.Flush()
+	if documentFinished(p.Status) {
+		return
+	}
+
+	ticker := time.NewTicker(progressHeartbeat)
+	defer ticker.Stop()
+	c.Stream(func(w io.Writer) bool {
+		select {
+		case msg, ok := <-msgs:
+			if !ok {
+				return false
+			}
+			var p api.DocumentProgress
+			err := json.Unmarshal(msg, &p)
+			if err != nil {
+				log.Errorf("Failed to unmarshal document progress, err: %v", err)
+				return true
+			}
+			c.SSEvent(p.Event, p)
+			return !documentFinished(p.Status)
+		case <-ticker.C:
+			fmt.Fprint(w, ": ping\n\n")
+			return true
+		case <-ctx.Done():
+			return false
+		}
+	})
+}
@@ -0,0 +1,316 @@
+package svr
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"slices"
+	"strings"
+	"text/template"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"gorm.io/gorm"
+
+	"imgagent/api"
+	"imgagent/bailian"
+	"imgagent/db"
+	hutil "imgagent/httputil"
+	"imgagent/imagegen"
+	"imgagent/pkg/logger"
+)
+
+// promptVars 提示词模板可用的变量，各类模板只填充用到的部分，见 api.PromptTemplate
+type promptVars struct {
+	Document        promptDocument
+	Chapter         promptChapter
+	Scene           promptScene
+	Roles           []bailian.RoleInfo
+	FeaturedRoles   []bailian.RoleInfo // 场景描述中提到名字的角色
+	RoleConsistency bool
+}
+
+type promptDocument struct {
+	Name    string
+	Summary string
+}
+
+type promptChapter struct {
+	Index   int
+	Content string
+}
+
+type promptScene struct {
+	Index   int
+	Content string
+	Style   string
+	// Location、TimeOfDay、Mood、CharactersPresent 场景拆分时提取的属性，Content 已包含这些属性
+	Location          string
+	TimeOfDay         string
+	Mood              string
+	CharactersPresent []string
+}
+
+func newPromptDocument(doc *db.Document) promptDocument {
+	return promptDocument{Name: doc.Name, Summary: doc.Summary}
+}
+
+func newPromptScene(scene *db.Scene, content, style string) promptScene {
+	return promptScene{
+		Index:             scene.Index,
+		Content:           content,
+		Style:             style,
+		Location:          scene.Location,
+		TimeOfDay:         scene.TimeOfDay,
+		Mood:              scene.Mood,
+		CharactersPresent: scene.CharactersPresent,
+	}
+}
+
+// imagePromptVars 场景图片提示词的变量，content 为本次生成使用的、已补充场景属性的场景描述，画面风格为合并文档风格后的 opts.Style
+func imagePromptVars(doc *db.Document, scene *db.Scene, content string, opts imagegen.Options) *promptVars {
+	return &promptVars{
+		Document:        newPromptDocument(doc),
+		Scene:           newPromptScene(scene, content, opts.Style),
+		Roles:           opts.Roles,
+		FeaturedRoles:   bailian.FeaturedRoles(content, opts.Roles),
+		RoleConsistency: doc.RoleConsistency,
+	}
+}
+
+// defaultImagePromptTemplate 与不支持参考图的图片服务使用的内置提示词一致
+const defaultImagePromptTemplate = `{{if .Document.Summary}}小说概要：{{.Document.Summary}}
+
+{{end}}{{if .RoleConsistency}}{{if .FeaturedRoles}}画面中出现的角色（外貌必须与以下设定严格一致，不得改变发型、发色、五官、服饰和体型）：
+{{range .FeaturedRoles}}- {{.Name}}：性别：{{.Gender}}；外貌特征：{{.Appearance}}
+{{end}}
+{{end}}{{else if .Roles}}主要角色信息：
+{{range .Roles}}{{if .Appearance}}- {{.Name}}：性别：{{.Gender}}； 性格特点：{{.Character}}；外貌特征：{{.Appearance}}
+{{end}}{{end}}角色信息使用规则：场景描述中提到的人物需参考对应的角色信息。
+
+{{end}}根据以下场景描述生成一张动漫图片：{{.Scene.Content}}
+{{if .Scene.Style}}画面风格：{{.Scene.Style}}
+{{end}}`
+
+// defaultPromptTemplates 未自定义时返回给管理员的默认模板，与百炼未配置 role_prompt、scene_prompt 时内置的提示词一致
+var defaultPromptTemplates = map[string]string{
+	db.PromptKindRole:  "{{if .Document.Summary}}小说摘要：\n{{.Document.Summary}}\n\n{{end}}" + bailian.DefaultRolePrompt,
+	db.PromptKindScene: strings.Replace(bailian.DefaultScenePrompt, "%s", "{{.Chapter.Content}}", 1),
+	db.PromptKindImage: defaultImagePromptTemplate,
+}
+
+// samplePromptVars 保存模板前试渲染使用的变量
+var samplePromptVars = func() promptVars {
+	roles := []bailian.RoleInfo{{Name: "张三", Gender: "男", Character: "勇敢", Appearance: "浓眉大眼"}}
+	return promptVars{
+		Document:        promptDocument{Name: "示例小说", Summary: "示例摘要"},
+		Chapter:         promptChapter{Content: "示例章节"},
+		Scene:           promptScene{Content: "张三走进房间", Style: "水彩"},
+		Roles:           roles,
+		FeaturedRoles:   roles,
+		RoleConsistency: true,
+	}
+}()
+
+func executePromptTemplate(kind, content string, vars *promptVars) (string, error) {
+	tmpl, err := template.New(kind).Parse(content)
+	if err != nil {
+		return "", err
+	}
+	var b strings.Builder
+	if err = tmpl.Execute(&b, vars); err != nil {
+		return "", err
+	}
+	return b.String(), nil
+}
+
+// renderPrompt 渲染管理员自定义的提示词模板，没有自定义模板时返回空字符串，由百炼客户端使用内置的提示词
+func renderPrompt(ctx context.Context, database db.IDataBase, kind string, vars *promptVars) (string, error) {
+	tmpl, err := database.GetPromptTemplate(ctx, kind)
+	if err != nil {
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			return "", nil
+		}
+		return "", err
+	}
+	prompt, err := executePromptTemplate(kind, tmpl.Content, vars)
+	if err != nil {
+		return "", fmt.Errorf("render %s prompt template: %w", kind, err)
+	}
+	return prompt, nil
+}
+
+func makePromptTemplate(t *db.PromptTemplate) api.PromptTemplate {
+	return api.PromptTemplate{
+		Kind:      t.Kind,
+		Content:   t.Content,
+		UpdatedAt: t.UpdatedAt.Format(time.DateTime),
+	}
+}
+
+func defaultPromptTemplate(kind string) api.PromptTemplate {
+	return api.PromptTemplate{Kind: kind, Content: defaultPromptTemplates[kind], Default: true}
+}
+
+// promptKind 解析路径中的模板类型
+func promptKind(c *gin.Context) (string, bool) {
+	kind := c.Param("kind")
+	if !slices.Contains(db.PromptKinds, kind) {
+		hutil.AbortError(c, http.StatusBadRequest, "unknown prompt template kind, available: "+strings.Join(db.PromptKinds, ","))
+		return "", false
+	}
+	return kind, true
+}
+
+// HandleListPromptTemplates 列出所有类型的提示词模板，未自定义的返回默认模板
+func (s *Service) HandleListPromptTemplates(c *gin.Context) {
+	ctx := c.Request.Context()
+	log := logger.FromGinContext(c)
+
+	stored, err := s.db.ListPromptTemplates(ctx)
+	if err != nil {
+		log.Errorf("Failed to list prompt templates, err: %v", err)
+		hutil.AbortError(c, hutil.ErrServerInternalCode, "list prompt templates failed")
+		return
+	}
+	templates := make([]api.PromptTemplate, 0, len(db.PromptKinds))
+	for _, kind := range db.PromptKinds {
+		i := slices.IndexFunc(stored, func(t db.PromptTemplate) bool { return t.Kind == kind })
+		if i < 0 {
+			templates = append(templates, defaultPromptTemplate(kind))
+			continue
+		}
+		templates = append(templates, makePromptTemplate(&stored[i]))
+	}
+	hutil.WriteData(c, &api.ListPromptTemplatesResult{Templates: templates})
+}
+
+func (s *Service) HandleGetPromptTemplate(c *gin.Context) {
+	ctx := c.Request.Context()
+	log := logger.FromGinContext(c)
+
+	kind, ok := promptKind(c)
+	if !ok {
+		return
+	}
+	tmpl, err := s.db.GetPromptTemplate(ctx, kind)
+	if err != nil {
+		if !errors.Is(err, gorm.ErrRecordNotFound) {
+			log.Errorf("Failed to get prompt template, kind: %s, err: %v", kind, err)
+			hutil.AbortError(c, hutil.ErrServerInternalCode, "get prompt template failed")
+			return
+		}
+		hutil.WriteData(c, defaultPromptTemplate(kind))
+		return
+	}
+	hutil.WriteData(c, makePromptTemplate(&tmpl))
+}
+
+// HandleUpdatePromptTemplate 创建或覆盖提示词模板，保存前用示例变量试渲染，模板有误时拒绝保存
+func (s *Service) HandleUpdatePromptTemplate(c *gin.Context) {
+	ctx := c.Request.Context()
+	log := logger.FromGinContext(c)
+
+	kind, ok := promptKind(c)
+	if !ok {
+		return
+	}
+	var args api.UpdatePromptTemplateArgs
+	if err := c.ShouldBindJSON(&args); err != nil {
+		log.Errorf("Invalid request body, err: %v", err)
+		hutil.AbortError(c, http.StatusBadRequest, "invalid request body")
+		return
+	}
+	if _, err := executePromptTemplate(kind, args.Content, &samplePromptVars); err != nil {
+		hutil.AbortError(c, http.StatusBadRequest, "invalid prompt template: "+err.Error())
+		return
+	}
+
+	log.Infof("Update prompt template, kind: %s", kind)
+	tmpl := &db.PromptTemplate{Kind: kind, Content: args.Content}
+	if err := s.db.SavePromptTemplate(ctx, tmpl); err != nil {
+		log.Errorf("Failed to save prompt template, err: %v", err)
+		hutil.AbortError(c, hutil.ErrServerInternalCode, "save prompt template failed")
+		return
+	}
+	hutil.WriteData(c, makePromptTemplate(tmpl))
+}
+
+// HandleDeletePromptTemplate 删除自定义模板，恢复为默认提示词
+func (s *Service) HandleDeletePromptTemplate(c *gin.Context) {
+	ctx := c.Request.Context()
+	log := logger.FromGinContext(c)
+
+	kind, ok := promptKind(c)
+	if !ok {
+		return
+	}
+	log.Infof("Delete prompt template, kind: %s", kind)
+	if err := s.db.DeletePromptTemplate(ctx, kind); err != nil {
+		log.Errorf("Failed to delete prompt template, err: %v", err)
+		hutil.AbortError(c, hutil.ErrServerInternalCode, "delete prompt template failed")
+		return
+	}
+	hutil.WriteData(c, defaultPromptTemplate(kind))
+}
+
+// HandlePreviewScenePrompt 返回场景图片生成时发送给图片服务的提示词，只渲染不生成，不消耗配额
+func (s *Service) HandlePreviewScenePrompt(c *gin.Context) {
+	sceneID := c.Param("id")
+	logger.FromGinContext(c).Infof("Preview scene prompt, sceneID: %s", sceneID)
+	scene, ok := s.getScene(c, sceneID)
+	if !ok {
+		return
+	}
+	ret, err := s.PreviewScenePrompt(c.Request.Context(), &scene)
+	if err != nil {
+		hutil.AbortErr(c, err)
+		return
+	}
+	hutil.WriteData(c, ret)
+}
+
+// PreviewScenePrompt 与生成图片时的处理一致：合并风格预设和文档、场景的画面风格，注入角色外貌，
+// 有自定义模板时用模板渲染，最后由文档使用的图片服务组装成实际发送的提示词
+func (s *Service) PreviewScenePrompt(ctx context.Context, scene *db.Scene) (*api.ScenePromptPreview, error) {
+	log := logger.FromContext(ctx)
+
+	doc, err := s.db.GetDocument(ctx, scene.DocumentID)
+	if err != nil {
+		log.Errorf("Failed to get document, id: %s, err: %v", scene.DocumentID, err)
+		return nil, documentError(err, "get document failed")
+	}
+	dbRoles, err := s.db.ListRolesByDocument(ctx, doc.ID)
+	if err != nil {
+		log.Errorf("Failed to list roles, doc: %s, err: %v", doc.ID, err)
+		return nil, hutil.NewApiError(hutil.ErrServerInternalCode, "list roles failed")
+	}
+	roles := make([]bailian.RoleInfo, 0, len(dbRoles))
+	for _, r := range dbRoles {
+		roles = append(roles, roleInfo(&r))
+	}
+
+	opts := sceneImageOptions(s.styles, &doc, scene, roles)
+	content := sceneImageContent(scene, scene.Content)
+	opts.Prompt, err = renderPrompt(ctx, s.db, db.PromptKindImage, imagePromptVars(&doc, scene, content, opts))
+	if err != nil {
+		log.Errorf("Failed to render image prompt, scene: %s, err: %v", scene.ID, err)
+		return nil, hutil.NewApiError(hutil.ErrServerInternalCode, err.Error())
+	}
+	images := s.images()
+	provider := images.Resolve(doc.ImageProvider)
+	previewer, ok := images.Get(provider).(imagegen.Previewer)
+	if !ok {
+		return nil, hutil.NewApiError(http.StatusNotImplemented, "image provider does not support prompt preview")
+	}
+	req := previewer.Preview(content, opts)
+	return &api.ScenePromptPreview{
+		SceneID:        scene.ID,
+		Provider:       provider,
+		Template:       opts.Prompt != "",
+		Prompt:         req.Prompt,
+		NegativePrompt: req.NegativePrompt,
+		References:     req.References,
+	}, nil
+}
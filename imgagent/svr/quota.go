@@ -0,0 +1,269 @@
+package svr
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/gin-gonic/gin"
+
+	"imgagent/api"
+	"imgagent/db"
+	hutil "imgagent/httputil"
+	"imgagent/pkg/logger"
+)
+
+const (
+	ErrQuotaExceededCode = 616
+	ErrQuotaExceeded     = "quota exceeded"
+
+	// quotaWarningHeader 用量越过预警线时在响应头中返回各项用量占上限的百分比，如 images_per_day=92, documents=80
+	quotaWarningHeader = "X-Quota-Warning"
+)
+
+// defaultQuotaWarningThresholds 未配置预警线时使用的默认值，上限的百分比
+var defaultQuotaWarningThresholds = []int{80, 90}
+
+// errQuotaExceeded 配额不足，文档生成任务保持当前状态，待配额恢复后继续处理
+var errQuotaExceeded = errors.New(ErrQuotaExceeded)
+
+// QuotaConfig 配额配置，Default 为所有租户的默认上限，Tenants 按用户 id 覆盖默认上限
+type QuotaConfig struct {
+	Default api.QuotaLimits            `json:"default"`
+	Tenants map[string]api.QuotaLimits `json:"tenants"`
+}
+
+// QuotaMgr 租户配额管理：文档数、上传字节数、每日图片/语音生成数
+type QuotaMgr struct {
+	conf     QuotaConfig
+	db       db.IDataBase
+	webhooks *WebhookMgr // 为 nil 时预警只记录事件
+}
+
+func newQuotaMgr(conf QuotaConfig, db db.IDataBase) *QuotaMgr {
+	return &QuotaMgr{
+		conf: conf,
+		db:   db,
+	}
+}
+
+// Limits 获取租户的配额上限，未配置预警线时使用默认预警线
+func (q *QuotaMgr) Limits(userID int64) api.QuotaLimits {
+	limits, ok := q.conf.Tenants[strconv.FormatInt(userID, 10)]
+	if !ok {
+		limits = q.conf.Default
+	}
+	if len(limits.WarningThresholds) == 0 {
+		limits.WarningThresholds = defaultQuotaWarningThresholds
+	}
+	return limits
+}
+
+// Usage 获取租户当前的配额用量
+func (q *QuotaMgr) Usage(ctx context.Context, userID int64) (api.QuotaUsage, error) {
+	count, bytes, err := q.db.GetDocumentUsage(ctx, userID)
+	if err != nil {
+		return api.QuotaUsage{}, err
+	}
+	daily, err := q.db.GetQuotaUsage(ctx, userID, quotaDate())
+	if err != nil {
+		return api.QuotaUsage{}, err
+	}
+	return api.QuotaUsage{
+		Documents:   count,
+		UploadBytes: bytes,
+		ImagesToday: daily.Images,
+		VoicesToday: daily.Voices,
+	}, nil
+}
+
+// CheckDocument 检查租户是否还能创建一个大小为 size 的文档
+func (q *QuotaMgr) CheckDocument(ctx context.Context, userID int64, size int64) error {
+	limits := q.Limits(userID)
+	if limits.MaxDocuments == 0 && limits.MaxUploadBytes == 0 {
+		return nil
+	}
+	count, bytes, err := q.db.GetDocumentUsage(ctx, userID)
+	if err != nil {
+		return err
+	}
+	if limits.MaxDocuments > 0 && count+1 > int64(limits.MaxDocuments) {
+		return errQuotaExceeded
+	}
+	if limits.MaxUploadBytes > 0 && bytes+size > limits.MaxUploadBytes {
+		return errQuotaExceeded
+	}
+	return nil
+}
+
+// CheckGeneration 检查租户今天是否还能生成 images 张图片和 voices 段语音
+func (q *QuotaMgr) CheckGeneration(ctx context.Context, userID int64, images, voices int) error {
+	limits := q.Limits(userID)
+	if limits.MaxImagesPerDay == 0 && limits.MaxVoicesPerDay == 0 {
+		return nil
+	}
+	daily, err := q.db.GetQuotaUsage(ctx, userID, quotaDate())
+	if err != nil {
+		return err
+	}
+	if limits.MaxImagesPerDay > 0 && daily.Images+images > limits.MaxImagesPerDay {
+		return errQuotaExceeded
+	}
+	if limits.MaxVoicesPerDay > 0 && daily.Voices+voices > limits.MaxVoicesPerDay {
+		return errQuotaExceeded
+	}
+	return nil
+}
+
+// RecordGeneration 记录租户今天生成的图片和语音数，用量越过预警线或上限时发出预警
+func (q *QuotaMgr) RecordGeneration(ctx context.Context, userID int64, images, voices int) error {
+	date := quotaDate()
+	err := q.db.IncrQuotaUsage(ctx, userID, date, images, voices)
+	if err != nil {
+		return err
+	}
+
+	limits := q.Limits(userID)
+	if limits.MaxImagesPerDay == 0 && limits.MaxVoicesPerDay == 0 {
+		return nil
+	}
+	daily, err := q.db.GetQuotaUsage(ctx, userID, date)
+	if err != nil {
+		return err
+	}
+	q.checkThreshold(ctx, userID, limits.WarningThresholds, "images_per_day", int64(daily.Images-images), int64(daily.Images), int64(limits.MaxImagesPerDay))
+	q.checkThreshold(ctx, userID, limits.WarningThresholds, "voices_per_day", int64(daily.Voices-voices), int64(daily.Voices), int64(limits.MaxVoicesPerDay))
+	return nil
+}
+
+// RecordDocument 在文档创建后调用，文档数或上传字节数越过预警线或上限时发出预警
+func (q *QuotaMgr) RecordDocument(ctx context.Context, userID int64, size int64) error {
+	limits := q.Limits(userID)
+	if limits.MaxDocuments == 0 && limits.MaxUploadBytes == 0 {
+		return nil
+	}
+	count, bytes, err := q.db.GetDocumentUsage(ctx, userID)
+	if err != nil {
+		return err
+	}
+	q.checkThreshold(ctx, userID, limits.WarningThresholds, "documents", count-1, count, int64(limits.MaxDocuments))
+	q.checkThreshold(ctx, userID, limits.WarningThresholds, "upload_bytes", bytes-size, bytes, limits.MaxUploadBytes)
+	return nil
+}
+
+// checkThreshold 用量从 before 增长到 after 时，仅在首次越过预警线或上限时记录事件并发送回调，
+// 一次越过多条线时只提醒最高的一条，避免重复提醒
+func (q *QuotaMgr) checkThreshold(ctx context.Context, userID int64, thresholds []int, kind string, before, after, limit int64) {
+	if limit == 0 {
+		return
+	}
+	crossed := 0
+	for _, t := range thresholds {
+		line := float64(limit) * float64(t) / 100
+		if float64(before) < line && float64(after) >= line && t > crossed {
+			crossed = t
+		}
+	}
+	if before < limit && after >= limit {
+		crossed = 100
+	}
+	if crossed == 0 {
+		return
+	}
+
+	eventType, msg := db.EventQuotaWarning, fmt.Sprintf("%s quota reached %d%%: %d/%d", kind, crossed, after, limit)
+	if crossed >= 100 {
+		eventType, msg = db.EventQuotaExceeded, fmt.Sprintf("%s quota exhausted: %d/%d", kind, after, limit)
+	}
+	recordEvent(ctx, q.db, db.Event{
+		UserID:  userID,
+		Type:    eventType,
+		Message: msg,
+	})
+	q.webhooks.NotifyUser(ctx, userID, eventType, api.QuotaAlert{
+		Kind:      kind,
+		Usage:     after,
+		Limit:     limit,
+		Threshold: crossed,
+	})
+}
+
+// Warnings 返回用量已越过最低预警线的各项配额及其占上限的百分比，按配额名排序
+func (q *QuotaMgr) Warnings(ctx context.Context, userID int64) ([]string, error) {
+	limits := q.Limits(userID)
+	if limits.MaxDocuments == 0 && limits.MaxUploadBytes == 0 && limits.MaxImagesPerDay == 0 && limits.MaxVoicesPerDay == 0 {
+		return nil, nil
+	}
+	usage, err := q.Usage(ctx, userID)
+	if err != nil {
+		return nil, err
+	}
+	lowest := slices.Min(limits.WarningThresholds)
+	var warnings []string
+	add := func(kind string, used, limit int64) {
+		if limit == 0 {
+			return
+		}
+		percent := used * 100 / limit
+		if percent >= int64(lowest) {
+			warnings = append(warnings, fmt.Sprintf("%s=%d", kind, percent))
+		}
+	}
+	add("documents", usage.Documents, int64(limits.MaxDocuments))
+	add("upload_bytes", usage.UploadBytes, limits.MaxUploadBytes)
+	add("images_per_day", int64(usage.ImagesToday), int64(limits.MaxImagesPerDay))
+	add("voices_per_day", int64(usage.VoicesToday), int64(limits.MaxVoicesPerDay))
+	slices.Sort(warnings)
+	return warnings, nil
+}
+
+// quotaError 将配额检查错误转换为业务错误
+func quotaError(err error, errMsg string) error {
+	if errors.Is(err, errQuotaExceeded) {
+		return hutil.NewApiError(ErrQuotaExceededCode, ErrQuotaExceeded)
+	}
+	return hutil.NewApiError(hutil.ErrServerInternalCode, errMsg)
+}
+
+func quotaDate() string {
+	return time.Now().Format(time.DateOnly)
+}
+
+// HandleGetQuota 获取当前租户的配额上限与用量
+func (s *Service) HandleGetQuota(c *gin.Context) {
+	ctx := c.Request.Context()
+	log := logger.FromGinContext(c)
+	ui := GetUserInfo(c)
+
+	log.Infof("Get quota, userID: %d", ui.ID)
+	usage, err := s.quota.Usage(ctx, ui.ID)
+	if err != nil {
+		log.Errorf("Failed to get quota usage, err: %v", err)
+		hutil.AbortError(c, hutil.ErrServerInternalCode, "get quota usage failed")
+		return
+	}
+
+	hutil.WriteData(c, &api.GetQuotaResult{
+		Limits: s.quota.Limits(ui.ID),
+		Usage:  usage,
+	})
+}
+
+// QuotaWarning 用于消耗配额的接口，用量越过预警线时在响应头返回 X-Quota-Warning，
+// 便于集成方在配额用尽被拒绝前调整；查询失败不影响请求
+func (s *Service) QuotaWarning() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		ui := GetUserInfo(c)
+		warnings, err := s.quota.Warnings(c.Request.Context(), ui.ID)
+		if err != nil {
+			logger.FromGinContext(c).Errorf("Failed to get quota warnings, userID: %d, err: %v", ui.ID, err)
+		} else if len(warnings) > 0 {
+			c.Header(quotaWarningHeader, strings.Join(warnings, ", "))
+		}
+		c.Next()
+	}
+}
@@ -0,0 +1,194 @@
+package svr
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"gorm.io/gorm"
+
+	"imgagent/api"
+	"imgagent/db"
+	hutil "imgagent/httputil"
+	"imgagent/pkg/logger"
+)
+
+// checkReadingTarget 校验章节和场景属于该文档，场景可以为空
+func (s *Service) checkReadingTarget(ctx context.Context, docID, chapterID, sceneID string) error {
+	if _, err := s.db.GetDocument(ctx, docID); err != nil {
+		return err
+	}
+	if _, err := s.db.GetChapter(ctx, chapterID, docID); err != nil {
+		return err
+	}
+	if sceneID == "" {
+		return nil
+	}
+	scene, err := s.db.GetScene(ctx, sceneID)
+	if err != nil {
+		return err
+	}
+	if scene.DocumentID != docID || scene.ChapterID != chapterID {
+		return gorm.ErrRecordNotFound
+	}
+	return nil
+}
+
+func makeBookmark(b *db.Bookmark) api.Bookmark {
+	return api.Bookmark{
+		ID:         b.ID,
+		DocumentID: b.DocumentID,
+		ChapterID:  b.ChapterID,
+		SceneID:    b.SceneID,
+		Note:       b.Note,
+		CreatedAt:  b.CreatedAt.Format(time.DateTime),
+	}
+}
+
+func (s *Service) HandleCreateBookmark(c *gin.Context) {
+	ctx := c.Request.Context()
+	log := logger.FromGinContext(c)
+	ui := GetUserInfo(c)
+
+	docID := c.Param("document_id")
+	var args api.CreateBookmarkArgs
+	if err := c.ShouldBindJSON(&args); err != nil {
+		log.Errorf("Invalid request body, err: %v", err)
+		hutil.AbortError(c, http.StatusBadRequest, "invalid request body")
+		return
+	}
+
+	log.Infof("Create bookmark, userID: %d, docID: %s, args: %+v", ui.ID, docID, args)
+	err := s.checkReadingTarget(ctx, docID, args.ChapterID, args.SceneID)
+	if err != nil {
+		log.Errorf("Failed to check bookmark target, err: %v", err)
+		readingErr(c, err, "check bookmark target failed")
+		return
+	}
+
+	bookmark := db.Bookmark{
+		ID:         db.MakeUUID(),
+		UserID:     ui.ID,
+		DocumentID: docID,
+		ChapterID:  args.ChapterID,
+		SceneID:    args.SceneID,
+		Note:       args.Note,
+		CreatedAt:  time.Now(),
+	}
+	err = s.db.CreateBookmark(ctx, &bookmark)
+	if err != nil {
+		log.Errorf("Failed to create bookmark, err: %v", err)
+		hutil.AbortError(c, hutil.ErrServerInternalCode, "create bookmark failed")
+		return
+	}
+	hutil.WriteData(c, makeBookmark(&bookmark))
+}
+
+func (s *Service) HandleListBookmarks(c *gin.Context) {
+	ctx := c.Request.Context()
+	log := logger.FromGinContext(c)
+	ui := GetUserInfo(c)
+
+	docID := c.Param("document_id")
+	log.Infof("List bookmarks, userID: %d, docID: %s", ui.ID, docID)
+	bookmarks, err := s.db.ListBookmarks(ctx, ui.ID, docID)
+	if err != nil {
+		log.Errorf("Failed to list bookmarks, err: %v", err)
+		hutil.AbortError(c, hutil.ErrServerInternalCode, "list bookmarks failed")
+		return
+	}
+
+	ret := &api.ListBookmarksResult{Bookmarks: []api.Bookmark{}}
+	for _, b := range bookmarks {
+		ret.Bookmarks = append(ret.Bookmarks, makeBookmark(&b))
+	}
+	hutil.WriteData(c, ret)
+}
+
+func (s *Service) HandleDeleteBookmark(c *gin.Context) {
+	ctx := c.Request.Context()
+	log := logger.FromGinContext(c)
+	ui := GetUserInfo(c)
+
+	docID := c.Param("document_id")
+	id := c.Param("id")
+	log.Infof("Delete bookmark, userID: %d, docID: %s, id: %s", ui.ID, docID, id)
+	err := s.db.DeleteBookmark(ctx, id, ui.ID, docID)
+	if err != nil {
+		log.Errorf("Failed to delete bookmark, err: %v", err)
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			hutil.AbortError(c, http.StatusNotFound, "bookmark not found")
+		} else {
+			hutil.AbortError(c, hutil.ErrServerInternalCode, "delete bookmark failed")
+		}
+		return
+	}
+	hutil.WriteData(c, nil)
+}
+
+// HandleGetReadPosition 获取最后阅读位置，从未阅读时返回空位置
+func (s *Service) HandleGetReadPosition(c *gin.Context) {
+	ctx := c.Request.Context()
+	log := logger.FromGinContext(c)
+	ui := GetUserInfo(c)
+
+	docID := c.Param("document_id")
+	log.Infof("Get read position, userID: %d, docID: %s", ui.ID, docID)
+	pos, err := s.db.GetReadPosition(ctx, ui.ID, docID)
+	if err != nil {
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			hutil.WriteData(c, &api.ReadPosition{DocumentID: docID})
+			return
+		}
+		log.Errorf("Failed to get read position, err: %v", err)
+		hutil.AbortError(c, hutil.ErrServerInternalCode, "get read position failed")
+		return
+	}
+
+	hutil.WriteData(c, &api.ReadPosition{
+		DocumentID: pos.DocumentID,
+		ChapterID:  pos.ChapterID,
+		SceneID:    pos.SceneID,
+		UpdatedAt:  pos.UpdatedAt.Format(time.DateTime),
+	})
+}
+
+func (s *Service) HandleUpdateReadPosition(c *gin.Context) {
+	ctx := c.Request.Context()
+	log := logger.FromGinContext(c)
+	ui := GetUserInfo(c)
+
+	docID := c.Param("document_id")
+	var args api.UpdateReadPositionArgs
+	if err := c.ShouldBindJSON(&args); err != nil {
+		log.Errorf("Invalid request body, err: %v", err)
+		hutil.AbortError(c, http.StatusBadRequest, "invalid request body")
+		return
+	}
+
+	log.Infof("Update read position, userID: %d, docID: %s, args: %+v", ui.ID, docID, args)
+	err := s.checkReadingTarget(ctx, docID, args.ChapterID, args.SceneID)
+	if err != nil {
+		log.Errorf("Failed to check read position target, err: %v", err)
+		readingErr(c, err, "check read position target failed")
+		return
+	}
+
+	err = s.db.SaveReadPosition(ctx, ui.ID, docID, args.ChapterID, args.SceneID)
+	if err != nil {
+		log.Errorf("Failed to save read position, err: %v", err)
+		hutil.AbortError(c, hutil.ErrServerInternalCode, "save read position failed")
+		return
+	}
+	hutil.WriteData(c, nil)
+}
+
+func readingErr(c *gin.Context, err error, errMsg string) {
+	if errors.Is(err, gorm.ErrRecordNotFound) {
+		hutil.AbortError(c, http.StatusNotFound, "document, chapter or scene not found")
+		return
+	}
+	hutil.AbortError(c, hutil.ErrServerInternalCode, errMsg)
+}
@@ -0,0 +1,16 @@
+package svr
+
+import (
+	"github.com/gin-gonic/gin"
+
+	"imgagent/pkg/dbutil"
+)
+
+// ReadReplica 用于数据量大的列表和统计接口，查询分发到从库，复制延迟期间可能读到稍旧的数据；
+// 未配置从库时仍读主库
+func (s *Service) ReadReplica() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		c.Request = c.Request.WithContext(dbutil.WithReplica(c.Request.Context()))
+		c.Next()
+	}
+}
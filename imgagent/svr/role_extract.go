@@ -0,0 +1,168 @@
+package svr
+
+import (
+	"context"
+	"net/http"
+	"strings"
+	"time"
+	"unicode/utf8"
+
+	"github.com/gin-gonic/gin"
+
+	"imgagent/api"
+	"imgagent/db"
+	hutil "imgagent/httputil"
+	"imgagent/pkg/logger"
+)
+
+// roleExtractBatchRunes 重新提取角色时每次调用大模型的章节字数上限，相邻章节合并到不超过该字数，单章超出时单独提取
+const roleExtractBatchRunes = 30000
+
+// HandleExtractRoles 按当前章节内容重新提取角色，需要多次同步调用大模型，按租户限制并发
+func (s *Service) HandleExtractRoles(c *gin.Context) {
+	docID := c.Param("document_id")
+	s.limiter.Run(c, GetUserInfo(c).ID, func(ctx context.Context) (any, error) {
+		return s.ExtractRoles(ctx, docID)
+	})
+}
+
+// ExtractRoles 分批从全部章节重新提取角色并与已有角色按名字合并：同名角色只补全空字段，不覆盖人工修改过的描述，
+// 新出现的角色追加到文档。角色提取阶段尚未完成时返回 409，由流水线提取
+func (s *Service) ExtractRoles(ctx context.Context, docID string) (*api.ExtractRolesResult, error) {
+	log := logger.FromContext(ctx)
+
+	if s.bailianClient == nil {
+		return nil, hutil.NewApiError(http.StatusBadRequest, "bailian client not configured")
+	}
+	doc, err := s.db.GetDocument(ctx, docID)
+	if err != nil {
+		log.Errorf("Failed to get document, id: %s, err: %v", docID, err)
+		return nil, documentError(err, "get document failed")
+	}
+	if doc.Status == db.DocumentStatusChapterReady {
+		return nil, hutil.NewApiError(http.StatusConflict, "roles cannot be extracted before role extraction finishes")
+	}
+	chapters, err := s.db.ListChapters(ctx, docID)
+	if err != nil {
+		log.Errorf("Failed to list chapters, docID: %s, err: %v", docID, err)
+		return nil, hutil.NewApiError(hutil.ErrServerInternalCode, "list chapters failed")
+	}
+	if len(chapters) == 0 {
+		return nil, hutil.NewApiError(http.StatusBadRequest, "document has no chapters")
+	}
+	prompt, err := renderPrompt(ctx, s.db, db.PromptKindRole, &promptVars{Document: newPromptDocument(&doc)})
+	if err != nil {
+		log.Errorf("Failed to render role prompt, docID: %s, err: %v", docID, err)
+		return nil, hutil.NewApiError(hutil.ErrServerInternalCode, "render role prompt failed")
+	}
+
+	batches := roleExtractBatches(chapters)
+	log.Infof("Extract roles, docID: %s, chapters: %d, batches: %d", docID, len(chapters), len(batches))
+	var extracted []db.Role
+	for _, text := range batches {
+		roles, err := s.bailianClient.ExtractRolesFromText(ctx, text, doc.Summary, prompt)
+		if err != nil {
+			log.Errorf("Failed to extract roles, docID: %s, err: %v", docID, err)
+			return nil, hutil.NewApiError(hutil.ErrServerInternalCode, "extract roles failed")
+		}
+		for _, r := range roles {
+			extracted = append(extracted, db.Role{Name: strings.TrimSpace(r.Name), Gender: r.Gender, Character: r.Character, Appearance: r.Appearance})
+		}
+	}
+
+	existing, err := s.db.ListRolesByDocument(ctx, docID)
+	if err != nil {
+		log.Errorf("Failed to list roles, docID: %s, err: %v", docID, err)
+		return nil, hutil.NewApiError(hutil.ErrServerInternalCode, "list roles failed")
+	}
+	created, updated := mergeExtractedRoles(docID, existing, extracted)
+	for i := range updated {
+		r := &updated[i]
+		err = s.db.UpdateRole(ctx, r.ID, &api.UpdateRoleArgs{Name: r.Name, Gender: r.Gender, Character: r.Character, Appearance: r.Appearance, PortraitURL: r.PortraitURL})
+		if err != nil {
+			log.Errorf("Failed to update role, id: %s, err: %v", r.ID, err)
+			return nil, hutil.NewApiError(hutil.ErrServerInternalCode, "update role failed")
+		}
+	}
+	if len(created) > 0 {
+		if err = s.db.CreateRoles(ctx, created); err != nil {
+			log.Errorf("Failed to create roles, docID: %s, err: %v", docID, err)
+			return nil, hutil.NewApiError(hutil.ErrServerInternalCode, "create roles failed")
+		}
+	}
+	log.Infof("Roles extracted, docID: %s, created: %d, updated: %d", docID, len(created), len(updated))
+
+	roles, err := s.db.ListRolesByDocument(ctx, docID)
+	if err != nil {
+		log.Errorf("Failed to list roles, docID: %s, err: %v", docID, err)
+		return nil, hutil.NewApiError(hutil.ErrServerInternalCode, "list roles failed")
+	}
+	ret := &api.ExtractRolesResult{Created: len(created), Updated: len(updated), Roles: make([]api.Role, 0, len(roles))}
+	for i := range roles {
+		ret.Roles = append(ret.Roles, makeRole(&roles[i]))
+	}
+	return ret, nil
+}
+
+// roleExtractBatches 按顺序把章节合并为不超过 roleExtractBatchRunes 字的文本
+func roleExtractBatches(chapters []db.Chapter) []string {
+	var batches []string
+	var b strings.Builder
+	n := 0
+	for i := range chapters {
+		text := chapters[i].Title + "\n" + chapters[i].Content + "\n\n"
+		size := utf8.RuneCountInString(text)
+		if n > 0 && n+size > roleExtractBatchRunes {
+			batches = append(batches, b.String())
+			b.Reset()
+			n = 0
+		}
+		b.WriteString(text)
+		n += size
+	}
+	if n > 0 {
+		batches = append(batches, b.String())
+	}
+	return batches
+}
+
+// mergeExtractedRoles 按名字把提取结果合并到已有角色，同一角色在多批中出现时合并为一条。
+// 返回需要新建的角色和补全了空字段的已有角色
+func mergeExtractedRoles(docID string, existing, extracted []db.Role) (created, updated []db.Role) {
+	byName := make(map[string]int, len(existing))
+	for i := range existing {
+		byName[existing[i].Name] = i
+	}
+	newByName := make(map[string]int)
+	changed := make(map[int]bool)
+	now := time.Now()
+	for _, r := range extracted {
+		if r.Name == "" {
+			continue
+		}
+		if i, ok := byName[r.Name]; ok {
+			merged := mergeRoleInfo(existing[i], []db.Role{r})
+			if merged != existing[i] {
+				existing[i] = merged
+				changed[i] = true
+			}
+			continue
+		}
+		if i, ok := newByName[r.Name]; ok {
+			created[i] = mergeRoleInfo(created[i], []db.Role{r})
+			continue
+		}
+		r.ID = db.MakeUUID()
+		r.DocumentID = docID
+		r.CreatedAt = now
+		r.UpdatedAt = now
+		newByName[r.Name] = len(created)
+		created = append(created, r)
+	}
+	for i := range existing {
+		if changed[i] {
+			updated = append(updated, existing[i])
+		}
+	}
+	return created, updated
+}
@@ -0,0 +1,160 @@
+package svr
+
+import (
+	"context"
+	"strings"
+	"time"
+
+	"github.com/gin-gonic/gin"
+
+	"imgagent/api"
+	"imgagent/bailian"
+	"imgagent/db"
+	hutil "imgagent/httputil"
+	"imgagent/pkg/logger"
+)
+
+// maxRelationDescriptionRunes 关系说明的最大长度，不超过 role_relations.description 字段
+const maxRelationDescriptionRunes = 100
+
+// roleRelationTypes 支持的关系类型，大模型返回其他类型时记为 other
+var roleRelationTypes = map[string]bool{
+	"parent": true, "child": true, "sibling": true, "spouse": true, "lover": true, "friend": true, "rival": true,
+	"enemy": true, "mentor": true, "student": true, "colleague": true, "master": true, "servant": true, "other": true,
+}
+
+// HandleGetRoleGraph 返回文档的角色关系图
+func (s *Service) HandleGetRoleGraph(c *gin.Context) {
+	ret, err := s.RoleGraph(c.Request.Context(), c.Param("document_id"))
+	if err != nil {
+		hutil.AbortErr(c, err)
+		return
+	}
+	hutil.WriteData(c, ret)
+}
+
+// RoleGraph 由角色和角色关系组成关系图。关系在角色提取阶段生成，合并角色后来源角色的关系转给目标角色，
+// 同一对角色只保留最先提取的关系
+func (s *Service) RoleGraph(ctx context.Context, docID string) (*api.RoleGraph, error) {
+	log := logger.FromContext(ctx)
+
+	if _, err := s.db.GetDocument(ctx, docID); err != nil {
+		log.Errorf("Failed to get document, id: %s, err: %v", docID, err)
+		return nil, documentError(err, "get document failed")
+	}
+	roles, err := s.db.ListRolesByDocument(ctx, docID)
+	if err != nil {
+		log.Errorf("Failed to list roles, docID: %s, err: %v", docID, err)
+		return nil, hutil.NewApiError(hutil.ErrServerInternalCode, "list roles failed")
+	}
+	relations, err := s.db.ListRoleRelations(ctx, docID)
+	if err != nil {
+		log.Errorf("Failed to list role relations, docID: %s, err: %v", docID, err)
+		return nil, hutil.NewApiError(hutil.ErrServerInternalCode, "list role relations failed")
+	}
+
+	ret := &api.RoleGraph{Nodes: make([]api.RoleGraphNode, 0, len(roles)), Edges: make([]api.RoleGraphEdge, 0, len(relations))}
+	index := make(map[string]int, len(roles))
+	for i, r := range roles {
+		index[r.ID] = i
+		ret.Nodes = append(ret.Nodes, api.RoleGraphNode{ID: r.ID, Name: r.Name, Gender: r.Gender, PortraitURL: r.PortraitURL})
+	}
+	pairs := make(map[[2]string]bool, len(relations))
+	for _, r := range relations {
+		source, ok := index[r.SourceRoleID]
+		if !ok {
+			continue
+		}
+		target, ok := index[r.TargetRoleID]
+		if !ok || pairs[rolePair(r.SourceRoleID, r.TargetRoleID)] {
+			continue
+		}
+		pairs[rolePair(r.SourceRoleID, r.TargetRoleID)] = true
+		ret.Nodes[source].Degree++
+		ret.Nodes[target].Degree++
+		ret.Edges = append(ret.Edges, api.RoleGraphEdge{Source: r.SourceRoleID, Target: r.TargetRoleID, Type: r.Type, Description: r.Description})
+	}
+	return ret, nil
+}
+
+// extractRoleRelations 角色提取阶段的最后一步，提取角色之间的关系并保存。关系图不影响后续流程，失败只记录日志；
+// 已有关系时不重复提取
+func (m *DocumentMgr) extractRoleRelations(ctx context.Context, doc db.Document, roles []db.Role) {
+	log := logger.FromContext(ctx)
+
+	if len(roles) < 2 {
+		return
+	}
+	existing, err := m.db.ListRoleRelations(ctx, doc.ID)
+	if err != nil {
+		log.Warnf("Failed to list role relations, doc: %s, err: %v", doc.ID, err)
+		return
+	}
+	if len(existing) > 0 {
+		log.Infof("Role relations already exist for doc: %s, count: %d", doc.ID, len(existing))
+		return
+	}
+
+	names := make([]string, 0, len(roles))
+	for _, r := range roles {
+		names = append(names, r.Name)
+	}
+	log.Infof("Extracting role relations, docID: %s", doc.ID)
+	relations, err := m.bailianClient.ExtractRoleRelations(ctx, doc.FileID, doc.Summary, names)
+	if err != nil {
+		log.Warnf("Failed to extract role relations, doc: %s, err: %v", doc.ID, err)
+		return
+	}
+	dbRelations := makeRoleRelations(doc.ID, roles, relations)
+	if err = m.db.ReplaceRoleRelations(ctx, doc.ID, dbRelations); err != nil {
+		log.Warnf("Failed to save role relations, doc: %s, err: %v", doc.ID, err)
+		return
+	}
+	log.Infof("Created %d role relations for doc: %s", len(dbRelations), doc.ID)
+}
+
+// makeRoleRelations 按角色名字匹配角色 id，丢弃名字不在角色列表中、指向自身或重复的关系
+func makeRoleRelations(docID string, roles []db.Role, relations []bailian.RoleRelation) []db.RoleRelation {
+	ids := make(map[string]string, len(roles))
+	for _, r := range roles {
+		if _, ok := ids[r.Name]; !ok {
+			ids[r.Name] = r.ID
+		}
+	}
+	now := time.Now()
+	pairs := make(map[[2]string]bool, len(relations))
+	ret := make([]db.RoleRelation, 0, len(relations))
+	for _, r := range relations {
+		source, target := ids[strings.TrimSpace(r.Source)], ids[strings.TrimSpace(r.Target)]
+		if source == "" || target == "" || source == target || pairs[rolePair(source, target)] {
+			continue
+		}
+		pairs[rolePair(source, target)] = true
+		typ := strings.ToLower(strings.TrimSpace(r.Type))
+		if !roleRelationTypes[typ] {
+			typ = "other"
+		}
+		description := strings.TrimSpace(r.Description)
+		if runes := []rune(description); len(runes) > maxRelationDescriptionRunes {
+			description = string(runes[:maxRelationDescriptionRunes])
+		}
+		ret = append(ret, db.RoleRelation{
+			ID:           db.MakeUUID(),
+			DocumentID:   docID,
+			SourceRoleID: source,
+			TargetRoleID: target,
+			Type:         typ,
+			Description:  description,
+			CreatedAt:    now,
+		})
+	}
+	return ret
+}
+
+// rolePair 不区分方向的角色对，同一对角色只保留一种关系
+func rolePair(a, b string) [2]string {
+	if a > b {
+		a, b = b, a
+	}
+	return [2]string{a, b}
+}
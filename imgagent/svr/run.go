@@ -0,0 +1,243 @@
+package svr
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"gorm.io/gorm"
+
+	"imgagent/api"
+	"imgagent/db"
+	hutil "imgagent/httputil"
+	"imgagent/pkg/logger"
+)
+
+// 场景对比结果
+const (
+	SceneUnchanged = "unchanged"
+	SceneChanged   = "changed"
+	SceneAdded     = "added"
+	SceneRemoved   = "removed"
+)
+
+// recordRun 文档处理结束时记录本次处理的场景 prompt 和媒体，失败只记录日志
+func (m *DocumentMgr) recordRun(ctx context.Context, doc db.Document, status string, scenes []db.Scene) {
+	run := db.DocumentRun{
+		ID:         db.MakeUUID(),
+		DocumentID: doc.ID,
+		Status:     status,
+		SceneCount: len(scenes),
+		CreatedAt:  time.Now(),
+	}
+	runScenes := make([]db.RunScene, 0, len(scenes))
+	for _, scene := range scenes {
+		if !scene.Placeholder {
+			if scene.ImageURL != "" {
+				run.ImageCount++
+			}
+			if scene.VoiceURL != "" {
+				run.VoiceCount++
+			}
+		}
+		runScenes = append(runScenes, db.RunScene{
+			SceneID:     scene.ID,
+			ChapterID:   scene.ChapterID,
+			Index:       scene.Index,
+			Prompt:      scene.Content,
+			ImageURL:    scene.ImageURL,
+			VoiceURL:    scene.VoiceURL,
+			Placeholder: scene.Placeholder,
+		})
+	}
+	err := m.db.CreateDocumentRun(ctx, &run, runScenes)
+	if err != nil {
+		logger.FromContext(ctx).Errorf("Failed to record document run, doc: %s, err: %v", doc.ID, err)
+	}
+}
+
+func makeDocumentRun(r *db.DocumentRun) api.DocumentRun {
+	return api.DocumentRun{
+		Seq:        r.Seq,
+		Status:     r.Status,
+		SceneCount: r.SceneCount,
+		ImageCount: r.ImageCount,
+		VoiceCount: r.VoiceCount,
+		CreatedAt:  r.CreatedAt.Format(time.DateTime),
+	}
+}
+
+// compareRuns 按场景序号对齐两次处理的场景，重新拆分场景后场景 id 会变化，序号保持播放顺序
+func compareRuns(oldScenes, newScenes []db.RunScene) []api.SceneComparison {
+	olds := make(map[int]db.RunScene, len(oldScenes))
+	for _, s := range oldScenes {
+		olds[s.Index] = s
+	}
+	news := make(map[int]db.RunScene, len(newScenes))
+	for _, s := range newScenes {
+		news[s.Index] = s
+	}
+
+	var ret []api.SceneComparison
+	appendScene := func(index int) {
+		o, inOld := olds[index]
+		n, inNew := news[index]
+		c := api.SceneComparison{
+			Index:       index,
+			OldSceneID:  o.SceneID,
+			NewSceneID:  n.SceneID,
+			OldImageURL: o.ImageURL,
+			NewImageURL: n.ImageURL,
+			OldVoiceURL: o.VoiceURL,
+			NewVoiceURL: n.VoiceURL,
+		}
+		switch {
+		case !inNew:
+			c.Change = SceneRemoved
+		case !inOld:
+			c.Change = SceneAdded
+		case o.Prompt == n.Prompt && o.ImageURL == n.ImageURL && o.VoiceURL == n.VoiceURL:
+			c.Change = SceneUnchanged
+		default:
+			c.Change = SceneChanged
+		}
+		if o.Prompt != n.Prompt {
+			c.PromptDiff = diffText(o.Prompt, n.Prompt)
+		}
+		ret = append(ret, c)
+	}
+	for _, s := range oldScenes {
+		appendScene(s.Index)
+	}
+	for _, s := range newScenes {
+		if _, ok := olds[s.Index]; !ok {
+			appendScene(s.Index)
+		}
+	}
+	return ret
+}
+
+// diffText 按字符计算最长公共子序列，返回从 a 到 b 的差异片段
+func diffText(a, b string) []api.DiffSegment {
+	x, y := []rune(a), []rune(b)
+	// lcs[i][j] 为 x[i:] 与 y[j:] 的最长公共子序列长度
+	lcs := make([][]int, len(x)+1)
+	for i := range lcs {
+		lcs[i] = make([]int, len(y)+1)
+	}
+	for i := len(x) - 1; i >= 0; i-- {
+		for j := len(y) - 1; j >= 0; j-- {
+			if x[i] == y[j] {
+				lcs[i][j] = lcs[i+1][j+1] + 1
+			} else {
+				lcs[i][j] = max(lcs[i+1][j], lcs[i][j+1])
+			}
+		}
+	}
+
+	var segs []api.DiffSegment
+	add := func(op string, r rune) {
+		if len(segs) > 0 && segs[len(segs)-1].Op == op {
+			segs[len(segs)-1].Text += string(r)
+			return
+		}
+		segs = append(segs, api.DiffSegment{Op: op, Text: string(r)})
+	}
+	i, j := 0, 0
+	for i < len(x) && j < len(y) {
+		switch {
+		case x[i] == y[j]:
+			add("equal", x[i])
+			i++
+			j++
+		case lcs[i+1][j] >= lcs[i][j+1]:
+			add("delete", x[i])
+			i++
+		default:
+			add("insert", y[j])
+			j++
+		}
+	}
+	for ; i < len(x); i++ {
+		add("delete", x[i])
+	}
+	for ; j < len(y); j++ {
+		add("insert", y[j])
+	}
+	return segs
+}
+
+func (s *Service) HandleListDocumentRuns(c *gin.Context) {
+	ctx := c.Request.Context()
+	log := logger.FromGinContext(c)
+
+	docID := c.Param("document_id")
+	log.Infof("List document runs, docID: %s", docID)
+	runs, err := s.db.ListDocumentRuns(ctx, docID)
+	if err != nil {
+		log.Errorf("Failed to list document runs, err: %v", err)
+		hutil.AbortError(c, hutil.ErrServerInternalCode, "list document runs failed")
+		return
+	}
+
+	ret := &api.ListDocumentRunsResult{Runs: []api.DocumentRun{}}
+	for _, r := range runs {
+		ret.Runs = append(ret.Runs, makeDocumentRun(&r))
+	}
+	hutil.WriteData(c, ret)
+}
+
+// HandleCompareDocumentRuns 逐场景对比文档的两次处理结果：prompt 差异、新旧媒体 URL 和生成量差异
+func (s *Service) HandleCompareDocumentRuns(c *gin.Context) {
+	ctx := c.Request.Context()
+	log := logger.FromGinContext(c)
+
+	docID := c.Param("document_id")
+	a, errA := strconv.Atoi(c.Param("a"))
+	b, errB := strconv.Atoi(c.Param("b"))
+	if errA != nil || errB != nil {
+		hutil.AbortError(c, http.StatusBadRequest, "invalid run seq")
+		return
+	}
+
+	log.Infof("Compare document runs, docID: %s, a: %d, b: %d", docID, a, b)
+	var runs [2]db.DocumentRun
+	var scenes [2][]db.RunScene
+	for i, seq := range []int{a, b} {
+		run, err := s.db.GetDocumentRun(ctx, docID, seq)
+		if err != nil {
+			log.Errorf("Failed to get document run, seq: %d, err: %v", seq, err)
+			if errors.Is(err, gorm.ErrRecordNotFound) {
+				hutil.AbortError(c, http.StatusNotFound, "run not found")
+			} else {
+				hutil.AbortError(c, hutil.ErrServerInternalCode, "get document run failed")
+			}
+			return
+		}
+		runs[i] = run
+		scenes[i], err = s.db.ListRunScenes(ctx, run.ID)
+		if err != nil {
+			log.Errorf("Failed to list run scenes, run: %s, err: %v", run.ID, err)
+			hutil.AbortError(c, hutil.ErrServerInternalCode, "list run scenes failed")
+			return
+		}
+	}
+
+	ret := &api.RunComparison{
+		DocumentID: docID,
+		Old:        makeDocumentRun(&runs[0]),
+		New:        makeDocumentRun(&runs[1]),
+		CostDelta: api.CostDelta{
+			Images: runs[1].ImageCount - runs[0].ImageCount,
+			Voices: runs[1].VoiceCount - runs[0].VoiceCount,
+		},
+		Scenes: compareRuns(scenes[0], scenes[1]),
+	}
+	if ret.Scenes == nil {
+		ret.Scenes = []api.SceneComparison{}
+	}
+	hutil.WriteData(c, ret)
+}
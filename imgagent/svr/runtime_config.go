@@ -0,0 +1,68 @@
+package svr
+
+import (
+	"context"
+	"time"
+
+	"github.com/gin-gonic/gin"
+
+	"imgagent/api"
+	"imgagent/bailian"
+	hutil "imgagent/httputil"
+	"imgagent/pkg/faults"
+	"imgagent/pkg/logger"
+)
+
+// RuntimeConfig 返回可在运行时修改的配置的当前生效值
+func (s *Service) RuntimeConfig() api.RuntimeConfig {
+	ret := api.RuntimeConfig{
+		LogLevel:   logger.Level(),
+		RateLimit:  s.limiter.Limits(),
+		ReloadedAt: s.reloadedAt.Load(),
+	}
+	if s.documentMgr != nil {
+		concurrency := s.documentMgr.Concurrency()
+		ret.DocumentMgr = &concurrency
+	}
+	if s.bailianClient != nil {
+		models := s.bailianClient.Models()
+		ret.Bailian = &api.BailianModels{ChatModel: models.ChatModel, ImageModel: models.ImageModel, TTSModel: models.TTSModel}
+	}
+	return ret
+}
+
+// UpdateRuntimeConfig 应用运行时配置，为空或不大于 0 的值使用默认值；未启用的组件对应的配置忽略。
+// 日志级别不合法时不做任何修改。故障注入只允许在 debug 级别下开启，级别调高时一并关闭，恢复 debug 后需重启才会重新开启
+func (s *Service) UpdateRuntimeConfig(ctx context.Context, conf api.RuntimeConfig) error {
+	log := logger.FromContext(ctx)
+
+	if conf.LogLevel == "" {
+		conf.LogLevel = "info"
+	}
+	if err := logger.SetLevel(conf.LogLevel); err != nil {
+		log.Errorf("Failed to set log level, err: %v", err)
+		return err
+	}
+	if logger.Level() != "debug" && faults.Enabled() {
+		faults.Init(faults.Config{})
+		log.Warnf("Fault injection disabled, log level: %s", logger.Level())
+	}
+	s.limiter.SetLimits(conf.RateLimit)
+	if s.documentMgr != nil && conf.DocumentMgr != nil {
+		s.documentMgr.SetConcurrency(*conf.DocumentMgr)
+	}
+	if s.bailianClient != nil && conf.Bailian != nil {
+		s.bailianClient.SetModels(bailian.Models{ChatModel: conf.Bailian.ChatModel, ImageModel: conf.Bailian.ImageModel, TTSModel: conf.Bailian.TTSModel})
+	}
+	now := time.Now()
+	s.reloadedAt.Store(&now)
+
+	ret := s.RuntimeConfig()
+	log.Infof("Runtime config updated, log level: %s, rate limit: %+v, document mgr: %+v, bailian: %+v", ret.LogLevel, ret.RateLimit, ret.DocumentMgr, ret.Bailian)
+	return nil
+}
+
+// HandleGetRuntimeConfig 返回日志级别、限流、文档处理并发和百炼模型的当前生效值
+func (s *Service) HandleGetRuntimeConfig(c *gin.Context) {
+	hutil.WriteData(c, s.RuntimeConfig())
+}
@@ -0,0 +1,105 @@
+package svr
+
+import (
+	"net/http"
+	"slices"
+	"strings"
+
+	"github.com/gin-gonic/gin"
+
+	"imgagent/api"
+	"imgagent/bailian"
+	"imgagent/db"
+	hutil "imgagent/httputil"
+)
+
+// sceneTimesOfDay 场景的时间段，值为图片提示词中的描述
+var sceneTimesOfDay = map[string]string{
+	"dawn":      "黎明",
+	"morning":   "上午",
+	"noon":      "正午",
+	"afternoon": "下午",
+	"evening":   "傍晚",
+	"night":     "夜晚",
+}
+
+// newScene 由场景拆分结果创建场景，地点、氛围和出场人物按字段长度截断，无法识别的时间段记为空
+func newScene(info bailian.SceneInfo) db.Scene {
+	timeOfDay := strings.ToLower(strings.TrimSpace(info.TimeOfDay))
+	if _, ok := sceneTimesOfDay[timeOfDay]; !ok {
+		timeOfDay = ""
+	}
+	var characters []string
+	for _, name := range info.CharactersPresent {
+		if name = truncateRunes(strings.TrimSpace(name), 50); name != "" && !slices.Contains(characters, name) {
+			characters = append(characters, name)
+		}
+	}
+	return db.Scene{
+		Content:           info.Description,
+		Location:          truncateRunes(strings.TrimSpace(info.Location), 100),
+		TimeOfDay:         timeOfDay,
+		Mood:              truncateRunes(strings.TrimSpace(info.Mood), 50),
+		CharactersPresent: characters,
+	}
+}
+
+// sceneImageContent 生成图片使用的场景描述，content 之后补充场景的地点、时间段、氛围和出场人物，
+// 出场人物同时用于注入角色外貌。没有属性的场景（用户插入、历史数据）直接使用 content
+func sceneImageContent(scene *db.Scene, content string) string {
+	var attrs []string
+	if scene.Location != "" {
+		attrs = append(attrs, "地点："+scene.Location)
+	}
+	if t := sceneTimesOfDay[scene.TimeOfDay]; t != "" {
+		attrs = append(attrs, "时间："+t)
+	}
+	if scene.Mood != "" {
+		attrs = append(attrs, "氛围："+scene.Mood)
+	}
+	if len(scene.CharactersPresent) > 0 {
+		attrs = append(attrs, "人物："+strings.Join(scene.CharactersPresent, "、"))
+	}
+	if len(attrs) == 0 {
+		return content
+	}
+	return content + "（" + strings.Join(attrs, "；") + "）"
+}
+
+// sceneFilter 场景列表的属性筛选，为空的条件不筛选。Location、Mood 为包含匹配，Character 为出场人物之一
+type sceneFilter struct {
+	Location  string
+	TimeOfDay string
+	Mood      string
+	Character string
+}
+
+func parseSceneFilter(c *gin.Context) (sceneFilter, error) {
+	f := sceneFilter{
+		Location:  strings.TrimSpace(c.Query("location")),
+		TimeOfDay: c.Query("time_of_day"),
+		Mood:      strings.TrimSpace(c.Query("mood")),
+		Character: strings.TrimSpace(c.Query("character")),
+	}
+	if _, ok := sceneTimesOfDay[f.TimeOfDay]; f.TimeOfDay != "" && !ok {
+		return f, hutil.NewApiError(http.StatusBadRequest, "invalid time_of_day")
+	}
+	return f, nil
+}
+
+func (f sceneFilter) apply(scenes []api.Scene) []api.Scene {
+	if f == (sceneFilter{}) {
+		return scenes
+	}
+	return slices.DeleteFunc(scenes, func(s api.Scene) bool {
+		return !strings.Contains(s.Location, f.Location) || (f.TimeOfDay != "" && s.TimeOfDay != f.TimeOfDay) ||
+			!strings.Contains(s.Mood, f.Mood) || (f.Character != "" && !slices.Contains(s.CharactersPresent, f.Character))
+	})
+}
+
+func truncateRunes(s string, n int) string {
+	if runes := []rune(s); len(runes) > n {
+		return string(runes[:n])
+	}
+	return s
+}
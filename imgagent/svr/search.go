@@ -0,0 +1,287 @@
+package svr
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"gorm.io/gorm"
+
+	"imgagent/api"
+	"imgagent/db"
+	hutil "imgagent/httputil"
+	"imgagent/pkg/logger"
+	"imgagent/pkg/redisutil"
+	"imgagent/pkg/tracing"
+)
+
+// searchIndexVersion 索引格式版本，归一化规则或索引字段变化时递增，启动时重建低版本的文档索引。
+// 2: 增加原始内容 Display
+const searchIndexVersion = 2
+
+const (
+	defaultSearchLimit = 20
+	maxSearchLimit     = 100
+)
+
+// SearchConfig 场景全文索引。场景写入时在同一事务中写入 outbox，由 Indexer 异步消费，不阻塞文档写入
+type SearchConfig struct {
+	Enable       bool `json:"enable"`
+	IntervalSecs int  `json:"interval_secs"`
+	BatchSize    int  `json:"batch_size"`
+}
+
+type Indexer struct {
+	conf SearchConfig
+	db   db.IDataBase
+
+	close     chan bool
+	closeOnce sync.Once
+	wg        sync.WaitGroup
+}
+
+func newIndexer(conf SearchConfig, database db.IDataBase) *Indexer {
+	if conf.IntervalSecs == 0 {
+		conf.IntervalSecs = 5
+	}
+	if conf.BatchSize == 0 {
+		conf.BatchSize = 100
+	}
+	return &Indexer{
+		conf:  conf,
+		db:    database,
+		close: make(chan bool),
+	}
+}
+
+func (x *Indexer) Run() {
+	x.wg.Add(1)
+	go x.loopHandleIndexTasks()
+}
+
+// Stop 通知索引循环退出，并等待当前批次处理完
+func (x *Indexer) Stop(ctx context.Context) error {
+	x.closeOnce.Do(func() { close(x.close) })
+
+	done := make(chan struct{})
+	go func() {
+		x.wg.Wait()
+		close(done)
+	}()
+	select {
+	case <-done:
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+func (x *Indexer) loopHandleIndexTasks() {
+	defer x.wg.Done()
+
+	ctx := logger.NewContext(fmt.Sprintf("EnqueueStaleIndex-%d", time.Now().Unix()))
+	x.EnqueueStaleDocuments(ctx)
+
+	ticker := time.NewTicker(time.Second * time.Duration(x.conf.IntervalSecs))
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ticker.C:
+			ctx, span := tracing.Start(logger.NewContext(fmt.Sprintf("HandleIndexTasks-%d", time.Now().Unix())), "Indexer.HandleIndexTasks")
+			x.HandleIndexTasks(logger.WithTrace(ctx))
+			span.End()
+		case <-x.close:
+			return
+		}
+	}
+}
+
+// EnqueueStaleDocuments 为索引版本低于当前版本的文档添加重建任务
+func (x *Indexer) EnqueueStaleDocuments(ctx context.Context) {
+	log := logger.FromContext(ctx)
+
+	docIDs, err := x.db.ListStaleIndexDocuments(ctx, searchIndexVersion)
+	if err != nil {
+		log.Errorf("Failed to list stale index documents, err: %v", err)
+		return
+	}
+	if len(docIDs) == 0 {
+		return
+	}
+	log.Infof("Enqueue stale index documents, count: %d, version: %d", len(docIDs), searchIndexVersion)
+	err = x.db.EnqueueDocumentIndexTasks(ctx, docIDs)
+	if err != nil {
+		log.Errorf("Failed to enqueue index tasks, err: %v", err)
+	}
+}
+
+// HandleIndexTasks 消费一批 outbox 任务。任务只携带 id，处理时读取当前数据，因此重复或乱序执行结果一致；
+// 失败的任务保留到下一轮重试
+func (x *Indexer) HandleIndexTasks(ctx context.Context) {
+	log := logger.FromContext(ctx)
+
+	tasks, err := x.db.ListIndexTasks(ctx, x.conf.BatchSize)
+	if err != nil {
+		log.Errorf("Failed to list index tasks, err: %v", err)
+		return
+	}
+	if len(tasks) == 0 {
+		return
+	}
+
+	var done []int64
+	for _, task := range tasks {
+		if task.SceneID == "" {
+			err = x.indexDocument(ctx, task.DocumentID)
+		} else {
+			err = x.indexScene(ctx, task.SceneID)
+		}
+		if err != nil {
+			log.Errorf("Failed to handle index task, task: %+v, err: %v", task, err)
+			continue
+		}
+		done = append(done, task.ID)
+	}
+
+	err = x.db.DeleteIndexTasks(ctx, done)
+	if err != nil {
+		log.Errorf("Failed to delete index tasks, err: %v", err)
+		return
+	}
+	log.Infof("Handle index tasks, total: %d, done: %d", len(tasks), len(done))
+}
+
+// indexDocument 重建文档下所有场景的索引，文档已删除时只清理索引
+func (x *Indexer) indexDocument(ctx context.Context, docID string) error {
+	err := x.db.DeleteSearchEntriesByDocument(ctx, docID)
+	if err != nil {
+		return err
+	}
+	doc, err := x.db.GetDocument(ctx, docID)
+	if err != nil {
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			return nil
+		}
+		return err
+	}
+	scenes, err := x.db.ListScenesByDocument(ctx, docID)
+	if err != nil {
+		return err
+	}
+	for _, scene := range scenes {
+		err = x.db.SaveSearchEntry(ctx, makeSearchEntry(&doc, &scene))
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// indexScene 更新单个场景的索引，场景或文档已删除时删除索引
+func (x *Indexer) indexScene(ctx context.Context, sceneID string) error {
+	scene, err := x.db.GetScene(ctx, sceneID)
+	if errors.Is(err, gorm.ErrRecordNotFound) {
+		return x.db.DeleteSearchEntry(ctx, sceneID)
+	}
+	if err != nil {
+		return err
+	}
+	doc, err := x.db.GetDocument(ctx, scene.DocumentID)
+	if errors.Is(err, gorm.ErrRecordNotFound) {
+		return x.db.DeleteSearchEntry(ctx, sceneID)
+	}
+	if err != nil {
+		return err
+	}
+	return x.db.SaveSearchEntry(ctx, makeSearchEntry(&doc, &scene))
+}
+
+func makeSearchEntry(doc *db.Document, scene *db.Scene) *db.SearchEntry {
+	return &db.SearchEntry{
+		SceneID:      scene.ID,
+		DocumentID:   scene.DocumentID,
+		ChapterID:    scene.ChapterID,
+		UserID:       doc.UserID,
+		Content:      normalizeSearchText(scene.Content),
+		Display:      scene.Content,
+		IndexVersion: searchIndexVersion,
+		IndexedAt:    time.Now(),
+	}
+}
+
+// normalizeSearchText 合并空白并转小写，索引内容和查询词使用同一规则
+func normalizeSearchText(s string) string {
+	return strings.ToLower(strings.Join(strings.Fields(s), " "))
+}
+
+// Reindex 为所有文档添加重建索引任务，由运行中的 Indexer 异步完成
+func Reindex(ctx context.Context, conf Config) error {
+	rdb := redisutil.NewClient(conf.Redis)
+	if rdb != nil {
+		defer rdb.Close()
+	}
+	database, err := openDatabase(conf, rdb)
+	if err != nil {
+		return err
+	}
+	defer database.Close()
+
+	docs, err := database.ListDocuments(ctx)
+	if err != nil {
+		return err
+	}
+	docIDs := make([]string, 0, len(docs))
+	for _, doc := range docs {
+		docIDs = append(docIDs, doc.ID)
+	}
+	logger.FromContext(ctx).Infof("Reindex documents, count: %d", len(docIDs))
+	return database.EnqueueDocumentIndexTasks(ctx, docIDs)
+}
+
+// HandleSearch 在当前租户的场景中按关键字搜索
+func (s *Service) HandleSearch(c *gin.Context) {
+	ctx := c.Request.Context()
+	log := logger.FromGinContext(c)
+	ui := GetUserInfo(c)
+
+	q := normalizeSearchText(c.Query("q"))
+	if q == "" {
+		hutil.AbortError(c, http.StatusBadRequest, "invalid q")
+		return
+	}
+	limit := defaultSearchLimit
+	if l := c.Query("limit"); l != "" {
+		v, err := strconv.Atoi(l)
+		if err != nil || v <= 0 {
+			hutil.AbortError(c, http.StatusBadRequest, "invalid limit")
+			return
+		}
+		limit = min(v, maxSearchLimit)
+	}
+
+	log.Infof("Search, userID: %d, q: %s, limit: %d", ui.ID, q, limit)
+	entries, err := s.db.SearchScenes(ctx, ui.ID, q, limit)
+	if err != nil {
+		log.Errorf("Failed to search scenes, err: %v", err)
+		hutil.AbortError(c, hutil.ErrServerInternalCode, "search failed")
+		return
+	}
+
+	ret := &api.SearchResult{Hits: []api.SearchHit{}}
+	for _, e := range entries {
+		ret.Hits = append(ret.Hits, api.SearchHit{
+			DocumentID: e.DocumentID,
+			ChapterID:  e.ChapterID,
+			SceneID:    e.SceneID,
+			Content:    e.Display,
+		})
+	}
+	hutil.WriteData(c, ret)
+}
@@ -0,0 +1,341 @@
+package svr
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"image"
+	"image/png"
+	"net/http"
+	"os"
+	"strings"
+	"time"
+
+	"github.com/gin-gonic/gin"
+
+	"imgagent/api"
+	"imgagent/bailian"
+	"imgagent/db"
+	hutil "imgagent/httputil"
+	"imgagent/imagegen"
+	"imgagent/pkg/logger"
+	"imgagent/pkg/mediastore"
+	"imgagent/placeholder"
+	"imgagent/spliter"
+	"imgagent/tts"
+)
+
+// selfTestTimeout 单次自检的最长时间，使用实际服务时图片和语音生成较慢
+const selfTestTimeout = 5 * time.Minute
+
+// selfTestDocument 自检使用的短篇文档，包含两个章节
+const selfTestDocument = `第一章 相遇
+
+清晨，少女林晓背着画板走进山间小镇。她在石桥边遇见了正在钓鱼的白发老人。
+
+第二章 约定
+
+傍晚，老人把一支旧毛笔送给林晓。两人约定来年春天再在石桥边相见。
+`
+
+// 自检阶段，与文档处理流程的顺序一致
+const (
+	selfTestSplit   = "split"
+	selfTestUpload  = "upload"
+	selfTestSummary = "summary"
+	selfTestRoles   = "roles"
+	selfTestScenes  = "scenes"
+	selfTestImage   = "image"
+	selfTestTTS     = "tts"
+)
+
+var selfTestStages = []string{selfTestSplit, selfTestUpload, selfTestSummary, selfTestRoles, selfTestScenes, selfTestImage, selfTestTTS}
+
+// selfTestLLM 自检用到的百炼文本接口，*bailian.Client 和 mockLLM 均实现
+type selfTestLLM interface {
+	UploadFile(ctx context.Context, filename string) (string, error)
+	DeleteFile(ctx context.Context, fileID string) error
+	ExtractSummary(ctx context.Context, fileID string) (string, error)
+	ExtractRoles(ctx context.Context, fileID string, summary string, prompt string) ([]bailian.RoleInfo, error)
+	GenerateScenes(ctx context.Context, content string, prompt string) ([]bailian.SceneInfo, error)
+}
+
+// selfTestProviders 自检使用的服务
+type selfTestProviders struct {
+	llm   selfTestLLM
+	image imagegen.Provider
+	tts   tts.Provider
+}
+
+// selfTestProviders 按 provider 选择模拟服务或实际配置的服务
+func (s *Service) selfTestProviders(provider string) (*selfTestProviders, error) {
+	switch provider {
+	case api.SelfTestProviderMock:
+		return &selfTestProviders{
+			llm:   mockLLM{},
+			image: &mockImageProvider{store: s.imageStore},
+			tts:   &mockTTSProvider{store: s.audioStore},
+		}, nil
+	case api.SelfTestProviderReal:
+		if s.bailianClient == nil {
+			return nil, hutil.NewApiError(http.StatusBadRequest, "bailian client not configured")
+		}
+		return &selfTestProviders{
+			llm:   s.bailianClient,
+			image: s.images().Get(""),
+			tts:   ttsProviderFunc(s.synthesize),
+		}, nil
+	}
+	return nil, hutil.NewApiError(http.StatusBadRequest, "provider must be one of mock, real")
+}
+
+// HandleSelfTest 用内置的短篇文档走一遍分割、上传、摘要、角色、场景、图片和语音流程，返回各阶段结果和耗时。
+// 不创建文档，部署后一次调用即可验证服务可用
+func (s *Service) HandleSelfTest(c *gin.Context) {
+	ret, err := s.SelfTest(c.Request.Context(), c.DefaultQuery("provider", api.SelfTestProviderMock))
+	if err != nil {
+		hutil.AbortErr(c, err)
+		return
+	}
+	hutil.WriteData(c, ret)
+}
+
+func (s *Service) SelfTest(ctx context.Context, provider string) (*api.SelfTestResult, error) {
+	log := logger.FromContext(ctx)
+
+	providers, err := s.selfTestProviders(provider)
+	if err != nil {
+		return nil, err
+	}
+	ctx, cancel := context.WithTimeout(ctx, selfTestTimeout)
+	defer cancel()
+
+	log.Infof("Self test started, provider: %s", provider)
+	start := time.Now()
+	ret := &api.SelfTestResult{Provider: provider, OK: true, Stages: make([]api.SelfTestStage, 0, len(selfTestStages))}
+	run := newSelfTestRun(s, providers)
+	defer run.cleanup(ctx)
+	for _, name := range selfTestStages {
+		stage := api.SelfTestStage{Name: name}
+		if !ret.OK {
+			stage.Skipped = true
+			ret.Stages = append(ret.Stages, stage)
+			continue
+		}
+		stageStart := time.Now()
+		stage.Detail, err = run.stage(ctx, name)
+		stage.DurationMs = time.Since(stageStart).Milliseconds()
+		if err != nil {
+			log.Errorf("Self test stage failed, provider: %s, stage: %s, err: %v", provider, name, err)
+			stage.Error = truncateError(sanitizeLog(err.Error()))
+			ret.OK = false
+		} else {
+			stage.OK = true
+		}
+		ret.Stages = append(ret.Stages, stage)
+	}
+	ret.DurationMs = time.Since(start).Milliseconds()
+	log.Infof("Self test finished, provider: %s, ok: %v, duration: %dms", provider, ret.OK, ret.DurationMs)
+	return ret, nil
+}
+
+// selfTestRun 一次自检的中间结果，各阶段依次使用前面阶段的产出
+type selfTestRun struct {
+	s         *Service
+	providers *selfTestProviders
+
+	filename string
+	chapters []string
+	fileID   string
+	doc      db.Document
+	roles    []bailian.RoleInfo
+	scenes   []bailian.SceneInfo
+}
+
+func newSelfTestRun(s *Service, providers *selfTestProviders) *selfTestRun {
+	// 与上传的原文同样命名，百炼上的文件删除失败时由孤立文件清理回收
+	return &selfTestRun{
+		s:         s,
+		providers: providers,
+		filename:  uploadTempFilename(s.conf.Temp, s.conf.DocumentConfig.BailianFilePrefix, db.MakeUUID(), ".txt"),
+		doc:       db.Document{Name: "自检文档"},
+	}
+}
+
+// stage 执行自检阶段，返回阶段产出的概要
+func (r *selfTestRun) stage(ctx context.Context, name string) (string, error) {
+	switch name {
+	case selfTestSplit:
+		err := os.WriteFile(r.filename, []byte(selfTestDocument), 0644)
+		if err != nil {
+			return "", err
+		}
+		r.chapters, err = spliter.Split(ctx, r.filename, spliter.Option{
+			ChunkSize:    chapterChunkSize,
+			ChunkOverlap: chapterChunkOverlap,
+			Separators:   r.s.conf.Separators,
+			Footnotes:    r.s.conf.PDFFootnotes,
+		})
+		if err != nil {
+			return "", err
+		}
+		if len(r.chapters) == 0 {
+			return "", errors.New("no chapters split")
+		}
+		return fmt.Sprintf("%d chapters", len(r.chapters)), nil
+
+	case selfTestUpload:
+		fileID, err := r.providers.llm.UploadFile(ctx, r.filename)
+		if err != nil {
+			return "", err
+		}
+		r.fileID = fileID
+		return fileID, nil
+
+	case selfTestSummary:
+		summary, err := r.providers.llm.ExtractSummary(ctx, r.fileID)
+		if err != nil {
+			return "", err
+		}
+		if summary == "" {
+			return "", errors.New("empty summary")
+		}
+		r.doc.Summary = summary
+		return fmt.Sprintf("%d chars", len([]rune(summary))), nil
+
+	case selfTestRoles:
+		prompt, err := renderPrompt(ctx, r.s.db, db.PromptKindRole, &promptVars{Document: newPromptDocument(&r.doc)})
+		if err != nil {
+			return "", err
+		}
+		r.roles, err = r.providers.llm.ExtractRoles(ctx, r.fileID, r.doc.Summary, prompt)
+		if err != nil {
+			return "", err
+		}
+		if len(r.roles) == 0 {
+			return "", errors.New("no roles extracted")
+		}
+		names := make([]string, 0, len(r.roles))
+		for _, role := range r.roles {
+			names = append(names, role.Name)
+		}
+		return strings.Join(names, ","), nil
+
+	case selfTestScenes:
+		prompt, err := renderPrompt(ctx, r.s.db, db.PromptKindScene, &promptVars{
+			Document: newPromptDocument(&r.doc),
+			Chapter:  promptChapter{Content: r.chapters[0]},
+		})
+		if err != nil {
+			return "", err
+		}
+		r.scenes, err = r.providers.llm.GenerateScenes(ctx, r.chapters[0], prompt)
+		if err != nil {
+			return "", err
+		}
+		if len(r.scenes) == 0 {
+			return "", errors.New("no scenes generated")
+		}
+		return fmt.Sprintf("%d scenes", len(r.scenes)), nil
+
+	case selfTestImage:
+		scene := newScene(r.scenes[0])
+		opts := sceneImageOptions(r.s.styles, &r.doc, &scene, r.roles)
+		content := sceneImageContent(&scene, scene.Content)
+		var err error
+		opts.Prompt, err = renderPrompt(ctx, r.s.db, db.PromptKindImage, imagePromptVars(&r.doc, &scene, content, opts))
+		if err != nil {
+			return "", err
+		}
+		return r.providers.image.Generate(ctx, content, opts)
+
+	case selfTestTTS:
+		return r.providers.tts.Synthesize(ctx, r.scenes[0].Description, tts.Options{})
+	}
+	return "", fmt.Errorf("unknown stage: %s", name)
+}
+
+// cleanup 删除临时文件和上传到百炼的文件，生成的图片和语音按内容命名，保留不影响后续自检
+func (r *selfTestRun) cleanup(ctx context.Context) {
+	log := logger.FromContext(ctx)
+	os.Remove(r.filename)
+	if r.fileID == "" {
+		return
+	}
+	err := r.providers.llm.DeleteFile(context.WithoutCancel(ctx), r.fileID)
+	if err != nil {
+		log.Warnf("Failed to delete self test file, fileID: %s, err: %v", r.fileID, err)
+	}
+}
+
+// ttsProviderFunc 将合成函数适配为 tts.Provider
+type ttsProviderFunc func(ctx context.Context, text string, opts tts.Options) (string, error)
+
+func (f ttsProviderFunc) Synthesize(ctx context.Context, text string, opts tts.Options) (string, error) {
+	return f(ctx, text, opts)
+}
+
+// mockLLM 模拟百炼的文本接口，按句子拆分场景，不访问外部服务
+type mockLLM struct{}
+
+func (mockLLM) UploadFile(ctx context.Context, filename string) (string, error) {
+	if _, err := os.Stat(filename); err != nil {
+		return "", err
+	}
+	return "mock-file-" + db.MakeUUID(), nil
+}
+
+func (mockLLM) DeleteFile(ctx context.Context, fileID string) error {
+	return nil
+}
+
+func (mockLLM) ExtractSummary(ctx context.Context, fileID string) (string, error) {
+	return "少女林晓在山间小镇遇见白发老人，两人约定来年春天再见。", nil
+}
+
+func (mockLLM) ExtractRoles(ctx context.Context, fileID string, summary string, prompt string) ([]bailian.RoleInfo, error) {
+	return []bailian.RoleInfo{
+		{Name: "林晓", Gender: "女", Character: "开朗", Appearance: "短发，背着画板"},
+		{Name: "老人", Gender: "男", Character: "温和", Appearance: "白发，穿布衣"},
+	}, nil
+}
+
+func (mockLLM) GenerateScenes(ctx context.Context, content string, prompt string) ([]bailian.SceneInfo, error) {
+	var scenes []bailian.SceneInfo
+	for _, sentence := range strings.SplitAfter(content, "。") {
+		if sentence = strings.TrimSpace(sentence); sentence != "" {
+			scenes = append(scenes, bailian.SceneInfo{Description: sentence})
+		}
+	}
+	return scenes, nil
+}
+
+// mockImageProvider 生成纯色 PNG 保存到本地，验证图片目录可写
+type mockImageProvider struct {
+	store *mediastore.Store
+}
+
+func (p *mockImageProvider) Generate(ctx context.Context, prompt string, opts imagegen.Options) (string, error) {
+	if p.store == nil {
+		return "", errors.New("image store not configured")
+	}
+	var buf bytes.Buffer
+	err := png.Encode(&buf, image.NewGray(image.Rect(0, 0, 64, 64)))
+	if err != nil {
+		return "", err
+	}
+	return p.store.Save(buf.Bytes(), "png")
+}
+
+// mockTTSProvider 按文字长度生成静音 WAV 保存到本地，验证音频目录可写
+type mockTTSProvider struct {
+	store *mediastore.Store
+}
+
+func (p *mockTTSProvider) Synthesize(ctx context.Context, text string, opts tts.Options) (string, error) {
+	if p.store == nil {
+		return "", errors.New("audio store not configured")
+	}
+	return p.store.Save(placeholder.SilentWAV(placeholder.Duration(text)), "wav")
+}
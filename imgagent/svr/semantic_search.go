@@ -0,0 +1,256 @@
+package svr
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"net/http"
+	"strconv"
+	"strings"
+
+	"github.com/gin-gonic/gin"
+
+	"imgagent/api"
+	"imgagent/db"
+	"imgagent/embedding"
+	hutil "imgagent/httputil"
+	"imgagent/pkg/logger"
+)
+
+const (
+	defaultSemanticSearchLimit = 5
+	maxSemanticSearchLimit     = 20
+	// maxSemanticQueryChars 查询文本的最大字符数
+	maxSemanticQueryChars = 500
+	// semanticSnippetChars 命中章节返回的内容片段长度
+	semanticSnippetChars = 200
+)
+
+// dbVectorStore 默认的向量存储，向量保存在数据库中，搜索时读取文档的全部向量在服务端计算相似度。
+// 单个文档的章节数有限，无需专用的向量库
+type dbVectorStore struct {
+	db db.IDataBase
+}
+
+func (s *dbVectorStore) Save(ctx context.Context, records []embedding.Record) error {
+	embeddings := make([]db.ChapterEmbedding, 0, len(records))
+	for _, r := range records {
+		embeddings = append(embeddings, db.ChapterEmbedding{
+			ChapterID:  r.ID,
+			DocumentID: r.DocumentID,
+			Hash:       r.Hash,
+			Model:      r.Model,
+			Vector:     r.Vector,
+		})
+	}
+	return s.db.SaveChapterEmbeddings(ctx, embeddings)
+}
+
+func (s *dbVectorStore) List(ctx context.Context, documentID string) ([]embedding.Record, error) {
+	embeddings, err := s.db.ListChapterEmbeddings(ctx, documentID, false)
+	if err != nil {
+		return nil, err
+	}
+	return makeEmbeddingRecords(embeddings), nil
+}
+
+func (s *dbVectorStore) Delete(ctx context.Context, documentID string, ids []string) error {
+	if len(ids) == 0 {
+		return nil
+	}
+	return s.db.DeleteChapterEmbeddings(ctx, documentID, ids)
+}
+
+func (s *dbVectorStore) Search(ctx context.Context, documentID string, vector []float32, limit int) ([]embedding.Hit, error) {
+	embeddings, err := s.db.ListChapterEmbeddings(ctx, documentID, true)
+	if err != nil {
+		return nil, err
+	}
+	return embedding.Rank(makeEmbeddingRecords(embeddings), vector, limit), nil
+}
+
+func makeEmbeddingRecords(embeddings []db.ChapterEmbedding) []embedding.Record {
+	records := make([]embedding.Record, 0, len(embeddings))
+	for _, e := range embeddings {
+		records = append(records, embedding.Record{
+			ID:         e.ChapterID,
+			DocumentID: e.DocumentID,
+			Hash:       e.Hash,
+			Model:      e.Model,
+			Vector:     e.Vector,
+		})
+	}
+	return records
+}
+
+// chapterEmbedder 计算并保存章节向量，按章节内容摘要增量更新，DocumentMgr 和 Service 共用
+type chapterEmbedder struct {
+	db       db.IDataBase
+	provider embedding.Provider
+	store    embedding.Store
+}
+
+// newChapterEmbedder 未配置向量服务时返回 nil
+func newChapterEmbedder(database db.IDataBase, provider embedding.Provider, store embedding.Store) *chapterEmbedder {
+	if provider == nil {
+		return nil
+	}
+	if store == nil {
+		store = &dbVectorStore{db: database}
+	}
+	return &chapterEmbedder{db: database, provider: provider, store: store}
+}
+
+// chapterEmbeddingText 计算向量的章节文本，标题与内容一起参与语义匹配
+func chapterEmbeddingText(ch *db.Chapter) string {
+	return ch.Title + "\n" + ch.Content
+}
+
+func chapterHash(ch *db.Chapter) string {
+	sum := sha256.Sum256([]byte(chapterEmbeddingText(ch)))
+	return hex.EncodeToString(sum[:])
+}
+
+// Sync 为内容或向量模型变化的章节重新计算向量，删除已不存在章节的向量
+func (e *chapterEmbedder) Sync(ctx context.Context, docID string) error {
+	log := logger.FromContext(ctx)
+
+	chapters, err := e.db.ListChapters(ctx, docID)
+	if err != nil {
+		return err
+	}
+	records, err := e.store.List(ctx, docID)
+	if err != nil {
+		return err
+	}
+	existing := make(map[string]embedding.Record, len(records))
+	for _, r := range records {
+		existing[r.ID] = r
+	}
+
+	model := e.provider.Model()
+	var changed []embedding.Record
+	var texts []string
+	for i := range chapters {
+		ch := &chapters[i]
+		hash := chapterHash(ch)
+		if r, ok := existing[ch.ID]; ok && r.Hash == hash && r.Model == model {
+			delete(existing, ch.ID)
+			continue
+		}
+		delete(existing, ch.ID)
+		changed = append(changed, embedding.Record{ID: ch.ID, DocumentID: docID, Hash: hash, Model: model})
+		texts = append(texts, chapterEmbeddingText(ch))
+	}
+
+	if len(changed) > 0 {
+		vectors, err := e.provider.Embed(ctx, texts)
+		if err != nil {
+			return err
+		}
+		for i := range changed {
+			changed[i].Vector = vectors[i]
+		}
+		err = e.store.Save(ctx, changed)
+		if err != nil {
+			return err
+		}
+	}
+	stale := make([]string, 0, len(existing))
+	for id := range existing {
+		stale = append(stale, id)
+	}
+	err = e.store.Delete(ctx, docID, stale)
+	if err != nil {
+		return err
+	}
+	if len(changed) > 0 || len(stale) > 0 {
+		log.Infof("Chapter embeddings synced, docID: %s, updated: %d, deleted: %d", docID, len(changed), len(stale))
+	}
+	return nil
+}
+
+// Search 返回文档中与 q 语义最相近的章节，搜索前先更新变化章节的向量
+func (e *chapterEmbedder) Search(ctx context.Context, docID, q string, limit int) ([]embedding.Hit, error) {
+	err := e.Sync(ctx, docID)
+	if err != nil {
+		return nil, err
+	}
+	vectors, err := e.provider.Embed(ctx, []string{q})
+	if err != nil {
+		return nil, err
+	}
+	return e.store.Search(ctx, docID, vectors[0], limit)
+}
+
+// HandleSemanticSearch 在文档的章节中按语义搜索，按相似度降序返回章节
+func (s *Service) HandleSemanticSearch(c *gin.Context) {
+	limit := defaultSemanticSearchLimit
+	if l := c.Query("limit"); l != "" {
+		v, err := strconv.Atoi(l)
+		if err != nil || v <= 0 {
+			hutil.AbortError(c, http.StatusBadRequest, "invalid limit")
+			return
+		}
+		limit = min(v, maxSemanticSearchLimit)
+	}
+	ret, err := s.SemanticSearch(c.Request.Context(), c.Param("document_id"), c.Query("q"), limit)
+	if err != nil {
+		hutil.AbortErr(c, err)
+		return
+	}
+	hutil.WriteData(c, ret)
+}
+
+func (s *Service) SemanticSearch(ctx context.Context, docID, q string, limit int) (*api.SemanticSearchResult, error) {
+	log := logger.FromContext(ctx)
+
+	q = strings.TrimSpace(q)
+	if q == "" || len([]rune(q)) > maxSemanticQueryChars {
+		return nil, hutil.NewApiError(http.StatusBadRequest, "invalid q")
+	}
+	if s.embedder == nil {
+		return nil, hutil.NewApiError(http.StatusBadRequest, "semantic search not enabled")
+	}
+	_, err := s.db.GetDocument(ctx, docID)
+	if err != nil {
+		log.Errorf("Failed to get document, id: %s, err: %v", docID, err)
+		return nil, documentError(err, "get document failed")
+	}
+
+	log.Infof("Semantic search, docID: %s, q: %s, limit: %d", docID, q, limit)
+	hits, err := s.embedder.Search(ctx, docID, q, limit)
+	if err != nil {
+		log.Errorf("Failed to search chapters, docID: %s, err: %v", docID, err)
+		return nil, hutil.NewApiError(hutil.ErrServerInternalCode, "semantic search failed")
+	}
+	chapters, err := s.db.ListChapters(ctx, docID)
+	if err != nil {
+		log.Errorf("Failed to list chapters, docID: %s, err: %v", docID, err)
+		return nil, hutil.NewApiError(hutil.ErrServerInternalCode, "semantic search failed")
+	}
+	byID := make(map[string]*db.Chapter, len(chapters))
+	for i := range chapters {
+		byID[chapters[i].ID] = &chapters[i]
+	}
+
+	ret := &api.SemanticSearchResult{Hits: []api.SemanticSearchHit{}}
+	for _, hit := range hits {
+		ch, ok := byID[hit.ID]
+		if !ok {
+			continue
+		}
+		snippet := []rune(ch.Content)
+		if len(snippet) > semanticSnippetChars {
+			snippet = snippet[:semanticSnippetChars]
+		}
+		ret.Hits = append(ret.Hits, api.SemanticSearchHit{
+			ChapterID: ch.ID,
+			Index:     ch.Index,
+			Title:     ch.Title,
+			Snippet:   string(snippet),
+			Score:     hit.Score,
+		})
+	}
+	return ret, nil
+}
@@ -0,0 +1,76 @@
+package svr
+
+import (
+	"context"
+	"slices"
+	"time"
+
+	"github.com/gin-gonic/gin"
+
+	"imgagent/api"
+	"imgagent/db"
+	hutil "imgagent/httputil"
+	"imgagent/pkg/logger"
+)
+
+// HandleGetStatsSummary 获取当前租户的文档、生成量、处理耗时和排队情况概览
+func (s *Service) HandleGetStatsSummary(c *gin.Context) {
+	ctx := c.Request.Context()
+	log := logger.FromGinContext(c)
+	ui := GetUserInfo(c)
+
+	log.Infof("Get stats summary, userID: %d", ui.ID)
+	summary, err := s.statsSummary(ctx, ui.ID, time.Now())
+	if err != nil {
+		log.Errorf("Failed to get stats summary, err: %v", err)
+		hutil.AbortError(c, hutil.ErrServerInternalCode, "get stats summary failed")
+		return
+	}
+	hutil.WriteData(c, summary)
+}
+
+func (s *Service) statsSummary(ctx context.Context, userID int64, now time.Time) (*api.StatsSummary, error) {
+	summary := &api.StatsSummary{DocumentsByStatus: map[string]int64{}}
+	counts, err := s.db.CountDocumentsByStatus(ctx, userID)
+	if err != nil {
+		return nil, err
+	}
+	for _, c := range counts {
+		summary.DocumentsByStatus[c.Status] += c.Count
+		if slices.Contains(db.DocumentFinishedStatuses, c.Status) {
+			continue
+		}
+		if c.Paused {
+			summary.Queue.PausedDocuments += c.Count
+		} else {
+			summary.Queue.Documents += c.Count
+		}
+	}
+
+	monthStart := time.Date(now.Year(), now.Month(), 1, 0, 0, 0, 0, now.Location())
+	usage, err := s.db.SumQuotaUsage(ctx, userID, monthStart.Format(time.DateOnly))
+	if err != nil {
+		return nil, err
+	}
+	summary.ScenesThisMonth = int64(usage.Images)
+	summary.VoicesThisMonth = int64(usage.Voices)
+
+	durations, err := s.db.ListProcessingDurations(ctx, userID, monthStart)
+	if err != nil {
+		return nil, err
+	}
+	if len(durations) > 0 {
+		var total time.Duration
+		for _, d := range durations {
+			total += d
+		}
+		summary.ProcessedThisMonth = len(durations)
+		summary.AvgProcessingSecs = (total / time.Duration(len(durations))).Seconds()
+	}
+
+	summary.Queue.Scenes, err = s.db.CountPendingScenes(ctx, userID)
+	if err != nil {
+		return nil, err
+	}
+	return summary, nil
+}
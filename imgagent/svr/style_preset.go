@@ -0,0 +1,96 @@
+package svr
+
+import (
+	"errors"
+	"strings"
+
+	"github.com/gin-gonic/gin"
+
+	"imgagent/api"
+	hutil "imgagent/httputil"
+)
+
+// builtinStylePresets 内置的画面风格预设
+var builtinStylePresets = []api.StylePreset{
+	{Name: "anime", Title: "日系动漫", Prompt: "日系动漫风格，线条清晰，色彩明快", NegativePrompt: "写实照片，模糊，畸形的手，多余的手指，文字，水印"},
+	{Name: "watercolor", Title: "水彩", Prompt: "水彩画风格，柔和的笔触与晕染，纸张纹理", NegativePrompt: "写实照片，锐利的数码线条，文字，水印"},
+	{Name: "ink", Title: "水墨", Prompt: "中国水墨画风格，黑白为主，留白意境", NegativePrompt: "鲜艳色彩，写实照片，文字，水印"},
+	{Name: "comic", Title: "美式漫画", Prompt: "美式漫画风格，粗线条，网点阴影，高对比度", NegativePrompt: "写实照片，模糊，文字，水印"},
+	{Name: "realistic", Title: "写实", Prompt: "电影感写实画面，自然光影，细节丰富", NegativePrompt: "卡通，动漫，畸形的手，多余的手指，文字，水印"},
+}
+
+// stylePresets 可用的画面风格预设，配置中的预设追加在内置预设之后，同名时覆盖内置预设。
+// 为 nil 时只使用内置预设
+type stylePresets struct {
+	presets []api.StylePreset
+}
+
+func newStylePresets(custom []api.StylePreset) (*stylePresets, error) {
+	p := &stylePresets{presets: append([]api.StylePreset(nil), builtinStylePresets...)}
+	for _, preset := range custom {
+		if preset.Name == "" {
+			return nil, errors.New("style preset name is required")
+		}
+		if i := p.index(preset.Name); i >= 0 {
+			p.presets[i] = preset
+			continue
+		}
+		p.presets = append(p.presets, preset)
+	}
+	return p, nil
+}
+
+func (p *stylePresets) list() []api.StylePreset {
+	if p == nil {
+		return builtinStylePresets
+	}
+	return p.presets
+}
+
+func (p *stylePresets) index(name string) int {
+	for i, preset := range p.list() {
+		if preset.Name == name {
+			return i
+		}
+	}
+	return -1
+}
+
+// Get 返回名称对应的预设
+func (p *stylePresets) Get(name string) (api.StylePreset, bool) {
+	if i := p.index(name); i >= 0 {
+		return p.list()[i], true
+	}
+	return api.StylePreset{}, false
+}
+
+// Names 可用的预设名称
+func (p *stylePresets) Names() []string {
+	names := make([]string, 0, len(p.list()))
+	for _, preset := range p.list() {
+		names = append(names, preset.Name)
+	}
+	return names
+}
+
+// sceneStyle 合并预设、文档和场景级的画面风格，返回正向和反向提示词；
+// 预设已从配置中删除时忽略预设
+func (p *stylePresets) sceneStyle(style api.ImageStyle, sceneStyle string) (string, string) {
+	preset, _ := p.Get(style.Preset)
+	return joinPrompts(preset.Prompt, style.Prompt, sceneStyle), joinPrompts(preset.NegativePrompt, style.NegativePrompt)
+}
+
+func joinPrompts(prompts ...string) string {
+	var parts []string
+	for _, prompt := range prompts {
+		if prompt = strings.TrimSpace(prompt); prompt != "" {
+			parts = append(parts, prompt)
+		}
+	}
+	return strings.Join(parts, "，")
+}
+
+// HandleListStylePresets 列出可用的画面风格预设
+func (s *Service) HandleListStylePresets(c *gin.Context) {
+	hutil.WriteData(c, &api.ListStylePresetsResult{Presets: s.styles.list()})
+}
@@ -0,0 +1,51 @@
+package svr
+
+import (
+	"context"
+	"time"
+
+	"imgagent/api"
+	"imgagent/db"
+	"imgagent/pkg/logger"
+	"imgagent/pkg/mediastore"
+	"imgagent/subtitle"
+)
+
+// saveSceneSubtitle 按语音时长生成场景的 WebVTT 字幕并保存到 store，返回字幕 URL。store 为 nil 时不生成
+func saveSceneSubtitle(store *mediastore.Store, text string, audioMs int64) (string, error) {
+	if store == nil {
+		return "", nil
+	}
+	cues := subtitle.Cues(text, time.Duration(audioMs)*time.Millisecond)
+	if len(cues) == 0 {
+		return "", nil
+	}
+	return store.Save(subtitle.VTT(cues), "vtt")
+}
+
+// updateSceneSubtitle 语音生成后更新场景字幕，字幕不影响场景生成结果，失败只记录日志
+func updateSceneSubtitle(ctx context.Context, database db.IDataBase, store *mediastore.Store, sceneID, text string, audioMs int64) {
+	log := logger.FromContext(ctx)
+
+	subtitleURL, err := saveSceneSubtitle(store, text, audioMs)
+	if err != nil {
+		log.Warnf("Failed to save scene subtitle, scene: %s, err: %v", sceneID, err)
+		return
+	}
+	if err = database.UpdateSceneSubtitleURL(ctx, sceneID, subtitleURL); err != nil {
+		log.Warnf("Failed to update scene subtitleURL, scene: %s, err: %v", sceneID, err)
+	}
+}
+
+// manifestSubtitles 按播放清单的时间轴拼接文档字幕，未生成语音的场景和占位场景没有字幕
+func manifestSubtitles(manifest *api.Manifest) []subtitle.Cue {
+	var cues []subtitle.Cue
+	for _, scene := range manifest.Scenes {
+		if scene.Placeholder || scene.AudioDurationMs == 0 {
+			continue
+		}
+		sceneCues := subtitle.Cues(scene.Content, time.Duration(scene.AudioDurationMs)*time.Millisecond)
+		cues = append(cues, subtitle.Shift(sceneCues, time.Duration(scene.StartMs)*time.Millisecond)...)
+	}
+	return cues
+}
@@ -0,0 +1,84 @@
+package svr
+
+import (
+	"context"
+	"fmt"
+	"runtime/debug"
+	"time"
+
+	"imgagent/db"
+	"imgagent/pkg/logger"
+)
+
+// superviseRestartDelay 处理循环 panic 后重启前的等待时间，避免持续 panic 时空转
+const superviseRestartDelay = time.Second
+
+// panicError 处理过程中 recover 到的 panic，携带发生时的调用栈
+type panicError struct {
+	value any
+	stack []byte
+}
+
+func (e *panicError) Error() string {
+	return fmt.Sprintf("panic: %v", e.value)
+}
+
+// safeCall 执行 fn，把 panic 转换为 *panicError 返回，避免单个文档或场景拖垮整个 DocumentMgr
+func safeCall(ctx context.Context, fn func(ctx context.Context) error) (err error) {
+	defer func() {
+		if r := recover(); r != nil {
+			err = &panicError{value: r, stack: debug.Stack()}
+		}
+	}()
+	return fn(ctx)
+}
+
+// supervise 在独立 goroutine 中运行处理循环，循环因 panic 退出时记录日志并重启，直到 Stop
+func (m *DocumentMgr) supervise(name string, loop func()) {
+	m.wg.Add(1)
+	go func() {
+		defer m.wg.Done()
+		for {
+			err := safeCall(context.Background(), func(context.Context) error {
+				loop()
+				return nil
+			})
+			if err == nil {
+				return
+			}
+			log := logger.FromContext(logger.NewContext(fmt.Sprintf("%s-%d", name, time.Now().Unix())))
+			log.Errorf("Loop %s panicked, restarting, err: %v, stack: %s", name, err, err.(*panicError).stack)
+			select {
+			case <-time.After(superviseRestartDelay):
+			case <-m.close:
+				return
+			}
+		}
+	}()
+}
+
+// failDocumentOnPanic 文档处理 panic 时将文档标记为失败，并在事件日志中记录调用栈
+func (m *DocumentMgr) failDocumentOnPanic(ctx context.Context, stage string, doc db.Document, pe *panicError) {
+	log := logger.FromContext(ctx)
+	log.Errorf("Document processing panicked, doc: %s, err: %v, stack: %s", doc.ID, pe, pe.stack)
+
+	recordDocumentLog(ctx, m.db, db.DocumentLog{
+		DocumentID: doc.ID,
+		Stage:      stage,
+		Level:      db.LogLevelError,
+		Message:    "failed due to an internal error",
+	})
+	err := m.db.FailDocument(ctx, doc.ID, stage, doc.Attempts+1, truncateError(pe.Error()))
+	if err != nil {
+		log.Errorf("Failed to update document status, doc: %s, err: %v", doc.ID, err)
+	}
+	recordEvent(ctx, m.db, db.Event{
+		UserID:     doc.UserID,
+		Type:       db.EventPipelinePanic,
+		DocumentID: doc.ID,
+		Message:    fmt.Sprintf("document %s failed due to an internal error", doc.Name),
+		Detail:     fmt.Sprintf("%v\n%s", pe, pe.stack),
+	})
+	m.publishProgress(ctx, doc.ID, ProgressDocumentFailed)
+	m.notifyDocument(ctx, doc.ID, WebhookDocumentFailed)
+}
@@ -1,40 +1,113 @@
 package svr
 
 import (
+	"context"
+	"errors"
+	"fmt"
 	"io"
+	"net/http"
 	"os"
+	"sync"
+	"sync/atomic"
+	"time"
 
 	"github.com/gin-gonic/gin"
+	"github.com/redis/go-redis/v9"
 	"go.uber.org/zap"
 
+	"imgagent/api"
 	"imgagent/bailian"
 	"imgagent/db"
+	"imgagent/embedding"
+	"imgagent/imagegen"
+	"imgagent/moderation"
+	"imgagent/pkg/cache"
 	"imgagent/pkg/dbutil"
+	"imgagent/pkg/dlock"
+	"imgagent/pkg/httpclient"
+	"imgagent/pkg/idempotency"
+	"imgagent/pkg/jobqueue"
+	"imgagent/pkg/mediastore"
 	"imgagent/pkg/middleware"
+	"imgagent/pkg/openapi"
+	"imgagent/pkg/pubsub"
+	"imgagent/pkg/redisutil"
+	"imgagent/pkg/tracing"
+	"imgagent/scanner"
+	"imgagent/spliter"
 	"imgagent/storage"
+	"imgagent/tts"
 )
 
 type Config struct {
-	APIVersion     string         `json:"api_version"`
-	Temp           string         `json:"temp"`
-	Storage        storage.Config `json:"storage"`
-	DB             dbutil.Config  `json:"db"`
-	BailianConfig  bailian.Config `json:"-"` // 从外部传入
-	DocumentConfig DocumentConfig `json:"-"` // 从外部传入
-}
-
-type EmbeddingConfig struct {
-	URL    string `json:"url"`
-	Model  string `json:"model"`
-	APIKey string `json:"api_key"`
+	APIVersion     string               `json:"api_version"`
+	PublicURL      string               `json:"public_url"` // 服务对外访问地址，用于生成占位媒体等服务端资源的 URL
+	Temp           string               `json:"temp"`
+	Storage        storage.Config       `json:"storage"`
+	DB             dbutil.Config        `json:"db"`
+	Quota          QuotaConfig          `json:"quota"`
+	Limit          LimitConfig          `json:"limit"`
+	Search         SearchConfig         `json:"search"`
+	Webhook        WebhookConfig        `json:"webhook"`
+	Export         ExportConfig         `json:"export"`
+	Backup         BackupConfig         `json:"backup"`         // 数据库备份保存在 storage 配置的对象存储中
+	Redis          redisutil.Config     `json:"redis"`          // 进度发布订阅、任务队列、分布式锁、幂等键和读缓存共用的 redis，多实例部署时必须配置
+	JobQueue       jobqueue.Config      `json:"job_queue"`      // 文档处理任务队列
+	Lock           dlock.Config         `json:"lock"`           // 文档处理的分布式锁，保证同一文档只由一个实例处理
+	Idempotency    idempotency.Config   `json:"idempotency"`    // 幂等键与响应的存储
+	Cache          cache.Config         `json:"cache"`          // 文档、章节列表、场景列表的读缓存
+	HTTPClient     httpclient.Config    `json:"http_client"`    // 访问用户提供的外部 URL（文件下载、webhook 投递）
+	URLPolicy      URLPolicyConfig      `json:"url_policy"`     // 通过 URL 创建文档的默认来源策略
+	TextClean      TextCleanConfig      `json:"text_clean"`     // 分割章节前的原文清洗
+	DocumentName   DocumentNameConfig   `json:"document_name"`  // 文档名的长度、字符和保留名称规则
+	Upload         UploadConfig         `json:"upload"`         // 原文文件的大小、扩展名和内容类型限制
+	StylePresets   []api.StylePreset    `json:"style_presets"`  // 追加或覆盖内置的画面风格预设
+	TTS            tts.Config           `json:"tts"`            // 场景语音合成服务，默认使用百炼
+	ImageGen       imagegen.Config      `json:"image_gen"`      // 场景图片生成服务，默认使用百炼，文档可单独指定
+	Moderation     moderation.Config    `json:"moderation"`     // 场景描述和生成图片的内容审核，未配置时不审核
+	Scanner        scanner.Config       `json:"scanner"`        // 上传原文的病毒扫描，未配置时不扫描
+	Embedding      embedding.Config     `json:"embedding"`      // 章节向量服务，用于文档内的语义搜索，未配置时不可用
+	PDFFootnotes   spliter.FootnoteMode `json:"pdf_footnotes"`  // PDF 脚注的处理方式 drop|append，默认删除
+	DedupChapters  bool                 `json:"dedup_chapters"` // 分割时删除与前文内容相同的重复章节，为 false 时只在上传报告中列出
+	Separators     []string             `json:"separators"`     // 分割章节的分隔符级别，如 ["<chapter>", "\n\n", "\n", "<sentence>"]，为空时使用 spliter.DefaultSeparators
+	BailianConfig  bailian.Config       `json:"-"`              // 从外部传入
+	DocumentConfig DocumentConfig       `json:"-"`              // 从外部传入
 }
 
 type Service struct {
 	conf          Config
 	db            db.IDataBase
+	redis         *redis.Client // 未配置 redis 时为 nil
 	stg           *storage.Storage
+	backups       objectStore
+	bgm           bgmStore
+	backupMu      sync.Mutex // 同一时间只执行一个备份或恢复
 	bailianClient *bailian.Client
+	ttsProvider   tts.Provider
+	audioStore    *mediastore.Store
+	imageGen      *imagegen.Providers
+	imageStore    *mediastore.Store
+	moderation    moderation.Provider
+	scanner       scanner.Provider
+	embedder      *chapterEmbedder
 	documentMgr   *DocumentMgr
+	quota         *QuotaMgr
+	limiter       *Limiter
+	indexer       *Indexer
+	webhooks      *WebhookMgr
+	exports       *ExportMgr
+	imports       *importRunner
+	cleaners      *textCleaners
+	names         *documentNameRules
+	uploads       *uploadRules
+	styles        *stylePresets
+	pubsub        pubsub.PubSub
+	queue         jobqueue.Queue
+	locker        dlock.Locker
+	idempotency   idempotency.Store
+	httpClient    *httpclient.Client
+	openAPI       *openapi.Document
+	reloadedAt    atomic.Pointer[time.Time] // 最近一次运行时修改配置的时间
 }
 
 func New(conf Config, bailianClient *bailian.Client) (*Service, error) {
@@ -47,23 +120,124 @@ func New(conf Config, bailianClient *bailian.Client) (*Service, error) {
 		return nil, err
 	}
 
+	cleaners, err := newTextCleaners(conf.TextClean)
+	if err != nil {
+		zap.S().Errorf("Failed to compile text clean rules, err: %v", err)
+		return nil, err
+	}
+	names, err := newDocumentNameRules(conf.DocumentName)
+	if err != nil {
+		zap.S().Errorf("Failed to compile document name rules, err: %v", err)
+		return nil, err
+	}
+	uploads, err := newUploadRules(conf.Upload)
+	if err != nil {
+		zap.S().Errorf("Failed to load upload rules, err: %v", err)
+		return nil, err
+	}
+	if p := conf.DocumentConfig.BailianFilePrefix; p != "" && !bailianFilePrefixPattern.MatchString(p) {
+		zap.S().Errorf("Invalid bailian file prefix: %s", p)
+		return nil, fmt.Errorf("invalid bailian file prefix %q", p)
+	}
+	styles, err := newStylePresets(conf.StylePresets)
+	if err != nil {
+		zap.S().Errorf("Failed to load style presets, err: %v", err)
+		return nil, err
+	}
+
 	stg, err := storage.NewStorage(conf.Storage)
 	if err != nil {
 		zap.S().Errorf("Failed to new storage, err: %v", err)
 		return nil, err
 	}
-	db, err := db.NewDatabase(conf.DB)
+	rdb := redisutil.NewClient(conf.Redis)
+	db, err := openDatabase(conf, rdb)
 	if err != nil {
 		zap.S().Errorf("Failed to new database, err: %v", err)
 		return nil, err
 	}
 
+	if conf.TTS.Dir == "" {
+		conf.TTS.Dir = "./audio"
+	}
+	audioStore := mediastore.New(conf.TTS.Dir, conf.PublicURL+conf.APIVersion+"/audio")
+	ttsProvider, err := tts.New(conf.TTS, bailianClient, audioStore)
+	if err != nil {
+		zap.S().Errorf("Failed to new tts provider, err: %v", err)
+		return nil, err
+	}
+
+	if conf.ImageGen.Dir == "" {
+		conf.ImageGen.Dir = "./images"
+	}
+	imageStore := mediastore.New(conf.ImageGen.Dir, conf.PublicURL+conf.APIVersion+"/images")
+	imageGen, err := imagegen.New(conf.ImageGen, bailianClient, imageStore)
+	if err != nil {
+		zap.S().Errorf("Failed to new image providers, err: %v", err)
+		return nil, err
+	}
+	moderator, err := moderation.New(conf.Moderation)
+	if err != nil {
+		zap.S().Errorf("Failed to new moderation provider, err: %v", err)
+		return nil, err
+	}
+	fileScanner, err := scanner.New(conf.Scanner)
+	if err != nil {
+		zap.S().Errorf("Failed to new scanner provider, err: %v", err)
+		return nil, err
+	}
+	embeddingProvider, err := embedding.New(conf.Embedding)
+	if err != nil {
+		zap.S().Errorf("Failed to new embedding provider, err: %v", err)
+		return nil, err
+	}
+	embedder := newChapterEmbedder(db, embeddingProvider, nil)
+
+	ps := pubsub.New(rdb)
+	queue := jobqueue.New(conf.JobQueue, rdb)
+	locker := dlock.New(conf.Lock, rdb)
+
+	var webhooks *WebhookMgr
+	if conf.Webhook.Enable {
+		webhooks = newWebhookMgr(conf.Webhook, conf.HTTPClient, db)
+		webhooks.Run()
+		zap.S().Info("Webhook dispatcher started")
+	}
+	quota := newQuotaMgr(conf.Quota, db)
+	quota.webhooks = webhooks
+
+	var exports *ExportMgr
+	if conf.Export.Enable {
+		exports = newExportMgr(conf.Export, db)
+		err = exports.Run()
+		if err != nil {
+			zap.S().Errorf("Failed to run export manager, err: %v", err)
+			return nil, err
+		}
+		zap.S().Info("Export manager started")
+	}
+
 	// 创建文档管理器
 	var docMgr *DocumentMgr
 	if conf.DocumentConfig.Enable {
 		confEx := DocumentConfigEx{
-			config: conf.DocumentConfig,
-			db:     db,
+			config:   conf.DocumentConfig,
+			db:       db,
+			quota:    quota,
+			webhooks: webhooks,
+			pubsub:   ps,
+			queue:    queue,
+			locker:   locker,
+			tts:      ttsProvider,
+			images:   imageGen,
+			styles:   styles,
+
+			moderation: moderator,
+			imageStore: imageStore,
+			audioStore: audioStore,
+			embedder:   embedder,
+
+			mediaBaseURL: conf.PublicURL + conf.APIVersion,
 		}
 		var err error
 		docMgr, err = newDocumentMgr(confEx, bailianClient)
@@ -76,15 +250,111 @@ func New(conf Config, bailianClient *bailian.Client) (*Service, error) {
 		zap.S().Info("Document manager started")
 	}
 
+	var indexer *Indexer
+	if conf.Search.Enable {
+		indexer = newIndexer(conf.Search, db)
+		indexer.Run()
+		zap.S().Info("Search indexer started")
+	}
+
 	return &Service{
 		conf:          conf,
 		db:            db,
+		redis:         rdb,
 		stg:           stg,
+		backups:       stg,
+		bgm:           stg,
 		bailianClient: bailianClient,
+		ttsProvider:   ttsProvider,
+		audioStore:    audioStore,
+		imageGen:      imageGen,
+		imageStore:    imageStore,
+		moderation:    moderator,
+		scanner:       fileScanner,
+		embedder:      embedder,
 		documentMgr:   docMgr,
+		quota:         quota,
+		limiter:       newLimiter(conf.Limit, conf.PublicURL+conf.APIVersion),
+		indexer:       indexer,
+		webhooks:      webhooks,
+		exports:       exports,
+		imports:       newImportRunner(),
+		cleaners:      cleaners,
+		names:         names,
+		uploads:       uploads,
+		styles:        styles,
+		pubsub:        ps,
+		queue:         queue,
+		locker:        locker,
+		idempotency:   idempotency.New(conf.Idempotency, rdb),
+		httpClient: httpclient.New(conf.HTTPClient, func(rt http.RoundTripper) http.RoundTripper {
+			return tracing.NewTransport(rt)
+		}),
 	}, nil
 }
 
+// Shutdown 在 HTTP 服务停止接收请求后调用：停止批量导入，停止文档管理器领取新任务并等待处理中的文档，
+// 等待排队的异步任务执行完，停止索引任务、回调投递和导出任务，最后关闭数据库
+func (s *Service) Shutdown(ctx context.Context) error {
+	var errs []error
+	err := s.imports.Stop(ctx)
+	if err != nil {
+		zap.S().Errorf("Failed to stop bulk import, err: %v", err)
+		errs = append(errs, err)
+	}
+	if s.documentMgr != nil {
+		err = s.documentMgr.Stop(ctx)
+		if err != nil {
+			zap.S().Errorf("Failed to stop document manager, err: %v", err)
+			errs = append(errs, err)
+		}
+	}
+	err = s.limiter.Wait(ctx)
+	if err != nil {
+		zap.S().Errorf("Failed to wait queued jobs, err: %v", err)
+		errs = append(errs, err)
+	}
+	if s.indexer != nil {
+		err = s.indexer.Stop(ctx)
+		if err != nil {
+			zap.S().Errorf("Failed to stop search indexer, err: %v", err)
+			errs = append(errs, err)
+		}
+	}
+	if s.webhooks != nil {
+		err = s.webhooks.Stop(ctx)
+		if err != nil {
+			zap.S().Errorf("Failed to stop webhook dispatcher, err: %v", err)
+			errs = append(errs, err)
+		}
+	}
+	if s.exports != nil {
+		err = s.exports.Stop(ctx)
+		if err != nil {
+			zap.S().Errorf("Failed to stop export manager, err: %v", err)
+			errs = append(errs, err)
+		}
+	}
+	s.pubsub.Close()
+	s.queue.Close()
+	s.locker.Close()
+	s.idempotency.Close()
+	s.db.Close()
+	if s.redis != nil {
+		s.redis.Close()
+	}
+	return errors.Join(errs...)
+}
+
+// openDatabase 打开数据库并加上读缓存，命令行工具也通过它打开，保证写路径同样失效缓存
+func openDatabase(conf Config, rdb *redis.Client) (db.IDataBase, error) {
+	database, err := db.NewDatabase(conf.DB)
+	if err != nil {
+		return nil, err
+	}
+	return db.NewCachedDatabase(database, cache.New(conf.Cache, rdb)), nil
+}
+
 func (s *Service) RegisterRouter(writer io.Writer) *gin.Engine {
 	router := middleware.NewRouter(writer)
 	api := router.Group(s.conf.APIVersion)
@@ -93,26 +363,135 @@ func (s *Service) RegisterRouter(writer io.Writer) *gin.Engine {
 	authGroup.Use(s.NilAuth())
 
 	// Document
-	authGroup.POST("/documents", s.HandleCreateDocument)
+	authGroup.POST("/documents", s.Audit(auditDocument, "create"), s.QuotaWarning(), s.Idempotent(), s.HandleCreateDocument)
+	authGroup.POST("/documents:action", s.QuotaWarning(), s.Idempotent(), s.HandleDocumentsAction)
 	authGroup.GET("/documents/:document_id", s.HandleGetDocument)
-	authGroup.PUT("/documents/:document_id", s.HandleUpdateDocument)
-	authGroup.DELETE("/documents/:document_id", s.HandleDeleteDocument)
-	authGroup.GET("/documents", s.HandleListDocuments)
+	authGroup.PUT("/documents/:document_id", s.Audit(auditDocument, "update"), s.HandleUpdateDocument)
+	authGroup.DELETE("/documents/:document_id", s.Audit(auditDocument, "delete"), s.HandleDeleteDocument)
+	authGroup.POST("/documents/:document_id", s.Audit(auditDocument, ""), s.HandleDocumentAction)
+	authGroup.POST("/documents/:document_id/pause", s.Audit(auditDocument, "pause"), s.HandlePauseDocument)
+	authGroup.POST("/documents/:document_id/resume", s.Audit(auditDocument, "resume"), s.HandleResumeDocument)
+	authGroup.GET("/documents", s.ReadReplica(), s.HandleListDocuments)
+	authGroup.GET("/documents/:document_id/events", s.HandleDocumentEvents)
+	authGroup.GET("/documents/:document_id/progress", s.HandleGetDocumentProgress)
+	authGroup.GET("/documents/:document_id/logs", s.HandleGetDocumentLogs)
+	authGroup.GET("/documents/:document_id/ingest-report", s.HandleGetIngestReport)
+	authGroup.GET("/documents/:document_id/search", s.HandleSemanticSearch)
+	authGroup.POST("/documents/:document_id/ask", s.HandleAskDocument)
+	authGroup.GET("/imports/:id", s.HandleGetImport)
+	authGroup.GET("/style-presets", s.HandleListStylePresets)
 
 	// Chapter
 	authGroup.GET("/documents/:document_id/chapters/:id", s.HandleGetChapter)
-	authGroup.PUT("/documents/:document_id/chapters/:id", s.HandleUpdateChapter)
-	authGroup.DELETE("/documents/:document_id/chapters/:id", s.HandleDeleteChapter)
-	authGroup.GET("/documents/:document_id/chapters", s.HandleListChapters)
+	authGroup.GET("/documents/:document_id/chapters/:id/summary", s.HandleStreamChapterSummary)
+	authGroup.PUT("/documents/:document_id/chapters/:id", s.Audit(auditChapter, "update"), s.HandleUpdateChapter)
+	authGroup.DELETE("/documents/:document_id/chapters/:id", s.Audit(auditChapter, "delete"), s.HandleDeleteChapter)
+	authGroup.GET("/documents/:document_id/chapters", s.ReadReplica(), s.HandleListChapters)
+	authGroup.POST("/documents/:document_id/chapters:action", s.Audit(auditChapters, ""), s.HandleChaptersAction)
+	authGroup.POST("/documents/:document_id/chapters/:id", s.Audit(auditChapter, ""), s.HandleChapterAction)
+	authGroup.GET("/documents/:document_id/duplicate-chapters", s.HandleListChapterDuplicates)
 
 	// Role
 	authGroup.GET("/documents/:document_id/roles", s.HandleGetRoles)
-	authGroup.PUT("/roles/:id", s.HandleUpdateRole)
+	authGroup.GET("/documents/:document_id/roles/graph", s.HandleGetRoleGraph)
+	authGroup.POST("/documents/:document_id/roles:action", s.Audit(auditRoles, ""), s.HandleRolesAction)
+	authGroup.PUT("/roles/:id", s.Audit(auditRole, "update"), s.HandleUpdateRole)
 
 	// Scene
-	authGroup.GET("/documents/:document_id/scenes", s.HandleListScenesByDocument)
-	authGroup.GET("/chapters/:chapter_id/scenes", s.HandleListScenesByChapter)
-	authGroup.PUT("/scenes/:id", s.HandleUpdateScene)
+	authGroup.GET("/documents/:document_id/scenes", s.ReadReplica(), s.HandleListScenesByDocument)
+	authGroup.GET("/documents/:document_id/manifest", s.HandleGetManifest)
+	authGroup.GET("/documents/:document_id/runs", s.ReadReplica(), s.HandleListDocumentRuns)
+	authGroup.GET("/documents/:document_id/runs/:a/compare/:b", s.HandleCompareDocumentRuns)
+
+	// Export
+	authGroup.GET("/documents/:document_id/export", s.HandleExportDocument)
+	authGroup.POST("/documents/:document_id/exports", s.Idempotent(), s.HandleCreateExport)
+	authGroup.GET("/documents/:document_id/exports", s.ReadReplica(), s.HandleListExports)
+	authGroup.GET("/exports/:id/download", s.HandleDownloadExport)
+	authGroup.DELETE("/exports/:id", s.HandleDeleteExport)
+	authGroup.GET("/chapters/:chapter_id/scenes", s.ReadReplica(), s.HandleListScenesByChapter)
+	authGroup.POST("/chapters/:chapter_id/scenes", s.Audit(auditScene, "create"), s.Idempotent(), s.HandleCreateScene)
+	authGroup.PUT("/scenes/:id", s.Audit(auditScene, "update"), s.QuotaWarning(), s.Idempotent(), s.HandleUpdateScene)
+	authGroup.PATCH("/scenes/:id", s.Audit(auditScene, "edit"), s.Idempotent(), s.HandleEditScene)
+	authGroup.GET("/scenes/:id/generations", s.HandleListSceneGenerations)
+	authGroup.GET("/scenes/:id/prompt-preview", s.HandlePreviewScenePrompt)
+	authGroup.POST("/scenes/:id/generations/:n/activate", s.Audit(auditScene, "activate_generation"), s.HandleActivateSceneGeneration)
+	authGroup.GET("/scenes/:id/image/history", s.HandleListSceneImageVersions)
+	authGroup.POST("/scenes/:id/image:action", s.Audit(auditScene, ""), s.QuotaWarning(), s.HandleSceneImageAction)
+
+	// Reading
+	authGroup.GET("/documents/:document_id/bookmarks", s.HandleListBookmarks)
+	authGroup.POST("/documents/:document_id/bookmarks", s.HandleCreateBookmark)
+	authGroup.DELETE("/documents/:document_id/bookmarks/:id", s.HandleDeleteBookmark)
+	authGroup.GET("/documents/:document_id/position", s.HandleGetReadPosition)
+	authGroup.PUT("/documents/:document_id/position", s.HandleUpdateReadPosition)
+
+	// Search
+	authGroup.GET("/search", s.ReadReplica(), s.HandleSearch)
+
+	// Webhook
+	authGroup.POST("/webhooks", s.HandleCreateWebhook)
+	authGroup.GET("/webhooks", s.HandleListWebhooks)
+	authGroup.DELETE("/webhooks/:id", s.HandleDeleteWebhook)
+
+	// Tenant，管理租户策略需要超级管理员认证
+	tenantGroup := api.Group("/tenants")
+	tenantGroup.Use(s.Auth(), s.SuperAdminOnly())
+	tenantGroup.GET("/:user_id/url_policy", s.HandleGetURLPolicy)
+	tenantGroup.PUT("/:user_id/url_policy", s.HandleUpdateURLPolicy)
+	tenantGroup.DELETE("/:user_id/url_policy", s.HandleDeleteURLPolicy)
+
+	// Admin，不经过 NilAuth，必须使用超级管理员的 token 访问
+	adminGroup := api.Group("/admin")
+	adminGroup.Use(s.Auth(), s.SuperAdminOnly())
+	adminGroup.GET("/failed-jobs", s.ReadReplica(), s.HandleListFailedJobs)
+	adminGroup.POST("/failed-jobs/documents/:id/requeue", s.Audit(auditDocument, "requeue"), s.Idempotent(), s.HandleRequeueDocument)
+	adminGroup.POST("/failed-jobs/scenes/:id/requeue", s.Audit(auditScene, "requeue"), s.Idempotent(), s.HandleRequeueScene)
+	adminGroup.GET("/moderation/scenes", s.ReadReplica(), s.HandleListBlockedScenes)
+	adminGroup.POST("/moderation/scenes/:id/approve", s.Audit(auditScene, "approve"), s.Idempotent(), s.HandleApproveBlockedScene)
+	adminGroup.POST("/moderation/scenes/:id/regenerate", s.Audit(auditScene, "regenerate"), s.Idempotent(), s.HandleRegenerateBlockedScene)
+	adminGroup.GET("/prompt-templates", s.HandleListPromptTemplates)
+	adminGroup.GET("/prompt-templates/:kind", s.HandleGetPromptTemplate)
+	adminGroup.PUT("/prompt-templates/:kind", s.Audit(auditPromptTemplate, "update"), s.HandleUpdatePromptTemplate)
+	adminGroup.DELETE("/prompt-templates/:kind", s.Audit(auditPromptTemplate, "delete"), s.HandleDeletePromptTemplate)
+	adminGroup.POST("/selftest", s.HandleSelfTest)
+	adminGroup.GET("/audit-logs", s.HandleListAuditLogs)
+	adminGroup.GET("/config", s.HandleGetRuntimeConfig)
+	adminGroup.POST("/backup", s.HandleCreateBackup)
+	adminGroup.POST("/restore", s.Audit(auditBackup, "restore"), s.HandleRestoreBackup)
+	adminGroup.POST("/bgm", s.HandleCreateBGMTrack)
+	adminGroup.DELETE("/bgm/:id", s.HandleDeleteBGMTrack)
+
+	// BGM
+	authGroup.GET("/bgm", s.HandleListBGMTracks)
+
+	// Quota
+	authGroup.GET("/quota", s.QuotaWarning(), s.HandleGetQuota)
+	authGroup.GET("/limits", s.HandleGetLimits)
+
+	// Activity
+	authGroup.GET("/activity", s.ReadReplica(), s.HandleListActivity)
+
+	// Stats
+	authGroup.GET("/stats/summary", s.ReadReplica(), s.HandleGetStatsSummary)
+
+	// Job
+	authGroup.GET("/jobs/:id", s.HandleGetJob)
+
+	// Operation
+	authGroup.GET("/operations/:id", s.HandleGetOperation)
+
+	// Placeholder 媒体需要能被播放器直接引用，不经过认证
+	api.GET("/scenes/:id/placeholder/image", s.HandleGetPlaceholderImage)
+	api.GET("/scenes/:id/placeholder/voice", s.HandleGetPlaceholderVoice)
+	api.GET("/audio/:name", s.HandleGetAudio)
+	api.GET("/images/:name", s.HandleGetImage)
+
+	// OpenAPI 文档
+	s.openAPI = openapi.Build(openapi.Info{Title: "imgagent API", Version: s.conf.APIVersion}, s.openAPIRoutes())
+	router.GET(openAPIPath, s.HandleOpenAPI)
+	router.GET(swaggerUIPath, s.HandleSwaggerUI)
+	router.GET(swaggerAssetsPath+"/*filepath", s.HandleSwaggerAssets)
 
 	return router
 }
@@ -0,0 +1,93 @@
+package svr
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"slices"
+
+	"imgagent/api"
+	"imgagent/bailian"
+	hutil "imgagent/httputil"
+	"imgagent/pkg/logger"
+)
+
+const (
+	// synopsisBatchSize 每次归纳的摘要数，章节较多时先分批归纳为阶段梗概，再归纳阶段梗概
+	synopsisBatchSize = 20
+	// maxSynopsisRunes 与 documents.synopsis 字段的长度一致
+	maxSynopsisRunes = 2000
+)
+
+// SummarizeDocument 生成全书梗概：先为尚无摘要的章节生成并保存摘要（map），再分批归纳各章摘要（reduce），
+// 结果保存到文档后返回。已有的章节摘要直接复用，章节内容修改后摘要清空，重新生成时只补齐这些章节
+func (s *Service) SummarizeDocument(ctx context.Context, docID string) (*api.Document, error) {
+	log := logger.FromContext(ctx)
+
+	if s.bailianClient == nil {
+		return nil, hutil.NewApiError(http.StatusBadRequest, "bailian client not configured")
+	}
+	doc, err := s.db.GetDocument(ctx, docID)
+	if err != nil {
+		log.Errorf("Failed to get document, id: %s, err: %v", docID, err)
+		return nil, documentError(err, "get document failed")
+	}
+	chapters, err := s.db.ListChapters(ctx, docID)
+	if err != nil {
+		log.Errorf("Failed to list chapters, docID: %s, err: %v", docID, err)
+		return nil, hutil.NewApiError(hutil.ErrServerInternalCode, "list chapters failed")
+	}
+	if len(chapters) == 0 {
+		return nil, hutil.NewApiError(http.StatusBadRequest, "document has no chapters")
+	}
+
+	log.Infof("Summarize document, docID: %s, chapters: %d", docID, len(chapters))
+	summaries := make([]string, 0, len(chapters))
+	for i := range chapters {
+		ch := &chapters[i]
+		if ch.Summary == "" {
+			summary, err := s.bailianClient.SummarizeChapterStream(ctx, ch.Content, func(string) error { return nil })
+			if err != nil {
+				log.Errorf("Failed to summarize chapter, id: %s, err: %v", ch.ID, err)
+				return nil, hutil.NewApiError(hutil.ErrServerInternalCode, "summarize chapter failed")
+			}
+			ch.Summary = truncateSummary(summary)
+			if err = s.db.UpdateChapterSummary(ctx, ch.ID, ch.Summary); err != nil {
+				log.Warnf("Failed to save chapter summary, id: %s, err: %v", ch.ID, err)
+			}
+		}
+		summaries = append(summaries, fmt.Sprintf("%s：%s", ch.Title, ch.Summary))
+	}
+
+	synopsis, err := s.reduceSynopsis(ctx, doc.Name, summaries)
+	if err != nil {
+		log.Errorf("Failed to summarize document, docID: %s, err: %v", docID, err)
+		return nil, hutil.NewApiError(hutil.ErrServerInternalCode, "summarize document failed")
+	}
+	if runes := []rune(synopsis.Synopsis); len(runes) > maxSynopsisRunes {
+		synopsis.Synopsis = string(runes[:maxSynopsisRunes])
+	}
+	if err = s.db.UpdateDocumentSynopsis(ctx, docID, synopsis.Synopsis, synopsis.PlotPoints); err != nil {
+		log.Errorf("Failed to save document synopsis, docID: %s, err: %v", docID, err)
+		return nil, documentError(err, "save document synopsis failed")
+	}
+	doc.Synopsis, doc.PlotPoints = synopsis.Synopsis, synopsis.PlotPoints
+	ret := makeDocument(&doc)
+	return &ret, nil
+}
+
+// reduceSynopsis 摘要超过 synopsisBatchSize 时分批归纳为阶段梗概，直到可以一次归纳出全书梗概
+func (s *Service) reduceSynopsis(ctx context.Context, name string, summaries []string) (bailian.DocumentSynopsis, error) {
+	for len(summaries) > synopsisBatchSize {
+		reduced := make([]string, 0, (len(summaries)+synopsisBatchSize-1)/synopsisBatchSize)
+		for batch := range slices.Chunk(summaries, synopsisBatchSize) {
+			ret, err := s.bailianClient.SummarizeDocument(ctx, name, batch)
+			if err != nil {
+				return bailian.DocumentSynopsis{}, err
+			}
+			reduced = append(reduced, ret.Synopsis)
+		}
+		summaries = reduced
+	}
+	return s.bailianClient.SummarizeDocument(ctx, name, summaries)
+}
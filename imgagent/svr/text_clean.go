@@ -0,0 +1,51 @@
+package svr
+
+import (
+	"fmt"
+	"strconv"
+
+	"imgagent/pkg/textclean"
+)
+
+// TextCleanConfig 分割前的原文清洗配置，Default 为所有租户的默认配置，Tenants 按用户 id 覆盖默认配置，
+// 租户配置整体替换默认配置，需要默认规则时在租户配置中重复填写
+type TextCleanConfig struct {
+	Default textclean.Config            `json:"default"`
+	Tenants map[string]textclean.Config `json:"tenants"`
+}
+
+type textCleaners struct {
+	def     *textclean.Cleaner
+	tenants map[string]*textclean.Cleaner
+}
+
+// newTextCleaners 启动时编译所有租户的规则，规则不合法时拒绝启动
+func newTextCleaners(conf TextCleanConfig) (*textCleaners, error) {
+	def, err := textclean.New(conf.Default)
+	if err != nil {
+		return nil, fmt.Errorf("text clean default: %w", err)
+	}
+	t := &textCleaners{
+		def:     def,
+		tenants: make(map[string]*textclean.Cleaner, len(conf.Tenants)),
+	}
+	for id, c := range conf.Tenants {
+		t.tenants[id], err = textclean.New(c)
+		if err != nil {
+			return nil, fmt.Errorf("text clean tenant %s: %w", id, err)
+		}
+	}
+	return t, nil
+}
+
+// cleanFunc 获取租户的清洗函数，未配置清洗时返回 nil
+func (t *textCleaners) cleanFunc(userID int64) func(string) string {
+	if t == nil {
+		return nil
+	}
+	c, ok := t.tenants[strconv.FormatInt(userID, 10)]
+	if !ok {
+		c = t.def
+	}
+	return c.Clean
+}
@@ -0,0 +1,248 @@
+package svr
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"gorm.io/gorm"
+
+	"imgagent/api"
+	"imgagent/db"
+	hutil "imgagent/httputil"
+	"imgagent/pkg/logger"
+	"imgagent/pkg/tracing"
+)
+
+// translationLanguages 支持的翻译目标语言，值为提示词中的语言名称
+var translationLanguages = map[string]string{
+	"en":      "英语",
+	"ja":      "日语",
+	"ko":      "韩语",
+	"fr":      "法语",
+	"de":      "德语",
+	"es":      "西班牙语",
+	"ru":      "俄语",
+	"zh-Hant": "繁体中文",
+}
+
+func checkTranslationLang(lang string) error {
+	if _, ok := translationLanguages[lang]; !ok {
+		return hutil.NewApiError(http.StatusBadRequest, "unsupported lang")
+	}
+	return nil
+}
+
+// HandleTranslateDocument 创建文档的翻译任务，由 DocumentMgr 在后台逐章翻译
+func (s *Service) HandleTranslateDocument(c *gin.Context, id string) {
+	log := logger.FromGinContext(c)
+
+	var args api.TranslateDocumentArgs
+	if err := c.ShouldBindJSON(&args); err != nil {
+		log.Errorf("Invalid request body, err: %v", err)
+		hutil.AbortError(c, http.StatusBadRequest, "invalid request body")
+		return
+	}
+	ret, err := s.TranslateDocument(c.Request.Context(), GetUserInfo(c).ID, id, args.Lang)
+	if err != nil {
+		hutil.AbortErr(c, err)
+		return
+	}
+	hutil.WriteAccepted(c, ret)
+}
+
+// TranslateDocument 翻译任务待执行或执行中时直接返回该任务；已结束时重新执行，只翻译尚无译文或原文已修改的章节
+func (s *Service) TranslateDocument(ctx context.Context, userID int64, docID, lang string) (*api.Translation, error) {
+	log := logger.FromContext(ctx)
+
+	if err := checkTranslationLang(lang); err != nil {
+		return nil, err
+	}
+	_, err := s.db.GetDocument(ctx, docID)
+	if err != nil {
+		log.Errorf("Failed to get document, id: %s, err: %v", docID, err)
+		return nil, documentError(err, "get document failed")
+	}
+
+	log.Infof("Translate document, docID: %s, lang: %s", docID, lang)
+	t, err := s.db.StartTranslation(ctx, userID, docID, lang)
+	if err != nil {
+		log.Errorf("Failed to start translation, docID: %s, lang: %s, err: %v", docID, lang, err)
+		return nil, hutil.NewApiError(hutil.ErrServerInternalCode, "translate document failed")
+	}
+	ret := makeTranslation(&t)
+	return &ret, nil
+}
+
+// GetTranslation 返回文档在 lang 上的翻译任务，尚未翻译时返回 nil
+func (s *Service) GetTranslation(ctx context.Context, docID, lang string) (*api.Translation, error) {
+	if err := checkTranslationLang(lang); err != nil {
+		return nil, err
+	}
+	t, err := s.db.GetTranslation(ctx, docID, lang)
+	if errors.Is(err, gorm.ErrRecordNotFound) {
+		return nil, nil
+	}
+	if err != nil {
+		logger.FromContext(ctx).Errorf("Failed to get translation, docID: %s, lang: %s, err: %v", docID, lang, err)
+		return nil, hutil.NewApiError(hutil.ErrServerInternalCode, "get translation failed")
+	}
+	ret := makeTranslation(&t)
+	return &ret, nil
+}
+
+// applyTranslations 用 lang 的译文替换章节的标题和内容。只使用与当前原文一致的译文，
+// 尚未翻译或原文在翻译后修改过的章节保持原文，Lang 为空
+func (s *Service) applyTranslations(ctx context.Context, docID, lang string, chapters []api.Chapter) error {
+	if err := checkTranslationLang(lang); err != nil {
+		return err
+	}
+	translations, err := s.db.ListChapterTranslations(ctx, docID, lang)
+	if err != nil {
+		logger.FromContext(ctx).Errorf("Failed to list chapter translations, docID: %s, lang: %s, err: %v", docID, lang, err)
+		return hutil.NewApiError(hutil.ErrServerInternalCode, "list chapter translations failed")
+	}
+	byID := make(map[string]*db.ChapterTranslation, len(translations))
+	for i := range translations {
+		byID[translations[i].ChapterID] = &translations[i]
+	}
+	for i := range chapters {
+		ch := &chapters[i]
+		t, ok := byID[ch.ID]
+		if !ok || t.SourceHash != chapterHash(&db.Chapter{Title: ch.Title, Content: ch.Content}) {
+			continue
+		}
+		ch.Title = t.Title
+		ch.Content = t.Content
+		ch.Lang = lang
+	}
+	return nil
+}
+
+func makeTranslation(t *db.DocumentTranslation) api.Translation {
+	return api.Translation{
+		DocumentID: t.DocumentID,
+		Lang:       t.Lang,
+		Status:     t.Status,
+		Translated: t.Translated,
+		Total:      t.Total,
+		Error:      t.Error,
+		CreatedAt:  t.CreatedAt.Format(time.DateTime),
+		UpdatedAt:  t.UpdatedAt.Format(time.DateTime),
+	}
+}
+
+func (m *DocumentMgr) loopHandleTranslationTasks() {
+	ticker := time.NewTicker(time.Second * time.Duration(m.config.HandleTranslationIntervalSecs))
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ticker.C:
+			ctx, span := tracing.Start(logger.NewContext(fmt.Sprintf("HandleTranslationTasks-%d", time.Now().Unix())), "DocumentMgr.HandleTranslationTasks")
+			m.HandleTranslationTasks(logger.WithTrace(ctx))
+			span.End()
+		case <-m.close:
+			return
+		}
+	}
+}
+
+// HandleTranslationTasks 领取并执行待执行的翻译任务
+func (m *DocumentMgr) HandleTranslationTasks(ctx context.Context) {
+	log := logger.FromContext(ctx)
+
+	tasks, err := m.db.ListPendingTranslations(ctx, 10)
+	if err != nil {
+		log.Errorf("Failed to list pending translations, err: %v", err)
+		return
+	}
+	for _, t := range tasks {
+		if m.stopping() {
+			return
+		}
+		// 状态已变化说明任务被其他实例领取
+		err = m.db.TransitTranslation(ctx, t.DocumentID, t.Lang, []string{db.TranslationStatusPending}, db.TranslationStatusRunning)
+		if err != nil {
+			if !errors.Is(err, gorm.ErrRecordNotFound) {
+				log.Errorf("Failed to claim translation, docID: %s, lang: %s, err: %v", t.DocumentID, t.Lang, err)
+			}
+			continue
+		}
+		m.runTranslation(ctx, t)
+	}
+}
+
+func (m *DocumentMgr) runTranslation(ctx context.Context, t db.DocumentTranslation) {
+	log := logger.FromContext(ctx)
+	log.Infof("Run translation, docID: %s, lang: %s", t.DocumentID, t.Lang)
+
+	err := m.translateDocument(ctx, t)
+	switch {
+	case err == nil:
+		err = m.db.CompleteTranslation(ctx, t.DocumentID, t.Lang, db.TranslationStatusSucceeded, "")
+	case errors.Is(err, errDocumentMgrStopping):
+		log.Infof("Translation interrupted by shutdown, docID: %s, lang: %s", t.DocumentID, t.Lang)
+		err = m.db.TransitTranslation(context.WithoutCancel(ctx), t.DocumentID, t.Lang, []string{db.TranslationStatusRunning}, db.TranslationStatusPending)
+	default:
+		log.Errorf("Failed to translate document, docID: %s, lang: %s, err: %v", t.DocumentID, t.Lang, err)
+		err = m.db.CompleteTranslation(ctx, t.DocumentID, t.Lang, db.TranslationStatusFailed, truncateError(err.Error()))
+	}
+	if err != nil {
+		log.Errorf("Failed to update translation, docID: %s, lang: %s, err: %v", t.DocumentID, t.Lang, err)
+	}
+}
+
+// translateDocument 逐章翻译，已有与当前原文一致译文的章节跳过，每章翻译后立即保存，中断后从剩余章节继续
+func (m *DocumentMgr) translateDocument(ctx context.Context, t db.DocumentTranslation) error {
+	log := logger.FromContext(ctx)
+
+	chapters, err := m.db.ListChapters(ctx, t.DocumentID)
+	if err != nil {
+		return fmt.Errorf("list chapters: %w", err)
+	}
+	translations, err := m.db.ListChapterTranslations(ctx, t.DocumentID, t.Lang)
+	if err != nil {
+		return fmt.Errorf("list chapter translations: %w", err)
+	}
+	hashes := make(map[string]string, len(translations))
+	for _, ct := range translations {
+		hashes[ct.ChapterID] = ct.SourceHash
+	}
+
+	language := translationLanguages[t.Lang]
+	translated := 0
+	for i := range chapters {
+		if m.stopping() {
+			return errDocumentMgrStopping
+		}
+		ch := &chapters[i]
+		hash := chapterHash(ch)
+		if hashes[ch.ID] != hash {
+			ret, err := m.bailianClient.TranslateChapter(ctx, ch.Title, ch.Content, language)
+			if err != nil {
+				return fmt.Errorf("translate chapter %d: %w", ch.Index, err)
+			}
+			err = m.db.SaveChapterTranslation(ctx, &db.ChapterTranslation{
+				ChapterID:  ch.ID,
+				Lang:       t.Lang,
+				DocumentID: t.DocumentID,
+				Title:      ret.Title,
+				Content:    ret.Content,
+				SourceHash: hash,
+			})
+			if err != nil {
+				return fmt.Errorf("save chapter %d translation: %w", ch.Index, err)
+			}
+		}
+		translated++
+		if err = m.db.UpdateTranslationProgress(ctx, t.DocumentID, t.Lang, translated, len(chapters)); err != nil {
+			log.Warnf("Failed to update translation progress, docID: %s, lang: %s, err: %v", t.DocumentID, t.Lang, err)
+		}
+	}
+	log.Infof("Document translated, docID: %s, lang: %s, chapters: %d", t.DocumentID, t.Lang, len(chapters))
+	return nil
+}
@@ -0,0 +1,178 @@
+package svr
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"io"
+	"mime"
+	"net/http"
+	"os"
+	"slices"
+	"sort"
+	"strings"
+
+	hutil "imgagent/httputil"
+	"imgagent/pkg/logger"
+)
+
+const (
+	// defaultMaxUploadBytes 未配置 max_bytes 时单个原文文件的最大字节数
+	defaultMaxUploadBytes = 100 << 20
+	// maxUploadFormOverhead 上传请求中文件以外的表单字段和 multipart 分隔的最大字节数
+	maxUploadFormOverhead = 1 << 20
+	// sniffLen 检测内容类型读取的字节数，与 http.DetectContentType 一致
+	sniffLen = 512
+	// oleMIME .doc 使用的 OLE 复合文档格式，http.DetectContentType 不能识别
+	oleMIME = "application/x-ole-storage"
+)
+
+var oleSignature = []byte{0xd0, 0xcf, 0x11, 0xe0, 0xa1, 0xb1, 0x1a, 0xe1}
+
+// documentMIMEs 原文扩展名允许的内容类型。纯文本以 HTML、XML 标签开头时会被识别为对应类型，
+// docx 按 zip 识别
+var documentMIMEs = map[string][]string{
+	".txt":  {"text/plain", "text/html", "text/xml"},
+	".md":   {"text/plain", "text/html", "text/xml"},
+	".pdf":  {"application/pdf"},
+	".docx": {"application/zip"},
+	".doc":  {oleMIME},
+}
+
+// UploadConfig 原文文件的校验规则，对上传、通过 URL 和 gRPC 创建以及批量导入的文件都生效
+type UploadConfig struct {
+	MaxBytes     int64    `json:"max_bytes"`     // 单个文件的最大字节数，默认 100MB
+	Extensions   []string `json:"extensions"`    // 允许的扩展名，如 [".txt", ".pdf"]，为空时允许全部支持的类型
+	DisableSniff bool     `json:"disable_sniff"` // 不检查文件内容的类型是否与扩展名一致
+}
+
+type uploadRules struct {
+	conf UploadConfig
+	exts map[string]bool
+}
+
+// newUploadRules 启动时校验配置，允许的扩展名超出支持的类型时拒绝启动
+func newUploadRules(conf UploadConfig) (*uploadRules, error) {
+	if conf.MaxBytes <= 0 {
+		conf.MaxBytes = defaultMaxUploadBytes
+	}
+	r := &uploadRules{conf: conf, exts: documentExts}
+	if len(conf.Extensions) > 0 {
+		r.exts = make(map[string]bool, len(conf.Extensions))
+		for _, ext := range conf.Extensions {
+			ext = strings.ToLower(strings.TrimSpace(ext))
+			if !strings.HasPrefix(ext, ".") {
+				ext = "." + ext
+			}
+			if !documentExts[ext] {
+				return nil, fmt.Errorf("upload extension %s is not supported", ext)
+			}
+			r.exts[ext] = true
+		}
+	}
+	return r, nil
+}
+
+func (r *uploadRules) maxBytes() int64 {
+	if r == nil {
+		return defaultMaxUploadBytes
+	}
+	return r.conf.MaxBytes
+}
+
+// allowed ext 为小写、带点的扩展名
+func (r *uploadRules) allowed(ext string) bool {
+	if r == nil {
+		return documentExts[ext]
+	}
+	return r.exts[ext]
+}
+
+// fileTypes 按字母序返回允许的扩展名
+func (r *uploadRules) fileTypes() []string {
+	exts := documentExts
+	if r != nil {
+		exts = r.exts
+	}
+	fileTypes := make([]string, 0, len(exts))
+	for ext := range exts {
+		fileTypes = append(fileTypes, ext)
+	}
+	sort.Strings(fileTypes)
+	return fileTypes
+}
+
+func (r *uploadRules) sizeError() error {
+	return hutil.NewApiError(http.StatusBadRequest, fmt.Sprintf("file exceeds maximum size of %d bytes", r.maxBytes()))
+}
+
+// Check 校验文件大小和扩展名，返回小写、带点的扩展名
+func (r *uploadRules) Check(filename string, size int64) (string, error) {
+	index := strings.LastIndex(filename, ".")
+	if index == -1 {
+		return "", hutil.NewApiError(http.StatusBadRequest, "file has no extension")
+	}
+	ext := strings.ToLower(filename[index:])
+	if !r.allowed(ext) {
+		return "", hutil.NewApiError(http.StatusBadRequest, "unsupported file type")
+	}
+	if size > r.maxBytes() {
+		return "", r.sizeError()
+	}
+	return ext, nil
+}
+
+// Sniff 读取文件开头检测内容类型，与扩展名不一致时返回错误。
+// 返回的 Reader 从文件开头读取，包含检测时已读取的内容
+func (r *uploadRules) Sniff(ext string, rd io.Reader) (io.Reader, error) {
+	if r != nil && r.conf.DisableSniff {
+		return rd, nil
+	}
+	head := make([]byte, sniffLen)
+	n, err := io.ReadFull(rd, head)
+	if err != nil && err != io.EOF && err != io.ErrUnexpectedEOF {
+		return nil, hutil.NewApiError(hutil.ErrServerInternalCode, "read file failed")
+	}
+	head = head[:n]
+	if contentType := sniffContentType(head); !slices.Contains(documentMIMEs[ext], contentType) {
+		return nil, hutil.NewApiError(http.StatusBadRequest, fmt.Sprintf("file content (%s) does not match extension %s", contentType, ext))
+	}
+	return io.MultiReader(bytes.NewReader(head), rd), nil
+}
+
+// sniffContentType 返回不带参数的内容类型
+func sniffContentType(head []byte) string {
+	if bytes.HasPrefix(head, oleSignature) {
+		return oleMIME
+	}
+	contentType, _, err := mime.ParseMediaType(http.DetectContentType(head))
+	if err != nil {
+		return "application/octet-stream"
+	}
+	return contentType
+}
+
+// scanFile 用配置的扫描服务检查已保存的文件，未配置时不扫描。扫描服务不可用时拒绝上传
+func (s *Service) scanFile(ctx context.Context, filename string) error {
+	if s.scanner == nil {
+		return nil
+	}
+	log := logger.FromContext(ctx)
+
+	f, err := os.Open(filename)
+	if err != nil {
+		log.Errorf("Failed to open file, err: %v", err)
+		return hutil.NewApiError(hutil.ErrServerInternalCode, "open file failed")
+	}
+	defer f.Close()
+	ret, err := s.scanner.Scan(ctx, f)
+	if err != nil {
+		log.Errorf("Failed to scan file, file: %s, err: %v", filename, err)
+		return hutil.NewApiError(hutil.ErrServerInternalCode, "scan file failed")
+	}
+	if ret.Infected {
+		log.Warnf("File rejected by scanner, file: %s, reason: %s", filename, ret.Reason)
+		return hutil.NewApiError(http.StatusUnprocessableEntity, "file rejected by scanner: "+ret.Reason)
+	}
+	return nil
+}
@@ -0,0 +1,128 @@
+package svr
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"net/http"
+
+	"github.com/gin-gonic/gin"
+	"gorm.io/gorm"
+
+	"imgagent/api"
+	"imgagent/db"
+	hutil "imgagent/httputil"
+	"imgagent/pkg/logger"
+)
+
+// sceneHDImageURL 场景当前图片的高清图片，场景图片重新生成后原高清图片失效
+func sceneHDImageURL(s *db.Scene) string {
+	if s.HDImageURL == "" || s.HDSourceURL != s.ImageURL {
+		return ""
+	}
+	return s.HDImageURL
+}
+
+// HandleSceneImageAction 场景图片操作，action 为 :upscale、:revert
+func (s *Service) HandleSceneImageAction(c *gin.Context) {
+	switch c.Param("action") {
+	case ":upscale":
+		s.HandleUpscaleSceneImage(c)
+	case ":revert":
+		s.HandleRevertSceneImage(c)
+	default:
+		hutil.AbortError(c, http.StatusNotFound, "not found")
+	}
+}
+
+func (s *Service) HandleUpscaleSceneImage(c *gin.Context) {
+	log := logger.FromGinContext(c)
+
+	var args api.UpscaleSceneImageArgs
+	if c.Request.ContentLength != 0 {
+		if err := c.ShouldBindJSON(&args); err != nil {
+			log.Errorf("Invalid request body, err: %v", err)
+			hutil.AbortError(c, http.StatusBadRequest, "invalid request body")
+			return
+		}
+	}
+	scene, err := s.UpscaleSceneImage(c.Request.Context(), c.Param("id"), &args)
+	if err != nil {
+		hutil.AbortErr(c, err)
+		return
+	}
+	hutil.WriteData(c, scene)
+}
+
+// UpscaleSceneImage 使用配置的超分服务放大场景当前的图片，保存为高清版本供导出视频使用，场景图片不变。
+// 超分计入当日图片生成配额
+func (s *Service) UpscaleSceneImage(ctx context.Context, sceneID string, args *api.UpscaleSceneImageArgs) (*api.Scene, error) {
+	log := logger.FromContext(ctx)
+
+	scene, err := s.db.GetScene(ctx, sceneID)
+	if err != nil {
+		log.Errorf("Failed to get scene, id: %s, err: %v", sceneID, err)
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			return nil, hutil.NewApiError(http.StatusNotFound, "scene not found")
+		}
+		return nil, hutil.NewApiError(hutil.ErrServerInternalCode, "get scene failed")
+	}
+	if scene.ImageURL == "" || scene.Placeholder {
+		return nil, hutil.NewApiError(http.StatusBadRequest, "scene has no generated image")
+	}
+	doc, err := s.db.GetDocument(ctx, scene.DocumentID)
+	if err != nil {
+		log.Errorf("Failed to get document, id: %s, err: %v", scene.DocumentID, err)
+		return nil, documentError(err, "get document failed")
+	}
+
+	err = s.quota.CheckGeneration(ctx, doc.UserID, 1, 0)
+	if err != nil {
+		log.Warnf("Quota check failed, userID: %d, err: %v", doc.UserID, err)
+		return nil, quotaError(err, "check quota failed")
+	}
+
+	image, err := s.readImage(ctx, scene.ImageURL)
+	if err != nil {
+		log.Errorf("Failed to read scene image, scene: %s, url: %s, err: %v", sceneID, scene.ImageURL, err)
+		return nil, hutil.NewApiError(hutil.ErrServerInternalCode, "read image failed")
+	}
+
+	log.Infof("Upscaling scene image, scene: %s, scale: %d", sceneID, args.Scale)
+	hdURL, err := s.images().Upscale(ctx, image, args.Scale)
+	if err != nil {
+		log.Errorf("Failed to upscale image, scene: %s, err: %v", sceneID, err)
+		return nil, hutil.NewApiError(hutil.ErrServerInternalCode, "upscale image failed")
+	}
+
+	err = s.db.UpdateSceneHDImage(ctx, sceneID, hdURL, scene.ImageURL)
+	if err != nil {
+		log.Errorf("Failed to update scene HD image, scene: %s, err: %v", sceneID, err)
+		return nil, hutil.NewApiError(hutil.ErrServerInternalCode, "update image failed")
+	}
+	err = s.quota.RecordGeneration(ctx, doc.UserID, 1, 0)
+	if err != nil {
+		log.Errorf("Failed to record quota usage, err: %v", err)
+	}
+	log.Infof("Scene image upscaled, scene: %s, URL: %s", sceneID, hdURL)
+
+	scene.HDImageURL, scene.HDSourceURL = hdURL, scene.ImageURL
+	ret := makeScene(&scene)
+	return &ret, nil
+}
+
+// readImage 读取场景图片，保存在本地的图片直接读取文件，其他图片通过 URL 下载
+func (s *Service) readImage(ctx context.Context, imageURL string) ([]byte, error) {
+	if s.imageStore != nil {
+		data, ok, err := s.imageStore.Read(imageURL)
+		if ok {
+			return data, err
+		}
+	}
+	var buf bytes.Buffer
+	_, err := s.httpClient.Download(ctx, imageURL, &buf, "image/png", "image/jpeg", "image/webp")
+	if err != nil {
+		return nil, err
+	}
+	return buf.Bytes(), nil
+}
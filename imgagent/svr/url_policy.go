@@ -0,0 +1,202 @@
+package svr
+
+import (
+	"context"
+	"errors"
+	"net"
+	"net/http"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"gorm.io/gorm"
+
+	"imgagent/api"
+	"imgagent/db"
+	hutil "imgagent/httputil"
+	"imgagent/pkg/httpclient"
+	"imgagent/pkg/logger"
+)
+
+const (
+	ErrURLNotAllowedCode = 617
+	ErrURLNotAllowed     = "url not allowed"
+)
+
+// URLPolicyConfig 通过 URL 创建文档的默认来源策略，管理员可按租户覆盖。
+// 内网地址还受 http_client.allow_private 限制，租户策略只能进一步收紧
+type URLPolicyConfig struct {
+	AllowedDomains []string `json:"allowed_domains"`
+	DeniedDomains  []string `json:"denied_domains"`
+	AllowPrivate   bool     `json:"allow_private"`
+}
+
+var domainPattern = regexp.MustCompile(`^(\*\.)?[a-z0-9]([a-z0-9-]*[a-z0-9])?(\.[a-z0-9]([a-z0-9-]*[a-z0-9])?)*$`)
+
+// normalizeDomains 校验并规范化域名列表，支持 IP 和 *.example.com
+func normalizeDomains(domains []string) ([]string, error) {
+	ret := make([]string, 0, len(domains))
+	for _, domain := range domains {
+		domain = strings.ToLower(strings.TrimSpace(domain))
+		if len(domain) > 253 || (!domainPattern.MatchString(domain) && net.ParseIP(domain) == nil) {
+			return nil, hutil.NewApiError(http.StatusBadRequest, "invalid domain "+strconv.Quote(domain))
+		}
+		ret = append(ret, domain)
+	}
+	return ret, nil
+}
+
+// URLPolicy 获取租户的 URL 来源策略，未单独设置时返回默认策略
+func (s *Service) URLPolicy(ctx context.Context, userID int64) (*api.URLPolicy, error) {
+	log := logger.FromContext(ctx)
+
+	policy, err := s.db.GetURLPolicy(ctx, userID)
+	if err != nil {
+		if !errors.Is(err, gorm.ErrRecordNotFound) {
+			log.Errorf("Failed to get url policy, userID: %d, err: %v", userID, err)
+			return nil, hutil.NewApiError(hutil.ErrServerInternalCode, "get url policy failed")
+		}
+		return &api.URLPolicy{
+			UserID:         userID,
+			AllowedDomains: s.conf.URLPolicy.AllowedDomains,
+			DeniedDomains:  s.conf.URLPolicy.DeniedDomains,
+			AllowPrivate:   s.conf.URLPolicy.AllowPrivate,
+			Default:        true,
+		}, nil
+	}
+	return makeURLPolicy(&policy), nil
+}
+
+func makeURLPolicy(p *db.URLPolicy) *api.URLPolicy {
+	return &api.URLPolicy{
+		UserID:         p.UserID,
+		AllowedDomains: p.AllowedDomains,
+		DeniedDomains:  p.DeniedDomains,
+		AllowPrivate:   p.AllowPrivate,
+		UpdatedAt:      p.UpdatedAt.Format(time.DateTime),
+	}
+}
+
+// urlRules 将租户策略转换为下载时的访问限制
+func urlRules(policy *api.URLPolicy) httpclient.Rules {
+	return httpclient.Rules{
+		AllowedHosts: policy.AllowedDomains,
+		DeniedHosts:  policy.DeniedDomains,
+		BlockPrivate: !policy.AllowPrivate,
+	}
+}
+
+// downloadDocument 按租户的 URL 来源策略下载原文到临时文件，调用方负责删除
+func (s *Service) downloadDocument(ctx context.Context, userID int64, rawURL string) (string, error) {
+	log := logger.FromContext(ctx)
+
+	policy, err := s.URLPolicy(ctx, userID)
+	if err != nil {
+		return "", err
+	}
+	ctx = httpclient.WithRules(ctx, urlRules(policy))
+	if err = s.httpClient.CheckURL(ctx, rawURL); err != nil {
+		log.Warnf("URL not allowed, userID: %d, url: %s, err: %v", userID, rawURL, err)
+		return "", hutil.NewApiError(ErrURLNotAllowedCode, ErrURLNotAllowed)
+	}
+
+	filename, err := s.downloadFile(ctx, rawURL)
+	switch {
+	case err == nil:
+		return filename, nil
+	case errors.Is(err, httpclient.ErrForbiddenURL):
+		return "", hutil.NewApiError(ErrURLNotAllowedCode, ErrURLNotAllowed)
+	case errors.Is(err, httpclient.ErrTooLarge):
+		return "", hutil.NewApiError(http.StatusBadRequest, "file too large")
+	case errors.Is(err, httpclient.ErrContentType):
+		return "", hutil.NewApiError(http.StatusBadRequest, "unsupported content type")
+	}
+	return "", hutil.NewApiError(http.StatusBadRequest, "download file failed")
+}
+
+// tenantID 解析路径中的租户 id，只有超级管理员可以管理租户的策略
+func tenantID(c *gin.Context) (int64, bool) {
+	if !GetUserInfo(c).SuperAdmin {
+		hutil.AbortError(c, http.StatusForbidden, "super admin required")
+		return 0, false
+	}
+	userID, err := strconv.ParseInt(c.Param("user_id"), 10, 64)
+	if err != nil || userID < 0 {
+		hutil.AbortError(c, http.StatusBadRequest, "invalid user id")
+		return 0, false
+	}
+	return userID, true
+}
+
+func (s *Service) HandleGetURLPolicy(c *gin.Context) {
+	userID, ok := tenantID(c)
+	if !ok {
+		return
+	}
+	policy, err := s.URLPolicy(c.Request.Context(), userID)
+	if err != nil {
+		hutil.AbortErr(c, err)
+		return
+	}
+	hutil.WriteData(c, policy)
+}
+
+func (s *Service) HandleUpdateURLPolicy(c *gin.Context) {
+	ctx := c.Request.Context()
+	log := logger.FromGinContext(c)
+
+	userID, ok := tenantID(c)
+	if !ok {
+		return
+	}
+	var args api.UpdateURLPolicyArgs
+	if err := c.ShouldBindJSON(&args); err != nil {
+		log.Errorf("Invalid request body, err: %v", err)
+		hutil.AbortError(c, http.StatusBadRequest, "invalid request body")
+		return
+	}
+	allowed, err := normalizeDomains(args.AllowedDomains)
+	if err != nil {
+		hutil.AbortErr(c, err)
+		return
+	}
+	denied, err := normalizeDomains(args.DeniedDomains)
+	if err != nil {
+		hutil.AbortErr(c, err)
+		return
+	}
+
+	log.Infof("Update url policy, userID: %d, allowed: %v, denied: %v, allowPrivate: %v", userID, allowed, denied, args.AllowPrivate)
+	policy := &db.URLPolicy{
+		UserID:         userID,
+		AllowedDomains: allowed,
+		DeniedDomains:  denied,
+		AllowPrivate:   args.AllowPrivate,
+	}
+	if err = s.db.SaveURLPolicy(ctx, policy); err != nil {
+		log.Errorf("Failed to save url policy, err: %v", err)
+		hutil.AbortError(c, hutil.ErrServerInternalCode, "save url policy failed")
+		return
+	}
+	hutil.WriteData(c, makeURLPolicy(policy))
+}
+
+// HandleDeleteURLPolicy 删除租户的策略，恢复为默认策略
+func (s *Service) HandleDeleteURLPolicy(c *gin.Context) {
+	ctx := c.Request.Context()
+	log := logger.FromGinContext(c)
+
+	userID, ok := tenantID(c)
+	if !ok {
+		return
+	}
+	log.Infof("Delete url policy, userID: %d", userID)
+	if err := s.db.DeleteURLPolicy(ctx, userID); err != nil {
+		log.Errorf("Failed to delete url policy, err: %v", err)
+		hutil.AbortError(c, hutil.ErrServerInternalCode, "delete url policy failed")
+		return
+	}
+	hutil.WriteData(c, nil)
+}
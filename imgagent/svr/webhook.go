@@ -0,0 +1,519 @@
+package svr
+
+import (
+	"bytes"
+	"context"
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"sort"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"gorm.io/gorm"
+
+	"imgagent/api"
+	"imgagent/db"
+	hutil "imgagent/httputil"
+	"imgagent/pkg/faults"
+	"imgagent/pkg/httpclient"
+	"imgagent/pkg/logger"
+	"imgagent/pkg/tracing"
+)
+
+// 回调事件类型
+const (
+	WebhookDocumentProcessed  = "document.processed"
+	WebhookDocumentFailed     = "document.failed"
+	WebhookSceneImageReady    = "scene.image.ready"
+	WebhookSceneVoiceReady    = "scene.voice.ready"
+	WebhookChapterScenesReady = "chapter.scenes.ready"
+	WebhookQuotaWarning       = "quota.warning"
+	WebhookQuotaExceeded      = "quota.exceeded"
+)
+
+// 回调请求头，签名为 hex(HMAC-SHA256(secret, timestamp + "." + body))
+const (
+	webhookEventHeader     = "X-Imgagent-Event"
+	webhookDeliveryHeader  = "X-Imgagent-Delivery"
+	webhookTimestampHeader = "X-Imgagent-Timestamp"
+	webhookSignatureHeader = "X-Imgagent-Signature"
+)
+
+type WebhookConfig struct {
+	Enable                bool `json:"enable"`
+	IntervalSecs          int  `json:"interval_secs"`             // 扫描待投递回调的间隔
+	BatchSize             int  `json:"batch_size"`                // 每轮投递的回调数
+	MaxAttempts           int  `json:"max_attempts"`              // 最大投递次数，超过后放弃
+	BaseBackoffSecs       int  `json:"base_backoff_secs"`         // 首次重试的等待时间，之后每次翻倍
+	TimeoutSecs           int  `json:"timeout_secs"`              // 单次投递超时
+	SceneBatchWindowSecs  int  `json:"scene_batch_window_secs"`   // 按章节合并场景事件的防抖窗口，章节在窗口内没有新的场景就绪时投递
+	SceneBatchMaxWaitSecs int  `json:"scene_batch_max_wait_secs"` // 章节第一个场景就绪后最多等待的时间，避免持续生成的章节一直不投递
+}
+
+// WebhookMgr 记录待投递的回调，并由后台循环签名投递，失败按指数退避重试
+type WebhookMgr struct {
+	conf   WebhookConfig
+	db     db.IDataBase
+	client *httpclient.Client
+
+	// batches 等待合并投递的章节场景事件，key 为 webhookID/chapterID
+	batchMu sync.Mutex
+	batches map[string]*sceneBatch
+
+	close     chan bool
+	closeOnce sync.Once
+	wg        sync.WaitGroup
+}
+
+// sceneBatch 一个 webhook 在一个章节上累积的就绪场景，同一场景多次就绪时保留最新的
+type sceneBatch struct {
+	hook       db.Webhook
+	documentID string
+	chapterID  string
+	scenes     map[string]api.Scene
+	deadline   time.Time
+	maxWait    time.Time
+}
+
+// newWebhookMgr httpConf 限制投递的目标地址，超时使用 conf.TimeoutSecs
+func newWebhookMgr(conf WebhookConfig, httpConf httpclient.Config, database db.IDataBase) *WebhookMgr {
+	if conf.IntervalSecs == 0 {
+		conf.IntervalSecs = 5
+	}
+	if conf.BatchSize == 0 {
+		conf.BatchSize = 100
+	}
+	if conf.MaxAttempts == 0 {
+		conf.MaxAttempts = 8
+	}
+	if conf.BaseBackoffSecs == 0 {
+		conf.BaseBackoffSecs = 10
+	}
+	if conf.TimeoutSecs == 0 {
+		conf.TimeoutSecs = 10
+	}
+	if conf.SceneBatchWindowSecs == 0 {
+		conf.SceneBatchWindowSecs = 30
+	}
+	if conf.SceneBatchMaxWaitSecs == 0 {
+		conf.SceneBatchMaxWaitSecs = 300
+	}
+	httpConf.TimeoutSecs = conf.TimeoutSecs
+	return &WebhookMgr{
+		conf: conf,
+		db:   database,
+		client: httpclient.New(httpConf, func(rt http.RoundTripper) http.RoundTripper {
+			return tracing.NewTransport(faults.NewTransport(rt, faults.TargetWebhook))
+		}),
+		batches: make(map[string]*sceneBatch),
+		close:   make(chan bool),
+	}
+}
+
+func (w *WebhookMgr) Run() {
+	w.wg.Add(1)
+	go w.loopHandleDeliveries()
+}
+
+// Stop 通知投递循环退出，并等待当前批次投递完；尚未到期的章节场景事件立即落库，重启后继续投递
+func (w *WebhookMgr) Stop(ctx context.Context) error {
+	w.closeOnce.Do(func() { close(w.close) })
+
+	done := make(chan struct{})
+	go func() {
+		w.wg.Wait()
+		// 所有批次的到期时间都不晚于 now + SceneBatchMaxWaitSecs
+		w.flushSceneBatches(logger.NewContext("FlushSceneBatches"), time.Now().Add(w.maxWait()))
+		close(done)
+	}()
+	select {
+	case <-done:
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+func (w *WebhookMgr) loopHandleDeliveries() {
+	defer w.wg.Done()
+	ticker := time.NewTicker(time.Second * time.Duration(w.conf.IntervalSecs))
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ticker.C:
+			ctx, span := tracing.Start(logger.NewContext(fmt.Sprintf("HandleWebhookDeliveries-%d", time.Now().Unix())), "WebhookMgr.HandleDeliveries")
+			w.HandleDeliveries(logger.WithTrace(ctx))
+			span.End()
+		case <-w.close:
+			return
+		}
+	}
+}
+
+// Notify 为应接收该文档事件的 webhook 记录待投递的回调，失败只记录日志，不影响文档处理。
+// w 为 nil 时不做任何事，便于未开启 webhook 时直接调用
+func (w *WebhookMgr) Notify(ctx context.Context, doc db.Document, eventType string, data any) {
+	if w == nil {
+		return
+	}
+	webhooks, err := w.db.ListDocumentWebhooks(ctx, doc.UserID, doc.ID)
+	if err != nil {
+		logger.FromContext(ctx).Errorf("Failed to list webhooks, doc: %s, err: %v", doc.ID, err)
+		return
+	}
+	w.enqueue(ctx, webhooks, eventType, data)
+}
+
+// NotifyUser 为用户全局的 webhook 记录不属于某个文档的事件（如配额预警），w 为 nil 时不做任何事
+func (w *WebhookMgr) NotifyUser(ctx context.Context, userID int64, eventType string, data any) {
+	if w == nil {
+		return
+	}
+	webhooks, err := w.db.ListUserWebhooks(ctx, userID)
+	if err != nil {
+		logger.FromContext(ctx).Errorf("Failed to list webhooks, userID: %d, err: %v", userID, err)
+		return
+	}
+	w.enqueue(ctx, webhooks, eventType, data)
+}
+
+// NotifyScene 发送场景事件：普通 webhook 每个事件类型一条回调，BatchScenes 的 webhook 合并到所在章节的
+// chapter.scenes.ready 事件中等待防抖窗口结束后投递。w 为 nil 时不做任何事
+func (w *WebhookMgr) NotifyScene(ctx context.Context, doc db.Document, scene db.Scene, eventTypes ...string) {
+	if w == nil {
+		return
+	}
+	webhooks, err := w.db.ListDocumentWebhooks(ctx, doc.UserID, doc.ID)
+	if err != nil {
+		logger.FromContext(ctx).Errorf("Failed to list webhooks, doc: %s, err: %v", doc.ID, err)
+		return
+	}
+
+	data := makeScene(&scene)
+	var immediate []db.Webhook
+	now := time.Now()
+	w.batchMu.Lock()
+	for _, hook := range webhooks {
+		if !hook.BatchScenes {
+			immediate = append(immediate, hook)
+			continue
+		}
+		key := hook.ID + "/" + scene.ChapterID
+		batch, ok := w.batches[key]
+		if !ok {
+			batch = &sceneBatch{
+				hook:       hook,
+				documentID: doc.ID,
+				chapterID:  scene.ChapterID,
+				scenes:     make(map[string]api.Scene),
+				maxWait:    now.Add(w.maxWait()),
+			}
+			w.batches[key] = batch
+		}
+		batch.scenes[scene.ID] = data
+		batch.deadline = now.Add(time.Duration(w.conf.SceneBatchWindowSecs) * time.Second)
+		if batch.deadline.After(batch.maxWait) {
+			batch.deadline = batch.maxWait
+		}
+	}
+	w.batchMu.Unlock()
+
+	for _, eventType := range eventTypes {
+		w.enqueue(ctx, immediate, eventType, data)
+	}
+}
+
+func (w *WebhookMgr) maxWait() time.Duration {
+	return time.Duration(w.conf.SceneBatchMaxWaitSecs) * time.Second
+}
+
+// flushSceneBatches 将到期时间不晚于 now 的章节场景事件记录为待投递的回调
+func (w *WebhookMgr) flushSceneBatches(ctx context.Context, now time.Time) {
+	var due []*sceneBatch
+	w.batchMu.Lock()
+	for key, batch := range w.batches {
+		if !batch.deadline.After(now) {
+			due = append(due, batch)
+			delete(w.batches, key)
+		}
+	}
+	w.batchMu.Unlock()
+
+	for _, batch := range due {
+		ready := api.ChapterScenesReady{
+			DocumentID: batch.documentID,
+			ChapterID:  batch.chapterID,
+			Scenes:     make([]api.Scene, 0, len(batch.scenes)),
+		}
+		for _, scene := range batch.scenes {
+			ready.Scenes = append(ready.Scenes, scene)
+		}
+		sort.Slice(ready.Scenes, func(i, j int) bool {
+			return ready.Scenes[i].Index < ready.Scenes[j].Index
+		})
+		w.enqueue(ctx, []db.Webhook{batch.hook}, WebhookChapterScenesReady, ready)
+	}
+}
+
+func (w *WebhookMgr) enqueue(ctx context.Context, webhooks []db.Webhook, eventType string, data any) {
+	log := logger.FromContext(ctx)
+	if len(webhooks) == 0 {
+		return
+	}
+
+	now := time.Now()
+	deliveries := make([]db.WebhookDelivery, 0, len(webhooks))
+	for _, hook := range webhooks {
+		id := db.MakeUUID()
+		payload, err := json.Marshal(api.WebhookEvent{
+			ID:        id,
+			Type:      eventType,
+			CreatedAt: now.Format(time.RFC3339),
+			Data:      data,
+		})
+		if err != nil {
+			log.Errorf("Failed to marshal webhook event, err: %v", err)
+			return
+		}
+		d := db.WebhookDelivery{
+			ID:            id,
+			WebhookID:     hook.ID,
+			EventType:     eventType,
+			Payload:       string(payload),
+			Status:        db.WebhookDeliveryPending,
+			NextAttemptAt: now,
+			CreatedAt:     now,
+			UpdatedAt:     now,
+		}
+		if hook.Template != "" {
+			// 模板渲染失败时记录为投递失败，不发送接收方无法解析的默认 body
+			d.Payload, err = renderHookTemplate(hook.Template, payload)
+			if err != nil {
+				log.Warnf("Failed to render webhook template, webhook: %s, event: %s, err: %v", hook.ID, eventType, err)
+				d.Payload = string(payload)
+				d.Status = db.WebhookDeliveryFailed
+				d.LastError = truncateError("render template: " + err.Error())
+			}
+		}
+		deliveries = append(deliveries, d)
+	}
+	err := w.db.CreateWebhookDeliveries(ctx, deliveries)
+	if err != nil {
+		log.Errorf("Failed to create webhook deliveries, event: %s, err: %v", eventType, err)
+	}
+}
+
+// HandleDeliveries 投递一批到期的回调
+func (w *WebhookMgr) HandleDeliveries(ctx context.Context) {
+	log := logger.FromContext(ctx)
+	w.flushSceneBatches(ctx, time.Now())
+
+	deliveries, err := w.db.ListDueWebhookDeliveries(ctx, time.Now(), w.conf.BatchSize)
+	if err != nil {
+		log.Errorf("Failed to list webhook deliveries, err: %v", err)
+		return
+	}
+
+	for _, d := range deliveries {
+		attempts := d.Attempts + 1
+		err := w.deliver(ctx, d)
+		if err == nil {
+			log.Infof("Webhook delivered, delivery: %s, event: %s, attempts: %d", d.ID, d.EventType, attempts)
+			err = w.db.UpdateWebhookDelivery(ctx, d.ID, db.WebhookDeliverySucceeded, attempts, time.Now(), "")
+			if err != nil {
+				log.Errorf("Failed to update webhook delivery, delivery: %s, err: %v", d.ID, err)
+			}
+			continue
+		}
+
+		status := db.WebhookDeliveryPending
+		if attempts >= w.conf.MaxAttempts {
+			status = db.WebhookDeliveryFailed
+			log.Warnf("Webhook delivery failed permanently, delivery: %s, attempts: %d, err: %v", d.ID, attempts, err)
+		} else {
+			log.Warnf("Webhook delivery failed, delivery: %s, attempts: %d, err: %v", d.ID, attempts, err)
+		}
+		errMsg := err.Error()
+		if len(errMsg) > 512 {
+			errMsg = errMsg[:512]
+		}
+		err = w.db.UpdateWebhookDelivery(ctx, d.ID, status, attempts, time.Now().Add(w.backoff(attempts)), errMsg)
+		if err != nil {
+			log.Errorf("Failed to update webhook delivery, delivery: %s, err: %v", d.ID, err)
+		}
+	}
+}
+
+// backoff 第 attempts 次失败后的等待时间：base * 2^(attempts-1)
+func (w *WebhookMgr) backoff(attempts int) time.Duration {
+	return time.Duration(w.conf.BaseBackoffSecs) * time.Second << (attempts - 1)
+}
+
+func (w *WebhookMgr) deliver(ctx context.Context, d db.WebhookDelivery) error {
+	hook, err := w.db.GetWebhook(ctx, d.WebhookID)
+	if err != nil {
+		return fmt.Errorf("get webhook: %w", err)
+	}
+
+	timestamp := strconv.FormatInt(time.Now().Unix(), 10)
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, hook.URL, bytes.NewReader([]byte(d.Payload)))
+	if err != nil {
+		return err
+	}
+	contentType := hook.ContentType
+	if contentType == "" {
+		contentType = "application/json"
+	}
+	req.Header.Set("Content-Type", contentType)
+	req.Header.Set(webhookEventHeader, d.EventType)
+	req.Header.Set(webhookDeliveryHeader, d.ID)
+	req.Header.Set(webhookTimestampHeader, timestamp)
+	req.Header.Set(webhookSignatureHeader, "sha256="+signWebhook(hook.Secret, timestamp, d.Payload))
+
+	resp, err := w.client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	io.Copy(io.Discard, io.LimitReader(resp.Body, 4096))
+	if resp.StatusCode < 200 || resp.StatusCode >= 300 {
+		return fmt.Errorf("unexpected status code %d", resp.StatusCode)
+	}
+	return nil
+}
+
+func signWebhook(secret, timestamp, payload string) string {
+	mac := hmac.New(sha256.New, []byte(secret))
+	mac.Write([]byte(timestamp + "." + payload))
+	return hex.EncodeToString(mac.Sum(nil))
+}
+
+func makeWebhook(w *db.Webhook) api.Webhook {
+	return api.Webhook{
+		ID:          w.ID,
+		URL:         w.URL,
+		DocumentID:  w.DocumentID,
+		BatchScenes: w.BatchScenes,
+		Template:    w.Template,
+		ContentType: w.ContentType,
+		CreatedAt:   w.CreatedAt.Format(time.DateTime),
+	}
+}
+
+func (s *Service) HandleCreateWebhook(c *gin.Context) {
+	ctx := c.Request.Context()
+	log := logger.FromGinContext(c)
+	ui := GetUserInfo(c)
+
+	var args api.CreateWebhookArgs
+	if err := c.ShouldBindJSON(&args); err != nil {
+		log.Errorf("Invalid request body, err: %v", err)
+		hutil.AbortError(c, http.StatusBadRequest, "invalid request body")
+		return
+	}
+
+	log.Infof("Create webhook, userID: %d, url: %s, docID: %s, batchScenes: %v", ui.ID, args.URL, args.DocumentID, args.BatchScenes)
+	if err := s.httpClient.CheckURL(ctx, args.URL); err != nil {
+		log.Warnf("Webhook url not allowed, err: %v", err)
+		hutil.AbortError(c, http.StatusBadRequest, "webhook url not allowed")
+		return
+	}
+	if args.Template != "" {
+		err := checkWebhookTemplate(args.Template)
+		if err != nil {
+			log.Warnf("Invalid webhook template, err: %v", err)
+			hutil.AbortError(c, http.StatusBadRequest, "invalid webhook template: "+err.Error())
+			return
+		}
+	}
+	if args.DocumentID != "" {
+		doc, err := s.db.GetDocument(ctx, args.DocumentID)
+		if err == nil && doc.UserID != ui.ID {
+			err = gorm.ErrRecordNotFound
+		}
+		if err != nil {
+			log.Errorf("Failed to get document, err: %v", err)
+			documentErr(c, err, "get document failed")
+			return
+		}
+	}
+
+	secret := args.Secret
+	if secret == "" {
+		b := make([]byte, 16)
+		rand.Read(b)
+		secret = hex.EncodeToString(b)
+	}
+	webhook := db.Webhook{
+		ID:          db.MakeUUID(),
+		UserID:      ui.ID,
+		DocumentID:  args.DocumentID,
+		URL:         args.URL,
+		Secret:      secret,
+		BatchScenes: args.BatchScenes,
+		Template:    args.Template,
+		ContentType: args.ContentType,
+		CreatedAt:   time.Now(),
+	}
+	err := s.db.CreateWebhook(ctx, &webhook)
+	if err != nil {
+		log.Errorf("Failed to create webhook, err: %v", err)
+		hutil.AbortError(c, hutil.ErrServerInternalCode, "create webhook failed")
+		return
+	}
+
+	ret := makeWebhook(&webhook)
+	ret.Secret = secret
+	hutil.WriteData(c, ret)
+}
+
+func (s *Service) HandleListWebhooks(c *gin.Context) {
+	ctx := c.Request.Context()
+	log := logger.FromGinContext(c)
+	ui := GetUserInfo(c)
+
+	docID := c.Query("document_id")
+	log.Infof("List webhooks, userID: %d, docID: %s", ui.ID, docID)
+	webhooks, err := s.db.ListWebhooks(ctx, ui.ID, docID)
+	if err != nil {
+		log.Errorf("Failed to list webhooks, err: %v", err)
+		hutil.AbortError(c, hutil.ErrServerInternalCode, "list webhooks failed")
+		return
+	}
+
+	ret := &api.ListWebhooksResult{Webhooks: []api.Webhook{}}
+	for _, w := range webhooks {
+		ret.Webhooks = append(ret.Webhooks, makeWebhook(&w))
+	}
+	hutil.WriteData(c, ret)
+}
+
+func (s *Service) HandleDeleteWebhook(c *gin.Context) {
+	ctx := c.Request.Context()
+	log := logger.FromGinContext(c)
+	ui := GetUserInfo(c)
+
+	id := c.Param("id")
+	log.Infof("Delete webhook, userID: %d, id: %s", ui.ID, id)
+	err := s.db.DeleteWebhook(ctx, id, ui.ID)
+	if err != nil {
+		log.Errorf("Failed to delete webhook, err: %v", err)
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			hutil.AbortError(c, http.StatusNotFound, "webhook not found")
+		} else {
+			hutil.AbortError(c, hutil.ErrServerInternalCode, "delete webhook failed")
+		}
+		return
+	}
+	hutil.WriteData(c, nil)
+}
@@ -0,0 +1,145 @@
+package svr
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"strings"
+	"text/template"
+	"time"
+
+	"imgagent/api"
+	"imgagent/db"
+)
+
+// webhookEventTitles 内置 text 模板使用的事件标题
+var webhookEventTitles = map[string]string{
+	WebhookDocumentProcessed:  "文档处理完成",
+	WebhookDocumentFailed:     "文档处理失败",
+	WebhookSceneImageReady:    "场景图片已生成",
+	WebhookSceneVoiceReady:    "场景语音已生成",
+	WebhookChapterScenesReady: "章节场景已就绪",
+	WebhookQuotaWarning:       "配额即将用尽",
+	WebhookQuotaExceeded:      "配额已用尽",
+}
+
+// webhookBuiltinTemplates 自定义模板中可以通过 {{template "text" .}} 等引用的内置模板：
+// text 为一行纯文本摘要，dingtalk 为钉钉机器人文本消息
+const webhookBuiltinTemplates = `
+{{- define "text"}}{{summary .}}{{end}}
+{{- define "dingtalk"}}{"msgtype":"text","text":{"content":{{summary . | json}}}}{{end}}`
+
+// webhookTemplateMaxSize 渲染结果的最大长度，渲染过程中超过时立即中止
+const webhookTemplateMaxSize = 64 << 10
+
+var errWebhookTemplateTooLarge = fmt.Errorf("rendered body exceeds %d bytes", webhookTemplateMaxSize)
+
+// limitedBuffer 写入超过 limit 时返回错误，模板执行随之中止，避免嵌套引用渲染出超大的 body
+type limitedBuffer struct {
+	bytes.Buffer
+	limit int
+}
+
+func (b *limitedBuffer) Write(p []byte) (int, error) {
+	if b.Len()+len(p) > b.limit {
+		return 0, errWebhookTemplateTooLarge
+	}
+	return b.Buffer.Write(p)
+}
+
+func webhookTitle(eventType string) string {
+	if title, ok := webhookEventTitles[eventType]; ok {
+		return title
+	}
+	return eventType
+}
+
+// webhookSummary 内置 text 模板的内容，一行纯文本摘要，空字段不输出
+func webhookSummary(event map[string]any) string {
+	present := func(v any) bool {
+		return v != nil && v != ""
+	}
+	eventType, _ := event["type"].(string)
+	data, _ := event["data"].(map[string]any)
+
+	var b strings.Builder
+	b.WriteString("[imgagent] " + webhookTitle(eventType))
+	if v := data["name"]; present(v) {
+		fmt.Fprintf(&b, " 文档「%v」", v)
+	}
+	if v := data["status"]; present(v) {
+		fmt.Fprintf(&b, " 状态：%v", v)
+	}
+	if v := data["last_error"]; present(v) {
+		fmt.Fprintf(&b, " 原因：%v", v)
+	}
+	if v := data["kind"]; present(v) {
+		fmt.Fprintf(&b, " 配额：%v %v/%v", v, data["usage"], data["limit"])
+	}
+	return b.String()
+}
+
+// parseWebhookTemplate 解析用户自定义的回调 body 模板
+// 不提供在模板内执行其他模板的函数：函数内的执行不计入 text/template 的嵌套深度，自引用会导致栈溢出
+func parseWebhookTemplate(text string) (*template.Template, error) {
+	funcs := template.FuncMap{
+		"json": func(v any) (string, error) {
+			b, err := json.Marshal(v)
+			return string(b), err
+		},
+		"title":   webhookTitle,
+		"summary": webhookSummary,
+		"default": func(def, v any) any {
+			if v == nil || v == "" {
+				return def
+			}
+			return v
+		},
+	}
+	tmpl, err := template.New("webhook").Funcs(funcs).Parse(webhookBuiltinTemplates)
+	if err != nil {
+		return nil, err
+	}
+	return tmpl.New("body").Parse(text)
+}
+
+// renderWebhookTemplate 以事件 JSON 解码后的对象为数据渲染模板，字段名与回调 body 相同，如 {{.type}}、{{.data.name}}
+func renderWebhookTemplate(tmpl *template.Template, payload []byte) (string, error) {
+	var event map[string]any
+	dec := json.NewDecoder(bytes.NewReader(payload))
+	dec.UseNumber()
+	err := dec.Decode(&event)
+	if err != nil {
+		return "", err
+	}
+	buf := &limitedBuffer{limit: webhookTemplateMaxSize}
+	err = tmpl.Execute(buf, event)
+	if err != nil {
+		return "", err
+	}
+	return strings.TrimSpace(buf.String()), nil
+}
+
+// renderHookTemplate 解析并渲染 webhook 的 body 模板
+func renderHookTemplate(text string, payload []byte) (string, error) {
+	tmpl, err := parseWebhookTemplate(text)
+	if err != nil {
+		return "", err
+	}
+	return renderWebhookTemplate(tmpl, payload)
+}
+
+// checkWebhookTemplate 注册时校验模板语法，并用示例事件试渲染一次
+func checkWebhookTemplate(text string) error {
+	payload, err := json.Marshal(api.WebhookEvent{
+		ID:        db.MakeUUID(),
+		Type:      WebhookDocumentProcessed,
+		CreatedAt: time.Now().Format(time.RFC3339),
+		Data:      api.Document{ID: db.MakeUUID(), Name: "example", Status: db.DocumentStatusImgReady},
+	})
+	if err != nil {
+		return err
+	}
+	_, err = renderHookTemplate(text, payload)
+	return err
+}
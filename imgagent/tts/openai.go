@@ -0,0 +1,146 @@
+package tts
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"strings"
+	"time"
+
+	"imgagent/pkg/faults"
+	"imgagent/pkg/logger"
+	"imgagent/pkg/mediastore"
+	"imgagent/pkg/tracing"
+)
+
+// 未配置时使用的模型和音色
+const (
+	defaultOpenAIModel = "gpt-4o-mini-tts"
+	defaultOpenAIVoice = "alloy"
+)
+
+// OpenAIConfig 兼容 OpenAI /v1/audio/speech 接口的语音服务
+type OpenAIConfig struct {
+	BaseURL        string `json:"base_url"`        // 默认 https://api.openai.com
+	APIKey         string `json:"api_key"`         // API 密钥
+	Model          string `json:"model"`           // 默认 gpt-4o-mini-tts
+	Voice          string `json:"voice"`           // 默认 alloy，场景未指定音色时使用
+	Instructions   string `json:"instructions"`    // 朗读风格提示，为空时不传
+	RequestTimeout int    `json:"request_timeout"` // 请求超时时间（秒），默认 120
+}
+
+type openAISpeechRequest struct {
+	Model          string  `json:"model"`
+	Input          string  `json:"input"`
+	Voice          string  `json:"voice"`
+	Instructions   string  `json:"instructions,omitempty"`
+	Speed          float64 `json:"speed,omitempty"`
+	ResponseFormat string  `json:"response_format"`
+}
+
+// OpenAI 兼容 OpenAI 语音接口的服务，返回的音频保存到 store
+type OpenAI struct {
+	config     OpenAIConfig
+	store      *mediastore.Store
+	httpClient *http.Client
+}
+
+func NewOpenAI(config OpenAIConfig, store *mediastore.Store) (*OpenAI, error) {
+	if config.APIKey == "" {
+		return nil, errors.New("openai tts api key is required")
+	}
+	if config.BaseURL == "" {
+		config.BaseURL = "https://api.openai.com"
+	}
+	config.BaseURL = strings.TrimSuffix(config.BaseURL, "/")
+	if config.Model == "" {
+		config.Model = defaultOpenAIModel
+	}
+	if config.Voice == "" {
+		config.Voice = defaultOpenAIVoice
+	}
+	if config.RequestTimeout == 0 {
+		config.RequestTimeout = 120
+	}
+	return &OpenAI{
+		config: config,
+		store:  store,
+		httpClient: &http.Client{
+			Timeout:   time.Duration(config.RequestTimeout) * time.Second,
+			Transport: tracing.NewTransport(logger.NewTransport(faults.NewTransport(http.DefaultTransport, faults.TargetTTS))),
+		},
+	}, nil
+}
+
+// ProsodyLimits OpenAI 语音接口支持 0.25 到 4 倍语速，不支持音调，情感风格追加到朗读风格提示中
+func (o *OpenAI) ProsodyLimits() ProsodyLimits {
+	return ProsodyLimits{Speed: Range{Min: 0.25, Max: 4}, Emotion: true}
+}
+
+func (o *OpenAI) Synthesize(ctx context.Context, text string, opts Options) (string, error) {
+	log := logger.FromContext(ctx)
+	log.Infof("Generating OpenAI TTS for text, length: %d", len(text))
+
+	req := openAISpeechRequest{
+		Model:          o.config.Model,
+		Input:          text,
+		Voice:          o.config.Voice,
+		Instructions:   o.config.Instructions,
+		ResponseFormat: "wav",
+	}
+	if opts.Model != "" {
+		req.Model = opts.Model
+	}
+	if opts.Voice != "" {
+		req.Voice = opts.Voice
+	}
+	if opts.Speed != 0 {
+		req.Speed = opts.Speed
+	}
+	if opts.Emotion != "" {
+		req.Instructions = strings.TrimSpace(req.Instructions + "\n情感和朗读风格：" + opts.Emotion)
+	}
+	reqBody, err := json.Marshal(req)
+	if err != nil {
+		return "", fmt.Errorf("marshal request failed: %w", err)
+	}
+
+	httpReq, err := http.NewRequestWithContext(ctx, http.MethodPost, o.config.BaseURL+"/v1/audio/speech", bytes.NewReader(reqBody))
+	if err != nil {
+		return "", fmt.Errorf("create request failed: %w", err)
+	}
+	httpReq.Header.Set("Authorization", "Bearer "+o.config.APIKey)
+	httpReq.Header.Set("Content-Type", "application/json")
+
+	resp, err := o.httpClient.Do(httpReq)
+	if err != nil {
+		log.Errorf("Failed to send request, err: %v", err)
+		return "", fmt.Errorf("send request failed: %w", err)
+	}
+	defer resp.Body.Close()
+
+	respBody, err := io.ReadAll(resp.Body)
+	if err != nil {
+		log.Errorf("Failed to read response, err: %v", err)
+		return "", fmt.Errorf("read response failed: %w", err)
+	}
+	if resp.StatusCode != http.StatusOK {
+		log.Errorf("Generate TTS failed, status: %d, body: %s", resp.StatusCode, string(respBody))
+		return "", fmt.Errorf("generate TTS failed, status: %d, body: %s", resp.StatusCode, string(respBody))
+	}
+	if len(respBody) < 12 || string(respBody[0:4]) != "RIFF" || string(respBody[8:12]) != "WAVE" {
+		return "", errors.New("response is not a wav file")
+	}
+
+	url, err := o.store.Save(respBody, "wav")
+	if err != nil {
+		log.Errorf("Failed to save audio, err: %v", err)
+		return "", fmt.Errorf("save audio failed: %w", err)
+	}
+	log.Infof("TTS generated successfully, URL: %s", url)
+	return url, nil
+}
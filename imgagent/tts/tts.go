@@ -0,0 +1,128 @@
+// Package tts 场景语音合成。Provider 屏蔽不同语音服务的差异，部署时通过配置选择百炼或兼容 OpenAI 语音接口的服务，
+// 生成的音频统一为 WAV，播放清单按 WAV 文件头计算时长。
+package tts
+
+import (
+	"context"
+	"errors"
+	"fmt"
+
+	"imgagent/bailian"
+	"imgagent/pkg/mediastore"
+)
+
+// 可选的语音服务
+const (
+	ProviderBailian = "bailian"
+	ProviderOpenAI  = "openai"
+)
+
+// Options 单次合成的参数，为空时使用服务的默认值
+type Options struct {
+	Voice   string  // 音色，取值由具体服务决定
+	Model   string  // 语音模型
+	Speed   float64 // 语速倍数，1 为正常语速
+	Pitch   float64 // 音调倍数，1 为正常音调
+	Emotion string  // 情感或朗读风格，如 欢快、低沉
+}
+
+// Provider 语音合成服务，返回可访问的 WAV 音频 URL
+type Provider interface {
+	Synthesize(ctx context.Context, text string, opts Options) (string, error)
+}
+
+// Range 参数的取值范围，Max 为 0 表示服务不支持该参数
+type Range struct {
+	Min float64
+	Max float64
+}
+
+// ProsodyLimits 服务支持的语速、音调范围和是否支持情感风格
+type ProsodyLimits struct {
+	Speed   Range
+	Pitch   Range
+	Emotion bool
+}
+
+// ProsodyLimiter 支持语速、音调或情感风格的服务，未实现的服务不支持这些参数
+type ProsodyLimiter interface {
+	ProsodyLimits() ProsodyLimits
+}
+
+// Limits 返回服务支持的语速、音调和情感风格
+func Limits(p Provider) ProsodyLimits {
+	if l, ok := p.(ProsodyLimiter); ok {
+		return l.ProsodyLimits()
+	}
+	return ProsodyLimits{}
+}
+
+// Check 校验 opts 中的语速、音调和情感风格，为零值的参数使用服务的默认值，不校验
+func (l ProsodyLimits) Check(opts Options) error {
+	if err := checkRange("speed", opts.Speed, l.Speed); err != nil {
+		return err
+	}
+	if err := checkRange("pitch", opts.Pitch, l.Pitch); err != nil {
+		return err
+	}
+	if opts.Emotion != "" && !l.Emotion {
+		return errors.New("emotion is not supported by the tts provider")
+	}
+	return nil
+}
+
+func checkRange(name string, v float64, r Range) error {
+	if v == 0 {
+		return nil
+	}
+	if r.Max == 0 {
+		return fmt.Errorf("%s is not supported by the tts provider", name)
+	}
+	if v < r.Min || v > r.Max {
+		return fmt.Errorf("%s must be between %g and %g", name, r.Min, r.Max)
+	}
+	return nil
+}
+
+// Config 语音合成配置
+type Config struct {
+	Provider string       `json:"provider"` // bailian|openai，默认 bailian
+	Dir      string       `json:"dir"`      // 服务直接返回音频数据时的保存目录，默认 ./audio，多实例部署时需为共享目录
+	OpenAI   OpenAIConfig `json:"openai"`
+}
+
+// New 按配置创建语音服务，服务直接返回音频数据时保存到 store
+func New(conf Config, bailianClient *bailian.Client, store *mediastore.Store) (Provider, error) {
+	switch conf.Provider {
+	case "", ProviderBailian:
+		return NewBailian(bailianClient), nil
+	case ProviderOpenAI:
+		return NewOpenAI(conf.OpenAI, store)
+	default:
+		return nil, fmt.Errorf("unknown tts provider: %s", conf.Provider)
+	}
+}
+
+// Bailian 百炼语音合成，音频由百炼托管
+type Bailian struct {
+	client *bailian.Client
+}
+
+func NewBailian(client *bailian.Client) *Bailian {
+	return &Bailian{client: client}
+}
+
+func (b *Bailian) Synthesize(ctx context.Context, text string, opts Options) (string, error) {
+	return b.client.GenerateTTS(ctx, text, bailian.GenerateOptions{
+		Voice:    opts.Voice,
+		TTSModel: opts.Model,
+		Speed:    opts.Speed,
+		Pitch:    opts.Pitch,
+		Emotion:  opts.Emotion,
+	})
+}
+
+// ProsodyLimits 百炼语音合成的语速和音调为 0.5 到 2 倍，情感风格作为朗读指令传入
+func (b *Bailian) ProsodyLimits() ProsodyLimits {
+	return ProsodyLimits{Speed: Range{Min: 0.5, Max: 2}, Pitch: Range{Min: 0.5, Max: 2}, Emotion: true}
+}
@@ -0,0 +1,85 @@
+package tts
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"imgagent/pkg/mediastore"
+	"imgagent/placeholder"
+)
+
+func TestOpenAI(t *testing.T) {
+	wav := placeholder.SilentWAV(time.Second)
+	var got openAISpeechRequest
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		assert.Equal(t, "/v1/audio/speech", r.URL.Path)
+		assert.Equal(t, "Bearer test", r.Header.Get("Authorization"))
+		require.NoError(t, json.NewDecoder(r.Body).Decode(&got))
+		if got.Input == "失败" {
+			w.WriteHeader(http.StatusBadRequest)
+			return
+		}
+		w.Write(wav)
+	}))
+	defer server.Close()
+
+	_, err := NewOpenAI(OpenAIConfig{BaseURL: server.URL}, nil)
+	require.Error(t, err)
+
+	dir := t.TempDir()
+	store := mediastore.New(dir, "http://localhost/v1/audio")
+	p, err := New(Config{Provider: ProviderOpenAI, OpenAI: OpenAIConfig{BaseURL: server.URL + "/", APIKey: "test"}}, nil, store)
+	require.NoError(t, err)
+
+	ctx := context.Background()
+	url, err := p.Synthesize(ctx, "你好", Options{})
+	require.NoError(t, err)
+	assert.Equal(t, openAISpeechRequest{Model: defaultOpenAIModel, Input: "你好", Voice: defaultOpenAIVoice, ResponseFormat: "wav"}, got)
+
+	// 音频按内容哈希保存，相同内容返回相同 URL
+	file, err := store.Path(path.Base(url))
+	require.NoError(t, err)
+	data, err := os.ReadFile(file)
+	require.NoError(t, err)
+	assert.Equal(t, wav, data)
+
+	url2, err := p.Synthesize(ctx, "你好", Options{Voice: "nova", Model: "tts-1"})
+	require.NoError(t, err)
+	assert.Equal(t, url, url2)
+	assert.Equal(t, "nova", got.Voice)
+	assert.Equal(t, "tts-1", got.Model)
+
+	// 情感风格追加到配置的朗读风格提示中
+	_, err = p.Synthesize(ctx, "你好", Options{Speed: 1.5, Emotion: "欢快"})
+	require.NoError(t, err)
+	assert.Equal(t, 1.5, got.Speed)
+	assert.Equal(t, "情感和朗读风格：欢快", got.Instructions)
+
+	_, err = p.Synthesize(ctx, "失败", Options{})
+	require.Error(t, err)
+
+	_, err = New(Config{Provider: "unknown"}, nil, store)
+	require.Error(t, err)
+}
+
+func TestProsodyLimits(t *testing.T) {
+	bailianLimits := Limits(NewBailian(nil))
+	assert.NoError(t, bailianLimits.Check(Options{}))
+	assert.NoError(t, bailianLimits.Check(Options{Speed: 0.5, Pitch: 2, Emotion: "低沉"}))
+	assert.EqualError(t, bailianLimits.Check(Options{Speed: 3}), "speed must be between 0.5 and 2")
+
+	openAI, err := NewOpenAI(OpenAIConfig{APIKey: "test"}, nil)
+	require.NoError(t, err)
+	openAILimits := Limits(openAI)
+	assert.NoError(t, openAILimits.Check(Options{Speed: 3}))
+	assert.EqualError(t, openAILimits.Check(Options{Pitch: 1.2}), "pitch is not supported by the tts provider")
+}